@@ -0,0 +1,36 @@
+# Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Async Python client for the Webmesh APIs.
+
+The generated message and service modules live in ``webmesh.v1``. The
+:class:`Client` wraps them with authentication, request IDs, retries, and
+leader redirection.
+"""
+
+from webmesh.client import (
+    Client,
+    Credentials,
+    LeaderHint,
+    leader_hint_from_error,
+)
+
+__version__ = "0.0.0"
+
+__all__ = [
+    "Client",
+    "Credentials",
+    "LeaderHint",
+    "leader_hint_from_error",
+]
@@ -0,0 +1,256 @@
+# Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+#
+# Licensed under the Apache License, Version 2.0 (the "License");
+# you may not use this file except in compliance with the License.
+# You may obtain a copy of the License at
+#
+#     http://www.apache.org/licenses/LICENSE-2.0
+#
+# Unless required by applicable law or agreed to in writing, software
+# distributed under the License is distributed on an "AS IS" BASIS,
+# WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+# See the License for the specific language governing permissions and
+# limitations under the License.
+
+"""Async client for the Webmesh node APIs."""
+
+from __future__ import annotations
+
+import asyncio
+import uuid
+from dataclasses import dataclass
+from typing import Any, Callable, Optional, Sequence, Tuple
+
+import grpc
+from google.rpc import error_details_pb2, status_pb2
+
+from webmesh.v1 import (
+    admin_pb2_grpc,
+    members_pb2_grpc,
+    mesh_pb2_grpc,
+    node_pb2_grpc,
+    storage_query_pb2_grpc,
+)
+
+# Metadata keys understood by webmesh nodes.
+REQUEST_ID_META = "x-webmesh-request-id"
+PREFER_LEADER_META = "x-webmesh-prefer-leader"
+BASIC_AUTH_USERNAME_META = "x-webmesh-basic-auth-username"
+BASIC_AUTH_PASSWORD_META = "x-webmesh-basic-auth-password"
+LDAP_USERNAME_META = "x-webmesh-ldap-auth-username"
+LDAP_PASSWORD_META = "x-webmesh-ldap-auth-password"
+
+# Error details set by nodes that reject a request because they are not the
+# leader.
+NOT_LEADER_REASON = "NOT_LEADER"
+ERROR_DOMAIN = "webmesh.io"
+
+_STATUS_DETAILS_META = "grpc-status-details-bin"
+
+Metadata = Sequence[Tuple[str, str]]
+
+
+@dataclass
+class Credentials:
+    """Credentials used to connect and authenticate to a node.
+
+    Transport security uses TLS unless ``insecure`` is set. Authentication
+    uses whichever of basic or LDAP auth is configured; mTLS is used when a
+    client certificate and key are provided.
+    """
+
+    insecure: bool = False
+    root_certificates: Optional[bytes] = None
+    certificate_chain: Optional[bytes] = None
+    private_key: Optional[bytes] = None
+    basic_auth: Optional[Tuple[str, str]] = None
+    ldap_auth: Optional[Tuple[str, str]] = None
+
+    def metadata(self) -> Metadata:
+        md = []
+        if self.basic_auth:
+            md.append((BASIC_AUTH_USERNAME_META, self.basic_auth[0]))
+            md.append((BASIC_AUTH_PASSWORD_META, self.basic_auth[1]))
+        if self.ldap_auth:
+            md.append((LDAP_USERNAME_META, self.ldap_auth[0]))
+            md.append((LDAP_PASSWORD_META, self.ldap_auth[1]))
+        return md
+
+    def channel(self, address: str) -> grpc.aio.Channel:
+        if self.insecure:
+            return grpc.aio.insecure_channel(address)
+        creds = grpc.ssl_channel_credentials(
+            root_certificates=self.root_certificates,
+            private_key=self.private_key,
+            certificate_chain=self.certificate_chain,
+        )
+        return grpc.aio.secure_channel(address, creds)
+
+
+@dataclass
+class LeaderHint:
+    """The leader information carried in a not-leader error."""
+
+    id: str
+    address: str = ""
+    public_address: str = ""
+
+    @property
+    def dial_address(self) -> str:
+        """The address to dial, preferring the public address."""
+        return self.public_address or self.address
+
+
+def leader_hint_from_error(err: grpc.aio.AioRpcError) -> Optional[LeaderHint]:
+    """Return the leader hint carried in a not-leader error, if any."""
+    if err.code() != grpc.StatusCode.FAILED_PRECONDITION:
+        return None
+    for key, value in err.trailing_metadata() or ():
+        if key != _STATUS_DETAILS_META:
+            continue
+        status = status_pb2.Status.FromString(value)
+        for detail in status.details:
+            info = error_details_pb2.ErrorInfo()
+            if not detail.Unpack(info):
+                continue
+            if info.reason != NOT_LEADER_REASON or info.domain != ERROR_DOMAIN:
+                continue
+            hint = LeaderHint(
+                id=info.metadata.get("leader-id", ""),
+                address=info.metadata.get("leader-address", ""),
+                public_address=info.metadata.get("leader-public-address", ""),
+            )
+            if hint.id and hint.dial_address:
+                return hint
+    return None
+
+
+class Client:
+    """An async client for a webmesh node.
+
+    The client exposes the generated stubs for the admin, membership, mesh,
+    node, and storage query APIs. Every call carries the configured
+    credentials and a request ID. Unary calls are retried while the node is
+    unavailable and followed to the leader when rejected by a non-leader.
+
+    Use it as an async context manager or call :meth:`close` when done::
+
+        async with Client("127.0.0.1:8443", Credentials(insecure=True)) as c:
+            nodes = await c.mesh.ListNodes(Empty())
+    """
+
+    def __init__(
+        self,
+        address: str,
+        credentials: Optional[Credentials] = None,
+        *,
+        prefer_leader: bool = False,
+        max_retries: int = 3,
+        retry_backoff: float = 0.25,
+        follow_leader: bool = True,
+    ) -> None:
+        self._credentials = credentials or Credentials()
+        self._channel = self._credentials.channel(address)
+        self._prefer_leader = prefer_leader
+        self._max_retries = max(max_retries, 0)
+        self._retry_backoff = retry_backoff
+        self._follow_leader = follow_leader
+
+    async def __aenter__(self) -> "Client":
+        return self
+
+    async def __aexit__(self, *exc: Any) -> None:
+        await self.close()
+
+    async def close(self) -> None:
+        """Close the underlying channel."""
+        await self._channel.close()
+
+    @property
+    def admin(self) -> Any:
+        """The admin API (``v1.Admin``)."""
+        return _Service(self, admin_pb2_grpc.AdminStub)
+
+    @property
+    def membership(self) -> Any:
+        """The membership API (``v1.Membership``)."""
+        return _Service(self, members_pb2_grpc.MembershipStub)
+
+    @property
+    def mesh(self) -> Any:
+        """The mesh API (``v1.Mesh``)."""
+        return _Service(self, mesh_pb2_grpc.MeshStub)
+
+    @property
+    def node(self) -> Any:
+        """The node API (``v1.Node``)."""
+        return _Service(self, node_pb2_grpc.NodeStub)
+
+    @property
+    def storage(self) -> Any:
+        """The storage query API (``v1.StorageQueryService``)."""
+        return _Service(self, storage_query_pb2_grpc.StorageQueryServiceStub)
+
+    def _metadata(self, extra: Optional[Metadata]) -> Metadata:
+        md = list(extra or ())
+        if not any(k == REQUEST_ID_META for k, _ in md):
+            md.append((REQUEST_ID_META, str(uuid.uuid4())))
+        if self._prefer_leader:
+            md.append((PREFER_LEADER_META, "true"))
+        md.extend(self._credentials.metadata())
+        return md
+
+
+class _Service:
+    """Wraps a generated stub, applying the client's call behavior."""
+
+    def __init__(self, client: Client, stub: Callable[[grpc.aio.Channel], Any]):
+        self._client = client
+        self._stub_type = stub
+        self._stub = stub(client._channel)
+
+    def __getattr__(self, name: str) -> Callable[..., Any]:
+        method = getattr(self._stub, name)
+        if isinstance(method, grpc.aio.UnaryUnaryMultiCallable):
+            return self._unary(name)
+        return self._streaming(method)
+
+    def _streaming(self, method: Any) -> Callable[..., Any]:
+        def call(request: Any = None, *, metadata: Optional[Metadata] = None, **kwargs: Any) -> Any:
+            md = self._client._metadata(metadata)
+            if request is None:
+                return method(metadata=md, **kwargs)
+            return method(request, metadata=md, **kwargs)
+
+        return call
+
+    def _unary(self, name: str) -> Callable[..., Any]:
+        client = self._client
+
+        async def call(request: Any, *, metadata: Optional[Metadata] = None, **kwargs: Any) -> Any:
+            # The request ID is fixed for all attempts so they can be
+            # correlated on the server side.
+            md = client._metadata(metadata)
+            attempt = 0
+            while True:
+                try:
+                    return await getattr(self._stub, name)(request, metadata=md, **kwargs)
+                except grpc.aio.AioRpcError as err:
+                    hint = leader_hint_from_error(err) if client._follow_leader else None
+                    if hint is not None:
+                        return await self._redirect(hint, name, request, md, kwargs)
+                    if err.code() != grpc.StatusCode.UNAVAILABLE or attempt >= client._max_retries:
+                        raise
+                await asyncio.sleep(client._retry_backoff * (2**attempt))
+                attempt += 1
+
+        return call
+
+    async def _redirect(self, hint: LeaderHint, name: str, request: Any, md: Metadata, kwargs: Any) -> Any:
+        channel = self._client._credentials.channel(hint.dial_address)
+        try:
+            stub = self._stub_type(channel)
+            return await getattr(stub, name)(request, metadata=md, **kwargs)
+        finally:
+            await channel.close()
+
@@ -0,0 +1,156 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import { randomUUID } from "crypto";
+import { Code, ConnectError, createPromiseClient, Interceptor, PromiseClient, Transport } from "@connectrpc/connect";
+import { createGrpcTransport } from "@connectrpc/connect-node";
+
+import { Admin } from "./gen/v1/admin_connect.js";
+import { Membership } from "./gen/v1/members_connect.js";
+import { Mesh } from "./gen/v1/mesh_connect.js";
+import { Node } from "./gen/v1/node_connect.js";
+import { StorageQueryService } from "./gen/v1/storage_query_connect.js";
+import { leaderRedirectInterceptor } from "./leader.js";
+
+/** The metadata key carrying the request ID. */
+export const RequestIDHeader = "x-webmesh-request-id";
+
+/** The metadata key asking nodes to proxy requests to the leader. */
+export const PreferLeaderHeader = "x-webmesh-prefer-leader";
+
+/** Credentials used to connect and authenticate to a node. */
+export interface Credentials {
+    /** Use plaintext instead of TLS. */
+    insecure?: boolean;
+    /** PEM encoded CA certificates used to verify the node. */
+    ca?: string | Buffer;
+    /** PEM encoded client certificate for mTLS authentication. */
+    cert?: string | Buffer;
+    /** PEM encoded client key for mTLS authentication. */
+    key?: string | Buffer;
+    /** Username and password for basic authentication. */
+    basicAuth?: { username: string; password: string };
+    /** Username and password for LDAP authentication. */
+    ldapAuth?: { username: string; password: string };
+}
+
+/** Options for creating a client. */
+export interface ClientOptions {
+    /** The host:port address of a node's gRPC server. */
+    address: string;
+    /** Credentials used for the node and any leader the client is redirected to. */
+    credentials?: Credentials;
+    /** Ask nodes to proxy requests that may be served locally to the leader. */
+    preferLeader?: boolean;
+    /** Number of times unary calls are retried while the node is unavailable. Defaults to 3. */
+    maxRetries?: number;
+    /** Initial backoff between retries in milliseconds. Doubles after each attempt. Defaults to 250. */
+    retryBackoffMs?: number;
+    /** Disable retrying calls rejected by a non-leader against the leader. */
+    disableLeaderRedirect?: boolean;
+}
+
+/** Client is a client for the webmesh node APIs. */
+export interface Client {
+    admin: PromiseClient<typeof Admin>;
+    membership: PromiseClient<typeof Membership>;
+    mesh: PromiseClient<typeof Mesh>;
+    node: PromiseClient<typeof Node>;
+    storage: PromiseClient<typeof StorageQueryService>;
+}
+
+/** Creates a new client for the node at the given address. */
+export function createClient(options: ClientOptions): Client {
+    const transport = newTransport(options, options.address, !options.disableLeaderRedirect);
+    return {
+        admin: createPromiseClient(Admin, transport),
+        membership: createPromiseClient(Membership, transport),
+        mesh: createPromiseClient(Mesh, transport),
+        node: createPromiseClient(Node, transport),
+        storage: createPromiseClient(StorageQueryService, transport),
+    };
+}
+
+function newTransport(options: ClientOptions, address: string, followLeader: boolean): Transport {
+    const creds = options.credentials ?? {};
+    const interceptors: Interceptor[] = [
+        retryInterceptor(options.maxRetries ?? 3, options.retryBackoffMs ?? 250),
+        requestIDInterceptor(),
+        authInterceptor(creds),
+    ];
+    if (options.preferLeader) {
+        interceptors.push((next) => (req) => {
+            req.header.set(PreferLeaderHeader, "true");
+            return next(req);
+        });
+    }
+    if (followLeader) {
+        // Redirects never follow further redirects.
+        interceptors.push(leaderRedirectInterceptor((addr) => newTransport(options, addr, false)));
+    }
+    return createGrpcTransport({
+        baseUrl: `${creds.insecure ? "http" : "https"}://${address}`,
+        httpVersion: "2",
+        nodeOptions: creds.insecure ? undefined : { ca: creds.ca, cert: creds.cert, key: creds.key },
+        interceptors,
+    });
+}
+
+/** Sets a request ID on calls that do not already carry one. */
+function requestIDInterceptor(): Interceptor {
+    return (next) => (req) => {
+        if (!req.header.has(RequestIDHeader)) {
+            req.header.set(RequestIDHeader, randomUUID());
+        }
+        return next(req);
+    };
+}
+
+/** Sets the basic or LDAP authentication headers. */
+function authInterceptor(creds: Credentials): Interceptor {
+    return (next) => (req) => {
+        if (creds.basicAuth) {
+            req.header.set("x-webmesh-basic-auth-username", creds.basicAuth.username);
+            req.header.set("x-webmesh-basic-auth-password", creds.basicAuth.password);
+        }
+        if (creds.ldapAuth) {
+            req.header.set("x-webmesh-ldap-auth-username", creds.ldapAuth.username);
+            req.header.set("x-webmesh-ldap-auth-password", creds.ldapAuth.password);
+        }
+        return next(req);
+    };
+}
+
+/** Retries unary calls while the node is unavailable. */
+function retryInterceptor(maxRetries: number, backoffMs: number): Interceptor {
+    return (next) => async (req) => {
+        if (req.stream) {
+            return next(req);
+        }
+        // Retries reuse the request, and therefore the request ID set by the
+        // first attempt, so they can be correlated on the server side.
+        for (let attempt = 0; ; attempt++) {
+            try {
+                return await next(req);
+            } catch (err) {
+                if (ConnectError.from(err).code !== Code.Unavailable || attempt >= maxRetries) {
+                    throw err;
+                }
+            }
+            await new Promise((resolve) => setTimeout(resolve, backoffMs * 2 ** attempt));
+        }
+    };
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import { proto3 } from "@bufbuild/protobuf";
+import { Code, ConnectError, Interceptor, Transport } from "@connectrpc/connect";
+
+/** The reason set by nodes that reject a request because they are not the leader. */
+export const NotLeaderReason = "NOT_LEADER";
+
+/** The domain set in webmesh error details. */
+export const ErrorDomain = "webmesh.io";
+
+/**
+ * google.rpc.ErrorInfo, declared here so the client does not need the
+ * full googleapis package to decode error details.
+ */
+export const ErrorInfo = proto3.makeMessageType("google.rpc.ErrorInfo", () => [
+    { no: 1, name: "reason", kind: "scalar", T: 9 /* ScalarType.STRING */ },
+    { no: 2, name: "domain", kind: "scalar", T: 9 /* ScalarType.STRING */ },
+    { no: 3, name: "metadata", kind: "map", K: 9 /* ScalarType.STRING */, V: { kind: "scalar", T: 9 /* ScalarType.STRING */ } },
+]);
+
+/** LeaderHint is the leader information carried in a not-leader error. */
+export interface LeaderHint {
+    /** The ID of the current leader. */
+    id: string;
+    /** The private gRPC address of the current leader. */
+    address: string;
+    /** The public gRPC address of the current leader, if it has one. */
+    publicAddress: string;
+}
+
+/**
+ * Returns the leader hint carried in a not-leader error, or undefined if
+ * the error carries none.
+ */
+export function leaderHintFromError(err: unknown): LeaderHint | undefined {
+    const cerr = ConnectError.from(err);
+    if (cerr.code !== Code.FailedPrecondition) {
+        return undefined;
+    }
+    for (const info of cerr.findDetails(ErrorInfo)) {
+        const { reason, domain, metadata } = info as unknown as {
+            reason: string;
+            domain: string;
+            metadata: Record<string, string>;
+        };
+        if (reason !== NotLeaderReason || domain !== ErrorDomain) {
+            continue;
+        }
+        const hint = {
+            id: metadata["leader-id"] ?? "",
+            address: metadata["leader-address"] ?? "",
+            publicAddress: metadata["leader-public-address"] ?? "",
+        };
+        if (hint.id && (hint.publicAddress || hint.address)) {
+            return hint;
+        }
+    }
+    return undefined;
+}
+
+/**
+ * Returns an interceptor that retries unary calls rejected by a non-leader
+ * once against the leader named in the error. The transport for the leader
+ * is created with the given function and is passed the address to dial,
+ * preferring the leader's public address.
+ */
+export function leaderRedirectInterceptor(newTransport: (address: string) => Transport): Interceptor {
+    return (next) => async (req) => {
+        try {
+            return await next(req);
+        } catch (err) {
+            const hint = leaderHintFromError(err);
+            if (req.stream || hint === undefined) {
+                throw err;
+            }
+            const transport = newTransport(hint.publicAddress || hint.address);
+            return await transport.unary(req.service, req.method as any, req.signal, undefined, req.header, req.message);
+        }
+    };
+}
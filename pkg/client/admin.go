@@ -0,0 +1,224 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// AdminClient is a typed client for the admin API. Requests must be
+// authorized by the RBAC rules of the mesh.
+type AdminClient struct {
+	cli v1.AdminClient
+}
+
+// Raw returns the underlying generated client.
+func (c *AdminClient) Raw() v1.AdminClient {
+	return c.cli
+}
+
+// PutRole creates or updates a role.
+func (c *AdminClient) PutRole(ctx context.Context, v types.Role) error {
+	_, err := c.cli.PutRole(ctx, v.Role)
+	return err
+}
+
+// GetRole returns the role with the given name.
+func (c *AdminClient) GetRole(ctx context.Context, name string) (types.Role, error) {
+	v, err := c.cli.GetRole(ctx, &v1.Role{Name: name})
+	if err != nil {
+		return types.Role{}, err
+	}
+	return types.Role{Role: v}, nil
+}
+
+// DeleteRole deletes the role with the given name.
+func (c *AdminClient) DeleteRole(ctx context.Context, name string) error {
+	_, err := c.cli.DeleteRole(ctx, &v1.Role{Name: name})
+	return err
+}
+
+// ListRoles returns all roles.
+func (c *AdminClient) ListRoles(ctx context.Context) ([]types.Role, error) {
+	resp, err := c.cli.ListRoles(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return wrapItems(resp.GetItems(), func(v *v1.Role) types.Role { return types.Role{Role: v} }), nil
+}
+
+// PutRoleBinding creates or updates a role binding.
+func (c *AdminClient) PutRoleBinding(ctx context.Context, v types.RoleBinding) error {
+	_, err := c.cli.PutRoleBinding(ctx, v.RoleBinding)
+	return err
+}
+
+// GetRoleBinding returns the role binding with the given name.
+func (c *AdminClient) GetRoleBinding(ctx context.Context, name string) (types.RoleBinding, error) {
+	v, err := c.cli.GetRoleBinding(ctx, &v1.RoleBinding{Name: name})
+	if err != nil {
+		return types.RoleBinding{}, err
+	}
+	return types.RoleBinding{RoleBinding: v}, nil
+}
+
+// DeleteRoleBinding deletes the role binding with the given name.
+func (c *AdminClient) DeleteRoleBinding(ctx context.Context, name string) error {
+	_, err := c.cli.DeleteRoleBinding(ctx, &v1.RoleBinding{Name: name})
+	return err
+}
+
+// ListRoleBindings returns all role bindings.
+func (c *AdminClient) ListRoleBindings(ctx context.Context) ([]types.RoleBinding, error) {
+	resp, err := c.cli.ListRoleBindings(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return wrapItems(resp.GetItems(), func(v *v1.RoleBinding) types.RoleBinding { return types.RoleBinding{RoleBinding: v} }), nil
+}
+
+// PutGroup creates or updates a group.
+func (c *AdminClient) PutGroup(ctx context.Context, v types.Group) error {
+	_, err := c.cli.PutGroup(ctx, v.Group)
+	return err
+}
+
+// GetGroup returns the group with the given name.
+func (c *AdminClient) GetGroup(ctx context.Context, name string) (types.Group, error) {
+	v, err := c.cli.GetGroup(ctx, &v1.Group{Name: name})
+	if err != nil {
+		return types.Group{}, err
+	}
+	return types.Group{Group: v}, nil
+}
+
+// DeleteGroup deletes the group with the given name.
+func (c *AdminClient) DeleteGroup(ctx context.Context, name string) error {
+	_, err := c.cli.DeleteGroup(ctx, &v1.Group{Name: name})
+	return err
+}
+
+// ListGroups returns all groups.
+func (c *AdminClient) ListGroups(ctx context.Context) ([]types.Group, error) {
+	resp, err := c.cli.ListGroups(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return wrapItems(resp.GetItems(), func(v *v1.Group) types.Group { return types.Group{Group: v} }), nil
+}
+
+// PutNetworkACL creates or updates a network ACL.
+func (c *AdminClient) PutNetworkACL(ctx context.Context, v types.NetworkACL) error {
+	_, err := c.cli.PutNetworkACL(ctx, v.NetworkACL)
+	return err
+}
+
+// GetNetworkACL returns the network ACL with the given name.
+func (c *AdminClient) GetNetworkACL(ctx context.Context, name string) (types.NetworkACL, error) {
+	v, err := c.cli.GetNetworkACL(ctx, &v1.NetworkACL{Name: name})
+	if err != nil {
+		return types.NetworkACL{}, err
+	}
+	return types.NetworkACL{NetworkACL: v}, nil
+}
+
+// DeleteNetworkACL deletes the network ACL with the given name.
+func (c *AdminClient) DeleteNetworkACL(ctx context.Context, name string) error {
+	_, err := c.cli.DeleteNetworkACL(ctx, &v1.NetworkACL{Name: name})
+	return err
+}
+
+// ListNetworkACLs returns all network ACLs.
+func (c *AdminClient) ListNetworkACLs(ctx context.Context) ([]types.NetworkACL, error) {
+	resp, err := c.cli.ListNetworkACLs(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return wrapItems(resp.GetItems(), func(v *v1.NetworkACL) types.NetworkACL { return types.NetworkACL{NetworkACL: v} }), nil
+}
+
+// PutRoute creates or updates a route.
+func (c *AdminClient) PutRoute(ctx context.Context, v types.Route) error {
+	_, err := c.cli.PutRoute(ctx, v.Route)
+	return err
+}
+
+// GetRoute returns the route with the given name.
+func (c *AdminClient) GetRoute(ctx context.Context, name string) (types.Route, error) {
+	v, err := c.cli.GetRoute(ctx, &v1.Route{Name: name})
+	if err != nil {
+		return types.Route{}, err
+	}
+	return types.Route{Route: v}, nil
+}
+
+// DeleteRoute deletes the route with the given name.
+func (c *AdminClient) DeleteRoute(ctx context.Context, name string) error {
+	_, err := c.cli.DeleteRoute(ctx, &v1.Route{Name: name})
+	return err
+}
+
+// ListRoutes returns all routes.
+func (c *AdminClient) ListRoutes(ctx context.Context) ([]types.Route, error) {
+	resp, err := c.cli.ListRoutes(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return wrapItems(resp.GetItems(), func(v *v1.Route) types.Route { return types.Route{Route: v} }), nil
+}
+
+// PutEdge creates or updates an edge between two nodes.
+func (c *AdminClient) PutEdge(ctx context.Context, v types.MeshEdge) error {
+	_, err := c.cli.PutEdge(ctx, v.MeshEdge)
+	return err
+}
+
+// GetEdge returns the edge between the given nodes.
+func (c *AdminClient) GetEdge(ctx context.Context, source, target types.NodeID) (types.MeshEdge, error) {
+	v, err := c.cli.GetEdge(ctx, &v1.MeshEdge{Source: source.String(), Target: target.String()})
+	if err != nil {
+		return types.MeshEdge{}, err
+	}
+	return types.MeshEdge{MeshEdge: v}, nil
+}
+
+// DeleteEdge deletes the edge between the given nodes.
+func (c *AdminClient) DeleteEdge(ctx context.Context, source, target types.NodeID) error {
+	_, err := c.cli.DeleteEdge(ctx, &v1.MeshEdge{Source: source.String(), Target: target.String()})
+	return err
+}
+
+// ListEdges returns all edges.
+func (c *AdminClient) ListEdges(ctx context.Context) ([]types.MeshEdge, error) {
+	resp, err := c.cli.ListEdges(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return wrapItems(resp.GetItems(), func(v *v1.MeshEdge) types.MeshEdge { return types.MeshEdge{MeshEdge: v} }), nil
+}
+
+func wrapItems[P any, T any](items []P, wrap func(P) T) []T {
+	out := make([]T, len(items))
+	for i, item := range items {
+		out[i] = wrap(item)
+	}
+	return out
+}
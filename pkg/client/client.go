@@ -0,0 +1,171 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package client provides typed Go wrappers around the webmesh gRPC APIs
+// for use by external tooling.
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+)
+
+const (
+	// DefaultMaxRetries is the default number of times a unary request is
+	// retried when the server is unavailable.
+	DefaultMaxRetries = 3
+	// DefaultRetryBackoff is the default initial backoff between retries.
+	DefaultRetryBackoff = 250 * time.Millisecond
+)
+
+// Options are options for creating a new client.
+type Options struct {
+	// Address is the address of a node's gRPC server.
+	Address string
+	// DialOptions are the options used when dialing the node and any leader
+	// the client is redirected to. They must include transport credentials
+	// and any authentication credentials the server requires.
+	DialOptions []grpc.DialOption
+	// PreferLeader asks nodes to proxy requests that may be served locally
+	// to the leader instead.
+	PreferLeader bool
+	// MaxRetries is the number of times a unary request is retried when the
+	// server is unavailable. Zero uses DefaultMaxRetries and a negative value
+	// disables retries.
+	MaxRetries int
+	// RetryBackoff is the initial backoff between retries. It doubles after
+	// each attempt. Zero uses DefaultRetryBackoff.
+	RetryBackoff time.Duration
+	// DisableLeaderRedirect disables retrying requests rejected by a
+	// non-leader against the leader named in the error.
+	DisableLeaderRedirect bool
+}
+
+// Client is a client for the webmesh APIs.
+type Client struct {
+	conn *grpc.ClientConn
+}
+
+// New dials the node at the configured address and returns a new client.
+func New(ctx context.Context, opts Options) (*Client, error) {
+	if opts.Address == "" {
+		return nil, errors.New("address must be provided")
+	}
+	conn, err := grpc.DialContext(ctx, opts.Address, opts.dialOptions()...)
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", opts.Address, err)
+	}
+	return &Client{conn: conn}, nil
+}
+
+// Conn returns the underlying gRPC connection.
+func (c *Client) Conn() *grpc.ClientConn {
+	return c.conn
+}
+
+// Close closes the underlying connection.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+// Admin returns a client for the admin API.
+func (c *Client) Admin() *AdminClient {
+	return &AdminClient{v1.NewAdminClient(c.conn)}
+}
+
+// Membership returns a client for the membership API.
+func (c *Client) Membership() *MembershipClient {
+	return &MembershipClient{v1.NewMembershipClient(c.conn)}
+}
+
+// Node returns a client for the node and mesh APIs.
+func (c *Client) Node() *NodeClient {
+	return &NodeClient{node: v1.NewNodeClient(c.conn), mesh: v1.NewMeshClient(c.conn)}
+}
+
+// Storage returns a client for the storage query API.
+func (c *Client) Storage() *StorageClient {
+	return &StorageClient{v1.NewStorageQueryServiceClient(c.conn)}
+}
+
+// IsNotFound returns true if the error is a NotFound status error.
+func IsNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound
+}
+
+func (o Options) dialOptions() []grpc.DialOption {
+	opts := append([]grpc.DialOption{}, o.DialOptions...)
+	unary := []grpc.UnaryClientInterceptor{
+		retryUnaryClientInterceptor(o.maxRetries(), o.retryBackoff()),
+		context.RequestIDUnaryClientInterceptor(),
+	}
+	stream := []grpc.StreamClientInterceptor{
+		context.RequestIDStreamClientInterceptor(),
+	}
+	if !o.DisableLeaderRedirect {
+		// The redirect dials use the caller's options without our
+		// interceptors so redirects are never chained.
+		redirectOpts := append([]grpc.DialOption{}, o.DialOptions...)
+		unary = append(unary, leaderproxy.NewLeaderHintUnaryClientInterceptor(
+			func(ctx context.Context, hint leaderproxy.LeaderHint) (transport.RPCClientConn, error) {
+				addr := hint.PublicAddress
+				if addr == "" {
+					addr = hint.Address
+				}
+				if addr == "" {
+					return nil, fmt.Errorf("no address for leader %q", hint.ID)
+				}
+				return grpc.DialContext(ctx, addr, redirectOpts...)
+			},
+		))
+	}
+	if o.PreferLeader {
+		unary = append(unary, preferLeaderUnaryClientInterceptor())
+		stream = append(stream, preferLeaderStreamClientInterceptor())
+	}
+	return append(opts,
+		grpc.WithChainUnaryInterceptor(unary...),
+		grpc.WithChainStreamInterceptor(stream...),
+	)
+}
+
+func (o Options) maxRetries() int {
+	switch {
+	case o.MaxRetries < 0:
+		return 0
+	case o.MaxRetries == 0:
+		return DefaultMaxRetries
+	default:
+		return o.MaxRetries
+	}
+}
+
+func (o Options) retryBackoff() time.Duration {
+	if o.RetryBackoff <= 0 {
+		return DefaultRetryBackoff
+	}
+	return o.RetryBackoff
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+)
+
+type fakeAdmin struct {
+	v1.UnimplementedAdminServer
+	calls   atomic.Int32
+	respond func(call int32) error
+}
+
+func (f *fakeAdmin) GetRoute(ctx context.Context, route *v1.Route) (*v1.Route, error) {
+	if err := f.respond(f.calls.Add(1)); err != nil {
+		return nil, err
+	}
+	return &v1.Route{Name: route.GetName(), Node: "node-a"}, nil
+}
+
+func serveAdmin(t *testing.T, srv *fakeAdmin) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := grpc.NewServer()
+	v1.RegisterAdminServer(s, srv)
+	go func() { _ = s.Serve(ln) }()
+	t.Cleanup(s.Stop)
+	return ln.Addr().String()
+}
+
+func newTestClient(t *testing.T, addr string) *Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	c, err := New(ctx, Options{
+		Address:      addr,
+		DialOptions:  []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+		RetryBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+	t.Cleanup(func() { _ = c.Close() })
+	return c
+}
+
+func TestClientRetriesUnavailable(t *testing.T) {
+	t.Parallel()
+	srv := &fakeAdmin{respond: func(call int32) error {
+		if call < 3 {
+			return status.Error(codes.Unavailable, "try again")
+		}
+		return nil
+	}}
+	c := newTestClient(t, serveAdmin(t, srv))
+	route, err := c.Admin().GetRoute(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("get route: %v", err)
+	}
+	if route.GetName() != "test" || route.GetNode() != "node-a" {
+		t.Fatalf("unexpected route: %v", route.Route)
+	}
+	if got := srv.calls.Load(); got != 3 {
+		t.Fatalf("expected 3 calls, got %d", got)
+	}
+
+	// Other errors are not retried.
+	srv.calls.Store(0)
+	srv.respond = func(int32) error { return status.Error(codes.NotFound, "no route") }
+	_, err = c.Admin().GetRoute(context.Background(), "test")
+	if !IsNotFound(err) {
+		t.Fatalf("expected not found, got %v", err)
+	}
+	if got := srv.calls.Load(); got != 1 {
+		t.Fatalf("expected 1 call, got %d", got)
+	}
+}
+
+func TestClientFollowsLeaderHint(t *testing.T) {
+	t.Parallel()
+	leader := &fakeAdmin{respond: func(int32) error { return nil }}
+	leaderAddr := serveAdmin(t, leader)
+	follower := &fakeAdmin{respond: func(int32) error {
+		st, _ := status.New(codes.FailedPrecondition, "not the leader").WithDetails(&errdetails.ErrorInfo{
+			Reason: leaderproxy.NotLeaderReason,
+			Domain: leaderproxy.ErrorDomain,
+			Metadata: map[string]string{
+				leaderproxy.LeaderIDDetail:      "node-b",
+				leaderproxy.LeaderAddressDetail: leaderAddr,
+			},
+		})
+		return st.Err()
+	}}
+	c := newTestClient(t, serveAdmin(t, follower))
+	route, err := c.Admin().GetRoute(context.Background(), "test")
+	if err != nil {
+		t.Fatalf("get route: %v", err)
+	}
+	if route.GetName() != "test" {
+		t.Fatalf("unexpected route: %v", route.Route)
+	}
+	if follower.calls.Load() != 1 || leader.calls.Load() != 1 {
+		t.Fatalf("expected one call to each server, got follower=%d leader=%d", follower.calls.Load(), leader.calls.Load())
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"log/slog"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+)
+
+// retryUnaryClientInterceptor retries unary requests that fail because the
+// server is unavailable, backing off exponentially between attempts.
+func retryUnaryClientInterceptor(maxRetries int, backoff time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		// Fix the request ID before the first attempt so every retry
+		// can be correlated on the server side.
+		if _, ok := context.RequestIDFrom(ctx); !ok {
+			ctx = context.WithRequestID(ctx, context.NewRequestID())
+		}
+		var err error
+		for attempt := 0; ; attempt++ {
+			err = invoker(ctx, method, req, reply, cc, opts...)
+			if err == nil || status.Code(err) != codes.Unavailable || attempt >= maxRetries {
+				return err
+			}
+			context.LoggerFrom(ctx).Debug("Retrying unavailable request",
+				slog.String("method", method),
+				slog.Int("attempt", attempt+1),
+				slog.String("error", err.Error()),
+			)
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(backoff << attempt):
+			}
+		}
+	}
+}
+
+func preferLeaderUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx = metadata.AppendToOutgoingContext(ctx, leaderproxy.PreferLeaderMeta, "true")
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+func preferLeaderStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx = metadata.AppendToOutgoingContext(ctx, leaderproxy.PreferLeaderMeta, "true")
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
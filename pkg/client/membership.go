@@ -0,0 +1,85 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"errors"
+	"io"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// MembershipClient is a typed client for the membership API.
+type MembershipClient struct {
+	cli v1.MembershipClient
+}
+
+// Raw returns the underlying generated client.
+func (c *MembershipClient) Raw() v1.MembershipClient {
+	return c.cli
+}
+
+// Join requests to join the mesh.
+func (c *MembershipClient) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinResponse, error) {
+	return c.cli.Join(ctx, req)
+}
+
+// Update updates the calling node's membership in the mesh.
+func (c *MembershipClient) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateResponse, error) {
+	return c.cli.Update(ctx, req)
+}
+
+// Leave removes the node with the given ID from the mesh.
+func (c *MembershipClient) Leave(ctx context.Context, id string) error {
+	_, err := c.cli.Leave(ctx, &v1.LeaveRequest{Id: id})
+	return err
+}
+
+// GetCurrentConsensus returns the current storage consensus of the mesh.
+func (c *MembershipClient) GetCurrentConsensus(ctx context.Context) (*v1.StorageConsensusResponse, error) {
+	return c.cli.GetCurrentConsensus(ctx, &v1.StorageConsensusRequest{})
+}
+
+// SubscribePeers calls fn with the peer configurations for the given node
+// whenever they change. It blocks until the context is canceled, the stream
+// ends, or fn returns an error.
+func (c *MembershipClient) SubscribePeers(ctx context.Context, id string, fn func(*v1.PeerConfigurations) error) error {
+	stream, err := c.cli.SubscribePeers(ctx, &v1.SubscribePeersRequest{Id: id})
+	if err != nil {
+		return err
+	}
+	return recvAll[v1.PeerConfigurations](ctx, stream.Recv, fn)
+}
+
+// recvAll receives messages from a stream until it ends, calling fn on each.
+// A clean end of stream or a canceled context is not treated as an error.
+func recvAll[T any](ctx context.Context, recv func() (*T, error), fn func(*T) error) error {
+	for {
+		msg, err := recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if err := fn(msg); err != nil {
+			return err
+		}
+	}
+}
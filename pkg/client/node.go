@@ -0,0 +1,70 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// NodeClient is a typed client for the node and mesh APIs.
+type NodeClient struct {
+	node v1.NodeClient
+	mesh v1.MeshClient
+}
+
+// Raw returns the underlying generated node client.
+func (c *NodeClient) Raw() v1.NodeClient {
+	return c.node
+}
+
+// RawMesh returns the underlying generated mesh client.
+func (c *NodeClient) RawMesh() v1.MeshClient {
+	return c.mesh
+}
+
+// GetStatus returns the status of the node with the given ID. An empty ID
+// returns the status of the node the client is connected to.
+func (c *NodeClient) GetStatus(ctx context.Context, id types.NodeID) (*v1.Status, error) {
+	return c.node.GetStatus(ctx, &v1.GetStatusRequest{Id: id.String()})
+}
+
+// GetNode returns the node with the given ID.
+func (c *NodeClient) GetNode(ctx context.Context, id types.NodeID) (types.MeshNode, error) {
+	node, err := c.mesh.GetNode(ctx, &v1.GetNodeRequest{Id: id.String()})
+	if err != nil {
+		return types.MeshNode{}, err
+	}
+	return types.MeshNode{MeshNode: node}, nil
+}
+
+// ListNodes returns all nodes in the mesh.
+func (c *NodeClient) ListNodes(ctx context.Context) ([]types.MeshNode, error) {
+	resp, err := c.mesh.ListNodes(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return wrapItems(resp.GetNodes(), func(v *v1.MeshNode) types.MeshNode { return types.MeshNode{MeshNode: v} }), nil
+}
+
+// GetMeshGraph returns the graph of the mesh.
+func (c *NodeClient) GetMeshGraph(ctx context.Context) (*v1.MeshGraph, error) {
+	return c.mesh.GetMeshGraph(ctx, &emptypb.Empty{})
+}
@@ -0,0 +1,93 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package client
+
+import (
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// StorageClient is a typed client for the storage query API.
+type StorageClient struct {
+	cli v1.StorageQueryServiceClient
+}
+
+// Raw returns the underlying generated client.
+func (c *StorageClient) Raw() v1.StorageQueryServiceClient {
+	return c.cli
+}
+
+// Query runs a raw query against the mesh storage.
+func (c *StorageClient) Query(ctx context.Context, req *v1.QueryRequest) ([][]byte, error) {
+	resp, err := c.cli.Query(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.GetItems(), nil
+}
+
+// GetValue returns the value of the given key.
+func (c *StorageClient) GetValue(ctx context.Context, key string) ([]byte, error) {
+	items, err := c.Query(ctx, &v1.QueryRequest{
+		Command: v1.QueryRequest_GET,
+		Type:    v1.QueryRequest_VALUE,
+		Query:   types.NewQueryFilters().WithID(key).Encode(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+	return items[0], nil
+}
+
+// ListKeys returns all keys with the given prefix.
+func (c *StorageClient) ListKeys(ctx context.Context, prefix string) ([][]byte, error) {
+	return c.Query(ctx, &v1.QueryRequest{
+		Command: v1.QueryRequest_LIST,
+		Type:    v1.QueryRequest_KEYS,
+		Query:   types.NewQueryFilters().WithID(prefix).Encode(),
+	})
+}
+
+// Publish sets the value of a key. A zero TTL means the key does not expire.
+func (c *StorageClient) Publish(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := c.cli.Publish(ctx, &v1.PublishRequest{
+		Key:   []byte(key),
+		Value: value,
+		Ttl:   durationpb.New(ttl),
+	})
+	return err
+}
+
+// Subscribe calls fn whenever a key with the given prefix changes. It blocks
+// until the context is canceled, the stream ends, or fn returns an error.
+func (c *StorageClient) Subscribe(ctx context.Context, prefix string, fn func(key, value []byte) error) error {
+	stream, err := c.cli.Subscribe(ctx, &v1.SubscribeRequest{Prefix: []byte(prefix)})
+	if err != nil {
+		return err
+	}
+	return recvAll[v1.SubscriptionEvent](ctx, stream.Recv, func(ev *v1.SubscriptionEvent) error {
+		return fn(ev.GetKey(), ev.GetValue())
+	})
+}
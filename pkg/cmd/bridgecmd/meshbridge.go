@@ -272,8 +272,8 @@ func RunBridgeConnection(ctx context.Context, conf config.BridgeOptions) error {
 					time.Sleep(time.Second)
 					continue
 				}
-				defer c.Close()
 				_, err = v1.NewMembershipClient(c).Update(ctx, req)
+				_ = c.Close()
 				if err != nil {
 					tries++
 					log.Error("Failed to send update RPC to mesh leader", slog.String("error", err.Error()))
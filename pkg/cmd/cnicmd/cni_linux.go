@@ -0,0 +1,232 @@
+//go:build linux
+
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cnicmd
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types"
+	types100 "github.com/containernetworking/cni/pkg/types/100"
+	"github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/ip"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/attach"
+	"github.com/webmeshproj/webmesh/pkg/services/cni"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// Main runs the CNI plugin.
+func Main() {
+	skel.PluginMain(cmdAdd, cmdCheck, cmdDel, version.All, about())
+}
+
+func cmdAdd(args *skel.CmdArgs) error {
+	conf, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	tags, err := podTags(conf, args.Args)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	client := cni.NewClient(conf.Socket)
+	id := attachmentID(args.ContainerID, args.IfName)
+	att, err := client.Allocate(ctx, cni.AllocateRequest{
+		ID:          id,
+		ContainerID: args.ContainerID,
+		Netns:       args.Netns,
+		Tags:        tags,
+	})
+	if err != nil {
+		return fmt.Errorf("allocate pod addresses: %w", err)
+	}
+	result, err := setupPod(ctx, conf, args, att)
+	if err != nil {
+		if rerr := client.Release(ctx, id); rerr != nil {
+			err = errors.Join(err, fmt.Errorf("release pod addresses: %w", rerr))
+		}
+		return err
+	}
+	return types.PrintResult(result, conf.CNIVersion)
+}
+
+func cmdDel(args *skel.CmdArgs) error {
+	conf, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	// Removing the pod end of the veth removes the host end and its routes.
+	if args.Netns != "" {
+		err := ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+			if err := ip.DelLinkByName(args.IfName); err != nil && !errors.Is(err, ip.ErrLinkNotFound) {
+				return err
+			}
+			return nil
+		})
+		var notExist ns.NSPathNotExistErr
+		if err != nil && !errors.As(err, &notExist) {
+			return fmt.Errorf("remove pod interface: %w", err)
+		}
+	}
+	if err := cni.NewClient(conf.Socket).Release(ctx, attachmentID(args.ContainerID, args.IfName)); err != nil {
+		return fmt.Errorf("release pod addresses: %w", err)
+	}
+	return nil
+}
+
+func cmdCheck(args *skel.CmdArgs) error {
+	conf, err := loadConf(args.StdinData)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	att, err := cni.NewClient(conf.Socket).Get(ctx, attachmentID(args.ContainerID, args.IfName))
+	if err != nil {
+		return fmt.Errorf("get pod addresses: %w", err)
+	}
+	return ns.WithNetNSPath(args.Netns, func(_ ns.NetNS) error {
+		link, err := netlink.LinkByName(args.IfName)
+		if err != nil {
+			return fmt.Errorf("get pod interface: %w", err)
+		}
+		addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+		if err != nil {
+			return fmt.Errorf("list pod addresses: %w", err)
+		}
+		for _, want := range []netip.Prefix{att.AddressV4, att.AddressV6} {
+			if !want.IsValid() {
+				continue
+			}
+			var found bool
+			for _, addr := range addrs {
+				if addr.IPNet.String() == want.String() {
+					found = true
+				}
+			}
+			if !found {
+				return fmt.Errorf("pod interface %s is missing address %s", args.IfName, want)
+			}
+		}
+		return nil
+	})
+}
+
+// setupPod creates a veth pair between the host and the pod, assigns the
+// addresses of the attachment to the pod end, and routes them to the host end.
+// The pod reaches the mesh through the link-local gateways on the host end.
+func setupPod(ctx context.Context, conf *NetConf, args *skel.CmdArgs, att storage.Attachment) (*types100.Result, error) {
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		return nil, fmt.Errorf("open pod netns: %w", err)
+	}
+	defer netns.Close()
+	hostName := hostVethName(args.ContainerID, args.IfName)
+	// Remove a veth left behind by a previous attempt.
+	if err := ip.DelLinkByName(hostName); err != nil && !errors.Is(err, ip.ErrLinkNotFound) {
+		return nil, fmt.Errorf("remove stale host veth: %w", err)
+	}
+	result := &types100.Result{CNIVersion: types100.ImplementedSpecVersion}
+	err = netns.Do(func(hostNS ns.NetNS) error {
+		hostVeth, podVeth, err := ip.SetupVethWithName(args.IfName, hostName, conf.MTU, "", hostNS)
+		if err != nil {
+			return fmt.Errorf("create veth: %w", err)
+		}
+		result.Interfaces = []*types100.Interface{
+			{Name: hostVeth.Name, Mac: hostVeth.HardwareAddr.String()},
+			{Name: podVeth.Name, Mac: podVeth.HardwareAddr.String(), Sandbox: args.Netns},
+		}
+		link, err := netlink.LinkByName(podVeth.Name)
+		if err != nil {
+			return fmt.Errorf("get pod interface: %w", err)
+		}
+		podIndex := 1
+		if att.AddressV4.IsValid() {
+			gw := net.IP(attach.GatewayV4.Addr().AsSlice())
+			if err := addAddress(link, att.AddressV4, 0); err != nil {
+				return err
+			}
+			err := netlink.RouteAdd(&netlink.Route{
+				LinkIndex: link.Attrs().Index,
+				Dst:       prefixToIPNet(attach.GatewayV4),
+				Scope:     netlink.SCOPE_LINK,
+			})
+			if err != nil {
+				return fmt.Errorf("add gateway route: %w", err)
+			}
+			if err := netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Gw: gw}); err != nil {
+				return fmt.Errorf("add default route: %w", err)
+			}
+			result.IPs = append(result.IPs, &types100.IPConfig{Interface: &podIndex, Address: *prefixToIPNet(att.AddressV4), Gateway: gw})
+			result.Routes = append(result.Routes, &types.Route{Dst: net.IPNet{IP: net.IPv4zero, Mask: net.CIDRMask(0, 32)}, GW: gw})
+		}
+		if att.AddressV6.IsValid() {
+			gw := net.IP(attach.GatewayV6.Addr().AsSlice())
+			// The address is unique within the prefix of the node, so skip
+			// duplicate address detection to make it usable right away.
+			if err := addAddress(link, att.AddressV6, unix.IFA_F_NODAD); err != nil {
+				return err
+			}
+			if err := netlink.RouteAdd(&netlink.Route{LinkIndex: link.Attrs().Index, Gw: gw}); err != nil {
+				return fmt.Errorf("add default ipv6 route: %w", err)
+			}
+			result.IPs = append(result.IPs, &types100.IPConfig{Interface: &podIndex, Address: *prefixToIPNet(att.AddressV6), Gateway: gw})
+			result.Routes = append(result.Routes, &types.Route{Dst: net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}, GW: gw})
+		}
+		return nil
+	})
+	if err == nil {
+		err = attach.ConfigureHostLink(ctx, hostName, att)
+	}
+	if err != nil {
+		_ = ip.DelLinkByName(hostName)
+		return nil, err
+	}
+	return result, nil
+}
+
+func addAddress(link netlink.Link, addr netip.Prefix, flags int) error {
+	if err := netlink.AddrAdd(link, &netlink.Addr{IPNet: prefixToIPNet(addr), Flags: flags}); err != nil {
+		return fmt.Errorf("add address %s: %w", addr, err)
+	}
+	return nil
+}
+
+func prefixToIPNet(p netip.Prefix) *net.IPNet {
+	return &net.IPNet{IP: p.Addr().AsSlice(), Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen())}
+}
+
+func init() {
+	// Keep main on the thread group leader since namespace operations
+	// only affect the calling thread.
+	runtime.LockOSThread()
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cnicmd contains the entrypoint for the webmesh CNI plugin.
+package cnicmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/containernetworking/cni/pkg/types"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/services/cni"
+	"github.com/webmeshproj/webmesh/pkg/version"
+)
+
+// apiTimeout is the timeout for calls to the node.
+const apiTimeout = 30 * time.Second
+
+// NamespaceTagPrefix prefixes the namespace of a pod in the tag added to its
+// attachment, so that network ACLs can reference pods by namespace.
+const NamespaceTagPrefix = "k8s-ns-"
+
+// NetConf is the network configuration of the plugin.
+type NetConf struct {
+	types.NetConf
+	// Socket is the socket of the CNI API served by the node.
+	Socket string `json:"socket,omitempty"`
+	// MTU is the MTU of pod interfaces. It should not exceed the MTU
+	// of the mesh interface.
+	MTU int `json:"mtu,omitempty"`
+	// Tags are added to the attachments of all pods.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// k8sArgs are the arguments passed by kubelet in CNI_ARGS.
+type k8sArgs struct {
+	types.CommonArgs
+	K8S_POD_NAMESPACE types.UnmarshallableString
+	K8S_POD_NAME      types.UnmarshallableString
+}
+
+func loadConf(data []byte) (*NetConf, error) {
+	conf := &NetConf{
+		Socket: cni.DefaultSocket,
+		MTU:    system.DefaultMTU,
+	}
+	if err := json.Unmarshal(data, conf); err != nil {
+		return nil, fmt.Errorf("parse network configuration: %w", err)
+	}
+	return conf, nil
+}
+
+func podTags(conf *NetConf, cniArgs string) ([]string, error) {
+	var args k8sArgs
+	args.IgnoreUnknown = true
+	if err := types.LoadArgs(cniArgs, &args); err != nil {
+		return nil, fmt.Errorf("parse cni args: %w", err)
+	}
+	tags := append([]string(nil), conf.Tags...)
+	if args.K8S_POD_NAMESPACE != "" {
+		tags = append(tags, NamespaceTagPrefix+string(args.K8S_POD_NAMESPACE))
+	}
+	return tags, nil
+}
+
+// attachmentID returns the ID of the attachment for an interface in a pod.
+func attachmentID(containerID, ifName string) string {
+	id := containerID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return "cni-" + id + "-" + ifName
+}
+
+// hostVethName returns the name of the host end of the veth for an interface
+// in a pod. It fits in the 15 character limit for interfaces.
+func hostVethName(containerID, ifName string) string {
+	sum := sha256.Sum256([]byte(containerID + "/" + ifName))
+	return "wm" + hex.EncodeToString(sum[:])[:13]
+}
+
+func about() string {
+	return fmt.Sprintf("webmesh CNI plugin %s", version.GetBuildInfo().Version)
+}
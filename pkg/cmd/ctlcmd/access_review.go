@@ -0,0 +1,78 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var getExpiringRoleBindingsWithin string
+
+func init() {
+	getExpiringRoleBindingsCmd.Flags().StringVar(&getExpiringRoleBindingsWithin, "within", "", "list rolebindings expiring within this duration, 720h if unset")
+	getCmd.AddCommand(getExpiringRoleBindingsCmd)
+}
+
+var getExpiringRoleBindingsCmd = &cobra.Command{
+	Use:   "expiring-rolebindings",
+	Short: "List the rolebindings that are about to expire",
+	Long: `List the rolebindings that are about to expire, soonest first.
+
+Rolebindings are given an expiry with "wmctl put rolebindings --expires" and
+are removed by the leader once it passes. Putting a rolebinding again replaces
+its expiry, so a rolebinding that passes review is renewed by putting it with
+a new expiry, or made permanent by putting it without one.`,
+	Aliases: []string{"expiring-rolebinding", "expiring-rb"},
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		fields := map[string]any{}
+		if getExpiringRoleBindingsWithin != "" {
+			fields["within"] = getExpiringRoleBindingsWithin
+		}
+		req, err := structpb.NewStruct(fields)
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewAccessReviewClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.ListExpiringRoleBindings(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		return encodeToStdout(cmd, resp)
+	},
+}
+
+// parseExpiry parses an expiry given as either a duration from now or an
+// RFC3339 time.
+func parseExpiry(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(d), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry %q: must be a duration or an RFC3339 time", s)
+	}
+	return t, nil
+}
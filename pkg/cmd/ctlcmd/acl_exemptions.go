@@ -0,0 +1,139 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var (
+	putACLExemptionsICMPEcho  bool
+	putACLExemptionsMeshDNS   bool
+	putACLExemptionsOverrides []string
+)
+
+func init() {
+	putACLExemptionsFlags := putACLExemptionsCmd.Flags()
+	putACLExemptionsFlags.BoolVar(&putACLExemptionsICMPEcho, "icmp-echo", false, "always allow ICMP echo between members")
+	putACLExemptionsFlags.BoolVar(&putACLExemptionsMeshDNS, "mesh-dns", false, "always allow members to query nodes serving meshdns")
+	putACLExemptionsFlags.StringArrayVar(&putACLExemptionsOverrides, "override", nil, "per-ACL override in the format ACL_NAME:icmp-echo|mesh-dns=true|false")
+
+	putCmd.AddCommand(putACLExemptionsCmd)
+	getCmd.AddCommand(getACLExemptionsCmd)
+}
+
+var putACLExemptionsCmd = &cobra.Command{
+	Use:   "acl-exemptions",
+	Short: "Set the traffic allowed between members regardless of network ACLs",
+	Long: `Set the traffic allowed between members regardless of network ACLs.
+
+Exempt traffic passes deny ACLs and the default deny, so that ping and name
+resolution keep working in meshes that deny by default. An override changes
+whether exempt traffic passes a single deny ACL, for example to keep blocking
+ICMP to sensitive nodes while the mesh-wide toggle is on.
+
+Nodes are peered when exempt traffic is allowed between them. Other traffic
+between such peers is only dropped by nodes tracking connections. The full set
+of exemptions is replaced on every invocation, which requires full access to
+the mesh.`,
+	Aliases: []string{"acl-exemption", "exemptions"},
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		exemptions := types.ACLExemptions{
+			ICMPEcho: putACLExemptionsICMPEcho,
+			MeshDNS:  putACLExemptionsMeshDNS,
+		}
+		for _, spec := range putACLExemptionsOverrides {
+			name, setting, ok := strings.Cut(spec, ":")
+			if !ok {
+				return fmt.Errorf("invalid override %q, expected ACL_NAME:KIND=BOOL", spec)
+			}
+			kind, value, ok := strings.Cut(setting, "=")
+			if !ok {
+				return fmt.Errorf("invalid override %q, expected ACL_NAME:KIND=BOOL", spec)
+			}
+			allow, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid override %q: %w", spec, err)
+			}
+			if exemptions.Overrides == nil {
+				exemptions.Overrides = make(map[string]types.ACLExemptionOverride)
+			}
+			override := exemptions.Overrides[name]
+			switch types.Exemption(kind) {
+			case types.ExemptICMPEcho:
+				override.ICMPEcho = &allow
+			case types.ExemptMeshDNS:
+				override.MeshDNS = &allow
+			default:
+				return fmt.Errorf("invalid override %q, unknown exemption %q", spec, kind)
+			}
+			exemptions.Overrides[name] = override
+		}
+		if err := exemptions.Validate(); err != nil {
+			return err
+		}
+		req, err := meshadmin.EncodeFields(map[string]any{"exemptions": exemptions})
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		_, err = client.PutACLExemptions(cmd.Context(), req)
+		return err
+	},
+}
+
+var getACLExemptionsCmd = &cobra.Command{
+	Use:     "acl-exemptions",
+	Short:   "Get the traffic allowed between members regardless of network ACLs",
+	Aliases: []string{"acl-exemption", "exemptions"},
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.GetACLExemptions(cmd.Context(), &structpb.Struct{})
+		if err != nil {
+			return err
+		}
+		var exemptions types.ACLExemptions
+		if err := meshadmin.DecodeField(resp, "exemptions", &exemptions); err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(exemptions, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(data))
+		return nil
+	},
+}
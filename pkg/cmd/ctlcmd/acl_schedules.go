@@ -0,0 +1,166 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var (
+	putACLScheduleStart   string
+	putACLScheduleEnd     string
+	putACLScheduleWindows []string
+)
+
+func init() {
+	putACLScheduleFlags := putACLScheduleCmd.Flags()
+	putACLScheduleFlags.StringVar(&putACLScheduleStart, "start", "", "RFC3339 time the ACL comes into effect, immediately if unset")
+	putACLScheduleFlags.StringVar(&putACLScheduleEnd, "end", "", "RFC3339 time the ACL stops being in effect, never if unset")
+	putACLScheduleFlags.StringArrayVar(&putACLScheduleWindows, "window", nil, "recurring window the ACL is in effect during in the format \"[DAYS ]HH:MM-HH:MM[ LOCATION]\"")
+
+	putCmd.AddCommand(putACLScheduleCmd)
+	getCmd.AddCommand(getACLSchedulesCmd)
+	deleteCmd.AddCommand(deleteACLSchedulesCmd)
+}
+
+var putACLScheduleCmd = &cobra.Command{
+	Use:   "acl-schedule [ACL_NAME]",
+	Short: "Restrict when a network ACL is in effect",
+	Long: `Restrict when a network ACL is in effect.
+
+A scheduled ACL is only evaluated between its start and end, and during one of
+its recurring windows if any are given. Outside of its schedule the ACL is
+skipped as if it did not exist, so an accept ACL with a schedule permits access
+only during maintenance windows. Nodes enforce the ACLs on existing peers and
+flows whenever a schedule opens or closes.
+
+Windows are given as days of the week, a time of day range, and an optional
+IANA time zone, for example "mon-fri 02:00-04:00 Europe/Berlin". Windows
+without days open every day and windows ending before they start end on the
+following day. The schedule of the ACL is replaced on every invocation, which
+requires permission to put the ACL.`,
+	Aliases: []string{"acl-schedules"},
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var schedule types.ACLSchedule
+		if putACLScheduleStart != "" {
+			start, err := time.Parse(time.RFC3339, putACLScheduleStart)
+			if err != nil {
+				return fmt.Errorf("parse start: %w", err)
+			}
+			schedule.Start = &start
+		}
+		if putACLScheduleEnd != "" {
+			end, err := time.Parse(time.RFC3339, putACLScheduleEnd)
+			if err != nil {
+				return fmt.Errorf("parse end: %w", err)
+			}
+			schedule.End = &end
+		}
+		for _, spec := range putACLScheduleWindows {
+			window, err := types.ParseACLWindow(spec)
+			if err != nil {
+				return err
+			}
+			schedule.Windows = append(schedule.Windows, window)
+		}
+		if schedule.Start == nil && schedule.End == nil && len(schedule.Windows) == 0 {
+			return fmt.Errorf("at least one of --start, --end, or --window is required")
+		}
+		if err := schedule.Validate(); err != nil {
+			return err
+		}
+		req, err := meshadmin.EncodeFields(map[string]any{"name": args[0], "schedule": schedule})
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		_, err = client.PutACLSchedule(cmd.Context(), req)
+		return err
+	},
+}
+
+var getACLSchedulesCmd = &cobra.Command{
+	Use:     "acl-schedules [ACL_NAME]",
+	Short:   "Get the schedules of network ACLs",
+	Aliases: []string{"acl-schedule"},
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		req := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+		if len(args) == 1 {
+			req.Fields["name"] = structpb.NewStringValue(args[0])
+		}
+		resp, err := client.GetACLSchedules(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		var schedules types.ACLSchedules
+		if err := meshadmin.DecodeField(resp, "schedules", &schedules); err != nil {
+			return err
+		}
+		var out any = schedules
+		if len(args) == 1 {
+			out = schedules[args[0]]
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(data))
+		return nil
+	},
+}
+
+var deleteACLSchedulesCmd = &cobra.Command{
+	Use:     "acl-schedules [ACL_NAME...]",
+	Short:   "Remove the schedules of network ACLs, putting them in effect at all times",
+	Aliases: []string{"acl-schedule"},
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		for _, name := range args {
+			_, err := client.DeleteACLSchedule(cmd.Context(), &structpb.Struct{Fields: map[string]*structpb.Value{
+				"name": structpb.NewStringValue(name),
+			}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"context"
+	"errors"
+
+	"github.com/spf13/cobra"
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/cmd/ctlcmd/apply"
+	"github.com/webmeshproj/webmesh/pkg/storage/rpcdb"
+)
+
+var (
+	applyFiles  []string
+	applyDryRun bool
+	applyPrune  bool
+)
+
+func init() {
+	applyFlags := applyCmd.Flags()
+	applyFlags.StringArrayVarP(&applyFiles, "filename", "f", nil, "manifest files or directories to apply")
+	applyFlags.BoolVar(&applyDryRun, "dry-run", false, "print the plan without applying it")
+	applyFlags.BoolVar(&applyPrune, "prune", false, "delete resources of the declared kinds that are not in any manifest")
+	cobra.CheckErr(applyCmd.MarkFlagRequired("filename"))
+	rootCmd.AddCommand(applyCmd)
+}
+
+var applyCmd = &cobra.Command{
+	Use:   "apply -f DIR",
+	Short: "Apply declarative resource manifests to the mesh",
+	Long: `Apply declarative resource manifests to the mesh.
+
+Manifests are YAML documents with a "kind" field and the fields of the
+corresponding API object. Supported kinds are Node, Group, Role, RoleBinding,
+NetworkACL, and Route. For example:
+
+	kind: Route
+	name: office
+	node: gateway
+	destinationCIDRs: ["10.10.0.0/16"]
+
+The manifests are compared against the current mesh state and the resulting
+plan is printed. Unless --dry-run is given the plan is then applied. If any
+change fails, the changes already made are reverted.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		ctx := cmd.Context()
+		resources, err := apply.Load(applyFiles...)
+		if err != nil {
+			return err
+		}
+		if len(resources) == 0 {
+			return errors.New("no resources found")
+		}
+		client, closer, err := cliConfig.NewStorageQueryClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		db := rpcdb.Open(rpcdb.QuerierFunc(func(ctx context.Context, req *v1.QueryRequest) (*v1.QueryResponse, error) {
+			return client.Query(ctx, req)
+		}))
+		plan, err := apply.NewPlan(ctx, db, resources, apply.PlanOptions{Prune: applyPrune})
+		if err != nil {
+			return err
+		}
+		plan.Print(cmd.OutOrStdout())
+		if applyDryRun || plan.Empty() {
+			return nil
+		}
+		if err := plan.Apply(ctx, db); err != nil {
+			return err
+		}
+		cmd.Println("Applied", len(plan.Changes), "changes")
+		return nil
+	},
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package apply implements declarative management of mesh resources from YAML manifests.
+package apply
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Kind is the kind of a declarative resource.
+type Kind string
+
+const (
+	// KindNode is a mesh node.
+	KindNode Kind = "Node"
+	// KindGroup is an RBAC group.
+	KindGroup Kind = "Group"
+	// KindRole is an RBAC role.
+	KindRole Kind = "Role"
+	// KindRoleBinding is an RBAC role binding.
+	KindRoleBinding Kind = "RoleBinding"
+	// KindNetworkACL is a network ACL.
+	KindNetworkACL Kind = "NetworkACL"
+	// KindRoute is a network route.
+	KindRoute Kind = "Route"
+)
+
+// Kinds are the supported kinds in the order they are created. Deletions
+// happen in reverse order so references are removed before their targets.
+var Kinds = []Kind{KindNode, KindGroup, KindRole, KindRoleBinding, KindNetworkACL, KindRoute}
+
+// ParseKind parses a kind case-insensitively, accepting plural forms.
+func ParseKind(s string) (Kind, error) {
+	norm := strings.TrimSuffix(strings.ToLower(s), "s")
+	for _, kind := range Kinds {
+		if strings.ToLower(string(kind)) == norm {
+			return kind, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported kind %q", s)
+}
+
+func (k Kind) order() int {
+	for i, kind := range Kinds {
+		if kind == k {
+			return i
+		}
+	}
+	return len(Kinds)
+}
+
+// kindHandler implements storage operations for a kind.
+type kindHandler struct {
+	new      func() proto.Message
+	name     func(proto.Message) string
+	validate func(proto.Message) error
+	system   func(name string) bool
+	// inherit copies server-managed fields from the current object into
+	// the desired one before they are compared. It may be nil.
+	inherit func(desired, current proto.Message)
+	list    func(context.Context, storage.MeshDB) ([]proto.Message, error)
+	put     func(context.Context, storage.MeshDB, proto.Message) error
+	delete  func(context.Context, storage.MeshDB, string) error
+}
+
+func noSystemResources(string) bool { return false }
+
+var handlers = map[Kind]kindHandler{
+	KindNode: {
+		new:  func() proto.Message { return &v1.MeshNode{} },
+		name: func(m proto.Message) string { return m.(*v1.MeshNode).GetId() },
+		validate: func(m proto.Message) error {
+			validated, err := types.ValidateMeshNode(types.MeshNode{MeshNode: m.(*v1.MeshNode)})
+			if err != nil {
+				return err
+			}
+			// Keep the normalized form so it compares equal to what is stored.
+			proto.Reset(m)
+			proto.Merge(m, validated.MeshNode)
+			return nil
+		},
+		system: noSystemResources,
+		inherit: func(desired, current proto.Message) {
+			d, c := desired.(*v1.MeshNode), current.(*v1.MeshNode)
+			if d.JoinedAt == nil {
+				d.JoinedAt = c.JoinedAt
+			}
+		},
+		list: func(ctx context.Context, db storage.MeshDB) ([]proto.Message, error) {
+			nodes, err := db.Peers().List(ctx)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]proto.Message, len(nodes))
+			for i, node := range nodes {
+				out[i] = node.MeshNode
+			}
+			return out, nil
+		},
+		put: func(ctx context.Context, db storage.MeshDB, m proto.Message) error {
+			return db.Peers().Put(ctx, types.MeshNode{MeshNode: m.(*v1.MeshNode)})
+		},
+		delete: func(ctx context.Context, db storage.MeshDB, name string) error {
+			return db.Peers().Delete(ctx, types.NodeID(name))
+		},
+	},
+	KindGroup: {
+		new:      func() proto.Message { return &v1.Group{} },
+		name:     func(m proto.Message) string { return m.(*v1.Group).GetName() },
+		validate: func(m proto.Message) error { return types.Group{Group: m.(*v1.Group)}.Validate() },
+		system:   storage.IsSystemGroup,
+		list: func(ctx context.Context, db storage.MeshDB) ([]proto.Message, error) {
+			groups, err := db.RBAC().ListGroups(ctx)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]proto.Message, len(groups))
+			for i, group := range groups {
+				out[i] = group.Group
+			}
+			return out, nil
+		},
+		put: func(ctx context.Context, db storage.MeshDB, m proto.Message) error {
+			return db.RBAC().PutGroup(ctx, types.Group{Group: m.(*v1.Group)})
+		},
+		delete: func(ctx context.Context, db storage.MeshDB, name string) error {
+			return db.RBAC().DeleteGroup(ctx, name)
+		},
+	},
+	KindRole: {
+		new:      func() proto.Message { return &v1.Role{} },
+		name:     func(m proto.Message) string { return m.(*v1.Role).GetName() },
+		validate: func(m proto.Message) error { return types.Role{Role: m.(*v1.Role)}.Validate() },
+		system:   storage.IsSystemRole,
+		list: func(ctx context.Context, db storage.MeshDB) ([]proto.Message, error) {
+			roles, err := db.RBAC().ListRoles(ctx)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]proto.Message, len(roles))
+			for i, role := range roles {
+				out[i] = role.Role
+			}
+			return out, nil
+		},
+		put: func(ctx context.Context, db storage.MeshDB, m proto.Message) error {
+			return db.RBAC().PutRole(ctx, types.Role{Role: m.(*v1.Role)})
+		},
+		delete: func(ctx context.Context, db storage.MeshDB, name string) error {
+			return db.RBAC().DeleteRole(ctx, name)
+		},
+	},
+	KindRoleBinding: {
+		new:  func() proto.Message { return &v1.RoleBinding{} },
+		name: func(m proto.Message) string { return m.(*v1.RoleBinding).GetName() },
+		validate: func(m proto.Message) error {
+			return types.RoleBinding{RoleBinding: m.(*v1.RoleBinding)}.Validate()
+		},
+		system: storage.IsSystemRoleBinding,
+		list: func(ctx context.Context, db storage.MeshDB) ([]proto.Message, error) {
+			rbs, err := db.RBAC().ListRoleBindings(ctx)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]proto.Message, len(rbs))
+			for i, rb := range rbs {
+				out[i] = rb.RoleBinding
+			}
+			return out, nil
+		},
+		put: func(ctx context.Context, db storage.MeshDB, m proto.Message) error {
+			return db.RBAC().PutRoleBinding(ctx, types.RoleBinding{RoleBinding: m.(*v1.RoleBinding)})
+		},
+		delete: func(ctx context.Context, db storage.MeshDB, name string) error {
+			return db.RBAC().DeleteRoleBinding(ctx, name)
+		},
+	},
+	KindNetworkACL: {
+		new:  func() proto.Message { return &v1.NetworkACL{} },
+		name: func(m proto.Message) string { return m.(*v1.NetworkACL).GetName() },
+		validate: func(m proto.Message) error {
+			return types.NetworkACL{NetworkACL: m.(*v1.NetworkACL)}.Validate()
+		},
+		system: storage.IsSystemNetworkACL,
+		list: func(ctx context.Context, db storage.MeshDB) ([]proto.Message, error) {
+			acls, err := db.Networking().ListNetworkACLs(ctx)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]proto.Message, len(acls))
+			for i, acl := range acls {
+				out[i] = acl.NetworkACL
+			}
+			return out, nil
+		},
+		put: func(ctx context.Context, db storage.MeshDB, m proto.Message) error {
+			return db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: m.(*v1.NetworkACL)})
+		},
+		delete: func(ctx context.Context, db storage.MeshDB, name string) error {
+			return db.Networking().DeleteNetworkACL(ctx, name)
+		},
+	},
+	KindRoute: {
+		new:      func() proto.Message { return &v1.Route{} },
+		name:     func(m proto.Message) string { return m.(*v1.Route).GetName() },
+		validate: func(m proto.Message) error { return types.Route{Route: m.(*v1.Route)}.Validate() },
+		system:   noSystemResources,
+		list: func(ctx context.Context, db storage.MeshDB) ([]proto.Message, error) {
+			routes, err := db.Networking().ListRoutes(ctx)
+			if err != nil {
+				return nil, err
+			}
+			out := make([]proto.Message, len(routes))
+			for i, route := range routes {
+				out[i] = route.Route
+			}
+			return out, nil
+		},
+		put: func(ctx context.Context, db storage.MeshDB, m proto.Message) error {
+			return db.Networking().PutRoute(ctx, types.Route{Route: m.(*v1.Route)})
+		},
+		delete: func(ctx context.Context, db storage.MeshDB, name string) error {
+			return db.Networking().DeleteRoute(ctx, name)
+		},
+	},
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apply
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/yaml.v3"
+)
+
+// Resource is a single declarative resource read from a manifest.
+type Resource struct {
+	// Kind is the kind of the resource.
+	Kind Kind
+	// Name is the name of the resource, or the ID for nodes.
+	Name string
+	// Source is the file the resource was read from.
+	Source string
+	// Object is the desired state of the resource.
+	Object proto.Message
+}
+
+// String returns a short string identifying the resource.
+func (r Resource) String() string {
+	return fmt.Sprintf("%s/%s", strings.ToLower(string(r.Kind)), r.Name)
+}
+
+// Load reads all resources from the given files and directories. Directories
+// are walked recursively for files ending in .yaml or .yml. Each file may
+// contain multiple documents separated by "---". A document holds a "kind"
+// field and the fields of the corresponding API object, for example:
+//
+//	kind: Route
+//	name: office
+//	node: gateway
+//	destinationCIDRs: ["10.10.0.0/16"]
+func Load(paths ...string) ([]Resource, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && isManifest(path) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	var resources []Resource
+	seen := make(map[string]string)
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		rs, err := Decode(f, file)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range rs {
+			if prev, ok := seen[r.String()]; ok {
+				return nil, fmt.Errorf("%s: %s is already defined in %s", file, r, prev)
+			}
+			seen[r.String()] = file
+		}
+		resources = append(resources, rs...)
+	}
+	return resources, nil
+}
+
+// Decode reads all resources from the given YAML stream. Source is used
+// in error messages and recorded on the returned resources.
+func Decode(r io.Reader, source string) ([]Resource, error) {
+	var resources []Resource
+	dec := yaml.NewDecoder(r)
+	for i := 0; ; i++ {
+		var doc map[string]any
+		err := dec.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return resources, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: decode document %d: %w", source, i, err)
+		}
+		if len(doc) == 0 {
+			continue
+		}
+		res, err := decodeDocument(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: document %d: %w", source, i, err)
+		}
+		res.Source = source
+		resources = append(resources, res)
+	}
+}
+
+func decodeDocument(doc map[string]any) (Resource, error) {
+	kindStr, ok := doc["kind"].(string)
+	if !ok {
+		return Resource{}, fmt.Errorf("missing kind")
+	}
+	kind, err := ParseKind(kindStr)
+	if err != nil {
+		return Resource{}, err
+	}
+	delete(doc, "kind")
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return Resource{}, err
+	}
+	handler := handlers[kind]
+	obj := handler.new()
+	if err := protojson.Unmarshal(data, obj); err != nil {
+		return Resource{}, fmt.Errorf("invalid %s: %w", kind, err)
+	}
+	name := handler.name(obj)
+	if name == "" {
+		return Resource{}, fmt.Errorf("%s has no name", kind)
+	}
+	if err := handler.validate(obj); err != nil {
+		return Resource{}, fmt.Errorf("invalid %s %q: %w", kind, name, err)
+	}
+	return Resource{Kind: kind, Name: name, Object: obj}, nil
+}
+
+func isManifest(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
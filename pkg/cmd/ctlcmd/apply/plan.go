@@ -0,0 +1,256 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apply
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// Action is an action taken on a resource.
+type Action string
+
+const (
+	// ActionCreate creates a resource that does not exist.
+	ActionCreate Action = "create"
+	// ActionUpdate replaces a resource that differs from its manifest.
+	ActionUpdate Action = "update"
+	// ActionDelete deletes a resource that is not in any manifest.
+	ActionDelete Action = "delete"
+)
+
+// Change is a single planned change to the mesh state.
+type Change struct {
+	// Action is the action to take.
+	Action Action
+	// Resource is the resource being changed. For deletions it holds
+	// the current state of the resource.
+	Resource
+	// Current is the current state of the resource. It is nil for creations.
+	Current proto.Message
+	// Fields are the top-level fields that differ for updates.
+	Fields []string
+}
+
+// String returns a short string describing the change.
+func (c Change) String() string {
+	switch c.Action {
+	case ActionCreate:
+		return "+ " + c.Resource.String()
+	case ActionUpdate:
+		return fmt.Sprintf("~ %s (%s)", c.Resource.String(), strings.Join(c.Fields, ", "))
+	default:
+		return "- " + c.Resource.String()
+	}
+}
+
+// PlanOptions are options for computing a plan.
+type PlanOptions struct {
+	// Prune deletes resources of the kinds present in the manifests
+	// that are not declared in any of them. System resources are never pruned.
+	Prune bool
+}
+
+// Plan is the set of changes needed to bring the mesh state in line with
+// a set of manifests.
+type Plan struct {
+	// Changes are the changes in the order they will be applied.
+	Changes []Change
+	// Unchanged is the number of declared resources that are already up to date.
+	Unchanged int
+}
+
+// NewPlan computes the changes needed to bring the state in db in line with
+// the given resources.
+func NewPlan(ctx context.Context, db storage.MeshDB, resources []Resource, opts PlanOptions) (*Plan, error) {
+	declared := make(map[Kind]map[string]Resource)
+	for _, r := range resources {
+		if declared[r.Kind] == nil {
+			declared[r.Kind] = make(map[string]Resource)
+		}
+		declared[r.Kind][r.Name] = r
+	}
+	var plan Plan
+	var puts, deletes []Change
+	for _, kind := range Kinds {
+		desired, ok := declared[kind]
+		if !ok {
+			continue
+		}
+		handler := handlers[kind]
+		existing, err := handler.list(ctx, db)
+		if err != nil {
+			return nil, fmt.Errorf("list %s resources: %w", strings.ToLower(string(kind)), err)
+		}
+		current := make(map[string]proto.Message, len(existing))
+		for _, obj := range existing {
+			current[handler.name(obj)] = obj
+		}
+		for _, r := range desired {
+			if handler.system(r.Name) {
+				return nil, fmt.Errorf("%s: %s is a system resource and cannot be managed", r.Source, r)
+			}
+			cur, ok := current[r.Name]
+			if !ok {
+				puts = append(puts, Change{Action: ActionCreate, Resource: r})
+				continue
+			}
+			if handler.inherit != nil {
+				handler.inherit(r.Object, cur)
+			}
+			if proto.Equal(r.Object, cur) {
+				plan.Unchanged++
+				continue
+			}
+			fields, err := diffFields(cur, r.Object)
+			if err != nil {
+				return nil, err
+			}
+			puts = append(puts, Change{Action: ActionUpdate, Resource: r, Current: cur, Fields: fields})
+		}
+		if !opts.Prune {
+			continue
+		}
+		for name, cur := range current {
+			if _, ok := desired[name]; ok || handler.system(name) {
+				continue
+			}
+			deletes = append(deletes, Change{
+				Action:   ActionDelete,
+				Resource: Resource{Kind: kind, Name: name, Object: cur},
+				Current:  cur,
+			})
+		}
+	}
+	// Deletions run first in reverse dependency order, followed by creations
+	// and updates in dependency order.
+	sort.SliceStable(deletes, func(i, j int) bool {
+		if deletes[i].Kind != deletes[j].Kind {
+			return deletes[i].Kind.order() > deletes[j].Kind.order()
+		}
+		return deletes[i].Name < deletes[j].Name
+	})
+	sort.SliceStable(puts, func(i, j int) bool {
+		if puts[i].Kind != puts[j].Kind {
+			return puts[i].Kind.order() < puts[j].Kind.order()
+		}
+		return puts[i].Name < puts[j].Name
+	})
+	plan.Changes = append(deletes, puts...)
+	return &plan, nil
+}
+
+// Empty returns true if the plan has no changes.
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Print writes a human readable summary of the plan to w.
+func (p *Plan) Print(w io.Writer) {
+	var create, update, del int
+	for _, c := range p.Changes {
+		fmt.Fprintln(w, c.String())
+		switch c.Action {
+		case ActionCreate:
+			create++
+		case ActionUpdate:
+			update++
+		case ActionDelete:
+			del++
+		}
+	}
+	fmt.Fprintf(w, "Plan: %d to create, %d to update, %d to delete, %d unchanged.\n", create, update, del, p.Unchanged)
+}
+
+// Apply applies the plan to db. If any change fails, the changes applied so
+// far are reverted in reverse order and the original error is returned along
+// with any errors encountered while reverting.
+func (p *Plan) Apply(ctx context.Context, db storage.MeshDB) error {
+	for i, c := range p.Changes {
+		if err := c.apply(ctx, db); err != nil {
+			err = fmt.Errorf("%s %s: %w", c.Action, c.Resource, err)
+			for j := i - 1; j >= 0; j-- {
+				if rerr := p.Changes[j].revert(ctx, db); rerr != nil {
+					err = errors.Join(err, fmt.Errorf("revert %s %s: %w", p.Changes[j].Action, p.Changes[j].Resource, rerr))
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (c Change) apply(ctx context.Context, db storage.MeshDB) error {
+	handler := handlers[c.Kind]
+	if c.Action == ActionDelete {
+		return handler.delete(ctx, db, c.Name)
+	}
+	return handler.put(ctx, db, c.Object)
+}
+
+func (c Change) revert(ctx context.Context, db storage.MeshDB) error {
+	handler := handlers[c.Kind]
+	if c.Action == ActionCreate {
+		return handler.delete(ctx, db, c.Name)
+	}
+	return handler.put(ctx, db, c.Current)
+}
+
+// diffFields returns the top-level JSON fields that differ between a and b.
+func diffFields(a, b proto.Message) ([]string, error) {
+	am, err := toMap(a)
+	if err != nil {
+		return nil, err
+	}
+	bm, err := toMap(b)
+	if err != nil {
+		return nil, err
+	}
+	var fields []string
+	for k, v := range am {
+		if !reflect.DeepEqual(v, bm[k]) {
+			fields = append(fields, k)
+		}
+	}
+	for k := range bm {
+		if _, ok := am[k]; !ok {
+			fields = append(fields, k)
+		}
+	}
+	sort.Strings(fields)
+	return fields, nil
+}
+
+func toMap(m proto.Message) (map[string]any, error) {
+	data, err := protojson.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	var out map[string]any
+	return out, json.Unmarshal(data, &out)
+}
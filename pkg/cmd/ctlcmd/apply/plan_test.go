@@ -0,0 +1,149 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package apply
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+const testManifest = `
+kind: Group
+name: ops
+subjects:
+  - type: SUBJECT_USER
+    name: alice
+---
+kind: Role
+name: route-admin
+rules:
+  - resources: [RESOURCE_ROUTES]
+    verbs: [VERB_ALL]
+---
+kind: NetworkACL
+name: allow-ops
+priority: 10
+action: ACTION_ACCEPT
+sourceNodes: ["*"]
+destinationNodes: ["*"]
+`
+
+func TestPlanAndApply(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+
+	resources, err := Decode(strings.NewReader(testManifest), "test.yaml")
+	if err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	if len(resources) != 3 {
+		t.Fatalf("expected 3 resources, got %d", len(resources))
+	}
+
+	plan, err := NewPlan(ctx, db, resources, PlanOptions{})
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if len(plan.Changes) != 3 {
+		t.Fatalf("expected 3 changes, got %d", len(plan.Changes))
+	}
+	if err := plan.Apply(ctx, db); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+
+	// Applying again should be a no-op.
+	plan, err = NewPlan(ctx, db, resources, PlanOptions{})
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	if !plan.Empty() || plan.Unchanged != 3 {
+		t.Fatalf("expected an empty plan with 3 unchanged resources, got %+v", plan)
+	}
+
+	// Changing a field should produce an update and an extra resource
+	// should be pruned.
+	err = db.RBAC().PutGroup(ctx, types.Group{Group: &v1.Group{
+		Name:     "stale",
+		Subjects: []*v1.Subject{{Type: v1.SubjectType_SUBJECT_USER, Name: "bob"}},
+	}})
+	if err != nil {
+		t.Fatalf("put group: %v", err)
+	}
+	resources[2].Object.(*v1.NetworkACL).Priority = 20
+	plan, err = NewPlan(ctx, db, resources, PlanOptions{Prune: true})
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	var out strings.Builder
+	plan.Print(&out)
+	for _, want := range []string{"- group/stale", "~ networkacl/allow-ops (priority)", "1 to update, 1 to delete"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("expected plan output to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestApplyRollback(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+
+	resources, err := Decode(strings.NewReader(testManifest), "test.yaml")
+	if err != nil {
+		t.Fatalf("decode manifest: %v", err)
+	}
+	plan, err := NewPlan(ctx, db, resources, PlanOptions{})
+	if err != nil {
+		t.Fatalf("plan: %v", err)
+	}
+	// Make the last change invalid so it fails after the others are applied.
+	plan.Changes[len(plan.Changes)-1].Object.(*v1.NetworkACL).SourceNodes = []string{"not a valid node id"}
+	if err := plan.Apply(ctx, db); err == nil {
+		t.Fatal("expected apply to fail")
+	}
+	if _, err := db.RBAC().GetGroup(ctx, "ops"); err == nil {
+		t.Error("expected group to be reverted")
+	}
+	if _, err := db.RBAC().GetRole(ctx, "route-admin"); err == nil {
+		t.Error("expected role to be reverted")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	t.Parallel()
+	tc := map[string]string{
+		"missing kind":   "name: foo\n",
+		"unknown kind":   "kind: Widget\nname: foo\n",
+		"unknown field":  "kind: Group\nname: foo\nmembers: []\n",
+		"missing name":   "kind: Route\nnode: foo\n",
+		"invalid object": "kind: Role\nname: foo\n",
+	}
+	for name, manifest := range tc {
+		if _, err := Decode(strings.NewReader(manifest), name); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
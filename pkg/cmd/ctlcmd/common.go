@@ -17,12 +17,18 @@ limitations under the License.
 package ctlcmd
 
 import (
+	"context"
+	"io"
 	"strings"
 
 	"github.com/spf13/cobra"
+	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/emptypb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/rpcdb"
 )
 
 var (
@@ -67,6 +73,18 @@ func encodeListToStdout[T proto.Message](cmd *cobra.Command, resp []T) error {
 	return nil
 }
 
+// openStorageKV returns a key-value store backed by the storage query API
+// of the current context.
+func openStorageKV() (storage.MeshStorage, io.Closer, error) {
+	client, closer, err := cliConfig.NewStorageQueryClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	return rpcdb.OpenKV(rpcdb.QuerierFunc(func(ctx context.Context, req *v1.QueryRequest) (*v1.QueryResponse, error) {
+		return client.Query(ctx, req)
+	})), closer, nil
+}
+
 func completeNodes(maxNodes int) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
 	return func(cmd *cobra.Command, args []string, _ string) ([]string, cobra.ShellCompDirective) {
 		if maxNodes > 0 && len(args) >= maxNodes {
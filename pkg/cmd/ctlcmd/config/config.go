@@ -28,6 +28,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -41,6 +42,12 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/plugins/builtins/basicauth"
 	"github.com/webmeshproj/webmesh/pkg/plugins/builtins/idauth"
 	"github.com/webmeshproj/webmesh/pkg/plugins/builtins/ldap"
+	"github.com/webmeshproj/webmesh/pkg/services/accessreview"
+	"github.com/webmeshproj/webmesh/pkg/services/conntrack"
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/services/node"
+	"github.com/webmeshproj/webmesh/pkg/services/peerconfig"
+	"github.com/webmeshproj/webmesh/pkg/services/policy"
 )
 
 const (
@@ -238,6 +245,69 @@ func (c *Config) NewAdminClient() (v1.AdminClient, io.Closer, error) {
 	return v1.NewAdminClient(conn), conn, nil
 }
 
+// NewStorageQueryClient creates a new StorageQueryService gRPC client for the current context.
+func (c *Config) NewStorageQueryClient() (v1.StorageQueryServiceClient, io.Closer, error) {
+	conn, err := c.DialCurrent()
+	if err != nil {
+		return nil, nil, err
+	}
+	return v1.NewStorageQueryServiceClient(conn), conn, nil
+}
+
+// NewConntrackClient creates a new Conntrack gRPC client for the current context.
+func (c *Config) NewConntrackClient() (conntrack.ConntrackClient, io.Closer, error) {
+	conn, err := c.DialCurrent()
+	if err != nil {
+		return nil, nil, err
+	}
+	return conntrack.NewConntrackClient(conn), conn, nil
+}
+
+// NewPeerConfigClient creates a new peer configuration client for the current context.
+func (c *Config) NewPeerConfigClient() (peerconfig.PeerConfigClient, io.Closer, error) {
+	conn, err := c.DialCurrent()
+	if err != nil {
+		return nil, nil, err
+	}
+	return peerconfig.NewPeerConfigClient(conn), conn, nil
+}
+
+// NewPolicyClient creates a new policy client for the current context.
+func (c *Config) NewPolicyClient() (policy.PolicyClient, io.Closer, error) {
+	conn, err := c.DialCurrent()
+	if err != nil {
+		return nil, nil, err
+	}
+	return policy.NewPolicyClient(conn), conn, nil
+}
+
+// NewAccessReviewClient creates a new access review client for the current context.
+func (c *Config) NewAccessReviewClient() (accessreview.AccessReviewClient, io.Closer, error) {
+	conn, err := c.DialCurrent()
+	if err != nil {
+		return nil, nil, err
+	}
+	return accessreview.NewAccessReviewClient(conn), conn, nil
+}
+
+// NewMeshAdminClient creates a new mesh admin client for the current context.
+func (c *Config) NewMeshAdminClient() (meshadmin.MeshAdminClient, io.Closer, error) {
+	conn, err := c.DialCurrent()
+	if err != nil {
+		return nil, nil, err
+	}
+	return meshadmin.NewMeshAdminClient(conn), conn, nil
+}
+
+// NewNodeStatusClient creates a new node status client for the current context.
+func (c *Config) NewNodeStatusClient() (node.NodeStatusClient, io.Closer, error) {
+	conn, err := c.DialCurrent()
+	if err != nil {
+		return nil, nil, err
+	}
+	return node.NewNodeStatusClient(conn), conn, nil
+}
+
 // DialCurrent connects to the current context.
 func (c *Config) DialCurrent() (*grpc.ClientConn, error) {
 	cluster := c.GetCurrentCluster()
@@ -284,6 +354,9 @@ func (c *Config) GetDialOptions() ([]grpc.DialOption, error) {
 	if user.LDAPUsername != "" && user.LDAPPassword != "" {
 		opts = append(opts, ldap.NewCreds(user.LDAPUsername, user.LDAPPassword))
 	}
+	// Follow not-leader hints using the same credentials as the original
+	// connection.
+	opts = append(opts, grpc.WithChainUnaryInterceptor(LeaderHintUnaryClientInterceptor(slices.Clone(opts))))
 	if cluster.PreferLeader {
 		opts = append(opts, grpc.WithUnaryInterceptor(LeaderUnaryClientInterceptor()))
 		opts = append(opts, grpc.WithStreamInterceptor(LeaderStreamClientInterceptor()))
@@ -19,10 +19,14 @@ package config
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 )
 
 // LeaderUnaryClientInterceptor returns a gRPC unary client interceptor that
@@ -72,3 +76,20 @@ func RequestTimeoutStreamClientInterceptor(timeout time.Duration) grpc.StreamCli
 		return streamer(ctx, desc, cc, method, opts...)
 	}
 }
+
+// LeaderHintUnaryClientInterceptor returns a gRPC unary client interceptor
+// that retries requests rejected by a non-leader against the leader named in
+// the error. The leader is dialed with the given options, preferring its
+// public address.
+func LeaderHintUnaryClientInterceptor(dialOpts []grpc.DialOption) grpc.UnaryClientInterceptor {
+	return leaderproxy.NewLeaderHintUnaryClientInterceptor(func(ctx context.Context, hint leaderproxy.LeaderHint) (transport.RPCClientConn, error) {
+		addr := hint.PublicAddress
+		if addr == "" {
+			addr = hint.Address
+		}
+		if addr == "" {
+			return nil, fmt.Errorf("no address for leader %q", hint.ID)
+		}
+		return grpc.DialContext(ctx, addr, dialOpts...)
+	})
+}
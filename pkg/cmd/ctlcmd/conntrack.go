@@ -0,0 +1,114 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var (
+	conntrackKillProtocol string
+	conntrackKillSrc      string
+	conntrackKillDst      string
+	conntrackKillSrcPort  uint16
+	conntrackKillDstPort  uint16
+	conntrackKillAll      bool
+)
+
+func init() {
+	conntrackKillCmd.Flags().StringVar(&conntrackKillProtocol, "protocol", "", "Only kill flows using this protocol (tcp, udp, icmp, icmpv6)")
+	conntrackKillCmd.Flags().StringVar(&conntrackKillSrc, "src", "", "Only kill flows originating from this address or prefix")
+	conntrackKillCmd.Flags().StringVar(&conntrackKillDst, "dst", "", "Only kill flows destined to this address or prefix")
+	conntrackKillCmd.Flags().Uint16Var(&conntrackKillSrcPort, "src-port", 0, "Only kill flows originating from this port")
+	conntrackKillCmd.Flags().Uint16Var(&conntrackKillDstPort, "dst-port", 0, "Only kill flows destined to this port")
+	conntrackKillCmd.Flags().BoolVar(&conntrackKillAll, "all", false, "Kill all tracked flows")
+	conntrackCmd.AddCommand(conntrackListCmd)
+	conntrackCmd.AddCommand(conntrackKillCmd)
+	rootCmd.AddCommand(conntrackCmd)
+}
+
+var conntrackCmd = &cobra.Command{
+	Use:   "conntrack",
+	Short: "Inspect and terminate connections tracked by a node",
+	Long: `Inspect and terminate connections tracked by a node.
+
+Connection tracking is only available on nodes running a userspace WireGuard
+interface with wireguard.conntrack.enabled set. The commands operate on the
+node in the current context.`,
+}
+
+var conntrackListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the connections tracked by a node",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		client, closer, err := cliConfig.NewConntrackClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.ListFlows(cmd.Context(), &emptypb.Empty{})
+		if err != nil {
+			return err
+		}
+		return encodeToStdout(cmd, resp)
+	},
+}
+
+var conntrackKillCmd = &cobra.Command{
+	Use:   "kill",
+	Short: "Terminate connections tracked by a node",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		fields := map[string]any{}
+		if conntrackKillAll {
+			fields["all"] = true
+		}
+		if conntrackKillProtocol != "" {
+			fields["protocol"] = conntrackKillProtocol
+		}
+		if conntrackKillSrc != "" {
+			fields["src"] = conntrackKillSrc
+		}
+		if conntrackKillDst != "" {
+			fields["dst"] = conntrackKillDst
+		}
+		if conntrackKillSrcPort != 0 {
+			fields["srcPort"] = int(conntrackKillSrcPort)
+		}
+		if conntrackKillDstPort != 0 {
+			fields["dstPort"] = int(conntrackKillDstPort)
+		}
+		req, err := structpb.NewStruct(fields)
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewConntrackClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.KillFlows(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		cmd.PrintErrln("Killed", resp.GetFields()["killed"].GetNumberValue(), "flow(s)")
+		return nil
+	},
+}
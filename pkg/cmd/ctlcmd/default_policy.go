@@ -0,0 +1,86 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+func init() {
+	putCmd.AddCommand(putDefaultPolicyCmd)
+	getCmd.AddCommand(getDefaultPolicyCmd)
+}
+
+var putDefaultPolicyCmd = &cobra.Command{
+	Use:   "default-network-policy [accept|drop]",
+	Short: "Change the default network policy of the mesh",
+	Long: `Change the default network policy of the mesh.
+
+The default policy decides traffic that no other network ACL matches. It is
+enforced by an explicit catch-all network ACL at the lowest priority, named
+default-accept or default-deny, which this command swaps out for the one of the
+new policy. The leader replaces the catch-all and records the new policy in a
+single transaction, so the mesh is never left without a policy or with a
+half-applied one. Changing the policy requires permission to put the new
+catch-all network ACL and to delete the old one.
+
+The policy is recorded in the mesh state and takes precedence over the
+catch-all ACLs. With the drop policy the mesh runs in default-deny mode: only
+the system network ACLs exist after bootstrap, and a default-accept ACL put
+back by hand is ignored until the policy is changed again.`,
+	Aliases:   []string{"default-policy"},
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{storage.NetworkPolicyAccept, storage.NetworkPolicyDrop},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		_, err = client.SetDefaultNetworkPolicy(cmd.Context(), &structpb.Struct{Fields: map[string]*structpb.Value{
+			"policy": structpb.NewStringValue(args[0]),
+		}})
+		if err != nil {
+			return err
+		}
+		cmd.PrintErrln("Default network policy set to", args[0])
+		return nil
+	},
+}
+
+var getDefaultPolicyCmd = &cobra.Command{
+	Use:     "default-network-policy",
+	Short:   "Get the default network policy of the mesh",
+	Aliases: []string{"default-policy"},
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.GetDefaultNetworkPolicy(cmd.Context(), &structpb.Struct{})
+		if err != nil {
+			return err
+		}
+		cmd.Println(resp.GetFields()["policy"].GetStringValue())
+		return nil
+	},
+}
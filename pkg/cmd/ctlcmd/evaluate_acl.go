@@ -0,0 +1,81 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var (
+	evaluateACLSrcNode string
+	evaluateACLSrcCIDR string
+	evaluateACLDstNode string
+	evaluateACLDstCIDR string
+	evaluateACLAt      string
+)
+
+func init() {
+	evaluateACLCmd.Flags().StringVar(&evaluateACLSrcNode, "src-node", "", "The node the traffic originates from")
+	evaluateACLCmd.Flags().StringVar(&evaluateACLSrcCIDR, "src-cidr", "", "The address or prefix the traffic originates from")
+	evaluateACLCmd.Flags().StringVar(&evaluateACLDstNode, "dst-node", "", "The node the traffic is destined to")
+	evaluateACLCmd.Flags().StringVar(&evaluateACLDstCIDR, "dst-cidr", "", "The address or prefix the traffic is destined to")
+	evaluateACLCmd.Flags().StringVar(&evaluateACLAt, "at", "", "RFC3339 time to evaluate scheduled network ACLs at, now if unset")
+	rootCmd.AddCommand(evaluateACLCmd)
+}
+
+var evaluateACLCmd = &cobra.Command{
+	Use:   "evaluate-acl",
+	Short: "Simulate traffic against the network policy",
+	Long: `Simulate traffic against the network policy.
+
+The source and destination are each given as a node, an address or prefix, or
+both. Nodes do not need to be registered in the mesh, but an address is
+required for those that are not. Every network ACL matching the traffic is
+listed in the order it is evaluated, followed by the verdict and, when both
+nodes are registered, whether they are peered.`,
+	Aliases: []string{"simulate-acl"},
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		fields := map[string]any{}
+		for key, value := range map[string]string{
+			"srcNode": evaluateACLSrcNode,
+			"srcCIDR": evaluateACLSrcCIDR,
+			"dstNode": evaluateACLDstNode,
+			"dstCIDR": evaluateACLDstCIDR,
+			"at":      evaluateACLAt,
+		} {
+			if value != "" {
+				fields[key] = value
+			}
+		}
+		req, err := structpb.NewStruct(fields)
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewPolicyClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.EvaluateACL(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		return encodeToStdout(cmd, resp)
+	},
+}
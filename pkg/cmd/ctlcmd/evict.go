@@ -0,0 +1,124 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+var (
+	evictReason  string
+	evictPurge   bool
+	evictRelease bool
+)
+
+func init() {
+	evictCmd.Flags().StringVar(&evictReason, "reason", "", "The reason for the eviction, recorded with the quarantine")
+	evictCmd.Flags().BoolVar(&evictPurge, "purge", false, "Remove the records of a quarantined node from the mesh")
+	evictCmd.Flags().BoolVar(&evictRelease, "release", false, "Lift the quarantine on a node and restore its routes")
+	evictCmd.MarkFlagsMutuallyExclusive("purge", "release")
+	rootCmd.AddCommand(evictCmd)
+	getCmd.AddCommand(getQuarantinesCmd)
+}
+
+var evictCmd = &cobra.Command{
+	Use:   "evict [NODE_ID]",
+	Short: "Quarantine and evict a node from the mesh",
+	Long: `Quarantine and evict a node from the mesh.
+
+Eviction happens in two phases. The first invocation quarantines the node:
+network ACLs denying all traffic to and from the node are created, any routes
+it advertises are withdrawn, and its key is revoked so it can no longer join.
+The node's registration and edges are preserved for investigation.
+
+Once the investigation is complete, run the command again with --purge to
+remove the node's records from the mesh, or with --release to lift the
+quarantine and restore the node's routes.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeNodes(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		nodeID := args[0]
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		req := &structpb.Struct{Fields: map[string]*structpb.Value{
+			"id": structpb.NewStringValue(nodeID),
+		}}
+		switch {
+		case evictPurge:
+			if _, err := client.PurgeNode(ctx, req); err != nil {
+				return fmt.Errorf("purge %s: %w", nodeID, err)
+			}
+			cmd.PrintErrln("Purged quarantined node", nodeID)
+		case evictRelease:
+			if _, err := client.ReleaseQuarantine(ctx, req); err != nil {
+				return fmt.Errorf("release %s: %w", nodeID, err)
+			}
+			cmd.PrintErrln("Released node", nodeID, "from quarantine")
+		default:
+			req.Fields["reason"] = structpb.NewStringValue(evictReason)
+			resp, err := client.QuarantineNode(ctx, req)
+			if err != nil {
+				return fmt.Errorf("quarantine %s: %w", nodeID, err)
+			}
+			var rec storage.QuarantineRecord
+			if err := meshadmin.DecodeField(resp, "quarantine", &rec); err != nil {
+				return err
+			}
+			cmd.PrintErrln("Quarantined node", nodeID, "and withdrew", len(rec.Routes), "route(s)")
+		}
+		return nil
+	},
+}
+
+var getQuarantinesCmd = &cobra.Command{
+	Use:     "quarantines",
+	Short:   "Get quarantined nodes from the mesh",
+	Aliases: []string{"quarantine", "quarantined"},
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.ListQuarantines(cmd.Context(), &structpb.Struct{})
+		if err != nil {
+			return err
+		}
+		var recs []storage.QuarantineRecord
+		if err := meshadmin.DecodeField(resp, "quarantines", &recs); err != nil {
+			return err
+		}
+		out, err := json.MarshalIndent(recs, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(out))
+		return nil
+	},
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var (
+	explainDenySrcNode string
+	explainDenySrcCIDR string
+	explainDenyDstNode string
+	explainDenyDstCIDR string
+)
+
+func init() {
+	explainDenyCmd.Flags().StringVar(&explainDenySrcNode, "src-node", "", "The node the traffic originates from")
+	explainDenyCmd.Flags().StringVar(&explainDenySrcCIDR, "src-cidr", "", "The address or prefix the traffic originates from")
+	explainDenyCmd.Flags().StringVar(&explainDenyDstNode, "dst-node", "", "The node the traffic is destined to")
+	explainDenyCmd.Flags().StringVar(&explainDenyDstCIDR, "dst-cidr", "", "The address or prefix the traffic is destined to")
+	rootCmd.AddCommand(explainDenyCmd)
+}
+
+var explainDenyCmd = &cobra.Command{
+	Use:   "explain-deny",
+	Short: "Explain why the network policy allows or denies traffic",
+	Long: `Explain why the network policy allows or denies traffic.
+
+The source and destination are each given as a node, an address or prefix, or
+both. When only a node is given its private address is used. Every network ACL
+is listed in the order it is evaluated along with why it did or did not match,
+followed by the rule or default that decided the traffic.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		fields := map[string]any{}
+		for key, value := range map[string]string{
+			"srcNode": explainDenySrcNode,
+			"srcCIDR": explainDenySrcCIDR,
+			"dstNode": explainDenyDstNode,
+			"dstCIDR": explainDenyDstCIDR,
+		} {
+			if value != "" {
+				fields[key] = value
+			}
+		}
+		req, err := structpb.NewStruct(fields)
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewPolicyClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.ExplainDeny(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		return encodeToStdout(cmd, resp)
+	},
+}
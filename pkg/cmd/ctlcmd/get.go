@@ -22,6 +22,7 @@ import (
 	"github.com/spf13/cobra"
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
 var (
@@ -43,6 +44,7 @@ func init() {
 	cobra.CheckErr(getEdgesCmd.RegisterFlagCompletionFunc("from", completeNodes(1)))
 	cobra.CheckErr(getEdgesCmd.RegisterFlagCompletionFunc("to", completeNodes(1)))
 	getCmd.AddCommand(getEdgesCmd)
+	getCmd.AddCommand(getComputedPeersCmd)
 
 	rootCmd.AddCommand(getCmd)
 }
@@ -276,3 +278,37 @@ var getEdgesCmd = &cobra.Command{
 		return encodeListToStdout(cmd, resp.Items)
 	},
 }
+
+var getComputedPeersCmd = &cobra.Command{
+	Use:   "computed-peers [NODE_ID]",
+	Short: "Get the WireGuard peers computed for a node and why",
+	Long: `Get the WireGuard peers computed for a node and why.
+
+The peers are computed exactly as the given node, or the node in the current
+context when no ID is given, would receive them. Each peer is listed with the
+edge and network ACL that allowed it, and each allowed IP with the node or route
+it belongs to. Nodes that are not reachable are listed with the reason they were
+excluded, which helps debugging why one node lacks a peer entry for another.`,
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeNodes(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fields := map[string]any{}
+		if len(args) == 1 {
+			fields["nodeId"] = args[0]
+		}
+		req, err := structpb.NewStruct(fields)
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewPeerConfigClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.GetComputedPeers(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		return encodeToStdout(cmd, resp)
+	},
+}
@@ -0,0 +1,86 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var (
+	historyType  string
+	historySince time.Duration
+)
+
+func init() {
+	getMembershipHistoryCmd.Flags().StringVar(&historyType, "type", "", "Only show events of this type (join, leave, or evict)")
+	getMembershipHistoryCmd.Flags().DurationVar(&historySince, "since", 0, "Only show events newer than this duration")
+	getCmd.AddCommand(getMembershipHistoryCmd)
+}
+
+var getMembershipHistoryCmd = &cobra.Command{
+	Use:   "membership-history [NODE_ID]",
+	Short: "Get the history of nodes joining and leaving the mesh",
+	Long: `Get the history of nodes joining and leaving the mesh.
+
+Events are recorded by the leader when nodes join, leave, or are evicted
+and are retained according to the membership history limits configured
+on the storage members.`,
+	Aliases:           []string{"history"},
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeNodes(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		filter := storage.MembershipEventFilter{
+			Type: storage.MembershipEventType(historyType),
+		}
+		if historyType != "" && !filter.Type.IsValid() {
+			return fmt.Errorf("invalid event type %q", historyType)
+		}
+		if len(args) == 1 {
+			filter.NodeID = types.NodeID(args[0])
+		}
+		if historySince > 0 {
+			filter.Since = time.Now().Add(-historySince)
+		}
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.ListMembershipEvents(cmd.Context(), meshadmin.EncodeMembershipEventFilter(filter))
+		if err != nil {
+			return err
+		}
+		var events []storage.MembershipEvent
+		if err := meshadmin.DecodeField(resp, "events", &events); err != nil {
+			return err
+		}
+		out, err := json.MarshalIndent(events, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(out))
+		return nil
+	},
+}
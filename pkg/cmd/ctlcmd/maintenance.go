@@ -0,0 +1,165 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var (
+	putMaintenanceNodes    []string
+	putMaintenanceStart    string
+	putMaintenanceEnd      string
+	putMaintenanceDuration time.Duration
+	putMaintenanceReason   string
+)
+
+func init() {
+	putMaintenanceFlags := putMaintenanceCmd.Flags()
+	putMaintenanceFlags.StringArrayVar(&putMaintenanceNodes, "node", nil, "nodes covered by the window, the entire mesh if unset")
+	putMaintenanceFlags.StringVar(&putMaintenanceStart, "start", "", "RFC3339 start time of the window, now if unset")
+	putMaintenanceFlags.StringVar(&putMaintenanceEnd, "end", "", "RFC3339 end time of the window")
+	putMaintenanceFlags.DurationVar(&putMaintenanceDuration, "duration", 0, "duration of the window, used when end is unset")
+	putMaintenanceFlags.StringVar(&putMaintenanceReason, "reason", "", "reason for the window")
+	putMaintenanceCmd.MarkFlagsMutuallyExclusive("end", "duration")
+	putMaintenanceCmd.MarkFlagsOneRequired("end", "duration")
+	cobra.CheckErr(putMaintenanceCmd.RegisterFlagCompletionFunc("node", completeNodes(1)))
+
+	putCmd.AddCommand(putMaintenanceCmd)
+	getCmd.AddCommand(getMaintenanceCmd)
+	deleteCmd.AddCommand(deleteMaintenanceCmd)
+}
+
+var putMaintenanceCmd = &cobra.Command{
+	Use:   "maintenance [NAME]",
+	Short: "Put a maintenance window in the mesh",
+	Long: `Put a maintenance window in the mesh.
+
+While a window is in effect, nodes it covers are not removed for failing
+heartbeats, are not promoted to voters, and do not trigger join, leave, or
+leader change events to watch plugins.`,
+	Aliases: []string{"maintenance-window"},
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w := storage.MaintenanceWindow{
+			Name:   args[0],
+			Start:  time.Now().UTC(),
+			Reason: putMaintenanceReason,
+		}
+		for _, node := range putMaintenanceNodes {
+			w.Nodes = append(w.Nodes, types.NodeID(node))
+		}
+		if putMaintenanceStart != "" {
+			start, err := time.Parse(time.RFC3339, putMaintenanceStart)
+			if err != nil {
+				return fmt.Errorf("parse start: %w", err)
+			}
+			w.Start = start
+		}
+		if putMaintenanceEnd != "" {
+			end, err := time.Parse(time.RFC3339, putMaintenanceEnd)
+			if err != nil {
+				return fmt.Errorf("parse end: %w", err)
+			}
+			w.End = end
+		} else {
+			w.End = w.Start.Add(putMaintenanceDuration)
+		}
+		if err := w.Validate(); err != nil {
+			return err
+		}
+		req, err := meshadmin.EncodeFields(map[string]any{"window": w})
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		_, err = client.PutMaintenanceWindow(cmd.Context(), req)
+		return err
+	},
+}
+
+var getMaintenanceCmd = &cobra.Command{
+	Use:     "maintenance [NAME]",
+	Short:   "Get maintenance windows from the mesh",
+	Aliases: []string{"maintenance-window", "maintenance-windows"},
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		req := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+		if len(args) == 1 {
+			req.Fields["name"] = structpb.NewStringValue(args[0])
+		}
+		resp, err := client.GetMaintenanceWindows(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		var windows []storage.MaintenanceWindow
+		if err := meshadmin.DecodeField(resp, "windows", &windows); err != nil {
+			return err
+		}
+		var out any = windows
+		if len(args) == 1 && len(windows) == 1 {
+			out = windows[0]
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(data))
+		return nil
+	},
+}
+
+var deleteMaintenanceCmd = &cobra.Command{
+	Use:     "maintenance [NAME...]",
+	Short:   "Delete maintenance windows from the mesh",
+	Aliases: []string{"maintenance-window", "maintenance-windows"},
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		for _, name := range args {
+			_, err := client.DeleteMaintenanceWindow(cmd.Context(), &structpb.Struct{Fields: map[string]*structpb.Value{
+				"name": structpb.NewStringValue(name),
+			}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var (
+	putNamespacePeers        []string
+	putNamespaceRoleBindings []string
+	putNamespaceNodes        []string
+	putNamespaceQuota        storage.NamespaceQuota
+)
+
+func init() {
+	putNamespaceFlags := putNamespaceCmd.Flags()
+	putNamespaceFlags.StringArrayVar(&putNamespacePeers, "peer", nil, "namespaces whose nodes may communicate with this one, peering must be mutual")
+	putNamespaceFlags.StringArrayVar(&putNamespaceRoleBindings, "role-binding", nil, "role bindings whose grants are confined to this namespace")
+	putNamespaceFlags.StringArrayVar(&putNamespaceNodes, "node", nil, "nodes to assign to this namespace")
+	putNamespaceFlags.Int64Var(&putNamespaceQuota.MaxNodes, "max-nodes", 0, "maximum number of nodes in the namespace, unlimited if zero")
+	putNamespaceFlags.Int64Var(&putNamespaceQuota.MaxRoutes, "max-routes", 0, "maximum number of routes advertised by nodes in the namespace, unlimited if zero")
+	putNamespaceFlags.Int64Var(&putNamespaceQuota.MaxNetworkACLs, "max-network-acls", 0, "maximum number of network ACLs in the namespace, unlimited if zero")
+	putNamespaceFlags.Int64Var(&putNamespaceQuota.MaxStorageBytes, "max-storage-bytes", 0, "maximum size of the records of the namespace, unlimited if zero")
+	cobra.CheckErr(putNamespaceCmd.RegisterFlagCompletionFunc("node", completeNodes(0)))
+
+	putCmd.AddCommand(putNamespaceCmd)
+	getCmd.AddCommand(getNamespacesCmd)
+	deleteCmd.AddCommand(deleteNamespacesCmd)
+}
+
+var putNamespaceCmd = &cobra.Command{
+	Use:   "namespace [NAME]",
+	Short: "Put a namespace in the mesh",
+	Long: `Put a namespace in the mesh.
+
+Nodes in different namespaces can not communicate regardless of network ACLs
+unless both namespaces list each other with --peer. Putting an existing
+namespace replaces its peers, role bindings and quotas unless only --node is
+given. Nodes that have not been
+assigned a namespace are in the default namespace. Assigning nodes to the
+default namespace removes them from their current one.
+
+Role bindings given with --role-binding only grant access to resources in the
+namespace: the nodes assigned to it and any other resources whose names are
+prefixed with the name of the namespace and a dash.
+
+The --max flags set quotas on the namespace. Network ACLs count towards the
+namespace prefixing their names and routes towards the namespace of the node
+advertising them. Changes that would take a namespace over a quota are refused.`,
+	Aliases: []string{"namespaces", "ns"},
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ns := storage.Namespace{
+			Name:         args[0],
+			Peers:        putNamespacePeers,
+			RoleBindings: putNamespaceRoleBindings,
+			Quota:        putNamespaceQuota,
+		}
+		onlyAssign := true
+		cmd.Flags().Visit(func(f *pflag.Flag) {
+			if f.Name != "node" {
+				onlyAssign = false
+			}
+		})
+		nodes := make([]types.NodeID, 0, len(putNamespaceNodes))
+		for _, node := range putNamespaceNodes {
+			nodes = append(nodes, types.NodeID(node))
+		}
+		req, err := meshadmin.EncodeFields(map[string]any{
+			"namespace":  ns,
+			"nodes":      nodes,
+			"assignOnly": onlyAssign,
+		})
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		_, err = client.PutNamespace(cmd.Context(), req)
+		return err
+	},
+}
+
+var getNamespacesCmd = &cobra.Command{
+	Use:     "namespaces [NAME]",
+	Short:   "Get namespaces from the mesh",
+	Aliases: []string{"namespace", "ns"},
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		req := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+		if len(args) == 1 {
+			req.Fields["name"] = structpb.NewStringValue(args[0])
+		}
+		resp, err := client.GetNamespaces(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		var out []meshadmin.NamespaceStatus
+		if err := meshadmin.DecodeField(resp, "namespaces", &out); err != nil {
+			return err
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(data))
+		return nil
+	},
+}
+
+var deleteNamespacesCmd = &cobra.Command{
+	Use:     "namespaces [NAME...]",
+	Short:   "Delete namespaces from the mesh",
+	Aliases: []string{"namespace", "ns"},
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		for _, name := range args {
+			_, err := client.DeleteNamespace(cmd.Context(), &structpb.Struct{Fields: map[string]*structpb.Value{
+				"name": structpb.NewStringValue(name),
+			}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func init() {
+	putCmd.AddCommand(putNodeLabelsCmd)
+	getCmd.AddCommand(getNodeLabelsCmd)
+	deleteCmd.AddCommand(deleteNodeLabelsCmd)
+}
+
+var putNodeLabelsCmd = &cobra.Command{
+	Use:   "node-labels [NODE_ID] [KEY=VALUE...]",
+	Short: "Set the labels of a node",
+	Long: `Set the labels of a node.
+
+Network ACLs can select nodes by their labels with references of the form
+label:<selector> in their source and destination nodes, for example
+"label:role=db". A selector is a comma separated list of requirements that
+must all be met, each one of key=value, key!=value, key, or !key. The labels
+of the node are replaced on every invocation. Since labels select nodes into
+network ACLs, changing them requires full access to the mesh.`,
+	Aliases:           []string{"node-label", "labels"},
+	Args:              cobra.MinimumNArgs(2),
+	ValidArgsFunction: completeNodes(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		labels, err := types.ParseNodeLabels(args[1:])
+		if err != nil {
+			return err
+		}
+		req, err := meshadmin.EncodeFields(map[string]any{"id": args[0], "labels": labels})
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		_, err = client.PutNodeLabels(cmd.Context(), req)
+		return err
+	},
+}
+
+var getNodeLabelsCmd = &cobra.Command{
+	Use:               "node-labels [NODE_ID]",
+	Short:             "Get the labels of nodes",
+	Aliases:           []string{"node-label", "labels"},
+	Args:              cobra.MaximumNArgs(1),
+	ValidArgsFunction: completeNodes(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		req := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+		if len(args) == 1 {
+			req.Fields["id"] = structpb.NewStringValue(args[0])
+		}
+		resp, err := client.GetNodeLabels(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		var labels map[types.NodeID]types.NodeLabels
+		if err := meshadmin.DecodeField(resp, "labels", &labels); err != nil {
+			return err
+		}
+		var out any = labels
+		if len(args) == 1 {
+			out = labels[types.NodeID(args[0])]
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(data))
+		return nil
+	},
+}
+
+var deleteNodeLabelsCmd = &cobra.Command{
+	Use:               "node-labels [NODE_ID...]",
+	Short:             "Remove all labels from nodes",
+	Aliases:           []string{"node-label", "labels"},
+	Args:              cobra.MinimumNArgs(1),
+	ValidArgsFunction: completeNodes(-1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		for _, id := range args {
+			_, err := client.DeleteNodeLabels(cmd.Context(), &structpb.Struct{Fields: map[string]*structpb.Value{
+				"id": structpb.NewStringValue(id),
+			}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
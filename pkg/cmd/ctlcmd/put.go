@@ -21,6 +21,8 @@ import (
 
 	"github.com/spf13/cobra"
 	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
 )
 
 var (
@@ -28,10 +30,11 @@ var (
 	putRoleResources     []string
 	putRoleResourceNames []string
 
-	putRoleBindingRole   string
-	putRoleBindingNodes  []string
-	putRoleBindingUsers  []string
-	putRoleBindingGroups []string
+	putRoleBindingRole    string
+	putRoleBindingNodes   []string
+	putRoleBindingUsers   []string
+	putRoleBindingGroups  []string
+	putRoleBindingExpires string
 
 	putGroupNodes []string
 	putGroupUsers []string
@@ -74,6 +77,7 @@ func init() {
 	putRoleBindingFlags.StringArrayVar(&putRoleBindingNodes, "node", nil, "nodes to bind the role to")
 	putRoleBindingFlags.StringArrayVar(&putRoleBindingUsers, "user", nil, "users to bind the role to")
 	putRoleBindingFlags.StringArrayVar(&putRoleBindingGroups, "group", nil, "groups to bind the role to")
+	putRoleBindingFlags.StringVar(&putRoleBindingExpires, "expires", "", "when the rolebinding is removed, as a duration from now or an RFC3339 time")
 	cobra.CheckErr(putRoleBindingCmd.MarkFlagRequired("role"))
 	cobra.CheckErr(putRoleBindingCmd.RegisterFlagCompletionFunc("role", completeRoles(1)))
 
@@ -82,9 +86,9 @@ func init() {
 	putGroupFlags.StringArrayVar(&putGroupUsers, "user", nil, "users to add to the group")
 
 	putACLFlags := putNetworkACLCmd.Flags()
-	putACLFlags.Int32Var(&putNetworkACLPriority, "priority", 0, "priority of the ACL")
-	putACLFlags.StringArrayVar(&putNetworkACLSrcNodes, "src-node", nil, "source nodes to add to the ACL")
-	putACLFlags.StringArrayVar(&putNetworkACLDstNodes, "dst-node", nil, "destination nodes to add to the ACL")
+	putACLFlags.Int32Var(&putNetworkACLPriority, "priority", 0, "priority of the ACL, higher priorities are evaluated first and deny wins ties")
+	putACLFlags.StringArrayVar(&putNetworkACLSrcNodes, "src-node", nil, "source nodes to add to the ACL, groups and label selectors are referenced as group:NAME and label:SELECTOR")
+	putACLFlags.StringArrayVar(&putNetworkACLDstNodes, "dst-node", nil, "destination nodes to add to the ACL, groups and label selectors are referenced as group:NAME and label:SELECTOR")
 	putACLFlags.StringArrayVar(&putNetworkACLSrcCIDRs, "src-cidr", nil, "source CIDRs to add to the ACL")
 	putACLFlags.StringArrayVar(&putNetworkACLDstCIDRs, "dst-cidr", nil, "destination CIDRs to add to the ACL")
 	putACLFlags.BoolVar(&putNetworkACLAccept, "accept", true, "whether to accept traffic matching the ACL")
@@ -244,12 +248,20 @@ var putRoleBindingCmd = &cobra.Command{
 				return subjects
 			}(),
 		}
+		ctx := cmd.Context()
+		if putRoleBindingExpires != "" {
+			expires, err := parseExpiry(putRoleBindingExpires)
+			if err != nil {
+				return err
+			}
+			ctx = storage.WithRoleBindingExpiry(ctx, expires)
+		}
 		client, closer, err := cliConfig.NewAdminClient()
 		if err != nil {
 			return err
 		}
 		defer closer.Close()
-		_, err = client.PutRoleBinding(cmd.Context(), roleBinding)
+		_, err = client.PutRoleBinding(ctx, roleBinding)
 		if err != nil {
 			return err
 		}
@@ -0,0 +1,131 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var (
+	recoveryKeyFile string
+	recoverOutput   string
+	recoverRestore  bool
+)
+
+func init() {
+	recoverNodeCmd.Flags().StringVar(&recoveryKeyFile, "recovery-key-file", "", "Path to the organizational recovery private key")
+	recoverNodeCmd.Flags().StringVarP(&recoverOutput, "output", "o", "", "Write the issued key to this file instead of stdout")
+	recoverNodeCmd.Flags().BoolVar(&recoverRestore, "restore", false, "Restore the escrowed key instead of issuing a new one and revoking it")
+	cobra.CheckErr(recoverNodeCmd.MarkFlagRequired("recovery-key-file"))
+	rootCmd.AddCommand(recoverNodeCmd)
+}
+
+var recoverNodeCmd = &cobra.Command{
+	Use:   "recover-node [NODE_ID]",
+	Short: "Recover a lost node identity from the key escrow",
+	Long: `Recover a lost node identity from the key escrow.
+
+The escrowed key for the node is unwrapped locally with the recovery key and
+the leader removes the node's registration from the mesh. By default a new key
+is issued for the replacement hardware, escrowed, and the old and registered
+keys are revoked.
+With --restore the original key is written out instead and is not revoked.
+
+Nodes using ID authentication derive their ID from their key and should
+be recovered with --restore.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeNodes(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		nodeID := types.NodeID(args[0])
+		recoveryKey, err := crypto.DecodePrivateKeyFromFile(recoveryKeyFile)
+		if err != nil {
+			return fmt.Errorf("load recovery key: %w", err)
+		}
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.GetEscrowedKey(ctx, &structpb.Struct{Fields: map[string]*structpb.Value{
+			"id": structpb.NewStringValue(nodeID.String()),
+		}})
+		if err != nil {
+			return fmt.Errorf("get escrowed key for %s: %w", nodeID, err)
+		}
+		wrapped, err := base64.StdEncoding.DecodeString(resp.GetFields()["wrapped"].GetStringValue())
+		if err != nil {
+			return fmt.Errorf("decode escrowed key: %w", err)
+		}
+		oldKey, err := crypto.UnwrapKey(wrapped, recoveryKey)
+		if err != nil {
+			return fmt.Errorf("unwrap escrowed key: %w", err)
+		}
+		// The keys are unwrapped and wrapped locally so the recovery
+		// key never leaves this machine.
+		issued := oldKey
+		req := meshadmin.RecoverNodeRequest{NodeID: nodeID, Restore: recoverRestore}
+		if !recoverRestore {
+			issued, err = crypto.GenerateKey()
+			if err != nil {
+				return err
+			}
+			req.RevokeKeys = []crypto.PublicKey{oldKey.PublicKey()}
+			req.Escrow, err = crypto.WrapKey(issued, recoveryKey.PublicKey())
+			if err != nil {
+				return fmt.Errorf("wrap issued key: %w", err)
+			}
+		}
+		in, err := req.Encode()
+		if err != nil {
+			return err
+		}
+		resp, err = client.RecoverNode(ctx, in)
+		if err != nil {
+			return fmt.Errorf("recover node %s: %w", nodeID, err)
+		}
+		if resp.GetFields()["removed"].GetBoolValue() {
+			cmd.PrintErrln("Removed registration for", nodeID)
+		}
+		for _, id := range resp.GetFields()["revoked"].GetListValue().GetValues() {
+			cmd.PrintErrln("Revoked key", id.GetStringValue())
+		}
+		encoded, err := issued.Encode()
+		if err != nil {
+			return err
+		}
+		if recoverOutput == "" {
+			fmt.Println(encoded)
+			return nil
+		}
+		if err := os.WriteFile(recoverOutput, []byte(strings.TrimSpace(encoded)+"\n"), 0600); err != nil {
+			return fmt.Errorf("write key: %w", err)
+		}
+		cmd.PrintErrln("Wrote key for", nodeID, "to", recoverOutput)
+		return nil
+	},
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var renameGracePeriod time.Duration
+
+func init() {
+	renameNodeCmd.Flags().DurationVar(&renameGracePeriod, "grace", storage.DefaultRenameGracePeriod, "How long the old ID keeps resolving to the renamed node")
+	rootCmd.AddCommand(renameNodeCmd)
+	getCmd.AddCommand(getNodeAliasesCmd)
+}
+
+var renameNodeCmd = &cobra.Command{
+	Use:   "rename-node [OLD_ID] [NEW_ID]",
+	Short: "Change the ID of a node in the mesh",
+	Long: `Change the ID of a node in the mesh.
+
+The node's edges, routes, network ACLs, role bindings, groups, attachments,
+escrowed key and namespace membership are moved to the new ID in a single
+transaction. The node keeps
+its addresses. The old ID resolves to the new one for the duration of --grace
+so that peers that have not yet seen the rename can still reach the node.
+
+The renamed node is refused when it tries to rejoin with its old ID and must be
+restarted with the new one. Members of the storage consensus can not be renamed,
+remove them from the consensus first.`,
+	Args:              cobra.ExactArgs(2),
+	ValidArgsFunction: completeNodes(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from, to := types.NodeID(args[0]), types.NodeID(args[1])
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		_, err = client.RenameNode(cmd.Context(), &structpb.Struct{Fields: map[string]*structpb.Value{
+			"id":    structpb.NewStringValue(from.String()),
+			"newId": structpb.NewStringValue(to.String()),
+			"grace": structpb.NewStringValue(renameGracePeriod.String()),
+		}})
+		if err != nil {
+			return fmt.Errorf("rename %s: %w", from, err)
+		}
+		cmd.PrintErrln("Renamed node", from, "to", to)
+		return nil
+	},
+}
+
+var getNodeAliasesCmd = &cobra.Command{
+	Use:     "node-aliases",
+	Short:   "Get the aliases left behind by renamed nodes",
+	Aliases: []string{"node-alias", "aliases"},
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		kv, closer, err := openStorageKV()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		aliases, err := storage.ListNodeAliases(cmd.Context(), kv)
+		if err != nil {
+			return err
+		}
+		out, err := json.MarshalIndent(aliases, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(out))
+		return nil
+	},
+}
@@ -0,0 +1,124 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func init() {
+	putCmd.AddCommand(putRouteMetricCmd)
+	getCmd.AddCommand(getRouteMetricsCmd)
+	deleteCmd.AddCommand(deleteRouteMetricsCmd)
+}
+
+var putRouteMetricCmd = &cobra.Command{
+	Use:   "route-metric [ROUTE_NAME] [METRIC]",
+	Short: "Set the administrative metric of a route",
+	Long: `Set the administrative metric of a route.
+
+When multiple nodes advertise routes to overlapping prefixes, peers send
+traffic for the prefix to the node whose route has the lowest metric. Routes
+with the same metric are preferred by how close their node is in the mesh.
+Routes without a metric have a metric of zero. Setting or resetting a metric
+requires permission to put the route.`,
+	Aliases: []string{"route-metrics"},
+	Args:    cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		metric, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return fmt.Errorf("parse metric: %w", err)
+		}
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		_, err = client.PutRouteMetric(cmd.Context(), &structpb.Struct{Fields: map[string]*structpb.Value{
+			"name":   structpb.NewStringValue(args[0]),
+			"metric": structpb.NewNumberValue(float64(metric)),
+		}})
+		return err
+	},
+}
+
+var getRouteMetricsCmd = &cobra.Command{
+	Use:     "route-metrics [ROUTE_NAME]",
+	Short:   "Get the administrative metrics of routes",
+	Aliases: []string{"route-metric"},
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		req := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+		if len(args) == 1 {
+			req.Fields["name"] = structpb.NewStringValue(args[0])
+		}
+		resp, err := client.GetRouteMetrics(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		var metrics types.RouteMetrics
+		if err := meshadmin.DecodeField(resp, "metrics", &metrics); err != nil {
+			return err
+		}
+		var out any = metrics
+		if len(args) == 1 {
+			out = metrics[args[0]]
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(data))
+		return nil
+	},
+}
+
+var deleteRouteMetricsCmd = &cobra.Command{
+	Use:     "route-metrics [ROUTE_NAME...]",
+	Short:   "Reset the administrative metrics of routes to zero",
+	Aliases: []string{"route-metric"},
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		for _, name := range args {
+			_, err := client.DeleteRouteMetric(cmd.Context(), &structpb.Struct{Fields: map[string]*structpb.Value{
+				"name": structpb.NewStringValue(name),
+			}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var splitBrainAction string
+
+func init() {
+	resolveSplitBrainCmd.Flags().StringVar(&splitBrainAction, "action", "", "How to resolve the alarm: rejoin to admit the node and discard its diverged state, or dismiss to clear the alarm without admitting it")
+	cobra.CheckErr(resolveSplitBrainCmd.MarkFlagRequired("action"))
+	cobra.CheckErr(resolveSplitBrainCmd.RegisterFlagCompletionFunc("action", func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+		return []string{storage.SplitBrainRejoin, storage.SplitBrainDismiss}, cobra.ShellCompDirectiveNoFileComp
+	}))
+	rootCmd.AddCommand(resolveSplitBrainCmd)
+	getCmd.AddCommand(getSplitBrainAlarmsCmd)
+}
+
+var resolveSplitBrainCmd = &cobra.Command{
+	Use:   "resolve-split-brain [NODE_ID]",
+	Short: "Resolve a split-brain alarm raised for a node",
+	Long: `Resolve a split-brain alarm raised for a node.
+
+A split-brain alarm is raised when a node tries to join with local state that
+diverged from the mesh, either because it belongs to a partition that was
+bootstrapped separately or because it holds log entries from a term the mesh
+never reached. The mesh refuses to merge such nodes automatically.
+
+Inspect the alarm with "get split-brain-alarms" first. Resolving with
+--action rejoin admits the node on its next join attempt, and any state it
+accumulated while partitioned is discarded in favor of this mesh. Resolving
+with --action dismiss clears the alarm without admitting the node; it is
+raised again if the node keeps trying to join.`,
+	Args:              cobra.ExactArgs(1),
+	ValidArgsFunction: completeNodes(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		nodeID := types.NodeID(args[0])
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		_, err = client.ResolveSplitBrain(cmd.Context(), &structpb.Struct{Fields: map[string]*structpb.Value{
+			"id":     structpb.NewStringValue(nodeID.String()),
+			"action": structpb.NewStringValue(splitBrainAction),
+		}})
+		if err != nil {
+			return fmt.Errorf("resolve split-brain alarm for %s: %w", nodeID, err)
+		}
+		switch splitBrainAction {
+		case storage.SplitBrainRejoin:
+			cmd.PrintErrln("Node", nodeID, "will be admitted on its next join attempt")
+		case storage.SplitBrainDismiss:
+			cmd.PrintErrln("Dismissed split-brain alarm for", nodeID)
+		}
+		return nil
+	},
+}
+
+var getSplitBrainAlarmsCmd = &cobra.Command{
+	Use:     "split-brain-alarms",
+	Short:   "Get split-brain alarms raised in the mesh",
+	Aliases: []string{"split-brain", "alarms"},
+	Args:    cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.ListSplitBrainAlarms(cmd.Context(), &structpb.Struct{})
+		if err != nil {
+			return err
+		}
+		var alarms []storage.SplitBrainAlarm
+		if err := meshadmin.DecodeField(resp, "alarms", &alarms); err != nil {
+			return err
+		}
+		out, err := json.MarshalIndent(alarms, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(out))
+		return nil
+	},
+}
@@ -19,9 +19,13 @@ package ctlcmd
 import (
 	"github.com/spf13/cobra"
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
+var statusDetailed bool
+
 func init() {
+	statusCmd.Flags().BoolVar(&statusDetailed, "detailed", false, "Retrieve a structured document with raft, storage, network, and plugin health")
 	rootCmd.AddCommand(statusCmd)
 }
 
@@ -31,6 +35,9 @@ var statusCmd = &cobra.Command{
 	Args:              cobra.MaximumNArgs(1),
 	ValidArgsFunction: completeNodes(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
+		if statusDetailed {
+			return getDetailedStatus(cmd, args)
+		}
 		client, closer, err := cliConfig.NewNodeClient()
 		if err != nil {
 			return err
@@ -47,3 +54,24 @@ var statusCmd = &cobra.Command{
 		return encodeToStdout(cmd, status)
 	},
 }
+
+func getDetailedStatus(cmd *cobra.Command, args []string) error {
+	client, closer, err := cliConfig.NewNodeStatusClient()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	fields := map[string]any{}
+	if len(args) > 0 {
+		fields["id"] = args[0]
+	}
+	req, err := structpb.NewStruct(fields)
+	if err != nil {
+		return err
+	}
+	status, err := client.GetStatus(cmd.Context(), req)
+	if err != nil {
+		return err
+	}
+	return encodeToStdout(cmd, status)
+}
@@ -0,0 +1,164 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var (
+	putTrafficPolicyACL       string
+	putTrafficPolicyNodes     []string
+	putTrafficPolicyCIDRs     []string
+	putTrafficPolicyRate      string
+	putTrafficPolicyCeil      string
+	putTrafficPolicyEnforcers []string
+)
+
+func init() {
+	putTrafficPolicyFlags := putTrafficPolicyCmd.Flags()
+	putTrafficPolicyFlags.StringVar(&putTrafficPolicyACL, "acl", "", "network ACL whose source nodes and prefixes are limited while it is in effect")
+	putTrafficPolicyFlags.StringArrayVar(&putTrafficPolicyNodes, "node", nil, "ID of a peer to limit, or * for every peer")
+	putTrafficPolicyFlags.StringArrayVar(&putTrafficPolicyCIDRs, "cidr", nil, "prefix to limit")
+	putTrafficPolicyFlags.StringVar(&putTrafficPolicyRate, "rate", "", "guaranteed rate, e.g. 500kbit or 10mbit")
+	putTrafficPolicyFlags.StringVar(&putTrafficPolicyCeil, "ceil", "", "rate traffic can borrow up to when the interface is not saturated, defaults to the rate")
+	putTrafficPolicyFlags.StringArrayVar(&putTrafficPolicyEnforcers, "enforcer", nil, "ID of a node enforcing the policy, every node if unset")
+	_ = putTrafficPolicyCmd.MarkFlagRequired("rate")
+
+	putCmd.AddCommand(putTrafficPolicyCmd)
+	getCmd.AddCommand(getTrafficPoliciesCmd)
+	deleteCmd.AddCommand(deleteTrafficPoliciesCmd)
+}
+
+var putTrafficPolicyCmd = &cobra.Command{
+	Use:   "traffic-policy [NAME]",
+	Short: "Create or replace a traffic policy",
+	Long: `Create or replace a traffic policy.
+
+A traffic policy limits the bandwidth of traffic between the nodes enforcing it
+and the peers and prefixes it selects. Every selected peer address and prefix
+is limited on its own, so gateways can cap noisy tenants without them starving
+each other. Policies attached to a network ACL select its source nodes and
+prefixes while the ACL is in effect. When policies select the same prefix, the
+lowest rate applies.
+
+Policies are enforced by nodes running with --wireguard.traffic-policies with
+tc on the WireGuard interface. Egress traffic is shaped and ingress traffic
+above the ceiling is dropped. Changing policies requires full access to the
+mesh.`,
+	Aliases: []string{"traffic-policies"},
+	Args:    cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		policy := types.TrafficPolicy{
+			NetworkACL: putTrafficPolicyACL,
+			Nodes:      putTrafficPolicyNodes,
+			CIDRs:      putTrafficPolicyCIDRs,
+			Enforcers:  putTrafficPolicyEnforcers,
+		}
+		var err error
+		policy.Rate, err = types.ParseRate(putTrafficPolicyRate)
+		if err != nil {
+			return fmt.Errorf("parse rate: %w", err)
+		}
+		if putTrafficPolicyCeil != "" {
+			policy.Ceil, err = types.ParseRate(putTrafficPolicyCeil)
+			if err != nil {
+				return fmt.Errorf("parse ceil: %w", err)
+			}
+		}
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+		req, err := meshadmin.EncodeFields(map[string]any{"name": args[0], "policy": policy})
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		_, err = client.PutTrafficPolicy(cmd.Context(), req)
+		return err
+	},
+}
+
+var getTrafficPoliciesCmd = &cobra.Command{
+	Use:     "traffic-policies [NAME]",
+	Short:   "Get traffic policies",
+	Aliases: []string{"traffic-policy"},
+	Args:    cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		req := &structpb.Struct{Fields: map[string]*structpb.Value{}}
+		if len(args) == 1 {
+			req.Fields["name"] = structpb.NewStringValue(args[0])
+		}
+		resp, err := client.GetTrafficPolicies(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		var policies types.TrafficPolicies
+		if err := meshadmin.DecodeField(resp, "policies", &policies); err != nil {
+			return err
+		}
+		var out any = policies
+		if len(args) == 1 {
+			out = policies[args[0]]
+		}
+		data, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return err
+		}
+		cmd.Println(string(data))
+		return nil
+	},
+}
+
+var deleteTrafficPoliciesCmd = &cobra.Command{
+	Use:     "traffic-policies [NAME...]",
+	Short:   "Delete traffic policies",
+	Aliases: []string{"traffic-policy"},
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		client, closer, err := cliConfig.NewMeshAdminClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		for _, name := range args {
+			_, err := client.DeleteTrafficPolicy(cmd.Context(), &structpb.Struct{Fields: map[string]*structpb.Value{
+				"name": structpb.NewStringValue(name),
+			}})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+}
@@ -18,24 +18,33 @@ package ctlcmd
 
 import (
 	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/webmeshproj/webmesh/pkg/version"
 )
 
 var (
-	versionJSON bool
+	versionJSON   bool
+	versionServer bool
+	versionNode   string
 )
 
 func init() {
 	versionCmd.Flags().BoolVar(&versionJSON, "json", false, "Print version information in JSON format")
+	versionCmd.Flags().BoolVar(&versionServer, "server", false, "Print the version of the node in the current context instead of the CLI")
+	versionCmd.Flags().StringVar(&versionNode, "node", "", "Print the version of the node with the given ID, implies --server")
+	cobra.CheckErr(versionCmd.RegisterFlagCompletionFunc("node", completeNodes(1)))
 	rootCmd.AddCommand(versionCmd)
 }
 
 var versionCmd = &cobra.Command{
 	Use:   "version",
-	Short: "Print the version number of the CLI",
+	Short: "Print the version number of the CLI or a node",
 	Args:  cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, _ []string) error {
+		if versionServer || versionNode != "" {
+			return getServerVersion(cmd)
+		}
 		version := version.GetBuildInfo()
 		if versionJSON {
 			cmd.Println(version.PrettyJSON("webmesh-cli"))
@@ -45,6 +54,30 @@ var versionCmd = &cobra.Command{
 		cmd.Println("    Version:    ", version.Version)
 		cmd.Println("    Git Commit: ", version.GitCommit)
 		cmd.Println("    Build Date: ", version.BuildDate)
+		cmd.Println("    Go Version: ", version.GoVersion)
+		cmd.Println("    Platform:   ", version.Platform)
+		cmd.Println("    Build Tags: ", version.Tags())
 		return nil
 	},
 }
+
+func getServerVersion(cmd *cobra.Command) error {
+	client, closer, err := cliConfig.NewNodeStatusClient()
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	fields := map[string]any{}
+	if versionNode != "" {
+		fields["id"] = versionNode
+	}
+	req, err := structpb.NewStruct(fields)
+	if err != nil {
+		return err
+	}
+	resp, err := client.GetVersion(cmd.Context(), req)
+	if err != nil {
+		return err
+	}
+	return encodeToStdout(cmd, resp)
+}
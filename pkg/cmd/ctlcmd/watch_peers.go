@@ -0,0 +1,64 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"errors"
+	"io"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/emptypb"
+)
+
+func init() {
+	rootCmd.AddCommand(watchPeersCmd)
+}
+
+var watchPeersCmd = &cobra.Command{
+	Use:   "watch-peers",
+	Short: "Stream changes to the WireGuard peers computed for a node",
+	Long: `Stream changes to the WireGuard peers computed for a node.
+
+The first message is a snapshot of the desired peers. Every following message
+holds the peers added, updated, or removed since the previous one. Nodes running
+with wireguard.external-peers leave applying these changes to the consumer of
+the stream. The command operates on the node in the current context.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, _ []string) error {
+		client, closer, err := cliConfig.NewPeerConfigClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		stream, err := client.WatchPeers(cmd.Context(), &emptypb.Empty{})
+		if err != nil {
+			return err
+		}
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+			if err := encodeToStdout(cmd, resp); err != nil {
+				return err
+			}
+		}
+	},
+}
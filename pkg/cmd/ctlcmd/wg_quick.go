@@ -0,0 +1,96 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ctlcmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+)
+
+var (
+	wgQuickKeepalive      string
+	wgQuickMTU            int
+	wgQuickPrivateKeyFile string
+	wgQuickOutput         string
+)
+
+func init() {
+	wgQuickCmd.Flags().StringVar(&wgQuickKeepalive, "persistent-keepalive", "", "Persistent keepalive interval to set on every peer (e.g. 25s)")
+	wgQuickCmd.Flags().IntVar(&wgQuickMTU, "mtu", 0, "MTU to set on the interface")
+	wgQuickCmd.Flags().StringVar(&wgQuickPrivateKeyFile, "private-key-file", "", "Webmesh key file to fill in the private key from")
+	wgQuickCmd.Flags().StringVarP(&wgQuickOutput, "output", "o", "", "File to write the configuration to (default: stdout)")
+	rootCmd.AddCommand(wgQuickCmd)
+}
+
+var wgQuickCmd = &cobra.Command{
+	Use:   "wg-quick [NODE_ID]",
+	Short: "Render the WireGuard configuration of a node for wg-quick",
+	Long: `Render the WireGuard configuration of a node for wg-quick.
+
+The configuration is computed from the current state of the mesh for the given
+node, or the node in the current context when no ID is given. It contains the
+interface addresses, peers, allowed IPs, and MeshDNS servers, so devices that
+cannot run a node can still join the mesh with plain wg-quick. Private keys are
+never stored in the mesh. The rendered file holds a placeholder unless a key is
+provided with --private-key-file, in which case it is filled in locally.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fields := map[string]any{}
+		if len(args) == 1 {
+			fields["nodeId"] = args[0]
+		}
+		if wgQuickKeepalive != "" {
+			fields["persistentKeepalive"] = wgQuickKeepalive
+		}
+		if wgQuickMTU > 0 {
+			fields["mtu"] = wgQuickMTU
+		}
+		req, err := structpb.NewStruct(fields)
+		if err != nil {
+			return err
+		}
+		client, closer, err := cliConfig.NewPeerConfigClient()
+		if err != nil {
+			return err
+		}
+		defer closer.Close()
+		resp, err := client.RenderWGQuick(cmd.Context(), req)
+		if err != nil {
+			return err
+		}
+		out := resp.GetValue()
+		if wgQuickPrivateKeyFile != "" {
+			key, err := crypto.DecodePrivateKeyFromFile(wgQuickPrivateKeyFile)
+			if err != nil {
+				return fmt.Errorf("read private key: %w", err)
+			}
+			out = strings.Replace(out, meshnet.WGQuickPrivateKeyPlaceholder, key.WireGuardKey().String(), 1)
+		}
+		if wgQuickOutput == "" {
+			_, err = fmt.Fprint(cmd.OutOrStdout(), out)
+			return err
+		}
+		return os.WriteFile(wgQuickOutput, []byte(out), 0600)
+	},
+}
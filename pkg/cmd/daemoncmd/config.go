@@ -20,12 +20,14 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
 	"github.com/spf13/pflag"
 
+	"github.com/webmeshproj/webmesh/pkg/config"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/logging"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
@@ -79,6 +81,9 @@ type Config struct {
 	LogLevel string `koanf:"log-level"`
 	// LogFormat is the log format for the daemon.
 	LogFormat string `koanf:"log-format"`
+	// StateRoot is a writable directory that the socket, key, and persistence
+	// paths are derived from. Use when the root filesystem is read-only.
+	StateRoot string `koanf:"state-root,omitempty"`
 
 	// Logger is a pre-configured logger.
 	Logger *slog.Logger `koanf:"-"`
@@ -146,6 +151,7 @@ func (conf *Config) BindFlags(prefix string, flagset *pflag.FlagSet) *Config {
 	flagset.Uint16Var(&conf.WireGuardStartPort, prefix+"wireguard-start-port", conf.WireGuardStartPort, "Starting port for WireGuard connections")
 	flagset.StringVar(&conf.LogLevel, prefix+"log-level", conf.LogLevel, "Log level for the application daemon")
 	flagset.StringVar(&conf.LogFormat, prefix+"log-format", conf.LogFormat, "Log format for the application daemon")
+	flagset.StringVar(&conf.StateRoot, prefix+"state-root", conf.StateRoot, "Writable directory to derive the socket, key, and persistence paths from")
 	conf.CORS.BindFlags(prefix+"cors.", flagset)
 	conf.UI.BindFlags(prefix+"ui.", flagset)
 	conf.Persistence.BindFlags(prefix+"persistence.", flagset)
@@ -188,6 +194,27 @@ func (conf *Config) Validate() error {
 	return nil
 }
 
+// Preflight derives the socket, key, and persistence paths from the state root
+// and verifies they are writable. It is a no-op if no state root is configured.
+func (conf *Config) Preflight() error {
+	if conf.StateRoot == "" {
+		return nil
+	}
+	paths := []string{}
+	if runtime.GOOS != "windows" {
+		if conf.Bind == DefaultDaemonSocket() {
+			conf.Bind = filepath.Join(conf.StateRoot, config.StateRootRunDir, "webmesh.sock")
+		}
+		if isLocalSocket(conf.Bind) {
+			paths = append(paths, strings.TrimPrefix(conf.Bind, "unix://"))
+		}
+	}
+	conf.KeyFile = config.ResolveStatePath(conf.StateRoot, conf.KeyFile, "", "")
+	conf.Persistence.Path = config.ResolveStatePath(conf.StateRoot, conf.Persistence.Path, "", "")
+	paths = append(paths, conf.KeyFile, conf.Persistence.Path)
+	return config.PreflightStateRoot(conf.StateRoot, paths...)
+}
+
 // LoadKey loads the wireguard key from the configuration.
 func (conf *Config) LoadKey(log *slog.Logger) (crypto.PrivateKey, error) {
 	if conf.KeyFile == "" {
@@ -0,0 +1,136 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodecmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/netip"
+	"os"
+
+	"github.com/webmeshproj/webmesh/pkg/config"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/privsep"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+)
+
+// DryRunReport is the output of a dry run. It describes everything the
+// node would have configured on the host had it been started normally.
+type DryRunReport struct {
+	// NodeID is the ID the node joined the mesh with.
+	NodeID string `json:"nodeID"`
+	// NetworkV4 is the IPv4 network of the mesh.
+	NetworkV4 netip.Prefix `json:"networkV4,omitempty"`
+	// NetworkV6 is the IPv6 network of the mesh.
+	NetworkV6 netip.Prefix `json:"networkV6,omitempty"`
+	// AddressV4 is the IPv4 address that would be assigned to the node.
+	AddressV4 netip.Prefix `json:"addressV4,omitempty"`
+	// AddressV6 is the IPv6 address that would be assigned to the node.
+	AddressV6 netip.Prefix `json:"addressV6,omitempty"`
+	// System are the changes that would have been made to the host.
+	System privsep.Plan `json:"system"`
+}
+
+// applyDryRun adjusts the configuration so that starting the node leaves
+// no state behind. Storage is kept in memory, a throwaway WireGuard key is
+// used, and the node never asks to become a voter.
+func applyDryRun(conf *config.Config) error {
+	if len(conf.Bridge.Meshes) > 0 {
+		return errors.New("dry-run is not supported for bridged connections")
+	}
+	conf.Storage.InMemory = true
+	conf.Storage.Credentials.Enabled = false
+	conf.WireGuard.KeyFile = ""
+	conf.Mesh.KnownPeersFile = ""
+	conf.Mesh.RequestVote = false
+	conf.PrivSep.Enabled = false
+	return nil
+}
+
+// runDryRun connects to the mesh with all system changes recorded instead
+// of applied, writes a report of them to out, and leaves the mesh again.
+func runDryRun(ctx context.Context, conf *config.Config, out io.Writer) (err error) {
+	log := context.LoggerFrom(ctx)
+	recorder := privsep.NewRecorder()
+	conf.SetSystemOps(recorder)
+	meshConfig, err := conf.NewMeshConfig(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create mesh config: %w", err)
+	}
+	node := meshnode.NewWithLogger(log, meshConfig)
+	storageProvider, err := conf.NewStorageProvider(ctx, node, conf.Bootstrap.Force)
+	if err != nil {
+		return fmt.Errorf("failed to create storage provider: %w", err)
+	}
+	connectOpts, err := conf.NewConnectOptions(ctx, node, storageProvider, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create connect options: %w", err)
+	}
+	log.Info("Starting dry run, no changes will be made to the system")
+	err = storageProvider.Start(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to start storage: %w", err)
+	}
+	err = node.Connect(ctx, connectOpts)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to open mesh connection: %w", err), storageProvider.Close())
+	}
+	defer func() {
+		log.Info("Leaving mesh after dry run")
+		if closeErr := node.Close(context.WithLogger(context.Background(), log)); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+	}()
+	select {
+	case <-node.Ready():
+	case <-ctx.Done():
+		return fmt.Errorf("failed to start webmesh node: %w", ctx.Err())
+	}
+	// Make sure the peer list reflects the current state of storage
+	// before taking the plan.
+	if err := node.Network().Peers().Sync(ctx); err != nil {
+		log.Warn("Failed to sync peers", slog.String("error", err.Error()))
+	}
+	report := DryRunReport{
+		NodeID:    node.ID().String(),
+		NetworkV4: node.Network().NetworkV4(),
+		NetworkV6: node.Network().NetworkV6(),
+		System:    recorder.Plan(),
+	}
+	if wg := node.Network().WireGuard(); wg != nil {
+		report.AddressV4 = wg.AddressV4()
+		report.AddressV6 = wg.AddressV6()
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+// openDryRunOutput returns the writer for the dry-run report.
+func openDryRunOutput(path string) (io.WriteCloser, error) {
+	if path == "" || path == "-" {
+		return nopCloser{os.Stdout}, nil
+	}
+	return os.Create(path)
+}
+
+type nopCloser struct{ io.Writer }
+
+func (nopCloser) Close() error { return nil }
@@ -47,11 +47,18 @@ var (
 	printConfig     = flagset.Bool("print-config", false, "Print the configuration and exit")
 	startTimeout    = flagset.Duration("start-timeout", 0, "Timeout for starting the node (default: no timeout)")
 	shutdownTimeout = flagset.Duration("shutdown-timeout", 0, "Timeout for shutting down the node (default: no timeout)")
+	dryRun          = flagset.Bool("dry-run", false, "Join the mesh and print the system changes that would be made, then exit")
+	dryRunOutput    = flagset.String("dry-run-output", "", "File to write the dry-run report to (default: stdout)")
+	privsepHelper   = flagset.Bool(privsepHelperFlag, false, "Run as the privilege separation helper")
 
 	conf       = config.NewDefaultConfig("").BindFlags("", flagset)
 	daemonconf = daemoncmd.NewDefaultConfig().BindFlags("daemon.", flagset)
 )
 
+func init() {
+	_ = flagset.MarkHidden(privsepHelperFlag)
+}
+
 func Execute() error {
 	// Parse flags and read in configurations
 	err := flagset.Parse(os.Args[1:])
@@ -77,6 +84,9 @@ func Execute() error {
 		fmt.Println("    Version:    ", version.Version)
 		fmt.Println("    Git Commit: ", version.GitCommit)
 		fmt.Println("    Build Date: ", version.BuildDate)
+		fmt.Println("    Go Version: ", version.GoVersion)
+		fmt.Println("    Platform:   ", version.Platform)
+		fmt.Println("    Build Tags: ", version.Tags())
 		return nil
 	}
 	// Load the configuration
@@ -105,6 +115,12 @@ func Execute() error {
 		if err := daemonconf.Validate(); err != nil {
 			return err
 		}
+		if daemonconf.StateRoot == "" {
+			daemonconf.StateRoot = conf.Global.StateRoot
+		}
+		if err := daemonconf.Preflight(); err != nil {
+			return err
+		}
 		return daemoncmd.Run(context.Background(), *daemonconf)
 	}
 	// Apply globals
@@ -112,6 +128,14 @@ func Execute() error {
 	if err != nil {
 		return err
 	}
+	if *privsepHelper {
+		return runPrivSepHelper(ctx, conf.PrivSep)
+	}
+	if *dryRun {
+		if err := applyDryRun(conf); err != nil {
+			return err
+		}
+	}
 	// Validate the configuration if we are not running in daemon mode.
 	err = conf.Validate()
 	if err != nil {
@@ -123,6 +147,33 @@ func Execute() error {
 		}
 		return err
 	}
+	// Run the preflight checks and exit
+	if len(os.Args) > 1 && os.Args[1] == "preflight" {
+		report := conf.RunPreflight(ctx)
+		_, _ = report.WriteTo(os.Stdout)
+		if report.Err() != nil {
+			return errors.New("preflight checks failed")
+		}
+		return nil
+	}
+	if *dryRun || len(conf.Bridge.Meshes) > 0 {
+		// Make sure all writable state is confined to the state root.
+		err = conf.Preflight()
+		if err != nil {
+			return err
+		}
+	} else {
+		// Verify the system can run the node, reporting every problem at once.
+		report := conf.RunPreflight(ctx)
+		for _, check := range report {
+			if !check.Passed() && check.Warning {
+				log.Warn("Preflight check failed", slog.String("check", check.Name), slog.String("error", check.Error), slog.String("hint", check.Hint))
+			}
+		}
+		if err := report.Err(); err != nil {
+			return err
+		}
+	}
 
 	// Time to get going
 	log.Info("Starting webmesh node",
@@ -137,7 +188,29 @@ func Execute() error {
 		ctx, cancel = context.WithTimeout(ctx, *startTimeout)
 		defer cancel()
 	}
+	if *dryRun {
+		out, err := openDryRunOutput(*dryRunOutput)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+		return runDryRun(ctx, conf, out)
+	}
+	if conf.PrivSep.Enabled {
+		client, helper, err := startPrivSepHelper(ctx, conf.PrivSep)
+		if err != nil {
+			return err
+		}
+		defer helper.Close()
+		defer client.Close()
+		conf.SetSystemOps(client)
+	}
 	if len(conf.Bridge.Meshes) > 0 {
+		// Bridged connections don't return until shutdown, so drop
+		// privileges before starting them.
+		if err := dropPrivileges(conf.PrivSep); err != nil {
+			return err
+		}
 		// Start a bridged connection
 		return bridgecmd.RunBridgeConnection(ctx, conf.Bridge)
 	}
@@ -152,6 +225,9 @@ func Execute() error {
 	if err != nil {
 		return err
 	}
+	if err := dropPrivileges(conf.PrivSep); err != nil {
+		return errors.Join(err, node.Stop(context.Background()))
+	}
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
 	select {
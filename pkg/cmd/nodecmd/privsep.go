@@ -0,0 +1,124 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nodecmd
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/config"
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/privsep"
+)
+
+// privsepHelperFlag is passed to the re-executed binary to run it as the
+// privilege separation helper.
+const privsepHelperFlag = "privsep-helper"
+
+// runPrivSepHelper runs the current process as the privilege separation
+// helper. It exits when the node process closes our stdin, which also
+// happens when it dies, and reverts any remaining changes.
+func runPrivSepHelper(ctx context.Context, opts config.PrivSepOptions) error {
+	log := context.LoggerFrom(ctx)
+	ln, err := privsep.Listen(opts.Socket, opts.User, opts.Group)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(opts.Socket)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		_, _ = io.Copy(io.Discard, os.Stdin)
+		log.Info("Node process exited, shutting down privsep helper")
+		cancel()
+	}()
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		cancel()
+	}()
+	helper := privsep.NewHelper(ctx, privsep.Local)
+	defer func() {
+		if err := helper.Close(); err != nil {
+			log.Error("Failed to revert system changes", slog.String("error", err.Error()))
+		}
+	}()
+	log.Info("Privsep helper listening", slog.String("socket", opts.Socket))
+	return helper.Serve(ctx, ln)
+}
+
+// startPrivSepHelper re-executes the current binary as the privilege
+// separation helper and connects to it. Closing the returned stdin of the
+// helper tells it to revert its changes and exit.
+func startPrivSepHelper(ctx context.Context, opts config.PrivSepOptions) (*privsep.Client, io.Closer, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, nil, fmt.Errorf("get executable: %w", err)
+	}
+	cmd := exec.Command(exe, append(os.Args[1:], "--"+privsepHelperFlag)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// The pipe is never written to. The helper exits once it is closed.
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("create helper stdin: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, nil, fmt.Errorf("start privsep helper: %w", err)
+	}
+	go func() {
+		err := cmd.Wait()
+		context.LoggerFrom(ctx).Info("Privsep helper exited", slog.Any("error", err))
+	}()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+	for {
+		client, err := privsep.Dial(ctx, opts.Socket)
+		if err == nil {
+			return client, stdin, nil
+		}
+		select {
+		case <-ctx.Done():
+			stdin.Close()
+			return nil, nil, ctx.Err()
+		case <-timeout:
+			stdin.Close()
+			return nil, nil, fmt.Errorf("privsep helper did not start: %w", err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// dropPrivileges switches to the configured unprivileged user when
+// privilege separation is enabled.
+func dropPrivileges(opts config.PrivSepOptions) error {
+	if !opts.Enabled || opts.User == "" {
+		return nil
+	}
+	if err := privsep.DropPrivileges(opts.User, opts.Group); err != nil {
+		return fmt.Errorf("drop privileges: %w", err)
+	}
+	return nil
+}
@@ -39,6 +39,7 @@ var configPrefixes = []string{
 	"wireguard",
 	"discovery",
 	"plugin",
+	"privsep",
 	"daemon",
 }
 
@@ -58,7 +59,10 @@ The order of precedence for parsing is:
 		
 	1. Files
 	2. Environment variables
-	3. Command line flags`,
+	3. Command line flags
+
+Run "webmesh-node preflight" with the same configuration to check the system for problems
+that would prevent the node from starting, without starting it.`,
 		Prefixes:     configPrefixes,
 		Flagset:      flagset,
 		SkipPrefixes: []string{"bridge"},
@@ -117,8 +121,10 @@ The below tables show all of the available configuration options and their defau
 FENCE
 General Flags
 
-  --config         Load flags from the given configuration file
-  --print-config   Print the configuration and exit
+  --config            Load flags from the given configuration file
+  --print-config      Print the configuration and exit
+  --dry-run           Join the mesh and print the system changes that would be made, then exit
+  --dry-run-output    File to write the dry-run report to (default: stdout)
 
   --help       Show this help message
   --version    Show version information and exit
@@ -144,6 +150,7 @@ FENCE
 	appendFlagSection("Discovery Configurations", "discovery", &sb)
 	appendFlagSection("Services Configurations", "services", &sb)
 	appendFlagSection("Plugin Configurations", "plugins", &sb)
+	appendFlagSection("Privilege Separation Configurations", "privsep", &sb)
 	return os.WriteFile(outfile, []byte(sb.String()), 0644)
 }
 
@@ -21,7 +21,10 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
+	"io/fs"
+	"log/slog"
 	"os"
 	"time"
 
@@ -29,6 +32,7 @@ import (
 	"github.com/spf13/pflag"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
 )
 
 // DefaultNodeID is the default node ID used if no other is configured
@@ -64,6 +68,8 @@ type Config struct {
 	Plugins PluginOptions `koanf:"plugins,omitempty"`
 	// Bridge are the bridge options.
 	Bridge BridgeOptions `koanf:"bridge,omitempty"`
+	// PrivSep are the privilege separation options.
+	PrivSep PrivSepOptions `koanf:"privsep,omitempty"`
 }
 
 // NewDefaultConfig returns a new config with the default options. If nodeID is empty,
@@ -81,6 +87,7 @@ func NewDefaultConfig(nodeID string) *Config {
 		Discovery: NewDiscoveryOptions("", false),
 		Plugins:   NewPluginOptions(),
 		Bridge:    NewBridgeOptions(),
+		PrivSep:   NewPrivSepOptions(),
 	}
 }
 
@@ -100,6 +107,7 @@ func NewInsecureConfig(nodeID string) *Config {
 		Discovery: NewDiscoveryOptions("", false),
 		Plugins:   NewPluginOptions(),
 		Bridge:    NewBridgeOptions(),
+		PrivSep:   NewPrivSepOptions(),
 	}
 	conf.Storage.InMemory = true
 	// Lower the raft timeouts
@@ -126,6 +134,7 @@ func (o *Config) BindFlags(prefix string, fs *pflag.FlagSet) *Config {
 	if prefix == "" {
 		o.Global.BindFlags("global.", fs)
 		o.Bridge.BindFlags("bridge.", fs)
+		o.PrivSep.BindFlags("privsep.", fs)
 	}
 	return o
 }
@@ -144,6 +153,7 @@ func (o *Config) ShallowCopy() *Config {
 		Discovery: o.Discovery,
 		Plugins:   o.Plugins,
 		Bridge:    o.Bridge,
+		PrivSep:   o.PrivSep,
 	}
 }
 
@@ -199,6 +209,10 @@ func (o *Config) Validate() error {
 	if err != nil {
 		return fmt.Errorf("invalid bridge options: %w", err)
 	}
+	err = o.PrivSep.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid privsep options: %w", err)
+	}
 	return nil
 }
 
@@ -210,7 +224,7 @@ func (o *Config) NodeID(ctx context.Context) (string, error) {
 	}
 	// Check if we are using ID authentication.
 	if o.Auth.IDAuth.Enabled {
-		key, err := o.WireGuard.LoadKey(ctx)
+		key, err := o.LoadKey(ctx)
 		if err != nil {
 			return "", fmt.Errorf("load wireguard key: %w", err)
 		}
@@ -224,7 +238,7 @@ func (o *Config) NodeID(ctx context.Context) (string, error) {
 		var err error
 		if o.Auth.MTLS.CertFile != "" {
 			// Parse the certificate file
-			certDataPEM, err = os.ReadFile(o.Auth.MTLS.CertFile)
+			certDataPEM, err = o.readCredentialFile(ctx, MTLSCertCredential, o.Auth.MTLS.CertFile)
 			if err != nil {
 				return "", fmt.Errorf("read certificate file: %w", err)
 			}
@@ -263,6 +277,48 @@ func (o *Config) NodeID(ctx context.Context) (string, error) {
 	return DefaultNodeID, nil
 }
 
+// LoadKey loads the WireGuard key for this configuration. The key is kept in the
+// encrypted credential store when it is enabled.
+func (o *Config) LoadKey(ctx context.Context) (crypto.PrivateKey, error) {
+	store, err := o.Storage.OpenCredentialStore()
+	if err != nil {
+		return nil, err
+	}
+	return o.WireGuard.LoadKeyFromStore(ctx, store)
+}
+
+const (
+	// MTLSCertCredential is the name of the mTLS certificate in the credential store.
+	MTLSCertCredential = "mtls.crt"
+	// MTLSKeyCredential is the name of the mTLS key in the credential store.
+	MTLSKeyCredential = "mtls.key"
+)
+
+// readCredentialFile reads the given file. When the credential store is enabled,
+// a sealed copy of the file is kept in the store and used when the file is
+// missing. The file itself is left in place, since it is managed by the operator.
+func (o *Config) readCredentialFile(ctx context.Context, name, path string) ([]byte, error) {
+	store, err := o.Storage.OpenCredentialStore()
+	if err != nil {
+		return nil, err
+	}
+	if store == nil {
+		return os.ReadFile(path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		context.LoggerFrom(ctx).Info("Credential file not found, using the sealed copy from the credential store", slog.String("file", path), slog.String("name", name))
+		return store.Get(name)
+	}
+	if err := store.Put(name, data); err != nil {
+		return nil, fmt.Errorf("store %s: %w", path, err)
+	}
+	return data, nil
+}
+
 // MTLSEnabled reports whether mtls is enabled.
 func (o *Config) MTLSEnabled() bool {
 	return o.Plugins.MTLSEnabled() && o.Auth.MTLSEnabled()
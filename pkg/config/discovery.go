@@ -44,6 +44,10 @@ type DiscoveryOptions struct {
 	LocalAddrs []string `koanf:"local-addrs,omitempty"`
 	// ConnectTimeout is the timeout for connecting to a peer.
 	ConnectTimeout time.Duration `koanf:"connect-timeout,omitempty"`
+	// IdentityFile is the path to a persistent libp2p identity for discovery.
+	// It is created if it does not exist. When empty, the WireGuard key is used
+	// and the peer ID changes whenever the key is rotated.
+	IdentityFile string `koanf:"identity-file,omitempty"`
 }
 
 // NewDiscoveryOptions returns a new DiscoveryOptions for the given PSK.
@@ -63,16 +67,23 @@ func (o *DiscoveryOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.StringSliceVar(&o.BootstrapServers, prefix+"bootstrap-servers", o.BootstrapServers, "list of bootstrap servers to use for the DHT")
 	fs.StringSliceVar(&o.LocalAddrs, prefix+"local-addrs", o.LocalAddrs, "list of local addresses to announce to the discovery service")
 	fs.DurationVar(&o.ConnectTimeout, prefix+"connect-timeout", o.ConnectTimeout, "timeout for connecting to a peer")
+	fs.StringVar(&o.IdentityFile, prefix+"identity-file", o.IdentityFile, "path to a persistent libp2p identity, created if it does not exist")
 }
 
 // NewHostConfig returns a new HostOptions for the discovery config.
+// The given key identifies the host unless an identity file is configured.
 func (o *DiscoveryOptions) HostOptions(ctx context.Context, key crypto.PrivateKey) libp2p.HostOptions {
-	return libp2p.HostOptions{
-		Options:        []config.Option{p2pcore.Identity(key.AsIdentity())},
+	opts := libp2p.HostOptions{
 		BootstrapPeers: libp2p.ToMultiaddrs(o.BootstrapServers),
 		LocalAddrs:     libp2p.ToMultiaddrs(o.LocalAddrs),
 		ConnectTimeout: o.ConnectTimeout,
 	}
+	if o.IdentityFile != "" {
+		opts.IdentityFile = o.IdentityFile
+	} else {
+		opts.Options = []config.Option{p2pcore.Identity(key.AsIdentity())}
+	}
+	return opts
 }
 
 // Validate validates the discovery options.
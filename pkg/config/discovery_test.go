@@ -114,6 +114,18 @@ func TestDiscoveryConfigHostOptions(t *testing.T) {
 		}
 	})
 
+	t.Run("IdentityFile", func(t *testing.T) {
+		opts := NewDiscoveryOptions("", false)
+		opts.IdentityFile = "/var/lib/webmesh/libp2p.key"
+		hostopts := opts.HostOptions(ctx, key)
+		if len(hostopts.Options) != 0 {
+			t.Errorf("expected no identity option, got %d options", len(hostopts.Options))
+		}
+		if hostopts.IdentityFile != opts.IdentityFile {
+			t.Errorf("expected identity file %q, got %q", opts.IdentityFile, hostopts.IdentityFile)
+		}
+	})
+
 	t.Run("CustomBootstrapPeers", func(t *testing.T) {
 		t.Run("ValidBootstrapPeers", func(t *testing.T) {
 			opts := NewDiscoveryOptions("", false)
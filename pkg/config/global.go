@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"path/filepath"
 	"sort"
 	"strconv"
 
@@ -76,6 +77,11 @@ type GlobalOptions struct {
 	DisableIPv4 bool `koanf:"disable-ipv4,omitempty"`
 	// DisableIPv6 is true if IPv6 should be disabled.
 	DisableIPv6 bool `koanf:"disable-ipv6,omitempty"`
+	// StateRoot is a directory that all writable state is derived from. When set,
+	// default and relative paths for storage, keys, and other files are placed
+	// beneath it and startup fails if any writable path is configured outside
+	// of it. This allows running on a read-only root filesystem.
+	StateRoot string `koanf:"state-root,omitempty"`
 }
 
 // NewGlobalOptions creates a new GlobalOptions.
@@ -99,6 +105,7 @@ func NewGlobalOptions() GlobalOptions {
 		DetectIPv6:             false,
 		DisableIPv4:            false,
 		DisableIPv6:            false,
+		StateRoot:              "",
 	}
 }
 
@@ -121,6 +128,7 @@ func (o *GlobalOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.BoolVar(&o.DetectIPv6, prefix+"detect-ipv6", o.DetectIPv6, "Detect and advertise IPv6 endpoints.")
 	fs.BoolVar(&o.DisableIPv4, prefix+"disable-ipv4", o.DisableIPv4, "Disable IPv4.")
 	fs.BoolVar(&o.DisableIPv6, prefix+"disable-ipv6", o.DisableIPv6, "Disable IPv6.")
+	fs.StringVar(&o.StateRoot, prefix+"state-root", o.StateRoot, "Writable directory to derive all state paths from. Use when the root filesystem is read-only.")
 }
 
 // Validate validates the global options.
@@ -131,6 +139,9 @@ func (o *GlobalOptions) Validate() error {
 	if o.DisableIPv4 && o.DisableIPv6 {
 		return fmt.Errorf("both IPv4 and IPv6 are disabled")
 	}
+	if o.StateRoot != "" && !filepath.IsAbs(o.StateRoot) {
+		return fmt.Errorf("state-root must be an absolute path")
+	}
 	if o.MTLS {
 		if o.TLSCertFile == "" {
 			return fmt.Errorf("mtls is enabled but no tls-cert-file is set")
@@ -162,6 +173,8 @@ func (global *GlobalOptions) ApplyGlobals(ctx context.Context, o *Config) (*Conf
 			return nil, fmt.Errorf("failed to generate node ID: %w", err)
 		}
 	}
+	// Derive writable paths from the state root
+	o.applyStateRoot(global.StateRoot, "")
 	// Protocol preferences
 	o.Mesh.DisableIPv4 = global.DisableIPv4
 	o.Mesh.DisableIPv6 = global.DisableIPv6
@@ -357,6 +370,7 @@ func (global *GlobalOptions) ApplyGlobals(ctx context.Context, o *Config) (*Conf
 		if bridgeOpts.Mesh.MeshDNSAdvertisePort == 0 {
 			bridgeOpts.Mesh.MeshDNSAdvertisePort = meshDNSPort
 		}
+		bridgeOpts.applyStateRoot(global.StateRoot, filepath.Join(StateRootBridgeDir, id))
 		overlay, err := global.ApplyGlobals(ctx, bridgeOpts)
 		if err != nil {
 			return nil, err
@@ -0,0 +1,148 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/spf13/pflag"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
+)
+
+// ListenerOptions are options for filtering and limiting the connections
+// accepted by a listener.
+type ListenerOptions struct {
+	// ProxyProtocol accepts the HAProxy PROXY protocol (v1 or v2) from trusted
+	// proxies, so client addresses are kept behind L4 load balancers.
+	ProxyProtocol bool `koanf:"proxy-protocol,omitempty"`
+	// TrustedProxies are the networks allowed to send a PROXY protocol header.
+	// They are required with ProxyProtocol, since a header from any other
+	// source could forge the client address.
+	TrustedProxies []string `koanf:"trusted-proxies,omitempty"`
+	// ProxyHeaderTimeout is how long to wait for a PROXY protocol header.
+	ProxyHeaderTimeout time.Duration `koanf:"proxy-header-timeout,omitempty"`
+	// AllowedCIDRs are the only client networks allowed to connect. Every
+	// client is allowed if empty. The lists also apply to the packets of the
+	// HTTP/3 listener, where client addresses are never taken from the PROXY
+	// protocol.
+	AllowedCIDRs []string `koanf:"allowed-cidrs,omitempty"`
+	// DeniedCIDRs are client networks refused even when they are allowed.
+	DeniedCIDRs []string `koanf:"denied-cidrs,omitempty"`
+	// MaxConnections is the maximum number of concurrent connections.
+	// Zero means unlimited.
+	MaxConnections int `koanf:"max-connections,omitempty"`
+}
+
+// BindFlags binds the flags.
+func (l *ListenerOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
+	fl.BoolVar(&l.ProxyProtocol, prefix+"proxy-protocol", l.ProxyProtocol, "Accept the HAProxy PROXY protocol (v1 or v2) from trusted proxies.")
+	fl.StringSliceVar(&l.TrustedProxies, prefix+"trusted-proxies", l.TrustedProxies, "CIDRs allowed to send a PROXY protocol header. Required with proxy-protocol, and headers are required from these.")
+	fl.DurationVar(&l.ProxyHeaderTimeout, prefix+"proxy-header-timeout", l.ProxyHeaderTimeout, "Time to wait for a PROXY protocol header.")
+	fl.StringSliceVar(&l.AllowedCIDRs, prefix+"allowed-cidrs", l.AllowedCIDRs, "Client CIDRs allowed to connect, over TCP and HTTP/3. All clients are allowed if empty.")
+	fl.StringSliceVar(&l.DeniedCIDRs, prefix+"denied-cidrs", l.DeniedCIDRs, "Client CIDRs refused at accept time, or whose HTTP/3 packets are dropped, even if they are in allowed-cidrs.")
+	fl.IntVar(&l.MaxConnections, prefix+"max-connections", l.MaxConnections, "Maximum number of concurrent connections. Zero means unlimited.")
+}
+
+// Validate validates the options.
+func (l ListenerOptions) Validate() error {
+	if len(l.TrustedProxies) > 0 && !l.ProxyProtocol {
+		return fmt.Errorf("trusted-proxies requires proxy-protocol")
+	}
+	if l.ProxyProtocol && len(l.TrustedProxies) == 0 {
+		return fmt.Errorf("proxy-protocol requires trusted-proxies")
+	}
+	if _, err := parsePrefixes(l.TrustedProxies); err != nil {
+		return fmt.Errorf("trusted-proxies is invalid: %w", err)
+	}
+	if l.ProxyHeaderTimeout < 0 {
+		return fmt.Errorf("proxy-header-timeout must not be negative")
+	}
+	if _, err := parsePrefixes(l.AllowedCIDRs); err != nil {
+		return fmt.Errorf("allowed-cidrs is invalid: %w", err)
+	}
+	if _, err := parsePrefixes(l.DeniedCIDRs); err != nil {
+		return fmt.Errorf("denied-cidrs is invalid: %w", err)
+	}
+	if l.MaxConnections < 0 {
+		return fmt.Errorf("max-connections must not be negative")
+	}
+	return nil
+}
+
+// Middlewares returns the listener middlewares for these options. The PROXY
+// protocol is handled first so the IP filter sees client addresses.
+func (l ListenerOptions) Middlewares() ([]netutil.ListenerMiddleware, error) {
+	var mws []netutil.ListenerMiddleware
+	if l.ProxyProtocol {
+		trusted, err := parsePrefixes(l.TrustedProxies)
+		if err != nil {
+			return nil, fmt.Errorf("parse trusted proxies: %w", err)
+		}
+		mws = append(mws, netutil.ProxyProtocol(netutil.ProxyProtocolOptions{
+			TrustedProxies: trusted,
+			HeaderTimeout:  l.ProxyHeaderTimeout,
+		}))
+	}
+	if len(l.AllowedCIDRs) > 0 || len(l.DeniedCIDRs) > 0 {
+		allowed, err := parsePrefixes(l.AllowedCIDRs)
+		if err != nil {
+			return nil, fmt.Errorf("parse allowed cidrs: %w", err)
+		}
+		denied, err := parsePrefixes(l.DeniedCIDRs)
+		if err != nil {
+			return nil, fmt.Errorf("parse denied cidrs: %w", err)
+		}
+		mws = append(mws, netutil.IPFilter(allowed, denied))
+	}
+	if l.MaxConnections > 0 {
+		mws = append(mws, netutil.ConnectionLimit(l.MaxConnections))
+	}
+	return mws, nil
+}
+
+// PacketConnMiddlewares returns the middlewares for packet connections, such
+// as the one of the HTTP/3 listener, for these options. Only the IP filter
+// applies to them.
+func (l ListenerOptions) PacketConnMiddlewares() ([]netutil.PacketConnMiddleware, error) {
+	if len(l.AllowedCIDRs) == 0 && len(l.DeniedCIDRs) == 0 {
+		return nil, nil
+	}
+	allowed, err := parsePrefixes(l.AllowedCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("parse allowed cidrs: %w", err)
+	}
+	denied, err := parsePrefixes(l.DeniedCIDRs)
+	if err != nil {
+		return nil, fmt.Errorf("parse denied cidrs: %w", err)
+	}
+	return []netutil.PacketConnMiddleware{netutil.PacketIPFilter(allowed, denied)}, nil
+}
+
+func parsePrefixes(cidrs []string) ([]netip.Prefix, error) {
+	out := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, prefix.Masked())
+	}
+	return out, nil
+}
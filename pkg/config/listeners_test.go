@@ -0,0 +1,76 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import "testing"
+
+func TestListenerOptions(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name    string
+		opts    ListenerOptions
+		wantErr bool
+		wantMWs int
+		// wantPCMWs is the number of packet connection middlewares.
+		wantPCMWs int
+	}{
+		{name: "defaults", opts: ListenerOptions{}},
+		{
+			name: "all middlewares",
+			opts: ListenerOptions{
+				ProxyProtocol:  true,
+				TrustedProxies: []string{"10.0.0.0/8"},
+				AllowedCIDRs:   []string{"192.168.0.0/16", "2001:db8::/32"},
+				MaxConnections: 100,
+			},
+			wantMWs:   3,
+			wantPCMWs: 1,
+		},
+		{name: "trusted proxies without proxy protocol", opts: ListenerOptions{TrustedProxies: []string{"10.0.0.0/8"}}, wantErr: true},
+		{name: "proxy protocol without trusted proxies", opts: ListenerOptions{ProxyProtocol: true}, wantErr: true},
+		{name: "invalid trusted proxy", opts: ListenerOptions{ProxyProtocol: true, TrustedProxies: []string{"10.0.0.1"}}, wantErr: true},
+		{name: "deny list only", opts: ListenerOptions{DeniedCIDRs: []string{"198.51.100.0/24"}}, wantMWs: 1, wantPCMWs: 1},
+		{name: "invalid allowed cidr", opts: ListenerOptions{AllowedCIDRs: []string{"not-a-cidr"}}, wantErr: true},
+		{name: "invalid denied cidr", opts: ListenerOptions{DeniedCIDRs: []string{"not-a-cidr"}}, wantErr: true},
+		{name: "negative max connections", opts: ListenerOptions{MaxConnections: -1}, wantErr: true},
+	}
+	for _, tt := range tc {
+		err := tt.opts.Validate()
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected validation error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected validation error: %v", tt.name, err)
+			continue
+		}
+		mws, err := tt.opts.Middlewares()
+		if err != nil {
+			t.Errorf("%s: unexpected error building middlewares: %v", tt.name, err)
+		} else if len(mws) != tt.wantMWs {
+			t.Errorf("%s: expected %d middlewares, got %d", tt.name, tt.wantMWs, len(mws))
+		}
+		pcmws, err := tt.opts.PacketConnMiddlewares()
+		if err != nil {
+			t.Errorf("%s: unexpected error building packet connection middlewares: %v", tt.name, err)
+		} else if len(pcmws) != tt.wantPCMWs {
+			t.Errorf("%s: expected %d packet connection middlewares, got %d", tt.name, tt.wantPCMWs, len(pcmws))
+		}
+	}
+}
@@ -28,16 +28,21 @@ import (
 	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/multiformats/go-multiaddr"
 	"github.com/spf13/pflag"
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/nat64"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/libp2p"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
@@ -46,6 +51,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/plugins/builtins/idauth"
 	"github.com/webmeshproj/webmesh/pkg/plugins/builtins/ldap"
 	"github.com/webmeshproj/webmesh/pkg/services"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/meshdns"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
@@ -60,6 +66,10 @@ type MeshOptions struct {
 	PrimaryEndpoint string `koanf:"primary-endpoint,omitempty"`
 	// ZoneAwarenessID is the zone awareness ID.
 	ZoneAwarenessID string `koanf:"zone-awareness-id,omitempty"`
+	// ClusterID is the UUID of the cluster this node belongs to. When bootstrapping
+	// it is used as the cluster ID, otherwise the node refuses to join a mesh with
+	// a different one. If unset, one is generated at bootstrap.
+	ClusterID string `koanf:"cluster-id,omitempty"`
 	// JoinAddresses are addresses of nodes to attempt to join.
 	JoinAddresses []string `koanf:"join-addresses,omitempty"`
 	// JoinMultiaddrs are multiaddresses to attempt to join over libp2p.
@@ -67,9 +77,16 @@ type MeshOptions struct {
 	JoinMultiaddrs []string `koanf:"join-multiaddrs,omitempty"`
 	// MaxJoinRetries is the maximum number of join retries.
 	MaxJoinRetries int `koanf:"max-join-retries,omitempty"`
+	// KnownPeersFile is a file to persist the addresses of known mesh peers to.
+	// On restart they are tried in parallel with any join addresses so the node
+	// can rejoin the mesh even if the original join endpoints are gone.
+	KnownPeersFile string `koanf:"known-peers-file,omitempty"`
 	// Routes are additional routes to advertise to the mesh. These routes are advertised to all peers.
 	// If the node is not allowed to put routes in the mesh, the node will be unable to join.
 	Routes []string `koanf:"routes,omitempty"`
+	// Gateway marks this node as a gateway for the configured routes. Forwarding and
+	// masquerading are enabled and peers prefer edges to the node when reaching it.
+	Gateway bool `koanf:"gateway,omitempty"`
 	// ICEPeers are peers to request direct edges to over ICE. If the node is not allowed to create edges
 	// and data channels, the node will be unable to join.
 	ICEPeers []string `koanf:"ice-peers,omitempty"`
@@ -86,6 +103,9 @@ type MeshOptions struct {
 	RequestVote bool `koanf:"request-vote,omitempty"`
 	// RequestObserver is true if the node should be a storage observer.
 	RequestObserver bool `koanf:"request-observer,omitempty"`
+	// RequestLearner is true if the node should be a storage learner. Learners
+	// are permanent observers that are never promoted to voters.
+	RequestLearner bool `koanf:"request-learner,omitempty"`
 	// StoragePreferIPv6 is the prefer IPv6 flag for storage provider connections.
 	StoragePreferIPv6 bool `koanf:"prefer-ipv6,omitempty"`
 	// DisableIPv4 disables IPv4 usage.
@@ -98,6 +118,37 @@ type MeshOptions struct {
 	DisableDefaultIPAM bool `koanf:"disable-default-ipam,omitempty"`
 	// DefaultIPAMStaticIPv4 are static IPv4 assignments to use for the default IPAM.
 	DefaultIPAMStaticIPv4 map[string]string `koanf:"default-ipam-static-ipv4,omitempty"`
+	// NATDetectionServers are STUN servers used to detect the type of NAT the node is behind.
+	// The result is reported to the mesh so peers can pre-select how to connect to the node.
+	// At least two servers on different addresses are required. Detection is disabled when empty.
+	NATDetectionServers []string `koanf:"nat-detection-servers,omitempty"`
+	// NATDetectionInterval is how often the NAT type is detected again after startup.
+	NATDetectionInterval time.Duration `koanf:"nat-detection-interval,omitempty"`
+	// NAT64 are options for translating traffic from IPv6-only members to the
+	// IPv4 routes of a gateway node.
+	NAT64 NAT64Options `koanf:"nat64,omitempty"`
+}
+
+// NAT64Options are options for translating traffic from IPv6-only members to
+// IPv4 destinations on a gateway node.
+type NAT64Options struct {
+	// Enabled enables NAT64 for the IPv4 routes of the node. The prefix is
+	// advertised to the mesh as an additional route.
+	Enabled bool `koanf:"enabled,omitempty"`
+	// Prefix is the IPv6 /96 prefix IPv4 addresses are embedded in.
+	Prefix string `koanf:"prefix,omitempty"`
+	// Pool is the IPv4 prefix translated sources are mapped to before they
+	// are masqueraded. It must not overlap with networks in use on the node.
+	Pool string `koanf:"pool,omitempty"`
+}
+
+// NewNAT64Options returns new NAT64Options with the default values.
+func NewNAT64Options() NAT64Options {
+	return NAT64Options{
+		Enabled: false,
+		Prefix:  nat64.WellKnownPrefix.String(),
+		Pool:    nat64.DefaultPool.String(),
+	}
 }
 
 // NewMeshOptions returns a new MeshOptions with the default values. If node id
@@ -110,6 +161,7 @@ func NewMeshOptions(nodeID string) MeshOptions {
 		JoinAddresses:               nil,
 		MaxJoinRetries:              15,
 		Routes:                      nil,
+		Gateway:                     false,
 		ICEPeers:                    []string{},
 		LibP2PPeers:                 []string{},
 		GRPCAdvertisePort:           services.DefaultGRPCPort,
@@ -117,12 +169,16 @@ func NewMeshOptions(nodeID string) MeshOptions {
 		UseMeshDNS:                  false,
 		RequestVote:                 false,
 		RequestObserver:             false,
+		RequestLearner:              false,
 		StoragePreferIPv6:           false,
 		DisableIPv4:                 false,
 		DisableIPv6:                 false,
 		DisableFeatureAdvertisement: false,
 		DisableDefaultIPAM:          false,
 		DefaultIPAMStaticIPv4:       map[string]string{},
+		NATDetectionServers:         []string{},
+		NATDetectionInterval:        meshnode.DefaultNATDetectionInterval,
+		NAT64:                       NewNAT64Options(),
 	}
 }
 
@@ -131,10 +187,13 @@ func (o *MeshOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.StringVar(&o.NodeID, prefix+"node-id", o.NodeID, "Node ID. One will be chosen automatically if left unset.")
 	fs.StringVar(&o.PrimaryEndpoint, prefix+"primary-endpoint", o.PrimaryEndpoint, "Primary endpoint to advertise when joining.")
 	fs.StringVar(&o.ZoneAwarenessID, prefix+"zone-awareness-id", o.ZoneAwarenessID, "Zone awareness ID.")
+	fs.StringVar(&o.ClusterID, prefix+"cluster-id", o.ClusterID, "UUID of the cluster to bootstrap or join. Joining a mesh with a different cluster ID is refused.")
 	fs.StringSliceVar(&o.JoinAddresses, prefix+"join-addresses", o.JoinAddresses, "Addresses of nodes to join.")
 	fs.StringSliceVar(&o.JoinMultiaddrs, prefix+"join-multiaddrs", o.JoinMultiaddrs, "Multiaddresses of nodes to join.")
 	fs.IntVar(&o.MaxJoinRetries, prefix+"max-join-retries", o.MaxJoinRetries, "Maximum number of join retries.")
+	fs.StringVar(&o.KnownPeersFile, prefix+"known-peers-file", o.KnownPeersFile, "File to persist known peer addresses to for rejoining the mesh on restart.")
 	fs.StringSliceVar(&o.Routes, prefix+"routes", o.Routes, "Additional routes to advertise to the mesh.")
+	fs.BoolVar(&o.Gateway, prefix+"gateway", o.Gateway, "Act as a gateway for the advertised routes by enabling forwarding and masquerading.")
 	fs.StringSliceVar(&o.ICEPeers, prefix+"ice-peers", o.ICEPeers, "Peers to request direct edges to over ICE.")
 	fs.StringSliceVar(&o.LibP2PPeers, prefix+"libp2p-peers", o.LibP2PPeers, "Map of peer IDs to rendezvous strings for edges over libp2p.")
 	fs.IntVar(&o.GRPCAdvertisePort, prefix+"grpc-advertise-port", o.GRPCAdvertisePort, "Port to advertise for gRPC.")
@@ -142,12 +201,63 @@ func (o *MeshOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.BoolVar(&o.UseMeshDNS, prefix+"use-meshdns", o.UseMeshDNS, "Set mesh DNS servers to the system configuration.")
 	fs.BoolVar(&o.RequestVote, prefix+"request-vote", o.RequestVote, "Request a vote in elections for the storage backend.")
 	fs.BoolVar(&o.RequestObserver, prefix+"request-observer", o.RequestObserver, "Request to be an observer in the storage backend.")
+	fs.BoolVar(&o.RequestLearner, prefix+"request-learner", o.RequestLearner, "Request to be a learner in the storage backend that is never promoted to voter.")
 	fs.BoolVar(&o.StoragePreferIPv6, prefix+"storage-prefer-ipv6", o.StoragePreferIPv6, "Prefer IPv6 connections for the storage backend transport.")
 	fs.BoolVar(&o.DisableIPv4, prefix+"disable-ipv4", o.DisableIPv4, "Disable IPv4 usage.")
 	fs.BoolVar(&o.DisableIPv6, prefix+"disable-ipv6", o.DisableIPv6, "Disable IPv6 usage.")
 	fs.BoolVar(&o.DisableFeatureAdvertisement, prefix+"disable-feature-advertisement", o.DisableFeatureAdvertisement, "Disable feature advertisement.")
 	fs.BoolVar(&o.DisableDefaultIPAM, prefix+"disable-default-ipam", o.DisableDefaultIPAM, "Disable the default IPAM.")
 	fs.StringToStringVar(&o.DefaultIPAMStaticIPv4, prefix+"default-ipam-static-ipv4", o.DefaultIPAMStaticIPv4, "Static IPv4 assignments to use for the default IPAM.")
+	fs.StringSliceVar(&o.NATDetectionServers, prefix+"nat-detection-servers", o.NATDetectionServers, "STUN servers to detect the NAT type of the node with. At least two are required.")
+	fs.DurationVar(&o.NATDetectionInterval, prefix+"nat-detection-interval", o.NATDetectionInterval, "Interval to detect the NAT type of the node again after startup.")
+	o.NAT64.BindFlags(prefix+"nat64.", fs)
+}
+
+// BindFlags binds the flags to the options.
+func (o *NAT64Options) BindFlags(prefix string, fs *pflag.FlagSet) {
+	fs.BoolVar(&o.Enabled, prefix+"enabled", o.Enabled, "Translate traffic from IPv6-only members to the IPv4 routes of this gateway.")
+	fs.StringVar(&o.Prefix, prefix+"prefix", o.Prefix, "IPv6 /96 prefix to embed IPv4 addresses in.")
+	fs.StringVar(&o.Pool, prefix+"pool", o.Pool, "IPv4 prefix to map translated sources to before masquerading them.")
+}
+
+// Validate validates the NAT64 options.
+func (o *NAT64Options) Validate() error {
+	if !o.Enabled {
+		return nil
+	}
+	prefix, err := netip.ParsePrefix(o.Prefix)
+	if err != nil {
+		return fmt.Errorf("invalid mesh.nat64.prefix: %w", err)
+	}
+	if !prefix.Addr().Is6() || prefix.Bits() != 96 {
+		return fmt.Errorf("mesh.nat64.prefix must be an IPv6 /96 prefix")
+	}
+	pool, err := netip.ParsePrefix(o.Pool)
+	if err != nil {
+		return fmt.Errorf("invalid mesh.nat64.pool: %w", err)
+	}
+	if !pool.Addr().Is4() {
+		return fmt.Errorf("mesh.nat64.pool must be an IPv4 prefix")
+	}
+	return nil
+}
+
+// Options returns the NAT64 options for translating to the IPv4 prefixes
+// in the given routes, or nil if NAT64 is disabled.
+func (o *NAT64Options) Options(routes []netip.Prefix) *nat64.Options {
+	if !o.Enabled {
+		return nil
+	}
+	opts := &nat64.Options{
+		Prefix: netip.MustParsePrefix(o.Prefix),
+		Pool:   netip.MustParsePrefix(o.Pool).Masked(),
+	}
+	for _, route := range routes {
+		if route.Addr().Is4() {
+			opts.Destinations = append(opts.Destinations, route)
+		}
+	}
+	return opts
 }
 
 // Validate validates the options.
@@ -163,6 +273,20 @@ func (o *MeshOptions) Validate() error {
 	if o.DisableIPv4 && o.DisableIPv6 {
 		return fmt.Errorf("cannot disable both IPv4 and IPv6")
 	}
+	// Mesh addresses of every enabled family are assigned to the WireGuard
+	// interface, so the host must support each of them.
+	families := netutil.DetectAddressFamilies()
+	if !o.DisableIPv4 && !families.IPv4 {
+		return fmt.Errorf("IPv4 is not available on this host, disable IPv4 to run the mesh over IPv6 only")
+	}
+	if !o.DisableIPv6 && !families.IPv6 {
+		return fmt.Errorf("IPv6 is not available on this host, disable IPv6 to run the mesh over IPv4 only")
+	}
+	if o.ClusterID != "" {
+		if _, err := uuid.Parse(o.ClusterID); err != nil {
+			return fmt.Errorf("invalid cluster ID: %w", err)
+		}
+	}
 	if (len(o.JoinAddresses) > 0 || len(o.JoinMultiaddrs) > 0) && o.MaxJoinRetries <= 0 {
 		return fmt.Errorf("max join retries must be >= 0")
 	}
@@ -177,9 +301,15 @@ func (o *MeshOptions) Validate() error {
 			return fmt.Errorf("invalid join multiaddress: %w", err)
 		}
 	}
+	if o.Gateway && len(o.Routes) == 0 {
+		return fmt.Errorf("gateway mode requires at least one route")
+	}
 	if o.RequestVote && o.RequestObserver {
 		return fmt.Errorf("cannot request vote and observer")
 	}
+	if o.RequestVote && o.RequestLearner {
+		return fmt.Errorf("cannot request vote and learner")
+	}
 	if o.DisableIPv6 && o.StoragePreferIPv6 {
 		return fmt.Errorf("cannot prefer IPv6 for storage when IPv6 is disabled")
 	}
@@ -231,12 +361,31 @@ func (o *MeshOptions) Validate() error {
 			}
 		}
 	}
+	if len(o.NATDetectionServers) > 0 {
+		if len(o.NATDetectionServers) < 2 {
+			return fmt.Errorf("NAT detection requires at least two STUN servers")
+		}
+		if o.NATDetectionInterval <= 0 {
+			return fmt.Errorf("NAT detection interval must be greater than zero")
+		}
+	}
+	if o.NAT64.Enabled {
+		if !o.Gateway {
+			return fmt.Errorf("NAT64 requires gateway mode")
+		}
+		if o.DisableIPv6 {
+			return fmt.Errorf("cannot enable NAT64 when IPv6 is disabled")
+		}
+	}
+	if err := o.NAT64.Validate(); err != nil {
+		return err
+	}
 	return nil
 }
 
 // IsStorageMember returns true if the node is a storage provider.
 func (o *Config) IsStorageMember() bool {
-	return o.Bootstrap.Enabled || o.Mesh.RequestVote || o.Mesh.RequestObserver
+	return o.Bootstrap.Enabled || o.Mesh.RequestVote || o.Mesh.RequestObserver || o.Mesh.RequestLearner
 }
 
 // NewMeshConfig return a new Mesh configuration based on the node configuration.
@@ -244,7 +393,7 @@ func (o *Config) IsStorageMember() bool {
 func (o *Config) NewMeshConfig(ctx context.Context, key crypto.PrivateKey) (conf meshnode.Config, err error) {
 	log := context.LoggerFrom(ctx)
 	if key == nil {
-		key, err = o.WireGuard.LoadKey(ctx)
+		key, err = o.LoadKey(ctx)
 		if err != nil {
 			return
 		}
@@ -258,6 +407,8 @@ func (o *Config) NewMeshConfig(ctx context.Context, key crypto.PrivateKey) (conf
 		DisableIPv6:             o.Mesh.DisableIPv6,
 		DisableDefaultIPAM:      o.Mesh.DisableDefaultIPAM,
 		DefaultIPAMStaticIPv4:   o.Mesh.DefaultIPAMStaticIPv4,
+		KnownPeersFile:          o.Mesh.KnownPeersFile,
+		ClusterID:               o.Mesh.ClusterID,
 	}
 	// Check if we are serving a local DNS server
 	if o.Services.MeshDNS.Enabled {
@@ -287,7 +438,11 @@ func (o *Config) NewClientCredentials(ctx context.Context, key crypto.PrivateKey
 	if !o.TLS.Insecure {
 		// We need a TLS configuration
 		log.Debug("Configuring secure gRPC transport")
-		tlsconf := &tls.Config{}
+		tlsconf := &tls.Config{
+			// Share a session cache across connections so that redials to
+			// the same node can resume TLS sessions instead of doing a full handshake.
+			ClientSessionCache: tls.NewLRUClientSessionCache(0),
+		}
 		var roots *x509.CertPool
 		roots, err := x509.SystemCertPool()
 		if err != nil {
@@ -335,7 +490,15 @@ func (o *Config) NewClientCredentials(ctx context.Context, key crypto.PrivateKey
 			var cert tls.Certificate
 			if o.Auth.MTLS.CertFile != "" && o.Auth.MTLS.KeyFile != "" {
 				log.Debug("Loading client certificate", slog.String("file", o.Auth.MTLS.CertFile), slog.String("key", o.Auth.MTLS.KeyFile))
-				cert, err = tls.LoadX509KeyPair(o.Auth.MTLS.CertFile, o.Auth.MTLS.KeyFile)
+				certData, err := o.readCredentialFile(ctx, MTLSCertCredential, o.Auth.MTLS.CertFile)
+				if err != nil {
+					return nil, fmt.Errorf("load client certificate: %w", err)
+				}
+				keyData, err := o.readCredentialFile(ctx, MTLSKeyCredential, o.Auth.MTLS.KeyFile)
+				if err != nil {
+					return nil, fmt.Errorf("load client key: %w", err)
+				}
+				cert, err = tls.X509KeyPair(certData, keyData)
 				if err != nil {
 					return nil, fmt.Errorf("load client certificate: %w", err)
 				}
@@ -428,11 +591,20 @@ func (o *Config) NewConnectOptions(ctx context.Context, conn meshnode.Node, prov
 			}
 		}
 	}
+	nat64opts := o.Mesh.NAT64.Options(routes)
+	if nat64opts != nil {
+		// Advertise the NAT64 prefix so IPv6-only members route it to us.
+		routes = append(routes, nat64opts.Prefix)
+	}
 	// Create the join transport
 	joinRT, err := o.NewJoinTransport(ctx, nodeid, conn, host)
 	if err != nil {
 		return
 	}
+	joinRT, err = o.NewKeyEscrowJoinTransport(conn.Key(), joinRT)
+	if err != nil {
+		return
+	}
 	// Configure any bootstrap options
 	var bootstrap *meshnode.BootstrapOptions
 	if o.Bootstrap.Enabled {
@@ -493,7 +665,10 @@ func (o *Config) NewConnectOptions(ctx context.Context, conn meshnode.Node, prov
 		WireGuardEndpoints:   wireguardEndpoints,
 		RequestVote:          o.Mesh.RequestVote,
 		RequestObserver:      o.Mesh.RequestObserver,
+		RequestLearner:       o.Mesh.RequestLearner,
 		Routes:               routes,
+		Gateway:              o.Mesh.Gateway,
+		NAT64:                nat64opts,
 		DirectPeers: func() map[types.NodeID]v1.ConnectProtocol {
 			peers := make(map[types.NodeID]v1.ConnectProtocol)
 			for _, peer := range o.Mesh.ICEPeers {
@@ -508,6 +683,10 @@ func (o *Config) NewConnectOptions(ctx context.Context, conn meshnode.Node, prov
 		}(),
 		PreferIPv6: o.Mesh.StoragePreferIPv6,
 		Plugins:    plugins,
+		NATDetection: meshnode.NATDetectionOptions{
+			STUNServers: o.Mesh.NATDetectionServers,
+			Interval:    o.Mesh.NATDetectionInterval,
+		},
 		NetworkOptions: meshnet.Options{
 			Modprobe:              o.WireGuard.Modprobe,
 			InterfaceName:         o.WireGuard.InterfaceName,
@@ -526,8 +705,22 @@ func (o *Config) NewConnectOptions(ctx context.Context, conn meshnode.Node, prov
 			DisableIPv4:           o.Mesh.DisableIPv4,
 			DisableIPv6:           o.Mesh.DisableIPv6,
 			DisableFullTunnel:     o.WireGuard.DisableFullTunnel,
+			SystemOps:             o.PrivSep.Ops(),
+			Conntrack:             o.WireGuard.Conntrack.Options(),
+			RouteHealth:           o.WireGuard.RouteHealth.Options(),
+			ExternalPeers:         o.WireGuard.ExternalPeers,
+			FirewallACLs:          o.WireGuard.FirewallACLs,
+			TrafficPolicies:       o.WireGuard.TrafficPolicies,
+			FirewallBackend:       firewall.Backend(o.WireGuard.FirewallBackend),
+			NetNs: func() string {
+				if o.Services.Sidecar.Enabled {
+					return o.Services.Sidecar.NetNs
+				}
+				return ""
+			}(),
 			Relays: meshnet.RelayOptions{
-				Host: o.Discovery.HostOptions(ctx, conn.Key()),
+				Host:     o.Discovery.HostOptions(ctx, conn.Key()),
+				TURNAuth: o.Services.WebRTC.TURNAuth(),
 			},
 		},
 	}
@@ -551,6 +744,31 @@ func (o *Config) NewJoinTransport(ctx context.Context, nodeID string, conn meshn
 		// Our join transport is nil and we either bootstrap or recover from storage.
 		return nil, nil
 	}
+	rt, err := o.newConfiguredJoinTransport(ctx, conn, host)
+	if err != nil {
+		return nil, err
+	}
+	if o.Mesh.KnownPeersFile == "" || rt == nil {
+		return rt, nil
+	}
+	// Try any peers we knew about from a previous run alongside the configured ones.
+	known, err := meshnode.LoadKnownPeers(o.Mesh.KnownPeersFile)
+	if err != nil {
+		context.LoggerFrom(ctx).Warn("Failed to load known peers", slog.String("error", err.Error()))
+		return rt, nil
+	}
+	if len(known) == 0 {
+		return rt, nil
+	}
+	context.LoggerFrom(ctx).Debug("Attempting to rejoin through known peers", slog.Any("peers", known))
+	return transport.NewParallelRoundTripper(rt, tcp.NewJoinRoundTripper(tcp.RoundTripOptions{
+		Addrs:          known,
+		Credentials:    conn.Credentials(),
+		AddressTimeout: time.Second * 3,
+	})), nil
+}
+
+func (o *Config) newConfiguredJoinTransport(ctx context.Context, conn meshnode.Node, host libp2p.Host) (transport.JoinRoundTripper, error) {
 	if len(o.Mesh.JoinAddresses) > 0 {
 		return tcp.NewJoinRoundTripper(tcp.RoundTripOptions{
 			Addrs:          o.Mesh.JoinAddresses,
@@ -585,3 +803,25 @@ func (o *Config) NewJoinTransport(ctx context.Context, nodeID string, conn meshn
 	// A nil transport is technically okay, it means we are a single-node mesh
 	return nil, nil
 }
+
+// NewKeyEscrowJoinTransport wraps the given join transport so that the node's key is
+// wrapped to the configured recovery key and escrowed with the mesh on join. The
+// transport is returned unchanged if no recovery key is configured.
+func (o *Config) NewKeyEscrowJoinTransport(key crypto.PrivateKey, rt transport.JoinRoundTripper) (transport.JoinRoundTripper, error) {
+	if rt == nil || o.WireGuard.KeyEscrowRecoveryKey == "" {
+		return rt, nil
+	}
+	recovery, err := crypto.DecodePublicKey(o.WireGuard.KeyEscrowRecoveryKey)
+	if err != nil {
+		return nil, fmt.Errorf("decode key escrow recovery key: %w", err)
+	}
+	wrapped, err := crypto.WrapKey(key, recovery)
+	if err != nil {
+		return nil, fmt.Errorf("wrap key for escrow: %w", err)
+	}
+	escrow := base64.StdEncoding.EncodeToString(wrapped)
+	return transport.JoinRoundTripperFunc(func(ctx context.Context, req *v1.JoinRequest) (*v1.JoinResponse, error) {
+		ctx = metadata.AppendToOutgoingContext(ctx, leaderproxy.KeyEscrowMeta, escrow)
+		return rt.RoundTrip(ctx, req)
+	}), nil
+}
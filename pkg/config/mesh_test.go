@@ -66,6 +66,62 @@ func TestMeshConfigValidation(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "GatewayWithoutRoutes",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+				Gateway:              true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "GatewayWithRoutes",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+				Routes:               []string{"10.10.0.0/16"},
+				Gateway:              true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "NAT64WithoutGateway",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+				Routes:               []string{"10.10.0.0/16"},
+				NAT64:                NAT64Options{Enabled: true, Prefix: "64:ff9b::/96", Pool: "192.168.255.0/24"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "NAT64InvalidPrefix",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+				Routes:               []string{"10.10.0.0/16"},
+				Gateway:              true,
+				NAT64:                NAT64Options{Enabled: true, Prefix: "64:ff9b::/64", Pool: "192.168.255.0/24"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "NAT64OnGateway",
+			cfg: &MeshOptions{
+				NodeID:               "test-node",
+				GRPCAdvertisePort:    services.DefaultGRPCPort,
+				MeshDNSAdvertisePort: meshdns.DefaultAdvertisePort,
+				Routes:               []string{"10.10.0.0/16"},
+				Gateway:              true,
+				NAT64:                NAT64Options{Enabled: true, Prefix: "64:ff9b::/96", Pool: "192.168.255.0/24"},
+			},
+			wantErr: false,
+		},
 		{
 			name: "InvalidStorageIPPreferences",
 			cfg: &MeshOptions{
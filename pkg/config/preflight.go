@@ -0,0 +1,299 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+	"github.com/webmeshproj/webmesh/pkg/version"
+)
+
+// wireguardOverhead is the number of bytes WireGuard adds to each packet when
+// tunneling over IPv6, the larger of the two outer headers.
+const wireguardOverhead = 80
+
+// PreflightCheck is the result of a single preflight check.
+type PreflightCheck struct {
+	// Name is the name of the check.
+	Name string `json:"name"`
+	// Error is why the check failed, empty if it passed.
+	Error string `json:"error,omitempty"`
+	// Warning is true if the failure does not prevent the node from
+	// starting.
+	Warning bool `json:"warning,omitempty"`
+	// Hint is what can be done to fix the failure.
+	Hint string `json:"hint,omitempty"`
+}
+
+// Passed returns true if the check passed.
+func (c PreflightCheck) Passed() bool {
+	return c.Error == ""
+}
+
+// PreflightReport is the result of every preflight check.
+type PreflightReport []PreflightCheck
+
+// Err returns an error describing every failed check that is not a warning,
+// or nil if there are none.
+func (r PreflightReport) Err() error {
+	var errs []error
+	for _, check := range r {
+		if check.Passed() || check.Warning {
+			continue
+		}
+		err := fmt.Errorf("preflight %s: %s", check.Name, check.Error)
+		if check.Hint != "" {
+			err = fmt.Errorf("%w (%s)", err, check.Hint)
+		}
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// WriteTo writes a human readable report to the given writer.
+func (r PreflightReport) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	for _, check := range r {
+		status := "ok"
+		switch {
+		case check.Passed():
+		case check.Warning:
+			status = "warn"
+		default:
+			status = "FAIL"
+		}
+		fmt.Fprintf(&sb, "[%4s] %s", status, check.Name)
+		if !check.Passed() {
+			fmt.Fprintf(&sb, ": %s", check.Error)
+			if check.Hint != "" {
+				fmt.Fprintf(&sb, "\n       %s", check.Hint)
+			}
+		}
+		sb.WriteString("\n")
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// RunPreflight verifies that the system can run a node with the current
+// configuration. Every check is run and reported, so that all problems can be
+// fixed at once instead of failing on the first one while the node starts.
+func (o *Config) RunPreflight(ctx context.Context) PreflightReport {
+	report := PreflightReport{
+		o.preflightCheck("state-root", o.Preflight, "set global.state-root to a writable directory or move the listed paths beneath it"),
+		o.preflightCheck("clock", preflightClock, "synchronize the system clock, e.g. with NTP"),
+	}
+	if o.IsStorageMember() && !o.Storage.InMemory && (o.Storage.Provider == string(StorageProviderRaft) || o.Storage.Provider == "") {
+		report = append(report, o.preflightCheck("data-dir", func() error {
+			return preflightWritableDir(o.Storage.Path)
+		}, "fix the permissions of storage.path or run the node as its owner"))
+	}
+	if !o.WireGuard.ForceTUN && runtime.GOOS == "linux" {
+		check := o.preflightCheck("kernel-module", preflightWireGuardModule, "load the wireguard module with 'modprobe wireguard', the slower userspace implementation is used otherwise")
+		check.Warning = true
+		report = append(report, check)
+	}
+	report = append(report, o.preflightCheck("interface", o.preflightInterface, "remove the interface, choose another wireguard.interface-name, or set wireguard.force-interface-name"))
+	report = append(report, o.preflightCheck("ports", o.preflightPorts, "stop the process using the port or configure another listen address"))
+	mtu := o.preflightCheck("mtu", func() error { return o.preflightMTU(ctx) }, "")
+	mtu.Warning = true
+	if !mtu.Passed() {
+		mtu.Hint = fmt.Sprintf("lower wireguard.mtu to at most the MTU of the default route minus %d bytes", wireguardOverhead)
+	}
+	return append(report, mtu)
+}
+
+func (o *Config) preflightCheck(name string, fn func() error, hint string) PreflightCheck {
+	check := PreflightCheck{Name: name}
+	if err := fn(); err != nil {
+		check.Error = err.Error()
+		check.Hint = hint
+	}
+	return check
+}
+
+// preflightClock verifies the system clock is not behind the build date of
+// the binary, which breaks certificate validation and raft timestamps.
+func preflightClock() error {
+	built, err := time.Parse(time.RFC3339, version.BuildDate)
+	if err != nil {
+		// Development builds do not have a build date.
+		return nil
+	}
+	if now := time.Now(); now.Before(built) {
+		return fmt.Errorf("system time %s is before the build date %s", now.UTC().Format(time.RFC3339), version.BuildDate)
+	}
+	return nil
+}
+
+// preflightWritableDir verifies the given directory, or the closest parent
+// that exists, is a writable directory.
+func preflightWritableDir(path string) error {
+	dir := path
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return fmt.Errorf("no parent of %s exists", path)
+		}
+		dir = parent
+	}
+	probe, err := os.CreateTemp(dir, ".webmesh-preflight-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+// preflightWireGuardModule verifies the wireguard kernel module is loaded or
+// can be loaded.
+func preflightWireGuardModule() error {
+	if _, err := os.Stat("/sys/module/wireguard"); err == nil {
+		return nil
+	}
+	release, err := os.ReadFile("/proc/sys/kernel/osrelease")
+	if err == nil {
+		modules := filepath.Join("/lib/modules", strings.TrimSpace(string(release)))
+		matches, _ := filepath.Glob(filepath.Join(modules, "kernel/drivers/net/wireguard/wireguard.ko*"))
+		if len(matches) > 0 {
+			return nil
+		}
+		builtin, err := os.ReadFile(filepath.Join(modules, "modules.builtin"))
+		if err == nil && strings.Contains(string(builtin), "wireguard.ko") {
+			return nil
+		}
+	}
+	return fmt.Errorf("the wireguard kernel module is not loaded or available")
+}
+
+// preflightInterface verifies no interface with the configured name exists
+// and that no interface has an address in the networks being bootstrapped.
+func (o *Config) preflightInterface() error {
+	var errs []error
+	name := o.WireGuard.InterfaceName
+	if !o.WireGuard.ForceInterfaceName && name != "" && !strings.HasSuffix(name, "+") {
+		if _, err := net.InterfaceByName(name); err == nil {
+			errs = append(errs, fmt.Errorf("interface %s already exists", name))
+		}
+	}
+	var networks []netip.Prefix
+	if o.Bootstrap.Enabled {
+		for _, network := range []string{o.Bootstrap.IPv4Network, o.Bootstrap.IPv6Network} {
+			if prefix, err := netip.ParsePrefix(network); err == nil {
+				networks = append(networks, prefix)
+			}
+		}
+	}
+	if len(networks) > 0 {
+		ifaces, err := net.Interfaces()
+		if err != nil {
+			return errors.Join(append(errs, fmt.Errorf("list interfaces: %w", err))...)
+		}
+		for _, iface := range ifaces {
+			if iface.Name == name {
+				continue
+			}
+			addrs, err := iface.Addrs()
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				prefix, err := netip.ParsePrefix(addr.String())
+				if err != nil {
+					continue
+				}
+				for _, network := range networks {
+					if network.Overlaps(prefix.Masked()) && !prefix.Addr().IsLinkLocalUnicast() {
+						errs = append(errs, fmt.Errorf("interface %s has address %s in the mesh network %s", iface.Name, prefix, network))
+					}
+				}
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// preflightPorts verifies the ports the node listens on are free.
+func (o *Config) preflightPorts() error {
+	var errs []error
+	if o.WireGuard.ListenPort > 0 {
+		conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", o.WireGuard.ListenPort))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("wireguard port %d: %w", o.WireGuard.ListenPort, err))
+		} else {
+			conn.Close()
+		}
+	}
+	var addrs []string
+	if !o.Services.API.Disabled && o.Services.API.ListenAddress != "" {
+		addrs = append(addrs, o.Services.API.ListenAddress)
+	}
+	if o.IsStorageMember() && (o.Storage.Provider == string(StorageProviderRaft) || o.Storage.Provider == "") && o.Storage.Raft.ListenAddress != "" {
+		addrs = append(addrs, o.Storage.Raft.ListenAddress)
+	}
+	for _, addr := range addrs {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("listen address %s: %w", addr, err))
+			continue
+		}
+		ln.Close()
+	}
+	return errors.Join(errs...)
+}
+
+// preflightMTU verifies the packets sent over the WireGuard interface fit in
+// the MTU of the interface holding the default route.
+func (o *Config) preflightMTU(ctx context.Context) error {
+	if o.WireGuard.MTU <= 0 {
+		return nil
+	}
+	gw, err := routes.GetDefaultGateway(ctx)
+	if err != nil || gw.Name == "" {
+		// Nodes without a default route only reach peers on local networks.
+		return nil
+	}
+	iface, err := net.InterfaceByName(gw.Name)
+	if err != nil {
+		return fmt.Errorf("get default route interface %s: %w", gw.Name, err)
+	}
+	if o.WireGuard.MTU+wireguardOverhead > iface.MTU {
+		return fmt.Errorf("wireguard mtu %d plus %d bytes of overhead exceeds the mtu %d of the default route interface %s", o.WireGuard.MTU, wireguardOverhead, iface.MTU, gw.Name)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestPreflight(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ReportsEveryFailure", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		file := filepath.Join(t.TempDir(), "file")
+		if err := os.WriteFile(file, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		conf := NewDefaultConfig("node")
+		conf.Mesh.RequestVote = true
+		conf.Storage.Path = filepath.Join(file, "data")
+		conf.WireGuard.ListenPort = 0
+		conf.Services.API.ListenAddress = ln.Addr().String()
+		conf.Storage.Raft.ListenAddress = "127.0.0.1:0"
+		report := conf.RunPreflight(context.Background())
+		failed := make(map[string]bool)
+		for _, check := range report {
+			if !check.Passed() && !check.Warning {
+				failed[check.Name] = true
+			}
+		}
+		for _, name := range []string{"data-dir", "ports"} {
+			if !failed[name] {
+				t.Errorf("expected %s check to fail, got %+v", name, report)
+			}
+		}
+		err = report.Err()
+		if err == nil {
+			t.Fatal("expected preflight to fail")
+		}
+		for _, want := range []string{"preflight data-dir", "preflight ports", ln.Addr().String()} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("expected error to contain %q, got %v", want, err)
+			}
+		}
+	})
+
+	t.Run("WarningsDoNotFail", func(t *testing.T) {
+		report := PreflightReport{
+			{Name: "passed"},
+			{Name: "warning", Error: "degraded", Warning: true},
+		}
+		if err := report.Err(); err != nil {
+			t.Errorf("expected warnings not to fail preflight, got %v", err)
+		}
+		var sb strings.Builder
+		if _, err := report.WriteTo(&sb); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(sb.String(), "[warn] warning: degraded") {
+			t.Errorf("expected warning in report, got %q", sb.String())
+		}
+	})
+}
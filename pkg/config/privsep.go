@@ -0,0 +1,95 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"errors"
+	"runtime"
+
+	"github.com/spf13/pflag"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/privsep"
+)
+
+// DefaultPrivSepSocket is the default socket for the privilege separation helper.
+const DefaultPrivSepSocket = "/var/run/webmesh/privsep.sock"
+
+// PrivSepOptions are options for running privileged system operations in a
+// separate helper process.
+type PrivSepOptions struct {
+	// Enabled runs interface, route, firewall, and DNS changes in a helper process.
+	Enabled bool `koanf:"enabled,omitempty"`
+	// Socket is the unix socket used to communicate with the helper.
+	Socket string `koanf:"socket,omitempty"`
+	// User is the user to drop privileges to after startup. If empty,
+	// the node process keeps its privileges.
+	User string `koanf:"user,omitempty"`
+	// Group is the group to drop privileges to after startup. Defaults
+	// to the primary group of User.
+	Group string `koanf:"group,omitempty"`
+
+	// ops is the connection to the helper once it is running.
+	ops privsep.Ops `koanf:"-"`
+}
+
+// NewPrivSepOptions returns new privilege separation options with the default values.
+func NewPrivSepOptions() PrivSepOptions {
+	return PrivSepOptions{
+		Enabled: false,
+		Socket:  DefaultPrivSepSocket,
+	}
+}
+
+// BindFlags binds the privilege separation options to the flag set.
+func (o *PrivSepOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
+	fs.BoolVar(&o.Enabled, prefix+"enabled", o.Enabled, "Run privileged system operations in a separate helper process.")
+	fs.StringVar(&o.Socket, prefix+"socket", o.Socket, "Unix socket for communicating with the privilege separation helper.")
+	fs.StringVar(&o.User, prefix+"user", o.User, "User to drop privileges to after startup.")
+	fs.StringVar(&o.Group, prefix+"group", o.Group, "Group to drop privileges to after startup. Defaults to the user's primary group.")
+}
+
+// Validate validates the privilege separation options.
+func (o *PrivSepOptions) Validate() error {
+	if !o.Enabled {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		return errors.New("privsep is not supported on windows")
+	}
+	if o.Socket == "" {
+		return errors.New("privsep.socket must be set when privsep is enabled")
+	}
+	if o.Group != "" && o.User == "" {
+		return errors.New("privsep.user must be set when privsep.group is set")
+	}
+	return nil
+}
+
+// Ops returns the operations to use for system changes. It is nil unless
+// the helper has been started and connected with SetSystemOps.
+func (o *PrivSepOptions) Ops() privsep.Ops {
+	return o.ops
+}
+
+// SetSystemOps sets the operations to use for system changes on this
+// configuration and all bridged meshes.
+func (o *Config) SetSystemOps(ops privsep.Ops) {
+	o.PrivSep.ops = ops
+	for _, bridged := range o.Bridge.Meshes {
+		bridged.SetSystemOps(ops)
+	}
+}
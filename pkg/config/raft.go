@@ -17,9 +17,11 @@ limitations under the License.
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"net"
 	"net/netip"
+	"os"
 	"time"
 
 	"github.com/spf13/pflag"
@@ -28,12 +30,18 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/s3snapshots"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/wal"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/shardedstorage"
 )
 
 // RaftOptions are options for the raft backend.
 type RaftOptions struct {
 	// ListenAddress is the address to listen on.
 	ListenAddress string `koanf:"listen-address,omitempty"`
+	// Listener are options for filtering and limiting raft connections.
+	Listener ListenerOptions `koanf:"listener,omitempty"`
 	// ConnectionPoolCount is the number of connections to pool. If 0, no connection pooling is used.
 	ConnectionPoolCount int `koanf:"connection-pool-count,omitempty"`
 	// ConnectionTimeout is the timeout for connections.
@@ -50,6 +58,28 @@ type RaftOptions struct {
 	MaxAppendEntries int `koanf:"max-append-entries,omitempty"`
 	// LeaderLeaseTimeout is the timeout for leader leases.
 	LeaderLeaseTimeout time.Duration `koanf:"leader-lease-timeout,omitempty"`
+	// ElectionMultiplier scales the heartbeat, election, and leader lease timeouts.
+	// Raise it on high-latency networks to reduce spurious elections.
+	ElectionMultiplier int `koanf:"election-multiplier,omitempty"`
+	// StableLeader raises the heartbeat and election timeouts at runtime when contact
+	// with the leader is observed to be slow, as is common on WAN meshes.
+	StableLeader bool `koanf:"stable-leader,omitempty"`
+	// StableLeaderMaxMultiplier is the largest multiplier stable leader mode raises
+	// the timeouts to.
+	StableLeaderMaxMultiplier int `koanf:"stable-leader-max-multiplier,omitempty"`
+	// TargetVoters is the number of voters the leader maintains by promoting eligible
+	// observers and demoting flaky voters. Zero disables automatic promotion.
+	TargetVoters int `koanf:"target-voters,omitempty"`
+	// VoterPolicyInterval is how often the leader reconciles the voters.
+	VoterPolicyInterval time.Duration `koanf:"voter-policy-interval,omitempty"`
+	// FlakyVoterTimeout is how long the leader must fail to heartbeat a voter
+	// before it is demoted.
+	FlakyVoterTimeout time.Duration `koanf:"flaky-voter-timeout,omitempty"`
+	// Shards is the number of raft groups the registry is partitioned across. Shard i
+	// listens on the port of the listen address plus i. The default of 1 runs a single
+	// raft group. Every storage member must use the same value, and it cannot be changed
+	// once the cluster is bootstrapped.
+	Shards int `koanf:"shards,omitempty"`
 	// SnapshotInterval is the interval to take snapshots.
 	SnapshotInterval time.Duration `koanf:"snapshot-interval,omitempty"`
 	// SnapshotThreshold is the threshold to take snapshots.
@@ -60,25 +90,122 @@ type RaftOptions struct {
 	ObserverChanBuffer int `koanf:"observer-chan-buffer,omitempty"`
 	// HeartbeatPurgeThreshold is the threshold of failed heartbeats before purging a peer.
 	HeartbeatPurgeThreshold int `koanf:"heartbeat-purge-threshold,omitempty"`
+	// ScrubInterval is the interval to verify the integrity of the local log store and snapshots.
+	// Scrubbing is disabled when 0, which is the default.
+	ScrubInterval time.Duration `koanf:"scrub-interval,omitempty"`
+	// ReadCacheSize is the number of keys and prefixes to keep in a read cache in front
+	// of the local storage. Set to 0 to disable the cache.
+	ReadCacheSize int `koanf:"read-cache-size,omitempty"`
+	// ReadCacheTTL is the maximum time an entry is served from the read cache.
+	ReadCacheTTL time.Duration `koanf:"read-cache-ttl,omitempty"`
+	// GraphSnapshotInterval is the interval at which the leader refreshes the graph snapshot
+	// stored alongside the registry. Set to 0 to disable graph snapshots.
+	GraphSnapshotInterval time.Duration `koanf:"graph-snapshot-interval,omitempty"`
+	// CheckInvariants checks the referential integrity of the mesh database after every
+	// applied log. It can be "log" or "panic". Leave empty to disable.
+	CheckInvariants string `koanf:"check-invariants,omitempty"`
+	// LogFormat is the format commands are encoded in in the raft log. It can be
+	// "protobuf+snappy" or "protobuf+zstd". Every storage member must use the same
+	// format, and nodes with a different one are refused when they join.
+	LogFormat string `koanf:"log-format,omitempty"`
+	// LogCompressionLevel is the zstd compression level for the protobuf+zstd log format.
+	LogCompressionLevel int `koanf:"log-compression-level,omitempty"`
+	// LogStore is where raft logs and stable state are stored. "shared" keeps
+	// them with the mesh state, "badger" uses a dedicated database, and "wal"
+	// uses a segmented write-ahead log. Logs are migrated on startup when this
+	// changes.
+	LogStore string `koanf:"log-store,omitempty"`
+	// WALSegmentSize is the size in bytes at which write-ahead log segments are rotated.
+	WALSegmentSize int64 `koanf:"wal-segment-size,omitempty"`
+	// WALSync is when write-ahead log appends are flushed to disk. It can be
+	// "always", "interval", or "never".
+	WALSync string `koanf:"wal-sync,omitempty"`
+	// WALSyncInterval is how often write-ahead log appends are flushed with the interval sync policy.
+	WALSyncInterval time.Duration `koanf:"wal-sync-interval,omitempty"`
+	// IncrementalSnapshots is the number of incremental snapshots taken between full snapshots.
+	// It must be less than the snapshot retention. Set to 0 to always take full snapshots.
+	IncrementalSnapshots int `koanf:"incremental-snapshots,omitempty"`
+	// SnapshotS3 are options for storing snapshots in an S3 compatible object store.
+	SnapshotS3 RaftSnapshotS3Options `koanf:"snapshot-s3,omitempty"`
+	// SnapshotExport are options for periodically exporting snapshots for backups.
+	SnapshotExport RaftSnapshotExportOptions `koanf:"snapshot-export,omitempty"`
+	// SnapshotEncryptionKey is a key shared by all nodes used to encrypt snapshots. Snapshots
+	// are also signed with the node key. Leave empty to store snapshots in cleartext.
+	SnapshotEncryptionKey string `koanf:"snapshot-encryption-key,omitempty"`
+	// SnapshotEncryptionKeyFile is a file containing the snapshot encryption key.
+	SnapshotEncryptionKeyFile string `koanf:"snapshot-encryption-key-file,omitempty"`
+}
+
+// RaftSnapshotS3Options are options for storing raft snapshots in an S3 compatible
+// object store instead of the data directory.
+type RaftSnapshotS3Options struct {
+	// Endpoint is the host and optional port of the object store. Snapshots are
+	// stored in the data directory when unset.
+	Endpoint string `koanf:"endpoint,omitempty"`
+	// Bucket is the bucket to store snapshots in.
+	Bucket string `koanf:"bucket,omitempty"`
+	// Prefix is the prefix of the objects holding snapshots.
+	Prefix string `koanf:"prefix,omitempty"`
+	// Region is the region of the bucket.
+	Region string `koanf:"region,omitempty"`
+	// AccessKeyID is the access key ID to use. Read from the environment if unset.
+	AccessKeyID string `koanf:"access-key-id,omitempty"`
+	// SecretAccessKey is the secret access key to use. Read from the environment if unset.
+	SecretAccessKey string `koanf:"secret-access-key,omitempty"`
+	// Insecure disables TLS when talking to the object store.
+	Insecure bool `koanf:"insecure,omitempty"`
+}
+
+// RaftSnapshotExportOptions are options for periodically exporting full snapshots
+// from the leader for point-in-time backups.
+type RaftSnapshotExportOptions struct {
+	// Interval is how often a snapshot is exported. Set to 0 to disable exports.
+	Interval time.Duration `koanf:"interval,omitempty"`
+	// Dir is the directory to export snapshots to.
+	Dir string `koanf:"dir,omitempty"`
+	// Retain is the number of exported snapshots to keep.
+	Retain int `koanf:"retain,omitempty"`
+	// S3 are options for exporting snapshots to an S3 compatible object store instead of Dir.
+	S3 RaftSnapshotS3Options `koanf:"s3,omitempty"`
 }
 
 // NewRaftOptions returns a new RaftOptions with the default values.
 func NewRaftOptions() RaftOptions {
 	return RaftOptions{
-		ListenAddress:           raftstorage.DefaultListenAddress,
-		ConnectionPoolCount:     0,
-		ConnectionTimeout:       3 * time.Second,
-		HeartbeatTimeout:        time.Second * 2,
-		ElectionTimeout:         time.Second * 2,
-		ApplyTimeout:            10 * time.Second,
-		CommitTimeout:           10 * time.Second,
-		MaxAppendEntries:        64,
-		LeaderLeaseTimeout:      time.Second * 2,
-		SnapshotInterval:        30 * time.Second,
-		SnapshotThreshold:       8192,
-		SnapshotRetention:       2,
-		ObserverChanBuffer:      100,
-		HeartbeatPurgeThreshold: 25,
+		ListenAddress:             raftstorage.DefaultListenAddress,
+		ConnectionPoolCount:       0,
+		ConnectionTimeout:         3 * time.Second,
+		HeartbeatTimeout:          time.Second * 2,
+		ElectionTimeout:           time.Second * 2,
+		ApplyTimeout:              10 * time.Second,
+		CommitTimeout:             10 * time.Second,
+		MaxAppendEntries:          64,
+		LeaderLeaseTimeout:        time.Second * 2,
+		ElectionMultiplier:        1,
+		StableLeader:              false,
+		StableLeaderMaxMultiplier: raftstorage.DefaultStableLeaderMaxMultiplier,
+		TargetVoters:              0,
+		VoterPolicyInterval:       raftstorage.DefaultVoterPolicyInterval,
+		FlakyVoterTimeout:         raftstorage.DefaultFlakyVoterTimeout,
+		Shards:                    1,
+		SnapshotInterval:          30 * time.Second,
+		SnapshotThreshold:         8192,
+		SnapshotRetention:         2,
+		ObserverChanBuffer:        100,
+		HeartbeatPurgeThreshold:   25,
+		ScrubInterval:             raftstorage.DefaultScrubInterval,
+		ReadCacheSize:             0,
+		ReadCacheTTL:              raftstorage.DefaultReadCacheTTL,
+		GraphSnapshotInterval:     raftstorage.DefaultGraphSnapshotInterval,
+		LogFormat:                 string(fsm.LogFormatProtobufSnappy),
+		LogCompressionLevel:       fsm.DefaultLogCompressionLevel,
+		LogStore:                  string(raftstorage.LogStoreShared),
+		WALSegmentSize:            wal.DefaultSegmentSize,
+		WALSync:                   string(wal.SyncAlways),
+		WALSyncInterval:           wal.DefaultSyncInterval,
+		SnapshotExport: RaftSnapshotExportOptions{
+			Retain: raftstorage.DefaultSnapshotExportRetain,
+		},
 	}
 }
 
@@ -93,11 +220,112 @@ func (o *RaftOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.DurationVar(&o.CommitTimeout, prefix+"commit-timeout", o.CommitTimeout, "Raft commit timeout.")
 	fs.IntVar(&o.MaxAppendEntries, prefix+"max-append-entries", o.MaxAppendEntries, "Raft max append entries.")
 	fs.DurationVar(&o.LeaderLeaseTimeout, prefix+"leader-lease-timeout", o.LeaderLeaseTimeout, "Raft leader lease timeout.")
+	fs.IntVar(&o.ElectionMultiplier, prefix+"election-multiplier", o.ElectionMultiplier, "Multiplier for the raft heartbeat, election, and leader lease timeouts. Raise on high-latency networks.")
+	fs.BoolVar(&o.StableLeader, prefix+"stable-leader", o.StableLeader, "Raise raft election timeouts at runtime when contact with the leader is slow.")
+	fs.IntVar(&o.StableLeaderMaxMultiplier, prefix+"stable-leader-max-multiplier", o.StableLeaderMaxMultiplier, "Largest multiplier stable leader mode raises raft election timeouts to.")
+	fs.IntVar(&o.TargetVoters, prefix+"target-voters", o.TargetVoters, "Number of voters to maintain by promoting eligible observers and demoting flaky voters. Zero disables automatic promotion.")
+	fs.DurationVar(&o.VoterPolicyInterval, prefix+"voter-policy-interval", o.VoterPolicyInterval, "Interval the leader reconciles voters at when a target number of voters is set.")
+	fs.DurationVar(&o.FlakyVoterTimeout, prefix+"flaky-voter-timeout", o.FlakyVoterTimeout, "Time the leader must fail to heartbeat a voter before it is demoted.")
+	fs.IntVar(&o.Shards, prefix+"shards", o.Shards, "Number of raft groups to partition the registry across. Shard i listens on the raft port plus i.")
 	fs.DurationVar(&o.SnapshotInterval, prefix+"snapshot-interval", o.SnapshotInterval, "Raft snapshot interval.")
 	fs.Uint64Var(&o.SnapshotThreshold, prefix+"snapshot-threshold", o.SnapshotThreshold, "Raft snapshot threshold.")
 	fs.Uint64Var(&o.SnapshotRetention, prefix+"snapshot-retention", o.SnapshotRetention, "Raft snapshot retention.")
 	fs.IntVar(&o.ObserverChanBuffer, prefix+"observer-chan-buffer", o.ObserverChanBuffer, "Raft observer channel buffer.")
 	fs.IntVar(&o.HeartbeatPurgeThreshold, prefix+"heartbeat-purge-threshold", o.HeartbeatPurgeThreshold, "Raft heartbeat purge threshold.")
+	fs.DurationVar(&o.ScrubInterval, prefix+"scrub-interval", o.ScrubInterval, "Interval to verify the integrity of the raft log and snapshots. Set to 0 to disable.")
+	fs.IntVar(&o.ReadCacheSize, prefix+"read-cache-size", o.ReadCacheSize, "Number of keys and prefixes to keep in the storage read cache. Set to 0 to disable.")
+	fs.DurationVar(&o.ReadCacheTTL, prefix+"read-cache-ttl", o.ReadCacheTTL, "Maximum time an entry is served from the storage read cache.")
+	fs.DurationVar(&o.GraphSnapshotInterval, prefix+"graph-snapshot-interval", o.GraphSnapshotInterval, "Interval to refresh the compact graph snapshot stored alongside the registry. Set to 0 to disable.")
+	fs.StringVar(&o.CheckInvariants, prefix+"check-invariants", o.CheckInvariants, "Check mesh database invariants after every applied log and \"log\" or \"panic\" on violations. For debugging only.")
+	fs.StringVar(&o.LogFormat, prefix+"log-format", o.LogFormat, "Format to encode commands in the raft log with. One of \"protobuf+snappy\" or \"protobuf+zstd\". Must match on every storage member.")
+	fs.IntVar(&o.LogCompressionLevel, prefix+"log-compression-level", o.LogCompressionLevel, "Zstd compression level for the protobuf+zstd raft log format.")
+	fs.StringVar(&o.LogStore, prefix+"log-store", o.LogStore, "Where to store raft logs. One of \"shared\" to store them with the mesh state, \"badger\" for a dedicated database, or \"wal\" for a segmented write-ahead log. Logs are migrated on startup when changed.")
+	fs.Int64Var(&o.WALSegmentSize, prefix+"wal-segment-size", o.WALSegmentSize, "Size in bytes at which raft write-ahead log segments are rotated.")
+	fs.StringVar(&o.WALSync, prefix+"wal-sync", o.WALSync, "When raft write-ahead log appends are flushed to disk. One of \"always\", \"interval\", or \"never\". Anything but \"always\" can lose recent appends on a crash.")
+	fs.DurationVar(&o.WALSyncInterval, prefix+"wal-sync-interval", o.WALSyncInterval, "Interval raft write-ahead log appends are flushed at with the \"interval\" sync policy.")
+	fs.IntVar(&o.IncrementalSnapshots, prefix+"incremental-snapshots", o.IncrementalSnapshots, "Number of incremental snapshots, recording only changed keys, to take between full snapshots. Must be less than the snapshot retention. Set to 0 to disable.")
+	fs.StringVar(&o.SnapshotEncryptionKey, prefix+"snapshot-encryption-key", o.SnapshotEncryptionKey, "Key shared by all nodes to encrypt raft snapshots with. Snapshots are also signed with the node key and unsealed snapshots are rejected.")
+	fs.StringVar(&o.SnapshotEncryptionKeyFile, prefix+"snapshot-encryption-key-file", o.SnapshotEncryptionKeyFile, "File containing the key to encrypt raft snapshots with.")
+	o.SnapshotS3.BindFlags(prefix+"snapshot-s3.", fs)
+	o.SnapshotExport.BindFlags(prefix+"snapshot-export.", fs)
+	o.Listener.BindFlags(prefix+"listener.", fs)
+}
+
+// BindFlags binds the flags.
+func (o *RaftSnapshotExportOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
+	fs.DurationVar(&o.Interval, prefix+"interval", o.Interval, "Interval to export a full raft snapshot from the leader for backups. Set to 0 to disable.")
+	fs.StringVar(&o.Dir, prefix+"dir", o.Dir, "Directory to export raft snapshots to.")
+	fs.IntVar(&o.Retain, prefix+"retain", o.Retain, "Number of exported raft snapshots to keep.")
+	o.S3.BindFlags(prefix+"s3.", fs)
+}
+
+// NewOptions returns the snapshot export options, or nil if exports are disabled.
+func (o RaftSnapshotExportOptions) NewOptions() *raftstorage.SnapshotExportOptions {
+	if o.Interval == 0 {
+		return nil
+	}
+	return &raftstorage.SnapshotExportOptions{
+		Interval: o.Interval,
+		Dir:      o.Dir,
+		S3:       o.S3.NewOptions(),
+		Retain:   o.Retain,
+	}
+}
+
+// Validate validates the options.
+func (o RaftSnapshotExportOptions) Validate() error {
+	if o.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	if o.Interval == 0 {
+		return nil
+	}
+	if o.Retain < 0 {
+		return fmt.Errorf("retain must not be negative")
+	}
+	if s3opts := o.S3.NewOptions(); s3opts != nil {
+		if err := s3opts.Validate(); err != nil {
+			return fmt.Errorf("s3 is invalid: %w", err)
+		}
+		return nil
+	}
+	if o.Dir == "" {
+		return fmt.Errorf("dir or s3.endpoint is required")
+	}
+	return nil
+}
+
+// BindFlags binds the flags.
+func (o *RaftSnapshotS3Options) BindFlags(prefix string, fs *pflag.FlagSet) {
+	fs.StringVar(&o.Endpoint, prefix+"endpoint", o.Endpoint, "Host and optional port of an S3 compatible object store to keep raft snapshots in instead of the data directory.")
+	fs.StringVar(&o.Bucket, prefix+"bucket", o.Bucket, "Bucket to keep raft snapshots in.")
+	fs.StringVar(&o.Prefix, prefix+"prefix", o.Prefix, "Prefix of the objects holding raft snapshots.")
+	fs.StringVar(&o.Region, prefix+"region", o.Region, "Region of the snapshot bucket.")
+	fs.StringVar(&o.AccessKeyID, prefix+"access-key-id", o.AccessKeyID, "Access key ID for the object store. Read from the environment if unset.")
+	fs.StringVar(&o.SecretAccessKey, prefix+"secret-access-key", o.SecretAccessKey, "Secret access key for the object store. Read from the environment if unset.")
+	fs.BoolVar(&o.Insecure, prefix+"insecure", o.Insecure, "Disable TLS when talking to the object store.")
+}
+
+// IsEmpty returns true if snapshots are not stored in an object store.
+func (o RaftSnapshotS3Options) IsEmpty() bool {
+	return o.Endpoint == ""
+}
+
+// NewOptions returns the snapshot store options, or nil if snapshots are not
+// stored in an object store.
+func (o RaftSnapshotS3Options) NewOptions() *s3snapshots.Options {
+	if o.IsEmpty() {
+		return nil
+	}
+	return &s3snapshots.Options{
+		Endpoint:        o.Endpoint,
+		Bucket:          o.Bucket,
+		Prefix:          o.Prefix,
+		Region:          o.Region,
+		AccessKeyID:     o.AccessKeyID,
+		SecretAccessKey: o.SecretAccessKey,
+		Insecure:        o.Insecure,
+	}
 }
 
 // Validate validates the options.
@@ -109,18 +337,149 @@ func (o RaftOptions) Validate(dataDir string, inMemory bool) error {
 	if err != nil {
 		return fmt.Errorf("raft.listen-address is invalid: %w", err)
 	}
+	if err := o.Listener.Validate(); err != nil {
+		return fmt.Errorf("raft.listener is invalid: %w", err)
+	}
+	if o.ElectionMultiplier < 0 {
+		return fmt.Errorf("raft.election-multiplier must not be negative")
+	}
+	if o.StableLeader && o.StableLeaderMaxMultiplier < 1 {
+		return fmt.Errorf("raft.stable-leader-max-multiplier must be at least 1")
+	}
+	if o.TargetVoters < 0 {
+		return fmt.Errorf("raft.target-voters must not be negative")
+	}
+	if o.TargetVoters > 0 {
+		if o.VoterPolicyInterval <= 0 {
+			return fmt.Errorf("raft.voter-policy-interval must be greater than 0")
+		}
+		if o.FlakyVoterTimeout <= 0 {
+			return fmt.Errorf("raft.flaky-voter-timeout must be greater than 0")
+		}
+		if o.Shards > 1 {
+			return fmt.Errorf("raft.target-voters is not supported with raft.shards")
+		}
+	}
+	if o.Shards < 0 {
+		return fmt.Errorf("raft.shards must not be negative")
+	}
+	if o.Shards > 1 {
+		if _, err := shardedstorage.ShardAddress(o.ListenAddress, o.Shards-1); err != nil {
+			return fmt.Errorf("raft.shards is invalid for raft.listen-address: %w", err)
+		}
+	}
+	if o.ScrubInterval < 0 {
+		return fmt.Errorf("raft.scrub-interval must not be negative")
+	}
+	if o.ReadCacheSize < 0 {
+		return fmt.Errorf("raft.read-cache-size must not be negative")
+	}
+	if o.ReadCacheTTL < 0 {
+		return fmt.Errorf("raft.read-cache-ttl must not be negative")
+	}
+	if o.GraphSnapshotInterval < 0 {
+		return fmt.Errorf("raft.graph-snapshot-interval must not be negative")
+	}
+	if err := fsm.InvariantMode(o.CheckInvariants).Validate(); err != nil {
+		return fmt.Errorf("raft.check-invariants is invalid: %w", err)
+	}
+	if err := fsm.LogFormat(o.LogFormat).Validate(); err != nil {
+		return fmt.Errorf("raft.log-format is invalid: %w", err)
+	}
+	if fsm.LogFormat(o.LogFormat) == fsm.LogFormatProtobufZstd {
+		if err := fsm.ValidateLogCompressionLevel(o.LogCompressionLevel); err != nil {
+			return fmt.Errorf("raft.log-compression-level is invalid: %w", err)
+		}
+	}
+	if err := raftstorage.LogStore(o.LogStore).Validate(); err != nil {
+		return fmt.Errorf("raft.log-store is invalid: %w", err)
+	}
+	if err := o.WALOptions().Validate(); err != nil {
+		return fmt.Errorf("raft.wal is invalid: %w", err)
+	}
+	if o.IncrementalSnapshots < 0 {
+		return fmt.Errorf("raft.incremental-snapshots must not be negative")
+	}
+	if o.IncrementalSnapshots > 0 && uint64(o.IncrementalSnapshots) >= o.SnapshotRetention {
+		return fmt.Errorf("raft.incremental-snapshots must be less than raft.snapshot-retention")
+	}
+	if o.SnapshotEncryptionKey != "" && o.SnapshotEncryptionKeyFile != "" {
+		return fmt.Errorf("only one of raft.snapshot-encryption-key and raft.snapshot-encryption-key-file may be set")
+	}
+	if s3opts := o.SnapshotS3.NewOptions(); s3opts != nil {
+		if err := s3opts.Validate(); err != nil {
+			return fmt.Errorf("raft.snapshot-s3 is invalid: %w", err)
+		}
+	}
+	if err := o.SnapshotExport.Validate(); err != nil {
+		return fmt.Errorf("raft.snapshot-export is invalid: %w", err)
+	}
 	if !inMemory && dataDir == "" {
 		return fmt.Errorf("storage.data-dir is required when not running in-memory")
 	}
 	return nil
 }
 
+// WALOptions returns the options for the raft write-ahead log.
+func (o RaftOptions) WALOptions() wal.Options {
+	return wal.Options{
+		SegmentSize:  o.WALSegmentSize,
+		Sync:         wal.SyncPolicy(o.WALSync),
+		SyncInterval: o.WALSyncInterval,
+	}
+}
+
+// LoadSnapshotEncryptionKey returns the snapshot encryption key, reading it from
+// the key file if set. It returns nil if snapshot encryption is disabled.
+func (o RaftOptions) LoadSnapshotEncryptionKey() ([]byte, error) {
+	if o.SnapshotEncryptionKeyFile != "" {
+		data, err := os.ReadFile(o.SnapshotEncryptionKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load snapshot-encryption-key-file: %w", err)
+		}
+		key := bytes.TrimSpace(data)
+		if len(key) == 0 {
+			return nil, fmt.Errorf("snapshot-encryption-key-file is empty")
+		}
+		return key, nil
+	}
+	if o.SnapshotEncryptionKey != "" {
+		return []byte(o.SnapshotEncryptionKey), nil
+	}
+	return nil, nil
+}
+
 // NewTransport creates a new raft transport for the current configuration.
 func (o RaftOptions) NewTransport(conn meshnode.Node) (transport.RaftTransport, error) {
+	mws, err := o.Listener.Middlewares()
+	if err != nil {
+		return nil, err
+	}
+	return tcp.NewRaftTransport(conn, tcp.RaftTransportOptions{
+		Addr:        o.ListenAddress,
+		MaxPool:     o.ConnectionPoolCount,
+		Timeout:     o.ConnectionTimeout,
+		ClusterID:   conn.ClusterID,
+		Middlewares: mws,
+	})
+}
+
+// NewShardTransport returns a new raft transport for the given shard.
+func (o RaftOptions) NewShardTransport(conn meshnode.Node, shard int) (transport.RaftTransport, error) {
+	addr, err := shardedstorage.ShardAddress(o.ListenAddress, shard)
+	if err != nil {
+		return nil, err
+	}
+	mws, err := o.Listener.Middlewares()
+	if err != nil {
+		return nil, err
+	}
 	return tcp.NewRaftTransport(conn, tcp.RaftTransportOptions{
-		Addr:    o.ListenAddress,
-		MaxPool: o.ConnectionPoolCount,
-		Timeout: o.ConnectionTimeout,
+		Addr:        addr,
+		MaxPool:     o.ConnectionPoolCount,
+		Timeout:     o.ConnectionTimeout,
+		ClusterID:   conn.ClusterID,
+		Middlewares: mws,
 	})
 }
 
@@ -41,20 +41,34 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/logging"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/attach"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/rendezvous"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/datachannels"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/libp2p"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/plugins/builtins/idauth"
 	"github.com/webmeshproj/webmesh/pkg/services"
+	"github.com/webmeshproj/webmesh/pkg/services/accessreview"
 	"github.com/webmeshproj/webmesh/pkg/services/admin"
+	"github.com/webmeshproj/webmesh/pkg/services/cni"
+	"github.com/webmeshproj/webmesh/pkg/services/conntrack"
+	"github.com/webmeshproj/webmesh/pkg/services/consul"
+	"github.com/webmeshproj/webmesh/pkg/services/docker"
 	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/membership"
+	"github.com/webmeshproj/webmesh/pkg/services/meshadmin"
 	"github.com/webmeshproj/webmesh/pkg/services/meshapi"
 	"github.com/webmeshproj/webmesh/pkg/services/meshdns"
+	"github.com/webmeshproj/webmesh/pkg/services/metadata"
 	"github.com/webmeshproj/webmesh/pkg/services/metrics"
 	"github.com/webmeshproj/webmesh/pkg/services/node"
+	"github.com/webmeshproj/webmesh/pkg/services/peerconfig"
+	"github.com/webmeshproj/webmesh/pkg/services/policy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 	"github.com/webmeshproj/webmesh/pkg/services/registrar"
+	"github.com/webmeshproj/webmesh/pkg/services/sidecar"
 	"github.com/webmeshproj/webmesh/pkg/services/storage"
 	"github.com/webmeshproj/webmesh/pkg/services/turn"
 	"github.com/webmeshproj/webmesh/pkg/services/webrtc"
@@ -76,18 +90,46 @@ type ServiceOptions struct {
 	Registrar RegistrarOptions `koanf:"registrar,omitempty"`
 	// Metrics options
 	Metrics MetricsOptions `koanf:"metrics,omitempty"`
+	// Membership options
+	Membership MembershipOptions `koanf:"membership,omitempty"`
+	// Sidecar options
+	Sidecar SidecarOptions `koanf:"sidecar,omitempty"`
+	// Metadata options
+	Metadata MetadataOptions `koanf:"metadata,omitempty"`
+	// Docker options
+	Docker DockerOptions `koanf:"docker,omitempty"`
+	// CNI options
+	CNI CNIOptions `koanf:"cni,omitempty"`
+	// Consul options
+	Consul ConsulOptions `koanf:"consul,omitempty"`
+	// Interceptors are custom gRPC interceptors registered by applications
+	// embedding the node. They cannot be set from configuration files.
+	Interceptors services.Interceptors `koanf:"-"`
+	// CustomServices are user-defined gRPC services registered by applications
+	// embedding the node. They cannot be set from configuration files.
+	CustomServices []services.CustomService `koanf:"-"`
+	// KeyEscrow stores the wrapped identity keys of joining nodes. It
+	// defaults to the mesh database and cannot be set from configuration
+	// files.
+	KeyEscrow meshstorage.KeyEscrow `koanf:"-"`
 }
 
 // NewServiceOptions returns a new ServiceOptions with the default values.
 // Disabled sets the initial state of whether the gRPC API is enabled.
 func NewServiceOptions(disabled bool) ServiceOptions {
 	return ServiceOptions{
-		API:       NewAPIOptions(disabled),
-		WebRTC:    NewWebRTCOptions(),
-		MeshDNS:   NewMeshDNSOptions(),
-		TURN:      NewTURNOptions(),
-		Registrar: NewRegistrarOptions(),
-		Metrics:   NewMetricsOptions(),
+		API:        NewAPIOptions(disabled),
+		WebRTC:     NewWebRTCOptions(),
+		MeshDNS:    NewMeshDNSOptions(),
+		TURN:       NewTURNOptions(),
+		Registrar:  NewRegistrarOptions(),
+		Metrics:    NewMetricsOptions(),
+		Membership: NewMembershipOptions(),
+		Sidecar:    NewSidecarOptions(),
+		Metadata:   NewMetadataOptions(),
+		Docker:     NewDockerOptions(),
+		CNI:        NewCNIOptions(),
+		Consul:     NewConsulOptions(),
 	}
 }
 
@@ -96,12 +138,18 @@ func NewServiceOptions(disabled bool) ServiceOptions {
 // is enabled.
 func NewInsecureServiceOptions(disabled bool) ServiceOptions {
 	return ServiceOptions{
-		API:       NewInsecureAPIOptions(disabled),
-		WebRTC:    NewWebRTCOptions(),
-		MeshDNS:   NewMeshDNSOptions(),
-		TURN:      NewTURNOptions(),
-		Registrar: NewRegistrarOptions(),
-		Metrics:   NewMetricsOptions(),
+		API:        NewInsecureAPIOptions(disabled),
+		WebRTC:     NewWebRTCOptions(),
+		MeshDNS:    NewMeshDNSOptions(),
+		TURN:       NewTURNOptions(),
+		Registrar:  NewRegistrarOptions(),
+		Metrics:    NewMetricsOptions(),
+		Membership: NewMembershipOptions(),
+		Sidecar:    NewSidecarOptions(),
+		Metadata:   NewMetadataOptions(),
+		Docker:     NewDockerOptions(),
+		CNI:        NewCNIOptions(),
+		Consul:     NewConsulOptions(),
 	}
 }
 
@@ -112,6 +160,12 @@ func (s *ServiceOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	s.TURN.BindFlags(prefix+"turn.", fl)
 	s.Registrar.BindFlags(prefix+"registrar.", fl)
 	s.Metrics.BindFlags(prefix+"metrics.", fl)
+	s.Membership.BindFlags(prefix+"membership.", fl)
+	s.Sidecar.BindFlags(prefix+"sidecar.", fl)
+	s.Metadata.BindFlags(prefix+"metadata.", fl)
+	s.Docker.BindFlags(prefix+"docker.", fl)
+	s.CNI.BindFlags(prefix+"cni.", fl)
+	s.Consul.BindFlags(prefix+"consul.", fl)
 	// Don't recurse on meshdns flags in bridge configurations
 	if !strings.Contains(prefix, "bridge.") {
 		s.MeshDNS.BindFlags(prefix+"meshdns.", fl)
@@ -143,6 +197,38 @@ func (s *ServiceOptions) Validate() error {
 	if err != nil {
 		return err
 	}
+	err = s.Membership.Validate()
+	if err != nil {
+		return err
+	}
+	err = s.Sidecar.Validate()
+	if err != nil {
+		return err
+	}
+	err = s.Metadata.Validate()
+	if err != nil {
+		return err
+	}
+	err = s.Docker.Validate()
+	if err != nil {
+		return err
+	}
+	err = s.CNI.Validate()
+	if err != nil {
+		return err
+	}
+	err = s.Consul.Validate()
+	if err != nil {
+		return err
+	}
+	for _, svc := range s.CustomServices {
+		if svc.Desc == nil || svc.Impl == nil {
+			return fmt.Errorf("custom services must have a service descriptor and implementation")
+		}
+		if s.API.Disabled {
+			return fmt.Errorf("custom service %s cannot be served with services.api.disabled", svc.Desc.ServiceName)
+		}
+	}
 	return nil
 }
 
@@ -158,6 +244,13 @@ type APIOptions struct {
 	ListenAddress string `koanf:"listen-address,omitempty"`
 	// WebEnabled enables serving gRPC over HTTP/1.1.
 	WebEnabled bool `koanf:"web-enabled,omitempty"`
+	// GRPCWebHTTP3Enabled enables the experimental HTTP/3 (QUIC) listener for
+	// gRPC-Web. It listens on the UDP port of the listen address and requires
+	// WebEnabled. Only gRPC-Web is served over HTTP/3, native gRPC clients keep
+	// using the TCP listener.
+	GRPCWebHTTP3Enabled bool `koanf:"grpc-web-http3-enabled,omitempty"`
+	// Listener are options for filtering and limiting gRPC connections.
+	Listener ListenerOptions `koanf:"listener,omitempty"`
 	// CORSEnabled enables CORS for the gRPC web server.
 	CORSEnabled bool `koanf:"cors-enabled,omitempty"`
 	// AllowedOrigins is a list of allowed origins for CORS.
@@ -183,6 +276,8 @@ type APIOptions struct {
 	MeshEnabled bool `koanf:"mesh-enabled,omitempty"`
 	// AdminEnabled is true if the admin API should be registered.
 	AdminEnabled bool `koanf:"admin-enabled,omitempty"`
+	// Health controls how peer health is applied to node lists served by the mesh API.
+	Health PeerHealthOptions `koanf:"health,omitempty"`
 }
 
 // LibP2PAPIOptions are options for serving the API over libp2p.
@@ -201,6 +296,21 @@ type LibP2PAPIOptions struct {
 	LocalAddrs []string `koanf:"local-addrs,omitempty"`
 	// ConnectTimeout is the timeout for connecting to a peer.
 	ConnectTimeout time.Duration `koanf:"connect-timeout,omitempty"`
+	// AnnounceTTL is the TTL requested when announcing on the DHT. The node
+	// re-announces itself before it expires.
+	AnnounceTTL time.Duration `koanf:"announce-ttl,omitempty"`
+	// AnnounceRetryInterval is the interval between announcements after one fails.
+	AnnounceRetryInterval time.Duration `koanf:"announce-retry-interval,omitempty"`
+	// AnnounceJitter is the fraction of each announcement interval that is
+	// randomly shaved off.
+	AnnounceJitter float64 `koanf:"announce-jitter,omitempty"`
+	// AnnounceMaxFailures stops announcing after the given number of
+	// consecutive failures. Zero retries until shutdown.
+	AnnounceMaxFailures int `koanf:"announce-max-failures,omitempty"`
+	// IdentityFile is the path to a persistent libp2p identity for the API.
+	// It is created if it does not exist, so clients can pin the peer ID of
+	// the node. When empty, the WireGuard key is used.
+	IdentityFile string `koanf:"identity-file,omitempty"`
 }
 
 // NewAPIOptions returns a new APIOptions with the default values.
@@ -227,6 +337,7 @@ func (a *APIOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.BoolVar(&a.Disabled, prefix+"disabled", a.Disabled, "Disable the API. This is ignored when joining as a Raft member.")
 	fl.StringVar(&a.ListenAddress, prefix+"listen-address", a.ListenAddress, "gRPC listen address.")
 	fl.BoolVar(&a.WebEnabled, prefix+"web-enabled", a.WebEnabled, "Enable gRPC over HTTP/1.1.")
+	fl.BoolVar(&a.GRPCWebHTTP3Enabled, prefix+"grpc-web-http3-enabled", a.GRPCWebHTTP3Enabled, "Enable the experimental HTTP/3 (QUIC) listener for gRPC-Web. Native gRPC is only served over TCP. Requires web-enabled.")
 	fl.BoolVar(&a.CORSEnabled, prefix+"cors-enabled", a.CORSEnabled, "Enable CORS for the gRPC web server.")
 	fl.StringSliceVar(&a.AllowedOrigins, prefix+"allowed-origins", a.AllowedOrigins, "Allowed origins for CORS.")
 	fl.BoolVar(&a.DisableLeaderProxy, prefix+"disable-leader-proxy", a.DisableLeaderProxy, "Disable the leader proxy.")
@@ -240,6 +351,8 @@ func (a *APIOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.BoolVar(&a.MeshEnabled, prefix+"mesh-enabled", a.MeshEnabled, "Enable and register the MeshAPI.")
 	fl.BoolVar(&a.AdminEnabled, prefix+"admin-enabled", a.AdminEnabled, "Enable and register the AdminAPI.")
 	a.LibP2P.BindFlags(prefix+"libp2p.", fl)
+	a.Health.BindFlags(prefix+"health.", fl)
+	a.Listener.BindFlags(prefix+"listener.", fl)
 }
 
 // Validate validates the options.
@@ -256,6 +369,17 @@ func (a APIOptions) Validate() error {
 			return fmt.Errorf("listen-address is invalid: %w", err)
 		}
 	}
+	if a.GRPCWebHTTP3Enabled {
+		if !a.WebEnabled {
+			return fmt.Errorf("services.api.web-enabled must be set when services.api.grpc-web-http3-enabled is set")
+		}
+		if a.Insecure {
+			return fmt.Errorf("services.api.grpc-web-http3-enabled cannot be used with services.api.insecure")
+		}
+		if a.ListenAddress == "" {
+			return fmt.Errorf("services.api.listen-address must be set when services.api.grpc-web-http3-enabled is set")
+		}
+	}
 	if !a.Insecure {
 		// If key file is supplied, make sure we have a cert-file with it.
 		if a.TLSKeyFile != "" && a.TLSCertFile == "" {
@@ -273,6 +397,12 @@ func (a APIOptions) Validate() error {
 			return fmt.Errorf("services.api.tls-key-data must be set when services.api.tls-cert-data is set")
 		}
 	}
+	if err := a.Health.Validate(); err != nil {
+		return fmt.Errorf("services.api.health is invalid: %w", err)
+	}
+	if err := a.Listener.Validate(); err != nil {
+		return fmt.Errorf("services.api.listener is invalid: %w", err)
+	}
 	return a.LibP2P.Validate()
 }
 
@@ -297,6 +427,11 @@ func (l *LibP2PAPIOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.StringSliceVar(&l.BootstrapServers, prefix+"bootstrap-servers", l.BootstrapServers, "List of bootstrap servers to use for the DHT.")
 	fl.StringSliceVar(&l.LocalAddrs, prefix+"local-addrs", l.LocalAddrs, "List of local addresses to announce to the discovery service.")
 	fl.DurationVar(&l.ConnectTimeout, prefix+"connect-timeout", l.ConnectTimeout, "Timeout for connecting to a peer.")
+	fl.DurationVar(&l.AnnounceTTL, prefix+"announce-ttl", l.AnnounceTTL, "TTL to request when announcing on the DHT. The node re-announces itself before it expires.")
+	fl.DurationVar(&l.AnnounceRetryInterval, prefix+"announce-retry-interval", l.AnnounceRetryInterval, "Interval between announcements after one fails.")
+	fl.Float64Var(&l.AnnounceJitter, prefix+"announce-jitter", l.AnnounceJitter, "Fraction of each announcement interval that is randomly shaved off.")
+	fl.IntVar(&l.AnnounceMaxFailures, prefix+"announce-max-failures", l.AnnounceMaxFailures, "Stop announcing after this many consecutive failures. Zero retries until shutdown.")
+	fl.StringVar(&l.IdentityFile, prefix+"identity-file", l.IdentityFile, "Path to a persistent libp2p identity, created if it does not exist.")
 }
 
 // Validate validates the options.
@@ -308,6 +443,15 @@ func (l LibP2PAPIOptions) Validate() error {
 		if l.Rendezvous == "" {
 			return fmt.Errorf("services.api.libp2p.rendezvous must be set when announcing")
 		}
+		if l.AnnounceTTL < 0 || l.AnnounceRetryInterval < 0 {
+			return fmt.Errorf("services.api.libp2p.announce-ttl and services.api.libp2p.announce-retry-interval must not be negative")
+		}
+		if l.AnnounceJitter < 0 || l.AnnounceJitter > 1 {
+			return fmt.Errorf("services.api.libp2p.announce-jitter must be between 0 and 1")
+		}
+		if l.AnnounceMaxFailures < 0 {
+			return fmt.Errorf("services.api.libp2p.announce-max-failures must not be negative")
+		}
 		for _, addr := range append(l.BootstrapServers, l.LocalAddrs...) {
 			_, err := multiaddr.NewMultiaddr(addr)
 			if err != nil {
@@ -318,6 +462,48 @@ func (l LibP2PAPIOptions) Validate() error {
 	return nil
 }
 
+// PeerHealthOptions are options for making peer lists health-aware.
+type PeerHealthOptions struct {
+	// Mode is how peer health is applied to peer lists. One of "none", "sort", or "filter".
+	// Sort orders live peers first and filter additionally removes peers that are not alive.
+	Mode string `koanf:"mode,omitempty"`
+	// MaxHandshakeAge is the maximum age of the last WireGuard handshake with a peer for
+	// it to be considered alive. Idle peers are only reliably reported as alive when
+	// wireguard.persistent-keepalive is set below this value.
+	MaxHandshakeAge time.Duration `koanf:"max-handshake-age,omitempty"`
+}
+
+// BindFlags binds the flags.
+func (p *PeerHealthOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
+	fl.StringVar(&p.Mode, prefix+"mode", p.Mode, "How peer health is applied to answers. One of none, sort, or filter.")
+	fl.DurationVar(&p.MaxHandshakeAge, prefix+"max-handshake-age", p.MaxHandshakeAge, "Maximum age of the last WireGuard handshake for a peer to be considered alive.")
+}
+
+// Validate validates the options.
+func (p PeerHealthOptions) Validate() error {
+	if _, err := meshnet.ParseHealthMode(p.Mode); err != nil {
+		return err
+	}
+	if p.MaxHandshakeAge < 0 {
+		return fmt.Errorf("max-handshake-age must be >= 0")
+	}
+	return nil
+}
+
+// HealthMode returns the parsed health mode.
+func (p PeerHealthOptions) HealthMode() meshnet.HealthMode {
+	mode, _ := meshnet.ParseHealthMode(p.Mode)
+	return mode
+}
+
+// NewPeerHealth returns a PeerHealth for the given node, or nil if health is disabled.
+func (p PeerHealthOptions) NewPeerHealth(node meshnode.Node) meshnet.PeerHealth {
+	if p.HealthMode() == meshnet.HealthModeNone {
+		return nil
+	}
+	return meshnet.NewHandshakeHealth(node.ID(), node.Network(), p.MaxHandshakeAge)
+}
+
 // RegistrarOptions are options for running a registrar service.
 type RegistrarOptions struct {
 	// Enabled is true if the registrar should be enabled.
@@ -346,12 +532,85 @@ func (r *RegistrarOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	r.IDAuth.BindFlags(prefix+"id-auth.", fl)
 }
 
+// MembershipOptions are options for the membership service. They only take
+// effect on nodes that are storage members.
+type MembershipOptions struct {
+	// CollisionPolicy is the policy to apply when a node joins with an ID that
+	// is already registered to a different public key. One of "reject" (the
+	// default), "evict", or "rename".
+	CollisionPolicy string `koanf:"collision-policy,omitempty"`
+	// HistoryMaxAge is the maximum age of events kept in the membership
+	// history. Zero keeps events regardless of age.
+	HistoryMaxAge time.Duration `koanf:"history-max-age,omitempty"`
+	// HistoryMaxEvents is the maximum number of events kept in the membership
+	// history. Zero keeps events regardless of count.
+	HistoryMaxEvents int `koanf:"history-max-events,omitempty"`
+}
+
+// NewMembershipOptions returns a new MembershipOptions with the default values.
+func NewMembershipOptions() MembershipOptions {
+	return MembershipOptions{
+		CollisionPolicy:  string(membership.DefaultCollisionPolicy),
+		HistoryMaxAge:    time.Hour * 24 * 30,
+		HistoryMaxEvents: 10000,
+	}
+}
+
+// BindFlags binds the flags.
+func (m *MembershipOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
+	fl.StringVar(&m.CollisionPolicy, prefix+"collision-policy", m.CollisionPolicy, "Policy for node ID collisions (reject, evict, or rename).")
+	fl.DurationVar(&m.HistoryMaxAge, prefix+"history-max-age", m.HistoryMaxAge, "Maximum age of events kept in the membership history (0 = unlimited).")
+	fl.IntVar(&m.HistoryMaxEvents, prefix+"history-max-events", m.HistoryMaxEvents, "Maximum number of events kept in the membership history (0 = unlimited).")
+}
+
+// Validate validates the options.
+func (m MembershipOptions) Validate() error {
+	_, err := membership.ParseCollisionPolicy(m.CollisionPolicy)
+	if err != nil {
+		return fmt.Errorf("services.membership.collision-policy is invalid: %w", err)
+	}
+	if m.HistoryMaxAge < 0 {
+		return fmt.Errorf("services.membership.history-max-age must not be negative")
+	}
+	if m.HistoryMaxEvents < 0 {
+		return fmt.Errorf("services.membership.history-max-events must not be negative")
+	}
+	return nil
+}
+
+// HistoryRetention returns the retention limits for the membership history.
+func (m MembershipOptions) HistoryRetention() meshstorage.MembershipHistoryRetention {
+	return meshstorage.MembershipHistoryRetention{
+		MaxAge:    m.HistoryMaxAge,
+		MaxEvents: m.HistoryMaxEvents,
+	}
+}
+
 // WebRTCOptions are the options for the WebRTC API.
 type WebRTCOptions struct {
 	// Enabled enables the WebRTC API.
 	Enabled bool `koanf:"enabled,omitempty"`
 	// STUNServers is a list of STUN servers to use for the WebRTC API.
 	STUNServers []string `koanf:"stun-servers,omitempty"`
+	// TURNCredentials are the credentials to use for TURN servers. They are also
+	// used when negotiating WebRTC connections to other nodes.
+	TURNCredentials TURNCredentialOptions `koanf:"turn-credentials,omitempty"`
+	// TURNServerCredentials are credentials for individual TURN servers keyed by URL.
+	// They take precedence over TURNCredentials.
+	TURNServerCredentials map[string]TURNCredentialOptions `koanf:"turn-server-credentials,omitempty"`
+}
+
+// TURNCredentialOptions are credentials for authenticating with a TURN server.
+type TURNCredentialOptions struct {
+	// Username is the username for long-term credentials.
+	Username string `koanf:"username,omitempty"`
+	// Password is the password for long-term credentials.
+	Password string `koanf:"password,omitempty"`
+	// Secret is a secret shared with the TURN server for generating ephemeral
+	// credentials with the TURN REST API scheme.
+	Secret string `koanf:"secret,omitempty"`
+	// TTL is the lifetime of ephemeral credentials.
+	TTL time.Duration `koanf:"ttl,omitempty"`
 }
 
 // NewWebRTCOptions returns a new WebRTCOptions with the default values.
@@ -359,6 +618,10 @@ func NewWebRTCOptions() WebRTCOptions {
 	return WebRTCOptions{
 		Enabled:     false,
 		STUNServers: webrtc.DefaultSTUNServers,
+		TURNCredentials: TURNCredentialOptions{
+			TTL: datachannels.DefaultTURNCredentialTTL,
+		},
+		TURNServerCredentials: map[string]TURNCredentialOptions{},
 	}
 }
 
@@ -366,10 +629,64 @@ func NewWebRTCOptions() WebRTCOptions {
 func (w *WebRTCOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.BoolVar(&w.Enabled, prefix+"enabled", w.Enabled, "Enable and register the WebRTC API.")
 	fl.StringSliceVar(&w.STUNServers, prefix+"stun-servers", w.STUNServers, "TURN/STUN servers to use for the WebRTC API.")
+	w.TURNCredentials.BindFlags(prefix+"turn-credentials.", fl)
+}
+
+// BindFlags binds the flags.
+func (t *TURNCredentialOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
+	fl.StringVar(&t.Username, prefix+"username", t.Username, "Username for long-term TURN credentials.")
+	fl.StringVar(&t.Password, prefix+"password", t.Password, "Password for long-term TURN credentials.")
+	fl.StringVar(&t.Secret, prefix+"secret", t.Secret, "Shared secret for generating ephemeral TURN REST API credentials.")
+	fl.DurationVar(&t.TTL, prefix+"ttl", t.TTL, "Lifetime of ephemeral TURN credentials.")
+}
+
+// Validate validates the credentials.
+func (t TURNCredentialOptions) Validate() error {
+	if t.Secret != "" && (t.Username != "" || t.Password != "") {
+		return fmt.Errorf("cannot use a shared secret with a username and password")
+	}
+	if (t.Username == "") != (t.Password == "") {
+		return fmt.Errorf("username and password must be set together")
+	}
+	if t.TTL < 0 {
+		return fmt.Errorf("ttl must not be negative")
+	}
+	return nil
+}
+
+// NewCredentials returns the credentials for the datachannels transport.
+func (t TURNCredentialOptions) NewCredentials() datachannels.TURNCredentials {
+	return datachannels.TURNCredentials{
+		Username: t.Username,
+		Password: t.Password,
+		Secret:   t.Secret,
+		TTL:      t.TTL,
+	}
+}
+
+// TURNAuth returns the TURN credentials for the datachannels transport.
+func (w WebRTCOptions) TURNAuth() datachannels.TURNAuth {
+	auth := datachannels.TURNAuth{
+		Credentials: w.TURNCredentials.NewCredentials(),
+		Servers:     make(map[string]datachannels.TURNCredentials, len(w.TURNServerCredentials)),
+	}
+	for url, creds := range w.TURNServerCredentials {
+		auth.Servers[url] = creds.NewCredentials()
+	}
+	return auth
 }
 
 // Validate validates the options.
 func (w WebRTCOptions) Validate() error {
+	// Credentials are also used for outbound connections when the API is disabled.
+	if err := w.TURNCredentials.Validate(); err != nil {
+		return fmt.Errorf("services.webrtc.turn-credentials is invalid: %w", err)
+	}
+	for url, creds := range w.TURNServerCredentials {
+		if err := creds.Validate(); err != nil {
+			return fmt.Errorf("services.webrtc.turn-server-credentials for %s is invalid: %w", url, err)
+		}
+	}
 	if !w.Enabled {
 		return nil
 	}
@@ -398,6 +715,10 @@ type TURNOptions struct {
 	Realm string `koanf:"realm,omitempty"`
 	// TURNPortRange is the port range to use for allocating TURN relays.
 	TURNPortRange string `koanf:"port-range,omitempty"`
+	// RendezvousListenAddress is the address to listen on for rendezvous requests.
+	// If set, the TURN server is accompanied by a rendezvous relay for peers meeting
+	// with a pre-shared key.
+	RendezvousListenAddress string `koanf:"rendezvous-listen-address,omitempty"`
 }
 
 // NewTURNOptions returns a new TURNOptions with the default values.
@@ -420,6 +741,7 @@ func (t *TURNOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.StringVar(&t.ListenAddress, prefix+"listen-address", t.ListenAddress, "Address to listen on for STUN/TURN requests.")
 	fl.StringVar(&t.Realm, prefix+"realm", t.Realm, "Realm used for TURN server authentication.")
 	fl.StringVar(&t.TURNPortRange, prefix+"port-range", t.TURNPortRange, "Port range to use for TURN relays.")
+	fl.StringVar(&t.RendezvousListenAddress, prefix+"rendezvous-listen-address", t.RendezvousListenAddress, "Address to listen on for rendezvous requests. Disabled if empty.")
 }
 
 // Validate values the TURN options.
@@ -448,6 +770,12 @@ func (t TURNOptions) Validate() error {
 	if err != nil {
 		return fmt.Errorf("services.turn.port-range is invalid: %w", err)
 	}
+	if t.RendezvousListenAddress != "" {
+		_, _, err := net.SplitHostPort(t.RendezvousListenAddress)
+		if err != nil {
+			return fmt.Errorf("services.turn.rendezvous-listen-address is invalid: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -496,6 +824,12 @@ type MeshDNSOptions struct {
 	CacheSize int `koanf:"cache-size,omitempty"`
 	// IPv6Only will only respond to IPv6 requests.
 	IPv6Only bool `koanf:"ipv6-only,omitempty"`
+	// Health controls how peer health is applied to answers. Clients can bypass it
+	// by setting the Checking Disabled (CD) bit on their query.
+	Health PeerHealthOptions `koanf:"health,omitempty"`
+	// DNS64Prefix enables DNS64 synthesis with the given IPv6 /96 prefix. Forwarded AAAA queries
+	// without answers are answered from A records routed through a node advertising the prefix.
+	DNS64Prefix string `koanf:"dns64-prefix,omitempty"`
 }
 
 // NewMeshDNSOptions returns a new MeshDNSOptions with the default values.
@@ -530,6 +864,14 @@ func (m *MeshDNSOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.BoolVar(&m.DisableForwarding, prefix+"disable-forwarding", m.DisableForwarding, "Disable forwarding requests.")
 	fl.IntVar(&m.CacheSize, prefix+"cache-size", m.CacheSize, "Size of the remote DNS cache (0 = disabled).")
 	fl.BoolVar(&m.IPv6Only, prefix+"ipv6-only", m.IPv6Only, "Only respond to IPv6 requests.")
+	m.Health.BindFlags(prefix+"health.", fl)
+	fl.StringVar(&m.DNS64Prefix, prefix+"dns64-prefix", m.DNS64Prefix, "IPv6 /96 NAT64 prefix to synthesize AAAA records with (empty = disabled).")
+}
+
+// DNS64 returns the parsed DNS64 prefix, or an invalid prefix if DNS64 is disabled.
+func (m MeshDNSOptions) DNS64() netip.Prefix {
+	prefix, _ := netip.ParsePrefix(m.DNS64Prefix)
+	return prefix
 }
 
 // ListenPort returns the listen port for the MeshDNS server is enabled.
@@ -575,6 +917,18 @@ func (m MeshDNSOptions) Validate() error {
 	} else if m.ReusePort != 0 && runtime.GOOS != "linux" {
 		return fmt.Errorf("services.meshdns.reuse-port is only supported on Linux")
 	}
+	if err := m.Health.Validate(); err != nil {
+		return fmt.Errorf("services.meshdns.health is invalid: %w", err)
+	}
+	if m.DNS64Prefix != "" {
+		prefix, err := netip.ParsePrefix(m.DNS64Prefix)
+		if err != nil {
+			return fmt.Errorf("services.meshdns.dns64-prefix is invalid: %w", err)
+		}
+		if !prefix.Addr().Is6() || prefix.Bits() != 96 {
+			return fmt.Errorf("services.meshdns.dns64-prefix must be an IPv6 /96 prefix")
+		}
+	}
 	return nil
 }
 
@@ -586,6 +940,14 @@ type MetricsOptions struct {
 	ListenAddress string `koanf:"listen-address,omitempty"`
 	// MetricsPath is the path to serve metrics on.
 	Path string `koanf:"path,omitempty"`
+	// ServiceDiscoveryPath is the path to serve the mesh node list on in Prometheus
+	// http_sd format. Leave empty to disable.
+	ServiceDiscoveryPath string `koanf:"service-discovery-path,omitempty"`
+	// ServiceDiscoveryFile is a file to keep updated with the mesh node list in
+	// Prometheus file_sd format. Leave empty to disable.
+	ServiceDiscoveryFile string `koanf:"service-discovery-file,omitempty"`
+	// ServiceDiscoveryPreferIPv6 uses private IPv6 addresses for discovered targets when available.
+	ServiceDiscoveryPreferIPv6 bool `koanf:"service-discovery-prefer-ipv6,omitempty"`
 }
 
 // NewMetricsOptions returns a new MetricsOptions with the default values.
@@ -602,6 +964,9 @@ func (m *MetricsOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
 	fl.BoolVar(&m.Enabled, prefix+"enabled", m.Enabled, "Enable gRPC metrics.")
 	fl.StringVar(&m.ListenAddress, prefix+"listen-address", m.ListenAddress, "gRPC metrics listen address.")
 	fl.StringVar(&m.Path, prefix+"path", m.Path, "gRPC metrics path.")
+	fl.StringVar(&m.ServiceDiscoveryPath, prefix+"service-discovery-path", m.ServiceDiscoveryPath, "Path to serve mesh nodes on in Prometheus http_sd format. Leave empty to disable.")
+	fl.StringVar(&m.ServiceDiscoveryFile, prefix+"service-discovery-file", m.ServiceDiscoveryFile, "File to write mesh nodes to in Prometheus file_sd format. Leave empty to disable.")
+	fl.BoolVar(&m.ServiceDiscoveryPreferIPv6, prefix+"service-discovery-prefer-ipv6", m.ServiceDiscoveryPreferIPv6, "Use private IPv6 addresses for discovered targets when available.")
 }
 
 // ListenPort returns the listen port for the Metrics server is enabled.
@@ -632,38 +997,338 @@ func (m MetricsOptions) Validate() error {
 	if err != nil {
 		return fmt.Errorf("services.metrics.listen-address is invalid: %w", err)
 	}
+	if m.ServiceDiscoveryPath != "" {
+		if !strings.HasPrefix(m.ServiceDiscoveryPath, "/") {
+			return fmt.Errorf("services.metrics.service-discovery-path must be an absolute path")
+		}
+		if m.ServiceDiscoveryPath == m.Path {
+			return fmt.Errorf("services.metrics.service-discovery-path must differ from services.metrics.path")
+		}
+	}
+	return nil
+}
+
+// SidecarOptions are options for running the node as a sidecar that configures
+// the network namespace it is injected into for an application container.
+type SidecarOptions struct {
+	// Enabled is true if the node should run in sidecar mode. Sidecar mode
+	// configures the mesh interface, routes, and DNS in the namespace of the
+	// application, keeps them configured, and serves a local API for the
+	// application container to query mesh addresses.
+	Enabled bool `koanf:"enabled,omitempty"`
+	// ListenAddress is the address to serve the sidecar API on.
+	ListenAddress string `koanf:"listen-address,omitempty"`
+	// NetNs is the network namespace of the application, for example
+	// /proc/<pid>/ns/net. It is only needed when the node does not already
+	// share the namespace of the application. Only supported on Linux.
+	NetNs string `koanf:"netns,omitempty"`
+	// ResolvConf is the resolver configuration of the application to keep
+	// the mesh DNS servers in. Leave empty to not configure DNS.
+	ResolvConf string `koanf:"resolv-conf,omitempty"`
+	// SuperviseInterval is how often the namespace is checked and restored.
+	SuperviseInterval time.Duration `koanf:"supervise-interval,omitempty"`
+}
+
+// NewSidecarOptions returns a new SidecarOptions with the default values.
+func NewSidecarOptions() SidecarOptions {
+	return SidecarOptions{
+		Enabled:           false,
+		ListenAddress:     sidecar.DefaultListenAddress,
+		ResolvConf:        sidecar.DefaultResolvConf,
+		SuperviseInterval: sidecar.DefaultSuperviseInterval,
+	}
+}
+
+// BindFlags binds the flags.
+func (s *SidecarOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
+	fl.BoolVar(&s.Enabled, prefix+"enabled", s.Enabled, "Run as a sidecar configuring the shared network namespace of an application container.")
+	fl.StringVar(&s.ListenAddress, prefix+"listen-address", s.ListenAddress, "Address to serve the sidecar API on.")
+	fl.StringVar(&s.NetNs, prefix+"netns", s.NetNs, "Network namespace of the application when it is not shared with the node.")
+	fl.StringVar(&s.ResolvConf, prefix+"resolv-conf", s.ResolvConf, "Resolver configuration of the application to add mesh DNS servers to. Empty disables DNS configuration.")
+	fl.DurationVar(&s.SuperviseInterval, prefix+"supervise-interval", s.SuperviseInterval, "Interval for checking and restoring the network namespace of the application.")
+}
+
+// Validate validates the options.
+func (s SidecarOptions) Validate() error {
+	if !s.Enabled {
+		return nil
+	}
+	if s.ListenAddress == "" {
+		return fmt.Errorf("services.sidecar.listen-address must be set")
+	}
+	_, _, err := net.SplitHostPort(s.ListenAddress)
+	if err != nil {
+		return fmt.Errorf("services.sidecar.listen-address is invalid: %w", err)
+	}
+	if s.NetNs != "" && runtime.GOOS != "linux" {
+		return fmt.Errorf("services.sidecar.netns is only supported on linux")
+	}
+	if s.SuperviseInterval <= 0 {
+		return fmt.Errorf("services.sidecar.supervise-interval must be positive")
+	}
 	return nil
 }
 
+// MetadataOptions are options for serving the local metadata API that workloads
+// on the node use to look up the mesh identity of their peers.
+type MetadataOptions struct {
+	// Enabled is true if the metadata API should be served.
+	Enabled bool `koanf:"enabled,omitempty"`
+	// Socket is the unix socket to serve the metadata API on.
+	Socket string `koanf:"socket,omitempty"`
+	// ListenAddress is an optional TCP address to serve the metadata API on,
+	// such as a link-local address assigned to the host.
+	ListenAddress string `koanf:"listen-address,omitempty"`
+}
+
+// NewMetadataOptions returns a new MetadataOptions with the default values.
+func NewMetadataOptions() MetadataOptions {
+	return MetadataOptions{
+		Enabled: false,
+		Socket:  metadata.DefaultSocket,
+	}
+}
+
+// BindFlags binds the flags.
+func (m *MetadataOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
+	fl.BoolVar(&m.Enabled, prefix+"enabled", m.Enabled, "Serve the local metadata API for looking up the mesh identity of peers.")
+	fl.StringVar(&m.Socket, prefix+"socket", m.Socket, "Unix socket to serve the metadata API on.")
+	fl.StringVar(&m.ListenAddress, prefix+"listen-address", m.ListenAddress, "Optional TCP address, such as a link-local address, to serve the metadata API on.")
+}
+
+// Validate validates the options.
+func (m MetadataOptions) Validate() error {
+	if !m.Enabled {
+		return nil
+	}
+	if m.Socket == "" && m.ListenAddress == "" {
+		return fmt.Errorf("services.metadata.socket or services.metadata.listen-address must be set")
+	}
+	if m.ListenAddress != "" {
+		_, _, err := net.SplitHostPort(m.ListenAddress)
+		if err != nil {
+			return fmt.Errorf("services.metadata.listen-address is invalid: %w", err)
+		}
+	}
+	return nil
+}
+
+// DockerOptions are options for serving a Docker network and IPAM plugin
+// that attaches containers directly to the mesh.
+type DockerOptions struct {
+	// Enabled is true if the Docker plugin should be served.
+	Enabled bool `koanf:"enabled,omitempty"`
+	// Socket is the path to serve the plugin on. The name of the socket
+	// is the name of the driver in Docker.
+	Socket string `koanf:"socket,omitempty"`
+	// DockerSocket is the socket of the Docker engine used to look up
+	// container labels. Leave empty to only tag containers by network
+	// and endpoint options.
+	DockerSocket string `koanf:"docker-socket,omitempty"`
+}
+
+// NewDockerOptions returns a new DockerOptions with the default values.
+func NewDockerOptions() DockerOptions {
+	return DockerOptions{
+		Enabled:      false,
+		Socket:       docker.DefaultSocket,
+		DockerSocket: docker.DefaultDockerSocket,
+	}
+}
+
+// BindFlags binds the flags.
+func (d *DockerOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
+	fl.BoolVar(&d.Enabled, prefix+"enabled", d.Enabled, "Serve a Docker network and IPAM plugin that attaches containers to the mesh.")
+	fl.StringVar(&d.Socket, prefix+"socket", d.Socket, "Path to serve the Docker plugin on.")
+	fl.StringVar(&d.DockerSocket, prefix+"docker-socket", d.DockerSocket, "Docker engine socket used to look up container labels. Empty disables label lookups.")
+}
+
+// Validate validates the options.
+func (d DockerOptions) Validate() error {
+	if !d.Enabled {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("services.docker is only supported on linux")
+	}
+	if d.Socket == "" {
+		return fmt.Errorf("services.docker.socket must be set")
+	}
+	return nil
+}
+
+// CNIOptions are options for serving the local API used by the webmesh
+// CNI plugin to attach pods to the mesh.
+type CNIOptions struct {
+	// Enabled is true if the CNI API should be served.
+	Enabled bool `koanf:"enabled,omitempty"`
+	// Socket is the path to serve the CNI API on. It must match the socket
+	// in the network configuration of the plugin.
+	Socket string `koanf:"socket,omitempty"`
+	// GCInterval is the interval for releasing the addresses of pods
+	// whose network namespace no longer exists.
+	GCInterval time.Duration `koanf:"gc-interval,omitempty"`
+}
+
+// NewCNIOptions returns a new CNIOptions with the default values.
+func NewCNIOptions() CNIOptions {
+	return CNIOptions{
+		Enabled:    false,
+		Socket:     cni.DefaultSocket,
+		GCInterval: cni.DefaultGCInterval,
+	}
+}
+
+// BindFlags binds the flags.
+func (c *CNIOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
+	fl.BoolVar(&c.Enabled, prefix+"enabled", c.Enabled, "Serve the local API for the webmesh CNI plugin.")
+	fl.StringVar(&c.Socket, prefix+"socket", c.Socket, "Path to serve the CNI API on.")
+	fl.DurationVar(&c.GCInterval, prefix+"gc-interval", c.GCInterval, "Interval for releasing the addresses of removed pods.")
+}
+
+// Validate validates the options.
+func (c CNIOptions) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("services.cni is only supported on linux")
+	}
+	if c.Socket == "" {
+		return fmt.Errorf("services.cni.socket must be set")
+	}
+	if c.GCInterval <= 0 {
+		return fmt.Errorf("services.cni.gc-interval must be greater than zero")
+	}
+	return nil
+}
+
+// ConsulOptions are options for registering services exposed over the mesh
+// with a local Consul agent.
+type ConsulOptions struct {
+	// Enabled is true if services should be registered with Consul.
+	Enabled bool `koanf:"enabled,omitempty"`
+	// Address is the address of the Consul agent.
+	Address string `koanf:"address,omitempty"`
+	// Token is the ACL token for the Consul agent.
+	Token string `koanf:"token,omitempty"`
+	// Interval is the interval for health checks and refreshes.
+	Interval time.Duration `koanf:"interval,omitempty"`
+	// Services are the services running on this node to register with their
+	// mesh address, in the format name=port[,tag...]. They are advertised in
+	// the mesh while Consul reports them healthy.
+	Services []string `koanf:"services,omitempty"`
+}
+
+// NewConsulOptions returns a new ConsulOptions with the default values.
+func NewConsulOptions() ConsulOptions {
+	return ConsulOptions{
+		Enabled:  false,
+		Address:  consul.DefaultAddress,
+		Interval: consul.DefaultInterval,
+	}
+}
+
+// BindFlags binds the flags.
+func (c *ConsulOptions) BindFlags(prefix string, fl *pflag.FlagSet) {
+	fl.BoolVar(&c.Enabled, prefix+"enabled", c.Enabled, "Register services exposed over the mesh with a local Consul agent.")
+	fl.StringVar(&c.Address, prefix+"address", c.Address, "Address of the Consul agent.")
+	fl.StringVar(&c.Token, prefix+"token", c.Token, "ACL token for the Consul agent.")
+	fl.DurationVar(&c.Interval, prefix+"interval", c.Interval, "Interval for Consul health checks and refreshes.")
+	fl.StringSliceVar(&c.Services, prefix+"services", c.Services, "Services to register in the format name=port[,tag...].")
+}
+
+// Validate validates the options.
+func (c ConsulOptions) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Address == "" {
+		return fmt.Errorf("services.consul.address must be set")
+	}
+	if c.Interval <= 0 {
+		return fmt.Errorf("services.consul.interval must be greater than zero")
+	}
+	if len(c.Services) == 0 {
+		return fmt.Errorf("services.consul.services must not be empty")
+	}
+	_, err := c.ParseServices()
+	return err
+}
+
+// ParseServices parses the configured services.
+func (c ConsulOptions) ParseServices() ([]consul.Service, error) {
+	var out []consul.Service
+	for _, s := range c.Services {
+		svc, err := consul.ParseService(s)
+		if err != nil {
+			return nil, fmt.Errorf("services.consul.services: %w", err)
+		}
+		out = append(out, svc)
+	}
+	return out, nil
+}
+
 // NewServiceOptions returns new options for the webmesh services.
 func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.Node) (conf services.Options, err error) {
 	conf.DisableGRPC = o.API.Disabled
 	if !conf.DisableGRPC {
 		conf.ListenAddress = o.API.ListenAddress
-		// Build out the server options
-		srvopts, err := o.NewServerOptions(ctx)
+		conf.ListenerMiddlewares, err = o.API.Listener.Middlewares()
 		if err != nil {
 			return conf, err
 		}
+		// Build out the server options
+		var srvopts grpc.ServerOption
+		if o.API.GRPCWebHTTP3Enabled {
+			// Share the TLS configuration so both listeners present the
+			// same certificate.
+			conf.GRPCWebHTTP3Enabled = true
+			conf.PacketConnMiddlewares, err = o.API.Listener.PacketConnMiddlewares()
+			if err != nil {
+				return conf, err
+			}
+			conf.TLSConfig, err = o.NewTLSConfig(ctx)
+			if err != nil {
+				return conf, err
+			}
+			srvopts = grpc.Creds(credentials.NewTLS(conf.TLSConfig))
+		} else {
+			srvopts, err = o.NewServerOptions(ctx)
+			if err != nil {
+				return conf, err
+			}
+		}
 		conf.ServerOptions = append(conf.ServerOptions, srvopts)
 		if o.API.LibP2P.Enabled {
 			conf.LibP2POptions = &services.LibP2POptions{
 				HostOptions: libp2p.HostOptions{
-					Options:        []config.Option{p2pcore.Identity(conn.Key().AsIdentity())},
 					BootstrapPeers: libp2p.ToMultiaddrs(o.API.LibP2P.BootstrapServers),
 					LocalAddrs:     libp2p.ToMultiaddrs(o.API.LibP2P.LocalAddrs),
+					IdentityFile:   o.API.LibP2P.IdentityFile,
+				},
+				Announce:   o.API.LibP2P.Announce,
+				Rendezvous: o.API.LibP2P.Rendezvous,
+				Advertise: libp2p.AdvertiseOptions{
+					TTL:           o.API.LibP2P.AnnounceTTL,
+					RetryInterval: o.API.LibP2P.AnnounceRetryInterval,
+					Jitter:        o.API.LibP2P.AnnounceJitter,
+					MaxFailures:   o.API.LibP2P.AnnounceMaxFailures,
 				},
-				Announce:   conf.LibP2POptions.Announce,
-				Rendezvous: conf.LibP2POptions.Rendezvous,
+			}
+			if o.API.LibP2P.IdentityFile == "" {
+				conf.LibP2POptions.HostOptions.Options = []config.Option{p2pcore.Identity(conn.Key().AsIdentity())}
 			}
 		}
 		// Always append logging middlewares to the server options
 		unarymiddlewares := []grpc.UnaryServerInterceptor{
 			context.LogInjectUnaryServerInterceptor(context.LoggerFrom(ctx)),
+			context.RequestIDUnaryServerInterceptor(),
 			logging.ContextUnaryServerInterceptor(),
 		}
 		streammiddlewares := []grpc.StreamServerInterceptor{
 			context.LogInjectStreamServerInterceptor(context.LoggerFrom(ctx)),
+			context.RequestIDStreamServerInterceptor(),
 			logging.ContextStreamServerInterceptor(),
 		}
 		// If metrics are enabled, register the metrics interceptor
@@ -673,11 +1338,21 @@ func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.No
 				return conf, err
 			}
 		}
+		unarymiddlewares = append(unarymiddlewares, o.Interceptors.UnaryBeforeAuth...)
+		streammiddlewares = append(streammiddlewares, o.Interceptors.StreamBeforeAuth...)
 		// Register any authentication interceptors
 		if conn.Plugins().HasAuth() {
 			unarymiddlewares = append(unarymiddlewares, conn.Plugins().AuthUnaryInterceptor())
 			streammiddlewares = append(streammiddlewares, conn.Plugins().AuthStreamInterceptor())
+			// Storage members can check callers against the key
+			// revocations without a round trip.
+			if conn.Storage().Consensus().IsMember() {
+				unarymiddlewares = append(unarymiddlewares, services.RevokedKeyUnaryServerInterceptor(conn.Storage().MeshDB(), conn.Storage().MeshStorage()))
+				streammiddlewares = append(streammiddlewares, services.RevokedKeyStreamServerInterceptor(conn.Storage().MeshDB(), conn.Storage().MeshStorage()))
+			}
 		}
+		unarymiddlewares = append(unarymiddlewares, o.Interceptors.UnaryAfterAuth...)
+		streammiddlewares = append(streammiddlewares, o.Interceptors.StreamAfterAuth...)
 		if !o.API.DisableLeaderProxy {
 			leaderProxy := leaderproxy.New(conn.ID(), conn.Storage().Consensus(), conn, conn.Network())
 			unarymiddlewares = append(unarymiddlewares, leaderProxy.UnaryInterceptor())
@@ -685,6 +1360,9 @@ func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.No
 		}
 		conf.ServerOptions = append(conf.ServerOptions, grpc.ChainUnaryInterceptor(unarymiddlewares...))
 		conf.ServerOptions = append(conf.ServerOptions, grpc.ChainStreamInterceptor(streammiddlewares...))
+		if advertised := o.AdvertisedServices(); len(advertised) > 0 {
+			conf.Servers = append(conf.Servers, services.NewServiceAdvertiser(ctx, conn.Storage().MeshStorage(), conn.ID(), advertised, 0))
+		}
 	}
 	// Append the enabled mesh services
 	if o.MeshDNS.Enabled {
@@ -698,6 +1376,8 @@ func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.No
 			IncludeSystemResolvers: o.MeshDNS.IncludeSystemResolvers,
 			DisableForwarding:      o.MeshDNS.DisableForwarding,
 			CacheSize:              o.MeshDNS.CacheSize,
+			HealthMode:             o.MeshDNS.Health.HealthMode(),
+			DNS64Prefix:            o.MeshDNS.DNS64(),
 		})
 		// Automatically register the local domain
 		err := dnsServer.RegisterDomain(meshdns.DomainOptions{
@@ -706,6 +1386,7 @@ func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.No
 			MeshStorage:         conn.Storage(),
 			IPv6Only:            o.MeshDNS.IPv6Only,
 			SubscribeForwarders: o.MeshDNS.SubscribeForwarders,
+			Health:              o.MeshDNS.Health.NewPeerHealth(conn),
 		})
 		if err != nil {
 			return conf, err
@@ -720,14 +1401,96 @@ func (o *ServiceOptions) NewServiceOptions(ctx context.Context, conn meshnode.No
 			PortRange: o.TURN.TURNPortRange,
 		})
 		conf.Servers = append(conf.Servers, turnServer)
+		if o.TURN.RendezvousListenAddress != "" {
+			conf.Servers = append(conf.Servers, rendezvous.NewServer(ctx, rendezvous.ServerOptions{
+				ListenUDP: o.TURN.RendezvousListenAddress,
+			}))
+		}
 	}
 	if o.Metrics.Enabled {
 		metricsServer := metrics.New(ctx, metrics.Options{
-			ListenAddress: o.Metrics.ListenAddress,
-			Path:          o.Metrics.Path,
+			ListenAddress:        o.Metrics.ListenAddress,
+			Path:                 o.Metrics.Path,
+			Peers:                conn.Storage().MeshDB().Peers(),
+			ServiceDiscoveryPath: o.Metrics.ServiceDiscoveryPath,
+			ServiceDiscoveryFile: o.Metrics.ServiceDiscoveryFile,
+			PreferIPv6:           o.Metrics.ServiceDiscoveryPreferIPv6,
 		})
 		conf.Servers = append(conf.Servers, metricsServer)
 	}
+	if o.Sidecar.Enabled {
+		var localDNSAddr netip.AddrPort
+		if o.MeshDNS.Enabled {
+			if _, port, err := net.SplitHostPort(o.MeshDNS.ListenUDP); err == nil {
+				localDNSAddr, _ = netip.ParseAddrPort(net.JoinHostPort("127.0.0.1", port))
+			}
+		}
+		sidecarServer := sidecar.New(ctx, sidecar.Options{
+			ListenAddress:     o.Sidecar.ListenAddress,
+			NodeID:            conn.ID(),
+			Domain:            conn.Domain(),
+			Network:           conn.Network(),
+			Peers:             conn.Storage().MeshDB().Peers(),
+			LocalDNSAddr:      localDNSAddr,
+			ResolvConf:        o.Sidecar.ResolvConf,
+			SuperviseInterval: o.Sidecar.SuperviseInterval,
+		})
+		conf.Servers = append(conf.Servers, sidecarServer)
+	}
+	if o.Metadata.Enabled {
+		metadataServer := metadata.New(ctx, metadata.Options{
+			Socket:        o.Metadata.Socket,
+			ListenAddress: o.Metadata.ListenAddress,
+			Storage:       conn.Storage().MeshDB(),
+			MeshStorage:   conn.Storage().MeshStorage(),
+		})
+		conf.Servers = append(conf.Servers, metadataServer)
+	}
+	if o.Docker.Enabled {
+		dockerServer := docker.New(ctx, docker.Options{
+			Socket:       o.Docker.Socket,
+			DockerSocket: o.Docker.DockerSocket,
+			NodeID:       conn.ID(),
+			Network:      conn.Network(),
+			Allocator: attach.NewAllocator(attach.Options{
+				NodeID:  conn.ID(),
+				Storage: conn.Storage(),
+				Plugins: conn.Plugins(),
+				Network: conn.Network(),
+			}),
+		})
+		conf.Servers = append(conf.Servers, dockerServer)
+	}
+	if o.CNI.Enabled {
+		cniServer := cni.New(ctx, cni.Options{
+			Socket:     o.CNI.Socket,
+			GCInterval: o.CNI.GCInterval,
+			Network:    conn.Network(),
+			Allocator: attach.NewAllocator(attach.Options{
+				NodeID:  conn.ID(),
+				Storage: conn.Storage(),
+				Plugins: conn.Plugins(),
+				Network: conn.Network(),
+			}),
+		})
+		conf.Servers = append(conf.Servers, cniServer)
+	}
+	if o.Consul.Enabled {
+		consulServices, err := o.Consul.ParseServices()
+		if err != nil {
+			return conf, err
+		}
+		consulServer := consul.New(ctx, consul.Options{
+			Address:  o.Consul.Address,
+			Token:    o.Consul.Token,
+			Interval: o.Consul.Interval,
+			Services: consulServices,
+			NodeID:   conn.ID(),
+			Network:  conn.Network(),
+			Storage:  conn.Storage().MeshStorage(),
+		})
+		conf.Servers = append(conf.Servers, consulServer)
+	}
 	return
 }
 
@@ -737,6 +1500,16 @@ func (o *ServiceOptions) NewServerOptions(ctx context.Context) (grpc.ServerOptio
 		// We shouldn't have gotten here. But as a fail safe, we return an insecure server.
 		return grpc.Creds(insecure.NewCredentials()), nil
 	}
+	tlsConfig, err := o.NewTLSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return grpc.Creds(credentials.NewTLS(tlsConfig)), nil
+}
+
+// NewTLSConfig returns the TLS configuration for the gRPC server. A self-signed
+// certificate is generated if none is configured.
+func (o *ServiceOptions) NewTLSConfig(ctx context.Context) (*tls.Config, error) {
 	tlsConfig := &tls.Config{}
 	if o.API.TLSCertFile != "" && o.API.TLSKeyFile != "" {
 		cert, err := tls.LoadX509KeyPair(o.API.TLSCertFile, o.API.TLSKeyFile)
@@ -788,7 +1561,7 @@ func (o *ServiceOptions) NewServerOptions(ctx context.Context) (grpc.ServerOptio
 			tlsConfig.ClientCAs = pool
 		}
 	}
-	return grpc.Creds(credentials.NewTLS(tlsConfig)), nil
+	return tlsConfig, nil
 }
 
 // APIRegistrationOptions are options for registering the APIs to a given server.
@@ -836,7 +1609,7 @@ func (o *ServiceOptions) RegisterAPIs(ctx context.Context, opts APIRegistrationO
 	}
 	// Always register the node API
 	log.Debug("Registering node service")
-	v1.RegisterNodeServer(opts.Server, node.NewServer(ctx, node.Options{
+	nodeServer := node.NewServer(ctx, node.Options{
 		NodeID:      opts.Node.ID(),
 		Description: opts.Description,
 		Version:     opts.BuildInfo,
@@ -845,7 +1618,10 @@ func (o *ServiceOptions) RegisterAPIs(ctx context.Context, opts APIRegistrationO
 		Meshnet:     opts.Node.Network(),
 		Plugins:     opts.Node.Plugins(),
 		Features:    opts.Features,
-	}))
+		TURNAuth:    o.WebRTC.TURNAuth(),
+	})
+	v1.RegisterNodeServer(opts.Server, nodeServer)
+	opts.Server.RegisterService(&node.StatusServiceDesc, nodeServer)
 	// Register membership and storage if we are a storage provider
 	if opts.Node.Storage().Consensus().IsMember() {
 		log.Debug("Registering membership service")
@@ -855,6 +1631,12 @@ func (o *ServiceOptions) RegisterAPIs(ctx context.Context, opts APIRegistrationO
 			Plugins: opts.Node.Plugins(),
 			RBAC:    rbacEvaluator,
 			Meshnet: opts.Node.Network(),
+			CollisionPolicy: func() membership.CollisionPolicy {
+				policy, _ := membership.ParseCollisionPolicy(o.Membership.CollisionPolicy)
+				return policy
+			}(),
+			HistoryRetention: o.Membership.HistoryRetention(),
+			KeyEscrow:        o.KeyEscrow,
 		}))
 		log.Debug("Registering storage service")
 		storageSrv := storage.NewServer(ctx, opts.Node.Storage(), rbacEvaluator, opts.Node.Network())
@@ -863,11 +1645,31 @@ func (o *ServiceOptions) RegisterAPIs(ctx context.Context, opts APIRegistrationO
 	// Register any other enabled APIs
 	if o.API.MeshEnabled {
 		log.Debug("Registering mesh api")
-		v1.RegisterMeshServer(opts.Server, meshapi.NewServer(opts.Node.Storage().MeshDB()))
+		v1.RegisterMeshServer(opts.Server, meshapi.NewServer(opts.Node.Storage().MeshDB(), meshapi.Options{
+			Health:     o.API.Health.NewPeerHealth(opts.Node),
+			HealthMode: o.API.Health.HealthMode(),
+		}))
 	}
 	if o.API.AdminEnabled {
 		log.Debug("Registering admin api")
 		v1.RegisterAdminServer(opts.Server, admin.NewServer(opts.Node.Storage(), rbacEvaluator))
+		if table := opts.Node.Network().Conntrack(); table != nil {
+			log.Debug("Registering conntrack api")
+			opts.Server.RegisterService(&conntrack.ServiceDesc, conntrack.NewServer(table, rbacEvaluator))
+		}
+		log.Debug("Registering peer configuration api")
+		opts.Server.RegisterService(&peerconfig.ServiceDesc, peerconfig.NewServer(opts.Node.ID(), opts.Node.Network().Peers(), opts.Node.Storage().MeshDB(), rbacEvaluator))
+		log.Debug("Registering policy api")
+		opts.Server.RegisterService(&policy.ServiceDesc, policy.NewServer(opts.Node.Storage().MeshDB(), rbacEvaluator))
+		log.Debug("Registering access review api")
+		opts.Server.RegisterService(&accessreview.ServiceDesc, accessreview.NewServer(opts.Node.Storage(), rbacEvaluator))
+		log.Debug("Registering mesh admin api")
+		opts.Server.RegisterService(&meshadmin.ServiceDesc, meshadmin.NewServer(meshadmin.Options{
+			NodeID:    opts.Node.ID(),
+			Storage:   opts.Node.Storage(),
+			RBAC:      rbacEvaluator,
+			KeyEscrow: o.KeyEscrow,
+		}))
 	}
 	if o.WebRTC.Enabled {
 		log.Debug("Registering WebRTC api")
@@ -884,6 +1686,7 @@ func (o *ServiceOptions) RegisterAPIs(ctx context.Context, opts APIRegistrationO
 			NodeDialer:  opts.Node,
 			RBAC:        rbacEvaluator,
 			STUNServers: o.WebRTC.STUNServers,
+			TURNAuth:    o.WebRTC.TURNAuth(),
 		}))
 	}
 	if o.Registrar.Enabled {
@@ -902,9 +1705,24 @@ func (o *ServiceOptions) RegisterAPIs(ctx context.Context, opts APIRegistrationO
 		}
 		v1.RegisterRegistrarServer(opts.Server, rs)
 	}
+	for _, svc := range o.CustomServices {
+		log.Debug("Registering custom service", "service", svc.Desc.ServiceName)
+		opts.Server.RegisterService(svc.Desc, svc.Impl)
+	}
 	return nil
 }
 
+// AdvertisedServices returns the names of the custom services to advertise in the mesh.
+func (o *ServiceOptions) AdvertisedServices() []string {
+	var names []string
+	for _, svc := range o.CustomServices {
+		if svc.Advertise {
+			names = append(names, svc.Desc.ServiceName)
+		}
+	}
+	return names
+}
+
 // NewFeatureSet returns a new FeatureSet for the given node options.
 func (o *ServiceOptions) NewFeatureSet(storage meshstorage.Provider, grpcPort int) []*v1.FeaturePort {
 	// We always expose the node API
@@ -517,6 +517,34 @@ func TestServiceOptionsValidate(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "InvalidCollisionPolicy",
+			opts: &ServiceOptions{
+				API:     NewInsecureAPIOptions(false),
+				WebRTC:  NewWebRTCOptions(),
+				MeshDNS: NewMeshDNSOptions(),
+				TURN:    NewTURNOptions(),
+				Metrics: NewMetricsOptions(),
+				Membership: MembershipOptions{
+					CollisionPolicy: "invalid",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "ValidCollisionPolicy",
+			opts: &ServiceOptions{
+				API:     NewInsecureAPIOptions(false),
+				WebRTC:  NewWebRTCOptions(),
+				MeshDNS: NewMeshDNSOptions(),
+				TURN:    NewTURNOptions(),
+				Metrics: NewMetricsOptions(),
+				Membership: MembershipOptions{
+					CollisionPolicy: "rename",
+				},
+			},
+			wantErr: false,
+		},
 	}
 
 	for _, tt := range tc {
@@ -0,0 +1,145 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/webmeshproj/webmesh/pkg/services/cni"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+)
+
+const (
+	// StateRootStorageDir is the storage directory relative to the state root.
+	StateRootStorageDir = "store"
+	// StateRootRunDir is the directory for sockets relative to the state root.
+	StateRootRunDir = "run"
+	// StateRootBridgeDir is the directory for bridged mesh state relative to the state root.
+	StateRootBridgeDir = "bridge"
+)
+
+// ResolveStatePath resolves a configured path against the state root. Empty or
+// default paths are replaced with subpath under the root, or left unchanged if
+// subpath is empty. Relative paths are joined to the root. Absolute paths are
+// returned as is. If root is empty, path is always returned unchanged.
+func ResolveStatePath(root, path, defaultPath, subpath string) string {
+	if root == "" {
+		return path
+	}
+	if path == "" || path == defaultPath {
+		if subpath == "" {
+			return path
+		}
+		return filepath.Join(root, subpath)
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(root, path)
+	}
+	return path
+}
+
+// PreflightStateRoot ensures the state root exists and is writable and that
+// every given path lives beneath it. Empty paths are ignored. This catches
+// configurations that would try to write to a read-only root filesystem before
+// the node starts making changes to the system.
+func PreflightStateRoot(root string, paths ...string) error {
+	if root == "" {
+		return nil
+	}
+	if !filepath.IsAbs(root) {
+		return fmt.Errorf("global.state-root must be an absolute path")
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return fmt.Errorf("create state root %s: %w", root, err)
+	}
+	probe, err := os.CreateTemp(root, ".webmesh-preflight-*")
+	if err != nil {
+		return fmt.Errorf("state root %s is not writable: %w", root, err)
+	}
+	probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return fmt.Errorf("state root %s is not writable: %w", root, err)
+	}
+	var outside []string
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			outside = append(outside, path)
+		}
+	}
+	if len(outside) > 0 {
+		return fmt.Errorf("paths outside the state root %s may not be writable: %s", root, strings.Join(outside, ", "))
+	}
+	return nil
+}
+
+// applyStateRoot derives writable paths from the state root. Subdir is
+// used to separate the state of bridged meshes.
+func (o *Config) applyStateRoot(root, subdir string) {
+	if root == "" {
+		return
+	}
+	o.Storage.Path = ResolveStatePath(root, o.Storage.Path, raftstorage.DefaultDataDir, filepath.Join(subdir, StateRootStorageDir))
+	o.Storage.Credentials.Path = ResolveStatePath(root, o.Storage.Credentials.Path, "", "")
+	o.WireGuard.KeyFile = ResolveStatePath(root, o.WireGuard.KeyFile, "", "")
+	o.Services.Metrics.ServiceDiscoveryFile = ResolveStatePath(root, o.Services.Metrics.ServiceDiscoveryFile, "", "")
+	if subdir == "" {
+		o.PrivSep.Socket = ResolveStatePath(root, o.PrivSep.Socket, DefaultPrivSepSocket, filepath.Join(StateRootRunDir, "privsep.sock"))
+		o.Services.CNI.Socket = ResolveStatePath(root, o.Services.CNI.Socket, cni.DefaultSocket, filepath.Join(StateRootRunDir, "cni.sock"))
+	}
+}
+
+// StatePaths returns the paths the node may write to with the current configuration.
+func (o *Config) StatePaths() []string {
+	var paths []string
+	if !o.Storage.InMemory {
+		paths = append(paths, o.Storage.Path)
+	}
+	if o.Storage.Credentials.Enabled {
+		path := o.Storage.Credentials.Path
+		if path == "" {
+			path = filepath.Join(o.Storage.Path, "credentials")
+		}
+		paths = append(paths, path)
+	}
+	paths = append(paths, o.WireGuard.KeyFile)
+	if o.Services.Metrics.Enabled {
+		paths = append(paths, o.Services.Metrics.ServiceDiscoveryFile)
+	}
+	if o.PrivSep.Enabled {
+		paths = append(paths, o.PrivSep.Socket)
+	}
+	if o.Services.CNI.Enabled {
+		paths = append(paths, o.Services.CNI.Socket)
+	}
+	for _, bridged := range o.Bridge.Meshes {
+		paths = append(paths, bridged.StatePaths()...)
+	}
+	return paths
+}
+
+// Preflight verifies that all writable state is confined to the state root
+// when one is configured.
+func (o *Config) Preflight() error {
+	return PreflightStateRoot(o.Global.StateRoot, o.StatePaths()...)
+}
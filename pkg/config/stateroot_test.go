@@ -0,0 +1,95 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestStateRoot(t *testing.T) {
+	t.Parallel()
+
+	t.Run("DerivesPaths", func(t *testing.T) {
+		root := t.TempDir()
+		conf := NewDefaultConfig("node")
+		conf.Global.StateRoot = root
+		conf.WireGuard.KeyFile = "keys/wireguard.key"
+		conf.Services.Metrics.ServiceDiscoveryFile = "/etc/prometheus/nodes.json"
+		conf.Bridge.Meshes = map[string]*Config{"other": NewDefaultConfig("node")}
+		conf, err := conf.Global.ApplyGlobals(context.Background(), conf)
+		if err != nil {
+			t.Fatalf("apply globals: %v", err)
+		}
+		if want := filepath.Join(root, StateRootStorageDir); conf.Storage.Path != want {
+			t.Errorf("expected storage path %q, got %q", want, conf.Storage.Path)
+		}
+		if want := filepath.Join(root, "keys/wireguard.key"); conf.WireGuard.KeyFile != want {
+			t.Errorf("expected key file %q, got %q", want, conf.WireGuard.KeyFile)
+		}
+		if conf.Services.Metrics.ServiceDiscoveryFile != "/etc/prometheus/nodes.json" {
+			t.Errorf("expected absolute path to be unchanged, got %q", conf.Services.Metrics.ServiceDiscoveryFile)
+		}
+		want := filepath.Join(root, StateRootBridgeDir, "other", StateRootStorageDir)
+		if got := conf.Bridge.Meshes["other"].Storage.Path; got != want {
+			t.Errorf("expected bridged storage path %q, got %q", want, got)
+		}
+		if err := conf.Preflight(); err != nil {
+			t.Errorf("expected preflight to pass, got %v", err)
+		}
+	})
+
+	t.Run("RejectsPathsOutsideRoot", func(t *testing.T) {
+		root := t.TempDir()
+		conf := NewDefaultConfig("node")
+		conf.Global.StateRoot = root
+		conf.Storage.Path = filepath.Join(t.TempDir(), "store")
+		conf, err := conf.Global.ApplyGlobals(context.Background(), conf)
+		if err != nil {
+			t.Fatalf("apply globals: %v", err)
+		}
+		if err := conf.Preflight(); err == nil {
+			t.Fatal("expected preflight to fail for storage outside the state root")
+		}
+	})
+
+	t.Run("RejectsUnwritableRoot", func(t *testing.T) {
+		if os.Geteuid() == 0 {
+			t.Skip("root can write to read-only directories")
+		}
+		root := t.TempDir()
+		if err := os.Chmod(root, 0555); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(root, 0755)
+		if err := PreflightStateRoot(root); err == nil {
+			t.Fatal("expected preflight to fail for read-only state root")
+		}
+	})
+
+	t.Run("NoStateRoot", func(t *testing.T) {
+		if got := ResolveStatePath("", "relative", "", "sub"); got != "relative" {
+			t.Errorf("expected path to be unchanged without a state root, got %q", got)
+		}
+		if err := PreflightStateRoot(""); err != nil {
+			t.Errorf("expected no-op preflight, got %v", err)
+		}
+	})
+}
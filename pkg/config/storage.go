@@ -22,17 +22,25 @@ import (
 	"encoding/base64"
 	"fmt"
 	"os"
+	"path"
+	"path/filepath"
+	"time"
 
 	"github.com/spf13/pflag"
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnode"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/postgres"
 	extstorage "github.com/webmeshproj/webmesh/pkg/storage/providers/external"
 	passthroughstorage "github.com/webmeshproj/webmesh/pkg/storage/providers/passthrough"
+	pgstorage "github.com/webmeshproj/webmesh/pkg/storage/providers/postgresstorage"
 	raftstorage "github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/shardedstorage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -46,12 +54,14 @@ const (
 	StorageProviderPassThrough StorageProvider = "passthrough"
 	// StorageProviderExternal is an external storage provider.
 	StorageProviderExternal StorageProvider = "external"
+	// StorageProviderPostgres is the PostgreSQL storage provider.
+	StorageProviderPostgres StorageProvider = "postgres"
 )
 
 // IsValid checks if the storage provider is valid.
 func (s StorageProvider) IsValid() bool {
 	switch s {
-	case StorageProviderRaft, StorageProviderPassThrough, StorageProviderExternal:
+	case StorageProviderRaft, StorageProviderPassThrough, StorageProviderExternal, StorageProviderPostgres:
 		return true
 	case "": // Defaults to raft
 		return true
@@ -71,20 +81,26 @@ type StorageOptions struct {
 	Raft RaftOptions `koanf:"raft,omitempty"`
 	// External are the external storage options.
 	External ExternalStorageOptions `koanf:"external,omitempty"`
+	// Postgres are the PostgreSQL storage options.
+	Postgres PostgresStorageOptions `koanf:"postgres,omitempty"`
 	// LogLevel is the log level for the storage provider.
 	LogLevel string `koanf:"log-level,omitempty"`
 	// LogFormat is the log format for the storage provider.
 	LogFormat string `koanf:"log-format,omitempty"`
+	// Credentials are the options for the encrypted node-local credential store.
+	Credentials CredentialStoreOptions `koanf:"credentials,omitempty"`
 }
 
 // NewStorageOptions creates a new storage options.
 func NewStorageOptions() StorageOptions {
 	return StorageOptions{
-		Path:     raftstorage.DefaultDataDir,
-		Provider: string(StorageProviderRaft),
-		Raft:     NewRaftOptions(),
-		External: NewExternalStorageOptions(),
-		LogLevel: "info",
+		Path:        raftstorage.DefaultDataDir,
+		Provider:    string(StorageProviderRaft),
+		Raft:        NewRaftOptions(),
+		External:    NewExternalStorageOptions(),
+		Postgres:    NewPostgresStorageOptions(),
+		LogLevel:    "info",
+		Credentials: NewCredentialStoreOptions(),
 	}
 }
 
@@ -97,6 +113,8 @@ func (o *StorageOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.StringVar(&o.LogFormat, prefix+"log-format", o.LogFormat, "Log format for the storage provider")
 	o.Raft.BindFlags(prefix+"raft.", fs)
 	o.External.BindFlags(prefix+"external.", fs)
+	o.Postgres.BindFlags(prefix+"postgres.", fs)
+	o.Credentials.BindFlags(prefix+"credentials.", fs)
 }
 
 // Validate validates the storage options.
@@ -117,9 +135,66 @@ func (o StorageOptions) Validate(isMember bool) error {
 			return err
 		}
 	}
+	if provider == StorageProviderPostgres {
+		if err := o.Postgres.Validate(); err != nil {
+			return err
+		}
+	}
+	if o.Credentials.Enabled && o.Credentials.Path == "" && o.Path == "" {
+		return fmt.Errorf("storage.credentials.path must be set when storage.path is empty")
+	}
 	return nil
 }
 
+// CredentialStoreOptions are options for the encrypted node-local credential store.
+// When enabled, private keys and TLS materials are kept encrypted under the data
+// directory. Generated plaintext keys are migrated into the store, while TLS
+// files provided by the operator are left in place and sealed copies are kept.
+type CredentialStoreOptions struct {
+	// Enabled enables the credential store.
+	Enabled bool `koanf:"enabled,omitempty"`
+	// Path is the path to the credential store. Defaults to a credentials
+	// directory under the storage path.
+	Path string `koanf:"path,omitempty"`
+	// Passphrase is an optional passphrase used to derive the store key. If unset,
+	// the key is bound to the machine ID of the current host, or to a random
+	// secret kept in the store directory when the host has no machine ID.
+	Passphrase string `koanf:"passphrase,omitempty"`
+}
+
+// NewCredentialStoreOptions returns new credential store options.
+func NewCredentialStoreOptions() CredentialStoreOptions {
+	return CredentialStoreOptions{
+		Enabled:    false,
+		Path:       "",
+		Passphrase: "",
+	}
+}
+
+// BindFlags binds the credential store options to the flag set.
+func (o *CredentialStoreOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
+	fs.BoolVar(&o.Enabled, prefix+"enabled", o.Enabled, "Keep private keys and TLS materials in an encrypted credential store")
+	fs.StringVar(&o.Path, prefix+"path", o.Path, "Path to the credential store (defaults to a credentials directory under the storage path)")
+	fs.StringVar(&o.Passphrase, prefix+"passphrase", o.Passphrase, "Passphrase for the credential store (defaults to a machine-bound key)")
+}
+
+// OpenCredentialStore opens the credential store if it is enabled. A nil store
+// is returned when the credential store is disabled.
+func (o StorageOptions) OpenCredentialStore() (*crypto.CredentialStore, error) {
+	if !o.Credentials.Enabled {
+		return nil, nil
+	}
+	path := o.Credentials.Path
+	if path == "" {
+		path = filepath.Join(o.Path, "credentials")
+	}
+	store, err := crypto.OpenCredentialStore(path, []byte(o.Credentials.Passphrase))
+	if err != nil {
+		return nil, fmt.Errorf("open credential store: %w", err)
+	}
+	return store, nil
+}
+
 // ListenPort returns the port to listen on for the storage provider.
 func (o StorageOptions) ListenPort() int {
 	if o.Provider == string(StorageProviderRaft) || o.Provider == "" {
@@ -140,6 +215,8 @@ func (o *Config) NewStorageProvider(ctx context.Context, node meshnode.Node, for
 		return o.Storage.NewRaftStorageProvider(ctx, node, force)
 	case StorageProviderExternal:
 		return o.Storage.NewExternalStorageProvider(ctx, node.ID())
+	case StorageProviderPostgres:
+		return o.Storage.NewPostgresStorageProvider(node.ID()), nil
 	case StorageProviderPassThrough:
 		return passthroughstorage.NewProvider(o.Storage.NewPassthroughOptions(ctx, node)), nil
 	default:
@@ -153,7 +230,50 @@ func (o StorageOptions) NewRaftStorageProvider(ctx context.Context, node meshnod
 	if err != nil {
 		return nil, err
 	}
-	return raftstorage.NewProvider(opts), nil
+	if o.Raft.Shards <= 1 {
+		return raftstorage.NewProvider(opts), nil
+	}
+	shards := []raftstorage.Options{opts}
+	for i := 1; i < o.Raft.Shards; i++ {
+		shard, err := o.NewRaftShardOptions(opts, node, i)
+		if err != nil {
+			for _, s := range shards {
+				_ = s.Transport.Close()
+			}
+			return nil, err
+		}
+		shards = append(shards, shard)
+	}
+	return shardedstorage.NewProvider(shardedstorage.Options{Shards: shards}), nil
+}
+
+// NewRaftShardOptions returns the raft options for the given shard derived from those of
+// the meta shard. Each shard keeps its data and snapshots apart from the others.
+func (o StorageOptions) NewRaftShardOptions(meta raftstorage.Options, node meshnode.Node, shard int) (raftstorage.Options, error) {
+	raftTransport, err := o.Raft.NewShardTransport(node, shard)
+	if err != nil {
+		return raftstorage.Options{}, fmt.Errorf("create raft transport for shard %d: %w", shard, err)
+	}
+	name := fmt.Sprintf("shard-%d", shard)
+	opts := meta
+	opts.Transport = raftTransport
+	opts.DataDir = filepath.Join(meta.DataDir, name)
+	if meta.S3Snapshots != nil {
+		s3opts := *meta.S3Snapshots
+		s3opts.Prefix = path.Join(s3opts.Prefix, name)
+		opts.S3Snapshots = &s3opts
+	}
+	if meta.SnapshotExport != nil {
+		export := *meta.SnapshotExport
+		export.Dir = filepath.Join(export.Dir, name)
+		if export.S3 != nil {
+			s3opts := *export.S3
+			s3opts.Prefix = path.Join(s3opts.Prefix, name)
+			export.S3 = &s3opts
+		}
+		opts.SnapshotExport = &export
+	}
+	return opts, nil
 }
 
 // NewExternalStorageProvider returns a new external storage provider for the current configuration.
@@ -165,6 +285,18 @@ func (o StorageOptions) NewExternalStorageProvider(ctx context.Context, nodeID t
 	return extstorage.NewProvider(opts), nil
 }
 
+// NewPostgresStorageProvider returns a new PostgreSQL storage provider for the current configuration.
+func (o StorageOptions) NewPostgresStorageProvider(nodeID types.NodeID) storage.Provider {
+	return pgstorage.NewProvider(pgstorage.Options{
+		NodeID:            nodeID,
+		ConnString:        o.Postgres.ConnString,
+		Table:             o.Postgres.Table,
+		HeartbeatInterval: o.Postgres.HeartbeatInterval,
+		LogLevel:          o.LogLevel,
+		LogFormat:         o.LogFormat,
+	})
+}
+
 // NewRaftOptions returns a new raft options for the current configuration.
 func (o StorageOptions) NewRaftOptions(ctx context.Context, node meshnode.Node, force bool) (raftstorage.Options, error) {
 	raftTransport, err := o.Raft.NewTransport(node)
@@ -183,10 +315,40 @@ func (o StorageOptions) NewRaftOptions(ctx context.Context, node meshnode.Node,
 	opts.CommitTimeout = o.Raft.CommitTimeout
 	opts.MaxAppendEntries = o.Raft.MaxAppendEntries
 	opts.LeaderLeaseTimeout = o.Raft.LeaderLeaseTimeout
+	opts.ElectionMultiplier = o.Raft.ElectionMultiplier
+	if o.Raft.StableLeader {
+		opts.StableLeader = &raftstorage.StableLeaderOptions{
+			MaxMultiplier: o.Raft.StableLeaderMaxMultiplier,
+		}
+	}
+	if o.Raft.TargetVoters > 0 {
+		opts.VoterPolicy = &raftstorage.VoterPolicyOptions{
+			TargetVoters: o.Raft.TargetVoters,
+			Interval:     o.Raft.VoterPolicyInterval,
+			FlakyTimeout: o.Raft.FlakyVoterTimeout,
+		}
+	}
 	opts.SnapshotInterval = o.Raft.SnapshotInterval
 	opts.SnapshotThreshold = o.Raft.SnapshotThreshold
 	opts.SnapshotRetention = o.Raft.SnapshotRetention
 	opts.ObserverChanBuffer = o.Raft.ObserverChanBuffer
+	opts.ScrubInterval = o.Raft.ScrubInterval
+	opts.ReadCacheSize = o.Raft.ReadCacheSize
+	opts.ReadCacheTTL = o.Raft.ReadCacheTTL
+	opts.GraphSnapshotInterval = o.Raft.GraphSnapshotInterval
+	opts.CheckInvariants = fsm.InvariantMode(o.Raft.CheckInvariants)
+	opts.IncrementalSnapshots = o.Raft.IncrementalSnapshots
+	opts.RaftLogFormat = fsm.LogFormat(o.Raft.LogFormat)
+	opts.RaftLogCompressionLevel = o.Raft.LogCompressionLevel
+	opts.LogStore = raftstorage.LogStore(o.Raft.LogStore)
+	opts.WAL = o.Raft.WALOptions()
+	opts.S3Snapshots = o.Raft.SnapshotS3.NewOptions()
+	opts.SnapshotExport = o.Raft.SnapshotExport.NewOptions()
+	opts.SnapshotEncryptionKey, err = o.Raft.LoadSnapshotEncryptionKey()
+	if err != nil {
+		return raftstorage.Options{}, err
+	}
+	opts.NodeKey = node.Key()
 	opts.LogLevel = o.LogLevel
 	opts.LogFormat = o.LogFormat
 	return opts, nil
@@ -230,6 +392,44 @@ func (o StorageOptions) NewExternalStorageOptions(ctx context.Context, nodeID ty
 	return opts, nil
 }
 
+// PostgresStorageOptions are the PostgreSQL storage options. All storage
+// members using the same database and table share the mesh state.
+type PostgresStorageOptions struct {
+	// ConnString is the PostgreSQL connection string.
+	ConnString string `koanf:"conn-string,omitempty"`
+	// Table is the name of the table to store mesh state in.
+	Table string `koanf:"table,omitempty"`
+	// HeartbeatInterval is the interval at which members refresh their
+	// membership and the leader verifies its lock.
+	HeartbeatInterval time.Duration `koanf:"heartbeat-interval,omitempty"`
+}
+
+// NewPostgresStorageOptions creates a new PostgreSQL storage options.
+func NewPostgresStorageOptions() PostgresStorageOptions {
+	return PostgresStorageOptions{
+		Table:             postgres.DefaultTable,
+		HeartbeatInterval: pgstorage.DefaultHeartbeatInterval,
+	}
+}
+
+// BindFlags binds the PostgreSQL storage options to the flag set.
+func (o *PostgresStorageOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
+	fs.StringVar(&o.ConnString, prefix+"conn-string", o.ConnString, "PostgreSQL connection string")
+	fs.StringVar(&o.Table, prefix+"table", o.Table, "Name of the table to store mesh state in")
+	fs.DurationVar(&o.HeartbeatInterval, prefix+"heartbeat-interval", o.HeartbeatInterval, "Interval at which members refresh their membership")
+}
+
+// Validate validates the PostgreSQL storage options.
+func (o PostgresStorageOptions) Validate() error {
+	if o.ConnString == "" {
+		return fmt.Errorf("postgres storage connection string is required")
+	}
+	if o.HeartbeatInterval < 0 {
+		return fmt.Errorf("postgres storage heartbeat interval must not be negative")
+	}
+	return nil
+}
+
 // ExternalStorageOptions are the external storage options.
 type ExternalStorageOptions struct {
 	// Server is the address of a server for the plugin.
@@ -15,3 +15,86 @@ limitations under the License.
 */
 
 package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+func TestCredentialStoreKeyMigration(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "wireguard.key")
+	key := crypto.MustGenerateKey()
+	if err := crypto.EncodeKeyToFile(key, keyFile); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+	conf := NewDefaultConfig("")
+	conf.Storage.Path = dir
+	conf.Storage.Credentials.Enabled = true
+	conf.Storage.Credentials.Passphrase = "passphrase"
+	conf.WireGuard.KeyFile = keyFile
+	loaded, err := conf.LoadKey(ctx)
+	if err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+	if !loaded.Equals(key) {
+		t.Fatal("expected migrated key to match the original key")
+	}
+	if _, err := os.Stat(keyFile); !os.IsNotExist(err) {
+		t.Fatalf("expected plaintext key file to be removed, got %v", err)
+	}
+	// A fresh configuration should load the same key from the store.
+	conf = NewDefaultConfig("")
+	conf.Storage.Path = dir
+	conf.Storage.Credentials.Enabled = true
+	conf.Storage.Credentials.Passphrase = "passphrase"
+	conf.WireGuard.KeyFile = keyFile
+	loaded, err = conf.LoadKey(ctx)
+	if err != nil {
+		t.Fatalf("load key: %v", err)
+	}
+	if !loaded.Equals(key) {
+		t.Fatal("expected stored key to match the original key")
+	}
+}
+
+func TestCredentialStoreReadsOperatorFiles(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	if err := os.WriteFile(certFile, []byte("certificate"), 0644); err != nil {
+		t.Fatalf("write cert file: %v", err)
+	}
+	conf := NewDefaultConfig("")
+	conf.Storage.Path = dir
+	conf.Storage.Credentials.Enabled = true
+	conf.Storage.Credentials.Passphrase = "passphrase"
+	data, err := conf.readCredentialFile(ctx, MTLSCertCredential, certFile)
+	if err != nil {
+		t.Fatalf("read credential file: %v", err)
+	}
+	if string(data) != "certificate" {
+		t.Fatalf("expected certificate, got %q", data)
+	}
+	if _, err := os.Stat(certFile); err != nil {
+		t.Fatalf("expected operator file to be left in place, got %v", err)
+	}
+	// The sealed copy is used once the file is gone.
+	if err := os.Remove(certFile); err != nil {
+		t.Fatalf("remove cert file: %v", err)
+	}
+	data, err = conf.readCredentialFile(ctx, MTLSCertCredential, certFile)
+	if err != nil {
+		t.Fatalf("read credential file: %v", err)
+	}
+	if string(data) != "certificate" {
+		t.Fatalf("expected certificate from the store, got %q", data)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package config
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -27,7 +28,10 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/conntrack"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
 )
 
@@ -67,6 +71,27 @@ type WireGuardOptions struct {
 	RecordMetricsInterval time.Duration `koanf:"record-metrics-interval,omitempty"`
 	// DisableFullTunnel will ignore routes for a default gateway.
 	DisableFullTunnel bool `koanf:"disable-full-tunnel,omitempty"`
+	// KeyEscrowRecoveryKey is the encoded public key of an organizational recovery key.
+	// When set, the WireGuard key is wrapped to it and escrowed with the mesh on join.
+	KeyEscrowRecoveryKey string `koanf:"key-escrow-recovery-key,omitempty"`
+	// Conntrack are options for connection tracking on userspace interfaces.
+	Conntrack ConntrackOptions `koanf:"conntrack,omitempty"`
+	// RouteHealth are options for avoiding unhealthy intermediate peers when routing.
+	RouteHealth RouteHealthOptions `koanf:"route-health,omitempty"`
+	// ExternalPeers leaves configuring peers on the interface to an external controller.
+	// The desired peers are streamed to it over the peer configuration API served
+	// with the admin API.
+	ExternalPeers bool `koanf:"external-peers,omitempty"`
+	// FirewallACLs enforces network ACLs with the system firewall on the
+	// WireGuard interface, in addition to pruning the peers they deny.
+	FirewallACLs bool `koanf:"firewall-acls,omitempty"`
+	// FirewallBackend is the backend used to enforce network ACLs and denied
+	// prefixes on Linux. One of netfilter or ebpf. The ebpf backend falls back
+	// to netfilter when the programs cannot be loaded.
+	FirewallBackend string `koanf:"firewall-backend,omitempty"`
+	// TrafficPolicies enforces the traffic policies this node is an enforcer
+	// of with tc rate limits on the WireGuard interface. Linux only.
+	TrafficPolicies bool `koanf:"traffic-policies,omitempty"`
 
 	// loaded is an already loaded key from the configuration.
 	loaded crypto.PrivateKey `koanf:"-"`
@@ -89,6 +114,64 @@ func NewWireGuardOptions() WireGuardOptions {
 		RecordMetrics:         false,
 		RecordMetricsInterval: time.Second * 10,
 		DisableFullTunnel:     false,
+		KeyEscrowRecoveryKey:  "",
+		Conntrack:             NewConntrackOptions(),
+		RouteHealth:           NewRouteHealthOptions(),
+		ExternalPeers:         false,
+		FirewallACLs:          false,
+		FirewallBackend:       string(firewall.BackendNetfilter),
+		TrafficPolicies:       false,
+	}
+}
+
+// RouteHealthOptions are options for health-aware routing. When enabled, addresses
+// of nodes reached through directly connected peers are moved to alternate paths
+// while the peer has not completed a recent WireGuard handshake.
+type RouteHealthOptions struct {
+	// Enabled enables health-aware routing.
+	Enabled bool `koanf:"enabled,omitempty"`
+	// MaxHandshakeAge is the maximum age of the last WireGuard handshake with a peer
+	// for it to be considered alive. Idle peers are only reliably reported as alive
+	// when wireguard.persistent-keepalive is set below this value.
+	MaxHandshakeAge time.Duration `koanf:"max-handshake-age,omitempty"`
+	// Interval is how often the health of directly connected peers is checked.
+	Interval time.Duration `koanf:"interval,omitempty"`
+}
+
+// NewRouteHealthOptions returns new RouteHealthOptions with sensible defaults.
+func NewRouteHealthOptions() RouteHealthOptions {
+	return RouteHealthOptions{
+		Enabled:         false,
+		MaxHandshakeAge: meshnet.DefaultMaxHandshakeAge,
+		Interval:        meshnet.DefaultRouteHealthInterval,
+	}
+}
+
+// ConntrackOptions are options for tracking connections through a userspace
+// WireGuard interface. Tracked connections are terminated when changes to the
+// network ACLs no longer allow them.
+type ConntrackOptions struct {
+	// Enabled enables connection tracking. It only takes effect when a TUN
+	// interface is used and no privileged helper is configured.
+	Enabled bool `koanf:"enabled,omitempty"`
+	// MaxFlows is the maximum number of tracked connections.
+	MaxFlows int `koanf:"max-flows,omitempty"`
+	// TCPIdleTimeout is the idle timeout for TCP connections.
+	TCPIdleTimeout time.Duration `koanf:"tcp-idle-timeout,omitempty"`
+	// UDPIdleTimeout is the idle timeout for UDP flows.
+	UDPIdleTimeout time.Duration `koanf:"udp-idle-timeout,omitempty"`
+	// OtherIdleTimeout is the idle timeout for all other flows.
+	OtherIdleTimeout time.Duration `koanf:"other-idle-timeout,omitempty"`
+}
+
+// NewConntrackOptions returns new ConntrackOptions with sensible defaults.
+func NewConntrackOptions() ConntrackOptions {
+	return ConntrackOptions{
+		Enabled:          false,
+		MaxFlows:         conntrack.DefaultMaxFlows,
+		TCPIdleTimeout:   conntrack.DefaultTCPIdleTimeout,
+		UDPIdleTimeout:   conntrack.DefaultUDPIdleTimeout,
+		OtherIdleTimeout: conntrack.DefaultOtherIdleTimeout,
 	}
 }
 
@@ -114,6 +197,83 @@ func (o *WireGuardOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.BoolVar(&o.RecordMetrics, prefix+"record-metrics", o.RecordMetrics, "Record WireGuard metrics. These are only exposed if the metrics server is enabled.")
 	fs.DurationVar(&o.RecordMetricsInterval, prefix+"record-metrics-interval", o.RecordMetricsInterval, "The interval at which to update WireGuard metrics.")
 	fs.BoolVar(&o.DisableFullTunnel, prefix+"disable-full-tunnel", o.DisableFullTunnel, "Ignore routes for a default gateway.")
+	fs.StringVar(&o.KeyEscrowRecoveryKey, prefix+"key-escrow-recovery-key", o.KeyEscrowRecoveryKey, "Public recovery key to escrow the WireGuard key to when joining.")
+	fs.BoolVar(&o.ExternalPeers, prefix+"external-peers", o.ExternalPeers, "Leave configuring peers to an external controller consuming the peer configuration API.")
+	fs.BoolVar(&o.FirewallACLs, prefix+"firewall-acls", o.FirewallACLs, "Enforce network ACLs on connections between peers with nftables, iptables, or pf rules on the WireGuard interface.")
+	fs.StringVar(&o.FirewallBackend, prefix+"firewall-backend", o.FirewallBackend, "Backend for enforcing network ACLs and denied prefixes on Linux. One of 'netfilter' or 'ebpf'. The ebpf backend falls back to netfilter when unsupported.")
+	fs.BoolVar(&o.TrafficPolicies, prefix+"traffic-policies", o.TrafficPolicies, "Enforce traffic policies with tc rate limits on the WireGuard interface (Linux only).")
+	o.Conntrack.BindFlags(prefix+"conntrack.", fs)
+	o.RouteHealth.BindFlags(prefix+"route-health.", fs)
+}
+
+// BindFlags binds the route health options to a flag set.
+func (o *RouteHealthOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
+	fs.BoolVar(&o.Enabled, prefix+"enabled", o.Enabled, "Avoid routing through directly connected peers that are not alive when an alternate path exists.")
+	fs.DurationVar(&o.MaxHandshakeAge, prefix+"max-handshake-age", o.MaxHandshakeAge, "Maximum age of the last WireGuard handshake for a peer to be considered alive.")
+	fs.DurationVar(&o.Interval, prefix+"interval", o.Interval, "How often to check the health of directly connected peers.")
+}
+
+// Validate validates the route health options.
+func (o *RouteHealthOptions) Validate() error {
+	if !o.Enabled {
+		return nil
+	}
+	if o.MaxHandshakeAge < 0 {
+		return fmt.Errorf("wireguard.route-health.max-handshake-age must be greater than or equal to 0")
+	}
+	if o.Interval < 0 {
+		return fmt.Errorf("wireguard.route-health.interval must be greater than or equal to 0")
+	}
+	return nil
+}
+
+// Options returns the route health options for the network manager, or nil
+// if health-aware routing is disabled.
+func (o *RouteHealthOptions) Options() *meshnet.RouteHealthOptions {
+	if !o.Enabled {
+		return nil
+	}
+	return &meshnet.RouteHealthOptions{
+		MaxHandshakeAge: o.MaxHandshakeAge,
+		Interval:        o.Interval,
+	}
+}
+
+// BindFlags binds the conntrack options to a flag set.
+func (o *ConntrackOptions) BindFlags(prefix string, fs *pflag.FlagSet) {
+	fs.BoolVar(&o.Enabled, prefix+"enabled", o.Enabled, "Track connections through userspace interfaces and terminate those no longer allowed by network ACLs.")
+	fs.IntVar(&o.MaxFlows, prefix+"max-flows", o.MaxFlows, "The maximum number of tracked connections.")
+	fs.DurationVar(&o.TCPIdleTimeout, prefix+"tcp-idle-timeout", o.TCPIdleTimeout, "The idle timeout for TCP connections.")
+	fs.DurationVar(&o.UDPIdleTimeout, prefix+"udp-idle-timeout", o.UDPIdleTimeout, "The idle timeout for UDP flows.")
+	fs.DurationVar(&o.OtherIdleTimeout, prefix+"other-idle-timeout", o.OtherIdleTimeout, "The idle timeout for all other flows.")
+}
+
+// Validate validates the conntrack options.
+func (o *ConntrackOptions) Validate() error {
+	if !o.Enabled {
+		return nil
+	}
+	if o.MaxFlows <= 0 {
+		return fmt.Errorf("wireguard.conntrack.max-flows must be greater than 0")
+	}
+	if o.TCPIdleTimeout <= 0 || o.UDPIdleTimeout <= 0 || o.OtherIdleTimeout <= 0 {
+		return fmt.Errorf("wireguard.conntrack idle timeouts must be greater than 0")
+	}
+	return nil
+}
+
+// Options returns the conntrack options for the network manager, or nil
+// if connection tracking is disabled.
+func (o *ConntrackOptions) Options() *conntrack.Options {
+	if !o.Enabled {
+		return nil
+	}
+	opts := conntrack.NewOptions()
+	opts.MaxFlows = o.MaxFlows
+	opts.TCPIdleTimeout = o.TCPIdleTimeout
+	opts.UDPIdleTimeout = o.UDPIdleTimeout
+	opts.OtherIdleTimeout = o.OtherIdleTimeout
+	return &opts
 }
 
 // Validate validates the options.
@@ -135,7 +295,18 @@ func (o *WireGuardOptions) Validate() error {
 			return fmt.Errorf("wireguard.record-metrics-interval must be greater than 0")
 		}
 	}
-	return nil
+	if o.KeyEscrowRecoveryKey != "" {
+		if _, err := crypto.DecodePublicKey(o.KeyEscrowRecoveryKey); err != nil {
+			return fmt.Errorf("wireguard.key-escrow-recovery-key is invalid: %w", err)
+		}
+	}
+	if !firewall.Backend(o.FirewallBackend).IsValid() {
+		return fmt.Errorf("wireguard.firewall-backend must be one of netfilter or ebpf")
+	}
+	if err := o.Conntrack.Validate(); err != nil {
+		return err
+	}
+	return o.RouteHealth.Validate()
 }
 
 // LoadKey loads the key from the given configuration.
@@ -210,3 +381,57 @@ func (o *WireGuardOptions) LoadKey(ctx context.Context) (crypto.PrivateKey, erro
 	o.loaded = key
 	return key, nil
 }
+
+// WireGuardKeyCredential is the name of the WireGuard key in the credential store.
+const WireGuardKeyCredential = "wireguard.key"
+
+// LoadKeyFromStore is like LoadKey but keeps the key in the given credential store
+// instead of a plaintext file. An existing key file is migrated into the store.
+// Ephemeral keys are still used when no key file is configured.
+func (o *WireGuardOptions) LoadKeyFromStore(ctx context.Context, store *crypto.CredentialStore) (crypto.PrivateKey, error) {
+	log := context.LoggerFrom(ctx)
+	if o.loaded != nil || o.KeyFile == "" || store == nil {
+		return o.LoadKey(ctx)
+	}
+	imported, err := store.Import(WireGuardKeyCredential, o.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("migrate wireguard key file: %w", err)
+	}
+	if imported {
+		log.Info("Migrated WireGuard key file into the credential store", slog.String("file", o.KeyFile))
+	}
+	modTime, err := store.ModTime(WireGuardKeyCredential)
+	if err == nil && o.KeyRotationInterval > 0 && modTime.Add(o.KeyRotationInterval).Before(time.Now()) {
+		log.Debug("Removing expired WireGuard key from the credential store")
+		if err := store.Delete(WireGuardKeyCredential); err != nil {
+			return nil, fmt.Errorf("remove expired wireguard key: %w", err)
+		}
+	}
+	keyData, err := store.Get(WireGuardKeyCredential)
+	if err != nil && !errors.Is(err, crypto.ErrCredentialNotFound) {
+		return nil, fmt.Errorf("read wireguard key: %w", err)
+	} else if err == nil {
+		log.Debug("Loading WireGuard key from the credential store")
+		key, err := crypto.DecodePrivateKey(strings.TrimSpace(string(keyData)))
+		if err != nil {
+			return nil, fmt.Errorf("decode key: %w", err)
+		}
+		o.loaded = key
+		return key, nil
+	}
+	log.Debug("Generating new WireGuard key and saving to the credential store")
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("generate new key: %w", err)
+	}
+	encoded, err := key.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("encode key: %w", err)
+	}
+	err = store.Put(WireGuardKeyCredential, []byte(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("save key: %w", err)
+	}
+	o.loaded = key
+	return key, nil
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package context
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDMeta is the metadata key used to carry the request ID between
+// clients, nodes, and plugins. It is also returned to clients in the
+// response headers.
+const RequestIDMeta = "x-webmesh-request-id"
+
+// RequestIDLogKey is the structured logging key for request IDs.
+const RequestIDLogKey = "request-id"
+
+type requestIDContextKey struct{}
+
+// NewRequestID generates a new random request ID.
+func NewRequestID() string {
+	return uuid.NewString()
+}
+
+// WithRequestID returns a context with the given request ID set. The logger
+// in the context is also annotated with the request ID.
+func WithRequestID(ctx Context, id string) Context {
+	ctx = context.WithValue(ctx, requestIDContextKey{}, id)
+	return WithLogger(ctx, LoggerFrom(ctx).With(slog.String(RequestIDLogKey, id)))
+}
+
+// RequestIDFrom returns the request ID from the context.
+func RequestIDFrom(ctx Context) (string, bool) {
+	id, ok := ctx.Value(requestIDContextKey{}).(string)
+	return id, ok && id != ""
+}
+
+// AppendRequestIDToOutgoing appends the request ID in the context, if any,
+// to the outgoing gRPC metadata.
+func AppendRequestIDToOutgoing(ctx Context) Context {
+	id, ok := RequestIDFrom(ctx)
+	if !ok {
+		return ctx
+	}
+	if md, ok := metadata.FromOutgoingContext(ctx); ok && len(md.Get(RequestIDMeta)) > 0 {
+		return ctx
+	}
+	return metadata.AppendToOutgoingContext(ctx, RequestIDMeta, id)
+}
+
+// incomingRequestID returns the request ID from the incoming metadata or
+// generates a new one.
+func incomingRequestID(ctx Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(RequestIDMeta); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+	return NewRequestID()
+}
+
+// RequestIDUnaryServerInterceptor returns a unary server interceptor that
+// reads the request ID from the incoming metadata, or generates one, and
+// sets it in the context and the response headers. It should be installed
+// after the log injection interceptor.
+func RequestIDUnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		id := incomingRequestID(ctx)
+		if err := grpc.SetHeader(ctx, metadata.Pairs(RequestIDMeta, id)); err != nil {
+			LoggerFrom(ctx).Debug("Failed to set request ID header", slog.String("error", err.Error()))
+		}
+		return handler(WithRequestID(ctx, id), req)
+	}
+}
+
+// RequestIDStreamServerInterceptor returns a stream server interceptor that
+// reads the request ID from the incoming metadata, or generates one, and
+// sets it in the context and the response headers. It should be installed
+// after the log injection interceptor.
+func RequestIDStreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		id := incomingRequestID(ss.Context())
+		if err := ss.SetHeader(metadata.Pairs(RequestIDMeta, id)); err != nil {
+			LoggerFrom(ss.Context()).Debug("Failed to set request ID header", slog.String("error", err.Error()))
+		}
+		return handler(srv, &requestIDServerStream{ss, WithRequestID(ss.Context(), id)})
+	}
+}
+
+type requestIDServerStream struct {
+	grpc.ServerStream
+	ctx Context
+}
+
+func (ss *requestIDServerStream) Context() Context {
+	return ss.ctx
+}
+
+// RequestIDUnaryClientInterceptor returns a unary client interceptor that
+// forwards the request ID in the context to the server.
+func RequestIDUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(AppendRequestIDToOutgoing(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+// RequestIDStreamClientInterceptor returns a stream client interceptor that
+// forwards the request ID in the context to the server.
+func RequestIDStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(AppendRequestIDToOutgoing(ctx), desc, cc, method, opts...)
+	}
+}
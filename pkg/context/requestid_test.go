@@ -0,0 +1,95 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package context
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type fakeTransportStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (f *fakeTransportStream) SetHeader(md metadata.MD) error {
+	f.header = metadata.Join(f.header, md)
+	return nil
+}
+
+func TestRequestIDUnaryServerInterceptor(t *testing.T) {
+	t.Parallel()
+	icep := RequestIDUnaryServerInterceptor()
+	handler := func(ctx context.Context, req any) (any, error) {
+		id, ok := RequestIDFrom(ctx)
+		if !ok {
+			t.Fatal("expected request ID in handler context")
+		}
+		return id, nil
+	}
+
+	t.Run("GeneratesID", func(t *testing.T) {
+		t.Parallel()
+		stream := &fakeTransportStream{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		resp, err := icep(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.(string) == "" {
+			t.Fatal("expected a generated request ID")
+		}
+		if got := stream.header.Get(RequestIDMeta); len(got) != 1 || got[0] != resp.(string) {
+			t.Fatalf("expected response header %q, got %v", resp, got)
+		}
+	})
+
+	t.Run("PropagatesIncomingID", func(t *testing.T) {
+		t.Parallel()
+		stream := &fakeTransportStream{}
+		ctx := grpc.NewContextWithServerTransportStream(context.Background(), stream)
+		ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(RequestIDMeta, "abc"))
+		resp, err := icep(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp.(string) != "abc" {
+			t.Fatalf("expected request ID abc, got %v", resp)
+		}
+		if got := stream.header.Get(RequestIDMeta); len(got) != 1 || got[0] != "abc" {
+			t.Fatalf("expected response header abc, got %v", got)
+		}
+	})
+}
+
+func TestAppendRequestIDToOutgoing(t *testing.T) {
+	t.Parallel()
+	ctx := AppendRequestIDToOutgoing(context.Background())
+	if _, ok := metadata.FromOutgoingContext(ctx); ok {
+		t.Fatal("expected no outgoing metadata without a request ID")
+	}
+	ctx = WithRequestID(context.Background(), "abc")
+	ctx = AppendRequestIDToOutgoing(ctx)
+	ctx = AppendRequestIDToOutgoing(ctx)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	if got := md.Get(RequestIDMeta); len(got) != 1 || got[0] != "abc" {
+		t.Fatalf("expected a single outgoing request ID abc, got %v", got)
+	}
+}
@@ -0,0 +1,318 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// credentialStoreVersion is the version byte prefixed to every sealed credential.
+	credentialStoreVersion byte = 1
+	// credentialStoreSaltFile is the name of the file holding the key derivation salt.
+	credentialStoreSaltFile = ".salt"
+	// credentialStoreSecretFile is the name of the file holding the generated
+	// secret on machines without a machine ID.
+	credentialStoreSecretFile = ".secret"
+	// credentialStoreSuffix is the file suffix used for sealed credentials.
+	credentialStoreSuffix = ".sealed"
+	// credentialStoreInfo is the HKDF info string used when deriving the store key.
+	credentialStoreInfo = "webmesh-credential-store-v1"
+)
+
+// ErrCredentialNotFound is returned when a credential does not exist in the store.
+var ErrCredentialNotFound = errors.New("credential not found")
+
+// ErrNoMachineSecret is returned by MachineSecret when the system has no machine ID.
+var ErrNoMachineSecret = errors.New("no machine id available")
+
+// MachineIDFiles are the files checked, in order, for a stable machine identifier
+// when deriving a machine-bound credential store key.
+var MachineIDFiles = []string{
+	"/etc/machine-id",
+	"/var/lib/dbus/machine-id",
+}
+
+// MachineSecret returns a stable, machine-specific secret that can be used to
+// derive a credential store key. ErrNoMachineSecret is returned when no machine
+// ID is available on the system.
+func MachineSecret() ([]byte, error) {
+	return machineSecret(MachineIDFiles)
+}
+
+func machineSecret(files []string) ([]byte, error) {
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err == nil && len(bytes.TrimSpace(data)) > 0 {
+			return bytes.TrimSpace(data), nil
+		}
+	}
+	return nil, ErrNoMachineSecret
+}
+
+// CredentialStore is a small encrypted store for node-local secrets such as
+// private keys, join tokens, and TLS materials. Each credential is sealed with
+// AES-256-GCM using a key derived from a secret and a per-store random salt.
+type CredentialStore struct {
+	dir  string
+	aead cipher.AEAD
+	mu   sync.Mutex
+}
+
+// OpenCredentialStore opens or creates the credential store at the given directory.
+// The secret is used to derive the encryption key. If it is empty, the secret
+// returned by MachineSecret is used, binding the store to the current machine.
+// On machines without a machine ID, a random secret is generated and kept
+// in the store directory, readable only by its owner.
+func OpenCredentialStore(dir string, secret []byte) (*CredentialStore, error) {
+	return openCredentialStore(dir, secret, MachineIDFiles)
+}
+
+func openCredentialStore(dir string, secret []byte, machineIDFiles []string) (*CredentialStore, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("credential store directory must be set")
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, fmt.Errorf("create credential store directory: %w", err)
+	}
+	if len(secret) == 0 {
+		secret, err = machineSecret(machineIDFiles)
+		if errors.Is(err, ErrNoMachineSecret) {
+			secret, err = loadOrCreateRandom(filepath.Join(dir, credentialStoreSecretFile), "secret")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	salt, err := loadOrCreateRandom(filepath.Join(dir, credentialStoreSaltFile), "salt")
+	if err != nil {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	_, err = io.ReadFull(hkdf.New(sha256.New, secret, salt, []byte(credentialStoreInfo)), key)
+	if err != nil {
+		return nil, fmt.Errorf("derive credential store key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+	return &CredentialStore{dir: dir, aead: aead}, nil
+}
+
+// Dir returns the directory backing the credential store.
+func (c *CredentialStore) Dir() string {
+	return c.dir
+}
+
+// Put seals and writes the given credential to the store.
+func (c *CredentialStore) Put(name string, data []byte) error {
+	path, err := c.pathFor(name)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("generate nonce: %w", err)
+	}
+	sealed := make([]byte, 0, 1+len(nonce)+len(data)+c.aead.Overhead())
+	sealed = append(sealed, credentialStoreVersion)
+	sealed = append(sealed, nonce...)
+	sealed = c.aead.Seal(sealed, nonce, data, []byte(name))
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeFileAtomic(path, sealed, 0600)
+}
+
+// Get reads and opens the given credential from the store. ErrCredentialNotFound
+// is returned if it does not exist.
+func (c *CredentialStore) Get(name string) ([]byte, error) {
+	path, err := c.pathFor(name)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%w: %s", ErrCredentialNotFound, name)
+		}
+		return nil, fmt.Errorf("read credential: %w", err)
+	}
+	nonceSize := c.aead.NonceSize()
+	if len(sealed) < 1+nonceSize || sealed[0] != credentialStoreVersion {
+		return nil, fmt.Errorf("credential %s is malformed or has an unsupported version", name)
+	}
+	data, err := c.aead.Open(nil, sealed[1:1+nonceSize], sealed[1+nonceSize:], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("open credential %s: %w", name, err)
+	}
+	return data, nil
+}
+
+// Has returns true if the given credential exists in the store.
+func (c *CredentialStore) Has(name string) bool {
+	_, err := c.ModTime(name)
+	return err == nil
+}
+
+// ModTime returns the last time the given credential was written.
+func (c *CredentialStore) ModTime(name string) (time.Time, error) {
+	path, err := c.pathFor(name)
+	if err != nil {
+		return time.Time{}, err
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return time.Time{}, fmt.Errorf("%w: %s", ErrCredentialNotFound, name)
+		}
+		return time.Time{}, err
+	}
+	return stat.ModTime(), nil
+}
+
+// Delete removes the given credential from the store. It is not an error
+// if the credential does not exist.
+func (c *CredentialStore) Delete(name string) error {
+	path, err := c.pathFor(name)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("remove credential: %w", err)
+	}
+	return nil
+}
+
+// List returns the names of all credentials in the store.
+func (c *CredentialStore) List() ([]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return nil, fmt.Errorf("read credential store directory: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), credentialStoreSuffix) {
+			continue
+		}
+		names = append(names, strings.TrimSuffix(entry.Name(), credentialStoreSuffix))
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Import migrates a plaintext file into the store under the given name and
+// removes the original. It returns false if the file did not exist.
+func (c *CredentialStore) Import(name, path string) (bool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return false, fmt.Errorf("read %s: %w", path, err)
+	}
+	err = c.Put(name, data)
+	if err != nil {
+		return false, err
+	}
+	err = os.Remove(path)
+	if err != nil {
+		return true, fmt.Errorf("remove plaintext %s: %w", path, err)
+	}
+	return true, nil
+}
+
+func (c *CredentialStore) pathFor(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		return "", fmt.Errorf("invalid credential name %q", name)
+	}
+	return filepath.Join(c.dir, name+credentialStoreSuffix), nil
+}
+
+func loadOrCreateRandom(path string, what string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if len(data) != 32 {
+			return nil, fmt.Errorf("credential store %s at %s is corrupt", what, path)
+		}
+		return data, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("read credential store %s: %w", what, err)
+	}
+	data = make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return nil, fmt.Errorf("generate credential store %s: %w", what, err)
+	}
+	err = writeFileAtomic(path, data, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("write credential store %s: %w", what, err)
+	}
+	return data, nil
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
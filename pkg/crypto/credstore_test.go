@@ -0,0 +1,187 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCredentialStore(t *testing.T) {
+	t.Parallel()
+
+	t.Run("PutAndGet", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		store, err := OpenCredentialStore(dir, []byte("secret"))
+		if err != nil {
+			t.Fatalf("open credential store: %v", err)
+		}
+		if err := store.Put("wireguard.key", []byte("private-key")); err != nil {
+			t.Fatalf("put credential: %v", err)
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, "wireguard.key"+credentialStoreSuffix))
+		if err != nil {
+			t.Fatalf("read sealed credential: %v", err)
+		}
+		if bytes.Contains(raw, []byte("private-key")) {
+			t.Fatal("expected credential to be encrypted at rest")
+		}
+		// Reopening the store with the same secret should decrypt the credential.
+		store, err = OpenCredentialStore(dir, []byte("secret"))
+		if err != nil {
+			t.Fatalf("reopen credential store: %v", err)
+		}
+		data, err := store.Get("wireguard.key")
+		if err != nil {
+			t.Fatalf("get credential: %v", err)
+		}
+		if string(data) != "private-key" {
+			t.Fatalf("expected private-key, got %q", data)
+		}
+		names, err := store.List()
+		if err != nil {
+			t.Fatalf("list credentials: %v", err)
+		}
+		if len(names) != 1 || names[0] != "wireguard.key" {
+			t.Fatalf("expected [wireguard.key], got %v", names)
+		}
+	})
+
+	t.Run("GeneratedSecret", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		missing := []string{filepath.Join(dir, "machine-id")}
+		store, err := openCredentialStore(dir, nil, missing)
+		if err != nil {
+			t.Fatalf("open credential store: %v", err)
+		}
+		if err := store.Put("token", []byte("data")); err != nil {
+			t.Fatalf("put credential: %v", err)
+		}
+		stat, err := os.Stat(filepath.Join(dir, credentialStoreSecretFile))
+		if err != nil {
+			t.Fatalf("stat generated secret: %v", err)
+		}
+		if stat.Mode().Perm() != 0600 {
+			t.Fatalf("expected generated secret to be private, got %v", stat.Mode().Perm())
+		}
+		store, err = openCredentialStore(dir, nil, missing)
+		if err != nil {
+			t.Fatalf("reopen credential store: %v", err)
+		}
+		data, err := store.Get("token")
+		if err != nil {
+			t.Fatalf("get credential: %v", err)
+		}
+		if string(data) != "data" {
+			t.Fatalf("expected data, got %q", data)
+		}
+		if _, err := machineSecret(missing); !errors.Is(err, ErrNoMachineSecret) {
+			t.Fatalf("expected ErrNoMachineSecret, got %v", err)
+		}
+	})
+
+	t.Run("WrongSecret", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		store, err := OpenCredentialStore(dir, []byte("secret"))
+		if err != nil {
+			t.Fatalf("open credential store: %v", err)
+		}
+		if err := store.Put("token", []byte("data")); err != nil {
+			t.Fatalf("put credential: %v", err)
+		}
+		store, err = OpenCredentialStore(dir, []byte("other-secret"))
+		if err != nil {
+			t.Fatalf("reopen credential store: %v", err)
+		}
+		if _, err := store.Get("token"); err == nil {
+			t.Fatal("expected error opening credential with the wrong secret")
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		store, err := OpenCredentialStore(t.TempDir(), []byte("secret"))
+		if err != nil {
+			t.Fatalf("open credential store: %v", err)
+		}
+		if _, err := store.Get("missing"); !errors.Is(err, ErrCredentialNotFound) {
+			t.Fatalf("expected ErrCredentialNotFound, got %v", err)
+		}
+		if store.Has("missing") {
+			t.Fatal("expected store to not have missing credential")
+		}
+		if err := store.Delete("missing"); err != nil {
+			t.Fatalf("expected no error deleting missing credential, got %v", err)
+		}
+	})
+
+	t.Run("InvalidNames", func(t *testing.T) {
+		t.Parallel()
+		store, err := OpenCredentialStore(t.TempDir(), []byte("secret"))
+		if err != nil {
+			t.Fatalf("open credential store: %v", err)
+		}
+		for _, name := range []string{"", "../escape", "a/b", ".salt"} {
+			if err := store.Put(name, []byte("data")); err == nil {
+				t.Fatalf("expected error for invalid name %q", name)
+			}
+		}
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		t.Parallel()
+		dir := t.TempDir()
+		store, err := OpenCredentialStore(filepath.Join(dir, "credentials"), []byte("secret"))
+		if err != nil {
+			t.Fatalf("open credential store: %v", err)
+		}
+		plaintext := filepath.Join(dir, "key")
+		if err := os.WriteFile(plaintext, []byte("private-key"), 0600); err != nil {
+			t.Fatalf("write plaintext file: %v", err)
+		}
+		imported, err := store.Import("key", plaintext)
+		if err != nil {
+			t.Fatalf("import credential: %v", err)
+		}
+		if !imported {
+			t.Fatal("expected credential to be imported")
+		}
+		if _, err := os.Stat(plaintext); !os.IsNotExist(err) {
+			t.Fatalf("expected plaintext file to be removed, got %v", err)
+		}
+		data, err := store.Get("key")
+		if err != nil {
+			t.Fatalf("get credential: %v", err)
+		}
+		if string(data) != "private-key" {
+			t.Fatalf("expected private-key, got %q", data)
+		}
+		imported, err = store.Import("key", plaintext)
+		if err != nil {
+			t.Fatalf("import missing file: %v", err)
+		}
+		if imported {
+			t.Fatal("expected nothing to be imported for a missing file")
+		}
+	})
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// escrowVersion is the version byte prefixed to wrapped keys.
+	escrowVersion byte = 1
+	// escrowInfo is the HKDF info string used when deriving the wrapping key.
+	escrowInfo = "webmesh-key-escrow-v1"
+)
+
+// WrapKey wraps the given private key to a recovery public key so that it
+// can be escrowed and later recovered by the holder of the recovery private key.
+// The key is sealed with AES-256-GCM using a key derived from an ephemeral
+// X25519 exchange with the recovery key.
+func WrapKey(key PrivateKey, recovery PublicKey) ([]byte, error) {
+	marshaled, err := key.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	var ephemeral [curve25519.ScalarSize]byte
+	if _, err := rand.Read(ephemeral[:]); err != nil {
+		return nil, fmt.Errorf("generate ephemeral key: %w", err)
+	}
+	ephemeralPub, err := curve25519.X25519(ephemeral[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, fmt.Errorf("compute ephemeral public key: %w", err)
+	}
+	recipient := recovery.WireGuardKey()
+	shared, err := curve25519.X25519(ephemeral[:], recipient[:])
+	if err != nil {
+		return nil, fmt.Errorf("compute shared secret: %w", err)
+	}
+	aead, err := newEscrowAEAD(shared, ephemeralPub, recipient[:])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	out := make([]byte, 0, 1+len(ephemeralPub)+len(nonce)+len(marshaled)+aead.Overhead())
+	out = append(out, escrowVersion)
+	out = append(out, ephemeralPub...)
+	out = append(out, nonce...)
+	return aead.Seal(out, nonce, marshaled, []byte{escrowVersion}), nil
+}
+
+// UnwrapKey recovers a private key wrapped with WrapKey using the recovery private key.
+func UnwrapKey(data []byte, recovery PrivateKey) (PrivateKey, error) {
+	if len(data) < 1+curve25519.PointSize || data[0] != escrowVersion {
+		return nil, fmt.Errorf("wrapped key is malformed or has an unsupported version")
+	}
+	ephemeralPub := data[1 : 1+curve25519.PointSize]
+	priv := recovery.WireGuardKey()
+	recipient := recovery.PublicKey().WireGuardKey()
+	shared, err := curve25519.X25519(priv[:], ephemeralPub)
+	if err != nil {
+		return nil, fmt.Errorf("compute shared secret: %w", err)
+	}
+	aead, err := newEscrowAEAD(shared, ephemeralPub, recipient[:])
+	if err != nil {
+		return nil, err
+	}
+	rest := data[1+curve25519.PointSize:]
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is malformed")
+	}
+	marshaled, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], []byte{escrowVersion})
+	if err != nil {
+		return nil, fmt.Errorf("unwrap key: %w", err)
+	}
+	return UnmarshalPrivateKey(marshaled)
+}
+
+func newEscrowAEAD(shared, ephemeralPub, recipient []byte) (cipher.AEAD, error) {
+	salt := make([]byte, 0, len(ephemeralPub)+len(recipient))
+	salt = append(salt, ephemeralPub...)
+	salt = append(salt, recipient...)
+	key := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(escrowInfo)), key)
+	if err != nil {
+		return nil, fmt.Errorf("derive wrapping key: %w", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package crypto
+
+import (
+	"testing"
+)
+
+func TestKeyEscrow(t *testing.T) {
+	t.Parallel()
+
+	t.Run("WrapAndUnwrap", func(t *testing.T) {
+		t.Parallel()
+		key := MustGenerateKey()
+		recovery := MustGenerateKey()
+		wrapped, err := WrapKey(key, recovery.PublicKey())
+		if err != nil {
+			t.Fatalf("wrap key: %v", err)
+		}
+		unwrapped, err := UnwrapKey(wrapped, recovery)
+		if err != nil {
+			t.Fatalf("unwrap key: %v", err)
+		}
+		if !unwrapped.Equals(key) {
+			t.Fatal("expected unwrapped key to match the original key")
+		}
+	})
+
+	t.Run("WrongRecoveryKey", func(t *testing.T) {
+		t.Parallel()
+		wrapped, err := WrapKey(MustGenerateKey(), MustGenerateKey().PublicKey())
+		if err != nil {
+			t.Fatalf("wrap key: %v", err)
+		}
+		if _, err := UnwrapKey(wrapped, MustGenerateKey()); err == nil {
+			t.Fatal("expected error unwrapping with the wrong recovery key")
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		t.Parallel()
+		if _, err := UnwrapKey([]byte{escrowVersion, 1, 2, 3}, MustGenerateKey()); err == nil {
+			t.Fatal("expected error unwrapping malformed data")
+		}
+	})
+}
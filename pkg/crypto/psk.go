@@ -23,7 +23,12 @@ import (
 	"crypto/sha256"
 	"fmt"
 	"hash"
+	"io"
+	"math"
 	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/hkdf"
 )
 
 // DefaultPSKLength is the default length of a PSK.
@@ -84,6 +89,132 @@ func GeneratePSKWithLength(length int) (PSK, error) {
 	return b, nil
 }
 
+// PSKPolicy is a minimum quality required of a PSK.
+type PSKPolicy struct {
+	// MinLength is the minimum length of the PSK.
+	MinLength int
+	// MinEntropyBits is the minimum estimated entropy of the PSK in bits.
+	MinEntropyBits float64
+}
+
+// DefaultPSKPolicy is the default PSK policy. Generated PSKs of the default
+// length satisfy it.
+var DefaultPSKPolicy = PSKPolicy{
+	MinLength:      16,
+	MinEntropyBits: 56,
+}
+
+// Check returns an error if the given PSK does not satisfy the policy.
+func (p PSKPolicy) Check(psk []byte) error {
+	if len(psk) < p.MinLength {
+		return fmt.Errorf("psk must be at least %d characters", p.MinLength)
+	}
+	if bits := EstimatePSKEntropy(psk); bits < p.MinEntropyBits {
+		return fmt.Errorf("psk is too weak: estimated %.0f bits of entropy, need %.0f", bits, p.MinEntropyBits)
+	}
+	return nil
+}
+
+// EstimatePSKEntropy returns a rough estimate of the entropy of the given PSK
+// in bits. It is the smaller of the Shannon entropy of the character
+// frequencies over the whole PSK, and the entropy of picking each distinct
+// character from the character classes used. Repeated characters and
+// patterns therefore add little.
+func EstimatePSKEntropy(psk []byte) float64 {
+	if len(psk) == 0 {
+		return 0
+	}
+	var pool int
+	classes := make(map[charClass]bool)
+	counts := make(map[byte]int)
+	for _, c := range psk {
+		counts[c]++
+		class := classOf(c)
+		if !classes[class] {
+			classes[class] = true
+			pool += class.size()
+		}
+	}
+	var shannon float64
+	for _, n := range counts {
+		p := float64(n) / float64(len(psk))
+		shannon -= p * math.Log2(p)
+	}
+	return math.Min(float64(len(psk))*shannon, float64(len(counts))*math.Log2(float64(pool)))
+}
+
+// charClass is a class of characters used to estimate PSK entropy.
+type charClass int
+
+const (
+	classLower charClass = iota
+	classUpper
+	classDigit
+	classSymbol
+)
+
+func classOf(c byte) charClass {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return classLower
+	case c >= 'A' && c <= 'Z':
+		return classUpper
+	case c >= '0' && c <= '9':
+		return classDigit
+	default:
+		return classSymbol
+	}
+}
+
+// size returns the number of characters in the class.
+func (c charClass) size() int {
+	switch c {
+	case classLower, classUpper:
+		return 26
+	case classDigit:
+		return 10
+	default:
+		return 33
+	}
+}
+
+// DerivePSK derives a PSK of the default length from a passphrase using
+// argon2id. The salt should be unique to the mesh, such as its domain, so
+// the same passphrase does not produce the same PSK across meshes.
+func DerivePSK(passphrase string, salt []byte) (PSK, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("passphrase must not be empty")
+	}
+	if len(salt) == 0 {
+		return nil, fmt.Errorf("salt must not be empty")
+	}
+	b := argon2.IDKey([]byte(passphrase), salt, 3, 64*1024, 4, DefaultPSKLength)
+	return toPSK(b), nil
+}
+
+// DeriveSessionPSK derives a PSK of the default length for the given session
+// from a master secret. Every session gets an unrelated PSK, so a PSK leaked
+// from one session reveals nothing about the master secret or other sessions.
+func DeriveSessionPSK(master PSK, session string) (PSK, error) {
+	if len(master) == 0 {
+		return nil, fmt.Errorf("master secret must not be empty")
+	}
+	b := make([]byte, DefaultPSKLength)
+	_, err := io.ReadFull(hkdf.New(sha256.New, master, nil, []byte("webmesh-session-psk-v1:"+session)), b)
+	if err != nil {
+		return nil, fmt.Errorf("derive session psk: %w", err)
+	}
+	return toPSK(b), nil
+}
+
+// toPSK maps random bytes onto the valid PSK characters.
+func toPSK(b []byte) PSK {
+	for i := range b {
+		b[i] = ValidPSKChars[int(b[i])%len(ValidPSKChars)]
+	}
+	return b
+}
+
 // MustGeneratePSK generates a PSK and panics on error.
 func MustGeneratePSK() PSK {
 	psk, err := GeneratePSK()
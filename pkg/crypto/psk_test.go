@@ -104,3 +104,87 @@ func TestPSK(t *testing.T) {
 		}
 	})
 }
+
+func TestPSKPolicy(t *testing.T) {
+	t.Parallel()
+
+	t.Run("WeakPSKs", func(t *testing.T) {
+		t.Parallel()
+		tc := []string{
+			"",
+			"campfire",
+			strings.Repeat("a", 64),
+			strings.Repeat("ab", 32),
+			"0123456789012345",
+		}
+		for _, psk := range tc {
+			if err := DefaultPSKPolicy.Check([]byte(psk)); err == nil {
+				t.Fatalf("expected %q to fail the default policy", psk)
+			}
+		}
+	})
+
+	t.Run("StrongPSKs", func(t *testing.T) {
+		t.Parallel()
+		for i := 0; i < 10; i++ {
+			psk := MustGeneratePSK()
+			if err := DefaultPSKPolicy.Check(psk); err != nil {
+				t.Fatalf("expected %s to pass the default policy: %v", psk, err)
+			}
+		}
+	})
+
+	t.Run("DerivePSK", func(t *testing.T) {
+		t.Parallel()
+		psk, err := DerivePSK("correct horse battery staple", []byte("webmesh.internal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsValidDefaultPSK(psk.String()) {
+			t.Fatalf("expected %s to be a valid PSK", psk)
+		}
+		again, err := DerivePSK("correct horse battery staple", []byte("webmesh.internal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(psk, again) {
+			t.Fatal("expected derivation to be deterministic")
+		}
+		other, err := DerivePSK("correct horse battery staple", []byte("other.internal"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(psk, other) {
+			t.Fatal("expected different salts to derive different PSKs")
+		}
+		if _, err := DerivePSK("passphrase", nil); err == nil {
+			t.Fatal("expected an error without a salt")
+		}
+	})
+
+	t.Run("DeriveSessionPSK", func(t *testing.T) {
+		t.Parallel()
+		master := MustGeneratePSK()
+		a, err := DeriveSessionPSK(master, "session-a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := DeriveSessionPSK(master, "session-b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsValidDefaultPSK(a.String()) || !IsValidDefaultPSK(b.String()) {
+			t.Fatal("expected valid session PSKs")
+		}
+		if bytes.Equal(a, b) || bytes.Equal(a, master) {
+			t.Fatal("expected unrelated session PSKs")
+		}
+		again, err := DeriveSessionPSK(master, "session-a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(a, again) {
+			t.Fatal("expected session derivation to be deterministic")
+		}
+	})
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package attach allocates mesh addresses to workloads running behind a node,
+// such as containers, and advertises them to the rest of the mesh.
+package attach
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/plugins"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var (
+	// GatewayV4 is the gateway of workloads for IPv4. It is answered with
+	// proxy ARP by the host end of the link to the workload.
+	GatewayV4 = netip.MustParsePrefix("169.254.1.1/32")
+	// GatewayV6 is the gateway of workloads for IPv6. It is assigned to the
+	// host end of the link to the workload.
+	GatewayV6 = netip.MustParsePrefix("fe80::1/64")
+)
+
+// Options are the options for an Allocator.
+type Options struct {
+	// NodeID is the ID of this node.
+	NodeID types.NodeID
+	// Storage is the storage provider of this node.
+	Storage storage.Provider
+	// Plugins is used to allocate IPv4 addresses from the mesh IPAM.
+	Plugins plugins.Manager
+	// Network is the network manager of this node.
+	Network meshnet.Manager
+}
+
+// Request is a request for an attachment.
+type Request struct {
+	// ID is the unique ID of the attachment on this node.
+	ID string
+	// Owner identifies the integration making the request.
+	Owner string
+	// Sandbox is the network namespace of the workload, if known.
+	Sandbox string
+	// IPv4 requests an IPv4 address from the mesh network.
+	IPv4 bool
+	// IPv6 requests an IPv6 address from the prefix of this node.
+	IPv6 bool
+	// Tags are used to reference the attachment from network ACLs.
+	Tags []string
+}
+
+// Allocator allocates and releases attachments behind this node.
+type Allocator struct {
+	Options
+	mu sync.Mutex
+}
+
+// NewAllocator returns a new Allocator.
+func NewAllocator(opts Options) *Allocator {
+	return &Allocator{Options: opts}
+}
+
+// Allocate returns the attachment with the requested ID, creating it if it
+// does not exist. The addresses of an existing attachment are not changed,
+// but its owner and tags are updated.
+func (a *Allocator) Allocate(ctx context.Context, req Request) (storage.Attachment, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st := a.Storage.MeshStorage()
+	att, err := storage.GetAttachment(ctx, st, a.NodeID, req.ID)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return att, fmt.Errorf("get attachment: %w", err)
+	}
+	if err != nil {
+		att = storage.Attachment{
+			ID:      req.ID,
+			NodeID:  a.NodeID,
+			Created: time.Now().UTC(),
+		}
+		if req.IPv4 {
+			att.AddressV4, err = a.allocateV4(ctx, req.ID)
+			if err != nil {
+				return att, err
+			}
+		}
+		if req.IPv6 {
+			att.AddressV6, err = a.allocateV6(ctx, req.ID)
+			if err != nil {
+				return att, err
+			}
+		}
+	}
+	att.Owner, att.Tags = req.Owner, req.Tags
+	if req.Sandbox != "" {
+		att.Sandbox = req.Sandbox
+	}
+	if err := storage.PutAttachment(ctx, st, att); err != nil {
+		return att, fmt.Errorf("put attachment: %w", err)
+	}
+	context.LoggerFrom(ctx).Debug("Allocated attachment",
+		slog.String("id", att.ID),
+		slog.String("address-v4", att.AddressV4.String()),
+		slog.String("address-v6", att.AddressV6.String()),
+	)
+	return att, a.sync(ctx)
+}
+
+// Get returns the attachment with the given ID.
+func (a *Allocator) Get(ctx context.Context, id string) (storage.Attachment, error) {
+	return storage.GetAttachment(ctx, a.Storage.MeshStorage(), a.NodeID, id)
+}
+
+// List returns the attachments behind this node.
+func (a *Allocator) List(ctx context.Context) ([]storage.Attachment, error) {
+	return storage.ListNodeAttachments(ctx, a.Storage.MeshStorage(), a.NodeID)
+}
+
+// Release releases the attachment with the given ID. Releasing an attachment
+// that does not exist is not an error.
+func (a *Allocator) Release(ctx context.Context, id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	err := storage.DeleteAttachment(ctx, a.Storage.MeshStorage(), a.NodeID, id)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete attachment: %w", err)
+	}
+	context.LoggerFrom(ctx).Debug("Released attachment", slog.String("id", id))
+	return a.sync(ctx)
+}
+
+func (a *Allocator) sync(ctx context.Context) error {
+	err := storage.SyncAttachmentRoutes(ctx, a.Storage.MeshStorage(), a.Storage.MeshDB().Networking(), a.NodeID)
+	if err != nil {
+		return fmt.Errorf("sync attachment routes: %w", err)
+	}
+	return nil
+}
+
+func (a *Allocator) allocateV4(ctx context.Context, id string) (netip.Prefix, error) {
+	network := a.Network.NetworkV4()
+	if !network.IsValid() {
+		return netip.Prefix{}, fmt.Errorf("ipv4 is not enabled in the mesh")
+	}
+	addr, err := a.Plugins.AllocateIP(ctx, &v1.AllocateIPRequest{
+		NodeID: a.NodeID.String() + "-" + id,
+		Subnet: network.String(),
+	})
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("allocate ipv4 address: %w", err)
+	}
+	return addr, nil
+}
+
+// allocateV6 derives an address for the attachment from the prefix of this
+// node, probing for a free address on collision.
+func (a *Allocator) allocateV6(ctx context.Context, id string) (netip.Prefix, error) {
+	wg := a.Network.WireGuard()
+	if wg == nil || !wg.AddressV6().IsValid() {
+		return netip.Prefix{}, fmt.Errorf("ipv6 is not enabled on this node")
+	}
+	nodePrefix := wg.AddressV6()
+	attachments, err := a.List(ctx)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("list attachments: %w", err)
+	}
+	used := []netip.Addr{nodePrefix.Addr()}
+	for _, att := range attachments {
+		if att.AddressV6.IsValid() {
+			used = append(used, att.AddressV6.Addr())
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(id))
+	start := uint16(h.Sum32())
+	base := nodePrefix.Masked().Addr().As16()
+	for i := 0; i < 1<<16; i++ {
+		host := start + uint16(i)
+		// Skip the subnet-router anycast address.
+		if host == 0 {
+			continue
+		}
+		b := base
+		b[14], b[15] = byte(host>>8), byte(host)
+		addr := netip.AddrFrom16(b)
+		if !slices.Contains(used, addr) {
+			return netip.PrefixFrom(addr, 128), nil
+		}
+	}
+	return netip.Prefix{}, fmt.Errorf("no more addresses in %s", nodePrefix.Masked())
+}
@@ -0,0 +1,56 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attach
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/link"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// ConfigureHostLink configures the host end of the link to a workload. The
+// link is brought up, answers for the workload gateways, and the addresses of
+// the attachment are routed over it.
+func ConfigureHostLink(ctx context.Context, name string, att storage.Attachment) error {
+	if _, err := sysctl.Sysctl(fmt.Sprintf("net/ipv4/conf/%s/proxy_arp", name), "1"); err != nil {
+		return fmt.Errorf("enable proxy arp: %w", err)
+	}
+	if err := link.ActivateInterface(ctx, name); err != nil {
+		return fmt.Errorf("activate host link: %w", err)
+	}
+	if att.AddressV6.IsValid() {
+		if err := link.SetInterfaceAddress(ctx, name, GatewayV6); err != nil {
+			return fmt.Errorf("set link-local gateway: %w", err)
+		}
+	}
+	for _, addr := range []netip.Prefix{att.AddressV4, att.AddressV6} {
+		if !addr.IsValid() {
+			continue
+		}
+		if err := routes.Add(ctx, name, addr); err != nil && !errors.Is(err, routes.ErrRouteExists) {
+			return fmt.Errorf("add route to %s: %w", addr, err)
+		}
+	}
+	return nil
+}
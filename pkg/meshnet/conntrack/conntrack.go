@@ -0,0 +1,458 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conntrack implements a connection tracking table for the userspace
+// WireGuard dataplane.
+package conntrack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxFlows is the default maximum number of tracked flows.
+	DefaultMaxFlows = 65536
+	// DefaultTCPIdleTimeout is the default idle timeout for TCP flows.
+	DefaultTCPIdleTimeout = time.Hour
+	// DefaultUDPIdleTimeout is the default idle timeout for UDP flows.
+	DefaultUDPIdleTimeout = 2 * time.Minute
+	// DefaultOtherIdleTimeout is the default idle timeout for all other flows.
+	DefaultOtherIdleTimeout = 30 * time.Second
+	// DefaultGCInterval is the default interval for collecting idle flows.
+	DefaultGCInterval = 30 * time.Second
+)
+
+// Protocol is an IP protocol number.
+type Protocol uint8
+
+const (
+	// ProtocolICMP is the ICMP protocol.
+	ProtocolICMP Protocol = 1
+	// ProtocolTCP is the TCP protocol.
+	ProtocolTCP Protocol = 6
+	// ProtocolUDP is the UDP protocol.
+	ProtocolUDP Protocol = 17
+	// ProtocolICMPv6 is the ICMPv6 protocol.
+	ProtocolICMPv6 Protocol = 58
+)
+
+// String returns the string representation of the protocol.
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolICMP:
+		return "icmp"
+	case ProtocolTCP:
+		return "tcp"
+	case ProtocolUDP:
+		return "udp"
+	case ProtocolICMPv6:
+		return "icmpv6"
+	}
+	return fmt.Sprintf("proto-%d", uint8(p))
+}
+
+// ParseProtocol parses a protocol from its string representation.
+func ParseProtocol(s string) (Protocol, error) {
+	switch s {
+	case "icmp":
+		return ProtocolICMP, nil
+	case "tcp":
+		return ProtocolTCP, nil
+	case "udp":
+		return ProtocolUDP, nil
+	case "icmpv6":
+		return ProtocolICMPv6, nil
+	}
+	var p uint8
+	if _, err := fmt.Sscanf(s, "proto-%d", &p); err != nil {
+		return 0, fmt.Errorf("invalid protocol: %q", s)
+	}
+	return Protocol(p), nil
+}
+
+// Direction is the direction of a packet relative to this node.
+type Direction int
+
+const (
+	// Outbound is a packet leaving this node for the mesh.
+	Outbound Direction = iota
+	// Inbound is a packet arriving at this node from the mesh.
+	Inbound
+)
+
+// String returns the string representation of the direction.
+func (d Direction) String() string {
+	if d == Inbound {
+		return "inbound"
+	}
+	return "outbound"
+}
+
+// FlowKey identifies a flow by protocol and endpoints. Src is always
+// the endpoint that originated the flow.
+type FlowKey struct {
+	Protocol Protocol
+	Src      netip.AddrPort
+	Dst      netip.AddrPort
+}
+
+// Reverse returns the key for the reply direction of the flow.
+func (k FlowKey) Reverse() FlowKey {
+	return FlowKey{Protocol: k.Protocol, Src: k.Dst, Dst: k.Src}
+}
+
+// String returns the string representation of the key.
+func (k FlowKey) String() string {
+	return fmt.Sprintf("%s %s->%s", k.Protocol, k.Src, k.Dst)
+}
+
+// Flow is a tracked connection.
+type Flow struct {
+	FlowKey
+	// Direction is the direction of the packet that opened the flow.
+	Direction Direction
+	// Created is when the flow was first seen.
+	Created time.Time
+	// LastSeen is when a packet was last seen on the flow.
+	LastSeen time.Time
+	// Packets is the number of packets seen on the flow.
+	Packets uint64
+	// Bytes is the number of bytes seen on the flow.
+	Bytes uint64
+	// Killed is true if the flow was terminated. Packets on a
+	// killed flow are dropped until the flow goes idle.
+	Killed bool
+}
+
+// LocalAddr returns the address of this node's end of the flow.
+func (f Flow) LocalAddr() netip.AddrPort {
+	if f.Direction == Inbound {
+		return f.Dst
+	}
+	return f.Src
+}
+
+// RemoteAddr returns the address of the remote end of the flow.
+func (f Flow) RemoteAddr() netip.AddrPort {
+	if f.Direction == Inbound {
+		return f.Src
+	}
+	return f.Dst
+}
+
+// Policy returns true if the given flow is allowed to continue.
+type Policy func(Flow) bool
+
+// Match selects flows. Zero values match everything.
+type Match struct {
+	// Protocol matches the protocol of the flow.
+	Protocol Protocol
+	// Src matches the address of the originating endpoint.
+	Src netip.Prefix
+	// Dst matches the address of the responding endpoint.
+	Dst netip.Prefix
+	// SrcPort matches the port of the originating endpoint.
+	SrcPort uint16
+	// DstPort matches the port of the responding endpoint.
+	DstPort uint16
+}
+
+// Matches returns true if the flow matches.
+func (m Match) Matches(f Flow) bool {
+	if m.Protocol != 0 && m.Protocol != f.Protocol {
+		return false
+	}
+	if m.Src.IsValid() && !m.Src.Contains(f.Src.Addr()) {
+		return false
+	}
+	if m.Dst.IsValid() && !m.Dst.Contains(f.Dst.Addr()) {
+		return false
+	}
+	if m.SrcPort != 0 && m.SrcPort != f.Src.Port() {
+		return false
+	}
+	if m.DstPort != 0 && m.DstPort != f.Dst.Port() {
+		return false
+	}
+	return true
+}
+
+// Options are options for the connection tracking table.
+type Options struct {
+	// MaxFlows is the maximum number of tracked flows. Packets that would
+	// open a new flow beyond this limit are dropped.
+	MaxFlows int
+	// TCPIdleTimeout is the idle timeout for TCP flows.
+	TCPIdleTimeout time.Duration
+	// UDPIdleTimeout is the idle timeout for UDP flows.
+	UDPIdleTimeout time.Duration
+	// OtherIdleTimeout is the idle timeout for all other flows.
+	OtherIdleTimeout time.Duration
+	// GCInterval is the interval at which idle flows are collected.
+	GCInterval time.Duration
+}
+
+// NewOptions returns options with the defaults set.
+func NewOptions() Options {
+	return Options{
+		MaxFlows:         DefaultMaxFlows,
+		TCPIdleTimeout:   DefaultTCPIdleTimeout,
+		UDPIdleTimeout:   DefaultUDPIdleTimeout,
+		OtherIdleTimeout: DefaultOtherIdleTimeout,
+		GCInterval:       DefaultGCInterval,
+	}
+}
+
+// Stats are counters for the table.
+type Stats struct {
+	// Flows is the number of tracked flows, including killed flows.
+	Flows int
+	// Killed is the number of killed flows still being tracked.
+	Killed int
+	// Dropped is the number of packets dropped since the table was created.
+	Dropped uint64
+}
+
+// Table is a connection tracking table.
+type Table struct {
+	opts    Options
+	flows   map[FlowKey]*Flow
+	dropped uint64
+	now     func() time.Time
+	stop    chan struct{}
+	once    sync.Once
+	mu      sync.Mutex
+}
+
+// New returns a new table and starts collecting idle flows in the background.
+// Zero values in the options are replaced with the defaults.
+func New(opts Options) *Table {
+	defaults := NewOptions()
+	if opts.MaxFlows <= 0 {
+		opts.MaxFlows = defaults.MaxFlows
+	}
+	if opts.TCPIdleTimeout <= 0 {
+		opts.TCPIdleTimeout = defaults.TCPIdleTimeout
+	}
+	if opts.UDPIdleTimeout <= 0 {
+		opts.UDPIdleTimeout = defaults.UDPIdleTimeout
+	}
+	if opts.OtherIdleTimeout <= 0 {
+		opts.OtherIdleTimeout = defaults.OtherIdleTimeout
+	}
+	if opts.GCInterval <= 0 {
+		opts.GCInterval = defaults.GCInterval
+	}
+	t := &Table{
+		opts:  opts,
+		flows: make(map[FlowKey]*Flow),
+		now:   time.Now,
+		stop:  make(chan struct{}),
+	}
+	go t.runGC()
+	return t
+}
+
+// Close stops the background collection of idle flows.
+func (t *Table) Close() {
+	t.once.Do(func() { close(t.stop) })
+}
+
+// Track records a packet in the given direction and returns false if
+// the packet should be dropped. Packets that cannot be parsed are allowed.
+func (t *Table) Track(dir Direction, pkt []byte) bool {
+	key, ok := parseKey(pkt)
+	if !ok {
+		return true
+	}
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	flow, ok := t.flows[key]
+	if !ok {
+		flow, ok = t.flows[key.Reverse()]
+	}
+	if !ok {
+		if len(t.flows) >= t.opts.MaxFlows {
+			t.collectLocked(now)
+			if len(t.flows) >= t.opts.MaxFlows {
+				t.dropped++
+				return false
+			}
+		}
+		flow = &Flow{
+			FlowKey:   key,
+			Direction: dir,
+			Created:   now,
+		}
+		t.flows[key] = flow
+	}
+	flow.LastSeen = now
+	if flow.Killed {
+		t.dropped++
+		return false
+	}
+	flow.Packets++
+	flow.Bytes += uint64(len(pkt))
+	return true
+}
+
+// List returns all tracked flows ordered by creation time.
+func (t *Table) List() []Flow {
+	t.mu.Lock()
+	out := make([]Flow, 0, len(t.flows))
+	for _, flow := range t.flows {
+		out = append(out, *flow)
+	}
+	t.mu.Unlock()
+	slices.SortFunc(out, func(a, b Flow) int {
+		if c := a.Created.Compare(b.Created); c != 0 {
+			return c
+		}
+		return strings.Compare(a.FlowKey.String(), b.FlowKey.String())
+	})
+	return out
+}
+
+// Kill terminates all live flows matching m and returns the number killed.
+func (t *Table) Kill(m Match) int {
+	return t.Revalidate(func(f Flow) bool {
+		return !m.Matches(f)
+	})
+}
+
+// Revalidate evaluates all live flows against the given policy and kills
+// the ones it no longer allows. It returns the number of flows killed.
+func (t *Table) Revalidate(policy Policy) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var killed int
+	for _, flow := range t.flows {
+		if flow.Killed {
+			continue
+		}
+		if !policy(*flow) {
+			flow.Killed = true
+			killed++
+		}
+	}
+	return killed
+}
+
+// Stats returns the current table counters.
+func (t *Table) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := Stats{Flows: len(t.flows), Dropped: t.dropped}
+	for _, flow := range t.flows {
+		if flow.Killed {
+			stats.Killed++
+		}
+	}
+	return stats
+}
+
+// Collect removes all idle flows and returns the number removed.
+func (t *Table) Collect() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.collectLocked(t.now())
+}
+
+func (t *Table) runGC() {
+	ticker := time.NewTicker(t.opts.GCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			t.Collect()
+		}
+	}
+}
+
+func (t *Table) collectLocked(now time.Time) int {
+	var removed int
+	for key, flow := range t.flows {
+		if now.Sub(flow.LastSeen) >= t.idleTimeout(flow.Protocol) {
+			delete(t.flows, key)
+			removed++
+		}
+	}
+	return removed
+}
+
+func (t *Table) idleTimeout(proto Protocol) time.Duration {
+	switch proto {
+	case ProtocolTCP:
+		return t.opts.TCPIdleTimeout
+	case ProtocolUDP:
+		return t.opts.UDPIdleTimeout
+	}
+	return t.opts.OtherIdleTimeout
+}
+
+// parseKey parses the flow key from an IPv4 or IPv6 packet. Ports are only
+// populated for unfragmented TCP and UDP packets without IPv6 extension headers.
+func parseKey(pkt []byte) (FlowKey, bool) {
+	var key FlowKey
+	if len(pkt) < 1 {
+		return key, false
+	}
+	var src, dst netip.Addr
+	var payload []byte
+	switch pkt[0] >> 4 {
+	case 4:
+		if len(pkt) < 20 {
+			return key, false
+		}
+		ihl := int(pkt[0]&0x0f) * 4
+		if ihl < 20 || len(pkt) < ihl {
+			return key, false
+		}
+		key.Protocol = Protocol(pkt[9])
+		src = netip.AddrFrom4([4]byte(pkt[12:16]))
+		dst = netip.AddrFrom4([4]byte(pkt[16:20]))
+		if binary.BigEndian.Uint16(pkt[6:8])&0x1fff == 0 {
+			payload = pkt[ihl:]
+		}
+	case 6:
+		if len(pkt) < 40 {
+			return key, false
+		}
+		key.Protocol = Protocol(pkt[6])
+		src = netip.AddrFrom16([16]byte(pkt[8:24]))
+		dst = netip.AddrFrom16([16]byte(pkt[24:40]))
+		payload = pkt[40:]
+	default:
+		return key, false
+	}
+	var srcPort, dstPort uint16
+	if (key.Protocol == ProtocolTCP || key.Protocol == ProtocolUDP) && len(payload) >= 4 {
+		srcPort = binary.BigEndian.Uint16(payload[0:2])
+		dstPort = binary.BigEndian.Uint16(payload[2:4])
+	}
+	key.Src = netip.AddrPortFrom(src, srcPort)
+	key.Dst = netip.AddrPortFrom(dst, dstPort)
+	return key, true
+}
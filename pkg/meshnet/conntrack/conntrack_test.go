@@ -0,0 +1,167 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conntrack
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestTable(t *testing.T) {
+	t.Parallel()
+
+	local := netip.MustParseAddrPort("172.16.0.1:40000")
+	remote := netip.MustParseAddrPort("172.16.0.2:443")
+	local6 := netip.MustParseAddrPort("[fd00::1]:40000")
+	remote6 := netip.MustParseAddrPort("[fd00::2]:53")
+
+	t.Run("TracksBothDirections", func(t *testing.T) {
+		table := newTestTable(Options{})
+		if !table.Track(Outbound, packet(ProtocolTCP, local, remote)) {
+			t.Fatal("expected outbound packet to be allowed")
+		}
+		if !table.Track(Inbound, packet(ProtocolTCP, remote, local)) {
+			t.Fatal("expected reply packet to be allowed")
+		}
+		advance(table, time.Second)
+		if !table.Track(Inbound, packet(ProtocolUDP, remote6, local6)) {
+			t.Fatal("expected inbound packet to be allowed")
+		}
+		flows := table.List()
+		if len(flows) != 2 {
+			t.Fatalf("expected 2 flows, got %d", len(flows))
+		}
+		if flows[0].Src != local || flows[0].Dst != remote || flows[0].Packets != 2 {
+			t.Errorf("unexpected tcp flow: %+v", flows[0])
+		}
+		if flows[1].Direction != Inbound || flows[1].LocalAddr() != local6 {
+			t.Errorf("unexpected udp flow: %+v", flows[1])
+		}
+	})
+
+	t.Run("EnforcesMaxFlows", func(t *testing.T) {
+		table := newTestTable(Options{MaxFlows: 1})
+		if !table.Track(Outbound, packet(ProtocolUDP, local, remote)) {
+			t.Fatal("expected first flow to be allowed")
+		}
+		if table.Track(Outbound, packet(ProtocolUDP, local6, remote6)) {
+			t.Fatal("expected second flow to be dropped")
+		}
+		if stats := table.Stats(); stats.Flows != 1 || stats.Dropped != 1 {
+			t.Fatalf("unexpected stats: %+v", stats)
+		}
+	})
+
+	t.Run("CollectsIdleFlows", func(t *testing.T) {
+		table := newTestTable(Options{TCPIdleTimeout: time.Minute, UDPIdleTimeout: time.Second})
+		table.Track(Outbound, packet(ProtocolTCP, local, remote))
+		table.Track(Outbound, packet(ProtocolUDP, local, remote))
+		advance(table, 2*time.Second)
+		if removed := table.Collect(); removed != 1 {
+			t.Fatalf("expected 1 flow removed, got %d", removed)
+		}
+		advance(table, time.Minute)
+		if removed := table.Collect(); removed != 1 {
+			t.Fatalf("expected 1 flow removed, got %d", removed)
+		}
+	})
+
+	t.Run("RevalidateKillsForbiddenFlows", func(t *testing.T) {
+		table := newTestTable(Options{})
+		table.Track(Outbound, packet(ProtocolTCP, local, remote))
+		table.Track(Outbound, packet(ProtocolTCP, local6, remote6))
+		killed := table.Revalidate(func(f Flow) bool {
+			return f.Dst.Addr().Is6()
+		})
+		if killed != 1 {
+			t.Fatalf("expected 1 flow killed, got %d", killed)
+		}
+		if table.Track(Inbound, packet(ProtocolTCP, remote, local)) {
+			t.Fatal("expected packet on killed flow to be dropped")
+		}
+		if !table.Track(Outbound, packet(ProtocolTCP, local6, remote6)) {
+			t.Fatal("expected packet on live flow to be allowed")
+		}
+	})
+
+	t.Run("KillMatchingFlows", func(t *testing.T) {
+		table := newTestTable(Options{})
+		table.Track(Outbound, packet(ProtocolTCP, local, remote))
+		table.Track(Outbound, packet(ProtocolUDP, local, remote))
+		killed := table.Kill(Match{Protocol: ProtocolUDP, Dst: netip.MustParsePrefix("172.16.0.0/24")})
+		if killed != 1 {
+			t.Fatalf("expected 1 flow killed, got %d", killed)
+		}
+		if stats := table.Stats(); stats.Killed != 1 {
+			t.Fatalf("unexpected stats: %+v", stats)
+		}
+	})
+}
+
+func TestParseProtocol(t *testing.T) {
+	t.Parallel()
+	for _, proto := range []Protocol{ProtocolICMP, ProtocolTCP, ProtocolUDP, ProtocolICMPv6, 47} {
+		parsed, err := ParseProtocol(proto.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if parsed != proto {
+			t.Errorf("expected %d, got %d", proto, parsed)
+		}
+	}
+	if _, err := ParseProtocol("bogus"); err == nil {
+		t.Error("expected error for invalid protocol")
+	}
+}
+
+func newTestTable(opts Options) *Table {
+	table := New(opts)
+	table.Close()
+	now := time.Now()
+	table.now = func() time.Time { return now }
+	return table
+}
+
+func advance(table *Table, d time.Duration) {
+	now := table.now().Add(d)
+	table.now = func() time.Time { return now }
+}
+
+func packet(proto Protocol, src, dst netip.AddrPort) []byte {
+	var pkt []byte
+	if src.Addr().Is4() {
+		pkt = make([]byte, 28)
+		pkt[0] = 0x45
+		pkt[9] = byte(proto)
+		s, d := src.Addr().As4(), dst.Addr().As4()
+		copy(pkt[12:16], s[:])
+		copy(pkt[16:20], d[:])
+	} else {
+		pkt = make([]byte, 48)
+		pkt[0] = 0x60
+		pkt[6] = byte(proto)
+		s, d := src.Addr().As16(), dst.Addr().As16()
+		copy(pkt[8:24], s[:])
+		copy(pkt[24:40], d[:])
+	}
+	l4 := pkt[len(pkt)-8:]
+	binary.BigEndian.PutUint16(l4[0:2], src.Port())
+	binary.BigEndian.PutUint16(l4[2:4], dst.Port())
+	return pkt
+}
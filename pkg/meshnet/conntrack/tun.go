@@ -0,0 +1,68 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conntrack
+
+import (
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// WrapTUN returns a tun.Device that tracks all packets passing through dev in
+// the given table and drops packets belonging to killed flows. Packets read from
+// the device are outbound and packets written to it are inbound.
+func WrapTUN(dev tun.Device, table *Table) tun.Device {
+	return &trackedDevice{Device: dev, table: table}
+}
+
+type trackedDevice struct {
+	tun.Device
+	table *Table
+}
+
+// Read reads packets from the underlying device and compacts out any that
+// belong to killed flows.
+func (d *trackedDevice) Read(bufs [][]byte, sizes []int, offset int) (int, error) {
+	n, err := d.Device.Read(bufs, sizes, offset)
+	kept := 0
+	for i := 0; i < n; i++ {
+		if !d.table.Track(Outbound, bufs[i][offset:offset+sizes[i]]) {
+			continue
+		}
+		if kept != i {
+			// The device owns the buffers so copy rather than swap them.
+			sizes[kept] = copy(bufs[kept][offset:], bufs[i][offset:offset+sizes[i]])
+		}
+		kept++
+	}
+	return kept, err
+}
+
+// Write writes packets to the underlying device, skipping any that belong
+// to killed flows.
+func (d *trackedDevice) Write(bufs [][]byte, offset int) (int, error) {
+	allowed := make([][]byte, 0, len(bufs))
+	for _, buf := range bufs {
+		if d.table.Track(Inbound, buf[offset:]) {
+			allowed = append(allowed, buf)
+		}
+	}
+	dropped := len(bufs) - len(allowed)
+	if len(allowed) == 0 {
+		return dropped, nil
+	}
+	n, err := d.Device.Write(allowed, offset)
+	return n + dropped, err
+}
@@ -26,7 +26,7 @@ import (
 	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
-	"github.com/webmeshproj/webmesh/pkg/meshnet/system/dns"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/privsep"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 )
@@ -46,6 +46,7 @@ type DNSManager interface {
 
 type dnsManager struct {
 	wg             wireguard.Interface
+	ops            privsep.Ops
 	storage        storage.MeshDB
 	localdnsaddr   netip.AddrPort
 	dnsservers     []netip.AddrPort
@@ -82,7 +83,7 @@ func (m *dnsManager) AddServers(ctx context.Context, servers []netip.AddrPort) e
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	context.LoggerFrom(ctx).Debug("Configuring DNS servers", slog.Any("servers", servers))
-	err := dns.AddServers(m.wg.Name(), servers)
+	err := m.ops.AddDNSServers(ctx, m.wg.Name(), servers)
 	if err != nil {
 		return fmt.Errorf("add dns servers: %w", err)
 	}
@@ -95,7 +96,7 @@ func (m *dnsManager) AddSearchDomains(ctx context.Context, domains []string) err
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	context.LoggerFrom(ctx).Debug("Configuring DNS search domains", slog.Any("domains", domains))
-	err := dns.AddSearchDomains(m.wg.Name(), domains)
+	err := m.ops.AddSearchDomains(ctx, m.wg.Name(), domains)
 	if err != nil {
 		return fmt.Errorf("add dns search domains: %w", err)
 	}
@@ -144,14 +145,14 @@ func (m *dnsManager) RefreshServers(ctx context.Context) error {
 	}
 	// Add the new servers first
 	if len(toAdd) > 0 {
-		err := dns.AddServers(m.wg.Name(), toAdd)
+		err := m.ops.AddDNSServers(ctx, m.wg.Name(), toAdd)
 		if err != nil {
 			return fmt.Errorf("add dns servers: %w", err)
 		}
 	}
 	// Remove the old servers
 	if len(toRemove) > 0 {
-		err := dns.RemoveServers(m.wg.Name(), toRemove)
+		err := m.ops.RemoveDNSServers(ctx, m.wg.Name(), toRemove)
 		if err != nil {
 			return fmt.Errorf("remove dns servers: %w", err)
 		}
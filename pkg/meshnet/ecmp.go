@@ -0,0 +1,122 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"math/bits"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// MaxECMPPaths is the maximum number of equal-cost paths a routed prefix is
+// split between.
+const MaxECMPPaths = 16
+
+// assignRoutes returns the prefixes routed through each of the given peers,
+// indexed like the peers. Every prefix goes to the peers with the preferred
+// route to it. WireGuard only routes a prefix through a single peer, so a
+// prefix with multiple equal-cost paths is split into sub-prefixes that are
+// handed out to the peers in turn, ordered by node ID. This balances flows by
+// destination, keeping every flow to the same destination on the same path.
+// Default routes and prefixes too long to split are routed through the first
+// of the peers alone.
+func assignRoutes(peers []WalkedPeer) [][]netip.Prefix {
+	paths := make(map[netip.Prefix][]int)
+	var prefixes []netip.Prefix
+	for i, peer := range peers {
+		for _, route := range peer.Routes {
+			if !isPreferredRoute(peers, route) {
+				continue
+			}
+			if _, ok := paths[route.CIDR]; !ok {
+				prefixes = append(prefixes, route.CIDR)
+			}
+			paths[route.CIDR] = append(paths[route.CIDR], i)
+		}
+	}
+	out := make([][]netip.Prefix, len(peers))
+	for _, prefix := range prefixes {
+		idxs := paths[prefix]
+		slices.SortFunc(idxs, func(a, b int) int {
+			return strings.Compare(peers[a].GetNode().GetId(), peers[b].GetNode().GetId())
+		})
+		if len(idxs) > MaxECMPPaths {
+			idxs = idxs[:MaxECMPPaths]
+		}
+		subprefixes := splitPrefix(prefix, len(idxs))
+		if len(subprefixes) == 0 {
+			out[idxs[0]] = append(out[idxs[0]], prefix)
+			continue
+		}
+		for i, subprefix := range subprefixes {
+			idx := idxs[i%len(idxs)]
+			out[idx] = append(out[idx], subprefix)
+		}
+	}
+	return out
+}
+
+// splitPrefix splits the prefix into the smallest power of two number of
+// equally sized sub-prefixes that is at least n. Nil is returned if n is less
+// than two, the prefix is a default route, or the prefix is too long to split.
+func splitPrefix(prefix netip.Prefix, n int) []netip.Prefix {
+	if n < 2 || prefix.Bits() <= 0 {
+		return nil
+	}
+	extra := bits.Len(uint(n - 1))
+	addr := prefix.Masked().Addr()
+	if prefix.Bits()+extra > addr.BitLen() {
+		return nil
+	}
+	out := make([]netip.Prefix, 0, 1<<extra)
+	for i := 0; i < 1<<extra; i++ {
+		raw := addr.As16()
+		offset := prefix.Bits()
+		if addr.Is4() {
+			offset += 96
+		}
+		for b := 0; b < extra; b++ {
+			if i&(1<<(extra-1-b)) == 0 {
+				continue
+			}
+			pos := offset + b
+			raw[pos/8] |= 0x80 >> (pos % 8)
+		}
+		sub := netip.AddrFrom16(raw)
+		if addr.Is4() {
+			sub = sub.Unmap()
+		}
+		out = append(out, netip.PrefixFrom(sub, prefix.Bits()+extra))
+	}
+	return out
+}
+
+// coveredByRoute returns true if the given prefix is part of one of the
+// given routes.
+func coveredByRoute(routes []netip.Prefix, ip string) bool {
+	prefix, err := netip.ParsePrefix(ip)
+	if err != nil {
+		return false
+	}
+	for _, route := range routes {
+		if route.Bits() < prefix.Bits() && route.Contains(prefix.Addr()) {
+			return true
+		}
+	}
+	return false
+}
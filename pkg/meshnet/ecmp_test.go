@@ -0,0 +1,79 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+func TestSplitPrefix(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name   string
+		prefix string
+		n      int
+		want   []string
+	}{
+		{
+			name:   "SinglePath",
+			prefix: "10.0.0.0/8",
+			n:      1,
+			want:   nil,
+		},
+		{
+			name:   "TwoPaths",
+			prefix: "10.0.0.0/8",
+			n:      2,
+			want:   []string{"10.0.0.0/9", "10.128.0.0/9"},
+		},
+		{
+			name:   "ThreePaths",
+			prefix: "192.168.0.0/16",
+			n:      3,
+			want:   []string{"192.168.0.0/18", "192.168.64.0/18", "192.168.128.0/18", "192.168.192.0/18"},
+		},
+		{
+			name:   "IPv6",
+			prefix: "fd00:1::/32",
+			n:      2,
+			want:   []string{"fd00:1::/33", "fd00:1:8000::/33"},
+		},
+		{
+			name:   "DefaultRoute",
+			prefix: "0.0.0.0/0",
+			n:      2,
+			want:   nil,
+		},
+		{
+			name:   "HostRoute",
+			prefix: "10.0.0.1/32",
+			n:      2,
+			want:   nil,
+		},
+	}
+	for _, tt := range tc {
+		var got []string
+		for _, prefix := range splitPrefix(netip.MustParsePrefix(tt.prefix), tt.n) {
+			got = append(got, prefix.String())
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -30,8 +30,12 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system/link"
 )
 
-// Detect detects endpoints for this machine.
+// Detect detects endpoints for this machine. IPv6 endpoints are returned
+// regardless of DetectIPv6 when no IPv4 endpoints were found, since an
+// IPv6-only host is not reachable otherwise.
 func Detect(ctx context.Context, opts DetectOpts) (PrefixList, error) {
+	detectIPv6 := opts.DetectIPv6
+	opts.DetectIPv6 = true
 	addrs, err := detectFromInterfaces(&opts)
 	if err != nil {
 		return nil, err
@@ -43,9 +47,6 @@ func Detect(ctx context.Context, opts DetectOpts) (PrefixList, error) {
 		}
 		for _, addr := range detected {
 			if !addrs.Contains(addr) {
-				if addr.Is6() && !opts.DetectIPv6 {
-					continue
-				}
 				addrs = append(addrs, netip.PrefixFrom(addr, func() int {
 					if addr.Is4() {
 						return 32
@@ -55,6 +56,9 @@ func Detect(ctx context.Context, opts DetectOpts) (PrefixList, error) {
 			}
 		}
 	}
+	if !detectIPv6 && slices.ContainsFunc(addrs, func(p netip.Prefix) bool { return p.Addr().Is4() }) {
+		addrs = slices.DeleteFunc(addrs, func(p netip.Prefix) bool { return p.Addr().Is6() })
+	}
 	return addrs, nil
 }
 
@@ -28,7 +28,8 @@ import (
 
 // DetectOpts contains options for endpoint detection.
 type DetectOpts struct {
-	// DetectIPv6 enables IPv6 detection.
+	// DetectIPv6 enables IPv6 detection. IPv6 addresses are still detected
+	// when the host has no IPv4 endpoints.
 	DetectIPv6 bool
 	// DetectPrivate enables private address detection.
 	DetectPrivate bool
@@ -0,0 +1,279 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/pion/stun"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
+)
+
+// NATType is the classification of the NAT a node is behind.
+type NATType string
+
+const (
+	// NATUnknown is used when the NAT type could not be determined.
+	NATUnknown NATType = ""
+	// NATNone is used when the node is directly reachable on the address
+	// it sends from.
+	NATNone NATType = "none"
+	// NATFullCone is a NAT that maps a local address to the same public
+	// address for every destination and accepts packets from any host on it.
+	NATFullCone NATType = "full-cone"
+	// NATRestrictedCone is a NAT that maps a local address to the same public
+	// address for every destination, but only accepts packets from hosts it
+	// has sent to. Address and port restricted NATs are not told apart.
+	NATRestrictedCone NATType = "restricted-cone"
+	// NATSymmetric is a NAT that maps a local address to a different public
+	// address for every destination.
+	NATSymmetric NATType = "symmetric"
+)
+
+// ParseNATType parses a NAT type from its string representation.
+func ParseNATType(s string) (NATType, error) {
+	switch t := NATType(s); t {
+	case NATUnknown, NATNone, NATFullCone, NATRestrictedCone, NATSymmetric:
+		return t, nil
+	}
+	return NATUnknown, fmt.Errorf("unknown NAT type %q", s)
+}
+
+// String returns the string representation of the NAT type.
+func (t NATType) String() string {
+	if t == NATUnknown {
+		return "unknown"
+	}
+	return string(t)
+}
+
+// ConnectStrategy is a strategy for establishing a connection between two nodes.
+type ConnectStrategy string
+
+const (
+	// StrategyDirect connects to the endpoints of the peer directly.
+	StrategyDirect ConnectStrategy = "direct"
+	// StrategyHolePunch connects to the endpoints of the peer while it does
+	// the same, so both NATs open a mapping for the other.
+	StrategyHolePunch ConnectStrategy = "hole-punch"
+	// StrategyRelay connects through a relay because the NATs of the
+	// nodes cannot be traversed.
+	StrategyRelay ConnectStrategy = "relay"
+)
+
+// SelectStrategy returns the connection strategy to use between two nodes
+// behind the given NAT types. The result does not depend on the order of the
+// arguments, so both ends of a connection select the same strategy. Nodes with
+// an unknown NAT type are connected directly.
+func SelectStrategy(local, remote NATType) ConnectStrategy {
+	switch {
+	case local == NATUnknown || remote == NATUnknown:
+		return StrategyDirect
+	case local == NATNone || remote == NATNone:
+		return StrategyDirect
+	case local == NATFullCone || remote == NATFullCone:
+		return StrategyDirect
+	case local == NATSymmetric || remote == NATSymmetric:
+		// A symmetric NAT opens a new mapping for every destination, so the
+		// mapping the other end learned about is never the one used. Only a
+		// full cone would accept packets on it.
+		return StrategyRelay
+	default:
+		return StrategyHolePunch
+	}
+}
+
+// DefaultNATProbeTimeout is the default time to wait for a response to a
+// single STUN probe.
+const DefaultNATProbeTimeout = 3 * time.Second
+
+// ErrNATUndetermined is returned when none of the STUN servers answered.
+var ErrNATUndetermined = errors.New("could not determine NAT type")
+
+// DetectNAT classifies the NAT this machine is behind by sending STUN binding
+// requests from a single socket to each of the given servers. At least two
+// servers on different addresses are required to tell symmetric NATs from
+// cone NATs. Servers that support CHANGE-REQUEST are used to tell full cone
+// NATs from restricted ones, otherwise cone NATs are reported as restricted.
+// Probes are sent over IPv4 unless the host only has routable IPv6 addresses.
+func DetectNAT(ctx context.Context, servers []string) (NATType, error) {
+	if len(servers) < 2 {
+		return NATUnknown, fmt.Errorf("at least two STUN servers are required for NAT detection")
+	}
+	network := netutil.DetectAddressFamilies().UDPNetwork()
+	conn, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return NATUnknown, fmt.Errorf("listen udp: %w", err)
+	}
+	defer conn.Close()
+	var mapped []netip.AddrPort
+	var changeServer *net.UDPAddr
+	for _, server := range servers {
+		if ctx.Err() != nil {
+			return NATUnknown, ctx.Err()
+		}
+		addr, err := net.ResolveUDPAddr(network, stunServerAddr(server))
+		if err != nil {
+			continue
+		}
+		m, err := stunProbe(ctx, conn, addr)
+		if err != nil {
+			continue
+		}
+		mapped = append(mapped, m)
+		if changeServer == nil {
+			changeServer = addr
+		}
+	}
+	if len(mapped) == 0 {
+		return NATUnknown, ErrNATUndetermined
+	}
+	local, err := net.InterfaceAddrs()
+	if err != nil {
+		return NATUnknown, fmt.Errorf("list interface addresses: %w", err)
+	}
+	var localAddrs []netip.Addr
+	for _, addr := range local {
+		if prefix, err := netip.ParsePrefix(addr.String()); err == nil {
+			localAddrs = append(localAddrs, prefix.Addr())
+		}
+	}
+	// The filtering probe asks the server to answer from a different address
+	// and port, which only passes a NAT that accepts packets from anyone.
+	_, err = stunProbe(ctx, conn, changeServer, stun.RawAttribute{
+		Type:  stun.AttrChangeRequest,
+		Value: []byte{0, 0, 0, 0x06},
+	})
+	unfiltered := err == nil
+	localPort := uint16(conn.LocalAddr().(*net.UDPAddr).Port)
+	return classifyNAT(localAddrs, localPort, mapped, unfiltered), nil
+}
+
+// classifyNAT classifies a NAT from the addresses a socket bound to the given
+// local port was mapped to by different STUN servers, and whether a response
+// from an unsolicited address made it through.
+func classifyNAT(localAddrs []netip.Addr, localPort uint16, mapped []netip.AddrPort, unfiltered bool) NATType {
+	if len(mapped) == 0 {
+		return NATUnknown
+	}
+	for _, m := range mapped[1:] {
+		if m != mapped[0] {
+			return NATSymmetric
+		}
+	}
+	if mapped[0].Port() == localPort {
+		for _, addr := range localAddrs {
+			if addr.Unmap() == mapped[0].Addr().Unmap() {
+				return NATNone
+			}
+		}
+	}
+	if len(mapped) < 2 {
+		// A single mapping cannot rule out a symmetric NAT.
+		return NATUnknown
+	}
+	if unfiltered {
+		return NATFullCone
+	}
+	return NATRestrictedCone
+}
+
+// stunProbe sends a binding request to the given server and returns the mapped
+// address from the response. Requests are retried until the probe times out.
+func stunProbe(ctx context.Context, conn *net.UDPConn, server *net.UDPAddr, setters ...stun.Setter) (netip.AddrPort, error) {
+	msg, err := stun.Build(append([]stun.Setter{stun.TransactionID, stun.BindingRequest}, setters...)...)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("build binding request: %w", err)
+	}
+	deadline := time.Now().Add(DefaultNATProbeTimeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	defer func() { _ = conn.SetReadDeadline(time.Time{}) }()
+	buf := make([]byte, 1500)
+	for time.Now().Before(deadline) {
+		if _, err := conn.WriteToUDP(msg.Raw, server); err != nil {
+			return netip.AddrPort{}, fmt.Errorf("send binding request: %w", err)
+		}
+		retry := time.Now().Add(500 * time.Millisecond)
+		if retry.After(deadline) {
+			retry = deadline
+		}
+		_ = conn.SetReadDeadline(retry)
+		for {
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				var netErr net.Error
+				if errors.As(err, &netErr) && netErr.Timeout() {
+					break
+				}
+				return netip.AddrPort{}, fmt.Errorf("read binding response: %w", err)
+			}
+			resp := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+			if err := resp.Decode(); err != nil || resp.TransactionID != msg.TransactionID {
+				// Stray or late responses to an earlier probe.
+				continue
+			}
+			return mappedAddress(resp)
+		}
+	}
+	return netip.AddrPort{}, fmt.Errorf("binding request to %s timed out", server)
+}
+
+// mappedAddress returns the mapped address from a binding response.
+func mappedAddress(m *stun.Message) (netip.AddrPort, error) {
+	var ip net.IP
+	var port int
+	var xor stun.XORMappedAddress
+	if err := xor.GetFrom(m); err == nil {
+		ip, port = xor.IP, xor.Port
+	} else {
+		var plain stun.MappedAddress
+		if err := plain.GetFrom(m); err != nil {
+			return netip.AddrPort{}, fmt.Errorf("get mapped address: %w", err)
+		}
+		ip, port = plain.IP, plain.Port
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("invalid mapped address %s", ip)
+	}
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port)), nil
+}
+
+// stunServerAddr returns the host and port of a STUN server URL, adding the
+// default STUN port if none is given.
+func stunServerAddr(server string) string {
+	for _, scheme := range []string{"stun:", "stuns:", "turn:", "turns:"} {
+		server = strings.TrimPrefix(server, scheme)
+	}
+	server = strings.TrimPrefix(server, "//")
+	if i := strings.Index(server, "?"); i >= 0 {
+		server = server[:i]
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		return net.JoinHostPort(strings.Trim(server, "[]"), "3478")
+	}
+	return server
+}
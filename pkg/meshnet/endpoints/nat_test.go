@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package endpoints
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/pion/stun"
+)
+
+func TestClassifyNAT(t *testing.T) {
+	t.Parallel()
+	local := []netip.Addr{netip.MustParseAddr("192.168.1.10")}
+	public := netip.MustParseAddrPort("203.0.113.1:40000")
+	tc := []struct {
+		name       string
+		mapped     []netip.AddrPort
+		unfiltered bool
+		want       NATType
+	}{
+		{"no responses", nil, false, NATUnknown},
+		{"no nat", []netip.AddrPort{netip.MustParseAddrPort("192.168.1.10:5000"), netip.MustParseAddrPort("192.168.1.10:5000")}, false, NATNone},
+		{"full cone", []netip.AddrPort{public, public}, true, NATFullCone},
+		{"restricted cone", []netip.AddrPort{public, public}, false, NATRestrictedCone},
+		{"symmetric", []netip.AddrPort{public, netip.MustParseAddrPort("203.0.113.1:40001")}, false, NATSymmetric},
+		{"single mapping", []netip.AddrPort{public}, true, NATUnknown},
+	}
+	for _, tt := range tc {
+		if got := classifyNAT(local, 5000, tt.mapped, tt.unfiltered); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestSelectStrategy(t *testing.T) {
+	t.Parallel()
+	types := []NATType{NATUnknown, NATNone, NATFullCone, NATRestrictedCone, NATSymmetric}
+	for _, a := range types {
+		for _, b := range types {
+			if SelectStrategy(a, b) != SelectStrategy(b, a) {
+				t.Fatalf("strategy for %s and %s depends on order", a, b)
+			}
+		}
+	}
+	if got := SelectStrategy(NATRestrictedCone, NATRestrictedCone); got != StrategyHolePunch {
+		t.Errorf("expected hole punching between cone NATs, got %s", got)
+	}
+	if got := SelectStrategy(NATSymmetric, NATRestrictedCone); got != StrategyRelay {
+		t.Errorf("expected relay for a symmetric NAT, got %s", got)
+	}
+	if got := SelectStrategy(NATSymmetric, NATFullCone); got != StrategyDirect {
+		t.Errorf("expected direct connection to a full cone NAT, got %s", got)
+	}
+}
+
+func TestDetectNAT(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	servers := make([]string, 2)
+	for i := range servers {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		defer conn.Close()
+		servers[i] = "stun:" + conn.LocalAddr().String()
+		go serveTestSTUN(conn)
+	}
+	natType, err := DetectNAT(ctx, servers)
+	if err != nil {
+		t.Fatalf("detect nat: %v", err)
+	}
+	// Loopback is never translated.
+	if natType != NATNone {
+		t.Fatalf("expected no NAT, got %s", natType)
+	}
+}
+
+// serveTestSTUN answers binding requests with the source address of the
+// request. Requests to change the response address are ignored.
+func serveTestSTUN(conn *net.UDPConn) {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		req := &stun.Message{Raw: append([]byte(nil), buf[:n]...)}
+		if err := req.Decode(); err != nil || req.Contains(stun.AttrChangeRequest) {
+			continue
+		}
+		resp, err := stun.Build(
+			stun.NewTransactionIDSetter(req.TransactionID),
+			stun.BindingSuccess,
+			&stun.XORMappedAddress{IP: from.IP, Port: from.Port},
+		)
+		if err != nil {
+			continue
+		}
+		_, _ = conn.WriteToUDP(resp.Raw, from)
+	}
+}
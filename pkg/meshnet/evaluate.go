@@ -0,0 +1,135 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ActionEvaluation is the result of evaluating hypothetical traffic against
+// the network policy.
+type ActionEvaluation struct {
+	// Action is the evaluated action with the addresses of registered
+	// nodes filled in.
+	Action types.NetworkAction
+	// Matched are every network ACL matching the action in the order they
+	// are evaluated. The first decides the action.
+	Matched types.NetworkACLs
+	// Inactive are the names of the network ACLs skipped because they are
+	// outside of their schedule, sorted by name.
+	Inactive []string
+	// Accepted is true if the action is accepted.
+	Accepted bool
+	// Reason names the network ACL, namespace policy, or default that
+	// decided the action.
+	Reason string
+	// Peered is whether the source and destination nodes are peered by
+	// FilterGraph. It is nil unless both nodes are registered.
+	Peered *bool
+}
+
+// EvaluateNetworkAction evaluates the given action against the network policy
+// in effect at the given time, taking the same path as FilterGraph. Nodes in
+// the action do not need to be registered, but addresses are required for
+// those that are not. Addresses left empty for registered nodes are filled in
+// with their private IPv4 address, or their IPv6 address if they have none.
+func EvaluateNetworkAction(ctx context.Context, st storage.MeshDB, action types.NetworkAction, at time.Time) (ActionEvaluation, error) {
+	action = types.NetworkAction{NetworkAction: action.NetworkAction.DeepCopy()}
+	resolve := func(id string, cidr *string) (types.MeshNode, bool, error) {
+		if id == "" {
+			return types.MeshNode{}, false, nil
+		}
+		node, err := st.Peers().Get(ctx, types.NodeID(id))
+		if err != nil {
+			if errors.IsNodeNotFound(err) && *cidr != "" {
+				return types.MeshNode{}, false, nil
+			}
+			return types.MeshNode{}, false, fmt.Errorf("get node %s: %w", id, err)
+		}
+		if *cidr == "" {
+			*cidr = node.GetPrivateIPv4()
+			if *cidr == "" {
+				*cidr = node.GetPrivateIPv6()
+			}
+		}
+		return node, true, nil
+	}
+	src, srcRegistered, err := resolve(action.GetSrcNode(), &action.SrcCIDR)
+	if err != nil {
+		return ActionEvaluation{}, err
+	}
+	dst, dstRegistered, err := resolve(action.GetDstNode(), &action.DstCIDR)
+	if err != nil {
+		return ActionEvaluation{}, err
+	}
+	acls, err := loadNetworkACLsAt(ctx, st, at)
+	if err != nil {
+		return ActionEvaluation{}, err
+	}
+	out := ActionEvaluation{Action: action}
+	schedules, err := storage.ACLSchedulesFor(ctx, st)
+	if err != nil {
+		return out, fmt.Errorf("load acl schedules: %w", err)
+	}
+	for name := range schedules {
+		if !schedules.Active(name, at) {
+			out.Inactive = append(out.Inactive, name)
+		}
+	}
+	sort.Strings(out.Inactive)
+	for _, acl := range acls {
+		if acl.Matches(ctx, action) {
+			out.Matched = append(out.Matched, acl)
+		}
+	}
+	namespaces, err := storage.NamespacePolicyFor(ctx, st)
+	if err != nil {
+		return out, fmt.Errorf("load namespace policy: %w", err)
+	}
+	isolated := action.GetSrcNode() != "" && action.GetDstNode() != "" &&
+		!namespaces.Allow(types.NodeID(action.GetSrcNode()), types.NodeID(action.GetDstNode()))
+	switch {
+	case isolated:
+		out.Reason = "denied by namespace policy, the nodes are in isolated namespaces"
+	case len(out.Matched) == 0:
+		out.Reason = "denied by default, no network ACL matched"
+	default:
+		out.Accepted = acls.Accept(ctx, action)
+		verb := "denied"
+		if out.Accepted {
+			verb = "accepted"
+		}
+		out.Reason = fmt.Sprintf("%s by network ACL %q", verb, out.Matched[0].GetName())
+	}
+	if srcRegistered && dstRegistered {
+		exemptions, err := storage.ACLExemptionsFor(ctx, st)
+		if err != nil {
+			return out, fmt.Errorf("load acl exemptions: %w", err)
+		}
+		peered := !isolated && len(acls) > 0 &&
+			(acls.AllowNodesToCommunicate(ctx, src, dst) || allowExemptTraffic(ctx, acls, exemptions, src, dst))
+		out.Peered = &peered
+	}
+	return out, nil
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestEvaluateNetworkAction(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	acls := []*v1.NetworkACL{
+		{
+			Name:             "deny-db",
+			Priority:         10,
+			Action:           v1.ACLAction_ACTION_DENY,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{"*"},
+			DestinationCIDRs: []string{"172.16.0.2/32"},
+		},
+		{
+			Name:             "allow-a",
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			SourceNodes:      []string{"a"},
+			DestinationNodes: []string{"*"},
+		},
+	}
+	for _, acl := range acls {
+		if err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: acl}); err != nil {
+			t.Fatalf("put network acl: %v", err)
+		}
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          id,
+			PublicKey:   mustGeneratePublicKey(t),
+			PrivateIPv4: fmt.Sprintf("172.16.0.%d/32", i+1),
+		}})
+		if err != nil {
+			t.Fatalf("put peer %q: %v", id, err)
+		}
+	}
+	// allow-a stopped being in effect an hour ago.
+	end := time.Now().Add(-time.Hour)
+	err := storage.PutACLSchedule(ctx, storage.MeshStorageOf(db.MeshDB), "allow-a", types.ACLSchedule{End: &end})
+	if err != nil {
+		t.Fatalf("put acl schedule: %v", err)
+	}
+
+	tc := []struct {
+		name     string
+		action   *v1.NetworkAction
+		at       time.Time
+		accepted bool
+		matched  []string
+		inactive int
+		peered   string
+	}{
+		{
+			name:    "MatchesEveryRule",
+			action:  &v1.NetworkAction{SrcNode: "a", DstNode: "b"},
+			at:      end.Add(-time.Minute),
+			matched: []string{"deny-db", "allow-a"},
+			peered:  "false",
+		},
+		{
+			name:     "AcceptedBeforeScheduleEnds",
+			action:   &v1.NetworkAction{SrcNode: "a", DstNode: "c"},
+			at:       end.Add(-time.Minute),
+			accepted: true,
+			matched:  []string{"allow-a"},
+			peered:   "true",
+		},
+		{
+			name:     "DeniedAfterScheduleEnds",
+			action:   &v1.NetworkAction{SrcNode: "a", DstNode: "c"},
+			at:       time.Now(),
+			inactive: 1,
+			peered:   "false",
+		},
+		{
+			name:    "HypotheticalNode",
+			action:  &v1.NetworkAction{SrcNode: "a", DstNode: "new", DstCIDR: "172.16.0.2/32"},
+			at:      end.Add(-time.Minute),
+			matched: []string{"deny-db", "allow-a"},
+		},
+	}
+	for _, tt := range tc {
+		eval, err := EvaluateNetworkAction(ctx, db, types.NetworkAction{NetworkAction: tt.action}, tt.at)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if eval.Accepted != tt.accepted {
+			t.Errorf("%s: expected accepted %v, got %v (%s)", tt.name, tt.accepted, eval.Accepted, eval.Reason)
+		}
+		var matched []string
+		for _, acl := range eval.Matched {
+			matched = append(matched, acl.GetName())
+		}
+		if fmt.Sprint(matched) != fmt.Sprint(tt.matched) {
+			t.Errorf("%s: expected matched %v, got %v", tt.name, tt.matched, matched)
+		}
+		if len(eval.Inactive) != tt.inactive {
+			t.Errorf("%s: expected %d inactive ACLs, got %v", tt.name, tt.inactive, eval.Inactive)
+		}
+		peered := ""
+		if eval.Peered != nil {
+			peered = fmt.Sprint(*eval.Peered)
+		}
+		if peered != tt.peered {
+			t.Errorf("%s: expected peered %q, got %q", tt.name, tt.peered, peered)
+		}
+	}
+
+	// Unregistered nodes need an address.
+	action := types.NetworkAction{NetworkAction: &v1.NetworkAction{SrcNode: "a", DstNode: "new"}}
+	if _, err := EvaluateNetworkAction(ctx, db, action, time.Now()); err == nil {
+		t.Error("expected error evaluating an unregistered node without an address")
+	}
+}
+
+func TestEvaluateDefaultNetworkPolicy(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	st := storage.MeshStorageOf(db.MeshDB)
+	for i, id := range []string{"a", "b"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          id,
+			PublicKey:   mustGeneratePublicKey(t),
+			PrivateIPv4: fmt.Sprintf("172.16.0.%d/32", i+1),
+		}})
+		if err != nil {
+			t.Fatalf("put peer %q: %v", id, err)
+		}
+	}
+	if err := storage.SetDefaultNetworkPolicy(ctx, db, st, storage.NetworkPolicyAccept); err != nil {
+		t.Fatalf("set default network policy: %v", err)
+	}
+	action := types.NetworkAction{NetworkAction: &v1.NetworkAction{SrcNode: "a", DstNode: "b"}}
+	expectAccepted := func(want bool) {
+		t.Helper()
+		out, err := EvaluateNetworkAction(ctx, db, action, time.Now())
+		if err != nil {
+			t.Fatalf("evaluate network action: %v", err)
+		}
+		if out.Accepted != want {
+			t.Fatalf("expected accepted=%v, got %v (%s)", want, out.Accepted, out.Reason)
+		}
+	}
+	expectAccepted(true)
+
+	// Switching to default-deny takes effect even if a catch-all accepting
+	// all traffic is put back by hand.
+	if err := storage.SetDefaultNetworkPolicy(ctx, db, st, storage.NetworkPolicyDrop); err != nil {
+		t.Fatalf("set default network policy: %v", err)
+	}
+	expectAccepted(false)
+	err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             storage.DefaultAcceptNetworkACLName,
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+	expectAccepted(false)
+
+	// Removing the catch-all of default-allow mode does not deny traffic.
+	if err := storage.SetDefaultNetworkPolicy(ctx, db, st, storage.NetworkPolicyAccept); err != nil {
+		t.Fatalf("set default network policy: %v", err)
+	}
+	if err := db.Networking().DeleteNetworkACL(ctx, storage.DefaultAcceptNetworkACLName); err != nil {
+		t.Fatalf("delete network acl: %v", err)
+	}
+	expectAccepted(true)
+}
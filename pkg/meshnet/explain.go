@@ -0,0 +1,375 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ComputedPeers are the WireGuard peers computed for a node along with
+// explanations of why each entry is present, and why other nodes are not.
+type ComputedPeers struct {
+	// NodeID is the node the peers were computed for.
+	NodeID types.NodeID
+	// Peers are the computed WireGuard peers sorted by ID.
+	Peers []ComputedPeer
+	// Excluded are the nodes that have no peer entry and are not reachable
+	// through one, sorted by ID.
+	Excluded []ExcludedNode
+}
+
+// ComputedPeer is a computed WireGuard peer and the reasons it is included.
+type ComputedPeer struct {
+	// Peer is the WireGuard peer exactly as the node would receive it.
+	Peer *v1.WireGuardPeer
+	// Reasons explain why the peer is included.
+	Reasons []string
+	// AllowedIPs explains why each allowed IP is routed through the peer.
+	AllowedIPs map[string]string
+}
+
+// ExcludedNode is a node that is not reachable from the node the peers were
+// computed for.
+type ExcludedNode struct {
+	// ID is the ID of the node.
+	ID types.NodeID
+	// Reason explains why the node is not reachable.
+	Reason string
+}
+
+// ExplainWireGuardPeers computes the WireGuard peers for the given node with
+// WireGuardPeersFor and explains which edges, network ACLs, and routes led to
+// each entry. It is meant for debugging why a node lacks a peer entry for
+// another node.
+func ExplainWireGuardPeers(ctx context.Context, st storage.MeshDB, nodeID types.NodeID) (*ComputedPeers, error) {
+	self, err := st.Peers().Get(ctx, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("get node %s: %w", nodeID, err)
+	}
+	peers, err := WireGuardPeersFor(ctx, st, nodeID)
+	if err != nil {
+		return nil, fmt.Errorf("get wireguard peers: %w", err)
+	}
+	acls, err := loadNetworkACLs(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := storage.NamespacePolicyFor(ctx, st)
+	if err != nil {
+		return nil, fmt.Errorf("load namespace policy: %w", err)
+	}
+	exemptions, err := storage.ACLExemptionsFor(ctx, st)
+	if err != nil {
+		return nil, fmt.Errorf("load acl exemptions: %w", err)
+	}
+	nodes, err := st.Peers().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	routes, err := st.Networking().ListRoutes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	nwState, err := st.MeshState().GetMeshState(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get mesh state: %w", err)
+	}
+	x := explainer{
+		self:       self,
+		acls:       acls,
+		exemptions: exemptions,
+		owner:      make(map[string]types.NodeID),
+		routes:     routes,
+		route:      make(map[string]types.Route),
+	}
+	for _, node := range nodes {
+		for _, addr := range []netip.Prefix{node.PrivateAddrV4(), node.PrivateAddrV6()} {
+			if addr.IsValid() {
+				x.owner[addr.String()] = node.NodeID()
+			}
+		}
+	}
+	for _, route := range routes {
+		for _, cidr := range route.DestinationPrefixes() {
+			x.route[cidr.String()] = route
+		}
+	}
+	out := &ComputedPeers{NodeID: nodeID}
+	reached := make(map[types.NodeID]struct{})
+	for _, peer := range peers {
+		peerID := types.NodeID(peer.GetNode().GetId())
+		reached[peerID] = struct{}{}
+		computed := ComputedPeer{
+			Peer: peer,
+			Reasons: []string{
+				fmt.Sprintf("direct edge between %s and %s", nodeID, peerID),
+				x.aclReason(ctx, types.MeshNode{MeshNode: peer.GetNode()}),
+				fmt.Sprintf("connects over %s", peer.GetProto()),
+			},
+			AllowedIPs: make(map[string]string, len(peer.GetAllowedIPs())),
+		}
+		for _, ip := range peer.GetAllowedIPs() {
+			if owner, ok := x.owner[ip]; ok {
+				reached[owner] = struct{}{}
+			}
+			computed.AllowedIPs[ip] = x.allowedIPReason(peerID, ip, nwState)
+		}
+		out.Peers = append(out.Peers, computed)
+	}
+	for _, node := range nodes {
+		id := node.NodeID()
+		if id == nodeID {
+			continue
+		}
+		if _, ok := reached[id]; ok {
+			continue
+		}
+		if len(peers) == 1 && x.inMeshNetwork(node, nwState) {
+			// The allowed IPs were flattened to the mesh networks.
+			continue
+		}
+		var reason string
+		switch {
+		case !namespaces.Allow(nodeID, id):
+			reason = "isolated by namespace policy"
+		case node.GetPublicKey() == "":
+			reason = "node has no public key"
+		default:
+			reason = x.denyReason(ctx, node)
+		}
+		out.Excluded = append(out.Excluded, ExcludedNode{ID: id, Reason: reason})
+	}
+	sort.Slice(out.Peers, func(i, j int) bool {
+		return out.Peers[i].Peer.GetNode().GetId() < out.Peers[j].Peer.GetNode().GetId()
+	})
+	sort.Slice(out.Excluded, func(i, j int) bool {
+		return out.Excluded[i].ID < out.Excluded[j].ID
+	})
+	return out, nil
+}
+
+// explainer looks up the reasons behind computed peers.
+type explainer struct {
+	self       types.MeshNode
+	acls       types.NetworkACLs
+	exemptions types.ACLExemptions
+	// owner maps private addresses to the node they belong to.
+	owner map[string]types.NodeID
+	// routes are all routes in the mesh.
+	routes types.Routes
+	// route maps route destinations to the route they belong to.
+	route map[string]types.Route
+}
+
+// actions returns the IPv4 and IPv6 actions for traffic from the local node
+// to the given node.
+func (x *explainer) actions(node types.MeshNode) []types.NetworkAction {
+	return []types.NetworkAction{
+		{NetworkAction: &v1.NetworkAction{
+			SrcNode: x.self.GetId(),
+			SrcCIDR: x.self.GetPrivateIPv4(),
+			DstNode: node.GetId(),
+			DstCIDR: node.GetPrivateIPv4(),
+		}},
+		{NetworkAction: &v1.NetworkAction{
+			SrcNode: x.self.GetId(),
+			SrcCIDR: x.self.GetPrivateIPv6(),
+			DstNode: node.GetId(),
+			DstCIDR: node.GetPrivateIPv6(),
+		}},
+	}
+}
+
+// acceptingACL returns the network ACL that accepts traffic to the given node.
+func (x *explainer) acceptingACL(ctx context.Context, node types.MeshNode) (types.NetworkACL, bool) {
+	for _, action := range x.actions(node) {
+		acl, ok := x.acls.Match(ctx, action)
+		if ok && acl.GetAction() == v1.ACLAction_ACTION_ACCEPT {
+			return acl, true
+		}
+	}
+	return types.NetworkACL{}, false
+}
+
+// aclReason explains which network ACL accepts traffic to the given node.
+func (x *explainer) aclReason(ctx context.Context, node types.MeshNode) string {
+	acl, ok := x.acceptingACL(ctx, node)
+	if !ok {
+		if allowExemptTraffic(ctx, x.acls, x.exemptions, x.self, node) {
+			return fmt.Sprintf("network ACL exemptions allow ICMP echo or meshdns traffic between %s and %s", x.self.GetId(), node.GetId())
+		}
+		return fmt.Sprintf("no network ACL accepts traffic from %s to %s", x.self.GetId(), node.GetId())
+	}
+	return fmt.Sprintf("network ACL %q accepts traffic from %s to %s", acl.GetName(), x.self.GetId(), node.GetId())
+}
+
+// routeDenial explains why traffic to the given route of a node is not
+// accepted. It returns an empty string if it is.
+func (x *explainer) routeDenial(ctx context.Context, route types.Route, cidr netip.Prefix) string {
+	src := x.self.GetPrivateIPv4()
+	if cidr.Addr().Is6() {
+		src = x.self.GetPrivateIPv6()
+	}
+	action := types.NetworkAction{NetworkAction: &v1.NetworkAction{
+		SrcNode: x.self.GetId(),
+		SrcCIDR: src,
+		DstNode: route.GetNode(),
+		DstCIDR: cidr.String(),
+	}}
+	acl, ok := x.acls.Match(ctx, action)
+	if !ok {
+		return fmt.Sprintf("no network ACL accepts traffic to route %q of %s", route.GetName(), route.GetNode())
+	}
+	if acl.GetAction() != v1.ACLAction_ACTION_ACCEPT {
+		return fmt.Sprintf("network ACL %q denies traffic to route %q of %s", acl.GetName(), route.GetName(), route.GetNode())
+	}
+	return ""
+}
+
+// allowedIPReason explains why the given IP is routed through the given peer.
+func (x *explainer) allowedIPReason(peerID types.NodeID, ip string, nwState types.NetworkState) string {
+	if owner, ok := x.owner[ip]; ok {
+		if owner == peerID {
+			return "private address of the peer"
+		}
+		return fmt.Sprintf("private address of %s, reached through %s", owner, peerID)
+	}
+	if route, ok := x.route[ip]; ok {
+		if route.GetNode() == peerID.String() {
+			return fmt.Sprintf("route %q of the peer", route.GetName())
+		}
+		return fmt.Sprintf("route %q of %s, reached through %s", route.GetName(), route.GetNode(), peerID)
+	}
+	if ip == nwState.NetworkV4().String() || ip == nwState.NetworkV6().String() {
+		return "mesh network, routed through the only peer"
+	}
+	if route, ok := x.splitRoute(peerID, ip); ok {
+		return fmt.Sprintf("share of route %q of %s, split between equal-cost paths", route.GetName(), route.GetNode())
+	}
+	return "unknown origin"
+}
+
+// splitRoute returns the route the given prefix is a share of when the route
+// is split between equal-cost paths. Routes of the given peer are preferred.
+func (x *explainer) splitRoute(peerID types.NodeID, ip string) (types.Route, bool) {
+	prefix, err := netip.ParsePrefix(ip)
+	if err != nil {
+		return types.Route{}, false
+	}
+	var out types.Route
+	var found bool
+	for _, route := range x.routes {
+		for _, cidr := range route.DestinationPrefixes() {
+			if cidr.Bits() == 0 || cidr.Bits() >= prefix.Bits() || !cidr.Contains(prefix.Addr()) {
+				continue
+			}
+			if route.GetNode() == peerID.String() {
+				return route, true
+			}
+			if !found {
+				out, found = route, true
+			}
+		}
+	}
+	return out, found
+}
+
+// inMeshNetwork reports if the private addresses of the node are part of the
+// mesh networks.
+func (x *explainer) inMeshNetwork(node types.MeshNode, nwState types.NetworkState) bool {
+	v4, v6 := node.PrivateAddrV4(), node.PrivateAddrV6()
+	return (v4.IsValid() && nwState.NetworkV4().Contains(v4.Addr())) ||
+		(v6.IsValid() && nwState.NetworkV6().Contains(v6.Addr()))
+}
+
+// denyReason explains why a node that is not isolated by namespaces is not
+// reachable from the local node.
+func (x *explainer) denyReason(ctx context.Context, node types.MeshNode) string {
+	if len(x.acls) == 0 {
+		return "no network ACLs are configured"
+	}
+	if _, ok := x.acceptingACL(ctx, node); !ok {
+		for _, action := range x.actions(node) {
+			if acl, ok := x.acls.Match(ctx, action); ok {
+				return fmt.Sprintf("network ACL %q denies traffic from %s to %s", acl.GetName(), x.self.GetId(), node.GetId())
+			}
+		}
+		return x.aclReason(ctx, node)
+	}
+	for _, route := range x.routes {
+		if route.GetNode() != node.GetId() {
+			continue
+		}
+		for _, cidr := range route.DestinationPrefixes() {
+			if reason := x.routeDenial(ctx, route, cidr); reason != "" {
+				return reason
+			}
+		}
+	}
+	return fmt.Sprintf("no path of allowed edges from %s to %s", x.self.GetId(), node.GetId())
+}
+
+// ExplainNetworkAction evaluates the given action against the network ACLs
+// and explains the decision. Source and destination addresses left empty are
+// filled in with the private IPv4 address of the source and destination nodes,
+// or their IPv6 address if they have none. Traffic between nodes in isolated
+// namespaces is denied before any ACL is considered.
+func ExplainNetworkAction(ctx context.Context, st storage.MeshDB, action types.NetworkAction) (types.ACLExplanation, error) {
+	action = types.NetworkAction{NetworkAction: action.NetworkAction.DeepCopy()}
+	resolve := func(id string, cidr *string) error {
+		if id == "" || *cidr != "" {
+			return nil
+		}
+		node, err := st.Peers().Get(ctx, types.NodeID(id))
+		if err != nil {
+			return fmt.Errorf("get node %s: %w", id, err)
+		}
+		*cidr = node.GetPrivateIPv4()
+		if *cidr == "" {
+			*cidr = node.GetPrivateIPv6()
+		}
+		return nil
+	}
+	if err := resolve(action.GetSrcNode(), &action.SrcCIDR); err != nil {
+		return types.ACLExplanation{}, err
+	}
+	if err := resolve(action.GetDstNode(), &action.DstCIDR); err != nil {
+		return types.ACLExplanation{}, err
+	}
+	if action.GetSrcNode() != "" && action.GetDstNode() != "" {
+		namespaces, err := storage.NamespacePolicyFor(ctx, st)
+		if err != nil {
+			return types.ACLExplanation{}, fmt.Errorf("load namespace policy: %w", err)
+		}
+		if !namespaces.Allow(types.NodeID(action.GetSrcNode()), types.NodeID(action.GetDstNode())) {
+			return types.ACLExplanation{Reason: "denied by namespace policy, the nodes are in isolated namespaces"}, nil
+		}
+	}
+	acls, err := loadNetworkACLs(ctx, st)
+	if err != nil {
+		return types.ACLExplanation{}, err
+	}
+	return acls.Explain(ctx, action), nil
+}
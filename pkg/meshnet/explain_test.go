@@ -0,0 +1,212 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"fmt"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestExplainWireGuardPeers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: "172.16.0.0/12",
+			NetworkV6: "2001:db8::/64",
+			Domain:    "example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set network state: %v", err)
+	}
+	acls := []*v1.NetworkACL{
+		{
+			Name:             "deny-d",
+			Priority:         10,
+			Action:           v1.ACLAction_ACTION_DENY,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{"d"},
+		},
+		{
+			Name:             "allow-all",
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{"*"},
+			SourceCIDRs:      []string{"*"},
+			DestinationCIDRs: []string{"*"},
+		},
+	}
+	for _, acl := range acls {
+		if err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: acl}); err != nil {
+			t.Fatalf("put network acl: %v", err)
+		}
+	}
+	for i, id := range []string{"a", "b", "c", "d", "e", "f"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          id,
+			PublicKey:   mustGeneratePublicKey(t),
+			PrivateIPv4: fmt.Sprintf("172.16.0.%d/32", i+1),
+		}})
+		if err != nil {
+			t.Fatalf("put peer %q: %v", id, err)
+		}
+	}
+	for _, edge := range [][2]string{{"a", "b"}, {"b", "c"}, {"a", "d"}, {"a", "e"}} {
+		err = db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: edge[0], Target: edge[1]}})
+		if err != nil {
+			t.Fatalf("put edge %v: %v", edge, err)
+		}
+	}
+
+	computed, err := ExplainWireGuardPeers(ctx, db, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(computed.Peers) != 2 {
+		t.Fatalf("expected 2 peers, got %+v", computed.Peers)
+	}
+	b := computed.Peers[0]
+	if b.Peer.GetNode().GetId() != "b" || computed.Peers[1].Peer.GetNode().GetId() != "e" {
+		t.Fatalf("expected peers b and e, got %+v", computed.Peers)
+	}
+	if want := `network ACL "allow-all" accepts traffic from a to b`; b.Reasons[1] != want {
+		t.Errorf("expected reason %q, got %q", want, b.Reasons[1])
+	}
+	if got, want := b.AllowedIPs["172.16.0.2/32"], "private address of the peer"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	if got, want := b.AllowedIPs["172.16.0.3/32"], "private address of c, reached through b"; got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+	want := []ExcludedNode{
+		{ID: "d", Reason: `network ACL "deny-d" denies traffic from a to d`},
+		{ID: "f", Reason: "no path of allowed edges from a to f"},
+	}
+	if len(computed.Excluded) != len(want) {
+		t.Fatalf("expected excluded nodes %+v, got %+v", want, computed.Excluded)
+	}
+	for i, node := range computed.Excluded {
+		if node != want[i] {
+			t.Errorf("expected excluded node %+v, got %+v", want[i], node)
+		}
+	}
+
+	_, err = ExplainWireGuardPeers(ctx, db, "unknown")
+	if err == nil {
+		t.Fatal("expected error for unknown node")
+	}
+}
+
+func TestExplainNetworkAction(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	acls := []*v1.NetworkACL{
+		{
+			Name:             "deny-db",
+			Priority:         10,
+			Action:           v1.ACLAction_ACTION_DENY,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{"*"},
+			DestinationCIDRs: []string{"172.16.0.2/32"},
+		},
+		{
+			Name:             "allow-a",
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			SourceNodes:      []string{"a"},
+			DestinationNodes: []string{"*"},
+		},
+	}
+	for _, acl := range acls {
+		if err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: acl}); err != nil {
+			t.Fatalf("put network acl: %v", err)
+		}
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          id,
+			PublicKey:   mustGeneratePublicKey(t),
+			PrivateIPv4: fmt.Sprintf("172.16.0.%d/32", i+1),
+		}})
+		if err != nil {
+			t.Fatalf("put peer %q: %v", id, err)
+		}
+	}
+
+	tc := []struct {
+		name     string
+		src, dst string
+		accepted bool
+		reason   string
+		lastEval string
+	}{
+		{
+			name:     "denied by rule",
+			src:      "a",
+			dst:      "b",
+			reason:   `denied by network ACL "deny-db"`,
+			lastEval: "matched",
+		},
+		{
+			name:     "accepted by rule",
+			src:      "a",
+			dst:      "c",
+			accepted: true,
+			reason:   `accepted by network ACL "allow-a"`,
+			lastEval: "matched",
+		},
+		{
+			name:     "denied by default",
+			src:      "c",
+			dst:      "a",
+			reason:   "denied by default, no network ACL matched",
+			lastEval: "source node c is not in [a]",
+		},
+	}
+	for _, tt := range tc {
+		action := types.NetworkAction{NetworkAction: &v1.NetworkAction{SrcNode: tt.src, DstNode: tt.dst}}
+		explanation, err := ExplainNetworkAction(ctx, db, action)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if explanation.Accepted != tt.accepted || explanation.Reason != tt.reason {
+			t.Errorf("%s: expected accepted=%v reason %q, got accepted=%v reason %q",
+				tt.name, tt.accepted, tt.reason, explanation.Accepted, explanation.Reason)
+		}
+		if len(explanation.Evaluated) == 0 {
+			t.Fatalf("%s: expected evaluated ACLs", tt.name)
+		}
+		if got := explanation.Evaluated[len(explanation.Evaluated)-1].Reason; got != tt.lastEval {
+			t.Errorf("%s: expected last evaluation %q, got %q", tt.name, tt.lastEval, got)
+		}
+	}
+
+	_, err := ExplainNetworkAction(ctx, db, types.NetworkAction{NetworkAction: &v1.NetworkAction{SrcNode: "unknown", DstNode: "a"}})
+	if err == nil {
+		t.Fatal("expected error for unknown node")
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// NewFirewallACLRules renders the network ACLs in effect into firewall rules
+// dropping the connections the given node receives that they deny. Every node
+// only renders rules for the traffic it receives, so together they enforce the
+// ACLs at the receiving end of every connection. Connections are evaluated from
+// each address and route of the other nodes to each address and route of this
+// node, with the namespace policy and ACL exemptions applied as they are by
+// NewFlowPolicy.
+func NewFirewallACLRules(ctx context.Context, db storage.MeshDB, thisNodeID types.NodeID) ([]firewall.ACLRule, error) {
+	acls, err := loadNetworkACLs(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := storage.NamespacePolicyFor(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("load namespace policy: %w", err)
+	}
+	exemptions, err := storage.ACLExemptionsFor(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("load acl exemptions: %w", err)
+	}
+	thisNode, err := db.Peers().Get(ctx, thisNodeID)
+	if err != nil {
+		return nil, fmt.Errorf("get node: %w", err)
+	}
+	local, err := nodePrefixes(ctx, db, thisNode)
+	if err != nil {
+		return nil, err
+	}
+	nodes, err := db.Peers().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	var rules []firewall.ACLRule
+	for _, node := range nodes {
+		if node.GetId() == thisNodeID.String() {
+			continue
+		}
+		remote, err := nodePrefixes(ctx, db, node)
+		if err != nil {
+			return nil, err
+		}
+		allowed := namespaces.Allow(node.NodeID(), thisNodeID)
+		for _, src := range remote {
+			for _, dst := range local {
+				if src.Addr().Is4() != dst.Addr().Is4() {
+					continue
+				}
+				action := types.NetworkAction{NetworkAction: &v1.NetworkAction{
+					SrcNode: node.GetId(),
+					SrcCIDR: src.String(),
+					DstNode: thisNodeID.String(),
+					DstCIDR: dst.String(),
+				}}
+				if allowed && acls.Accept(ctx, action) {
+					continue
+				}
+				rule := firewall.ACLRule{Source: src, Destination: dst}
+				if allowed {
+					rule.AllowICMP = exemptions.Enabled(types.ExemptICMPEcho) &&
+						acls.AcceptExempt(ctx, action, exemptions, types.ExemptICMPEcho)
+					if port := thisNode.DNSPort(); port != 0 && exemptions.Enabled(types.ExemptMeshDNS) &&
+						acls.AcceptExempt(ctx, action, exemptions, types.ExemptMeshDNS) {
+						rule.AllowPort = port
+					}
+				}
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// nodePrefixes returns the private addresses of the node and the prefixes it
+// routes.
+func nodePrefixes(ctx context.Context, db storage.MeshDB, node types.MeshNode) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, prefix := range []netip.Prefix{node.PrivateAddrV4(), node.PrivateAddrV6()} {
+		if prefix.IsValid() {
+			out = append(out, prefix)
+		}
+	}
+	routes, err := db.Networking().GetRoutesByNode(ctx, node.NodeID())
+	if err != nil {
+		return nil, fmt.Errorf("get routes by node: %w", err)
+	}
+	for _, route := range routes {
+		out = append(out, route.DestinationPrefixes()...)
+	}
+	return out, nil
+}
+
+// syncFirewallACLs renders the network ACLs into the firewall when enabled.
+// The rules are only replaced when they change.
+func (m *manager) syncFirewallACLs(ctx context.Context) error {
+	if !m.opts.FirewallACLs || m.fw == nil || m.wg == nil {
+		return nil
+	}
+	rules, err := NewFirewallACLRules(ctx, m.storage, m.nodeID)
+	if err != nil {
+		return fmt.Errorf("render firewall acl rules: %w", err)
+	}
+	m.aclmu.Lock()
+	defer m.aclmu.Unlock()
+	if m.aclRulesSynced && slices.Equal(rules, m.aclRules) {
+		return nil
+	}
+	if err := m.fw.SetACLRules(ctx, m.wg.Name(), rules); err != nil {
+		return fmt.Errorf("set firewall acl rules: %w", err)
+	}
+	m.aclRules, m.aclRulesSynced = rules, true
+	context.LoggerFrom(ctx).Debug("Updated firewall ACL rules", slog.Int("rules", len(rules)))
+	return nil
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestNewFirewallACLRules(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	for _, node := range []types.MeshNode{
+		{MeshNode: &v1.MeshNode{Id: "a", PrivateIPv4: "172.16.0.1/32", Features: []*v1.FeaturePort{
+			{Feature: v1.Feature_MESH_DNS, Port: 53},
+		}}},
+		{MeshNode: &v1.MeshNode{Id: "b", PrivateIPv4: "172.16.0.2/32"}},
+		{MeshNode: &v1.MeshNode{Id: "c", PrivateIPv4: "172.16.0.3/32"}},
+	} {
+		if err := db.Peers().Put(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name:             "a-lan",
+		Node:             "a",
+		DestinationCIDRs: []string{"10.20.0.0/16"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// b may only reach the address of a, not the network it routes.
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "b-to-a",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"b"},
+		DestinationNodes: []string{"a"},
+		SourceCIDRs:      []string{"*"},
+		DestinationCIDRs: []string{"172.16.0.1/32"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.PutACLExemptions(ctx, storage.MeshStorageOf(db.MeshDB), types.ACLExemptions{
+		ICMPEcho: true,
+		MeshDNS:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err := NewFirewallACLRules(ctx, db.MeshDB, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := netip.MustParsePrefix
+	want := []firewall.ACLRule{
+		{Source: prefix("172.16.0.2/32"), Destination: prefix("10.20.0.0/16"), AllowICMP: true, AllowPort: 53},
+		{Source: prefix("172.16.0.3/32"), Destination: prefix("172.16.0.1/32"), AllowICMP: true, AllowPort: 53},
+		{Source: prefix("172.16.0.3/32"), Destination: prefix("10.20.0.0/16"), AllowICMP: true, AllowPort: 53},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("expected %d rules, got %v", len(want), rules)
+	}
+	for _, rule := range want {
+		if !slices.Contains(rules, rule) {
+			t.Errorf("expected rule %+v in %v", rule, rules)
+		}
+	}
+
+	// Without exemptions nothing is accepted despite the rules.
+	err = storage.PutACLExemptions(ctx, storage.MeshStorageOf(db.MeshDB), types.ACLExemptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules, err = NewFirewallACLRules(ctx, db.MeshDB, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range rules {
+		if rule.AllowICMP || rule.AllowPort != 0 {
+			t.Errorf("expected no exemptions, got %+v", rule)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+)
+
+// Firewall Metrics
+var (
+	// FirewallTrackedFlows tracks the flows counted by the firewall on the
+	// wireguard interface.
+	FirewallTrackedFlows = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "webmesh",
+		Name:      "firewall_tracked_flows",
+		Help:      "The current number of flows tracked by the firewall.",
+	}, []string{"node_id"})
+
+	// FirewallDroppedPacketsTotal tracks packets dropped by the firewall on
+	// the wireguard interface.
+	FirewallDroppedPacketsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "firewall_dropped_packets_total",
+		Help:      "Total packets dropped by the firewall on the wireguard interface.",
+	}, []string{"node_id"})
+)
+
+// recordFirewallMetrics periodically records the counters of firewalls that
+// keep them until the context is canceled.
+func (m *manager) recordFirewallMetrics(ctx context.Context, fc firewall.FlowCounter, interval time.Duration) {
+	log := context.LoggerFrom(ctx)
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var lastDropped uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			flows, err := fc.Flows(ctx)
+			if err != nil {
+				log.Error("Failed to list firewall flows", slog.String("error", err.Error()))
+				continue
+			}
+			FirewallTrackedFlows.WithLabelValues(m.nodeID.String()).Set(float64(len(flows)))
+			dropped, err := fc.DroppedPackets(ctx)
+			if err != nil {
+				log.Error("Failed to get dropped packets", slog.String("error", err.Error()))
+				continue
+			}
+			if dropped > lastDropped {
+				FirewallDroppedPacketsTotal.WithLabelValues(m.nodeID.String()).Add(float64(dropped - lastDropped))
+			}
+			lastDropped = dropped
+		}
+	}
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"fmt"
+	"net/netip"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/conntrack"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// NewFlowPolicy builds a connection tracking policy for the given node from the
+// network ACLs in effect. The remote end of a flow is attributed to the node owning
+// the address, or to the node advertising the most specific route containing it.
+// Flows to addresses not owned by any node are always allowed. As with FilterGraph,
+// an empty ACL list denies all flows between nodes, as do namespaces that do not
+// peer with each other. ICMP flows and queries to a node's meshdns port are
+// evaluated with the mesh's ACL exemptions. Connection tracking does not
+// distinguish ICMP message types, so the ICMP echo exemption covers all ICMP.
+func NewFlowPolicy(ctx context.Context, db storage.MeshDB, thisNodeID types.NodeID) (conntrack.Policy, error) {
+	acls, err := loadNetworkACLs(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	namespaces, err := storage.NamespacePolicyFor(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("load namespace policy: %w", err)
+	}
+	exemptions, err := storage.ACLExemptionsFor(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("load acl exemptions: %w", err)
+	}
+	nodes, err := db.Peers().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	dnsPorts := make(map[string]uint16)
+	for _, node := range nodes {
+		if port := node.DNSPort(); port != 0 {
+			dnsPorts[node.GetId()] = port
+		}
+	}
+	routes, err := db.Networking().ListRoutes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	owner := func(addr netip.Addr) (string, bool) {
+		for _, node := range nodes {
+			if node.PrivateAddrV4().Contains(addr) || node.PrivateAddrV6().Contains(addr) {
+				return node.GetId(), true
+			}
+		}
+		var match netip.Prefix
+		var nodeID string
+		for _, route := range routes {
+			for _, prefix := range route.DestinationPrefixes() {
+				if prefix.Contains(addr) && (!match.IsValid() || prefix.Bits() > match.Bits()) {
+					match, nodeID = prefix, route.GetNode()
+				}
+			}
+		}
+		return nodeID, match.IsValid()
+	}
+	return func(flow conntrack.Flow) bool {
+		local, remote := flow.LocalAddr().Addr(), flow.RemoteAddr().Addr()
+		remoteNode, ok := owner(remote)
+		if !ok || remoteNode == thisNodeID.String() {
+			return true
+		}
+		if !namespaces.Allow(thisNodeID, types.NodeID(remoteNode)) {
+			return false
+		}
+		action := &v1.NetworkAction{
+			SrcNode: thisNodeID.String(),
+			SrcCIDR: netip.PrefixFrom(local, local.BitLen()).String(),
+			DstNode: remoteNode,
+			DstCIDR: netip.PrefixFrom(remote, remote.BitLen()).String(),
+		}
+		if flow.Direction == conntrack.Inbound {
+			action.SrcNode, action.DstNode = action.DstNode, action.SrcNode
+			action.SrcCIDR, action.DstCIDR = action.DstCIDR, action.SrcCIDR
+		}
+		var kind types.Exemption
+		switch {
+		case flow.Protocol == conntrack.ProtocolICMP || flow.Protocol == conntrack.ProtocolICMPv6:
+			kind = types.ExemptICMPEcho
+		case flow.Dst.Port() != 0 && flow.Dst.Port() == dnsPorts[action.DstNode]:
+			kind = types.ExemptMeshDNS
+		}
+		if kind != "" && exemptions.Enabled(kind) {
+			return acls.AcceptExempt(ctx, types.NetworkAction{NetworkAction: action}, exemptions, kind)
+		}
+		return acls.Accept(ctx, types.NetworkAction{NetworkAction: action})
+	}, nil
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/conntrack"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestFlowPolicy(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	for _, node := range []types.MeshNode{
+		{MeshNode: &v1.MeshNode{Id: "a", PrivateIPv4: "172.16.0.1/32"}},
+		{MeshNode: &v1.MeshNode{Id: "b", PrivateIPv4: "172.16.0.2/32"}},
+		{MeshNode: &v1.MeshNode{Id: "c", PrivateIPv4: "172.16.0.3/32"}},
+	} {
+		if err := db.Peers().Put(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name:             "c-lan",
+		Node:             "c",
+		DestinationCIDRs: []string{"10.10.0.0/16"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "a-to-b",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"a"},
+		DestinationNodes: []string{"b"},
+		SourceCIDRs:      []string{"*"},
+		DestinationCIDRs: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewFlowPolicy(ctx, db, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := func(dir conntrack.Direction, src, dst string) conntrack.Flow {
+		return conntrack.Flow{
+			FlowKey: conntrack.FlowKey{
+				Protocol: conntrack.ProtocolTCP,
+				Src:      netip.MustParseAddrPort(src),
+				Dst:      netip.MustParseAddrPort(dst),
+			},
+			Direction: dir,
+		}
+	}
+	tc := []struct {
+		name string
+		flow conntrack.Flow
+		want bool
+	}{
+		{"OutboundToAllowedNode", flow(conntrack.Outbound, "172.16.0.1:4000", "172.16.0.2:80"), true},
+		{"InboundFromAllowedNode", flow(conntrack.Inbound, "172.16.0.2:4000", "172.16.0.1:80"), false},
+		{"OutboundToDeniedNode", flow(conntrack.Outbound, "172.16.0.1:4000", "172.16.0.3:80"), false},
+		{"OutboundToDeniedRoute", flow(conntrack.Outbound, "172.16.0.1:4000", "10.10.1.1:80"), false},
+		{"OutboundOutsideMesh", flow(conntrack.Outbound, "172.16.0.1:4000", "192.0.2.1:80"), true},
+	}
+	for _, tt := range tc {
+		if got := policy(tt.flow); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestFlowPolicyExemptions(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	for _, node := range []types.MeshNode{
+		{MeshNode: &v1.MeshNode{Id: "a", PrivateIPv4: "172.16.0.1/32"}},
+		{MeshNode: &v1.MeshNode{Id: "b", PrivateIPv4: "172.16.0.2/32", Features: []*v1.FeaturePort{
+			{Feature: v1.Feature_MESH_DNS, Port: 53},
+		}}},
+		{MeshNode: &v1.MeshNode{Id: "c", PrivateIPv4: "172.16.0.3/32"}},
+	} {
+		if err := db.Peers().Put(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, acl := range []*v1.NetworkACL{
+		{Name: "deny-to-c", Action: v1.ACLAction_ACTION_DENY, SourceNodes: []string{"*"}, DestinationNodes: []string{"c"}},
+		{Name: "deny-from-c", Action: v1.ACLAction_ACTION_DENY, SourceNodes: []string{"c"}, DestinationNodes: []string{"*"}},
+	} {
+		if err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: acl}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	deny := false
+	err := storage.PutACLExemptions(ctx, storage.MeshStorageOf(db.MeshDB), types.ACLExemptions{
+		ICMPEcho: true,
+		MeshDNS:  true,
+		Overrides: map[string]types.ACLExemptionOverride{
+			"deny-to-c":   {ICMPEcho: &deny},
+			"deny-from-c": {ICMPEcho: &deny},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewFlowPolicy(ctx, db.MeshDB, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := func(proto conntrack.Protocol, src, dst string) conntrack.Flow {
+		return conntrack.Flow{
+			FlowKey: conntrack.FlowKey{
+				Protocol: proto,
+				Src:      netip.MustParseAddrPort(src),
+				Dst:      netip.MustParseAddrPort(dst),
+			},
+			Direction: conntrack.Outbound,
+		}
+	}
+	tc := []struct {
+		name string
+		flow conntrack.Flow
+		want bool
+	}{
+		{"ICMPByDefaultDeny", flow(conntrack.ProtocolICMP, "172.16.0.1:0", "172.16.0.2:0"), true},
+		{"MeshDNSByDefaultDeny", flow(conntrack.ProtocolUDP, "172.16.0.1:4000", "172.16.0.2:53"), true},
+		{"OtherTrafficByDefaultDeny", flow(conntrack.ProtocolTCP, "172.16.0.1:4000", "172.16.0.2:80"), false},
+		{"ICMPByOverriddenACL", flow(conntrack.ProtocolICMP, "172.16.0.1:0", "172.16.0.3:0"), false},
+		{"DNSToNodeWithoutMeshDNS", flow(conntrack.ProtocolUDP, "172.16.0.1:4000", "172.16.0.3:53"), false},
+	}
+	for _, tt := range tc {
+		if got := policy(tt.flow); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+
+	// Exempt traffic peers nodes the network ACLs deny.
+	a, err := db.Peers().Get(ctx, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []struct {
+		node string
+		peer bool
+	}{{"b", true}, {"c", false}} {
+		node, err := db.Peers().Get(ctx, types.NodeID(want.node))
+		if err != nil {
+			t.Fatal(err)
+		}
+		acls, err := loadNetworkACLs(ctx, db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exemptions, err := storage.ACLExemptionsFor(ctx, db.MeshDB)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := allowExemptTraffic(ctx, acls, exemptions, a, node); got != want.peer {
+			t.Errorf("expected exempt traffic between a and %s to be %v, got %v", want.node, want.peer, got)
+		}
+	}
+}
+
+func TestFlowPolicySchedules(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	for _, node := range []types.MeshNode{
+		{MeshNode: &v1.MeshNode{Id: "a", PrivateIPv4: "172.16.0.1/32"}},
+		{MeshNode: &v1.MeshNode{Id: "b", PrivateIPv4: "172.16.0.2/32"}},
+		{MeshNode: &v1.MeshNode{Id: "c", PrivateIPv4: "172.16.0.3/32"}},
+	} {
+		if err := db.Peers().Put(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, acl := range []*v1.NetworkACL{
+		{Name: "a-to-b", Action: v1.ACLAction_ACTION_ACCEPT, SourceNodes: []string{"a"}, DestinationNodes: []string{"b"}},
+		{Name: "a-to-c", Action: v1.ACLAction_ACTION_ACCEPT, SourceNodes: []string{"a"}, DestinationNodes: []string{"c"}},
+	} {
+		if err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: acl}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Access to b has ended and access to c is ongoing.
+	ended := time.Now().Add(-time.Hour)
+	started := time.Now().Add(-time.Minute)
+	st := storage.MeshStorageOf(db.MeshDB)
+	if err := storage.PutACLSchedule(ctx, st, "a-to-b", types.ACLSchedule{End: &ended}); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.PutACLSchedule(ctx, st, "a-to-c", types.ACLSchedule{Start: &started}); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := NewFlowPolicy(ctx, db.MeshDB, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow := func(dst string) conntrack.Flow {
+		return conntrack.Flow{
+			FlowKey: conntrack.FlowKey{
+				Protocol: conntrack.ProtocolTCP,
+				Src:      netip.MustParseAddrPort("172.16.0.1:4000"),
+				Dst:      netip.MustParseAddrPort(dst),
+			},
+			Direction: conntrack.Outbound,
+		}
+	}
+	if policy(flow("172.16.0.2:80")) {
+		t.Error("expected flow allowed by an ended ACL to be denied")
+	}
+	if !policy(flow("172.16.0.3:80")) {
+		t.Error("expected flow allowed by a started ACL to be allowed")
+	}
+
+	// Removing the schedule puts the ACL back in effect.
+	if err := storage.DeleteACLSchedule(ctx, st, "a-to-b"); err != nil {
+		t.Fatal(err)
+	}
+	policy, err = NewFlowPolicy(ctx, db.MeshDB, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !policy(flow("172.16.0.2:80")) {
+		t.Error("expected flow to be allowed once the schedule is removed")
+	}
+}
@@ -0,0 +1,102 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"net/netip"
+	"slices"
+	"sync/atomic"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/privsep"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+)
+
+// forwardingRecorder is a Recorder that counts requests to enable forwarding.
+type forwardingRecorder struct {
+	*privsep.Recorder
+	forwarding atomic.Int32
+}
+
+func (r *forwardingRecorder) EnableIPForwarding(ctx context.Context) error {
+	r.forwarding.Add(1)
+	return r.Recorder.EnableIPForwarding(ctx)
+}
+
+func TestStartGateway(t *testing.T) {
+	t.Parallel()
+	networkV4 := netip.MustParsePrefix("172.16.0.0/12")
+	networkV6 := netip.MustParsePrefix("fd00:dead:beef::/48")
+	tc := []struct {
+		name        string
+		disableIPv6 bool
+		want        []netip.Prefix
+	}{
+		{"dual stack", false, []netip.Prefix{networkV4, networkV6}},
+		{"ipv4 only", true, []netip.Prefix{networkV4}},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			ctx := context.Background()
+			db := meshdb.NewTestDB()
+			defer db.Close()
+			rec := &forwardingRecorder{Recorder: privsep.NewRecorder()}
+			m := New(db, Options{
+				InterfaceName: "webmesh0",
+				ListenPort:    51820,
+				DisableIPv6:   tt.disableIPv6,
+				SystemOps:     rec,
+			}, "node-a")
+			err := m.Start(ctx, StartOptions{
+				Key:       crypto.MustGenerateKey(),
+				AddressV4: netip.MustParsePrefix("172.16.0.1/32"),
+				AddressV6: netip.MustParsePrefix("fd00:dead:beef:1::/64"),
+				NetworkV4: networkV4,
+				NetworkV6: networkV6,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer m.Close(ctx)
+			rec.forwarding.Store(0)
+			for i := 0; i < 2; i++ {
+				if err := m.StartGateway(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if rec.forwarding.Load() != 1 {
+				t.Errorf("expected forwarding to be enabled once, got %d", rec.forwarding.Load())
+			}
+			plan := rec.Plan()
+			if len(plan.Firewalls) != 1 {
+				t.Fatalf("expected a single firewall, got %d", len(plan.Firewalls))
+			}
+			fw := plan.Firewalls[0]
+			if got := fw.EgressMasq[m.WireGuard().Name()]; !slices.Equal(got, tt.want) {
+				t.Errorf("expected egress masquerade for %v, got %v", tt.want, fw.EgressMasq)
+			}
+			// Traffic into the mesh is not masqueraded.
+			if len(fw.Masquerade) != 0 || len(fw.SourceMasq) != 0 {
+				t.Errorf("unexpected masquerade rules: %v %v", fw.Masquerade, fw.SourceMasq)
+			}
+		})
+	}
+}
@@ -18,6 +18,7 @@ package meshnet
 
 import (
 	"fmt"
+	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 
@@ -27,7 +28,7 @@ import (
 )
 
 // FilterGraph filters the adjacency map in the given graph for the given node name according
-// to the current network ACLs. If the ACL list is nil, an empty adjacency map is returned. An
+// to the current network ACLs and namespaces. If the ACL list is nil, an empty adjacency map is returned. An
 // error is returned on faiure building the initial map or any database error. This implementation
 // needs improvement to be more efficient and to allow edges so long as one of the routes encountered is
 // allowed. Currently if a single route provided by a destination node is not allowed, the entire node
@@ -43,18 +44,21 @@ func FilterGraph(ctx context.Context, db storage.MeshDB, thisNodeID types.NodeID
 	}
 
 	// Gather all the ACLs and the current adjacency map
-	acls, err := db.Networking().ListNetworkACLs(ctx)
+	acls, err := loadNetworkACLs(ctx, db)
 	if err != nil {
-		return nil, fmt.Errorf("list network acls: %w", err)
+		return nil, err
 	}
 	if len(acls) == 0 {
 		return nil, nil
 	}
-	err = storage.ExpandACLs(ctx, db.RBAC(), acls)
+	namespaces, err := storage.NamespacePolicyFor(ctx, db)
 	if err != nil {
-		return nil, fmt.Errorf("expand network acls: %w", err)
+		return nil, fmt.Errorf("load namespace policy: %w", err)
+	}
+	exemptions, err := storage.ACLExemptionsFor(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("load acl exemptions: %w", err)
 	}
-	acls.Sort(types.SortDescending)
 	fullMap, err := types.NewAdjacencyMap(graph)
 	if err != nil {
 		return nil, fmt.Errorf("build adjacency map: %w", err)
@@ -75,7 +79,12 @@ Nodes:
 		if err != nil {
 			return nil, fmt.Errorf("get node: %w", err)
 		}
-		if !acls.AllowNodesToCommunicate(ctx, thisNode, node) {
+		if !namespaces.Allow(thisNode.NodeID(), node.NodeID()) {
+			log.Debug("Nodes in isolated namespaces", "nodeA", thisNode, "nodeB", node)
+			delete(filtered[thisNode.NodeID()], node.NodeID())
+			continue Nodes
+		}
+		if !acls.AllowNodesToCommunicate(ctx, thisNode, node) && !allowExemptTraffic(ctx, acls, exemptions, thisNode, node) {
 			log.Debug("Nodes not allowed to communicate", "nodeA", thisNode, "nodeB", node)
 			delete(filtered[thisNode.NodeID()], node.NodeID())
 			continue Nodes
@@ -133,6 +142,10 @@ Nodes:
 			if err != nil {
 				return nil, fmt.Errorf("get peer: %w", err)
 			}
+			if !namespaces.Allow(thisNode.NodeID(), peerID) {
+				log.Debug("Nodes in isolated namespaces", "nodeA", thisNode, "nodeB", peer)
+				continue Peers
+			}
 			if !acls.AllowNodesToCommunicate(ctx, thisNode, peer) {
 				log.Debug("Nodes not allowed to communicate", "nodeA", thisNode, "nodeB", peer)
 				continue Peers
@@ -174,3 +187,67 @@ Nodes:
 	log.Debug("Filtered adjacency map", "from", thisNode.Id, "map", filtered)
 	return filtered, nil
 }
+
+// loadNetworkACLs returns the network ACLs currently in effect with their
+// groups and tags expanded and the default network policy of the mesh
+// enforced, sorted in the order they are evaluated.
+func loadNetworkACLs(ctx context.Context, db storage.MeshDB) (types.NetworkACLs, error) {
+	return loadNetworkACLsAt(ctx, db, time.Now())
+}
+
+// loadNetworkACLsAt is like loadNetworkACLs but returns the network ACLs in
+// effect at the given time.
+func loadNetworkACLsAt(ctx context.Context, db storage.MeshDB, t time.Time) (types.NetworkACLs, error) {
+	acls, err := db.Networking().ListNetworkACLs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list network acls: %w", err)
+	}
+	policy, err := storage.DefaultNetworkPolicyFor(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("load default network policy: %w", err)
+	}
+	if len(acls) == 0 && policy == "" {
+		return acls, nil
+	}
+	schedules, err := storage.ACLSchedulesFor(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("load acl schedules: %w", err)
+	}
+	acls = storage.ApplyDefaultNetworkPolicy(acls.ActiveAt(schedules, t), policy)
+	err = storage.ExpandACLs(ctx, db.RBAC(), acls)
+	if err != nil {
+		return nil, fmt.Errorf("expand network acls: %w", err)
+	}
+	err = storage.ExpandACLTags(ctx, db.Networking(), acls)
+	if err != nil {
+		return nil, fmt.Errorf("expand network acl tags: %w", err)
+	}
+	err = storage.ExpandACLLabels(ctx, db, acls)
+	if err != nil {
+		return nil, fmt.Errorf("expand network acl labels: %w", err)
+	}
+	acls.Sort(types.SortDescending)
+	return acls, nil
+}
+
+// allowExemptTraffic returns true if the ACL exemptions allow any traffic between
+// the two nodes, in which case they are peered even when the network ACLs deny
+// them. Enforcing that only exempt traffic flows between them is left to nodes
+// tracking connections.
+func allowExemptTraffic(ctx context.Context, acls types.NetworkACLs, exemptions types.ACLExemptions, nodeA, nodeB types.MeshNode) bool {
+	for _, pair := range [][2]types.MeshNode{{nodeA, nodeB}, {nodeB, nodeA}} {
+		src, dst := pair[0], pair[1]
+		for _, action := range []types.NetworkAction{
+			{NetworkAction: &v1.NetworkAction{SrcNode: src.GetId(), SrcCIDR: src.GetPrivateIPv4(), DstNode: dst.GetId(), DstCIDR: dst.GetPrivateIPv4()}},
+			{NetworkAction: &v1.NetworkAction{SrcNode: src.GetId(), SrcCIDR: src.GetPrivateIPv6(), DstNode: dst.GetId(), DstCIDR: dst.GetPrivateIPv6()}},
+		} {
+			if exemptions.Enabled(types.ExemptICMPEcho) && acls.AcceptExempt(ctx, action, exemptions, types.ExemptICMPEcho) {
+				return true
+			}
+			if dst.DNSPort() != 0 && exemptions.Enabled(types.ExemptMeshDNS) && acls.AcceptExempt(ctx, action, exemptions, types.ExemptMeshDNS) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -237,6 +237,69 @@ func TestFilteredGraph(t *testing.T) {
 			t.Fatalf("filtered graphs should be equal")
 		}
 	})
+
+	t.Run("IsolatedNamespaces", func(t *testing.T) {
+		t.Parallel()
+		ctx := context.Background()
+
+		db := setupGraphTest(t, graphSetup{
+			nodes: []types.MeshNode{
+				{
+					MeshNode: &v1.MeshNode{
+						Id:          "node-a",
+						PublicKey:   generateEncodedKey(t),
+						PrivateIPv4: "172.16.0.1/32",
+						PrivateIPv6: "fe80::1/128",
+					},
+				},
+				{
+					MeshNode: &v1.MeshNode{
+						Id:          "node-b",
+						PublicKey:   generateEncodedKey(t),
+						PrivateIPv4: "172.16.0.2/32",
+						PrivateIPv6: "fe80::2/128",
+					},
+				},
+			},
+			edges: []types.MeshEdge{
+				{
+					MeshEdge: &v1.MeshEdge{
+						Source: "node-a",
+						Target: "node-b",
+					},
+				},
+			},
+			acls: []*v1.NetworkACL{
+				{
+					Name:             "allow-all",
+					Action:           v1.ACLAction_ACTION_ACCEPT,
+					SourceNodes:      []string{"*"},
+					DestinationNodes: []string{"*"},
+					SourceCIDRs:      []string{"*"},
+					DestinationCIDRs: []string{"*"},
+				},
+			},
+		})
+		st := storage.MeshStorageOf(db)
+		if err := storage.PutNamespace(ctx, st, storage.Namespace{Name: "team-b"}); err != nil {
+			t.Fatalf("put namespace: %v", err)
+		}
+		if err := storage.SetNodeNamespace(ctx, st, "node-b", "team-b"); err != nil {
+			t.Fatalf("set node namespace: %v", err)
+		}
+
+		filteredA, err := FilterGraph(ctx, db, "node-a")
+		if err != nil {
+			t.Fatalf("filter graph: %v", err)
+		}
+		// The ACLs allow everything, but node-b is in another namespace
+		if len(filteredA) != 1 {
+			t.Fatalf("filtered graph should only contain node-a, got: %v", filteredA)
+		}
+		if len(filteredA["node-a"]) != 0 {
+			t.Fatalf("filtered graph should contain no edges, got: %d", len(filteredA["node-a"]))
+		}
+	})
 }
 
 type graphSetup struct {
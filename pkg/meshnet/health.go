@@ -0,0 +1,267 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultMaxHandshakeAge is the default maximum age of the last WireGuard
+// handshake with a peer for it to be considered alive. WireGuard renews
+// sessions every two minutes while traffic is flowing, so idle peers are
+// only reliably reported alive with a persistent keepalive configured.
+const DefaultMaxHandshakeAge = 3 * time.Minute
+
+// DefaultRouteHealthInterval is the default interval at which the health of
+// directly connected peers is checked when health-aware routing is enabled.
+const DefaultRouteHealthInterval = 30 * time.Second
+
+// HealthStatus is the liveness of a peer as observed by the local node.
+type HealthStatus int
+
+const (
+	// HealthUnknown means no liveness information is available for the peer,
+	// usually because the local node is not directly connected to it.
+	HealthUnknown HealthStatus = iota
+	// HealthAlive means the peer was recently seen.
+	HealthAlive
+	// HealthDead means the peer has not been seen recently.
+	HealthDead
+)
+
+// HealthMode controls how peer health is applied to peer lists.
+type HealthMode string
+
+const (
+	// HealthModeNone leaves peer lists untouched.
+	HealthModeNone HealthMode = "none"
+	// HealthModeSort orders alive peers first, followed by peers with
+	// unknown health and then dead peers.
+	HealthModeSort HealthMode = "sort"
+	// HealthModeFilter removes dead peers and orders the remainder as
+	// with HealthModeSort.
+	HealthModeFilter HealthMode = "filter"
+)
+
+// ParseHealthMode parses a health mode. An empty string is HealthModeNone.
+func ParseHealthMode(s string) (HealthMode, error) {
+	switch mode := HealthMode(strings.ToLower(s)); mode {
+	case "":
+		return HealthModeNone, nil
+	case HealthModeNone, HealthModeSort, HealthModeFilter:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid health mode %q", s)
+	}
+}
+
+// RouteHealthOptions are options for avoiding unhealthy intermediate nodes
+// when routing traffic to other nodes through directly connected peers.
+type RouteHealthOptions struct {
+	// MaxHandshakeAge is the maximum age of the last WireGuard handshake with
+	// a peer for it to be considered alive. Defaults to DefaultMaxHandshakeAge.
+	MaxHandshakeAge time.Duration
+	// Interval is how often the health of directly connected peers is checked.
+	// Peers are reconfigured whenever it changes. Defaults to DefaultRouteHealthInterval.
+	Interval time.Duration
+}
+
+// PeerHealth reports the liveness of peers.
+type PeerHealth interface {
+	// Status returns the health of the given peer.
+	Status(node types.MeshNode) HealthStatus
+}
+
+// ApplyHealth orders or filters the given nodes according to their health and the
+// given mode. The relative order of nodes with the same health is preserved. A nil
+// PeerHealth leaves the list untouched.
+func ApplyHealth(nodes []types.MeshNode, health PeerHealth, mode HealthMode) []types.MeshNode {
+	if health == nil || mode == HealthModeNone || mode == "" {
+		return nodes
+	}
+	type ranked struct {
+		node   types.MeshNode
+		status HealthStatus
+	}
+	rank := func(s HealthStatus) int {
+		switch s {
+		case HealthAlive:
+			return 0
+		case HealthUnknown:
+			return 1
+		default:
+			return 2
+		}
+	}
+	out := make([]ranked, 0, len(nodes))
+	for _, node := range nodes {
+		status := health.Status(node)
+		if mode == HealthModeFilter && status == HealthDead {
+			continue
+		}
+		out = append(out, ranked{node, status})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		return rank(out[i].status) < rank(out[j].status)
+	})
+	result := make([]types.MeshNode, len(out))
+	for i, r := range out {
+		result[i] = r.node
+	}
+	return result
+}
+
+// NewHandshakeHealth returns a PeerHealth that reports peers as alive when the
+// local WireGuard interface completed a handshake with them within maxAge. The
+// local node is always alive. Peers that are not configured on the interface
+// have unknown health. If maxAge is zero, DefaultMaxHandshakeAge is used.
+func NewHandshakeHealth(nodeID types.NodeID, nw Manager, maxAge time.Duration) PeerHealth {
+	if maxAge <= 0 {
+		maxAge = DefaultMaxHandshakeAge
+	}
+	return &handshakeHealth{nodeID: nodeID, nw: nw, maxAge: maxAge}
+}
+
+// handshakeCacheTTL is how long interface metrics are reused between lookups.
+const handshakeCacheTTL = time.Second
+
+type handshakeHealth struct {
+	nodeID     types.NodeID
+	nw         Manager
+	maxAge     time.Duration
+	handshakes map[string]time.Time
+	fetched    time.Time
+	mu         sync.Mutex
+}
+
+func (h *handshakeHealth) Status(node types.MeshNode) HealthStatus {
+	if node.NodeID() == h.nodeID {
+		return HealthAlive
+	}
+	key, err := crypto.DecodePublicKey(node.GetPublicKey())
+	if err != nil {
+		return HealthUnknown
+	}
+	last, ok := h.lastHandshake(key.WireGuardKey().String())
+	if !ok {
+		return HealthUnknown
+	}
+	if last.IsZero() || time.Since(last) > h.maxAge {
+		return HealthDead
+	}
+	return HealthAlive
+}
+
+func (h *handshakeHealth) lastHandshake(key string) (time.Time, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if time.Since(h.fetched) > handshakeCacheTTL {
+		h.refresh()
+	}
+	last, ok := h.handshakes[key]
+	return last, ok
+}
+
+func (h *handshakeHealth) refresh() {
+	h.fetched = time.Now()
+	h.handshakes = nil
+	wg := h.nw.WireGuard()
+	if wg == nil {
+		return
+	}
+	metrics, err := wg.Metrics()
+	if err != nil {
+		return
+	}
+	h.handshakes = make(map[string]time.Time, len(metrics.GetPeers()))
+	for _, peer := range metrics.GetPeers() {
+		last, err := time.Parse(time.RFC3339, peer.GetLastHandshakeTime())
+		if err != nil || last.Year() <= 1 {
+			// WireGuard reports the zero time for peers it has never shaken hands with.
+			last = time.Time{}
+		}
+		h.handshakes[peer.GetPublicKey()] = last
+	}
+}
+
+// watchRouteHealth syncs peers whenever the set of directly connected peers
+// that are not alive changes, so that routes through them are moved to
+// alternate paths and moved back once they recover.
+func (m *manager) watchRouteHealth(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultRouteHealthInterval
+	}
+	log := context.LoggerFrom(ctx)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var last []string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		dead := m.deadPeers()
+		if slices.Equal(dead, last) {
+			continue
+		}
+		log.Info("Health of directly connected peers changed, reconfiguring routes", slog.Any("dead-peers", dead))
+		last = dead
+		syncCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		if err := m.peers.Sync(syncCtx); err != nil {
+			log.Error("Error syncing peers after health change", slog.String("error", err.Error()))
+		}
+		cancel()
+	}
+}
+
+// deadPeers returns the sorted IDs of the peers on the wireguard interface
+// that are not alive.
+func (m *manager) deadPeers() []string {
+	wg := m.WireGuard()
+	if wg == nil {
+		return nil
+	}
+	var dead []string
+	for id, peer := range wg.Peers() {
+		if peer.PublicKey == nil {
+			continue
+		}
+		key, err := peer.PublicKey.Encode()
+		if err != nil {
+			continue
+		}
+		node := types.MeshNode{MeshNode: &v1.MeshNode{Id: id, PublicKey: key}}
+		if m.health.Status(node) == HealthDead {
+			dead = append(dead, id)
+		}
+	}
+	sort.Strings(dead)
+	return dead
+}
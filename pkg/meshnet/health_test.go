@@ -0,0 +1,159 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+type staticHealth map[string]HealthStatus
+
+func (h staticHealth) Status(node types.MeshNode) HealthStatus {
+	return h[node.GetId()]
+}
+
+func TestApplyHealth(t *testing.T) {
+	t.Parallel()
+	nodes := []types.MeshNode{
+		{MeshNode: &v1.MeshNode{Id: "dead"}},
+		{MeshNode: &v1.MeshNode{Id: "unknown"}},
+		{MeshNode: &v1.MeshNode{Id: "alive-1"}},
+		{MeshNode: &v1.MeshNode{Id: "alive-2"}},
+	}
+	health := staticHealth{
+		"dead":    HealthDead,
+		"alive-1": HealthAlive,
+		"alive-2": HealthAlive,
+	}
+	tc := []struct {
+		mode   HealthMode
+		health PeerHealth
+		want   []string
+	}{
+		{HealthModeNone, health, []string{"dead", "unknown", "alive-1", "alive-2"}},
+		{HealthModeSort, nil, []string{"dead", "unknown", "alive-1", "alive-2"}},
+		{HealthModeSort, health, []string{"alive-1", "alive-2", "unknown", "dead"}},
+		{HealthModeFilter, health, []string{"alive-1", "alive-2", "unknown"}},
+	}
+	for _, c := range tc {
+		got := ApplyHealth(nodes, c.health, c.mode)
+		var ids []string
+		for _, node := range got {
+			ids = append(ids, node.GetId())
+		}
+		if len(ids) != len(c.want) {
+			t.Errorf("mode %s: expected %v, got %v", c.mode, c.want, ids)
+			continue
+		}
+		for i := range ids {
+			if ids[i] != c.want[i] {
+				t.Errorf("mode %s: expected %v, got %v", c.mode, c.want, ids)
+				break
+			}
+		}
+	}
+}
+
+func TestParseHealthMode(t *testing.T) {
+	t.Parallel()
+	for in, want := range map[string]HealthMode{"": HealthModeNone, "none": HealthModeNone, "Sort": HealthModeSort, "filter": HealthModeFilter} {
+		got, err := ParseHealthMode(in)
+		if err != nil || got != want {
+			t.Errorf("ParseHealthMode(%q) = %q, %v; expected %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseHealthMode("drop"); err == nil {
+		t.Error("expected error for invalid mode")
+	}
+}
+
+func TestWireGuardPeersWithHealth(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: "172.16.0.0/12",
+			NetworkV6: "2001:db8::/64",
+			Domain:    "example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set network state: %v", err)
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "allow-all",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+		SourceCIDRs:      []string{"*"},
+		DestinationCIDRs: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+	// Node a reaches d through either b or c, and e only through b.
+	for id, addr := range map[string]string{
+		"a": "172.16.0.1/32",
+		"b": "172.16.0.2/32",
+		"c": "172.16.0.3/32",
+		"d": "172.16.0.4/32",
+		"e": "172.16.0.5/32",
+	} {
+		err = db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          id,
+			PublicKey:   mustGeneratePublicKey(t),
+			PrivateIPv4: addr,
+		}})
+		if err != nil {
+			t.Fatalf("put peer %q: %v", id, err)
+		}
+	}
+	for _, edge := range [][2]string{{"a", "b"}, {"a", "c"}, {"b", "d"}, {"c", "d"}, {"b", "e"}} {
+		err = db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: edge[0], Target: edge[1]}})
+		if err != nil {
+			t.Fatalf("put edge %v: %v", edge, err)
+		}
+	}
+	peers, err := WireGuardPeersWithHealth(ctx, db, "a", staticHealth{"b": HealthDead, "c": HealthAlive})
+	if err != nil {
+		t.Fatalf("get peers: %v", err)
+	}
+	got := make(map[string][]string)
+	for _, p := range peers {
+		sort.Strings(p.AllowedIPs)
+		got[p.GetNode().GetId()] = p.AllowedIPs
+	}
+	want := map[string][]string{
+		// e is only reachable through b so it stays there.
+		"b": {"172.16.0.2/32", "172.16.0.5/32"},
+		// d moves to the healthy path.
+		"c": {"172.16.0.3/32", "172.16.0.4/32"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
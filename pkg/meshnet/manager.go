@@ -27,15 +27,19 @@ import (
 	"sync"
 	"time"
 
+	"golang.zx2c4.com/wireguard/tun"
 	"google.golang.org/grpc"
 
 	"github.com/webmeshproj/webmesh/pkg/common"
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/conntrack"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/nat64"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
-	"github.com/webmeshproj/webmesh/pkg/meshnet/system/dns"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/privsep"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/datachannels"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/libp2p"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
 	"github.com/webmeshproj/webmesh/pkg/storage"
@@ -86,6 +90,31 @@ type Options struct {
 	// Relays are options for when presented with the need to negotiate
 	// p2p data channels.
 	Relays RelayOptions
+	// SystemOps performs privileged system operations. If nil, they are
+	// performed directly in the current process.
+	SystemOps privsep.Ops
+	// Conntrack enables connection tracking with the given options when
+	// not nil. It is only supported on userspace interfaces created in
+	// the current process.
+	Conntrack *conntrack.Options
+	// RouteHealth enables health-aware routing with the given options when
+	// not nil. Addresses of other nodes are not routed through directly
+	// connected peers that are not alive when an alternate path exists.
+	RouteHealth *RouteHealthOptions
+	// ExternalPeers disables configuring peers on the interface. The desired
+	// peers are still computed and published to subscribers of the peer manager
+	// so that an external controller can apply them.
+	ExternalPeers bool
+	// FirewallACLs renders the network ACLs into firewall rules on the
+	// wireguard interface, so that connections between peers are filtered
+	// by the system firewall and not only by the allowed IPs of peers.
+	FirewallACLs bool
+	// FirewallBackend is the backend used to enforce network ACLs and
+	// denied prefixes on Linux. Defaults to netfilter.
+	FirewallBackend firewall.Backend
+	// TrafficPolicies renders the traffic policies enforced by this node into
+	// rate limits on the wireguard interface. It is only supported on Linux.
+	TrafficPolicies bool
 }
 
 func (o *Options) MarshalJSON() ([]byte, error) {
@@ -109,6 +138,13 @@ func (o *Options) MarshalJSON() ([]byte, error) {
 		"disableFullTunnel":     o.DisableFullTunnel,
 		"ignoreRoutes":          o.IgnoreRoutes,
 		"relays":                o.Relays,
+		"privsep":               o.SystemOps != nil,
+		"conntrack":             o.Conntrack,
+		"routeHealth":           o.RouteHealth,
+		"externalPeers":         o.ExternalPeers,
+		"firewallACLs":          o.FirewallACLs,
+		"firewallBackend":       o.FirewallBackend,
+		"trafficPolicies":       o.TrafficPolicies,
 	})
 }
 
@@ -117,6 +153,9 @@ func (o *Options) MarshalJSON() ([]byte, error) {
 type RelayOptions struct {
 	// Host are the options for a libp2p host.
 	Host libp2p.HostOptions
+	// TURNAuth are the credentials to use for TURN servers when
+	// negotiating WebRTC data channels.
+	TURNAuth datachannels.TURNAuth
 }
 
 // StartOptions are the options for starting the network manager and configuring
@@ -148,6 +187,14 @@ type Manager interface {
 	NetworkV6() netip.Prefix
 	// StartMasquerade ensures that masquerading is enabled.
 	StartMasquerade(ctx context.Context) error
+	// StartGateway enables IP forwarding and masquerades traffic from the mesh
+	// as it leaves the node toward the networks it routes. Traffic forwarded
+	// between peers over the wireguard interface is left untouched.
+	StartGateway(ctx context.Context) error
+	// StartNAT64 starts translating packets from IPv6-only members to IPv4
+	// destinations. Traffic from the NAT64 pool is masqueraded as it leaves
+	// the host.
+	StartNAT64(ctx context.Context, opts nat64.Options) error
 	// DNS returns the DNS server manager. The DNS server manager is only
 	// available after Start has been called.
 	DNS() DNSManager
@@ -159,6 +206,9 @@ type Manager interface {
 	// WireGuard returns the wireguard interface.
 	// The wireguard interface is only available after Start has been called.
 	WireGuard() wireguard.Interface
+	// Conntrack returns the connection tracking table. It is nil unless
+	// connection tracking is enabled and a userspace interface is in use.
+	Conntrack() *conntrack.Table
 	// Close closes the network manager and cleans up any resources.
 	Close(ctx context.Context) error
 }
@@ -170,6 +220,9 @@ func New(store storage.MeshDB, opts Options, nodeID types.NodeID) Manager {
 		storage: store,
 		opts:    opts,
 	}
+	if opts.RouteHealth != nil {
+		m.health = NewHandshakeHealth(nodeID, m, opts.RouteHealth.MaxHandshakeAge)
+	}
 	m.peers = newPeerManager(m)
 	return m
 }
@@ -183,8 +236,19 @@ type manager struct {
 	storage              storage.MeshDB
 	fw                   firewall.Firewall
 	wg                   wireguard.Interface
+	conntrack            *conntrack.Table
+	nat64                nat64.NAT64
+	health               PeerHealth
+	stopHealth           context.CancelFunc
+	stopFirewallMetrics  context.CancelFunc
 	networkv4, networkv6 netip.Prefix
 	masquerading         bool
+	gateway              bool
+	aclRules             []firewall.ACLRule
+	aclRulesSynced       bool
+	rateLimits           []firewall.RateLimit
+	rateLimitsSynced     bool
+	aclmu                sync.Mutex
 	mu                   sync.Mutex
 }
 
@@ -217,6 +281,10 @@ func (m *manager) WireGuard() wireguard.Interface {
 	return m.wg
 }
 
+func (m *manager) Conntrack() *conntrack.Table {
+	return m.conntrack
+}
+
 func (m *manager) Start(ctx context.Context, opts StartOptions) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -232,6 +300,10 @@ func (m *manager) Start(ctx context.Context, opts StartOptions) error {
 	}
 	log.Debug("Network manager start options", slog.Any("start-opts", opts))
 	handleErr := func(err error) error {
+		if m.conntrack != nil {
+			m.conntrack.Close()
+			m.conntrack = nil
+		}
 		if m.wg != nil {
 			if closeErr := m.wg.Close(ctx); closeErr != nil {
 				err = fmt.Errorf("%w: %v", err, closeErr)
@@ -266,14 +338,33 @@ func (m *manager) Start(ctx context.Context, opts StartOptions) error {
 		DisableIPv4:         m.opts.DisableIPv4,
 		DisableIPv6:         m.opts.DisableIPv6,
 		DisableFullTunnel:   m.opts.DisableFullTunnel,
+		SystemOps:           m.opts.SystemOps,
+	}
+	var wrapped bool
+	if m.opts.Conntrack != nil {
+		if m.opts.SystemOps != nil {
+			log.Warn("Connection tracking is not supported with a privileged helper, disabling")
+		} else {
+			m.conntrack = conntrack.New(*m.opts.Conntrack)
+			wgopts.TUNWrapper = func(dev tun.Device) tun.Device {
+				wrapped = true
+				return conntrack.WrapTUN(dev, m.conntrack)
+			}
+		}
 	}
 	log.Debug("Configuring wireguard", slog.Any("opts", wgopts))
 	m.wg, err = wireguard.New(ctx, wgopts)
 	if err != nil {
 		return handleErr(fmt.Errorf("new wireguard interface: %w", err))
 	}
+	if m.conntrack != nil && !wrapped {
+		log.Warn("Connection tracking requires a userspace interface, disabling")
+		m.conntrack.Close()
+		m.conntrack = nil
+	}
 	m.dns = &dnsManager{
 		wg:           m.wg,
+		ops:          privsep.OrLocal(m.opts.SystemOps),
 		storage:      m.storage,
 		localdnsaddr: m.opts.LocalDNSAddr,
 		dnsservers:   []netip.AddrPort{},
@@ -318,9 +409,10 @@ func (m *manager) Start(ctx context.Context, opts StartOptions) error {
 		WireguardPort: uint16(realPort),
 		StoragePort:   uint16(m.opts.StoragePort),
 		GRPCPort:      uint16(m.opts.GRPCPort),
+		Backend:       m.opts.FirewallBackend,
 	}
 	log.Debug("Configuring firewall", slog.Any("opts", fwopts))
-	m.fw, err = firewall.New(ctx, fwopts)
+	m.fw, err = privsep.OrLocal(m.opts.SystemOps).NewFirewall(ctx, fwopts)
 	if err != nil {
 		return handleErr(fmt.Errorf("new firewall manager: %w", err))
 	}
@@ -329,6 +421,16 @@ func (m *manager) Start(ctx context.Context, opts StartOptions) error {
 	if err != nil {
 		return handleErr(fmt.Errorf("add wireguard forwarding rule: %w", err))
 	}
+	if fc, ok := m.fw.(firewall.FlowCounter); ok && m.opts.RecordMetrics {
+		var metricsCtx context.Context
+		metricsCtx, m.stopFirewallMetrics = context.WithCancel(context.WithLogger(context.Background(), log))
+		go m.recordFirewallMetrics(metricsCtx, fc, m.opts.RecordMetricsInterval)
+	}
+	if m.health != nil {
+		var healthCtx context.Context
+		healthCtx, m.stopHealth = context.WithCancel(context.WithLogger(context.Background(), log))
+		go m.watchRouteHealth(healthCtx, m.opts.RouteHealth.Interval)
+	}
 	return nil
 }
 
@@ -398,11 +500,87 @@ func (m *manager) StartMasquerade(ctx context.Context) error {
 	return nil
 }
 
+func (m *manager) StartGateway(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.gateway {
+		return nil
+	}
+	// Forwarding is required regardless of who we are running as. Without it
+	// the gateway silently drops the traffic it advertises routes for.
+	if err := privsep.OrLocal(m.opts.SystemOps).EnableIPForwarding(ctx); err != nil {
+		return fmt.Errorf("enable ip forwarding: %w", err)
+	}
+	for _, network := range m.gatewayNetworks() {
+		err := m.fw.AddEgressMasquerade(ctx, m.wg.Name(), network)
+		if err != nil {
+			return fmt.Errorf("add egress masquerade rule for %s: %w", network, err)
+		}
+	}
+	m.gateway = true
+	return nil
+}
+
+// gatewayNetworks returns the mesh networks whose traffic is masqueraded when
+// acting as a gateway.
+func (m *manager) gatewayNetworks() []netip.Prefix {
+	var networks []netip.Prefix
+	if !m.opts.DisableIPv4 && m.networkv4.IsValid() {
+		networks = append(networks, m.networkv4)
+	}
+	if !m.opts.DisableIPv6 && m.networkv6.IsValid() {
+		networks = append(networks, m.networkv6)
+	}
+	return networks
+}
+
+func (m *manager) StartNAT64(ctx context.Context, opts nat64.Options) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.nat64 != nil {
+		return nil
+	}
+	if opts.Name == "" {
+		// Interface names are limited to 15 characters.
+		name := m.wg.Name()
+		if len(name) > 9 {
+			name = name[:9]
+		}
+		opts.Name = name + "-nat64"
+	}
+	opts.NetNs = m.opts.NetNs
+	opts.Default()
+	nat, err := privsep.OrLocal(m.opts.SystemOps).NewNAT64(ctx, &opts)
+	if err != nil {
+		return fmt.Errorf("new nat64: %w", err)
+	}
+	// Translated packets are forwarded from the device and leave the
+	// host with the address of its uplink.
+	err = m.fw.AddWireguardForwarding(ctx, nat.Name())
+	if err == nil {
+		err = m.fw.AddSourceMasquerade(ctx, opts.Pool)
+	}
+	if err != nil {
+		if closeErr := nat.Close(ctx); closeErr != nil {
+			err = fmt.Errorf("%w: %v", err, closeErr)
+		}
+		return fmt.Errorf("add nat64 firewall rules: %w", err)
+	}
+	m.nat64 = nat
+	return nil
+}
+
 func (m *manager) Close(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	log := context.LoggerFrom(ctx).With("component", "net-manager")
 	defer m.peers.Close(context.WithLogger(ctx, log))
+	if m.stopHealth != nil {
+		m.stopHealth()
+	}
+	if m.stopFirewallMetrics != nil {
+		m.stopFirewallMetrics()
+	}
 	if m.fw != nil {
 		// Clear the firewall rules after wireguard is shutdown
 		defer func() {
@@ -415,19 +593,28 @@ func (m *manager) Close(ctx context.Context) error {
 	if m.dns != nil {
 		if len(m.dns.dnsservers) > 0 {
 			log.Debug("Removing DNS servers", slog.Any("servers", m.dns.dnsservers))
-			err := dns.RemoveServers(m.wg.Name(), m.dns.dnsservers)
+			err := m.dns.ops.RemoveDNSServers(ctx, m.wg.Name(), m.dns.dnsservers)
 			if err != nil {
 				log.Error("error removing DNS servers", slog.String("error", err.Error()))
 			}
 		}
 		if len(m.dns.searchdomains) > 0 {
 			log.Debug("Removing DNS search domains", slog.Any("domains", m.dns.searchdomains))
-			err := dns.RemoveSearchDomains(m.wg.Name(), m.dns.searchdomains)
+			err := m.dns.ops.RemoveSearchDomains(ctx, m.wg.Name(), m.dns.searchdomains)
 			if err != nil {
 				log.Error("error removing DNS search domains", slog.String("error", err.Error()))
 			}
 		}
 	}
+	if m.conntrack != nil {
+		m.conntrack.Close()
+	}
+	if m.nat64 != nil {
+		log.Debug("Closing NAT64 device")
+		if err := m.nat64.Close(ctx); err != nil {
+			log.Error("error closing NAT64 device", slog.String("error", err.Error()))
+		}
+	}
 	if m.wg != nil {
 		log.Debug("Closing wireguard interface")
 		err := m.wg.Close(ctx)
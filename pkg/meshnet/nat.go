@@ -0,0 +1,77 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/endpoints"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// natTypes looks up the NAT types nodes reported to the mesh. Lookups are
+// cached for the lifetime of the value.
+type natTypes struct {
+	st    storage.MeshStorage
+	cache map[types.NodeID]endpoints.NATType
+}
+
+// newNATTypes returns NAT type lookups against the given database. Every node
+// is reported as behind an unknown NAT if the database does not expose its
+// underlying storage.
+func newNATTypes(db storage.MeshDB) *natTypes {
+	return &natTypes{
+		st:    storage.MeshStorageOf(db),
+		cache: make(map[types.NodeID]endpoints.NATType),
+	}
+}
+
+// Get returns the NAT type reported by the given node.
+func (n *natTypes) Get(ctx context.Context, nodeID types.NodeID) endpoints.NATType {
+	if n.st == nil {
+		return endpoints.NATUnknown
+	}
+	if natType, ok := n.cache[nodeID]; ok {
+		return natType
+	}
+	natType := endpoints.NATUnknown
+	rec, err := storage.GetNATType(ctx, n.st, nodeID)
+	if err != nil {
+		context.LoggerFrom(ctx).Debug("Failed to get NAT type", slog.String("node", nodeID.String()), slog.String("error", err.Error()))
+	} else if parsed, err := endpoints.ParseNATType(rec.Type); err == nil {
+		natType = parsed
+	}
+	n.cache[nodeID] = natType
+	return natType
+}
+
+// ConnectProto returns the protocol to use for an edge between the given nodes.
+// Native edges between nodes whose NATs cannot be traversed are relayed over
+// libp2p instead. Both ends of the edge select the same protocol.
+func (n *natTypes) ConnectProto(ctx context.Context, proto v1.ConnectProtocol, a, b types.NodeID) v1.ConnectProtocol {
+	if proto != v1.ConnectProtocol_CONNECT_NATIVE {
+		return proto
+	}
+	if endpoints.SelectStrategy(n.Get(ctx, a), n.Get(ctx, b)) == endpoints.StrategyRelay {
+		return v1.ConnectProtocol_CONNECT_LIBP2P
+	}
+	return proto
+}
@@ -14,26 +14,130 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-// Package nat64 provides a stateless bi-directional NAT64 implementation. It is
-// based on eBPF and XDP and is intended to be used in conjunction with a mesh network.
+// Package nat64 provides NAT64 for gateway nodes, allowing IPv6-only members of
+// a mesh to reach IPv4 destinations.
 //
-// TODO: The intention of this package is to be a potential option for bridging
-// meshes to either the public internet or other meshes. A node could use part of
-// its private IPv6 allocation to provide IPv6/IPv4 translation to another mesh. It
-// could also use a public IPv6 allocation to provide translation into its current
-// mesh. There are other projects capable of providing this functionality, such as
-// Jool, but it may be nice to have an option that is more tightly integrated with
-// the mesh.
+// IPv6 packets sent to addresses that embed an IPv4 address in the NAT64 prefix
+// (RFC 6052) are routed to a TUN device, where they are translated to IPv4
+// (RFC 7915). The source of each IPv6 host is mapped to an address in an IPv4
+// pool, and the gateway masquerades traffic from the pool as it leaves the host,
+// making the combination a stateful NAT64. Replies are translated back on the
+// same device. Clients find the translated addresses through DNS64, which is
+// provided by the Mesh DNS server.
 package nat64
 
-import "net/netip"
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// WellKnownPrefix is the well-known NAT64 prefix defined by RFC 6052.
+var WellKnownPrefix = netip.MustParsePrefix("64:ff9b::/96")
+
+// DefaultPool is the default IPv4 pool IPv6 sources are mapped to.
+var DefaultPool = netip.MustParsePrefix("192.168.255.0/24")
+
+// DefaultMTU is the default MTU of the translation device.
+const DefaultMTU = 1420
+
+// DefaultMappingTimeout is the default time an idle source mapping is kept.
+const DefaultMappingTimeout = 5 * time.Minute
+
+// NAT64 is a running NAT64 translator.
+type NAT64 interface {
+	// Name returns the name of the device packets are translated on.
+	Name() string
+	// Close stops translating packets and removes the device.
+	Close(ctx context.Context) error
+}
 
 // Options contains the configuration options for a NAT64 instance.
 type Options struct {
-	// The interfaces to swap IPv4 and IPv6 addresses on.
-	LIface, RIface string
-	// The IPv6 prefixes to use for translation.
-	LPrefixV6, RPrefixV6 netip.Prefix
-	// The IPv4 prefixes to use for translation.
-	LPrefixV4, RPrefixV4 netip.Prefix
+	// Name is the name of the TUN device packets are translated on.
+	Name string
+	// NetNs is the network namespace to create the device in.
+	// This is only applicable on Linux.
+	NetNs string
+	// Prefix is the NAT64 prefix IPv4 addresses are embedded in. Only /96
+	// prefixes are supported. Defaults to WellKnownPrefix.
+	Prefix netip.Prefix
+	// Pool is the IPv4 prefix IPv6 sources are mapped to. Each source uses
+	// one address of the pool while it is active. It must not overlap with
+	// any network in use on the host. Defaults to DefaultPool.
+	Pool netip.Prefix
+	// Destinations are the IPv4 prefixes packets may be translated to.
+	// Packets to other destinations are dropped. If empty, packets to
+	// any destination are translated.
+	Destinations []netip.Prefix
+	// MTU is the MTU of the device. Defaults to DefaultMTU.
+	MTU uint32
+	// MappingTimeout is how long an idle source mapping is kept before its
+	// pool address can be reused. Defaults to DefaultMappingTimeout.
+	MappingTimeout time.Duration
+}
+
+// Default sets the default values for any unset options.
+func (o *Options) Default() {
+	if !o.Prefix.IsValid() {
+		o.Prefix = WellKnownPrefix
+	}
+	if !o.Pool.IsValid() {
+		o.Pool = DefaultPool
+	}
+	if o.MTU == 0 {
+		o.MTU = DefaultMTU
+	}
+	if o.MappingTimeout <= 0 {
+		o.MappingTimeout = DefaultMappingTimeout
+	}
+}
+
+// Validate validates the options.
+func (o *Options) Validate() error {
+	if o.Name == "" {
+		return fmt.Errorf("nat64 device name must not be empty")
+	}
+	if !o.Prefix.Addr().Is6() || o.Prefix.Addr().Is4In6() || o.Prefix.Bits() != 96 {
+		return fmt.Errorf("nat64 prefix %s must be an IPv6 /96 prefix", o.Prefix)
+	}
+	if !o.Pool.Addr().Is4() || o.Pool.Bits() > 30 {
+		return fmt.Errorf("nat64 pool %s must be an IPv4 prefix of at least 4 addresses", o.Pool)
+	}
+	for _, dst := range o.Destinations {
+		if !dst.Addr().Is4() {
+			return fmt.Errorf("nat64 destination %s must be an IPv4 prefix", dst)
+		}
+	}
+	return nil
+}
+
+// New creates the translation device and starts translating packets.
+func New(ctx context.Context, opts Options) (NAT64, error) {
+	opts.Default()
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	return newNAT64(ctx, opts)
+}
+
+// Embed returns the IPv6 address embedding the given IPv4 address in a /96
+// NAT64 prefix.
+func Embed(prefix netip.Prefix, addr netip.Addr) netip.Addr {
+	out := prefix.Masked().Addr().As16()
+	v4 := addr.As4()
+	copy(out[12:], v4[:])
+	return netip.AddrFrom16(out)
+}
+
+// Extract returns the IPv4 address embedded in an address of a /96 NAT64
+// prefix. It returns false if the address is not in the prefix.
+func Extract(prefix netip.Prefix, addr netip.Addr) (netip.Addr, bool) {
+	if !addr.Is6() || addr.Is4In6() || !prefix.Contains(addr) {
+		return netip.Addr{}, false
+	}
+	b := addr.As16()
+	return netip.AddrFrom4([4]byte(b[12:])), true
 }
@@ -0,0 +1,162 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nat64
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+
+	"golang.zx2c4.com/wireguard/tun"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/link"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+)
+
+// packetOffset is the headroom left in front of packets read from and
+// written to the device.
+const packetOffset = 16
+
+// tunNAT64 translates packets on a TUN device.
+type tunNAT64 struct {
+	name string
+	dev  tun.Device
+	tr   *translator
+	log  *slog.Logger
+	done chan struct{}
+}
+
+func newNAT64(ctx context.Context, opts Options) (NAT64, error) {
+	log := context.LoggerFrom(ctx).With("component", "nat64")
+	var dev tun.Device
+	var name string
+	create := func() error {
+		var err error
+		dev, err = tun.CreateTUN(opts.Name, int(opts.MTU))
+		if err != nil {
+			return fmt.Errorf("create tun: %w", err)
+		}
+		name, err = dev.Name()
+		if err != nil {
+			dev.Close()
+			return fmt.Errorf("get tun name: %w", err)
+		}
+		if err := link.ActivateInterface(ctx, name); err != nil {
+			dev.Close()
+			return fmt.Errorf("activate interface: %w", err)
+		}
+		// Translated packets are written back to the device, so both the
+		// NAT64 prefix and the pool are routed to it.
+		for _, prefix := range []netip.Prefix{opts.Prefix, opts.Pool} {
+			err := routes.Add(ctx, name, prefix)
+			if err != nil && !errors.Is(err, routes.ErrRouteExists) {
+				dev.Close()
+				return fmt.Errorf("add route for %s: %w", prefix, err)
+			}
+		}
+		return nil
+	}
+	var err error
+	if opts.NetNs != "" {
+		err = system.DoInNetNS(opts.NetNs, create)
+	} else {
+		err = create()
+	}
+	if err != nil {
+		return nil, err
+	}
+	n := &tunNAT64{
+		name: name,
+		dev:  dev,
+		tr:   newTranslator(opts),
+		log:  log,
+		done: make(chan struct{}),
+	}
+	log.Info("Started NAT64",
+		slog.String("interface", name),
+		slog.String("prefix", opts.Prefix.String()),
+		slog.String("pool", opts.Pool.String()),
+	)
+	go func() {
+		for range dev.Events() {
+		}
+	}()
+	go n.run()
+	return n, nil
+}
+
+// Name returns the name of the device packets are translated on.
+func (n *tunNAT64) Name() string {
+	return n.name
+}
+
+// Close stops translating packets and removes the device.
+func (n *tunNAT64) Close(ctx context.Context) error {
+	err := n.dev.Close()
+	<-n.done
+	return err
+}
+
+func (n *tunNAT64) run() {
+	defer close(n.done)
+	batch := n.dev.BatchSize()
+	bufs := make([][]byte, batch)
+	for i := range bufs {
+		// Reads may coalesce segments up to the maximum IP packet size.
+		bufs[i] = make([]byte, packetOffset+65535)
+	}
+	sizes := make([]int, batch)
+	out := make([][]byte, 0, batch)
+	for {
+		count, err := n.dev.Read(bufs, sizes, packetOffset)
+		if err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			if errors.Is(err, tun.ErrTooManySegments) {
+				n.log.Debug("Dropped segments of a coalesced packet")
+				continue
+			}
+			n.log.Error("Failed to read from NAT64 device", slog.String("error", err.Error()))
+			return
+		}
+		out = out[:0]
+		for i := 0; i < count; i++ {
+			pkt, err := n.tr.translate(bufs[i][packetOffset : packetOffset+sizes[i]])
+			if err != nil {
+				n.log.Debug("Dropping untranslatable packet", slog.String("error", err.Error()))
+				continue
+			}
+			buf := make([]byte, packetOffset+len(pkt))
+			copy(buf[packetOffset:], pkt)
+			out = append(out, buf)
+		}
+		if len(out) == 0 {
+			continue
+		}
+		if _, err := n.dev.Write(out, packetOffset); err != nil {
+			if errors.Is(err, os.ErrClosed) {
+				return
+			}
+			n.log.Debug("Failed to write translated packets", slog.String("error", err.Error()))
+		}
+	}
+}
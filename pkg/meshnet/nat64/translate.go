@@ -0,0 +1,342 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nat64
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+
+	protoICMP   = 1
+	protoTCP    = 6
+	protoUDP    = 17
+	protoICMPv6 = 58
+)
+
+// errPoolExhausted is returned when every address of the pool is mapped to
+// an active source.
+var errPoolExhausted = errors.New("nat64 pool exhausted")
+
+// icmpToV6 and icmpToV4 map the ICMP echo types between the two families.
+// Other ICMP messages are not translated.
+var (
+	icmpToV6 = map[uint8]uint8{8: 128, 0: 129}
+	icmpToV4 = map[uint8]uint8{128: 8, 129: 0}
+)
+
+// translator translates packets between IPv6 and IPv4 and keeps the
+// mappings of IPv6 sources to pool addresses.
+type translator struct {
+	prefix  netip.Prefix
+	pool    netip.Prefix
+	dests   []netip.Prefix
+	timeout time.Duration
+	now     func() time.Time
+	bySrc   map[netip.Addr]*mapping
+	byPool  map[netip.Addr]*mapping
+	mu      sync.Mutex
+}
+
+// mapping maps an IPv6 source to an address of the pool.
+type mapping struct {
+	src      netip.Addr
+	pool     netip.Addr
+	lastSeen time.Time
+}
+
+func newTranslator(opts Options) *translator {
+	return &translator{
+		prefix:  opts.Prefix,
+		pool:    opts.Pool.Masked(),
+		dests:   opts.Destinations,
+		timeout: opts.MappingTimeout,
+		now:     time.Now,
+		bySrc:   make(map[netip.Addr]*mapping),
+		byPool:  make(map[netip.Addr]*mapping),
+	}
+}
+
+// translate translates an IPv6 packet to IPv4 or an IPv4 packet to IPv6.
+func (t *translator) translate(pkt []byte) ([]byte, error) {
+	if len(pkt) == 0 {
+		return nil, fmt.Errorf("empty packet")
+	}
+	switch pkt[0] >> 4 {
+	case 6:
+		return t.toIPv4(pkt)
+	case 4:
+		return t.toIPv6(pkt)
+	default:
+		return nil, fmt.Errorf("unknown IP version %d", pkt[0]>>4)
+	}
+}
+
+// toIPv4 translates a packet from an IPv6 host to an embedded IPv4 destination.
+func (t *translator) toIPv4(pkt []byte) ([]byte, error) {
+	if len(pkt) < ipv6HeaderLen {
+		return nil, fmt.Errorf("truncated IPv6 header")
+	}
+	payloadLen := int(binary.BigEndian.Uint16(pkt[4:6]))
+	if len(pkt) < ipv6HeaderLen+payloadLen {
+		return nil, fmt.Errorf("truncated IPv6 packet")
+	}
+	src := netip.AddrFrom16([16]byte(pkt[8:24]))
+	dst, ok := Extract(t.prefix, netip.AddrFrom16([16]byte(pkt[24:40])))
+	if !ok {
+		return nil, fmt.Errorf("destination is not in the NAT64 prefix")
+	}
+	if !t.allowed(dst) {
+		return nil, fmt.Errorf("destination %s is not allowed", dst)
+	}
+	// Extension headers, including fragments, are not translated.
+	proto := pkt[6]
+	switch proto {
+	case protoTCP, protoUDP:
+	case protoICMPv6:
+		proto = protoICMP
+	default:
+		return nil, fmt.Errorf("unsupported next header %d", proto)
+	}
+	poolAddr, err := t.mapSource(src)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, ipv4HeaderLen+payloadLen)
+	out[0] = 0x45
+	out[1] = pkt[0]<<4 | pkt[1]>>4
+	binary.BigEndian.PutUint16(out[2:4], uint16(len(out)))
+	// Don't fragment, the IPv6 host relies on path MTU discovery.
+	out[6] = 0x40
+	out[8] = pkt[7]
+	out[9] = proto
+	copy(out[12:16], poolAddr.AsSlice())
+	copy(out[16:20], dst.AsSlice())
+	binary.BigEndian.PutUint16(out[10:12], checksum(out[:ipv4HeaderLen], 0))
+	payload := out[ipv4HeaderLen:]
+	copy(payload, pkt[ipv6HeaderLen:ipv6HeaderLen+payloadLen])
+	if proto == protoICMP {
+		if err := translateICMP(payload, icmpToV4); err != nil {
+			return nil, err
+		}
+	}
+	if err := setChecksum(payload, proto, poolAddr, dst); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// toIPv6 translates a packet from an IPv4 host to the IPv6 source mapped to
+// its destination.
+func (t *translator) toIPv6(pkt []byte) ([]byte, error) {
+	if len(pkt) < ipv4HeaderLen {
+		return nil, fmt.Errorf("truncated IPv4 header")
+	}
+	headerLen := int(pkt[0]&0x0f) * 4
+	totalLen := int(binary.BigEndian.Uint16(pkt[2:4]))
+	if headerLen < ipv4HeaderLen || totalLen < headerLen || len(pkt) < totalLen {
+		return nil, fmt.Errorf("truncated IPv4 packet")
+	}
+	if binary.BigEndian.Uint16(pkt[6:8])&0x3fff != 0 {
+		return nil, fmt.Errorf("fragmented packets are not supported")
+	}
+	src := netip.AddrFrom4([4]byte(pkt[12:16]))
+	dst, ok := t.lookupPool(netip.AddrFrom4([4]byte(pkt[16:20])))
+	if !ok {
+		return nil, fmt.Errorf("no mapping for destination")
+	}
+	if !t.allowed(src) {
+		return nil, fmt.Errorf("source %s is not allowed", src)
+	}
+	proto := pkt[9]
+	switch proto {
+	case protoTCP, protoUDP:
+	case protoICMP:
+		proto = protoICMPv6
+	default:
+		return nil, fmt.Errorf("unsupported protocol %d", proto)
+	}
+	payloadLen := totalLen - headerLen
+	out := make([]byte, ipv6HeaderLen+payloadLen)
+	out[0] = 0x60 | pkt[1]>>4
+	out[1] = pkt[1] << 4
+	binary.BigEndian.PutUint16(out[4:6], uint16(payloadLen))
+	out[6] = proto
+	out[7] = pkt[8]
+	embedded := Embed(t.prefix, src)
+	copy(out[8:24], embedded.AsSlice())
+	copy(out[24:40], dst.AsSlice())
+	payload := out[ipv6HeaderLen:]
+	copy(payload, pkt[headerLen:totalLen])
+	if proto == protoICMPv6 {
+		if err := translateICMP(payload, icmpToV6); err != nil {
+			return nil, err
+		}
+	}
+	if err := setChecksum(payload, proto, embedded, dst); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// allowed returns true if packets may be translated to or from the given
+// IPv4 address.
+func (t *translator) allowed(addr netip.Addr) bool {
+	if len(t.dests) == 0 {
+		return true
+	}
+	for _, dst := range t.dests {
+		if dst.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// mapSource returns the pool address mapped to the given IPv6 source,
+// mapping a free address if there is none.
+func (t *translator) mapSource(src netip.Addr) (netip.Addr, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if m, ok := t.bySrc[src]; ok {
+		m.lastSeen = now
+		return m.pool, nil
+	}
+	addr, ok := t.freeAddr(now)
+	if !ok {
+		return netip.Addr{}, errPoolExhausted
+	}
+	m := &mapping{src: src, pool: addr, lastSeen: now}
+	t.bySrc[src] = m
+	t.byPool[addr] = m
+	return addr, nil
+}
+
+// lookupPool returns the IPv6 source mapped to the given pool address.
+func (t *translator) lookupPool(addr netip.Addr) (netip.Addr, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	m, ok := t.byPool[addr]
+	if !ok {
+		return netip.Addr{}, false
+	}
+	m.lastSeen = t.now()
+	return m.src, true
+}
+
+// freeAddr returns an unmapped address of the pool. If every address is
+// mapped, the least recently used mapping is released if it expired.
+func (t *translator) freeAddr(now time.Time) (netip.Addr, bool) {
+	// The network and broadcast addresses of the pool are skipped.
+	for addr := t.pool.Addr().Next(); t.pool.Contains(addr.Next()); addr = addr.Next() {
+		if _, ok := t.byPool[addr]; !ok {
+			return addr, true
+		}
+	}
+	var oldest *mapping
+	for _, m := range t.byPool {
+		if oldest == nil || m.lastSeen.Before(oldest.lastSeen) {
+			oldest = m
+		}
+	}
+	if oldest == nil || now.Sub(oldest.lastSeen) < t.timeout {
+		return netip.Addr{}, false
+	}
+	delete(t.bySrc, oldest.src)
+	delete(t.byPool, oldest.pool)
+	return oldest.pool, true
+}
+
+// translateICMP rewrites the type of an ICMP echo message using the given
+// type map.
+func translateICMP(payload []byte, types map[uint8]uint8) error {
+	if len(payload) < 8 {
+		return fmt.Errorf("truncated ICMP header")
+	}
+	typ, ok := types[payload[0]]
+	if !ok {
+		return fmt.Errorf("unsupported ICMP type %d", payload[0])
+	}
+	payload[0] = typ
+	return nil
+}
+
+// setChecksum recomputes the checksum of a TCP, UDP, or ICMP payload sent
+// from src to dst.
+func setChecksum(payload []byte, proto uint8, src, dst netip.Addr) error {
+	var offset, minLen int
+	switch proto {
+	case protoTCP:
+		offset, minLen = 16, 20
+	case protoUDP:
+		offset, minLen = 6, 8
+	case protoICMP, protoICMPv6:
+		offset, minLen = 2, 8
+	}
+	if len(payload) < minLen {
+		return fmt.Errorf("truncated protocol %d header", proto)
+	}
+	payload[offset], payload[offset+1] = 0, 0
+	var sum uint32
+	// Only ICMP for IPv4 is computed without a pseudo-header.
+	if proto != protoICMP {
+		sum = pseudoHeaderSum(src, dst, proto, len(payload))
+	}
+	csum := checksum(payload, sum)
+	if proto == protoUDP && csum == 0 {
+		csum = 0xffff
+	}
+	binary.BigEndian.PutUint16(payload[offset:offset+2], csum)
+	return nil
+}
+
+// pseudoHeaderSum returns the sum of the pseudo-header covered by transport
+// checksums.
+func pseudoHeaderSum(src, dst netip.Addr, proto uint8, length int) uint32 {
+	var sum uint32
+	for _, addr := range [][]byte{src.AsSlice(), dst.AsSlice()} {
+		for i := 0; i < len(addr); i += 2 {
+			sum += uint32(binary.BigEndian.Uint16(addr[i:]))
+		}
+	}
+	sum += uint32(proto)
+	sum += uint32(length>>16) + uint32(length&0xffff)
+	return sum
+}
+
+// checksum returns the internet checksum of b added to the initial sum.
+func checksum(b []byte, initial uint32) uint16 {
+	sum := initial
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,231 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nat64
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestEmbedExtract(t *testing.T) {
+	t.Parallel()
+	addr := netip.MustParseAddr("192.0.2.33")
+	embedded := Embed(WellKnownPrefix, addr)
+	if embedded != netip.MustParseAddr("64:ff9b::c000:221") {
+		t.Fatalf("expected 64:ff9b::c000:221, got %s", embedded)
+	}
+	extracted, ok := Extract(WellKnownPrefix, embedded)
+	if !ok || extracted != addr {
+		t.Fatalf("expected to extract %s, got %s", addr, extracted)
+	}
+	if _, ok := Extract(WellKnownPrefix, netip.MustParseAddr("2001:db8::c000:221")); ok {
+		t.Fatal("expected address outside the prefix to not be extracted")
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	t.Parallel()
+	member := netip.MustParseAddr("fd00:1::2")
+	remote := netip.MustParseAddr("192.0.2.1")
+	tr := newTestTranslator(Options{})
+
+	tc := []struct {
+		name    string
+		proto   uint8
+		payload []byte
+	}{
+		{
+			name:    "udp",
+			proto:   protoUDP,
+			payload: []byte{0x30, 0x39, 0x00, 0x35, 0x00, 0x0c, 0x00, 0x00, 'p', 'i', 'n', 'g'},
+		},
+		{
+			name:    "tcp",
+			proto:   protoTCP,
+			payload: []byte{0x30, 0x39, 0x00, 0x50, 0, 0, 0, 1, 0, 0, 0, 0, 0x50, 0x02, 0xff, 0xff, 0, 0, 0, 0},
+		},
+		{
+			name:    "icmp echo",
+			proto:   protoICMPv6,
+			payload: []byte{128, 0, 0, 0, 0x12, 0x34, 0x00, 0x01, 'p', 'i', 'n', 'g'},
+		},
+	}
+	for _, tt := range tc {
+		pkt := newIPv6Packet(member, Embed(WellKnownPrefix, remote), tt.proto, tt.payload)
+		out, err := tr.translate(pkt)
+		if err != nil {
+			t.Errorf("%s: translate to IPv4: %v", tt.name, err)
+			continue
+		}
+		if out[0] != 0x45 || checksum(out[:ipv4HeaderLen], 0) != 0 {
+			t.Errorf("%s: invalid IPv4 header", tt.name)
+		}
+		src, dst := netip.AddrFrom4([4]byte(out[12:16])), netip.AddrFrom4([4]byte(out[16:20]))
+		if src != netip.MustParseAddr("192.168.255.1") || dst != remote {
+			t.Errorf("%s: expected 192.168.255.1 -> %s, got %s -> %s", tt.name, remote, src, dst)
+		}
+		if !validChecksum(out[ipv4HeaderLen:], out[9], src, dst) {
+			t.Errorf("%s: invalid IPv4 payload checksum", tt.name)
+		}
+		if tt.proto == protoICMPv6 && (out[9] != protoICMP || out[ipv4HeaderLen] != 8) {
+			t.Errorf("%s: expected an ICMP echo request, got protocol %d type %d", tt.name, out[9], out[ipv4HeaderLen])
+		}
+
+		// Send the packet back as a reply.
+		reply := make([]byte, len(out))
+		copy(reply, out)
+		copy(reply[12:16], out[16:20])
+		copy(reply[16:20], out[12:16])
+		if tt.proto == protoICMPv6 {
+			reply[ipv4HeaderLen] = 0
+		}
+		back, err := tr.translate(reply)
+		if err != nil {
+			t.Errorf("%s: translate to IPv6: %v", tt.name, err)
+			continue
+		}
+		src, dst = netip.AddrFrom16([16]byte(back[8:24])), netip.AddrFrom16([16]byte(back[24:40]))
+		if src != Embed(WellKnownPrefix, remote) || dst != member {
+			t.Errorf("%s: expected %s -> %s, got %s -> %s", tt.name, Embed(WellKnownPrefix, remote), member, src, dst)
+		}
+		if int(binary.BigEndian.Uint16(back[4:6])) != len(tt.payload) {
+			t.Errorf("%s: expected payload length %d, got %d", tt.name, len(tt.payload), binary.BigEndian.Uint16(back[4:6]))
+		}
+		if !validChecksum(back[ipv6HeaderLen:], back[6], src, dst) {
+			t.Errorf("%s: invalid IPv6 payload checksum", tt.name)
+		}
+		if tt.proto == protoICMPv6 && back[ipv6HeaderLen] != 129 {
+			t.Errorf("%s: expected an ICMPv6 echo reply, got type %d", tt.name, back[ipv6HeaderLen])
+		}
+	}
+}
+
+func TestTranslateDrops(t *testing.T) {
+	t.Parallel()
+	member := netip.MustParseAddr("fd00:1::2")
+	udp := []byte{0x30, 0x39, 0x00, 0x35, 0x00, 0x08, 0x00, 0x00}
+	tr := newTestTranslator(Options{
+		Destinations: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+	})
+
+	tc := []struct {
+		name string
+		pkt  []byte
+	}{
+		{
+			name: "destination not allowed",
+			pkt:  newIPv6Packet(member, Embed(WellKnownPrefix, netip.MustParseAddr("198.51.100.1")), protoUDP, udp),
+		},
+		{
+			name: "destination outside prefix",
+			pkt:  newIPv6Packet(member, netip.MustParseAddr("2001:db8::c000:201"), protoUDP, udp),
+		},
+		{
+			name: "extension header",
+			pkt:  newIPv6Packet(member, Embed(WellKnownPrefix, netip.MustParseAddr("192.0.2.1")), 44, udp),
+		},
+		{
+			name: "unmapped pool address",
+			pkt:  newIPv4Packet(netip.MustParseAddr("192.0.2.1"), netip.MustParseAddr("192.168.255.9"), protoUDP, udp),
+		},
+	}
+	for _, tt := range tc {
+		if _, err := tr.translate(tt.pkt); err == nil {
+			t.Errorf("%s: expected packet to be dropped", tt.name)
+		}
+	}
+}
+
+func TestTranslatorPool(t *testing.T) {
+	t.Parallel()
+	now := time.Now()
+	tr := newTestTranslator(Options{Pool: netip.MustParsePrefix("192.168.255.0/30")})
+	tr.now = func() time.Time { return now }
+
+	first, err := tr.mapSource(netip.MustParseAddr("fd00:1::1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tr.mapSource(netip.MustParseAddr("fd00:1::2")); err != nil {
+		t.Fatal(err)
+	}
+	// The pool has two usable addresses and both mappings are active.
+	if _, err := tr.mapSource(netip.MustParseAddr("fd00:1::3")); err != errPoolExhausted {
+		t.Fatalf("expected pool to be exhausted, got %v", err)
+	}
+	// Existing sources keep their mapping.
+	if addr, _ := tr.mapSource(netip.MustParseAddr("fd00:1::1")); addr != first {
+		t.Fatalf("expected mapping to be kept as %s, got %s", first, addr)
+	}
+	// Once a mapping expires its address is reused.
+	now = now.Add(DefaultMappingTimeout / 2)
+	if _, err := tr.mapSource(netip.MustParseAddr("fd00:1::1")); err != nil {
+		t.Fatal(err)
+	}
+	now = now.Add(DefaultMappingTimeout)
+	if _, err := tr.mapSource(netip.MustParseAddr("fd00:1::3")); err != nil {
+		t.Fatalf("expected expired mapping to be reused, got %v", err)
+	}
+	if _, ok := tr.lookupPool(first); !ok {
+		t.Fatal("expected the most recently used mapping to be kept")
+	}
+}
+
+func newTestTranslator(opts Options) *translator {
+	opts.Name = "nat64test"
+	opts.Default()
+	return newTranslator(opts)
+}
+
+func newIPv6Packet(src, dst netip.Addr, proto uint8, payload []byte) []byte {
+	pkt := make([]byte, ipv6HeaderLen+len(payload))
+	pkt[0] = 0x60
+	binary.BigEndian.PutUint16(pkt[4:6], uint16(len(payload)))
+	pkt[6] = proto
+	pkt[7] = 64
+	copy(pkt[8:24], src.AsSlice())
+	copy(pkt[24:40], dst.AsSlice())
+	copy(pkt[ipv6HeaderLen:], payload)
+	if proto == protoTCP || proto == protoUDP || proto == protoICMPv6 {
+		_ = setChecksum(pkt[ipv6HeaderLen:], proto, src, dst)
+	}
+	return pkt
+}
+
+func newIPv4Packet(src, dst netip.Addr, proto uint8, payload []byte) []byte {
+	pkt := make([]byte, ipv4HeaderLen+len(payload))
+	pkt[0] = 0x45
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(len(pkt)))
+	pkt[8] = 64
+	pkt[9] = proto
+	copy(pkt[12:16], src.AsSlice())
+	copy(pkt[16:20], dst.AsSlice())
+	binary.BigEndian.PutUint16(pkt[10:12], checksum(pkt[:ipv4HeaderLen], 0))
+	copy(pkt[ipv4HeaderLen:], payload)
+	_ = setChecksum(pkt[ipv4HeaderLen:], proto, src, dst)
+	return pkt
+}
+
+func validChecksum(payload []byte, proto uint8, src, dst netip.Addr) bool {
+	var sum uint32
+	if proto != protoICMP {
+		sum = pseudoHeaderSum(src, dst, proto, len(payload))
+	}
+	return checksum(payload, sum) == 0
+}
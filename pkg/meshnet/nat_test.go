@@ -0,0 +1,108 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/endpoints"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestWireGuardPeersNATStrategy(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: "172.16.0.0/12",
+			NetworkV6: "2001:db8::/64",
+			Domain:    "example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set network state: %v", err)
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "allow-all",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+		SourceCIDRs:      []string{"*"},
+		DestinationCIDRs: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network ACL: %v", err)
+	}
+	nats := map[string]endpoints.NATType{
+		"a": endpoints.NATSymmetric,
+		"b": endpoints.NATRestrictedCone,
+		"c": endpoints.NATFullCone,
+	}
+	for i, id := range []string{"a", "b", "c"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          id,
+			PublicKey:   mustGeneratePublicKey(t),
+			PrivateIPv4: []string{"172.16.0.1/32", "172.16.0.2/32", "172.16.0.3/32"}[i],
+			PrivateIPv6: []string{"2001:db8::1/128", "2001:db8::2/128", "2001:db8::3/128"}[i],
+		}})
+		if err != nil {
+			t.Fatalf("create peer: %v", err)
+		}
+		if err := storage.SetNATType(ctx, storage.MeshStorageOf(db), types.NodeID(id), string(nats[id])); err != nil {
+			t.Fatalf("set nat type: %v", err)
+		}
+	}
+	for _, edge := range [][2]string{{"a", "b"}, {"a", "c"}, {"b", "c"}} {
+		err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+			Source: edge[0],
+			Target: edge[1],
+		}})
+		if err != nil {
+			t.Fatalf("put edge: %v", err)
+		}
+	}
+	want := map[[2]string]v1.ConnectProtocol{
+		{"a", "b"}: v1.ConnectProtocol_CONNECT_LIBP2P,
+		{"b", "a"}: v1.ConnectProtocol_CONNECT_LIBP2P,
+		{"a", "c"}: v1.ConnectProtocol_CONNECT_NATIVE,
+		{"c", "a"}: v1.ConnectProtocol_CONNECT_NATIVE,
+		{"b", "c"}: v1.ConnectProtocol_CONNECT_NATIVE,
+		{"c", "b"}: v1.ConnectProtocol_CONNECT_NATIVE,
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		peers, err := WireGuardPeersFor(ctx, db, types.NodeID(id))
+		if err != nil {
+			t.Fatalf("get WireGuard peers for %q: %v", id, err)
+		}
+		if len(peers) != 2 {
+			t.Fatalf("expected 2 peers for %q, got %d", id, len(peers))
+		}
+		for _, peer := range peers {
+			key := [2]string{id, peer.GetNode().GetId()}
+			if peer.GetProto() != want[key] {
+				t.Errorf("expected %s between %s and %s, got %s", want[key], key[0], key[1], peer.GetProto())
+			}
+		}
+	}
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutil
+
+import (
+	"net"
+	"net/netip"
+)
+
+// AddressFamilies reports which address families are usable on this host.
+type AddressFamilies struct {
+	// IPv4 is true if the host can open IPv4 sockets.
+	IPv4 bool
+	// IPv6 is true if the host can open IPv6 sockets.
+	IPv6 bool
+	// RoutableIPv4 is true if an interface that is up has an IPv4 address
+	// that is neither loopback nor link-local.
+	RoutableIPv4 bool
+	// RoutableIPv6 is true if an interface that is up has an IPv6 address
+	// that is neither loopback nor link-local.
+	RoutableIPv6 bool
+}
+
+// DetectAddressFamilies detects the address families available on this host.
+// Support for a family is probed by binding a socket to its loopback address.
+// The result is not cached, so it reflects interfaces coming and going.
+func DetectAddressFamilies() AddressFamilies {
+	families := AddressFamilies{
+		IPv4: canBind("udp4", "127.0.0.1:0"),
+		IPv6: canBind("udp6", "[::1]:0"),
+	}
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return families
+	}
+	var addrs []net.Addr
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		addrs = append(addrs, ifaddrs...)
+	}
+	families.RoutableIPv4, families.RoutableIPv6 = routableFamilies(addrs)
+	families.RoutableIPv4 = families.RoutableIPv4 && families.IPv4
+	families.RoutableIPv6 = families.RoutableIPv6 && families.IPv6
+	return families
+}
+
+// Loopback returns the loopback address of a supported family, preferring IPv4.
+func (f AddressFamilies) Loopback() netip.Addr {
+	if !f.IPv4 && f.IPv6 {
+		return netip.IPv6Loopback()
+	}
+	return netip.AddrFrom4([4]byte{127, 0, 0, 1})
+}
+
+// Unspecified returns the unspecified address of a routable family,
+// preferring IPv4.
+func (f AddressFamilies) Unspecified() netip.Addr {
+	if !f.RoutableIPv4 && f.RoutableIPv6 {
+		return netip.IPv6Unspecified()
+	}
+	return netip.IPv4Unspecified()
+}
+
+// UDPNetwork returns the UDP network to use for reaching public hosts,
+// preferring IPv4.
+func (f AddressFamilies) UDPNetwork() string {
+	if !f.RoutableIPv4 && f.RoutableIPv6 {
+		return "udp6"
+	}
+	return "udp4"
+}
+
+// routableFamilies reports whether the given interface addresses contain an
+// IPv4 or IPv6 address that is neither loopback nor link-local.
+func routableFamilies(addrs []net.Addr) (v4, v6 bool) {
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil {
+			continue
+		}
+		ip := prefix.Addr().Unmap()
+		if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+			continue
+		}
+		if ip.Is4() {
+			v4 = true
+		} else {
+			v6 = true
+		}
+	}
+	return
+}
+
+func canBind(network, addr string) bool {
+	conn, err := net.ListenPacket(network, addr)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutil
+
+import (
+	"net"
+	"testing"
+)
+
+func TestRoutableFamilies(t *testing.T) {
+	t.Parallel()
+	cidr := func(s string) net.Addr {
+		ip, ipnet, err := net.ParseCIDR(s)
+		if err != nil {
+			t.Fatalf("parse %s: %v", s, err)
+		}
+		ipnet.IP = ip
+		return ipnet
+	}
+	tc := []struct {
+		name   string
+		addrs  []net.Addr
+		wantV4 bool
+		wantV6 bool
+	}{
+		{
+			name: "no addresses",
+		},
+		{
+			name:   "dual stack",
+			addrs:  []net.Addr{cidr("192.168.1.10/24"), cidr("2001:db8::10/64")},
+			wantV4: true,
+			wantV6: true,
+		},
+		{
+			name:   "IPv6 only",
+			addrs:  []net.Addr{cidr("fe80::1/64"), cidr("2001:db8::10/64")},
+			wantV6: true,
+		},
+		{
+			name:   "IPv4 only",
+			addrs:  []net.Addr{cidr("10.0.0.10/8"), cidr("fe80::1/64")},
+			wantV4: true,
+		},
+		{
+			name:  "loopback and link-local",
+			addrs: []net.Addr{cidr("127.0.0.1/8"), cidr("::1/128"), cidr("169.254.1.1/16"), cidr("fe80::1/64")},
+		},
+	}
+	for _, tt := range tc {
+		v4, v6 := routableFamilies(tt.addrs)
+		if v4 != tt.wantV4 || v6 != tt.wantV6 {
+			t.Errorf("%s: expected v4=%v v6=%v, got v4=%v v6=%v", tt.name, tt.wantV4, tt.wantV6, v4, v6)
+		}
+	}
+}
+
+func TestAddressFamilyDefaults(t *testing.T) {
+	t.Parallel()
+	v6only := AddressFamilies{IPv6: true, RoutableIPv6: true}
+	if got := v6only.Loopback().String(); got != "::1" {
+		t.Errorf("expected IPv6 loopback, got %s", got)
+	}
+	if got := v6only.Unspecified().String(); got != "::" {
+		t.Errorf("expected IPv6 unspecified address, got %s", got)
+	}
+	if got := v6only.UDPNetwork(); got != "udp6" {
+		t.Errorf("expected udp6, got %s", got)
+	}
+	dual := AddressFamilies{IPv4: true, IPv6: true, RoutableIPv4: true, RoutableIPv6: true}
+	if got := dual.Loopback().String(); got != "127.0.0.1" {
+		t.Errorf("expected IPv4 loopback, got %s", got)
+	}
+	if got := dual.Unspecified().String(); got != "0.0.0.0" {
+		t.Errorf("expected IPv4 unspecified address, got %s", got)
+	}
+	if got := dual.UDPNetwork(); got != "udp4" {
+		t.Errorf("expected udp4, got %s", got)
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutil
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// ListenerMiddleware wraps a listener, for example to filter or limit the
+// connections it accepts.
+type ListenerMiddleware func(net.Listener) net.Listener
+
+// WrapListener applies the given middlewares to the listener. Accepted
+// connections pass through the middlewares in the order they are given, so
+// ProxyProtocol should come first for the others to see client addresses.
+func WrapListener(ln net.Listener, middlewares ...ListenerMiddleware) net.Listener {
+	for _, mw := range middlewares {
+		ln = mw(ln)
+	}
+	return ln
+}
+
+// PacketConnMiddleware wraps a packet connection, for example to filter the
+// packets it reads.
+type PacketConnMiddleware func(net.PacketConn) net.PacketConn
+
+// WrapPacketConn applies the given middlewares to the packet connection.
+func WrapPacketConn(conn net.PacketConn, middlewares ...PacketConnMiddleware) net.PacketConn {
+	for _, mw := range middlewares {
+		conn = mw(conn)
+	}
+	return conn
+}
+
+// IPFilter returns a listener middleware that filters connections by source
+// address. Connections from denied networks are always refused, and when
+// allowed is not empty, so are connections from anywhere outside of it.
+// Refused connections are closed at accept time, or on their first use when
+// their address is only known after a PROXY protocol header.
+func IPFilter(allowed, denied []netip.Prefix) ListenerMiddleware {
+	return func(ln net.Listener) net.Listener {
+		return &filterListener{Listener: ln, ipFilter: ipFilter{allowed: allowed, denied: denied}}
+	}
+}
+
+// PacketIPFilter returns a packet connection middleware that drops packets
+// by source address with the same rules as IPFilter. Dropping the packets of
+// a client keeps it from ever completing a QUIC handshake.
+func PacketIPFilter(allowed, denied []netip.Prefix) PacketConnMiddleware {
+	return func(conn net.PacketConn) net.PacketConn {
+		return &filterPacketConn{PacketConn: conn, ipFilter: ipFilter{allowed: allowed, denied: denied}}
+	}
+}
+
+type ipFilter struct {
+	allowed []netip.Prefix
+	denied  []netip.Prefix
+}
+
+func (f ipFilter) permits(addr net.Addr) bool {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	if containsAddr(f.denied, ap.Addr()) {
+		return false
+	}
+	return len(f.allowed) == 0 || containsAddr(f.allowed, ap.Addr())
+}
+
+type filterListener struct {
+	net.Listener
+	ipFilter
+}
+
+func (l *filterListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := conn.(*proxyConn); ok {
+			return &filterConn{Conn: conn, filter: l}, nil
+		}
+		if l.permits(conn.RemoteAddr()) {
+			return conn, nil
+		}
+		_ = conn.Close()
+	}
+}
+
+// filterConn is a connection whose address is checked on first use.
+type filterConn struct {
+	net.Conn
+	filter *filterListener
+	once   sync.Once
+	err    error
+}
+
+func (c *filterConn) check() {
+	c.once.Do(func() {
+		if !c.filter.permits(c.RemoteAddr()) {
+			c.err = fmt.Errorf("connection from %s is not allowed", c.RemoteAddr())
+			_ = c.Conn.Close()
+		}
+	})
+}
+
+func (c *filterConn) Read(b []byte) (int, error) {
+	c.check()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *filterConn) Write(b []byte) (int, error) {
+	c.check()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.Conn.Write(b)
+}
+
+type filterPacketConn struct {
+	net.PacketConn
+	ipFilter
+}
+
+func (c *filterPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || c.permits(addr) {
+			return n, addr, err
+		}
+	}
+}
+
+// ConnectionLimit returns a listener middleware that serves at most max
+// connections at a time. Connections over the limit are closed as soon as
+// they are accepted, so that clients fail fast instead of queueing.
+func ConnectionLimit(max int) ListenerMiddleware {
+	return func(ln net.Listener) net.Listener {
+		return &limitListener{Listener: ln, max: int64(max)}
+	}
+}
+
+type limitListener struct {
+	net.Listener
+	max    int64
+	active atomic.Int64
+}
+
+func (l *limitListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.active.Add(1) > l.max {
+			l.active.Add(-1)
+			_ = conn.Close()
+			continue
+		}
+		return &limitConn{Conn: conn, release: func() { l.active.Add(-1) }}, nil
+	}
+}
+
+type limitConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *limitConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,329 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	t.Parallel()
+	v2 := func(cmd, family byte, addrs []byte) []byte {
+		out := append([]byte{}, proxyV2Sig...)
+		out = append(out, 0x20|cmd, family)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(addrs)))
+		return append(out, addrs...)
+	}
+	tcp4 := []byte{192, 168, 1, 10, 10, 0, 0, 1, 0x30, 0x39, 0x01, 0xBB}
+	tcp6 := make([]byte, 36)
+	copy(tcp6, netip.MustParseAddr("2001:db8::1").AsSlice())
+	binary.BigEndian.PutUint16(tcp6[32:], 8443)
+	tc := []struct {
+		name    string
+		header  []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "v1 tcp4", header: []byte("PROXY TCP4 192.168.1.10 10.0.0.1 12345 443\r\n"), want: "192.168.1.10:12345"},
+		{name: "v1 tcp6", header: []byte("PROXY TCP6 2001:db8::1 2001:db8::2 8443 443\r\n"), want: "[2001:db8::1]:8443"},
+		{name: "v1 unknown", header: []byte("PROXY UNKNOWN\r\n")},
+		{name: "v1 malformed", header: []byte("PROXY TCP4 192.168.1.10\r\n"), wantErr: true},
+		{name: "v1 too long", header: append([]byte("PROXY "), bytes.Repeat([]byte("a"), 200)...), wantErr: true},
+		{name: "v2 tcp4", header: v2(0x1, 0x11, tcp4), want: "192.168.1.10:12345"},
+		{name: "v2 tcp6", header: v2(0x1, 0x21, tcp6), want: "[2001:db8::1]:8443"},
+		{name: "v2 tcp4 with tlvs", header: v2(0x1, 0x11, append(tcp4, 0x04, 0x00, 0x01, 0xFF)), want: "192.168.1.10:12345"},
+		{name: "v2 local", header: v2(0x0, 0x00, nil)},
+		{name: "v2 truncated", header: v2(0x1, 0x11, tcp4[:4]), wantErr: true},
+		{name: "no header", header: []byte("GET / HTTP/1.1\r\n"), wantErr: true},
+	}
+	for _, tt := range tc {
+		r := bufio.NewReader(io.MultiReader(bytes.NewReader(tt.header), bytes.NewReader([]byte("payload"))))
+		addr, err := ReadProxyHeader(r)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error, got address %v", tt.name, addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		var got string
+		if addr != nil {
+			got = addr.String()
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected address %q, got %q", tt.name, tt.want, got)
+		}
+		rest, _ := io.ReadAll(r)
+		if string(rest) != "payload" {
+			t.Errorf("%s: expected the header to be consumed, got %q", tt.name, rest)
+		}
+	}
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	t.Parallel()
+	t.Run("TrustedProxy", func(t *testing.T) {
+		t.Parallel()
+		ln := newTestListener(t, ProxyProtocol(ProxyProtocolOptions{
+			TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		}))
+		conn := acceptWith(t, ln, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 40000 443\r\nhello"))
+		defer conn.Close()
+		if got := conn.RemoteAddr().String(); got != "203.0.113.7:40000" {
+			t.Fatalf("expected the proxied client address, got %s", got)
+		}
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+			t.Fatalf("expected payload after the header, got %q: %v", buf, err)
+		}
+	})
+	t.Run("MissingHeader", func(t *testing.T) {
+		t.Parallel()
+		ln := newTestListener(t, ProxyProtocol(ProxyProtocolOptions{
+			TrustedProxies: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")},
+		}))
+		conn := acceptWith(t, ln, []byte("hello"))
+		defer conn.Close()
+		if _, err := conn.Read(make([]byte, 5)); err == nil {
+			t.Fatal("expected connections from trusted proxies without a header to fail")
+		}
+	})
+	t.Run("UntrustedSource", func(t *testing.T) {
+		t.Parallel()
+		ln := newTestListener(t, ProxyProtocol(ProxyProtocolOptions{
+			TrustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+		}))
+		conn := acceptWith(t, ln, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 40000 443\r\n"))
+		defer conn.Close()
+		if got := conn.RemoteAddr().(*net.TCPAddr).IP.String(); got != "127.0.0.1" {
+			t.Fatalf("expected headers from untrusted sources to be ignored, got %s", got)
+		}
+	})
+	t.Run("SpoofedHeader", func(t *testing.T) {
+		t.Parallel()
+		// Without trusted proxies a header can not forge an allowed address.
+		ln := newTestListener(t,
+			ProxyProtocol(ProxyProtocolOptions{}),
+			IPFilter([]netip.Prefix{netip.MustParsePrefix("203.0.113.0/24")}, nil),
+		)
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err == nil {
+				accepted <- conn
+			}
+		}()
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		defer client.Close()
+		if _, err := client.Write([]byte("PROXY TCP4 203.0.113.7 10.0.0.1 40000 443\r\n")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+			t.Fatalf("expected the spoofing client to be refused, got %v", err)
+		}
+		select {
+		case conn := <-accepted:
+			conn.Close()
+			t.Fatal("expected the spoofing client not to be accepted")
+		default:
+		}
+	})
+}
+
+func TestIPFilter(t *testing.T) {
+	t.Parallel()
+	prefixes := func(cidrs ...string) []netip.Prefix {
+		var out []netip.Prefix
+		for _, cidr := range cidrs {
+			out = append(out, netip.MustParsePrefix(cidr))
+		}
+		return out
+	}
+	tc := []struct {
+		name    string
+		allowed []netip.Prefix
+		denied  []netip.Prefix
+		refused bool
+	}{
+		{name: "no lists"},
+		{name: "allowed", allowed: prefixes("127.0.0.0/8")},
+		{name: "not allowed", allowed: prefixes("10.0.0.0/8"), refused: true},
+		{name: "denied", denied: prefixes("127.0.0.1/32"), refused: true},
+		{name: "denied overrides allowed", allowed: prefixes("127.0.0.0/8"), denied: prefixes("127.0.0.1/32"), refused: true},
+		{name: "not denied", denied: prefixes("10.0.0.0/8")},
+	}
+	for _, tt := range tc {
+		ln := newTestListener(t, IPFilter(tt.allowed, tt.denied))
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err == nil {
+				accepted <- conn
+			}
+		}()
+		client, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Fatalf("%s: dial: %v", tt.name, err)
+		}
+		if tt.refused {
+			// Refused connections are closed at accept time.
+			_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			if _, err := client.Read(make([]byte, 1)); err != io.EOF {
+				t.Errorf("%s: expected connection to be closed, got %v", tt.name, err)
+			}
+		} else {
+			select {
+			case conn := <-accepted:
+				conn.Close()
+			case <-time.After(5 * time.Second):
+				t.Errorf("%s: expected connection to be accepted", tt.name)
+			}
+		}
+		client.Close()
+		ln.Close()
+	}
+
+	t.Run("ProxiedClient", func(t *testing.T) {
+		t.Parallel()
+		ln := newTestListener(t,
+			ProxyProtocol(ProxyProtocolOptions{TrustedProxies: prefixes("127.0.0.0/8")}),
+			IPFilter(nil, prefixes("203.0.113.0/24")),
+		)
+		conn := acceptWith(t, ln, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 40000 443\r\nhello"))
+		defer conn.Close()
+		if _, err := conn.Read(make([]byte, 5)); err == nil {
+			t.Fatal("expected the denied proxied client to be refused")
+		}
+	})
+}
+
+func TestPacketIPFilter(t *testing.T) {
+	t.Parallel()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	filtered := WrapPacketConn(conn, PacketIPFilter(nil, []netip.Prefix{netip.MustParsePrefix("127.0.0.2/32")}))
+	defer filtered.Close()
+	send := func(from, payload string) {
+		t.Helper()
+		laddr := &net.UDPAddr{IP: net.ParseIP(from)}
+		client, err := net.DialUDP("udp", laddr, filtered.LocalAddr().(*net.UDPAddr))
+		if err != nil {
+			t.Skipf("dial from %s: %v", from, err)
+		}
+		defer client.Close()
+		if _, err := client.Write([]byte(payload)); err != nil {
+			t.Fatalf("write from %s: %v", from, err)
+		}
+	}
+	// Packets from the denied address are dropped before the allowed one.
+	send("127.0.0.2", "denied")
+	send("127.0.0.1", "allowed")
+	_ = filtered.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 16)
+	n, addr, err := filtered.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if got := string(buf[:n]); got != "allowed" {
+		t.Fatalf("expected the allowed packet from 127.0.0.1, got %q from %s", got, addr)
+	}
+}
+
+func TestConnectionLimit(t *testing.T) {
+	t.Parallel()
+	ln := newTestListener(t, ConnectionLimit(1))
+	first := acceptWith(t, ln, nil)
+
+	// Connections over the limit are closed while the slot is taken.
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+	rejected, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer rejected.Close()
+	_ = rejected.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := rejected.Read(make([]byte, 1)); err != io.EOF {
+		t.Fatalf("expected the connection over the limit to be closed, got %v", err)
+	}
+
+	// Closing the first connection releases its slot.
+	first.Close()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a connection to be accepted after the slot was released")
+	}
+}
+
+func newTestListener(t *testing.T, mws ...ListenerMiddleware) net.Listener {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return WrapListener(ln, mws...)
+}
+
+// acceptWith dials the listener, writes data, and returns the accepted
+// connection.
+func acceptWith(t *testing.T, ln net.Listener, data []byte) net.Conn {
+	t.Helper()
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	if len(data) > 0 {
+		if _, err := client.Write(data); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatalf("accept: %v", err)
+	}
+	return conn
+}
@@ -0,0 +1,240 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package netutil
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout is the default time to wait for the PROXY protocol
+// header of a connection.
+const DefaultProxyHeaderTimeout = 10 * time.Second
+
+// ErrNoProxyHeader is returned when a connection from a trusted proxy does not
+// start with a PROXY protocol header.
+var ErrNoProxyHeader = errors.New("connection did not send a PROXY protocol header")
+
+var (
+	proxyV1Prefix  = []byte("PROXY ")
+	proxyV2Sig     = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+	proxyV1MaxSize = 107
+)
+
+// ProxyProtocolOptions are options for accepting the HAProxy PROXY protocol.
+type ProxyProtocolOptions struct {
+	// TrustedProxies are the networks of the load balancers allowed to send
+	// a PROXY protocol header. Connections from trusted proxies must send one,
+	// while connections from anywhere else are used as they are. No source is
+	// trusted if empty, so that clients can never forge their address.
+	TrustedProxies []netip.Prefix
+	// HeaderTimeout is how long to wait for the header. Defaults to
+	// DefaultProxyHeaderTimeout.
+	HeaderTimeout time.Duration
+}
+
+// ProxyProtocol returns a listener middleware that accepts version 1 and 2 of
+// the HAProxy PROXY protocol, so that the remote address of connections made
+// through an L4 load balancer is that of the original client. The header is
+// read on the first use of the connection, so a slow client does not block
+// the accept loop.
+func ProxyProtocol(opts ProxyProtocolOptions) ListenerMiddleware {
+	if opts.HeaderTimeout <= 0 {
+		opts.HeaderTimeout = DefaultProxyHeaderTimeout
+	}
+	return func(ln net.Listener) net.Listener {
+		return &proxyListener{Listener: ln, opts: opts}
+	}
+}
+
+type proxyListener struct {
+	net.Listener
+	opts ProxyProtocolOptions
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(conn.RemoteAddr()) {
+		return conn, nil
+	}
+	return &proxyConn{Conn: conn, r: bufio.NewReader(conn), timeout: l.opts.HeaderTimeout}, nil
+}
+
+func (l *proxyListener) trusted(addr net.Addr) bool {
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	return containsAddr(l.opts.TrustedProxies, ap.Addr())
+}
+
+// proxyConn is a connection from a trusted proxy. Its remote address is the
+// one announced in the PROXY protocol header.
+type proxyConn struct {
+	net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	remote  net.Addr
+	err     error
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) readHeader() {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+	defer func() { _ = c.Conn.SetReadDeadline(time.Time{}) }()
+	c.remote, c.err = ReadProxyHeader(c.r)
+	if c.err != nil {
+		c.err = fmt.Errorf("read PROXY protocol header from %s: %w", c.Conn.RemoteAddr(), c.err)
+		_ = c.Conn.Close()
+	}
+}
+
+// ReadProxyHeader reads a version 1 or 2 PROXY protocol header from the given
+// reader and returns the source address it announces. A nil address is
+// returned for headers that do not carry one, such as health checks made by
+// the proxy itself.
+func ReadProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// Look at the first byte before waiting for a full signature, so that
+	// clients not speaking the protocol are refused right away.
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	var sig []byte
+	switch first[0] {
+	case proxyV1Prefix[0]:
+		sig = proxyV1Prefix
+	case proxyV2Sig[0]:
+		sig = proxyV2Sig
+	default:
+		return nil, ErrNoProxyHeader
+	}
+	prefix, err := r.Peek(len(sig))
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(prefix, sig) {
+		return nil, ErrNoProxyHeader
+	}
+	if first[0] == proxyV2Sig[0] {
+		return readProxyV2(r)
+	}
+	return readProxyV1(r)
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < proxyV1MaxSize {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if bytes.HasSuffix(line, []byte("\r\n")) {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("PROXY v1 header exceeds %d bytes", proxyV1MaxSize)
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("malformed PROXY v1 header %q", strings.TrimSpace(string(line)))
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed PROXY v1 source address: %w", err)
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("malformed PROXY v1 source port: %w", err)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, len(proxyV2Sig)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	verCmd, family := header[12], header[13]
+	length := binary.BigEndian.Uint16(header[14:])
+	if verCmd>>4 != 2 {
+		return nil, fmt.Errorf("unsupported PROXY protocol version %d", verCmd>>4)
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	switch verCmd & 0x0F {
+	case 0x0:
+		// LOCAL, the connection was made by the proxy itself.
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("unsupported PROXY v2 command %d", verCmd&0x0F)
+	}
+	switch family >> 4 {
+	case 0x1:
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("truncated PROXY v2 IPv4 addresses")
+		}
+		addr := netip.AddrFrom4([4]byte(payload[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[8:10]))), nil
+	case 0x2:
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("truncated PROXY v2 IPv6 addresses")
+		}
+		addr := netip.AddrFrom16([16]byte(payload[0:16]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[32:34]))), nil
+	default:
+		// Unspecified or unix sockets, keep the address of the proxy.
+		return nil, nil
+	}
+}
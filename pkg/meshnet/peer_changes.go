@@ -0,0 +1,175 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"sort"
+	"sync"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// PeerChangeType is the type of a change to the desired WireGuard peers.
+type PeerChangeType int
+
+const (
+	// PeerAdded means the peer was added to the desired peers.
+	PeerAdded PeerChangeType = iota
+	// PeerUpdated means the configuration of an existing peer changed.
+	PeerUpdated
+	// PeerRemoved means the peer was removed from the desired peers.
+	PeerRemoved
+)
+
+// String returns the string representation of the change type.
+func (t PeerChangeType) String() string {
+	switch t {
+	case PeerAdded:
+		return "added"
+	case PeerUpdated:
+		return "updated"
+	case PeerRemoved:
+		return "removed"
+	default:
+		return "unknown"
+	}
+}
+
+// PeerChange is a single change to the desired WireGuard peers.
+type PeerChange struct {
+	// Type is the type of change.
+	Type PeerChangeType
+	// ID is the ID of the peer.
+	ID string
+	// Peer is the desired configuration of the peer. It is nil for removals.
+	Peer *v1.WireGuardPeer
+}
+
+// PeerChangeSet is a set of changes to the desired WireGuard peers.
+type PeerChangeSet struct {
+	// Revision increases by one with every change set computed by the node.
+	// The first set a subscriber receives is a snapshot of the current peers
+	// at the current revision.
+	Revision uint64
+	// Changes are the changes ordered by peer ID.
+	Changes []PeerChange
+}
+
+// DiffWireGuardPeers returns the changes required to go from the old to the
+// new set of peers, ordered by peer ID.
+func DiffWireGuardPeers(old, new []*v1.WireGuardPeer) []PeerChange {
+	oldPeers := make(map[string]*v1.WireGuardPeer, len(old))
+	for _, peer := range old {
+		oldPeers[peer.GetNode().GetId()] = peer
+	}
+	var changes []PeerChange
+	seen := make(map[string]struct{}, len(new))
+	for _, peer := range new {
+		id := peer.GetNode().GetId()
+		seen[id] = struct{}{}
+		current, ok := oldPeers[id]
+		switch {
+		case !ok:
+			changes = append(changes, PeerChange{Type: PeerAdded, ID: id, Peer: peer})
+		case !proto.Equal(current, peer):
+			changes = append(changes, PeerChange{Type: PeerUpdated, ID: id, Peer: peer})
+		}
+	}
+	for id := range oldPeers {
+		if _, ok := seen[id]; !ok {
+			changes = append(changes, PeerChange{Type: PeerRemoved, ID: id})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].ID < changes[j].ID
+	})
+	return changes
+}
+
+// peerWatchers tracks the last computed peers and notifies subscribers
+// of changes to them.
+type peerWatchers struct {
+	peers    []*v1.WireGuardPeer
+	revision uint64
+	subs     map[uint64]func(PeerChangeSet)
+	nextSub  uint64
+	mu       sync.Mutex
+}
+
+func newPeerWatchers() *peerWatchers {
+	return &peerWatchers{
+		subs: make(map[uint64]func(PeerChangeSet)),
+	}
+}
+
+// publish records the given peers as the desired peers and notifies
+// subscribers of any changes.
+func (w *peerWatchers) publish(peers []*v1.WireGuardPeer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.publishLocked(peers)
+}
+
+// put adds or replaces a single peer in the desired peers and notifies
+// subscribers of the change.
+func (w *peerWatchers) put(peer *v1.WireGuardPeer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	peers := make([]*v1.WireGuardPeer, 0, len(w.peers)+1)
+	for _, current := range w.peers {
+		if current.GetNode().GetId() != peer.GetNode().GetId() {
+			peers = append(peers, current)
+		}
+	}
+	w.publishLocked(append(peers, peer))
+}
+
+func (w *peerWatchers) publishLocked(peers []*v1.WireGuardPeer) {
+	changes := DiffWireGuardPeers(w.peers, peers)
+	if len(changes) == 0 {
+		return
+	}
+	w.peers = peers
+	w.revision++
+	set := PeerChangeSet{Revision: w.revision, Changes: changes}
+	for _, fn := range w.subs {
+		fn(set)
+	}
+}
+
+// subscribe calls fn with a snapshot of the current peers and then with
+// every subsequent change set until the returned function is called or
+// the context is canceled. Fn is called with the watchers locked and must not block.
+func (w *peerWatchers) subscribe(ctx context.Context, fn func(PeerChangeSet)) context.CancelFunc {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	fn(PeerChangeSet{Revision: w.revision, Changes: DiffWireGuardPeers(nil, w.peers)})
+	id := w.nextSub
+	w.nextSub++
+	w.subs[id] = fn
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-ctx.Done()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		delete(w.subs, id)
+	}()
+	return cancel
+}
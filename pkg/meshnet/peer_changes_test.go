@@ -0,0 +1,83 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestPeerWatchers(t *testing.T) {
+	t.Parallel()
+	peer := func(id string, allowed ...string) *v1.WireGuardPeer {
+		return &v1.WireGuardPeer{Node: &v1.MeshNode{Id: id}, AllowedIPs: allowed}
+	}
+	w := newPeerWatchers()
+	w.publish([]*v1.WireGuardPeer{peer("a", "172.16.0.1/32"), peer("b", "172.16.0.2/32")})
+
+	var sets []PeerChangeSet
+	cancel := w.subscribe(context.Background(), func(set PeerChangeSet) {
+		sets = append(sets, set)
+	})
+	defer cancel()
+	if len(sets) != 1 || sets[0].Revision != 1 || len(sets[0].Changes) != 2 {
+		t.Fatalf("expected snapshot of 2 peers at revision 1, got %+v", sets)
+	}
+	for _, change := range sets[0].Changes {
+		if change.Type != PeerAdded {
+			t.Errorf("expected snapshot change to be an addition, got %s", change.Type)
+		}
+	}
+
+	// Publishing the same peers is not a change.
+	w.publish([]*v1.WireGuardPeer{peer("b", "172.16.0.2/32"), peer("a", "172.16.0.1/32")})
+	if len(sets) != 1 {
+		t.Fatalf("expected no change set for identical peers, got %+v", sets[1:])
+	}
+
+	w.publish([]*v1.WireGuardPeer{peer("a", "172.16.0.1/32", "10.0.0.0/8"), peer("c", "172.16.0.3/32")})
+	if len(sets) != 2 || sets[1].Revision != 2 {
+		t.Fatalf("expected change set at revision 2, got %+v", sets)
+	}
+	want := []struct {
+		typ PeerChangeType
+		id  string
+	}{
+		{PeerUpdated, "a"},
+		{PeerRemoved, "b"},
+		{PeerAdded, "c"},
+	}
+	if len(sets[1].Changes) != len(want) {
+		t.Fatalf("expected %d changes, got %+v", len(want), sets[1].Changes)
+	}
+	for i, change := range sets[1].Changes {
+		if change.Type != want[i].typ || change.ID != want[i].id {
+			t.Errorf("expected %s %s, got %s %s", want[i].typ, want[i].id, change.Type, change.ID)
+		}
+		if change.Type == PeerRemoved && change.Peer != nil {
+			t.Errorf("expected removal of %s to have no peer", change.ID)
+		}
+	}
+
+	w.put(peer("d", "172.16.0.4/32"))
+	if len(sets) != 3 || len(sets[2].Changes) != 1 || sets[2].Changes[0].ID != "d" {
+		t.Fatalf("expected addition of d, got %+v", sets[2:])
+	}
+}
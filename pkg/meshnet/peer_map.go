@@ -42,8 +42,18 @@ type GraphWalk struct {
 	AllowedIPs   []string
 	LocalRoutes  []netip.Prefix
 	Routes       []Route
+	RouteMetrics types.RouteMetrics
 	Visited      map[types.NodeID]struct{}
 	Depth        int
+	// Health, when set, is used to avoid walking through intermediate
+	// nodes that are not alive.
+	Health PeerHealth
+}
+
+// SkipIntermediate reports if the walk should not continue through the given
+// node because it is known to be dead.
+func (g *GraphWalk) SkipIntermediate(node types.MeshNode) bool {
+	return g.Health != nil && g.Health.Status(node) == HealthDead
 }
 
 // SkipNode reports if the given node ID should be skipped.
@@ -72,16 +82,85 @@ type WalkedPeer struct {
 	Routes []Route
 }
 
-// Route tracks a route and the depth into the graph of the route.
-// Smallest depth wins in the end.
+// Route tracks a route, its metric, and the depth into the graph of the route.
+// Lowest metric wins in the end, then smallest depth.
 type Route struct {
-	CIDR  netip.Prefix
-	Depth int
+	CIDR   netip.Prefix
+	Metric uint32
+	Depth  int
+}
+
+// PreferredOver returns true if the route is preferred over the given one.
+func (r Route) PreferredOver(other Route) bool {
+	if r.Metric != other.Metric {
+		return r.Metric < other.Metric
+	}
+	return r.Depth < other.Depth
 }
 
 // WireGuardPeersFor returns the WireGuard peers for the given peer ID.
 // Peers are filtered by network ACLs.
 func WireGuardPeersFor(ctx context.Context, st storage.MeshDB, peerID types.NodeID) ([]*v1.WireGuardPeer, error) {
+	return walkWireGuardPeers(ctx, st, peerID, nil)
+}
+
+// WireGuardPeersWithHealth is like WireGuardPeersFor, but does not route the
+// addresses of other nodes through intermediate peers the given health reports
+// as dead. Those addresses are instead left to alternate paths. Addresses only
+// reachable through dead intermediates are still routed through them, so that
+// they are picked up again as soon as the intermediate recovers.
+func WireGuardPeersWithHealth(ctx context.Context, st storage.MeshDB, peerID types.NodeID, health PeerHealth) ([]*v1.WireGuardPeer, error) {
+	if health == nil {
+		return WireGuardPeersFor(ctx, st, peerID)
+	}
+	healthy, err := walkWireGuardPeers(ctx, st, peerID, health)
+	if err != nil {
+		return nil, err
+	}
+	all, err := walkWireGuardPeers(ctx, st, peerID, nil)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]struct{})
+	byID := make(map[string]*v1.WireGuardPeer, len(healthy))
+	var healthyRoutes []netip.Prefix
+	for _, peer := range healthy {
+		byID[peer.GetNode().GetId()] = peer
+		for _, ip := range peer.GetAllowedIPs() {
+			seen[ip] = struct{}{}
+		}
+		for _, route := range peer.GetAllowedRoutes() {
+			if prefix, err := netip.ParsePrefix(route); err == nil && prefix.Bits() > 0 {
+				healthyRoutes = append(healthyRoutes, prefix)
+			}
+		}
+	}
+	for _, peer := range all {
+		out, ok := byID[peer.GetNode().GetId()]
+		if !ok {
+			continue
+		}
+		for _, ip := range peer.GetAllowedIPs() {
+			if _, ok := seen[ip]; ok {
+				continue
+			}
+			isRoute := slices.Contains(peer.GetAllowedRoutes(), ip)
+			if isRoute && coveredByRoute(healthyRoutes, ip) {
+				// Shares of prefixes split between equal-cost paths stay
+				// on the healthy paths.
+				continue
+			}
+			seen[ip] = struct{}{}
+			out.AllowedIPs = append(out.AllowedIPs, ip)
+			if isRoute {
+				out.AllowedRoutes = append(out.AllowedRoutes, ip)
+			}
+		}
+	}
+	return healthy, nil
+}
+
+func walkWireGuardPeers(ctx context.Context, st storage.MeshDB, peerID types.NodeID, health PeerHealth) ([]*v1.WireGuardPeer, error) {
 	log := context.LoggerFrom(ctx).With("source-peer", peerID)
 	graph := st.Peers().Graph()
 	nw := st.Networking()
@@ -97,12 +176,17 @@ func WireGuardPeersFor(ctx context.Context, st storage.MeshDB, peerID types.Node
 	if err != nil {
 		return nil, fmt.Errorf("get routes by node: %w", err)
 	}
+	routeMetrics, err := storage.RouteMetricsFor(ctx, st)
+	if err != nil {
+		return nil, fmt.Errorf("get route metrics: %w", err)
+	}
 	ourRoutes := make([]netip.Prefix, 0)
 	for _, route := range routes {
 		ourRoutes = append(ourRoutes, route.DestinationPrefixes()...)
 	}
 	directAdjacents := adjacencyMap[peerID]
 	peers := make([]WalkedPeer, 0, len(directAdjacents))
+	nats := newNATTypes(st)
 	for adjacent, edge := range directAdjacents {
 		directPeer, err := graph.Vertex(adjacent)
 		if err != nil {
@@ -135,7 +219,7 @@ func WireGuardPeersFor(ctx context.Context, st storage.MeshDB, peerID types.Node
 		peer := WalkedPeer{
 			WireGuardPeer: &v1.WireGuardPeer{
 				Node:          directPeer.MeshNode,
-				Proto:         types.ConnectProtoFromEdgeAttrs(edge.Properties.Attributes),
+				Proto:         nats.ConnectProto(ctx, types.ConnectProtoFromEdgeAttrs(edge.Properties.Attributes), peerID, adjacent),
 				AllowedIPs:    []string{},
 				AllowedRoutes: []string{},
 			},
@@ -151,8 +235,10 @@ func WireGuardPeersFor(ctx context.Context, st storage.MeshDB, peerID types.Node
 			LocalRoutes:  ourRoutes,
 			AllowedIPs:   []string{},
 			Routes:       []Route{},
+			RouteMetrics: routeMetrics,
 			Visited:      map[types.NodeID]struct{}{},
 			Depth:        0,
+			Health:       health,
 		}
 		err = recursePeers(ctx, &walk)
 		if err != nil {
@@ -163,16 +249,14 @@ func WireGuardPeersFor(ctx context.Context, st storage.MeshDB, peerID types.Node
 		peer.AllowedIPs = append(peer.AllowedIPs, walk.AllowedIPs...)
 		peers = append(peers, peer)
 	}
-	// Walk our results and assign routes based on shortest path.
+	// Walk our results and assign routes based on metric and shortest path,
+	// splitting prefixes with equal-cost paths between them.
+	assigned := assignRoutes(peers)
 	out := make([]*v1.WireGuardPeer, 0, len(peers))
-	for _, peer := range peers {
-		// For each route, check if its the shortest depth for that prefix.
-		for _, route := range peer.Routes {
-			if isSmallestDepth(peers, route) {
-				// This is the shortest depth for this route.
-				peer.AllowedRoutes = append(peer.AllowedRoutes, route.CIDR.String())
-				peer.AllowedIPs = append(peer.AllowedIPs, route.CIDR.String())
-			}
+	for i, peer := range peers {
+		for _, prefix := range assigned[i] {
+			peer.AllowedRoutes = append(peer.AllowedRoutes, prefix.String())
+			peer.AllowedIPs = append(peer.AllowedIPs, prefix.String())
 		}
 		out = append(out, peer.WireGuardPeer)
 	}
@@ -212,15 +296,17 @@ func recursePeers(ctx context.Context, walk *GraphWalk) error {
 	for _, route := range routes {
 		for _, cidr := range route.DestinationPrefixes() {
 			if !slices.Contains(walk.AllowedIPs, cidr.String()) && !slices.Contains(walk.LocalRoutes, cidr) {
-				if !routeExists(walk.Routes, cidr) {
-					walk.Routes = append(walk.Routes, Route{
-						CIDR:  cidr,
-						Depth: walk.Depth,
-					})
-				}
+				walk.addRoute(Route{
+					CIDR:   cidr,
+					Metric: walk.RouteMetrics.MetricOf(route),
+					Depth:  walk.Depth,
+				})
 			}
 		}
 	}
+	if walk.SkipIntermediate(*walk.TargetNode) {
+		return nil
+	}
 	walk.Depth++
 	err = recursePeerEdges(ctx, walk)
 	if err != nil {
@@ -260,15 +346,17 @@ func recursePeerEdges(ctx context.Context, walk *GraphWalk) error {
 		for _, route := range routes {
 			for _, cidr := range route.DestinationPrefixes() {
 				if !slices.Contains(walk.AllowedIPs, cidr.String()) && !slices.Contains(walk.LocalRoutes, cidr) {
-					if !routeExists(walk.Routes, cidr) {
-						walk.Routes = append(walk.Routes, Route{
-							CIDR:  cidr,
-							Depth: walk.Depth,
-						})
-					}
+					walk.addRoute(Route{
+						CIDR:   cidr,
+						Metric: walk.RouteMetrics.MetricOf(route),
+						Depth:  walk.Depth,
+					})
 				}
 			}
 		}
+		if walk.SkipIntermediate(targetNode) {
+			continue
+		}
 		walk.Depth++
 		walk.TargetNode = &targetNode
 		err = recursePeerEdges(ctx, walk)
@@ -279,11 +367,10 @@ func recursePeerEdges(ctx context.Context, walk *GraphWalk) error {
 	return nil
 }
 
-func isSmallestDepth(peers []WalkedPeer, rt Route) bool {
-	depth := rt.Depth
+func isPreferredRoute(peers []WalkedPeer, rt Route) bool {
 	for _, peer := range peers {
 		for _, route := range peer.Routes {
-			if route.CIDR == rt.CIDR && route.Depth < depth {
+			if route.CIDR == rt.CIDR && route.PreferredOver(rt) {
 				return false
 			}
 		}
@@ -291,11 +378,16 @@ func isSmallestDepth(peers []WalkedPeer, rt Route) bool {
 	return true
 }
 
-func routeExists(routes []Route, rt netip.Prefix) bool {
-	for _, route := range routes {
-		if route.CIDR == rt {
-			return true
+// addRoute adds the route to the walk. If the prefix is already routed, the
+// preferred of the two routes is kept.
+func (g *GraphWalk) addRoute(rt Route) {
+	for i, route := range g.Routes {
+		if route.CIDR == rt.CIDR {
+			if rt.PreferredOver(route) {
+				g.Routes[i] = rt
+			}
+			return
 		}
 	}
-	return false
+	g.Routes = append(g.Routes, rt)
 }
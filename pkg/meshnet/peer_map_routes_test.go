@@ -24,6 +24,7 @@ import (
 	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
@@ -35,6 +36,7 @@ func TestWireGuardPeersWithRoutes(t *testing.T) {
 		name       string
 		peers      []types.MeshNode
 		routes     []types.Route
+		metrics    map[string]uint32              // route name -> metric
 		edges      map[string][]string            // peerID -> []peerID
 		wantRoutes map[string]map[string][]string // peerID -> peerID -> []routes
 	}{
@@ -155,6 +157,97 @@ func TestWireGuardPeersWithRoutes(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "OverlappingRoutesPreferLowerMetric",
+			peers: []types.MeshNode{
+				{MeshNode: &v1.MeshNode{
+					Id:          "gateway-a",
+					PrivateIPv4: "172.16.0.1/32",
+					PrivateIPv6: "2001:db8::1/128",
+				}},
+				{MeshNode: &v1.MeshNode{
+					Id:          "gateway-b",
+					PrivateIPv4: "172.16.0.2/32",
+					PrivateIPv6: "2001:db8::2/128",
+				}},
+				{MeshNode: &v1.MeshNode{
+					Id:          "client",
+					PrivateIPv4: "172.16.0.3/32",
+					PrivateIPv6: "2001:db8::3/128",
+				}},
+			},
+			routes: []types.Route{
+				{Route: &v1.Route{
+					Name:             "gateway-a-lan",
+					Node:             "gateway-a",
+					DestinationCIDRs: []string{"10.0.0.0/8"},
+				}},
+				{Route: &v1.Route{
+					Name:             "gateway-b-lan",
+					Node:             "gateway-b",
+					DestinationCIDRs: []string{"10.0.0.0/8", "192.168.0.0/16"},
+				}},
+			},
+			metrics: map[string]uint32{
+				"gateway-a-lan": 100,
+				"gateway-b-lan": 10,
+			},
+			edges: map[string][]string{
+				"client":    {"gateway-a", "gateway-b"},
+				"gateway-a": {"client"},
+				"gateway-b": {"client"},
+			},
+			wantRoutes: map[string]map[string][]string{
+				"client": {
+					"gateway-a": {},
+					"gateway-b": {"10.0.0.0/8", "192.168.0.0/16"},
+				},
+			},
+		},
+		{
+			name: "EqualCostRoutesAreSplit",
+			peers: []types.MeshNode{
+				{MeshNode: &v1.MeshNode{
+					Id:          "gateway-a",
+					PrivateIPv4: "172.16.0.1/32",
+					PrivateIPv6: "2001:db8::1/128",
+				}},
+				{MeshNode: &v1.MeshNode{
+					Id:          "gateway-b",
+					PrivateIPv4: "172.16.0.2/32",
+					PrivateIPv6: "2001:db8::2/128",
+				}},
+				{MeshNode: &v1.MeshNode{
+					Id:          "client",
+					PrivateIPv4: "172.16.0.3/32",
+					PrivateIPv6: "2001:db8::3/128",
+				}},
+			},
+			routes: []types.Route{
+				{Route: &v1.Route{
+					Name:             "gateway-a-lan",
+					Node:             "gateway-a",
+					DestinationCIDRs: []string{"10.0.0.0/8", "0.0.0.0/0"},
+				}},
+				{Route: &v1.Route{
+					Name:             "gateway-b-lan",
+					Node:             "gateway-b",
+					DestinationCIDRs: []string{"10.0.0.0/8", "0.0.0.0/0"},
+				}},
+			},
+			edges: map[string][]string{
+				"client":    {"gateway-a", "gateway-b"},
+				"gateway-a": {"client"},
+				"gateway-b": {"client"},
+			},
+			wantRoutes: map[string]map[string][]string{
+				"client": {
+					// Default routes are never split.
+					"gateway-a": {"10.0.0.0/9", "0.0.0.0/0"},
+					"gateway-b": {"10.128.0.0/9"},
+				},
+			},
+		},
 	}
 
 	for _, testcase := range tt {
@@ -196,6 +289,11 @@ func TestWireGuardPeersWithRoutes(t *testing.T) {
 					t.Fatal(err)
 				}
 			}
+			for name, metric := range tc.metrics {
+				if err := storage.PutRouteMetric(ctx, storage.MeshStorageOf(db.MeshDB), name, metric); err != nil {
+					t.Fatal(err)
+				}
+			}
 			// Create an allow-all ACL
 			err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{
 				NetworkACL: &v1.NetworkACL{
@@ -212,7 +310,7 @@ func TestWireGuardPeersWithRoutes(t *testing.T) {
 				t.Fatal(err)
 			}
 			for peer, want := range tc.wantRoutes {
-				peers, err := WireGuardPeersFor(ctx, db, types.NodeID(peer))
+				peers, err := WireGuardPeersFor(ctx, db.MeshDB, types.NodeID(peer))
 				if err != nil {
 					t.Fatalf("get peers for %q: %v", peer, err)
 				}
@@ -56,6 +56,10 @@ type PeerManager interface {
 	Refresh(ctx context.Context, peers []*v1.WireGuardPeer) error
 	// Sync is like refresh but uses the storage to get the list of peers.
 	Sync(ctx context.Context) error
+	// Subscribe calls fn with a snapshot of the desired peers and then with
+	// every change to them computed by Refresh or Sync, until the returned
+	// function is called or the context is canceled. Fn must not block.
+	Subscribe(ctx context.Context, fn func(PeerChangeSet)) context.CancelFunc
 	// Resolver returns a resolver backed by the storage
 	// of this instance.
 	Resolver() PeerResolver
@@ -76,6 +80,7 @@ type peerManager struct {
 	net      *manager
 	storage  storage.MeshDB
 	p2pConns map[string]clientPeerConn
+	watchers *peerWatchers
 	peermu   sync.Mutex
 	p2pmu    sync.Mutex
 }
@@ -85,6 +90,7 @@ func newPeerManager(m *manager) *peerManager {
 		net:      m,
 		storage:  m.storage,
 		p2pConns: make(map[string]clientPeerConn),
+		watchers: newPeerWatchers(),
 	}
 }
 
@@ -112,6 +118,10 @@ func (m *peerManager) Resolver() PeerResolver {
 func (m *peerManager) Add(ctx context.Context, peer *v1.WireGuardPeer, iceServers []string) error {
 	m.peermu.Lock()
 	defer m.peermu.Unlock()
+	if m.net.opts.ExternalPeers {
+		m.watchers.put(peer)
+		return nil
+	}
 	if m.net.WireGuard() == nil {
 		return errors.New("add peer called before wireguard interface is ready")
 	}
@@ -121,16 +131,26 @@ func (m *peerManager) Add(ctx context.Context, peer *v1.WireGuardPeer, iceServer
 }
 
 func (m *peerManager) Sync(ctx context.Context) error {
-	peers, err := WireGuardPeersFor(ctx, m.net.storage, m.net.nodeID)
+	peers, err := WireGuardPeersWithHealth(ctx, m.net.storage, m.net.nodeID, m.net.health)
 	if err != nil {
 		return fmt.Errorf("get wireguard peers: %w", err)
 	}
-	return m.Refresh(ctx, peers)
+	return errors.Join(m.Refresh(ctx, peers), m.net.syncFirewallACLs(ctx), m.net.syncRateLimits(ctx))
+}
+
+func (m *peerManager) Subscribe(ctx context.Context, fn func(PeerChangeSet)) context.CancelFunc {
+	return m.watchers.subscribe(ctx, fn)
 }
 
 func (m *peerManager) Refresh(ctx context.Context, wgpeers []*v1.WireGuardPeer) error {
 	m.peermu.Lock()
 	defer m.peermu.Unlock()
+	m.watchers.publish(wgpeers)
+	if m.net.opts.ExternalPeers {
+		// Peers are configured by an external controller
+		// subscribed to the changes.
+		return nil
+	}
 	if m.net.WireGuard() == nil {
 		return errors.New("refresh peers called before wireguard interface is ready")
 	}
@@ -396,7 +416,7 @@ func (m *peerManager) negotiateP2PRelay(ctx context.Context, peer *v1.WireGuardP
 		defer func() {
 			// This is a hacky way to attempt to reconnect to the peer if
 			// the ICE connection is closed and they are still in the store.
-			wgpeers, err := WireGuardPeersFor(ctx, m.net.storage, m.net.nodeID)
+			wgpeers, err := WireGuardPeersWithHealth(ctx, m.net.storage, m.net.nodeID, m.net.health)
 			if err != nil {
 				log.Error("Error getting wireguard peers after p2p connection closed", slog.String("error", err.Error()))
 				return
@@ -461,7 +481,7 @@ func (m *peerManager) negotiateICEConn(ctx context.Context, peer *v1.WireGuardPe
 		defer func() {
 			// This is a hacky way to attempt to reconnect to the peer if
 			// the ICE connection is closed and they are still in the store.
-			wgpeers, err := WireGuardPeersFor(ctx, m.net.storage, m.net.nodeID)
+			wgpeers, err := WireGuardPeersWithHealth(ctx, m.net.storage, m.net.nodeID, m.net.health)
 			if err != nil {
 				log.Error("Error getting wireguard peers after ICE connection closed", slog.String("error", err.Error()))
 				return
@@ -517,5 +537,6 @@ func (m *peerManager) getSignalingTransport(ctx context.Context, peer *v1.WireGu
 		NodeID:      peer.GetNode().GetId(),
 		TargetProto: "udp",
 		TargetAddr:  netip.AddrPortFrom(netip.IPv4Unspecified(), 0),
+		TURNAuth:    m.net.opts.Relays.TURNAuth,
 	}), nil
 }
@@ -30,6 +30,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
 )
 
 // Relay is a generic interface for proxying read-write streams between each other.
@@ -66,12 +67,11 @@ type LocalUDP struct {
 
 // NewLocalUDP creates a new UDP relay listening on the given port
 // and proxying traffic to the listener on the given target port.
+// The listener is reached over IPv6 loopback on hosts without IPv4.
 func NewLocalUDP(opts UDPOptions) (Relay, error) {
 	var laddr *net.UDPAddr
-	c, err := net.DialUDP("udp", laddr, &net.UDPAddr{
-		IP:   net.IPv4zero,
-		Port: int(opts.TargetPort),
-	})
+	target := netip.AddrPortFrom(netutil.DetectAddressFamilies().Loopback(), opts.TargetPort)
+	c, err := net.DialUDP("udp", laddr, net.UDPAddrFromAddrPort(target))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,211 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rendezvous
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// JoinInterval is how often joins are sent while waiting for the other peer.
+const JoinInterval = 250 * time.Millisecond
+
+// KeepAliveInterval is how often a connected peer refreshes its location on the server.
+const KeepAliveInterval = 15 * time.Second
+
+// Conn is a connection to a peer relayed through a rendezvous server. Datagram
+// boundaries are preserved, so it can carry any UDP based protocol such as
+// WireGuard.
+type Conn struct {
+	sock     net.PacketConn
+	server   net.Addr
+	loc      Location
+	pending  []byte
+	buf      []byte
+	readMu   sync.Mutex
+	closec   chan struct{}
+	closeErr error
+	once     sync.Once
+}
+
+// Dial meets the peer deriving the same locations and returns a connection relayed
+// through the server of the first location where both peers arrived. Joins are
+// sent to all the given locations until the peer arrives or the context is done.
+// Locations are usually obtained from Nearby.
+func Dial(ctx context.Context, locations ...Location) (*Conn, error) {
+	if len(locations) == 0 {
+		return nil, ErrNoServers
+	}
+	servers := make([]net.Addr, len(locations))
+	for i, loc := range locations {
+		addr, err := net.ResolveUDPAddr("udp", serverAddr(loc.Server))
+		if err != nil {
+			return nil, fmt.Errorf("resolve rendezvous server %q: %w", loc.Server, err)
+		}
+		servers[i] = addr
+	}
+	sock, err := net.ListenPacket("udp", ":0")
+	if err != nil {
+		return nil, fmt.Errorf("listen on UDP: %w", err)
+	}
+	done := make(chan struct{})
+	joined := make(chan struct{})
+	stopJoining := func() {
+		close(done)
+		<-joined
+	}
+	go func() {
+		defer close(joined)
+		t := time.NewTicker(JoinInterval)
+		defer t.Stop()
+		for {
+			for i, loc := range locations {
+				_, _ = sock.WriteTo(appendHeader(nil, frameJoin, loc.ID), servers[i])
+			}
+			select {
+			case <-ctx.Done():
+				// Unblock the read below.
+				_ = sock.SetReadDeadline(time.Now())
+				return
+			case <-done:
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := sock.ReadFrom(buf)
+		if err != nil {
+			stopJoining()
+			sock.Close()
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			return nil, fmt.Errorf("read from UDP: %w", err)
+		}
+		typ, id, ok := parseHeader(buf[:n])
+		if !ok || (typ != frameMatch && typ != frameData) {
+			continue
+		}
+		for i, loc := range locations {
+			if loc.ID != id || servers[i].String() != addr.String() {
+				continue
+			}
+			c := &Conn{
+				sock:   sock,
+				server: servers[i],
+				loc:    loc,
+				buf:    buf,
+				closec: make(chan struct{}),
+			}
+			if typ == frameData {
+				// The other peer saw the match first and already sent data.
+				c.pending = append([]byte(nil), buf[headerSize:n]...)
+			}
+			stopJoining()
+			// Clear a deadline the join loop may have set while we matched.
+			_ = sock.SetReadDeadline(time.Time{})
+			go c.keepAlive()
+			return c, nil
+		}
+	}
+}
+
+// Location returns the location the peers met at.
+func (c *Conn) Location() Location {
+	return c.loc
+}
+
+// Read reads the next datagram sent by the peer.
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+	if c.pending != nil {
+		n := copy(b, c.pending)
+		c.pending = nil
+		return n, nil
+	}
+	for {
+		n, addr, err := c.sock.ReadFrom(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		if addr.String() != c.server.String() {
+			continue
+		}
+		typ, id, ok := parseHeader(c.buf[:n])
+		if !ok || typ != frameData || id != c.loc.ID {
+			continue
+		}
+		return copy(b, c.buf[headerSize:n]), nil
+	}
+}
+
+// Write sends b to the peer as a single datagram.
+func (c *Conn) Write(b []byte) (int, error) {
+	frame := appendHeader(make([]byte, 0, headerSize+len(b)), frameData, c.loc.ID)
+	if _, err := c.sock.WriteTo(append(frame, b...), c.server); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	c.once.Do(func() {
+		close(c.closec)
+		c.closeErr = c.sock.Close()
+	})
+	return c.closeErr
+}
+
+// LocalAddr returns the local address of the connection.
+func (c *Conn) LocalAddr() net.Addr { return c.sock.LocalAddr() }
+
+// RemoteAddr returns the address of the rendezvous server relaying the connection.
+func (c *Conn) RemoteAddr() net.Addr { return c.server }
+
+// SetDeadline sets the read and write deadlines of the connection.
+func (c *Conn) SetDeadline(t time.Time) error { return c.sock.SetDeadline(t) }
+
+// SetReadDeadline sets the read deadline of the connection.
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.sock.SetReadDeadline(t) }
+
+// SetWriteDeadline sets the write deadline of the connection.
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.sock.SetWriteDeadline(t) }
+
+func (c *Conn) keepAlive() {
+	t := time.NewTicker(KeepAliveInterval)
+	defer t.Stop()
+	join := appendHeader(nil, frameJoin, c.loc.ID)
+	for {
+		select {
+		case <-c.closec:
+			return
+		case <-t.C:
+			if _, err := c.sock.WriteTo(join, c.server); errors.Is(err, net.ErrClosed) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rendezvous
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"github.com/pion/stun"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/endpoints"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+)
+
+// DefaultProbeTimeout is the default time a candidate endpoint is given to
+// complete a WireGuard handshake.
+const DefaultProbeTimeout = 5 * time.Second
+
+// DirectOptions are options for bringing up a direct WireGuard tunnel with a
+// peer met at a rendezvous.
+type DirectOptions struct {
+	// WireGuard is the interface to configure the peer on.
+	WireGuard wireguard.Interface
+	// Key is the key of the local node. Its public key is sent to the peer.
+	Key crypto.PrivateKey
+	// Peer is the peer to configure. Its public key and endpoint are filled
+	// in from the exchange.
+	Peer wireguard.Peer
+	// STUNServers are used to discover the public address of this node.
+	// Only local addresses are offered when empty.
+	STUNServers []string
+	// ProbeTimeout is the time each candidate endpoint is given to complete
+	// a handshake. Defaults to DefaultProbeTimeout.
+	ProbeTimeout time.Duration
+}
+
+// ConnectDirect exchanges WireGuard keys and candidate endpoints with the peer
+// over the rendezvous connection, then configures the peer at the first of its
+// candidates that completes a handshake. The rendezvous connection is closed
+// when ConnectDirect returns, leaving the peers connected directly. Candidates
+// behind NAT are only reachable if the NAT keeps the WireGuard port, as both
+// peers send to each other at the same time to punch through it.
+func ConnectDirect(ctx context.Context, conn *Conn, opts DirectOptions) (wireguard.Peer, error) {
+	defer conn.Close()
+	log := context.LoggerFrom(ctx).With("component", "rendezvous")
+	if opts.ProbeTimeout <= 0 {
+		opts.ProbeTimeout = DefaultProbeTimeout
+	}
+	wgPort, err := opts.WireGuard.ListenPort()
+	if err != nil {
+		return wireguard.Peer{}, fmt.Errorf("wireguard listen port: %w", err)
+	}
+	candidates, err := Candidates(ctx, uint16(wgPort), opts.STUNServers, opts.WireGuard.Name())
+	if err != nil {
+		return wireguard.Peer{}, err
+	}
+	pubkey, err := opts.Key.PublicKey().Encode()
+	if err != nil {
+		return wireguard.Peer{}, fmt.Errorf("encode public key: %w", err)
+	}
+	remote, err := Exchange(ctx, conn, Endpoints{PublicKey: pubkey, Candidates: candidates})
+	if err != nil {
+		return wireguard.Peer{}, fmt.Errorf("exchange endpoints: %w", err)
+	}
+	peer := opts.Peer
+	peer.PublicKey, err = crypto.DecodePublicKey(remote.PublicKey)
+	if err != nil {
+		return wireguard.Peer{}, fmt.Errorf("decode peer public key: %w", err)
+	}
+	wgkey := peer.PublicKey.WireGuardKey().String()
+	for _, candidate := range remote.Candidates {
+		log.Debug("Probing peer endpoint", slog.String("endpoint", candidate.String()))
+		peer.Endpoint = candidate
+		start := time.Now().Truncate(time.Second)
+		if err := opts.WireGuard.PutPeer(ctx, &peer); err != nil {
+			return wireguard.Peer{}, fmt.Errorf("put wireguard peer: %w", err)
+		}
+		if probeHandshake(ctx, opts.WireGuard, peer, wgkey, start, opts.ProbeTimeout) {
+			log.Info("Direct tunnel established", slog.String("endpoint", candidate.String()))
+			return peer, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return wireguard.Peer{}, err
+		}
+	}
+	return wireguard.Peer{}, fmt.Errorf("no handshake with any of the %d peer endpoints", len(remote.Candidates))
+}
+
+// probeHandshake sends traffic to the peer until a handshake newer than start
+// completes or the timeout expires.
+func probeHandshake(ctx context.Context, wg wireguard.Interface, peer wireguard.Peer, wgkey string, start time.Time, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var target netip.Addr
+	if peer.PrivateIPv4.IsValid() {
+		target = peer.PrivateIPv4.Addr()
+	} else if peer.PrivateIPv6.IsValid() {
+		target = peer.PrivateIPv6.Addr()
+	}
+	ticker := time.NewTicker(JoinInterval)
+	defer ticker.Stop()
+	for {
+		if target.IsValid() {
+			// Traffic triggers a handshake, it does not matter if the ping fails.
+			pingCtx, pingCancel := context.WithTimeout(ctx, JoinInterval)
+			_ = netutil.Ping(pingCtx, target)
+			pingCancel()
+		}
+		metrics, err := wg.Metrics()
+		if err == nil {
+			for _, p := range metrics.GetPeers() {
+				if p.GetPublicKey() != wgkey {
+					continue
+				}
+				last, err := time.Parse(time.RFC3339, p.GetLastHandshakeTime())
+				if err == nil && !last.Before(start) {
+					return true
+				}
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+// Candidates returns the endpoints a WireGuard interface listening on the given
+// port may be reached at. Addresses of local interfaces come first, followed by
+// the public addresses discovered with the given STUN servers. The discovered
+// addresses are paired with the WireGuard port, assuming the NAT preserves it.
+func Candidates(ctx context.Context, wgPort uint16, stunServers []string, skipInterfaces ...string) ([]netip.AddrPort, error) {
+	local, err := endpoints.Detect(ctx, endpoints.DetectOpts{
+		DetectIPv6:     true,
+		DetectPrivate:  true,
+		SkipInterfaces: skipInterfaces,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("detect local endpoints: %w", err)
+	}
+	seen := make(map[netip.Addr]bool)
+	var out []netip.AddrPort
+	add := func(addr netip.Addr) {
+		addr = addr.Unmap()
+		if seen[addr] {
+			return
+		}
+		seen[addr] = true
+		out = append(out, netip.AddrPortFrom(addr, wgPort))
+	}
+	for _, prefix := range local {
+		add(prefix.Addr())
+	}
+	for _, server := range stunServers {
+		addr, err := DiscoverPublicAddr(serverAddr(server))
+		if err != nil {
+			context.LoggerFrom(ctx).Debug("Failed to discover public address",
+				slog.String("server", server), slog.String("error", err.Error()))
+			continue
+		}
+		add(addr.Addr())
+	}
+	return out, nil
+}
+
+// DiscoverPublicAddr returns the address a UDP socket is seen from by the given
+// STUN server.
+func DiscoverPublicAddr(server string) (netip.AddrPort, error) {
+	c, err := stun.Dial("udp", server)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("dial stun server: %w", err)
+	}
+	defer c.Close()
+	var addr stun.XORMappedAddress
+	var reqErr error
+	err = c.Do(stun.MustBuild(stun.TransactionID, stun.BindingRequest), func(ev stun.Event) {
+		if ev.Error != nil {
+			reqErr = ev.Error
+			return
+		}
+		reqErr = addr.GetFrom(ev.Message)
+	})
+	if err == nil {
+		err = reqErr
+	}
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("stun binding request: %w", err)
+	}
+	ip, ok := netip.AddrFromSlice(addr.IP)
+	if !ok {
+		return netip.AddrPort{}, fmt.Errorf("invalid mapped address %s", addr.IP)
+	}
+	return netip.AddrPortFrom(ip.Unmap(), uint16(addr.Port)), nil
+}
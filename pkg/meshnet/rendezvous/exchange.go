@@ -0,0 +1,121 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rendezvous
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// ErrBadExchange is returned when an exchange times out after receiving only
+// messages that were not authenticated with the secret of the location.
+var ErrBadExchange = errors.New("rendezvous exchange message is not authentic")
+
+// Endpoints are what peers exchange at a rendezvous to set up a direct
+// WireGuard tunnel.
+type Endpoints struct {
+	// PublicKey is the encoded public key of the peer.
+	PublicKey string `json:"publicKey"`
+	// Candidates are the addresses the WireGuard interface of the peer may
+	// be reached at, in order of preference.
+	Candidates []netip.AddrPort `json:"candidates"`
+}
+
+// exchangeMessage is sent until the peer acknowledges it.
+type exchangeMessage struct {
+	Endpoints Endpoints `json:"endpoints"`
+	// Ack is true once the sender has received the endpoints of the peer.
+	Ack bool `json:"ack"`
+}
+
+// Exchange sends the local endpoints to the peer at the other end of the
+// connection and returns those of the peer. Messages are authenticated with
+// the secret of the location, so the rendezvous server cannot substitute its
+// own keys or endpoints.
+func Exchange(ctx context.Context, conn *Conn, local Endpoints) (Endpoints, error) {
+	var remote Endpoints
+	var received, acked, forged bool
+	secret := []byte(conn.Location().Secret)
+	send := func() error {
+		data, err := json.Marshal(exchangeMessage{Endpoints: local, Ack: received})
+		if err != nil {
+			return err
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		_, err = conn.Write(mac.Sum(data))
+		return err
+	}
+	defer conn.SetReadDeadline(time.Time{})
+	buf := make([]byte, 65535)
+	for !received || !acked {
+		if err := send(); err != nil {
+			return Endpoints{}, fmt.Errorf("send endpoints: %w", err)
+		}
+		if err := ctx.Err(); err != nil {
+			if forged && !received {
+				return Endpoints{}, fmt.Errorf("%w: %w", ErrBadExchange, err)
+			}
+			return Endpoints{}, err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(JoinInterval))
+		n, err := conn.Read(buf)
+		if err != nil {
+			if isTimeout(err) {
+				continue
+			}
+			return Endpoints{}, fmt.Errorf("receive endpoints: %w", err)
+		}
+		if n < sha256.Size {
+			forged = true
+			continue
+		}
+		data, sum := buf[:n-sha256.Size], buf[n-sha256.Size:n]
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(data)
+		if !hmac.Equal(mac.Sum(nil), sum) {
+			// Drop forged messages instead of failing, so a single injected
+			// datagram cannot abort the exchange.
+			forged = true
+			continue
+		}
+		var msg exchangeMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			return Endpoints{}, fmt.Errorf("decode endpoints: %w", err)
+		}
+		remote, received = msg.Endpoints, true
+		acked = acked || msg.Ack
+	}
+	// Acknowledge the endpoints of the peer a few more times in case it has
+	// not seen our acknowledgement yet.
+	for i := 0; i < 3; i++ {
+		_ = send()
+	}
+	return remote, nil
+}
+
+func isTimeout(err error) bool {
+	var t interface{ Timeout() bool }
+	return errors.As(err, &t) && t.Timeout()
+}
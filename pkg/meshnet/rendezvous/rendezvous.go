@@ -0,0 +1,118 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package rendezvous lets two peers holding the same pre-shared key meet at a
+// relay server without any other signaling. Both peers derive the same location,
+// a relay server and an identifier, from the PSK and the current time slot, and
+// the server pairs them up and relays UDP datagrams between them. Locations
+// rotate with every slot so an observed identifier is only useful for a short time.
+//
+// Peers can also use the relayed connection only to exchange WireGuard keys and
+// endpoints with ConnectDirect, and then talk over a direct tunnel.
+package rendezvous
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+// DefaultSlot is the default length of the time slots locations rotate on.
+const DefaultSlot = 5 * time.Minute
+
+// IDSize is the size of a location identifier.
+const IDSize = 16
+
+// locationInfo is the HMAC context used when deriving locations.
+const locationInfo = "webmesh-rendezvous-v1"
+
+// ErrNoServers is returned when no servers are given to derive a location from.
+var ErrNoServers = errors.New("no rendezvous servers given")
+
+// Location is a rendezvous point derived from a PSK for a single time slot.
+type Location struct {
+	// Server is the server to meet at, as it was given when deriving the location.
+	Server string
+	// ID identifies the rendezvous on the server. It is only known to holders of the PSK.
+	ID [IDSize]byte
+	// Secret is a secret shared by the peers meeting at the location. It can be used
+	// to authenticate the peers to each other, or as credentials for other protocols
+	// such as ICE.
+	Secret string
+	// Expires is when the time slot of the location ends.
+	Expires time.Time
+}
+
+// Find returns the location for the given PSK and servers in the current time slot.
+// If slot is zero DefaultSlot is used.
+func Find(psk []byte, servers []string, slot time.Duration) (Location, error) {
+	return FindAt(psk, servers, slot, time.Now())
+}
+
+// FindAt returns the location for the given PSK and servers in the time slot
+// containing t. If slot is zero DefaultSlot is used. Peers must use the same
+// servers in the same order to derive the same location.
+func FindAt(psk []byte, servers []string, slot time.Duration, t time.Time) (Location, error) {
+	if len(servers) == 0 {
+		return Location{}, ErrNoServers
+	}
+	if slot <= 0 {
+		slot = DefaultSlot
+	}
+	index := t.UnixNano() / int64(slot)
+	mac := hmac.New(sha256.New, psk)
+	mac.Write([]byte(locationInfo))
+	_ = binary.Write(mac, binary.BigEndian, index)
+	sum := mac.Sum(nil)
+	loc := Location{
+		Server:  servers[binary.BigEndian.Uint64(sum[:8])%uint64(len(servers))],
+		Secret:  base64.RawURLEncoding.EncodeToString(sum[8+IDSize:]),
+		Expires: time.Unix(0, (index+1)*int64(slot)),
+	}
+	copy(loc.ID[:], sum[8:8+IDSize])
+	return loc, nil
+}
+
+// Nearby returns the locations of the time slot containing t and the one before
+// it, so peers whose clocks disagree around a slot boundary still meet.
+func Nearby(psk []byte, servers []string, slot time.Duration, t time.Time) ([]Location, error) {
+	if slot <= 0 {
+		slot = DefaultSlot
+	}
+	current, err := FindAt(psk, servers, slot, t)
+	if err != nil {
+		return nil, err
+	}
+	previous, err := FindAt(psk, servers, slot, t.Add(-slot))
+	if err != nil {
+		return nil, err
+	}
+	return []Location{current, previous}, nil
+}
+
+// serverAddr strips any STUN/TURN scheme and query from a server URL, leaving
+// its host and port.
+func serverAddr(server string) string {
+	for _, scheme := range []string{"turn:", "turns:", "stun:", "stuns:", "udp://"} {
+		server = strings.TrimPrefix(server, scheme)
+	}
+	server, _, _ = strings.Cut(server, "?")
+	return server
+}
@@ -0,0 +1,184 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rendezvous
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestFindAt(t *testing.T) {
+	t.Parallel()
+	servers := []string{"turn:a:3478", "turn:b:3478", "turn:c:3478"}
+	now := time.Unix(1700000000, 0)
+
+	a, err := FindAt([]byte("psk"), servers, time.Minute, now)
+	if err != nil {
+		t.Fatalf("find location: %v", err)
+	}
+	b, err := FindAt([]byte("psk"), servers, time.Minute, now.Add(10*time.Second))
+	if err != nil {
+		t.Fatalf("find location: %v", err)
+	}
+	if a != b {
+		t.Fatalf("expected the same location within a slot, got %+v and %+v", a, b)
+	}
+	if !a.Expires.Equal(time.Unix(1700000040, 0)) {
+		t.Fatalf("expected location to expire at the end of the slot, got %v", a.Expires)
+	}
+	next, _ := FindAt([]byte("psk"), servers, time.Minute, a.Expires)
+	if next.ID == a.ID || next.Secret == a.Secret {
+		t.Fatal("expected the location to rotate with the slot")
+	}
+	other, _ := FindAt([]byte("other"), servers, time.Minute, now)
+	if other.ID == a.ID {
+		t.Fatal("expected different PSKs to derive different locations")
+	}
+
+	nearby, err := Nearby([]byte("psk"), servers, time.Minute, a.Expires)
+	if err != nil {
+		t.Fatalf("find nearby locations: %v", err)
+	}
+	if len(nearby) != 2 || nearby[0] != next || nearby[1] != a {
+		t.Fatalf("expected the current and previous locations, got %+v", nearby)
+	}
+
+	if _, err := FindAt([]byte("psk"), nil, time.Minute, now); err != ErrNoServers {
+		t.Fatalf("expected ErrNoServers, got %v", err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	servers := startTestServer(t, ctx)
+	a, b := dialPair(t, ctx, servers, "psk")
+
+	buf := make([]byte, 1500)
+	for _, pair := range [][2]*Conn{{a, b}, {b, a}} {
+		msg := []byte("hello " + pair[0].LocalAddr().String())
+		if _, err := pair[0].Write(msg); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		_ = pair[1].SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, err := pair[1].Read(buf)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if !bytes.Equal(buf[:n], msg) {
+			t.Fatalf("expected %q, got %q", msg, buf[:n])
+		}
+	}
+
+	// A peer with a different PSK never meets anyone.
+	lonelyCtx, lonelyCancel := context.WithTimeout(ctx, time.Second)
+	defer lonelyCancel()
+	locs, _ := Nearby([]byte("other"), servers, 0, time.Now())
+	if _, err := Dial(lonelyCtx, locs...); err == nil {
+		t.Fatal("expected dial without a peer to fail")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	t.Parallel()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	servers := startTestServer(t, ctx)
+	a, b := dialPair(t, ctx, servers, "psk")
+
+	local := Endpoints{
+		PublicKey:  "key-a",
+		Candidates: []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:51820")},
+	}
+	peer := Endpoints{
+		PublicKey:  "key-b",
+		Candidates: []netip.AddrPort{netip.MustParseAddrPort("192.168.1.1:51820")},
+	}
+	// A forged message from the server is ignored.
+	if _, err := a.Write([]byte("forged message that is longer than a mac.....")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	errs := make(chan error, 1)
+	go func() {
+		got, err := Exchange(ctx, b, peer)
+		if err == nil && got.PublicKey != local.PublicKey {
+			err = fmt.Errorf("expected %q, got %q", local.PublicKey, got.PublicKey)
+		}
+		errs <- err
+	}()
+	got, err := Exchange(ctx, a, local)
+	if err != nil {
+		t.Fatalf("exchange: %v", err)
+	}
+	if got.PublicKey != peer.PublicKey || len(got.Candidates) != 1 || got.Candidates[0] != peer.Candidates[0] {
+		t.Fatalf("expected %+v, got %+v", peer, got)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("peer exchange: %v", err)
+	}
+}
+
+func startTestServer(t *testing.T, ctx context.Context) []string {
+	t.Helper()
+	l, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := NewServer(ctx, ServerOptions{})
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(func() { _ = srv.Shutdown(context.Background()) })
+	return []string{"udp://" + l.LocalAddr().String()}
+}
+
+func dialPair(t *testing.T, ctx context.Context, servers []string, psk string) (*Conn, *Conn) {
+	t.Helper()
+	dial := func() chan *Conn {
+		ch := make(chan *Conn, 1)
+		go func() {
+			defer close(ch)
+			locs, err := Nearby([]byte(psk), servers, 0, time.Now())
+			if err != nil {
+				t.Errorf("find locations: %v", err)
+				return
+			}
+			conn, err := Dial(ctx, locs...)
+			if err != nil {
+				t.Errorf("dial: %v", err)
+				return
+			}
+			ch <- conn
+		}()
+		return ch
+	}
+	ach, bch := dial(), dial()
+	a, b := <-ach, <-bch
+	if a == nil || b == nil {
+		t.FailNow()
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
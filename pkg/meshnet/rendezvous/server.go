@@ -0,0 +1,229 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rendezvous
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// DefaultListenAddress is the default listen address for the rendezvous server.
+const DefaultListenAddress = "[::]:3479"
+
+// DefaultIdleTimeout is the default time after which an idle rendezvous is forgotten.
+const DefaultIdleTimeout = 2 * time.Minute
+
+// DefaultMaxLocations is the default number of rendezvous a server tracks at once.
+const DefaultMaxLocations = 4096
+
+// Frames exchanged with the server start with frameMagic, followed by the frame
+// type and the location ID. Data frames carry the relayed datagram after the header.
+var frameMagic = []byte("WMRV")
+
+const headerSize = 4 + 1 + IDSize
+
+const (
+	// frameJoin is sent by peers to join, or stay at, a location.
+	frameJoin byte = iota + 1
+	// frameMatch is sent by the server to both peers once a location is full.
+	frameMatch
+	// frameData carries a datagram to relay to the other peer.
+	frameData
+)
+
+// ServerOptions are options for the rendezvous server.
+type ServerOptions struct {
+	// ListenUDP is the address the server listens on. Defaults to DefaultListenAddress.
+	ListenUDP string
+	// IdleTimeout is how long a rendezvous is kept without traffic from either peer.
+	// Defaults to DefaultIdleTimeout.
+	IdleTimeout time.Duration
+	// MaxLocations is the maximum number of rendezvous tracked at once. Joins to new
+	// locations are dropped while the server is full. Defaults to DefaultMaxLocations.
+	MaxLocations int
+}
+
+// Server pairs up peers joining the same location and relays datagrams between them.
+// The server never learns the PSK, only the IDs derived from it.
+type Server struct {
+	ServerOptions
+	context.Context
+	cancel    context.CancelFunc
+	log       *slog.Logger
+	locations map[[IDSize]byte]*meeting
+	mu        sync.Mutex
+}
+
+// meeting is the state of a single rendezvous on the server.
+type meeting struct {
+	peers    []net.Addr
+	lastSeen time.Time
+}
+
+// NewServer creates a new rendezvous server.
+func NewServer(ctx context.Context, o ServerOptions) *Server {
+	log := context.LoggerFrom(ctx).With("component", "rendezvous-server")
+	if o.ListenUDP == "" {
+		o.ListenUDP = DefaultListenAddress
+	}
+	if o.IdleTimeout <= 0 {
+		o.IdleTimeout = DefaultIdleTimeout
+	}
+	if o.MaxLocations <= 0 {
+		o.MaxLocations = DefaultMaxLocations
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Server{
+		ServerOptions: o,
+		Context:       ctx,
+		cancel:        cancel,
+		log:           log,
+		locations:     make(map[[IDSize]byte]*meeting),
+	}
+}
+
+// ListenAndServe starts the rendezvous server and blocks until the server exits.
+func (s *Server) ListenAndServe() error {
+	conn, err := net.ListenPacket("udp", s.ListenUDP)
+	if err != nil {
+		return fmt.Errorf("failed to listen on UDP: %w", err)
+	}
+	s.log.Info("Listening for rendezvous requests", slog.String("listen-addr", s.ListenUDP))
+	return s.Serve(conn)
+}
+
+// Serve serves rendezvous requests on the given connection until the server is
+// shut down. The connection is closed when Serve returns.
+func (s *Server) Serve(conn net.PacketConn) error {
+	defer conn.Close()
+	go func() {
+		ticker := time.NewTicker(s.IdleTimeout / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.Done():
+				conn.Close()
+				return
+			case <-ticker.C:
+				s.expire()
+			}
+		}
+	}()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("read from UDP: %w", err)
+		}
+		s.handle(conn, addr, buf[:n])
+	}
+}
+
+// Shutdown stops the rendezvous server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	context.LoggerFrom(ctx).Info("Shutting down rendezvous server")
+	s.cancel()
+	return nil
+}
+
+func (s *Server) handle(conn net.PacketConn, addr net.Addr, frame []byte) {
+	typ, id, ok := parseHeader(frame)
+	if !ok {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.locations[id]
+	switch typ {
+	case frameJoin:
+		if !ok {
+			if len(s.locations) >= s.MaxLocations {
+				s.log.Debug("Dropping join, too many locations", slog.String("addr", addr.String()))
+				return
+			}
+			m = &meeting{}
+			s.locations[id] = m
+		}
+		if indexOf(m.peers, addr) == -1 {
+			if len(m.peers) == 2 {
+				return
+			}
+			m.peers = append(m.peers, addr)
+		}
+		m.lastSeen = time.Now()
+		if len(m.peers) == 2 {
+			// Matches are sent on every join so peers recover from lost replies.
+			match := appendHeader(nil, frameMatch, id)
+			for _, peer := range m.peers {
+				_, _ = conn.WriteTo(match, peer)
+			}
+		}
+	case frameData:
+		if !ok || len(m.peers) != 2 {
+			return
+		}
+		idx := indexOf(m.peers, addr)
+		if idx == -1 {
+			return
+		}
+		m.lastSeen = time.Now()
+		_, _ = conn.WriteTo(frame, m.peers[1-idx])
+	}
+}
+
+func (s *Server) expire() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, m := range s.locations {
+		if time.Since(m.lastSeen) > s.IdleTimeout {
+			delete(s.locations, id)
+		}
+	}
+}
+
+func indexOf(addrs []net.Addr, addr net.Addr) int {
+	for i, a := range addrs {
+		if a.String() == addr.String() {
+			return i
+		}
+	}
+	return -1
+}
+
+func appendHeader(b []byte, typ byte, id [IDSize]byte) []byte {
+	b = append(b, frameMagic...)
+	b = append(b, typ)
+	return append(b, id[:]...)
+}
+
+func parseHeader(frame []byte) (typ byte, id [IDSize]byte, ok bool) {
+	if len(frame) < headerSize || !bytes.HasPrefix(frame, frameMagic) {
+		return 0, id, false
+	}
+	copy(id[:], frame[len(frameMagic)+1:headerSize])
+	return frame[len(frameMagic)], id, true
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/graphstore"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultSamples is the number of nodes the pipeline is run for when none
+// is given.
+const DefaultSamples = 10
+
+// RunOptions are options for driving the pipeline against a population.
+type RunOptions struct {
+	// Samples is the number of nodes to compute peers for. Computing them
+	// for every node of a large mesh takes far too long to be useful, so a
+	// random sample is used. Defaults to DefaultSamples.
+	Samples int
+	// Seed seeds the choice of sampled nodes.
+	Seed int64
+}
+
+// Report holds the timings collected by Run.
+type Report struct {
+	// Nodes is the number of nodes in the mesh.
+	Nodes int `json:"nodes"`
+	// WriteSnapshot is the time it took to write a graph snapshot.
+	WriteSnapshot time.Duration `json:"writeSnapshot"`
+	// LoadSnapshot is the time it took to load the graph snapshot back.
+	LoadSnapshot time.Duration `json:"loadSnapshot"`
+	// SnapshotError is set if the graph snapshot could not be written.
+	SnapshotError string `json:"snapshotError,omitempty"`
+	// FilterGraph are the timings of FilterGraph for each sampled node.
+	FilterGraph Stats `json:"filterGraph"`
+	// WireGuardPeers are the timings of WireGuardPeersFor for each sampled node.
+	WireGuardPeers Stats `json:"wireguardPeers"`
+	// MeanPeers is the mean number of peers computed for the sampled nodes.
+	MeanPeers float64 `json:"meanPeers"`
+}
+
+// Stats summarizes a set of timings.
+type Stats struct {
+	Count int           `json:"count"`
+	Min   time.Duration `json:"min"`
+	Max   time.Duration `json:"max"`
+	Mean  time.Duration `json:"mean"`
+	P50   time.Duration `json:"p50"`
+	P99   time.Duration `json:"p99"`
+}
+
+// NewStats summarizes the given timings.
+func NewStats(timings []time.Duration) Stats {
+	if len(timings) == 0 {
+		return Stats{}
+	}
+	sorted := slices.Clone(timings)
+	slices.Sort(sorted)
+	var total time.Duration
+	for _, t := range sorted {
+		total += t
+	}
+	percentile := func(p int) time.Duration {
+		return sorted[(len(sorted)-1)*p/100]
+	}
+	return Stats{
+		Count: len(sorted),
+		Min:   sorted[0],
+		Max:   sorted[len(sorted)-1],
+		Mean:  total / time.Duration(len(sorted)),
+		P50:   percentile(50),
+		P99:   percentile(99),
+	}
+}
+
+// String returns a one line summary of the stats.
+func (s Stats) String() string {
+	return fmt.Sprintf("n=%d min=%s p50=%s p99=%s max=%s mean=%s", s.Count, s.Min, s.P50, s.P99, s.Max, s.Mean)
+}
+
+// Run drives the peer computation pipeline against a population written
+// to the given storage with Populate.
+func Run(ctx context.Context, st storage.MeshStorage, pop *Population, opts RunOptions) (*Report, error) {
+	if opts.Samples <= 0 {
+		opts.Samples = DefaultSamples
+	}
+	db := meshdb.NewFromStorage(st)
+	report := &Report{Nodes: len(pop.Nodes)}
+
+	// Nodes keep working when the snapshot cannot be written, they just fall
+	// back to reading the whole graph. Report the failure instead of failing
+	// the run, since hitting such limits is what the simulator is for.
+	start := time.Now()
+	if _, err := graphstore.WriteSnapshot(ctx, st); err != nil {
+		report.SnapshotError = err.Error()
+	} else {
+		report.WriteSnapshot = time.Since(start)
+		start = time.Now()
+		snap, ok, err := graphstore.LoadSnapshot(ctx, st)
+		if err != nil {
+			return nil, fmt.Errorf("load snapshot: %w", err)
+		}
+		report.LoadSnapshot = time.Since(start)
+		if !ok || len(snap.Nodes) != len(pop.Nodes) {
+			return nil, fmt.Errorf("snapshot is stale or incomplete: found %d of %d nodes", len(snap.Nodes), len(pop.Nodes))
+		}
+	}
+
+	rng := rand.New(rand.NewSource(opts.Seed))
+	samples := make([]types.NodeID, min(opts.Samples, len(pop.Nodes)))
+	for i, j := range rng.Perm(len(pop.Nodes))[:len(samples)] {
+		samples[i] = pop.Nodes[j]
+	}
+	var filterTimings, peerTimings []time.Duration
+	var totalPeers int
+	for _, id := range samples {
+		start := time.Now()
+		if _, err := meshnet.FilterGraph(ctx, db, id); err != nil {
+			return nil, fmt.Errorf("filter graph for %s: %w", id, err)
+		}
+		filterTimings = append(filterTimings, time.Since(start))
+		start = time.Now()
+		peers, err := meshnet.WireGuardPeersFor(ctx, db, id)
+		if err != nil {
+			return nil, fmt.Errorf("compute peers for %s: %w", id, err)
+		}
+		peerTimings = append(peerTimings, time.Since(start))
+		totalPeers += len(peers)
+	}
+	report.FilterGraph = NewStats(filterTimings)
+	report.WireGuardPeers = NewStats(peerTimings)
+	report.MeanPeers = float64(totalPeers) / float64(len(samples))
+	return report, nil
+}
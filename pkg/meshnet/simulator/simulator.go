@@ -0,0 +1,348 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package simulator generates synthetic mesh populations directly into a
+// storage backend and drives the peer computation pipeline against them.
+// It is used for performance regression testing of large meshes without
+// any real networking.
+package simulator
+
+import (
+	"fmt"
+	"math/rand"
+	"net/netip"
+
+	"github.com/google/uuid"
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Topology is the shape of a generated mesh.
+type Topology string
+
+const (
+	// TopologyHubAndSpoke connects every spoke to one or more hubs, and the
+	// hubs to each other.
+	TopologyHubAndSpoke Topology = "hub-and-spoke"
+	// TopologyRandom connects every node to a number of random other nodes.
+	TopologyRandom Topology = "random"
+)
+
+const (
+	// DefaultNetworkV4 is the IPv4 network used when none is given.
+	DefaultNetworkV4 = "172.16.0.0/12"
+	// DefaultNetworkV6 is the IPv6 network used when none is given.
+	DefaultNetworkV6 = "fd00:dead:beef::/48"
+	// DefaultKeyPoolSize is the number of keys generated when none is given.
+	DefaultKeyPoolSize = 256
+)
+
+// Options are options for generating a synthetic mesh.
+type Options struct {
+	// Nodes is the number of nodes to generate.
+	Nodes int
+	// Topology is the shape of the mesh. Defaults to TopologyHubAndSpoke.
+	Topology Topology
+	// Hubs is the number of hubs in a hub-and-spoke mesh. Defaults to one
+	// for every hundred nodes.
+	Hubs int
+	// EdgesPerNode is the number of hubs each spoke connects to, or the
+	// number of random edges each node has. Defaults to one.
+	EdgesPerNode int
+	// ACLs is the number of random network ACLs to generate in addition to
+	// the allow-all ACL every mesh starts with.
+	ACLs int
+	// KeyPoolSize is the number of distinct keys to hand out to nodes.
+	// Generating a key for every node dominates the time it takes to
+	// populate large meshes, so keys are reused. Defaults to DefaultKeyPoolSize.
+	KeyPoolSize int
+	// Seed seeds the generator so that populations can be reproduced.
+	Seed int64
+}
+
+// Default sets any unset options to their defaults.
+func (o *Options) Default() {
+	if o.Topology == "" {
+		o.Topology = TopologyHubAndSpoke
+	}
+	if o.Hubs <= 0 {
+		o.Hubs = max(1, o.Nodes/100)
+	}
+	if o.EdgesPerNode <= 0 {
+		o.EdgesPerNode = 1
+	}
+	if o.KeyPoolSize <= 0 {
+		o.KeyPoolSize = DefaultKeyPoolSize
+	}
+}
+
+// Validate validates the options.
+func (o *Options) Validate() error {
+	if o.Nodes < 2 {
+		return fmt.Errorf("at least two nodes are required")
+	}
+	if o.Nodes >= 1<<20 {
+		return fmt.Errorf("too many nodes for the default networks: %d", o.Nodes)
+	}
+	switch o.Topology {
+	case TopologyHubAndSpoke:
+		if o.Hubs >= o.Nodes {
+			return fmt.Errorf("hubs (%d) must be fewer than nodes (%d)", o.Hubs, o.Nodes)
+		}
+	case TopologyRandom:
+		if o.EdgesPerNode >= o.Nodes {
+			return fmt.Errorf("edges per node (%d) must be fewer than nodes (%d)", o.EdgesPerNode, o.Nodes)
+		}
+	default:
+		return fmt.Errorf("unknown topology: %q", o.Topology)
+	}
+	return nil
+}
+
+// Population describes a generated mesh.
+type Population struct {
+	// Nodes are the IDs of the generated nodes.
+	Nodes []types.NodeID
+	// Edges is the number of generated edges.
+	Edges int
+	// ACLs is the number of generated network ACLs.
+	ACLs int
+}
+
+// Populate writes a synthetic mesh to the given storage.
+func Populate(ctx context.Context, st storage.MeshStorage, opts Options) (*Population, error) {
+	opts.Default()
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	rng := rand.New(rand.NewSource(opts.Seed))
+	db := meshdb.NewFromStorage(st)
+	err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: DefaultNetworkV4,
+			NetworkV6: DefaultNetworkV6,
+			Domain:    "sim.internal.",
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("set mesh state: %w", err)
+	}
+	keys := make([]string, min(opts.KeyPoolSize, opts.Nodes))
+	for i := range keys {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return nil, fmt.Errorf("generate key: %w", err)
+		}
+		keys[i], err = key.PublicKey().Encode()
+		if err != nil {
+			return nil, fmt.Errorf("encode key: %w", err)
+		}
+	}
+	// Nodes and edges are written straight to storage in batches. Going
+	// through the graph would re-read the whole graph on every edge, which
+	// makes populating large meshes quadratic.
+	w := &batchWriter{st: st}
+	pop := &Population{Nodes: make([]types.NodeID, opts.Nodes)}
+	netv4 := netip.MustParsePrefix(DefaultNetworkV4)
+	netv6 := netip.MustParsePrefix(DefaultNetworkV6)
+	for i := range pop.Nodes {
+		id := types.NodeID(fmt.Sprintf("sim-%06d", i))
+		pop.Nodes[i] = id
+		node := types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:                 id.String(),
+			PublicKey:          keys[i%len(keys)],
+			PrimaryEndpoint:    fmt.Sprintf("198.18.%d.%d", (i>>8)&0xff, i&0xff),
+			WireguardEndpoints: []string{fmt.Sprintf("198.18.%d.%d:51820", (i>>8)&0xff, i&0xff)},
+			PrivateIPv4:        addrV4(netv4, i).String(),
+			PrivateIPv6:        addrV6(netv6, i).String(),
+		}}
+		data, err := node.MarshalProtoJSON()
+		if err != nil {
+			return nil, fmt.Errorf("marshal node %s: %w", id, err)
+		}
+		if err := w.put(ctx, storage.NodesPrefix.For(id.Bytes()), data); err != nil {
+			return nil, fmt.Errorf("put node %s: %w", id, err)
+		}
+	}
+	seen := make(map[[2]types.NodeID]struct{})
+	putEdge := func(a, b types.NodeID) error {
+		if b < a {
+			a, b = b, a
+		}
+		if _, ok := seen[[2]types.NodeID{a, b}]; ok {
+			return nil
+		}
+		seen[[2]types.NodeID{a, b}] = struct{}{}
+		// The graph is undirected and stores an edge in each direction.
+		for _, dir := range [][2]types.NodeID{{a, b}, {b, a}} {
+			edge := types.MeshEdge{MeshEdge: &v1.MeshEdge{
+				Source: dir[0].String(),
+				Target: dir[1].String(),
+				Weight: 1,
+			}}
+			data, err := edge.MarshalProtoJSON()
+			if err != nil {
+				return fmt.Errorf("marshal edge %s -> %s: %w", dir[0], dir[1], err)
+			}
+			if err := w.put(ctx, storage.EdgesPrefix.For(dir[0].Bytes()).For(dir[1].Bytes()), data); err != nil {
+				return fmt.Errorf("put edge %s -> %s: %w", dir[0], dir[1], err)
+			}
+		}
+		pop.Edges++
+		return nil
+	}
+	switch opts.Topology {
+	case TopologyHubAndSpoke:
+		hubs := pop.Nodes[:opts.Hubs]
+		for i, a := range hubs {
+			for _, b := range hubs[i+1:] {
+				if err := putEdge(a, b); err != nil {
+					return nil, err
+				}
+			}
+		}
+		for _, spoke := range pop.Nodes[opts.Hubs:] {
+			for _, j := range sample(rng, len(hubs), opts.EdgesPerNode) {
+				if err := putEdge(spoke, hubs[j]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case TopologyRandom:
+		for i, a := range pop.Nodes {
+			for n := 0; n < opts.EdgesPerNode; n++ {
+				j := rng.Intn(len(pop.Nodes) - 1)
+				if j >= i {
+					j++
+				}
+				if err := putEdge(a, pop.Nodes[j]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	// Bump the graph version so that cached copies of the graph are dropped.
+	if err := w.put(ctx, storage.GraphVersionKey, []byte(uuid.NewString())); err != nil {
+		return nil, fmt.Errorf("put graph version: %w", err)
+	}
+	if err := w.flush(ctx); err != nil {
+		return nil, err
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "sim-allow-all",
+		Priority:         0,
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+		SourceCIDRs:      []string{"*"},
+		DestinationCIDRs: []string{"*"},
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("put network acl: %w", err)
+	}
+	pop.ACLs++
+	for i := 0; i < opts.ACLs; i++ {
+		// Random ACLs between small groups of nodes, so that evaluation
+		// has to walk past them before reaching the allow-all.
+		action := v1.ACLAction_ACTION_ACCEPT
+		if rng.Intn(4) == 0 {
+			action = v1.ACLAction_ACTION_DENY
+		}
+		acl := &v1.NetworkACL{
+			Name:             fmt.Sprintf("sim-acl-%06d", i),
+			Priority:         int32(1 + rng.Intn(100)),
+			Action:           action,
+			SourceCIDRs:      []string{"*"},
+			DestinationCIDRs: []string{"*"},
+		}
+		for n := 1 + rng.Intn(5); n > 0; n-- {
+			acl.SourceNodes = append(acl.SourceNodes, pop.Nodes[rng.Intn(len(pop.Nodes))].String())
+			acl.DestinationNodes = append(acl.DestinationNodes, pop.Nodes[rng.Intn(len(pop.Nodes))].String())
+		}
+		if err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: acl}); err != nil {
+			return nil, fmt.Errorf("put network acl %s: %w", acl.Name, err)
+		}
+		pop.ACLs++
+	}
+	return pop, nil
+}
+
+// sample returns up to k distinct random integers in [0, n).
+func sample(rng *rand.Rand, n, k int) []int {
+	if k >= n {
+		return rng.Perm(n)
+	}
+	seen := make(map[int]struct{}, k)
+	out := make([]int, 0, k)
+	for len(out) < k {
+		i := rng.Intn(n)
+		if _, ok := seen[i]; ok {
+			continue
+		}
+		seen[i] = struct{}{}
+		out = append(out, i)
+	}
+	return out
+}
+
+// batchSize is the number of writes applied to storage at once.
+const batchSize = 1000
+
+// batchWriter buffers writes and applies them in batches.
+type batchWriter struct {
+	st  storage.MeshStorage
+	ops []storage.WriteOp
+}
+
+func (w *batchWriter) put(ctx context.Context, key, value []byte) error {
+	w.ops = append(w.ops, storage.WriteOp{Key: key, Value: value})
+	if len(w.ops) < batchSize {
+		return nil
+	}
+	return w.flush(ctx)
+}
+
+func (w *batchWriter) flush(ctx context.Context) error {
+	if len(w.ops) == 0 {
+		return nil
+	}
+	if err := w.st.WriteBatch(ctx, w.ops); err != nil {
+		return fmt.Errorf("write batch: %w", err)
+	}
+	w.ops = w.ops[:0]
+	return nil
+}
+
+// addrV4 returns the address of the i-th node in the given network.
+func addrV4(network netip.Prefix, i int) netip.Prefix {
+	b := network.Addr().As4()
+	n := uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+	n += uint32(i) + 1
+	return netip.PrefixFrom(netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}), 32)
+}
+
+// addrV6 returns the /112 of the i-th node in the given /48 network.
+func addrV6(network netip.Prefix, i int) netip.Prefix {
+	b := network.Addr().As16()
+	n := uint32(i) + 1
+	b[10], b[11], b[12], b[13] = byte(n>>24), byte(n>>16), byte(n>>8), byte(n)
+	return netip.PrefixFrom(netip.AddrFrom16(b), 112)
+}
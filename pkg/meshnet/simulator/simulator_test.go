@@ -0,0 +1,140 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package simulator
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+// benchNodesEnv overrides the mesh sizes used by BenchmarkPipeline. It takes
+// a comma separated list of node counts, e.g. "1000,10000,50000".
+const benchNodesEnv = "WEBMESH_SIM_NODES"
+
+func TestSimulator(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	tc := []struct {
+		name string
+		opts Options
+	}{
+		{"HubAndSpoke", Options{Nodes: 50, Hubs: 3, EdgesPerNode: 2, ACLs: 5, Seed: 1}},
+		{"Random", Options{Nodes: 50, Topology: TopologyRandom, EdgesPerNode: 3, ACLs: 5, Seed: 1}},
+	}
+	for _, c := range tc {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			t.Parallel()
+			st := badgerdb.NewTestStorage(false)
+			defer st.Close()
+			pop, err := Populate(ctx, st, c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(pop.Nodes) != c.opts.Nodes || pop.ACLs != c.opts.ACLs+1 || pop.Edges == 0 {
+				t.Fatalf("unexpected population: nodes=%d edges=%d acls=%d", len(pop.Nodes), pop.Edges, pop.ACLs)
+			}
+			report, err := Run(ctx, st, pop, RunOptions{Samples: 5})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report.Nodes != c.opts.Nodes || report.FilterGraph.Count != 5 || report.WireGuardPeers.Count != 5 {
+				t.Fatalf("unexpected report: %+v", report)
+			}
+			if report.MeanPeers == 0 {
+				t.Fatal("expected sampled nodes to have peers")
+			}
+		})
+	}
+
+	t.Run("InvalidOptions", func(t *testing.T) {
+		t.Parallel()
+		for _, opts := range []Options{
+			{Nodes: 1},
+			{Nodes: 10, Hubs: 10},
+			{Nodes: 10, Topology: TopologyRandom, EdgesPerNode: 10},
+			{Nodes: 10, Topology: "ring"},
+		} {
+			opts.Default()
+			if err := opts.Validate(); err == nil {
+				t.Errorf("expected error for options %+v", opts)
+			}
+		}
+	})
+}
+
+func TestNewStats(t *testing.T) {
+	t.Parallel()
+	var timings []time.Duration
+	for i := 100; i > 0; i-- {
+		timings = append(timings, time.Duration(i)*time.Millisecond)
+	}
+	stats := NewStats(timings)
+	if stats.Count != 100 || stats.Min != time.Millisecond || stats.Max != 100*time.Millisecond {
+		t.Fatalf("unexpected stats: %s", stats)
+	}
+	if stats.P50 != 50*time.Millisecond || stats.P99 != 99*time.Millisecond {
+		t.Fatalf("unexpected percentiles: %s", stats)
+	}
+	if stats.Mean != 50500*time.Microsecond {
+		t.Fatalf("unexpected mean: %s", stats)
+	}
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	sizes := []int{100, 1000}
+	if env := os.Getenv(benchNodesEnv); env != "" {
+		sizes = nil
+		for _, s := range strings.Split(env, ",") {
+			n, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				b.Fatalf("invalid %s: %v", benchNodesEnv, err)
+			}
+			sizes = append(sizes, n)
+		}
+	}
+	ctx := context.Background()
+	for _, n := range sizes {
+		b.Run(fmt.Sprintf("Nodes=%d", n), func(b *testing.B) {
+			st := badgerdb.NewTestStorage(false)
+			defer st.Close()
+			pop, err := Populate(ctx, st, Options{Nodes: n, ACLs: n / 100, Seed: 1})
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				report, err := Run(ctx, st, pop, RunOptions{Samples: 1, Seed: int64(i)})
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.ReportMetric(float64(report.FilterGraph.Mean.Microseconds()), "filter-us")
+				b.ReportMetric(float64(report.WireGuardPeers.Mean.Microseconds()), "peers-us")
+				if report.SnapshotError != "" && i == 0 {
+					b.Logf("graph snapshot not written: %s", report.SnapshotError)
+				}
+			}
+		})
+	}
+}
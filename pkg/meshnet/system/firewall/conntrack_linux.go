@@ -0,0 +1,68 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"bufio"
+	"io"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// nfConntrackFile is where the kernel exposes its connection tracking table.
+const nfConntrackFile = "/proc/net/nf_conntrack"
+
+// countEstablishedFlows returns the number of flows in the kernel connection tracking
+// table whose original source or destination falls in one of the given prefixes. Zero
+// is returned if the table is not available.
+func countEstablishedFlows(prefixes []netip.Prefix) int {
+	if len(prefixes) == 0 {
+		return 0
+	}
+	f, err := os.Open(nfConntrackFile)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	return countMatchingFlows(f, prefixes)
+}
+
+// countMatchingFlows counts the entries in an nf_conntrack formatted reader whose
+// original source or destination falls in one of the given prefixes.
+func countMatchingFlows(r io.Reader, prefixes []netip.Prefix) int {
+	var count int
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var src, dst netip.Addr
+		for _, field := range strings.Fields(scanner.Text()) {
+			// Only the first src and dst fields describe the original direction.
+			if v, ok := strings.CutPrefix(field, "src="); ok && !src.IsValid() {
+				src, _ = netip.ParseAddr(v)
+			} else if v, ok := strings.CutPrefix(field, "dst="); ok && !dst.IsValid() {
+				dst, _ = netip.ParseAddr(v)
+			}
+		}
+		for _, prefix := range prefixes {
+			if (src.IsValid() && prefix.Contains(src)) || (dst.IsValid() && prefix.Contains(dst)) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
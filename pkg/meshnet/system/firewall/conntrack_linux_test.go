@@ -0,0 +1,54 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func TestCountMatchingFlows(t *testing.T) {
+	t.Parallel()
+	table := strings.Join([]string{
+		"ipv4     2 tcp      6 431999 ESTABLISHED src=172.16.0.1 dst=172.16.0.2 sport=40000 dport=22 src=172.16.0.2 dst=172.16.0.1 sport=22 dport=40000 [ASSURED] mark=0 zone=0 use=2",
+		"ipv4     2 udp      17 29 src=172.16.0.3 dst=172.16.0.1 sport=5353 dport=53 src=172.16.0.1 dst=172.16.0.3 sport=53 dport=5353 mark=0 zone=0 use=2",
+		"ipv4     2 tcp      6 431999 ESTABLISHED src=192.168.1.10 dst=192.168.1.1 sport=50000 dport=443 src=192.168.1.1 dst=192.168.1.10 sport=443 dport=50000 [ASSURED] mark=0 zone=0 use=2",
+		"ipv6     10 tcp      6 431999 ESTABLISHED src=fd00::1 dst=fd00::2 sport=40000 dport=80 src=fd00::2 dst=fd00::1 sport=80 dport=40000 [ASSURED] mark=0 zone=0 use=2",
+	}, "\n")
+	tc := []struct {
+		name     string
+		prefixes []string
+		want     int
+	}{
+		{"NoPrefixes", nil, 0},
+		{"Destination", []string{"172.16.0.2/32"}, 1},
+		{"Source", []string{"172.16.0.3/32"}, 1},
+		{"Network", []string{"172.16.0.0/12"}, 2},
+		{"IPv6", []string{"fd00::2/128"}, 1},
+		{"Multiple", []string{"172.16.0.2/32", "fd00::/64"}, 2},
+	}
+	for _, tt := range tc {
+		var prefixes []netip.Prefix
+		for _, p := range tt.prefixes {
+			prefixes = append(prefixes, netip.MustParsePrefix(p))
+		}
+		if got := countMatchingFlows(strings.NewReader(table), prefixes); got != tt.want {
+			t.Errorf("%s: expected %d flows, got %d", tt.name, tt.want, got)
+		}
+	}
+}
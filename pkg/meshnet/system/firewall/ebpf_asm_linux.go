@@ -0,0 +1,178 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"golang.org/x/sys/unix"
+)
+
+// bpfReg is an eBPF register.
+type bpfReg uint8
+
+const (
+	r0 bpfReg = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+	r6
+	r7
+	r8
+	r9
+	r10
+)
+
+// bpfInsn is a single eBPF instruction. Jumps refer to a label that is
+// resolved to an offset when the program is assembled.
+type bpfInsn struct {
+	op    uint8
+	dst   bpfReg
+	src   bpfReg
+	off   int16
+	imm   int32
+	label string
+}
+
+// bpfAsm is a minimal assembler for the eBPF programs enforcing rules on
+// the wireguard interface. It only implements the instructions they use.
+type bpfAsm struct {
+	insns  []bpfInsn
+	labels map[string]int
+	nlabel int
+}
+
+func newBPFAsm() *bpfAsm {
+	return &bpfAsm{labels: make(map[string]int)}
+}
+
+// newLabel returns a label name that has not been used before.
+func (a *bpfAsm) newLabel(name string) string {
+	a.nlabel++
+	return fmt.Sprintf("%s_%d", name, a.nlabel)
+}
+
+// label marks the position of the next instruction with the given label.
+func (a *bpfAsm) label(name string) {
+	a.labels[name] = len(a.insns)
+}
+
+func (a *bpfAsm) emit(insn bpfInsn) {
+	a.insns = append(a.insns, insn)
+}
+
+// movImm sets dst to imm.
+func (a *bpfAsm) movImm(dst bpfReg, imm int32) {
+	a.emit(bpfInsn{op: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_K, dst: dst, imm: imm})
+}
+
+// movReg sets dst to src.
+func (a *bpfAsm) movReg(dst, src bpfReg) {
+	a.emit(bpfInsn{op: unix.BPF_ALU64 | unix.BPF_MOV | unix.BPF_X, dst: dst, src: src})
+}
+
+// alu64 applies the given operation with imm to dst.
+func (a *bpfAsm) alu64(op uint8, dst bpfReg, imm int32) {
+	a.emit(bpfInsn{op: unix.BPF_ALU64 | op | unix.BPF_K, dst: dst, imm: imm})
+}
+
+// alu32 applies the given operation with imm to the lower 32 bits of dst.
+func (a *bpfAsm) alu32(op uint8, dst bpfReg, imm int32) {
+	a.emit(bpfInsn{op: unix.BPF_ALU | op | unix.BPF_K, dst: dst, imm: imm})
+}
+
+// load loads size bytes at src+off into dst.
+func (a *bpfAsm) load(size uint8, dst, src bpfReg, off int16) {
+	a.emit(bpfInsn{op: unix.BPF_LDX | unix.BPF_MEM | size, dst: dst, src: src, off: off})
+}
+
+// store stores size bytes of src at dst+off.
+func (a *bpfAsm) store(size uint8, dst bpfReg, off int16, src bpfReg) {
+	a.emit(bpfInsn{op: unix.BPF_STX | unix.BPF_MEM | size, dst: dst, src: src, off: off})
+}
+
+// storeImm stores size bytes of imm at dst+off.
+func (a *bpfAsm) storeImm(size uint8, dst bpfReg, off int16, imm int32) {
+	a.emit(bpfInsn{op: unix.BPF_ST | unix.BPF_MEM | size, dst: dst, off: off, imm: imm})
+}
+
+// atomicAdd atomically adds src to the 64-bit value at dst+off.
+func (a *bpfAsm) atomicAdd(dst bpfReg, off int16, src bpfReg) {
+	a.emit(bpfInsn{op: unix.BPF_STX | unix.BPF_ATOMIC | unix.BPF_DW, dst: dst, src: src, off: off, imm: unix.BPF_ADD})
+}
+
+// jmp jumps to label when dst compares to imm with the given operation.
+func (a *bpfAsm) jmp(op uint8, dst bpfReg, imm int32, label string) {
+	a.emit(bpfInsn{op: unix.BPF_JMP | op | unix.BPF_K, dst: dst, imm: imm, label: label})
+}
+
+// jmp32 is like jmp, but only compares the lower 32 bits of dst.
+func (a *bpfAsm) jmp32(op uint8, dst bpfReg, imm int32, label string) {
+	a.emit(bpfInsn{op: unix.BPF_JMP32 | op | unix.BPF_K, dst: dst, imm: imm, label: label})
+}
+
+// ja jumps to label unconditionally.
+func (a *bpfAsm) ja(label string) {
+	a.emit(bpfInsn{op: unix.BPF_JMP | unix.BPF_JA, label: label})
+}
+
+// call calls the helper function with the given ID.
+func (a *bpfAsm) call(fn int32) {
+	a.emit(bpfInsn{op: unix.BPF_JMP | unix.BPF_CALL, imm: fn})
+}
+
+// exit returns from the program with the value of r0.
+func (a *bpfAsm) exit() {
+	a.emit(bpfInsn{op: unix.BPF_JMP | unix.BPF_EXIT})
+}
+
+// loadMap loads a reference to the map with the given file descriptor into dst.
+func (a *bpfAsm) loadMap(dst bpfReg, fd int) {
+	a.emit(bpfInsn{op: unix.BPF_LD | unix.BPF_IMM | unix.BPF_DW, dst: dst, src: unix.BPF_PSEUDO_MAP_FD, imm: int32(fd)})
+	a.emit(bpfInsn{})
+}
+
+// assemble resolves labels and encodes the program.
+func (a *bpfAsm) assemble() ([]byte, error) {
+	out := make([]byte, 0, len(a.insns)*8)
+	for pc, insn := range a.insns {
+		if insn.label != "" {
+			target, ok := a.labels[insn.label]
+			if !ok {
+				return nil, fmt.Errorf("undefined label %q", insn.label)
+			}
+			off := target - pc - 1
+			if off < math.MinInt16 || off > math.MaxInt16 {
+				return nil, fmt.Errorf("jump to %q is out of range, the program is too large", insn.label)
+			}
+			insn.off = int16(off)
+		}
+		regs := uint8(insn.src)<<4 | uint8(insn.dst)
+		if binary.NativeEndian.Uint16([]byte{0, 1}) == 1 {
+			// Register nibbles are swapped on big endian hosts.
+			regs = uint8(insn.dst)<<4 | uint8(insn.src)
+		}
+		out = append(out, insn.op, regs)
+		out = binary.NativeEndian.AppendUint16(out, uint16(insn.off))
+		out = binary.NativeEndian.AppendUint32(out, uint32(insn.imm))
+	}
+	return out, nil
+}
@@ -0,0 +1,686 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+const (
+	// Helper functions called by the programs.
+	bpfFuncMapLookupElem = 1
+	bpfFuncMapUpdateElem = 2
+	bpfFuncSkbLoadBytes  = 26
+	// Return codes of the programs.
+	tcActUnspec = -1
+	tcActShot   = 2
+	// Offsets of the buffers on the stack of the programs.
+	bpfKeyOff     = -40
+	bpfValueOff   = -72
+	bpfHeaderOff  = -112
+	bpfPortsOff   = -120
+	bpfDropKeyOff = -128
+	// Layout of the key of the flows map. Addresses and ports are
+	// in network byte order.
+	flowLocalAddr  = 0
+	flowRemoteAddr = 16
+	flowLocalPort  = 32
+	flowRemotePort = 34
+	flowProto      = 36
+	flowFamily     = 37
+	flowKeySize    = 40
+	// Layout of the value of the flows map.
+	flowRxPackets = 0
+	flowTxPackets = 16
+	flowValueSize = 32
+	// maxFlows is the number of flows tracked before the least recently
+	// used ones are evicted.
+	maxFlows = 65536
+)
+
+// withBackend wraps the netfilter firewall with the configured backend.
+func withBackend(ctx context.Context, opts *Options, nf Firewall) Firewall {
+	if opts.Backend == BackendEBPF {
+		return newEBPFFirewall(ctx, opts, nf)
+	}
+	return nf
+}
+
+// ebpfFirewall enforces denied prefixes and network ACLs with tc programs
+// attached to the wireguard interface. Everything else is left to netfilter.
+// Flows are tracked in a map shared by the ingress and egress programs, which
+// is what lets replies to outbound connections through the ACLs.
+type ebpfFirewall struct {
+	Firewall
+	opts   *Options
+	log    *slog.Logger
+	flows  int
+	drops  int
+	iface  string
+	denied []netip.Prefix
+	rules  []ACLRule
+	// fallback is set once the programs fail to load or attach, after
+	// which netfilter enforces the rules instead.
+	fallback bool
+	mu       sync.Mutex
+}
+
+// newEBPFFirewall returns an eBPF firewall wrapping the given netfilter firewall.
+// The netfilter firewall is returned as is when eBPF is not available.
+func newEBPFFirewall(ctx context.Context, opts *Options, nf Firewall) Firewall {
+	fw := &ebpfFirewall{
+		Firewall: nf,
+		opts:     opts,
+		log:      context.LoggerFrom(ctx).With(slog.String("component", "ebpf-firewall")),
+		flows:    -1,
+		drops:    -1,
+	}
+	err := fw.createMaps()
+	if err == nil {
+		// Make sure the programs pass the verifier before committing to them.
+		var fd int
+		fd, err = fw.loadProgram(true)
+		if err == nil {
+			unix.Close(fd)
+		}
+	}
+	if err != nil {
+		fw.log.Warn("eBPF firewall backend is not available, falling back to netfilter", slog.String("error", err.Error()))
+		fw.closeMaps()
+		return nf
+	}
+	return fw
+}
+
+// SetDeniedPrefixes replaces the set of prefixes whose traffic is dropped on the wireguard
+// interface, including packets belonging to already established flows.
+func (fw *ebpfFirewall) SetDeniedPrefixes(ctx context.Context, ifaceName string, prefixes []netip.Prefix) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.fallback {
+		return fw.Firewall.SetDeniedPrefixes(ctx, ifaceName, prefixes)
+	}
+	fw.denied = prefixes
+	if err := fw.attach(ctx, ifaceName); err != nil {
+		if fw.fallback {
+			return fw.Firewall.SetDeniedPrefixes(ctx, ifaceName, prefixes)
+		}
+		return 0, err
+	}
+	return fw.countFlows(prefixes), nil
+}
+
+// SetACLRules replaces the set of rules dropping new connections arriving on the
+// wireguard interface.
+func (fw *ebpfFirewall) SetACLRules(ctx context.Context, ifaceName string, rules []ACLRule) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.fallback {
+		return fw.Firewall.SetACLRules(ctx, ifaceName, rules)
+	}
+	fw.rules = rules
+	return fw.attach(ctx, ifaceName)
+}
+
+// Flows returns the counters of the flows currently tracked.
+func (fw *ebpfFirewall) Flows(ctx context.Context) ([]Flow, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.flows < 0 {
+		return nil, nil
+	}
+	var out []Flow
+	key := make([]byte, flowKeySize)
+	value := make([]byte, flowValueSize)
+	var prev []byte
+	for {
+		err := bpfMapNextKey(fw.flows, prev, key)
+		if errors.Is(err, unix.ENOENT) {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("iterate flows: %w", err)
+		}
+		prev = append(prev[:0], key...)
+		if err := bpfMapLookup(fw.flows, key, value); err != nil {
+			// The flow was evicted while iterating.
+			continue
+		}
+		out = append(out, parseFlow(key, value))
+	}
+}
+
+// DroppedPackets returns the number of packets dropped on the wireguard
+// interface since the firewall was created.
+func (fw *ebpfFirewall) DroppedPackets(ctx context.Context) (uint64, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.drops < 0 {
+		return 0, nil
+	}
+	value := make([]byte, 8)
+	if err := bpfMapLookup(fw.drops, make([]byte, 4), value); err != nil {
+		return 0, fmt.Errorf("lookup dropped packets: %w", err)
+	}
+	return binary.NativeEndian.Uint64(value), nil
+}
+
+// Clear should clear any changes made to the firewall.
+func (fw *ebpfFirewall) Clear(ctx context.Context) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.denied, fw.rules = nil, nil
+	return errors.Join(fw.detach(), fw.Firewall.Clear(ctx))
+}
+
+// Close should close any resources used by the firewall. It should also perform a Clear.
+func (fw *ebpfFirewall) Close(ctx context.Context) error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	err := errors.Join(fw.detach(), fw.Firewall.Close(ctx))
+	fw.closeMaps()
+	return err
+}
+
+// attach loads programs for the current rules and attaches them to the given
+// interface, replacing any attached before. When this fails the rules are
+// handed to netfilter and the fallback flag is set.
+func (fw *ebpfFirewall) attach(ctx context.Context, ifaceName string) error {
+	ingress, err := fw.loadProgram(true)
+	if err == nil {
+		var egress int
+		egress, err = fw.loadProgram(false)
+		if err == nil {
+			err = fw.inNetNS(func() error {
+				return attachPrograms(ifaceName, ingress, egress)
+			})
+			unix.Close(egress)
+		}
+		unix.Close(ingress)
+	}
+	if err == nil {
+		fw.iface = ifaceName
+		return nil
+	}
+	fw.log.Warn("Failed to attach eBPF programs, falling back to netfilter", slog.String("error", err.Error()))
+	fw.fallback = true
+	fw.iface = ifaceName
+	if err := fw.detach(); err != nil {
+		fw.log.Warn("Failed to detach eBPF programs", slog.String("error", err.Error()))
+	}
+	_, err = fw.Firewall.SetDeniedPrefixes(ctx, ifaceName, fw.denied)
+	return errors.Join(err, fw.Firewall.SetACLRules(ctx, ifaceName, fw.rules))
+}
+
+// detach removes the programs from the interface they are attached to.
+func (fw *ebpfFirewall) detach() error {
+	if fw.iface == "" {
+		return nil
+	}
+	iface := fw.iface
+	fw.iface = ""
+	return fw.inNetNS(func() error {
+		link, err := netlink.LinkByName(iface)
+		if err != nil {
+			// The interface is already gone along with its programs.
+			return nil
+		}
+		err = netlink.QdiscDel(clsactQdisc(link.Attrs().Index))
+		if err != nil && !errors.Is(err, unix.ENOENT) && !errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("delete clsact qdisc: %w", err)
+		}
+		return nil
+	})
+}
+
+func (fw *ebpfFirewall) inNetNS(fn func() error) error {
+	if fw.opts.NetNs == "" {
+		return fn()
+	}
+	netns, err := ns.GetNS(fw.opts.NetNs)
+	if err != nil {
+		return fmt.Errorf("failed to get netns: %w", err)
+	}
+	defer netns.Close()
+	return netns.Do(func(_ ns.NetNS) error {
+		return fn()
+	})
+}
+
+func (fw *ebpfFirewall) loadProgram(ingress bool) (int, error) {
+	insns, err := buildBPFProgram(ingress, fw.flows, fw.drops, fw.denied, fw.rules)
+	if err != nil {
+		return -1, fmt.Errorf("build program: %w", err)
+	}
+	return bpfLoadProgram(insns)
+}
+
+func (fw *ebpfFirewall) createMaps() error {
+	var err error
+	fw.flows, err = bpfCreateMap(unix.BPF_MAP_TYPE_LRU_HASH, flowKeySize, flowValueSize, maxFlows)
+	if err != nil {
+		return fmt.Errorf("create flows map: %w", err)
+	}
+	fw.drops, err = bpfCreateMap(unix.BPF_MAP_TYPE_ARRAY, 4, 8, 1)
+	if err != nil {
+		return fmt.Errorf("create drops map: %w", err)
+	}
+	return nil
+}
+
+func (fw *ebpfFirewall) closeMaps() {
+	for _, fd := range []*int{&fw.flows, &fw.drops} {
+		if *fd >= 0 {
+			unix.Close(*fd)
+			*fd = -1
+		}
+	}
+}
+
+// countFlows returns the number of tracked flows with a remote address in
+// one of the given prefixes.
+func (fw *ebpfFirewall) countFlows(prefixes []netip.Prefix) int {
+	if len(prefixes) == 0 {
+		return 0
+	}
+	var count int
+	key := make([]byte, flowKeySize)
+	var prev []byte
+	for bpfMapNextKey(fw.flows, prev, key) == nil {
+		prev = append(prev[:0], key...)
+		remote := parseFlow(key, make([]byte, flowValueSize)).Remote.Addr()
+		for _, prefix := range prefixes {
+			if prefix.Contains(remote) {
+				count++
+				break
+			}
+		}
+	}
+	return count
+}
+
+func clsactQdisc(linkIndex int) *netlink.GenericQdisc {
+	return &netlink.GenericQdisc{
+		QdiscAttrs: netlink.QdiscAttrs{
+			LinkIndex: linkIndex,
+			Handle:    netlink.MakeHandle(0xffff, 0),
+			Parent:    netlink.HANDLE_CLSACT,
+		},
+		QdiscType: "clsact",
+	}
+}
+
+// attachPrograms attaches the given programs to the ingress and egress hooks
+// of the interface, replacing the programs attached before.
+func attachPrograms(ifaceName string, ingress, egress int) error {
+	link, err := netlink.LinkByName(ifaceName)
+	if err != nil {
+		return fmt.Errorf("get link %s: %w", ifaceName, err)
+	}
+	if err := netlink.QdiscReplace(clsactQdisc(link.Attrs().Index)); err != nil {
+		return fmt.Errorf("add clsact qdisc: %w", err)
+	}
+	for _, hook := range []struct {
+		parent uint32
+		fd     int
+		name   string
+	}{
+		{netlink.HANDLE_MIN_INGRESS, ingress, "webmesh_ingress"},
+		{netlink.HANDLE_MIN_EGRESS, egress, "webmesh_egress"},
+	} {
+		err := netlink.FilterReplace(&netlink.BpfFilter{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: link.Attrs().Index,
+				Parent:    hook.parent,
+				Handle:    netlink.MakeHandle(0, 1),
+				Protocol:  unix.ETH_P_ALL,
+				Priority:  1,
+			},
+			Fd:           hook.fd,
+			Name:         hook.name,
+			DirectAction: true,
+		})
+		if err != nil {
+			return fmt.Errorf("attach %s program: %w", hook.name, err)
+		}
+	}
+	return nil
+}
+
+// buildBPFProgram returns the tc program for one direction of the wireguard
+// interface. Packets from or to denied prefixes are dropped in both directions.
+// Inbound packets that do not belong to a tracked flow are checked against the
+// ACL rules, and every packet that is let through is counted towards its flow.
+// Packets on the interface start at the IP header.
+func buildBPFProgram(ingress bool, flowsFD, dropsFD int, denied []netip.Prefix, rules []ACLRule) ([]byte, error) {
+	a := newBPFAsm()
+	pass, drop, track, count := a.newLabel("pass"), a.newLabel("drop"), a.newLabel("track"), a.newLabel("count")
+	// The flow key holds the local end first, so the source of an inbound
+	// packet is stored as the remote end.
+	srcAddr, dstAddr, srcPort, dstPort := int16(flowLocalAddr), int16(flowRemoteAddr), int16(flowLocalPort), int16(flowRemotePort)
+	counters := int16(flowTxPackets)
+	if ingress {
+		srcAddr, dstAddr, srcPort, dstPort = dstAddr, srcAddr, dstPort, srcPort
+		counters = flowRxPackets
+	}
+	loadBytes := func(offset bpfReg, to int16, n int32) {
+		a.movReg(r1, r6)
+		a.movReg(r2, offset)
+		a.movReg(r3, r10)
+		a.alu64(unix.BPF_ADD, r3, int32(to))
+		a.movImm(r4, n)
+		a.call(bpfFuncSkbLoadBytes)
+	}
+	copyWords := func(from, to int16, n int) {
+		for i := int16(0); i < int16(n)*4; i += 4 {
+			a.load(unix.BPF_W, r1, r10, from+i)
+			a.store(unix.BPF_W, r10, to+i, r1)
+		}
+	}
+
+	// r6 holds the context, r7 the protocol, r8 the offset of the
+	// transport header and r9 the length of the packet.
+	a.movReg(r6, r1)
+	a.load(unix.BPF_W, r9, r6, 0)
+	for off := int16(bpfDropKeyOff); off < 0; off += 8 {
+		a.storeImm(unix.BPF_DW, r10, off, 0)
+	}
+	a.movImm(r8, 0)
+	loadBytes(r8, bpfHeaderOff, 1)
+	a.jmp(unix.BPF_JNE, r0, 0, pass)
+	ipv4, ipv6, l4, ports, l4done := a.newLabel("ipv4"), a.newLabel("ipv6"), a.newLabel("l4"), a.newLabel("ports"), a.newLabel("l4done")
+	a.load(unix.BPF_B, r1, r10, bpfHeaderOff)
+	a.alu64(unix.BPF_RSH, r1, 4)
+	a.jmp(unix.BPF_JEQ, r1, 4, ipv4)
+	a.jmp(unix.BPF_JEQ, r1, 6, ipv6)
+	a.ja(pass)
+
+	a.label(ipv4)
+	loadBytes(r8, bpfHeaderOff, 20)
+	a.jmp(unix.BPF_JNE, r0, 0, pass)
+	a.load(unix.BPF_B, r7, r10, bpfHeaderOff+9)
+	a.load(unix.BPF_B, r8, r10, bpfHeaderOff)
+	a.alu64(unix.BPF_AND, r8, 0xf)
+	a.alu64(unix.BPF_LSH, r8, 2)
+	copyWords(bpfHeaderOff+12, bpfKeyOff+srcAddr, 1)
+	copyWords(bpfHeaderOff+16, bpfKeyOff+dstAddr, 1)
+	a.storeImm(unix.BPF_B, r10, bpfKeyOff+flowFamily, 4)
+	a.store(unix.BPF_B, r10, bpfKeyOff+flowProto, r7)
+	// Only the first fragment of a packet carries the ports.
+	a.load(unix.BPF_H, r1, r10, bpfHeaderOff+6)
+	a.alu32(unix.BPF_AND, r1, int32(binary.NativeEndian.Uint16([]byte{0x1f, 0xff})))
+	a.jmp(unix.BPF_JNE, r1, 0, l4done)
+	a.ja(l4)
+
+	a.label(ipv6)
+	loadBytes(r8, bpfHeaderOff, 40)
+	a.jmp(unix.BPF_JNE, r0, 0, pass)
+	a.load(unix.BPF_B, r7, r10, bpfHeaderOff+6)
+	a.movImm(r8, 40)
+	copyWords(bpfHeaderOff+8, bpfKeyOff+srcAddr, 4)
+	copyWords(bpfHeaderOff+24, bpfKeyOff+dstAddr, 4)
+	a.storeImm(unix.BPF_B, r10, bpfKeyOff+flowFamily, 6)
+	a.store(unix.BPF_B, r10, bpfKeyOff+flowProto, r7)
+
+	a.label(l4)
+	a.jmp(unix.BPF_JEQ, r7, unix.IPPROTO_TCP, ports)
+	a.jmp(unix.BPF_JEQ, r7, unix.IPPROTO_UDP, ports)
+	a.ja(l4done)
+	a.label(ports)
+	loadBytes(r8, bpfPortsOff, 4)
+	a.jmp(unix.BPF_JNE, r0, 0, l4done)
+	a.load(unix.BPF_H, r1, r10, bpfPortsOff)
+	a.store(unix.BPF_H, r10, bpfKeyOff+srcPort, r1)
+	a.load(unix.BPF_H, r1, r10, bpfPortsOff+2)
+	a.store(unix.BPF_H, r10, bpfKeyOff+dstPort, r1)
+	a.label(l4done)
+
+	for _, prefix := range denied {
+		next := a.newLabel("denied")
+		bpfMatchPrefix(a, flowRemoteAddr, prefix, next)
+		a.ja(drop)
+		a.label(next)
+	}
+
+	// Packets of tracked flows are counted and let through.
+	lookupFlow := func() {
+		a.loadMap(r1, flowsFD)
+		a.movReg(r2, r10)
+		a.alu64(unix.BPF_ADD, r2, bpfKeyOff)
+		a.call(bpfFuncMapLookupElem)
+	}
+	lookupFlow()
+	a.jmp(unix.BPF_JNE, r0, 0, count)
+	if ingress {
+		for _, rule := range rules {
+			next := a.newLabel("rule")
+			bpfMatchPrefix(a, flowRemoteAddr, rule.Source, next)
+			bpfMatchPrefix(a, flowLocalAddr, rule.Destination, next)
+			if rule.AllowICMP {
+				proto := int32(unix.IPPROTO_ICMP)
+				if rule.Source.Addr().Is6() {
+					proto = unix.IPPROTO_ICMPV6
+				}
+				a.jmp(unix.BPF_JEQ, r7, proto, track)
+			}
+			if rule.AllowPort != 0 {
+				port := a.newLabel("port")
+				a.jmp(unix.BPF_JEQ, r7, unix.IPPROTO_TCP, port)
+				a.jmp(unix.BPF_JEQ, r7, unix.IPPROTO_UDP, port)
+				a.ja(drop)
+				a.label(port)
+				a.load(unix.BPF_H, r1, r10, bpfKeyOff+flowLocalPort)
+				a.jmp(unix.BPF_JEQ, r1, int32(binary.NativeEndian.Uint16(binary.BigEndian.AppendUint16(nil, rule.AllowPort))), track)
+			}
+			a.ja(drop)
+			a.label(next)
+		}
+	}
+
+	// Start tracking the flow.
+	a.label(track)
+	a.loadMap(r1, flowsFD)
+	a.movReg(r2, r10)
+	a.alu64(unix.BPF_ADD, r2, bpfKeyOff)
+	a.movReg(r3, r10)
+	a.alu64(unix.BPF_ADD, r3, bpfValueOff)
+	a.movImm(r4, unix.BPF_NOEXIST)
+	a.call(bpfFuncMapUpdateElem)
+	lookupFlow()
+	a.jmp(unix.BPF_JEQ, r0, 0, pass)
+
+	a.label(count)
+	a.movImm(r1, 1)
+	a.atomicAdd(r0, counters, r1)
+	a.atomicAdd(r0, counters+8, r9)
+	a.ja(pass)
+
+	if len(denied) > 0 || (ingress && len(rules) > 0) {
+		shot := a.newLabel("shot")
+		a.label(drop)
+		a.loadMap(r1, dropsFD)
+		a.movReg(r2, r10)
+		a.alu64(unix.BPF_ADD, r2, bpfDropKeyOff)
+		a.call(bpfFuncMapLookupElem)
+		a.jmp(unix.BPF_JEQ, r0, 0, shot)
+		a.movImm(r1, 1)
+		a.atomicAdd(r0, 0, r1)
+		a.label(shot)
+		a.movImm(r0, tcActShot)
+		a.exit()
+	}
+
+	// Let the filters after the program, such as rate limits, see the
+	// packets it passes.
+	a.label(pass)
+	a.movImm(r0, tcActUnspec)
+	a.exit()
+	return a.assemble()
+}
+
+// bpfMatchPrefix jumps to miss unless the address at the given field of the
+// flow key is within the prefix.
+func bpfMatchPrefix(a *bpfAsm, field int16, prefix netip.Prefix, miss string) {
+	family := int32(4)
+	if prefix.Addr().Is6() {
+		family = 6
+	}
+	a.load(unix.BPF_B, r1, r10, bpfKeyOff+flowFamily)
+	a.jmp(unix.BPF_JNE, r1, family, miss)
+	addr := prefix.Masked().Addr().AsSlice()
+	mask := net.CIDRMask(prefix.Bits(), len(addr)*8)
+	for i := 0; i < len(addr); i += 4 {
+		m := binary.NativeEndian.Uint32(mask[i:])
+		if m == 0 {
+			break
+		}
+		a.load(unix.BPF_W, r1, r10, bpfKeyOff+field+int16(i))
+		a.alu32(unix.BPF_AND, r1, int32(m))
+		a.jmp32(unix.BPF_JNE, r1, int32(binary.NativeEndian.Uint32(addr[i:])), miss)
+	}
+}
+
+// parseFlow parses a key and value of the flows map.
+func parseFlow(key, value []byte) Flow {
+	addr := func(off int) netip.Addr {
+		if key[flowFamily] == 4 {
+			return netip.AddrFrom4([4]byte(key[off : off+4]))
+		}
+		return netip.AddrFrom16([16]byte(key[off : off+16]))
+	}
+	return Flow{
+		Local:     netip.AddrPortFrom(addr(flowLocalAddr), binary.BigEndian.Uint16(key[flowLocalPort:])),
+		Remote:    netip.AddrPortFrom(addr(flowRemoteAddr), binary.BigEndian.Uint16(key[flowRemotePort:])),
+		Protocol:  key[flowProto],
+		RxPackets: binary.NativeEndian.Uint64(value[0:]),
+		RxBytes:   binary.NativeEndian.Uint64(value[8:]),
+		TxPackets: binary.NativeEndian.Uint64(value[16:]),
+		TxBytes:   binary.NativeEndian.Uint64(value[24:]),
+	}
+}
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (int, error) {
+	fd, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+func bpfCreateMap(mapType, keySize, valueSize, maxEntries uint32) (int, error) {
+	attr := struct {
+		mapType    uint32
+		keySize    uint32
+		valueSize  uint32
+		maxEntries uint32
+		mapFlags   uint32
+	}{mapType, keySize, valueSize, maxEntries, 0}
+	return bpf(unix.BPF_MAP_CREATE, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+}
+
+type bpfMapElemAttr struct {
+	mapFD uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+func bpfMapLookup(fd int, key, value []byte) error {
+	attr := bpfMapElemAttr{
+		mapFD: uint32(fd),
+		key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+		value: uint64(uintptr(unsafe.Pointer(&value[0]))),
+	}
+	_, err := bpf(unix.BPF_MAP_LOOKUP_ELEM, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(value)
+	return err
+}
+
+// bpfMapNextKey writes the key following key into next, or the first key
+// when key is nil. It returns ENOENT after the last key.
+func bpfMapNextKey(fd int, key, next []byte) error {
+	attr := bpfMapElemAttr{
+		mapFD: uint32(fd),
+		value: uint64(uintptr(unsafe.Pointer(&next[0]))),
+	}
+	if key != nil {
+		attr.key = uint64(uintptr(unsafe.Pointer(&key[0])))
+	}
+	_, err := bpf(unix.BPF_MAP_GET_NEXT_KEY, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(next)
+	return err
+}
+
+// bpfLoadProgram loads a tc classifier. The verifier log is included in the
+// error when the program is rejected.
+func bpfLoadProgram(insns []byte) (int, error) {
+	license := []byte("Apache-2.0\x00")
+	attr := struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		progFlags   uint32
+		progName    [unix.BPF_OBJ_NAME_LEN]byte
+	}{
+		progType: unix.BPF_PROG_TYPE_SCHED_CLS,
+		insnCnt:  uint32(len(insns) / 8),
+		insns:    uint64(uintptr(unsafe.Pointer(&insns[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+	}
+	copy(attr.progName[:], "webmesh_fw")
+	fd, err := bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil && !errors.Is(err, unix.EPERM) {
+		logBuf := make([]byte, 64*1024)
+		attr.logLevel = 1
+		attr.logSize = uint32(len(logBuf))
+		attr.logBuf = uint64(uintptr(unsafe.Pointer(&logBuf[0])))
+		fd, err = bpf(unix.BPF_PROG_LOAD, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+		if n := bytes.IndexByte(logBuf, 0); err != nil && n > 0 {
+			err = fmt.Errorf("%w: %s", err, logBuf[:n])
+		}
+		runtime.KeepAlive(logBuf)
+	}
+	runtime.KeepAlive(insns)
+	runtime.KeepAlive(license)
+	if err != nil {
+		return -1, fmt.Errorf("load program: %w", err)
+	}
+	return fd, nil
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestBuildBPFProgram(t *testing.T) {
+	t.Parallel()
+	flows, err := bpfCreateMap(unix.BPF_MAP_TYPE_LRU_HASH, flowKeySize, flowValueSize, 16)
+	if errors.Is(err, unix.EPERM) || errors.Is(err, unix.ENOSYS) {
+		t.Skipf("eBPF is not available: %v", err)
+	}
+	if err != nil {
+		t.Fatalf("create flows map: %v", err)
+	}
+	defer unix.Close(flows)
+	drops, err := bpfCreateMap(unix.BPF_MAP_TYPE_ARRAY, 4, 8, 1)
+	if err != nil {
+		t.Fatalf("create drops map: %v", err)
+	}
+	defer unix.Close(drops)
+	tc := []struct {
+		name   string
+		denied []netip.Prefix
+		rules  []ACLRule
+	}{
+		{
+			name: "no rules",
+		},
+		{
+			name:   "denied prefixes",
+			denied: []netip.Prefix{netip.MustParsePrefix("172.16.0.0/24"), netip.MustParsePrefix("fd00::/64")},
+		},
+		{
+			name: "acl rules",
+			rules: []ACLRule{
+				{
+					Source:      netip.MustParsePrefix("172.16.0.2/32"),
+					Destination: netip.MustParsePrefix("172.16.0.1/32"),
+				},
+				{
+					Source:      netip.MustParsePrefix("fd00::2/128"),
+					Destination: netip.MustParsePrefix("fd00::/48"),
+					AllowICMP:   true,
+					AllowPort:   53,
+				},
+				{
+					Source:      netip.MustParsePrefix("0.0.0.0/0"),
+					Destination: netip.MustParsePrefix("10.0.0.0/8"),
+					AllowPort:   443,
+				},
+			},
+		},
+	}
+	for _, tt := range tc {
+		for _, ingress := range []bool{true, false} {
+			insns, err := buildBPFProgram(ingress, flows, drops, tt.denied, tt.rules)
+			if err != nil {
+				t.Fatalf("%s: build program: %v", tt.name, err)
+			}
+			fd, err := bpfLoadProgram(insns)
+			if err != nil {
+				t.Errorf("%s: load program (ingress=%v): %v", tt.name, ingress, err)
+				continue
+			}
+			unix.Close(fd)
+		}
+	}
+}
@@ -19,6 +19,7 @@ package firewall
 
 import (
 	"context"
+	"fmt"
 	"net/netip"
 )
 
@@ -28,12 +29,119 @@ type Firewall interface {
 	AddWireguardForwarding(ctx context.Context, ifaceName string) error
 	// AddMasquerade should configure the firewall to masquerade outbound traffic on the wireguard interface.
 	AddMasquerade(ctx context.Context, ifaceName string) error
+	// AddSourceMasquerade should configure the firewall to masquerade traffic from the given
+	// source prefix as it leaves the host.
+	AddSourceMasquerade(ctx context.Context, prefix netip.Prefix) error
+	// AddEgressMasquerade should configure the firewall to masquerade traffic from the given
+	// source prefix as it leaves the host on any interface other than the wireguard interface.
+	AddEgressMasquerade(ctx context.Context, ifaceName string, prefix netip.Prefix) error
+	// SetDeniedPrefixes should replace the set of prefixes whose traffic is dropped on the wireguard
+	// interface, including packets belonging to already established flows. It returns the number of
+	// established flows matching the denied prefixes, or zero if this cannot be determined.
+	SetDeniedPrefixes(ctx context.Context, ifaceName string, prefixes []netip.Prefix) (int, error)
+	// SetACLRules should replace the set of rules dropping new connections arriving on the
+	// wireguard interface.
+	SetACLRules(ctx context.Context, ifaceName string, rules []ACLRule) error
+	// SetRateLimits should replace the set of bandwidth limits on traffic sent to and received
+	// from prefixes on the wireguard interface.
+	SetRateLimits(ctx context.Context, ifaceName string, limits []RateLimit) error
 	// Clear should clear any changes made to the firewall.
 	Clear(ctx context.Context) error
 	// Close should close any resources used by the firewall. It should also perform a Clear.
 	Close(ctx context.Context) error
 }
 
+// ACLRule drops new connections from Source to Destination arriving on the
+// wireguard interface. Replies to connections made in the other direction are
+// not affected.
+type ACLRule struct {
+	// Source is the prefix connections are dropped from.
+	Source netip.Prefix
+	// Destination is the prefix connections are dropped to.
+	Destination netip.Prefix
+	// AllowICMP accepts ICMP from Source to Destination despite the rule.
+	AllowICMP bool
+	// AllowPort, if not zero, is a TCP and UDP destination port accepted
+	// despite the rule.
+	AllowPort uint16
+}
+
+// String returns a description of the rule.
+func (r ACLRule) String() string {
+	return fmt.Sprintf("%s -> %s", r.Source, r.Destination)
+}
+
+// RateLimit limits the bandwidth of traffic between the wireguard interface
+// and a prefix. Traffic sent to the prefix is shaped and traffic received
+// from it beyond the rate is dropped.
+type RateLimit struct {
+	// Prefix is the prefix traffic is limited to and from.
+	Prefix netip.Prefix
+	// Rate is the guaranteed rate in bits per second.
+	Rate uint64
+	// Ceil is the rate in bits per second traffic sent to the prefix can
+	// borrow up to when the interface is not saturated. It defaults to Rate.
+	Ceil uint64
+}
+
+// String returns a description of the limit.
+func (l RateLimit) String() string {
+	return fmt.Sprintf("%s %d/%d bit/s", l.Prefix, l.Rate, l.Ceil)
+}
+
+// Flow holds the counters of a flow crossing the wireguard interface. Flows
+// are keyed from the point of view of this node, so Local is the address on
+// this side of the interface regardless of which end opened the flow.
+type Flow struct {
+	// Local is the local address and port of the flow.
+	Local netip.AddrPort
+	// Remote is the remote address and port of the flow.
+	Remote netip.AddrPort
+	// Protocol is the IP protocol number of the flow.
+	Protocol uint8
+	// RxPackets is the number of packets received on the flow.
+	RxPackets uint64
+	// RxBytes is the number of bytes received on the flow.
+	RxBytes uint64
+	// TxPackets is the number of packets sent on the flow.
+	TxPackets uint64
+	// TxBytes is the number of bytes sent on the flow.
+	TxBytes uint64
+}
+
+// FlowCounter is implemented by firewalls that count the traffic of each
+// flow crossing the wireguard interface.
+type FlowCounter interface {
+	// Flows returns the counters of the flows currently tracked.
+	Flows(ctx context.Context) ([]Flow, error)
+	// DroppedPackets returns the number of packets dropped on the wireguard
+	// interface since the firewall was created.
+	DroppedPackets(ctx context.Context) (uint64, error)
+}
+
+// Backend is the mechanism used to enforce rules on the wireguard interface.
+type Backend string
+
+const (
+	// BackendNetfilter enforces rules with nftables, falling back to iptables
+	// when nftables is not available. It is the default on Linux.
+	BackendNetfilter Backend = "netfilter"
+	// BackendEBPF enforces denied prefixes and network ACLs with eBPF programs
+	// attached to the wireguard interface with tc, and counts the traffic of
+	// each flow. Forwarding and masquerading are still configured with netfilter.
+	// It falls back to netfilter when the programs cannot be loaded.
+	BackendEBPF Backend = "ebpf"
+)
+
+// IsValid returns true if the backend is known. The empty backend is the default.
+func (b Backend) IsValid() bool {
+	switch b {
+	case "", BackendNetfilter, BackendEBPF:
+		return true
+	}
+	return false
+}
+
 // Policy is a firewall policy.
 type Policy string
 
@@ -61,6 +169,9 @@ type Options struct {
 	StoragePort uint16
 	// GRPCPort is the port to allow for grpc traffic.
 	GRPCPort uint16
+	// Backend is the backend used to enforce denied prefixes and network ACLs.
+	// This is only applicable on Linux, and defaults to BackendNetfilter.
+	Backend Backend
 }
 
 // New returns a new firewall manager for the given options.
@@ -19,6 +19,7 @@ package firewall
 import (
 	"context"
 	"fmt"
+	"net/netip"
 	"os"
 	"strings"
 
@@ -91,6 +92,77 @@ func (pf *pfctlFirewall) AddMasquerade(ctx context.Context, ifaceName string) er
 	return err
 }
 
+// AddSourceMasquerade is not implemented on darwin.
+func (pf *pfctlFirewall) AddSourceMasquerade(ctx context.Context, prefix netip.Prefix) error {
+	return fmt.Errorf("masquerading traffic from %s is not supported on darwin", prefix)
+}
+
+// AddEgressMasquerade is not implemented on darwin.
+func (pf *pfctlFirewall) AddEgressMasquerade(ctx context.Context, ifaceName string, prefix netip.Prefix) error {
+	return fmt.Errorf("masquerading traffic from %s is not supported on darwin", prefix)
+}
+
+// SetDeniedPrefixes is not implemented on darwin. Traffic for denied prefixes is
+// still cut by removing them from the WireGuard allowed IPs.
+func (pf *pfctlFirewall) SetDeniedPrefixes(ctx context.Context, ifaceName string, prefixes []netip.Prefix) (int, error) {
+	return 0, nil
+}
+
+// SetACLRules should replace the set of rules dropping new connections arriving on the
+// wireguard interface. pf matches replies against the state of their connection before
+// evaluating rules, so only new connections are blocked.
+func (pf *pfctlFirewall) SetACLRules(ctx context.Context, ifaceName string, rules []ACLRule) error {
+	data, err := os.ReadFile(pf.anchorFile)
+	if err != nil {
+		return fmt.Errorf("read anchor file: %w", err)
+	}
+	var lines []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" || strings.HasSuffix(line, aclRuleMarker) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	for _, rule := range rules {
+		lines = append(lines, pfACLRules(ifaceName, rule)...)
+	}
+	err = os.WriteFile(pf.anchorFile, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("write anchor file: %w", err)
+	}
+	// Reload pfctl
+	return common.Exec(ctx, "pfctl", "-f", pf.anchorFile)
+}
+
+// SetRateLimits is not supported on darwin.
+func (pf *pfctlFirewall) SetRateLimits(ctx context.Context, ifaceName string, limits []RateLimit) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	return fmt.Errorf("rate limits are not supported on darwin")
+}
+
+// aclRuleMarker marks the lines of the anchor file rendered from network ACLs.
+const aclRuleMarker = "# webmesh-acl"
+
+// pfACLRules renders an ACL rule. Quick rules stop evaluation at the first
+// match, so the exemptions come before the block.
+func pfACLRules(ifaceName string, rule ACLRule) []string {
+	match := fmt.Sprintf("from %s to %s", rule.Source.Masked(), rule.Destination.Masked())
+	var out []string
+	if rule.AllowICMP {
+		icmp := "icmp"
+		if rule.Source.Addr().Is6() {
+			icmp = "icmp6"
+		}
+		out = append(out, fmt.Sprintf("pass in quick on %s proto %s %s %s", ifaceName, icmp, match, aclRuleMarker))
+	}
+	if rule.AllowPort != 0 {
+		out = append(out, fmt.Sprintf("pass in quick on %s proto { tcp udp } %s port %d %s", ifaceName, match, rule.AllowPort, aclRuleMarker))
+	}
+	return append(out, fmt.Sprintf("block in quick on %s %s %s", ifaceName, match, aclRuleMarker))
+}
+
 // Clear should clear any changes made to the firewall.
 func (pf *pfctlFirewall) Clear(ctx context.Context) error {
 	// Clear the anchor file
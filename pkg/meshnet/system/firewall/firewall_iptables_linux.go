@@ -19,7 +19,10 @@ package firewall
 import (
 	"fmt"
 	"log/slog"
+	"net/netip"
 	"os/exec"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
@@ -29,9 +32,10 @@ import (
 // is technically not safe for use with multiple interfaces. The Close method may restore
 // rules from another interface. But documentation should push people to use nftables instead.
 // This is just a fallback.
-func newIPTablesFirewall(ctx context.Context, _ *Options) (Firewall, error) {
+func newIPTablesFirewall(ctx context.Context, opts *Options) (Firewall, error) {
 	fw := &iptablesFirewall{
-		log: context.LoggerFrom(ctx).With(slog.String("component", "iptables-firewall")),
+		log:    context.LoggerFrom(ctx).With(slog.String("component", "iptables-firewall")),
+		limits: rateLimiter{netns: opts.NetNs},
 	}
 	var initialRules []string
 	rules, err := fw.execOutput(context.Background(), "-S")
@@ -46,6 +50,9 @@ func newIPTablesFirewall(ctx context.Context, _ *Options) (Firewall, error) {
 type iptablesFirewall struct {
 	log          *slog.Logger
 	initialRules []string
+	denied       [][]string
+	acls         [][]string
+	limits       rateLimiter
 }
 
 // AddWireguardForwarding should configure the firewall to allow forwarding traffic on the wireguard interface.
@@ -58,8 +65,116 @@ func (fw *iptablesFirewall) AddMasquerade(ctx context.Context, ifaceName string)
 	return fw.exec(ctx, "-t", "nat", "-A", "POSTROUTING", "-o", ifaceName, "-j", "MASQUERADE")
 }
 
+// AddSourceMasquerade should configure the firewall to masquerade traffic from the given
+// source prefix as it leaves the host.
+func (fw *iptablesFirewall) AddSourceMasquerade(ctx context.Context, prefix netip.Prefix) error {
+	cmd := "iptables"
+	if prefix.Addr().Is6() {
+		cmd = "ip6tables"
+	}
+	return fw.execCmd(ctx, cmd, "-t", "nat", "-A", "POSTROUTING", "-s", prefix.Masked().String(), "-j", "MASQUERADE")
+}
+
+// AddEgressMasquerade should configure the firewall to masquerade traffic from the given
+// source prefix as it leaves the host on any interface other than the wireguard interface.
+func (fw *iptablesFirewall) AddEgressMasquerade(ctx context.Context, ifaceName string, prefix netip.Prefix) error {
+	cmd, args := egressMasqueradeArgs(ifaceName, prefix)
+	return fw.execCmd(ctx, cmd, args...)
+}
+
+// egressMasqueradeArgs returns the command and arguments for an egress masquerade rule.
+func egressMasqueradeArgs(ifaceName string, prefix netip.Prefix) (string, []string) {
+	cmd := "iptables"
+	if prefix.Addr().Is6() {
+		cmd = "ip6tables"
+	}
+	return cmd, []string{"-t", "nat", "-A", "POSTROUTING", "-s", prefix.Masked().String(), "!", "-o", ifaceName, "-j", "MASQUERADE"}
+}
+
+// SetDeniedPrefixes should replace the set of prefixes whose traffic is dropped on the wireguard
+// interface, including packets belonging to already established flows.
+func (fw *iptablesFirewall) SetDeniedPrefixes(ctx context.Context, ifaceName string, prefixes []netip.Prefix) (int, error) {
+	for _, rule := range fw.denied {
+		if err := fw.execCmd(ctx, rule[0], append([]string{"-D"}, rule[1:]...)...); err != nil {
+			return 0, err
+		}
+	}
+	fw.denied = nil
+	for _, prefix := range prefixes {
+		cmd := "iptables"
+		if prefix.Addr().Is6() {
+			cmd = "ip6tables"
+		}
+		cidr := prefix.Masked().String()
+		for _, rule := range [][]string{
+			{"INPUT", "-i", ifaceName, "-s", cidr, "-j", "DROP"},
+			{"FORWARD", "-i", ifaceName, "-s", cidr, "-j", "DROP"},
+			{"FORWARD", "-o", ifaceName, "-d", cidr, "-j", "DROP"},
+		} {
+			if err := fw.execCmd(ctx, cmd, append([]string{"-I"}, rule...)...); err != nil {
+				return 0, err
+			}
+			fw.denied = append(fw.denied, append([]string{cmd}, rule...))
+		}
+	}
+	return countEstablishedFlows(prefixes), nil
+}
+
+// SetACLRules should replace the set of rules dropping new connections arriving on the
+// wireguard interface.
+func (fw *iptablesFirewall) SetACLRules(ctx context.Context, ifaceName string, rules []ACLRule) error {
+	for _, rule := range fw.acls {
+		if err := fw.execCmd(ctx, rule[0], append([]string{"-D"}, rule[1:]...)...); err != nil {
+			return err
+		}
+	}
+	fw.acls = nil
+	for _, rule := range rules {
+		cmd, icmp := "iptables", "icmp"
+		if rule.Source.Addr().Is6() {
+			cmd, icmp = "ip6tables", "ipv6-icmp"
+		}
+		match := []string{"-i", ifaceName, "-s", rule.Source.Masked().String(), "-d", rule.Destination.Masked().String()}
+		// Rules are inserted at the top of each chain, so the exemptions are
+		// inserted after the drop to be evaluated before it.
+		specs := [][]string{
+			append(slices.Clone(match), "-m", "conntrack", "--ctstate", "NEW", "-j", "DROP"),
+		}
+		if rule.AllowICMP {
+			specs = append(specs, append(slices.Clone(match), "-p", icmp, "-j", "ACCEPT"))
+		}
+		if rule.AllowPort != 0 {
+			port := strconv.Itoa(int(rule.AllowPort))
+			for _, proto := range []string{"tcp", "udp"} {
+				specs = append(specs, append(slices.Clone(match), "-p", proto, "--dport", port, "-j", "ACCEPT"))
+			}
+		}
+		for _, chain := range []string{"INPUT", "FORWARD"} {
+			for _, spec := range specs {
+				rule := append([]string{chain}, spec...)
+				if err := fw.execCmd(ctx, cmd, append([]string{"-I"}, rule...)...); err != nil {
+					return err
+				}
+				fw.acls = append(fw.acls, append([]string{cmd}, rule...))
+			}
+		}
+	}
+	return nil
+}
+
+// SetRateLimits should replace the set of bandwidth limits on traffic sent to and received
+// from prefixes on the wireguard interface.
+func (fw *iptablesFirewall) SetRateLimits(ctx context.Context, ifaceName string, limits []RateLimit) error {
+	return fw.limits.set(ifaceName, limits)
+}
+
 // Clear should clear any changes made to the firewall.
 func (fw *iptablesFirewall) Clear(ctx context.Context) error {
+	fw.denied = nil
+	fw.acls = nil
+	if err := fw.limits.clear(); err != nil {
+		return err
+	}
 	err := fw.exec(ctx, "-F")
 	if err != nil {
 		return err
@@ -84,10 +199,14 @@ func (fw *iptablesFirewall) Close(ctx context.Context) error {
 }
 
 func (fw *iptablesFirewall) exec(ctx context.Context, args ...string) error {
-	cmd := exec.CommandContext(ctx, "iptables", args...)
-	fw.log.Debug("iptables", slog.String("args", strings.Join(args, " ")))
+	return fw.execCmd(ctx, "iptables", args...)
+}
+
+func (fw *iptablesFirewall) execCmd(ctx context.Context, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	fw.log.Debug(name, slog.String("args", strings.Join(args, " ")))
 	if out, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("iptables %v: %v: %s", args, err, out)
+		return fmt.Errorf("%s %v: %v: %s", name, args, err, out)
 	}
 	return nil
 }
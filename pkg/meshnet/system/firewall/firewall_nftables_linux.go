@@ -18,13 +18,16 @@ package firewall
 
 import (
 	"context"
+	"encoding/binary"
 	"fmt"
+	"net/netip"
 	"strings"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/google/nftables"
 	"github.com/google/nftables/expr"
 	"github.com/sbezverk/nftableslib"
+	"golang.org/x/sys/unix"
 )
 
 // firewall is a firewall manager that uses nftables.
@@ -46,11 +49,23 @@ type firewall struct {
 	forward nftableslib.RulesInterface
 	// raw chains
 	rawprerouting nftableslib.RulesInterface
+	// rules dropping traffic for denied prefixes
+	denied []installedRule
+	// rules rendered from network ACLs
+	acls []installedRule
+	// rate limits on the wireguard interface
+	limits rateLimiter
+}
+
+// installedRule is a rule added by SetDeniedPrefixes or SetACLRules.
+type installedRule struct {
+	chain  nftableslib.RulesInterface
+	handle uint64
 }
 
 // newFirewall returns a new nftables firewall manager.
 func newFirewall(ctx context.Context, opts *Options) (Firewall, error) {
-	fw := &firewall{opts: opts}
+	fw := &firewall{opts: opts, limits: rateLimiter{netns: opts.NetNs}}
 	// Initialize a long lasting connection to the nftables library
 	var netns []int
 	if opts.NetNs != "" {
@@ -67,11 +82,15 @@ func newFirewall(ctx context.Context, opts *Options) (Firewall, error) {
 	if err != nil {
 		if strings.Contains(err.Error(), "not supported") || strings.Contains(err.Error(), "no such file") {
 			// Try to fallback to iptables
-			return newIPTablesFirewall(ctx, opts)
+			ipt, err := newIPTablesFirewall(ctx, opts)
+			if err != nil {
+				return nil, err
+			}
+			return withBackend(ctx, opts, ipt), nil
 		}
 		return nil, err
 	}
-	return fw, nil
+	return withBackend(ctx, opts, fw), nil
 }
 
 // AddWireguardForwarding should configure the firewall to allow forwarding traffic on the wireguard interface.
@@ -145,14 +164,249 @@ func (fw *firewall) AddMasquerade(ctx context.Context, ifaceName string) error {
 	return fw.conn.Flush()
 }
 
+// AddSourceMasquerade should configure the firewall to masquerade traffic from the given
+// source prefix as it leaves the host.
+func (fw *firewall) AddSourceMasquerade(ctx context.Context, prefix netip.Prefix) error {
+	masq, err := nftableslib.SetMasq(false, false, false)
+	if err != nil {
+		return fmt.Errorf("failed to create masquerade verdict: %w", err)
+	}
+	addr, err := nftableslib.NewIPAddr(prefix.Masked().String())
+	if err != nil {
+		return fmt.Errorf("failed to parse masquerade prefix %s: %w", prefix, err)
+	}
+	_, err = fw.postrouting.Rules().InsertImm(&nftableslib.Rule{
+		L3: &nftableslib.L3Rule{
+			Src: &nftableslib.IPAddrSpec{List: []*nftableslib.IPAddr{addr}},
+		},
+		Action:   masq,
+		UserData: nftableslib.MakeRuleComment(fmt.Sprintf("Masquerade outbound traffic from %s", prefix)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create source masquerade rule for %s: %w", prefix, err)
+	}
+	return fw.conn.Flush()
+}
+
+// AddEgressMasquerade should configure the firewall to masquerade traffic from the given
+// source prefix as it leaves the host on any interface other than the wireguard interface.
+func (fw *firewall) AddEgressMasquerade(ctx context.Context, ifaceName string, prefix netip.Prefix) error {
+	rule, err := egressMasqueradeRule(ifaceName, prefix)
+	if err != nil {
+		return err
+	}
+	_, err = fw.postrouting.Rules().InsertImm(rule)
+	if err != nil {
+		return fmt.Errorf("failed to create egress masquerade rule for %s: %w", prefix, err)
+	}
+	return fw.conn.Flush()
+}
+
+// egressMasqueradeRule returns a rule masquerading traffic from the given prefix
+// leaving on any interface other than the given one.
+func egressMasqueradeRule(ifaceName string, prefix netip.Prefix) (*nftableslib.Rule, error) {
+	if len(ifaceName) > 15 {
+		ifaceName = ifaceName[:15]
+	}
+	masq, err := nftableslib.SetMasq(false, false, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create masquerade verdict: %w", err)
+	}
+	addr, err := nftableslib.NewIPAddr(prefix.Masked().String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse masquerade prefix %s: %w", prefix, err)
+	}
+	return &nftableslib.Rule{
+		Meta: &nftableslib.Meta{
+			Expr: []nftableslib.MetaExpr{
+				{
+					Key: uint32(expr.MetaKeyOIFNAME),
+					// Interface names are null terminated in the kernel, without
+					// the terminator this would also skip interfaces sharing a prefix.
+					Value: append([]byte(ifaceName), 0),
+					RelOp: nftableslib.NEQ,
+				},
+			},
+		},
+		L3: &nftableslib.L3Rule{
+			Src: &nftableslib.IPAddrSpec{List: []*nftableslib.IPAddr{addr}},
+		},
+		Action:   masq,
+		UserData: nftableslib.MakeRuleComment(fmt.Sprintf("Masquerade traffic from %s leaving the mesh", prefix)),
+	}, nil
+}
+
+// SetDeniedPrefixes should replace the set of prefixes whose traffic is dropped on the wireguard
+// interface, including packets belonging to already established flows.
+func (fw *firewall) SetDeniedPrefixes(ctx context.Context, ifaceName string, prefixes []netip.Prefix) (int, error) {
+	if len(ifaceName) > 15 {
+		ifaceName = ifaceName[:15]
+	}
+	for _, rule := range fw.denied {
+		if err := rule.chain.Rules().DeleteImm(rule.handle); err != nil {
+			return 0, fmt.Errorf("failed to delete denied prefix rule: %w", err)
+		}
+	}
+	fw.denied = nil
+	drop, err := nftableslib.SetVerdict(nftableslib.NFT_DROP)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create drop verdict: %w", err)
+	}
+	for _, prefix := range prefixes {
+		addr, err := nftableslib.NewIPAddr(prefix.Masked().String())
+		if err != nil {
+			return 0, fmt.Errorf("failed to parse denied prefix %s: %w", prefix, err)
+		}
+		spec := &nftableslib.IPAddrSpec{List: []*nftableslib.IPAddr{addr}}
+		// Rules are inserted at the top of each chain so that they are evaluated
+		// before the rule accepting established connections.
+		for _, rule := range []struct {
+			chain nftableslib.RulesInterface
+			key   expr.MetaKey
+			l3    *nftableslib.L3Rule
+		}{
+			{fw.input, expr.MetaKeyIIFNAME, &nftableslib.L3Rule{Src: spec}},
+			{fw.forward, expr.MetaKeyIIFNAME, &nftableslib.L3Rule{Src: spec}},
+			{fw.forward, expr.MetaKeyOIFNAME, &nftableslib.L3Rule{Dst: spec}},
+		} {
+			handle, err := rule.chain.Rules().InsertImm(&nftableslib.Rule{
+				Meta: &nftableslib.Meta{
+					Expr: []nftableslib.MetaExpr{
+						{
+							Key:   uint32(rule.key),
+							Value: []byte(ifaceName),
+						},
+					},
+				},
+				L3:       rule.l3,
+				Action:   drop,
+				UserData: nftableslib.MakeRuleComment(fmt.Sprintf("Drop traffic for denied prefix %s", prefix)),
+			})
+			if err != nil {
+				return 0, fmt.Errorf("failed to create denied prefix rule for %s: %w", prefix, err)
+			}
+			fw.denied = append(fw.denied, installedRule{chain: rule.chain, handle: handle})
+		}
+	}
+	if err := fw.conn.Flush(); err != nil {
+		return 0, err
+	}
+	return countEstablishedFlows(prefixes), nil
+}
+
+// SetACLRules should replace the set of rules dropping new connections arriving on the
+// wireguard interface.
+func (fw *firewall) SetACLRules(ctx context.Context, ifaceName string, rules []ACLRule) error {
+	if len(ifaceName) > 15 {
+		ifaceName = ifaceName[:15]
+	}
+	for _, rule := range fw.acls {
+		if err := rule.chain.Rules().DeleteImm(rule.handle); err != nil {
+			return fmt.Errorf("failed to delete acl rule: %w", err)
+		}
+	}
+	fw.acls = nil
+	drop, err := nftableslib.SetVerdict(nftableslib.NFT_DROP)
+	if err != nil {
+		return fmt.Errorf("failed to create drop verdict: %w", err)
+	}
+	accept, err := nftableslib.SetVerdict(nftableslib.NFT_ACCEPT)
+	if err != nil {
+		return fmt.Errorf("failed to create accept verdict: %w", err)
+	}
+	var ctNew [4]byte
+	binary.BigEndian.PutUint32(ctNew[:], nftableslib.CTStateNew)
+	iif := nftableslib.MetaExpr{Key: uint32(expr.MetaKeyIIFNAME), Value: []byte(ifaceName)}
+	for _, rule := range rules {
+		src, err := nftableslib.NewIPAddr(rule.Source.Masked().String())
+		if err != nil {
+			return fmt.Errorf("failed to parse acl source %s: %w", rule.Source, err)
+		}
+		dst, err := nftableslib.NewIPAddr(rule.Destination.Masked().String())
+		if err != nil {
+			return fmt.Errorf("failed to parse acl destination %s: %w", rule.Destination, err)
+		}
+		l3 := &nftableslib.L3Rule{
+			Src: &nftableslib.IPAddrSpec{List: []*nftableslib.IPAddr{src}},
+			Dst: &nftableslib.IPAddrSpec{List: []*nftableslib.IPAddr{dst}},
+		}
+		// Rules are inserted at the top of each chain, so the exemptions are
+		// inserted after the drop to be evaluated before it.
+		nfrules := []*nftableslib.Rule{{
+			Meta: &nftableslib.Meta{Expr: []nftableslib.MetaExpr{iif}},
+			L3:   l3,
+			Conntracks: []*nftableslib.Conntrack{
+				{
+					Key:   uint32(expr.CtKeySTATE),
+					Value: ctNew[:],
+				},
+			},
+			Action:   drop,
+			UserData: nftableslib.MakeRuleComment(fmt.Sprintf("Drop connections denied by network ACLs %s", rule)),
+		}}
+		if rule.AllowICMP {
+			proto := byte(unix.IPPROTO_ICMP)
+			if rule.Source.Addr().Is6() {
+				proto = unix.IPPROTO_ICMPV6
+			}
+			nfrules = append(nfrules, &nftableslib.Rule{
+				Meta: &nftableslib.Meta{Expr: []nftableslib.MetaExpr{
+					iif,
+					{Key: uint32(expr.MetaKeyL4PROTO), Value: []byte{proto}},
+				}},
+				L3:       l3,
+				Action:   accept,
+				UserData: nftableslib.MakeRuleComment(fmt.Sprintf("Allow exempt icmp %s", rule)),
+			})
+		}
+		if rule.AllowPort != 0 {
+			for _, proto := range []uint8{unix.IPPROTO_TCP, unix.IPPROTO_UDP} {
+				nfrules = append(nfrules, &nftableslib.Rule{
+					Meta: &nftableslib.Meta{Expr: []nftableslib.MetaExpr{iif}},
+					L3:   l3,
+					L4: &nftableslib.L4Rule{
+						L4Proto: proto,
+						Dst: &nftableslib.Port{
+							List: nftableslib.SetPortList([]int{int(rule.AllowPort)}),
+						},
+					},
+					Action:   accept,
+					UserData: nftableslib.MakeRuleComment(fmt.Sprintf("Allow exempt port %d %s", rule.AllowPort, rule)),
+				})
+			}
+		}
+		for _, chain := range []nftableslib.RulesInterface{fw.input, fw.forward} {
+			for _, nfrule := range nfrules {
+				handle, err := chain.Rules().InsertImm(nfrule)
+				if err != nil {
+					return fmt.Errorf("failed to create acl rule %s: %w", rule, err)
+				}
+				fw.acls = append(fw.acls, installedRule{chain: chain, handle: handle})
+			}
+		}
+	}
+	return fw.conn.Flush()
+}
+
+// SetRateLimits should replace the set of bandwidth limits on traffic sent to and received
+// from prefixes on the wireguard interface.
+func (fw *firewall) SetRateLimits(ctx context.Context, ifaceName string, limits []RateLimit) error {
+	return fw.limits.set(ifaceName, limits)
+}
+
 // Clear should clear any changes made to the firewall.
 func (fw *firewall) Clear(ctx context.Context) error {
+	if err := fw.limits.clear(); err != nil {
+		return fmt.Errorf("failed to clear rate limits: %w", err)
+	}
 	for _, table := range []string{inetNatTable, inetFilterTable, inetRawTable} {
 		err := fw.ti.DeleteImm(table, nftables.TableFamilyINet)
 		if err != nil {
 			return fmt.Errorf("failed to delete inet %s table: %w", table, err)
 		}
 	}
+	fw.denied = nil
+	fw.acls = nil
 	return fw.conn.Flush()
 }
 
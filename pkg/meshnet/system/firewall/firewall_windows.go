@@ -19,6 +19,7 @@ package firewall
 import (
 	"fmt"
 	"net"
+	"net/netip"
 
 	"github.com/webmeshproj/webmesh/pkg/common"
 	"github.com/webmeshproj/webmesh/pkg/context"
@@ -83,6 +84,36 @@ func (wf *winFirewall) AddMasquerade(ctx context.Context, ifaceName string) erro
 	return nil
 }
 
+// AddSourceMasquerade is not implemented on windows.
+func (wf *winFirewall) AddSourceMasquerade(ctx context.Context, prefix netip.Prefix) error {
+	return fmt.Errorf("masquerading traffic from %s is not supported on windows", prefix)
+}
+
+// AddEgressMasquerade is not implemented on windows.
+func (wf *winFirewall) AddEgressMasquerade(ctx context.Context, ifaceName string, prefix netip.Prefix) error {
+	return fmt.Errorf("masquerading traffic from %s is not supported on windows", prefix)
+}
+
+// SetDeniedPrefixes is not implemented on windows. Traffic for denied prefixes is
+// still cut by removing them from the WireGuard allowed IPs.
+func (wf *winFirewall) SetDeniedPrefixes(ctx context.Context, ifaceName string, prefixes []netip.Prefix) (int, error) {
+	return 0, nil
+}
+
+// SetACLRules is not implemented on windows. Peers denied by network ACLs are
+// still removed from the WireGuard interface.
+func (wf *winFirewall) SetACLRules(ctx context.Context, ifaceName string, rules []ACLRule) error {
+	return nil
+}
+
+// SetRateLimits is not supported on windows.
+func (wf *winFirewall) SetRateLimits(ctx context.Context, ifaceName string, limits []RateLimit) error {
+	if len(limits) == 0 {
+		return nil
+	}
+	return fmt.Errorf("rate limits are not supported on windows")
+}
+
 // Clear should clear any changes made to the firewall.
 func (wf *winFirewall) Clear(ctx context.Context) error {
 	for _, name := range []string{"webmesh-forward-inbound", "webmesh-forward-outbound"} {
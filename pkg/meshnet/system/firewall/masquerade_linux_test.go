@@ -0,0 +1,95 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/google/nftables/expr"
+	"github.com/sbezverk/nftableslib"
+)
+
+func TestEgressMasqueradeArgs(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name    string
+		prefix  string
+		wantCmd string
+		want    []string
+	}{
+		{
+			name:    "ipv4",
+			prefix:  "172.16.0.0/12",
+			wantCmd: "iptables",
+			want:    []string{"-t", "nat", "-A", "POSTROUTING", "-s", "172.16.0.0/12", "!", "-o", "webmesh0", "-j", "MASQUERADE"},
+		},
+		{
+			name:    "ipv6",
+			prefix:  "fd00:dead:beef::1/48",
+			wantCmd: "ip6tables",
+			want:    []string{"-t", "nat", "-A", "POSTROUTING", "-s", "fd00:dead:beef::/48", "!", "-o", "webmesh0", "-j", "MASQUERADE"},
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cmd, args := egressMasqueradeArgs("webmesh0", netip.MustParsePrefix(tt.prefix))
+			if cmd != tt.wantCmd {
+				t.Errorf("expected command %s, got %s", tt.wantCmd, cmd)
+			}
+			if !slices.Equal(args, tt.want) {
+				t.Errorf("expected args %v, got %v", tt.want, args)
+			}
+		})
+	}
+}
+
+func TestEgressMasqueradeRule(t *testing.T) {
+	t.Parallel()
+	rule, err := egressMasqueradeRule("webmesh0", netip.MustParsePrefix("172.16.0.1/12"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Only traffic leaving on interfaces other than the mesh is masqueraded.
+	if rule.Meta == nil || len(rule.Meta.Expr) != 1 {
+		t.Fatalf("expected a single meta expression, got %+v", rule.Meta)
+	}
+	meta := rule.Meta.Expr[0]
+	if meta.Key != uint32(expr.MetaKeyOIFNAME) {
+		t.Errorf("expected rule to match the output interface, got key %d", meta.Key)
+	}
+	if meta.RelOp != nftableslib.NEQ {
+		t.Errorf("expected rule to exclude the mesh interface, got operator %v", meta.RelOp)
+	}
+	if string(meta.Value) != "webmesh0\x00" {
+		t.Errorf("expected null terminated interface name, got %q", meta.Value)
+	}
+	// Only traffic from the mesh is masqueraded.
+	if rule.L3 == nil || rule.L3.Src == nil || len(rule.L3.Src.List) != 1 {
+		t.Fatalf("expected a single source prefix, got %+v", rule.L3)
+	}
+	src := rule.L3.Src.List[0]
+	if src.String() != "172.16.0.0" || src.Mask == nil || *src.Mask != 12 {
+		t.Errorf("expected source 172.16.0.0/12, got %s", src)
+	}
+	if rule.Action == nil {
+		t.Fatal("expected a masquerade action")
+	}
+}
@@ -0,0 +1,257 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/netip"
+
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// rateLimitMajor is the major handle of the HTB qdisc shaping traffic
+	// sent on the wireguard interface.
+	rateLimitMajor = 0x1eb
+	// Priorities of the filters on the ingress hook. The eBPF firewall
+	// programs run before them.
+	rateLimitPriorityV4 = 10
+	rateLimitPriorityV6 = 11
+	// minPoliceBurst is the smallest burst allowed through the policers,
+	// large enough for the coalesced packets wireguard hands to the stack.
+	minPoliceBurst = 64 * 1024
+)
+
+// rateLimiter enforces rate limits on the wireguard interface with tc. An HTB
+// qdisc with a class for each limit shapes traffic sent to the prefixes, and
+// policing filters on the ingress hook drop traffic received from them beyond
+// the rate.
+type rateLimiter struct {
+	netns string
+	iface string
+}
+
+// set replaces the rate limits on the given interface.
+func (r *rateLimiter) set(ifaceName string, limits []RateLimit) error {
+	return r.inNetNS(func() error {
+		if r.iface != "" && r.iface != ifaceName {
+			if err := removeRateLimits(r.iface); err != nil {
+				return err
+			}
+			r.iface = ""
+		}
+		if err := removeRateLimits(ifaceName); err != nil {
+			return err
+		}
+		if len(limits) == 0 {
+			r.iface = ""
+			return nil
+		}
+		r.iface = ifaceName
+		return addRateLimits(ifaceName, limits)
+	})
+}
+
+// clear removes the rate limits from the interface they were set on.
+func (r *rateLimiter) clear() error {
+	if r.iface == "" {
+		return nil
+	}
+	iface := r.iface
+	r.iface = ""
+	return r.inNetNS(func() error {
+		return removeRateLimits(iface)
+	})
+}
+
+func (r *rateLimiter) inNetNS(fn func() error) error {
+	if r.netns == "" {
+		return fn()
+	}
+	netns, err := ns.GetNS(r.netns)
+	if err != nil {
+		return fmt.Errorf("failed to get netns: %w", err)
+	}
+	defer netns.Close()
+	return netns.Do(func(_ ns.NetNS) error {
+		return fn()
+	})
+}
+
+func addRateLimits(ifaceName string, limits []RateLimit) error {
+	if len(limits) >= math.MaxUint16 {
+		return fmt.Errorf("too many rate limits: %d", len(limits))
+	}
+	link, err := netlink.LinkByName(ifaceName)
+	if err != nil {
+		return fmt.Errorf("get link %s: %w", ifaceName, err)
+	}
+	index := link.Attrs().Index
+	root := netlink.MakeHandle(rateLimitMajor, 0)
+	// Unclassified traffic bypasses the classes at full speed.
+	htb := netlink.NewHtb(netlink.QdiscAttrs{
+		LinkIndex: index,
+		Handle:    root,
+		Parent:    netlink.HANDLE_ROOT,
+	})
+	if err := netlink.QdiscReplace(htb); err != nil {
+		return fmt.Errorf("add htb qdisc: %w", err)
+	}
+	err = netlink.QdiscAdd(clsactQdisc(index))
+	if err != nil && !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("add clsact qdisc: %w", err)
+	}
+	for i, limit := range limits {
+		classID := netlink.MakeHandle(rateLimitMajor, uint16(i+1))
+		class := netlink.NewHtbClass(netlink.ClassAttrs{
+			LinkIndex: index,
+			Parent:    root,
+			Handle:    classID,
+		}, netlink.HtbClassAttrs{
+			Rate: limit.Rate,
+			Ceil: max(limit.Ceil, limit.Rate),
+		})
+		if err := netlink.ClassAdd(class); err != nil {
+			return fmt.Errorf("add htb class for %s: %w", limit.Prefix, err)
+		}
+		protocol, priority := rateLimitProtocol(limit.Prefix)
+		err := netlink.FilterAdd(&netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: index,
+				Parent:    root,
+				Protocol:  protocol,
+				Priority:  priority,
+			},
+			ClassId: classID,
+			Sel:     u32PrefixSel(limit.Prefix, false),
+		})
+		if err != nil {
+			return fmt.Errorf("add egress filter for %s: %w", limit.Prefix, err)
+		}
+		rate := min(limit.Rate/8, math.MaxUint32)
+		police := netlink.NewPoliceAction()
+		police.Rate = uint32(rate)
+		police.Burst = uint32(max(rate/10, minPoliceBurst))
+		police.Mtu = math.MaxUint16
+		police.ExceedAction = netlink.TC_POLICE_SHOT
+		police.NotExceedAction = netlink.TC_POLICE_OK
+		err = netlink.FilterAdd(&netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: index,
+				Parent:    netlink.HANDLE_MIN_INGRESS,
+				Protocol:  protocol,
+				Priority:  priority,
+			},
+			Sel:     u32PrefixSel(limit.Prefix, true),
+			Actions: []netlink.Action{police},
+		})
+		if err != nil {
+			return fmt.Errorf("add ingress filter for %s: %w", limit.Prefix, err)
+		}
+	}
+	return nil
+}
+
+// removeRateLimits removes the HTB qdisc and the policing filters from the
+// interface. The clsact qdisc is left in place for the eBPF firewall.
+func removeRateLimits(ifaceName string) error {
+	link, err := netlink.LinkByName(ifaceName)
+	if err != nil {
+		// The interface is already gone along with its limits.
+		return nil
+	}
+	index := link.Attrs().Index
+	qdiscs, err := netlink.QdiscList(link)
+	if err != nil {
+		return fmt.Errorf("list qdiscs: %w", err)
+	}
+	for _, qdisc := range qdiscs {
+		attrs := qdisc.Attrs()
+		if attrs.Parent != netlink.HANDLE_ROOT || attrs.Handle != netlink.MakeHandle(rateLimitMajor, 0) {
+			continue
+		}
+		if err := netlink.QdiscDel(qdisc); err != nil && !errors.Is(err, unix.ENOENT) {
+			return fmt.Errorf("delete htb qdisc: %w", err)
+		}
+	}
+	for _, priority := range []uint16{rateLimitPriorityV4, rateLimitPriorityV6} {
+		protocol := uint16(unix.ETH_P_IP)
+		if priority == rateLimitPriorityV6 {
+			protocol = unix.ETH_P_IPV6
+		}
+		err := netlink.FilterDel(&netlink.U32{
+			FilterAttrs: netlink.FilterAttrs{
+				LinkIndex: index,
+				Parent:    netlink.HANDLE_MIN_INGRESS,
+				Protocol:  protocol,
+				Priority:  priority,
+			},
+		})
+		if err != nil && !errors.Is(err, unix.ENOENT) && !errors.Is(err, unix.EINVAL) {
+			return fmt.Errorf("delete ingress filters: %w", err)
+		}
+	}
+	return nil
+}
+
+func rateLimitProtocol(prefix netip.Prefix) (protocol uint16, priority uint16) {
+	if prefix.Addr().Is4() {
+		return unix.ETH_P_IP, rateLimitPriorityV4
+	}
+	return unix.ETH_P_IPV6, rateLimitPriorityV6
+}
+
+// u32PrefixSel returns a u32 selector matching packets to the prefix, or from
+// it if source is true. Packets on the interface start at the IP header.
+func u32PrefixSel(prefix netip.Prefix, source bool) *netlink.TcU32Sel {
+	prefix = prefix.Masked()
+	var off int32
+	switch {
+	case prefix.Addr().Is4() && source:
+		off = 12
+	case prefix.Addr().Is4():
+		off = 16
+	case source:
+		off = 8
+	default:
+		off = 24
+	}
+	addr := prefix.Addr().AsSlice()
+	sel := &netlink.TcU32Sel{Flags: netlink.TC_U32_TERMINAL}
+	for word, bits := 0, prefix.Bits(); word*4 < len(addr) && bits > 0; word, bits = word+1, bits-32 {
+		mask := uint32(math.MaxUint32)
+		if bits < 32 {
+			mask <<= 32 - bits
+		}
+		sel.Keys = append(sel.Keys, netlink.TcU32Key{
+			Mask: mask,
+			Val:  binary.BigEndian.Uint32(addr[word*4:]) & mask,
+			Off:  off + int32(word*4),
+		})
+	}
+	if len(sel.Keys) == 0 {
+		// Match everything for default routes.
+		sel.Keys = append(sel.Keys, netlink.TcU32Key{})
+	}
+	sel.Nkeys = uint8(len(sel.Keys))
+	return sel
+}
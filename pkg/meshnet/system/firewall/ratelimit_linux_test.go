@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package firewall
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestU32PrefixSel(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name   string
+		prefix string
+		source bool
+		want   []netlink.TcU32Key
+	}{
+		{
+			name:   "ipv4 destination",
+			prefix: "172.16.1.0/24",
+			want:   []netlink.TcU32Key{{Mask: 0xffffff00, Val: 0xac100100, Off: 16}},
+		},
+		{
+			name:   "ipv4 source",
+			prefix: "172.16.1.2/32",
+			source: true,
+			want:   []netlink.TcU32Key{{Mask: 0xffffffff, Val: 0xac100102, Off: 12}},
+		},
+		{
+			name:   "ipv6 destination",
+			prefix: "fd00:1:2:3::/56",
+			want: []netlink.TcU32Key{
+				{Mask: 0xffffffff, Val: 0xfd000001, Off: 24},
+				{Mask: 0xffffff00, Val: 0x00020000, Off: 28},
+			},
+		},
+		{
+			name:   "ipv6 source",
+			prefix: "fd00::1/128",
+			source: true,
+			want: []netlink.TcU32Key{
+				{Mask: 0xffffffff, Val: 0xfd000000, Off: 8},
+				{Mask: 0xffffffff, Val: 0, Off: 12},
+				{Mask: 0xffffffff, Val: 0, Off: 16},
+				{Mask: 0xffffffff, Val: 1, Off: 20},
+			},
+		},
+		{
+			name:   "default route",
+			prefix: "0.0.0.0/0",
+			want:   []netlink.TcU32Key{{}},
+		},
+	}
+	for _, tt := range tc {
+		sel := u32PrefixSel(netip.MustParsePrefix(tt.prefix), tt.source)
+		if !slices.Equal(sel.Keys, tt.want) {
+			t.Errorf("%s: got keys %+v, want %+v", tt.name, sel.Keys, tt.want)
+		}
+		if int(sel.Nkeys) != len(tt.want) {
+			t.Errorf("%s: got %d keys, want %d", tt.name, sel.Nkeys, len(tt.want))
+		}
+	}
+}
@@ -84,6 +84,9 @@ type Options struct {
 	DisableIPv4 bool
 	// DisableIPv6 disables IPv6 on the interface.
 	DisableIPv6 bool
+	// TUNWrapper wraps the userspace TUN device when one is used. It is
+	// ignored for kernel interfaces and cannot cross a privilege boundary.
+	TUNWrapper link.TUNWrapper `json:"-"`
 }
 
 // IsRouteExists returns true if the given error is a route exists error.
@@ -114,7 +117,7 @@ func New(ctx context.Context, opts *Options) (Interface, error) {
 	mtu := opts.MTU
 	if forceTUN {
 		log.Debug("Creating wireguard tun interface")
-		name, closer, err := link.NewTUN(ctx, iface.ifname, mtu)
+		name, closer, err := link.NewTUN(ctx, iface.ifname, mtu, opts.TUNWrapper)
 		if err != nil {
 			return nil, fmt.Errorf("new tun: %w", err)
 		}
@@ -129,7 +132,7 @@ func New(ctx context.Context, opts *Options) (Interface, error) {
 		if err != nil {
 			log.Error("Failed to create kernel interface failed, falling back to TUN driver", "error", err)
 			// Try the TUN device as a fallback
-			name, closer, err := link.NewTUN(ctx, iface.ifname, mtu)
+			name, closer, err := link.NewTUN(ctx, iface.ifname, mtu, opts.TUNWrapper)
 			if err != nil {
 				return nil, fmt.Errorf("new tun: %w", err)
 			}
@@ -16,9 +16,16 @@ limitations under the License.
 
 package link
 
-import "errors"
+import (
+	"errors"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
 
 var (
 	// ErrLinkNotExists is returned when a link does not exist.
 	ErrLinkNotExists = errors.New("link does not exist")
 )
+
+// TUNWrapper wraps a userspace TUN device before it is handed to WireGuard.
+type TUNWrapper func(tun.Device) tun.Device
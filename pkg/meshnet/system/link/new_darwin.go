@@ -35,7 +35,8 @@ func NewKernel(ctx context.Context, name string, mtu uint32) error {
 }
 
 // NewTUN creates a new WireGuard interface using the userspace tun driver.
-func NewTUN(ctx context.Context, name string, mtu uint32) (realName string, closer func(), err error) {
+// If wrap is not nil, it is applied to the TUN device before it is handed to WireGuard.
+func NewTUN(ctx context.Context, name string, mtu uint32, wrap TUNWrapper) (realName string, closer func(), err error) {
 	tun, err := tun.CreateTUN(name, int(mtu))
 	if err != nil {
 		err = fmt.Errorf("create tun: %w", err)
@@ -52,6 +53,9 @@ func NewTUN(ctx context.Context, name string, mtu uint32) (realName string, clos
 		err = fmt.Errorf("uapi open: %w", err)
 		return
 	}
+	if wrap != nil {
+		tun = wrap(tun)
+	}
 	device := device.NewDevice(tun, conn.NewDefaultBind(), device.NewLogger(
 		func() int {
 			if context.LoggerFrom(ctx).Handler().Enabled(context.Background(), slog.LevelDebug) {
@@ -61,7 +61,8 @@ func NewKernel(ctx context.Context, name string, mtu uint32) error {
 }
 
 // NewTUN creates a new WireGuard interface using the userspace tun driver.
-func NewTUN(ctx context.Context, name string, mtu uint32) (realName string, closer func(), err error) {
+// If wrap is not nil, it is applied to the TUN device before it is handed to WireGuard.
+func NewTUN(ctx context.Context, name string, mtu uint32, wrap TUNWrapper) (realName string, closer func(), err error) {
 	// Create the TUN device
 	tun, err := tun.CreateTUN(name, int(mtu))
 	if err != nil {
@@ -81,6 +82,9 @@ func NewTUN(ctx context.Context, name string, mtu uint32) (realName string, clos
 		tun.Close()
 		return
 	}
+	if wrap != nil {
+		tun = wrap(tun)
+	}
 	// Create the tunnel device
 	device := device.NewDevice(tun, conn.NewDefaultBind(), device.NewLogger(
 		func() int {
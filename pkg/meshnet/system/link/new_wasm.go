@@ -28,6 +28,7 @@ func NewKernel(ctx context.Context, name string, mtu uint32) error {
 }
 
 // NewTUN creates a new WireGuard interface using the userspace tun driver.
-func NewTUN(ctx context.Context, name string, mtu uint32) (realName string, closer func(), err error) {
+// If wrap is not nil, it is applied to the TUN device before it is handed to WireGuard.
+func NewTUN(ctx context.Context, name string, mtu uint32, wrap TUNWrapper) (realName string, closer func(), err error) {
 	return "", nil, errors.New("tun interfaces not supported on wasm")
 }
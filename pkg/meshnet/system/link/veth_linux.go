@@ -0,0 +1,43 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package link
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/vishvananda/netlink"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// NewVeth creates a veth pair with the given names and brings the first end up.
+func NewVeth(ctx context.Context, name, peer string) error {
+	context.LoggerFrom(ctx).Debug("Create veth pair", slog.String("interface", name), slog.String("peer", peer))
+	veth := &netlink.Veth{
+		LinkAttrs: netlink.LinkAttrs{Name: name},
+		PeerName:  peer,
+	}
+	if err := netlink.LinkAdd(veth); err != nil {
+		return fmt.Errorf("add veth pair: %w", err)
+	}
+	if err := netlink.LinkSetUp(veth); err != nil {
+		_ = netlink.LinkDel(veth)
+		return fmt.Errorf("set interface up: %w", err)
+	}
+	return nil
+}
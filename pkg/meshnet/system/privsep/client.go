@@ -0,0 +1,290 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privsep
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/rpc"
+	"strings"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/nat64"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/link"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+)
+
+// Client performs privileged operations by forwarding them to a helper
+// process. It implements Ops.
+type Client struct {
+	rpc *rpc.Client
+}
+
+var _ Ops = (*Client)(nil)
+
+// Dial connects to the helper listening on the given unix socket.
+func Dial(ctx context.Context, socket string) (*Client, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("dial privsep helper: %w", err)
+	}
+	return &Client{rpc: rpc.NewClient(conn)}, nil
+}
+
+// Close closes the connection to the helper.
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+func (c *Client) call(ctx context.Context, method string, req, resp any) error {
+	call := c.rpc.Go(serviceName+"."+method, req, resp, make(chan *rpc.Call, 1))
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-call.Done:
+	}
+	return decodeError(call.Error)
+}
+
+// decodeError restores the sentinel errors callers check for, since errors
+// returned by the helper only carry their message.
+func decodeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var serr rpc.ServerError
+	if !errors.As(err, &serr) {
+		return err
+	}
+	for _, sentinel := range []error{routes.ErrRouteExists, link.ErrLinkNotExists} {
+		if strings.Contains(string(serr), sentinel.Error()) {
+			return fmt.Errorf("%w: %s", sentinel, string(serr))
+		}
+	}
+	return errors.New(string(serr))
+}
+
+// NewInterface creates a new system interface.
+func (c *Client) NewInterface(ctx context.Context, opts *system.Options) (system.Interface, error) {
+	var resp NewInterfaceResponse
+	if err := c.call(ctx, "NewInterface", &NewInterfaceRequest{Options: *opts}, &resp); err != nil {
+		return nil, err
+	}
+	return &remoteInterface{
+		c:      c,
+		name:   resp.Name,
+		netns:  opts.NetNs,
+		addrv4: opts.AddressV4,
+		addrv6: opts.AddressV6,
+	}, nil
+}
+
+// RemoveInterface removes the WireGuard interface with the given name.
+func (c *Client) RemoveInterface(ctx context.Context, name string) error {
+	return c.call(ctx, "RemoveInterface", &NameRequest{Name: name}, &Empty{})
+}
+
+// EnableIPForwarding enables IP forwarding on the system.
+func (c *Client) EnableIPForwarding(ctx context.Context) error {
+	return c.call(ctx, "EnableIPForwarding", &Empty{}, &Empty{})
+}
+
+// SetDefaultIPv4Gateway sets the default IPv4 gateway in the given network namespace.
+func (c *Client) SetDefaultIPv4Gateway(ctx context.Context, netns string, gw routes.Gateway) error {
+	return c.call(ctx, "SetDefaultIPv4Gateway", &GatewayRequest{NetNs: netns, Gateway: gw}, &Empty{})
+}
+
+// ConfigureDevice applies the configuration to the WireGuard device.
+func (c *Client) ConfigureDevice(ctx context.Context, netns, name string, cfg wgtypes.Config) error {
+	return c.call(ctx, "ConfigureDevice", &DeviceRequest{NetNs: netns, Name: name, Config: cfg}, &Empty{})
+}
+
+// Device returns the current state of the WireGuard device.
+func (c *Client) Device(ctx context.Context, netns, name string) (*wgtypes.Device, error) {
+	var device wgtypes.Device
+	if err := c.call(ctx, "Device", &DeviceRequest{NetNs: netns, Name: name}, &device); err != nil {
+		return nil, err
+	}
+	return &device, nil
+}
+
+// NewFirewall creates a new firewall manager.
+func (c *Client) NewFirewall(ctx context.Context, opts *firewall.Options) (firewall.Firewall, error) {
+	if err := c.call(ctx, "NewFirewall", &NewFirewallRequest{Options: *opts}, &Empty{}); err != nil {
+		return nil, err
+	}
+	return &remoteFirewall{c: c, id: opts.ID}, nil
+}
+
+// NewNAT64 creates a NAT64 translation device.
+func (c *Client) NewNAT64(ctx context.Context, opts *nat64.Options) (nat64.NAT64, error) {
+	var resp NameRequest
+	if err := c.call(ctx, "NewNAT64", &NewNAT64Request{Options: *opts}, &resp); err != nil {
+		return nil, err
+	}
+	return &remoteNAT64{c: c, name: resp.Name}, nil
+}
+
+// AddDNSServers adds DNS servers to the system configuration for the interface.
+func (c *Client) AddDNSServers(ctx context.Context, iface string, servers []netip.AddrPort) error {
+	return c.call(ctx, "DNSServers", &DNSRequest{Interface: iface, Servers: servers}, &Empty{})
+}
+
+// RemoveDNSServers removes DNS servers from the system configuration for the interface.
+func (c *Client) RemoveDNSServers(ctx context.Context, iface string, servers []netip.AddrPort) error {
+	return c.call(ctx, "DNSServers", &DNSRequest{Interface: iface, Servers: servers, Remove: true}, &Empty{})
+}
+
+// AddSearchDomains adds search domains to the system configuration for the interface.
+func (c *Client) AddSearchDomains(ctx context.Context, iface string, domains []string) error {
+	return c.call(ctx, "SearchDomains", &DNSRequest{Interface: iface, Domains: domains}, &Empty{})
+}
+
+// RemoveSearchDomains removes search domains from the system configuration for the interface.
+func (c *Client) RemoveSearchDomains(ctx context.Context, iface string, domains []string) error {
+	return c.call(ctx, "SearchDomains", &DNSRequest{Interface: iface, Domains: domains, Remove: true}, &Empty{})
+}
+
+// remoteInterface is an interface created by the helper.
+type remoteInterface struct {
+	c      *Client
+	name   string
+	netns  string
+	addrv4 netip.Prefix
+	addrv6 netip.Prefix
+}
+
+func (r *remoteInterface) Name() string            { return r.name }
+func (r *remoteInterface) AddressV4() netip.Prefix { return r.addrv4 }
+func (r *remoteInterface) AddressV6() netip.Prefix { return r.addrv6 }
+
+func (r *remoteInterface) do(ctx context.Context, op InterfaceOp, prefix netip.Prefix) error {
+	return r.c.call(ctx, "Interface", &InterfaceRequest{Name: r.name, Op: op, Prefix: prefix}, &Empty{})
+}
+
+func (r *remoteInterface) Up(ctx context.Context) error {
+	return r.do(ctx, InterfaceUp, netip.Prefix{})
+}
+
+func (r *remoteInterface) Down(ctx context.Context) error {
+	return r.do(ctx, InterfaceDown, netip.Prefix{})
+}
+
+func (r *remoteInterface) Destroy(ctx context.Context) error {
+	return r.do(ctx, InterfaceDestroy, netip.Prefix{})
+}
+
+func (r *remoteInterface) AddAddress(ctx context.Context, addr netip.Prefix) error {
+	return r.do(ctx, InterfaceAddAddress, addr)
+}
+
+func (r *remoteInterface) RemoveAddress(ctx context.Context, addr netip.Prefix) error {
+	return r.do(ctx, InterfaceRemoveAddress, addr)
+}
+
+func (r *remoteInterface) AddRoute(ctx context.Context, network netip.Prefix) error {
+	return r.do(ctx, InterfaceAddRoute, network)
+}
+
+func (r *remoteInterface) RemoveRoute(ctx context.Context, network netip.Prefix) error {
+	return r.do(ctx, InterfaceRemoveRoute, network)
+}
+
+// Link returns the underlying net.Interface. Reading link information does
+// not require privileges, so it is done in the calling process.
+func (r *remoteInterface) Link() (*net.Interface, error) {
+	if r.netns != "" {
+		return nil, errors.New("cannot read links in a network namespace with privilege separation")
+	}
+	return net.InterfaceByName(r.name)
+}
+
+// HardwareAddr returns the hardware address of the interface.
+func (r *remoteInterface) HardwareAddr() (net.HardwareAddr, error) {
+	l, err := r.Link()
+	if err != nil {
+		return nil, err
+	}
+	return l.HardwareAddr, nil
+}
+
+// remoteFirewall is a firewall created by the helper.
+type remoteFirewall struct {
+	c  *Client
+	id string
+}
+
+func (r *remoteFirewall) do(ctx context.Context, op FirewallOp, iface string) error {
+	return r.c.call(ctx, "Firewall", &FirewallRequest{ID: r.id, Op: op, Interface: iface}, &FirewallResponse{})
+}
+
+func (r *remoteFirewall) AddWireguardForwarding(ctx context.Context, ifaceName string) error {
+	return r.do(ctx, FirewallAddForwarding, ifaceName)
+}
+
+func (r *remoteFirewall) AddMasquerade(ctx context.Context, ifaceName string) error {
+	return r.do(ctx, FirewallAddMasquerade, ifaceName)
+}
+
+func (r *remoteFirewall) AddSourceMasquerade(ctx context.Context, prefix netip.Prefix) error {
+	return r.c.call(ctx, "Firewall", &FirewallRequest{ID: r.id, Op: FirewallAddSourceMasq, Prefixes: []netip.Prefix{prefix}}, &FirewallResponse{})
+}
+
+func (r *remoteFirewall) AddEgressMasquerade(ctx context.Context, ifaceName string, prefix netip.Prefix) error {
+	return r.c.call(ctx, "Firewall", &FirewallRequest{ID: r.id, Op: FirewallAddEgressMasq, Interface: ifaceName, Prefixes: []netip.Prefix{prefix}}, &FirewallResponse{})
+}
+
+func (r *remoteFirewall) SetDeniedPrefixes(ctx context.Context, ifaceName string, prefixes []netip.Prefix) (int, error) {
+	var resp FirewallResponse
+	err := r.c.call(ctx, "Firewall", &FirewallRequest{ID: r.id, Op: FirewallSetDenied, Interface: ifaceName, Prefixes: prefixes}, &resp)
+	return resp.Flows, err
+}
+
+func (r *remoteFirewall) SetACLRules(ctx context.Context, ifaceName string, rules []firewall.ACLRule) error {
+	return r.c.call(ctx, "Firewall", &FirewallRequest{ID: r.id, Op: FirewallSetACLRules, Interface: ifaceName, Rules: rules}, &FirewallResponse{})
+}
+
+func (r *remoteFirewall) SetRateLimits(ctx context.Context, ifaceName string, limits []firewall.RateLimit) error {
+	return r.c.call(ctx, "Firewall", &FirewallRequest{ID: r.id, Op: FirewallSetLimits, Interface: ifaceName, Limits: limits}, &FirewallResponse{})
+}
+
+func (r *remoteFirewall) Clear(ctx context.Context) error {
+	return r.do(ctx, FirewallClear, "")
+}
+
+func (r *remoteFirewall) Close(ctx context.Context) error {
+	return r.do(ctx, FirewallClose, "")
+}
+
+// remoteNAT64 is a NAT64 device created by the helper.
+type remoteNAT64 struct {
+	c    *Client
+	name string
+}
+
+func (r *remoteNAT64) Name() string { return r.name }
+
+func (r *remoteNAT64) Close(ctx context.Context) error {
+	return r.c.call(ctx, "CloseNAT64", &NameRequest{Name: r.name}, &Empty{})
+}
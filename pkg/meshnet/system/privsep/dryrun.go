@@ -0,0 +1,668 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privsep
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/nat64"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+)
+
+// Recorder implements Ops by recording the changes that would be made to
+// the host instead of making them. It is used to run a node in dry-run
+// mode and report what it would have configured.
+type Recorder struct {
+	mu         sync.Mutex
+	ifaces     map[string]*recordedInterface
+	ifaceOrder []string
+	removed    []string
+	forwarding bool
+	gateway    *GatewayPlan
+	firewalls  []*recordedFirewall
+	nat64s     []*recordedNAT64
+	dns        map[string]*DNSPlan
+}
+
+var _ Ops = (*Recorder)(nil)
+
+// Plan is the set of changes recorded by a Recorder.
+type Plan struct {
+	// Interfaces are the interfaces that would be created.
+	Interfaces []InterfacePlan `json:"interfaces,omitempty"`
+	// RemovedInterfaces are existing interfaces that would be removed.
+	RemovedInterfaces []string `json:"removedInterfaces,omitempty"`
+	// IPForwarding is true if IP forwarding would be enabled.
+	IPForwarding bool `json:"ipForwarding"`
+	// DefaultIPv4Gateway is the default gateway that would be set, if any.
+	DefaultIPv4Gateway *GatewayPlan `json:"defaultIPv4Gateway,omitempty"`
+	// Firewalls are the firewalls that would be configured.
+	Firewalls []FirewallPlan `json:"firewalls,omitempty"`
+	// NAT64 are the NAT64 translation devices that would be created.
+	NAT64 []NAT64Plan `json:"nat64,omitempty"`
+	// DNS is the DNS configuration that would be applied keyed by interface.
+	DNS map[string]DNSPlan `json:"dns,omitempty"`
+}
+
+// InterfacePlan describes an interface and its WireGuard configuration.
+type InterfacePlan struct {
+	Name       string         `json:"name"`
+	NetNs      string         `json:"netns,omitempty"`
+	MTU        uint32         `json:"mtu"`
+	Up         bool           `json:"up"`
+	Addresses  []netip.Prefix `json:"addresses,omitempty"`
+	Routes     []netip.Prefix `json:"routes,omitempty"`
+	ListenPort int            `json:"listenPort,omitempty"`
+	Peers      []PeerPlan     `json:"peers,omitempty"`
+}
+
+// PeerPlan describes a configured WireGuard peer.
+type PeerPlan struct {
+	PublicKey           string        `json:"publicKey"`
+	Endpoint            string        `json:"endpoint,omitempty"`
+	AllowedIPs          []string      `json:"allowedIPs,omitempty"`
+	PersistentKeepalive time.Duration `json:"persistentKeepalive,omitempty"`
+}
+
+// GatewayPlan describes a default gateway change.
+type GatewayPlan struct {
+	NetNs string     `json:"netns,omitempty"`
+	Name  string     `json:"name,omitempty"`
+	Addr  netip.Addr `json:"addr"`
+}
+
+// FirewallPlan describes the rules a firewall would be configured with.
+type FirewallPlan struct {
+	ID             string                          `json:"id,omitempty"`
+	NetNs          string                          `json:"netns,omitempty"`
+	DefaultPolicy  firewall.Policy                 `json:"defaultPolicy,omitempty"`
+	WireguardPort  uint16                          `json:"wireguardPort,omitempty"`
+	StoragePort    uint16                          `json:"storagePort,omitempty"`
+	GRPCPort       uint16                          `json:"grpcPort,omitempty"`
+	Forwarding     []string                        `json:"forwarding,omitempty"`
+	Masquerade     []string                        `json:"masquerade,omitempty"`
+	SourceMasq     []netip.Prefix                  `json:"sourceMasquerade,omitempty"`
+	EgressMasq     map[string][]netip.Prefix       `json:"egressMasquerade,omitempty"`
+	DeniedPrefixes map[string][]netip.Prefix       `json:"deniedPrefixes,omitempty"`
+	ACLRules       map[string][]firewall.ACLRule   `json:"aclRules,omitempty"`
+	RateLimits     map[string][]firewall.RateLimit `json:"rateLimits,omitempty"`
+}
+
+// NAT64Plan describes a NAT64 translation device.
+type NAT64Plan struct {
+	Name         string         `json:"name"`
+	NetNs        string         `json:"netns,omitempty"`
+	Prefix       netip.Prefix   `json:"prefix"`
+	Pool         netip.Prefix   `json:"pool"`
+	Destinations []netip.Prefix `json:"destinations,omitempty"`
+}
+
+// DNSPlan describes the DNS configuration for an interface.
+type DNSPlan struct {
+	Servers       []netip.AddrPort `json:"servers,omitempty"`
+	SearchDomains []string         `json:"searchDomains,omitempty"`
+}
+
+// NewRecorder returns a new Recorder with no recorded changes.
+func NewRecorder() *Recorder {
+	return &Recorder{
+		ifaces: make(map[string]*recordedInterface),
+		dns:    make(map[string]*DNSPlan),
+	}
+}
+
+// Plan returns a copy of the changes recorded so far.
+func (r *Recorder) Plan() Plan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	plan := Plan{
+		RemovedInterfaces: slices.Clone(r.removed),
+		IPForwarding:      r.forwarding,
+	}
+	for _, name := range r.ifaceOrder {
+		iface, ok := r.ifaces[name]
+		if !ok {
+			continue
+		}
+		plan.Interfaces = append(plan.Interfaces, iface.plan())
+	}
+	if r.gateway != nil {
+		gw := *r.gateway
+		plan.DefaultIPv4Gateway = &gw
+	}
+	for _, fw := range r.firewalls {
+		if fw.closed {
+			continue
+		}
+		p := fw.FirewallPlan
+		p.Forwarding = slices.Clone(p.Forwarding)
+		p.Masquerade = slices.Clone(p.Masquerade)
+		p.SourceMasq = slices.Clone(p.SourceMasq)
+		if len(p.DeniedPrefixes) > 0 {
+			denied := make(map[string][]netip.Prefix, len(p.DeniedPrefixes))
+			for iface, prefixes := range p.DeniedPrefixes {
+				denied[iface] = slices.Clone(prefixes)
+			}
+			p.DeniedPrefixes = denied
+		}
+		if len(p.ACLRules) > 0 {
+			rules := make(map[string][]firewall.ACLRule, len(p.ACLRules))
+			for iface, r := range p.ACLRules {
+				rules[iface] = slices.Clone(r)
+			}
+			p.ACLRules = rules
+		}
+		if len(p.RateLimits) > 0 {
+			limits := make(map[string][]firewall.RateLimit, len(p.RateLimits))
+			for iface, l := range p.RateLimits {
+				limits[iface] = slices.Clone(l)
+			}
+			p.RateLimits = limits
+		}
+		plan.Firewalls = append(plan.Firewalls, p)
+	}
+	for _, nat := range r.nat64s {
+		if nat.closed {
+			continue
+		}
+		p := nat.plan
+		p.Destinations = slices.Clone(p.Destinations)
+		plan.NAT64 = append(plan.NAT64, p)
+	}
+	for iface, dns := range r.dns {
+		if len(dns.Servers) == 0 && len(dns.SearchDomains) == 0 {
+			continue
+		}
+		if plan.DNS == nil {
+			plan.DNS = make(map[string]DNSPlan)
+		}
+		plan.DNS[iface] = DNSPlan{
+			Servers:       slices.Clone(dns.Servers),
+			SearchDomains: slices.Clone(dns.SearchDomains),
+		}
+	}
+	return plan
+}
+
+func (r *Recorder) NewInterface(ctx context.Context, opts *system.Options) (system.Interface, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := opts.Name
+	if base, ok := strings.CutSuffix(name, "+"); ok {
+		// Mirror the kernel picking the next free index for wildcard names.
+		for i := 0; ; i++ {
+			name = base + strconv.Itoa(i)
+			if _, exists := r.ifaces[name]; !exists {
+				break
+			}
+		}
+	}
+	mtu := opts.MTU
+	if mtu == 0 {
+		mtu = system.DefaultMTU
+	}
+	iface := &recordedInterface{
+		r:     r,
+		name:  name,
+		netns: opts.NetNs,
+		mtu:   mtu,
+		// Interfaces are brought up as part of creating them.
+		up:     true,
+		addrv4: opts.AddressV4,
+		addrv6: opts.AddressV6,
+		peers:  make(map[wgtypes.Key]*PeerPlan),
+	}
+	if opts.AddressV4.IsValid() && !opts.DisableIPv4 {
+		iface.addrs = append(iface.addrs, opts.AddressV4)
+	}
+	if opts.AddressV6.IsValid() && !opts.DisableIPv6 {
+		iface.addrs = append(iface.addrs, opts.AddressV6)
+	}
+	if _, exists := r.ifaces[name]; !exists {
+		r.ifaceOrder = append(r.ifaceOrder, name)
+	}
+	r.ifaces[name] = iface
+	return iface, nil
+}
+
+func (r *Recorder) RemoveInterface(ctx context.Context, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.ifaces[name]; ok {
+		delete(r.ifaces, name)
+		return nil
+	}
+	r.removed = append(r.removed, name)
+	return nil
+}
+
+func (r *Recorder) EnableIPForwarding(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.forwarding = true
+	return nil
+}
+
+func (r *Recorder) SetDefaultIPv4Gateway(ctx context.Context, netns string, gw routes.Gateway) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gateway = &GatewayPlan{NetNs: netns, Name: gw.Name, Addr: gw.Addr}
+	return nil
+}
+
+func (r *Recorder) ConfigureDevice(ctx context.Context, netns, name string, cfg wgtypes.Config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	iface, ok := r.ifaces[name]
+	if !ok {
+		return errors.New("device not found: " + name)
+	}
+	if cfg.ListenPort != nil {
+		iface.listenPort = *cfg.ListenPort
+	}
+	if cfg.ReplacePeers {
+		clear(iface.peers)
+	}
+	for _, pc := range cfg.Peers {
+		if pc.Remove {
+			delete(iface.peers, pc.PublicKey)
+			continue
+		}
+		peer, ok := iface.peers[pc.PublicKey]
+		if !ok {
+			peer = &PeerPlan{PublicKey: pc.PublicKey.String()}
+			iface.peers[pc.PublicKey] = peer
+		}
+		if pc.Endpoint != nil {
+			peer.Endpoint = pc.Endpoint.String()
+		}
+		if pc.PersistentKeepaliveInterval != nil {
+			peer.PersistentKeepalive = *pc.PersistentKeepaliveInterval
+		}
+		if pc.ReplaceAllowedIPs {
+			peer.AllowedIPs = nil
+		}
+		for _, ipnet := range pc.AllowedIPs {
+			peer.AllowedIPs = append(peer.AllowedIPs, ipnet.String())
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) Device(ctx context.Context, netns, name string) (*wgtypes.Device, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	iface, ok := r.ifaces[name]
+	if !ok {
+		return nil, errors.New("device not found: " + name)
+	}
+	dev := &wgtypes.Device{
+		Name:       name,
+		Type:       wgtypes.Unknown,
+		ListenPort: iface.listenPort,
+	}
+	for key, peer := range iface.peers {
+		p := wgtypes.Peer{
+			PublicKey:                   key,
+			PersistentKeepaliveInterval: peer.PersistentKeepalive,
+		}
+		if peer.Endpoint != "" {
+			p.Endpoint, _ = net.ResolveUDPAddr("udp", peer.Endpoint)
+		}
+		for _, allowed := range peer.AllowedIPs {
+			if _, ipnet, err := net.ParseCIDR(allowed); err == nil {
+				p.AllowedIPs = append(p.AllowedIPs, *ipnet)
+			}
+		}
+		dev.Peers = append(dev.Peers, p)
+	}
+	return dev, nil
+}
+
+func (r *Recorder) NewFirewall(ctx context.Context, opts *firewall.Options) (firewall.Firewall, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fw := &recordedFirewall{
+		r: r,
+		FirewallPlan: FirewallPlan{
+			ID:            opts.ID,
+			NetNs:         opts.NetNs,
+			DefaultPolicy: opts.DefaultPolicy,
+			WireguardPort: opts.WireguardPort,
+			StoragePort:   opts.StoragePort,
+			GRPCPort:      opts.GRPCPort,
+		},
+	}
+	r.firewalls = append(r.firewalls, fw)
+	return fw, nil
+}
+
+func (r *Recorder) NewNAT64(ctx context.Context, opts *nat64.Options) (nat64.NAT64, error) {
+	o := *opts
+	o.Default()
+	if err := o.Validate(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	nat := &recordedNAT64{
+		r: r,
+		plan: NAT64Plan{
+			Name:         o.Name,
+			NetNs:        o.NetNs,
+			Prefix:       o.Prefix,
+			Pool:         o.Pool,
+			Destinations: slices.Clone(o.Destinations),
+		},
+	}
+	r.nat64s = append(r.nat64s, nat)
+	return nat, nil
+}
+
+func (r *Recorder) dnsFor(iface string) *DNSPlan {
+	dns, ok := r.dns[iface]
+	if !ok {
+		dns = &DNSPlan{}
+		r.dns[iface] = dns
+	}
+	return dns
+}
+
+func (r *Recorder) AddDNSServers(ctx context.Context, iface string, servers []netip.AddrPort) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dns := r.dnsFor(iface)
+	for _, server := range servers {
+		if !slices.Contains(dns.Servers, server) {
+			dns.Servers = append(dns.Servers, server)
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) RemoveDNSServers(ctx context.Context, iface string, servers []netip.AddrPort) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dns := r.dnsFor(iface)
+	dns.Servers = slices.DeleteFunc(dns.Servers, func(s netip.AddrPort) bool {
+		return slices.Contains(servers, s)
+	})
+	return nil
+}
+
+func (r *Recorder) AddSearchDomains(ctx context.Context, iface string, domains []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dns := r.dnsFor(iface)
+	for _, domain := range domains {
+		if !slices.Contains(dns.SearchDomains, domain) {
+			dns.SearchDomains = append(dns.SearchDomains, domain)
+		}
+	}
+	return nil
+}
+
+func (r *Recorder) RemoveSearchDomains(ctx context.Context, iface string, domains []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dns := r.dnsFor(iface)
+	dns.SearchDomains = slices.DeleteFunc(dns.SearchDomains, func(d string) bool {
+		return slices.Contains(domains, d)
+	})
+	return nil
+}
+
+// recordedInterface is an interface that only exists in a Recorder.
+type recordedInterface struct {
+	r          *Recorder
+	name       string
+	netns      string
+	mtu        uint32
+	addrv4     netip.Prefix
+	addrv6     netip.Prefix
+	up         bool
+	addrs      []netip.Prefix
+	routes     []netip.Prefix
+	listenPort int
+	peers      map[wgtypes.Key]*PeerPlan
+}
+
+// plan must be called with the recorder lock held.
+func (i *recordedInterface) plan() InterfacePlan {
+	p := InterfacePlan{
+		Name:       i.name,
+		NetNs:      i.netns,
+		MTU:        i.mtu,
+		Up:         i.up,
+		Addresses:  slices.Clone(i.addrs),
+		Routes:     slices.Clone(i.routes),
+		ListenPort: i.listenPort,
+	}
+	for _, peer := range i.peers {
+		peer := *peer
+		peer.AllowedIPs = slices.Clone(peer.AllowedIPs)
+		p.Peers = append(p.Peers, peer)
+	}
+	slices.SortFunc(p.Peers, func(a, b PeerPlan) int {
+		return strings.Compare(a.PublicKey, b.PublicKey)
+	})
+	return p
+}
+
+func (i *recordedInterface) Name() string            { return i.name }
+func (i *recordedInterface) AddressV4() netip.Prefix { return i.addrv4 }
+func (i *recordedInterface) AddressV6() netip.Prefix { return i.addrv6 }
+
+func (i *recordedInterface) Up(ctx context.Context) error {
+	i.r.mu.Lock()
+	defer i.r.mu.Unlock()
+	i.up = true
+	return nil
+}
+
+func (i *recordedInterface) Down(ctx context.Context) error {
+	i.r.mu.Lock()
+	defer i.r.mu.Unlock()
+	i.up = false
+	return nil
+}
+
+func (i *recordedInterface) Destroy(ctx context.Context) error {
+	i.r.mu.Lock()
+	defer i.r.mu.Unlock()
+	delete(i.r.ifaces, i.name)
+	return nil
+}
+
+func (i *recordedInterface) AddAddress(ctx context.Context, addr netip.Prefix) error {
+	i.r.mu.Lock()
+	defer i.r.mu.Unlock()
+	if !slices.Contains(i.addrs, addr) {
+		i.addrs = append(i.addrs, addr)
+	}
+	return nil
+}
+
+func (i *recordedInterface) RemoveAddress(ctx context.Context, addr netip.Prefix) error {
+	i.r.mu.Lock()
+	defer i.r.mu.Unlock()
+	i.addrs = slices.DeleteFunc(i.addrs, func(p netip.Prefix) bool { return p == addr })
+	return nil
+}
+
+func (i *recordedInterface) AddRoute(ctx context.Context, network netip.Prefix) error {
+	i.r.mu.Lock()
+	defer i.r.mu.Unlock()
+	if slices.Contains(i.routes, network) {
+		return routes.ErrRouteExists
+	}
+	i.routes = append(i.routes, network)
+	return nil
+}
+
+func (i *recordedInterface) RemoveRoute(ctx context.Context, network netip.Prefix) error {
+	i.r.mu.Lock()
+	defer i.r.mu.Unlock()
+	i.routes = slices.DeleteFunc(i.routes, func(p netip.Prefix) bool { return p == network })
+	return nil
+}
+
+// Link always fails since the interface does not exist on the host.
+func (i *recordedInterface) Link() (*net.Interface, error) {
+	return nil, errors.New("interface " + i.name + " is not created in dry-run mode")
+}
+
+// HardwareAddr always fails since the interface does not exist on the host.
+func (i *recordedInterface) HardwareAddr() (net.HardwareAddr, error) {
+	_, err := i.Link()
+	return nil, err
+}
+
+// recordedFirewall is a firewall that only exists in a Recorder.
+type recordedFirewall struct {
+	FirewallPlan
+	r      *Recorder
+	closed bool
+}
+
+func (f *recordedFirewall) AddWireguardForwarding(ctx context.Context, ifaceName string) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+	if !slices.Contains(f.Forwarding, ifaceName) {
+		f.Forwarding = append(f.Forwarding, ifaceName)
+	}
+	return nil
+}
+
+func (f *recordedFirewall) AddMasquerade(ctx context.Context, ifaceName string) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+	if !slices.Contains(f.Masquerade, ifaceName) {
+		f.Masquerade = append(f.Masquerade, ifaceName)
+	}
+	return nil
+}
+
+func (f *recordedFirewall) AddSourceMasquerade(ctx context.Context, prefix netip.Prefix) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+	if !slices.Contains(f.SourceMasq, prefix) {
+		f.SourceMasq = append(f.SourceMasq, prefix)
+	}
+	return nil
+}
+
+func (f *recordedFirewall) AddEgressMasquerade(ctx context.Context, ifaceName string, prefix netip.Prefix) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+	if f.EgressMasq == nil {
+		f.EgressMasq = make(map[string][]netip.Prefix)
+	}
+	if !slices.Contains(f.EgressMasq[ifaceName], prefix) {
+		f.EgressMasq[ifaceName] = append(f.EgressMasq[ifaceName], prefix)
+	}
+	return nil
+}
+
+func (f *recordedFirewall) SetDeniedPrefixes(ctx context.Context, ifaceName string, prefixes []netip.Prefix) (int, error) {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+	if len(prefixes) == 0 {
+		delete(f.DeniedPrefixes, ifaceName)
+		return 0, nil
+	}
+	if f.DeniedPrefixes == nil {
+		f.DeniedPrefixes = make(map[string][]netip.Prefix)
+	}
+	f.DeniedPrefixes[ifaceName] = slices.Clone(prefixes)
+	return 0, nil
+}
+
+func (f *recordedFirewall) SetACLRules(ctx context.Context, ifaceName string, rules []firewall.ACLRule) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+	if len(rules) == 0 {
+		delete(f.ACLRules, ifaceName)
+		return nil
+	}
+	if f.ACLRules == nil {
+		f.ACLRules = make(map[string][]firewall.ACLRule)
+	}
+	f.ACLRules[ifaceName] = slices.Clone(rules)
+	return nil
+}
+
+func (f *recordedFirewall) SetRateLimits(ctx context.Context, ifaceName string, limits []firewall.RateLimit) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+	if len(limits) == 0 {
+		delete(f.RateLimits, ifaceName)
+		return nil
+	}
+	if f.RateLimits == nil {
+		f.RateLimits = make(map[string][]firewall.RateLimit)
+	}
+	f.RateLimits[ifaceName] = slices.Clone(limits)
+	return nil
+}
+
+func (f *recordedFirewall) Clear(ctx context.Context) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+	f.Forwarding = nil
+	f.Masquerade = nil
+	f.SourceMasq = nil
+	f.DeniedPrefixes = nil
+	f.ACLRules = nil
+	f.RateLimits = nil
+	return nil
+}
+
+func (f *recordedFirewall) Close(ctx context.Context) error {
+	f.r.mu.Lock()
+	defer f.r.mu.Unlock()
+	f.closed = true
+	return nil
+}
+
+// recordedNAT64 is a NAT64 device that only exists in a Recorder.
+type recordedNAT64 struct {
+	plan   NAT64Plan
+	r      *Recorder
+	closed bool
+}
+
+func (n *recordedNAT64) Name() string { return n.plan.Name }
+
+func (n *recordedNAT64) Close(ctx context.Context) error {
+	n.r.mu.Lock()
+	defer n.r.mu.Unlock()
+	n.closed = true
+	return nil
+}
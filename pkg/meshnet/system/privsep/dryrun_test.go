@@ -0,0 +1,131 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privsep
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+)
+
+func TestRecorder(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	rec := NewRecorder()
+
+	addr := netip.MustParsePrefix("172.16.0.1/32")
+	iface, err := rec.NewInterface(ctx, &system.Options{Name: "webmesh+", AddressV4: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface.Name() != "webmesh0" {
+		t.Fatalf("expected wildcard name to resolve to webmesh0, got %s", iface.Name())
+	}
+	route := netip.MustParsePrefix("10.0.0.0/8")
+	if err := iface.Up(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := iface.AddRoute(ctx, route); err != nil {
+		t.Fatal(err)
+	}
+	if err := iface.AddRoute(ctx, route); !system.IsRouteExists(err) {
+		t.Fatalf("expected route exists error, got %v", err)
+	}
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := 51820
+	keepalive := 30 * time.Second
+	_, allowed, _ := net.ParseCIDR("172.16.0.2/32")
+	err = rec.ConfigureDevice(ctx, "", iface.Name(), wgtypes.Config{
+		ListenPort: &port,
+		Peers: []wgtypes.PeerConfig{{
+			PublicKey:                   key.PublicKey(),
+			Endpoint:                    &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820},
+			PersistentKeepaliveInterval: &keepalive,
+			AllowedIPs:                  []net.IPNet{*allowed},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := rec.Device(ctx, "", iface.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.ListenPort != port || len(dev.Peers) != 1 || len(dev.Peers[0].AllowedIPs) != 1 {
+		t.Fatalf("unexpected device state %+v", dev)
+	}
+	fw, err := rec.NewFirewall(ctx, &firewall.Options{ID: "test", DefaultPolicy: firewall.PolicyAccept})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.AddWireguardForwarding(ctx, iface.Name()); err != nil {
+		t.Fatal(err)
+	}
+	denied := []netip.Prefix{netip.MustParsePrefix("172.16.0.3/32")}
+	if _, err := fw.SetDeniedPrefixes(ctx, iface.Name(), denied); err != nil {
+		t.Fatal(err)
+	}
+	dns := []netip.AddrPort{netip.MustParseAddrPort("172.16.0.1:53")}
+	if err := rec.AddDNSServers(ctx, iface.Name(), dns); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.EnableIPForwarding(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	plan := rec.Plan()
+	if len(plan.Interfaces) != 1 {
+		t.Fatalf("expected 1 interface, got %d", len(plan.Interfaces))
+	}
+	ip := plan.Interfaces[0]
+	if !ip.Up || ip.MTU != system.DefaultMTU || len(ip.Addresses) != 1 || len(ip.Routes) != 1 {
+		t.Fatalf("unexpected interface plan %+v", ip)
+	}
+	if len(ip.Peers) != 1 || ip.Peers[0].PublicKey != key.PublicKey().String() || ip.Peers[0].Endpoint != "192.0.2.1:51820" {
+		t.Fatalf("unexpected peers %+v", ip.Peers)
+	}
+	if !plan.IPForwarding {
+		t.Fatal("expected ip forwarding to be recorded")
+	}
+	if len(plan.Firewalls) != 1 || len(plan.Firewalls[0].Forwarding) != 1 || len(plan.Firewalls[0].DeniedPrefixes[iface.Name()]) != 1 {
+		t.Fatalf("unexpected firewall plan %+v", plan.Firewalls)
+	}
+	if len(plan.DNS[iface.Name()].Servers) != 1 {
+		t.Fatalf("unexpected dns plan %+v", plan.DNS)
+	}
+
+	// Tearing down after taking the plan must not affect it.
+	if err := iface.Destroy(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := fw.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Interfaces) != 1 || len(rec.Plan().Interfaces) != 0 || len(rec.Plan().Firewalls) != 0 {
+		t.Fatal("expected teardown to be reflected only in new plans")
+	}
+}
@@ -0,0 +1,410 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privsep
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"net/rpc"
+	"sync"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/nat64"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+)
+
+// serviceName is the name the helper service is registered under.
+const serviceName = "Privsep"
+
+// InterfaceOp is an operation on an interface created through the helper.
+type InterfaceOp string
+
+const (
+	InterfaceUp            InterfaceOp = "up"
+	InterfaceDown          InterfaceOp = "down"
+	InterfaceDestroy       InterfaceOp = "destroy"
+	InterfaceAddAddress    InterfaceOp = "add-address"
+	InterfaceRemoveAddress InterfaceOp = "remove-address"
+	InterfaceAddRoute      InterfaceOp = "add-route"
+	InterfaceRemoveRoute   InterfaceOp = "remove-route"
+)
+
+// FirewallOp is an operation on a firewall created through the helper.
+type FirewallOp string
+
+const (
+	FirewallAddForwarding FirewallOp = "add-forwarding"
+	FirewallAddMasquerade FirewallOp = "add-masquerade"
+	FirewallAddSourceMasq FirewallOp = "add-source-masquerade"
+	FirewallAddEgressMasq FirewallOp = "add-egress-masquerade"
+	FirewallClear         FirewallOp = "clear"
+	FirewallClose         FirewallOp = "close"
+	FirewallSetDenied     FirewallOp = "set-denied-prefixes"
+	FirewallSetACLRules   FirewallOp = "set-acl-rules"
+	FirewallSetLimits     FirewallOp = "set-rate-limits"
+)
+
+// The following types are the messages exchanged with the helper.
+
+// Empty is an empty request or response.
+type Empty struct{}
+
+// NewInterfaceRequest is a request to create an interface.
+type NewInterfaceRequest struct {
+	Options system.Options
+}
+
+// NewInterfaceResponse is the response to a NewInterfaceRequest.
+type NewInterfaceResponse struct {
+	Name string
+}
+
+// InterfaceRequest is a request to perform an operation on an interface.
+type InterfaceRequest struct {
+	Name   string
+	Op     InterfaceOp
+	Prefix netip.Prefix
+}
+
+// NameRequest is a request that only carries a name.
+type NameRequest struct {
+	Name string
+}
+
+// GatewayRequest is a request to set the default gateway.
+type GatewayRequest struct {
+	NetNs   string
+	Gateway routes.Gateway
+}
+
+// DeviceRequest is a request to read or configure a WireGuard device.
+type DeviceRequest struct {
+	NetNs  string
+	Name   string
+	Config wgtypes.Config
+}
+
+// NewFirewallRequest is a request to create a firewall.
+type NewFirewallRequest struct {
+	Options firewall.Options
+}
+
+// FirewallRequest is a request to perform an operation on a firewall.
+type FirewallRequest struct {
+	ID        string
+	Op        FirewallOp
+	Interface string
+	Prefixes  []netip.Prefix
+	Rules     []firewall.ACLRule
+	Limits    []firewall.RateLimit
+}
+
+// FirewallResponse is the response to a FirewallRequest.
+type FirewallResponse struct {
+	Flows int
+}
+
+// NewNAT64Request is a request to create a NAT64 translation device.
+type NewNAT64Request struct {
+	Options nat64.Options
+}
+
+// DNSRequest is a request to change the system DNS configuration.
+type DNSRequest struct {
+	Interface string
+	Servers   []netip.AddrPort
+	Domains   []string
+	Remove    bool
+}
+
+// Helper serves privileged operations to an unprivileged node process.
+// Operations on interfaces and firewalls are only allowed on those created
+// through the helper.
+type Helper struct {
+	ops       Ops
+	ctx       context.Context
+	log       *slog.Logger
+	ifaces    map[string]system.Interface
+	firewalls map[string]firewall.Firewall
+	nat64s    map[string]nat64.NAT64
+	mu        sync.Mutex
+}
+
+// NewHelper returns a new helper performing operations with the given ops.
+// If ops is nil, Local is used.
+func NewHelper(ctx context.Context, ops Ops) *Helper {
+	log := context.LoggerFrom(ctx).With("component", "privsep-helper")
+	return &Helper{
+		ops:       OrLocal(ops),
+		ctx:       context.WithLogger(context.Background(), log),
+		log:       log,
+		ifaces:    make(map[string]system.Interface),
+		firewalls: make(map[string]firewall.Firewall),
+		nat64s:    make(map[string]nat64.NAT64),
+	}
+}
+
+// Serve serves requests on the listener until the context is canceled or
+// the listener is closed.
+func (h *Helper) Serve(ctx context.Context, ln net.Listener) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName(serviceName, &helperService{h}); err != nil {
+		return fmt.Errorf("register helper service: %w", err)
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("accept: %w", err)
+		}
+		h.log.Debug("Accepted connection from node process")
+		go srv.ServeConn(conn)
+	}
+}
+
+// Close reverts the changes made through the helper by clearing firewalls
+// and destroying interfaces and NAT64 devices that are still present.
+func (h *Helper) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var errs []error
+	for id, fw := range h.firewalls {
+		if err := fw.Close(h.ctx); err != nil {
+			errs = append(errs, fmt.Errorf("close firewall %s: %w", id, err))
+		}
+		delete(h.firewalls, id)
+	}
+	for name, nat := range h.nat64s {
+		if err := nat.Close(h.ctx); err != nil {
+			errs = append(errs, fmt.Errorf("close nat64 %s: %w", name, err))
+		}
+		delete(h.nat64s, name)
+	}
+	for name, iface := range h.ifaces {
+		if err := iface.Destroy(h.ctx); err != nil {
+			errs = append(errs, fmt.Errorf("destroy interface %s: %w", name, err))
+		}
+		delete(h.ifaces, name)
+	}
+	return errors.Join(errs...)
+}
+
+func (h *Helper) iface(name string) (system.Interface, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	iface, ok := h.ifaces[name]
+	if !ok {
+		return nil, fmt.Errorf("interface %s was not created by the helper", name)
+	}
+	return iface, nil
+}
+
+// helperService exposes the helper over net/rpc.
+type helperService struct {
+	h *Helper
+}
+
+func (s *helperService) NewInterface(req *NewInterfaceRequest, resp *NewInterfaceResponse) error {
+	opts := req.Options
+	s.h.log.Info("Creating interface", slog.String("name", opts.Name))
+	iface, err := s.h.ops.NewInterface(s.h.ctx, &opts)
+	if err != nil {
+		return err
+	}
+	s.h.mu.Lock()
+	s.h.ifaces[iface.Name()] = iface
+	s.h.mu.Unlock()
+	resp.Name = iface.Name()
+	return nil
+}
+
+func (s *helperService) Interface(req *InterfaceRequest, _ *Empty) error {
+	iface, err := s.h.iface(req.Name)
+	if err != nil {
+		return err
+	}
+	ctx := s.h.ctx
+	s.h.log.Debug("Interface operation", slog.String("name", req.Name), slog.String("op", string(req.Op)), slog.String("prefix", req.Prefix.String()))
+	switch req.Op {
+	case InterfaceUp:
+		return iface.Up(ctx)
+	case InterfaceDown:
+		return iface.Down(ctx)
+	case InterfaceDestroy:
+		s.h.mu.Lock()
+		delete(s.h.ifaces, req.Name)
+		s.h.mu.Unlock()
+		return iface.Destroy(ctx)
+	case InterfaceAddAddress:
+		return iface.AddAddress(ctx, req.Prefix)
+	case InterfaceRemoveAddress:
+		return iface.RemoveAddress(ctx, req.Prefix)
+	case InterfaceAddRoute:
+		return iface.AddRoute(ctx, req.Prefix)
+	case InterfaceRemoveRoute:
+		return iface.RemoveRoute(ctx, req.Prefix)
+	default:
+		return fmt.Errorf("unknown interface operation %q", req.Op)
+	}
+}
+
+func (s *helperService) RemoveInterface(req *NameRequest, _ *Empty) error {
+	// Only allow removing WireGuard interfaces so that the node process
+	// can't be used to take down arbitrary links.
+	if _, err := s.h.ops.Device(s.h.ctx, "", req.Name); err != nil {
+		return fmt.Errorf("refusing to remove %s: not a wireguard interface", req.Name)
+	}
+	s.h.log.Info("Removing interface", slog.String("name", req.Name))
+	return s.h.ops.RemoveInterface(s.h.ctx, req.Name)
+}
+
+func (s *helperService) EnableIPForwarding(_ *Empty, _ *Empty) error {
+	return s.h.ops.EnableIPForwarding(s.h.ctx)
+}
+
+func (s *helperService) SetDefaultIPv4Gateway(req *GatewayRequest, _ *Empty) error {
+	s.h.log.Info("Setting default IPv4 gateway", slog.String("name", req.Gateway.Name), slog.String("addr", req.Gateway.Addr.String()))
+	return s.h.ops.SetDefaultIPv4Gateway(s.h.ctx, req.NetNs, req.Gateway)
+}
+
+func (s *helperService) ConfigureDevice(req *DeviceRequest, _ *Empty) error {
+	if _, err := s.h.iface(req.Name); err != nil {
+		return err
+	}
+	return s.h.ops.ConfigureDevice(s.h.ctx, req.NetNs, req.Name, req.Config)
+}
+
+func (s *helperService) Device(req *DeviceRequest, resp *wgtypes.Device) error {
+	device, err := s.h.ops.Device(s.h.ctx, req.NetNs, req.Name)
+	if err != nil {
+		return err
+	}
+	*resp = *device
+	return nil
+}
+
+func (s *helperService) NewFirewall(req *NewFirewallRequest, _ *Empty) error {
+	opts := req.Options
+	s.h.mu.Lock()
+	defer s.h.mu.Unlock()
+	if _, ok := s.h.firewalls[opts.ID]; ok {
+		return fmt.Errorf("firewall %q already exists", opts.ID)
+	}
+	s.h.log.Info("Creating firewall", slog.String("id", opts.ID))
+	fw, err := s.h.ops.NewFirewall(s.h.ctx, &opts)
+	if err != nil {
+		return err
+	}
+	s.h.firewalls[opts.ID] = fw
+	return nil
+}
+
+func (s *helperService) Firewall(req *FirewallRequest, resp *FirewallResponse) error {
+	s.h.mu.Lock()
+	fw, ok := s.h.firewalls[req.ID]
+	if ok && req.Op == FirewallClose {
+		delete(s.h.firewalls, req.ID)
+	}
+	s.h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("firewall %q was not created by the helper", req.ID)
+	}
+	s.h.log.Debug("Firewall operation", slog.String("id", req.ID), slog.String("op", string(req.Op)))
+	switch req.Op {
+	case FirewallAddForwarding:
+		return fw.AddWireguardForwarding(s.h.ctx, req.Interface)
+	case FirewallAddMasquerade:
+		return fw.AddMasquerade(s.h.ctx, req.Interface)
+	case FirewallAddSourceMasq:
+		if len(req.Prefixes) != 1 {
+			return fmt.Errorf("source masquerade requires a single prefix")
+		}
+		return fw.AddSourceMasquerade(s.h.ctx, req.Prefixes[0])
+	case FirewallAddEgressMasq:
+		if len(req.Prefixes) != 1 {
+			return fmt.Errorf("egress masquerade requires a single prefix")
+		}
+		return fw.AddEgressMasquerade(s.h.ctx, req.Interface, req.Prefixes[0])
+	case FirewallClear:
+		return fw.Clear(s.h.ctx)
+	case FirewallClose:
+		return fw.Close(s.h.ctx)
+	case FirewallSetDenied:
+		flows, err := fw.SetDeniedPrefixes(s.h.ctx, req.Interface, req.Prefixes)
+		resp.Flows = flows
+		return err
+	case FirewallSetACLRules:
+		return fw.SetACLRules(s.h.ctx, req.Interface, req.Rules)
+	case FirewallSetLimits:
+		return fw.SetRateLimits(s.h.ctx, req.Interface, req.Limits)
+	default:
+		return fmt.Errorf("unknown firewall operation %q", req.Op)
+	}
+}
+
+func (s *helperService) NewNAT64(req *NewNAT64Request, resp *NameRequest) error {
+	opts := req.Options
+	s.h.log.Info("Creating NAT64 device", slog.String("name", opts.Name))
+	nat, err := s.h.ops.NewNAT64(s.h.ctx, &opts)
+	if err != nil {
+		return err
+	}
+	s.h.mu.Lock()
+	s.h.nat64s[nat.Name()] = nat
+	s.h.mu.Unlock()
+	resp.Name = nat.Name()
+	return nil
+}
+
+func (s *helperService) CloseNAT64(req *NameRequest, _ *Empty) error {
+	s.h.mu.Lock()
+	nat, ok := s.h.nat64s[req.Name]
+	delete(s.h.nat64s, req.Name)
+	s.h.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("nat64 device %s was not created by the helper", req.Name)
+	}
+	s.h.log.Info("Closing NAT64 device", slog.String("name", req.Name))
+	return nat.Close(s.h.ctx)
+}
+
+func (s *helperService) DNSServers(req *DNSRequest, _ *Empty) error {
+	if req.Remove {
+		return s.h.ops.RemoveDNSServers(s.h.ctx, req.Interface, req.Servers)
+	}
+	return s.h.ops.AddDNSServers(s.h.ctx, req.Interface, req.Servers)
+}
+
+func (s *helperService) SearchDomains(req *DNSRequest, _ *Empty) error {
+	if req.Remove {
+		return s.h.ops.RemoveSearchDomains(s.h.ctx, req.Interface, req.Domains)
+	}
+	return s.h.ops.AddSearchDomains(s.h.ctx, req.Interface, req.Domains)
+}
@@ -0,0 +1,37 @@
+//go:build !unix
+
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privsep
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrUnsupported is returned on platforms that do not support privilege separation.
+var ErrUnsupported = errors.New("privilege separation is not supported on this platform")
+
+// Listen creates the helper socket.
+func Listen(socket, username, group string) (net.Listener, error) {
+	return nil, ErrUnsupported
+}
+
+// DropPrivileges switches the current process to the given user and group.
+func DropPrivileges(username, group string) error {
+	return ErrUnsupported
+}
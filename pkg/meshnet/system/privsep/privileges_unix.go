@@ -0,0 +1,106 @@
+//go:build unix
+
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privsep
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// Listen creates the helper socket. Only the given user and group, or
+// root if they are empty, are able to connect to it.
+func Listen(socket, username, group string) (net.Listener, error) {
+	uid, gid, err := lookupIDs(username, group)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(socket), 0755); err != nil {
+		return nil, fmt.Errorf("create socket directory: %w", err)
+	}
+	if err := os.Remove(socket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("remove stale socket: %w", err)
+	}
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", socket, err)
+	}
+	if err := os.Chmod(socket, 0600); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("chmod socket: %w", err)
+	}
+	if err := os.Chown(socket, uid, gid); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("chown socket: %w", err)
+	}
+	return ln, nil
+}
+
+// DropPrivileges switches the current process to the given user and group.
+// If group is empty, the primary group of the user is used.
+func DropPrivileges(username, group string) error {
+	if username == "" {
+		return errors.New("no user to drop privileges to")
+	}
+	uid, gid, err := lookupIDs(username, group)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("setgroups: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("setgid: %w", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("setuid: %w", err)
+	}
+	return nil
+}
+
+func lookupIDs(username, group string) (uid, gid int, err error) {
+	uid, gid = os.Getuid(), os.Getgid()
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return 0, 0, fmt.Errorf("lookup user %s: %w", username, err)
+		}
+		if uid, err = strconv.Atoi(u.Uid); err != nil {
+			return 0, 0, fmt.Errorf("parse uid: %w", err)
+		}
+		if gid, err = strconv.Atoi(u.Gid); err != nil {
+			return 0, 0, fmt.Errorf("parse gid: %w", err)
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return 0, 0, fmt.Errorf("lookup group %s: %w", group, err)
+		}
+		if gid, err = strconv.Atoi(g.Gid); err != nil {
+			return 0, 0, fmt.Errorf("parse gid: %w", err)
+		}
+	}
+	return uid, gid, nil
+}
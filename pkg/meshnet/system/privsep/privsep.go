@@ -0,0 +1,158 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package privsep implements privilege separation for the system network
+// operations performed by a node. A small helper process running with
+// elevated privileges performs interface, route, firewall, and DNS changes
+// on behalf of the node process over a local socket, allowing the node
+// process to drop its privileges after startup.
+package privsep
+
+import (
+	"fmt"
+	"net/netip"
+	"runtime"
+
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/nat64"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/dns"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/link"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+)
+
+// Ops are the privileged operations required to manage the mesh network.
+type Ops interface {
+	// NewInterface creates a new system interface.
+	NewInterface(ctx context.Context, opts *system.Options) (system.Interface, error)
+	// RemoveInterface removes the WireGuard interface with the given name.
+	RemoveInterface(ctx context.Context, name string) error
+	// EnableIPForwarding enables IP forwarding on the system.
+	EnableIPForwarding(ctx context.Context) error
+	// SetDefaultIPv4Gateway sets the default IPv4 gateway in the given network namespace.
+	SetDefaultIPv4Gateway(ctx context.Context, netns string, gw routes.Gateway) error
+	// ConfigureDevice applies the configuration to the WireGuard device.
+	ConfigureDevice(ctx context.Context, netns, name string, cfg wgtypes.Config) error
+	// Device returns the current state of the WireGuard device.
+	Device(ctx context.Context, netns, name string) (*wgtypes.Device, error)
+	// NewFirewall creates a new firewall manager.
+	NewFirewall(ctx context.Context, opts *firewall.Options) (firewall.Firewall, error)
+	// NewNAT64 creates a NAT64 translation device.
+	NewNAT64(ctx context.Context, opts *nat64.Options) (nat64.NAT64, error)
+	// AddDNSServers adds DNS servers to the system configuration for the interface.
+	AddDNSServers(ctx context.Context, iface string, servers []netip.AddrPort) error
+	// RemoveDNSServers removes DNS servers from the system configuration for the interface.
+	RemoveDNSServers(ctx context.Context, iface string, servers []netip.AddrPort) error
+	// AddSearchDomains adds search domains to the system configuration for the interface.
+	AddSearchDomains(ctx context.Context, iface string, domains []string) error
+	// RemoveSearchDomains removes search domains from the system configuration for the interface.
+	RemoveSearchDomains(ctx context.Context, iface string, domains []string) error
+}
+
+// Local performs operations directly in the current process. It is used
+// when privilege separation is disabled and by the helper process.
+var Local Ops = localOps{}
+
+// OrLocal returns ops, or Local if ops is nil.
+func OrLocal(ops Ops) Ops {
+	if ops == nil {
+		return Local
+	}
+	return ops
+}
+
+type localOps struct{}
+
+func inNetNS(netns string, fn func() error) error {
+	if runtime.GOOS == "linux" && netns != "" {
+		return system.DoInNetNS(netns, fn)
+	}
+	return fn()
+}
+
+func (localOps) NewInterface(ctx context.Context, opts *system.Options) (system.Interface, error) {
+	return system.New(ctx, opts)
+}
+
+func (localOps) RemoveInterface(ctx context.Context, name string) error {
+	return link.RemoveInterface(ctx, name)
+}
+
+func (localOps) EnableIPForwarding(ctx context.Context) error {
+	return routes.EnableIPForwarding()
+}
+
+func (localOps) SetDefaultIPv4Gateway(ctx context.Context, netns string, gw routes.Gateway) error {
+	return inNetNS(netns, func() error {
+		return routes.SetDefaultIPv4Gateway(ctx, gw)
+	})
+}
+
+func (localOps) ConfigureDevice(ctx context.Context, netns, name string, cfg wgtypes.Config) error {
+	return inNetNS(netns, func() error {
+		cli, err := wgctrl.New()
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+		return cli.ConfigureDevice(name, cfg)
+	})
+}
+
+func (localOps) Device(ctx context.Context, netns, name string) (*wgtypes.Device, error) {
+	var device *wgtypes.Device
+	err := inNetNS(netns, func() error {
+		cli, err := wgctrl.New()
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+		device, err = cli.Device(name)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get device %s: %w", name, err)
+	}
+	return device, nil
+}
+
+func (localOps) NewFirewall(ctx context.Context, opts *firewall.Options) (firewall.Firewall, error) {
+	return firewall.New(ctx, opts)
+}
+
+func (localOps) NewNAT64(ctx context.Context, opts *nat64.Options) (nat64.NAT64, error) {
+	return nat64.New(ctx, *opts)
+}
+
+func (localOps) AddDNSServers(ctx context.Context, iface string, servers []netip.AddrPort) error {
+	return dns.AddServers(iface, servers)
+}
+
+func (localOps) RemoveDNSServers(ctx context.Context, iface string, servers []netip.AddrPort) error {
+	return dns.RemoveServers(iface, servers)
+}
+
+func (localOps) AddSearchDomains(ctx context.Context, iface string, domains []string) error {
+	return dns.AddSearchDomains(iface, domains)
+}
+
+func (localOps) RemoveSearchDomains(ctx context.Context, iface string, domains []string) error {
+	return dns.RemoveSearchDomains(iface, domains)
+}
@@ -0,0 +1,337 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package privsep
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/nat64"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
+)
+
+func TestHelper(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	fake := &fakeOps{devices: map[string]bool{}}
+	client := newTestClient(t, fake)
+
+	t.Run("Interfaces", func(t *testing.T) {
+		addr := netip.MustParsePrefix("172.16.0.1/32")
+		iface, err := client.NewInterface(ctx, &system.Options{Name: "wgtest0", AddressV4: addr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if iface.Name() != "wgtest0" || iface.AddressV4() != addr {
+			t.Fatalf("unexpected interface %s %s", iface.Name(), iface.AddressV4())
+		}
+		route := netip.MustParsePrefix("10.0.0.0/8")
+		if err := iface.Up(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := iface.AddRoute(ctx, route); err != nil {
+			t.Fatal(err)
+		}
+		err = iface.AddRoute(ctx, route)
+		if !system.IsRouteExists(err) {
+			t.Fatalf("expected route exists error, got %v", err)
+		}
+		if err := client.ConfigureDevice(ctx, "", "wgtest0", wgtypes.Config{}); err != nil {
+			t.Fatal(err)
+		}
+		if err := iface.Destroy(ctx); err != nil {
+			t.Fatal(err)
+		}
+		fake.expect(t, "new wgtest0", "up wgtest0", "route wgtest0 10.0.0.0/8", "configure wgtest0", "destroy wgtest0")
+		// Operations on interfaces the helper did not create are refused.
+		if err := iface.Up(ctx); err == nil {
+			t.Fatal("expected error on destroyed interface")
+		}
+		if err := client.ConfigureDevice(ctx, "", "eth0", wgtypes.Config{}); err == nil {
+			t.Fatal("expected error configuring unknown device")
+		}
+		if err := client.RemoveInterface(ctx, "eth0"); err == nil {
+			t.Fatal("expected error removing non-wireguard interface")
+		}
+		fake.expect(t)
+	})
+
+	t.Run("Firewall", func(t *testing.T) {
+		fw, err := client.NewFirewall(ctx, &firewall.Options{ID: "test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.NewFirewall(ctx, &firewall.Options{ID: "test"}); err == nil {
+			t.Fatal("expected error creating duplicate firewall")
+		}
+		if err := fw.AddMasquerade(ctx, "wgtest0"); err != nil {
+			t.Fatal(err)
+		}
+		if err := fw.AddSourceMasquerade(ctx, netip.MustParsePrefix("192.168.255.0/24")); err != nil {
+			t.Fatal(err)
+		}
+		if err := fw.AddEgressMasquerade(ctx, "wgtest0", netip.MustParsePrefix("172.16.0.0/12")); err != nil {
+			t.Fatal(err)
+		}
+		limits := []firewall.RateLimit{{Prefix: netip.MustParsePrefix("172.16.0.2/32"), Rate: 1_000_000}}
+		if err := fw.SetRateLimits(ctx, "wgtest0", limits); err != nil {
+			t.Fatal(err)
+		}
+		if err := fw.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := fw.Clear(ctx); err == nil {
+			t.Fatal("expected error on closed firewall")
+		}
+		fake.expect(t, "firewall test", "masquerade test wgtest0", "source masquerade test 192.168.255.0/24", "egress masquerade test wgtest0 172.16.0.0/12", "rate limits test wgtest0 [172.16.0.2/32 1000000/0 bit/s]", "close test")
+	})
+
+	t.Run("NAT64", func(t *testing.T) {
+		nat, err := client.NewNAT64(ctx, &nat64.Options{Name: "nat64test"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nat.Name() != "nat64test" {
+			t.Fatalf("unexpected nat64 device %s", nat.Name())
+		}
+		if err := nat.Close(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if err := nat.Close(ctx); err == nil {
+			t.Fatal("expected error on closed nat64 device")
+		}
+		fake.expect(t, "nat64 nat64test", "close nat64 nat64test")
+	})
+
+	t.Run("DNS", func(t *testing.T) {
+		servers := []netip.AddrPort{netip.MustParseAddrPort("10.0.0.1:53")}
+		if err := client.AddDNSServers(ctx, "wgtest0", servers); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.RemoveSearchDomains(ctx, "wgtest0", []string{"webmesh.internal"}); err != nil {
+			t.Fatal(err)
+		}
+		if err := client.SetDefaultIPv4Gateway(ctx, "", routes.Gateway{Name: "eth0", Addr: netip.MustParseAddr("192.168.1.1")}); err != nil {
+			t.Fatal(err)
+		}
+		fake.expect(t, "dns add [10.0.0.1:53]", "domains remove [webmesh.internal]", "gateway eth0 192.168.1.1")
+	})
+}
+
+func newTestClient(t *testing.T, ops Ops) *Client {
+	t.Helper()
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "privsep.sock"))
+	if err != nil {
+		t.Skip("unix sockets not available:", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	helper := NewHelper(ctx, ops)
+	done := make(chan error, 1)
+	go func() { done <- helper.Serve(ctx, ln) }()
+	client, err := Dial(ctx, ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		cancel()
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+		if err := helper.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	return client
+}
+
+type fakeOps struct {
+	calls   []string
+	devices map[string]bool
+	mu      sync.Mutex
+}
+
+func (f *fakeOps) record(format string, args ...any) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, fmt.Sprintf(format, args...))
+}
+
+func (f *fakeOps) expect(t *testing.T, calls ...string) {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.calls) != len(calls) {
+		t.Fatalf("expected calls %q, got %q", calls, f.calls)
+	}
+	for i := range calls {
+		if f.calls[i] != calls[i] {
+			t.Fatalf("expected calls %q, got %q", calls, f.calls)
+		}
+	}
+	f.calls = nil
+}
+
+func (f *fakeOps) NewInterface(ctx context.Context, opts *system.Options) (system.Interface, error) {
+	f.record("new %s", opts.Name)
+	f.mu.Lock()
+	f.devices[opts.Name] = true
+	f.mu.Unlock()
+	return &fakeInterface{f: f, name: opts.Name, addrv4: opts.AddressV4, routes: map[netip.Prefix]bool{}}, nil
+}
+
+func (f *fakeOps) RemoveInterface(ctx context.Context, name string) error {
+	f.record("remove %s", name)
+	return nil
+}
+
+func (f *fakeOps) EnableIPForwarding(ctx context.Context) error {
+	f.record("forwarding")
+	return nil
+}
+
+func (f *fakeOps) SetDefaultIPv4Gateway(ctx context.Context, netns string, gw routes.Gateway) error {
+	f.record("gateway %s %s", gw.Name, gw.Addr)
+	return nil
+}
+
+func (f *fakeOps) ConfigureDevice(ctx context.Context, netns, name string, cfg wgtypes.Config) error {
+	f.record("configure %s", name)
+	return nil
+}
+
+func (f *fakeOps) Device(ctx context.Context, netns, name string) (*wgtypes.Device, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.devices[name] {
+		return nil, errors.New("no such device")
+	}
+	return &wgtypes.Device{Name: name}, nil
+}
+
+func (f *fakeOps) NewFirewall(ctx context.Context, opts *firewall.Options) (firewall.Firewall, error) {
+	f.record("firewall %s", opts.ID)
+	return &fakeFirewall{f: f, id: opts.ID}, nil
+}
+
+func (f *fakeOps) NewNAT64(ctx context.Context, opts *nat64.Options) (nat64.NAT64, error) {
+	f.record("nat64 %s", opts.Name)
+	return &fakeNAT64{f: f, name: opts.Name}, nil
+}
+
+func (f *fakeOps) AddDNSServers(ctx context.Context, iface string, servers []netip.AddrPort) error {
+	f.record("dns add %v", servers)
+	return nil
+}
+
+func (f *fakeOps) RemoveDNSServers(ctx context.Context, iface string, servers []netip.AddrPort) error {
+	f.record("dns remove %v", servers)
+	return nil
+}
+
+func (f *fakeOps) AddSearchDomains(ctx context.Context, iface string, domains []string) error {
+	f.record("domains add %v", domains)
+	return nil
+}
+
+func (f *fakeOps) RemoveSearchDomains(ctx context.Context, iface string, domains []string) error {
+	f.record("domains remove %v", domains)
+	return nil
+}
+
+type fakeInterface struct {
+	system.Interface
+	f      *fakeOps
+	name   string
+	addrv4 netip.Prefix
+	routes map[netip.Prefix]bool
+}
+
+func (i *fakeInterface) Name() string            { return i.name }
+func (i *fakeInterface) AddressV4() netip.Prefix { return i.addrv4 }
+
+func (i *fakeInterface) Up(ctx context.Context) error {
+	i.f.record("up %s", i.name)
+	return nil
+}
+
+func (i *fakeInterface) Destroy(ctx context.Context) error {
+	i.f.record("destroy %s", i.name)
+	return nil
+}
+
+func (i *fakeInterface) AddRoute(ctx context.Context, network netip.Prefix) error {
+	if i.routes[network] {
+		return routes.ErrRouteExists
+	}
+	i.routes[network] = true
+	i.f.record("route %s %s", i.name, network)
+	return nil
+}
+
+type fakeFirewall struct {
+	firewall.Firewall
+	f  *fakeOps
+	id string
+}
+
+func (fw *fakeFirewall) AddMasquerade(ctx context.Context, ifaceName string) error {
+	fw.f.record("masquerade %s %s", fw.id, ifaceName)
+	return nil
+}
+
+func (fw *fakeFirewall) AddEgressMasquerade(ctx context.Context, ifaceName string, prefix netip.Prefix) error {
+	fw.f.record("egress masquerade %s %s %s", fw.id, ifaceName, prefix)
+	return nil
+}
+
+func (fw *fakeFirewall) AddSourceMasquerade(ctx context.Context, prefix netip.Prefix) error {
+	fw.f.record("source masquerade %s %s", fw.id, prefix)
+	return nil
+}
+
+func (fw *fakeFirewall) SetRateLimits(ctx context.Context, ifaceName string, limits []firewall.RateLimit) error {
+	fw.f.record("rate limits %s %s %v", fw.id, ifaceName, limits)
+	return nil
+}
+
+func (fw *fakeFirewall) Close(ctx context.Context) error {
+	fw.f.record("close %s", fw.id)
+	return nil
+}
+
+type fakeNAT64 struct {
+	f    *fakeOps
+	name string
+}
+
+func (n *fakeNAT64) Name() string { return n.name }
+
+func (n *fakeNAT64) Close(ctx context.Context) error {
+	n.f.record("close nat64 %s", n.name)
+	return nil
+}
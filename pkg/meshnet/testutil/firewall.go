@@ -16,7 +16,12 @@ limitations under the License.
 
 package testutil
 
-import "context"
+import (
+	"context"
+	"net/netip"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+)
 
 // Firewall is a mock firewall.
 type Firewall struct{}
@@ -31,6 +36,32 @@ func (fw *Firewall) AddMasquerade(ctx context.Context, ifaceName string) error {
 	return nil
 }
 
+// AddSourceMasquerade should masquerade traffic from the given source prefix leaving the host.
+func (fw *Firewall) AddSourceMasquerade(ctx context.Context, prefix netip.Prefix) error {
+	return nil
+}
+
+// AddEgressMasquerade should masquerade traffic from the given source prefix leaving the host
+// on any interface other than the given one.
+func (fw *Firewall) AddEgressMasquerade(ctx context.Context, ifaceName string, prefix netip.Prefix) error {
+	return nil
+}
+
+// SetDeniedPrefixes should drop traffic to and from the given prefixes on the interface.
+func (fw *Firewall) SetDeniedPrefixes(ctx context.Context, ifaceName string, prefixes []netip.Prefix) (int, error) {
+	return 0, nil
+}
+
+// SetACLRules should drop new connections matching the given rules on the interface.
+func (fw *Firewall) SetACLRules(ctx context.Context, ifaceName string, rules []firewall.ACLRule) error {
+	return nil
+}
+
+// SetRateLimits should limit the bandwidth of traffic to and from the given prefixes on the interface.
+func (fw *Firewall) SetRateLimits(ctx context.Context, ifaceName string, limits []firewall.RateLimit) error {
+	return nil
+}
+
 // Clear should clear any changes made to the firewall.
 func (fw *Firewall) Clear(ctx context.Context) error {
 	return nil
@@ -26,6 +26,7 @@ import (
 	"sync"
 
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
 )
 
 // SystemInterface is a test interface for use with testing.
@@ -154,10 +155,22 @@ func (t *SystemInterface) AddRoute(_ context.Context, route netip.Prefix) error
 	if t.Options.DisableIPv6 && route.Addr().Is6() {
 		return nil
 	}
+	for _, r := range t.routes {
+		if r == route {
+			return routes.ErrRouteExists
+		}
+	}
 	t.routes = append(t.routes, route)
 	return nil
 }
 
+// Routes returns the routes added to the interface.
+func (t *SystemInterface) Routes() []netip.Prefix {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]netip.Prefix(nil), t.routes...)
+}
+
 // RemoveRoute removes the route for the given network.
 func (t *SystemInterface) RemoveRoute(_ context.Context, route netip.Prefix) error {
 	t.mu.Lock()
@@ -176,11 +189,18 @@ func (t *SystemInterface) RemoveRoute(_ context.Context, route netip.Prefix) err
 
 // Link returns the underlying net.Interface.
 func (t *SystemInterface) Link() (*net.Interface, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var flags net.Flags
+	if t.started {
+		flags |= net.FlagUp
+	}
 	return &net.Interface{
 		Index:        1,
 		MTU:          system.DefaultMTU,
 		Name:         t.Options.Name,
 		HardwareAddr: t.hwaddr,
+		Flags:        flags,
 	}, nil
 }
 
@@ -23,6 +23,8 @@ import (
 	"sync"
 
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/conntrack"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/nat64"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
 	"github.com/webmeshproj/webmesh/pkg/storage"
@@ -43,6 +45,7 @@ type Manager struct {
 	netv4  netip.Prefix
 	netv6  netip.Prefix
 	masq   bool
+	nat64  bool
 	mu     sync.Mutex
 }
 
@@ -116,6 +119,11 @@ func (c *Manager) InNetwork(addr netip.Addr) bool {
 	return false
 }
 
+// StartGateway enables forwarding and masquerading of mesh traffic leaving the node.
+func (c *Manager) StartGateway(ctx context.Context) error {
+	return nil
+}
+
 // StartMasquerade ensures that masquerading is enabled.
 func (c *Manager) StartMasquerade(ctx context.Context) error {
 	c.mu.Lock()
@@ -124,6 +132,14 @@ func (c *Manager) StartMasquerade(ctx context.Context) error {
 	return nil
 }
 
+// StartNAT64 starts translating packets from IPv6-only members to IPv4 destinations.
+func (c *Manager) StartNAT64(ctx context.Context, opts nat64.Options) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nat64 = true
+	return nil
+}
+
 // DNS returns the DNS server manager. The DNS server manager is only
 // available after Start has been called.
 func (c *Manager) DNS() meshnet.DNSManager {
@@ -147,6 +163,11 @@ func (c *Manager) WireGuard() wireguard.Interface {
 	return c.wg
 }
 
+// Conntrack returns nil as connection tracking requires a userspace interface.
+func (c *Manager) Conntrack() *conntrack.Table {
+	return nil
+}
+
 func (c *Manager) Dial(ctx context.Context, network, address string) (net.Conn, error) {
 	return (&net.Dialer{}).DialContext(ctx, network, address)
 }
@@ -80,6 +80,12 @@ func (p *PeerManager) Sync(ctx context.Context) error {
 	return nil
 }
 
+// Subscribe calls fn with an empty snapshot. Changes are not tracked.
+func (p *PeerManager) Subscribe(ctx context.Context, fn func(meshnet.PeerChangeSet)) context.CancelFunc {
+	fn(meshnet.PeerChangeSet{})
+	return func() {}
+}
+
 // Resolver returns a resolver backed by the storage
 // of this instance.
 func (p *PeerManager) Resolver() meshnet.PeerResolver {
@@ -0,0 +1,149 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// NewRateLimits renders the traffic policies enforced by the given node into
+// rate limits on its wireguard interface. Every private address of a selected
+// peer and every selected prefix is limited on its own. When policies select
+// the same prefix, the one with the lowest rate applies. Policies attached to
+// a network ACL select its source nodes and prefixes while it is in effect.
+func NewRateLimits(ctx context.Context, db storage.MeshDB, thisNodeID types.NodeID) ([]firewall.RateLimit, error) {
+	policies, err := storage.TrafficPoliciesFor(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("load traffic policies: %w", err)
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+	var acls types.NetworkACLs
+	for _, policy := range policies {
+		if policy.NetworkACL != "" {
+			acls, err = loadNetworkACLs(ctx, db)
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	nodes, err := db.Peers().List(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list nodes: %w", err)
+	}
+	limits := make(map[netip.Prefix]firewall.RateLimit)
+	add := func(prefix netip.Prefix, policy types.TrafficPolicy) {
+		prefix = prefix.Masked()
+		if existing, ok := limits[prefix]; ok && existing.Rate <= policy.Rate {
+			return
+		}
+		limits[prefix] = firewall.RateLimit{
+			Prefix: prefix,
+			Rate:   policy.Rate,
+			Ceil:   max(policy.Ceil, policy.Rate),
+		}
+	}
+	for _, policy := range policies {
+		if !policy.EnforcedBy(thisNodeID) {
+			continue
+		}
+		selectedNodes := slices.Clone(policy.Nodes)
+		var prefixes []netip.Prefix
+		for _, cidr := range policy.CIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("parse traffic policy cidr %q: %w", cidr, err)
+			}
+			prefixes = append(prefixes, prefix)
+		}
+		if policy.NetworkACL != "" {
+			idx := slices.IndexFunc(acls, func(acl types.NetworkACL) bool { return acl.GetName() == policy.NetworkACL })
+			if idx == -1 {
+				// The ACL does not exist or is not in effect.
+				if len(selectedNodes) == 0 && len(prefixes) == 0 {
+					continue
+				}
+			} else {
+				selectedNodes = append(selectedNodes, acls[idx].GetSourceNodes()...)
+				for _, prefix := range acls[idx].SourcePrefixes() {
+					// Wildcard prefixes are left to the source nodes.
+					if prefix.Bits() != 0 {
+						prefixes = append(prefixes, prefix)
+					}
+				}
+			}
+		}
+		for _, node := range nodes {
+			if node.GetId() == thisNodeID.String() {
+				continue
+			}
+			if !slices.Contains(selectedNodes, "*") && !slices.Contains(selectedNodes, node.GetId()) {
+				continue
+			}
+			for _, prefix := range []netip.Prefix{node.PrivateAddrV4(), node.PrivateAddrV6()} {
+				if prefix.IsValid() {
+					add(prefix, policy)
+				}
+			}
+		}
+		for _, prefix := range prefixes {
+			add(prefix, policy)
+		}
+	}
+	out := make([]firewall.RateLimit, 0, len(limits))
+	for _, limit := range limits {
+		out = append(out, limit)
+	}
+	slices.SortFunc(out, func(a, b firewall.RateLimit) int {
+		return strings.Compare(a.Prefix.String(), b.Prefix.String())
+	})
+	return out, nil
+}
+
+// syncRateLimits renders the traffic policies into the firewall when enabled.
+// The limits are only replaced when they change.
+func (m *manager) syncRateLimits(ctx context.Context) error {
+	if !m.opts.TrafficPolicies || m.fw == nil || m.wg == nil {
+		return nil
+	}
+	limits, err := NewRateLimits(ctx, m.storage, m.nodeID)
+	if err != nil {
+		return fmt.Errorf("render rate limits: %w", err)
+	}
+	m.aclmu.Lock()
+	defer m.aclmu.Unlock()
+	if m.rateLimitsSynced && slices.Equal(limits, m.rateLimits) {
+		return nil
+	}
+	if err := m.fw.SetRateLimits(ctx, m.wg.Name(), limits); err != nil {
+		return fmt.Errorf("set firewall rate limits: %w", err)
+	}
+	m.rateLimits, m.rateLimitsSynced = limits, true
+	context.LoggerFrom(ctx).Debug("Updated firewall rate limits", slog.Int("limits", len(limits)))
+	return nil
+}
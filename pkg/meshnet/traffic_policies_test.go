@@ -0,0 +1,102 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/firewall"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestNewRateLimits(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	for _, node := range []types.MeshNode{
+		{MeshNode: &v1.MeshNode{Id: "a", PrivateIPv4: "172.16.0.1/32"}},
+		{MeshNode: &v1.MeshNode{Id: "b", PrivateIPv4: "172.16.0.2/32", PrivateIPv6: "fd00::2/128"}},
+		{MeshNode: &v1.MeshNode{Id: "c", PrivateIPv4: "172.16.0.3/32"}},
+	} {
+		if err := db.Peers().Put(ctx, node); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "tenant",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"c"},
+		DestinationNodes: []string{"*"},
+		SourceCIDRs:      []string{"10.30.0.0/16"},
+		DestinationCIDRs: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := storage.MeshStorageOf(db.MeshDB)
+	for name, policy := range map[string]types.TrafficPolicy{
+		"everyone": {Nodes: []string{"*"}, Rate: 10_000_000, Ceil: 20_000_000},
+		"tenant":   {NetworkACL: "tenant", Rate: 1_000_000},
+		"lan":      {CIDRs: []string{"10.40.0.1/16"}, Rate: 5_000_000},
+		"other":    {CIDRs: []string{"10.50.0.0/16"}, Rate: 5_000_000, Enforcers: []string{"b"}},
+	} {
+		if err := storage.PutTrafficPolicy(ctx, st, name, policy); err != nil {
+			t.Fatal(err)
+		}
+	}
+	limits, err := NewRateLimits(ctx, db.MeshDB, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := netip.MustParsePrefix
+	want := []firewall.RateLimit{
+		{Prefix: prefix("10.30.0.0/16"), Rate: 1_000_000, Ceil: 1_000_000},
+		{Prefix: prefix("10.40.0.0/16"), Rate: 5_000_000, Ceil: 5_000_000},
+		{Prefix: prefix("172.16.0.2/32"), Rate: 10_000_000, Ceil: 20_000_000},
+		{Prefix: prefix("172.16.0.3/32"), Rate: 1_000_000, Ceil: 1_000_000},
+		{Prefix: prefix("fd00::2/128"), Rate: 10_000_000, Ceil: 20_000_000},
+	}
+	if !slices.Equal(limits, want) {
+		t.Errorf("expected limits %v, got %v", want, limits)
+	}
+
+	// Removing the ACL leaves its peers to the other policies.
+	if err := db.Networking().DeleteNetworkACL(ctx, "tenant"); err != nil {
+		t.Fatal(err)
+	}
+	limits, err = NewRateLimits(ctx, db.MeshDB, "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []firewall.RateLimit{
+		{Prefix: prefix("10.40.0.0/16"), Rate: 5_000_000, Ceil: 5_000_000},
+		{Prefix: prefix("172.16.0.2/32"), Rate: 10_000_000, Ceil: 20_000_000},
+		{Prefix: prefix("172.16.0.3/32"), Rate: 10_000_000, Ceil: 20_000_000},
+		{Prefix: prefix("fd00::2/128"), Rate: 10_000_000, Ceil: 20_000_000},
+	}
+	if !slices.Equal(limits, want) {
+		t.Errorf("expected limits %v, got %v", want, limits)
+	}
+}
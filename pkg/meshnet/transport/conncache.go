@@ -0,0 +1,174 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+)
+
+// ConnDialFunc is a function that dials a gRPC client connection to an address.
+type ConnDialFunc func(ctx context.Context, addr string) (*grpc.ClientConn, error)
+
+// ConnCache is a cache of shared gRPC client connections keyed by address.
+// It is intended for chatty, long-lived targets such as the current leader.
+// Requesting a different address than the previous call retires connections
+// to the old address, so the cache follows leadership changes. Retired
+// connections are drained: they are closed once every caller that obtained
+// them has closed its handle, so in-flight calls and streams can finish.
+type ConnCache struct {
+	conns    map[string]*cachedConn
+	draining map[*cachedConn]struct{}
+	mu       sync.Mutex
+}
+
+// cachedConn is a cached connection and the number of open handles to it.
+type cachedConn struct {
+	*grpc.ClientConn
+	refs    int
+	retired bool
+}
+
+// NewConnCache returns a new connection cache.
+func NewConnCache() *ConnCache {
+	return &ConnCache{
+		conns:    make(map[string]*cachedConn),
+		draining: make(map[*cachedConn]struct{}),
+	}
+}
+
+// Get returns a shared connection to the given address, dialing a new one if
+// none is cached or the cached connection has failed. Callers must close the
+// returned connection when they are done with it. This only releases the
+// handle, the underlying connection is torn down once it has been retired by
+// a later Get, Invalidate, or Purge and all of its handles are closed.
+func (c *ConnCache) Get(ctx context.Context, addr string, dial ConnDialFunc) (RPCClientConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cachedAddr, conn := range c.conns {
+		if cachedAddr != addr {
+			c.retire(cachedAddr, conn)
+		}
+	}
+	if conn, ok := c.conns[addr]; ok {
+		switch conn.GetState() {
+		case connectivity.Shutdown, connectivity.TransientFailure:
+			c.retire(addr, conn)
+		default:
+			return c.acquire(conn), nil
+		}
+	}
+	dialed, err := dial(ctx, addr)
+	if err != nil {
+		return nil, err
+	}
+	conn := &cachedConn{ClientConn: dialed}
+	c.conns[addr] = conn
+	return c.acquire(conn), nil
+}
+
+// Invalidate retires any cached connection to the given address.
+func (c *ConnCache) Invalidate(addr string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if conn, ok := c.conns[addr]; ok {
+		c.retire(addr, conn)
+	}
+}
+
+// Purge retires all cached connections. Connections without open handles
+// are closed immediately, the rest are closed as their handles are closed.
+func (c *ConnCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for addr, conn := range c.conns {
+		c.retire(addr, conn)
+	}
+}
+
+// Close closes all cached and draining connections without waiting for
+// open handles.
+func (c *ConnCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var errs []error
+	for addr, conn := range c.conns {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(c.conns, addr)
+	}
+	for conn := range c.draining {
+		if err := conn.Close(); err != nil {
+			errs = append(errs, err)
+		}
+		delete(c.draining, conn)
+	}
+	return errors.Join(errs...)
+}
+
+// acquire returns a new handle to the given connection. It must be called
+// with the lock held.
+func (c *ConnCache) acquire(conn *cachedConn) RPCClientConn {
+	conn.refs++
+	return &sharedConn{
+		ClientConn: conn.ClientConn,
+		release:    func() { c.release(conn) },
+	}
+}
+
+// release releases a handle to the given connection, closing it if it was
+// retired and this was the last handle.
+func (c *ConnCache) release(conn *cachedConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	conn.refs--
+	if conn.retired && conn.refs == 0 {
+		_ = conn.Close()
+		delete(c.draining, conn)
+	}
+}
+
+// retire removes the connection from the cache and closes it once it has no
+// open handles. It must be called with the lock held.
+func (c *ConnCache) retire(addr string, conn *cachedConn) {
+	delete(c.conns, addr)
+	conn.retired = true
+	if conn.refs == 0 {
+		_ = conn.Close()
+		return
+	}
+	c.draining[conn] = struct{}{}
+}
+
+// sharedConn is a handle to a cached connection. Closing it releases the
+// handle instead of closing the connection.
+type sharedConn struct {
+	*grpc.ClientConn
+	release func()
+	once    sync.Once
+}
+
+// Close releases the handle. It is safe to call more than once.
+func (s *sharedConn) Close() error {
+	s.once.Do(s.release)
+	return nil
+}
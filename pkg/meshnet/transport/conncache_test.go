@@ -0,0 +1,132 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestConnCache(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var dials int
+	dial := func(ctx context.Context, addr string) (*grpc.ClientConn, error) {
+		dials++
+		return grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	cache := NewConnCache()
+	t.Cleanup(func() { _ = cache.Close() })
+	addr1, addr2 := newTestServer(t), newTestServer(t)
+
+	first, err := cache.Get(ctx, addr1, dial)
+	if err != nil {
+		t.Fatalf("get connection: %v", err)
+	}
+	// Closing a shared connection should not tear it down.
+	if err := first.Close(); err != nil {
+		t.Fatalf("close shared connection: %v", err)
+	}
+	if state := first.(*sharedConn).GetState(); state == connectivity.Shutdown {
+		t.Fatal("expected shared connection to remain open after close")
+	}
+	second, err := cache.Get(ctx, addr1, dial)
+	if err != nil {
+		t.Fatalf("get connection: %v", err)
+	}
+	_ = second.Close()
+	if dials != 1 {
+		t.Fatalf("expected cached connection to be reused, got %d dials", dials)
+	}
+
+	// Requesting a new address should close the old connection once
+	// it has no open handles.
+	if _, err := cache.Get(ctx, addr2, dial); err != nil {
+		t.Fatalf("get connection: %v", err)
+	}
+	if dials != 2 {
+		t.Fatalf("expected new address to be dialed, got %d dials", dials)
+	}
+	if state := first.(*sharedConn).GetState(); state != connectivity.Shutdown {
+		t.Fatalf("expected previous connection to be closed, got state %s", state)
+	}
+
+	// Purging should force a redial.
+	cache.Purge()
+	if _, err := cache.Get(ctx, addr2, dial); err != nil {
+		t.Fatalf("get connection: %v", err)
+	}
+	if dials != 3 {
+		t.Fatalf("expected purged connection to be redialed, got %d dials", dials)
+	}
+}
+
+func TestConnCacheDrain(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dial := func(ctx context.Context, addr string) (*grpc.ClientConn, error) {
+		return grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	cache := NewConnCache()
+	t.Cleanup(func() { _ = cache.Close() })
+	addr := newTestServer(t)
+
+	inflight, err := cache.Get(ctx, addr, dial)
+	if err != nil {
+		t.Fatalf("get connection: %v", err)
+	}
+	// Purging should not tear down a connection that is still in use.
+	cache.Purge()
+	if state := inflight.(*sharedConn).GetState(); state == connectivity.Shutdown {
+		t.Fatal("expected in-use connection to remain open after purge")
+	}
+	// New callers should get a fresh connection.
+	fresh, err := cache.Get(ctx, addr, dial)
+	if err != nil {
+		t.Fatalf("get connection: %v", err)
+	}
+	defer fresh.Close()
+	if fresh.(*sharedConn).ClientConn == inflight.(*sharedConn).ClientConn {
+		t.Fatal("expected purged connection to not be reused")
+	}
+	// Releasing the last handle should close the drained connection.
+	_ = inflight.Close()
+	_ = inflight.Close()
+	if state := inflight.(*sharedConn).GetState(); state != connectivity.Shutdown {
+		t.Fatalf("expected drained connection to be closed, got state %s", state)
+	}
+	if state := fresh.(*sharedConn).GetState(); state == connectivity.Shutdown {
+		t.Fatal("expected fresh connection to remain open")
+	}
+}
+
+func newTestServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	srv := grpc.NewServer()
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+	return l.Addr().String()
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datachannels
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-yamux/v4"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// MaxStreamLabelSize is the maximum size of a stream label.
+const MaxStreamLabelSize = 255
+
+// maxMessageSize is the largest message written to the underlying data channel.
+// Writes are split so they are deliverable by any WebRTC implementation.
+const maxMessageSize = 16 * 1024
+
+// readBufferSize is the size of the buffer used for reading messages from the
+// underlying data channel. It must be at least the maximum message size the
+// remote side may send.
+const readBufferSize = 64 * 1024
+
+// Mux multiplexes labeled logical streams over a single data channel, so that
+// a single negotiation can carry multiple independent streams, for example a
+// control stream alongside file transfers.
+type Mux struct {
+	session *yamux.Session
+}
+
+// NewMuxClient creates a multiplexer over the given data channel for the side
+// that opened it. The other side must use NewMuxServer.
+func NewMuxClient(rw io.ReadWriteCloser) (*Mux, error) {
+	session, err := yamux.Client(newMessageConn(rw), muxConfig(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create mux session: %w", err)
+	}
+	return &Mux{session: session}, nil
+}
+
+// NewMuxServer creates a multiplexer over the given data channel for the side
+// that accepted it.
+func NewMuxServer(rw io.ReadWriteCloser) (*Mux, error) {
+	session, err := yamux.Server(newMessageConn(rw), muxConfig(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("create mux session: %w", err)
+	}
+	return &Mux{session: session}, nil
+}
+
+func muxConfig() *yamux.Config {
+	conf := yamux.DefaultConfig()
+	conf.LogOutput = io.Discard
+	return conf
+}
+
+// OpenStream opens a new stream with the given label. The label is presented
+// to the remote side when it accepts the stream.
+func (m *Mux) OpenStream(ctx context.Context, label string) (net.Conn, error) {
+	if len(label) > MaxStreamLabelSize {
+		return nil, fmt.Errorf("stream label exceeds %d bytes", MaxStreamLabelSize)
+	}
+	stream, err := m.session.OpenStream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("open stream: %w", err)
+	}
+	header := append([]byte{byte(len(label))}, label...)
+	if _, err := stream.Write(header); err != nil {
+		_ = stream.Reset()
+		return nil, fmt.Errorf("write stream label: %w", err)
+	}
+	return stream, nil
+}
+
+// AcceptStream waits for the remote side to open a stream and returns
+// it along with its label.
+func (m *Mux) AcceptStream() (label string, conn net.Conn, err error) {
+	stream, err := m.session.AcceptStream()
+	if err != nil {
+		return "", nil, err
+	}
+	var size [1]byte
+	if _, err := io.ReadFull(stream, size[:]); err != nil {
+		_ = stream.Reset()
+		return "", nil, fmt.Errorf("read stream label: %w", err)
+	}
+	buf := make([]byte, size[0])
+	if _, err := io.ReadFull(stream, buf); err != nil {
+		_ = stream.Reset()
+		return "", nil, fmt.Errorf("read stream label: %w", err)
+	}
+	return string(buf), stream, nil
+}
+
+// NumStreams returns the number of open streams.
+func (m *Mux) NumStreams() int {
+	return m.session.NumStreams()
+}
+
+// Closed returns a channel that is closed when the multiplexer is closed.
+func (m *Mux) Closed() <-chan struct{} {
+	return m.session.CloseChan()
+}
+
+// Close closes all streams and the underlying data channel.
+func (m *Mux) Close() error {
+	return m.session.Close()
+}
+
+// messageConn adapts a message oriented data channel to a net.Conn.
+type messageConn struct {
+	rw   io.ReadWriteCloser
+	buf  []byte
+	pend []byte
+	rmu  sync.Mutex
+	wmu  sync.Mutex
+}
+
+func newMessageConn(rw io.ReadWriteCloser) net.Conn {
+	return &messageConn{rw: rw, buf: make([]byte, readBufferSize)}
+}
+
+// Read reads from the current message, reading the next one when it
+// is exhausted.
+func (c *messageConn) Read(p []byte) (int, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if len(c.pend) == 0 {
+		n, err := c.rw.Read(c.buf)
+		if err != nil {
+			return 0, err
+		}
+		c.pend = c.buf[:n]
+	}
+	n := copy(p, c.pend)
+	c.pend = c.pend[n:]
+	return n, nil
+}
+
+// Write writes p split into messages of at most maxMessageSize.
+func (c *messageConn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	var written int
+	for len(p) > 0 {
+		chunk := p[:min(len(p), maxMessageSize)]
+		n, err := c.rw.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+func (c *messageConn) Close() error { return c.rw.Close() }
+
+func (c *messageConn) LocalAddr() net.Addr { return muxAddr{} }
+
+func (c *messageConn) RemoteAddr() net.Addr { return muxAddr{} }
+
+func (c *messageConn) SetDeadline(t time.Time) error {
+	return errors.Join(c.SetReadDeadline(t), c.SetWriteDeadline(t))
+}
+
+// SetReadDeadline sets the read deadline if the data channel supports it.
+func (c *messageConn) SetReadDeadline(t time.Time) error {
+	if d, ok := c.rw.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return d.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// SetWriteDeadline sets the write deadline if the data channel supports it.
+func (c *messageConn) SetWriteDeadline(t time.Time) error {
+	if d, ok := c.rw.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return d.SetWriteDeadline(t)
+	}
+	return nil
+}
+
+type muxAddr struct{}
+
+func (muxAddr) Network() string { return "webrtc" }
+
+func (muxAddr) String() string { return "datachannel" }
@@ -0,0 +1,87 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datachannels
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestMux(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	clientConn, serverConn := net.Pipe()
+	client, err := NewMuxClient(clientConn)
+	if err != nil {
+		t.Fatalf("create client mux: %v", err)
+	}
+	defer client.Close()
+	server, err := NewMuxServer(serverConn)
+	if err != nil {
+		t.Fatalf("create server mux: %v", err)
+	}
+	defer server.Close()
+
+	// Payloads larger than a single data channel message are split and reassembled.
+	payloads := map[string][]byte{
+		"control": []byte("hello"),
+		"file":    bytes.Repeat([]byte("x"), 3*maxMessageSize+1),
+	}
+	errs := make(chan error, len(payloads))
+	for label, payload := range payloads {
+		go func(label string, payload []byte) {
+			stream, err := client.OpenStream(ctx, label)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer stream.Close()
+			_, err = stream.Write(payload)
+			errs <- err
+		}(label, payload)
+	}
+	for i := 0; i < len(payloads); i++ {
+		label, stream, err := server.AcceptStream()
+		if err != nil {
+			t.Fatalf("accept stream: %v", err)
+		}
+		data, err := io.ReadAll(stream)
+		if err != nil {
+			t.Fatalf("read stream %q: %v", label, err)
+		}
+		want, ok := payloads[label]
+		if !ok {
+			t.Fatalf("unexpected stream label %q", label)
+		}
+		if !bytes.Equal(data, want) {
+			t.Fatalf("stream %q: expected %d bytes, got %d", label, len(want), len(data))
+		}
+	}
+	for i := 0; i < len(payloads); i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("write stream: %v", err)
+		}
+	}
+
+	if _, err := client.OpenStream(ctx, string(make([]byte, MaxStreamLabelSize+1))); err == nil {
+		t.Fatal("expected error for oversized label")
+	}
+}
@@ -72,6 +72,8 @@ type OfferOptions struct {
 	DstAddress string
 	// STUNServers is a list of STUN servers to use for the connection.
 	STUNServers []string
+	// TURNAuth are the credentials to use for TURN servers.
+	TURNAuth TURNAuth
 }
 
 // NewPeerConnectionServer creates a new peer connection server with the given options.
@@ -84,15 +86,7 @@ func NewPeerConnectionServer(ctx context.Context, opts *OfferOptions) (*PeerConn
 	s.SetIncludeLoopbackCandidate(true)
 	api := webrtc.NewAPI(webrtc.WithSettingEngine(s))
 	conn, err := api.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: opts.STUNServers,
-				// TODO: Authentication
-				Username:       "-",
-				Credential:     "-",
-				CredentialType: webrtc.ICECredentialTypePassword,
-			},
-		},
+		ICEServers: opts.TURNAuth.ICEServers(opts.STUNServers, ""),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create peer connection: %w", err)
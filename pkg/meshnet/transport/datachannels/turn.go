@@ -0,0 +1,119 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datachannels
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pion/webrtc/v3"
+)
+
+// DefaultTURNCredentialTTL is the default lifetime of ephemeral TURN credentials.
+const DefaultTURNCredentialTTL = 24 * time.Hour
+
+// TURNCredentials are credentials for authenticating with a TURN server.
+type TURNCredentials struct {
+	// Username and Password are long-term credentials.
+	Username string
+	Password string
+	// Secret is a secret shared with the TURN server. When set, ephemeral
+	// credentials are generated using the TURN REST API scheme (coturn's
+	// use-auth-secret) instead of using Username and Password.
+	Secret string
+	// TTL is the lifetime of ephemeral credentials. Defaults to
+	// DefaultTURNCredentialTTL.
+	TTL time.Duration
+}
+
+// IsEmpty returns true if no credentials are configured.
+func (c TURNCredentials) IsEmpty() bool {
+	return c.Secret == "" && c.Username == "" && c.Password == ""
+}
+
+// Resolve returns the username and password to present to the TURN server.
+// Ephemeral credentials are issued to the given user.
+func (c TURNCredentials) Resolve(user string, now time.Time) (username, password string) {
+	if c.Secret == "" {
+		return c.Username, c.Password
+	}
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = DefaultTURNCredentialTTL
+	}
+	return EphemeralTURNCredentials(c.Secret, user, now.Add(ttl))
+}
+
+// EphemeralTURNCredentials returns credentials for the TURN REST API that are
+// valid until the given expiry. The username is the expiry as a unix timestamp,
+// followed by a colon and the user if one is given. The password is the base64
+// encoded HMAC-SHA1 of the username keyed with the shared secret.
+func EphemeralTURNCredentials(secret, user string, expires time.Time) (username, password string) {
+	username = strconv.FormatInt(expires.Unix(), 10)
+	if user != "" {
+		username = fmt.Sprintf("%s:%s", username, user)
+	}
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// TURNAuth configures the credentials used for TURN servers.
+type TURNAuth struct {
+	// Credentials are the credentials used for servers without
+	// an entry in Servers.
+	Credentials TURNCredentials
+	// Servers are credentials for individual servers, keyed by URL.
+	Servers map[string]TURNCredentials
+}
+
+// For returns the credentials configured for the given server URL.
+func (a TURNAuth) For(url string) TURNCredentials {
+	if creds, ok := a.Servers[url]; ok {
+		return creds
+	}
+	return a.Credentials
+}
+
+// ICEServers returns an ICE server for each of the given URLs with its configured
+// credentials. Ephemeral credentials are issued to the given user. Servers without
+// credentials use the user as both username and password, or "-" if it is empty,
+// which servers that do not require authentication accept.
+func (a TURNAuth) ICEServers(urls []string, user string) []webrtc.ICEServer {
+	now := time.Now()
+	servers := make([]webrtc.ICEServer, 0, len(urls))
+	for _, url := range urls {
+		username, password := user, user
+		if username == "" {
+			username, password = "-", "-"
+		}
+		if creds := a.For(url); !creds.IsEmpty() {
+			username, password = creds.Resolve(user, now)
+		}
+		servers = append(servers, webrtc.ICEServer{
+			URLs:           []string{url},
+			Username:       username,
+			Credential:     password,
+			CredentialType: webrtc.ICECredentialTypePassword,
+		})
+	}
+	return servers
+}
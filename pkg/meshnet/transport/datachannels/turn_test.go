@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package datachannels
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEphemeralTURNCredentials(t *testing.T) {
+	t.Parallel()
+	username, password := EphemeralTURNCredentials("secret", "node-a", time.Unix(1700000000, 0))
+	if username != "1700000000:node-a" {
+		t.Fatalf("unexpected username %q", username)
+	}
+	if password != "K2PhhRbEM9V0WZ1u6Fqq8mMReLI=" {
+		t.Fatalf("unexpected password %q", password)
+	}
+	username, _ = EphemeralTURNCredentials("secret", "", time.Unix(1700000000, 0))
+	if username != "1700000000" {
+		t.Fatalf("unexpected username without user %q", username)
+	}
+}
+
+func TestTURNAuthICEServers(t *testing.T) {
+	t.Parallel()
+	auth := TURNAuth{
+		Credentials: TURNCredentials{Username: "user", Password: "pass"},
+		Servers: map[string]TURNCredentials{
+			"turn:ephemeral.example.com:3478": {Secret: "secret", TTL: time.Hour},
+		},
+	}
+	servers := auth.ICEServers([]string{
+		"turn:static.example.com:3478",
+		"turn:ephemeral.example.com:3478",
+	}, "node-a")
+	if len(servers) != 2 {
+		t.Fatalf("expected 2 servers, got %d", len(servers))
+	}
+	if servers[0].Username != "user" || servers[0].Credential != "pass" {
+		t.Fatalf("expected long-term credentials, got %q/%q", servers[0].Username, servers[0].Credential)
+	}
+	expiry, user, ok := strings.Cut(servers[1].Username, ":")
+	if !ok || user != "node-a" {
+		t.Fatalf("expected ephemeral username for node-a, got %q", servers[1].Username)
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil {
+		t.Fatalf("parse expiry: %v", err)
+	}
+	if ttl := time.Until(time.Unix(expires, 0)); ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("expected credentials to expire within the TTL, got %s", ttl)
+	}
+	_, want := EphemeralTURNCredentials("secret", "node-a", time.Unix(expires, 0))
+	if servers[1].Credential != want {
+		t.Fatalf("expected ephemeral password %q, got %q", want, servers[1].Credential)
+	}
+
+	// Servers without credentials fall back to the user or "-".
+	servers = TURNAuth{}.ICEServers([]string{"stun:stun.example.com:3478"}, "")
+	if servers[0].Username != "-" || servers[0].Credential != "-" {
+		t.Fatalf("expected placeholder credentials, got %q/%q", servers[0].Username, servers[0].Credential)
+	}
+}
@@ -41,22 +41,15 @@ type WireGuardProxyServer struct {
 }
 
 // NewWireGuardProxyServer creates a new WireGuardProxyServer using the given STUN servers
-// for ICE negotiation. Traffic will be proxied to the wireguard interface listening on targetPort.
-func NewWireGuardProxyServer(ctx context.Context, stunServers []string, targetPort uint16) (*WireGuardProxyServer, error) {
+// and TURN credentials for ICE negotiation. Traffic will be proxied to the wireguard
+// interface listening on targetPort.
+func NewWireGuardProxyServer(ctx context.Context, stunServers []string, auth TURNAuth, targetPort uint16) (*WireGuardProxyServer, error) {
 	s := webrtc.SettingEngine{}
 	s.DetachDataChannels()
 	s.SetIncludeLoopbackCandidate(true)
 	api := webrtc.NewAPI(webrtc.WithSettingEngine(s))
 	c, err := api.NewPeerConnection(webrtc.Configuration{
-		ICEServers: []webrtc.ICEServer{
-			{
-				URLs: stunServers,
-				// TODO: Authentication
-				Username:       "-",
-				Credential:     "-",
-				CredentialType: webrtc.ICECredentialTypePassword,
-			},
-		},
+		ICEServers: auth.ICEServers(stunServers, ""),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("new peer connection: %w", err)
@@ -0,0 +1,69 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+type responseHeaderKey struct{}
+
+// WithResponseHeader returns a context that asks gRPC round trippers to
+// capture the header of the response they return. The header is stored in md
+// once the round trip completes.
+func WithResponseHeader(ctx context.Context, md *metadata.MD) context.Context {
+	return context.WithValue(ctx, responseHeaderKey{}, md)
+}
+
+// ResponseHeaderCallOptions returns the call options a round tripper should
+// pass to its invocation to honor WithResponseHeader. The returned function
+// must be called with the result of the invocation to store the header.
+func ResponseHeaderCallOptions(ctx context.Context) ([]grpc.CallOption, func(error)) {
+	out, ok := ctx.Value(responseHeaderKey{}).(*metadata.MD)
+	if !ok || out == nil {
+		return nil, func(error) {}
+	}
+	var md metadata.MD
+	return []grpc.CallOption{grpc.Header(&md)}, func(err error) {
+		if err == nil {
+			*out = md
+		}
+	}
+}
+
+// withResponseHeaderCapture replaces any header requested on the context
+// with a private one, so concurrent round trips do not write the same header.
+func withResponseHeaderCapture(ctx context.Context) (context.Context, *metadata.MD) {
+	if _, ok := ctx.Value(responseHeaderKey{}).(*metadata.MD); !ok {
+		return ctx, nil
+	}
+	var md metadata.MD
+	return WithResponseHeader(ctx, &md), &md
+}
+
+// storeResponseHeader copies a header captured with withResponseHeaderCapture
+// into the header requested on the original context.
+func storeResponseHeader(ctx context.Context, md *metadata.MD) {
+	out, ok := ctx.Value(responseHeaderKey{}).(*metadata.MD)
+	if !ok || out == nil || md == nil {
+		return
+	}
+	*out = *md
+}
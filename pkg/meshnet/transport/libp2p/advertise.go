@@ -0,0 +1,148 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libp2p
+
+import (
+	"log/slog"
+	"math/rand"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+const (
+	// DefaultAdvertiseTTL is the default TTL requested for advertisements.
+	DefaultAdvertiseTTL = 3 * time.Hour
+	// DefaultAdvertiseRetryInterval is the default interval between attempts
+	// after a failed advertisement.
+	DefaultAdvertiseRetryInterval = 2 * time.Minute
+	// DefaultAdvertiseJitter is the default fraction of each interval that is
+	// randomly shaved off, so hosts started together do not advertise in
+	// lockstep.
+	DefaultAdvertiseJitter = 0.1
+)
+
+// Advertisement Metrics
+var (
+	// DHTAdvertisementsTotal tracks successful advertisements on the DHT.
+	DHTAdvertisementsTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "dht_advertisements_total",
+		Help:      "Total successful advertisements of rendezvous points on the DHT.",
+	})
+
+	// DHTAdvertisementFailuresTotal tracks failed advertisements on the DHT.
+	DHTAdvertisementFailuresTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "dht_advertisement_failures_total",
+		Help:      "Total failed advertisements of rendezvous points on the DHT.",
+	})
+)
+
+// AdvertiseOptions are options for keeping a host advertised at a rendezvous
+// point on the DHT.
+type AdvertiseOptions struct {
+	// TTL is the TTL requested for each advertisement. The host is
+	// re-advertised before the TTL granted by the DHT expires. Defaults to
+	// DefaultAdvertiseTTL.
+	TTL time.Duration
+	// RetryInterval is the interval between attempts after a failed
+	// advertisement. Defaults to DefaultAdvertiseRetryInterval.
+	RetryInterval time.Duration
+	// Jitter is the fraction of each interval, between 0 and 1, that is
+	// randomly shaved off. Defaults to DefaultAdvertiseJitter. Set it to a
+	// negative value to disable jitter.
+	Jitter float64
+	// MaxFailures stops advertising after the given number of consecutive
+	// failures. Zero retries until stopped.
+	MaxFailures int
+	// StopAfter stops advertising once the given duration has passed. Zero
+	// advertises until the context is canceled.
+	StopAfter time.Duration
+}
+
+// Default returns the options with defaults applied.
+func (o AdvertiseOptions) Default() AdvertiseOptions {
+	if o.TTL <= 0 {
+		o.TTL = DefaultAdvertiseTTL
+	}
+	if o.RetryInterval <= 0 {
+		o.RetryInterval = DefaultAdvertiseRetryInterval
+	}
+	if o.Jitter == 0 {
+		o.Jitter = DefaultAdvertiseJitter
+	}
+	o.Jitter = min(max(o.Jitter, 0), 1)
+	return o
+}
+
+// Advertise keeps advertising the rendezvous point with the given advertiser
+// until the context is canceled or one of the stop conditions in the options
+// is met. It returns a channel that is closed once advertising has stopped.
+func Advertise(ctx context.Context, advertiser discovery.Advertiser, rendezvous string, opts AdvertiseOptions) <-chan struct{} {
+	opts = opts.Default()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log := context.LoggerFrom(ctx)
+		if opts.StopAfter > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, opts.StopAfter)
+			defer cancel()
+		}
+		var failures int
+		for {
+			ttl, err := advertiser.Advertise(ctx, rendezvous, discovery.TTL(opts.TTL))
+			if ctx.Err() != nil {
+				return
+			}
+			var wait time.Duration
+			if err != nil {
+				failures++
+				DHTAdvertisementFailuresTotal.Inc()
+				log.Warn("Failed to advertise on the DHT", slog.String("error", err.Error()), slog.Int("failures", failures))
+				if opts.MaxFailures > 0 && failures >= opts.MaxFailures {
+					log.Error("Giving up advertising on the DHT", slog.Int("failures", failures))
+					return
+				}
+				wait = opts.RetryInterval
+			} else {
+				failures = 0
+				DHTAdvertisementsTotal.Inc()
+				if ttl <= 0 {
+					ttl = opts.TTL
+				}
+				log.Debug("Advertised on the DHT", slog.String("ttl", ttl.String()))
+				// Re-advertise before the granted TTL expires.
+				wait = ttl * 7 / 8
+			}
+			wait -= time.Duration(rand.Float64() * opts.Jitter * float64(wait))
+			t := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+				return
+			case <-t.C:
+			}
+		}
+	}()
+	return done
+}
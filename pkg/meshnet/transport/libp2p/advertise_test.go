@@ -0,0 +1,94 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libp2p
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/discovery"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+type testAdvertiser struct {
+	calls atomic.Int64
+	err   error
+	ttl   time.Duration
+}
+
+func (a *testAdvertiser) Advertise(ctx context.Context, ns string, opts ...discovery.Option) (time.Duration, error) {
+	a.calls.Add(1)
+	if a.err != nil {
+		return 0, a.err
+	}
+	var o discovery.Options
+	if err := o.Apply(opts...); err != nil {
+		return 0, err
+	}
+	return min(o.Ttl, a.ttl), nil
+}
+
+func TestAdvertise(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ReadvertisesBeforeExpiry", func(t *testing.T) {
+		t.Parallel()
+		ctx, cancel := context.WithCancel(context.Background())
+		adv := &testAdvertiser{ttl: 8 * time.Millisecond}
+		done := Advertise(ctx, adv, "test", AdvertiseOptions{TTL: time.Hour})
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		<-done
+		if calls := adv.calls.Load(); calls < 3 {
+			t.Errorf("expected the host to be re-advertised, got %d advertisements", calls)
+		}
+	})
+
+	t.Run("MaxFailures", func(t *testing.T) {
+		t.Parallel()
+		adv := &testAdvertiser{err: errors.New("no peers")}
+		done := Advertise(context.Background(), adv, "test", AdvertiseOptions{
+			RetryInterval: time.Millisecond,
+			MaxFailures:   3,
+		})
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected advertising to stop after max failures")
+		}
+		if calls := adv.calls.Load(); calls != 3 {
+			t.Errorf("expected 3 advertisements, got %d", calls)
+		}
+	})
+
+	t.Run("StopAfter", func(t *testing.T) {
+		t.Parallel()
+		adv := &testAdvertiser{ttl: time.Hour}
+		done := Advertise(context.Background(), adv, "test", AdvertiseOptions{StopAfter: 10 * time.Millisecond})
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected advertising to stop after the deadline")
+		}
+		if calls := adv.calls.Load(); calls != 1 {
+			t.Errorf("expected 1 advertisement, got %d", calls)
+		}
+	})
+}
@@ -25,11 +25,8 @@ import (
 	"log/slog"
 	"time"
 
-	"github.com/libp2p/go-libp2p/core/discovery"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
-	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
-	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/protobuf/proto"
 
@@ -53,8 +50,9 @@ type Announcer interface {
 type AnnounceOptions struct {
 	// Rendezvous is the pre-shared key to use as a rendezvous point for the DHT.
 	Rendezvous string
-	// AnnounceTTL is the TTL to use for the discovery service.
-	AnnounceTTL time.Duration
+	// Advertise are options for keeping the host advertised at the
+	// rendezvous point.
+	Advertise AdvertiseOptions
 	// HostOptions are options for configuring the host. These can be left
 	// empty if using a pre-created host.
 	HostOptions HostOptions
@@ -68,7 +66,7 @@ type AnnounceOptions struct {
 func (opts AnnounceOptions) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]any{
 		"rendezvous":  opts.Rendezvous,
-		"announceTTL": opts.AnnounceTTL,
+		"advertise":   opts.Advertise,
 		"hostOptions": opts.HostOptions,
 		"method":      opts.Method,
 	})
@@ -119,13 +117,8 @@ func newAnnouncerWithHostAndCloseFunc[REQ, RESP any](ctx context.Context, host D
 		go handleIncomingStream(log, rt, s)
 	})
 	log.Debug("Announcing protocol with our PSK", "protocol", opts.Method, "psk", opts.Rendezvous)
-	routingDiscovery := drouting.NewRoutingDiscovery(host.DHT())
-	var discoveryOpts []discovery.Option
-	if opts.AnnounceTTL > 0 {
-		discoveryOpts = append(discoveryOpts, discovery.TTL(opts.AnnounceTTL))
-	}
-	advertise, cancel := context.WithCancel(context.Background())
-	dutil.Advertise(advertise, routingDiscovery, opts.Rendezvous, discoveryOpts...)
+	advertise, cancel := context.WithCancel(context.WithLogger(context.Background(), log))
+	host.Announce(advertise, opts.Rendezvous, opts.Advertise)
 	announcer := &announcer[REQ, RESP]{
 		close: func() error {
 			cancel()
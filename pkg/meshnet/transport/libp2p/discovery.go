@@ -25,12 +25,10 @@ import (
 	"time"
 
 	dht "github.com/libp2p/go-libp2p-kad-dht"
-	"github.com/libp2p/go-libp2p/core/discovery"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/record"
 	drouting "github.com/libp2p/go-libp2p/p2p/discovery/routing"
-	dutil "github.com/libp2p/go-libp2p/p2p/discovery/util"
 	"github.com/multiformats/go-multiaddr"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
@@ -44,8 +42,9 @@ type DiscoveryHost interface {
 
 	// DHT is the underlying libp2p DHT.
 	DHT() *dht.IpfsDHT
-	// Announce announces the host to the DHT for the given rendezvous string.
-	Announce(ctx context.Context, rendezvous string, ttl time.Duration)
+	// Announce keeps the host announced on the DHT for the given rendezvous
+	// string until the context is canceled or a stop condition is met.
+	Announce(ctx context.Context, rendezvous string, opts AdvertiseOptions)
 }
 
 // NewDiscoveryHost creates a new libp2p host connected to the DHT with the given options.
@@ -111,13 +110,8 @@ func (h *discoveryHost) RPCListener() net.Listener {
 	return h.h.RPCListener()
 }
 
-func (h *discoveryHost) Announce(ctx context.Context, rendezvous string, ttl time.Duration) {
-	routingDiscovery := drouting.NewRoutingDiscovery(h.dht)
-	var discoveryOpts []discovery.Option
-	if ttl > 0 {
-		discoveryOpts = append(discoveryOpts, discovery.TTL(ttl))
-	}
-	dutil.Advertise(ctx, routingDiscovery, rendezvous, discoveryOpts...)
+func (h *discoveryHost) Announce(ctx context.Context, rendezvous string, opts AdvertiseOptions) {
+	Advertise(ctx, drouting.NewRoutingDiscovery(h.dht), rendezvous, opts)
 }
 
 func (h *discoveryHost) Close() error {
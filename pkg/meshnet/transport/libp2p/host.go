@@ -36,6 +36,7 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
 )
 
 // Host is an interface that provides facilities for connecting to peers over libp2p.
@@ -60,9 +61,13 @@ type Host interface {
 
 // HostOptions are options for creating a new libp2p host.
 type HostOptions struct {
-	// Key is the key to use for identification. If left empty, an ephemeral
-	// key is generated.
+	// Key is the key to use for identification. If left empty, the key is
+	// loaded from IdentityFile or an ephemeral key is generated.
 	Key crypto.PrivateKey
+	// IdentityFile is the path to persist the identity of the host at when
+	// no Key is given. It is created if it does not exist, keeping the peer
+	// ID of the host stable across restarts.
+	IdentityFile string
 	// BootstrapPeers is a list of bootstrap peers to use for the DHT when
 	// creating a discovery host. If empty or nil, the default bootstrap
 	// peers will be used.
@@ -70,7 +75,8 @@ type HostOptions struct {
 	// Options are options for configuring the libp2p host.
 	Options []config.Option
 	// LocalAddrs is a list of local addresses to announce the host with.
-	// If empty or nil, the default local addresses will be used.
+	// If empty or nil, the default local addresses will be used. On hosts
+	// with only one routable address family, the defaults are limited to it.
 	LocalAddrs []multiaddr.Multiaddr
 	// ConnectTimeout is the timeout for connecting to peers when bootstrapping.
 	ConnectTimeout time.Duration
@@ -87,6 +93,7 @@ type HostOptions struct {
 func (o HostOptions) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]any{
 		"key":            "redacted",
+		"identityFile":   o.IdentityFile,
 		"bootstrapPeers": o.BootstrapPeers,
 		"localAddrs":     o.LocalAddrs,
 		"connectTimeout": o.ConnectTimeout,
@@ -95,9 +102,21 @@ func (o HostOptions) MarshalJSON() ([]byte, error) {
 
 // NewHost creates a new libp2p host with the given options.
 func NewHost(ctx context.Context, opts HostOptions) (Host, error) {
+	if opts.Key == nil && opts.IdentityFile != "" {
+		key, err := LoadIdentity(opts.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.Key = key
+	}
 	if opts.Key != nil {
 		opts.Options = append(opts.Options, libp2p.Identity(opts.Key.AsIdentity()))
 	}
+	if len(opts.LocalAddrs) == 0 && !opts.NoFallbackDefaults {
+		if families := netutil.DetectAddressFamilies(); families.RoutableIPv4 != families.RoutableIPv6 {
+			opts.LocalAddrs = defaultListenAddrs(families)
+		}
+	}
 	if len(opts.LocalAddrs) > 0 {
 		opts.Options = append(opts.Options, libp2p.ListenAddrs(opts.LocalAddrs...))
 	}
@@ -121,6 +140,21 @@ func NewHost(ctx context.Context, opts HostOptions) (Host, error) {
 	return wrapHost(host), nil
 }
 
+// defaultListenAddrs returns the default libp2p listen addresses for the
+// routable address families of this host.
+func defaultListenAddrs(families netutil.AddressFamilies) []multiaddr.Multiaddr {
+	var addrs []multiaddr.Multiaddr
+	for _, proto := range []string{"/tcp/0", "/udp/0/quic-v1", "/udp/0/quic-v1/webtransport"} {
+		if families.RoutableIPv4 {
+			addrs = append(addrs, multiaddr.StringCast("/ip4/0.0.0.0"+proto))
+		}
+		if families.RoutableIPv6 {
+			addrs = append(addrs, multiaddr.StringCast("/ip6/::"+proto))
+		}
+	}
+	return addrs
+}
+
 type libp2pHost struct {
 	host      host.Host
 	liscancel func()
@@ -0,0 +1,46 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libp2p
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+// LoadIdentity loads the host identity persisted at the given path. A new
+// identity is generated and saved when the file does not exist, so hosts
+// created with it keep the same peer ID across restarts and can be pinned
+// by their peers.
+func LoadIdentity(path string) (crypto.PrivateKey, error) {
+	key, err := crypto.DecodePrivateKeyFromFile(path)
+	if err == nil {
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("load libp2p identity: %w", err)
+	}
+	key, err = crypto.GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("generate libp2p identity: %w", err)
+	}
+	if err := crypto.EncodeKeyToFile(key, path); err != nil {
+		return nil, fmt.Errorf("save libp2p identity: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package libp2p
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestLoadIdentity(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "libp2p.key")
+	key, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatalf("generate identity: %v", err)
+	}
+	loaded, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatalf("load identity: %v", err)
+	}
+	if !loaded.Equals(key) {
+		t.Fatalf("expected the persisted identity to be loaded")
+	}
+
+	// Hosts created with the identity file share the same peer ID.
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 2; i++ {
+		host, err := NewHost(ctx, HostOptions{
+			IdentityFile: path,
+			LocalAddrs:   []multiaddr.Multiaddr{multiaddr.StringCast("/ip4/127.0.0.1/tcp/0")},
+		})
+		if err != nil {
+			t.Fatalf("new host: %v", err)
+		}
+		ids = append(ids, peer.ID(host.ID()).String())
+		host.Close()
+	}
+	if ids[0] != ids[1] || ids[0] != key.ID() {
+		t.Fatalf("expected stable peer ID %s, got %v", key.ID(), ids)
+	}
+}
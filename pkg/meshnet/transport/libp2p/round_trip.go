@@ -144,7 +144,9 @@ func (rt *roundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *REQ) (*RE
 				callOpts = append(callOpts, callCred)
 			}
 		}
-		err = conn.Invoke(ctx, rt.Method, req, &resp, callOpts...)
+		headerOpts, storeHeader := transport.ResponseHeaderCallOptions(ctx)
+		err = conn.Invoke(ctx, rt.Method, req, &resp, append(callOpts, headerOpts...)...)
+		storeHeader(err)
 		if err != nil {
 			log.Debug("Invoke request failed", "error", err)
 			return nil, err
@@ -188,7 +190,9 @@ func (rt *discoveryRoundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *
 			callOpts = append(callOpts, callCred)
 		}
 	}
-	err = conn.Invoke(ctx, rt.Method, req, &resp, callOpts...)
+	headerOpts, storeHeader := transport.ResponseHeaderCallOptions(ctx)
+	err = conn.Invoke(ctx, rt.Method, req, &resp, append(callOpts, headerOpts...)...)
+	storeHeader(err)
 	if err != nil {
 		log.Debug("Invoke request failed", "error", err)
 		return nil, err
@@ -514,7 +514,7 @@ func TestDiscoveryRPCTransport(t *testing.T) {
 			t.Fatal(err)
 		}
 		// Announce this host to the DHT and start a dummy server.
-		server.Announce(ctx, rendezvous, time.Minute)
+		server.Announce(ctx, rendezvous, AdvertiseOptions{TTL: time.Minute})
 		t.Cleanup(func() { _ = server.Close() })
 		srv := grpc.NewServer(grpc.Creds(insecure.NewCredentials()))
 		v1.RegisterMeshServer(srv, &TestMeshAPI{})
@@ -571,7 +571,7 @@ func TestDiscoveryRPCTransport(t *testing.T) {
 			t.Fatal(err)
 		}
 		// Announce this host to the DHT and start a dummy server
-		server.Announce(ctx, rendezvous, time.Minute)
+		server.Announce(ctx, rendezvous, AdvertiseOptions{TTL: time.Minute})
 		t.Cleanup(func() { _ = server.Close() })
 		srv := grpc.NewServer(grpc.Creds(insecure.NewCredentials()))
 		v1.RegisterMeshServer(srv, &TestMeshAPI{})
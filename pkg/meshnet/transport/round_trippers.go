@@ -18,9 +18,11 @@ package transport
 
 import (
 	"context"
+	"errors"
 	"io"
 
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/metadata"
 )
 
 // RoundTripper is a generic interface for executing a request and returning
@@ -44,6 +46,69 @@ func (f RoundTripperFunc[REQ, RESP]) Close() error {
 	return nil
 }
 
+// NewParallelRoundTripper returns a RoundTripper that issues the request on all
+// the given round trippers concurrently. The first successful response is
+// returned and the remaining requests are cancelled. If all of them fail, the
+// error from the last one to finish is returned. Nil round trippers are ignored.
+func NewParallelRoundTripper[REQ, RESP any](rts ...RoundTripper[REQ, RESP]) RoundTripper[REQ, RESP] {
+	var out []RoundTripper[REQ, RESP]
+	for _, rt := range rts {
+		if rt != nil {
+			out = append(out, rt)
+		}
+	}
+	if len(out) == 1 {
+		return out[0]
+	}
+	return &parallelRoundTripper[REQ, RESP]{rts: out}
+}
+
+type parallelRoundTripper[REQ, RESP any] struct {
+	rts []RoundTripper[REQ, RESP]
+}
+
+type roundTripResult[RESP any] struct {
+	resp   *RESP
+	header *metadata.MD
+	err    error
+}
+
+func (p *parallelRoundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *REQ) (*RESP, error) {
+	if len(p.rts) == 0 {
+		return nil, errors.New("no round trippers configured")
+	}
+	rtctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan roundTripResult[RESP], len(p.rts))
+	for _, rt := range p.rts {
+		go func(rt RoundTripper[REQ, RESP]) {
+			rtctx, header := withResponseHeaderCapture(rtctx)
+			resp, err := rt.RoundTrip(rtctx, req)
+			results <- roundTripResult[RESP]{resp: resp, header: header, err: err}
+		}(rt)
+	}
+	var err error
+	for range p.rts {
+		res := <-results
+		if res.err == nil {
+			storeResponseHeader(ctx, res.header)
+			return res.resp, nil
+		}
+		err = res.err
+	}
+	return nil, err
+}
+
+func (p *parallelRoundTripper[REQ, RESP]) Close() error {
+	var errs []error
+	for _, rt := range p.rts {
+		if err := rt.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // JoinRoundTripper is the interface for joining a cluster.
 type JoinRoundTripper = RoundTripper[v1.JoinRequest, v1.JoinResponse]
 
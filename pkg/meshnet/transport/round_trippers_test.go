@@ -0,0 +1,53 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParallelRoundTripper(t *testing.T) {
+	t.Parallel()
+	slow := RoundTripperFunc[string, string](func(ctx context.Context, req *string) (*string, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	failing := RoundTripperFunc[string, string](func(ctx context.Context, req *string) (*string, error) {
+		return nil, errors.New("unreachable")
+	})
+	ok := RoundTripperFunc[string, string](func(ctx context.Context, req *string) (*string, error) {
+		resp := "joined via " + *req
+		return &resp, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req := "peer"
+	resp, err := NewParallelRoundTripper[string, string](slow, failing, nil, ok).RoundTrip(ctx, &req)
+	if err != nil {
+		t.Fatalf("expected a successful round trip, got %v", err)
+	}
+	if *resp != "joined via peer" {
+		t.Fatalf("unexpected response %q", *resp)
+	}
+	_, err = NewParallelRoundTripper[string, string](failing, failing).RoundTrip(ctx, &req)
+	if err == nil {
+		t.Fatal("expected an error when all round trippers fail")
+	}
+}
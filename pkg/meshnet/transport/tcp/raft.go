@@ -18,14 +18,19 @@ limitations under the License.
 package tcp
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
+	"math"
 	"net"
 	"net/netip"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
 
+	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 )
 
@@ -37,11 +42,18 @@ type RaftTransportOptions struct {
 	MaxPool int
 	// Timeout is the timeout for dialing a connection.
 	Timeout time.Duration
+	// ClusterID returns the ID of the cluster this node belongs to, or an empty
+	// string if it is not known yet. When set, outgoing connections announce the
+	// cluster ID and incoming connections announcing a different one are refused.
+	ClusterID func() string
+	// Middlewares are applied to the listener in order, for example to accept
+	// the PROXY protocol or limit connections.
+	Middlewares []netutil.ListenerMiddleware
 }
 
 // NewRaftTransport creates a new TCP transport listening on the given address.
 func NewRaftTransport(leaderDialer transport.LeaderDialer, opts RaftTransportOptions) (transport.RaftTransport, error) {
-	sl, err := newTCPStreamLayer(opts.Addr)
+	sl, err := newTCPStreamLayer(opts.Addr, opts.ClusterID, opts.Middlewares...)
 	if err != nil {
 		return nil, fmt.Errorf("create TCP stream layer: %w", err)
 	}
@@ -68,23 +80,41 @@ func (t *RaftTransport) AddrPort() netip.AddrPort {
 	return t.laddr
 }
 
+// clusterPreamble is written ahead of the raft RPCs on a connection to announce
+// the cluster of the dialing node. It cannot be confused with a raft RPC type,
+// so connections from nodes that do not send it are still accepted.
+const clusterPreamble byte = 0xC1
+
 // TCPTransport is a transport that uses raw TCP.
 type tcpStreamLayer struct {
 	net.Listener
 	*net.Dialer
+	clusterID func() string
 }
 
-func newTCPStreamLayer(addr string) (*tcpStreamLayer, error) {
+func newTCPStreamLayer(addr string, clusterID func() string, middlewares ...netutil.ListenerMiddleware) (*tcpStreamLayer, error) {
 	ln, err := net.Listen("tcp", addr)
 	if err != nil {
 		return nil, fmt.Errorf("listen %s: %w", addr, err)
 	}
 	return &tcpStreamLayer{
-		Listener: ln,
-		Dialer:   &net.Dialer{},
+		Listener:  netutil.WrapListener(ln, middlewares...),
+		Dialer:    &net.Dialer{},
+		clusterID: clusterID,
 	}, nil
 }
 
+// Accept waits for and returns the next incoming connection. The cluster
+// preamble, if any, is verified on the first read so a slow peer cannot
+// block the accept loop.
+func (t *tcpStreamLayer) Accept() (net.Conn, error) {
+	conn, err := t.Listener.Accept()
+	if err != nil || t.clusterID == nil {
+		return conn, err
+	}
+	return &clusterConn{Conn: conn, r: bufio.NewReader(conn), clusterID: t.clusterID}, nil
+}
+
 func (t *tcpStreamLayer) AddrPort() netip.AddrPort {
 	return t.Listener.Addr().(*net.TCPAddr).AddrPort()
 }
@@ -93,5 +123,67 @@ func (t *tcpStreamLayer) AddrPort() netip.AddrPort {
 func (t *tcpStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
-	return t.DialContext(ctx, "tcp", string(address))
+	conn, err := t.DialContext(ctx, "tcp", string(address))
+	if err != nil || t.clusterID == nil {
+		return conn, err
+	}
+	id := t.clusterID()
+	if id == "" {
+		return conn, nil
+	}
+	if len(id) > math.MaxUint8 {
+		defer conn.Close()
+		return nil, fmt.Errorf("cluster id too long")
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err = conn.Write(append([]byte{clusterPreamble, byte(len(id))}, id...))
+	_ = conn.SetWriteDeadline(time.Time{})
+	if err != nil {
+		defer conn.Close()
+		return nil, fmt.Errorf("write cluster preamble: %w", err)
+	}
+	return conn, nil
+}
+
+// clusterConn is an accepted connection that verifies the cluster preamble
+// sent by the dialer before handing any data to raft.
+type clusterConn struct {
+	net.Conn
+	r         *bufio.Reader
+	clusterID func() string
+	once      sync.Once
+	err       error
+}
+
+func (c *clusterConn) Read(b []byte) (int, error) {
+	c.once.Do(func() { c.err = c.verify() })
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.r.Read(b)
+}
+
+func (c *clusterConn) verify() error {
+	first, err := c.r.Peek(1)
+	if err != nil {
+		return err
+	}
+	if first[0] != clusterPreamble {
+		// A node that does not announce its cluster.
+		return nil
+	}
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, header); err != nil {
+		return err
+	}
+	remote := make([]byte, header[1])
+	if _, err := io.ReadFull(c.r, remote); err != nil {
+		return err
+	}
+	local := c.clusterID()
+	if local != "" && local != string(remote) {
+		_ = c.Conn.Close()
+		return fmt.Errorf("refusing raft connection from %s: it belongs to cluster %s, not %s", c.RemoteAddr(), remote, local)
+	}
+	return nil
 }
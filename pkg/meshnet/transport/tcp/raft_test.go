@@ -0,0 +1,75 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tcp
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestStreamLayerClusterPreamble(t *testing.T) {
+	t.Parallel()
+	ln, err := newTCPStreamLayer("127.0.0.1:0", func() string { return "cluster-a" })
+	if err != nil {
+		t.Fatalf("create stream layer: %v", err)
+	}
+	defer ln.Close()
+	addr := raft.ServerAddress(ln.AddrPort().String())
+
+	tc := []struct {
+		name      string
+		clusterID func() string
+		accepted  bool
+	}{
+		{"SameCluster", func() string { return "cluster-a" }, true},
+		{"UnknownCluster", func() string { return "" }, true},
+		{"NoPreamble", nil, true},
+		{"OtherCluster", func() string { return "cluster-b" }, false},
+	}
+	for _, tt := range tc {
+		dialer := &tcpStreamLayer{clusterID: tt.clusterID}
+		dialer.Dialer = ln.Dialer
+		conn, err := dialer.Dial(addr, time.Second)
+		if err != nil {
+			t.Fatalf("%s: dial: %v", tt.name, err)
+		}
+		if _, err := conn.Write([]byte{0x01}); err != nil {
+			t.Fatalf("%s: write: %v", tt.name, err)
+		}
+		accepted, err := ln.Accept()
+		if err != nil {
+			t.Fatalf("%s: accept: %v", tt.name, err)
+		}
+		buf := make([]byte, 1)
+		_, err = io.ReadFull(accepted, buf)
+		if tt.accepted {
+			if err != nil {
+				t.Fatalf("%s: expected the connection to be accepted, got %v", tt.name, err)
+			}
+			if buf[0] != 0x01 {
+				t.Fatalf("%s: expected the raft payload to be preserved, got %x", tt.name, buf[0])
+			}
+		} else if err == nil {
+			t.Fatalf("%s: expected the connection to be refused", tt.name)
+		}
+		conn.Close()
+		accepted.Close()
+	}
+}
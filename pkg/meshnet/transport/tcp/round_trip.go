@@ -73,7 +73,7 @@ func (rt *grpcRoundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *REQ)
 		if rt.AddressTimeout > 0 {
 			dialCtx, cancel = context.WithTimeout(ctx, rt.AddressTimeout)
 		} else {
-			dialCtx = ctx
+			dialCtx, cancel = ctx, func() {}
 		}
 		var conn transport.RPCClientConn
 		conn, err = t.Dial(dialCtx, "", addr)
@@ -92,7 +92,9 @@ func (rt *grpcRoundTripper[REQ, RESP]) RoundTrip(ctx context.Context, req *REQ)
 				callOpts = append(callOpts, callCred)
 			}
 		}
-		err = conn.Invoke(ctx, rt.method, req, &resp, callOpts...)
+		headerOpts, storeHeader := transport.ResponseHeaderCallOptions(ctx)
+		err = conn.Invoke(ctx, rt.method, req, &resp, append(callOpts, headerOpts...)...)
+		storeHeader(err)
 		if err != nil {
 			log.Debug("Invoke request failed", "error", err)
 			continue
@@ -33,6 +33,7 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/datachannels"
 )
 
 // SignalOptions are options for configuring the WebRTC transport.
@@ -47,6 +48,8 @@ type SignalOptions struct {
 	TargetProto string
 	// TargetAddr is the target address to request from the remote node.
 	TargetAddr netip.AddrPort
+	// TURNAuth are the credentials to use for TURN servers.
+	TURNAuth datachannels.TURNAuth
 }
 
 // NewSignalTransport returns a new WebRTC signaling transport that attempts
@@ -134,16 +137,7 @@ Connect:
 		return fmt.Errorf("unmarshal SDP offer: %w", err)
 	}
 	rt.remoteDescription = offer
-	rt.turnServers = make([]webrtc.ICEServer, len(resp.GetStunServers()))
-	for i, server := range resp.GetStunServers() {
-		rt.turnServers[i] = webrtc.ICEServer{
-			URLs: []string{server},
-			// TODO: Authentication
-			Username:       rt.NodeID,
-			Credential:     rt.NodeID,
-			CredentialType: webrtc.ICECredentialTypePassword,
-		}
-	}
+	rt.turnServers = rt.TURNAuth.ICEServers(resp.GetStunServers(), rt.NodeID)
 	rt.stream = neg
 	go rt.handleNegotiateStream(ctx, conn, neg)
 	return nil
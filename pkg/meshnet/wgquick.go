@@ -0,0 +1,144 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"bytes"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// WGQuickPrivateKeyPlaceholder is written in place of the private key when
+// rendering a wg-quick configuration without one. Private keys never leave
+// the node that owns them, so the consumer must substitute its own.
+const WGQuickPrivateKeyPlaceholder = "<private-key>"
+
+// WGQuickOptions are options for rendering a wg-quick configuration.
+type WGQuickOptions struct {
+	// NodeID is the node to render the configuration for.
+	NodeID types.NodeID
+	// PrivateKey is the private key of the node. If nil, a placeholder
+	// is written instead.
+	PrivateKey crypto.PrivateKey
+	// PersistentKeepalive is the keepalive interval to set on every peer.
+	// It is omitted when zero.
+	PersistentKeepalive time.Duration
+	// MTU is the MTU of the interface. It is omitted when zero.
+	MTU int
+}
+
+// RenderWGQuick renders the desired WireGuard configuration of a node in
+// the format understood by wg-quick. The output only depends on the state
+// of the mesh, so rendering the same state twice produces identical files.
+func RenderWGQuick(ctx context.Context, st storage.MeshDB, opts WGQuickOptions) ([]byte, error) {
+	node, err := st.Peers().Get(ctx, opts.NodeID)
+	if err != nil {
+		return nil, fmt.Errorf("get node %s: %w", opts.NodeID, err)
+	}
+	peers, err := WireGuardPeersFor(ctx, st, opts.NodeID)
+	if err != nil {
+		return nil, fmt.Errorf("get wireguard peers: %w", err)
+	}
+	dnsServers, err := st.Peers().List(ctx, storage.FilterByFeature(v1.Feature_MESH_DNS))
+	if err != nil {
+		return nil, fmt.Errorf("list dns servers: %w", err)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# wg-quick configuration for webmesh node %s\n", node.GetId())
+	buf.WriteString("[Interface]\n")
+	privateKey := WGQuickPrivateKeyPlaceholder
+	if opts.PrivateKey != nil {
+		privateKey = opts.PrivateKey.WireGuardKey().String()
+	}
+	fmt.Fprintf(&buf, "PrivateKey = %s\n", privateKey)
+	var addrs []string
+	for _, addr := range []netip.Prefix{node.PrivateAddrV4(), node.PrivateAddrV6()} {
+		if addr.IsValid() {
+			addrs = append(addrs, addr.String())
+		}
+	}
+	if len(addrs) > 0 {
+		fmt.Fprintf(&buf, "Address = %s\n", strings.Join(addrs, ", "))
+	}
+	if port := node.WireGuardPort(); port != 0 {
+		fmt.Fprintf(&buf, "ListenPort = %d\n", port)
+	}
+	if opts.MTU > 0 {
+		fmt.Fprintf(&buf, "MTU = %d\n", opts.MTU)
+	}
+	// wg-quick has no way to express a DNS port, so only servers listening
+	// on the standard port are usable.
+	var dns []string
+	for _, server := range dnsServers {
+		if server.NodeID() == opts.NodeID {
+			continue
+		}
+		var addr netip.AddrPort
+		switch {
+		case server.PrivateDNSAddrV4().IsValid():
+			addr = server.PrivateDNSAddrV4()
+		case server.PrivateDNSAddrV6().IsValid():
+			addr = server.PrivateDNSAddrV6()
+		default:
+			continue
+		}
+		if addr.Port() != 53 {
+			fmt.Fprintf(&buf, "# Skipping DNS server %s on non-standard port\n", addr)
+			continue
+		}
+		dns = append(dns, addr.Addr().String())
+	}
+	slices.Sort(dns)
+	if len(dns) > 0 {
+		fmt.Fprintf(&buf, "DNS = %s\n", strings.Join(slices.Compact(dns), ", "))
+	}
+	slices.SortFunc(peers, func(a, b *v1.WireGuardPeer) int {
+		return strings.Compare(a.GetNode().GetId(), b.GetNode().GetId())
+	})
+	for _, peer := range peers {
+		key, err := crypto.DecodePublicKey(peer.GetNode().GetPublicKey())
+		if err != nil {
+			return nil, fmt.Errorf("parse key for peer %s: %w", peer.GetNode().GetId(), err)
+		}
+		buf.WriteString("\n")
+		fmt.Fprintf(&buf, "# %s\n", peer.GetNode().GetId())
+		buf.WriteString("[Peer]\n")
+		fmt.Fprintf(&buf, "PublicKey = %s\n", key.WireGuardKey().String())
+		if endpoint := peer.GetNode().GetPrimaryEndpoint(); endpoint != "" {
+			fmt.Fprintf(&buf, "Endpoint = %s\n", endpoint)
+		}
+		allowedIPs := slices.Clone(peer.GetAllowedIPs())
+		slices.Sort(allowedIPs)
+		if len(allowedIPs) > 0 {
+			fmt.Fprintf(&buf, "AllowedIPs = %s\n", strings.Join(slices.Compact(allowedIPs), ", "))
+		}
+		if opts.PersistentKeepalive > 0 {
+			fmt.Fprintf(&buf, "PersistentKeepalive = %d\n", int(opts.PersistentKeepalive.Seconds()))
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnet
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestRenderWGQuick(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	err := db.MeshState().SetMeshState(ctx, types.NetworkState{
+		NetworkState: &v1.NetworkState{
+			NetworkV4: "172.16.0.0/12",
+			NetworkV6: "2001:db8::/64",
+			Domain:    "example.com",
+		},
+	})
+	if err != nil {
+		t.Fatalf("set network state: %v", err)
+	}
+	err = db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "allow-all",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+		SourceCIDRs:      []string{"*"},
+		DestinationCIDRs: []string{"*"},
+	}})
+	if err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+	nodes := []*v1.MeshNode{
+		{
+			Id:                 "device",
+			PublicKey:          mustGeneratePublicKey(t),
+			PrivateIPv4:        "172.16.0.1/32",
+			PrivateIPv6:        "2001:db8::1/112",
+			WireguardEndpoints: []string{"192.0.2.1:51820"},
+		},
+		{
+			Id:                 "hub",
+			PublicKey:          mustGeneratePublicKey(t),
+			PrivateIPv4:        "172.16.0.2/32",
+			PrimaryEndpoint:    "198.51.100.1",
+			WireguardEndpoints: []string{"198.51.100.1:51820"},
+			Features:           []*v1.FeaturePort{{Feature: v1.Feature_MESH_DNS, Port: 53}},
+		},
+		{
+			Id:          "spoke",
+			PublicKey:   mustGeneratePublicKey(t),
+			PrivateIPv4: "172.16.0.3/32",
+			Features:    []*v1.FeaturePort{{Feature: v1.Feature_MESH_DNS, Port: 5353}},
+		},
+	}
+	for _, node := range nodes {
+		if err := db.Peers().Put(ctx, types.MeshNode{MeshNode: node}); err != nil {
+			t.Fatalf("put peer %q: %v", node.GetId(), err)
+		}
+	}
+	for _, edge := range [][2]string{{"device", "hub"}, {"hub", "spoke"}} {
+		err = db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: edge[0], Target: edge[1]}})
+		if err != nil {
+			t.Fatalf("put edge %v: %v", edge, err)
+		}
+	}
+	hubKey, err := crypto.DecodePublicKey(nodes[1].GetPublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Placeholder", func(t *testing.T) {
+		out, err := RenderWGQuick(ctx, db, WGQuickOptions{NodeID: "device", PersistentKeepalive: 25 * time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := strings.Join([]string{
+			"# wg-quick configuration for webmesh node device",
+			"[Interface]",
+			"PrivateKey = " + WGQuickPrivateKeyPlaceholder,
+			"Address = 172.16.0.1/32, 2001:db8::1/112",
+			"ListenPort = 51820",
+			"# Skipping DNS server 172.16.0.3:5353 on non-standard port",
+			"DNS = 172.16.0.2",
+			"",
+			"# hub",
+			"[Peer]",
+			"PublicKey = " + hubKey.WireGuardKey().String(),
+			"Endpoint = 198.51.100.1:51820",
+			"AllowedIPs = 172.16.0.0/12, 2001:db8::/64",
+			"PersistentKeepalive = 25",
+			"",
+		}, "\n")
+		if string(out) != want {
+			t.Fatalf("unexpected configuration:\n%s\nexpected:\n%s", out, want)
+		}
+		again, err := RenderWGQuick(ctx, db, WGQuickOptions{NodeID: "device", PersistentKeepalive: 25 * time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(out) {
+			t.Fatal("expected rendering to be deterministic")
+		}
+	})
+
+	t.Run("PrivateKey", func(t *testing.T) {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := RenderWGQuick(ctx, db, WGQuickOptions{NodeID: "device", PrivateKey: key})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(out), "PrivateKey = "+key.WireGuardKey().String()+"\n") {
+			t.Fatalf("expected private key in configuration:\n%s", out)
+		}
+	})
+
+	t.Run("UnknownNode", func(t *testing.T) {
+		_, err := RenderWGQuick(ctx, db, WGQuickOptions{NodeID: "unknown"})
+		if err == nil {
+			t.Fatal("expected error for unknown node")
+		}
+	})
+}
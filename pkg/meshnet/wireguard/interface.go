@@ -29,13 +29,13 @@ import (
 	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
-	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system/link"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/privsep"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/system/routes"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
@@ -119,6 +119,11 @@ type Options struct {
 	DisableFullTunnel bool
 	// IgnoreRoutes are additional routes to ignore.
 	IgnoreRoutes []netip.Prefix
+	// SystemOps performs the privileged system operations. If nil, they
+	// are performed directly in the current process.
+	SystemOps privsep.Ops
+	// TUNWrapper wraps the userspace TUN device when one is used.
+	TUNWrapper link.TUNWrapper
 }
 
 type wginterface struct {
@@ -126,6 +131,7 @@ type wginterface struct {
 	defaultGateway routes.Gateway
 	changedGateway bool
 	opts           *Options
+	ops            privsep.Ops
 	log            *slog.Logger
 	peers          map[string]Peer
 	peersMux       sync.Mutex
@@ -141,6 +147,7 @@ func New(ctx context.Context, opts *Options) (Interface, error) {
 	if opts.MTU <= 0 {
 		opts.MTU = system.DefaultMTU
 	}
+	ops := privsep.OrLocal(opts.SystemOps)
 	if opts.ForceName {
 		if !strings.HasSuffix(opts.Name, "+") {
 			log.Warn("Forcing wireguard interface name", "name", opts.Name)
@@ -150,16 +157,16 @@ func New(ctx context.Context, opts *Options) (Interface, error) {
 					return nil, fmt.Errorf("failed to get interface: %w", err)
 				}
 			} else if iface != nil {
-				err = link.RemoveInterface(ctx, opts.Name)
+				err = ops.RemoveInterface(ctx, opts.Name)
 				if err != nil {
 					return nil, fmt.Errorf("failed to delete interface: %w", err)
 				}
 			}
 		}
 	}
-	if os.Getuid() == 0 {
+	if os.Getuid() == 0 || opts.SystemOps != nil {
 		log.Debug("Enabling IP forwarding")
-		err := routes.EnableIPForwarding()
+		err := ops.EnableIPForwarding(ctx)
 		if err != nil {
 			log.Debug("Failed to enable ip forwarding", "error", err.Error())
 		}
@@ -181,9 +188,10 @@ func New(ctx context.Context, opts *Options) (Interface, error) {
 		MTU:         uint32(opts.MTU),
 		DisableIPv4: opts.DisableIPv4,
 		DisableIPv6: opts.DisableIPv6,
+		TUNWrapper:  opts.TUNWrapper,
 	}
 	log.Debug("Creating system interface", "options", ifaceopts)
-	iface, err := system.New(ctx, ifaceopts)
+	iface, err := ops.NewInterface(ctx, ifaceopts)
 	if err != nil {
 		return nil, fmt.Errorf("new system interface: %w", err)
 	}
@@ -192,6 +200,7 @@ func New(ctx context.Context, opts *Options) (Interface, error) {
 		Interface:      iface,
 		defaultGateway: gw,
 		opts:           opts,
+		ops:            ops,
 		peers:          make(map[string]Peer),
 		log:            log,
 	}
@@ -209,24 +218,7 @@ func New(ctx context.Context, opts *Options) (Interface, error) {
 
 // ListenPort returns the current listen port of the wireguard interface.
 func (w *wginterface) ListenPort() (int, error) {
-	if runtime.GOOS == "linux" && w.opts.NetNs != "" {
-		var listenPort int
-		var err error
-		err = system.DoInNetNS(w.opts.NetNs, func() error {
-			listenPort, err = w.getListenPort()
-			return err
-		})
-		return listenPort, err
-	}
-	return w.getListenPort()
-}
-
-func (w *wginterface) getListenPort() (int, error) {
-	cli, err := wgctrl.New()
-	if err != nil {
-		return 0, err
-	}
-	iface, err := cli.Device(w.Name())
+	iface, err := w.ops.Device(context.Background(), w.opts.NetNs, w.Name())
 	if err != nil {
 		return 0, err
 	}
@@ -262,14 +254,7 @@ func (w *wginterface) Close(ctx context.Context) error {
 	}
 	if w.changedGateway {
 		defer func() {
-			var err error
-			if w.opts.NetNs != "" {
-				err = system.DoInNetNS(w.opts.NetNs, func() error {
-					return routes.SetDefaultIPv4Gateway(ctx, w.defaultGateway)
-				})
-			} else {
-				err = routes.SetDefaultIPv4Gateway(ctx, w.defaultGateway)
-			}
+			err := w.ops.SetDefaultIPv4Gateway(ctx, w.opts.NetNs, w.defaultGateway)
 			if err != nil {
 				w.log.Warn("Failed to reset default gateway", "error", err.Error())
 			}
@@ -280,25 +265,12 @@ func (w *wginterface) Close(ctx context.Context) error {
 
 // Configure configures the wireguard interface to use the given key and listen port.
 func (w *wginterface) Configure(ctx context.Context, key crypto.PrivateKey) error {
-	if runtime.GOOS == "linux" && w.opts.NetNs != "" {
-		return system.DoInNetNS(w.opts.NetNs, func() error {
-			return w.configure(ctx, key)
-		})
-	}
-	return w.configure(ctx, key)
-}
-
-func (w *wginterface) configure(ctx context.Context, key crypto.PrivateKey) error {
-	cli, err := wgctrl.New()
-	if err != nil {
-		return err
-	}
 	var listenPort *int
 	if w.opts.ListenPort != 0 {
 		listenPort = &w.opts.ListenPort
 	}
 	wgKey := key.WireGuardKey()
-	err = cli.ConfigureDevice(w.Name(), wgtypes.Config{
+	err := w.ops.ConfigureDevice(ctx, w.opts.NetNs, w.Name(), wgtypes.Config{
 		PrivateKey:   &wgKey,
 		ListenPort:   listenPort,
 		ReplacePeers: false,
@@ -311,27 +283,7 @@ func (w *wginterface) configure(ctx context.Context, key crypto.PrivateKey) erro
 
 // Metrics returns the metrics for the wireguard interface.
 func (w *wginterface) Metrics() (*v1.InterfaceMetrics, error) {
-	var metrics *v1.InterfaceMetrics
-	if runtime.GOOS == "linux" && w.opts.NetNs != "" {
-		var err error
-		err = system.DoInNetNS(w.opts.NetNs, func() error {
-			metrics, err = w.getMetrics()
-			return err
-		})
-		if err != nil {
-			return nil, err
-		}
-		return metrics, nil
-	}
-	return w.getMetrics()
-}
-
-func (w *wginterface) getMetrics() (*v1.InterfaceMetrics, error) {
-	cli, err := wgctrl.New()
-	if err != nil {
-		return nil, err
-	}
-	device, err := cli.Device(w.Name())
+	device, err := w.ops.Device(context.Background(), w.opts.NetNs, w.Name())
 	if err != nil {
 		return nil, err
 	}
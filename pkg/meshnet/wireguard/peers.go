@@ -23,11 +23,9 @@ import (
 	"log/slog"
 	"net"
 	"net/netip"
-	"runtime"
 	"time"
 
 	"github.com/multiformats/go-multiaddr"
-	"golang.zx2c4.com/wireguard/wgctrl"
 	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
 
 	"github.com/webmeshproj/webmesh/pkg/crypto"
@@ -170,18 +168,12 @@ func (w *wginterface) PutPeer(ctx context.Context, peer *Peer) error {
 		}
 	}
 	w.log.Debug("Configuring device with peer", slog.Any("peer", &peerConfigMarshaler{peerCfg}))
-	if runtime.GOOS == "linux" && w.opts.NetNs != "" {
-		err = system.DoInNetNS(w.opts.NetNs, func() error {
-			return w.putPeer(peerCfg)
-		})
-		if err != nil {
-			return err
-		}
-	} else {
-		err = w.putPeer(peerCfg)
-		if err != nil {
-			return err
-		}
+	err = w.ops.ConfigureDevice(ctx, w.opts.NetNs, w.Name(), wgtypes.Config{
+		Peers:        []wgtypes.PeerConfig{peerCfg},
+		ReplacePeers: false,
+	})
+	if err != nil {
+		return err
 	}
 	w.registerPeer(peer)
 	// Add routes to the allowed IPs
@@ -211,20 +203,10 @@ func (w *wginterface) PutPeer(ctx context.Context, peer *Peer) error {
 			}
 			if !w.opts.DisableIPv4 && !w.changedGateway {
 				w.log.Debug("Setting default IPv4 gateway", slog.String("prefix", prefix.String()))
-				var err error
-				if w.opts.NetNs != "" {
-					err = system.DoInNetNS(w.opts.NetNs, func() error {
-						return routes.SetDefaultIPv4Gateway(ctx, routes.Gateway{
-							Name: w.Name(),
-							Addr: w.AddressV4().Addr(),
-						})
-					})
-				} else {
-					err = routes.SetDefaultIPv4Gateway(ctx, routes.Gateway{
-						Name: w.Name(),
-						Addr: w.AddressV4().Addr(),
-					})
-				}
+				err := w.ops.SetDefaultIPv4Gateway(ctx, w.opts.NetNs, routes.Gateway{
+					Name: w.Name(),
+					Addr: w.AddressV4().Addr(),
+				})
 				if err != nil {
 					return fmt.Errorf("failed to set default IPv4 gateway: %w", err)
 				}
@@ -255,17 +237,6 @@ func (w *wginterface) PutPeer(ctx context.Context, peer *Peer) error {
 	return nil
 }
 
-func (w *wginterface) putPeer(cfg wgtypes.PeerConfig) error {
-	cli, err := wgctrl.New()
-	if err != nil {
-		return err
-	}
-	return cli.ConfigureDevice(w.Name(), wgtypes.Config{
-		Peers:        []wgtypes.PeerConfig{cfg},
-		ReplacePeers: false,
-	})
-}
-
 // DeletePeer removes a peer from the wireguard configuration.
 func (w *wginterface) DeletePeer(ctx context.Context, id string) error {
 	if key, ok := w.popPeerKey(id); ok {
@@ -273,32 +244,19 @@ func (w *wginterface) DeletePeer(ctx context.Context, id string) error {
 			slog.String("id", id),
 			slog.String("key", key.WireGuardKey().String()),
 		)
-		if runtime.GOOS == "linux" && w.opts.NetNs != "" {
-			return system.DoInNetNS(w.opts.NetNs, func() error {
-				return w.deletePeer(key)
-			})
-		}
-		return w.deletePeer(key)
+		return w.ops.ConfigureDevice(ctx, w.opts.NetNs, w.Name(), wgtypes.Config{
+			Peers: []wgtypes.PeerConfig{
+				{
+					PublicKey: key.WireGuardKey(),
+					Remove:    true,
+				},
+			},
+			ReplacePeers: false,
+		})
 	}
 	return nil
 }
 
-func (w *wginterface) deletePeer(key crypto.PublicKey) error {
-	cli, err := wgctrl.New()
-	if err != nil {
-		return err
-	}
-	return cli.ConfigureDevice(w.Name(), wgtypes.Config{
-		Peers: []wgtypes.PeerConfig{
-			{
-				PublicKey: key.WireGuardKey(),
-				Remove:    true,
-			},
-		},
-		ReplacePeers: false,
-	})
-}
-
 // registerPeer adds a peer to the peer map.
 func (w *wginterface) registerPeer(peer *Peer) {
 	w.peersMux.Lock()
@@ -0,0 +1,147 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
+)
+
+// ACL Enforcement Metrics
+var (
+	// ACLEnforcementsTotal tracks the number of times network ACL changes
+	// were enforced against existing peers and flows.
+	ACLEnforcementsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "acl_enforcements_total",
+		Help:      "Total number of network ACL changes enforced on existing peers and flows.",
+	}, []string{"node_id"})
+
+	// ACLFlowsCutTotal tracks the number of established flows that were
+	// cut because of a network ACL change.
+	ACLFlowsCutTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "acl_flows_cut_total",
+		Help:      "Total number of established flows cut by network ACL changes.",
+	}, []string{"node_id"})
+)
+
+func (s *meshStore) onNetworkACLUpdate(key, value []byte) {
+	s.log.Debug("Network ACL update triggered", slog.String("key", string(key)))
+	if s.testStore {
+		return
+	}
+	go s.queueACLEnforcement()
+}
+
+func (s *meshStore) queueACLEnforcement() {
+	s.log.Debug("Queuing enforcement of network ACLs")
+	time.Sleep(time.Second * 2)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	s.aclUpdateGroup.TryGo(func() error {
+		defer cancel()
+		wg := s.nw.WireGuard()
+		if wg == nil {
+			return nil
+		}
+		// Refresh the wireguard peers so any allowed IPs that are no longer
+		// permitted are torn down, and note which prefixes went away.
+		before := peerPrefixes(wg.Peers())
+		if err := s.nw.Peers().Sync(ctx); err != nil {
+			s.log.Error("refresh wireguard peers failed", slog.String("error", err.Error()))
+		}
+		after := peerPrefixes(wg.Peers())
+		var removed []netip.Prefix
+		for prefix := range before {
+			if _, ok := after[prefix]; !ok {
+				removed = append(removed, prefix)
+			}
+		}
+		var cut int
+		if table := s.nw.Conntrack(); table != nil {
+			// We are tracking flows in userspace, kill the ones no longer allowed.
+			policy, err := meshnet.NewFlowPolicy(ctx, s.Storage().MeshDB(), s.ID())
+			if err != nil {
+				s.log.Error("error building connection tracking policy", slog.String("error", err.Error()))
+				return nil
+			}
+			cut = table.Revalidate(policy)
+		} else if fw := s.nw.Firewall(); fw != nil {
+			// Drop established flows to and from anything that was removed,
+			// and lift drops on anything that has since been allowed again.
+			denied := make([]netip.Prefix, 0, len(s.deniedPrefixes)+len(removed))
+			for _, prefix := range append(s.deniedPrefixes, removed...) {
+				if _, ok := after[prefix]; ok || containsPrefix(denied, prefix) {
+					continue
+				}
+				denied = append(denied, prefix)
+			}
+			if len(denied) > 0 || len(s.deniedPrefixes) > 0 {
+				var err error
+				cut, err = fw.SetDeniedPrefixes(ctx, wg.Name(), denied)
+				if err != nil {
+					s.log.Error("error updating denied prefixes in firewall", slog.String("error", err.Error()))
+					return nil
+				}
+				s.deniedPrefixes = denied
+			}
+		}
+		if len(removed) == 0 && cut == 0 {
+			return nil
+		}
+		ACLEnforcementsTotal.WithLabelValues(s.ID().String()).Inc()
+		ACLFlowsCutTotal.WithLabelValues(s.ID().String()).Add(float64(cut))
+		s.log.Info("Enforced network ACL change on existing peers and flows",
+			slog.Any("removed-prefixes", removed),
+			slog.Int("flows-cut", cut),
+		)
+		return nil
+	})
+}
+
+// peerPrefixes returns the set of addresses currently routed to wireguard peers.
+func peerPrefixes(peers map[string]wireguard.Peer) map[netip.Prefix]struct{} {
+	out := make(map[netip.Prefix]struct{})
+	for _, peer := range peers {
+		for _, prefix := range []netip.Prefix{peer.PrivateIPv4, peer.PrivateIPv6} {
+			if prefix.IsValid() {
+				out[prefix] = struct{}{}
+			}
+		}
+		for _, prefix := range peer.AllowedIPs {
+			out[prefix] = struct{}{}
+		}
+	}
+	return out
+}
+
+func containsPrefix(prefixes []netip.Prefix, prefix netip.Prefix) bool {
+	for _, p := range prefixes {
+		if p == prefix {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// aclScheduleRetryInterval is how long to wait before reloading the network
+// ACL schedules after failing to.
+const aclScheduleRetryInterval = time.Minute
+
+// onACLScheduleUpdate enforces the network ACLs after their schedules change
+// and reschedules the next time they need to be enforced.
+func (s *meshStore) onACLScheduleUpdate(key, value []byte) {
+	s.onNetworkACLUpdate(key, value)
+	select {
+	case s.aclSchedWake <- struct{}{}:
+	default:
+	}
+}
+
+// runACLScheduler enforces the network ACLs on existing peers and flows
+// whenever a scheduled ACL comes into or goes out of effect.
+func (s *meshStore) runACLScheduler() (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		for {
+			var timer <-chan time.Time
+			next, err := s.nextACLTransition()
+			switch {
+			case err != nil:
+				s.log.Warn("Failed to load network ACL schedules", slog.String("error", err.Error()))
+				timer = time.After(aclScheduleRetryInterval)
+			case !next.IsZero():
+				s.log.Debug("Scheduled network ACL enforcement", slog.Time("at", next))
+				timer = time.After(time.Until(next))
+			}
+			select {
+			case <-closeCh:
+				return
+			case <-s.aclSchedWake:
+			case <-timer:
+				if err == nil {
+					s.log.Info("Network ACL schedule transition, enforcing network ACLs")
+					s.onNetworkACLUpdate(storage.ACLSchedulesPrefix, nil)
+				}
+			}
+		}
+	}()
+	return
+}
+
+// nextACLTransition returns the next time a scheduled network ACL comes into
+// or goes out of effect.
+func (s *meshStore) nextACLTransition() (time.Time, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	schedules, err := storage.ListACLSchedules(ctx, s.storage.MeshStorage())
+	if err != nil {
+		return time.Time{}, err
+	}
+	return schedules.NextTransition(time.Now()), nil
+}
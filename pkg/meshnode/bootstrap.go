@@ -99,6 +99,7 @@ func (s *meshStore) initialBootstrapLeader(ctx context.Context, opts ConnectOpti
 		BootstrapNodes:       append(opts.Bootstrap.Servers, s.ID().String()),
 		Voters:               opts.Bootstrap.Voters,
 		DisableRBAC:          opts.Bootstrap.DisableRBAC,
+		ClusterID:            s.opts.ClusterID,
 	}
 	s.log.Debug("Bootstrapping mesh database", slog.Any("params", bootstrapOpts))
 	results, err := storage.Bootstrap(ctx, s.Storage().MeshDB(), &bootstrapOpts)
@@ -107,6 +108,7 @@ func (s *meshStore) initialBootstrapLeader(ctx context.Context, opts ConnectOpti
 	}
 	s.meshDomain = results.MeshDomain
 	s.log.Info("Bootstrapped webmesh cluster database",
+		slog.String("cluster-id", results.ClusterID),
 		slog.String("ipv4-network", results.NetworkV4.String()),
 		slog.String("ipv6-network", results.NetworkV6.String()),
 		slog.String("mesh-domain", results.MeshDomain),
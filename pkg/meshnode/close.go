@@ -17,6 +17,7 @@ limitations under the License.
 package meshnode
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 
@@ -35,16 +36,30 @@ func (s *meshStore) Close(ctx context.Context) error {
 	ctx = context.WithLogger(ctx, s.log)
 	defer s.open.Store(false)
 	defer close(s.closec)
-	s.kvSubCancel()
-	if s.nw != nil {
-		// Do this last so that we don't lose connectivity to the network
-		defer func() {
-			s.log.Debug("Closing network manager")
-			if err := s.nw.Close(ctx); err != nil {
-				s.log.Error("Error clearing firewall rules", slog.String("error", err.Error()))
-			}
-		}()
+	var hookErrs []error
+	runHooks := func(stage ShutdownStage) {
+		if err := s.hooks.run(ctx, stage, s.opts.ShutdownHookTimeout); err != nil {
+			hookErrs = append(hookErrs, err)
+		}
+	}
+	runHooks(PreStop)
+	if s.natDetectClose != nil {
+		close(s.natDetectClose)
+		<-s.natDetectDone
+		s.natDetectClose, s.natDetectDone = nil, nil
+	}
+	if s.rbReapClose != nil {
+		close(s.rbReapClose)
+		<-s.rbReapDone
+		s.rbReapClose, s.rbReapDone = nil, nil
+	}
+	if s.aclSchedClose != nil {
+		close(s.aclSchedClose)
+		<-s.aclSchedDone
+		s.aclSchedClose, s.aclSchedDone = nil, nil
 	}
+	s.kvSubCancel()
+	s.aclSubCancel()
 	if s.plugins != nil {
 		// Close the plugins
 		s.log.Debug("Closing plugin manager")
@@ -61,11 +76,16 @@ func (s *meshStore) Close(ctx context.Context) error {
 			s.log.Error("Error relinquishing storage leadership", slog.String("error", err.Error()))
 		}
 	}
+	runHooks(PreLeave)
 	// Try to leave the cluster.
 	err := s.leaveCluster(ctx)
 	if err != nil {
 		s.log.Error("Error leaving cluster", slog.String("error", err.Error()))
 	}
+	if err := s.leaderConns.Close(); err != nil {
+		s.log.Error("Error closing leader connections", slog.String("error", err.Error()))
+	}
+	runHooks(PreStoreClose)
 	if s.storage != nil {
 		s.log.Debug("Closing storage provider")
 		err := s.storage.Close()
@@ -73,8 +93,17 @@ func (s *meshStore) Close(ctx context.Context) error {
 			s.log.Error("Error stopping storage provider", slog.String("error", err.Error()))
 		}
 	}
+	runHooks(PostStoreClose)
+	if s.nw != nil {
+		// Do this last so that we don't lose connectivity to the network
+		s.log.Debug("Closing network manager")
+		if err := s.nw.Close(ctx); err != nil {
+			s.log.Error("Error clearing firewall rules", slog.String("error", err.Error()))
+		}
+	}
+	runHooks(PostStop)
 	s.log.Info("Webmesh node shut down")
-	return nil
+	return errors.Join(hookErrs...)
 }
 
 // leaveCluster attempts to remove this node from the cluster. The node must
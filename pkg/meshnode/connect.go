@@ -29,10 +29,13 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/endpoints"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/nat64"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 	"github.com/webmeshproj/webmesh/pkg/plugins"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/shardedstorage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -67,8 +70,19 @@ type ConnectOptions struct {
 	RequestVote bool
 	// RequestObserver requests to be an observer in Raft elections.
 	RequestObserver bool
+	// RequestLearner requests to be a learner in Raft elections. Learners are
+	// permanent observers that are never promoted to voters.
+	RequestLearner bool
 	// Routes are additional routes to broadcast to the mesh.
 	Routes []netip.Prefix
+	// Gateway advertises the node as a gateway for its routes. IP forwarding
+	// is enabled, traffic from the mesh is masqueraded as it leaves the node
+	// toward the routed networks, and peers prefer edges to the node.
+	Gateway bool
+	// NAT64, if set, translates traffic from IPv6-only members to the IPv4
+	// destinations of the node's routes. The NAT64 prefix should be included
+	// in Routes so the mesh routes it to this node.
+	NAT64 *nat64.Options
 	// DirectPeers are a map of peers to connect to directly. The values
 	// are the prefered transport to use.
 	DirectPeers map[types.NodeID]v1.ConnectProtocol
@@ -79,6 +93,9 @@ type ConnectOptions struct {
 	PreferIPv6 bool
 	// Multiaddrs are the multiaddrs to advertise for this node.
 	Multiaddrs []multiaddr.Multiaddr
+	// NATDetection are options for detecting the type of NAT the node is
+	// behind and reporting it to the mesh.
+	NATDetection NATDetectionOptions
 }
 
 func (c ConnectOptions) MarshalJSON() ([]byte, error) {
@@ -98,12 +115,16 @@ func (c ConnectOptions) MarshalJSON() ([]byte, error) {
 		"primaryEndpoint":    c.PrimaryEndpoint,
 		"wireguardEndpoints": c.WireGuardEndpoints,
 		"requestVote":        c.RequestVote,
+		"gateway":            c.Gateway,
+		"nat64":              c.NAT64,
 		"requestObserver":    c.RequestObserver,
+		"requestLearner":     c.RequestLearner,
 		"routes":             c.Routes,
 		"directPeers":        c.DirectPeers,
 		"bootstrap":          c.Bootstrap,
 		"preferIPv6":         c.PreferIPv6,
 		"multiaddrs":         c.Multiaddrs,
+		"natDetection":       c.NATDetection,
 	})
 }
 
@@ -174,14 +195,25 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 	// Create the network manager
 	opts.NetworkOptions.StoragePort = int(s.storage.ListenPort())
 	s.nw = meshnet.New(s.Storage().MeshDB(), opts.NetworkOptions, s.ID())
+	var reportedNAT *endpoints.NATType
 	if opts.Bootstrap != nil {
 		// Attempt bootstrap.
 		if err = s.bootstrap(ctx, opts); err != nil {
 			return fmt.Errorf("bootstrap: %w", err)
 		}
 	} else if opts.JoinRoundTripper != nil {
+		// Report whether we are a gateway with the join request so peers
+		// prefer edges to us from the start.
+		joinCtx := storage.WithGateway(ctx, opts.Gateway)
+		if opts.NATDetection.Enabled() {
+			// Report our NAT type with the join request so it is known
+			// before peers first connect to us.
+			natType := s.detectNAT(ctx, opts.NATDetection)
+			joinCtx = storage.WithNATType(joinCtx, string(natType))
+			reportedNAT = &natType
+		}
 		// Attempt to join the cluster.
-		err = s.join(ctx, opts)
+		err = s.join(joinCtx, opts)
 		if err != nil {
 			return fmt.Errorf("join: %w", err)
 		}
@@ -217,6 +249,24 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 		}
 		return cause
 	}
+	if opts.Bootstrap != nil || opts.JoinRoundTripper == nil {
+		// We did not join, so record our gateway status ourselves.
+		if err := s.reportGateway(ctx, opts.Gateway); err != nil {
+			log.Warn("Failed to report gateway status", slog.String("error", err.Error()))
+		}
+	}
+	if opts.Gateway {
+		log.Debug("Enabling forwarding and masquerade for gateway routes")
+		if err := s.nw.StartGateway(ctx); err != nil {
+			return handleErr(fmt.Errorf("start gateway: %w", err))
+		}
+	}
+	if opts.NAT64 != nil {
+		log.Debug("Starting NAT64 for gateway routes")
+		if err := s.nw.StartNAT64(ctx, *opts.NAT64); err != nil {
+			return handleErr(fmt.Errorf("start nat64: %w", err))
+		}
+	}
 	// Create the plugin manager
 	pluginopts := plugins.Options{
 		Storage:               s.Storage(),
@@ -243,8 +293,39 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 		}
 	})
 	// If we are using the built-in raftstorage, register the observer
-	if raft, ok := s.storage.(*raftstorage.Provider); ok {
+	switch raft := s.storage.(type) {
+	case *raftstorage.Provider:
 		raft.OnObservation(s.newObserver())
+	case *shardedstorage.Provider:
+		raft.OnObservation(s.newObserver())
+	}
+	// Enforce network ACL, namespace, exemption, and route preference changes
+	// on existing peers and flows.
+	s.log.Debug("Subscribing to network ACL updates")
+	var aclSubCancels []context.CancelFunc
+	for _, prefix := range []types.StoragePrefix{storage.NetworkACLsPrefix, storage.NamespacesPrefix, storage.NamespaceMembersPrefix, storage.ACLExemptionsKey, storage.NodeLabelsPrefix, storage.TrafficPoliciesPrefix, storage.RouteMetricsPrefix} {
+		cancel, err := s.storage.MeshStorage().Subscribe(context.Background(), prefix, s.onNetworkACLUpdate)
+		if err != nil {
+			for _, cancel := range aclSubCancels {
+				cancel()
+			}
+			return handleErr(fmt.Errorf("subscribe to %s: %w", prefix, err))
+		}
+		aclSubCancels = append(aclSubCancels, cancel)
+	}
+	// Enforce schedule changes and reschedule enforcement of scheduled ACLs.
+	cancel, err := s.storage.MeshStorage().Subscribe(context.Background(), storage.ACLSchedulesPrefix, s.onACLScheduleUpdate)
+	if err != nil {
+		for _, cancel := range aclSubCancels {
+			cancel()
+		}
+		return handleErr(fmt.Errorf("subscribe to %s: %w", storage.ACLSchedulesPrefix, err))
+	}
+	aclSubCancels = append(aclSubCancels, cancel)
+	s.aclSubCancel = func() {
+		for _, cancel := range aclSubCancels {
+			cancel()
+		}
 	}
 	// Register an update hook to watch for network changes.
 	if s.storage.Consensus().IsMember() {
@@ -259,60 +340,70 @@ func (s *meshStore) Connect(ctx context.Context, opts ConnectOptions) (err error
 		var subctx context.Context
 		subctx, s.kvSubCancel = context.WithCancel(context.Background())
 		go func() {
-			for {
-				s.log.Debug("Dialing network leader for membership updates")
-				c, err := s.DialLeader(subctx)
-				if err != nil {
-					if subctx.Err() != nil {
-						return
-					}
-					s.log.Error("Failed to dial leader for membership updates, will retry", slog.String("error", err.Error()))
-					time.Sleep(time.Second)
-					continue
-				}
-				defer c.Close()
-				s.log.Debug("Subscribing to peer updates from the network leader")
-				stream, err := v1.NewMembershipClient(c).SubscribePeers(subctx, &v1.SubscribePeersRequest{
-					Id: s.ID().String(),
-				})
-				if err != nil {
-					if subctx.Err() != nil {
-						return
-					}
-					s.log.Error("Failed to subscribe to peers, will retry", slog.String("error", err.Error()))
-					time.Sleep(time.Second)
-					continue
-				}
-				defer func() {
-					_ = stream.CloseSend()
-				}()
-				for {
-					peers, err := stream.Recv()
-					if err != nil {
-						if subctx.Err() != nil {
-							return
-						}
-						s.log.Error("Failed to receive peer updates, will retry", slog.String("error", err.Error()))
-						time.Sleep(time.Second)
-						break
-					}
-					s.log.Debug("Received peer updates", slog.Any("peers", peers))
-					err = s.nw.Peers().Refresh(subctx, peers.Peers)
-					if err != nil {
-						if subctx.Err() != nil {
-							return
-						}
-						s.log.Error("Failed to refresh peers, will retry", slog.String("error", err.Error()))
-						time.Sleep(time.Second)
-						break
-					}
-				}
+			for s.subscribePeers(subctx) {
+				time.Sleep(time.Second)
 			}
 		}()
 	}
+	if opts.NATDetection.Enabled() {
+		s.natDetectClose, s.natDetectDone = s.runNATDetector(opts.NATDetection, reportedNAT)
+	}
+	s.rbReapClose, s.rbReapDone = s.runRoleBindingReaper()
+	s.aclSchedClose, s.aclSchedDone = s.runACLScheduler()
 	return nil
 }
 
+// subscribePeers subscribes to peer updates from the network leader until
+// the subscription fails. It returns true if the subscription should be
+// retried. The leader connection is released on return so connections to a
+// previous leader can be closed.
+func (s *meshStore) subscribePeers(ctx context.Context) bool {
+	s.log.Debug("Dialing network leader for membership updates")
+	c, err := s.DialLeader(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		s.log.Error("Failed to dial leader for membership updates, will retry", slog.String("error", err.Error()))
+		return true
+	}
+	defer c.Close()
+	s.log.Debug("Subscribing to peer updates from the network leader")
+	stream, err := v1.NewMembershipClient(c).SubscribePeers(ctx, &v1.SubscribePeersRequest{
+		Id: s.ID().String(),
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+		s.log.Error("Failed to subscribe to peers, will retry", slog.String("error", err.Error()))
+		return true
+	}
+	defer func() {
+		_ = stream.CloseSend()
+	}()
+	for {
+		peers, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			s.log.Error("Failed to receive peer updates, will retry", slog.String("error", err.Error()))
+			return true
+		}
+		s.log.Debug("Received peer updates", slog.Any("peers", peers))
+		s.saveKnownWireGuardPeers(peers.Peers)
+		err = s.nw.Peers().Refresh(ctx, peers.Peers)
+		if err != nil {
+			if ctx.Err() != nil {
+				return false
+			}
+			s.log.Error("Failed to refresh peers, will retry", slog.String("error", err.Error()))
+			return true
+		}
+	}
+}
+
 func (s *meshStore) recoverWireguard(ctx context.Context) error {
 	if s.testStore {
 		return nil
@@ -355,9 +446,5 @@ func (s *meshStore) recoverWireguard(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("configure wireguard: %w", err)
 	}
-	wgpeers, err := meshnet.WireGuardPeersFor(ctx, s.Storage().MeshDB(), s.ID())
-	if err != nil {
-		return fmt.Errorf("get wireguard peers: %w", err)
-	}
-	return s.nw.Peers().Refresh(ctx, wgpeers)
+	return s.nw.Peers().Sync(ctx)
 }
@@ -0,0 +1,52 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"fmt"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// reportGateway records whether the node acts as a gateway for its routes.
+// The leader records it directly, other nodes send it along with an update
+// request.
+func (s *meshStore) reportGateway(ctx context.Context, gateway bool) error {
+	if s.storage.Consensus().IsLeader() {
+		if gateway {
+			if err := storage.PreferGatewayEdges(ctx, s.storage.MeshDB().Peers(), s.ID()); err != nil {
+				return err
+			}
+		}
+		return storage.SetGateway(ctx, s.storage.MeshStorage(), s.ID(), gateway)
+	}
+	c, err := s.DialLeader(ctx)
+	if err != nil {
+		return fmt.Errorf("dial leader: %w", err)
+	}
+	defer c.Close()
+	_, err = v1.NewMembershipClient(c).Update(storage.WithGateway(ctx, gateway), &v1.UpdateRequest{
+		Id: s.ID().String(),
+	})
+	if err != nil {
+		return fmt.Errorf("update gateway status: %w", err)
+	}
+	return nil
+}
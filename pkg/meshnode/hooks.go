@@ -0,0 +1,168 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// DefaultShutdownHookTimeout is the default time allowed for a single
+// shutdown hook to complete.
+const DefaultShutdownHookTimeout = 10 * time.Second
+
+// ShutdownStage is a point in the node shutdown sequence at which
+// shutdown hooks are run. Stages run in the order they are declared.
+type ShutdownStage int
+
+const (
+	// PreStop hooks run before any part of the node is torn down.
+	PreStop ShutdownStage = iota
+	// PreLeave hooks run after plugins are closed and leadership is
+	// relinquished, but before the node leaves the cluster.
+	PreLeave
+	// PreStoreClose hooks run after the node has left the cluster, but
+	// before the storage provider is closed.
+	PreStoreClose
+	// PostStoreClose hooks run after the storage provider is closed, but
+	// before the WireGuard interface and network are torn down.
+	PostStoreClose
+	// PostStop hooks run after the node is completely shut down.
+	PostStop
+)
+
+// String returns the string representation of the stage.
+func (s ShutdownStage) String() string {
+	switch s {
+	case PreStop:
+		return "pre-stop"
+	case PreLeave:
+		return "pre-leave"
+	case PreStoreClose:
+		return "pre-store-close"
+	case PostStoreClose:
+		return "post-store-close"
+	case PostStop:
+		return "post-stop"
+	default:
+		return fmt.Sprintf("unknown(%d)", s)
+	}
+}
+
+// IsValid returns true if the stage is valid.
+func (s ShutdownStage) IsValid() bool {
+	return s >= PreStop && s <= PostStop
+}
+
+// ShutdownHook is a function run at a stage of the node shutdown sequence.
+// Hooks in the same stage run sequentially in the order they were added.
+// Hooks must not call Close on the node.
+type ShutdownHook struct {
+	// Name is a name for the hook used in logs and errors.
+	Name string
+	// Stage is the stage at which to run the hook.
+	Stage ShutdownStage
+	// Timeout is the time allowed for the hook to complete. If zero,
+	// the node's default hook timeout is used. Hooks that exceed their
+	// timeout are abandoned and shutdown continues.
+	Timeout time.Duration
+	// Run is the function to run. The context is cancelled when the
+	// timeout expires.
+	Run func(ctx context.Context) error
+}
+
+// Validate validates the hook.
+func (h ShutdownHook) Validate() error {
+	if h.Name == "" {
+		return fmt.Errorf("shutdown hook name is required")
+	}
+	if !h.Stage.IsValid() {
+		return fmt.Errorf("shutdown hook %s has invalid stage %s", h.Name, h.Stage)
+	}
+	if h.Run == nil {
+		return fmt.Errorf("shutdown hook %s has no function", h.Name)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("shutdown hook %s has negative timeout", h.Name)
+	}
+	return nil
+}
+
+// shutdownHooks is an ordered registry of shutdown hooks.
+type shutdownHooks struct {
+	hooks map[ShutdownStage][]ShutdownHook
+	mu    sync.Mutex
+}
+
+func (h *shutdownHooks) add(hook ShutdownHook) error {
+	if err := hook.Validate(); err != nil {
+		return err
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.hooks == nil {
+		h.hooks = make(map[ShutdownStage][]ShutdownHook)
+	}
+	h.hooks[hook.Stage] = append(h.hooks[hook.Stage], hook)
+	return nil
+}
+
+// run runs the hooks for the given stage with the given default timeout. Errors
+// and timeouts are logged and returned together, but do not stop later hooks.
+func (h *shutdownHooks) run(ctx context.Context, stage ShutdownStage, defaultTimeout time.Duration) error {
+	h.mu.Lock()
+	hooks := append([]ShutdownHook(nil), h.hooks[stage]...)
+	h.mu.Unlock()
+	if len(hooks) == 0 {
+		return nil
+	}
+	if defaultTimeout <= 0 {
+		defaultTimeout = DefaultShutdownHookTimeout
+	}
+	log := context.LoggerFrom(ctx).With(slog.String("stage", stage.String()))
+	var errs []error
+	for _, hook := range hooks {
+		timeout := hook.Timeout
+		if timeout <= 0 {
+			timeout = defaultTimeout
+		}
+		log.Debug("Running shutdown hook", slog.String("hook", hook.Name))
+		if err := runHook(ctx, hook, timeout); err != nil {
+			log.Error("Shutdown hook failed", slog.String("hook", hook.Name), slog.String("error", err.Error()))
+			errs = append(errs, fmt.Errorf("%s hook %s: %w", stage, hook.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func runHook(ctx context.Context, hook ShutdownHook, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- hook.Run(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShutdownHooks(t *testing.T) {
+	t.Parallel()
+	var hooks shutdownHooks
+	var order []string
+	record := func(name string) func(context.Context) error {
+		return func(context.Context) error {
+			order = append(order, name)
+			return nil
+		}
+	}
+	for _, hook := range []ShutdownHook{
+		{Name: "first", Stage: PreStop, Run: record("first")},
+		{Name: "store", Stage: PostStoreClose, Run: record("store")},
+		{Name: "second", Stage: PreStop, Run: record("second")},
+		{Name: "failing", Stage: PreStop, Run: func(context.Context) error { return errors.New("boom") }},
+		{Name: "slow", Stage: PreStop, Timeout: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+			<-ctx.Done()
+			time.Sleep(time.Second)
+			return nil
+		}},
+		{Name: "third", Stage: PreStop, Run: record("third")},
+	} {
+		if err := hooks.add(hook); err != nil {
+			t.Fatalf("add hook %s: %v", hook.Name, err)
+		}
+	}
+	if err := hooks.add(ShutdownHook{Name: "invalid", Stage: PostStop + 1, Run: record("invalid")}); err == nil {
+		t.Fatal("expected error adding hook with invalid stage")
+	}
+	if err := hooks.add(ShutdownHook{Name: "nil", Stage: PreStop}); err == nil {
+		t.Fatal("expected error adding hook without a function")
+	}
+
+	start := time.Now()
+	err := hooks.run(context.Background(), PreStop, time.Second)
+	if err == nil {
+		t.Fatal("expected errors from failing and slow hooks")
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded from slow hook, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("expected slow hook to be abandoned at its timeout, took %s", elapsed)
+	}
+	if got := len(order); got != 3 || order[0] != "first" || order[1] != "second" || order[2] != "third" {
+		t.Fatalf("expected pre-stop hooks to run in order, got %v", order)
+	}
+	if err := hooks.run(context.Background(), PostStoreClose, 0); err != nil {
+		t.Fatalf("run post-store-close hooks: %v", err)
+	}
+	if order[len(order)-1] != "store" {
+		t.Fatalf("expected post-store-close hook to run, got %v", order)
+	}
+}
@@ -24,10 +24,13 @@ import (
 	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
 	"github.com/webmeshproj/webmesh/pkg/services"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 )
 
 func (s *meshStore) join(ctx context.Context, opts ConnectOptions) error {
@@ -40,13 +43,41 @@ func (s *meshStore) join(ctx context.Context, opts ConnectOptions) error {
 	if err != nil {
 		return fmt.Errorf("encode public key: %w", err)
 	}
+	// Report the cluster we belong to, along with any lineage we have locally,
+	// so the mesh can refuse us if we are pointed at the wrong one or diverged
+	// from it while partitioned.
+	var term uint64
+	clusterID, err := storage.GetClusterID(ctx, s.storage.MeshStorage())
+	if err == nil {
+		if s.opts.ClusterID != "" && clusterID != s.opts.ClusterID {
+			return fmt.Errorf("local state belongs to cluster %s, not the configured cluster %s", clusterID, s.opts.ClusterID)
+		}
+		if tp, ok := s.storage.(storage.TermProvider); ok {
+			term = tp.LastLogTerm()
+		}
+	} else {
+		clusterID = s.opts.ClusterID
+	}
+	if clusterID != "" {
+		log.Debug("Reporting cluster lineage", slog.String("cluster-id", clusterID), slog.Uint64("term", term))
+		ctx = storage.WithClusterLineage(ctx, clusterID, term)
+	}
+	if opts.RequestLearner {
+		ctx = storage.WithLearnerRequest(ctx)
+	}
+	if opts.RequestVote || opts.RequestObserver || opts.RequestLearner {
+		if lf, ok := s.storage.(storage.RaftLogFormatProvider); ok {
+			ctx = storage.WithRaftLogFormat(ctx, lf.RaftLogFormat())
+		}
+	}
 	for tries <= opts.MaxJoinRetries {
 		if tries > 0 {
 			log.Info("Retrying join request", slog.Int("tries", tries))
 		}
 		req := s.newJoinRequest(opts, encoded)
 		log.Debug("Sending join request to node", slog.Any("req", req))
-		resp, err := opts.JoinRoundTripper.RoundTrip(ctx, req)
+		var header metadata.MD
+		resp, err := opts.JoinRoundTripper.RoundTrip(transport.WithResponseHeader(ctx, &header), req)
 		if err != nil {
 			if ctx.Err() != nil {
 				return ctx.Err()
@@ -60,6 +91,12 @@ func (s *meshStore) join(ctx context.Context, opts ConnectOptions) error {
 			time.Sleep(time.Second)
 			continue
 		}
+		if id, ok := storage.AssignedNodeID(header); ok && id != s.ID() {
+			// The mesh registered us under a different ID to resolve a collision.
+			log.Warn("Node ID collided with another node, using the ID assigned by the mesh", slog.String("assigned-id", id.String()))
+			s.nodeID = id.String()
+			s.nw = meshnet.New(s.Storage().MeshDB(), opts.NetworkOptions, s.ID())
+		}
 		err = s.handleJoinResponse(ctx, opts, resp)
 		if err != nil {
 			return fmt.Errorf("handle join response: %w", err)
@@ -115,6 +152,7 @@ func (s *meshStore) handleJoinResponse(ctx context.Context, opts ConnectOptions,
 	if err != nil {
 		return fmt.Errorf("starting network manager: %w", err)
 	}
+	s.saveKnownWireGuardPeers(resp.GetPeers())
 	for _, peer := range resp.GetPeers() {
 		log.Debug("Adding peer", slog.Any("peer", peer))
 		err = s.nw.Peers().Add(ctx, peer, resp.GetIceServers())
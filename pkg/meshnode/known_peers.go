@@ -0,0 +1,108 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// LoadKnownPeers reads the public RPC addresses of previously known mesh peers
+// from the given file. A missing file is not an error and returns no addresses.
+func LoadKnownPeers(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read known peers: %w", err)
+	}
+	var addrs []string
+	if err := json.Unmarshal(data, &addrs); err != nil {
+		return nil, fmt.Errorf("decode known peers: %w", err)
+	}
+	return addrs, nil
+}
+
+// SaveKnownPeers atomically writes the given addresses to the known peers file.
+func SaveKnownPeers(path string, addrs []string) error {
+	data, err := json.Marshal(addrs)
+	if err != nil {
+		return fmt.Errorf("encode known peers: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return fmt.Errorf("create known peers directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("write known peers: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// KnownPeerAddrs returns the sorted public RPC addresses of the given nodes,
+// excluding the node with the given ID and any without a public address.
+func KnownPeerAddrs(self types.NodeID, nodes []types.MeshNode) []string {
+	addrs := make([]string, 0, len(nodes))
+	for _, node := range nodes {
+		if node.NodeID() == self {
+			continue
+		}
+		addr := node.PublicRPCAddr()
+		if !addr.IsValid() {
+			continue
+		}
+		addrs = append(addrs, addr.String())
+	}
+	slices.Sort(addrs)
+	return slices.Compact(addrs)
+}
+
+func (s *meshStore) saveKnownPeers(nodes []types.MeshNode) {
+	if s.opts.KnownPeersFile == "" {
+		return
+	}
+	addrs := KnownPeerAddrs(s.ID(), nodes)
+	if len(addrs) == 0 {
+		// Keep whatever we had last rather than forgetting the mesh.
+		return
+	}
+	if err := SaveKnownPeers(s.opts.KnownPeersFile, addrs); err != nil {
+		s.log.Warn("Failed to persist known peers", slog.String("error", err.Error()))
+	}
+}
+
+func (s *meshStore) saveKnownWireGuardPeers(peers []*v1.WireGuardPeer) {
+	if s.opts.KnownPeersFile == "" {
+		return
+	}
+	nodes := make([]types.MeshNode, 0, len(peers))
+	for _, peer := range peers {
+		if peer.GetNode() != nil {
+			nodes = append(nodes, types.MeshNode{MeshNode: peer.GetNode()})
+		}
+	}
+	s.saveKnownPeers(nodes)
+}
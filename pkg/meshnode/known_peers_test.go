@@ -0,0 +1,66 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestKnownPeers(t *testing.T) {
+	t.Parallel()
+	path := filepath.Join(t.TempDir(), "state", "known-peers.json")
+	addrs, err := LoadKnownPeers(path)
+	if err != nil {
+		t.Fatalf("load missing file: %v", err)
+	}
+	if len(addrs) != 0 {
+		t.Fatalf("expected no addresses from a missing file, got %v", addrs)
+	}
+	node := func(id, endpoint string) types.MeshNode {
+		return types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:              id,
+			PrimaryEndpoint: endpoint,
+			Features:        []*v1.FeaturePort{{Feature: v1.Feature_NODES, Port: 8443}},
+		}}
+	}
+	got := KnownPeerAddrs("self", []types.MeshNode{
+		node("self", "10.0.0.1"),
+		node("b", "10.0.0.3"),
+		node("a", "10.0.0.2"),
+		node("private", ""),
+	})
+	want := []string{"10.0.0.2:8443", "10.0.0.3:8443"}
+	if !slices.Equal(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	if err := SaveKnownPeers(path, got); err != nil {
+		t.Fatalf("save known peers: %v", err)
+	}
+	addrs, err = LoadKnownPeers(path)
+	if err != nil {
+		t.Fatalf("load known peers: %v", err)
+	}
+	if !slices.Equal(addrs, want) {
+		t.Fatalf("expected %v, got %v", want, addrs)
+	}
+}
@@ -0,0 +1,131 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/endpoints"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// DefaultNATDetectionInterval is the default interval at which the NAT type
+// is detected again after startup.
+const DefaultNATDetectionInterval = 10 * time.Minute
+
+// NATDetectionOptions are options for detecting the type of NAT the node is
+// behind and reporting it to the mesh.
+type NATDetectionOptions struct {
+	// STUNServers are the STUN servers to probe. At least two servers on
+	// different addresses are required. Detection is disabled when empty.
+	STUNServers []string
+	// Interval is how often the NAT type is detected again after startup.
+	// Defaults to DefaultNATDetectionInterval.
+	Interval time.Duration
+}
+
+// Enabled returns true if NAT detection is enabled.
+func (o NATDetectionOptions) Enabled() bool {
+	return len(o.STUNServers) > 0
+}
+
+// detectNAT detects the NAT type of the node. Failures are logged and reported
+// as an unknown NAT type.
+func (s *meshStore) detectNAT(ctx context.Context, opts NATDetectionOptions) endpoints.NATType {
+	natType, err := endpoints.DetectNAT(ctx, opts.STUNServers)
+	if err != nil {
+		s.log.Warn("Failed to detect NAT type", slog.String("error", err.Error()))
+		return endpoints.NATUnknown
+	}
+	s.log.Debug("Detected NAT type", slog.String("nat-type", natType.String()))
+	return natType
+}
+
+// reportNATType records the NAT type of the node in the mesh. The leader
+// records it directly, other nodes send it along with an update request.
+func (s *meshStore) reportNATType(ctx context.Context, natType endpoints.NATType) error {
+	if s.storage.Consensus().IsLeader() {
+		return storage.SetNATType(ctx, s.storage.MeshStorage(), s.ID(), string(natType))
+	}
+	c, err := s.DialLeader(ctx)
+	if err != nil {
+		return fmt.Errorf("dial leader: %w", err)
+	}
+	defer c.Close()
+	_, err = v1.NewMembershipClient(c).Update(storage.WithNATType(ctx, string(natType)), &v1.UpdateRequest{
+		Id: s.ID().String(),
+	})
+	if err != nil {
+		return fmt.Errorf("update nat type: %w", err)
+	}
+	return nil
+}
+
+// runNATDetector detects the NAT type of the node on an interval and reports it
+// whenever it changes. Reported is the NAT type already known to the mesh. If it
+// is nil, the NAT type is detected and reported right away.
+func (s *meshStore) runNATDetector(opts NATDetectionOptions, reported *endpoints.NATType) (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultNATDetectionInterval
+	}
+	go func() {
+		defer close(doneCh)
+		ctx, cancel := context.WithCancel(context.WithLogger(context.Background(), s.log))
+		defer cancel()
+		go func() {
+			select {
+			case <-closeCh:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		detect := func() {
+			natType := s.detectNAT(ctx, opts)
+			if ctx.Err() != nil || (reported != nil && *reported == natType) {
+				return
+			}
+			if err := s.reportNATType(ctx, natType); err != nil {
+				s.log.Warn("Failed to report NAT type", slog.String("error", err.Error()))
+				return
+			}
+			s.log.Info("Reported NAT type", slog.String("nat-type", natType.String()))
+			reported = &natType
+		}
+		if reported == nil {
+			detect()
+		}
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				detect()
+			}
+		}
+	}()
+	return
+}
@@ -37,6 +37,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/meshnet/wireguard"
 	"github.com/webmeshproj/webmesh/pkg/plugins"
 	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -65,6 +66,9 @@ type Node interface {
 	Started() bool
 	// Domain returns the domain of the mesh network.
 	Domain() string
+	// ClusterID returns the ID of the cluster the node belongs to, or an
+	// empty string if it is not known yet.
+	ClusterID() string
 	// Key returns the private key used for WireGuard and libp2p connections.
 	Key() crypto.PrivateKey
 	// Connect opens the connection to the mesh. This must be called before
@@ -75,6 +79,9 @@ type Node interface {
 	Ready() <-chan struct{}
 	// Close closes the connection to the mesh and shuts down the storage.
 	Close(ctx context.Context) error
+	// AddShutdownHook registers a hook to run at a stage of the shutdown
+	// sequence when Close is called.
+	AddShutdownHook(hook ShutdownHook) error
 	// Credentials returns the gRPC credentials to use for dialing the mesh.
 	Credentials() []grpc.DialOption
 	// LeaderID returns the current Raft leader ID.
@@ -123,6 +130,20 @@ type Config struct {
 	DisableDefaultIPAM bool
 	// DefaultIPAMStaticIPv4 is a map of node names to IPv4 addresses.
 	DefaultIPAMStaticIPv4 map[string]string
+	// ShutdownHooks are hooks to run when the node is closed. More can be
+	// added with AddShutdownHook.
+	ShutdownHooks []ShutdownHook
+	// ShutdownHookTimeout is the default time allowed for each shutdown hook
+	// to complete. Defaults to DefaultShutdownHookTimeout.
+	ShutdownHookTimeout time.Duration
+	// KnownPeersFile is a file to persist the public RPC addresses of mesh
+	// peers to. When set, the list is kept up to date as peers change so it
+	// can be used to rejoin the mesh on restart.
+	KnownPeersFile string
+	// ClusterID pins the ID of the cluster this node belongs to. It is used
+	// as the cluster ID when bootstrapping, and the node refuses to join a
+	// mesh with a different one.
+	ClusterID string
 }
 
 // New creates a new Mesh. You must call Open() on the returned mesh
@@ -135,10 +156,11 @@ func New(opts Config) Node {
 // Open() on the returned mesh before it can be used.
 func NewWithLogger(log *slog.Logger, opts Config) Node {
 	log = log.With(slog.String("component", "mesh"))
-	var peerUpdateGroup, routeUpdateGroup, dnsUpdateGroup errgroup.Group
+	var peerUpdateGroup, routeUpdateGroup, dnsUpdateGroup, aclUpdateGroup errgroup.Group
 	peerUpdateGroup.SetLimit(1)
 	routeUpdateGroup.SetLimit(1)
 	dnsUpdateGroup.SetLimit(1)
+	aclUpdateGroup.SetLimit(1)
 	st := &meshStore{
 		opts:             opts,
 		nodeID:           opts.NodeID,
@@ -146,9 +168,18 @@ func NewWithLogger(log *slog.Logger, opts Config) Node {
 		peerUpdateGroup:  &peerUpdateGroup,
 		routeUpdateGroup: &routeUpdateGroup,
 		dnsUpdateGroup:   &dnsUpdateGroup,
+		aclUpdateGroup:   &aclUpdateGroup,
 		log:              log.With(slog.String("node-id", string(opts.NodeID))),
 		kvSubCancel:      func() {},
+		aclSubCancel:     func() {},
+		aclSchedWake:     make(chan struct{}, 1),
 		closec:           make(chan struct{}),
+		leaderConns:      transport.NewConnCache(),
+	}
+	for _, hook := range opts.ShutdownHooks {
+		if err := st.hooks.add(hook); err != nil {
+			st.log.Warn("Ignoring invalid shutdown hook", slog.String("error", err.Error()))
+		}
 	}
 	return st
 }
@@ -162,11 +193,23 @@ type meshStore struct {
 	storage          storage.Provider
 	plugins          plugins.Manager
 	kvSubCancel      context.CancelFunc
+	aclSubCancel     context.CancelFunc
 	nw               meshnet.Manager
 	peerUpdateGroup  *errgroup.Group
 	routeUpdateGroup *errgroup.Group
 	dnsUpdateGroup   *errgroup.Group
+	aclUpdateGroup   *errgroup.Group
+	deniedPrefixes   []netip.Prefix
 	leaveRTT         transport.LeaveRoundTripper
+	leaderConns      *transport.ConnCache
+	hooks            shutdownHooks
+	natDetectClose   chan struct{}
+	natDetectDone    chan struct{}
+	rbReapClose      chan struct{}
+	rbReapDone       chan struct{}
+	aclSchedClose    chan struct{}
+	aclSchedDone     chan struct{}
+	aclSchedWake     chan struct{}
 	closec           chan struct{}
 	log              *slog.Logger
 	mu               sync.Mutex
@@ -184,6 +227,18 @@ func (s *meshStore) Started() bool {
 	return s.open.Load()
 }
 
+// ClusterID returns the ID of the cluster the node belongs to. The ID found
+// in local storage takes precedence over the configured one.
+func (s *meshStore) ClusterID() string {
+	if st := s.Storage(); st != nil {
+		id, err := storage.GetClusterID(context.Background(), st.MeshStorage())
+		if err == nil {
+			return id
+		}
+	}
+	return s.opts.ClusterID
+}
+
 // Key returns the private key used for WireGuard and libp2p connections.
 func (s *meshStore) Key() crypto.PrivateKey {
 	return s.key
@@ -204,6 +259,12 @@ func (s *meshStore) Network() meshnet.Manager {
 	return s.nw
 }
 
+// AddShutdownHook registers a hook to run at a stage of the shutdown
+// sequence when Close is called.
+func (s *meshStore) AddShutdownHook(hook ShutdownHook) error {
+	return s.hooks.add(hook)
+}
+
 // Plugins returns the plugin manager. Note that the returned value
 // may be nil if the store is not open.
 func (s *meshStore) Plugins() plugins.Manager {
@@ -265,76 +326,99 @@ func (s *meshStore) Dial(ctx context.Context, network, address string) (net.Conn
 	return s.nw.Dial(ctx, network, address)
 }
 
-// DialLeader opens a new gRPC connection to the current Raft leader.
+// DialLeader returns a gRPC connection to the current Raft leader. Connections
+// are shared and cached by leader address, so closing the returned connection
+// only releases it. Connections to a previous leader are closed once every
+// caller using them has closed its connection.
 func (s *meshStore) DialLeader(ctx context.Context) (transport.RPCClientConn, error) {
 	leader, err := s.LeaderID()
 	if err != nil {
 		return nil, err
 	}
-	return s.DialNode(ctx, leader)
+	addr, err := s.rpcAddrFor(ctx, leader)
+	if err != nil {
+		return nil, err
+	}
+	return s.leaderConns.Get(ctx, addr, s.newGRPCConn)
 }
 
 // Dial opens a new gRPC connection to the given node.
 func (s *meshStore) DialNode(ctx context.Context, nodeID types.NodeID) (transport.RPCClientConn, error) {
+	addr, err := s.rpcAddrFor(ctx, nodeID)
+	if err != nil {
+		return nil, err
+	}
+	return s.newGRPCConn(ctx, addr)
+}
+
+// rpcAddrFor returns the private gRPC address for the given node.
+func (s *meshStore) rpcAddrFor(ctx context.Context, nodeID types.NodeID) (string, error) {
 	if s.storage == nil || !s.open.Load() {
-		return nil, ErrNotOpen
+		return "", ErrNotOpen
 	}
 	if !s.storage.Consensus().IsMember() {
 		// We are not a raft node and don't have a local copy of the DB.
-		// A call to storage would cause a recursive call to this method.
-		return s.dialWithWireguardPeers(ctx, nodeID)
+		// A call to storage would cause a recursive call to DialNode.
+		return s.rpcAddrFromWireGuardPeers(nodeID)
 	}
-	return s.dialWithLocalStorage(ctx, nodeID)
+	return s.rpcAddrFromLocalStorage(ctx, nodeID)
 }
 
 func (s *meshStore) Credentials() []grpc.DialOption {
 	return s.opts.Credentials
 }
 
-func (s *meshStore) dialWithLocalStorage(ctx context.Context, nodeID types.NodeID) (*grpc.ClientConn, error) {
+func (s *meshStore) rpcAddrFromLocalStorage(ctx context.Context, nodeID types.NodeID) (string, error) {
 	var node types.MeshNode
 	var err error
 	if nodeID == "" {
 		// This is a request for any storage providing node.
 		nodes, err := s.Storage().MeshDB().Peers().List(ctx, storage.FilterByFeature(v1.Feature_STORAGE_PROVIDER))
 		if err != nil {
-			return nil, fmt.Errorf("list storage providers: %w", err)
+			return "", fmt.Errorf("list storage providers: %w", err)
 		}
 		if len(nodes) == 0 {
-			return nil, fmt.Errorf("no storage providers found")
+			return "", fmt.Errorf("no storage providers found")
 		}
 		node = nodes[0]
 	} else {
 		node, err = s.Storage().MeshDB().Peers().Get(ctx, nodeID)
+		if errors.IsNodeNotFound(err) {
+			// The node may have been renamed recently.
+			id, aliasErr := storage.ResolveNodeAlias(ctx, s.Storage().MeshStorage(), nodeID)
+			if aliasErr == nil && id != nodeID {
+				node, err = s.Storage().MeshDB().Peers().Get(ctx, id)
+			}
+		}
 		if err != nil {
-			return nil, fmt.Errorf("get node private rpc address: %w", err)
+			return "", fmt.Errorf("get node private rpc address: %w", err)
 		}
 	}
 	if s.opts.DisableIPv4 {
 		addr := node.PrivateRPCAddrV6()
 		if !addr.IsValid() {
-			return nil, fmt.Errorf("node %q has no private IPv6 address", nodeID)
+			return "", fmt.Errorf("node %q has no private IPv6 address", nodeID)
 		}
-		return s.newGRPCConn(ctx, addr.String())
+		return addr.String(), nil
 	}
 	if s.opts.DisableIPv6 {
 		addr := node.PrivateRPCAddrV4()
 		if !addr.IsValid() {
-			return nil, fmt.Errorf("node %q has no private IPv4 address", nodeID)
+			return "", fmt.Errorf("node %q has no private IPv4 address", nodeID)
 		}
-		return s.newGRPCConn(ctx, addr.String())
+		return addr.String(), nil
 	}
 	// Fallback to whichever is valid if both are present (preferring IPv6)
 	if node.PrivateRPCAddrV6().IsValid() {
-		return s.newGRPCConn(ctx, node.PrivateRPCAddrV6().String())
+		return node.PrivateRPCAddrV6().String(), nil
 	}
-	return s.newGRPCConn(ctx, node.PrivateRPCAddrV4().String())
+	return node.PrivateRPCAddrV4().String(), nil
 }
 
-func (s *meshStore) dialWithWireguardPeers(ctx context.Context, nodeID types.NodeID) (*grpc.ClientConn, error) {
+func (s *meshStore) rpcAddrFromWireGuardPeers(nodeID types.NodeID) (string, error) {
 	peers := s.Network().WireGuard().Peers()
 	if len(peers) == 0 {
-		return nil, fmt.Errorf("no wireguard peers")
+		return "", fmt.Errorf("no wireguard peers")
 	}
 	var toDial *wireguard.Peer
 	for id, peer := range peers {
@@ -351,23 +435,23 @@ func (s *meshStore) dialWithWireguardPeers(ctx context.Context, nodeID types.Nod
 		}
 	}
 	if toDial == nil {
-		return nil, fmt.Errorf("no wireguard peer found for node %q", nodeID)
+		return "", fmt.Errorf("no wireguard peer found for node %q", nodeID)
 	}
 	if s.opts.DisableIPv4 && toDial.PrivateIPv6.IsValid() {
 		addr := netip.AddrPortFrom(toDial.PrivateIPv6.Addr(), uint16(toDial.GRPCPort))
-		return s.newGRPCConn(ctx, addr.String())
+		return addr.String(), nil
 	}
 	if s.opts.DisableIPv6 && toDial.PrivateIPv4.IsValid() {
 		addr := netip.AddrPortFrom(toDial.PrivateIPv4.Addr(), uint16(toDial.GRPCPort))
-		return s.newGRPCConn(ctx, addr.String())
+		return addr.String(), nil
 	}
 	// Fallback to whichever is valid if both are present (preferring IPv6)
 	if toDial.PrivateIPv6.IsValid() {
 		addr := netip.AddrPortFrom(toDial.PrivateIPv6.Addr(), uint16(toDial.GRPCPort))
-		return s.newGRPCConn(ctx, addr.String())
+		return addr.String(), nil
 	}
 	addr := netip.AddrPortFrom(toDial.PrivateIPv4.Addr(), uint16(toDial.GRPCPort))
-	return s.newGRPCConn(ctx, addr.String())
+	return addr.String(), nil
 }
 
 func (s *meshStore) newGRPCConn(ctx context.Context, addr string) (*grpc.ClientConn, error) {
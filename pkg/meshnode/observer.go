@@ -24,8 +24,7 @@ import (
 	"github.com/hashicorp/raft"
 	v1 "github.com/webmeshproj/api/go/v1"
 
-	"github.com/webmeshproj/webmesh/pkg/meshnet"
-	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -34,7 +33,7 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 	return func(ctx context.Context, ev raft.Observation) {
 		log := s.log.With("event", "observation")
 		log.Debug("Received observation event", slog.String("type", reflect.TypeOf(ev.Data).String()))
-		provider := s.Storage().(*raftstorage.Provider)
+		provider := s.Storage()
 		consensus := provider.Consensus()
 		switch data := ev.Data.(type) {
 		case raft.FailedHeartbeatObservation:
@@ -44,6 +43,10 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 			failedHeartBeats[data.PeerID]++
 			log.Debug("Failed heartbeat", slog.String("peer", string(data.PeerID)), slog.Int("count", failedHeartBeats[data.PeerID]))
 			if failedHeartBeats[data.PeerID] >= s.opts.HeartbeatPurgeThreshold && consensus.IsLeader() {
+				if s.inMaintenance(ctx, provider, types.NodeID(data.PeerID)) {
+					log.Info("Failed heartbeat threshold reached, but peer is in a maintenance window", slog.String("peer", string(data.PeerID)))
+					return
+				}
 				// Remove the peer from the cluster
 				log.Info("Failed heartbeat threshold reached, removing peer", slog.String("peer", string(data.PeerID)))
 				if err := consensus.RemovePeer(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{Id: string(data.PeerID)}}, true); err != nil {
@@ -53,6 +56,15 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 				if err := provider.MeshDB().Peers().Delete(ctx, types.NodeID(data.PeerID)); err != nil {
 					log.Warn("Failed to remove peer from database", slog.String("error", err.Error()))
 				}
+				err := storage.RecordMembershipEvent(ctx, provider.MeshStorage(), storage.MembershipEvent{
+					Type:   storage.MembershipEventEvict,
+					NodeID: types.NodeID(data.PeerID),
+					Reason: "failed heartbeat threshold reached",
+					Actor:  s.ID(),
+				})
+				if err != nil {
+					log.Warn("Failed to record membership event", slog.String("error", err.Error()))
+				}
 				delete(failedHeartBeats, data.PeerID)
 			}
 		case raft.ResumedHeartbeatObservation:
@@ -66,15 +78,10 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 			if string(data.Peer.ID) == s.nodeID {
 				return
 			}
-			wgpeers, err := meshnet.WireGuardPeersFor(ctx, provider.MeshDB(), s.ID())
-			if err != nil {
-				log.Warn("Failed to get wireguard peers", slog.String("error", err.Error()))
-			} else {
-				if err := s.nw.Peers().Refresh(ctx, wgpeers); err != nil {
-					log.Warn("Failed to refresh local wireguard peers", slog.String("error", err.Error()))
-				}
+			if err := s.nw.Peers().Sync(ctx); err != nil {
+				log.Warn("Failed to refresh local wireguard peers", slog.String("error", err.Error()))
 			}
-			if s.plugins.HasWatchers() {
+			if s.plugins.HasWatchers() && !s.inMaintenance(ctx, provider, types.NodeID(data.Peer.ID)) {
 				node, err := provider.MeshDB().Peers().Get(ctx, types.NodeID(data.Peer.ID))
 				if err != nil {
 					log.Warn("Failed to lookup peer, can't emit event", slog.String("error", err.Error()))
@@ -96,7 +103,9 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 				}
 			}
 		case raft.LeaderObservation:
-			if s.plugins.HasWatchers() {
+			// Drop any cached connections to the previous leader.
+			s.leaderConns.Purge()
+			if s.plugins.HasWatchers() && !s.inMaintenance(ctx, provider, types.NodeID(data.LeaderID)) {
 				node, err := provider.MeshDB().Peers().Get(ctx, types.NodeID(data.LeaderID))
 				if err != nil {
 					log.Warn("Failed to get leader, may be fresh cluster, can't emit event", slog.String("error", err.Error()))
@@ -115,3 +124,14 @@ func (s *meshStore) newObserver() func(context.Context, raft.Observation) {
 		}
 	}
 }
+
+// inMaintenance returns true if automatic actions against the given node should
+// be suppressed because of a maintenance window.
+func (s *meshStore) inMaintenance(ctx context.Context, provider storage.Provider, nodeID types.NodeID) bool {
+	ok, err := storage.InMaintenance(ctx, provider.MeshStorage(), nodeID)
+	if err != nil {
+		s.log.Warn("Failed to check maintenance windows", slog.String("error", err.Error()))
+		return false
+	}
+	return ok
+}
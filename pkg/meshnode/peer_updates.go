@@ -21,7 +21,6 @@ import (
 	"log/slog"
 	"time"
 
-	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -30,6 +29,7 @@ func (s *meshStore) onPeerUpdate(peers []types.MeshNode) {
 	if s.testStore {
 		return
 	}
+	s.saveKnownPeers(peers)
 	go s.queuePeersUpdate()
 	go s.queueRouteUpdate()
 	if s.opts.UseMeshDNS && !s.opts.LocalDNSOnly {
@@ -69,12 +69,7 @@ func (s *meshStore) queuePeersUpdate() {
 	s.peerUpdateGroup.TryGo(func() error {
 		defer cancel()
 		s.log.Debug("applied batch with node edge changes, refreshing wireguard peers")
-		wgpeers, err := meshnet.WireGuardPeersFor(ctx, s.Storage().MeshDB(), s.ID())
-		if err != nil {
-			s.log.Error("error getting wireguard peers", slog.String("error", err.Error()))
-			return nil
-		}
-		if err := s.nw.Peers().Refresh(ctx, wgpeers); err != nil {
+		if err := s.nw.Peers().Sync(ctx); err != nil {
 			s.log.Error("refresh wireguard peers failed", slog.String("error", err.Error()))
 		}
 		return nil
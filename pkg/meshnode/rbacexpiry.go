@@ -0,0 +1,60 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshnode
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// roleBindingReapInterval is how often the leader removes expired rolebindings.
+const roleBindingReapInterval = time.Minute
+
+// runRoleBindingReaper removes expired rolebindings on an interval while the
+// node is the storage leader.
+func (s *meshStore) runRoleBindingReaper() (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(roleBindingReapInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closeCh:
+				return
+			case <-ticker.C:
+				if !s.storage.Consensus().IsLeader() {
+					continue
+				}
+				ctx, cancel := context.WithTimeout(context.WithLogger(context.Background(), s.log), roleBindingReapInterval)
+				removed, err := storage.RemoveExpiredRoleBindings(ctx, s.storage.MeshDB(), s.storage.MeshStorage(), time.Now())
+				cancel()
+				for _, name := range removed {
+					s.log.Info("Removed expired rolebinding", slog.String("rolebinding", name))
+				}
+				if err != nil {
+					s.log.Warn("Failed to remove expired rolebindings", slog.String("error", err.Error()))
+				}
+			}
+		}
+	}()
+	return
+}
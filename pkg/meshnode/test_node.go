@@ -18,6 +18,7 @@ package meshnode
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -30,6 +31,7 @@ import (
 	"github.com/google/uuid"
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 
 	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
@@ -97,6 +99,7 @@ type TestNode struct {
 	discovery  libp2p.Announcer
 	nodeID     types.NodeID
 	meshDomain string
+	hooks      shutdownHooks
 	log        *slog.Logger
 	mu         sync.Mutex
 }
@@ -111,7 +114,7 @@ func NewTestNode(opts Config) Node {
 // NewTestNodeWithLogger creates a new test mesh node with a logger.
 // It is not started and proper methods will return errors.
 func NewTestNodeWithLogger(log *slog.Logger, opts Config) Node {
-	return &TestNode{
+	node := &TestNode{
 		cfg:          opts,
 		log:          log,
 		nodeID:       types.NodeID(opts.NodeID),
@@ -119,6 +122,12 @@ func NewTestNodeWithLogger(log *slog.Logger, opts Config) Node {
 		NodeDialer:   transport.NewNoOpNodeDialer(),
 		LeaderDialer: transport.NewNoOpLeaderDialer(),
 	}
+	for _, hook := range opts.ShutdownHooks {
+		if err := node.hooks.add(hook); err != nil {
+			log.Warn("Ignoring invalid shutdown hook", slog.String("error", err.Error()))
+		}
+	}
+	return node
 }
 
 // ID returns the node ID.
@@ -141,6 +150,17 @@ func (t *TestNode) Domain() string {
 	return t.meshDomain
 }
 
+// ClusterID returns the ID of the cluster the node belongs to.
+func (t *TestNode) ClusterID() string {
+	if t.storage != nil {
+		id, err := storage.GetClusterID(context.Background(), t.storage.MeshStorage())
+		if err == nil {
+			return id
+		}
+	}
+	return t.cfg.ClusterID
+}
+
 // Key returns the private key used for WireGuard and libp2p connections.
 func (t *TestNode) Key() crypto.PrivateKey {
 	return t.cfg.Key
@@ -189,7 +209,8 @@ func (t *TestNode) Connect(ctx context.Context, opts ConnectOptions) error {
 	for _, r := range opts.Routes {
 		routes = append(routes, r.String())
 	}
-	resp, err := opts.JoinRoundTripper.RoundTrip(ctx, &v1.JoinRequest{
+	var header metadata.MD
+	resp, err := opts.JoinRoundTripper.RoundTrip(transport.WithResponseHeader(ctx, &header), &v1.JoinRequest{
 		Id:                 t.nodeID.String(),
 		PublicKey:          encoded,
 		PrimaryEndpoint:    opts.PrimaryEndpoint.String(),
@@ -215,6 +236,9 @@ func (t *TestNode) Connect(ctx context.Context, opts ConnectOptions) error {
 	if err != nil {
 		return fmt.Errorf("mock node join request: %w", err)
 	}
+	if id, ok := storage.AssignedNodeID(header); ok {
+		t.nodeID = id
+	}
 	var addrv4, addrv6, netv4, netv6 netip.Prefix
 	if !t.cfg.DisableIPv4 {
 		if resp.GetAddressIPv4() != "" {
@@ -289,12 +313,25 @@ func (t *TestNode) Close(ctx context.Context) error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	defer t.started.Store(false)
+	var errs []error
+	for _, stage := range []ShutdownStage{PreStop, PreLeave, PreStoreClose} {
+		errs = append(errs, t.hooks.run(ctx, stage, t.cfg.ShutdownHookTimeout))
+	}
 	if t.storage != nil {
 		if err := t.storage.Close(); err != nil {
 			t.log.Error("Failed to close storage", slog.String("error", err.Error()))
 		}
 	}
-	return t.nw.Close(ctx)
+	errs = append(errs, t.hooks.run(ctx, PostStoreClose, t.cfg.ShutdownHookTimeout))
+	errs = append(errs, t.nw.Close(ctx))
+	errs = append(errs, t.hooks.run(ctx, PostStop, t.cfg.ShutdownHookTimeout))
+	return errors.Join(errs...)
+}
+
+// AddShutdownHook registers a hook to run at a stage of the shutdown
+// sequence when Close is called.
+func (t *TestNode) AddShutdownHook(hook ShutdownHook) error {
+	return t.hooks.add(hook)
 }
 
 // LeaderID returns the current Raft leader ID.
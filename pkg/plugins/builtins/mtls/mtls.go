@@ -38,7 +38,8 @@ type Plugin struct {
 	v1.UnimplementedPluginServer
 	v1.UnimplementedAuthPluginServer
 
-	config *tls.Config
+	config     *tls.Config
+	revocation *revocationChecker
 }
 
 // Config is the configuration for the mTLS plugin.
@@ -51,18 +52,43 @@ type Config struct {
 	// If not provided, the system pool and any intermediate chains provided
 	// in the authentication request will be used.
 	CAData string `koanf:"ca-data" mapstructure:"ca-data"`
+	// CRLFiles are paths to PEM or DER encoded certificate revocation lists
+	// to check client certificates against. Files are reloaded when they change.
+	CRLFiles []string `koanf:"crl-files" mapstructure:"crl-files"`
+	// OCSP enables checking client certificates against the OCSP responders
+	// listed in the certificate.
+	OCSP bool `koanf:"ocsp" mapstructure:"ocsp"`
+	// OCSPCacheTTL is the maximum duration to cache OCSP responses. Responses are
+	// never cached past their next update time. Defaults to 1h.
+	OCSPCacheTTL string `koanf:"ocsp-cache-ttl" mapstructure:"ocsp-cache-ttl"`
+	// OCSPTimeout is the timeout for OCSP requests. Defaults to 5s.
+	OCSPTimeout string `koanf:"ocsp-timeout" mapstructure:"ocsp-timeout"`
+	// RevocationMode is either soft-fail or hard-fail. In soft-fail mode, certificates
+	// are allowed when their revocation status cannot be determined. In hard-fail mode
+	// they are rejected. Defaults to soft-fail.
+	RevocationMode string `koanf:"revocation-mode" mapstructure:"revocation-mode"`
 }
 
 // BindFlags binds the plugin flags to the given flag set.
 func (c *Config) BindFlags(prefix string, fs *pflag.FlagSet) {
 	fs.StringVar(&c.CAFile, prefix+"ca-file", "", "Path to a CA file to use to verify client certificates.")
 	fs.StringVar(&c.CAData, prefix+"ca-data", "", "Base64 encoded PEM CA data to use to verify client certificates.")
+	fs.StringSliceVar(&c.CRLFiles, prefix+"crl-files", nil, "Paths to certificate revocation lists to check client certificates against.")
+	fs.BoolVar(&c.OCSP, prefix+"ocsp", false, "Check client certificates against their OCSP responders.")
+	fs.StringVar(&c.OCSPCacheTTL, prefix+"ocsp-cache-ttl", "", "Maximum duration to cache OCSP responses (default 1h).")
+	fs.StringVar(&c.OCSPTimeout, prefix+"ocsp-timeout", "", "Timeout for OCSP requests (default 5s).")
+	fs.StringVar(&c.RevocationMode, prefix+"revocation-mode", "", "Behavior when revocation status is unknown: soft-fail or hard-fail (default soft-fail).")
 }
 
 func (c *Config) AsMapStructure() map[string]any {
 	return map[string]any{
-		"ca-file": c.CAFile,
-		"ca-data": c.CAData,
+		"ca-file":         c.CAFile,
+		"ca-data":         c.CAData,
+		"crl-files":       c.CRLFiles,
+		"ocsp":            c.OCSP,
+		"ocsp-cache-ttl":  c.OCSPCacheTTL,
+		"ocsp-timeout":    c.OCSPTimeout,
+		"revocation-mode": c.RevocationMode,
 	}
 }
 
@@ -121,6 +147,10 @@ func (p *Plugin) Configure(ctx context.Context, req *v1.PluginConfiguration) (*e
 		}
 	}
 	p.config.ClientCAs = roots
+	p.revocation, err = newRevocationChecker(config)
+	if err != nil {
+		return nil, err
+	}
 	return &emptypb.Empty{}, nil
 }
 
@@ -150,11 +180,18 @@ func (p *Plugin) Authenticate(ctx context.Context, req *v1.AuthenticationRequest
 			opts.Intermediates.AddCert(intermediate)
 		}
 	}
-	_, err = cert.Verify(opts)
+	chains, err := cert.Verify(opts)
 	if err != nil {
 		context.LoggerFrom(ctx).Warn("mtls-auth failed to verify certificate", "error", err.Error())
 		return nil, fmt.Errorf("mtls-auth failed to verify certificate: %w", err)
 	}
+	if p.revocation != nil && len(chains) > 0 && len(chains[0]) > 1 {
+		err = p.revocation.Check(ctx, cert, chains[0][1])
+		if err != nil {
+			context.LoggerFrom(ctx).Warn("mtls-auth rejected certificate", "error", err.Error())
+			return nil, fmt.Errorf("mtls-auth rejected certificate: %w", err)
+		}
+	}
 	commonName := cert.Subject.CommonName
 	if commonName == "" {
 		return nil, fmt.Errorf("no common name in certificate")
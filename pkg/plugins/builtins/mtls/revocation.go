@@ -0,0 +1,318 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mtls
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// RevocationMode is the behavior when the revocation status of a certificate
+// cannot be determined.
+type RevocationMode string
+
+const (
+	// RevocationModeSoftFail allows certificates whose revocation status cannot
+	// be determined, e.g. when an OCSP responder is unreachable.
+	RevocationModeSoftFail RevocationMode = "soft-fail"
+	// RevocationModeHardFail rejects certificates whose revocation status cannot
+	// be determined.
+	RevocationModeHardFail RevocationMode = "hard-fail"
+)
+
+const (
+	// DefaultOCSPCacheTTL is the default maximum time an OCSP response is cached.
+	DefaultOCSPCacheTTL = time.Hour
+	// DefaultOCSPTimeout is the default timeout for OCSP requests.
+	DefaultOCSPTimeout = 5 * time.Second
+)
+
+// ErrCertificateRevoked is returned when a certificate has been revoked.
+var ErrCertificateRevoked = errors.New("certificate has been revoked")
+
+// errRevocationUnknown is returned when no revocation source gave a definitive answer.
+var errRevocationUnknown = errors.New("certificate revocation status is unknown")
+
+// revocationChecker checks certificates against CRLs and OCSP responders.
+type revocationChecker struct {
+	mode      RevocationMode
+	crlFiles  []string
+	ocsp      bool
+	cacheTTL  time.Duration
+	client    *http.Client
+	crls      []*crlEntry
+	ocspCache map[string]ocspCacheEntry
+	mu        sync.Mutex
+}
+
+type crlEntry struct {
+	path    string
+	modTime time.Time
+	list    *x509.RevocationList
+	revoked map[string]struct{}
+}
+
+type ocspCacheEntry struct {
+	status  int
+	expires time.Time
+}
+
+// newRevocationChecker creates a revocation checker for the given configuration.
+// A nil checker is returned if no revocation checks are configured.
+func newRevocationChecker(config Config) (*revocationChecker, error) {
+	if len(config.CRLFiles) == 0 && !config.OCSP {
+		return nil, nil
+	}
+	mode := RevocationMode(config.RevocationMode)
+	switch mode {
+	case "":
+		mode = RevocationModeSoftFail
+	case RevocationModeSoftFail, RevocationModeHardFail:
+	default:
+		return nil, fmt.Errorf("invalid revocation-mode %q", config.RevocationMode)
+	}
+	cacheTTL := DefaultOCSPCacheTTL
+	if config.OCSPCacheTTL != "" {
+		var err error
+		cacheTTL, err = time.ParseDuration(config.OCSPCacheTTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ocsp-cache-ttl: %w", err)
+		}
+	}
+	timeout := DefaultOCSPTimeout
+	if config.OCSPTimeout != "" {
+		var err error
+		timeout, err = time.ParseDuration(config.OCSPTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ocsp-timeout: %w", err)
+		}
+	}
+	rc := &revocationChecker{
+		mode:      mode,
+		crlFiles:  config.CRLFiles,
+		ocsp:      config.OCSP,
+		cacheTTL:  cacheTTL,
+		client:    &http.Client{Timeout: timeout},
+		ocspCache: make(map[string]ocspCacheEntry),
+	}
+	for _, path := range config.CRLFiles {
+		entry, err := loadCRL(path)
+		if err != nil {
+			return nil, err
+		}
+		rc.crls = append(rc.crls, entry)
+	}
+	return rc, nil
+}
+
+// Check checks the revocation status of the leaf certificate issued by the
+// given issuer. ErrCertificateRevoked is returned if the certificate is revoked.
+// Any other error is only returned in hard-fail mode.
+func (r *revocationChecker) Check(ctx context.Context, leaf, issuer *x509.Certificate) error {
+	log := context.LoggerFrom(ctx)
+	err := r.check(ctx, leaf, issuer)
+	if err == nil || errors.Is(err, ErrCertificateRevoked) {
+		return err
+	}
+	if r.mode == RevocationModeHardFail {
+		return err
+	}
+	log.Warn("mtls-auth could not determine certificate revocation status, allowing (soft-fail)",
+		slog.String("subject", leaf.Subject.String()),
+		slog.String("error", err.Error()),
+	)
+	return nil
+}
+
+func (r *revocationChecker) check(ctx context.Context, leaf, issuer *x509.Certificate) error {
+	var errs []error
+	checked := false
+	if len(r.crlFiles) > 0 {
+		covered, err := r.checkCRLs(leaf, issuer)
+		if err != nil {
+			if errors.Is(err, ErrCertificateRevoked) {
+				return err
+			}
+			errs = append(errs, err)
+		}
+		checked = checked || covered
+	}
+	if r.ocsp && len(leaf.OCSPServer) > 0 {
+		err := r.checkOCSP(ctx, leaf, issuer)
+		if err != nil {
+			if errors.Is(err, ErrCertificateRevoked) {
+				return err
+			}
+			errs = append(errs, err)
+		} else {
+			checked = true
+		}
+	}
+	if checked {
+		return nil
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	return errRevocationUnknown
+}
+
+// checkCRLs checks the leaf against any CRLs issued by the given issuer. It returns
+// true if a valid CRL covering the issuer was found.
+func (r *revocationChecker) checkCRLs(leaf, issuer *x509.Certificate) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var errs []error
+	covered := false
+	for i, entry := range r.crls {
+		// Reload the CRL if it changed on disk.
+		if stat, err := os.Stat(entry.path); err == nil && !stat.ModTime().Equal(entry.modTime) {
+			reloaded, err := loadCRL(entry.path)
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				r.crls[i] = reloaded
+				entry = reloaded
+			}
+		}
+		if !bytes.Equal(entry.list.RawIssuer, issuer.RawSubject) {
+			continue
+		}
+		if err := entry.list.CheckSignatureFrom(issuer); err != nil {
+			errs = append(errs, fmt.Errorf("invalid CRL signature in %s: %w", entry.path, err))
+			continue
+		}
+		if _, ok := entry.revoked[string(leaf.SerialNumber.Bytes())]; ok {
+			return true, ErrCertificateRevoked
+		}
+		if !entry.list.NextUpdate.IsZero() && time.Now().After(entry.list.NextUpdate) {
+			errs = append(errs, fmt.Errorf("CRL %s is expired", entry.path))
+			continue
+		}
+		covered = true
+	}
+	return covered, errors.Join(errs...)
+}
+
+func (r *revocationChecker) checkOCSP(ctx context.Context, leaf, issuer *x509.Certificate) error {
+	cacheKey := string(issuer.RawSubject) + "/" + string(leaf.SerialNumber.Bytes())
+	r.mu.Lock()
+	cached, ok := r.ocspCache[cacheKey]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return ocspStatusError(cached.status)
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return fmt.Errorf("create OCSP request: %w", err)
+	}
+	var errs []error
+	for _, server := range leaf.OCSPServer {
+		resp, err := r.queryOCSP(ctx, server, req, leaf, issuer)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		expires := time.Now().Add(r.cacheTTL)
+		if !resp.NextUpdate.IsZero() && resp.NextUpdate.Before(expires) {
+			expires = resp.NextUpdate
+		}
+		r.mu.Lock()
+		r.ocspCache[cacheKey] = ocspCacheEntry{status: resp.Status, expires: expires}
+		r.mu.Unlock()
+		return ocspStatusError(resp.Status)
+	}
+	return errors.Join(errs...)
+}
+
+func (r *revocationChecker) queryOCSP(ctx context.Context, server string, req []byte, leaf, issuer *x509.Certificate) (*ocsp.Response, error) {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, server, bytes.NewReader(req))
+	if err != nil {
+		return nil, fmt.Errorf("create OCSP request to %s: %w", server, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	httpReq.Header.Set("Accept", "application/ocsp-response")
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("query OCSP responder %s: %w", server, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("OCSP responder %s returned status %d", server, resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("read OCSP response from %s: %w", server, err)
+	}
+	parsed, err := ocsp.ParseResponseForCert(body, leaf, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("parse OCSP response from %s: %w", server, err)
+	}
+	return parsed, nil
+}
+
+func ocspStatusError(status int) error {
+	switch status {
+	case ocsp.Good:
+		return nil
+	case ocsp.Revoked:
+		return ErrCertificateRevoked
+	default:
+		return errRevocationUnknown
+	}
+}
+
+func loadCRL(path string) (*crlEntry, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("stat CRL file %q: %w", path, err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read CRL file %q: %w", path, err)
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse CRL file %q: %w", path, err)
+	}
+	revoked := make(map[string]struct{}, len(list.RevokedCertificateEntries))
+	for _, entry := range list.RevokedCertificateEntries {
+		revoked[string(entry.SerialNumber.Bytes())] = struct{}{}
+	}
+	return &crlEntry{
+		path:    path,
+		modTime: stat.ModTime(),
+		list:    list,
+		revoked: revoked,
+	}, nil
+}
@@ -0,0 +1,252 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package mtls
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"golang.org/x/crypto/ocsp"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestRevocation(t *testing.T) {
+	t.Parallel()
+
+	t.Run("CRL", func(t *testing.T) {
+		t.Parallel()
+		ca := newTestCA(t)
+		good := ca.issue(t, 2, nil)
+		revoked := ca.issue(t, 3, nil)
+		crlFile := ca.writeCRL(t, revoked.SerialNumber)
+		p := configureTestPlugin(t, ca, map[string]any{
+			"crl-files":       []any{crlFile},
+			"revocation-mode": "hard-fail",
+		})
+		if _, err := authenticate(p, good); err != nil {
+			t.Fatalf("expected good certificate to authenticate, got: %v", err)
+		}
+		if _, err := authenticate(p, revoked); err == nil {
+			t.Fatal("expected revoked certificate to be rejected")
+		}
+	})
+
+	t.Run("OCSP", func(t *testing.T) {
+		t.Parallel()
+		ca := newTestCA(t)
+		revokedSerial := big.NewInt(3)
+		var requests int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			body, _ := io.ReadAll(r.Body)
+			req, err := ocsp.ParseRequest(body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			status := ocsp.Good
+			if req.SerialNumber.Cmp(revokedSerial) == 0 {
+				status = ocsp.Revoked
+			}
+			resp, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+				Status:       status,
+				SerialNumber: req.SerialNumber,
+				ThisUpdate:   time.Now().Add(-time.Minute),
+				NextUpdate:   time.Now().Add(time.Hour),
+				RevokedAt:    time.Now().Add(-time.Minute),
+			}, ca.key)
+			if err != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				return
+			}
+			_, _ = w.Write(resp)
+		}))
+		t.Cleanup(srv.Close)
+		p := configureTestPlugin(t, ca, map[string]any{
+			"ocsp":            true,
+			"revocation-mode": "hard-fail",
+		})
+		good := ca.issue(t, 2, []string{srv.URL})
+		revoked := ca.issue(t, 3, []string{srv.URL})
+		if _, err := authenticate(p, good); err != nil {
+			t.Fatalf("expected good certificate to authenticate, got: %v", err)
+		}
+		if _, err := authenticate(p, good); err != nil {
+			t.Fatalf("expected cached good certificate to authenticate, got: %v", err)
+		}
+		if requests != 1 {
+			t.Fatalf("expected OCSP response to be cached, got %d requests", requests)
+		}
+		if _, err := authenticate(p, revoked); err == nil {
+			t.Fatal("expected revoked certificate to be rejected")
+		}
+	})
+
+	t.Run("UnreachableResponder", func(t *testing.T) {
+		t.Parallel()
+		ca := newTestCA(t)
+		cert := ca.issue(t, 2, []string{"http://127.0.0.1:1"})
+		soft := configureTestPlugin(t, ca, map[string]any{"ocsp": true, "ocsp-timeout": "1s"})
+		if _, err := authenticate(soft, cert); err != nil {
+			t.Fatalf("expected soft-fail to allow certificate, got: %v", err)
+		}
+		hard := configureTestPlugin(t, ca, map[string]any{"ocsp": true, "ocsp-timeout": "1s", "revocation-mode": "hard-fail"})
+		if _, err := authenticate(hard, cert); err == nil {
+			t.Fatal("expected hard-fail to reject certificate")
+		}
+	})
+
+	t.Run("InvalidMode", func(t *testing.T) {
+		t.Parallel()
+		ca := newTestCA(t)
+		conf, err := structpb.NewStruct(map[string]any{
+			"ca-data":         ca.encodedPEM(),
+			"ocsp":            true,
+			"revocation-mode": "maybe-fail",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var p Plugin
+		if _, err := p.Configure(context.Background(), &v1.PluginConfiguration{Config: conf}); err == nil {
+			t.Fatal("expected invalid revocation mode to be rejected")
+		}
+	})
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, dir: t.TempDir()}
+}
+
+func (c *testCA) encodedPEM() string {
+	var buf bytes.Buffer
+	_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw})
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func (c *testCA) issue(t *testing.T, serial int64, ocspServers []string) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "node"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		OCSPServer:   ocspServers,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, c.cert, &key.PublicKey, c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func (c *testCA) writeCRL(t *testing.T, revoked ...*big.Int) string {
+	t.Helper()
+	var entries []x509.RevocationListEntry
+	for _, serial := range revoked {
+		entries = append(entries, x509.RevocationListEntry{SerialNumber: serial, RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(1),
+		ThisUpdate:                time.Now().Add(-time.Minute),
+		NextUpdate:                time.Now().Add(time.Hour),
+		RevokedCertificateEntries: entries,
+	}, c.cert, c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(c.dir, "ca.crl")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func configureTestPlugin(t *testing.T, ca *testCA, config map[string]any) *Plugin {
+	t.Helper()
+	config["ca-data"] = ca.encodedPEM()
+	conf, err := structpb.NewStruct(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p Plugin
+	if _, err := p.Configure(context.Background(), &v1.PluginConfiguration{Config: conf}); err != nil {
+		t.Fatalf("configure plugin: %v", err)
+	}
+	return &p
+}
+
+func authenticate(p *Plugin, cert *x509.Certificate) (*v1.AuthenticationResponse, error) {
+	return p.Authenticate(context.Background(), &v1.AuthenticationRequest{
+		Certificates: [][]byte{cert.Raw},
+	})
+}
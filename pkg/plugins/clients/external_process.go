@@ -144,9 +144,9 @@ func (p *externalProcessPlugin) start(ctx context.Context) error {
 		if err := p.checkProcess(ctx); err != nil {
 			return err
 		}
-		return invoker(ctx, method, req, reply, p.conn, opts...)
+		return invoker(context.AppendRequestIDToOutgoing(ctx), method, req, reply, p.conn, opts...)
 	}
-	p.conn, err = grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithUnaryInterceptor(interceptor))
+	p.conn, err = grpc.DialContext(ctx, addr, grpc.WithTransportCredentials(insecure.NewCredentials()), grpc.WithUnaryInterceptor(interceptor), grpc.WithStreamInterceptor(context.RequestIDStreamClientInterceptor()))
 	if err != nil {
 		return fmt.Errorf("dial: %w", err)
 	}
@@ -43,6 +43,9 @@ type BuiltinIPAM struct {
 type IPAMConfig struct {
 	// Storage is the storage plugin to use for IPAM.
 	Storage storage.MeshDB
+	// Attachments is the storage to look up attachment addresses in. If nil,
+	// attachments are not considered when allocating addresses.
+	Attachments storage.MeshStorage
 	// StaticIPv4 is a map of node names to IPv4 addresses.
 	StaticIPv4 map[string]string
 }
@@ -87,6 +90,17 @@ func (p *BuiltinIPAM) allocateV4(ctx context.Context, r *v1.AllocateIPRequest) (
 			allocated[n.PrivateAddrV4()] = struct{}{}
 		}
 	}
+	if p.Attachments != nil {
+		attachments, err := storage.ListAttachments(ctx, p.Attachments)
+		if err != nil {
+			return nil, fmt.Errorf("list attachments: %w", err)
+		}
+		for _, a := range attachments {
+			if a.AddressV4.IsValid() {
+				allocated[a.AddressV4] = struct{}{}
+			}
+		}
+	}
 	prefix, err := p.next32(globalPrefix, allocated)
 	if err != nil {
 		return nil, fmt.Errorf("find next available IPv4: %w", err)
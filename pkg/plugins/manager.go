@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net/netip"
+	"sort"
 	"strings"
 
 	v1 "github.com/webmeshproj/api/go/v1"
@@ -123,6 +124,8 @@ type Manager interface {
 	ReleaseIP(ctx context.Context, req *v1.ReleaseIPRequest) error
 	// Emit emits an event to all watch plugins.
 	Emit(ctx context.Context, ev *v1.Event) error
+	// Health queries every plugin and returns their health, sorted by name.
+	Health(ctx context.Context) []PluginHealth
 	// Close closes all plugins.
 	Close() error
 }
@@ -201,8 +204,9 @@ func NewManager(ctx context.Context, opts Options) (Manager, error) {
 	// If we didn't find any IPAM plugins, register the default one
 	if ipamv4 == nil && !opts.DisableDefaultIPAM {
 		ipamv4 = NewBuiltinIPAM(IPAMConfig{
-			Storage:    opts.Storage.MeshDB(),
-			StaticIPv4: opts.DefaultIPAMStaticIPv4,
+			Storage:     opts.Storage.MeshDB(),
+			Attachments: opts.Storage.MeshStorage(),
+			StaticIPv4:  opts.DefaultIPAMStaticIPv4,
 		})
 	}
 	m := &manager{
@@ -225,6 +229,18 @@ func NewManagerWithDB(db storage.Provider) Manager {
 	}
 }
 
+// PluginHealth is the health of a plugin.
+type PluginHealth struct {
+	// Name is the configured name of the plugin.
+	Name string
+	// Capabilities are the capabilities the plugin reported when started.
+	Capabilities []string
+	// Healthy is true if the plugin answered an info request.
+	Healthy bool
+	// Error is the error returned by the plugin when it is unhealthy.
+	Error string
+}
+
 // IPAMPlugin wraps the interface of the IPAM plugin only exposing the Allocate method.
 // This makes for ease of use with the built-in IPAM.
 type IPAMPlugin interface {
@@ -246,6 +262,24 @@ func (m *manager) Get(name string) (clients.PluginClient, bool) {
 	return p.Client, ok
 }
 
+// Health queries every plugin and returns their health, sorted by name.
+func (m *manager) Health(ctx context.Context) []PluginHealth {
+	out := make([]PluginHealth, 0, len(m.plugins))
+	for name, plugin := range m.plugins {
+		health := PluginHealth{Name: name, Healthy: true}
+		for _, cap := range plugin.capabilities {
+			health.Capabilities = append(health.Capabilities, cap.String())
+		}
+		if _, err := plugin.Client.GetInfo(ctx, &emptypb.Empty{}); err != nil {
+			health.Healthy = false
+			health.Error = err.Error()
+		}
+		out = append(out, health)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
 // HasAuth returns true if the manager has an auth plugin.
 func (m *manager) HasAuth() bool {
 	return m.auth != nil
@@ -48,7 +48,16 @@ func Serve(ctx context.Context, plugin v1.PluginServer) error {
 			return err
 		}
 	}
-	s := grpc.NewServer()
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			context.LogInjectUnaryServerInterceptor(log),
+			context.RequestIDUnaryServerInterceptor(),
+		),
+		grpc.ChainStreamInterceptor(
+			context.LogInjectStreamServerInterceptor(log),
+			context.RequestIDStreamServerInterceptor(),
+		),
+	)
 	v1.RegisterPluginServer(s, plugin)
 	if storage, ok := plugin.(v1.StorageQuerierPluginServer); ok {
 		log.Info("registering storage plugin")
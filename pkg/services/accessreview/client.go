@@ -0,0 +1,49 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accessreview
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// AccessReviewClient is the client API for the access review service.
+type AccessReviewClient interface {
+	// ListExpiringRoleBindings lists the rolebindings expiring within a
+	// window.
+	ListExpiringRoleBindings(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+}
+
+// NewAccessReviewClient returns a new access review client using the given connection.
+func NewAccessReviewClient(cc grpc.ClientConnInterface) AccessReviewClient {
+	return &accessReviewClient{cc}
+}
+
+type accessReviewClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *accessReviewClient) ListExpiringRoleBindings(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	out := new(structpb.Struct)
+	err := c.cc.Invoke(ctx, ListExpiringRoleBindingsFullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package accessreview provides a gRPC service for periodic access reviews,
+// listing the rolebindings that are about to expire so they can be renewed or
+// left to lapse. The service uses only well-known protobuf types so that it
+// can be served without generated code.
+package accessreview
+
+import (
+	"fmt"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+const (
+	// ServiceName is the full name of the access review service.
+	ServiceName = "webmesh.accessreview.v1.AccessReview"
+	// ListExpiringRoleBindingsFullMethodName is the full method name of ListExpiringRoleBindings.
+	ListExpiringRoleBindingsFullMethodName = "/" + ServiceName + "/ListExpiringRoleBindings"
+)
+
+// DefaultReviewWindow is how far ahead expiring rolebindings are listed when
+// no window is given.
+const DefaultReviewWindow = 30 * 24 * time.Hour
+
+// AccessReviewServer is the server API for the access review service.
+type AccessReviewServer interface {
+	// ListExpiringRoleBindings lists the rolebindings expiring within a
+	// window.
+	ListExpiringRoleBindings(context.Context, *structpb.Struct) (*structpb.Struct, error)
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the access review service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*AccessReviewServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListExpiringRoleBindings",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(structpb.Struct)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(AccessReviewServer).ListExpiringRoleBindings(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: ListExpiringRoleBindingsFullMethodName}
+				return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(AccessReviewServer).ListExpiringRoleBindings(ctx, req.(*structpb.Struct))
+				})
+			},
+		},
+	},
+}
+
+var listExpiringAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_ROLE_BINDINGS,
+		Verb:     v1.RuleVerb_VERB_GET,
+	},
+}
+
+// Server is the access review service.
+type Server struct {
+	storage  storage.Provider
+	rbacEval rbac.Evaluator
+}
+
+// NewServer returns a new access review server.
+func NewServer(st storage.Provider, rbac rbac.Evaluator) *Server {
+	return &Server{
+		storage:  st,
+		rbacEval: rbac,
+	}
+}
+
+// ListExpiringRoleBindings lists the rolebindings expiring within "within", a
+// duration defaulting to DefaultReviewWindow, soonest first. Rolebindings that
+// already expired but were not yet removed are included.
+func (s *Server) ListExpiringRoleBindings(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if ok, err := s.rbacEval.Evaluate(ctx, listExpiringAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate list expiring rolebindings action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to get rolebindings")
+	}
+	within, err := DecodeRequest(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	now := time.Now()
+	expiring, err := storage.ListExpiringRoleBindings(ctx, s.storage.MeshDB(), s.storage.MeshStorage(), now.Add(within))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out, err := EncodeExpiring(expiring, now)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return out, nil
+}
+
+// DecodeRequest decodes the review window from a ListExpiringRoleBindings request.
+func DecodeRequest(req *structpb.Struct) (time.Duration, error) {
+	within := req.GetFields()["within"].GetStringValue()
+	if within == "" {
+		return DefaultReviewWindow, nil
+	}
+	d, err := time.ParseDuration(within)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window %q: %w", within, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("window must be positive")
+	}
+	return d, nil
+}
+
+// EncodeExpiring encodes expiring rolebindings into a ListExpiringRoleBindings
+// response.
+func EncodeExpiring(expiring []storage.ExpiringRoleBinding, now time.Time) (*structpb.Struct, error) {
+	rolebindings := make([]any, len(expiring))
+	for i, rb := range expiring {
+		subjects := make([]any, len(rb.GetSubjects()))
+		for j, subject := range rb.GetSubjects() {
+			subjects[j] = map[string]any{
+				"type": subject.GetType().String(),
+				"name": subject.GetName(),
+			}
+		}
+		rolebindings[i] = map[string]any{
+			"name":      rb.GetName(),
+			"role":      rb.GetRole(),
+			"subjects":  subjects,
+			"expires":   rb.Expires.UTC().Format(time.RFC3339),
+			"expiresIn": rb.Expires.Sub(now).Round(time.Second).String(),
+		}
+	}
+	return structpb.NewStruct(map[string]any{
+		"rolebindings": rolebindings,
+	})
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accessreview
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name    string
+		fields  map[string]any
+		want    time.Duration
+		wantErr bool
+	}{
+		{
+			name: "default",
+			want: DefaultReviewWindow,
+		},
+		{
+			name:   "window",
+			fields: map[string]any{"within": "168h"},
+			want:   7 * 24 * time.Hour,
+		},
+		{
+			name:    "invalid window",
+			fields:  map[string]any{"within": "a week"},
+			wantErr: true,
+		},
+		{
+			name:    "negative window",
+			fields:  map[string]any{"within": "-1h"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tc {
+		req, err := structpb.NewStruct(tt.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := DecodeRequest(req)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
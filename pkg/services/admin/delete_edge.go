@@ -24,6 +24,7 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
@@ -37,7 +38,7 @@ var deleteEdgeAction = rbac.Actions{
 
 func (s *Server) DeleteEdge(ctx context.Context, edge *v1.MeshEdge) (*emptypb.Empty, error) {
 	if !s.storage.Consensus().IsLeader() {
-		return nil, status.Error(codes.FailedPrecondition, "not the leader")
+		return nil, leaderproxy.NewNotLeaderError(ctx, s.storage, "not the leader")
 	}
 	if edge.GetSource() == "" {
 		return nil, status.Error(codes.InvalidArgument, "edge source is required")
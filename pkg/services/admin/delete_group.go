@@ -24,6 +24,7 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 )
@@ -37,7 +38,7 @@ var deleteGroupAction = rbac.Actions{
 
 func (s *Server) DeleteGroup(ctx context.Context, group *v1.Group) (*emptypb.Empty, error) {
 	if !s.storage.Consensus().IsLeader() {
-		return nil, status.Error(codes.FailedPrecondition, "not the leader")
+		return nil, leaderproxy.NewNotLeaderError(ctx, s.storage, "not the leader")
 	}
 	if group.GetName() == "" {
 		return nil, status.Error(codes.InvalidArgument, "group name is required")
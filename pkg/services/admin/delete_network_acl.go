@@ -24,7 +24,9 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 )
 
 var deleteNetworkACLAction = rbac.Actions{
@@ -36,7 +38,7 @@ var deleteNetworkACLAction = rbac.Actions{
 
 func (s *Server) DeleteNetworkACL(ctx context.Context, acl *v1.NetworkACL) (*emptypb.Empty, error) {
 	if !s.storage.Consensus().IsLeader() {
-		return nil, status.Error(codes.FailedPrecondition, "not the leader")
+		return nil, leaderproxy.NewNotLeaderError(ctx, s.storage, "not the leader")
 	}
 	if acl.GetName() == "" {
 		return nil, status.Error(codes.InvalidArgument, "acl name is required")
@@ -51,5 +53,9 @@ func (s *Server) DeleteNetworkACL(ctx context.Context, acl *v1.NetworkACL) (*emp
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	err = storage.DeleteACLSchedule(ctx, s.storage.MeshStorage(), acl.GetName())
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 	return &emptypb.Empty{}, nil
 }
@@ -18,12 +18,15 @@ limitations under the License.
 package admin
 
 import (
+	"time"
+
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 )
@@ -37,7 +40,7 @@ var deleteRoleBindingAction = rbac.Actions{
 
 func (s *Server) DeleteRoleBinding(ctx context.Context, rb *v1.RoleBinding) (*emptypb.Empty, error) {
 	if !s.storage.Consensus().IsLeader() {
-		return nil, status.Error(codes.FailedPrecondition, "not the leader")
+		return nil, leaderproxy.NewNotLeaderError(ctx, s.storage, "not the leader")
 	}
 	if rb.GetName() == "" {
 		return nil, status.Error(codes.InvalidArgument, "name is required")
@@ -55,5 +58,9 @@ func (s *Server) DeleteRoleBinding(ctx context.Context, rb *v1.RoleBinding) (*em
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	err = storage.SetRoleBindingExpiry(ctx, s.storage.MeshStorage(), rb.GetName(), time.Time{})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 	return &emptypb.Empty{}, nil
 }
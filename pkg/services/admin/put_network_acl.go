@@ -24,7 +24,9 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -37,7 +39,7 @@ var putNetworkACLAction = rbac.Actions{
 
 func (s *Server) PutNetworkACL(ctx context.Context, acl *v1.NetworkACL) (*emptypb.Empty, error) {
 	if !s.storage.Consensus().IsLeader() {
-		return nil, status.Error(codes.FailedPrecondition, "not the leader")
+		return nil, leaderproxy.NewNotLeaderError(ctx, s.storage, "not the leader")
 	}
 	if acl.GetName() == "" {
 		return nil, status.Error(codes.InvalidArgument, "acl name is required")
@@ -62,6 +64,22 @@ func (s *Server) PutNetworkACL(ctx context.Context, acl *v1.NetworkACL) (*emptyp
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	conflicts, err := storage.NetworkACLConflicts(ctx, s.db, nacl)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if len(conflicts) > 0 {
+		return nil, status.Errorf(codes.InvalidArgument,
+			"acl conflicts with network acl %q: both have priority %d and may match the same traffic with different actions, use a distinct priority",
+			conflicts[0].GetName(), acl.GetPriority())
+	}
+	err = storage.CheckNetworkACLQuota(ctx, s.db, s.storage.MeshStorage(), nacl)
+	if err != nil {
+		if storage.IsQuotaExceeded(err) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 	err = s.db.Networking().PutNetworkACL(ctx, nacl)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
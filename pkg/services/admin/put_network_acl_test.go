@@ -128,6 +128,36 @@ func TestPutNetworkACL(t *testing.T) {
 				}
 			},
 		},
+		{
+			name: "conflicting acl at the same priority",
+			code: codes.InvalidArgument,
+			req: &v1.NetworkACL{
+				Name:             "deny-foo",
+				Action:           v1.ACLAction_ACTION_DENY,
+				DestinationNodes: []string{"foo"},
+				DestinationCIDRs: []string{"10.0.0.0/8"},
+			},
+		},
+		{
+			name: "overriding acl at a higher priority",
+			code: codes.OK,
+			req: &v1.NetworkACL{
+				Name:             "deny-foo",
+				Priority:         10,
+				Action:           v1.ACLAction_ACTION_DENY,
+				DestinationNodes: []string{"foo"},
+				DestinationCIDRs: []string{"10.0.0.0/8"},
+			},
+		},
+		{
+			name: "non-overlapping acl at the same priority",
+			code: codes.OK,
+			req: &v1.NetworkACL{
+				Name:             "deny-bar",
+				Action:           v1.ACLAction_ACTION_DENY,
+				DestinationNodes: []string{"bar"},
+			},
+		},
 	}
 
 	runTestCases(t, tt, server.PutNetworkACL)
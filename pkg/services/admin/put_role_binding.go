@@ -18,12 +18,15 @@ limitations under the License.
 package admin
 
 import (
+	"time"
+
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
@@ -38,7 +41,7 @@ var putRoleBindingAction = rbac.Actions{
 
 func (s *Server) PutRoleBinding(ctx context.Context, rb *v1.RoleBinding) (*emptypb.Empty, error) {
 	if !s.storage.Consensus().IsLeader() {
-		return nil, status.Error(codes.FailedPrecondition, "not the leader")
+		return nil, leaderproxy.NewNotLeaderError(ctx, s.storage, "not the leader")
 	}
 	if rb.GetName() == "" {
 		return nil, status.Error(codes.InvalidArgument, "rolebinding name cannot be empty")
@@ -74,7 +77,19 @@ func (s *Server) PutRoleBinding(ctx context.Context, rb *v1.RoleBinding) (*empty
 			return nil, status.Error(codes.InvalidArgument, "subject name must be a valid node ID")
 		}
 	}
-	err := s.db.RBAC().PutRoleBinding(ctx, types.RoleBinding{RoleBinding: rb})
+	// Putting a rolebinding replaces its expiry, clearing it when none is set.
+	expires, err := storage.RoleBindingExpiryFromRequest(ctx)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !expires.IsZero() && !expires.After(time.Now()) {
+		return nil, status.Error(codes.InvalidArgument, "rolebinding expiry must be in the future")
+	}
+	err = s.db.RBAC().PutRoleBinding(ctx, types.RoleBinding{RoleBinding: rb})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	err = storage.SetRoleBindingExpiry(ctx, s.storage.MeshStorage(), rb.GetName(), expires)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
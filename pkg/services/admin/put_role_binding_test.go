@@ -19,9 +19,12 @@ package admin
 import (
 	"context"
 	"testing"
+	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/storage"
 )
@@ -189,3 +192,49 @@ func TestPutRoleBinding(t *testing.T) {
 
 	runTestCases(t, tt, server.PutRoleBinding)
 }
+
+func TestPutRoleBindingExpiry(t *testing.T) {
+	t.Parallel()
+
+	server := newTestServer(t)
+	rb := &v1.RoleBinding{
+		Name:     "expiring-rolebinding",
+		Role:     "test-role",
+		Subjects: []*v1.Subject{{Name: "contractor", Type: v1.SubjectType_SUBJECT_USER}},
+	}
+	withExpiry := func(expires string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(storage.RoleBindingExpiresHeader, expires))
+	}
+
+	_, err := server.PutRoleBinding(withExpiry("tomorrow"), rb)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument for a malformed expiry, got %v", err)
+	}
+	_, err = server.PutRoleBinding(withExpiry(time.Now().Add(-time.Hour).Format(time.RFC3339)), rb)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument for a past expiry, got %v", err)
+	}
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	if _, err := server.PutRoleBinding(withExpiry(expires.Format(time.RFC3339)), rb); err != nil {
+		t.Fatalf("put rolebinding: %v", err)
+	}
+	got, err := storage.GetRoleBindingExpiry(context.Background(), server.storage.MeshStorage(), rb.GetName())
+	if err != nil {
+		t.Fatalf("get expiry: %v", err)
+	}
+	if !got.Equal(expires) {
+		t.Fatalf("expected expiry %v, got %v", expires, got)
+	}
+
+	// Putting the rolebinding without an expiry makes it permanent.
+	if _, err := server.PutRoleBinding(context.Background(), rb); err != nil {
+		t.Fatalf("put rolebinding: %v", err)
+	}
+	got, err = storage.GetRoleBindingExpiry(context.Background(), server.storage.MeshStorage(), rb.GetName())
+	if err != nil {
+		t.Fatalf("get expiry: %v", err)
+	}
+	if !got.IsZero() {
+		t.Fatalf("expected the expiry to be cleared, got %v", got)
+	}
+}
@@ -24,7 +24,9 @@ import (
 	"google.golang.org/protobuf/types/known/emptypb"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -37,7 +39,7 @@ var putRouteAction = rbac.Actions{
 
 func (s *Server) PutRoute(ctx context.Context, route *v1.Route) (*emptypb.Empty, error) {
 	if !s.storage.Consensus().IsLeader() {
-		return nil, status.Error(codes.FailedPrecondition, "not the leader")
+		return nil, leaderproxy.NewNotLeaderError(ctx, s.storage, "not the leader")
 	}
 	rt := types.Route{Route: route}
 	err := types.ValidateRoute(rt)
@@ -50,6 +52,13 @@ func (s *Server) PutRoute(ctx context.Context, route *v1.Route) (*emptypb.Empty,
 		}
 		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to put network routes")
 	}
+	err = storage.CheckRouteQuota(ctx, s.db, s.storage.MeshStorage(), rt)
+	if err != nil {
+		if storage.IsQuotaExceeded(err) {
+			return nil, status.Error(codes.ResourceExhausted, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
 	err = s.db.Networking().PutRoute(ctx, rt)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
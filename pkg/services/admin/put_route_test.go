@@ -22,6 +22,8 @@ import (
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
 )
 
 func TestPutRoute(t *testing.T) {
@@ -133,3 +135,58 @@ func TestPutRoute(t *testing.T) {
 
 	runTestCases(t, tt, server.PutRoute)
 }
+
+func TestPutRouteQuota(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	server := newTestServer(t)
+	st := server.storage.MeshStorage()
+	if err := storage.PutNamespace(ctx, st, storage.Namespace{Name: "team", Quota: storage.NamespaceQuota{MaxRoutes: 1}}); err != nil {
+		t.Fatalf("put namespace: %v", err)
+	}
+	if err := storage.SetNodeNamespace(ctx, st, "test", "team"); err != nil {
+		t.Fatalf("set node namespace: %v", err)
+	}
+
+	tt := []testCase[v1.Route]{
+		{
+			name: "within quota",
+			code: codes.OK,
+			req: &v1.Route{
+				Name:             "test-a",
+				Node:             "test",
+				DestinationCIDRs: []string{"10.0.0.0/24"},
+			},
+		},
+		{
+			name: "over quota",
+			code: codes.ResourceExhausted,
+			req: &v1.Route{
+				Name:             "test-b",
+				Node:             "test",
+				DestinationCIDRs: []string{"10.0.1.0/24"},
+			},
+		},
+		{
+			name: "replace within quota",
+			code: codes.OK,
+			req: &v1.Route{
+				Name:             "test-a",
+				Node:             "test",
+				DestinationCIDRs: []string{"10.0.2.0/24"},
+			},
+		},
+		{
+			name: "other namespace",
+			code: codes.OK,
+			req: &v1.Route{
+				Name:             "other-a",
+				Node:             "other",
+				DestinationCIDRs: []string{"10.0.3.0/24"},
+			},
+		},
+	}
+
+	runTestCases(t, tt, server.PutRoute)
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cni
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// ErrNotFound is returned by the client when an attachment does not exist.
+var ErrNotFound = errors.New("attachment not found")
+
+// Client is a client for the CNI API.
+type Client struct {
+	http *http.Client
+}
+
+// NewClient returns a client for the CNI API served on the given socket.
+func NewClient(socket string) *Client {
+	return &Client{
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// Allocate allocates an attachment for a pod. Allocating an existing
+// attachment returns it unchanged.
+func (c *Client) Allocate(ctx context.Context, req AllocateRequest) (storage.Attachment, error) {
+	var att storage.Attachment
+	body, err := json.Marshal(req)
+	if err != nil {
+		return att, err
+	}
+	err = c.do(ctx, http.MethodPost, AttachmentsPath, body, &att)
+	return att, err
+}
+
+// Get returns the attachment with the given ID.
+func (c *Client) Get(ctx context.Context, id string) (storage.Attachment, error) {
+	var att storage.Attachment
+	err := c.do(ctx, http.MethodGet, AttachmentsPath+"/"+id, nil, &att)
+	return att, err
+}
+
+// Release releases the attachment with the given ID.
+func (c *Client) Release(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, AttachmentsPath+"/"+id, nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body []byte, out any) error {
+	req, err := http.NewRequestWithContext(ctx, method, "http://webmesh"+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("call node api: %w", err)
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrNotFound
+	case resp.StatusCode >= 300:
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("node api: %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	case out != nil:
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,242 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package cni contains the local API used by the webmesh CNI plugin to
+// attach pods to the mesh.
+package cni
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/attach"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// DefaultSocket is the default socket for the CNI API.
+const DefaultSocket = "/var/run/webmesh/cni.sock"
+
+// DefaultGCInterval is the default interval for releasing the attachments of
+// pods whose network namespace no longer exists.
+const DefaultGCInterval = time.Minute
+
+// AttachmentsPath is the path of the attachments API. Attachments are
+// allocated with a POST, and fetched or released by appending their ID.
+const AttachmentsPath = "/v1/attachments"
+
+// OwnerPrefix prefixes the container ID in the owner of pod attachments.
+const OwnerPrefix = "cni:"
+
+// gcGracePeriod protects attachments of pods that are still being set up
+// from garbage collection.
+const gcGracePeriod = time.Minute
+
+// AllocateRequest is a request to allocate an attachment for a pod.
+type AllocateRequest struct {
+	// ID is the ID of the attachment.
+	ID string `json:"id"`
+	// ContainerID is the ID of the pod sandbox container.
+	ContainerID string `json:"containerID"`
+	// Netns is the path to the network namespace of the pod.
+	Netns string `json:"netns"`
+	// Tags are used to reference the pod from network ACLs.
+	Tags []string `json:"tags,omitempty"`
+}
+
+// Options are the options for the CNI API.
+type Options struct {
+	// Socket is the path to serve the API on.
+	Socket string
+	// GCInterval is the interval for releasing the attachments of pods that
+	// have gone away. Defaults to DefaultGCInterval.
+	GCInterval time.Duration
+	// Network is the network manager of this node.
+	Network meshnet.Manager
+	// Allocator allocates attachments for pods.
+	Allocator *attach.Allocator
+}
+
+// Server serves the CNI API.
+type Server struct {
+	Options
+	srv  *http.Server
+	log  *slog.Logger
+	stop chan struct{}
+}
+
+// New returns a new CNI API server.
+func New(ctx context.Context, o Options) *Server {
+	if o.GCInterval <= 0 {
+		o.GCInterval = DefaultGCInterval
+	}
+	s := &Server{
+		Options: o,
+		log:     context.LoggerFrom(ctx).With("component", "cni-api"),
+		stop:    make(chan struct{}),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(AttachmentsPath, s.serveAttachments)
+	mux.HandleFunc(AttachmentsPath+"/", s.serveAttachments)
+	s.srv = &http.Server{Handler: mux}
+	return s
+}
+
+// ListenAndServe starts the server and blocks until the server exits.
+func (s *Server) ListenAndServe() error {
+	s.log.Info("Starting CNI API server", slog.String("socket", s.Socket))
+	if err := os.MkdirAll(filepath.Dir(s.Socket), 0750); err != nil {
+		return fmt.Errorf("create socket directory: %w", err)
+	}
+	if err := os.Remove(s.Socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove stale socket: %w", err)
+	}
+	ln, err := net.Listen("unix", s.Socket)
+	if err != nil {
+		return fmt.Errorf("listen on socket: %w", err)
+	}
+	if err := os.Chmod(s.Socket, 0600); err != nil {
+		ln.Close()
+		return fmt.Errorf("set socket permissions: %w", err)
+	}
+	go s.runGC()
+	if err := s.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown attempts to stop the server gracefully.
+func (s *Server) Shutdown(ctx context.Context) error {
+	context.LoggerFrom(ctx).Info("Shutting down CNI API server")
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	defer os.Remove(s.Socket)
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) serveAttachments(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithLogger(r.Context(), s.log)
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, AttachmentsPath), "/")
+	switch {
+	case r.Method == http.MethodPost && id == "":
+		var req AllocateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("decode request: %v", err), http.StatusBadRequest)
+			return
+		}
+		att, err := s.Allocator.Allocate(ctx, attach.Request{
+			ID:      req.ID,
+			Owner:   OwnerPrefix + req.ContainerID,
+			Sandbox: req.Netns,
+			IPv4:    s.Network.NetworkV4().IsValid(),
+			IPv6:    s.Network.WireGuard() != nil && s.Network.WireGuard().AddressV6().IsValid(),
+			Tags:    req.Tags,
+		})
+		if err != nil {
+			s.log.Error("Failed to allocate pod attachment", slog.String("id", req.ID), slog.String("error", err.Error()))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, s.log, att)
+	case r.Method == http.MethodGet && id != "":
+		att, err := s.Allocator.Get(ctx, id)
+		if err != nil {
+			if errors.IsKeyNotFound(err) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, s.log, att)
+	case r.Method == http.MethodDelete && id != "":
+		if err := s.Allocator.Release(ctx, id); err != nil {
+			s.log.Error("Failed to release pod attachment", slog.String("id", id), slog.String("error", err.Error()))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) runGC() {
+	t := time.NewTicker(s.GCInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			ctx := context.WithLogger(context.Background(), s.log)
+			if err := s.collect(ctx); err != nil {
+				s.log.Warn("Failed to garbage collect pod attachments", slog.String("error", err.Error()))
+			}
+		}
+	}
+}
+
+// collect releases the attachments of pods whose network namespace no longer
+// exists. Kubelet normally releases them with a CNI DEL, but does not retry if
+// the pod is gone or the node was down at the time.
+func (s *Server) collect(ctx context.Context) error {
+	attachments, err := s.Allocator.List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, att := range attachments {
+		if !isStale(att) {
+			continue
+		}
+		s.log.Info("Releasing attachment of removed pod", slog.String("id", att.ID), slog.String("netns", att.Sandbox))
+		if err := s.Allocator.Release(ctx, att.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isStale(att storage.Attachment) bool {
+	if !strings.HasPrefix(att.Owner, OwnerPrefix) || att.Sandbox == "" {
+		return false
+	}
+	if time.Since(att.Created) < gcGracePeriod {
+		return false
+	}
+	_, err := os.Stat(att.Sandbox)
+	return os.IsNotExist(err)
+}
+
+func writeJSON(w http.ResponseWriter, log *slog.Logger, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Error("Failed to write response", slog.String("error", err.Error()))
+	}
+}
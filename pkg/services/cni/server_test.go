@@ -0,0 +1,159 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cni
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/attach"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/testutil"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+type testProvider struct {
+	storage.Provider
+	st storage.MeshStorage
+	db storage.MeshDB
+}
+
+func (p testProvider) MeshStorage() storage.MeshStorage { return p.st }
+
+func (p testProvider) MeshDB() storage.MeshDB { return p.db }
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+	network := testutil.NewManagerWithDB(db, meshnet.Options{}, "node-a")
+	err := network.Start(ctx, meshnet.StartOptions{
+		Key:       crypto.MustGenerateKey(),
+		AddressV6: netip.MustParsePrefix("fd00:1:2:3:4:5:6:0/112"),
+		NetworkV6: netip.MustParsePrefix("fd00:1:2::/48"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(ctx, Options{
+		Network: network,
+		Allocator: attach.NewAllocator(attach.Options{
+			NodeID:  "node-a",
+			Storage: testProvider{st: st, db: db},
+			Network: network,
+		}),
+	})
+	handler := srv.srv.Handler
+
+	do := func(t *testing.T, method, path string, body any) *httptest.ResponseRecorder {
+		t.Helper()
+		var data []byte
+		if body != nil {
+			var err error
+			data, err = json.Marshal(body)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, path, bytes.NewReader(data)))
+		return rec
+	}
+
+	sandbox := t.TempDir()
+	rec := do(t, http.MethodPost, AttachmentsPath, AllocateRequest{
+		ID:          "cni-abc-eth0",
+		ContainerID: "abc",
+		Netns:       sandbox,
+		Tags:        []string{"web"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status allocating attachment %d: %s", rec.Code, rec.Body.String())
+	}
+	var att storage.Attachment
+	if err := json.Unmarshal(rec.Body.Bytes(), &att); err != nil {
+		t.Fatal(err)
+	}
+	if att.AddressV4.IsValid() || !netip.MustParsePrefix("fd00:1:2:3:4:5:6:0/112").Contains(att.AddressV6.Addr()) {
+		t.Fatalf("unexpected addresses: %+v", att)
+	}
+	if att.Owner != OwnerPrefix+"abc" || att.Sandbox != sandbox {
+		t.Fatalf("unexpected owner or sandbox: %+v", att)
+	}
+	// Allocating again returns the same addresses.
+	rec = do(t, http.MethodPost, AttachmentsPath, AllocateRequest{ID: "cni-abc-eth0", ContainerID: "abc", Netns: sandbox})
+	var again storage.Attachment
+	if err := json.Unmarshal(rec.Body.Bytes(), &again); err != nil {
+		t.Fatal(err)
+	}
+	if again.AddressV6 != att.AddressV6 {
+		t.Fatalf("expected the same address on repeated allocation, got %s and %s", att.AddressV6, again.AddressV6)
+	}
+	if rec := do(t, http.MethodGet, AttachmentsPath+"/cni-abc-eth0", nil); rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status getting attachment: %d", rec.Code)
+	}
+	if rec := do(t, http.MethodGet, AttachmentsPath+"/cni-def-eth0", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected not found for unknown attachment, got %d", rec.Code)
+	}
+
+	// Attachments of pods whose netns is gone are collected after the grace period.
+	gone := storage.Attachment{
+		ID:        "cni-def-eth0",
+		NodeID:    "node-a",
+		Owner:     OwnerPrefix + "def",
+		Sandbox:   filepath.Join(sandbox, "missing"),
+		AddressV6: netip.MustParsePrefix("fd00:1:2:3:4:5:6:10/128"),
+		Created:   time.Now().Add(-2 * gcGracePeriod),
+	}
+	if err := storage.PutAttachment(ctx, st, gone); err != nil {
+		t.Fatal(err)
+	}
+	fresh := gone
+	fresh.ID, fresh.Created = "cni-ghi-eth0", time.Now()
+	if err := storage.PutAttachment(ctx, st, fresh); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.collect(ctx); err != nil {
+		t.Fatalf("collect attachments: %v", err)
+	}
+	attachments, err := srv.Allocator.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 2 || attachments[0].ID != "cni-abc-eth0" || attachments[1].ID != "cni-ghi-eth0" {
+		t.Fatalf("unexpected attachments after collection: %+v", attachments)
+	}
+
+	if rec := do(t, http.MethodDelete, AttachmentsPath+"/cni-abc-eth0", nil); rec.Code != http.StatusNoContent {
+		t.Fatalf("unexpected status releasing attachment: %d", rec.Code)
+	}
+	if rec := do(t, http.MethodGet, AttachmentsPath+"/cni-abc-eth0", nil); rec.Code != http.StatusNotFound {
+		t.Fatalf("expected released attachment to be gone, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,289 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package conntrack provides a gRPC service for inspecting and terminating the
+// connections tracked by a node's userspace dataplane. The service uses only
+// well-known protobuf types so that it can be served without generated code.
+package conntrack
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/conntrack"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+const (
+	// ServiceName is the full name of the conntrack service.
+	ServiceName = "webmesh.conntrack.v1.Conntrack"
+	// ListFlowsFullMethodName is the full method name of ListFlows.
+	ListFlowsFullMethodName = "/" + ServiceName + "/ListFlows"
+	// KillFlowsFullMethodName is the full method name of KillFlows.
+	KillFlowsFullMethodName = "/" + ServiceName + "/KillFlows"
+)
+
+// ConntrackServer is the server API for the conntrack service.
+type ConntrackServer interface {
+	// ListFlows lists the tracked flows and table statistics.
+	ListFlows(context.Context, *emptypb.Empty) (*structpb.Struct, error)
+	// KillFlows terminates the flows matching the request and returns the number killed.
+	KillFlows(context.Context, *structpb.Struct) (*structpb.Struct, error)
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the conntrack service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*ConntrackServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ListFlows",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(emptypb.Empty)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(ConntrackServer).ListFlows(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: ListFlowsFullMethodName}
+				return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(ConntrackServer).ListFlows(ctx, req.(*emptypb.Empty))
+				})
+			},
+		},
+		{
+			MethodName: "KillFlows",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(structpb.Struct)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(ConntrackServer).KillFlows(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: KillFlowsFullMethodName}
+				return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(ConntrackServer).KillFlows(ctx, req.(*structpb.Struct))
+				})
+			},
+		},
+	},
+}
+
+var listFlowsAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_NETWORK_ACLS,
+		Verb:     v1.RuleVerb_VERB_GET,
+	},
+}
+
+var killFlowsAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_NETWORK_ACLS,
+		Verb:     v1.RuleVerb_VERB_DELETE,
+	},
+}
+
+// Server is the conntrack service.
+type Server struct {
+	table    *conntrack.Table
+	rbacEval rbac.Evaluator
+}
+
+// NewServer returns a new conntrack server for the given table.
+func NewServer(table *conntrack.Table, rbac rbac.Evaluator) *Server {
+	return &Server{
+		table:    table,
+		rbacEval: rbac,
+	}
+}
+
+// ListFlows lists the tracked flows and table statistics.
+func (s *Server) ListFlows(ctx context.Context, _ *emptypb.Empty) (*structpb.Struct, error) {
+	if ok, err := s.rbacEval.Evaluate(ctx, listFlowsAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate list flows action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to list flows")
+	}
+	flows := s.table.List()
+	items := make([]any, len(flows))
+	for i, flow := range flows {
+		items[i] = encodeFlow(flow)
+	}
+	stats := s.table.Stats()
+	out, err := structpb.NewStruct(map[string]any{
+		"flows": items,
+		"stats": map[string]any{
+			"flows":   stats.Flows,
+			"killed":  stats.Killed,
+			"dropped": float64(stats.Dropped),
+		},
+	})
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return out, nil
+}
+
+// KillFlows terminates the flows matching the request and returns the number killed.
+// The request must set at least one match field, or "all" to kill every flow.
+func (s *Server) KillFlows(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if ok, err := s.rbacEval.Evaluate(ctx, killFlowsAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate kill flows action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to kill flows")
+	}
+	match, all, err := DecodeMatch(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if match == (conntrack.Match{}) && !all {
+		return nil, status.Error(codes.InvalidArgument, "at least one match field or all is required")
+	}
+	killed := s.table.Kill(match)
+	context.LoggerFrom(ctx).Info("Killed tracked flows", "killed", killed, "match", match)
+	return structpb.NewStruct(map[string]any{"killed": killed})
+}
+
+// EncodeMatch encodes a match into a KillFlows request.
+func EncodeMatch(m conntrack.Match, all bool) (*structpb.Struct, error) {
+	fields := map[string]any{}
+	if all {
+		fields["all"] = true
+	}
+	if m.Protocol != 0 {
+		fields["protocol"] = m.Protocol.String()
+	}
+	if m.Src.IsValid() {
+		fields["src"] = m.Src.String()
+	}
+	if m.Dst.IsValid() {
+		fields["dst"] = m.Dst.String()
+	}
+	if m.SrcPort != 0 {
+		fields["srcPort"] = int(m.SrcPort)
+	}
+	if m.DstPort != 0 {
+		fields["dstPort"] = int(m.DstPort)
+	}
+	return structpb.NewStruct(fields)
+}
+
+// DecodeMatch decodes a match from a KillFlows request. Addresses may be
+// given as single addresses or prefixes.
+func DecodeMatch(req *structpb.Struct) (m conntrack.Match, all bool, err error) {
+	fields := req.GetFields()
+	all = fields["all"].GetBoolValue()
+	if v := fields["protocol"].GetStringValue(); v != "" {
+		if m.Protocol, err = conntrack.ParseProtocol(v); err != nil {
+			return
+		}
+	}
+	if v := fields["src"].GetStringValue(); v != "" {
+		if m.Src, err = parsePrefix(v); err != nil {
+			return
+		}
+	}
+	if v := fields["dst"].GetStringValue(); v != "" {
+		if m.Dst, err = parsePrefix(v); err != nil {
+			return
+		}
+	}
+	if m.SrcPort, err = parsePort(fields["srcPort"]); err != nil {
+		return
+	}
+	m.DstPort, err = parsePort(fields["dstPort"])
+	return
+}
+
+// DecodeFlows decodes the flows from a ListFlows response.
+func DecodeFlows(resp *structpb.Struct) ([]conntrack.Flow, error) {
+	values := resp.GetFields()["flows"].GetListValue().GetValues()
+	flows := make([]conntrack.Flow, 0, len(values))
+	for _, v := range values {
+		fields := v.GetStructValue().GetFields()
+		proto, err := conntrack.ParseProtocol(fields["protocol"].GetStringValue())
+		if err != nil {
+			return nil, err
+		}
+		src, err := netip.ParseAddrPort(fields["src"].GetStringValue())
+		if err != nil {
+			return nil, fmt.Errorf("parse flow source: %w", err)
+		}
+		dst, err := netip.ParseAddrPort(fields["dst"].GetStringValue())
+		if err != nil {
+			return nil, fmt.Errorf("parse flow destination: %w", err)
+		}
+		flow := conntrack.Flow{
+			FlowKey: conntrack.FlowKey{Protocol: proto, Src: src, Dst: dst},
+			Packets: uint64(fields["packets"].GetNumberValue()),
+			Bytes:   uint64(fields["bytes"].GetNumberValue()),
+			Killed:  fields["killed"].GetBoolValue(),
+		}
+		if fields["direction"].GetStringValue() == conntrack.Inbound.String() {
+			flow.Direction = conntrack.Inbound
+		}
+		flow.Created, _ = time.Parse(time.RFC3339Nano, fields["created"].GetStringValue())
+		flow.LastSeen, _ = time.Parse(time.RFC3339Nano, fields["lastSeen"].GetStringValue())
+		flows = append(flows, flow)
+	}
+	return flows, nil
+}
+
+func encodeFlow(f conntrack.Flow) map[string]any {
+	return map[string]any{
+		"protocol":  f.Protocol.String(),
+		"src":       f.Src.String(),
+		"dst":       f.Dst.String(),
+		"direction": f.Direction.String(),
+		"created":   f.Created.Format(time.RFC3339Nano),
+		"lastSeen":  f.LastSeen.Format(time.RFC3339Nano),
+		"packets":   float64(f.Packets),
+		"bytes":     float64(f.Bytes),
+		"killed":    f.Killed,
+	}
+}
+
+func parsePrefix(s string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(s); err == nil {
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(s)
+	if err != nil {
+		return prefix, fmt.Errorf("invalid address or prefix: %q", s)
+	}
+	return prefix.Masked(), nil
+}
+
+func parsePort(v *structpb.Value) (uint16, error) {
+	n := v.GetNumberValue()
+	if n < 0 || n > 65535 || n != float64(uint16(n)) {
+		return 0, fmt.Errorf("invalid port: %v", n)
+	}
+	return uint16(n), nil
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package conntrack
+
+import (
+	"net/netip"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/conntrack"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	table := conntrack.New(conntrack.Options{})
+	defer table.Close()
+	table.Track(conntrack.Outbound, ipv4Packet(conntrack.ProtocolTCP, "172.16.0.1:4000", "172.16.0.2:443"))
+	table.Track(conntrack.Inbound, ipv4Packet(conntrack.ProtocolUDP, "172.16.0.3:5353", "172.16.0.1:53"))
+	srv := NewServer(table, rbac.NewNoopEvaluator())
+
+	resp, err := srv.ListFlows(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	flows, err := DecodeFlows(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flows) != 2 {
+		t.Fatalf("expected 2 flows, got %d", len(flows))
+	}
+	for _, flow := range flows {
+		if flow.Protocol == conntrack.ProtocolUDP && flow.Direction != conntrack.Inbound {
+			t.Errorf("expected udp flow to be inbound, got %s", flow.Direction)
+		}
+		if flow.Packets != 1 || flow.Created.IsZero() {
+			t.Errorf("unexpected flow: %+v", flow)
+		}
+	}
+
+	_, err = srv.KillFlows(ctx, &structpb.Struct{})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected invalid argument for empty match, got %v", err)
+	}
+	req, err := EncodeMatch(conntrack.Match{
+		Protocol: conntrack.ProtocolTCP,
+		Dst:      netip.MustParsePrefix("172.16.0.2/32"),
+		DstPort:  443,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err = srv.KillFlows(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if killed := resp.GetFields()["killed"].GetNumberValue(); killed != 1 {
+		t.Fatalf("expected 1 flow killed, got %v", killed)
+	}
+	if stats := table.Stats(); stats.Killed != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestDecodeMatch(t *testing.T) {
+	t.Parallel()
+	want := conntrack.Match{
+		Protocol: conntrack.ProtocolUDP,
+		Src:      netip.MustParsePrefix("10.0.0.0/8"),
+		Dst:      netip.MustParsePrefix("fd00::1/128"),
+		SrcPort:  1234,
+		DstPort:  53,
+	}
+	req, err := EncodeMatch(want, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, all, err := DecodeMatch(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want || !all {
+		t.Fatalf("expected %+v, got %+v (all=%v)", want, got, all)
+	}
+	req.Fields["dst"] = structpb.NewStringValue("fd00::2")
+	got, _, err = DecodeMatch(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Dst != netip.MustParsePrefix("fd00::2/128") {
+		t.Fatalf("expected single address to decode as a host prefix, got %s", got.Dst)
+	}
+	req.Fields["dstPort"] = structpb.NewNumberValue(70000)
+	if _, _, err = DecodeMatch(req); err == nil {
+		t.Fatal("expected error for invalid port")
+	}
+}
+
+func TestMethodsAreLocal(t *testing.T) {
+	t.Parallel()
+	for _, method := range []string{ListFlowsFullMethodName, KillFlowsFullMethodName} {
+		if policy, ok := leaderproxy.MethodPolicyMap[method]; !ok || policy != leaderproxy.RequireLocal {
+			t.Errorf("expected %s to require local handling", method)
+		}
+	}
+}
+
+func ipv4Packet(proto conntrack.Protocol, src, dst string) []byte {
+	s, d := netip.MustParseAddrPort(src), netip.MustParseAddrPort(dst)
+	pkt := make([]byte, 28)
+	pkt[0] = 0x45
+	pkt[9] = byte(proto)
+	sa, da := s.Addr().As4(), d.Addr().As4()
+	copy(pkt[12:16], sa[:])
+	copy(pkt[16:20], da[:])
+	pkt[20], pkt[21] = byte(s.Port()>>8), byte(s.Port())
+	pkt[22], pkt[23] = byte(d.Port()>>8), byte(d.Port())
+	return pkt
+}
@@ -0,0 +1,321 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package consul registers services exposed over the mesh with a local Consul
+// agent and advertises them in the mesh while Consul reports them healthy.
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultAddress is the default address of the Consul agent.
+const DefaultAddress = "http://127.0.0.1:8500"
+
+// DefaultInterval is the default interval for health checks and for
+// refreshing registrations and advertisements.
+const DefaultInterval = 10 * time.Second
+
+// NodeMetaKey is the service meta key holding the ID of the mesh node.
+const NodeMetaKey = "webmesh-node"
+
+// Health statuses reported by the Consul agent.
+const (
+	StatusPassing  = "passing"
+	StatusWarning  = "warning"
+	StatusCritical = "critical"
+)
+
+// Service is a service running on this node that is exposed over the mesh.
+type Service struct {
+	// Name is the name of the service in Consul and in the mesh.
+	Name string
+	// Port is the port the service listens on.
+	Port int
+	// Tags are the Consul tags of the service.
+	Tags []string
+}
+
+// ParseService parses a service in the format name=port[,tag...].
+func ParseService(s string) (Service, error) {
+	name, rest, ok := strings.Cut(s, "=")
+	if !ok || name == "" || rest == "" {
+		return Service{}, fmt.Errorf("invalid service %q, expected name=port[,tag...]", s)
+	}
+	fields := strings.Split(rest, ",")
+	var port int
+	if _, err := fmt.Sscanf(fields[0], "%d", &port); err != nil || port <= 0 || port > 65535 {
+		return Service{}, fmt.Errorf("invalid port in service %q", s)
+	}
+	if !types.IsValidID(name) {
+		return Service{}, fmt.Errorf("invalid service name %q", name)
+	}
+	return Service{Name: name, Port: port, Tags: fields[1:]}, nil
+}
+
+// Options are the options for the Consul integration.
+type Options struct {
+	// Address is the address of the Consul agent.
+	Address string
+	// Token is the ACL token for the Consul agent.
+	Token string
+	// Interval is the interval for health checks and refreshes.
+	Interval time.Duration
+	// Services are the services to register.
+	Services []Service
+	// NodeID is the ID of this node.
+	NodeID types.NodeID
+	// Network is the network manager of this node.
+	Network meshnet.Manager
+	// Storage is used to advertise healthy services in the mesh.
+	Storage storage.MeshStorage
+}
+
+// Registrar keeps the services of this node registered in Consul with their
+// mesh address, and advertised in the mesh only while Consul reports them
+// healthy. Mesh members picking providers with storage.ListServiceProviders
+// therefore skip instances that Consul considers critical.
+type Registrar struct {
+	Options
+	client     *http.Client
+	log        *slog.Logger
+	registered map[string]netip.Addr
+	started    atomic.Bool
+	stop       chan struct{}
+	done       chan struct{}
+}
+
+// New returns a new Consul registrar.
+func New(ctx context.Context, o Options) *Registrar {
+	if o.Interval <= 0 {
+		o.Interval = DefaultInterval
+	}
+	return &Registrar{
+		Options:    o,
+		client:     &http.Client{Timeout: o.Interval},
+		log:        context.LoggerFrom(ctx).With("component", "consul"),
+		registered: make(map[string]netip.Addr),
+		stop:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// ListenAndServe registers the services and keeps their advertisements in
+// sync with their health until Shutdown is called.
+func (r *Registrar) ListenAndServe() error {
+	r.started.Store(true)
+	defer close(r.done)
+	r.log.Info("Starting consul integration", slog.String("address", r.Address))
+	t := time.NewTicker(r.Interval)
+	defer t.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), r.Interval)
+		r.sync(ctx)
+		cancel()
+		select {
+		case <-r.stop:
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// Shutdown stops the registrar, withdraws the advertisements, and
+// deregisters the services from Consul.
+func (r *Registrar) Shutdown(ctx context.Context) error {
+	context.LoggerFrom(ctx).Info("Shutting down consul integration")
+	select {
+	case <-r.stop:
+		return nil
+	default:
+		close(r.stop)
+	}
+	if r.started.Load() {
+		select {
+		case <-r.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for _, svc := range r.Services {
+		if err := storage.WithdrawService(ctx, r.Storage, svc.Name, r.NodeID); err != nil {
+			r.log.Warn("Failed to withdraw service advertisement", slog.String("service", svc.Name), slog.String("error", err.Error()))
+		}
+		if _, ok := r.registered[svc.Name]; !ok {
+			continue
+		}
+		if err := r.call(ctx, http.MethodPut, "/v1/agent/service/deregister/"+url.PathEscape(r.serviceID(svc)), nil); err != nil {
+			r.log.Warn("Failed to deregister service from consul", slog.String("service", svc.Name), slog.String("error", err.Error()))
+		}
+	}
+	return nil
+}
+
+func (r *Registrar) sync(ctx context.Context) {
+	addr := r.meshAddress()
+	if !addr.IsValid() {
+		r.log.Debug("Mesh address not yet assigned, skipping consul sync")
+		return
+	}
+	for _, svc := range r.Services {
+		log := r.log.With(slog.String("service", svc.Name))
+		if r.registered[svc.Name] != addr {
+			if err := r.register(ctx, svc, addr); err != nil {
+				log.Warn("Failed to register service with consul", slog.String("error", err.Error()))
+				continue
+			}
+			r.registered[svc.Name] = addr
+			log.Info("Registered service with consul", slog.String("address", addr.String()))
+		}
+		status, err := r.health(ctx, svc)
+		if err != nil {
+			log.Warn("Failed to get service health from consul", slog.String("error", err.Error()))
+			// The registration may have been lost with an agent restart.
+			delete(r.registered, svc.Name)
+			continue
+		}
+		if status == StatusCritical {
+			log.Debug("Service is critical, withdrawing advertisement")
+			if err := storage.WithdrawService(ctx, r.Storage, svc.Name, r.NodeID); err != nil {
+				log.Warn("Failed to withdraw service advertisement", slog.String("error", err.Error()))
+			}
+			continue
+		}
+		if err := storage.AdvertiseService(ctx, r.Storage, svc.Name, r.NodeID, 3*r.Interval); err != nil {
+			log.Warn("Failed to advertise service", slog.String("error", err.Error()))
+		}
+	}
+}
+
+type agentService struct {
+	ID      string
+	Name    string
+	Address string
+	Port    int
+	Tags    []string          `json:",omitempty"`
+	Meta    map[string]string `json:",omitempty"`
+	Check   *agentCheck       `json:",omitempty"`
+}
+
+type agentCheck struct {
+	TCP                            string
+	Interval                       string
+	DeregisterCriticalServiceAfter string `json:",omitempty"`
+}
+
+func (r *Registrar) register(ctx context.Context, svc Service, addr netip.Addr) error {
+	return r.call(ctx, http.MethodPut, "/v1/agent/service/register", agentService{
+		ID:      r.serviceID(svc),
+		Name:    svc.Name,
+		Address: addr.String(),
+		Port:    svc.Port,
+		Tags:    svc.Tags,
+		Meta:    map[string]string{NodeMetaKey: r.NodeID.String()},
+		Check: &agentCheck{
+			TCP:      netip.AddrPortFrom(addr, uint16(svc.Port)).String(),
+			Interval: r.Interval.String(),
+		},
+	})
+}
+
+// health returns the aggregated status of the service on the local agent.
+// The agent answers with a non-200 status for services that are not passing.
+func (r *Registrar) health(ctx context.Context, svc Service) (string, error) {
+	err := r.call(ctx, http.MethodGet, "/v1/agent/health/service/id/"+url.PathEscape(r.serviceID(svc))+"?format=text", nil)
+	var statusErr *agentStatusError
+	switch {
+	case err == nil:
+		return StatusPassing, nil
+	case errors.As(err, &statusErr) && statusErr.code == http.StatusTooManyRequests:
+		return StatusWarning, nil
+	case errors.As(err, &statusErr) && statusErr.code == http.StatusServiceUnavailable:
+		return StatusCritical, nil
+	}
+	return "", err
+}
+
+func (r *Registrar) serviceID(svc Service) string {
+	return "webmesh-" + r.NodeID.String() + "-" + svc.Name
+}
+
+// meshAddress returns the address services are registered with. IPv4 is
+// preferred since it is what most service consumers expect.
+func (r *Registrar) meshAddress() netip.Addr {
+	wg := r.Network.WireGuard()
+	if wg == nil {
+		return netip.Addr{}
+	}
+	if addr := wg.AddressV4(); addr.IsValid() {
+		return addr.Addr()
+	}
+	if addr := wg.AddressV6(); addr.IsValid() {
+		return addr.Addr()
+	}
+	return netip.Addr{}
+}
+
+type agentStatusError struct {
+	code int
+	msg  string
+}
+
+func (e *agentStatusError) Error() string {
+	return fmt.Sprintf("consul agent returned %d: %s", e.code, e.msg)
+}
+
+func (r *Registrar) call(ctx context.Context, method, path string, body any) error {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		if err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(r.Address, "/")+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if r.Token != "" {
+		req.Header.Set("X-Consul-Token", r.Token)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return &agentStatusError{code: resp.StatusCode, msg: strings.TrimSpace(string(msg))}
+	}
+	return nil
+}
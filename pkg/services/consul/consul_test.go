@@ -0,0 +1,159 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package consul
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/testutil"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestParseService(t *testing.T) {
+	t.Parallel()
+	svc, err := ParseService("web=8080,primary,v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if svc.Name != "web" || svc.Port != 8080 || !slices.Equal(svc.Tags, []string{"primary", "v1"}) {
+		t.Fatalf("unexpected service: %+v", svc)
+	}
+	for _, invalid := range []string{"web", "=80", "web=", "web=http", "web=70000", "we/b=80"} {
+		if _, err := ParseService(invalid); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestRegistrar(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	var (
+		mu           sync.Mutex
+		registered   agentService
+		deregistered string
+		status       atomic.Int32
+	)
+	status.Store(http.StatusOK)
+	agent := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/v1/agent/service/register":
+			if err := json.NewDecoder(r.Body).Decode(&registered); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+		case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+			deregistered = strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+		case strings.HasPrefix(r.URL.Path, "/v1/agent/health/service/id/"):
+			w.WriteHeader(int(status.Load()))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer agent.Close()
+
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+	// Only registered nodes are listed as providers.
+	err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: "node-a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	network := testutil.NewManagerWithDB(db, meshnet.Options{}, "node-a")
+	err = network.Start(ctx, meshnet.StartOptions{
+		Key:       crypto.MustGenerateKey(),
+		AddressV4: netip.MustParsePrefix("172.16.0.1/32"),
+		NetworkV4: netip.MustParsePrefix("172.16.0.0/12"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := New(ctx, Options{
+		Address:  agent.URL,
+		Services: []Service{{Name: "web", Port: 8080}},
+		NodeID:   "node-a",
+		Network:  network,
+		Storage:  st,
+	})
+	providers := func() []string {
+		t.Helper()
+		ids, err := storage.ListServiceProviders(ctx, st, "web")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, id := range ids {
+			out = append(out, id.String())
+		}
+		return out
+	}
+
+	r.sync(ctx)
+	mu.Lock()
+	if registered.Address != "172.16.0.1" || registered.Port != 8080 || registered.Meta[NodeMetaKey] != "node-a" {
+		t.Fatalf("unexpected registration: %+v", registered)
+	}
+	if registered.Check == nil || registered.Check.TCP != "172.16.0.1:8080" {
+		t.Fatalf("unexpected check: %+v", registered.Check)
+	}
+	mu.Unlock()
+	if got := providers(); !slices.Equal(got, []string{"node-a"}) {
+		t.Fatalf("expected healthy service to be advertised, got %v", got)
+	}
+
+	status.Store(http.StatusServiceUnavailable)
+	r.sync(ctx)
+	if got := providers(); len(got) != 0 {
+		t.Fatalf("expected critical service to be withdrawn, got %v", got)
+	}
+
+	status.Store(http.StatusTooManyRequests)
+	r.sync(ctx)
+	if got := providers(); !slices.Equal(got, []string{"node-a"}) {
+		t.Fatalf("expected warning service to be advertised, got %v", got)
+	}
+
+	if err := r.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if deregistered != "webmesh-node-a-web" {
+		t.Fatalf("expected service to be deregistered, got %q", deregistered)
+	}
+	if got := providers(); len(got) != 0 {
+		t.Fatalf("expected advertisement to be withdrawn on shutdown, got %v", got)
+	}
+}
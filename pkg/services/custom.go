@@ -0,0 +1,126 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultServiceAdvertisementTTL is the default TTL for custom service advertisements.
+// Advertisements are refreshed at half this interval while the node is running.
+const DefaultServiceAdvertisementTTL = time.Minute
+
+// CustomService is a user-defined gRPC service served on the node's server
+// alongside the mesh APIs. It shares the server's listeners, TLS configuration,
+// and interceptors, including authentication.
+type CustomService struct {
+	// Desc is the service descriptor, usually the generated _ServiceDesc.
+	Desc *grpc.ServiceDesc
+	// Impl is the service implementation.
+	Impl any
+	// Advertise advertises the service in the mesh so other members can
+	// discover it with storage.ListServiceProviders.
+	Advertise bool
+}
+
+// ServiceAdvertiser is a MeshServer that keeps advertisements for custom
+// services up to date in mesh storage and withdraws them on shutdown.
+type ServiceAdvertiser struct {
+	storage  storage.MeshStorage
+	nodeID   types.NodeID
+	services []string
+	ttl      time.Duration
+	log      *slog.Logger
+	started  atomic.Bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewServiceAdvertiser returns a new ServiceAdvertiser for the given services.
+// If ttl is zero, DefaultServiceAdvertisementTTL is used.
+func NewServiceAdvertiser(ctx context.Context, st storage.MeshStorage, nodeID types.NodeID, services []string, ttl time.Duration) *ServiceAdvertiser {
+	if ttl <= 0 {
+		ttl = DefaultServiceAdvertisementTTL
+	}
+	return &ServiceAdvertiser{
+		storage:  st,
+		nodeID:   nodeID,
+		services: services,
+		ttl:      ttl,
+		log:      context.LoggerFrom(ctx).With("component", "service-advertiser"),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// ListenAndServe advertises the services and refreshes the advertisements
+// until Shutdown is called.
+func (s *ServiceAdvertiser) ListenAndServe() error {
+	s.started.Store(true)
+	defer close(s.done)
+	t := time.NewTicker(s.ttl / 2)
+	defer t.Stop()
+	for {
+		s.advertise()
+		select {
+		case <-s.stop:
+			return nil
+		case <-t.C:
+		}
+	}
+}
+
+// Shutdown stops refreshing and withdraws the advertisements.
+func (s *ServiceAdvertiser) Shutdown(ctx context.Context) error {
+	select {
+	case <-s.stop:
+		return nil
+	default:
+		close(s.stop)
+	}
+	if s.started.Load() {
+		select {
+		case <-s.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for _, service := range s.services {
+		if err := storage.WithdrawService(ctx, s.storage, service, s.nodeID); err != nil {
+			s.log.Warn("Failed to withdraw service advertisement", slog.String("service", service), slog.String("error", err.Error()))
+		}
+	}
+	return nil
+}
+
+func (s *ServiceAdvertiser) advertise() {
+	ctx, cancel := context.WithTimeout(context.Background(), s.ttl/2)
+	defer cancel()
+	for _, service := range s.services {
+		if err := storage.AdvertiseService(ctx, s.storage, service, s.nodeID, s.ttl); err != nil {
+			s.log.Warn("Failed to advertise service", slog.String("service", service), slog.String("error", err.Error()))
+		}
+	}
+}
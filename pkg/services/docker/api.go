@@ -0,0 +1,149 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+// Types of the Docker plugin API used by the network and IPAM drivers.
+// See https://github.com/moby/libnetwork/blob/master/docs/remote.md and
+// https://github.com/moby/libnetwork/blob/master/docs/ipam.md.
+
+type activateResponse struct {
+	Implements []string
+}
+
+type errorResponse struct {
+	Err string
+}
+
+type capabilitiesResponse struct {
+	Scope             string
+	ConnectivityScope string
+}
+
+type ipamData struct {
+	AddressSpace string
+	Pool         string
+	Gateway      string
+	AuxAddresses map[string]string
+}
+
+type createNetworkRequest struct {
+	NetworkID string
+	Options   map[string]any
+	IPv4Data  []ipamData
+	IPv6Data  []ipamData
+}
+
+type networkRequest struct {
+	NetworkID string
+}
+
+type endpointInterface struct {
+	Address     string
+	AddressIPv6 string
+	MacAddress  string
+}
+
+type createEndpointRequest struct {
+	NetworkID  string
+	EndpointID string
+	Interface  *endpointInterface
+	Options    map[string]any
+}
+
+type createEndpointResponse struct {
+	Interface *endpointInterface
+}
+
+type endpointRequest struct {
+	NetworkID  string
+	EndpointID string
+}
+
+type endpointInfoResponse struct {
+	Value map[string]any
+}
+
+type joinRequest struct {
+	NetworkID  string
+	EndpointID string
+	SandboxKey string
+	Options    map[string]any
+}
+
+type interfaceName struct {
+	SrcName   string
+	DstPrefix string
+}
+
+type staticRoute struct {
+	Destination string
+	RouteType   int
+	NextHop     string
+}
+
+type joinResponse struct {
+	InterfaceName         interfaceName
+	Gateway               string
+	GatewayIPv6           string
+	StaticRoutes          []staticRoute
+	DisableGatewayService bool
+}
+
+type ipamCapabilitiesResponse struct {
+	RequiresMACAddress bool
+}
+
+type addressSpacesResponse struct {
+	LocalDefaultAddressSpace  string
+	GlobalDefaultAddressSpace string
+}
+
+type requestPoolRequest struct {
+	AddressSpace string
+	Pool         string
+	SubPool      string
+	Options      map[string]string
+	V6           bool
+}
+
+type requestPoolResponse struct {
+	PoolID string
+	Pool   string
+	Data   map[string]string
+}
+
+type releasePoolRequest struct {
+	PoolID string
+}
+
+type requestAddressRequest struct {
+	PoolID  string
+	Address string
+	Options map[string]string
+}
+
+type requestAddressResponse struct {
+	Address string
+	Data    map[string]string
+}
+
+type releaseAddressRequest struct {
+	PoolID  string
+	Address string
+}
+
+type empty struct{}
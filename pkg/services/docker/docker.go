@@ -0,0 +1,182 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package docker contains a Docker network and IPAM plugin that attaches
+// containers directly to the mesh.
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/attach"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultSocket is the default socket for the plugin. Docker discovers
+// plugins by the sockets placed in this directory, so the driver name
+// of the plugin is webmesh.
+const DefaultSocket = "/run/docker/plugins/webmesh.sock"
+
+// DefaultDockerSocket is the default socket of the Docker engine.
+const DefaultDockerSocket = "/var/run/docker.sock"
+
+// TagsOption is the network option, endpoint driver option, or container
+// label holding a comma separated list of tags to apply to the attachments
+// of containers. Tags can be referenced in network ACLs with a tag: prefix.
+const TagsOption = "webmesh.io/tags"
+
+// AddressSpace is the address space of the IPAM driver.
+const AddressSpace = "webmesh"
+
+const (
+	pluginContentType = "application/vnd.docker.plugins.v1.2+json"
+	genericOption     = "com.docker.network.generic"
+	gatewayOption     = "com.docker.network.gateway"
+	addressTypeOption = "RequestAddressType"
+)
+
+// Options are the options for the Docker plugin.
+type Options struct {
+	// Socket is the path to serve the plugin API on.
+	Socket string
+	// DockerSocket is the socket of the Docker engine. It is used to look up
+	// the labels of containers joining a network. If empty, container labels
+	// are not used for tagging.
+	DockerSocket string
+	// NodeID is the ID of this node.
+	NodeID types.NodeID
+	// Network is the network manager of this node.
+	Network meshnet.Manager
+	// Allocator allocates attachments for containers.
+	Allocator *attach.Allocator
+}
+
+// Server serves the Docker plugin API.
+type Server struct {
+	Options
+	srv      *http.Server
+	log      *slog.Logger
+	networks map[string][]string
+	mu       sync.Mutex
+}
+
+// New returns a new Docker plugin server.
+func New(ctx context.Context, o Options) *Server {
+	s := &Server{
+		Options:  o,
+		log:      context.LoggerFrom(ctx).With("component", "docker-plugin"),
+		networks: make(map[string][]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/Plugin.Activate", handle(s, func(context.Context, empty) (any, error) {
+		return activateResponse{Implements: []string{"NetworkDriver", "IpamDriver"}}, nil
+	}))
+	mux.HandleFunc("/NetworkDriver.GetCapabilities", handle(s, s.networkCapabilities))
+	mux.HandleFunc("/NetworkDriver.CreateNetwork", handle(s, s.createNetwork))
+	mux.HandleFunc("/NetworkDriver.DeleteNetwork", handle(s, s.deleteNetwork))
+	mux.HandleFunc("/NetworkDriver.CreateEndpoint", handle(s, s.createEndpoint))
+	mux.HandleFunc("/NetworkDriver.DeleteEndpoint", handle(s, s.deleteEndpoint))
+	mux.HandleFunc("/NetworkDriver.EndpointOperInfo", handle(s, s.endpointInfo))
+	mux.HandleFunc("/NetworkDriver.Join", handle(s, s.join))
+	mux.HandleFunc("/NetworkDriver.Leave", handle(s, s.leave))
+	for _, noop := range []string{
+		"/NetworkDriver.AllocateNetwork",
+		"/NetworkDriver.FreeNetwork",
+		"/NetworkDriver.DiscoverNew",
+		"/NetworkDriver.DiscoverDelete",
+		"/NetworkDriver.ProgramExternalConnectivity",
+		"/NetworkDriver.RevokeExternalConnectivity",
+	} {
+		mux.HandleFunc(noop, handle(s, func(context.Context, empty) (any, error) {
+			return empty{}, nil
+		}))
+	}
+	mux.HandleFunc("/IpamDriver.GetCapabilities", handle(s, func(context.Context, empty) (any, error) {
+		return ipamCapabilitiesResponse{}, nil
+	}))
+	mux.HandleFunc("/IpamDriver.GetDefaultAddressSpaces", handle(s, func(context.Context, empty) (any, error) {
+		return addressSpacesResponse{LocalDefaultAddressSpace: AddressSpace, GlobalDefaultAddressSpace: AddressSpace}, nil
+	}))
+	mux.HandleFunc("/IpamDriver.RequestPool", handle(s, s.requestPool))
+	mux.HandleFunc("/IpamDriver.ReleasePool", handle(s, s.releasePool))
+	mux.HandleFunc("/IpamDriver.RequestAddress", handle(s, s.requestAddress))
+	mux.HandleFunc("/IpamDriver.ReleaseAddress", handle(s, s.releaseAddress))
+	s.srv = &http.Server{Handler: mux}
+	return s
+}
+
+// ListenAndServe starts the server and blocks until the server exits.
+func (s *Server) ListenAndServe() error {
+	s.log.Info("Starting docker plugin server", slog.String("socket", s.Socket))
+	if err := os.MkdirAll(filepath.Dir(s.Socket), 0755); err != nil {
+		return fmt.Errorf("create plugin directory: %w", err)
+	}
+	if err := os.Remove(s.Socket); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove stale plugin socket: %w", err)
+	}
+	ln, err := net.Listen("unix", s.Socket)
+	if err != nil {
+		return fmt.Errorf("listen on plugin socket: %w", err)
+	}
+	if err := s.srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown attempts to stop the server gracefully.
+func (s *Server) Shutdown(ctx context.Context) error {
+	context.LoggerFrom(ctx).Info("Shutting down docker plugin server")
+	defer os.Remove(s.Socket)
+	return s.srv.Shutdown(ctx)
+}
+
+// handle decodes plugin requests into T and encodes the response or error
+// returned by fn.
+func handle[T any](s *Server, fn func(context.Context, T) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", pluginContentType)
+		var req T
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+				w.WriteHeader(http.StatusBadRequest)
+				_ = json.NewEncoder(w).Encode(errorResponse{Err: fmt.Sprintf("decode request: %v", err)})
+				return
+			}
+		}
+		ctx := context.WithLogger(r.Context(), s.log)
+		resp, err := fn(ctx, req)
+		if err != nil {
+			s.log.Error("Docker plugin request failed", slog.String("path", r.URL.Path), slog.String("error", err.Error()))
+			w.WriteHeader(http.StatusInternalServerError)
+			_ = json.NewEncoder(w).Encode(errorResponse{Err: err.Error()})
+			return
+		}
+		if err := json.NewEncoder(w).Encode(resp); err != nil {
+			s.log.Error("Failed to write docker plugin response", slog.String("path", r.URL.Path), slog.String("error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/attach"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/testutil"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+type testProvider struct {
+	storage.Provider
+	st storage.MeshStorage
+	db storage.MeshDB
+}
+
+func (p testProvider) MeshStorage() storage.MeshStorage { return p.st }
+
+func (p testProvider) MeshDB() storage.MeshDB { return p.db }
+
+func TestPlugin(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+	network := testutil.NewManagerWithDB(db, meshnet.Options{}, "node-a")
+	err := network.Start(ctx, meshnet.StartOptions{
+		Key:       crypto.MustGenerateKey(),
+		AddressV6: netip.MustParsePrefix("fd00:1:2:3:4:5:6:0/112"),
+		NetworkV6: netip.MustParsePrefix("fd00:1:2::/48"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := New(ctx, Options{
+		NodeID:  "node-a",
+		Network: network,
+		Allocator: attach.NewAllocator(attach.Options{
+			NodeID:  "node-a",
+			Storage: testProvider{st: st, db: db},
+			Network: network,
+		}),
+	})
+	handler := srv.srv.Handler
+
+	call := func(t *testing.T, path string, req, resp any) int {
+		t.Helper()
+		body, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body)))
+		if resp != nil {
+			if err := json.Unmarshal(rec.Body.Bytes(), resp); err != nil {
+				t.Fatalf("decode response from %s: %v", path, err)
+			}
+		}
+		return rec.Code
+	}
+
+	var activate activateResponse
+	if code := call(t, "/Plugin.Activate", empty{}, &activate); code != http.StatusOK {
+		t.Fatalf("unexpected status activating plugin: %d", code)
+	}
+	if !slices.Equal(activate.Implements, []string{"NetworkDriver", "IpamDriver"}) {
+		t.Fatalf("unexpected implements: %v", activate.Implements)
+	}
+
+	var pool requestPoolResponse
+	if code := call(t, "/IpamDriver.RequestPool", requestPoolRequest{AddressSpace: AddressSpace, V6: true}, &pool); code != http.StatusOK {
+		t.Fatalf("unexpected status requesting pool: %d", code)
+	}
+	if pool.PoolID != poolV6 || pool.Pool != "fd00:1:2:3:4:5:6:0/112" {
+		t.Fatalf("unexpected pool: %+v", pool)
+	}
+	if code := call(t, "/IpamDriver.RequestPool", requestPoolRequest{AddressSpace: AddressSpace}, nil); code == http.StatusOK {
+		t.Fatal("expected error requesting an ipv4 pool without ipv4")
+	}
+
+	var addr requestAddressResponse
+	if code := call(t, "/IpamDriver.RequestAddress", requestAddressRequest{PoolID: poolV6}, &addr); code != http.StatusOK {
+		t.Fatalf("unexpected status requesting address: %d", code)
+	}
+	prefix := netip.MustParsePrefix(addr.Address)
+	if !netip.MustParsePrefix(pool.Pool).Contains(prefix.Addr()) || prefix.Bits() != 128 {
+		t.Fatalf("unexpected address %s", addr.Address)
+	}
+
+	var errResp errorResponse
+	if code := call(t, "/NetworkDriver.CreateNetwork", createNetworkRequest{
+		NetworkID: "other",
+		IPv4Data:  []ipamData{{AddressSpace: "LocalDefault", Pool: "172.17.0.0/16"}},
+	}, &errResp); code == http.StatusOK || errResp.Err == "" {
+		t.Fatal("expected error creating a network with another ipam driver")
+	}
+	if code := call(t, "/NetworkDriver.CreateNetwork", createNetworkRequest{
+		NetworkID: "net",
+		Options:   map[string]any{genericOption: map[string]any{TagsOption: "mesh"}},
+		IPv6Data:  []ipamData{{AddressSpace: AddressSpace, Pool: pool.Pool}},
+	}, nil); code != http.StatusOK {
+		t.Fatalf("unexpected status creating network: %d", code)
+	}
+	if code := call(t, "/NetworkDriver.CreateEndpoint", createEndpointRequest{
+		NetworkID:  "net",
+		EndpointID: "ep1",
+		Interface:  &endpointInterface{AddressIPv6: addr.Address},
+		Options:    map[string]any{TagsOption: "web, db"},
+	}, nil); code != http.StatusOK {
+		t.Fatalf("unexpected status creating endpoint: %d", code)
+	}
+	attachments, err := srv.endpointAttachments(ctx, "ep1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 1 || !slices.Equal(attachments[0].Tags, []string{"mesh", "web", "db"}) {
+		t.Fatalf("unexpected endpoint attachments: %+v", attachments)
+	}
+	routes, err := db.Networking().GetRoutesByNode(ctx, "node-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 3 {
+		t.Fatalf("expected a route per tag, got %v", routes)
+	}
+
+	if code := call(t, "/IpamDriver.ReleaseAddress", releaseAddressRequest{PoolID: poolV6, Address: prefix.Addr().String()}, nil); code != http.StatusOK {
+		t.Fatalf("unexpected status releasing address: %d", code)
+	}
+	attachments, err = srv.Allocator.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attachments) != 0 {
+		t.Fatalf("expected no attachments after release, got %+v", attachments)
+	}
+	routes, err = db.Networking().GetRoutesByNode(ctx, "node-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(routes) != 0 {
+		t.Fatalf("expected attachment routes to be removed, got %v", routes)
+	}
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/netip"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/attach"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+const (
+	poolV4 = "webmesh-v4"
+	poolV6 = "webmesh-v6"
+)
+
+// requestPool hands out the IPv4 network of the mesh and the IPv6 prefix of this
+// node. Containers receive host addresses out of these pools and reach the rest
+// of the mesh through a link-local gateway on the host.
+func (s *Server) requestPool(ctx context.Context, req requestPoolRequest) (any, error) {
+	if req.SubPool != "" {
+		return nil, fmt.Errorf("sub pools are not supported by the webmesh ipam driver")
+	}
+	resp := requestPoolResponse{PoolID: poolV4, Data: map[string]string{gatewayOption: attach.GatewayV4.String()}}
+	pool := s.Network.NetworkV4()
+	if req.V6 {
+		resp = requestPoolResponse{PoolID: poolV6, Data: map[string]string{gatewayOption: netip.PrefixFrom(attach.GatewayV6.Addr(), 128).String()}}
+		if wg := s.Network.WireGuard(); wg != nil {
+			pool = wg.AddressV6().Masked()
+		} else {
+			pool = netip.Prefix{}
+		}
+	}
+	if !pool.IsValid() {
+		return nil, fmt.Errorf("no mesh pool is available for the requested address family")
+	}
+	if req.Pool != "" && req.Pool != pool.String() {
+		return nil, fmt.Errorf("requested pool %s does not match the mesh pool %s", req.Pool, pool)
+	}
+	resp.Pool = pool.String()
+	return resp, nil
+}
+
+func (s *Server) releasePool(ctx context.Context, req releasePoolRequest) (any, error) {
+	return empty{}, nil
+}
+
+// requestAddress allocates an attachment for a new endpoint. Docker does not
+// say which endpoint the address is for, so the attachment is claimed by the
+// endpoint when it is created.
+func (s *Server) requestAddress(ctx context.Context, req requestAddressRequest) (any, error) {
+	if req.Options[addressTypeOption] == gatewayOption {
+		if req.PoolID == poolV6 {
+			return requestAddressResponse{Address: netip.PrefixFrom(attach.GatewayV6.Addr(), 128).String()}, nil
+		}
+		return requestAddressResponse{Address: attach.GatewayV4.String()}, nil
+	}
+	if req.Address != "" {
+		return nil, fmt.Errorf("static addresses are not supported by the webmesh ipam driver")
+	}
+	var id [6]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return nil, fmt.Errorf("generate attachment id: %w", err)
+	}
+	att, err := s.Allocator.Allocate(ctx, attach.Request{
+		ID:    "docker-" + hex.EncodeToString(id[:]),
+		Owner: ownerIPAM,
+		IPv4:  req.PoolID == poolV4,
+		IPv6:  req.PoolID == poolV6,
+	})
+	if err != nil {
+		return nil, err
+	}
+	addr := att.AddressV4
+	if req.PoolID == poolV6 {
+		addr = att.AddressV6
+	}
+	return requestAddressResponse{Address: addr.String()}, nil
+}
+
+func (s *Server) releaseAddress(ctx context.Context, req releaseAddressRequest) (any, error) {
+	addr, err := netip.ParseAddr(req.Address)
+	if err != nil {
+		return nil, fmt.Errorf("parse address: %w", err)
+	}
+	att, ok, err := s.lookupAddress(ctx, addr)
+	if err != nil || !ok {
+		return empty{}, err
+	}
+	return empty{}, s.Allocator.Release(ctx, att.ID)
+}
+
+func (s *Server) lookupAddress(ctx context.Context, addr netip.Addr) (storage.Attachment, bool, error) {
+	attachments, err := s.Allocator.List(ctx)
+	if err != nil {
+		return storage.Attachment{}, false, err
+	}
+	for _, att := range attachments {
+		if att.AddressV4.Addr() == addr || att.AddressV6.Addr() == addr {
+			return att, true, nil
+		}
+	}
+	return storage.Attachment{}, false, nil
+}
@@ -0,0 +1,114 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// labelLookupTimeout is how long to wait for a joining container to show up
+// in the Docker engine.
+const labelLookupTimeout = 30 * time.Second
+
+// tagFromLabels looks up the container behind an endpoint in the Docker engine
+// and adds the tags in its labels to the attachments of the endpoint. Docker
+// does not pass container labels to network drivers, and the container is only
+// listed on the network after the join completes, so this runs in the background.
+func (s *Server) tagFromLabels(ctx context.Context, networkID, endpointID string) {
+	ctx, cancel := context.WithTimeout(ctx, labelLookupTimeout)
+	defer cancel()
+	log := context.LoggerFrom(ctx).With(slog.String("endpoint", endpointID))
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", s.DockerSocket)
+			},
+		},
+	}
+	defer client.CloseIdleConnections()
+	var containerID string
+	for containerID == "" {
+		var network struct {
+			Containers map[string]struct{ EndpointID string }
+		}
+		if err := getJSON(ctx, client, "/networks/"+url.PathEscape(networkID), &network); err != nil {
+			log.Debug("Failed to inspect docker network", slog.String("error", err.Error()))
+		}
+		for id, c := range network.Containers {
+			if c.EndpointID == endpointID {
+				containerID = id
+			}
+		}
+		if containerID != "" {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			log.Debug("Gave up looking up container for endpoint")
+			return
+		case <-time.After(time.Second):
+		}
+	}
+	var container struct {
+		Config struct{ Labels map[string]string }
+	}
+	if err := getJSON(ctx, client, "/containers/"+url.PathEscape(containerID)+"/json", &container); err != nil {
+		log.Warn("Failed to inspect docker container", slog.String("container", containerID), slog.String("error", err.Error()))
+		return
+	}
+	tags := splitTags(container.Config.Labels[TagsOption])
+	if len(tags) == 0 {
+		return
+	}
+	attachments, err := s.endpointAttachments(ctx, endpointID)
+	if err != nil {
+		log.Warn("Failed to list endpoint attachments", slog.String("error", err.Error()))
+		return
+	}
+	for _, att := range attachments {
+		if err := s.claim(ctx, att, endpointID, tags); err != nil {
+			log.Warn("Failed to tag attachment from container labels", slog.String("error", err.Error()))
+			continue
+		}
+		log.Info("Tagged attachment from container labels", slog.String("attachment", att.ID), slog.Any("tags", tags))
+	}
+}
+
+func getJSON(ctx context.Context, client *http.Client, path string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker"+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -0,0 +1,233 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package docker
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/attach"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system/link"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+const (
+	// ownerIPAM owns attachments that have not been claimed by an endpoint.
+	ownerIPAM = "docker"
+	// ownerPrefix prefixes the endpoint ID in the owner of claimed attachments.
+	ownerPrefix = "docker:"
+	// containerIfPrefix is the prefix of interface names in containers.
+	containerIfPrefix = "eth"
+)
+
+func (s *Server) networkCapabilities(ctx context.Context, _ empty) (any, error) {
+	return capabilitiesResponse{Scope: "local", ConnectivityScope: "global"}, nil
+}
+
+func (s *Server) createNetwork(ctx context.Context, req createNetworkRequest) (any, error) {
+	for _, data := range append(req.IPv4Data, req.IPv6Data...) {
+		if data.AddressSpace != AddressSpace {
+			return nil, fmt.Errorf("webmesh networks must use the webmesh ipam driver (--ipam-driver webmesh)")
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.networks[req.NetworkID] = tagsFrom(req.Options)
+	context.LoggerFrom(ctx).Info("Created docker network", slog.String("network", req.NetworkID), slog.Any("tags", s.networks[req.NetworkID]))
+	return empty{}, nil
+}
+
+func (s *Server) deleteNetwork(ctx context.Context, req networkRequest) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.networks, req.NetworkID)
+	return empty{}, nil
+}
+
+// createEndpoint claims the attachments allocated by the IPAM driver for the
+// addresses of the endpoint and tags them.
+func (s *Server) createEndpoint(ctx context.Context, req createEndpointRequest) (any, error) {
+	if req.Interface == nil {
+		return nil, fmt.Errorf("endpoint %s has no addresses from the webmesh ipam driver", req.EndpointID)
+	}
+	s.mu.Lock()
+	tags := append(slices.Clone(s.networks[req.NetworkID]), tagsFrom(req.Options)...)
+	s.mu.Unlock()
+	var claimed int
+	for _, address := range []string{req.Interface.Address, req.Interface.AddressIPv6} {
+		if address == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			return nil, fmt.Errorf("parse endpoint address: %w", err)
+		}
+		att, ok, err := s.lookupAddress(ctx, prefix.Addr())
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("address %s was not allocated by the webmesh ipam driver", prefix.Addr())
+		}
+		if err := s.claim(ctx, att, req.EndpointID, tags); err != nil {
+			return nil, err
+		}
+		claimed++
+	}
+	if claimed == 0 {
+		return nil, fmt.Errorf("endpoint %s has no addresses from the webmesh ipam driver", req.EndpointID)
+	}
+	return createEndpointResponse{}, nil
+}
+
+func (s *Server) deleteEndpoint(ctx context.Context, req endpointRequest) (any, error) {
+	return empty{}, nil
+}
+
+func (s *Server) endpointInfo(ctx context.Context, req endpointRequest) (any, error) {
+	value := make(map[string]any)
+	attachments, err := s.endpointAttachments(ctx, req.EndpointID)
+	if err != nil {
+		return nil, err
+	}
+	for _, att := range attachments {
+		value[att.ID] = att.Tags
+	}
+	return endpointInfoResponse{Value: value}, nil
+}
+
+// join creates a veth pair for the endpoint and routes its addresses to the host
+// end. Docker moves the other end into the container.
+func (s *Server) join(ctx context.Context, req joinRequest) (any, error) {
+	attachments, err := s.endpointAttachments(ctx, req.EndpointID)
+	if err != nil {
+		return nil, err
+	}
+	if len(attachments) == 0 {
+		return nil, fmt.Errorf("no attachments found for endpoint %s", req.EndpointID)
+	}
+	hostName, peerName := vethNames(req.EndpointID)
+	if err := link.NewVeth(ctx, hostName, peerName); err != nil {
+		return nil, err
+	}
+	resp := joinResponse{
+		InterfaceName:         interfaceName{SrcName: peerName, DstPrefix: containerIfPrefix},
+		DisableGatewayService: true,
+	}
+	for _, att := range attachments {
+		if err := attach.ConfigureHostLink(ctx, hostName, att); err != nil {
+			_ = link.RemoveInterface(ctx, hostName)
+			return nil, err
+		}
+		if att.AddressV4.IsValid() && resp.Gateway == "" {
+			resp.Gateway = attach.GatewayV4.Addr().String()
+			resp.StaticRoutes = append(resp.StaticRoutes, staticRoute{Destination: attach.GatewayV4.String(), RouteType: 1})
+		}
+		if att.AddressV6.IsValid() {
+			resp.GatewayIPv6 = attach.GatewayV6.Addr().String()
+		}
+	}
+	if s.DockerSocket != "" {
+		go s.tagFromLabels(context.WithLogger(context.Background(), s.log), req.NetworkID, req.EndpointID)
+	}
+	return resp, nil
+}
+
+func (s *Server) leave(ctx context.Context, req endpointRequest) (any, error) {
+	hostName, _ := vethNames(req.EndpointID)
+	if err := link.RemoveInterface(ctx, hostName); err != nil && !errors.Is(err, link.ErrLinkNotExists) {
+		return nil, fmt.Errorf("remove veth: %w", err)
+	}
+	return empty{}, nil
+}
+
+func (s *Server) claim(ctx context.Context, att storage.Attachment, endpointID string, tags []string) error {
+	_, err := s.Allocator.Allocate(ctx, attach.Request{
+		ID:    att.ID,
+		Owner: ownerPrefix + endpointID,
+		Tags:  mergeTags(att.Tags, tags),
+	})
+	if err != nil {
+		return fmt.Errorf("claim attachment %s: %w", att.ID, err)
+	}
+	return nil
+}
+
+func (s *Server) endpointAttachments(ctx context.Context, endpointID string) ([]storage.Attachment, error) {
+	attachments, err := s.Allocator.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []storage.Attachment
+	for _, att := range attachments {
+		if att.Owner == ownerPrefix+endpointID {
+			out = append(out, att)
+		}
+	}
+	return out, nil
+}
+
+// vethNames returns the names of the host and container ends of the veth
+// pair for an endpoint. Both fit in the 15 character limit for interfaces.
+func vethNames(endpointID string) (host, peer string) {
+	id := endpointID
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return "wmh" + id, "wmc" + id
+}
+
+// tagsFrom returns the tags in the given plugin options. Options passed with
+// -o or --driver-opt are found either at the top level or in the generic
+// options depending on the request.
+func tagsFrom(opts map[string]any) []string {
+	var tags []string
+	if v, ok := opts[TagsOption].(string); ok {
+		tags = append(tags, splitTags(v)...)
+	}
+	if generic, ok := opts[genericOption].(map[string]any); ok {
+		if v, ok := generic[TagsOption].(string); ok {
+			tags = append(tags, splitTags(v)...)
+		}
+	}
+	return tags
+}
+
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func mergeTags(a, b []string) []string {
+	out := slices.Clone(a)
+	for _, tag := range b {
+		if !slices.Contains(out, tag) {
+			out = append(out, tag)
+		}
+	}
+	return out
+}
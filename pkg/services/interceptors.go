@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"google.golang.org/grpc"
+)
+
+// InterceptorPosition is the position in the server interceptor chain
+// at which custom interceptors are installed.
+type InterceptorPosition int
+
+const (
+	// BeforeAuth installs interceptors after the logging and metrics
+	// interceptors, but before any authentication plugins. The caller
+	// is not yet authenticated when they run.
+	BeforeAuth InterceptorPosition = iota
+	// AfterAuth installs interceptors after the authentication plugins
+	// and before requests are proxied to the leader. The authenticated
+	// caller is available via context.AuthenticatedCallerFrom.
+	AfterAuth
+)
+
+// Interceptors are custom gRPC interceptors registered by applications
+// embedding a node. They are inserted into the server interceptor chain
+// at the requested position.
+type Interceptors struct {
+	// UnaryBeforeAuth are unary interceptors run before authentication.
+	UnaryBeforeAuth []grpc.UnaryServerInterceptor
+	// StreamBeforeAuth are stream interceptors run before authentication.
+	StreamBeforeAuth []grpc.StreamServerInterceptor
+	// UnaryAfterAuth are unary interceptors run after authentication.
+	UnaryAfterAuth []grpc.UnaryServerInterceptor
+	// StreamAfterAuth are stream interceptors run after authentication.
+	StreamAfterAuth []grpc.StreamServerInterceptor
+}
+
+// AddUnary registers unary interceptors at the given position. They run
+// in the order they are added.
+func (i *Interceptors) AddUnary(pos InterceptorPosition, interceptors ...grpc.UnaryServerInterceptor) {
+	switch pos {
+	case AfterAuth:
+		i.UnaryAfterAuth = append(i.UnaryAfterAuth, interceptors...)
+	default:
+		i.UnaryBeforeAuth = append(i.UnaryBeforeAuth, interceptors...)
+	}
+}
+
+// AddStream registers stream interceptors at the given position. They run
+// in the order they are added.
+func (i *Interceptors) AddStream(pos InterceptorPosition, interceptors ...grpc.StreamServerInterceptor) {
+	switch pos {
+	case AfterAuth:
+		i.StreamAfterAuth = append(i.StreamAfterAuth, interceptors...)
+	default:
+		i.StreamBeforeAuth = append(i.StreamBeforeAuth, interceptors...)
+	}
+}
+
+// Merge appends the interceptors in other to i.
+func (i *Interceptors) Merge(other Interceptors) {
+	i.UnaryBeforeAuth = append(i.UnaryBeforeAuth, other.UnaryBeforeAuth...)
+	i.StreamBeforeAuth = append(i.StreamBeforeAuth, other.StreamBeforeAuth...)
+	i.UnaryAfterAuth = append(i.UnaryAfterAuth, other.UnaryAfterAuth...)
+	i.StreamAfterAuth = append(i.StreamAfterAuth, other.StreamAfterAuth...)
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestInterceptorsAdd(t *testing.T) {
+	var calls []string
+	unary := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			calls = append(calls, name)
+			return handler(ctx, req)
+		}
+	}
+	var i Interceptors
+	i.AddUnary(AfterAuth, unary("after"))
+	i.AddUnary(BeforeAuth, unary("before-1"), unary("before-2"))
+	i.AddStream(AfterAuth, func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, ss)
+	})
+	if len(i.UnaryBeforeAuth) != 2 || len(i.UnaryAfterAuth) != 1 {
+		t.Fatalf("unexpected unary interceptors: %d before, %d after", len(i.UnaryBeforeAuth), len(i.UnaryAfterAuth))
+	}
+	if len(i.StreamBeforeAuth) != 0 || len(i.StreamAfterAuth) != 1 {
+		t.Fatalf("unexpected stream interceptors: %d before, %d after", len(i.StreamBeforeAuth), len(i.StreamAfterAuth))
+	}
+	var merged Interceptors
+	merged.Merge(i)
+	merged.Merge(i)
+	if len(merged.UnaryBeforeAuth) != 4 || len(merged.StreamAfterAuth) != 2 {
+		t.Fatal("expected merged interceptors to be appended")
+	}
+	for _, interceptor := range i.UnaryBeforeAuth {
+		_, _ = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, func(context.Context, any) (any, error) { return nil, nil })
+	}
+	if len(calls) != 2 || calls[0] != "before-1" || calls[1] != "before-2" {
+		t.Fatalf("expected interceptors to keep registration order, got %v", calls)
+	}
+}
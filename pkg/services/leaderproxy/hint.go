@@ -0,0 +1,159 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leaderproxy
+
+import (
+	"log/slog"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+const (
+	// NotLeaderReason is the error reason set in the details of errors
+	// returned when a node rejects a request because it is not the leader.
+	NotLeaderReason = "NOT_LEADER"
+	// ErrorDomain is the domain set in webmesh error details.
+	ErrorDomain = "webmesh.io"
+	// LeaderIDDetail is the error detail key for the current leader's ID.
+	LeaderIDDetail = "leader-id"
+	// LeaderAddressDetail is the error detail key for the current leader's
+	// private gRPC address.
+	LeaderAddressDetail = "leader-address"
+	// LeaderPublicAddressDetail is the error detail key for the current
+	// leader's public gRPC address, if it has one.
+	LeaderPublicAddressDetail = "leader-public-address"
+)
+
+// LeaderHint is the leader information carried in a not-leader error.
+type LeaderHint struct {
+	// ID is the ID of the current leader.
+	ID string
+	// Address is the private gRPC address of the current leader.
+	Address string
+	// PublicAddress is the public gRPC address of the current leader.
+	PublicAddress string
+}
+
+// NewNotLeaderError returns a FailedPrecondition error with the given message
+// and, when the current leader is known, a hint for reaching it. Lookup
+// failures are logged and result in an error without a hint.
+func NewNotLeaderError(ctx context.Context, st storage.Provider, msg string) error {
+	stat := status.New(codes.FailedPrecondition, msg)
+	hint, err := lookupLeaderHint(ctx, st)
+	if err != nil {
+		context.LoggerFrom(ctx).Debug("Could not determine leader for not-leader hint", slog.String("error", err.Error()))
+		return stat.Err()
+	}
+	withDetails, err := stat.WithDetails(&errdetails.ErrorInfo{
+		Reason:   NotLeaderReason,
+		Domain:   ErrorDomain,
+		Metadata: hint.metadata(),
+	})
+	if err != nil {
+		return stat.Err()
+	}
+	return withDetails.Err()
+}
+
+// LeaderHintFromError returns the leader hint carried in the given error.
+// False is returned if the error is not a not-leader error or carries no hint.
+func LeaderHintFromError(err error) (LeaderHint, bool) {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.FailedPrecondition {
+		return LeaderHint{}, false
+	}
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.GetReason() != NotLeaderReason || info.GetDomain() != ErrorDomain {
+			continue
+		}
+		md := info.GetMetadata()
+		hint := LeaderHint{
+			ID:            md[LeaderIDDetail],
+			Address:       md[LeaderAddressDetail],
+			PublicAddress: md[LeaderPublicAddressDetail],
+		}
+		return hint, hint.ID != ""
+	}
+	return LeaderHint{}, false
+}
+
+// HintDialer dials the leader described by a hint.
+type HintDialer func(ctx context.Context, hint LeaderHint) (transport.RPCClientConn, error)
+
+// NewLeaderHintUnaryClientInterceptor returns a unary client interceptor that
+// retries a call once against the hinted leader when the server rejects it
+// as not the leader.
+func NewLeaderHintUnaryClientInterceptor(dial HintDialer) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		hint, ok := LeaderHintFromError(err)
+		if !ok {
+			return err
+		}
+		log := context.LoggerFrom(ctx)
+		log.Debug("Redirecting request to hinted leader", slog.String("method", method), slog.String("leader", hint.ID))
+		conn, dialErr := dial(ctx, hint)
+		if dialErr != nil {
+			log.Debug("Failed to dial hinted leader", slog.String("leader", hint.ID), slog.String("error", dialErr.Error()))
+			return err
+		}
+		defer conn.Close()
+		return conn.Invoke(ctx, method, req, reply, opts...)
+	}
+}
+
+func (h LeaderHint) metadata() map[string]string {
+	md := map[string]string{LeaderIDDetail: h.ID}
+	if h.Address != "" {
+		md[LeaderAddressDetail] = h.Address
+	}
+	if h.PublicAddress != "" {
+		md[LeaderPublicAddressDetail] = h.PublicAddress
+	}
+	return md
+}
+
+func lookupLeaderHint(ctx context.Context, st storage.Provider) (LeaderHint, error) {
+	leader, err := st.Consensus().GetLeader(ctx)
+	if err != nil {
+		return LeaderHint{}, err
+	}
+	hint := LeaderHint{ID: leader.GetId()}
+	node, err := st.MeshDB().Peers().Get(ctx, types.NodeID(leader.GetId()))
+	if err != nil {
+		// The ID alone is still useful to callers that can resolve it.
+		return hint, nil
+	}
+	if addr := node.PrivateRPCAddrV6(); addr.IsValid() {
+		hint.Address = addr.String()
+	} else if addr := node.PrivateRPCAddrV4(); addr.IsValid() {
+		hint.Address = addr.String()
+	}
+	if addr := node.PublicRPCAddr(); addr.IsValid() {
+		hint.PublicAddress = addr.String()
+	}
+	return hint, nil
+}
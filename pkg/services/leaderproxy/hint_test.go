@@ -0,0 +1,114 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leaderproxy
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+)
+
+func TestLeaderHintFromError(t *testing.T) {
+	t.Parallel()
+	hint := LeaderHint{ID: "node-b", Address: "[fd00::2]:8443", PublicAddress: "10.0.0.2:8443"}
+	st, err := status.New(codes.FailedPrecondition, "not leader").WithDetails(&errdetails.ErrorInfo{
+		Reason:   NotLeaderReason,
+		Domain:   ErrorDomain,
+		Metadata: hint.metadata(),
+	})
+	if err != nil {
+		t.Fatalf("build status: %v", err)
+	}
+	got, ok := LeaderHintFromError(st.Err())
+	if !ok {
+		t.Fatal("expected a leader hint")
+	}
+	if got != hint {
+		t.Fatalf("expected hint %+v, got %+v", hint, got)
+	}
+	for _, err := range []error{
+		nil,
+		errors.New("not leader"),
+		status.Error(codes.FailedPrecondition, "not leader"),
+		status.Error(codes.Unavailable, "unavailable"),
+	} {
+		if _, ok := LeaderHintFromError(err); ok {
+			t.Fatalf("expected no hint from %v", err)
+		}
+	}
+}
+
+type fakeConn struct {
+	transport.RPCClientConn
+	invoked string
+	closed  bool
+}
+
+func (f *fakeConn) Invoke(ctx context.Context, method string, args any, reply any, opts ...grpc.CallOption) error {
+	f.invoked = method
+	return nil
+}
+
+func (f *fakeConn) Close() error {
+	f.closed = true
+	return nil
+}
+
+func TestLeaderHintUnaryClientInterceptor(t *testing.T) {
+	t.Parallel()
+	hint := LeaderHint{ID: "node-b", Address: "[fd00::2]:8443"}
+	st, _ := status.New(codes.FailedPrecondition, "not leader").WithDetails(&errdetails.ErrorInfo{
+		Reason:   NotLeaderReason,
+		Domain:   ErrorDomain,
+		Metadata: hint.metadata(),
+	})
+	conn := &fakeConn{}
+	var dialed LeaderHint
+	icep := NewLeaderHintUnaryClientInterceptor(func(ctx context.Context, h LeaderHint) (transport.RPCClientConn, error) {
+		dialed = h
+		return conn, nil
+	})
+	invoker := func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return st.Err()
+	}
+	err := icep(context.Background(), "/v1.Admin/PutRoute", nil, nil, nil, invoker)
+	if err != nil {
+		t.Fatalf("expected redirected call to succeed, got %v", err)
+	}
+	if dialed != hint {
+		t.Fatalf("expected to dial %+v, got %+v", hint, dialed)
+	}
+	if conn.invoked != "/v1.Admin/PutRoute" || !conn.closed {
+		t.Fatalf("expected call to be retried on and close the hinted connection")
+	}
+
+	// Errors without a hint are returned unchanged.
+	plain := status.Error(codes.FailedPrecondition, "not leader")
+	err = icep(context.Background(), "/v1.Admin/PutRoute", nil, nil, nil, func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return plain
+	})
+	if err != plain {
+		t.Fatalf("expected original error, got %v", err)
+	}
+}
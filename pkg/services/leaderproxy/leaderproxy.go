@@ -135,9 +135,55 @@ func (i *Interceptor) proxyUnaryToLeader(ctx context.Context, req any, info *grp
 	}
 	defer conn.Close()
 	ctx = metadata.AppendToOutgoingContext(ctx, ProxiedFromMeta, i.nodeID.String())
+	ctx = context.AppendRequestIDToOutgoing(ctx)
 	if peer, ok := context.AuthenticatedCallerFrom(ctx); ok {
 		ctx = metadata.AppendToOutgoingContext(ctx, ProxiedForMeta, peer)
 	}
+	if info.FullMethod == v1.Membership_Join_FullMethodName {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if escrow := md.Get(KeyEscrowMeta); len(escrow) > 0 {
+				ctx = metadata.AppendToOutgoingContext(ctx, KeyEscrowMeta, escrow[0])
+			}
+			for _, key := range []string{storage.LearnerHeader, storage.ClusterIDHeader, storage.ClusterTermHeader, storage.RaftLogFormatHeader} {
+				if val := md.Get(key); len(val) > 0 {
+					ctx = metadata.AppendToOutgoingContext(ctx, key, val[0])
+				}
+			}
+		}
+	}
+	if info.FullMethod == v1.Membership_Join_FullMethodName || info.FullMethod == v1.Membership_Update_FullMethodName {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			for _, key := range []string{storage.NATTypeHeader, storage.GatewayHeader} {
+				if val := md.Get(key); len(val) > 0 {
+					ctx = metadata.AppendToOutgoingContext(ctx, key, val[0])
+				}
+			}
+		}
+	}
+	if info.FullMethod == v1.Admin_PutRoleBinding_FullMethodName {
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if val := md.Get(storage.RoleBindingExpiresHeader); len(val) > 0 {
+				ctx = metadata.AppendToOutgoingContext(ctx, storage.RoleBindingExpiresHeader, val[0])
+			}
+		}
+	}
+	resp, err := forwardUnary(ctx, conn, req, info)
+	hint, ok := LeaderHintFromError(err)
+	if !ok || hint.ID == i.nodeID.String() {
+		return resp, err
+	}
+	// Leadership moved since we resolved the leader. Follow the hint
+	// directly instead of re-resolving and risking another stale answer.
+	context.LoggerFrom(ctx).Debug("Following leader hint", slog.String("method", info.FullMethod), slog.String("leader", hint.ID))
+	hinted, dialErr := i.dialer.DialNode(ctx, types.NodeID(hint.ID))
+	if dialErr != nil {
+		return resp, err
+	}
+	defer hinted.Close()
+	return forwardUnary(ctx, hinted, req, info)
+}
+
+func forwardUnary(ctx context.Context, conn grpc.ClientConnInterface, req any, info *grpc.UnaryServerInfo) (any, error) {
 	switch info.FullMethod {
 	// Membership API
 	case v1.Membership_Join_FullMethodName:
@@ -236,6 +282,7 @@ func (i *Interceptor) proxyStreamToLeader(srv any, ss grpc.ServerStream, info *g
 	}
 	defer conn.Close()
 	ctx := metadata.AppendToOutgoingContext(ss.Context(), ProxiedFromMeta, i.nodeID.String())
+	ctx = context.AppendRequestIDToOutgoing(ctx)
 	if peer, ok := context.AuthenticatedCallerFrom(ctx); ok {
 		ctx = metadata.AppendToOutgoingContext(ctx, ProxiedForMeta, peer)
 	}
@@ -29,6 +29,10 @@ const (
 	ProxiedFromMeta = "x-webmesh-proxied-from"
 	// ProxiedForMeta is the metadata key for the Proxied-For header.
 	ProxiedForMeta = "x-webmesh-proxied-for"
+	// KeyEscrowMeta is the metadata key used by joining nodes to submit their
+	// identity key wrapped to the organizational recovery key. It is forwarded
+	// to the leader when a join is proxied.
+	KeyEscrowMeta = "x-webmesh-key-escrow"
 )
 
 // HasPreferLeaderMeta returns true if the context has the Prefer-Leader header set to true.
@@ -58,6 +58,8 @@ var MethodPolicyMap = map[string]MethodPolicy{
 
 	// Node API
 	v1.Node_GetStatus_FullMethodName:            RequireLocal,
+	"/webmesh.node.v1.NodeStatus/GetStatus":     RequireLocal,
+	"/webmesh.node.v1.NodeStatus/GetVersion":    RequireLocal,
 	v1.Node_NegotiateDataChannel_FullMethodName: RequireLocal,
 
 	// Storage API
@@ -73,6 +75,56 @@ var MethodPolicyMap = map[string]MethodPolicy{
 	// WebRTC API
 	v1.WebRTC_StartDataChannel_FullMethodName: AllowNonLeader,
 
+	// Conntrack API (see services/conntrack, which imports this package)
+	"/webmesh.conntrack.v1.Conntrack/ListFlows": RequireLocal,
+	"/webmesh.conntrack.v1.Conntrack/KillFlows": RequireLocal,
+
+	// Peer configuration API (see services/peerconfig)
+	"/webmesh.peerconfig.v1.PeerConfig/WatchPeers":       RequireLocal,
+	"/webmesh.peerconfig.v1.PeerConfig/RenderWGQuick":    RequireLocal,
+	"/webmesh.peerconfig.v1.PeerConfig/GetComputedPeers": RequireLocal,
+
+	// Policy API (see services/policy)
+	"/webmesh.policy.v1.Policy/ExplainDeny": AllowNonLeader,
+	"/webmesh.policy.v1.Policy/EvaluateACL": AllowNonLeader,
+
+	// Access review API (see services/accessreview)
+	"/webmesh.accessreview.v1.AccessReview/ListExpiringRoleBindings": AllowNonLeader,
+
+	// Mesh admin API (see services/meshadmin)
+	"/webmesh.meshadmin.v1.MeshAdmin/GetEscrowedKey":          AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/RecoverNode":             RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/ListMembershipEvents":    AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/ListQuarantines":         AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/QuarantineNode":          RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/ReleaseQuarantine":       RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/PurgeNode":               RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/PutMaintenanceWindow":    RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/GetMaintenanceWindows":   AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/DeleteMaintenanceWindow": RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/ListSplitBrainAlarms":    AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/ResolveSplitBrain":       RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/PutNamespace":            RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/GetNamespaces":           AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/DeleteNamespace":         RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/RenameNode":              RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/SetDefaultNetworkPolicy": RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/GetDefaultNetworkPolicy": AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/PutACLExemptions":        RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/GetACLExemptions":        AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/PutACLSchedule":          RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/GetACLSchedules":         AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/DeleteACLSchedule":       RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/PutNodeLabels":           RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/GetNodeLabels":           AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/DeleteNodeLabels":        RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/PutTrafficPolicy":        RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/GetTrafficPolicies":      AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/DeleteTrafficPolicy":     RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/PutRouteMetric":          RequireLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/GetRouteMetrics":         AllowNonLeader,
+	"/webmesh.meshadmin.v1.MeshAdmin/DeleteRouteMetric":       RequireLeader,
+
 	// Admin API
 	v1.Admin_PutRole_FullMethodName:    RequireLeader,
 	v1.Admin_DeleteRole_FullMethodName: RequireLeader,
@@ -28,7 +28,9 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/shardedstorage"
 )
 
 func (s *Server) Apply(ctx context.Context, log *v1.RaftLogEntry) (*v1.RaftApplyResponse, error) {
@@ -38,12 +40,21 @@ func (s *Server) Apply(ctx context.Context, log *v1.RaftLogEntry) (*v1.RaftApply
 		s.log.Warn("Received Apply request from out of network", slog.String("peer", addr.String()))
 		return nil, status.Errorf(codes.PermissionDenied, "request is not in-network")
 	}
-	provider, ok := s.storage.(*raftstorage.Provider)
-	if !ok {
+	var provider *raftstorage.Provider
+	switch st := s.storage.(type) {
+	case *raftstorage.Provider:
+		provider = st
+	case *shardedstorage.Provider:
+		var err error
+		provider, err = st.ShardForLog(log)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid log entry: %v", err)
+		}
+	default:
 		return nil, status.Errorf(codes.FailedPrecondition, "storage provider is not a raftstorage provider")
 	}
 	if !provider.Consensus().IsLeader() {
-		return nil, status.Errorf(codes.FailedPrecondition, "not leader")
+		return nil, leaderproxy.NewNotLeaderError(ctx, s.storage, "not leader")
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,265 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// CollisionPolicy is the policy applied when a node attempts to join with an ID
+// that is already registered to a different public key.
+type CollisionPolicy string
+
+const (
+	// CollisionPolicyEvict removes the older registration and lets the joining
+	// node take over the ID. It must be opted into, and suits meshes where
+	// nodes rejoin with rotated or ephemeral keys without proving ownership
+	// of their ID.
+	CollisionPolicyEvict CollisionPolicy = "evict"
+	// CollisionPolicyReject refuses the join request. This is the default.
+	CollisionPolicyReject CollisionPolicy = "reject"
+	// CollisionPolicyRename registers the joining node under the first free
+	// suffixed ID (e.g. <id>-2). The assigned ID is returned to the caller
+	// in the AssignedNodeIDHeader response header. Nodes joining as storage
+	// members are rejected instead, since consensus knows them by their ID.
+	CollisionPolicyRename CollisionPolicy = "rename"
+)
+
+// DefaultCollisionPolicy is the default collision policy.
+const DefaultCollisionPolicy = CollisionPolicyReject
+
+// AssignedNodeIDHeader is the response header set when a joining node was
+// registered under a different ID than the one it requested.
+const AssignedNodeIDHeader = storage.AssignedNodeIDHeader
+
+// maxRenameAttempts is the maximum number of suffixes tried before giving up
+// on finding a free ID for a colliding node.
+const maxRenameAttempts = 100
+
+// IsValid returns true if the collision policy is a known value. An empty
+// policy is considered valid and treated as the default.
+func (c CollisionPolicy) IsValid() bool {
+	switch c {
+	case "", CollisionPolicyEvict, CollisionPolicyReject, CollisionPolicyRename:
+		return true
+	}
+	return false
+}
+
+// OrDefault returns the collision policy or the default if it is empty.
+func (c CollisionPolicy) OrDefault() CollisionPolicy {
+	if c == "" {
+		return DefaultCollisionPolicy
+	}
+	return c
+}
+
+// idCollision is how a node ID collision was resolved for a join request.
+type idCollision struct {
+	// requestedID is the node ID the joining node asked for.
+	requestedID string
+	// evict is the older registration to evict, if any.
+	evict *types.MeshNode
+	// renamed is true if the request ID was rewritten to a free ID.
+	renamed bool
+}
+
+// handleIDCollision checks if the requested node ID is already registered to
+// a different key and applies the configured collision policy. The request ID
+// is rewritten in place when the node is renamed.
+func (s *Server) handleIDCollision(ctx context.Context, req *v1.JoinRequest) error {
+	collision, err := s.resolveIDCollision(ctx, req)
+	if err != nil {
+		return err
+	}
+	return s.applyIDCollision(ctx, req, collision)
+}
+
+// resolveIDCollision decides how a collision on the requested node ID is
+// handled without changing any registration, so that the join can be checked
+// against the ID the node ends up with first. The request ID is rewritten in
+// place when the node is renamed.
+func (s *Server) resolveIDCollision(ctx context.Context, req *v1.JoinRequest) (idCollision, error) {
+	log := context.LoggerFrom(ctx)
+	collision := idCollision{requestedID: req.GetId()}
+	existing, err := s.storage.MeshDB().Peers().Get(ctx, types.NodeID(req.GetId()))
+	if err != nil {
+		if errors.IsNodeNotFound(err) {
+			return collision, nil
+		}
+		return collision, status.Errorf(codes.Internal, "failed to lookup existing node: %v", err)
+	}
+	if existing.GetPublicKey() == "" || existing.GetPublicKey() == req.GetPublicKey() {
+		// Placeholder registration or the same node rejoining.
+		return collision, nil
+	}
+	if s.plugins != nil && s.plugins.HasAuth() && nodeIDMatchesContext(ctx, req.GetId()) {
+		// The caller proved ownership of the ID, this is a key rotation.
+		return collision, nil
+	}
+	policy := s.collisionPolicy.OrDefault()
+	if existing.NodeID() == s.nodeID {
+		// Never evict or shadow ourselves.
+		policy = CollisionPolicyReject
+	}
+	if policy == CollisionPolicyRename && (req.GetAsVoter() || req.GetAsObserver() || storage.IsLearnerRequest(ctx)) {
+		// Storage members are known to consensus by their configured ID.
+		policy = CollisionPolicyReject
+	}
+	log.Warn("Node ID collision detected",
+		slog.String("id", req.GetId()),
+		slog.String("existing-key", existing.GetPublicKey()),
+		slog.String("policy", string(policy)),
+	)
+	switch policy {
+	case CollisionPolicyEvict:
+		collision.evict = &existing
+		return collision, nil
+	case CollisionPolicyRename:
+		newID, err := s.nextFreeNodeID(ctx, req)
+		if err != nil {
+			return collision, err
+		}
+		req.Id = newID
+		collision.renamed = true
+		return collision, nil
+	default:
+		s.recordEvent(ctx, storage.MembershipEventCollision, types.NodeID(req.GetId()), "join rejected, node id is registered to a different key")
+		return collision, status.Errorf(codes.AlreadyExists, "node id %s is already registered to a different key", req.GetId())
+	}
+}
+
+// applyIDCollision evicts the older registration or records the rename
+// decided by resolveIDCollision.
+func (s *Server) applyIDCollision(ctx context.Context, req *v1.JoinRequest, collision idCollision) error {
+	log := context.LoggerFrom(ctx)
+	switch {
+	case collision.evict != nil:
+		log.Info("Evicting older registration for node", slog.String("id", collision.requestedID))
+		s.recordEvent(ctx, storage.MembershipEventCollision, types.NodeID(collision.requestedID), "older registration evicted for joining node")
+		return s.evictNode(ctx, *collision.evict)
+	case collision.renamed:
+		s.recordEvent(ctx, storage.MembershipEventCollision, types.NodeID(collision.requestedID), fmt.Sprintf("joining node renamed to %s", req.GetId()))
+		log.Info("Renaming colliding node", slog.String("id", collision.requestedID), slog.String("assigned-id", req.GetId()))
+		if err := grpc.SetHeader(ctx, metadata.Pairs(AssignedNodeIDHeader, req.GetId())); err != nil {
+			log.Warn("Failed to set assigned node ID header", slog.String("error", err.Error()))
+		}
+	}
+	return nil
+}
+
+// nextFreeNodeID returns the first suffixed variant of the requested node ID that
+// is either unregistered or already registered to the caller's key. Unregistered
+// IDs that are quarantined or still resolve to a renamed node are skipped.
+func (s *Server) nextFreeNodeID(ctx context.Context, req *v1.JoinRequest) (string, error) {
+	base := strings.TrimSuffix(req.GetId(), "-")
+	for i := 2; i < maxRenameAttempts+2; i++ {
+		suffix := "-" + strconv.Itoa(i)
+		candidate := types.TruncateIDTo(base, types.MaxIDLength-len(suffix)) + suffix
+		if !types.IsValidNodeID(candidate) {
+			continue
+		}
+		node, err := s.storage.MeshDB().Peers().Get(ctx, types.NodeID(candidate))
+		if err != nil {
+			if !errors.IsNodeNotFound(err) {
+				return "", status.Errorf(codes.Internal, "failed to lookup node %s: %v", candidate, err)
+			}
+			reserved, err := s.isReservedNodeID(ctx, types.NodeID(candidate))
+			if err != nil {
+				return "", err
+			}
+			if reserved {
+				continue
+			}
+			return candidate, nil
+		}
+		if node.GetPublicKey() == req.GetPublicKey() {
+			return candidate, nil
+		}
+	}
+	return "", status.Errorf(codes.ResourceExhausted, "no free node id found for %s", req.GetId())
+}
+
+// isReservedNodeID returns true if the given unregistered node ID can not be
+// handed out, because it is quarantined or an alias of a renamed node.
+func (s *Server) isReservedNodeID(ctx context.Context, id types.NodeID) (bool, error) {
+	quarantined, err := storage.IsQuarantined(ctx, s.storage.MeshStorage(), id)
+	if err != nil {
+		return false, status.Errorf(codes.Internal, "failed to check quarantine: %v", err)
+	}
+	if quarantined {
+		return true, nil
+	}
+	_, err = storage.GetNodeAlias(ctx, s.storage.MeshStorage(), id)
+	if err == nil {
+		return true, nil
+	} else if !errors.IsKeyNotFound(err) {
+		return false, status.Errorf(codes.Internal, "failed to check node alias: %v", err)
+	}
+	return false, nil
+}
+
+// evictNode removes the given node from storage consensus and the peers database.
+func (s *Server) evictNode(ctx context.Context, node types.MeshNode) error {
+	if node.PortFor(v1.Feature_STORAGE_PROVIDER) != 0 {
+		err := s.storage.Consensus().RemovePeer(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{Id: node.GetId()}}, false)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to remove evicted node from storage consensus: %v", err)
+		}
+	}
+	err := s.storage.MeshDB().Peers().Delete(ctx, node.NodeID())
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to delete evicted node: %v", err)
+	}
+	s.recordEvent(ctx, storage.MembershipEventEvict, node.NodeID(), "node id claimed by a different key")
+	suppressEvents := s.inMaintenance(ctx, node.NodeID())
+	go func() {
+		if !suppressEvents && s.plugins != nil && s.plugins.HasWatchers() {
+			err := s.plugins.Emit(context.Background(), &v1.Event{
+				Type:  v1.Event_NODE_LEAVE,
+				Event: &v1.Event_Node{Node: node.MeshNode},
+			})
+			if err != nil {
+				s.log.Warn("Failed to emit event", "error", err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+// ParseCollisionPolicy parses the given string into a CollisionPolicy.
+func ParseCollisionPolicy(s string) (CollisionPolicy, error) {
+	policy := CollisionPolicy(strings.ToLower(s))
+	if !policy.IsValid() {
+		return "", fmt.Errorf("invalid collision policy %q", s)
+	}
+	return policy.OrDefault(), nil
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+	"github.com/webmeshproj/webmesh/pkg/plugins"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestHandleIDCollision(t *testing.T) {
+	t.Parallel()
+
+	newServer := func(t *testing.T, policy CollisionPolicy) *Server {
+		t.Helper()
+		ctx := context.Background()
+		node, err := meshnode.NewSingleNodeTestMesh(ctx)
+		if err != nil {
+			t.Fatalf("create test mesh: %v", err)
+		}
+		t.Cleanup(func() { node.Close(ctx) })
+		return NewServer(ctx, Options{
+			NodeID:          node.ID(),
+			Storage:         node.Storage(),
+			Plugins:         node.Plugins(),
+			Meshnet:         node.Network(),
+			CollisionPolicy: policy,
+		})
+	}
+	newKey := func(t *testing.T) string {
+		t.Helper()
+		encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+		if err != nil {
+			t.Fatalf("encode public key: %v", err)
+		}
+		return encoded
+	}
+	register := func(t *testing.T, s *Server, id, key string) {
+		t.Helper()
+		err := s.storage.MeshDB().Peers().Put(context.Background(), types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:        id,
+			PublicKey: key,
+		}})
+		if err != nil {
+			t.Fatalf("register node: %v", err)
+		}
+	}
+
+	t.Run("SameKey", func(t *testing.T) {
+		s := newServer(t, CollisionPolicyReject)
+		key := newKey(t)
+		register(t, s, "node-a", key)
+		req := &v1.JoinRequest{Id: "node-a", PublicKey: key}
+		if err := s.handleIDCollision(context.Background(), req); err != nil {
+			t.Fatalf("expected no error for rejoining node, got: %v", err)
+		}
+	})
+
+	collisionEvents := func(t *testing.T, s *Server) []storage.MembershipEvent {
+		t.Helper()
+		events, err := storage.ListMembershipEvents(context.Background(), s.storage.MeshStorage(), storage.MembershipEventFilter{
+			Type: storage.MembershipEventCollision,
+		})
+		if err != nil {
+			t.Fatalf("list membership events: %v", err)
+		}
+		return events
+	}
+
+	t.Run("Reject", func(t *testing.T) {
+		s := newServer(t, CollisionPolicyReject)
+		register(t, s, "node-a", newKey(t))
+		req := &v1.JoinRequest{Id: "node-a", PublicKey: newKey(t)}
+		err := s.handleIDCollision(context.Background(), req)
+		if status.Code(err) != codes.AlreadyExists {
+			t.Fatalf("expected AlreadyExists, got: %v", err)
+		}
+		if events := collisionEvents(t, s); len(events) != 1 || events[0].NodeID != "node-a" {
+			t.Fatalf("expected one collision event for node-a, got: %+v", events)
+		}
+	})
+
+	t.Run("DefaultRejects", func(t *testing.T) {
+		s := newServer(t, "")
+		key := newKey(t)
+		register(t, s, "node-a", key)
+		req := &v1.JoinRequest{Id: "node-a", PublicKey: newKey(t)}
+		err := s.handleIDCollision(context.Background(), req)
+		if status.Code(err) != codes.AlreadyExists {
+			t.Fatalf("expected AlreadyExists, got: %v", err)
+		}
+		peer, err := s.storage.MeshDB().Peers().Get(context.Background(), "node-a")
+		if err != nil {
+			t.Fatalf("get node: %v", err)
+		}
+		if peer.GetPublicKey() != key {
+			t.Fatalf("expected existing registration to be kept")
+		}
+	})
+
+	t.Run("RenameStorageMember", func(t *testing.T) {
+		s := newServer(t, CollisionPolicyRename)
+		register(t, s, "node-a", newKey(t))
+		req := &v1.JoinRequest{Id: "node-a", PublicKey: newKey(t), AsVoter: true}
+		err := s.handleIDCollision(context.Background(), req)
+		if status.Code(err) != codes.AlreadyExists {
+			t.Fatalf("expected AlreadyExists, got: %v", err)
+		}
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		s := newServer(t, CollisionPolicyRename)
+		register(t, s, "node-a", newKey(t))
+		register(t, s, "node-a-2", newKey(t))
+		req := &v1.JoinRequest{Id: "node-a", PublicKey: newKey(t)}
+		if err := s.handleIDCollision(context.Background(), req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if req.GetId() != "node-a-3" {
+			t.Fatalf("expected node to be renamed to node-a-3, got: %s", req.GetId())
+		}
+		if events := collisionEvents(t, s); len(events) != 1 {
+			t.Fatalf("expected one collision event, got: %+v", events)
+		}
+	})
+
+	prepareJoin := func(t *testing.T, s *Server) {
+		t.Helper()
+		pm, err := plugins.NewManager(context.Background(), plugins.Options{Storage: s.storage})
+		if err != nil {
+			t.Fatalf("create plugin manager: %v", err)
+		}
+		t.Cleanup(func() { pm.Close() })
+		s.plugins = pm
+		s.rbac = rbac.NewNoopEvaluator()
+		s.ipv4Prefix = netip.MustParsePrefix("172.16.0.0/12")
+		s.ipv6Prefix = netip.MustParsePrefix("fd00:dead:beef::/48")
+	}
+	quarantine := func(t *testing.T, s *Server, id string) {
+		t.Helper()
+		_, err := storage.QuarantineNode(context.Background(), s.storage.MeshDB(), s.storage.MeshStorage(), types.NodeID(id), "test")
+		if err != nil {
+			t.Fatalf("quarantine node: %v", err)
+		}
+	}
+
+	t.Run("RenameSkipsReservedIDs", func(t *testing.T) {
+		s := newServer(t, CollisionPolicyRename)
+		register(t, s, "node-a", newKey(t))
+		// node-a-2 is quarantined but no longer registered.
+		register(t, s, "node-a-2", newKey(t))
+		quarantine(t, s, "node-a-2")
+		if err := s.storage.MeshDB().Peers().Delete(context.Background(), "node-a-2"); err != nil {
+			t.Fatalf("delete node: %v", err)
+		}
+		req := &v1.JoinRequest{Id: "node-a", PublicKey: newKey(t)}
+		if err := s.handleIDCollision(context.Background(), req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if req.GetId() != "node-a-3" {
+			t.Fatalf("expected node to be renamed to node-a-3, got: %s", req.GetId())
+		}
+	})
+
+	t.Run("RenameIntoQuarantine", func(t *testing.T) {
+		s := newServer(t, CollisionPolicyRename)
+		prepareJoin(t, s)
+		key := newKey(t)
+		register(t, s, "node-a", newKey(t))
+		// node-a-2 is registered to the joining key and quarantined.
+		register(t, s, "node-a-2", newKey(t))
+		quarantine(t, s, "node-a-2")
+		register(t, s, "node-a-2", key)
+		_, err := s.Join(context.Background(), &v1.JoinRequest{Id: "node-a", PublicKey: key})
+		if status.Code(err) != codes.PermissionDenied || !strings.Contains(err.Error(), "node-a-2 is quarantined") {
+			t.Fatalf("expected the join to be rejected as node-a-2 is quarantined, got: %v", err)
+		}
+	})
+
+	t.Run("RenameJoiningNode", func(t *testing.T) {
+		s := newServer(t, CollisionPolicyRename)
+		prepareJoin(t, s)
+		register(t, s, "node-a", newKey(t))
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("listen: %v", err)
+		}
+		srv := grpc.NewServer()
+		v1.RegisterMembershipServer(srv, s)
+		go func() { _ = srv.Serve(lis) }()
+		t.Cleanup(srv.Stop)
+
+		key := crypto.MustGenerateKey()
+		node := meshnode.NewTestNode(meshnode.Config{NodeID: "node-a", Key: key})
+		err = node.Connect(context.Background(), meshnode.ConnectOptions{
+			JoinRoundTripper: tcp.NewJoinRoundTripper(tcp.RoundTripOptions{
+				Addrs:       []string{lis.Addr().String()},
+				Credentials: []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())},
+			}),
+		})
+		if err != nil {
+			t.Fatalf("join: %v", err)
+		}
+		t.Cleanup(func() { node.Close(context.Background()) })
+		if node.ID() != "node-a-2" {
+			t.Fatalf("expected joining node to use assigned id node-a-2, got: %s", node.ID())
+		}
+		peer, err := s.storage.MeshDB().Peers().Get(context.Background(), "node-a-2")
+		if err != nil {
+			t.Fatalf("get renamed node: %v", err)
+		}
+		encoded, _ := key.PublicKey().Encode()
+		if peer.GetPublicKey() != encoded {
+			t.Fatalf("expected node-a-2 to be registered to the joining key")
+		}
+	})
+
+	t.Run("Evict", func(t *testing.T) {
+		s := newServer(t, CollisionPolicyEvict)
+		register(t, s, "node-a", newKey(t))
+		req := &v1.JoinRequest{Id: "node-a", PublicKey: newKey(t)}
+		if err := s.handleIDCollision(context.Background(), req); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err := s.storage.MeshDB().Peers().Get(context.Background(), "node-a")
+		if !errors.IsNodeNotFound(err) {
+			t.Fatalf("expected older registration to be evicted, got: %v", err)
+		}
+		if events := collisionEvents(t, s); len(events) != 1 {
+			t.Fatalf("expected one collision event, got: %+v", events)
+		}
+	})
+}
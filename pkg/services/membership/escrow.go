@@ -0,0 +1,80 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"encoding/base64"
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// KeyEscrowHeader is the request header a joining node uses to submit its
+// identity key wrapped to the organizational recovery key. The value is the
+// base64 encoded output of crypto.WrapKey.
+const KeyEscrowHeader = leaderproxy.KeyEscrowMeta
+
+// maxEscrowSize is the maximum size of a wrapped key accepted for escrow.
+const maxEscrowSize = 1024
+
+// storeKeyEscrow persists a wrapped identity key submitted with the join
+// request, if any. The server never sees the unwrapped key.
+func (s *Server) storeKeyEscrow(ctx context.Context, req *v1.JoinRequest) error {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	values := md.Get(KeyEscrowHeader)
+	if len(values) == 0 || values[0] == "" {
+		return nil
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(values[0])
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid key escrow header: %v", err)
+	}
+	if len(wrapped) > maxEscrowSize {
+		return status.Errorf(codes.InvalidArgument, "escrowed key exceeds %d bytes", maxEscrowSize)
+	}
+	err = s.keyEscrow.PutEscrowedKey(ctx, types.NodeID(req.GetId()), wrapped)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to store escrowed key: %v", err)
+	}
+	context.LoggerFrom(ctx).Debug("Stored escrowed identity key for node", slog.String("id", req.GetId()))
+	return nil
+}
+
+// checkKeyRevoked returns a PermissionDenied error if the given key has been
+// revoked.
+func (s *Server) checkKeyRevoked(ctx context.Context, key crypto.PublicKey) error {
+	revoked, err := storage.IsKeyRevoked(ctx, s.storage.MeshStorage(), key)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to check key revocation: %v", err)
+	}
+	if revoked {
+		return status.Errorf(codes.PermissionDenied, "public key has been revoked")
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+func TestStoreKeyEscrow(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { node.Close(ctx) })
+	s := NewServer(ctx, Options{
+		NodeID:  node.ID(),
+		Storage: node.Storage(),
+		Plugins: node.Plugins(),
+		Meshnet: node.Network(),
+	})
+
+	key := crypto.MustGenerateKey()
+	recovery := crypto.MustGenerateKey()
+	wrapped, err := crypto.WrapKey(key, recovery.PublicKey())
+	if err != nil {
+		t.Fatalf("wrap key: %v", err)
+	}
+	reqctx := metadata.NewIncomingContext(ctx, metadata.Pairs(KeyEscrowHeader, base64.StdEncoding.EncodeToString(wrapped)))
+	err = s.storeKeyEscrow(reqctx, &v1.JoinRequest{Id: "node-a"})
+	if err != nil {
+		t.Fatalf("store key escrow: %v", err)
+	}
+	stored, err := s.storage.MeshStorage().GetValue(ctx, storage.EscrowPrefix.ForString("node-a"))
+	if err != nil {
+		t.Fatalf("get escrowed key: %v", err)
+	}
+	if !bytes.Equal(stored, wrapped) {
+		t.Fatal("expected stored escrow to match the submitted wrapped key")
+	}
+	recovered, err := crypto.UnwrapKey(stored, recovery)
+	if err != nil {
+		t.Fatalf("unwrap escrowed key: %v", err)
+	}
+	if !recovered.Equals(key) {
+		t.Fatal("expected recovered key to match the original key")
+	}
+
+	// Revoked keys should be reported as such
+	revoked, err := storage.IsKeyRevoked(ctx, s.storage.MeshStorage(), key.PublicKey())
+	if err != nil {
+		t.Fatalf("check key revocation: %v", err)
+	}
+	if revoked {
+		t.Fatal("expected key to not be revoked")
+	}
+	if err := storage.RevokeKey(ctx, s.storage.MeshStorage(), key.PublicKey()); err != nil {
+		t.Fatalf("revoke key: %v", err)
+	}
+	revoked, err = storage.IsKeyRevoked(ctx, s.storage.MeshStorage(), key.PublicKey())
+	if err != nil {
+		t.Fatalf("check key revocation: %v", err)
+	}
+	if !revoked {
+		t.Fatal("expected key to be revoked")
+	}
+}
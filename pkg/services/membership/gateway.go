@@ -0,0 +1,59 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// reportedGateway returns whether a node reported itself as a gateway with a
+// join or update request, and whether it reported anything at all.
+func reportedGateway(ctx context.Context) (gateway bool, reported bool, err error) {
+	gateway, reported, err = storage.GatewayFromRequest(ctx)
+	if err != nil {
+		return false, false, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return gateway, reported, nil
+}
+
+// recordGateway records whether the given node acts as a gateway. When it
+// becomes one, its existing edges are lowered so peers prefer reaching it
+// directly.
+func (s *Server) recordGateway(ctx context.Context, nodeID types.NodeID, gateway bool) error {
+	st := s.storage.MeshStorage()
+	current, err := storage.IsGateway(ctx, st, nodeID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to check gateway status: %v", err)
+	}
+	if current == gateway {
+		return nil
+	}
+	if err := storage.SetGateway(ctx, st, nodeID, gateway); err != nil {
+		return status.Errorf(codes.Internal, "failed to record gateway status: %v", err)
+	}
+	if gateway {
+		if err := storage.PreferGatewayEdges(ctx, s.storage.MeshDB().Peers(), nodeID); err != nil {
+			return status.Errorf(codes.Internal, "failed to prefer gateway edges: %v", err)
+		}
+	}
+	return nil
+}
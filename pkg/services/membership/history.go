@@ -0,0 +1,60 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// historyCompactionInterval is the minimum interval between compactions
+// of the membership history.
+const historyCompactionInterval = time.Minute
+
+// recordEvent records a membership event in the history and compacts the history
+// if it is due. Failures are logged and do not fail the calling operation.
+// The caller must hold the server lock.
+func (s *Server) recordEvent(ctx context.Context, typ storage.MembershipEventType, nodeID types.NodeID, reason string) {
+	log := context.LoggerFrom(ctx)
+	st := s.storage.MeshStorage()
+	err := storage.RecordMembershipEvent(ctx, st, storage.MembershipEvent{
+		Type:   typ,
+		NodeID: nodeID,
+		Reason: reason,
+		Actor:  s.nodeID,
+	})
+	if err != nil {
+		log.Warn("Failed to record membership event", slog.String("error", err.Error()))
+		return
+	}
+	if s.historyRetention.IsZero() || time.Since(s.lastCompaction) < historyCompactionInterval {
+		return
+	}
+	s.lastCompaction = time.Now()
+	removed, err := storage.CompactMembershipHistory(ctx, st, s.historyRetention)
+	if err != nil {
+		log.Warn("Failed to compact membership history", slog.String("error", err.Error()))
+		return
+	}
+	if removed > 0 {
+		log.Debug("Compacted membership history", slog.Int("removed", removed))
+	}
+}
@@ -61,7 +61,7 @@ var canPutEdgeAction = &rbac.Action{
 
 func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinResponse, error) {
 	if !s.storage.Consensus().IsLeader() {
-		return nil, status.Errorf(codes.FailedPrecondition, "not leader")
+		return nil, leaderproxy.NewNotLeaderError(ctx, s.storage, "not leader")
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -104,8 +104,69 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 	if err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
 	}
+	if err := s.checkKeyRevoked(ctx, publicKey); err != nil {
+		return nil, err
+	}
+
+	// Decide how a collision on the requested ID is handled before anything
+	// else, so that every ID-scoped check runs on the ID the node ends up with.
+	requestedID := req.GetId()
+	collision, err := s.resolveIDCollision(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if collision.renamed {
+		log = s.log.With("op", "join", "id", req.GetId(), "requested-id", requestedID)
+		ctx = context.WithLogger(ctx, log)
+		// A quarantined node can not escape its quarantine by rejoining
+		// with a new key under a suffixed ID.
+		quarantined, err := storage.IsQuarantined(ctx, s.storage.MeshStorage(), types.NodeID(requestedID))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to check quarantine: %v", err)
+		}
+		if quarantined {
+			return nil, status.Errorf(codes.PermissionDenied, "node %s is quarantined", requestedID)
+		}
+	}
+	quarantined, err := storage.IsQuarantined(ctx, s.storage.MeshStorage(), types.NodeID(req.GetId()))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check quarantine: %v", err)
+	}
+	if quarantined {
+		return nil, status.Errorf(codes.PermissionDenied, "node %s is quarantined", req.GetId())
+	}
+	alias, err := storage.GetNodeAlias(ctx, s.storage.MeshStorage(), types.NodeID(req.GetId()))
+	if err == nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s was renamed to %s, restart it with the new ID", req.GetId(), alias.NodeID)
+	} else if !errors.IsKeyNotFound(err) {
+		return nil, status.Errorf(codes.Internal, "failed to check node alias: %v", err)
+	}
+	if err := s.checkClusterLineage(ctx, types.NodeID(req.GetId())); err != nil {
+		return nil, err
+	}
+	natType, natReported, err := reportedNATType(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gateway, _, err := reportedGateway(ctx)
+	if err != nil {
+		return nil, err
+	}
+	learner := storage.IsLearnerRequest(ctx)
+	if learner && req.GetAsVoter() {
+		return nil, status.Error(codes.InvalidArgument, "learners cannot join as voters")
+	}
+	if req.GetAsVoter() {
+		isLearner, err := storage.IsLearner(ctx, s.storage.MeshStorage(), types.NodeID(req.GetId()))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to check learner: %v", err)
+		}
+		if isLearner {
+			return nil, status.Errorf(codes.FailedPrecondition, "node %s is a learner and cannot be promoted to voter", req.GetId())
+		}
+	}
 	var storagePort int32
-	if req.GetAsVoter() || req.GetAsObserver() {
+	if req.GetAsVoter() || req.GetAsObserver() || learner {
 		for _, feat := range req.GetFeatures() {
 			if feat.Feature == v1.Feature_STORAGE_PROVIDER {
 				storagePort = feat.Port
@@ -115,6 +176,9 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 		if storagePort <= 0 {
 			return nil, status.Error(codes.InvalidArgument, "storage provider port required")
 		}
+		if err := s.checkRaftLogFormat(ctx); err != nil {
+			return nil, err
+		}
 	}
 
 	// We can go ahead and check here if the node is allowed to do what
@@ -123,7 +187,7 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 	if req.GetAsVoter() {
 		actions = append(actions, canVoteAction)
 	}
-	if req.GetAsObserver() {
+	if req.GetAsObserver() || learner {
 		// Technically, voters are also observers, but we check it for now
 		// for consistency.
 		actions = append(actions, canObserveAction)
@@ -149,6 +213,29 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 		}
 	}
 
+	// Make sure we are not clobbering another node's registration
+	err = s.applyIDCollision(ctx, req, collision)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.storage.MeshDB().Peers().Get(ctx, types.NodeID(req.GetId())); errors.IsNodeNotFound(err) {
+		ns, err := storage.GetNodeNamespace(ctx, s.storage.MeshStorage(), types.NodeID(req.GetId()))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get node namespace: %v", err)
+		}
+		err = storage.CheckNamespaceQuota(ctx, s.storage.MeshDB(), s.storage.MeshStorage(), ns, storage.NamespaceUsage{Nodes: 1})
+		if err != nil {
+			if storage.IsQuotaExceeded(err) {
+				return nil, status.Error(codes.ResourceExhausted, err.Error())
+			}
+			return nil, status.Errorf(codes.Internal, "failed to check namespace quota: %v", err)
+		}
+	}
+	err = s.storeKeyEscrow(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
 	// Start building a list of clean up functions to run if we fail
 	cleanFuncs := make([]func(), 0)
 	handleErr := func(cause error) error {
@@ -162,7 +249,7 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 	if len(req.GetRoutes()) > 0 {
 		created, err := s.ensurePeerRoutes(ctx, types.NodeID(req.GetId()), req.GetRoutes())
 		if err != nil {
-			return nil, handleErr(status.Errorf(codes.Internal, "failed to ensure peer routes: %v", err))
+			return nil, handleErr(routesError(err))
 		} else if created {
 			cleanFuncs = append(cleanFuncs, func() {
 				err := s.storage.MeshDB().Networking().DeleteRoute(ctx, nodeAutoRoute(types.NodeID(req.GetId())))
@@ -189,22 +276,21 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 		}
 		log.Debug("Assigned IPv4 address to peer", slog.String("ipv4", leasev4.String()))
 	}
-	// Write the peer to the database
 	p := s.storage.MeshDB().Peers()
-	err = p.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
-		Id:                 req.GetId(),
-		PrimaryEndpoint:    req.GetPrimaryEndpoint(),
-		WireguardEndpoints: req.GetWireguardEndpoints(),
-		ZoneAwarenessID:    req.GetZoneAwarenessID(),
-		PublicKey:          req.GetPublicKey(),
-		PrivateIPv4:        leasev4.String(),
-		PrivateIPv6:        leasev6.String(),
-		Features:           req.GetFeatures(),
-		Multiaddrs:         req.GetMultiaddrs(),
-		JoinedAt:           timestamppb.New(time.Now().UTC()),
-	}})
+	joinReason := "new node"
+	if existing, err := p.Get(ctx, types.NodeID(req.GetId())); err == nil && existing.GetPublicKey() != "" {
+		joinReason = "rejoin"
+	}
+	// Write the peer and its edges to the database in a single transaction
+	// so a failure part way through does not leave a partially registered node.
+	err = s.storage.MeshDB().Txn(ctx, func(tx storage.MeshDB) error {
+		return s.registerPeer(ctx, tx.Peers(), req, leasev4, leasev6, gateway)
+	})
 	if err != nil {
-		return nil, handleErr(status.Errorf(codes.Internal, "failed to persist peer details to storage: %v", err))
+		if _, ok := status.FromError(err); !ok {
+			err = status.Errorf(codes.Internal, "failed to persist peer details to storage: %v", err)
+		}
+		return nil, handleErr(err)
 	}
 	cleanFuncs = append(cleanFuncs, func() {
 		err := p.Delete(ctx, types.NodeID(req.GetId()))
@@ -212,98 +298,15 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 			log.Warn("failed to delete peer", slog.String("error", err.Error()))
 		}
 	})
-	// At this point we want to
-	// Add an edge from the joining server to the caller
-	joiningServer := s.nodeID
-	if proxiedFrom, ok := leaderproxy.ProxiedFrom(ctx); ok {
-		joiningServer = types.NodeID(proxiedFrom)
-	}
-	log.Debug("Adding edge between caller and joining server", slog.String("join-edge", joiningServer.String()))
-	err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
-		Source: joiningServer.String(),
-		Target: req.GetId(),
-		Weight: 1,
-	}})
-	if err != nil {
-		return nil, handleErr(status.Errorf(codes.Internal, "failed to add edge: %v", err))
-	}
-	if req.GetPrimaryEndpoint() != "" {
-		// Add an edge between the caller and all other nodes with public endpoints
-		// TODO: This should be done according to network policy and batched
-		allPeers, err := p.List(ctx, storage.FilterByIsPublic())
-		if err != nil {
-			return nil, handleErr(status.Errorf(codes.Internal, "failed to list peers: %v", err))
-		}
-		for _, peer := range allPeers {
-			if peer.GetId() != req.GetId() && peer.PrimaryEndpoint != "" {
-				log.Debug("adding edge from public peer to public caller", slog.String("peer", peer.GetId()))
-				err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
-					Source: peer.GetId(),
-					Target: req.GetId(),
-					Weight: 99,
-				}})
-				if err != nil {
-					return nil, handleErr(status.Errorf(codes.Internal, "failed to add edge: %v", err))
-				}
-			}
-		}
-	}
-	if req.GetZoneAwarenessID() != "" {
-		// Add an edge between the caller and all other nodes in the same zone
-		// with public endpoints.
-		// TODO: Same as above - this should be done according to network policy and batched
-		zonePeers, err := p.List(ctx, storage.FilterByZoneID(req.GetZoneAwarenessID()))
-		if err != nil {
-			return nil, handleErr(status.Errorf(codes.Internal, "failed to list peers: %v", err))
-		}
-		for _, peer := range zonePeers {
-			if peer.GetId() == req.GetId() || peer.PrimaryEndpoint == "" {
-				continue
-			}
-			log.Debug("Adding edges to peer in the same zone", slog.String("peer", peer.GetId()))
-			if peer.GetId() != req.GetId() {
-				err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
-					Source: peer.GetId(),
-					Target: req.GetId(),
-					Weight: 1,
-				}})
-				if err != nil {
-					return nil, handleErr(status.Errorf(codes.Internal, "failed to add edge: %v", err))
-				}
-			}
+	if natReported {
+		log.Debug("Recording NAT type of peer", slog.String("nat-type", natType.String()))
+		if err := s.recordNATType(ctx, types.NodeID(req.GetId()), natType); err != nil {
+			return nil, handleErr(err)
 		}
 	}
 
-	if len(req.GetDirectPeers()) > 0 {
-		// Put an edge between the caller and all direct peers
-		for peer, proto := range req.GetDirectPeers() {
-			// Check if the peer exists
-			if !types.IsValidNodeID(peer) {
-				return nil, handleErr(status.Errorf(codes.InvalidArgument, "invalid peer id %q", peer))
-			}
-			_, err := p.Get(ctx, types.NodeID(peer))
-			if err != nil {
-				if !errors.IsNodeNotFound(err) {
-					return nil, handleErr(status.Errorf(codes.Internal, "failed to get peer: %v", err))
-				}
-				// The peer doesn't exist, so create a placeholder for it
-				log.Debug("Registering empty peer", slog.String("peer", peer))
-				err = p.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: peer}})
-				if err != nil {
-					return nil, handleErr(status.Errorf(codes.Internal, "failed to register peer: %v", err))
-				}
-			}
-			log.Debug("Adding ICE edge to peer", slog.String("peer", peer))
-			err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
-				Source:     peer,
-				Target:     req.GetId(),
-				Weight:     1,
-				Attributes: types.EdgeAttrsForConnectProto(proto),
-			}})
-			if err != nil {
-				return nil, handleErr(status.Errorf(codes.Internal, "failed to add edge: %v", err))
-			}
-		}
+	if err := s.recordGateway(ctx, types.NodeID(req.GetId()), gateway); err != nil {
+		return nil, handleErr(err)
 	}
 
 	// Collect the list of peers we will send to the new node
@@ -330,7 +333,7 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 	// Add the node to Raft if requested
 	// The node will otherwise need to subscribe to cluster events manually with
 	// the Subscribe RPC.
-	if req.GetAsVoter() || req.GetAsObserver() {
+	if req.GetAsVoter() || req.GetAsObserver() || learner {
 		// Add peer to the raft cluster
 		addStorageMember := func() {
 			// Wait for the call to be complete before adding the storage member
@@ -355,6 +358,16 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 					log.Error("Failed to add voter", slog.String("error", err.Error()))
 					return
 				}
+			} else if learner {
+				log.Info("Adding learner to cluster", slog.String("raft_address", storageAddress))
+				if err := s.storage.Consensus().AddLearner(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{
+					Id:        req.GetId(),
+					PublicKey: req.GetPublicKey(),
+					Address:   storageAddress,
+				}}); err != nil {
+					log.Error("Failed to add learner", slog.String("error", err.Error()))
+					return
+				}
 			} else if req.GetAsObserver() {
 				log.Info("Adding observer to cluster", slog.String("raft_address", storageAddress))
 				if err := s.storage.Consensus().AddObserver(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{
@@ -418,9 +431,12 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 		}
 	}
 
+	s.recordEvent(ctx, storage.MembershipEventJoin, types.NodeID(req.GetId()), joinReason)
+
+	suppressEvents := s.inMaintenance(ctx, types.NodeID(req.GetId()))
 	go func() {
 		// Notify any watching plugins
-		if s.plugins != nil && s.plugins.HasWatchers() {
+		if !suppressEvents && s.plugins != nil && s.plugins.HasWatchers() {
 			err := s.plugins.Emit(context.Background(), &v1.Event{
 				Type: v1.Event_NODE_JOIN,
 				Event: &v1.Event_Node{
@@ -446,3 +462,150 @@ func (s *Server) Join(ctx context.Context, req *v1.JoinRequest) (*v1.JoinRespons
 	log.Debug("Sending join response", slog.Any("response", resp))
 	return resp, nil
 }
+
+// registerPeer writes a joining node and the edges to its initial peers.
+func (s *Server) registerPeer(ctx context.Context, p storage.Peers, req *v1.JoinRequest, leasev4, leasev6 netip.Prefix, gateway bool) error {
+	log := context.LoggerFrom(ctx)
+	err := p.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+		Id:                 req.GetId(),
+		PrimaryEndpoint:    req.GetPrimaryEndpoint(),
+		WireguardEndpoints: req.GetWireguardEndpoints(),
+		ZoneAwarenessID:    req.GetZoneAwarenessID(),
+		PublicKey:          req.GetPublicKey(),
+		PrivateIPv4:        leasev4.String(),
+		PrivateIPv6:        leasev6.String(),
+		Features:           req.GetFeatures(),
+		Multiaddrs:         req.GetMultiaddrs(),
+		JoinedAt:           timestamppb.New(time.Now().UTC()),
+	}})
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to persist peer details to storage: %v", err)
+	}
+	// At this point we want to
+	// Add an edge from the joining server to the caller
+	joiningServer := s.nodeID
+	if proxiedFrom, ok := leaderproxy.ProxiedFrom(ctx); ok {
+		joiningServer = types.NodeID(proxiedFrom)
+	}
+	log.Debug("Adding edge between caller and joining server", slog.String("join-edge", joiningServer.String()))
+	err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+		Source: joiningServer.String(),
+		Target: req.GetId(),
+		Weight: 1,
+	}})
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to add edge: %v", err)
+	}
+	if req.GetPrimaryEndpoint() != "" {
+		// Add an edge between the caller and all other nodes with public endpoints
+		// TODO: This should be done according to network policy
+		allPeers, err := p.List(ctx, storage.FilterByIsPublic())
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to list peers: %v", err)
+		}
+		for _, peer := range allPeers {
+			if peer.GetId() != req.GetId() && peer.PrimaryEndpoint != "" {
+				log.Debug("adding edge from public peer to public caller", slog.String("peer", peer.GetId()))
+				// Edges between public peers are discouraged unless one
+				// of them is a gateway.
+				weight := int32(99)
+				peerIsGateway, err := storage.IsGateway(ctx, s.storage.MeshStorage(), peer.NodeID())
+				if err != nil {
+					return status.Errorf(codes.Internal, "failed to check gateway status: %v", err)
+				}
+				if gateway || peerIsGateway {
+					weight = storage.GatewayEdgeWeight
+				}
+				err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+					Source: peer.GetId(),
+					Target: req.GetId(),
+					Weight: weight,
+				}})
+				if err != nil {
+					return status.Errorf(codes.Internal, "failed to add edge: %v", err)
+				}
+			}
+		}
+	}
+	if req.GetZoneAwarenessID() != "" {
+		// Add an edge between the caller and all other nodes in the same zone
+		// with public endpoints.
+		// TODO: Same as above - this should be done according to network policy
+		zonePeers, err := p.List(ctx, storage.FilterByZoneID(req.GetZoneAwarenessID()))
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to list peers: %v", err)
+		}
+		for _, peer := range zonePeers {
+			if peer.GetId() == req.GetId() || peer.PrimaryEndpoint == "" {
+				continue
+			}
+			log.Debug("Adding edges to peer in the same zone", slog.String("peer", peer.GetId()))
+			if peer.GetId() != req.GetId() {
+				err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+					Source: peer.GetId(),
+					Target: req.GetId(),
+					Weight: 1,
+				}})
+				if err != nil {
+					return status.Errorf(codes.Internal, "failed to add edge: %v", err)
+				}
+			}
+		}
+	}
+
+	if len(req.GetDirectPeers()) > 0 {
+		// Put an edge between the caller and all direct peers
+		for peer, proto := range req.GetDirectPeers() {
+			// Check if the peer exists
+			if !types.IsValidNodeID(peer) {
+				return status.Errorf(codes.InvalidArgument, "invalid peer id %q", peer)
+			}
+			_, err := p.Get(ctx, types.NodeID(peer))
+			if err != nil {
+				if !errors.IsNodeNotFound(err) {
+					return status.Errorf(codes.Internal, "failed to get peer: %v", err)
+				}
+				// The peer doesn't exist, so create a placeholder for it
+				log.Debug("Registering empty peer", slog.String("peer", peer))
+				err = p.Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: peer}})
+				if err != nil {
+					return status.Errorf(codes.Internal, "failed to register peer: %v", err)
+				}
+			}
+			log.Debug("Adding ICE edge to peer", slog.String("peer", peer))
+			err = p.PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{
+				Source:     peer,
+				Target:     req.GetId(),
+				Weight:     1,
+				Attributes: types.EdgeAttrsForConnectProto(proto),
+			}})
+			if err != nil {
+				return status.Errorf(codes.Internal, "failed to add edge: %v", err)
+			}
+		}
+	}
+	return nil
+}
+
+// routesError converts an error from ensurePeerRoutes to a gRPC status.
+func routesError(err error) error {
+	if storage.IsQuotaExceeded(err) {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return status.Errorf(codes.Internal, "failed to ensure peer routes: %v", err)
+}
+
+// checkRaftLogFormat refuses a storage member whose raft log format differs
+// from ours. It could not decode the log entries we replicate to it, so it is
+// refused before it is added to the storage group.
+func (s *Server) checkRaftLogFormat(ctx context.Context) error {
+	lf, ok := s.storage.(storage.RaftLogFormatProvider)
+	if !ok {
+		return nil
+	}
+	local, remote := lf.RaftLogFormat(), storage.RaftLogFormatFrom(ctx)
+	if local != remote {
+		return status.Errorf(codes.FailedPrecondition, "node uses raft log format %s, this mesh uses %s", remote, local)
+	}
+	return nil
+}
@@ -25,6 +25,7 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
@@ -42,7 +43,7 @@ func (s *Server) Leave(ctx context.Context, req *v1.LeaveRequest) (*v1.LeaveResp
 		return nil, status.Error(codes.InvalidArgument, "invalid node id")
 	}
 	if !s.storage.Consensus().IsLeader() {
-		return nil, status.Errorf(codes.FailedPrecondition, "not leader")
+		return nil, leaderproxy.NewNotLeaderError(ctx, s.storage, "not leader")
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -81,17 +82,31 @@ func (s *Server) Leave(ctx context.Context, req *v1.LeaveRequest) (*v1.LeaveResp
 		if err != nil {
 			return nil, status.Errorf(codes.Internal, "failed to remove raft member: %v", err)
 		}
+		err = storage.UnmarkLearner(ctx, s.storage.MeshStorage(), types.NodeID(req.GetId()))
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to remove learner record: %v", err)
+		}
 	}
 
+	err = storage.DeleteNATType(ctx, s.storage.MeshStorage(), types.NodeID(req.GetId()))
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to remove NAT record: %v", err)
+	}
+	err = storage.SetGateway(ctx, s.storage.MeshStorage(), types.NodeID(req.GetId()), false)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to remove gateway record: %v", err)
+	}
 	s.log.Info("Removing mesh node from peers DB", "id", req.GetId())
 	err = s.storage.MeshDB().Peers().Delete(ctx, types.NodeID(req.GetId()))
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to delete peer: %v", err)
 	}
+	s.recordEvent(ctx, storage.MembershipEventLeave, types.NodeID(req.GetId()), "requested by node")
 
+	suppressEvents := s.inMaintenance(ctx, types.NodeID(req.GetId()))
 	go func() {
 		// Notify any watching plugins
-		if s.plugins != nil && s.plugins.HasWatchers() {
+		if !suppressEvents && s.plugins != nil && s.plugins.HasWatchers() {
 			err := s.plugins.Emit(context.Background(), &v1.Event{
 				Type: v1.Event_NODE_JOIN,
 				Event: &v1.Event_Node{
@@ -0,0 +1,37 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"log/slog"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// inMaintenance returns true if automatic actions against the given node should
+// be suppressed because of a maintenance window. Errors are logged and treated
+// as no maintenance window being in effect.
+func (s *Server) inMaintenance(ctx context.Context, nodeID types.NodeID) bool {
+	ok, err := storage.InMaintenance(ctx, s.storage.MeshStorage(), nodeID)
+	if err != nil {
+		context.LoggerFrom(ctx).Warn("Failed to check maintenance windows", slog.String("error", err.Error()))
+		return false
+	}
+	return ok
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/endpoints"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// reportedNATType returns the NAT type a node reported with a join or update
+// request, and whether one was reported at all.
+func reportedNATType(ctx context.Context) (endpoints.NATType, bool, error) {
+	value, ok := storage.NATTypeFromRequest(ctx)
+	if !ok {
+		return endpoints.NATUnknown, false, nil
+	}
+	natType, err := endpoints.ParseNATType(value)
+	if err != nil {
+		return endpoints.NATUnknown, false, status.Errorf(codes.InvalidArgument, "invalid NAT type: %v", err)
+	}
+	return natType, true, nil
+}
+
+// recordNATType stores the NAT type reported by the given node so peers can
+// select a connection strategy for it.
+func (s *Server) recordNATType(ctx context.Context, nodeID types.NodeID, natType endpoints.NATType) error {
+	current, err := storage.GetNATType(ctx, s.storage.MeshStorage(), nodeID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get NAT type: %v", err)
+	}
+	if current.Type == string(natType) && !current.Time.IsZero() {
+		return nil
+	}
+	if err := storage.SetNATType(ctx, s.storage.MeshStorage(), nodeID, string(natType)); err != nil {
+		return status.Errorf(codes.Internal, "failed to record NAT type: %v", err)
+	}
+	return nil
+}
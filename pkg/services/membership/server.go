@@ -22,6 +22,7 @@ import (
 	"log/slog"
 	"net/netip"
 	"sync"
+	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 
@@ -46,8 +47,15 @@ type Server struct {
 	ipv4Prefix netip.Prefix
 	ipv6Prefix netip.Prefix
 	meshDomain string
-	log        *slog.Logger
-	mu         sync.Mutex
+	// collisionPolicy is the policy applied to node ID collisions.
+	collisionPolicy CollisionPolicy
+	// historyRetention are the limits applied to the membership history.
+	historyRetention storage.MembershipHistoryRetention
+	lastCompaction   time.Time
+	// keyEscrow stores wrapped identity keys submitted by joining nodes.
+	keyEscrow storage.KeyEscrow
+	log       *slog.Logger
+	mu        sync.Mutex
 }
 
 // Options are the options for the Membership service.
@@ -57,17 +65,33 @@ type Options struct {
 	Plugins plugins.Manager
 	RBAC    rbac.Evaluator
 	Meshnet meshnet.Manager
+	// CollisionPolicy is the policy applied when a node joins with an ID
+	// registered to a different key. Defaults to CollisionPolicyReject.
+	CollisionPolicy CollisionPolicy
+	// HistoryRetention are the retention limits applied to the membership
+	// history. Zero values retain events indefinitely.
+	HistoryRetention storage.MembershipHistoryRetention
+	// KeyEscrow stores wrapped identity keys submitted by joining nodes.
+	// Defaults to storing them in the mesh database.
+	KeyEscrow storage.KeyEscrow
 }
 
 // NewServer returns a new Server.
 func NewServer(ctx context.Context, opts Options) *Server {
+	keyEscrow := opts.KeyEscrow
+	if keyEscrow == nil && opts.Storage != nil {
+		keyEscrow = storage.NewKeyEscrow(opts.Storage.MeshStorage())
+	}
 	return &Server{
-		nodeID:  opts.NodeID,
-		storage: opts.Storage,
-		plugins: opts.Plugins,
-		rbac:    opts.RBAC,
-		meshnet: opts.Meshnet,
-		log:     context.LoggerFrom(ctx).With("component", "membership-server"),
+		nodeID:           opts.NodeID,
+		storage:          opts.Storage,
+		plugins:          opts.Plugins,
+		rbac:             opts.RBAC,
+		meshnet:          opts.Meshnet,
+		collisionPolicy:  opts.CollisionPolicy.OrDefault(),
+		historyRetention: opts.HistoryRetention,
+		keyEscrow:        keyEscrow,
+		log:              context.LoggerFrom(ctx).With("component", "membership-server"),
 	}
 }
 
@@ -110,6 +134,10 @@ Routes:
 			Node:             nodeID.String(),
 			DestinationCIDRs: routes,
 		}}
+		err = storage.CheckRouteQuota(ctx, s.storage.MeshDB(), s.storage.MeshStorage(), rt)
+		if err != nil {
+			return false, fmt.Errorf("check route quota for node %q: %w", nodeID, err)
+		}
 		s.log.Debug("Adding new route for node", "node", nodeID, "route", &rt)
 		err = nw.PutRoute(ctx, rt)
 		if err != nil {
@@ -0,0 +1,104 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// SplitBrainAlarmsTotal tracks the number of join attempts refused because the
+// joining node's state diverged from the mesh.
+var SplitBrainAlarmsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "webmesh",
+	Name:      "split_brain_alarms_total",
+	Help:      "Total number of join attempts refused due to a diverged cluster lineage.",
+}, []string{"node_id", "remote_node_id"})
+
+// checkClusterLineage refuses the join if the node belongs to a different cluster.
+// If the node also has local state from that cluster, or log entries written in a
+// term this mesh never reached, it was part of a partition that elected its own
+// leader and diverged. Merging it automatically could silently lose writes, so a
+// split-brain alarm is raised for an administrator to resolve.
+func (s *Server) checkClusterLineage(ctx context.Context, nodeID types.NodeID) error {
+	st := s.storage.MeshStorage()
+	localID, err := storage.GetClusterID(ctx, st)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return status.Errorf(codes.Internal, "failed to get cluster id: %v", err)
+	}
+	remoteID, remoteTerm := storage.ClusterLineageFrom(ctx)
+	if remoteID == "" || localID == "" {
+		// A new node, or a node or mesh that predates cluster IDs.
+		return nil
+	}
+	var localTerm uint64
+	if tp, ok := s.storage.(storage.TermProvider); ok {
+		localTerm = tp.CurrentTerm()
+	}
+	if remoteID != localID && remoteTerm == 0 {
+		// The node has no state of its own, it was just pointed at the wrong mesh.
+		return status.Errorf(codes.PermissionDenied, "node belongs to cluster %s, this mesh is cluster %s", remoteID, localID)
+	}
+	var reason string
+	switch {
+	case remoteID != localID:
+		reason = fmt.Sprintf("node has state from cluster %s, this mesh is cluster %s", remoteID, localID)
+	case localTerm > 0 && remoteTerm > localTerm:
+		reason = fmt.Sprintf("node has log entries from term %d, this mesh is at term %d", remoteTerm, localTerm)
+	default:
+		return nil
+	}
+	alarm, err := storage.GetSplitBrainAlarm(ctx, st, nodeID)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return status.Errorf(codes.Internal, "failed to get split-brain alarm: %v", err)
+	}
+	if err == nil && alarm.AllowRejoin {
+		context.LoggerFrom(ctx).Warn("Admitting diverged node after split-brain resolution", slog.String("reason", reason))
+		if err := st.Delete(ctx, storage.SplitBrainAlarmPrefix.ForString(nodeID.String())); err != nil {
+			return status.Errorf(codes.Internal, "failed to clear split-brain alarm: %v", err)
+		}
+		return nil
+	}
+	alarm, err = storage.RaiseSplitBrainAlarm(ctx, st, storage.SplitBrainAlarm{
+		NodeID:          nodeID,
+		Reason:          reason,
+		LocalClusterID:  localID,
+		RemoteClusterID: remoteID,
+		LocalTerm:       localTerm,
+		RemoteTerm:      remoteTerm,
+	})
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to raise split-brain alarm: %v", err)
+	}
+	SplitBrainAlarmsTotal.WithLabelValues(s.nodeID.String(), nodeID.String()).Inc()
+	context.LoggerFrom(ctx).Error("SPLIT BRAIN DETECTED: refusing to merge diverged node, administrator action required",
+		slog.String("reason", reason),
+		slog.Int("attempts", alarm.Attempts),
+		slog.Any("recovery", alarm.Recovery),
+	)
+	return status.Errorf(codes.FailedPrecondition, "split brain detected: %s; refusing automatic merge until an administrator resolves the alarm", reason)
+}
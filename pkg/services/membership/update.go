@@ -28,7 +28,9 @@ import (
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
@@ -40,7 +42,7 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 		return nil, status.Errorf(codes.PermissionDenied, "request is not in-network")
 	}
 	if !s.storage.Consensus().IsLeader() {
-		return nil, status.Errorf(codes.FailedPrecondition, "not leader")
+		return nil, leaderproxy.NewNotLeaderError(ctx, s.storage, "not leader")
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -65,6 +67,14 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 			return nil, status.Errorf(codes.PermissionDenied, "node id %s does not match authenticated caller", req.GetId())
 		}
 	}
+	natType, natReported, err := reportedNATType(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gateway, gatewayReported, err := reportedGateway(ctx)
+	if err != nil {
+		return nil, err
+	}
 	if len(req.GetRoutes()) > 0 {
 		for _, route := range req.GetRoutes() {
 			route, err := netip.ParsePrefix(route)
@@ -84,6 +94,9 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 		if err != nil {
 			return nil, status.Errorf(codes.InvalidArgument, "invalid public key: %v", err)
 		}
+		if err := s.checkKeyRevoked(ctx, publicKey); err != nil {
+			return nil, err
+		}
 	}
 
 	// We can go ahead and check here if the node is allowed to do what they want.
@@ -120,6 +133,22 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 		// Peer doesn't exist, they need to call Join first
 		return nil, status.Errorf(codes.FailedPrecondition, "node %s not found", req.GetId())
 	}
+	// A quarantined node must not restore its withdrawn routes or rotate
+	// its key until it is released.
+	quarantined, err := storage.IsQuarantined(ctx, s.storage.MeshStorage(), peer.NodeID())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check quarantine: %v", err)
+	}
+	if quarantined {
+		return nil, status.Errorf(codes.PermissionDenied, "node %s is quarantined", req.GetId())
+	}
+	// The caller may still hold the key the node was registered with
+	// after it was revoked during a recovery.
+	if registered, err := crypto.DecodePublicKey(peer.GetPublicKey()); err == nil {
+		if err := s.checkKeyRevoked(ctx, registered); err != nil {
+			return nil, err
+		}
+	}
 	// Determine the peer's current status
 	for _, server := range storageStatus.GetPeers() {
 		if server.GetId() == peer.GetId() {
@@ -131,7 +160,7 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 	// Ensure any new routes
 	_, err = s.ensurePeerRoutes(ctx, peer.NodeID(), req.GetRoutes())
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to ensure peer routes: %v", err)
+		return nil, routesError(err)
 	}
 	// Overwrite any provided fields
 	var hasChanges bool
@@ -189,9 +218,23 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 			return nil, status.Errorf(codes.Internal, "failed to update peer: %v", err)
 		}
 	}
+	if natReported {
+		if err := s.recordNATType(ctx, peer.NodeID(), natType); err != nil {
+			return nil, err
+		}
+	}
+	if gatewayReported {
+		if err := s.recordGateway(ctx, peer.NodeID(), gateway); err != nil {
+			return nil, err
+		}
+	}
 
 	// Change to voter if requested and not already
 	if req.GetAsVoter() && currentSuffrage != v1.ClusterStatus_CLUSTER_VOTER {
+		if s.inMaintenance(ctx, types.NodeID(req.GetId())) {
+			log.Info("Node is in a maintenance window, not promoting to voter")
+			return nil, status.Errorf(codes.FailedPrecondition, "node %s is in a maintenance window and cannot be promoted to voter", req.GetId())
+		}
 		if currentAddress == "" {
 			return nil, status.Errorf(codes.Internal, "failed to lookup peer address")
 		}
@@ -201,6 +244,9 @@ func (s *Server) Update(ctx context.Context, req *v1.UpdateRequest) (*v1.UpdateR
 			Id:      req.GetId(),
 			Address: currentAddress,
 		}}); err != nil {
+			if errors.Is(err, errors.ErrIsLearner) {
+				return nil, status.Errorf(codes.FailedPrecondition, "node %s is a learner and cannot be promoted to voter", req.GetId())
+			}
 			return nil, status.Errorf(codes.Internal, "failed to promote to voter: %v", err)
 		}
 	}
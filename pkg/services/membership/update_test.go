@@ -0,0 +1,167 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package membership
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+	"github.com/webmeshproj/webmesh/pkg/plugins"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestUpdateRejectsIsolatedNodes(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { node.Close(ctx) })
+	s := NewServer(ctx, Options{
+		NodeID:  node.ID(),
+		Storage: node.Storage(),
+		Plugins: plugins.NewManagerWithDB(node.Storage()),
+		Meshnet: inNetworkMeshnet{node.Network()},
+	})
+	// Requests must come from inside the mesh.
+	inNetwork := inNetworkMeshnet{}.NetworkV4().Addr().Next()
+	reqctx := peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: inNetwork.AsSlice(), Port: 8443}})
+
+	tc := []struct {
+		name    string
+		id      string
+		isolate func(t *testing.T, id types.NodeID, key crypto.PublicKey)
+	}{
+		{
+			name: "quarantined",
+			id:   "node-a",
+			isolate: func(t *testing.T, id types.NodeID, key crypto.PublicKey) {
+				_, err := storage.QuarantineNode(ctx, s.storage.MeshDB(), s.storage.MeshStorage(), id, "test")
+				if err != nil {
+					t.Fatalf("quarantine node: %v", err)
+				}
+				// Only the quarantine itself should stop the node.
+				if err := s.storage.MeshStorage().Delete(ctx, storage.RevokedKeysPrefix.ForString(key.ID())); err != nil {
+					t.Fatalf("lift key revocation: %v", err)
+				}
+			},
+		},
+		{
+			name: "revoked registered key",
+			id:   "node-b",
+			isolate: func(t *testing.T, _ types.NodeID, key crypto.PublicKey) {
+				if err := storage.RevokeKey(ctx, s.storage.MeshStorage(), key); err != nil {
+					t.Fatalf("revoke key: %v", err)
+				}
+			},
+		},
+	}
+	for _, tt := range tc {
+		t.Run(tt.name, func(t *testing.T) {
+			key := crypto.MustGenerateKey().PublicKey()
+			registered, err := key.Encode()
+			if err != nil {
+				t.Fatalf("encode key: %v", err)
+			}
+			err = s.storage.MeshDB().Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: tt.id, PublicKey: registered}})
+			if err != nil {
+				t.Fatalf("register node: %v", err)
+			}
+			tt.isolate(t, types.NodeID(tt.id), key)
+			// Rotating to a fresh key must not lift the isolation.
+			newKey, err := crypto.MustGenerateKey().PublicKey().Encode()
+			if err != nil {
+				t.Fatalf("encode key: %v", err)
+			}
+			_, err = s.Update(reqctx, &v1.UpdateRequest{Id: tt.id, PublicKey: newKey})
+			if status.Code(err) != codes.PermissionDenied {
+				t.Fatalf("expected %v, got: %v", codes.PermissionDenied, err)
+			}
+			peer, err := s.storage.MeshDB().Peers().Get(ctx, types.NodeID(tt.id))
+			if err != nil {
+				t.Fatalf("get node: %v", err)
+			}
+			if peer.GetPublicKey() != registered {
+				t.Fatal("expected the registered key to be unchanged")
+			}
+		})
+	}
+}
+
+func TestUpdateVoterInMaintenance(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { node.Close(ctx) })
+	s := NewServer(ctx, Options{
+		NodeID:  node.ID(),
+		Storage: node.Storage(),
+		Plugins: plugins.NewManagerWithDB(node.Storage()),
+		RBAC:    rbac.NewNoopEvaluator(),
+		Meshnet: inNetworkMeshnet{node.Network()},
+	})
+	inNetwork := inNetworkMeshnet{}.NetworkV4().Addr().Next()
+	reqctx := peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: inNetwork.AsSlice(), Port: 8443}})
+	err = s.storage.MeshDB().Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: "node-a"}})
+	if err != nil {
+		t.Fatalf("register node: %v", err)
+	}
+	err = storage.PutMaintenanceWindow(ctx, s.storage.MeshStorage(), storage.MaintenanceWindow{
+		Name:  "upgrade",
+		Nodes: []types.NodeID{"node-a"},
+		Start: time.Now().Add(-time.Minute),
+		End:   time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatalf("put maintenance window: %v", err)
+	}
+	_, err = s.Update(reqctx, &v1.UpdateRequest{Id: "node-a", AsVoter: true})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected %v, got: %v", codes.FailedPrecondition, err)
+	}
+	// Updates that do not ask for a promotion are unaffected.
+	if _, err := s.Update(reqctx, &v1.UpdateRequest{Id: "node-a"}); err != nil {
+		t.Fatalf("update node: %v", err)
+	}
+}
+
+// inNetworkMeshnet reports a fixed mesh network so requests can be made from
+// inside it without a running interface.
+type inNetworkMeshnet struct {
+	meshnet.Manager
+}
+
+func (inNetworkMeshnet) NetworkV4() netip.Prefix {
+	return netip.MustParsePrefix("172.16.0.0/12")
+}
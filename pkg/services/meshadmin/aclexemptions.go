@@ -0,0 +1,82 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Exemptions let traffic pass every deny ACL in the mesh, so changing them is
+// only granted to callers with full access to the mesh.
+var (
+	getACLExemptionsAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_NETWORK_ACLS,
+			Verb:     v1.RuleVerb_VERB_GET,
+		},
+	}
+	putACLExemptionsAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_PUT,
+		},
+	}
+)
+
+// PutACLExemptions replaces the network ACL exemptions of the mesh with the
+// ones in the "exemptions" field of the request.
+func (s *Server) PutACLExemptions(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, putACLExemptionsAction, "put acl exemptions"); err != nil {
+		return nil, err
+	}
+	var exemptions types.ACLExemptions
+	if err := DecodeField(req, "exemptions", &exemptions); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := exemptions.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := storage.PutACLExemptions(ctx, s.storage.MeshStorage(), exemptions); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to put acl exemptions: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Updated network ACL exemptions")
+	return &structpb.Struct{}, nil
+}
+
+// GetACLExemptions returns the network ACL exemptions of the mesh in the
+// "exemptions" field.
+func (s *Server) GetACLExemptions(ctx context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.authorize(ctx, getACLExemptionsAction, "get acl exemptions"); err != nil {
+		return nil, err
+	}
+	exemptions, err := storage.GetACLExemptions(ctx, s.storage.MeshStorage())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get acl exemptions: %v", err)
+	}
+	return encodeFields(map[string]any{"exemptions": exemptions})
+}
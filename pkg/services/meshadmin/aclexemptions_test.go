@@ -0,0 +1,75 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestACLExemptions(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	putRequest := func(t *testing.T, exemptions types.ACLExemptions) *structpb.Struct {
+		t.Helper()
+		req, err := EncodeFields(map[string]any{"exemptions": exemptions})
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		return req
+	}
+
+	t.Run("Put", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		if _, err := s.PutACLExemptions(ctx, putRequest(t, types.ACLExemptions{ICMPEcho: true})); err != nil {
+			t.Fatalf("put acl exemptions: %v", err)
+		}
+		resp, err := s.GetACLExemptions(ctx, &structpb.Struct{})
+		if err != nil {
+			t.Fatalf("get acl exemptions: %v", err)
+		}
+		var got types.ACLExemptions
+		if err := DecodeField(resp, "exemptions", &got); err != nil {
+			t.Fatalf("decode acl exemptions: %v", err)
+		}
+		if !got.ICMPEcho || got.MeshDNS {
+			t.Fatalf("unexpected acl exemptions: %+v", got)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		_, err := s.PutACLExemptions(ctx, &structpb.Struct{})
+		expectCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		_, err := s.PutACLExemptions(ctx, putRequest(t, types.ACLExemptions{ICMPEcho: true}))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.GetACLExemptions(ctx, &structpb.Struct{})
+		expectCode(t, err, codes.PermissionDenied)
+	})
+}
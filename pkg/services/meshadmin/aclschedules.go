@@ -0,0 +1,121 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// A schedule decides when a network ACL is in effect, so it is authorized as
+// the network ACL it belongs to. Removing a schedule changes the ACL as much
+// as putting one, so both require permission to put the ACL.
+var (
+	getACLSchedulesAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_NETWORK_ACLS,
+			Verb:     v1.RuleVerb_VERB_GET,
+		},
+	}
+	putACLScheduleAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_NETWORK_ACLS,
+			Verb:     v1.RuleVerb_VERB_PUT,
+		},
+	}
+)
+
+// PutACLSchedule sets the schedule of the network ACL with the given "name"
+// to the one in the "schedule" field of the request.
+func (s *Server) PutACLSchedule(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	name := req.GetFields()["name"].GetStringValue()
+	if !types.IsValidID(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid network acl name %q", name)
+	}
+	if err := s.authorize(ctx, putACLScheduleAction.For(name), "put acl schedules"); err != nil {
+		return nil, err
+	}
+	var schedule types.ACLSchedule
+	if err := DecodeField(req, "schedule", &schedule); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := schedule.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := storage.PutACLSchedule(ctx, s.storage.MeshStorage(), name, schedule); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to put acl schedule: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Put network ACL schedule", "name", name)
+	return &structpb.Struct{}, nil
+}
+
+// GetACLSchedules returns the schedules of all network ACLs in the
+// "schedules" field. If the request has a "name", only the schedule of that
+// ACL is returned.
+func (s *Server) GetACLSchedules(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if name := req.GetFields()["name"].GetStringValue(); name != "" {
+		if err := s.authorize(ctx, getACLSchedulesAction.For(name), "get acl schedules"); err != nil {
+			return nil, err
+		}
+		schedule, ok, err := storage.GetACLSchedule(ctx, s.storage.MeshStorage(), name)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get acl schedule: %v", err)
+		}
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "network acl %s has no schedule", name)
+		}
+		return encodeFields(map[string]any{"schedules": types.ACLSchedules{name: schedule}})
+	}
+	if err := s.authorize(ctx, getACLSchedulesAction, "get acl schedules"); err != nil {
+		return nil, err
+	}
+	schedules, err := storage.ListACLSchedules(ctx, s.storage.MeshStorage())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list acl schedules: %v", err)
+	}
+	return encodeFields(map[string]any{"schedules": schedules})
+}
+
+// DeleteACLSchedule removes the schedule of the network ACL with the given
+// "name", putting it in effect at all times.
+func (s *Server) DeleteACLSchedule(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	name := req.GetFields()["name"].GetStringValue()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "network acl name is required")
+	}
+	if err := s.authorize(ctx, putACLScheduleAction.For(name), "delete acl schedules"); err != nil {
+		return nil, err
+	}
+	if err := storage.DeleteACLSchedule(ctx, s.storage.MeshStorage(), name); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete acl schedule: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Deleted network ACL schedule", "name", name)
+	return &structpb.Struct{}, nil
+}
@@ -0,0 +1,95 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestACLSchedules(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	putRequest := func(t *testing.T, name string, schedule types.ACLSchedule) *structpb.Struct {
+		t.Helper()
+		req, err := EncodeFields(map[string]any{"name": name, "schedule": schedule})
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		return req
+	}
+	nameRequest := func(name string) *structpb.Struct {
+		return &structpb.Struct{Fields: map[string]*structpb.Value{"name": structpb.NewStringValue(name)}}
+	}
+	window, err := types.ParseACLWindow("mon-fri 02:00-04:00")
+	if err != nil {
+		t.Fatalf("parse window: %v", err)
+	}
+	schedule := types.ACLSchedule{Windows: []types.ACLWindow{window}}
+
+	t.Run("PutGetDelete", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		if _, err := s.PutACLSchedule(ctx, putRequest(t, "maintenance", schedule)); err != nil {
+			t.Fatalf("put acl schedule: %v", err)
+		}
+		resp, err := s.GetACLSchedules(ctx, nameRequest("maintenance"))
+		if err != nil {
+			t.Fatalf("get acl schedule: %v", err)
+		}
+		var got types.ACLSchedules
+		if err := DecodeField(resp, "schedules", &got); err != nil {
+			t.Fatalf("decode acl schedules: %v", err)
+		}
+		if len(got["maintenance"].Windows) != 1 {
+			t.Fatalf("unexpected acl schedules: %+v", got)
+		}
+		if _, err := s.DeleteACLSchedule(ctx, nameRequest("maintenance")); err != nil {
+			t.Fatalf("delete acl schedule: %v", err)
+		}
+		_, err = s.GetACLSchedules(ctx, nameRequest("maintenance"))
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		_, err := s.PutACLSchedule(ctx, putRequest(t, "not an acl", schedule))
+		expectCode(t, err, codes.InvalidArgument)
+		start := time.Now()
+		end := start.Add(-time.Hour)
+		_, err = s.PutACLSchedule(ctx, putRequest(t, "maintenance", types.ACLSchedule{Start: &start, End: &end}))
+		expectCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		_, err := s.PutACLSchedule(ctx, putRequest(t, "maintenance", schedule))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.DeleteACLSchedule(ctx, nameRequest("maintenance"))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.GetACLSchedules(ctx, &structpb.Struct{})
+		expectCode(t, err, codes.PermissionDenied)
+	})
+}
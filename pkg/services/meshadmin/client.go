@@ -0,0 +1,239 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// MeshAdminClient is the client API for the mesh admin service.
+type MeshAdminClient interface {
+	// GetEscrowedKey returns the wrapped identity key escrowed for a node.
+	GetEscrowedKey(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// RecoverNode removes a lost node's registration, revokes its keys,
+	// and escrows the key issued to its replacement.
+	RecoverNode(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// ListMembershipEvents lists the membership history.
+	ListMembershipEvents(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// ListQuarantines lists the quarantined nodes.
+	ListQuarantines(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// QuarantineNode isolates a node from the mesh and withdraws its routes.
+	QuarantineNode(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// ReleaseQuarantine lifts the quarantine on a node.
+	ReleaseQuarantine(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// PurgeNode removes a quarantined node from the mesh.
+	PurgeNode(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// PutMaintenanceWindow creates or updates a maintenance window.
+	PutMaintenanceWindow(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// GetMaintenanceWindows returns the maintenance windows that have not ended.
+	GetMaintenanceWindows(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// DeleteMaintenanceWindow deletes a maintenance window.
+	DeleteMaintenanceWindow(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// ListSplitBrainAlarms returns the raised split-brain alarms.
+	ListSplitBrainAlarms(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// ResolveSplitBrain resolves the split-brain alarm raised for a node.
+	ResolveSplitBrain(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// PutNamespace creates or updates a namespace and assigns nodes to it.
+	PutNamespace(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// GetNamespaces returns namespaces along with their nodes and usage.
+	GetNamespaces(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// DeleteNamespace deletes a namespace.
+	DeleteNamespace(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// RenameNode changes the ID of a node and every reference to it.
+	RenameNode(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// SetDefaultNetworkPolicy changes the default network policy of the mesh.
+	SetDefaultNetworkPolicy(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// GetDefaultNetworkPolicy returns the default network policy of the mesh.
+	GetDefaultNetworkPolicy(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// PutACLExemptions replaces the network ACL exemptions of the mesh.
+	PutACLExemptions(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// GetACLExemptions returns the network ACL exemptions of the mesh.
+	GetACLExemptions(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// PutACLSchedule sets the schedule of a network ACL.
+	PutACLSchedule(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// GetACLSchedules returns the schedules of network ACLs.
+	GetACLSchedules(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// DeleteACLSchedule removes the schedule of a network ACL.
+	DeleteACLSchedule(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// PutNodeLabels replaces the labels of a node.
+	PutNodeLabels(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// GetNodeLabels returns the labels of nodes.
+	GetNodeLabels(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// DeleteNodeLabels removes all labels from a node.
+	DeleteNodeLabels(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// PutTrafficPolicy creates or replaces a traffic policy.
+	PutTrafficPolicy(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// GetTrafficPolicies returns traffic policies.
+	GetTrafficPolicies(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// DeleteTrafficPolicy deletes a traffic policy.
+	DeleteTrafficPolicy(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// PutRouteMetric sets the metric of a route.
+	PutRouteMetric(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// GetRouteMetrics returns the metrics of routes.
+	GetRouteMetrics(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// DeleteRouteMetric resets the metric of a route to zero.
+	DeleteRouteMetric(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+}
+
+// NewMeshAdminClient returns a new mesh admin client using the given connection.
+func NewMeshAdminClient(cc grpc.ClientConnInterface) MeshAdminClient {
+	return &meshAdminClient{cc}
+}
+
+type meshAdminClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *meshAdminClient) invoke(ctx context.Context, method string, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	out := new(structpb.Struct)
+	err := c.cc.Invoke(ctx, method, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *meshAdminClient) GetEscrowedKey(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, GetEscrowedKeyFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) RecoverNode(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, RecoverNodeFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) ListMembershipEvents(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, ListMembershipEventsFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) ListQuarantines(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, ListQuarantinesFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) QuarantineNode(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, QuarantineNodeFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) ReleaseQuarantine(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, ReleaseQuarantineFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) PurgeNode(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, PurgeNodeFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) PutMaintenanceWindow(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, PutMaintenanceWindowFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) GetMaintenanceWindows(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, GetMaintenanceWindowsFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) DeleteMaintenanceWindow(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, DeleteMaintenanceWindowFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) ListSplitBrainAlarms(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, ListSplitBrainAlarmsFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) ResolveSplitBrain(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, ResolveSplitBrainFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) PutNamespace(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, PutNamespaceFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) GetNamespaces(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, GetNamespacesFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) DeleteNamespace(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, DeleteNamespaceFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) RenameNode(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, RenameNodeFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) SetDefaultNetworkPolicy(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, SetDefaultNetworkPolicyFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) GetDefaultNetworkPolicy(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, GetDefaultNetworkPolicyFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) PutACLExemptions(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, PutACLExemptionsFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) GetACLExemptions(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, GetACLExemptionsFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) PutACLSchedule(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, PutACLScheduleFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) GetACLSchedules(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, GetACLSchedulesFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) DeleteACLSchedule(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, DeleteACLScheduleFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) PutNodeLabels(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, PutNodeLabelsFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) GetNodeLabels(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, GetNodeLabelsFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) DeleteNodeLabels(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, DeleteNodeLabelsFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) PutTrafficPolicy(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, PutTrafficPolicyFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) GetTrafficPolicies(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, GetTrafficPoliciesFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) DeleteTrafficPolicy(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, DeleteTrafficPolicyFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) PutRouteMetric(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, PutRouteMetricFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) GetRouteMetrics(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, GetRouteMetricsFullMethodName, in, opts...)
+}
+
+func (c *meshAdminClient) DeleteRouteMetric(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	return c.invoke(ctx, DeleteRouteMetricFullMethodName, in, opts...)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+)
+
+// The default network policy is enforced by the catch-all network ACLs, so
+// changing it is authorized as replacing them.
+var (
+	getDefaultNetworkPolicyAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_NETWORK_ACLS,
+			Verb:     v1.RuleVerb_VERB_GET,
+		},
+	}
+	putDefaultAcceptACLAction = rbac.Actions{
+		{
+			Resource:     v1.RuleResource_RESOURCE_NETWORK_ACLS,
+			ResourceName: storage.DefaultAcceptNetworkACLName,
+			Verb:         v1.RuleVerb_VERB_PUT,
+		},
+		{
+			Resource:     v1.RuleResource_RESOURCE_NETWORK_ACLS,
+			ResourceName: storage.DefaultDenyNetworkACLName,
+			Verb:         v1.RuleVerb_VERB_DELETE,
+		},
+	}
+	putDefaultDenyACLAction = rbac.Actions{
+		{
+			Resource:     v1.RuleResource_RESOURCE_NETWORK_ACLS,
+			ResourceName: storage.DefaultDenyNetworkACLName,
+			Verb:         v1.RuleVerb_VERB_PUT,
+		},
+		{
+			Resource:     v1.RuleResource_RESOURCE_NETWORK_ACLS,
+			ResourceName: storage.DefaultAcceptNetworkACLName,
+			Verb:         v1.RuleVerb_VERB_DELETE,
+		},
+	}
+)
+
+// SetDefaultNetworkPolicy changes the default network policy of the mesh to
+// the given "policy". The catch-all network ACLs and the recorded policy are
+// replaced in a single transaction.
+func (s *Server) SetDefaultNetworkPolicy(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	policy := req.GetFields()["policy"].GetStringValue()
+	if err := storage.ValidateNetworkPolicy(policy); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	actions := putDefaultAcceptACLAction
+	if policy == storage.NetworkPolicyDrop {
+		actions = putDefaultDenyACLAction
+	}
+	if err := s.authorize(ctx, actions, "set the default network policy"); err != nil {
+		return nil, err
+	}
+	txn := storage.NewTxnStorage(s.storage.MeshStorage())
+	if err := storage.SetDefaultNetworkPolicy(ctx, meshdb.NewFromStorage(txn), txn, policy); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to set default network policy: %v", err)
+	}
+	if err := txn.Commit(ctx); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to set default network policy: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Set default network policy", "policy", policy)
+	return &structpb.Struct{}, nil
+}
+
+// GetDefaultNetworkPolicy returns the default network policy of the mesh in
+// the "policy" field.
+func (s *Server) GetDefaultNetworkPolicy(ctx context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.authorize(ctx, getDefaultNetworkPolicyAction, "get the default network policy"); err != nil {
+		return nil, err
+	}
+	policy, err := storage.GetDefaultNetworkPolicy(ctx, s.storage.MeshDB(), s.storage.MeshStorage())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to get default network policy: %v", err)
+	}
+	return encodeFields(map[string]any{"policy": policy})
+}
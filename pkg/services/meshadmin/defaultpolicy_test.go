@@ -0,0 +1,74 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+func TestDefaultNetworkPolicy(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	policyRequest := func(policy string) *structpb.Struct {
+		return &structpb.Struct{Fields: map[string]*structpb.Value{"policy": structpb.NewStringValue(policy)}}
+	}
+
+	t.Run("Set", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		if _, err := s.SetDefaultNetworkPolicy(ctx, policyRequest(storage.NetworkPolicyDrop)); err != nil {
+			t.Fatalf("set default network policy: %v", err)
+		}
+		resp, err := s.GetDefaultNetworkPolicy(ctx, &structpb.Struct{})
+		if err != nil {
+			t.Fatalf("get default network policy: %v", err)
+		}
+		if got := resp.GetFields()["policy"].GetStringValue(); got != storage.NetworkPolicyDrop {
+			t.Fatalf("expected policy %q, got %q", storage.NetworkPolicyDrop, got)
+		}
+		nw := s.storage.MeshDB().Networking()
+		if _, err := nw.GetNetworkACL(ctx, storage.DefaultDenyNetworkACLName); err != nil {
+			t.Fatalf("expected %s network acl: %v", storage.DefaultDenyNetworkACLName, err)
+		}
+		if _, err := nw.GetNetworkACL(ctx, storage.DefaultAcceptNetworkACLName); !errors.IsACLNotFound(err) {
+			t.Fatalf("expected %s network acl to be removed, got: %v", storage.DefaultAcceptNetworkACLName, err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		_, err := s.SetDefaultNetworkPolicy(ctx, policyRequest("maybe"))
+		expectCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		_, err := s.SetDefaultNetworkPolicy(ctx, policyRequest(storage.NetworkPolicyDrop))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.GetDefaultNetworkPolicy(ctx, &structpb.Struct{})
+		expectCode(t, err, codes.PermissionDenied)
+	})
+}
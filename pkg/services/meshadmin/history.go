@@ -0,0 +1,102 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"fmt"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+var listMembershipEventsAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_ALL,
+		Verb:     v1.RuleVerb_VERB_GET,
+	},
+}
+
+// EncodeMembershipEventFilter encodes a filter for the ListMembershipEvents RPC.
+func EncodeMembershipEventFilter(filter storage.MembershipEventFilter) *structpb.Struct {
+	fields := map[string]*structpb.Value{}
+	if filter.NodeID != "" {
+		fields["id"] = structpb.NewStringValue(filter.NodeID.String())
+	}
+	if filter.Type != "" {
+		fields["type"] = structpb.NewStringValue(string(filter.Type))
+	}
+	if !filter.Since.IsZero() {
+		fields["since"] = structpb.NewStringValue(filter.Since.UTC().Format(time.RFC3339Nano))
+	}
+	if !filter.Until.IsZero() {
+		fields["until"] = structpb.NewStringValue(filter.Until.UTC().Format(time.RFC3339Nano))
+	}
+	return &structpb.Struct{Fields: fields}
+}
+
+// DecodeMembershipEventFilter decodes a ListMembershipEvents request.
+func DecodeMembershipEventFilter(req *structpb.Struct) (storage.MembershipEventFilter, error) {
+	var filter storage.MembershipEventFilter
+	fields := req.GetFields()
+	if id := fields["id"].GetStringValue(); id != "" {
+		if !types.IsValidNodeID(id) {
+			return filter, fmt.Errorf("invalid node id %q", id)
+		}
+		filter.NodeID = types.NodeID(id)
+	}
+	if typ := fields["type"].GetStringValue(); typ != "" {
+		filter.Type = storage.MembershipEventType(typ)
+		if !filter.Type.IsValid() {
+			return filter, fmt.Errorf("invalid event type %q", typ)
+		}
+	}
+	var err error
+	for name, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := fields[name].GetStringValue(); v != "" {
+			*t, err = time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s time %q: %w", name, v, err)
+			}
+		}
+	}
+	return filter, nil
+}
+
+// ListMembershipEvents lists the membership history matching the filter in
+// the request, oldest first. The events are returned in the "events" field.
+func (s *Server) ListMembershipEvents(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.authorize(ctx, listMembershipEventsAction, "get the membership history"); err != nil {
+		return nil, err
+	}
+	filter, err := DecodeMembershipEventFilter(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	events, err := storage.ListMembershipEvents(ctx, s.storage.MeshStorage(), filter)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list membership events: %v", err)
+	}
+	return encodeFields(map[string]any{"events": events})
+}
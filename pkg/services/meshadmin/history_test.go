@@ -0,0 +1,68 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+func TestListMembershipEvents(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	s := newTestServer(t, false)
+	now := time.Now().UTC()
+	for i, ev := range []storage.MembershipEvent{
+		{Type: storage.MembershipEventJoin, NodeID: "node-a", Time: now.Add(-time.Hour)},
+		{Type: storage.MembershipEventJoin, NodeID: "node-b", Time: now.Add(-time.Minute)},
+		{Type: storage.MembershipEventLeave, NodeID: "node-a", Time: now, Reason: "shutdown"},
+	} {
+		if err := storage.RecordMembershipEvent(ctx, s.storage.MeshStorage(), ev); err != nil {
+			t.Fatalf("record event %d: %v", i, err)
+		}
+	}
+
+	list := func(t *testing.T, filter storage.MembershipEventFilter) []storage.MembershipEvent {
+		t.Helper()
+		resp, err := s.ListMembershipEvents(ctx, EncodeMembershipEventFilter(filter))
+		if err != nil {
+			t.Fatalf("list membership events: %v", err)
+		}
+		var events []storage.MembershipEvent
+		if err := DecodeField(resp, "events", &events); err != nil {
+			t.Fatalf("decode events: %v", err)
+		}
+		return events
+	}
+	if events := list(t, storage.MembershipEventFilter{}); len(events) != 3 {
+		t.Fatalf("expected 3 events, got %d", len(events))
+	}
+	events := list(t, storage.MembershipEventFilter{NodeID: "node-a", Since: now.Add(-30 * time.Minute)})
+	if len(events) != 1 || events[0].Type != storage.MembershipEventLeave || events[0].Reason != "shutdown" {
+		t.Fatalf("expected the leave event of node-a, got %+v", events)
+	}
+
+	_, err := s.ListMembershipEvents(ctx, EncodeMembershipEventFilter(storage.MembershipEventFilter{Type: "bogus"}))
+	expectCode(t, err, codes.InvalidArgument)
+	_, err = newTestServer(t, true).ListMembershipEvents(ctx, EncodeMembershipEventFilter(storage.MembershipEventFilter{}))
+	expectCode(t, err, codes.PermissionDenied)
+}
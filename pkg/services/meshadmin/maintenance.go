@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// Maintenance windows suppress heartbeat reaping and voter promotion for
+// the nodes they cover, so they are only granted to callers with full
+// access to the mesh.
+var (
+	getMaintenanceWindowsAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_GET,
+		},
+	}
+	putMaintenanceWindowAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_PUT,
+		},
+	}
+	deleteMaintenanceWindowAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_DELETE,
+		},
+	}
+)
+
+// PutMaintenanceWindow creates or updates the maintenance window in the
+// "window" field of the request.
+func (s *Server) PutMaintenanceWindow(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, putMaintenanceWindowAction, "put maintenance windows"); err != nil {
+		return nil, err
+	}
+	var w storage.MaintenanceWindow
+	if err := DecodeField(req, "window", &w); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := w.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := storage.PutMaintenanceWindow(ctx, s.storage.MeshStorage(), w); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "failed to put maintenance window: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Put maintenance window", "name", w.Name, "nodes", len(w.Nodes), "end", w.End)
+	return &structpb.Struct{}, nil
+}
+
+// GetMaintenanceWindows returns the maintenance windows that have not ended
+// in the "windows" field. If the request has a "name", only that window is
+// returned.
+func (s *Server) GetMaintenanceWindows(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.authorize(ctx, getMaintenanceWindowsAction, "get maintenance windows"); err != nil {
+		return nil, err
+	}
+	if name := req.GetFields()["name"].GetStringValue(); name != "" {
+		w, err := storage.GetMaintenanceWindow(ctx, s.storage.MeshStorage(), name)
+		if err != nil {
+			if errors.IsKeyNotFound(err) {
+				return nil, status.Errorf(codes.NotFound, "maintenance window %s not found", name)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to get maintenance window: %v", err)
+		}
+		return encodeFields(map[string]any{"windows": []storage.MaintenanceWindow{w}})
+	}
+	windows, err := storage.ListMaintenanceWindows(ctx, s.storage.MeshStorage())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list maintenance windows: %v", err)
+	}
+	return encodeFields(map[string]any{"windows": windows})
+}
+
+// DeleteMaintenanceWindow deletes the maintenance window with the given
+// "name".
+func (s *Server) DeleteMaintenanceWindow(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, deleteMaintenanceWindowAction, "delete maintenance windows"); err != nil {
+		return nil, err
+	}
+	name := req.GetFields()["name"].GetStringValue()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "maintenance window name is required")
+	}
+	if err := storage.DeleteMaintenanceWindow(ctx, s.storage.MeshStorage(), name); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete maintenance window: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Deleted maintenance window", "name", name)
+	return &structpb.Struct{}, nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestMaintenanceWindows(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Now().UTC()
+	put := func(t *testing.T, s *Server, w storage.MaintenanceWindow) error {
+		t.Helper()
+		req, err := EncodeFields(map[string]any{"window": w})
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		_, err = s.PutMaintenanceWindow(ctx, req)
+		return err
+	}
+	nameRequest := func(name string) *structpb.Struct {
+		return &structpb.Struct{Fields: map[string]*structpb.Value{"name": structpb.NewStringValue(name)}}
+	}
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		w := storage.MaintenanceWindow{
+			Name:   "upgrade",
+			Nodes:  []types.NodeID{"node-a"},
+			Start:  now.Add(-time.Minute),
+			End:    now.Add(time.Hour),
+			Reason: "kernel upgrade",
+		}
+		if err := put(t, s, w); err != nil {
+			t.Fatalf("put maintenance window: %v", err)
+		}
+		inMaintenance, err := storage.InMaintenance(ctx, s.storage.MeshStorage(), "node-a")
+		if err != nil {
+			t.Fatalf("check maintenance: %v", err)
+		}
+		if !inMaintenance {
+			t.Fatal("expected node-a to be in maintenance")
+		}
+		resp, err := s.GetMaintenanceWindows(ctx, nameRequest("upgrade"))
+		if err != nil {
+			t.Fatalf("get maintenance window: %v", err)
+		}
+		var windows []storage.MaintenanceWindow
+		if err := DecodeField(resp, "windows", &windows); err != nil {
+			t.Fatalf("decode windows: %v", err)
+		}
+		if len(windows) != 1 || windows[0].Reason != w.Reason || !windows[0].End.Equal(w.End) {
+			t.Fatalf("unexpected windows: %+v", windows)
+		}
+		if _, err := s.DeleteMaintenanceWindow(ctx, nameRequest("upgrade")); err != nil {
+			t.Fatalf("delete maintenance window: %v", err)
+		}
+		_, err = s.GetMaintenanceWindows(ctx, nameRequest("upgrade"))
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		tc := []struct {
+			name   string
+			window storage.MaintenanceWindow
+		}{
+			{"no name", storage.MaintenanceWindow{End: now.Add(time.Hour)}},
+			{"no end", storage.MaintenanceWindow{Name: "upgrade"}},
+			{"ended", storage.MaintenanceWindow{Name: "upgrade", Start: now.Add(-time.Hour), End: now.Add(-time.Minute)}},
+			{"invalid node", storage.MaintenanceWindow{Name: "upgrade", Nodes: []types.NodeID{"not a node"}, End: now.Add(time.Hour)}},
+		}
+		for _, tt := range tc {
+			tt := tt
+			t.Run(tt.name, func(t *testing.T) {
+				t.Parallel()
+				expectCode(t, put(t, s, tt.window), codes.InvalidArgument)
+			})
+		}
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		err := put(t, s, storage.MaintenanceWindow{Name: "upgrade", End: now.Add(time.Hour)})
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.GetMaintenanceWindows(ctx, &structpb.Struct{})
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.DeleteMaintenanceWindow(ctx, nameRequest("upgrade"))
+		expectCode(t, err, codes.PermissionDenied)
+	})
+}
@@ -0,0 +1,199 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"sort"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Namespaces confine role bindings and isolate nodes, so managing them is
+// authorized the same as role bindings, scoped to the name of the namespace.
+var (
+	getNamespacesAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ROLE_BINDINGS,
+			Verb:     v1.RuleVerb_VERB_GET,
+		},
+	}
+	putNamespaceAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ROLE_BINDINGS,
+			Verb:     v1.RuleVerb_VERB_PUT,
+		},
+	}
+	deleteNamespaceAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ROLE_BINDINGS,
+			Verb:     v1.RuleVerb_VERB_DELETE,
+		},
+	}
+)
+
+// NamespaceStatus is a namespace along with the nodes assigned to it and the
+// resources it uses.
+type NamespaceStatus struct {
+	storage.Namespace `json:",inline"`
+	Nodes             []types.NodeID         `json:"nodes,omitempty"`
+	Usage             storage.NamespaceUsage `json:"usage"`
+}
+
+// PutNamespace creates or updates the namespace in the "namespace" field of
+// the request and assigns the nodes in the "nodes" field to it. When
+// "assignOnly" is set, an existing namespace is left as it is and only the
+// nodes are assigned. Moving a node out of another namespace requires
+// permission on that namespace as well.
+func (s *Server) PutNamespace(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	var ns storage.Namespace
+	if err := DecodeField(req, "namespace", &ns); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var nodes []types.NodeID
+	if _, ok := req.GetFields()["nodes"]; ok {
+		if err := DecodeField(req, "nodes", &nodes); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if err := ns.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for _, node := range nodes {
+		if !node.IsValid() {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid node id %q", node)
+		}
+	}
+	if err := s.authorize(ctx, putNamespaceAction.For(ns.Name), "put namespace "+ns.Name); err != nil {
+		return nil, err
+	}
+	st := s.storage.MeshStorage()
+	for _, node := range nodes {
+		current, err := storage.GetNodeNamespace(ctx, st, node)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get namespace of %s: %v", node, err)
+		}
+		if current == ns.Name {
+			continue
+		}
+		if err := s.authorize(ctx, putNamespaceAction.For(current), "move nodes out of namespace "+current); err != nil {
+			return nil, err
+		}
+	}
+	_, err := storage.GetNamespace(ctx, st, ns.Name)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return nil, status.Errorf(codes.Internal, "failed to get namespace: %v", err)
+	}
+	assignOnly := req.GetFields()["assignOnly"].GetBoolValue()
+	txn := storage.NewTxnStorage(st)
+	switch {
+	case assignOnly && (err == nil || ns.Name == storage.DefaultNamespace):
+		// Only assigning nodes, leave the namespace as it is. The default
+		// namespace does not need a record for that.
+	default:
+		if err := storage.PutNamespace(ctx, txn, ns); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to put namespace: %v", err)
+		}
+	}
+	for _, node := range nodes {
+		if err := storage.SetNodeNamespace(ctx, txn, node, ns.Name); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "failed to assign %s: %v", node, err)
+		}
+	}
+	if err := txn.Commit(ctx); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to put namespace: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Put namespace", "name", ns.Name, "nodes", nodes)
+	return &structpb.Struct{}, nil
+}
+
+// GetNamespaces returns the namespaces in the "namespaces" field as
+// NamespaceStatus values. If the request has a "name", only that namespace is
+// returned.
+func (s *Server) GetNamespaces(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	name := req.GetFields()["name"].GetStringValue()
+	what, actions := "get namespaces", getNamespacesAction
+	if name != "" {
+		what, actions = "get namespace "+name, getNamespacesAction.For(name)
+	}
+	if err := s.authorize(ctx, actions, what); err != nil {
+		return nil, err
+	}
+	st := s.storage.MeshStorage()
+	namespaces, err := storage.ListNamespaces(ctx, st)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list namespaces: %v", err)
+	}
+	members, err := storage.ListNamespaceMembers(ctx, st)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list namespace members: %v", err)
+	}
+	db := s.storage.MeshDB()
+	out := make([]NamespaceStatus, 0, len(namespaces))
+	for _, ns := range namespaces {
+		if name != "" && ns.Name != name {
+			continue
+		}
+		o := NamespaceStatus{Namespace: ns}
+		for node, nsName := range members {
+			if nsName == ns.Name {
+				o.Nodes = append(o.Nodes, node)
+			}
+		}
+		sort.Slice(o.Nodes, func(i, j int) bool { return o.Nodes[i] < o.Nodes[j] })
+		o.Usage, err = storage.GetNamespaceUsage(ctx, db, st, ns.Name)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get usage of %s: %v", ns.Name, err)
+		}
+		out = append(out, o)
+	}
+	if name != "" && len(out) == 0 {
+		return nil, status.Errorf(codes.NotFound, "namespace %s not found", name)
+	}
+	return encodeFields(map[string]any{"namespaces": out})
+}
+
+// DeleteNamespace deletes the namespace with the given "name". Namespaces
+// that still have nodes assigned to them can not be deleted.
+func (s *Server) DeleteNamespace(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	name := req.GetFields()["name"].GetStringValue()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "namespace name is required")
+	}
+	if err := s.authorize(ctx, deleteNamespaceAction.For(name), "delete namespace "+name); err != nil {
+		return nil, err
+	}
+	if err := storage.DeleteNamespace(ctx, s.storage.MeshStorage(), name); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "failed to delete namespace: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Deleted namespace", "name", name)
+	return &structpb.Struct{}, nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestNamespaces(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	put := func(t *testing.T, s *Server, ns storage.Namespace, nodes ...types.NodeID) error {
+		t.Helper()
+		req, err := EncodeFields(map[string]any{"namespace": ns, "nodes": nodes})
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		_, err = s.PutNamespace(ctx, req)
+		return err
+	}
+	nameRequest := func(name string) *structpb.Struct {
+		return &structpb.Struct{Fields: map[string]*structpb.Value{"name": structpb.NewStringValue(name)}}
+	}
+
+	t.Run("Lifecycle", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		registerNode(t, s, "node-a", crypto.MustGenerateKey().PublicKey())
+		if err := put(t, s, storage.Namespace{Name: "team-a", Peers: []string{"team-b"}}, "node-a"); err != nil {
+			t.Fatalf("put namespace: %v", err)
+		}
+		resp, err := s.GetNamespaces(ctx, nameRequest("team-a"))
+		if err != nil {
+			t.Fatalf("get namespace: %v", err)
+		}
+		var namespaces []NamespaceStatus
+		if err := DecodeField(resp, "namespaces", &namespaces); err != nil {
+			t.Fatalf("decode namespaces: %v", err)
+		}
+		if len(namespaces) != 1 || !namespaces[0].PeersWith("team-b") || len(namespaces[0].Nodes) != 1 || namespaces[0].Nodes[0] != "node-a" {
+			t.Fatalf("unexpected namespaces: %+v", namespaces)
+		}
+		if namespaces[0].Usage.Nodes != 1 {
+			t.Fatalf("expected usage of one node, got %+v", namespaces[0].Usage)
+		}
+		// Namespaces with nodes can not be deleted.
+		_, err = s.DeleteNamespace(ctx, nameRequest("team-a"))
+		expectCode(t, err, codes.FailedPrecondition)
+		if err := put(t, s, storage.Namespace{Name: storage.DefaultNamespace}, "node-a"); err != nil {
+			t.Fatalf("return node to default namespace: %v", err)
+		}
+		if _, err := s.DeleteNamespace(ctx, nameRequest("team-a")); err != nil {
+			t.Fatalf("delete namespace: %v", err)
+		}
+		_, err = s.GetNamespaces(ctx, nameRequest("team-a"))
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		tc := []struct {
+			name  string
+			ns    storage.Namespace
+			nodes []types.NodeID
+		}{
+			{"no name", storage.Namespace{}, nil},
+			{"peers with itself", storage.Namespace{Name: "team-a", Peers: []string{"team-a"}}, nil},
+			{"scoped default", storage.Namespace{Name: storage.DefaultNamespace, RoleBindings: []string{"admins"}}, nil},
+			{"invalid node", storage.Namespace{Name: "team-a"}, []types.NodeID{"not a node"}},
+		}
+		for _, tt := range tc {
+			tt := tt
+			t.Run(tt.name, func(t *testing.T) {
+				t.Parallel()
+				expectCode(t, put(t, s, tt.ns, tt.nodes...), codes.InvalidArgument)
+			})
+		}
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		err := put(t, s, storage.Namespace{Name: "team-a"})
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.GetNamespaces(ctx, &structpb.Struct{})
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.DeleteNamespace(ctx, nameRequest("team-a"))
+		expectCode(t, err, codes.PermissionDenied)
+	})
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Labels select nodes into any network ACL referring to them, so changing
+// them is only granted to callers with full access to the mesh. Reading them
+// is authorized as reading network ACLs.
+var (
+	getNodeLabelsAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_NETWORK_ACLS,
+			Verb:     v1.RuleVerb_VERB_GET,
+		},
+	}
+	putNodeLabelsAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_PUT,
+		},
+	}
+	deleteNodeLabelsAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_DELETE,
+		},
+	}
+)
+
+// PutNodeLabels replaces the labels of the node with the given "id" with the
+// ones in the "labels" field of the request. Putting empty labels removes
+// them.
+func (s *Server) PutNodeLabels(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, putNodeLabelsAction, "put node labels"); err != nil {
+		return nil, err
+	}
+	nodeID, err := decodeNodeID(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	var labels types.NodeLabels
+	if err := DecodeField(req, "labels", &labels); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := labels.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := storage.PutNodeLabels(ctx, s.storage.MeshStorage(), nodeID, labels); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to put node labels: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Put node labels", "node", nodeID.String(), "labels", len(labels))
+	return &structpb.Struct{}, nil
+}
+
+// GetNodeLabels returns the labels of every labeled node in the "labels"
+// field, indexed by node ID. If the request has an "id", only the labels of
+// that node are returned.
+func (s *Server) GetNodeLabels(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.authorize(ctx, getNodeLabelsAction, "get node labels"); err != nil {
+		return nil, err
+	}
+	if _, ok := req.GetFields()["id"]; ok {
+		nodeID, err := decodeNodeID(req)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		labels, err := storage.GetNodeLabels(ctx, s.storage.MeshStorage(), nodeID)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get node labels: %v", err)
+		}
+		return encodeFields(map[string]any{"labels": map[types.NodeID]types.NodeLabels{nodeID: labels}})
+	}
+	labels, err := storage.ListNodeLabels(ctx, s.storage.MeshStorage())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list node labels: %v", err)
+	}
+	return encodeFields(map[string]any{"labels": labels})
+}
+
+// DeleteNodeLabels removes all labels from the node with the given "id".
+func (s *Server) DeleteNodeLabels(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, deleteNodeLabelsAction, "delete node labels"); err != nil {
+		return nil, err
+	}
+	nodeID, err := decodeNodeID(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := storage.DeleteNodeLabels(ctx, s.storage.MeshStorage(), nodeID); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete node labels: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Deleted node labels", "node", nodeID.String())
+	return &structpb.Struct{}, nil
+}
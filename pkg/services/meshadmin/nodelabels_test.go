@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestNodeLabels(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	putRequest := func(t *testing.T, id string, labels types.NodeLabels) *structpb.Struct {
+		t.Helper()
+		req, err := EncodeFields(map[string]any{"id": id, "labels": labels})
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		return req
+	}
+	idRequest := func(id string) *structpb.Struct {
+		return &structpb.Struct{Fields: map[string]*structpb.Value{"id": structpb.NewStringValue(id)}}
+	}
+	getLabels := func(t *testing.T, s *Server, id string) types.NodeLabels {
+		t.Helper()
+		resp, err := s.GetNodeLabels(ctx, idRequest(id))
+		if err != nil {
+			t.Fatalf("get node labels: %v", err)
+		}
+		var labels map[types.NodeID]types.NodeLabels
+		if err := DecodeField(resp, "labels", &labels); err != nil {
+			t.Fatalf("decode node labels: %v", err)
+		}
+		return labels[types.NodeID(id)]
+	}
+
+	t.Run("PutGetDelete", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		if _, err := s.PutNodeLabels(ctx, putRequest(t, "node-a", types.NodeLabels{"role": "db"})); err != nil {
+			t.Fatalf("put node labels: %v", err)
+		}
+		if got := getLabels(t, s, "node-a"); got["role"] != "db" {
+			t.Fatalf("unexpected node labels: %+v", got)
+		}
+		if _, err := s.DeleteNodeLabels(ctx, idRequest("node-a")); err != nil {
+			t.Fatalf("delete node labels: %v", err)
+		}
+		if got := getLabels(t, s, "node-a"); len(got) != 0 {
+			t.Fatalf("expected node labels to be removed, got: %+v", got)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		_, err := s.PutNodeLabels(ctx, putRequest(t, "not a node", types.NodeLabels{"role": "db"}))
+		expectCode(t, err, codes.InvalidArgument)
+		_, err = s.PutNodeLabels(ctx, putRequest(t, "node-a", types.NodeLabels{"bad key": "db"}))
+		expectCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		_, err := s.PutNodeLabels(ctx, putRequest(t, "node-a", types.NodeLabels{"role": "db"}))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.DeleteNodeLabels(ctx, idRequest("node-a"))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.GetNodeLabels(ctx, &structpb.Struct{})
+		expectCode(t, err, codes.PermissionDenied)
+	})
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Quarantining a node rewrites its ACLs, routes and key revocations, so it
+// is only granted to callers with full access to the mesh.
+var (
+	listQuarantinesAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_GET,
+		},
+	}
+	quarantineNodeAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_PUT,
+		},
+	}
+	purgeNodeAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_DELETE,
+		},
+	}
+)
+
+// ListQuarantines lists the quarantined nodes in the "quarantines" field.
+func (s *Server) ListQuarantines(ctx context.Context, _ *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.authorize(ctx, listQuarantinesAction, "list quarantined nodes"); err != nil {
+		return nil, err
+	}
+	recs, err := storage.ListQuarantines(ctx, s.storage.MeshStorage())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list quarantines: %v", err)
+	}
+	return encodeFields(map[string]any{"quarantines": recs})
+}
+
+// QuarantineNode quarantines the node with the given "id", recording the
+// optional "reason". The quarantine record is returned in the "quarantine"
+// field.
+func (s *Server) QuarantineNode(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, quarantineNodeAction, "quarantine nodes"); err != nil {
+		return nil, err
+	}
+	nodeID, err := decodeNodeID(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	quarantined, err := storage.IsQuarantined(ctx, s.storage.MeshStorage(), nodeID)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check quarantine: %v", err)
+	}
+	if quarantined {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s is already quarantined", nodeID)
+	}
+	txn := storage.NewTxnStorage(s.storage.MeshStorage())
+	rec, err := storage.QuarantineNode(ctx, meshdb.NewFromStorage(txn), txn, nodeID, req.GetFields()["reason"].GetStringValue())
+	if err != nil {
+		return nil, quarantineError("quarantine", err)
+	}
+	if err := txn.Commit(ctx); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to quarantine node: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Quarantined node", "id", nodeID.String(), "routes", len(rec.Routes))
+	return encodeFields(map[string]any{"quarantine": rec})
+}
+
+// ReleaseQuarantine lifts the quarantine on the node with the given "id"
+// and restores its routes.
+func (s *Server) ReleaseQuarantine(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, quarantineNodeAction, "release quarantined nodes"); err != nil {
+		return nil, err
+	}
+	nodeID, err := decodeNodeID(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	txn := storage.NewTxnStorage(s.storage.MeshStorage())
+	if err := storage.ReleaseQuarantine(ctx, meshdb.NewFromStorage(txn), txn, nodeID); err != nil {
+		return nil, quarantineError("release", err)
+	}
+	if err := txn.Commit(ctx); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to release node: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Released node from quarantine", "id", nodeID.String())
+	return &structpb.Struct{}, nil
+}
+
+// PurgeNode completes the eviction of the quarantined node with the given
+// "id", removing it from the storage consensus and the mesh.
+func (s *Server) PurgeNode(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, purgeNodeAction, "purge quarantined nodes"); err != nil {
+		return nil, err
+	}
+	nodeID, err := decodeNodeID(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if _, err := storage.GetQuarantine(ctx, s.storage.MeshStorage(), nodeID); err != nil {
+		return nil, quarantineError("purge", err)
+	}
+	peer, err := s.storage.MeshDB().Peers().Get(ctx, nodeID)
+	if err == nil && peer.PortFor(v1.Feature_STORAGE_PROVIDER) != 0 {
+		err := s.storage.Consensus().RemovePeer(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{Id: nodeID.String()}}, false)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to remove node from storage consensus: %v", err)
+		}
+	}
+	txn := storage.NewTxnStorage(s.storage.MeshStorage())
+	if err := storage.PurgeQuarantinedNode(ctx, meshdb.NewFromStorage(txn), txn, nodeID, s.nodeID); err != nil {
+		return nil, quarantineError("purge", err)
+	}
+	if err := txn.Commit(ctx); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to purge node: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Purged quarantined node", "id", nodeID.String())
+	return &structpb.Struct{}, nil
+}
+
+// quarantineError converts an error from a quarantine operation into a
+// gRPC status.
+func quarantineError(op string, err error) error {
+	if errors.IsKeyNotFound(err) {
+		return status.Errorf(codes.NotFound, "failed to %s node: node is not quarantined", op)
+	}
+	if errors.IsNodeNotFound(err) {
+		return status.Errorf(codes.NotFound, "failed to %s node: %v", op, err)
+	}
+	return status.Errorf(codes.Internal, "failed to %s node: %v", op, err)
+}
@@ -0,0 +1,165 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestQuarantineNode(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	setup := func(t *testing.T) (*Server, crypto.PublicKey) {
+		t.Helper()
+		s := newTestServer(t, false)
+		key := crypto.MustGenerateKey().PublicKey()
+		registerNode(t, s, "node-a", key)
+		err := s.storage.MeshDB().Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+			Name:             "node-a-auto",
+			Node:             "node-a",
+			DestinationCIDRs: []string{"10.0.0.0/24"},
+		}})
+		if err != nil {
+			t.Fatalf("put route: %v", err)
+		}
+		quarantine, err := structpb.NewStruct(map[string]any{"id": "node-a", "reason": "suspected compromise"})
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		resp, err := s.QuarantineNode(ctx, quarantine)
+		if err != nil {
+			t.Fatalf("quarantine node: %v", err)
+		}
+		var rec storage.QuarantineRecord
+		if err := DecodeField(resp, "quarantine", &rec); err != nil {
+			t.Fatalf("decode quarantine: %v", err)
+		}
+		if rec.Reason != "suspected compromise" || len(rec.Routes) != 1 {
+			t.Fatalf("unexpected quarantine record: %+v", rec)
+		}
+		_, err = s.QuarantineNode(ctx, quarantine)
+		expectCode(t, err, codes.FailedPrecondition)
+		routes, err := s.storage.MeshDB().Networking().GetRoutesByNode(ctx, "node-a")
+		if err != nil {
+			t.Fatalf("get routes: %v", err)
+		}
+		if len(routes) != 0 {
+			t.Fatalf("expected routes to be withdrawn, got %d", len(routes))
+		}
+		resp, err = s.ListQuarantines(ctx, &structpb.Struct{})
+		if err != nil {
+			t.Fatalf("list quarantines: %v", err)
+		}
+		var recs []storage.QuarantineRecord
+		if err := DecodeField(resp, "quarantines", &recs); err != nil {
+			t.Fatalf("decode quarantines: %v", err)
+		}
+		if len(recs) != 1 || recs[0].NodeID != "node-a" {
+			t.Fatalf("expected node-a to be quarantined, got %+v", recs)
+		}
+		return s, key
+	}
+
+	t.Run("Release", func(t *testing.T) {
+		t.Parallel()
+		s, key := setup(t)
+		if _, err := s.ReleaseQuarantine(ctx, nodeRequest("node-a")); err != nil {
+			t.Fatalf("release quarantine: %v", err)
+		}
+		routes, err := s.storage.MeshDB().Networking().GetRoutesByNode(ctx, "node-a")
+		if err != nil {
+			t.Fatalf("get routes: %v", err)
+		}
+		if len(routes) != 1 {
+			t.Fatalf("expected route to be restored, got %d", len(routes))
+		}
+		revoked, err := storage.IsKeyRevoked(ctx, s.storage.MeshStorage(), key)
+		if err != nil {
+			t.Fatalf("check revocation: %v", err)
+		}
+		if revoked {
+			t.Fatal("expected key revocation to be lifted")
+		}
+		_, err = s.ReleaseQuarantine(ctx, nodeRequest("node-a"))
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		t.Parallel()
+		s, key := setup(t)
+		if _, err := s.PurgeNode(ctx, nodeRequest("node-a")); err != nil {
+			t.Fatalf("purge node: %v", err)
+		}
+		if _, err := s.storage.MeshDB().Peers().Get(ctx, "node-a"); err == nil {
+			t.Fatal("expected node to be removed")
+		}
+		revoked, err := storage.IsKeyRevoked(ctx, s.storage.MeshStorage(), key)
+		if err != nil {
+			t.Fatalf("check revocation: %v", err)
+		}
+		if !revoked {
+			t.Fatal("expected key to stay revoked")
+		}
+		events, err := storage.ListMembershipEvents(ctx, s.storage.MeshStorage(), storage.MembershipEventFilter{NodeID: "node-a"})
+		if err != nil {
+			t.Fatalf("list membership events: %v", err)
+		}
+		if len(events) != 1 || events[0].Type != storage.MembershipEventEvict || events[0].Actor != s.nodeID {
+			t.Fatalf("expected an evict event, got %+v", events)
+		}
+		_, err = s.PurgeNode(ctx, nodeRequest("node-a"))
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		_, err := s.QuarantineNode(ctx, nodeRequest("node-a"))
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		registerNode(t, s, "node-a", crypto.MustGenerateKey().PublicKey())
+		_, err := s.QuarantineNode(ctx, nodeRequest("node-a"))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.ReleaseQuarantine(ctx, nodeRequest("node-a"))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.PurgeNode(ctx, nodeRequest("node-a"))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.ListQuarantines(ctx, &structpb.Struct{})
+		expectCode(t, err, codes.PermissionDenied)
+		quarantined, err := storage.IsQuarantined(ctx, s.storage.MeshStorage(), "node-a")
+		if err != nil {
+			t.Fatalf("check quarantine: %v", err)
+		}
+		if quarantined {
+			t.Fatal("expected node to not be quarantined")
+		}
+	})
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Recovering a node identity replaces its registration and keys, so it is
+// only granted to callers with full access to the mesh.
+var (
+	getEscrowedKeyAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_GET,
+		},
+	}
+	recoverNodeAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_PUT,
+		},
+	}
+)
+
+// RecoverNodeRequest is a request to recover a lost node identity.
+type RecoverNodeRequest struct {
+	// NodeID is the ID of the node being recovered.
+	NodeID types.NodeID
+	// RevokeKeys are keys to revoke in addition to the node's registered
+	// key, usually the escrowed key.
+	RevokeKeys []crypto.PublicKey
+	// Escrow is the key issued to the replacement node, wrapped to the
+	// recovery key. It replaces the node's escrowed key when set.
+	Escrow []byte
+	// Restore restores the escrowed key instead of issuing a new one.
+	// No keys are revoked.
+	Restore bool
+}
+
+// Encode encodes the request for the RecoverNode RPC.
+func (r RecoverNodeRequest) Encode() (*structpb.Struct, error) {
+	keys := make([]any, len(r.RevokeKeys))
+	for i, key := range r.RevokeKeys {
+		encoded, err := key.Encode()
+		if err != nil {
+			return nil, fmt.Errorf("encode key: %w", err)
+		}
+		keys[i] = encoded
+	}
+	return structpb.NewStruct(map[string]any{
+		"id":         r.NodeID.String(),
+		"revokeKeys": keys,
+		"escrow":     base64.StdEncoding.EncodeToString(r.Escrow),
+		"restore":    r.Restore,
+	})
+}
+
+// DecodeRecoverNodeRequest decodes a RecoverNode request.
+func DecodeRecoverNodeRequest(req *structpb.Struct) (RecoverNodeRequest, error) {
+	var out RecoverNodeRequest
+	var err error
+	out.NodeID, err = decodeNodeID(req)
+	if err != nil {
+		return out, err
+	}
+	fields := req.GetFields()
+	for _, value := range fields["revokeKeys"].GetListValue().GetValues() {
+		key, err := crypto.DecodePublicKey(value.GetStringValue())
+		if err != nil {
+			return out, fmt.Errorf("invalid key to revoke: %w", err)
+		}
+		out.RevokeKeys = append(out.RevokeKeys, key)
+	}
+	out.Escrow, err = base64.StdEncoding.DecodeString(fields["escrow"].GetStringValue())
+	if err != nil {
+		return out, fmt.Errorf("invalid escrowed key: %w", err)
+	}
+	out.Restore = fields["restore"].GetBoolValue()
+	if out.Restore && (len(out.RevokeKeys) > 0 || len(out.Escrow) > 0) {
+		return out, fmt.Errorf("keys cannot be revoked or escrowed when restoring")
+	}
+	return out, nil
+}
+
+// GetEscrowedKey returns the wrapped identity key escrowed for the node with
+// the given "id" in the "wrapped" field, base64 encoded. The key can only be
+// unwrapped with the recovery key.
+func (s *Server) GetEscrowedKey(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.authorize(ctx, getEscrowedKeyAction, "get escrowed keys"); err != nil {
+		return nil, err
+	}
+	nodeID, err := decodeNodeID(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	wrapped, err := s.keyEscrow.GetEscrowedKey(ctx, nodeID)
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "no key escrowed for %s", nodeID)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get escrowed key: %v", err)
+	}
+	return structpb.NewStruct(map[string]any{
+		"wrapped": base64.StdEncoding.EncodeToString(wrapped),
+	})
+}
+
+// RecoverNode removes the registration of a lost node so a replacement can
+// join with its ID. Unless restoring, the node's registered key and the
+// requested keys are revoked, and the key issued to the replacement is
+// escrowed. The response lists the revoked key IDs in "revoked" and whether
+// a registration was removed in "removed".
+func (s *Server) RecoverNode(ctx context.Context, in *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, recoverNodeAction, "recover nodes"); err != nil {
+		return nil, err
+	}
+	req, err := DecodeRecoverNodeRequest(in)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	log := context.LoggerFrom(ctx).With("op", "recover-node", "id", req.NodeID.String())
+	revoke := req.RevokeKeys
+	var removed bool
+	peer, err := s.storage.MeshDB().Peers().Get(ctx, req.NodeID)
+	switch {
+	case err == nil:
+		if registered, err := crypto.DecodePublicKey(peer.GetPublicKey()); err == nil {
+			revoke = append(revoke, registered)
+		}
+		if err := s.removeNode(ctx, peer); err != nil {
+			return nil, err
+		}
+		removed = true
+		log.Info("Removed registration for recovered node")
+	case !errors.IsNodeNotFound(err):
+		return nil, status.Errorf(codes.Internal, "failed to get node: %v", err)
+	}
+	revoked := make([]any, 0, len(revoke))
+	if !req.Restore {
+		seen := make(map[string]struct{}, len(revoke))
+		for _, key := range revoke {
+			if _, ok := seen[key.ID()]; ok {
+				continue
+			}
+			seen[key.ID()] = struct{}{}
+			if err := storage.RevokeKey(ctx, s.storage.MeshStorage(), key); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to revoke key %s: %v", key.ID(), err)
+			}
+			log.Info("Revoked key of recovered node", slog.String("key", key.ID()))
+			revoked = append(revoked, key.ID())
+		}
+	}
+	if len(req.Escrow) > 0 {
+		if err := s.keyEscrow.PutEscrowedKey(ctx, req.NodeID, req.Escrow); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to escrow issued key: %v", err)
+		}
+	}
+	if removed {
+		s.recordEvent(ctx, storage.MembershipEventEvict, req.NodeID, "node identity recovered")
+	}
+	return structpb.NewStruct(map[string]any{
+		"removed": removed,
+		"revoked": revoked,
+	})
+}
+
+// removeNode removes a node from the mesh and, if it is a storage member,
+// from the storage consensus.
+func (s *Server) removeNode(ctx context.Context, node types.MeshNode) error {
+	if node.PortFor(v1.Feature_STORAGE_PROVIDER) != 0 {
+		err := s.storage.Consensus().RemovePeer(ctx, types.StoragePeer{StoragePeer: &v1.StoragePeer{Id: node.GetId()}}, false)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to remove node from storage consensus: %v", err)
+		}
+	}
+	if err := s.storage.MeshDB().Peers().Delete(ctx, node.NodeID()); err != nil {
+		return status.Errorf(codes.Internal, "failed to delete node: %v", err)
+	}
+	return nil
+}
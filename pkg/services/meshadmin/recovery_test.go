@@ -0,0 +1,211 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+func TestRecoverNode(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	recovery := crypto.MustGenerateKey()
+
+	t.Run("IssueNewKey", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		escrowed := crypto.MustGenerateKey()
+		registered := crypto.MustGenerateKey()
+		registerNode(t, s, "node-a", registered.PublicKey())
+		wrapped, err := crypto.WrapKey(escrowed, recovery.PublicKey())
+		if err != nil {
+			t.Fatalf("wrap key: %v", err)
+		}
+		if err := s.keyEscrow.PutEscrowedKey(ctx, "node-a", wrapped); err != nil {
+			t.Fatalf("escrow key: %v", err)
+		}
+		resp, err := s.GetEscrowedKey(ctx, nodeRequest("node-a"))
+		if err != nil {
+			t.Fatalf("get escrowed key: %v", err)
+		}
+		if resp.GetFields()["wrapped"].GetStringValue() == "" {
+			t.Fatal("expected wrapped key in response")
+		}
+
+		issued := crypto.MustGenerateKey()
+		rewrapped, err := crypto.WrapKey(issued, recovery.PublicKey())
+		if err != nil {
+			t.Fatalf("wrap key: %v", err)
+		}
+		in, err := RecoverNodeRequest{
+			NodeID:     "node-a",
+			RevokeKeys: []crypto.PublicKey{escrowed.PublicKey()},
+			Escrow:     rewrapped,
+		}.Encode()
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		resp, err = s.RecoverNode(ctx, in)
+		if err != nil {
+			t.Fatalf("recover node: %v", err)
+		}
+		if !resp.GetFields()["removed"].GetBoolValue() {
+			t.Fatal("expected registration to be removed")
+		}
+		if n := len(resp.GetFields()["revoked"].GetListValue().GetValues()); n != 2 {
+			t.Fatalf("expected 2 revoked keys, got %d", n)
+		}
+		if _, err := s.storage.MeshDB().Peers().Get(ctx, "node-a"); err == nil {
+			t.Fatal("expected node to be removed")
+		}
+		for _, key := range []crypto.PublicKey{escrowed.PublicKey(), registered.PublicKey()} {
+			revoked, err := storage.IsKeyRevoked(ctx, s.storage.MeshStorage(), key)
+			if err != nil {
+				t.Fatalf("check revocation: %v", err)
+			}
+			if !revoked {
+				t.Fatalf("expected key %s to be revoked", key.ID())
+			}
+		}
+		stored, err := s.keyEscrow.GetEscrowedKey(ctx, "node-a")
+		if err != nil {
+			t.Fatalf("get escrowed key: %v", err)
+		}
+		if !bytes.Equal(stored, rewrapped) {
+			t.Fatal("expected the issued key to be escrowed")
+		}
+		events, err := storage.ListMembershipEvents(ctx, s.storage.MeshStorage(), storage.MembershipEventFilter{NodeID: "node-a"})
+		if err != nil {
+			t.Fatalf("list membership events: %v", err)
+		}
+		if len(events) != 1 || events[0].Type != storage.MembershipEventEvict {
+			t.Fatalf("expected an evict event, got %+v", events)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		key := crypto.MustGenerateKey()
+		registerNode(t, s, "node-a", key.PublicKey())
+		in, err := RecoverNodeRequest{NodeID: "node-a", Restore: true}.Encode()
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		resp, err := s.RecoverNode(ctx, in)
+		if err != nil {
+			t.Fatalf("recover node: %v", err)
+		}
+		if n := len(resp.GetFields()["revoked"].GetListValue().GetValues()); n != 0 {
+			t.Fatalf("expected no revoked keys, got %d", n)
+		}
+		revoked, err := storage.IsKeyRevoked(ctx, s.storage.MeshStorage(), key.PublicKey())
+		if err != nil {
+			t.Fatalf("check revocation: %v", err)
+		}
+		if revoked {
+			t.Fatal("expected restored key to not be revoked")
+		}
+	})
+
+	t.Run("NotEscrowed", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		_, err := s.GetEscrowedKey(ctx, nodeRequest("node-a"))
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		_, err := s.GetEscrowedKey(ctx, nodeRequest("node-a"))
+		expectCode(t, err, codes.PermissionDenied)
+		in, err := RecoverNodeRequest{NodeID: "node-a", Restore: true}.Encode()
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		_, err = s.RecoverNode(ctx, in)
+		expectCode(t, err, codes.PermissionDenied)
+	})
+}
+
+func TestDecodeRecoverNodeRequest(t *testing.T) {
+	t.Parallel()
+	key := crypto.MustGenerateKey().PublicKey()
+	tc := []struct {
+		name    string
+		req     RecoverNodeRequest
+		wantErr bool
+	}{
+		{
+			name: "ValidRequest",
+			req:  RecoverNodeRequest{NodeID: "node-a", RevokeKeys: []crypto.PublicKey{key}, Escrow: []byte("wrapped")},
+		},
+		{
+			name:    "MissingID",
+			req:     RecoverNodeRequest{},
+			wantErr: true,
+		},
+		{
+			name:    "InvalidID",
+			req:     RecoverNodeRequest{NodeID: "node a"},
+			wantErr: true,
+		},
+		{
+			name:    "RestoreWithRevocations",
+			req:     RecoverNodeRequest{NodeID: "node-a", RevokeKeys: []crypto.PublicKey{key}, Restore: true},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			in, err := tt.req.Encode()
+			if err != nil {
+				t.Fatalf("encode request: %v", err)
+			}
+			out, err := DecodeRecoverNodeRequest(in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("decode request: %v", err)
+			}
+			if out.NodeID != tt.req.NodeID || len(out.RevokeKeys) != 1 || !out.RevokeKeys[0].Equals(key) || string(out.Escrow) != "wrapped" {
+				t.Fatalf("unexpected decoded request: %+v", out)
+			}
+		})
+	}
+}
+
+func nodeRequest(id string) *structpb.Struct {
+	return &structpb.Struct{Fields: map[string]*structpb.Value{
+		"id": structpb.NewStringValue(id),
+	}}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Renaming a node rewrites every ACL, route and role binding referring to
+// it, so it is only granted to callers with full access to the mesh.
+var renameNodeAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_ALL,
+		Verb:     v1.RuleVerb_VERB_PUT,
+	},
+}
+
+// RenameNode changes the ID of the node with the given "id" to "newId". The
+// old ID keeps resolving to the node for the optional "grace" duration.
+// Members of the storage consensus can not be renamed, since consensus knows
+// them by their ID.
+func (s *Server) RenameNode(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, renameNodeAction, "rename nodes"); err != nil {
+		return nil, err
+	}
+	from, err := decodeNodeID(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	to := types.NodeID(req.GetFields()["newId"].GetStringValue())
+	if !to.IsValid() {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid new node id %q", to)
+	}
+	var grace time.Duration
+	if v := req.GetFields()["grace"].GetStringValue(); v != "" {
+		grace, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid grace period: %v", err)
+		}
+	}
+	node, err := s.storage.MeshDB().Peers().Get(ctx, from)
+	if err != nil {
+		if errors.IsNodeNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "node %s not found", from)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get node: %v", err)
+	}
+	member, err := s.isStorageMember(ctx, node)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to check storage membership: %v", err)
+	}
+	if member {
+		return nil, status.Errorf(codes.FailedPrecondition, "node %s is a member of the storage consensus and can not be renamed", from)
+	}
+	if err := meshdb.RenameNode(ctx, s.storage.MeshStorage(), from, to, grace, s.callerID(ctx)); err != nil {
+		return nil, status.Errorf(codes.FailedPrecondition, "failed to rename node: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Renamed node", "from", from.String(), "to", to.String())
+	return &structpb.Struct{}, nil
+}
+
+// isStorageMember returns true if the given node is a voter, observer or
+// learner in the storage consensus.
+func (s *Server) isStorageMember(ctx context.Context, node types.MeshNode) (bool, error) {
+	if node.PortFor(v1.Feature_STORAGE_PROVIDER) != 0 {
+		return true, nil
+	}
+	peers, err := s.storage.Consensus().GetPeers(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, peer := range peers {
+		if peer.GetId() == node.GetId() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,94 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+func TestRenameNode(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	rename := func(t *testing.T, s *Server, from, to string) error {
+		t.Helper()
+		req, err := structpb.NewStruct(map[string]any{"id": from, "newId": to, "grace": "1m"})
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		_, err = s.RenameNode(ctx, req)
+		return err
+	}
+
+	t.Run("Rename", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		registerNode(t, s, "node-a", crypto.MustGenerateKey().PublicKey())
+		if err := rename(t, s, "node-a", "node-b"); err != nil {
+			t.Fatalf("rename node: %v", err)
+		}
+		if _, err := s.storage.MeshDB().Peers().Get(ctx, "node-b"); err != nil {
+			t.Fatalf("get renamed node: %v", err)
+		}
+		if _, err := s.storage.MeshDB().Peers().Get(ctx, "node-a"); !errors.IsNodeNotFound(err) {
+			t.Fatalf("expected old node to be removed, got: %v", err)
+		}
+		// The rename is recorded in the protected membership history.
+		events, err := storage.ListMembershipEvents(ctx, s.storage.MeshStorage(), storage.MembershipEventFilter{
+			Type: storage.MembershipEventRename,
+		})
+		if err != nil {
+			t.Fatalf("list membership events: %v", err)
+		}
+		if len(events) != 1 || events[0].NodeID != "node-a" {
+			t.Fatalf("expected one rename event for node-a, got: %+v", events)
+		}
+		expectCode(t, rename(t, s, "node-a", "node-c"), codes.NotFound)
+	})
+
+	t.Run("StorageMember", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		// The test node is the only voter in its storage consensus.
+		expectCode(t, rename(t, s, s.nodeID.String(), "renamed-voter"), codes.FailedPrecondition)
+		if _, err := s.storage.MeshDB().Peers().Get(ctx, s.nodeID); err != nil {
+			t.Fatalf("expected voter to keep its ID: %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		registerNode(t, s, "node-a", crypto.MustGenerateKey().PublicKey())
+		expectCode(t, rename(t, s, "node-a", "not a node"), codes.InvalidArgument)
+		expectCode(t, rename(t, s, "", "node-b"), codes.InvalidArgument)
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		registerNode(t, s, "node-a", crypto.MustGenerateKey().PublicKey())
+		expectCode(t, rename(t, s, "node-a", "node-b"), codes.PermissionDenied)
+	})
+}
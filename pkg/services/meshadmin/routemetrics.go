@@ -0,0 +1,116 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// A metric decides which node traffic for the prefixes of a route is sent
+// to, so it is authorized as the route it belongs to. Resetting a metric
+// changes the route as much as setting one, so both require permission to
+// put the route.
+var (
+	getRouteMetricsAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ROUTES,
+			Verb:     v1.RuleVerb_VERB_GET,
+		},
+	}
+	putRouteMetricAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ROUTES,
+			Verb:     v1.RuleVerb_VERB_PUT,
+		},
+	}
+)
+
+// PutRouteMetric sets the metric of the route with the given "name" to the
+// "metric" in the request.
+func (s *Server) PutRouteMetric(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	name := req.GetFields()["name"].GetStringValue()
+	if !types.IsValidID(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid route name %q", name)
+	}
+	if err := s.authorize(ctx, putRouteMetricAction.For(name), "put route metrics"); err != nil {
+		return nil, err
+	}
+	var metric uint32
+	if err := DecodeField(req, "metric", &metric); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := storage.PutRouteMetric(ctx, s.storage.MeshStorage(), name, metric); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to put route metric: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Put route metric", "name", name, "metric", metric)
+	return &structpb.Struct{}, nil
+}
+
+// GetRouteMetrics returns the metrics of all routes that have one in the
+// "metrics" field. If the request has a "name", only the metric of that
+// route is returned, which is zero if it has none.
+func (s *Server) GetRouteMetrics(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if name := req.GetFields()["name"].GetStringValue(); name != "" {
+		if err := s.authorize(ctx, getRouteMetricsAction.For(name), "get route metrics"); err != nil {
+			return nil, err
+		}
+		metric, _, err := storage.GetRouteMetric(ctx, s.storage.MeshStorage(), name)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get route metric: %v", err)
+		}
+		return encodeFields(map[string]any{"metrics": types.RouteMetrics{name: metric}})
+	}
+	if err := s.authorize(ctx, getRouteMetricsAction, "get route metrics"); err != nil {
+		return nil, err
+	}
+	metrics, err := storage.ListRouteMetrics(ctx, s.storage.MeshStorage())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list route metrics: %v", err)
+	}
+	return encodeFields(map[string]any{"metrics": metrics})
+}
+
+// DeleteRouteMetric removes the metric of the route with the given "name",
+// resetting it to zero.
+func (s *Server) DeleteRouteMetric(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	name := req.GetFields()["name"].GetStringValue()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "route name is required")
+	}
+	if err := s.authorize(ctx, putRouteMetricAction.For(name), "delete route metrics"); err != nil {
+		return nil, err
+	}
+	if err := storage.DeleteRouteMetric(ctx, s.storage.MeshStorage(), name); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete route metric: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Deleted route metric", "name", name)
+	return &structpb.Struct{}, nil
+}
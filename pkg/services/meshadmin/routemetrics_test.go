@@ -0,0 +1,90 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestRouteMetrics(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	putRequest := func(name string, metric float64) *structpb.Struct {
+		return &structpb.Struct{Fields: map[string]*structpb.Value{
+			"name":   structpb.NewStringValue(name),
+			"metric": structpb.NewNumberValue(metric),
+		}}
+	}
+	nameRequest := func(name string) *structpb.Struct {
+		return &structpb.Struct{Fields: map[string]*structpb.Value{"name": structpb.NewStringValue(name)}}
+	}
+	getMetric := func(t *testing.T, s *Server, name string) uint32 {
+		t.Helper()
+		resp, err := s.GetRouteMetrics(ctx, nameRequest(name))
+		if err != nil {
+			t.Fatalf("get route metric: %v", err)
+		}
+		var metrics types.RouteMetrics
+		if err := DecodeField(resp, "metrics", &metrics); err != nil {
+			t.Fatalf("decode route metrics: %v", err)
+		}
+		return metrics[name]
+	}
+
+	t.Run("PutGetDelete", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		if _, err := s.PutRouteMetric(ctx, putRequest("route-a", 10)); err != nil {
+			t.Fatalf("put route metric: %v", err)
+		}
+		if got := getMetric(t, s, "route-a"); got != 10 {
+			t.Fatalf("expected metric 10, got %d", got)
+		}
+		if _, err := s.DeleteRouteMetric(ctx, nameRequest("route-a")); err != nil {
+			t.Fatalf("delete route metric: %v", err)
+		}
+		if got := getMetric(t, s, "route-a"); got != 0 {
+			t.Fatalf("expected metric to be reset, got %d", got)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		_, err := s.PutRouteMetric(ctx, putRequest("not a route", 10))
+		expectCode(t, err, codes.InvalidArgument)
+		_, err = s.PutRouteMetric(ctx, putRequest("route-a", -1))
+		expectCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		_, err := s.PutRouteMetric(ctx, putRequest("route-a", 10))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.DeleteRouteMetric(ctx, nameRequest("route-a"))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.GetRouteMetrics(ctx, &structpb.Struct{})
+		expectCode(t, err, codes.PermissionDenied)
+	})
+}
@@ -0,0 +1,370 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package meshadmin provides a gRPC service for administrative operations on
+// mesh members that are not covered by the Admin API, such as recovering a
+// lost node identity. Writes are authorized on the leader instead of being
+// made directly against the storage API. The service uses only well-known
+// protobuf types so that it can be served without generated code.
+package meshadmin
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/leaderproxy"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+const (
+	// ServiceName is the full name of the mesh admin service.
+	ServiceName = "webmesh.meshadmin.v1.MeshAdmin"
+	// GetEscrowedKeyFullMethodName is the full method name of GetEscrowedKey.
+	GetEscrowedKeyFullMethodName = "/" + ServiceName + "/GetEscrowedKey"
+	// RecoverNodeFullMethodName is the full method name of RecoverNode.
+	RecoverNodeFullMethodName = "/" + ServiceName + "/RecoverNode"
+	// ListMembershipEventsFullMethodName is the full method name of ListMembershipEvents.
+	ListMembershipEventsFullMethodName = "/" + ServiceName + "/ListMembershipEvents"
+	// ListQuarantinesFullMethodName is the full method name of ListQuarantines.
+	ListQuarantinesFullMethodName = "/" + ServiceName + "/ListQuarantines"
+	// QuarantineNodeFullMethodName is the full method name of QuarantineNode.
+	QuarantineNodeFullMethodName = "/" + ServiceName + "/QuarantineNode"
+	// ReleaseQuarantineFullMethodName is the full method name of ReleaseQuarantine.
+	ReleaseQuarantineFullMethodName = "/" + ServiceName + "/ReleaseQuarantine"
+	// PurgeNodeFullMethodName is the full method name of PurgeNode.
+	PurgeNodeFullMethodName = "/" + ServiceName + "/PurgeNode"
+	// PutMaintenanceWindowFullMethodName is the full method name of PutMaintenanceWindow.
+	PutMaintenanceWindowFullMethodName = "/" + ServiceName + "/PutMaintenanceWindow"
+	// GetMaintenanceWindowsFullMethodName is the full method name of GetMaintenanceWindows.
+	GetMaintenanceWindowsFullMethodName = "/" + ServiceName + "/GetMaintenanceWindows"
+	// DeleteMaintenanceWindowFullMethodName is the full method name of DeleteMaintenanceWindow.
+	DeleteMaintenanceWindowFullMethodName = "/" + ServiceName + "/DeleteMaintenanceWindow"
+	// ListSplitBrainAlarmsFullMethodName is the full method name of ListSplitBrainAlarms.
+	ListSplitBrainAlarmsFullMethodName = "/" + ServiceName + "/ListSplitBrainAlarms"
+	// ResolveSplitBrainFullMethodName is the full method name of ResolveSplitBrain.
+	ResolveSplitBrainFullMethodName = "/" + ServiceName + "/ResolveSplitBrain"
+	// PutNamespaceFullMethodName is the full method name of PutNamespace.
+	PutNamespaceFullMethodName = "/" + ServiceName + "/PutNamespace"
+	// GetNamespacesFullMethodName is the full method name of GetNamespaces.
+	GetNamespacesFullMethodName = "/" + ServiceName + "/GetNamespaces"
+	// DeleteNamespaceFullMethodName is the full method name of DeleteNamespace.
+	DeleteNamespaceFullMethodName = "/" + ServiceName + "/DeleteNamespace"
+	// RenameNodeFullMethodName is the full method name of RenameNode.
+	RenameNodeFullMethodName = "/" + ServiceName + "/RenameNode"
+	// SetDefaultNetworkPolicyFullMethodName is the full method name of SetDefaultNetworkPolicy.
+	SetDefaultNetworkPolicyFullMethodName = "/" + ServiceName + "/SetDefaultNetworkPolicy"
+	// GetDefaultNetworkPolicyFullMethodName is the full method name of GetDefaultNetworkPolicy.
+	GetDefaultNetworkPolicyFullMethodName = "/" + ServiceName + "/GetDefaultNetworkPolicy"
+	// PutACLExemptionsFullMethodName is the full method name of PutACLExemptions.
+	PutACLExemptionsFullMethodName = "/" + ServiceName + "/PutACLExemptions"
+	// GetACLExemptionsFullMethodName is the full method name of GetACLExemptions.
+	GetACLExemptionsFullMethodName = "/" + ServiceName + "/GetACLExemptions"
+	// PutACLScheduleFullMethodName is the full method name of PutACLSchedule.
+	PutACLScheduleFullMethodName = "/" + ServiceName + "/PutACLSchedule"
+	// GetACLSchedulesFullMethodName is the full method name of GetACLSchedules.
+	GetACLSchedulesFullMethodName = "/" + ServiceName + "/GetACLSchedules"
+	// DeleteACLScheduleFullMethodName is the full method name of DeleteACLSchedule.
+	DeleteACLScheduleFullMethodName = "/" + ServiceName + "/DeleteACLSchedule"
+	// PutNodeLabelsFullMethodName is the full method name of PutNodeLabels.
+	PutNodeLabelsFullMethodName = "/" + ServiceName + "/PutNodeLabels"
+	// GetNodeLabelsFullMethodName is the full method name of GetNodeLabels.
+	GetNodeLabelsFullMethodName = "/" + ServiceName + "/GetNodeLabels"
+	// DeleteNodeLabelsFullMethodName is the full method name of DeleteNodeLabels.
+	DeleteNodeLabelsFullMethodName = "/" + ServiceName + "/DeleteNodeLabels"
+	// PutTrafficPolicyFullMethodName is the full method name of PutTrafficPolicy.
+	PutTrafficPolicyFullMethodName = "/" + ServiceName + "/PutTrafficPolicy"
+	// GetTrafficPoliciesFullMethodName is the full method name of GetTrafficPolicies.
+	GetTrafficPoliciesFullMethodName = "/" + ServiceName + "/GetTrafficPolicies"
+	// DeleteTrafficPolicyFullMethodName is the full method name of DeleteTrafficPolicy.
+	DeleteTrafficPolicyFullMethodName = "/" + ServiceName + "/DeleteTrafficPolicy"
+	// PutRouteMetricFullMethodName is the full method name of PutRouteMetric.
+	PutRouteMetricFullMethodName = "/" + ServiceName + "/PutRouteMetric"
+	// GetRouteMetricsFullMethodName is the full method name of GetRouteMetrics.
+	GetRouteMetricsFullMethodName = "/" + ServiceName + "/GetRouteMetrics"
+	// DeleteRouteMetricFullMethodName is the full method name of DeleteRouteMetric.
+	DeleteRouteMetricFullMethodName = "/" + ServiceName + "/DeleteRouteMetric"
+)
+
+// MeshAdminServer is the server API for the mesh admin service.
+type MeshAdminServer interface {
+	// GetEscrowedKey returns the wrapped identity key escrowed for a node.
+	GetEscrowedKey(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// RecoverNode removes a lost node's registration, revokes its keys,
+	// and escrows the key issued to its replacement.
+	RecoverNode(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// ListMembershipEvents lists the membership history.
+	ListMembershipEvents(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// ListQuarantines lists the quarantined nodes.
+	ListQuarantines(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// QuarantineNode isolates a node from the mesh and withdraws its routes.
+	QuarantineNode(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// ReleaseQuarantine lifts the quarantine on a node.
+	ReleaseQuarantine(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// PurgeNode removes a quarantined node from the mesh.
+	PurgeNode(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// PutMaintenanceWindow creates or updates a maintenance window.
+	PutMaintenanceWindow(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// GetMaintenanceWindows returns the maintenance windows that have not ended.
+	GetMaintenanceWindows(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// DeleteMaintenanceWindow deletes a maintenance window.
+	DeleteMaintenanceWindow(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// ListSplitBrainAlarms returns the raised split-brain alarms.
+	ListSplitBrainAlarms(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// ResolveSplitBrain resolves the split-brain alarm raised for a node.
+	ResolveSplitBrain(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// PutNamespace creates or updates a namespace and assigns nodes to it.
+	PutNamespace(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// GetNamespaces returns namespaces along with their nodes and usage.
+	GetNamespaces(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// DeleteNamespace deletes a namespace.
+	DeleteNamespace(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// RenameNode changes the ID of a node and every reference to it.
+	RenameNode(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// SetDefaultNetworkPolicy changes the default network policy of the mesh.
+	SetDefaultNetworkPolicy(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// GetDefaultNetworkPolicy returns the default network policy of the mesh.
+	GetDefaultNetworkPolicy(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// PutACLExemptions replaces the network ACL exemptions of the mesh.
+	PutACLExemptions(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// GetACLExemptions returns the network ACL exemptions of the mesh.
+	GetACLExemptions(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// PutACLSchedule sets the schedule of a network ACL.
+	PutACLSchedule(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// GetACLSchedules returns the schedules of network ACLs.
+	GetACLSchedules(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// DeleteACLSchedule removes the schedule of a network ACL.
+	DeleteACLSchedule(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// PutNodeLabels replaces the labels of a node.
+	PutNodeLabels(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// GetNodeLabels returns the labels of nodes.
+	GetNodeLabels(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// DeleteNodeLabels removes all labels from a node.
+	DeleteNodeLabels(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// PutTrafficPolicy creates or replaces a traffic policy.
+	PutTrafficPolicy(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// GetTrafficPolicies returns traffic policies.
+	GetTrafficPolicies(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// DeleteTrafficPolicy deletes a traffic policy.
+	DeleteTrafficPolicy(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// PutRouteMetric sets the metric of a route.
+	PutRouteMetric(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// GetRouteMetrics returns the metrics of routes.
+	GetRouteMetrics(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// DeleteRouteMetric resets the metric of a route to zero.
+	DeleteRouteMetric(context.Context, *structpb.Struct) (*structpb.Struct, error)
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the mesh admin service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*MeshAdminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		unaryMethod("GetEscrowedKey", GetEscrowedKeyFullMethodName, MeshAdminServer.GetEscrowedKey),
+		unaryMethod("RecoverNode", RecoverNodeFullMethodName, MeshAdminServer.RecoverNode),
+		unaryMethod("ListMembershipEvents", ListMembershipEventsFullMethodName, MeshAdminServer.ListMembershipEvents),
+		unaryMethod("ListQuarantines", ListQuarantinesFullMethodName, MeshAdminServer.ListQuarantines),
+		unaryMethod("QuarantineNode", QuarantineNodeFullMethodName, MeshAdminServer.QuarantineNode),
+		unaryMethod("ReleaseQuarantine", ReleaseQuarantineFullMethodName, MeshAdminServer.ReleaseQuarantine),
+		unaryMethod("PurgeNode", PurgeNodeFullMethodName, MeshAdminServer.PurgeNode),
+		unaryMethod("PutMaintenanceWindow", PutMaintenanceWindowFullMethodName, MeshAdminServer.PutMaintenanceWindow),
+		unaryMethod("GetMaintenanceWindows", GetMaintenanceWindowsFullMethodName, MeshAdminServer.GetMaintenanceWindows),
+		unaryMethod("DeleteMaintenanceWindow", DeleteMaintenanceWindowFullMethodName, MeshAdminServer.DeleteMaintenanceWindow),
+		unaryMethod("ListSplitBrainAlarms", ListSplitBrainAlarmsFullMethodName, MeshAdminServer.ListSplitBrainAlarms),
+		unaryMethod("ResolveSplitBrain", ResolveSplitBrainFullMethodName, MeshAdminServer.ResolveSplitBrain),
+		unaryMethod("PutNamespace", PutNamespaceFullMethodName, MeshAdminServer.PutNamespace),
+		unaryMethod("GetNamespaces", GetNamespacesFullMethodName, MeshAdminServer.GetNamespaces),
+		unaryMethod("DeleteNamespace", DeleteNamespaceFullMethodName, MeshAdminServer.DeleteNamespace),
+		unaryMethod("RenameNode", RenameNodeFullMethodName, MeshAdminServer.RenameNode),
+		unaryMethod("SetDefaultNetworkPolicy", SetDefaultNetworkPolicyFullMethodName, MeshAdminServer.SetDefaultNetworkPolicy),
+		unaryMethod("GetDefaultNetworkPolicy", GetDefaultNetworkPolicyFullMethodName, MeshAdminServer.GetDefaultNetworkPolicy),
+		unaryMethod("PutACLExemptions", PutACLExemptionsFullMethodName, MeshAdminServer.PutACLExemptions),
+		unaryMethod("GetACLExemptions", GetACLExemptionsFullMethodName, MeshAdminServer.GetACLExemptions),
+		unaryMethod("PutACLSchedule", PutACLScheduleFullMethodName, MeshAdminServer.PutACLSchedule),
+		unaryMethod("GetACLSchedules", GetACLSchedulesFullMethodName, MeshAdminServer.GetACLSchedules),
+		unaryMethod("DeleteACLSchedule", DeleteACLScheduleFullMethodName, MeshAdminServer.DeleteACLSchedule),
+		unaryMethod("PutNodeLabels", PutNodeLabelsFullMethodName, MeshAdminServer.PutNodeLabels),
+		unaryMethod("GetNodeLabels", GetNodeLabelsFullMethodName, MeshAdminServer.GetNodeLabels),
+		unaryMethod("DeleteNodeLabels", DeleteNodeLabelsFullMethodName, MeshAdminServer.DeleteNodeLabels),
+		unaryMethod("PutTrafficPolicy", PutTrafficPolicyFullMethodName, MeshAdminServer.PutTrafficPolicy),
+		unaryMethod("GetTrafficPolicies", GetTrafficPoliciesFullMethodName, MeshAdminServer.GetTrafficPolicies),
+		unaryMethod("DeleteTrafficPolicy", DeleteTrafficPolicyFullMethodName, MeshAdminServer.DeleteTrafficPolicy),
+		unaryMethod("PutRouteMetric", PutRouteMetricFullMethodName, MeshAdminServer.PutRouteMetric),
+		unaryMethod("GetRouteMetrics", GetRouteMetricsFullMethodName, MeshAdminServer.GetRouteMetrics),
+		unaryMethod("DeleteRouteMetric", DeleteRouteMetricFullMethodName, MeshAdminServer.DeleteRouteMetric),
+	},
+}
+
+func unaryMethod(name, fullName string, call func(MeshAdminServer, context.Context, *structpb.Struct) (*structpb.Struct, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+			in := new(structpb.Struct)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return call(srv.(MeshAdminServer), ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: fullName}
+			return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+				return call(srv.(MeshAdminServer), ctx, req.(*structpb.Struct))
+			})
+		},
+	}
+}
+
+// Server is the mesh admin service.
+type Server struct {
+	nodeID    types.NodeID
+	storage   storage.Provider
+	rbacEval  rbac.Evaluator
+	keyEscrow storage.KeyEscrow
+}
+
+// Options are the options for the mesh admin service.
+type Options struct {
+	// NodeID is the ID of this node.
+	NodeID types.NodeID
+	// Storage is the storage provider.
+	Storage storage.Provider
+	// RBAC is the evaluator used to authorize requests.
+	RBAC rbac.Evaluator
+	// KeyEscrow stores wrapped identity keys. Defaults to storing them in
+	// the mesh database.
+	KeyEscrow storage.KeyEscrow
+}
+
+// NewServer returns a new mesh admin server.
+func NewServer(opts Options) *Server {
+	keyEscrow := opts.KeyEscrow
+	if keyEscrow == nil {
+		keyEscrow = storage.NewKeyEscrow(opts.Storage.MeshStorage())
+	}
+	return &Server{
+		nodeID:    opts.NodeID,
+		storage:   opts.Storage,
+		rbacEval:  opts.RBAC,
+		keyEscrow: keyEscrow,
+	}
+}
+
+// authorize returns an error if the caller is not allowed to perform the
+// given actions.
+func (s *Server) authorize(ctx context.Context, actions rbac.Actions, what string) error {
+	ok, err := s.rbacEval.Evaluate(ctx, actions)
+	if err != nil {
+		context.LoggerFrom(ctx).Error("failed to evaluate action", "action", what, "error", err)
+	}
+	if !ok {
+		return status.Errorf(codes.PermissionDenied, "caller does not have permission to %s", what)
+	}
+	return nil
+}
+
+// requireLeader returns an error if this node is not the leader.
+func (s *Server) requireLeader(ctx context.Context) error {
+	if !s.storage.Consensus().IsLeader() {
+		return leaderproxy.NewNotLeaderError(ctx, s.storage, "not the leader")
+	}
+	return nil
+}
+
+// callerID returns the ID of the node that made the request, or the ID of
+// this node if the caller is not known.
+func (s *Server) callerID(ctx context.Context) types.NodeID {
+	if proxiedFor, ok := leaderproxy.ProxiedFor(ctx); ok && proxiedFor != "" {
+		return types.NodeID(proxiedFor)
+	}
+	if caller, ok := context.AuthenticatedCallerFrom(ctx); ok && caller != "" {
+		return types.NodeID(caller)
+	}
+	return s.nodeID
+}
+
+// recordEvent records a membership event processed by this node.
+func (s *Server) recordEvent(ctx context.Context, typ storage.MembershipEventType, nodeID types.NodeID, reason string) {
+	err := storage.RecordMembershipEvent(ctx, s.storage.MeshStorage(), storage.MembershipEvent{
+		Type:   typ,
+		NodeID: nodeID,
+		Reason: reason,
+		Actor:  s.nodeID,
+	})
+	if err != nil {
+		context.LoggerFrom(ctx).Warn("Failed to record membership event", "error", err.Error())
+	}
+}
+
+// decodeNodeID decodes and validates the "id" field of a request.
+func decodeNodeID(req *structpb.Struct) (types.NodeID, error) {
+	id := req.GetFields()["id"].GetStringValue()
+	if id == "" {
+		return "", fmt.Errorf("node id is required")
+	}
+	if !types.IsValidNodeID(id) {
+		return "", fmt.Errorf("invalid node id %q", id)
+	}
+	return types.NodeID(id), nil
+}
+
+// EncodeFields encodes JSON-encodable values into a request or response.
+// Values are encoded with their JSON representation so they can be decoded
+// with DecodeField.
+func EncodeFields(fields map[string]any) (*structpb.Struct, error) {
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	var out structpb.Struct
+	if err := protojson.Unmarshal(data, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// encodeFields encodes a response with EncodeFields.
+func encodeFields(fields map[string]any) (*structpb.Struct, error) {
+	out, err := EncodeFields(fields)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to encode response: %v", err)
+	}
+	return out, nil
+}
+
+// DecodeField decodes the JSON representation of a field in a request or
+// response into out.
+func DecodeField(resp *structpb.Struct, field string, out any) error {
+	value, ok := resp.GetFields()[field]
+	if !ok {
+		return fmt.Errorf("response is missing %q", field)
+	}
+	data, err := protojson.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encode %q: %w", field, err)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("decode %q: %w", field, err)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnode"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func newTestServer(t *testing.T, secure bool) *Server {
+	t.Helper()
+	ctx := context.Background()
+	node, err := meshnode.NewSingleNodeTestMesh(ctx)
+	if err != nil {
+		t.Fatalf("create test mesh: %v", err)
+	}
+	t.Cleanup(func() { node.Close(ctx) })
+	evaluator := rbac.NewNoopEvaluator()
+	if secure {
+		evaluator = rbac.NewStoreEvaluator(node.Storage().MeshDB())
+	}
+	return NewServer(Options{
+		NodeID:  node.ID(),
+		Storage: node.Storage(),
+		RBAC:    evaluator,
+	})
+}
+
+func registerNode(t *testing.T, s *Server, id string, key crypto.PublicKey) {
+	t.Helper()
+	encoded, err := key.Encode()
+	if err != nil {
+		t.Fatalf("encode key: %v", err)
+	}
+	err = s.storage.MeshDB().Peers().Put(context.Background(), types.MeshNode{MeshNode: &v1.MeshNode{
+		Id:        id,
+		PublicKey: encoded,
+	}})
+	if err != nil {
+		t.Fatalf("register node: %v", err)
+	}
+}
+
+func expectCode(t *testing.T, err error, code codes.Code) {
+	t.Helper()
+	if status.Code(err) != code {
+		t.Fatalf("expected %v, got: %v", code, err)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// Resolving a split-brain alarm can admit a node with diverged state into
+// the mesh, so it is only granted to callers with full access to the mesh.
+var (
+	listSplitBrainAlarmsAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_GET,
+		},
+	}
+	resolveSplitBrainAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_PUT,
+		},
+	}
+)
+
+// ListSplitBrainAlarms returns the raised split-brain alarms in the "alarms"
+// field.
+func (s *Server) ListSplitBrainAlarms(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.authorize(ctx, listSplitBrainAlarmsAction, "list split-brain alarms"); err != nil {
+		return nil, err
+	}
+	alarms, err := storage.ListSplitBrainAlarms(ctx, s.storage.MeshStorage())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list split-brain alarms: %v", err)
+	}
+	return encodeFields(map[string]any{"alarms": alarms})
+}
+
+// ResolveSplitBrain resolves the split-brain alarm raised for the node with
+// the given "id" using the given "action", either storage.SplitBrainRejoin or
+// storage.SplitBrainDismiss.
+func (s *Server) ResolveSplitBrain(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, resolveSplitBrainAction, "resolve split-brain alarms"); err != nil {
+		return nil, err
+	}
+	nodeID, err := decodeNodeID(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	action := req.GetFields()["action"].GetStringValue()
+	switch action {
+	case storage.SplitBrainRejoin, storage.SplitBrainDismiss:
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "action must be %q or %q", storage.SplitBrainRejoin, storage.SplitBrainDismiss)
+	}
+	if _, err := storage.GetSplitBrainAlarm(ctx, s.storage.MeshStorage(), nodeID); err != nil {
+		if errors.IsKeyNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "no split-brain alarm raised for node %s", nodeID)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to get split-brain alarm: %v", err)
+	}
+	if err := storage.ResolveSplitBrainAlarm(ctx, s.storage.MeshStorage(), nodeID, action); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to resolve split-brain alarm: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Resolved split-brain alarm", "node", nodeID, "action", action)
+	return &structpb.Struct{}, nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+func TestResolveSplitBrain(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	raise := func(t *testing.T, s *Server) {
+		t.Helper()
+		_, err := storage.RaiseSplitBrainAlarm(ctx, s.storage.MeshStorage(), storage.SplitBrainAlarm{
+			NodeID:          "node-a",
+			Reason:          "cluster id mismatch",
+			LocalClusterID:  "local",
+			RemoteClusterID: "remote",
+		})
+		if err != nil {
+			t.Fatalf("raise split-brain alarm: %v", err)
+		}
+	}
+	resolveRequest := func(id, action string) *structpb.Struct {
+		return &structpb.Struct{Fields: map[string]*structpb.Value{
+			"id":     structpb.NewStringValue(id),
+			"action": structpb.NewStringValue(action),
+		}}
+	}
+
+	t.Run("Rejoin", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		raise(t, s)
+		resp, err := s.ListSplitBrainAlarms(ctx, &structpb.Struct{})
+		if err != nil {
+			t.Fatalf("list split-brain alarms: %v", err)
+		}
+		var alarms []storage.SplitBrainAlarm
+		if err := DecodeField(resp, "alarms", &alarms); err != nil {
+			t.Fatalf("decode alarms: %v", err)
+		}
+		if len(alarms) != 1 || alarms[0].NodeID != "node-a" || alarms[0].AllowRejoin {
+			t.Fatalf("unexpected alarms: %+v", alarms)
+		}
+		if _, err := s.ResolveSplitBrain(ctx, resolveRequest("node-a", storage.SplitBrainRejoin)); err != nil {
+			t.Fatalf("resolve split-brain alarm: %v", err)
+		}
+		alarm, err := storage.GetSplitBrainAlarm(ctx, s.storage.MeshStorage(), "node-a")
+		if err != nil {
+			t.Fatalf("get split-brain alarm: %v", err)
+		}
+		if !alarm.AllowRejoin {
+			t.Fatal("expected node-a to be allowed to rejoin")
+		}
+	})
+
+	t.Run("Dismiss", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		raise(t, s)
+		if _, err := s.ResolveSplitBrain(ctx, resolveRequest("node-a", storage.SplitBrainDismiss)); err != nil {
+			t.Fatalf("resolve split-brain alarm: %v", err)
+		}
+		_, err := storage.GetSplitBrainAlarm(ctx, s.storage.MeshStorage(), "node-a")
+		if !errors.IsKeyNotFound(err) {
+			t.Fatalf("expected alarm to be cleared, got %v", err)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		raise(t, s)
+		_, err := s.ResolveSplitBrain(ctx, resolveRequest("node-a", "merge"))
+		expectCode(t, err, codes.InvalidArgument)
+		_, err = s.ResolveSplitBrain(ctx, resolveRequest("", storage.SplitBrainRejoin))
+		expectCode(t, err, codes.InvalidArgument)
+		_, err = s.ResolveSplitBrain(ctx, resolveRequest("node-b", storage.SplitBrainRejoin))
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		raise(t, s)
+		_, err := s.ListSplitBrainAlarms(ctx, &structpb.Struct{})
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.ResolveSplitBrain(ctx, resolveRequest("node-a", storage.SplitBrainRejoin))
+		expectCode(t, err, codes.PermissionDenied)
+	})
+}
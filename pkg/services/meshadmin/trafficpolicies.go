@@ -0,0 +1,121 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Traffic policies can limit the bandwidth between any nodes and prefixes in
+// the mesh, so they are only granted to callers with full access to the mesh.
+var (
+	getTrafficPoliciesAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_GET,
+		},
+	}
+	putTrafficPolicyAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_PUT,
+		},
+	}
+	deleteTrafficPolicyAction = rbac.Actions{
+		{
+			Resource: v1.RuleResource_RESOURCE_ALL,
+			Verb:     v1.RuleVerb_VERB_DELETE,
+		},
+	}
+)
+
+// PutTrafficPolicy creates or replaces the traffic policy with the given
+// "name" with the one in the "policy" field of the request.
+func (s *Server) PutTrafficPolicy(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, putTrafficPolicyAction, "put traffic policies"); err != nil {
+		return nil, err
+	}
+	name := req.GetFields()["name"].GetStringValue()
+	if !types.IsValidID(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid traffic policy name %q", name)
+	}
+	var policy types.TrafficPolicy
+	if err := DecodeField(req, "policy", &policy); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := storage.PutTrafficPolicy(ctx, s.storage.MeshStorage(), name, policy); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to put traffic policy: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Put traffic policy", "name", name)
+	return &structpb.Struct{}, nil
+}
+
+// GetTrafficPolicies returns all traffic policies in the "policies" field.
+// If the request has a "name", only that policy is returned.
+func (s *Server) GetTrafficPolicies(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.authorize(ctx, getTrafficPoliciesAction, "get traffic policies"); err != nil {
+		return nil, err
+	}
+	if name := req.GetFields()["name"].GetStringValue(); name != "" {
+		policy, ok, err := storage.GetTrafficPolicy(ctx, s.storage.MeshStorage(), name)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get traffic policy: %v", err)
+		}
+		if !ok {
+			return nil, status.Errorf(codes.NotFound, "traffic policy %s not found", name)
+		}
+		return encodeFields(map[string]any{"policies": types.TrafficPolicies{name: policy}})
+	}
+	policies, err := storage.ListTrafficPolicies(ctx, s.storage.MeshStorage())
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list traffic policies: %v", err)
+	}
+	return encodeFields(map[string]any{"policies": policies})
+}
+
+// DeleteTrafficPolicy deletes the traffic policy with the given "name".
+func (s *Server) DeleteTrafficPolicy(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if err := s.requireLeader(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.authorize(ctx, deleteTrafficPolicyAction, "delete traffic policies"); err != nil {
+		return nil, err
+	}
+	name := req.GetFields()["name"].GetStringValue()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "traffic policy name is required")
+	}
+	if err := storage.DeleteTrafficPolicy(ctx, s.storage.MeshStorage(), name); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to delete traffic policy: %v", err)
+	}
+	context.LoggerFrom(ctx).Info("Deleted traffic policy", "name", name)
+	return &structpb.Struct{}, nil
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshadmin
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestTrafficPolicies(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	putRequest := func(t *testing.T, name string, policy types.TrafficPolicy) *structpb.Struct {
+		t.Helper()
+		req, err := EncodeFields(map[string]any{"name": name, "policy": policy})
+		if err != nil {
+			t.Fatalf("encode request: %v", err)
+		}
+		return req
+	}
+	nameRequest := func(name string) *structpb.Struct {
+		return &structpb.Struct{Fields: map[string]*structpb.Value{"name": structpb.NewStringValue(name)}}
+	}
+	policy := types.TrafficPolicy{CIDRs: []string{"10.0.0.0/24"}, Rate: 1_000_000}
+
+	t.Run("PutGetDelete", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		if _, err := s.PutTrafficPolicy(ctx, putRequest(t, "tenant-a", policy)); err != nil {
+			t.Fatalf("put traffic policy: %v", err)
+		}
+		resp, err := s.GetTrafficPolicies(ctx, nameRequest("tenant-a"))
+		if err != nil {
+			t.Fatalf("get traffic policy: %v", err)
+		}
+		var got types.TrafficPolicies
+		if err := DecodeField(resp, "policies", &got); err != nil {
+			t.Fatalf("decode traffic policies: %v", err)
+		}
+		if got["tenant-a"].Rate != policy.Rate {
+			t.Fatalf("unexpected traffic policies: %+v", got)
+		}
+		if _, err := s.DeleteTrafficPolicy(ctx, nameRequest("tenant-a")); err != nil {
+			t.Fatalf("delete traffic policy: %v", err)
+		}
+		_, err = s.GetTrafficPolicies(ctx, nameRequest("tenant-a"))
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, false)
+		_, err := s.PutTrafficPolicy(ctx, putRequest(t, "not a policy", policy))
+		expectCode(t, err, codes.InvalidArgument)
+		_, err = s.PutTrafficPolicy(ctx, putRequest(t, "tenant-a", types.TrafficPolicy{Rate: 1_000_000}))
+		expectCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("PermissionDenied", func(t *testing.T) {
+		t.Parallel()
+		s := newTestServer(t, true)
+		_, err := s.PutTrafficPolicy(ctx, putRequest(t, "tenant-a", policy))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.DeleteTrafficPolicy(ctx, nameRequest("tenant-a"))
+		expectCode(t, err, codes.PermissionDenied)
+		_, err = s.GetTrafficPolicies(ctx, &structpb.Struct{})
+		expectCode(t, err, codes.PermissionDenied)
+	})
+}
@@ -23,24 +23,39 @@ import (
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
+// HealthModeHeader is the metadata header callers can use to override the
+// health mode applied to node lists, e.g. "none" to list all nodes.
+const HealthModeHeader = "x-webmesh-health-mode"
+
+// Options are options for the Mesh API server.
+type Options struct {
+	// Health reports the liveness of peers. If nil, node lists are not health-aware.
+	Health meshnet.PeerHealth
+	// HealthMode is the health mode applied to node lists by default.
+	HealthMode meshnet.HealthMode
+}
+
 // Server is the webmesh Mesh service.
 type Server struct {
 	v1.UnimplementedMeshServer
 
 	storage storage.MeshDB
+	opts    Options
 }
 
 // NewServer returns a new Server.
-func NewServer(storage storage.MeshDB) *Server {
-	return &Server{storage: storage}
+func NewServer(storage storage.MeshDB, opts Options) *Server {
+	return &Server{storage: storage, opts: opts}
 }
 
 func (s *Server) GetNode(ctx context.Context, req *v1.GetNodeRequest) (*v1.MeshNode, error) {
@@ -59,6 +74,11 @@ func (s *Server) ListNodes(ctx context.Context, req *emptypb.Empty) (*v1.NodeLis
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "failed to get node: %v", err)
 	}
+	mode, err := s.healthMode(ctx)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	nodes = meshnet.ApplyHealth(nodes, s.opts.Health, mode)
 	out := make([]*v1.MeshNode, len(nodes))
 	for i, node := range nodes {
 		out[i] = node.MeshNode
@@ -100,3 +120,11 @@ func (s *Server) GetMeshGraph(ctx context.Context, _ *emptypb.Empty) (*v1.MeshGr
 	out.Dot = buf.String()
 	return out, nil
 }
+
+func (s *Server) healthMode(ctx context.Context) (meshnet.HealthMode, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get(HealthModeHeader)) == 0 {
+		return s.opts.HealthMode, nil
+	}
+	return meshnet.ParseHealthMode(md.Get(HealthModeHeader)[0])
+}
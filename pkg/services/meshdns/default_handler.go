@@ -125,6 +125,9 @@ func (s *Server) handleDefault(ctx context.Context, w dns.ResponseWriter, r *dns
 		}
 		s.log.Debug("Forward lookup succeeded", slog.Duration("rtt", rtt))
 		if m.Rcode == dns.RcodeSuccess {
+			if q.Qtype == dns.TypeAAAA && s.opts.DNS64Prefix.IsValid() {
+				s.synthesizeDNS64(ctx, cli, forwarder, r, m)
+			}
 			// If the forwarder returned a success response, save it in the cache and return it
 			s.log.Debug("Received success response from forwarder, returning", slog.String("forwarder", forwarder))
 			if s.cache != nil {
@@ -0,0 +1,123 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdns
+
+import (
+	"log/slog"
+	"net/netip"
+
+	"github.com/miekg/dns"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/nat64"
+)
+
+// synthesizeDNS64 adds AAAA records to a forwarded AAAA response without
+// answers for the A records of the name that are reachable through a NAT64
+// gateway in the mesh.
+func (s *Server) synthesizeDNS64(ctx context.Context, cli *dns.Client, forwarder string, r, m *dns.Msg) {
+	for _, rr := range m.Answer {
+		if rr.Header().Rrtype == dns.TypeAAAA {
+			return
+		}
+	}
+	dests := s.dns64Destinations(ctx)
+	if len(dests) == 0 {
+		return
+	}
+	req := r.Copy()
+	req.Question[0].Qtype = dns.TypeA
+	resp, _, err := cli.ExchangeContext(ctx, req, forwarder)
+	if err != nil {
+		s.log.Debug("DNS64 A lookup failed", slog.String("error", err.Error()))
+		return
+	}
+	if resp.Rcode != dns.RcodeSuccess {
+		return
+	}
+	for _, rr := range resp.Answer {
+		a, ok := rr.(*dns.A)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(a.A.To4())
+		if !ok || !containsAddr(dests, addr) {
+			continue
+		}
+		m.Answer = append(m.Answer, &dns.AAAA{
+			Hdr: dns.RR_Header{
+				Name:   a.Hdr.Name,
+				Rrtype: dns.TypeAAAA,
+				Class:  dns.ClassINET,
+				Ttl:    a.Hdr.Ttl,
+			},
+			AAAA: nat64.Embed(s.opts.DNS64Prefix, addr).AsSlice(),
+		})
+	}
+}
+
+// dns64Destinations returns the IPv4 destinations of the routes of nodes that
+// advertise the DNS64 prefix. It must be called with the server lock held.
+func (s *Server) dns64Destinations(ctx context.Context) []netip.Prefix {
+	var dests []netip.Prefix
+	for _, mux := range s.meshmuxes {
+		mux.mu.RLock()
+		for _, dom := range mux.meshes {
+			routes, err := dom.storage.MeshDB().Networking().ListRoutes(ctx)
+			if err != nil {
+				s.log.Debug("Failed to list routes for DNS64", slog.String("error", err.Error()))
+				continue
+			}
+			gateways := make(map[string]struct{})
+			for _, route := range routes {
+				if containsPrefix(route.DestinationPrefixes(), s.opts.DNS64Prefix) {
+					gateways[route.GetNode()] = struct{}{}
+				}
+			}
+			for _, route := range routes {
+				if _, ok := gateways[route.GetNode()]; !ok {
+					continue
+				}
+				for _, prefix := range route.DestinationPrefixes() {
+					if prefix.Addr().Is4() {
+						dests = append(dests, prefix)
+					}
+				}
+			}
+		}
+		mux.mu.RUnlock()
+	}
+	return dests
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsPrefix(prefixes []netip.Prefix, prefix netip.Prefix) bool {
+	for _, p := range prefixes {
+		if p.Masked() == prefix.Masked() {
+			return true
+		}
+	}
+	return false
+}
@@ -26,6 +26,7 @@ import (
 	v1 "github.com/webmeshproj/api/go/v1"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
@@ -55,6 +56,7 @@ type meshDomain struct {
 	domain   string
 	storage  storage.Provider
 	ipv6Only bool
+	health   meshnet.PeerHealth
 }
 
 func (s *Server) newMeshLookupMux(dom meshDomain) *meshLookupMux {
@@ -164,17 +166,21 @@ func (s *meshLookupMux) handleVotersLookup(ctx context.Context, w dns.ResponseWr
 	mesh := s.meshes[0]
 	m := s.newMsg(mesh, r)
 	status := mesh.storage.Status()
+	var ids []string
 	for _, server := range status.GetPeers() {
 		if status.ClusterStatus == v1.ClusterStatus_CLUSTER_VOTER {
-			m.Answer = append(m.Answer, &dns.CNAME{
-				Hdr:    dns.RR_Header{Name: newFQDN(mesh, "voters"), Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 1},
-				Target: newFQDN(mesh, server.GetId()),
-			})
-			err := s.appendPeerToMessage(ctx, mesh, r, m, server.GetId(), s.ipv6Only)
-			if err != nil {
-				s.writeMsg(w, r, m, errToRcode(err))
-				return
-			}
+			ids = append(ids, server.GetId())
+		}
+	}
+	for _, id := range s.orderByHealth(ctx, mesh, r, ids) {
+		m.Answer = append(m.Answer, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: newFQDN(mesh, "voters"), Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 1},
+			Target: newFQDN(mesh, id),
+		})
+		err := s.appendPeerToMessage(ctx, mesh, r, m, id, s.ipv6Only)
+		if err != nil {
+			s.writeMsg(w, r, m, errToRcode(err))
+			return
 		}
 	}
 	s.writeMsg(w, r, m, dns.RcodeSuccess)
@@ -189,18 +195,45 @@ func (s *meshLookupMux) handleObserversLookup(ctx context.Context, w dns.Respons
 	mesh := s.meshes[0]
 	m := s.newMsg(mesh, r)
 	status := mesh.storage.Status()
+	var ids []string
 	for _, server := range status.GetPeers() {
 		if server.ClusterStatus == v1.ClusterStatus_CLUSTER_OBSERVER {
-			m.Answer = append(m.Answer, &dns.CNAME{
-				Hdr:    dns.RR_Header{Name: newFQDN(mesh, "observers"), Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 1},
-				Target: newFQDN(mesh, server.GetId()),
-			})
-			err := s.appendPeerToMessage(ctx, mesh, r, m, server.GetId(), s.ipv6Only)
-			if err != nil {
-				s.writeMsg(w, r, m, errToRcode(err))
-				return
-			}
+			ids = append(ids, server.GetId())
+		}
+	}
+	for _, id := range s.orderByHealth(ctx, mesh, r, ids) {
+		m.Answer = append(m.Answer, &dns.CNAME{
+			Hdr:    dns.RR_Header{Name: newFQDN(mesh, "observers"), Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 1},
+			Target: newFQDN(mesh, id),
+		})
+		err := s.appendPeerToMessage(ctx, mesh, r, m, id, s.ipv6Only)
+		if err != nil {
+			s.writeMsg(w, r, m, errToRcode(err))
+			return
 		}
 	}
 	s.writeMsg(w, r, m, dns.RcodeSuccess)
 }
+
+// orderByHealth orders the given node IDs according to the configured health mode.
+// Nodes that cannot be found are left in place for appendPeerToMessage to report.
+func (s *meshLookupMux) orderByHealth(ctx context.Context, mesh meshDomain, r *dns.Msg, ids []string) []string {
+	if !s.healthAware(mesh, r) || len(ids) == 0 {
+		return ids
+	}
+	nodes := make([]types.MeshNode, 0, len(ids))
+	var missing []string
+	for _, id := range ids {
+		node, err := mesh.storage.MeshDB().Peers().Get(ctx, types.NodeID(id))
+		if err != nil {
+			missing = append(missing, id)
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	ordered := make([]string, 0, len(ids))
+	for _, node := range meshnet.ApplyHealth(nodes, mesh.health, s.opts.HealthMode) {
+		ordered = append(ordered, node.GetId())
+	}
+	return append(ordered, missing...)
+}
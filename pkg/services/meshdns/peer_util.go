@@ -24,6 +24,7 @@ import (
 	"github.com/miekg/dns"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -34,8 +35,12 @@ func (s *Server) appendPeerToMessage(ctx context.Context, dom meshDomain, r, m *
 		return err
 	}
 	s.log.Debug("Found peer in mesh")
+	if s.healthAware(dom, r) && s.opts.HealthMode == meshnet.HealthModeFilter && dom.health.Status(peer) == meshnet.HealthDead {
+		s.log.Debug("Peer is not alive, omitting from answer", slog.String("peer-id", peerID))
+		return errPeerNotAlive{}
+	}
 	fqdn := newFQDN(dom, peer.GetId())
-	for i, q := range r.Question {
+	for _, q := range r.Question {
 		switch q.Qtype {
 		case dns.TypeTXT:
 			s.log.Debug("Handling peer TXT question")
@@ -53,17 +58,16 @@ func (s *Server) appendPeerToMessage(ctx context.Context, dom meshDomain, r, m *
 				})
 			}
 		case dns.TypeA:
+			// A peer without an address in the asked family still exists, so
+			// the question is left unanswered instead of failing the lookup.
+			// Answering NXDOMAIN would make resolvers drop the other family.
 			if ipv6Only {
-				if i != len(r.Question)-1 {
-					// Maybe they asked for a AAAA also
-					continue
-				}
-				return errNoIPv4{}
+				continue
 			}
 			s.log.Debug("Handling peer A question")
 			if !peer.PrivateAddrV4().IsValid() {
 				s.log.Debug("No private IPv4 address for peer")
-				return errNoIPv4{}
+				continue
 			}
 			m.Answer = append(m.Answer, &dns.A{
 				Hdr: dns.RR_Header{Name: fqdn, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 1},
@@ -74,7 +78,7 @@ func (s *Server) appendPeerToMessage(ctx context.Context, dom meshDomain, r, m *
 			s.log.Debug("Handling peer AAAA question")
 			if !peer.PrivateAddrV6().IsValid() {
 				s.log.Debug("No private IPv6 address for peer")
-				return errNoIPv6{}
+				continue
 			}
 			m.Answer = append(m.Answer, &dns.AAAA{
 				Hdr:  dns.RR_Header{Name: fqdn, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 1},
@@ -86,6 +90,15 @@ func (s *Server) appendPeerToMessage(ctx context.Context, dom meshDomain, r, m *
 	return nil
 }
 
+// healthAware returns true if answers for the given domain should take peer
+// health into account. Clients can opt out by setting the CD bit.
+func (s *Server) healthAware(dom meshDomain, r *dns.Msg) bool {
+	if dom.health == nil || r.CheckingDisabled {
+		return false
+	}
+	return s.opts.HealthMode == meshnet.HealthModeSort || s.opts.HealthMode == meshnet.HealthModeFilter
+}
+
 func newPeerTXTRecord(name string, peer *types.MeshNode) *dns.TXT {
 	txtData := []string{
 		fmt.Sprintf("id=%s", peer.GetId()),
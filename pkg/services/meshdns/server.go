@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
 	"sync"
 	"time"
 
@@ -30,6 +31,7 @@ import (
 	"golang.org/x/sync/errgroup"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	dnsutil "github.com/webmeshproj/webmesh/pkg/meshnet/system/dns"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
@@ -69,6 +71,14 @@ type Options struct {
 	DisableForwarding bool
 	// CacheSize is the size of the remote DNS cache.
 	CacheSize int
+	// HealthMode controls how peer health is applied to answers for domains
+	// registered with a PeerHealth. Clients can bypass it by setting the
+	// Checking Disabled (CD) bit on their query, e.g. "dig +cd".
+	HealthMode meshnet.HealthMode
+	// DNS64Prefix enables DNS64 when set. Forwarded AAAA queries without
+	// answers are answered with A records embedded in this prefix, for
+	// addresses routed through a mesh node that advertises it.
+	DNS64Prefix netip.Prefix
 }
 
 // NewServer returns a new Mesh DNS server.
@@ -182,6 +192,9 @@ type DomainOptions struct {
 	// SubscribeForwarders indicates that new forwarders added to the mesh should be
 	// appeneded to the current server.
 	SubscribeForwarders bool
+	// Health reports the liveness of peers in this domain. It is used according
+	// to the server's HealthMode. If nil, answers are not health-aware.
+	Health meshnet.PeerHealth
 }
 
 // ListenPortUDP returns the UDP listen port.
@@ -289,6 +302,7 @@ func (s *Server) RegisterDomain(opts DomainOptions) error {
 		domain:   opts.MeshDomain,
 		storage:  opts.MeshStorage,
 		ipv6Only: opts.IPv6Only,
+		health:   opts.Health,
 	}
 	// Check if we have an overlapping domain. This is not a good way to run this,
 	// but we'll support it for test cases. A flag should maybe be exposed to cause
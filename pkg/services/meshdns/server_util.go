@@ -27,16 +27,12 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 )
 
-type errNoIPv4 struct{}
+// errPeerNotAlive is returned when a peer is omitted from an answer because it
+// is not alive. The reply is sent with no answers.
+type errPeerNotAlive struct{}
 
-func (e errNoIPv4) Error() string {
-	return "no IPv4 address"
-}
-
-type errNoIPv6 struct{}
-
-func (e errNoIPv6) Error() string {
-	return "no IPv6 address"
+func (e errPeerNotAlive) Error() string {
+	return "peer is not alive"
 }
 
 func errToRcode(err error) int {
@@ -45,7 +41,9 @@ func errToRcode(err error) int {
 		return dns.RcodeSuccess
 	case context.DeadlineExceeded:
 		return dns.RcodeServerFailure
-	case errors.ErrNodeNotFound, errNoIPv4{}, errNoIPv6{}:
+	case errPeerNotAlive{}:
+		return dns.RcodeSuccess
+	case errors.ErrNodeNotFound:
 		return dns.RcodeNameError
 	default:
 		return dns.RcodeServerFailure
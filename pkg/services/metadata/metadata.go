@@ -0,0 +1,277 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package metadata contains the local API workloads on a node use to look up
+// the mesh identity of the peers they talk to.
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultSocket is the default socket for the metadata API.
+const DefaultSocket = "/var/run/webmesh/metadata.sock"
+
+// PeersPath returns the identity of the mesh peer at the address
+// appended to the path, for example /v1/peers/172.16.0.2.
+const PeersPath = "/v1/peers/"
+
+// Labels attached to peer identities.
+const (
+	LabelZone            = "webmesh.io/zone"
+	LabelAttachment      = "webmesh.io/attachment"
+	LabelAttachmentOwner = "webmesh.io/attachment-owner"
+)
+
+// Options are the options for the metadata API.
+type Options struct {
+	// Socket is the unix socket to serve the API on. It is
+	// disabled when empty.
+	Socket string
+	// ListenAddress is a TCP address to serve the API on, for example
+	// a link-local address assigned to the host. It is disabled when empty.
+	ListenAddress string
+	// Storage is the mesh database used to look up peers.
+	Storage storage.MeshDB
+	// MeshStorage is the raw mesh storage used to look up attachments.
+	MeshStorage storage.MeshStorage
+}
+
+// Identity is the mesh identity of the peer at an address.
+type Identity struct {
+	// Address is the address that was looked up.
+	Address string `json:"address"`
+	// NodeID is the ID of the node the address belongs to or is routed through.
+	NodeID string `json:"nodeID"`
+	// PublicKey is the public key of the node.
+	PublicKey string `json:"publicKey,omitempty"`
+	// AddressV4 is the private IPv4 address of the node.
+	AddressV4 string `json:"addressV4,omitempty"`
+	// AddressV6 is the private IPv6 address of the node.
+	AddressV6 string `json:"addressV6,omitempty"`
+	// Features are the features exposed by the node.
+	Features []string `json:"features,omitempty"`
+	// Labels are additional attributes of the node and workload.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Tags are the network ACL tags of the workload at the address.
+	Tags []string `json:"tags,omitempty"`
+	// Groups are the groups the node is a member of.
+	Groups []string `json:"groups,omitempty"`
+}
+
+// Server is the metadata API server.
+type Server struct {
+	Options
+	srv *http.Server
+	log *slog.Logger
+}
+
+// New returns a new metadata API server.
+func New(ctx context.Context, o Options) *Server {
+	s := &Server{
+		Options: o,
+		log:     context.LoggerFrom(ctx).With("component", "metadata-api"),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(PeersPath, s.servePeer)
+	s.srv = &http.Server{Handler: mux}
+	return s
+}
+
+// ListenAndServe starts the server and blocks until the server exits.
+func (s *Server) ListenAndServe() error {
+	var listeners []net.Listener
+	closeAll := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+	if s.Socket != "" {
+		s.log.Info("Starting metadata API server", slog.String("socket", s.Socket))
+		if err := os.MkdirAll(filepath.Dir(s.Socket), 0755); err != nil {
+			return fmt.Errorf("create socket directory: %w", err)
+		}
+		if err := os.Remove(s.Socket); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove stale socket: %w", err)
+		}
+		ln, err := net.Listen("unix", s.Socket)
+		if err != nil {
+			return fmt.Errorf("listen on socket: %w", err)
+		}
+		listeners = append(listeners, ln)
+		// The API is read-only and meant for any workload on the host.
+		if err := os.Chmod(s.Socket, 0666); err != nil {
+			closeAll()
+			return fmt.Errorf("set socket permissions: %w", err)
+		}
+	}
+	if s.ListenAddress != "" {
+		s.log.Info("Starting metadata API server", slog.String("listen_address", s.ListenAddress))
+		ln, err := net.Listen("tcp", s.ListenAddress)
+		if err != nil {
+			closeAll()
+			return fmt.Errorf("listen on %s: %w", s.ListenAddress, err)
+		}
+		listeners = append(listeners, ln)
+	}
+	if len(listeners) == 0 {
+		return fmt.Errorf("no socket or listen address configured")
+	}
+	errs := make(chan error, len(listeners))
+	for _, ln := range listeners {
+		go func(ln net.Listener) {
+			errs <- s.srv.Serve(ln)
+		}(ln)
+	}
+	for range listeners {
+		if err := <-errs; err != nil && err != http.ErrServerClosed {
+			s.srv.Close()
+			return err
+		}
+	}
+	return nil
+}
+
+// Shutdown attempts to stop the server gracefully.
+func (s *Server) Shutdown(ctx context.Context) error {
+	context.LoggerFrom(ctx).Info("Shutting down metadata API server")
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) servePeer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	addr, err := netip.ParseAddr(strings.Trim(strings.TrimPrefix(r.URL.Path, PeersPath), "/"))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid address: %v", err), http.StatusBadRequest)
+		return
+	}
+	id, ok, err := Lookup(r.Context(), s.Storage, s.MeshStorage, addr)
+	if err != nil {
+		s.log.Error("Failed to look up peer", slog.String("address", addr.String()), slog.String("error", err.Error()))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, fmt.Sprintf("no mesh peer at %s", addr), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(id); err != nil {
+		s.log.Error("Failed to write peer response", slog.String("error", err.Error()))
+	}
+}
+
+// Lookup returns the identity of the mesh peer at the given address. Addresses of
+// workload attachments resolve to the node they are behind along with their tags.
+// Other addresses resolve to the node owning them or, failing that, the node
+// advertising the most specific route containing them. False is returned if no
+// node is found.
+func Lookup(ctx context.Context, db storage.MeshDB, st storage.MeshStorage, addr netip.Addr) (Identity, bool, error) {
+	id := Identity{Address: addr.String(), Labels: map[string]string{}}
+	attachments, err := storage.ListAttachments(ctx, st)
+	if err != nil {
+		return id, false, fmt.Errorf("list attachments: %w", err)
+	}
+	for _, a := range attachments {
+		if a.AddressV4.Addr() == addr || a.AddressV6.Addr() == addr {
+			id.NodeID = a.NodeID.String()
+			id.Tags = append(id.Tags, a.Tags...)
+			id.Labels[LabelAttachment] = a.ID
+			if a.Owner != "" {
+				id.Labels[LabelAttachmentOwner] = a.Owner
+			}
+			break
+		}
+	}
+	if id.NodeID == "" {
+		nodeID, ok, err := ownerOf(ctx, db, addr)
+		if err != nil || !ok {
+			return id, false, err
+		}
+		id.NodeID = nodeID
+	}
+	node, err := db.Peers().Get(ctx, types.NodeID(id.NodeID))
+	if err != nil {
+		return id, false, fmt.Errorf("get node %s: %w", id.NodeID, err)
+	}
+	id.PublicKey = node.GetPublicKey()
+	if prefix := node.PrivateAddrV4(); prefix.IsValid() {
+		id.AddressV4 = prefix.Addr().String()
+	}
+	if prefix := node.PrivateAddrV6(); prefix.IsValid() {
+		id.AddressV6 = prefix.Addr().String()
+	}
+	for _, feat := range node.GetFeatures() {
+		id.Features = append(id.Features, strings.ToLower(feat.GetFeature().String()))
+	}
+	sort.Strings(id.Features)
+	if node.GetZoneAwarenessID() != "" {
+		id.Labels[LabelZone] = node.GetZoneAwarenessID()
+	}
+	groups, err := db.RBAC().ListGroups(ctx)
+	if err != nil {
+		return id, false, fmt.Errorf("list groups: %w", err)
+	}
+	for _, group := range groups {
+		if group.ContainsNode(node.NodeID()) {
+			id.Groups = append(id.Groups, group.GetName())
+		}
+	}
+	sort.Strings(id.Groups)
+	return id, true, nil
+}
+
+func ownerOf(ctx context.Context, db storage.MeshDB, addr netip.Addr) (string, bool, error) {
+	nodes, err := db.Peers().List(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("list nodes: %w", err)
+	}
+	for _, node := range nodes {
+		if node.PrivateAddrV4().Contains(addr) || node.PrivateAddrV6().Contains(addr) {
+			return node.GetId(), true, nil
+		}
+	}
+	routes, err := db.Networking().ListRoutes(ctx)
+	if err != nil {
+		return "", false, fmt.Errorf("list routes: %w", err)
+	}
+	var match netip.Prefix
+	var nodeID string
+	for _, route := range routes {
+		for _, prefix := range route.DestinationPrefixes() {
+			if prefix.Contains(addr) && (!match.IsValid() || prefix.Bits() > match.Bits()) {
+				match, nodeID = prefix, route.GetNode()
+			}
+		}
+	}
+	return nodeID, match.IsValid(), nil
+}
@@ -0,0 +1,147 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metadata
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+
+	encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+		Id:              "node-b",
+		PublicKey:       encoded,
+		PrivateIPv4:     "172.16.0.2/32",
+		ZoneAwarenessID: "zone-a",
+		Features: []*v1.FeaturePort{
+			{Feature: v1.Feature_NODES, Port: 8443},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.RBAC().PutGroup(ctx, types.Group{Group: &v1.Group{
+		Name:     "backends",
+		Subjects: []*v1.Subject{{Name: "node-b", Type: v1.SubjectType_SUBJECT_NODE}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name:             "node-b-lan",
+		Node:             "node-b",
+		DestinationCIDRs: []string{"10.0.0.0/8"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = storage.PutAttachment(ctx, st, storage.Attachment{
+		ID:        "c1",
+		NodeID:    "node-b",
+		Owner:     "docker",
+		AddressV4: netip.MustParsePrefix("172.16.0.10/32"),
+		Tags:      []string{"web"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := New(ctx, Options{Storage: db, MeshStorage: st}).srv.Handler
+	get := func(t *testing.T, addr string) (Identity, int) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, PeersPath+addr, nil))
+		var id Identity
+		if rec.Code == http.StatusOK {
+			if err := json.Unmarshal(rec.Body.Bytes(), &id); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return id, rec.Code
+	}
+
+	t.Run("NodeAddress", func(t *testing.T) {
+		id, code := get(t, "172.16.0.2")
+		if code != http.StatusOK {
+			t.Fatalf("unexpected status %d", code)
+		}
+		if id.NodeID != "node-b" || id.PublicKey != encoded || id.AddressV4 != "172.16.0.2" {
+			t.Errorf("unexpected identity: %+v", id)
+		}
+		if id.Labels[LabelZone] != "zone-a" || !slices.Equal(id.Features, []string{"nodes"}) {
+			t.Errorf("unexpected labels or features: %+v", id)
+		}
+		if !slices.Contains(id.Groups, "backends") {
+			t.Errorf("expected node to be in the backends group, got %v", id.Groups)
+		}
+	})
+
+	t.Run("Attachment", func(t *testing.T) {
+		id, code := get(t, "172.16.0.10")
+		if code != http.StatusOK {
+			t.Fatalf("unexpected status %d", code)
+		}
+		if id.NodeID != "node-b" || !slices.Equal(id.Tags, []string{"web"}) {
+			t.Errorf("unexpected identity: %+v", id)
+		}
+		if id.Labels[LabelAttachment] != "c1" || id.Labels[LabelAttachmentOwner] != "docker" {
+			t.Errorf("unexpected labels: %v", id.Labels)
+		}
+	})
+
+	t.Run("RoutedAddress", func(t *testing.T) {
+		id, code := get(t, "10.1.2.3")
+		if code != http.StatusOK {
+			t.Fatalf("unexpected status %d", code)
+		}
+		if id.NodeID != "node-b" || len(id.Tags) != 0 {
+			t.Errorf("unexpected identity: %+v", id)
+		}
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		if _, code := get(t, "192.168.1.1"); code != http.StatusNotFound {
+			t.Errorf("expected not found, got %d", code)
+		}
+		if _, code := get(t, "not-an-ip"); code != http.StatusBadRequest {
+			t.Errorf("expected bad request, got %d", code)
+		}
+	})
+}
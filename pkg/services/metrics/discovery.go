@@ -0,0 +1,160 @@
+//go:build !wasm
+
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultServiceDiscoveryPath is the default path for the Prometheus HTTP service discovery endpoint.
+const DefaultServiceDiscoveryPath = "/sd"
+
+// Labels attached to discovered targets. Prometheus drops labels prefixed
+// with __meta_ after relabeling unless they are explicitly kept.
+const (
+	LabelNodeID          = "__meta_webmesh_node_id"
+	LabelZone            = "__meta_webmesh_zone"
+	LabelFeatures        = "__meta_webmesh_features"
+	LabelPrivateIPv4     = "__meta_webmesh_private_ipv4"
+	LabelPrivateIPv6     = "__meta_webmesh_private_ipv6"
+	LabelPrimaryEndpoint = "__meta_webmesh_primary_endpoint"
+)
+
+// TargetGroup is a Prometheus http_sd and file_sd target group.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+// NewTargetGroups returns a target group for every node in the list exposing
+// metrics. Targets use the node's private IPv4 address when available unless
+// preferIPv6 is true.
+func NewTargetGroups(nodes []types.MeshNode, preferIPv6 bool) []TargetGroup {
+	groups := make([]TargetGroup, 0, len(nodes))
+	for _, node := range nodes {
+		port := node.PortFor(v1.Feature_METRICS)
+		if port == 0 {
+			continue
+		}
+		addrv4, addrv6 := node.PrivateAddrV4(), node.PrivateAddrV6()
+		var addr netip.Addr
+		switch {
+		case preferIPv6 && addrv6.IsValid():
+			addr = addrv6.Addr()
+		case addrv4.IsValid():
+			addr = addrv4.Addr()
+		case addrv6.IsValid():
+			addr = addrv6.Addr()
+		default:
+			continue
+		}
+		features := make([]string, 0, len(node.GetFeatures()))
+		for _, feat := range node.GetFeatures() {
+			features = append(features, strings.ToLower(feat.GetFeature().String()))
+		}
+		sort.Strings(features)
+		labels := map[string]string{
+			LabelNodeID:   node.GetId(),
+			LabelFeatures: "," + strings.Join(features, ",") + ",",
+		}
+		if node.GetZoneAwarenessID() != "" {
+			labels[LabelZone] = node.GetZoneAwarenessID()
+		}
+		if addrv4.IsValid() {
+			labels[LabelPrivateIPv4] = addrv4.Addr().String()
+		}
+		if addrv6.IsValid() {
+			labels[LabelPrivateIPv6] = addrv6.Addr().String()
+		}
+		if node.GetPrimaryEndpoint() != "" {
+			labels[LabelPrimaryEndpoint] = node.GetPrimaryEndpoint()
+		}
+		groups = append(groups, TargetGroup{
+			Targets: []string{netip.AddrPortFrom(addr, port).String()},
+			Labels:  labels,
+		})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Labels[LabelNodeID] < groups[j].Labels[LabelNodeID]
+	})
+	return groups
+}
+
+// serveServiceDiscovery serves the current node list in Prometheus http_sd format.
+func (s *Server) serveServiceDiscovery(w http.ResponseWriter, r *http.Request) {
+	groups, err := s.targetGroups(r.Context())
+	if err != nil {
+		s.log.Error("Failed to list nodes for service discovery", slog.String("error", err.Error()))
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(groups); err != nil {
+		s.log.Error("Failed to write service discovery response", slog.String("error", err.Error()))
+	}
+}
+
+// watchServiceDiscoveryFile keeps the file_sd file up to date with the node list.
+func (s *Server) watchServiceDiscoveryFile(ctx context.Context) (context.CancelFunc, error) {
+	write := func() {
+		if err := s.writeServiceDiscoveryFile(ctx); err != nil {
+			s.log.Error("Failed to write service discovery file", slog.String("file", s.ServiceDiscoveryFile), slog.String("error", err.Error()))
+		}
+	}
+	write()
+	return s.Peers.Subscribe(ctx, func([]types.MeshNode) { write() })
+}
+
+func (s *Server) writeServiceDiscoveryFile(ctx context.Context) error {
+	groups, err := s.targetGroups(ctx)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(groups, "", "  ")
+	if err != nil {
+		return err
+	}
+	// Write atomically so Prometheus never reads a partial file.
+	tmp := filepath.Join(filepath.Dir(s.ServiceDiscoveryFile), "."+filepath.Base(s.ServiceDiscoveryFile)+".tmp")
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.ServiceDiscoveryFile)
+}
+
+func (s *Server) targetGroups(ctx context.Context) ([]TargetGroup, error) {
+	nodes, err := s.Peers.List(ctx, storage.FilterByFeature(v1.Feature_METRICS))
+	if err != nil {
+		return nil, err
+	}
+	return NewTargetGroups(nodes, s.PreferIPv6), nil
+}
@@ -0,0 +1,96 @@
+//go:build !wasm
+
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestNewTargetGroups(t *testing.T) {
+	t.Parallel()
+
+	nodes := []types.MeshNode{
+		{MeshNode: &v1.MeshNode{
+			Id:              "node-b",
+			PrivateIPv4:     "172.16.0.2/32",
+			PrivateIPv6:     "fd00::2/128",
+			ZoneAwarenessID: "zone-a",
+			PrimaryEndpoint: "10.0.0.2",
+			Features: []*v1.FeaturePort{
+				{Feature: v1.Feature_NODES, Port: 8443},
+				{Feature: v1.Feature_METRICS, Port: 8080},
+			},
+		}},
+		{MeshNode: &v1.MeshNode{
+			Id:          "node-a",
+			PrivateIPv6: "fd00::1/128",
+			Features: []*v1.FeaturePort{
+				{Feature: v1.Feature_METRICS, Port: 9090},
+			},
+		}},
+		{MeshNode: &v1.MeshNode{
+			Id:          "no-metrics",
+			PrivateIPv4: "172.16.0.3/32",
+			Features: []*v1.FeaturePort{
+				{Feature: v1.Feature_NODES, Port: 8443},
+			},
+		}},
+	}
+
+	t.Run("PreferIPv4", func(t *testing.T) {
+		groups := NewTargetGroups(nodes, false)
+		if len(groups) != 2 {
+			t.Fatalf("expected 2 target groups, got %d", len(groups))
+		}
+		if groups[0].Labels[LabelNodeID] != "node-a" || groups[0].Targets[0] != "[fd00::1]:9090" {
+			t.Errorf("unexpected first group: %+v", groups[0])
+		}
+		b := groups[1]
+		if b.Targets[0] != "172.16.0.2:8080" {
+			t.Errorf("expected target 172.16.0.2:8080, got %s", b.Targets[0])
+		}
+		expected := map[string]string{
+			LabelNodeID:          "node-b",
+			LabelZone:            "zone-a",
+			LabelFeatures:        ",metrics,nodes,",
+			LabelPrivateIPv4:     "172.16.0.2",
+			LabelPrivateIPv6:     "fd00::2",
+			LabelPrimaryEndpoint: "10.0.0.2",
+		}
+		for k, v := range expected {
+			if b.Labels[k] != v {
+				t.Errorf("expected label %s=%q, got %q", k, v, b.Labels[k])
+			}
+		}
+	})
+
+	t.Run("PreferIPv6", func(t *testing.T) {
+		groups := NewTargetGroups(nodes, true)
+		if len(groups) != 2 {
+			t.Fatalf("expected 2 target groups, got %d", len(groups))
+		}
+		if groups[1].Targets[0] != "[fd00::2]:8080" {
+			t.Errorf("expected target [fd00::2]:8080, got %s", groups[1].Targets[0])
+		}
+	})
+}
@@ -25,10 +25,13 @@ import (
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/providers/prometheus"
 	promapi "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/version"
 )
 
 // DefaultListenAddress is the default listen address for the node Metrics.
@@ -37,43 +40,90 @@ const DefaultListenAddress = "[::]:8080"
 // DefaultPath is the default path for the node Metrics.
 const DefaultPath = "/metrics"
 
+// BuildInfo exposes the build information of the running binary in its labels
+// so that fleet tooling can identify what is deployed. Its value is always 1.
+var BuildInfo = promauto.NewGaugeVec(promapi.GaugeOpts{
+	Namespace: "webmesh",
+	Name:      "build_info",
+	Help:      "Build information of the running binary, always 1.",
+}, []string{"version", "git_commit", "build_date", "go_version", "platform", "build_tags"})
+
+func init() {
+	info := version.GetBuildInfo()
+	BuildInfo.WithLabelValues(info.Version, info.GitCommit, info.BuildDate, info.GoVersion, info.Platform, info.Tags()).Set(1)
+}
+
 // Options contains the configuration for exposing node metrics.
 type Options struct {
 	// ListenAddress is the address to start the metrics server on.
 	ListenAddress string
 	// Path is the path to expose metrics on.
 	Path string
+	// Peers is the peer storage used for Prometheus service discovery.
+	// Service discovery is disabled when it is nil.
+	Peers storage.Peers
+	// ServiceDiscoveryPath is the path to serve the node list on in
+	// Prometheus http_sd format. It is disabled when empty.
+	ServiceDiscoveryPath string
+	// ServiceDiscoveryFile is a file to keep updated with the node list
+	// in Prometheus file_sd format. It is disabled when empty.
+	ServiceDiscoveryFile string
+	// PreferIPv6 uses the private IPv6 address of nodes for discovered
+	// targets when available.
+	PreferIPv6 bool
 }
 
 // Server is the metrics server.
 type Server struct {
 	Options
-	srv *http.Server
-	log *slog.Logger
+	srv     *http.Server
+	log     *slog.Logger
+	closing chan struct{}
 }
 
 // New returns a new metrics server.
 func New(ctx context.Context, o Options) *Server {
-	return &Server{
+	s := &Server{
 		Options: o,
 		log:     context.LoggerFrom(ctx),
+		closing: make(chan struct{}),
+	}
+	s.srv = &http.Server{
+		Addr:    o.ListenAddress,
+		Handler: http.HandlerFunc(s.serveHTTP),
+	}
+	return s
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == s.Path:
+		promhttp.Handler().ServeHTTP(w, r)
+	case s.Peers != nil && s.ServiceDiscoveryPath != "" && r.URL.Path == s.ServiceDiscoveryPath:
+		s.serveServiceDiscovery(w, r)
+	default:
+		http.NotFound(w, r)
 	}
 }
 
 // ListenAndServe starts the server and blocks until the server exits.
 func (s *Server) ListenAndServe() error {
 	s.log.Info("Starting Prometheus metrics server", slog.String("listen_address", s.ListenAddress), slog.String("path", s.Path))
-	srv := &http.Server{
-		Addr: s.ListenAddress,
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if r.URL.Path == s.Path {
-				promhttp.Handler().ServeHTTP(w, r)
-			} else {
-				http.NotFound(w, r)
+	if s.Peers != nil && s.ServiceDiscoveryFile != "" {
+		ctx, cancel := context.WithCancel(context.WithLogger(context.Background(), s.log))
+		stop, err := s.watchServiceDiscoveryFile(ctx)
+		if err != nil {
+			s.log.Error("Failed to watch nodes for service discovery", slog.String("error", err.Error()))
+		}
+		go func() {
+			<-s.closing
+			if stop != nil {
+				stop()
 			}
-		}),
+			cancel()
+		}()
 	}
-	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+	if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		s.log.Error("metrics server failed", slog.String("error", err.Error()))
 	}
 	return nil
@@ -82,6 +132,11 @@ func (s *Server) ListenAndServe() error {
 // Shutdown attempts to stop the server gracefully.
 func (s *Server) Shutdown(ctx context.Context) error {
 	context.LoggerFrom(ctx).Info("Shutting down Prometheus metrics server")
+	select {
+	case <-s.closing:
+	default:
+		close(s.closing)
+	}
 	return s.srv.Shutdown(ctx)
 }
 
@@ -53,7 +53,7 @@ func (s *Server) NegotiateDataChannel(stream v1.Node_NegotiateDataChannelServer)
 		if err != nil {
 			return status.Errorf(codes.Internal, "failed to get WireGuard listen port: %v", err)
 		}
-		conn, err = datachannels.NewWireGuardProxyServer(stream.Context(), req.GetStunServers(), uint16(port))
+		conn, err = datachannels.NewWireGuardProxyServer(stream.Context(), req.GetStunServers(), s.TURNAuth, uint16(port))
 		if err != nil {
 			return err
 		}
@@ -64,6 +64,7 @@ func (s *Server) NegotiateDataChannel(stream v1.Node_NegotiateDataChannelServer)
 			SrcAddress:  req.GetSrc(),
 			DstAddress:  net.JoinHostPort(req.GetDst(), strconv.Itoa(int(req.GetPort()))),
 			STUNServers: req.GetStunServers(),
+			TURNAuth:    s.TURNAuth,
 		})
 		if err != nil {
 			return err
@@ -28,6 +28,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/meshnet"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/datachannels"
 	"github.com/webmeshproj/webmesh/pkg/plugins"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
@@ -52,6 +53,7 @@ type Options struct {
 	NodeDialer  transport.NodeDialer
 	Plugins     plugins.Manager
 	Features    []*v1.FeaturePort
+	TURNAuth    datachannels.TURNAuth
 }
 
 // NewServer returns a new Server. Features are used for returning what features are enabled.
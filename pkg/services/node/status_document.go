@@ -0,0 +1,303 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package node
+
+import (
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+	"github.com/webmeshproj/webmesh/pkg/version"
+)
+
+const (
+	// StatusServiceName is the full name of the node status service.
+	StatusServiceName = "webmesh.node.v1.NodeStatus"
+	// GetStatusDocumentFullMethodName is the full method name of GetStatus on
+	// the node status service.
+	GetStatusDocumentFullMethodName = "/" + StatusServiceName + "/GetStatus"
+	// GetVersionFullMethodName is the full method name of GetVersion on the
+	// node status service.
+	GetVersionFullMethodName = "/" + StatusServiceName + "/GetVersion"
+)
+
+// pluginHealthTimeout bounds how long plugins are queried for their health.
+const pluginHealthTimeout = 3 * time.Second
+
+// NodeStatusServer is the server API for the node status service.
+type NodeStatusServer interface {
+	// GetStatusDocument returns a structured document describing the raft,
+	// storage, network, and plugin state of a node.
+	GetStatusDocument(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// GetVersion returns the build information of a node.
+	GetVersion(context.Context, *structpb.Struct) (*structpb.Struct, error)
+}
+
+// StatusServiceDesc is the grpc.ServiceDesc for the node status service. The
+// service uses only well-known protobuf types so that it can be served
+// without generated code.
+var StatusServiceDesc = grpc.ServiceDesc{
+	ServiceName: StatusServiceName,
+	HandlerType: (*NodeStatusServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStatus",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(structpb.Struct)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(NodeStatusServer).GetStatusDocument(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: GetStatusDocumentFullMethodName}
+				return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(NodeStatusServer).GetStatusDocument(ctx, req.(*structpb.Struct))
+				})
+			},
+		},
+		{
+			MethodName: "GetVersion",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(structpb.Struct)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(NodeStatusServer).GetVersion(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: GetVersionFullMethodName}
+				return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(NodeStatusServer).GetVersion(ctx, req.(*structpb.Struct))
+				})
+			},
+		},
+	},
+}
+
+// NodeStatusClient is the client API for the node status service.
+type NodeStatusClient interface {
+	// GetStatus returns a structured document describing the raft, storage,
+	// network, and plugin state of a node.
+	GetStatus(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+	// GetVersion returns the build information of a node.
+	GetVersion(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+}
+
+// NewNodeStatusClient returns a new node status client using the given connection.
+func NewNodeStatusClient(cc grpc.ClientConnInterface) NodeStatusClient {
+	return &nodeStatusClient{cc}
+}
+
+type nodeStatusClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *nodeStatusClient) GetStatus(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	out := new(structpb.Struct)
+	err := c.cc.Invoke(ctx, GetStatusDocumentFullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *nodeStatusClient) GetVersion(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	out := new(structpb.Struct)
+	err := c.cc.Invoke(ctx, GetVersionFullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// GetVersion returns the build information of the node with the "id" in the
+// request, or this node if none is given.
+func (s *Server) GetVersion(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if id := req.GetFields()["id"].GetStringValue(); id != "" && id != s.NodeID.String() {
+		conn, err := s.NodeDialer.DialNode(ctx, types.NodeID(id))
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return NewNodeStatusClient(conn).GetVersion(ctx, req)
+	}
+	info := EncodeBuildInfo(s.Version)
+	info["id"] = s.NodeID.String()
+	out, err := structpb.NewStruct(info)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return out, nil
+}
+
+// EncodeBuildInfo encodes build information for a structured response.
+func EncodeBuildInfo(info version.BuildInfo) map[string]any {
+	tags := make([]any, len(info.BuildTags))
+	for i, tag := range info.BuildTags {
+		tags[i] = tag
+	}
+	return map[string]any{
+		"version":   info.Version,
+		"gitCommit": info.GitCommit,
+		"buildDate": info.BuildDate,
+		"goVersion": info.GoVersion,
+		"platform":  info.Platform,
+		"buildTags": tags,
+		"modified":  info.Modified,
+	}
+}
+
+// GetStatusDocument returns a structured document describing the node with
+// the "id" in the request, or this node if none is given. It consolidates the
+// version and features of the node, the raft role, term, and indexes, storage
+// backend statistics, the state of the WireGuard interface, peer counts, and
+// the health of every plugin. Sections that cannot be read hold an "error"
+// instead of failing the request.
+func (s *Server) GetStatusDocument(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if id := req.GetFields()["id"].GetStringValue(); id != "" && id != s.NodeID.String() {
+		conn, err := s.NodeDialer.DialNode(ctx, types.NodeID(id))
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+		return NewNodeStatusClient(conn).GetStatus(ctx, req)
+	}
+	doc, err := structpb.NewStruct(s.statusDocument(ctx))
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return doc, nil
+}
+
+// statusDocument builds the status document of this node.
+func (s *Server) statusDocument(ctx context.Context) map[string]any {
+	features := make([]any, 0, len(s.Features))
+	for _, feature := range s.Features {
+		features = append(features, map[string]any{
+			"feature": feature.GetFeature().String(),
+			"port":    feature.GetPort(),
+		})
+	}
+	return map[string]any{
+		"id":          s.NodeID.String(),
+		"description": s.Description,
+		"version":     EncodeBuildInfo(s.Version),
+		"startedAt":   s.startedAt.UTC().Format(time.RFC3339),
+		"uptime":      time.Since(s.startedAt).Round(time.Second).String(),
+		"features":    features,
+		"storage":     s.storageStatus(ctx),
+		"network":     s.networkStatus(ctx),
+		"plugins":     s.pluginStatus(ctx),
+	}
+}
+
+// storageStatus returns the storage section of the status document.
+func (s *Server) storageStatus(ctx context.Context) map[string]any {
+	st := s.Storage.Status()
+	counts := map[string]any{}
+	var leader string
+	for _, peer := range st.GetPeers() {
+		key := "nodes"
+		switch peer.GetClusterStatus() {
+		case v1.ClusterStatus_CLUSTER_LEADER:
+			leader = peer.GetId()
+			key = "voters"
+		case v1.ClusterStatus_CLUSTER_VOTER:
+			key = "voters"
+		case v1.ClusterStatus_CLUSTER_OBSERVER:
+			key = "observers"
+		}
+		n, _ := counts[key].(int)
+		counts[key] = n + 1
+	}
+	out := map[string]any{
+		"clusterStatus": st.GetClusterStatus().String(),
+		"isWritable":    st.GetIsWritable(),
+		"message":       st.GetMessage(),
+		"leader":        leader,
+		"members":       counts,
+	}
+	if reporter, ok := s.Storage.(storage.StatsReporter); ok {
+		out["stats"] = reporter.Stats()
+	}
+	return out
+}
+
+// networkStatus returns the network section of the status document.
+func (s *Server) networkStatus(ctx context.Context) map[string]any {
+	out := map[string]any{}
+	ids, err := s.Storage.MeshDB().Peers().ListIDs(ctx)
+	if err != nil {
+		out["error"] = err.Error()
+	} else {
+		out["meshNodes"] = len(ids)
+	}
+	if s.Meshnet == nil || s.Meshnet.WireGuard() == nil {
+		return out
+	}
+	metrics, err := s.Meshnet.WireGuard().Metrics()
+	if err != nil {
+		out["error"] = err.Error()
+		return out
+	}
+	out["interface"] = map[string]any{
+		"deviceName":         metrics.GetDeviceName(),
+		"type":               metrics.GetType(),
+		"publicKey":          metrics.GetPublicKey(),
+		"addressV4":          metrics.GetAddressV4(),
+		"addressV6":          metrics.GetAddressV6(),
+		"listenPort":         metrics.GetListenPort(),
+		"totalReceiveBytes":  metrics.GetTotalReceiveBytes(),
+		"totalTransmitBytes": metrics.GetTotalTransmitBytes(),
+	}
+	out["wireguardPeers"] = metrics.GetNumPeers()
+	return out
+}
+
+// pluginStatus returns the plugin section of the status document.
+func (s *Server) pluginStatus(ctx context.Context) []any {
+	out := []any{}
+	if s.Plugins == nil {
+		return out
+	}
+	ctx, cancel := context.WithTimeout(ctx, pluginHealthTimeout)
+	defer cancel()
+	for _, health := range s.Plugins.Health(ctx) {
+		capabilities := make([]any, len(health.Capabilities))
+		for i, cap := range health.Capabilities {
+			capabilities[i] = cap
+		}
+		plugin := map[string]any{
+			"name":         health.Name,
+			"capabilities": capabilities,
+			"healthy":      health.Healthy,
+		}
+		if health.Error != "" {
+			plugin["error"] = health.Error
+		}
+		out = append(out, plugin)
+	}
+	return out
+}
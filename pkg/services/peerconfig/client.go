@@ -0,0 +1,99 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peerconfig
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// PeerConfigClient is the client API for the peer configuration service.
+type PeerConfigClient interface {
+	// WatchPeers streams a snapshot of the desired peers followed by every
+	// change to them.
+	WatchPeers(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (PeerConfig_WatchPeersClient, error)
+	// RenderWGQuick renders the desired WireGuard configuration of a node
+	// as a wg-quick configuration file.
+	RenderWGQuick(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*wrapperspb.StringValue, error)
+	// GetComputedPeers returns the WireGuard peers computed for a node
+	// with explanations of why each entry is present.
+	GetComputedPeers(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error)
+}
+
+// PeerConfig_WatchPeersClient is the client stream for WatchPeers.
+type PeerConfig_WatchPeersClient interface {
+	Recv() (*structpb.Struct, error)
+	grpc.ClientStream
+}
+
+// NewPeerConfigClient returns a new peer configuration client using the given connection.
+func NewPeerConfigClient(cc grpc.ClientConnInterface) PeerConfigClient {
+	return &peerConfigClient{cc}
+}
+
+type peerConfigClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func (c *peerConfigClient) WatchPeers(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (PeerConfig_WatchPeersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &ServiceDesc.Streams[0], WatchPeersFullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &watchPeersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func (c *peerConfigClient) RenderWGQuick(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*wrapperspb.StringValue, error) {
+	out := new(wrapperspb.StringValue)
+	err := c.cc.Invoke(ctx, RenderWGQuickFullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *peerConfigClient) GetComputedPeers(ctx context.Context, in *structpb.Struct, opts ...grpc.CallOption) (*structpb.Struct, error) {
+	out := new(structpb.Struct)
+	err := c.cc.Invoke(ctx, GetComputedPeersFullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+type watchPeersClient struct {
+	grpc.ClientStream
+}
+
+func (x *watchPeersClient) Recv() (*structpb.Struct, error) {
+	m := new(structpb.Struct)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
@@ -0,0 +1,446 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package peerconfig provides a gRPC service that streams the changes to the
+// WireGuard peers computed for a node. External controllers, such as hardware
+// appliances or WireGuard managers not written in Go, can consume the stream
+// and apply the desired peers themselves. Devices that cannot run anything
+// beyond wg-quick can instead fetch a rendered configuration file. Operators
+// can inspect the peers computed for any node, along with the reasons behind
+// each entry, to debug missing peers. The
+// service uses only well-known protobuf types so that it can be served
+// without generated code.
+package peerconfig
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+const (
+	// ServiceName is the full name of the peer configuration service.
+	ServiceName = "webmesh.peerconfig.v1.PeerConfig"
+	// WatchPeersFullMethodName is the full method name of WatchPeers.
+	WatchPeersFullMethodName = "/" + ServiceName + "/WatchPeers"
+	// RenderWGQuickFullMethodName is the full method name of RenderWGQuick.
+	RenderWGQuickFullMethodName = "/" + ServiceName + "/RenderWGQuick"
+	// GetComputedPeersFullMethodName is the full method name of GetComputedPeers.
+	GetComputedPeersFullMethodName = "/" + ServiceName + "/GetComputedPeers"
+)
+
+// PeerConfigServer is the server API for the peer configuration service.
+type PeerConfigServer interface {
+	// WatchPeers streams a snapshot of the desired peers followed by every
+	// change to them.
+	WatchPeers(*emptypb.Empty, PeerConfig_WatchPeersServer) error
+	// RenderWGQuick renders the desired WireGuard configuration of a node
+	// as a wg-quick configuration file.
+	RenderWGQuick(context.Context, *structpb.Struct) (*wrapperspb.StringValue, error)
+	// GetComputedPeers returns the WireGuard peers computed for a node
+	// with explanations of why each entry is present.
+	GetComputedPeers(context.Context, *structpb.Struct) (*structpb.Struct, error)
+}
+
+// PeerConfig_WatchPeersServer is the server stream for WatchPeers.
+type PeerConfig_WatchPeersServer interface {
+	Send(*structpb.Struct) error
+	grpc.ServerStream
+}
+
+type watchPeersServer struct {
+	grpc.ServerStream
+}
+
+func (x *watchPeersServer) Send(m *structpb.Struct) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the peer configuration service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*PeerConfigServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "RenderWGQuick",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(structpb.Struct)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(PeerConfigServer).RenderWGQuick(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{
+					Server:     srv,
+					FullMethod: RenderWGQuickFullMethodName,
+				}
+				handler := func(ctx context.Context, req any) (any, error) {
+					return srv.(PeerConfigServer).RenderWGQuick(ctx, req.(*structpb.Struct))
+				}
+				return interceptor(ctx, in, info, handler)
+			},
+		},
+		{
+			MethodName: "GetComputedPeers",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(structpb.Struct)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(PeerConfigServer).GetComputedPeers(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{
+					Server:     srv,
+					FullMethod: GetComputedPeersFullMethodName,
+				}
+				handler := func(ctx context.Context, req any) (any, error) {
+					return srv.(PeerConfigServer).GetComputedPeers(ctx, req.(*structpb.Struct))
+				}
+				return interceptor(ctx, in, info, handler)
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchPeers",
+			ServerStreams: true,
+			Handler: func(srv any, stream grpc.ServerStream) error {
+				in := new(emptypb.Empty)
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(PeerConfigServer).WatchPeers(in, &watchPeersServer{stream})
+			},
+		},
+	},
+}
+
+var getEdgesAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_EDGES,
+		Verb:     v1.RuleVerb_VERB_GET,
+	},
+}
+
+var getComputedPeersAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_EDGES,
+		Verb:     v1.RuleVerb_VERB_GET,
+	},
+	{
+		Resource: v1.RuleResource_RESOURCE_NETWORK_ACLS,
+		Verb:     v1.RuleVerb_VERB_GET,
+	},
+}
+
+// Server is the peer configuration service.
+type Server struct {
+	nodeID   types.NodeID
+	peers    meshnet.PeerManager
+	storage  storage.MeshDB
+	rbacEval rbac.Evaluator
+}
+
+// NewServer returns a new peer configuration server for the given node.
+func NewServer(nodeID types.NodeID, peers meshnet.PeerManager, st storage.MeshDB, rbac rbac.Evaluator) *Server {
+	return &Server{
+		nodeID:   nodeID,
+		peers:    peers,
+		storage:  st,
+		rbacEval: rbac,
+	}
+}
+
+// WatchPeers streams a snapshot of the desired peers followed by every
+// change to them.
+func (s *Server) WatchPeers(_ *emptypb.Empty, stream PeerConfig_WatchPeersServer) error {
+	ctx := stream.Context()
+	if ok, err := s.rbacEval.Evaluate(ctx, getEdgesAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate watch peers action", "error", err)
+		}
+		return status.Error(codes.PermissionDenied, "caller does not have permission to watch peers")
+	}
+	var mu sync.Mutex
+	var queue []meshnet.PeerChangeSet
+	notify := make(chan struct{}, 1)
+	cancel := s.peers.Subscribe(ctx, func(set meshnet.PeerChangeSet) {
+		mu.Lock()
+		queue = append(queue, set)
+		mu.Unlock()
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	})
+	defer cancel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-notify:
+		}
+		mu.Lock()
+		sets := queue
+		queue = nil
+		mu.Unlock()
+		for _, set := range sets {
+			out, err := EncodeChangeSet(set)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			if err := stream.Send(out); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// RenderWGQuick renders the desired WireGuard configuration of a node as a
+// wg-quick configuration file. The request may set "nodeId" to render the
+// configuration of another node, "persistentKeepalive" as a duration string,
+// and "mtu". The private key is always left as a placeholder.
+func (s *Server) RenderWGQuick(ctx context.Context, req *structpb.Struct) (*wrapperspb.StringValue, error) {
+	if ok, err := s.rbacEval.Evaluate(ctx, getEdgesAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate render wg-quick action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to render peer configurations")
+	}
+	fields := req.GetFields()
+	opts := meshnet.WGQuickOptions{
+		NodeID: s.nodeID,
+		MTU:    int(fields["mtu"].GetNumberValue()),
+	}
+	if id := fields["nodeId"].GetStringValue(); id != "" {
+		opts.NodeID = types.NodeID(id)
+	}
+	if keepalive := fields["persistentKeepalive"].GetStringValue(); keepalive != "" {
+		d, err := time.ParseDuration(keepalive)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid persistent keepalive: %v", err)
+		}
+		opts.PersistentKeepalive = d
+	}
+	out, err := meshnet.RenderWGQuick(ctx, s.storage, opts)
+	if err != nil {
+		if errors.IsNodeNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "node %s not found", opts.NodeID)
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return wrapperspb.String(string(out)), nil
+}
+
+// GetComputedPeers returns the WireGuard peers computed for the node set in
+// "nodeId", or the local node if unset, exactly as that node would receive
+// them. Each peer is returned with the edges and network ACLs that led to it
+// and the origin of each allowed IP. Nodes that are not reachable are returned
+// with the reason they were excluded.
+func (s *Server) GetComputedPeers(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if ok, err := s.rbacEval.Evaluate(ctx, getComputedPeersAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate get computed peers action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to get computed peers")
+	}
+	nodeID := s.nodeID
+	if id := req.GetFields()["nodeId"].GetStringValue(); id != "" {
+		nodeID = types.NodeID(id)
+	}
+	computed, err := meshnet.ExplainWireGuardPeers(ctx, s.storage, nodeID)
+	if err != nil {
+		if errors.IsNodeNotFound(err) {
+			return nil, status.Errorf(codes.NotFound, "node %s not found", nodeID)
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out, err := EncodeComputedPeers(computed)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return out, nil
+}
+
+// EncodeChangeSet encodes a change set into a WatchPeers response. Peers
+// are encoded with their protobuf JSON representation.
+func EncodeChangeSet(set meshnet.PeerChangeSet) (*structpb.Struct, error) {
+	changes := make([]any, len(set.Changes))
+	for i, change := range set.Changes {
+		fields := map[string]any{
+			"type": change.Type.String(),
+			"id":   change.ID,
+		}
+		if change.Peer != nil {
+			peer, err := encodePeer(change.Peer)
+			if err != nil {
+				return nil, fmt.Errorf("encode peer %s: %w", change.ID, err)
+			}
+			fields["peer"] = peer
+		}
+		changes[i] = fields
+	}
+	return structpb.NewStruct(map[string]any{
+		"revision": float64(set.Revision),
+		"changes":  changes,
+	})
+}
+
+// DecodeChangeSet decodes a change set from a WatchPeers response.
+func DecodeChangeSet(resp *structpb.Struct) (meshnet.PeerChangeSet, error) {
+	fields := resp.GetFields()
+	set := meshnet.PeerChangeSet{
+		Revision: uint64(fields["revision"].GetNumberValue()),
+	}
+	for _, v := range fields["changes"].GetListValue().GetValues() {
+		change := v.GetStructValue().GetFields()
+		out := meshnet.PeerChange{ID: change["id"].GetStringValue()}
+		switch typ := change["type"].GetStringValue(); typ {
+		case meshnet.PeerAdded.String():
+			out.Type = meshnet.PeerAdded
+		case meshnet.PeerUpdated.String():
+			out.Type = meshnet.PeerUpdated
+		case meshnet.PeerRemoved.String():
+			out.Type = meshnet.PeerRemoved
+		default:
+			return set, fmt.Errorf("unknown change type %q for peer %s", typ, out.ID)
+		}
+		if peer := change["peer"].GetStructValue(); peer != nil {
+			decoded, err := decodePeer(peer)
+			if err != nil {
+				return set, fmt.Errorf("peer %s: %w", out.ID, err)
+			}
+			out.Peer = decoded
+		}
+		set.Changes = append(set.Changes, out)
+	}
+	return set, nil
+}
+
+// EncodeComputedPeers encodes computed peers into a GetComputedPeers response.
+// Peers are encoded with their protobuf JSON representation.
+func EncodeComputedPeers(computed *meshnet.ComputedPeers) (*structpb.Struct, error) {
+	peers := make([]any, len(computed.Peers))
+	for i, peer := range computed.Peers {
+		encoded, err := encodePeer(peer.Peer)
+		if err != nil {
+			return nil, fmt.Errorf("encode peer %s: %w", peer.Peer.GetNode().GetId(), err)
+		}
+		reasons := make([]any, len(peer.Reasons))
+		for j, reason := range peer.Reasons {
+			reasons[j] = reason
+		}
+		allowedIPs := make(map[string]any, len(peer.AllowedIPs))
+		for ip, reason := range peer.AllowedIPs {
+			allowedIPs[ip] = reason
+		}
+		peers[i] = map[string]any{
+			"peer":       encoded,
+			"reasons":    reasons,
+			"allowedIPs": allowedIPs,
+		}
+	}
+	excluded := make([]any, len(computed.Excluded))
+	for i, node := range computed.Excluded {
+		excluded[i] = map[string]any{
+			"id":     node.ID.String(),
+			"reason": node.Reason,
+		}
+	}
+	return structpb.NewStruct(map[string]any{
+		"nodeId":   computed.NodeID.String(),
+		"peers":    peers,
+		"excluded": excluded,
+	})
+}
+
+// DecodeComputedPeers decodes computed peers from a GetComputedPeers response.
+func DecodeComputedPeers(resp *structpb.Struct) (*meshnet.ComputedPeers, error) {
+	fields := resp.GetFields()
+	out := &meshnet.ComputedPeers{
+		NodeID: types.NodeID(fields["nodeId"].GetStringValue()),
+	}
+	for _, v := range fields["peers"].GetListValue().GetValues() {
+		peer := v.GetStructValue().GetFields()
+		decoded, err := decodePeer(peer["peer"].GetStructValue())
+		if err != nil {
+			return nil, fmt.Errorf("peer %d: %w", len(out.Peers), err)
+		}
+		computed := meshnet.ComputedPeer{
+			Peer:       decoded,
+			AllowedIPs: make(map[string]string),
+		}
+		for _, reason := range peer["reasons"].GetListValue().GetValues() {
+			computed.Reasons = append(computed.Reasons, reason.GetStringValue())
+		}
+		for ip, reason := range peer["allowedIPs"].GetStructValue().GetFields() {
+			computed.AllowedIPs[ip] = reason.GetStringValue()
+		}
+		out.Peers = append(out.Peers, computed)
+	}
+	for _, v := range fields["excluded"].GetListValue().GetValues() {
+		node := v.GetStructValue().GetFields()
+		out.Excluded = append(out.Excluded, meshnet.ExcludedNode{
+			ID:     types.NodeID(node["id"].GetStringValue()),
+			Reason: node["reason"].GetStringValue(),
+		})
+	}
+	return out, nil
+}
+
+// encodePeer encodes a peer with its protobuf JSON representation.
+func encodePeer(peer *v1.WireGuardPeer) (map[string]any, error) {
+	data, err := protojson.Marshal(peer)
+	if err != nil {
+		return nil, fmt.Errorf("marshal peer: %w", err)
+	}
+	var out structpb.Struct
+	if err := protojson.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("encode peer: %w", err)
+	}
+	return out.AsMap(), nil
+}
+
+// decodePeer decodes a peer from its protobuf JSON representation.
+func decodePeer(in *structpb.Struct) (*v1.WireGuardPeer, error) {
+	data, err := protojson.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("decode peer: %w", err)
+	}
+	peer := &v1.WireGuardPeer{}
+	if err := protojson.Unmarshal(data, peer); err != nil {
+		return nil, fmt.Errorf("unmarshal peer: %w", err)
+	}
+	return peer, nil
+}
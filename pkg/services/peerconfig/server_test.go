@@ -0,0 +1,113 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package peerconfig
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+)
+
+func TestDecodeChangeSet(t *testing.T) {
+	t.Parallel()
+	want := meshnet.PeerChangeSet{
+		Revision: 7,
+		Changes: []meshnet.PeerChange{
+			{
+				Type: meshnet.PeerAdded,
+				ID:   "node-a",
+				Peer: &v1.WireGuardPeer{
+					Node: &v1.MeshNode{
+						Id:          "node-a",
+						PublicKey:   "public-key",
+						PrivateIPv4: "172.16.0.2/32",
+					},
+					AllowedIPs: []string{"172.16.0.2/32", "10.0.0.0/8"},
+				},
+			},
+			{Type: meshnet.PeerRemoved, ID: "node-b"},
+		},
+	}
+	resp, err := EncodeChangeSet(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeChangeSet(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Revision != want.Revision || len(got.Changes) != len(want.Changes) {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	for i, change := range got.Changes {
+		if change.Type != want.Changes[i].Type || change.ID != want.Changes[i].ID {
+			t.Errorf("expected change %+v, got %+v", want.Changes[i], change)
+		}
+		if !proto.Equal(change.Peer, want.Changes[i].Peer) {
+			t.Errorf("expected peer %v, got %v", want.Changes[i].Peer, change.Peer)
+		}
+	}
+	resp.Fields["changes"].GetListValue().GetValues()[1].GetStructValue().Fields["type"].Kind = nil
+	if _, err := DecodeChangeSet(resp); err == nil {
+		t.Fatal("expected error for unknown change type")
+	}
+}
+
+func TestDecodeComputedPeers(t *testing.T) {
+	t.Parallel()
+	want := &meshnet.ComputedPeers{
+		NodeID: "node-a",
+		Peers: []meshnet.ComputedPeer{
+			{
+				Peer: &v1.WireGuardPeer{
+					Node:       &v1.MeshNode{Id: "node-b", PrivateIPv4: "172.16.0.2/32"},
+					AllowedIPs: []string{"172.16.0.2/32"},
+				},
+				Reasons:    []string{"direct edge between node-a and node-b"},
+				AllowedIPs: map[string]string{"172.16.0.2/32": "private address of the peer"},
+			},
+		},
+		Excluded: []meshnet.ExcludedNode{{ID: "node-c", Reason: "isolated by namespace policy"}},
+	}
+	resp, err := EncodeComputedPeers(want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := DecodeComputedPeers(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.NodeID != want.NodeID || len(got.Peers) != 1 || len(got.Excluded) != 1 {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	peer := got.Peers[0]
+	if !proto.Equal(peer.Peer, want.Peers[0].Peer) {
+		t.Errorf("expected peer %v, got %v", want.Peers[0].Peer, peer.Peer)
+	}
+	if len(peer.Reasons) != 1 || peer.Reasons[0] != want.Peers[0].Reasons[0] {
+		t.Errorf("expected reasons %v, got %v", want.Peers[0].Reasons, peer.Reasons)
+	}
+	if peer.AllowedIPs["172.16.0.2/32"] != "private address of the peer" {
+		t.Errorf("expected allowed IP reasons %v, got %v", want.Peers[0].AllowedIPs, peer.AllowedIPs)
+	}
+	if got.Excluded[0] != want.Excluded[0] {
+		t.Errorf("expected excluded node %+v, got %+v", want.Excluded[0], got.Excluded[0])
+	}
+}
@@ -0,0 +1,269 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package policy provides a gRPC service for explaining and simulating
+// network policy decisions, so that blocked traffic can be diagnosed without
+// reading the raw network ACLs. The service uses only well-known protobuf types so that
+// it can be served without generated code.
+package policy
+
+import (
+	"fmt"
+	"net/netip"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+const (
+	// ServiceName is the full name of the policy service.
+	ServiceName = "webmesh.policy.v1.Policy"
+	// ExplainDenyFullMethodName is the full method name of ExplainDeny.
+	ExplainDenyFullMethodName = "/" + ServiceName + "/ExplainDeny"
+	// EvaluateACLFullMethodName is the full method name of EvaluateACL.
+	EvaluateACLFullMethodName = "/" + ServiceName + "/EvaluateACL"
+)
+
+// PolicyServer is the server API for the policy service.
+type PolicyServer interface {
+	// ExplainDeny explains how the network ACLs decide traffic between a
+	// source and destination.
+	ExplainDeny(context.Context, *structpb.Struct) (*structpb.Struct, error)
+	// EvaluateACL evaluates hypothetical traffic between a source and
+	// destination against the network policy.
+	EvaluateACL(context.Context, *structpb.Struct) (*structpb.Struct, error)
+}
+
+// ServiceDesc is the grpc.ServiceDesc for the policy service.
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*PolicyServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "ExplainDeny",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(structpb.Struct)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(PolicyServer).ExplainDeny(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: ExplainDenyFullMethodName}
+				return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(PolicyServer).ExplainDeny(ctx, req.(*structpb.Struct))
+				})
+			},
+		},
+		{
+			MethodName: "EvaluateACL",
+			Handler: func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+				in := new(structpb.Struct)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(PolicyServer).EvaluateACL(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: EvaluateACLFullMethodName}
+				return interceptor(ctx, in, info, func(ctx context.Context, req any) (any, error) {
+					return srv.(PolicyServer).EvaluateACL(ctx, req.(*structpb.Struct))
+				})
+			},
+		},
+	},
+}
+
+var explainAction = rbac.Actions{
+	{
+		Resource: v1.RuleResource_RESOURCE_NETWORK_ACLS,
+		Verb:     v1.RuleVerb_VERB_GET,
+	},
+}
+
+// Server is the policy service.
+type Server struct {
+	storage  storage.MeshDB
+	rbacEval rbac.Evaluator
+}
+
+// NewServer returns a new policy server.
+func NewServer(st storage.MeshDB, rbac rbac.Evaluator) *Server {
+	return &Server{
+		storage:  st,
+		rbacEval: rbac,
+	}
+}
+
+// ExplainDeny explains how the network ACLs decide traffic from "srcNode" or
+// "srcCIDR" to "dstNode" or "dstCIDR". The response holds the ACLs considered
+// in order, why each did or did not match, and the rule or default that
+// produced the decision.
+func (s *Server) ExplainDeny(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if ok, err := s.rbacEval.Evaluate(ctx, explainAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate explain deny action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to explain network policy")
+	}
+	action, err := DecodeRequest(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	explanation, err := meshnet.ExplainNetworkAction(ctx, s.storage, action)
+	if err != nil {
+		if errors.IsNodeNotFound(err) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out, err := EncodeExplanation(explanation)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return out, nil
+}
+
+// EvaluateACL evaluates hypothetical traffic from "srcNode" or "srcCIDR" to
+// "dstNode" or "dstCIDR" against the network policy in effect at "at", or now
+// if unset. The nodes do not need to be registered. The response holds every
+// ACL matching the traffic, the verdict, and whether registered nodes are
+// peered.
+func (s *Server) EvaluateACL(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	if ok, err := s.rbacEval.Evaluate(ctx, explainAction); !ok {
+		if err != nil {
+			context.LoggerFrom(ctx).Error("failed to evaluate acl evaluation action", "error", err)
+		}
+		return nil, status.Error(codes.PermissionDenied, "caller does not have permission to evaluate network policy")
+	}
+	action, err := DecodeRequest(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	at := time.Now()
+	if value := req.GetFields()["at"].GetStringValue(); value != "" {
+		at, err = time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, fmt.Sprintf("invalid time %q: %v", value, err))
+		}
+	}
+	eval, err := meshnet.EvaluateNetworkAction(ctx, s.storage, action, at)
+	if err != nil {
+		if errors.IsNodeNotFound(err) {
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	out, err := EncodeEvaluation(eval)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return out, nil
+}
+
+// DecodeRequest decodes the action to evaluate from an ExplainDeny or
+// EvaluateACL request.
+func DecodeRequest(req *structpb.Struct) (types.NetworkAction, error) {
+	fields := req.GetFields()
+	action := types.NetworkAction{NetworkAction: &v1.NetworkAction{
+		SrcNode: fields["srcNode"].GetStringValue(),
+		SrcCIDR: fields["srcCIDR"].GetStringValue(),
+		DstNode: fields["dstNode"].GetStringValue(),
+		DstCIDR: fields["dstCIDR"].GetStringValue(),
+	}}
+	if action.GetSrcNode() == "" && action.GetSrcCIDR() == "" {
+		return action, fmt.Errorf("a source node or CIDR is required")
+	}
+	if action.GetDstNode() == "" && action.GetDstCIDR() == "" {
+		return action, fmt.Errorf("a destination node or CIDR is required")
+	}
+	// Single addresses are accepted in place of CIDRs.
+	for _, cidr := range []*string{&action.SrcCIDR, &action.DstCIDR} {
+		if *cidr == "" || *cidr == "*" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(*cidr); err == nil {
+			*cidr = netip.PrefixFrom(addr, addr.BitLen()).String()
+			continue
+		}
+		if _, err := netip.ParsePrefix(*cidr); err != nil {
+			return action, fmt.Errorf("invalid CIDR %q: %w", *cidr, err)
+		}
+	}
+	return action, nil
+}
+
+// EncodeExplanation encodes an explanation into an ExplainDeny response.
+func EncodeExplanation(explanation types.ACLExplanation) (*structpb.Struct, error) {
+	evaluated := make([]any, len(explanation.Evaluated))
+	for i, eval := range explanation.Evaluated {
+		evaluated[i] = map[string]any{
+			"name":     eval.ACL.GetName(),
+			"priority": float64(eval.ACL.GetPriority()),
+			"action":   eval.ACL.GetAction().String(),
+			"matched":  eval.Matched,
+			"reason":   eval.Reason,
+		}
+	}
+	return structpb.NewStruct(map[string]any{
+		"accepted":  explanation.Accepted,
+		"reason":    explanation.Reason,
+		"evaluated": evaluated,
+	})
+}
+
+// EncodeEvaluation encodes an evaluation into an EvaluateACL response.
+func EncodeEvaluation(eval meshnet.ActionEvaluation) (*structpb.Struct, error) {
+	matched := make([]any, len(eval.Matched))
+	for i, acl := range eval.Matched {
+		matched[i] = map[string]any{
+			"name":     acl.GetName(),
+			"priority": float64(acl.GetPriority()),
+			"action":   acl.GetAction().String(),
+		}
+	}
+	inactive := make([]any, len(eval.Inactive))
+	for i, name := range eval.Inactive {
+		inactive[i] = name
+	}
+	verdict := v1.ACLAction_ACTION_DENY
+	if eval.Accepted {
+		verdict = v1.ACLAction_ACTION_ACCEPT
+	}
+	out := map[string]any{
+		"srcCIDR":  eval.Action.GetSrcCIDR(),
+		"dstCIDR":  eval.Action.GetDstCIDR(),
+		"verdict":  verdict.String(),
+		"reason":   eval.Reason,
+		"matched":  matched,
+		"inactive": inactive,
+	}
+	if eval.Peered != nil {
+		out["peered"] = *eval.Peered
+	}
+	return structpb.NewStruct(out)
+}
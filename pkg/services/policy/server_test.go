@@ -0,0 +1,84 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package policy
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestDecodeRequest(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name    string
+		fields  map[string]any
+		srcCIDR string
+		dstCIDR string
+		wantErr bool
+	}{
+		{
+			name:   "nodes",
+			fields: map[string]any{"srcNode": "a", "dstNode": "b"},
+		},
+		{
+			name:    "addresses",
+			fields:  map[string]any{"srcCIDR": "172.16.0.1", "dstCIDR": "2001:db8::1"},
+			srcCIDR: "172.16.0.1/32",
+			dstCIDR: "2001:db8::1/128",
+		},
+		{
+			name:    "prefixes",
+			fields:  map[string]any{"srcNode": "a", "dstCIDR": "10.0.0.0/24"},
+			dstCIDR: "10.0.0.0/24",
+		},
+		{
+			name:    "no source",
+			fields:  map[string]any{"dstNode": "b"},
+			wantErr: true,
+		},
+		{
+			name:    "no destination",
+			fields:  map[string]any{"srcNode": "a"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid cidr",
+			fields:  map[string]any{"srcNode": "a", "dstCIDR": "not-a-cidr"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tc {
+		req, err := structpb.NewStruct(tt.fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		action, err := DecodeRequest(req)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if action.GetSrcCIDR() != tt.srcCIDR || action.GetDstCIDR() != tt.dstCIDR {
+			t.Errorf("%s: expected %q -> %q, got %q -> %q", tt.name, tt.srcCIDR, tt.dstCIDR, action.GetSrcCIDR(), action.GetDstCIDR())
+		}
+	}
+}
@@ -69,11 +69,12 @@ func (a *Action) action() *v1.RBACAction {
 // NewStoreEvaluator returns a ActionEvaluator that evaluates actions
 // against the roles in the given store.
 func NewStoreEvaluator(store storage.MeshDB) Evaluator {
-	return &storeEvaluator{rbac: store.RBAC()}
+	return &storeEvaluator{rbac: store.RBAC(), storage: storage.MeshStorageOf(store)}
 }
 
 type storeEvaluator struct {
-	rbac storage.RBAC
+	rbac    storage.RBAC
+	storage storage.MeshStorage
 }
 
 func (s *storeEvaluator) IsSecure() bool {
@@ -94,6 +95,15 @@ func (s *storeEvaluator) Evaluate(ctx context.Context, actions Actions) (bool, e
 	if peerName == "" {
 		return false, fmt.Errorf("no peer information in context")
 	}
+	if s.storage != nil {
+		scopes, err := storage.ListNamespaceScopes(ctx, s.storage)
+		if err != nil {
+			return false, fmt.Errorf("list namespace scopes: %w", err)
+		}
+		if len(scopes) > 0 {
+			return s.evaluateScoped(ctx, types.NodeID(peerName), scopes, actions)
+		}
+	}
 	// We treat nodes and users as the same entity for the purpose of authorization.
 	nodeRoles, err := s.rbac.ListNodeRoles(ctx, types.NodeID(peerName))
 	if err != nil {
@@ -111,6 +121,53 @@ func (s *storeEvaluator) Evaluate(ctx context.Context, actions Actions) (bool, e
 	return true, nil
 }
 
+// evaluateScoped evaluates the actions when some role bindings are confined to
+// namespaces. Roles granted through a scoped binding only allow actions on
+// resources in its namespace.
+func (s *storeEvaluator) evaluateScoped(ctx context.Context, peer types.NodeID, scopes map[string]string, actions Actions) (bool, error) {
+	rbs, err := s.rbac.ListRoleBindings(ctx)
+	if err != nil {
+		return false, fmt.Errorf("list rolebindings: %w", err)
+	}
+	var global types.RolesList
+	scoped := make(map[string]types.RolesList)
+	for _, rb := range rbs {
+		// We treat nodes and users as the same entity for the purpose of authorization.
+		if !rb.ContainsNodeID(peer) && !rb.ContainsUserID(peer) {
+			continue
+		}
+		role, err := s.rbac.GetRole(ctx, rb.GetRole())
+		if err != nil {
+			return false, fmt.Errorf("get role: %w", err)
+		}
+		if ns, ok := scopes[rb.GetName()]; ok {
+			scoped[ns] = append(scoped[ns], role)
+			continue
+		}
+		global = append(global, role)
+	}
+Actions:
+	for _, action := range actions {
+		if global.Eval(action.action()) {
+			continue
+		}
+		for ns, roles := range scoped {
+			if !roles.Eval(action.action()) {
+				continue
+			}
+			ok, err := storage.InNamespace(ctx, s.storage, ns, action.ResourceName)
+			if err != nil {
+				return false, fmt.Errorf("check namespace: %w", err)
+			}
+			if ok {
+				continue Actions
+			}
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
 // NewNoopEvaluator returns an evaluator that always returns true.
 func NewNoopEvaluator() Evaluator {
 	return &noopEvaluator{}
@@ -0,0 +1,81 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// RevokedKeyUnaryServerInterceptor returns a unary interceptor that rejects
+// authenticated callers whose registered key has been revoked. It must be
+// installed after the authentication plugins.
+func RevokedKeyUnaryServerInterceptor(db storage.MeshDB, st storage.MeshStorage) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := checkCallerRevoked(ctx, db, st); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// RevokedKeyStreamServerInterceptor returns a stream interceptor that rejects
+// authenticated callers whose registered key has been revoked. It must be
+// installed after the authentication plugins.
+func RevokedKeyStreamServerInterceptor(db storage.MeshDB, st storage.MeshStorage) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := checkCallerRevoked(ss.Context(), db, st); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func checkCallerRevoked(ctx context.Context, db storage.MeshDB, st storage.MeshStorage) error {
+	caller, ok := context.AuthenticatedCallerFrom(ctx)
+	if !ok {
+		return nil
+	}
+	peer, err := db.Peers().Get(ctx, types.NodeID(caller))
+	if err != nil {
+		if errors.IsNodeNotFound(err) {
+			// Nodes that have not joined yet have no registered key.
+			return nil
+		}
+		return status.Errorf(codes.Unavailable, "failed to lookup caller: %v", err)
+	}
+	key, err := crypto.DecodePublicKey(peer.GetPublicKey())
+	if err != nil {
+		return nil
+	}
+	revoked, err := storage.IsKeyRevoked(ctx, st, key)
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to check key revocation: %v", err)
+	}
+	if revoked {
+		context.LoggerFrom(ctx).Warn("Rejecting caller with a revoked key", "caller", caller)
+		return status.Errorf(codes.Unauthenticated, "key for %s has been revoked", caller)
+	}
+	return nil
+}
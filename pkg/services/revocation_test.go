@@ -0,0 +1,72 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package services
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestRevokedKeyInterceptor(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+	key := crypto.MustGenerateKey().PublicKey()
+	encoded, err := key.Encode()
+	if err != nil {
+		t.Fatalf("encode key: %v", err)
+	}
+	err = db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: "node-a", PublicKey: encoded}})
+	if err != nil {
+		t.Fatalf("put node: %v", err)
+	}
+	interceptor := RevokedKeyUnaryServerInterceptor(db, st)
+	call := func(ctx context.Context) error {
+		_, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, func(context.Context, any) (any, error) { return nil, nil })
+		return err
+	}
+
+	if err := call(ctx); err != nil {
+		t.Fatalf("expected unauthenticated callers to pass, got: %v", err)
+	}
+	if err := call(context.WithAuthenticatedCaller(ctx, "node-b")); err != nil {
+		t.Fatalf("expected unregistered callers to pass, got: %v", err)
+	}
+	callerCtx := context.WithAuthenticatedCaller(ctx, "node-a")
+	if err := call(callerCtx); err != nil {
+		t.Fatalf("expected caller to pass before revocation, got: %v", err)
+	}
+	if err := storage.RevokeKey(ctx, st, key); err != nil {
+		t.Fatalf("revoke key: %v", err)
+	}
+	if err := call(callerCtx); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected revoked caller to be rejected, got: %v", err)
+	}
+}
@@ -18,15 +18,18 @@ limitations under the License.
 package services
 
 import (
+	"crypto/tls"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/quic-go/quic-go/http3"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
 	"golang.org/x/sync/errgroup"
@@ -34,6 +37,7 @@ import (
 	"google.golang.org/grpc/reflection"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/netutil"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/libp2p"
 )
 
@@ -89,6 +93,24 @@ type Options struct {
 	AllowedOrigins []string
 	// ListenAddress is the address to start the gRPC server on.
 	ListenAddress string
+	// GRPCWebHTTP3Enabled enables the experimental HTTP/3 listener for
+	// gRPC-Web. It serves gRPC-Web over QUIC on the UDP port matching the TCP
+	// listener, and is advertised to clients with an Alt-Svc header on the TCP
+	// listener. Native gRPC is not served over HTTP/3 and is answered with
+	// 505 HTTP Version Not Supported, so native gRPC clients keep using TCP.
+	// It requires WebEnabled and TLSConfig.
+	GRPCWebHTTP3Enabled bool
+	// TLSConfig is the TLS configuration for the gRPC-Web HTTP/3 listener.
+	TLSConfig *tls.Config
+	// ListenerMiddlewares are applied to the TCP listener in order, for
+	// example to accept the PROXY protocol from a load balancer. Client
+	// addresses seen by authentication and rate limiting are the ones they
+	// produce.
+	ListenerMiddlewares []netutil.ListenerMiddleware
+	// PacketConnMiddlewares are applied to the packet connection of the
+	// gRPC-Web HTTP/3 listener in order, for example to drop the packets of
+	// clients the TCP listener refuses.
+	PacketConnMiddlewares []netutil.PacketConnMiddleware
 	// ServerOptions are options for the server. This should include
 	// any registered authentication mechanisms.
 	ServerOptions []grpc.ServerOption
@@ -106,6 +128,8 @@ type LibP2POptions struct {
 	Announce bool
 	// Rendezvous is the rendezvous string to use for libp2p.
 	Rendezvous string
+	// Advertise are options for keeping the host announced on the DHT.
+	Advertise libp2p.AdvertiseOptions
 }
 
 // GetServer returns the server of the given type.
@@ -117,9 +141,11 @@ func (o *Options) GetServer(typ any) (MeshServer, bool) {
 type Server struct {
 	opts    Options
 	hostlis net.Listener
-	lis     *net.TCPListener
+	lis     net.Listener
+	quiclis net.PacketConn
 	srv     *grpc.Server
 	websrv  *http.Server
+	h3srv   *http3.Server
 	srvs    []MeshServer
 	log     *slog.Logger
 	mu      sync.Mutex
@@ -145,7 +171,30 @@ func NewServer(ctx context.Context, o Options) (*Server, error) {
 			if err != nil {
 				return nil, fmt.Errorf("start TCP listener: %w", err)
 			}
-			server.lis = lis.(*net.TCPListener)
+			server.lis = netutil.WrapListener(lis, o.ListenerMiddlewares...)
+		}
+		if o.GRPCWebHTTP3Enabled && server.lis != nil {
+			if !o.WebEnabled || o.TLSConfig == nil {
+				server.lis.Close()
+				return nil, fmt.Errorf("the gRPC-Web HTTP/3 listener requires grpc-web and a TLS configuration")
+			}
+			host, _, err := net.SplitHostPort(o.ListenAddress)
+			if err != nil {
+				server.lis.Close()
+				return nil, fmt.Errorf("parse listen address: %w", err)
+			}
+			addr := net.JoinHostPort(host, strconv.Itoa(server.GRPCListenPort()))
+			log.Debug("Starting QUIC listener", "address", addr)
+			conn, err := net.ListenPacket("udp", addr)
+			if err != nil {
+				server.lis.Close()
+				return nil, fmt.Errorf("start QUIC listener: %w", err)
+			}
+			server.quiclis = netutil.WrapPacketConn(conn, o.PacketConnMiddlewares...)
+			server.h3srv = &http3.Server{
+				TLSConfig: http3.ConfigureTLSConfig(o.TLSConfig.Clone()),
+				Handler:   server.webHandler(),
+			}
 		}
 		if o.LibP2POptions != nil {
 			log.Debug("Starting libp2p host listener")
@@ -160,7 +209,7 @@ func NewServer(ctx context.Context, o Options) (*Server, error) {
 				if err != nil {
 					return nil, fmt.Errorf("wrap host with discovery: %w", err)
 				}
-				discovery.Announce(ctx, o.LibP2POptions.Rendezvous, 0)
+				discovery.Announce(ctx, o.LibP2POptions.Rendezvous, o.LibP2POptions.Advertise)
 			}
 			server.hostlis = host.RPCListener()
 		}
@@ -188,30 +237,8 @@ func (s *Server) ListenAndServe() error {
 			defer s.lis.Close()
 			if s.opts.WebEnabled {
 				s.log.Info(fmt.Sprintf("Starting gRPC-web server on %s", s.lis.Addr().String()))
-				wrapped := grpcweb.WrapServer(s.srv, grpcweb.WithWebsockets(true))
-				handler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
-					if s.opts.EnableCORS {
-						s.log.Debug("Handling CORS options for request", "origin", req.Header.Get("Origin"))
-						resp.Header().Set("Access-Control-Allow-Origin", strings.Join(s.opts.AllowedOrigins, ", "))
-						resp.Header().Set("Access-Control-Allow-Credentials", "true")
-						resp.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Grpc-Web, X-User-Agent")
-						resp.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-						if req.Method == http.MethodOptions {
-							resp.WriteHeader(http.StatusOK)
-							return
-						}
-					}
-					if wrapped.IsGrpcWebRequest(req) {
-						s.log.Debug("Handling gRPC-Web request")
-						wrapped.ServeHTTP(resp, req)
-						return
-					}
-					// Fall down to the gRPC server
-					s.log.Debug("Handling gRPC request")
-					s.srv.ServeHTTP(resp, req)
-				})
 				s.websrv = &http.Server{
-					Handler: h2c.NewHandler(handler, &http2.Server{}),
+					Handler: h2c.NewHandler(s.webHandler(), &http2.Server{}),
 				}
 				if err := s.websrv.Serve(s.lis); err != nil && err != http.ErrServerClosed {
 					return fmt.Errorf("grpc-web serve: %w", err)
@@ -225,6 +252,16 @@ func (s *Server) ListenAndServe() error {
 			return nil
 		})
 	}
+	if s.h3srv != nil {
+		g.Go(func() error {
+			defer s.quiclis.Close()
+			s.log.Info(fmt.Sprintf("Starting experimental HTTP/3 gRPC-web server on %s", s.quiclis.LocalAddr().String()))
+			if err := s.h3srv.Serve(s.quiclis); err != nil && err != http.ErrServerClosed {
+				return fmt.Errorf("http3 serve: %w", err)
+			}
+			return nil
+		})
+	}
 	if s.hostlis != nil {
 		g.Go(func() error {
 			defer s.hostlis.Close()
@@ -239,6 +276,42 @@ func (s *Server) ListenAndServe() error {
 	return g.Wait()
 }
 
+// webHandler returns the handler serving gRPC-Web and gRPC over HTTP. Requests
+// received over TCP advertise the HTTP/3 listener, if enabled. Only gRPC-Web is
+// served over HTTP/3 because it carries the gRPC trailers in the response body.
+func (s *Server) webHandler() http.Handler {
+	wrapped := grpcweb.WrapServer(s.srv, grpcweb.WithWebsockets(true))
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		if s.opts.EnableCORS {
+			s.log.Debug("Handling CORS options for request", "origin", req.Header.Get("Origin"))
+			resp.Header().Set("Access-Control-Allow-Origin", strings.Join(s.opts.AllowedOrigins, ", "))
+			resp.Header().Set("Access-Control-Allow-Credentials", "true")
+			resp.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Grpc-Web, X-User-Agent")
+			resp.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
+			if req.Method == http.MethodOptions {
+				resp.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+		if s.h3srv != nil && req.ProtoMajor < 3 {
+			// This only fails before the HTTP/3 listener is serving.
+			_ = s.h3srv.SetQuicHeaders(resp.Header())
+		}
+		if wrapped.IsGrpcWebRequest(req) {
+			s.log.Debug("Handling gRPC-Web request")
+			wrapped.ServeHTTP(resp, req)
+			return
+		}
+		if req.ProtoMajor >= 3 {
+			http.Error(resp, "only gRPC-Web is served over HTTP/3", http.StatusHTTPVersionNotSupported)
+			return
+		}
+		// Fall down to the gRPC server
+		s.log.Debug("Handling gRPC request")
+		s.srv.ServeHTTP(resp, req)
+	})
+}
+
 // RegisterService implements grpc.RegistrarService.
 func (s *Server) RegisterService(desc *grpc.ServiceDesc, impl any) {
 	if s.opts.DisableGRPC {
@@ -275,6 +348,12 @@ func (s *Server) Shutdown(ctx context.Context) {
 			s.log.Error("Mesh server shutdown failed", slog.String("error", err.Error()))
 		}
 	}
+	if s.h3srv != nil {
+		s.log.Info("Shutting down HTTP/3 gRPC-web server")
+		if err := s.h3srv.Close(); err != nil {
+			s.log.Error("HTTP/3 gRPC-web server shutdown failed", slog.String("error", err.Error()))
+		}
+	}
 	if s.websrv != nil {
 		s.log.Info("Shutting down gRPC-web server")
 		if err := s.websrv.Shutdown(ctx); err != nil {
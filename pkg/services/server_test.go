@@ -18,8 +18,17 @@ limitations under the License.
 package services
 
 import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/services/meshdns"
 )
 
@@ -47,3 +56,62 @@ func TestGetServerByType(t *testing.T) {
 		t.Fatal("expected server to not be nil")
 	}
 }
+
+func TestGRPCWebHTTP3Listener(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	key, cert, err := crypto.GenerateSelfSignedServerCert()
+	if err != nil {
+		t.Fatalf("generate certificate: %v", err)
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{cert.Raw}, PrivateKey: key}}}
+
+	_, err = NewServer(ctx, Options{ListenAddress: "127.0.0.1:0", GRPCWebHTTP3Enabled: true, TLSConfig: tlsConfig})
+	if err == nil {
+		t.Fatal("expected error enabling HTTP/3 without grpc-web")
+	}
+
+	srv, err := NewServer(ctx, Options{
+		ListenAddress:       "127.0.0.1:0",
+		WebEnabled:          true,
+		GRPCWebHTTP3Enabled: true,
+		TLSConfig:           tlsConfig,
+	})
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	defer srv.Shutdown(ctx)
+	port := srv.GRPCListenPort()
+	if got := srv.quiclis.LocalAddr().(*net.UDPAddr).Port; got != port {
+		t.Fatalf("expected QUIC listener on port %d, got %d", port, got)
+	}
+
+	// Native gRPC is not served over HTTP/3.
+	req := httptest.NewRequest(http.MethodPost, "/grpc.health.v1.Health/Check", nil)
+	req.ProtoMajor, req.ProtoMinor, req.Proto = 3, 0, "HTTP/3.0"
+	req.Header.Set("Content-Type", "application/grpc")
+	rec := httptest.NewRecorder()
+	srv.webHandler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusHTTPVersionNotSupported {
+		t.Fatalf("expected native gRPC over HTTP/3 to be refused, got status %d", rec.Code)
+	}
+
+	go func() { _ = srv.ListenAndServe() }()
+
+	// The TCP listener advertises the QUIC listener once it is serving.
+	want := `h3=":` + strconv.Itoa(port) + `"`
+	url := "http://" + net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	var altSvc string
+	for i := 0; i < 50; i++ {
+		resp, err := http.Get(url)
+		if err == nil {
+			altSvc = resp.Header.Get("Alt-Svc")
+			resp.Body.Close()
+			if strings.Contains(altSvc, want) {
+				return
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("expected Alt-Svc header to contain %s, got %q", want, altSvc)
+}
@@ -0,0 +1,350 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecar
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/system"
+)
+
+// DefaultSuperviseInterval is the default interval for checking the network
+// namespace of the application.
+const DefaultSuperviseInterval = 10 * time.Second
+
+// DefaultResolvConf is the default resolver configuration of the application.
+// Containers in a pod share the file written by the kubelet, so changes made
+// by the sidecar in place are seen by the application containers.
+const DefaultResolvConf = "/etc/resolv.conf"
+
+// ErrNotConfigured is returned when the mesh network has not been started.
+var ErrNotConfigured = errors.New("mesh network is not configured")
+
+// SupervisorOptions are the options for supervising the network namespace of
+// the application.
+type SupervisorOptions struct {
+	// Network is the network manager of this node. When the node is started
+	// with a network namespace, the interface and routes are managed inside it.
+	Network meshnet.Manager
+	// Domain is the mesh domain. It is added as a search domain to the
+	// resolver configuration.
+	Domain string
+	// ResolvConf is the path to the resolver configuration of the
+	// application. Mesh DNS servers are kept in it while the node runs. If
+	// empty, DNS is not configured.
+	ResolvConf string
+	// DNSServers returns the mesh DNS servers to configure.
+	DNSServers func(ctx context.Context) []netip.AddrPort
+	// Interval is how often the namespace is checked. Defaults to
+	// DefaultSuperviseInterval.
+	Interval time.Duration
+}
+
+// Supervisor configures the network namespace the node is injected into and
+// keeps it configured. The mesh interface is kept up, routes to the mesh
+// networks are restored if they are removed, and the mesh DNS servers and
+// domain are kept in the resolver configuration of the application.
+type Supervisor struct {
+	SupervisorOptions
+	log   *slog.Logger
+	mu    sync.Mutex
+	err   error
+	added resolvEntries
+	stop  context.CancelFunc
+	done  chan struct{}
+}
+
+// NewSupervisor returns a new supervisor for the network namespace.
+func NewSupervisor(ctx context.Context, opts SupervisorOptions) *Supervisor {
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultSuperviseInterval
+	}
+	return &Supervisor{
+		SupervisorOptions: opts,
+		log:               context.LoggerFrom(ctx).With("component", "sidecar-supervisor"),
+		err:               ErrNotConfigured,
+	}
+}
+
+// Start configures the namespace and supervises it in the background until
+// Stop is called.
+func (s *Supervisor) Start() {
+	var ctx context.Context
+	ctx, s.stop = context.WithCancel(context.WithLogger(context.Background(), s.log))
+	s.done = make(chan struct{})
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			s.Reconcile(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop stops supervising the namespace and removes the DNS configuration
+// added by the supervisor.
+func (s *Supervisor) Stop(ctx context.Context) error {
+	if s.stop != nil {
+		s.stop()
+		<-s.done
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ResolvConf == "" || s.added.empty() {
+		return nil
+	}
+	err := removeResolvEntries(s.ResolvConf, s.added)
+	if err != nil {
+		return fmt.Errorf("remove mesh dns configuration: %w", err)
+	}
+	s.added = resolvEntries{}
+	return nil
+}
+
+// Err returns the result of the last check of the namespace. It is nil if
+// the namespace is configured.
+func (s *Supervisor) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Reconcile checks the namespace once and restores any configuration that
+// was lost. The result is also returned by Err.
+func (s *Supervisor) Reconcile(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.reconcile(ctx)
+	if err != nil && (s.err == nil || s.err.Error() != err.Error()) {
+		s.log.Error("Network namespace is not configured", slog.String("error", err.Error()))
+	}
+	s.err = err
+	return err
+}
+
+func (s *Supervisor) reconcile(ctx context.Context) error {
+	wg := s.Network.WireGuard()
+	if wg == nil || (!wg.AddressV4().IsValid() && !wg.AddressV6().IsValid()) {
+		return ErrNotConfigured
+	}
+	link, err := wg.Link()
+	if err != nil {
+		return fmt.Errorf("mesh interface %s is missing: %w", wg.Name(), err)
+	}
+	if link.Flags&net.FlagUp == 0 {
+		s.log.Warn("Mesh interface is down, bringing it up", slog.String("interface", wg.Name()))
+		if err := wg.Up(ctx); err != nil {
+			return fmt.Errorf("bring up mesh interface %s: %w", wg.Name(), err)
+		}
+	}
+	var networks []netip.Prefix
+	if wg.AddressV4().IsValid() && s.Network.NetworkV4().IsValid() {
+		networks = append(networks, s.Network.NetworkV4())
+	}
+	if wg.AddressV6().IsValid() {
+		networks = append(networks, wg.AddressV6())
+		if s.Network.NetworkV6().IsValid() {
+			networks = append(networks, s.Network.NetworkV6())
+		}
+	}
+	for _, network := range networks {
+		err := wg.AddRoute(ctx, network)
+		if err == nil {
+			s.log.Warn("Restored route to mesh network", slog.String("network", network.String()))
+			continue
+		}
+		if !system.IsRouteExists(err) {
+			return fmt.Errorf("add route to %s: %w", network, err)
+		}
+	}
+	if s.ResolvConf == "" || s.DNSServers == nil {
+		return nil
+	}
+	want := resolvEntries{}
+	for _, server := range s.DNSServers(ctx) {
+		// Resolver configurations cannot carry a port.
+		if server.Port() != 53 {
+			continue
+		}
+		want.servers = append(want.servers, server.Addr().String())
+	}
+	if len(want.servers) > 0 && s.Domain != "" {
+		want.search = append(want.search, strings.TrimSuffix(s.Domain, "."))
+	}
+	added, err := ensureResolvEntries(s.ResolvConf, want)
+	if err != nil {
+		return fmt.Errorf("configure mesh dns: %w", err)
+	}
+	if !added.empty() {
+		s.log.Info("Configured mesh DNS", slog.Any("servers", added.servers), slog.Any("search", added.search))
+		s.added.merge(added)
+	}
+	return nil
+}
+
+// resolvEntries are nameserver and search entries in a resolver configuration.
+type resolvEntries struct {
+	servers []string
+	search  []string
+}
+
+func (r resolvEntries) empty() bool {
+	return len(r.servers) == 0 && len(r.search) == 0
+}
+
+func (r *resolvEntries) merge(o resolvEntries) {
+	for _, server := range o.servers {
+		if !slices.Contains(r.servers, server) {
+			r.servers = append(r.servers, server)
+		}
+	}
+	for _, domain := range o.search {
+		if !slices.Contains(r.search, domain) {
+			r.search = append(r.search, domain)
+		}
+	}
+}
+
+// parseResolvConf returns the nameserver and search entries of a resolver
+// configuration.
+func parseResolvConf(data []byte) resolvEntries {
+	var entries resolvEntries
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			entries.servers = append(entries.servers, fields[1])
+		case "search", "domain":
+			entries.search = append(entries.search, fields[1:]...)
+		}
+	}
+	return entries
+}
+
+// ensureResolvEntries adds any of the wanted entries missing from the resolver
+// configuration at path and returns the entries that were added. Mesh DNS
+// servers are placed before existing servers so they are queried first. The
+// file is rewritten in place so bind mounts of it see the change.
+func ensureResolvEntries(path string, want resolvEntries) (resolvEntries, error) {
+	var added resolvEntries
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return added, err
+	}
+	current := parseResolvConf(data)
+	for _, server := range want.servers {
+		if !slices.Contains(current.servers, server) {
+			added.servers = append(added.servers, server)
+		}
+	}
+	for _, domain := range want.search {
+		if !slices.Contains(current.search, domain) {
+			added.search = append(added.search, domain)
+		}
+	}
+	if added.empty() {
+		return added, nil
+	}
+	var out bytes.Buffer
+	for _, server := range added.servers {
+		fmt.Fprintf(&out, "nameserver %s\n", server)
+	}
+	searchWritten := len(added.search) == 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if !searchWritten && len(fields) > 0 && fields[0] == "search" {
+			line = strings.Join(append(append([]string{"search"}, added.search...), fields[1:]...), " ")
+			searchWritten = true
+		}
+		out.WriteString(line + "\n")
+	}
+	if !searchWritten {
+		fmt.Fprintf(&out, "search %s\n", strings.Join(added.search, " "))
+	}
+	return added, writeInPlace(path, out.Bytes())
+}
+
+// removeResolvEntries removes the given entries from the resolver
+// configuration at path.
+func removeResolvEntries(path string, remove resolvEntries) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) > 1 && fields[0] == "nameserver" && slices.Contains(remove.servers, fields[1]) {
+			continue
+		}
+		if len(fields) > 1 && fields[0] == "search" {
+			kept := slices.DeleteFunc(fields[1:], func(domain string) bool {
+				return slices.Contains(remove.search, domain)
+			})
+			if len(kept) == 0 {
+				continue
+			}
+			line = strings.Join(append([]string{"search"}, kept...), " ")
+		}
+		out.WriteString(line + "\n")
+	}
+	return writeInPlace(path, out.Bytes())
+}
+
+// writeInPlace truncates and rewrites the file at path instead of replacing
+// it, so the inode shared with other containers is preserved.
+func writeInPlace(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecar
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/testutil"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+)
+
+func TestResolvEntries(t *testing.T) {
+	t.Parallel()
+	const original = "nameserver 10.96.0.10\nsearch default.svc.cluster.local cluster.local\noptions ndots:5\n"
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := resolvEntries{
+		servers: []string{"172.16.0.2", "10.96.0.10"},
+		search:  []string{"webmesh.internal"},
+	}
+	added, err := ensureResolvEntries(path, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(added.servers, []string{"172.16.0.2"}) || !slices.Equal(added.search, []string{"webmesh.internal"}) {
+		t.Fatalf("unexpected entries added: %+v", added)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "nameserver 172.16.0.2\nnameserver 10.96.0.10\nsearch webmesh.internal default.svc.cluster.local cluster.local\noptions ndots:5\n"
+	if string(data) != expected {
+		t.Fatalf("unexpected resolv.conf:\n%s", data)
+	}
+	// Entries already present are left alone.
+	added, err = ensureResolvEntries(path, want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !added.empty() {
+		t.Fatalf("expected no entries added, got %+v", added)
+	}
+	if err := removeResolvEntries(path, resolvEntries{servers: []string{"172.16.0.2"}, search: []string{"webmesh.internal"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != original {
+		t.Fatalf("expected resolv.conf to be restored, got:\n%s", data)
+	}
+}
+
+func TestSupervisor(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	network := testutil.NewManagerWithDB(db, meshnet.Options{}, "node-a")
+	resolvConf := filepath.Join(t.TempDir(), "resolv.conf")
+	if err := os.WriteFile(resolvConf, []byte("nameserver 10.96.0.10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	supervisor := NewSupervisor(ctx, SupervisorOptions{
+		Network:    network,
+		Domain:     "webmesh.internal.",
+		ResolvConf: resolvConf,
+		DNSServers: func(context.Context) []netip.AddrPort {
+			return []netip.AddrPort{
+				netip.MustParseAddrPort("127.0.0.1:53"),
+				// Servers on other ports cannot be configured.
+				netip.MustParseAddrPort("172.16.0.2:5353"),
+			}
+		},
+	})
+	if err := supervisor.Reconcile(ctx); err != ErrNotConfigured {
+		t.Fatalf("expected ErrNotConfigured before start, got %v", err)
+	}
+	err := network.Start(ctx, meshnet.StartOptions{
+		Key:       crypto.MustGenerateKey(),
+		AddressV4: netip.MustParsePrefix("172.16.0.1/32"),
+		AddressV6: netip.MustParsePrefix("fd00:dead::1/64"),
+		NetworkV4: netip.MustParsePrefix("172.16.0.0/12"),
+		NetworkV6: netip.MustParsePrefix("fd00:dead::/48"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := network.WireGuard()
+	sys := wg.(*testutil.WireGuardInterface).Interface.(*testutil.SystemInterface)
+
+	if err := supervisor.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if supervisor.Err() != nil {
+		t.Fatalf("expected no error after reconcile, got %v", supervisor.Err())
+	}
+	link, err := wg.Link()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if link.Flags&net.FlagUp == 0 {
+		t.Fatal("expected interface to be brought up")
+	}
+	expectRoutes := func(t *testing.T) {
+		t.Helper()
+		routes := sys.Routes()
+		for _, want := range []string{"172.16.0.0/12", "fd00:dead::1/64", "fd00:dead::/48"} {
+			if !slices.Contains(routes, netip.MustParsePrefix(want)) {
+				t.Fatalf("expected route to %s, got %v", want, routes)
+			}
+		}
+	}
+	expectRoutes(t)
+	data, err := os.ReadFile(resolvConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "nameserver 127.0.0.1\nnameserver 10.96.0.10\nsearch webmesh.internal\n" {
+		t.Fatalf("unexpected resolv.conf:\n%s", data)
+	}
+
+	// Lost configuration is restored.
+	if err := wg.RemoveRoute(ctx, netip.MustParsePrefix("172.16.0.0/12")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(resolvConf, []byte("nameserver 10.96.0.10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := supervisor.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	expectRoutes(t)
+	data, err = os.ReadFile(resolvConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "nameserver 127.0.0.1\nnameserver 10.96.0.10\nsearch webmesh.internal\n" {
+		t.Fatalf("unexpected resolv.conf after restore:\n%s", data)
+	}
+
+	// Stopping removes the DNS configuration.
+	if err := supervisor.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	data, err = os.ReadFile(resolvConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "nameserver 10.96.0.10\n" {
+		t.Fatalf("expected mesh dns to be removed, got:\n%s", data)
+	}
+}
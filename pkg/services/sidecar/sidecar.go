@@ -0,0 +1,239 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sidecar configures the network namespace of an application container
+// when a node is injected as a sidecar and serves a local API for the
+// application to query mesh addresses.
+package sidecar
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// DefaultListenAddress is the default listen address for the sidecar API.
+// It is only reachable from containers sharing the network namespace.
+const DefaultListenAddress = "127.0.0.1:8086"
+
+const (
+	// HealthPath reports whether the mesh network and the namespace of the
+	// application are configured. It is
+	// suitable for readiness probes and for delaying application startup.
+	HealthPath = "/healthz"
+	// AddressesPath returns the mesh addresses of this node. Appending
+	// a node ID returns the addresses of that node instead.
+	AddressesPath = "/v1/addresses"
+)
+
+// Options are the options for the sidecar API.
+type Options struct {
+	// ListenAddress is the address to serve the API on.
+	ListenAddress string
+	// NodeID is the ID of this node.
+	NodeID types.NodeID
+	// Domain is the mesh domain.
+	Domain string
+	// Network is the network manager of this node.
+	Network meshnet.Manager
+	// Peers is used to look up other nodes and DNS servers.
+	Peers storage.Peers
+	// LocalDNSAddr is the address of a MeshDNS server running in this node.
+	LocalDNSAddr netip.AddrPort
+	// ResolvConf is the resolver configuration of the application to keep
+	// the mesh DNS servers in. If empty, DNS is not configured.
+	ResolvConf string
+	// SuperviseInterval is how often the namespace is checked. Defaults to
+	// DefaultSuperviseInterval.
+	SuperviseInterval time.Duration
+}
+
+// Addresses are the mesh addresses of a node.
+type Addresses struct {
+	NodeID     string   `json:"nodeID"`
+	Domain     string   `json:"domain,omitempty"`
+	AddressV4  string   `json:"addressV4,omitempty"`
+	AddressV6  string   `json:"addressV6,omitempty"`
+	NetworkV4  string   `json:"networkV4,omitempty"`
+	NetworkV6  string   `json:"networkV6,omitempty"`
+	DNSServers []string `json:"dnsServers,omitempty"`
+}
+
+// Server is the sidecar API server.
+type Server struct {
+	Options
+	srv        *http.Server
+	supervisor *Supervisor
+	log        *slog.Logger
+}
+
+// New returns a new sidecar API server.
+func New(ctx context.Context, o Options) *Server {
+	s := &Server{
+		Options: o,
+		log:     context.LoggerFrom(ctx).With("component", "sidecar-api"),
+	}
+	s.supervisor = NewSupervisor(ctx, SupervisorOptions{
+		Network:    o.Network,
+		Domain:     o.Domain,
+		ResolvConf: o.ResolvConf,
+		DNSServers: s.dnsServers,
+		Interval:   o.SuperviseInterval,
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc(HealthPath, s.serveHealth)
+	mux.HandleFunc(AddressesPath, s.serveAddresses)
+	mux.HandleFunc(AddressesPath+"/", s.serveAddresses)
+	s.srv = &http.Server{
+		Addr:    o.ListenAddress,
+		Handler: mux,
+	}
+	return s
+}
+
+// ListenAndServe starts supervising the namespace and serving the API and
+// blocks until the server exits.
+func (s *Server) ListenAndServe() error {
+	s.supervisor.Start()
+	s.log.Info("Starting sidecar API server", slog.String("listen_address", s.ListenAddress))
+	if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown attempts to stop the server gracefully.
+func (s *Server) Shutdown(ctx context.Context) error {
+	context.LoggerFrom(ctx).Info("Shutting down sidecar API server")
+	err := s.srv.Shutdown(ctx)
+	if serr := s.supervisor.Stop(ctx); serr != nil {
+		s.log.Error("Failed to clean up network namespace", slog.String("error", serr.Error()))
+	}
+	return err
+}
+
+func (s *Server) ready() bool {
+	wg := s.Network.WireGuard()
+	return wg != nil && (wg.AddressV4().IsValid() || wg.AddressV6().IsValid())
+}
+
+func (s *Server) serveHealth(w http.ResponseWriter, r *http.Request) {
+	if !s.ready() {
+		http.Error(w, "mesh network is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	if err := s.supervisor.Err(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	_, _ = w.Write([]byte("ok\n"))
+}
+
+func (s *Server) serveAddresses(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.ready() {
+		http.Error(w, "mesh network is not configured", http.StatusServiceUnavailable)
+		return
+	}
+	var addrs Addresses
+	nodeID := strings.Trim(strings.TrimPrefix(r.URL.Path, AddressesPath), "/")
+	if nodeID == "" || nodeID == s.NodeID.String() {
+		addrs = s.localAddresses(r.Context())
+	} else {
+		node, err := s.Peers.Get(r.Context(), types.NodeID(nodeID))
+		if err != nil {
+			if errors.IsNodeNotFound(err) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			s.log.Error("Failed to look up node", slog.String("node", nodeID), slog.String("error", err.Error()))
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		addrs = Addresses{
+			NodeID:    node.GetId(),
+			Domain:    s.Domain,
+			AddressV4: prefixString(node.PrivateAddrV4()),
+			AddressV6: prefixString(node.PrivateAddrV6()),
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(addrs); err != nil {
+		s.log.Error("Failed to write addresses response", slog.String("error", err.Error()))
+	}
+}
+
+func (s *Server) localAddresses(ctx context.Context) Addresses {
+	wg := s.Network.WireGuard()
+	addrs := Addresses{
+		NodeID:    s.NodeID.String(),
+		Domain:    s.Domain,
+		AddressV4: prefixString(wg.AddressV4()),
+		AddressV6: prefixString(wg.AddressV6()),
+		NetworkV4: prefixString(s.Network.NetworkV4()),
+		NetworkV6: prefixString(s.Network.NetworkV6()),
+	}
+	for _, server := range s.dnsServers(ctx) {
+		addrs.DNSServers = append(addrs.DNSServers, server.String())
+	}
+	return addrs
+}
+
+// dnsServers returns the mesh DNS servers reachable from this node.
+func (s *Server) dnsServers(ctx context.Context) []netip.AddrPort {
+	var out []netip.AddrPort
+	if s.LocalDNSAddr.IsValid() {
+		out = append(out, s.LocalDNSAddr)
+	}
+	servers, err := s.Peers.List(ctx, storage.FilterByFeature(v1.Feature_MESH_DNS))
+	if err != nil {
+		s.log.Warn("Failed to list DNS servers", slog.String("error", err.Error()))
+		return out
+	}
+	hasV4 := s.Network.WireGuard().AddressV4().IsValid()
+	for _, server := range servers {
+		if server.NodeID() == s.NodeID && s.LocalDNSAddr.IsValid() {
+			continue
+		}
+		if addr := server.PrivateDNSAddrV4(); addr.IsValid() && hasV4 {
+			out = append(out, addr)
+		} else if addr := server.PrivateDNSAddrV6(); addr.IsValid() {
+			out = append(out, addr)
+		}
+	}
+	return out
+}
+
+func prefixString(p netip.Prefix) string {
+	if !p.IsValid() {
+		return ""
+	}
+	return p.String()
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sidecar
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/meshnet"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/testutil"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	key := crypto.MustGenerateKey()
+	network := testutil.NewManagerWithDB(db, meshnet.Options{}, "node-a")
+	srv := New(ctx, Options{
+		NodeID:       "node-a",
+		Domain:       "webmesh.internal",
+		Network:      network,
+		Peers:        db.Peers(),
+		LocalDNSAddr: netip.MustParseAddrPort("127.0.0.1:53"),
+	})
+	handler := srv.srv.Handler
+
+	get := func(t *testing.T, path string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	// Nothing is served until the network is configured.
+	if rec := get(t, HealthPath); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected unavailable before start, got %d", rec.Code)
+	}
+	err := network.Start(ctx, meshnet.StartOptions{
+		Key:       key,
+		AddressV4: netip.MustParsePrefix("172.16.0.1/32"),
+		NetworkV4: netip.MustParsePrefix("172.16.0.0/12"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(t, HealthPath); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected unavailable before the namespace is configured, got %d", rec.Code)
+	}
+	if err := srv.supervisor.Reconcile(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if rec := get(t, HealthPath); rec.Code != http.StatusOK {
+		t.Fatalf("expected ok after start, got %d", rec.Code)
+	}
+
+	encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+		Id:          "node-b",
+		PublicKey:   encoded,
+		PrivateIPv4: "172.16.0.2/32",
+		Features: []*v1.FeaturePort{
+			{Feature: v1.Feature_MESH_DNS, Port: 53},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("LocalAddresses", func(t *testing.T) {
+		rec := get(t, AddressesPath)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+		}
+		var addrs Addresses
+		if err := json.Unmarshal(rec.Body.Bytes(), &addrs); err != nil {
+			t.Fatal(err)
+		}
+		if addrs.NodeID != "node-a" || addrs.AddressV4 != "172.16.0.1/32" || addrs.NetworkV4 != "172.16.0.0/12" {
+			t.Errorf("unexpected addresses: %+v", addrs)
+		}
+		if len(addrs.DNSServers) != 2 || addrs.DNSServers[0] != "127.0.0.1:53" || addrs.DNSServers[1] != "172.16.0.2:53" {
+			t.Errorf("unexpected dns servers: %v", addrs.DNSServers)
+		}
+	})
+
+	t.Run("PeerAddresses", func(t *testing.T) {
+		rec := get(t, AddressesPath+"/node-b")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("unexpected status %d: %s", rec.Code, rec.Body.String())
+		}
+		var addrs Addresses
+		if err := json.Unmarshal(rec.Body.Bytes(), &addrs); err != nil {
+			t.Fatal(err)
+		}
+		if addrs.NodeID != "node-b" || addrs.AddressV4 != "172.16.0.2/32" {
+			t.Errorf("unexpected addresses: %+v", addrs)
+		}
+		if rec := get(t, AddressesPath+"/node-c"); rec.Code != http.StatusNotFound {
+			t.Errorf("expected not found for unknown node, got %d", rec.Code)
+		}
+	})
+}
@@ -21,10 +21,13 @@ import (
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/services/rbac"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -57,7 +60,15 @@ func (s *Server) Publish(ctx context.Context, req *v1.PublishRequest) (*v1.Publi
 		return nil, status.Errorf(codes.InvalidArgument, "key %q is reserved", req.GetKey())
 	}
 	// TODO: Validate key and value and check for overlaps and other issues.
-	err = s.storage.MeshStorage().PutValue(ctx, req.GetKey(), req.GetValue(), req.GetTtl().AsDuration())
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get(storage.ExpectedVersionHeader)) > 0 {
+		expected := storage.Version(md.Get(storage.ExpectedVersionHeader)[0])
+		err = s.storage.MeshStorage().CompareAndSwap(ctx, req.GetKey(), req.GetValue(), expected, req.GetTtl().AsDuration())
+	} else {
+		err = s.storage.MeshStorage().PutValue(ctx, req.GetKey(), req.GetValue(), req.GetTtl().AsDuration())
+	}
+	if errors.IsVersionConflict(err) {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
 	if err != nil {
 		return nil, status.Errorf(codes.Internal, "error publishing: %v", err)
 	}
@@ -24,7 +24,9 @@ import (
 	"google.golang.org/grpc/status"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/rpcsrv"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
 func (s *Server) Query(ctx context.Context, req *v1.QueryRequest) (*v1.QueryResponse, error) {
@@ -37,5 +39,39 @@ func (s *Server) Query(ctx context.Context, req *v1.QueryRequest) (*v1.QueryResp
 		// In theory - non-storage members shouldn't even expose the Node service.
 		return nil, status.Error(codes.Unavailable, "node not available to query")
 	}
+	if err := checkProtectedWrites(req); err != nil {
+		return nil, err
+	}
 	return rpcsrv.ServeQuery(ctx, s.storage, req), nil
 }
+
+// checkProtectedWrites rejects raw writes to keys that are managed through
+// an authorized API.
+func checkProtectedWrites(req *v1.QueryRequest) error {
+	var keys [][]byte
+	switch req.GetCommand() {
+	case v1.QueryRequest_PUT, v1.QueryRequest_DELETE:
+		if req.GetType() != v1.QueryRequest_VALUE {
+			return nil
+		}
+		// The filters are read without validating the query, so protection
+		// does not depend on which keys the query parser accepts.
+		if id, ok := types.ParseQueryFilters(req).GetID(); ok {
+			keys = append(keys, []byte(id))
+		}
+	case types.QueryCommandBatch:
+		ops, err := storage.UnmarshalWriteOps(req.GetItem())
+		if err != nil {
+			return nil
+		}
+		for _, op := range ops {
+			keys = append(keys, op.Key)
+		}
+	}
+	for _, key := range keys {
+		if storage.IsProtectedKey(key) {
+			return status.Errorf(codes.PermissionDenied, "%s can only be written through the admin APIs", key)
+		}
+	}
+	return nil
+}
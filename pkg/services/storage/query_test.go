@@ -0,0 +1,86 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestCheckProtectedWrites(t *testing.T) {
+	t.Parallel()
+	put := func(key []byte) *v1.QueryRequest {
+		return &v1.QueryRequest{
+			Command: v1.QueryRequest_PUT,
+			Type:    v1.QueryRequest_VALUE,
+			Query:   types.NewQueryFilters().WithID(string(key)).Encode(),
+			Item:    []byte("team-a"),
+		}
+	}
+	batch := func(t *testing.T, key []byte) *v1.QueryRequest {
+		t.Helper()
+		item, err := storage.MarshalWriteOps([]storage.WriteOp{
+			{Key: storage.NodesPrefix.ForString("node-a"), Value: []byte("{}")},
+			{Key: key, Value: []byte("team-a")},
+		})
+		if err != nil {
+			t.Fatalf("marshal write ops: %v", err)
+		}
+		return &v1.QueryRequest{Command: types.QueryCommandBatch, Item: item}
+	}
+	member := storage.NamespaceMembersPrefix.ForString("node-a")
+	tc := []struct {
+		name string
+		req  *v1.QueryRequest
+		want codes.Code
+	}{
+		{"namespace member put", put(member), codes.PermissionDenied},
+		{"namespace member batch", batch(t, member), codes.PermissionDenied},
+		{"escrow put", put(storage.EscrowPrefix.ForString("node-a")), codes.PermissionDenied},
+		{"escrow batch", batch(t, storage.EscrowPrefix.ForString("node-a")), codes.PermissionDenied},
+		{"cluster id put", put(storage.ClusterIDKey), codes.PermissionDenied},
+		{"cluster id batch", batch(t, storage.ClusterIDKey), codes.PermissionDenied},
+		{"namespace put", put(storage.NamespacesPrefix.ForString("team-a")), codes.PermissionDenied},
+		{"acl exemptions put", put(storage.ACLExemptionsKey), codes.PermissionDenied},
+		{"acl schedule put", put(storage.ACLSchedulesPrefix.ForString("maintenance")), codes.PermissionDenied},
+		{"node labels put", put(storage.NodeLabelsPrefix.ForString("node-a")), codes.PermissionDenied},
+		{"traffic policy put", put(storage.TrafficPoliciesPrefix.ForString("tenant-a")), codes.PermissionDenied},
+		{"route metric put", put(storage.RouteMetricsPrefix.ForString("route-a")), codes.PermissionDenied},
+		{"sharded batch put", put(storage.ShardedBatchesPrefix.ForString("commits/batch-a")), codes.PermissionDenied},
+		{"unprotected put", put([]byte("/services/acme.v1.Widgets/node-a")), codes.OK},
+		{"get", &v1.QueryRequest{
+			Command: v1.QueryRequest_GET,
+			Type:    v1.QueryRequest_VALUE,
+			Query:   types.NewQueryFilters().WithID(string(member)).Encode(),
+		}, codes.OK},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			if got := status.Code(checkProtectedWrites(tt.req)); got != tt.want {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
@@ -43,7 +43,7 @@ type Options struct {
 	// PublicIP is the public IP address of the TURN server. This is used for relaying.
 	PublicIP string
 	// RelayAddressUDP is the binding address the TURN server uses for request handling and STUN relays.
-	// Defaults to 0.0.0.0.
+	// Defaults to 0.0.0.0, or :: on hosts without a routable IPv4 address.
 	RelayAddressUDP string
 	// ListenUDP is the address the TURN server listens on for UDP requests.
 	ListenUDP string
@@ -76,6 +76,9 @@ func (s *Server) ListenAndServe() error {
 	}
 	if s.RelayAddressUDP == "" {
 		s.RelayAddressUDP = DefaultRelayAddress
+		if families := netutil.DetectAddressFamilies(); !families.RoutableIPv4 && families.RoutableIPv6 {
+			s.RelayAddressUDP = families.Unspecified().String()
+		}
 	}
 	startPort, endPort, err := netutil.ParsePortRange(s.PortRange)
 	if err != nil {
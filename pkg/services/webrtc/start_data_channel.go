@@ -121,7 +121,7 @@ func (s *Server) handleLocalNegotiation(log *slog.Logger, stream v1.WebRTC_Start
 		if err != nil {
 			return status.Errorf(codes.Internal, "failed to get WireGuard listen port: %v", err)
 		}
-		conn, err = datachannels.NewWireGuardProxyServer(stream.Context(), s.opts.STUNServers, uint16(port))
+		conn, err = datachannels.NewWireGuardProxyServer(stream.Context(), s.opts.STUNServers, s.opts.TURNAuth, uint16(port))
 		if err != nil {
 			return err
 		}
@@ -132,6 +132,7 @@ func (s *Server) handleLocalNegotiation(log *slog.Logger, stream v1.WebRTC_Start
 			SrcAddress:  remoteAddr,
 			DstAddress:  net.JoinHostPort(r.GetDst(), strconv.Itoa(int(r.GetPort()))),
 			STUNServers: s.opts.STUNServers,
+			TURNAuth:    s.opts.TURNAuth,
 		})
 		if err != nil {
 			return err
@@ -0,0 +1,72 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ACLExemptionsKey is where the network ACL exemptions of the mesh are stored
+// in the database.
+var ACLExemptionsKey = types.RegistryPrefix.ForString("acl-exemptions")
+
+// GetACLExemptions returns the network ACL exemptions of the mesh. No traffic
+// is exempt if none were configured.
+func GetACLExemptions(ctx context.Context, st MeshStorage) (types.ACLExemptions, error) {
+	var exemptions types.ACLExemptions
+	data, err := st.GetValue(ctx, ACLExemptionsKey)
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return exemptions, nil
+		}
+		return exemptions, fmt.Errorf("get acl exemptions: %w", err)
+	}
+	if err := json.Unmarshal(data, &exemptions); err != nil {
+		return exemptions, fmt.Errorf("unmarshal acl exemptions: %w", err)
+	}
+	return exemptions, nil
+}
+
+// PutACLExemptions replaces the network ACL exemptions of the mesh.
+func PutACLExemptions(ctx context.Context, st MeshStorage, exemptions types.ACLExemptions) error {
+	if err := exemptions.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(exemptions)
+	if err != nil {
+		return fmt.Errorf("marshal acl exemptions: %w", err)
+	}
+	if err := st.PutValue(ctx, ACLExemptionsKey, data, 0); err != nil {
+		return fmt.Errorf("put acl exemptions: %w", err)
+	}
+	return nil
+}
+
+// ACLExemptionsFor loads the network ACL exemptions for the given database. No
+// traffic is exempt if the database does not expose its underlying storage.
+func ACLExemptionsFor(ctx context.Context, db MeshDB) (types.ACLExemptions, error) {
+	st := MeshStorageOf(db)
+	if st == nil {
+		return types.ACLExemptions{}, nil
+	}
+	return GetACLExemptions(ctx, st)
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ACLSchedulesPrefix is where network ACL schedules are stored in the database.
+// Schedules are indexed by ACL name in the format /registry/acl-schedules/<name>.
+var ACLSchedulesPrefix = types.RegistryPrefix.ForString("acl-schedules")
+
+// GetACLSchedule returns the schedule of the given network ACL. False is
+// returned if the ACL has no schedule.
+func GetACLSchedule(ctx context.Context, st MeshStorage, name string) (types.ACLSchedule, bool, error) {
+	var schedule types.ACLSchedule
+	data, err := st.GetValue(ctx, ACLSchedulesPrefix.ForString(name))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return schedule, false, nil
+		}
+		return schedule, false, fmt.Errorf("get acl schedule: %w", err)
+	}
+	if err := json.Unmarshal(data, &schedule); err != nil {
+		return schedule, false, fmt.Errorf("unmarshal acl schedule: %w", err)
+	}
+	return schedule, true, nil
+}
+
+// PutACLSchedule sets the schedule of the given network ACL.
+func PutACLSchedule(ctx context.Context, st MeshStorage, name string, schedule types.ACLSchedule) error {
+	if !types.IsValidID(name) {
+		return fmt.Errorf("invalid network acl name %q", name)
+	}
+	if err := schedule.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(schedule)
+	if err != nil {
+		return fmt.Errorf("marshal acl schedule: %w", err)
+	}
+	if err := st.PutValue(ctx, ACLSchedulesPrefix.ForString(name), data, 0); err != nil {
+		return fmt.Errorf("put acl schedule: %w", err)
+	}
+	return nil
+}
+
+// DeleteACLSchedule removes the schedule of the given network ACL, putting it
+// in effect at all times.
+func DeleteACLSchedule(ctx context.Context, st MeshStorage, name string) error {
+	if err := st.Delete(ctx, ACLSchedulesPrefix.ForString(name)); err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete acl schedule: %w", err)
+	}
+	return nil
+}
+
+// ListACLSchedules returns the schedules of all network ACLs.
+func ListACLSchedules(ctx context.Context, st MeshStorage) (types.ACLSchedules, error) {
+	out := make(types.ACLSchedules)
+	prefix := append(ACLSchedulesPrefix, '/')
+	err := st.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		var schedule types.ACLSchedule
+		if err := json.Unmarshal(value, &schedule); err != nil {
+			return fmt.Errorf("unmarshal acl schedule %s: %w", key, err)
+		}
+		out[string(bytes.TrimPrefix(key, prefix))] = schedule
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list acl schedules: %w", err)
+	}
+	return out, nil
+}
+
+// ACLSchedulesFor loads the network ACL schedules for the given database. Every
+// ACL is in effect if the database does not expose its underlying storage.
+func ACLSchedulesFor(ctx context.Context, db MeshDB) (types.ACLSchedules, error) {
+	st := MeshStorageOf(db)
+	if st == nil {
+		return types.ACLSchedules{}, nil
+	}
+	return ListACLSchedules(ctx, st)
+}
@@ -0,0 +1,141 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// NodeAliasPrefix is where the aliases left behind by renamed nodes are stored.
+// Aliases are indexed by the old node ID in the format /registry/node-aliases/<id>.
+var NodeAliasPrefix = types.RegistryPrefix.ForString("node-aliases")
+
+// DefaultRenameGracePeriod is the default time an alias resolves the old ID of
+// a renamed node.
+const DefaultRenameGracePeriod = time.Hour
+
+// AssignedNodeIDHeader is the response header set on a join request when the
+// joining node was registered under a different ID than the one it requested.
+const AssignedNodeIDHeader = "x-webmesh-assigned-node-id"
+
+// maxAliasHops bounds the chain of aliases followed when a node is renamed
+// several times within the grace period.
+const maxAliasHops = 8
+
+// NodeAlias maps the old ID of a renamed node to its new ID for a grace period,
+// so that peers still using the old ID keep resolving the node.
+type NodeAlias struct {
+	// Alias is the old ID of the node.
+	Alias types.NodeID `json:"alias"`
+	// NodeID is the new ID of the node.
+	NodeID types.NodeID `json:"nodeID"`
+	// Renamed is when the node was renamed.
+	Renamed time.Time `json:"renamed"`
+	// Expires is when the alias stops resolving.
+	Expires time.Time `json:"expires"`
+}
+
+// AssignedNodeID returns the node ID assigned in the header of a join
+// response, if the joining node was renamed to resolve an ID collision.
+func AssignedNodeID(header metadata.MD) (types.NodeID, bool) {
+	ids := header.Get(AssignedNodeIDHeader)
+	if len(ids) == 0 || ids[0] == "" {
+		return "", false
+	}
+	return types.NodeID(ids[0]), true
+}
+
+// PutNodeAlias records an alias from the old ID of a node to its new ID that
+// expires after the given grace period.
+func PutNodeAlias(ctx context.Context, st MeshStorage, from, to types.NodeID, grace time.Duration) error {
+	now := time.Now().UTC()
+	data, err := json.Marshal(NodeAlias{
+		Alias:   from,
+		NodeID:  to,
+		Renamed: now,
+		Expires: now.Add(grace),
+	})
+	if err != nil {
+		return fmt.Errorf("marshal node alias: %w", err)
+	}
+	return st.PutValue(ctx, NodeAliasPrefix.ForString(from.String()), data, grace)
+}
+
+// GetNodeAlias returns the unexpired alias for the given old node ID. A key not
+// found error is returned if there is none.
+func GetNodeAlias(ctx context.Context, st MeshStorage, id types.NodeID) (NodeAlias, error) {
+	var alias NodeAlias
+	data, err := st.GetValue(ctx, NodeAliasPrefix.ForString(id.String()))
+	if err != nil {
+		return alias, err
+	}
+	if err := json.Unmarshal(data, &alias); err != nil {
+		return alias, fmt.Errorf("unmarshal node alias: %w", err)
+	}
+	// Not every storage honors TTLs, so check the expiry ourselves.
+	if time.Now().After(alias.Expires) {
+		return alias, errors.ErrKeyNotFound
+	}
+	return alias, nil
+}
+
+// ResolveNodeAlias returns the current ID of a node that may have been renamed,
+// following aliases across repeated renames. The given ID is returned unchanged
+// if it is not an alias.
+func ResolveNodeAlias(ctx context.Context, st MeshStorage, id types.NodeID) (types.NodeID, error) {
+	for i := 0; i < maxAliasHops; i++ {
+		alias, err := GetNodeAlias(ctx, st, id)
+		if errors.IsKeyNotFound(err) {
+			return id, nil
+		}
+		if err != nil {
+			return id, err
+		}
+		id = alias.NodeID
+	}
+	return id, fmt.Errorf("too many aliases resolving node %s", id)
+}
+
+// ListNodeAliases returns all unexpired node aliases ordered by old ID.
+func ListNodeAliases(ctx context.Context, st MeshStorage) ([]NodeAlias, error) {
+	var aliases []NodeAlias
+	now := time.Now()
+	err := st.IterPrefix(ctx, append(NodeAliasPrefix, '/'), func(key, value []byte) error {
+		var alias NodeAlias
+		if err := json.Unmarshal(value, &alias); err != nil {
+			return fmt.Errorf("unmarshal node alias %s: %w", key, err)
+		}
+		if now.Before(alias.Expires) {
+			aliases = append(aliases, alias)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Alias < aliases[j].Alias })
+	return aliases, nil
+}
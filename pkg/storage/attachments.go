@@ -0,0 +1,272 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// AttachmentsPrefix is where workload attachments are stored in the database.
+// Attachments are indexed by node and ID in the format
+// /registry/attachments/<node>/<id>.
+var AttachmentsPrefix = types.RegistryPrefix.ForString("attachments")
+
+// AttachmentRouteSuffix is appended to a node ID to form the name of the route
+// holding the untagged attachment addresses of the node.
+const AttachmentRouteSuffix = "-attachments"
+
+// AttachmentTagRouteInfix separates the node ID and the tag in the names of the
+// routes holding the tagged attachment addresses of a node.
+const AttachmentTagRouteInfix = "-tag-"
+
+// Attachment is an address allocated from the mesh to a workload, such as a
+// container, running behind a node. The node routes the addresses of its
+// attachments and advertises them to the rest of the mesh.
+type Attachment struct {
+	// ID is the unique ID of the attachment on the node.
+	ID string `json:"id"`
+	// NodeID is the node the attachment is behind.
+	NodeID types.NodeID `json:"nodeID"`
+	// Owner identifies the integration that created the attachment,
+	// for example the endpoint of a container runtime.
+	Owner string `json:"owner,omitempty"`
+	// Sandbox is the network namespace of the workload, if known. It is
+	// used to release the attachments of workloads that have gone away.
+	Sandbox string `json:"sandbox,omitempty"`
+	// AddressV4 is the IPv4 address of the attachment.
+	AddressV4 netip.Prefix `json:"addressV4"`
+	// AddressV6 is the IPv6 address of the attachment.
+	AddressV6 netip.Prefix `json:"addressV6"`
+	// Tags are used to reference the attachment from network ACLs.
+	Tags []string `json:"tags,omitempty"`
+	// Created is when the attachment was created.
+	Created time.Time `json:"created"`
+}
+
+// Validate validates the attachment.
+func (a Attachment) Validate() error {
+	if a.ID == "" {
+		return fmt.Errorf("attachment id is required")
+	}
+	if !types.IsValidID(a.ID) {
+		return fmt.Errorf("attachment id %q is invalid", a.ID)
+	}
+	if !a.NodeID.IsValid() {
+		return fmt.Errorf("invalid node id %q for attachment", a.NodeID)
+	}
+	if !a.AddressV4.IsValid() && !a.AddressV6.IsValid() {
+		return fmt.Errorf("attachment %s has no addresses", a.ID)
+	}
+	if a.AddressV4.IsValid() && !a.AddressV4.Addr().Is4() {
+		return fmt.Errorf("attachment %s has invalid IPv4 address %s", a.ID, a.AddressV4)
+	}
+	if a.AddressV6.IsValid() && !a.AddressV6.Addr().Is6() {
+		return fmt.Errorf("attachment %s has invalid IPv6 address %s", a.ID, a.AddressV6)
+	}
+	for _, tag := range a.Tags {
+		if !types.IsValidID(tag) {
+			return fmt.Errorf("attachment %s has invalid tag %q", a.ID, tag)
+		}
+	}
+	return nil
+}
+
+// PutAttachment creates or updates an attachment.
+func PutAttachment(ctx context.Context, st MeshStorage, a Attachment) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return fmt.Errorf("marshal attachment: %w", err)
+	}
+	return st.PutValue(ctx, AttachmentsPrefix.ForString(a.NodeID.String()+"/"+a.ID), data, 0)
+}
+
+// GetAttachment returns the attachment with the given ID on the given node.
+func GetAttachment(ctx context.Context, st MeshStorage, nodeID types.NodeID, id string) (Attachment, error) {
+	var a Attachment
+	data, err := st.GetValue(ctx, AttachmentsPrefix.ForString(nodeID.String()+"/"+id))
+	if err != nil {
+		return a, err
+	}
+	if err := json.Unmarshal(data, &a); err != nil {
+		return a, fmt.Errorf("unmarshal attachment: %w", err)
+	}
+	return a, nil
+}
+
+// DeleteAttachment deletes the attachment with the given ID on the given node.
+func DeleteAttachment(ctx context.Context, st MeshStorage, nodeID types.NodeID, id string) error {
+	return st.Delete(ctx, AttachmentsPrefix.ForString(nodeID.String()+"/"+id))
+}
+
+// ListAttachments returns all attachments in the mesh ordered by node and ID.
+func ListAttachments(ctx context.Context, st MeshStorage) ([]Attachment, error) {
+	return listAttachments(ctx, st, append(AttachmentsPrefix, '/'))
+}
+
+// ListNodeAttachments returns the attachments behind the given node ordered by ID.
+func ListNodeAttachments(ctx context.Context, st MeshStorage, nodeID types.NodeID) ([]Attachment, error) {
+	return listAttachments(ctx, st, append(AttachmentsPrefix.ForString(nodeID.String()), '/'))
+}
+
+func listAttachments(ctx context.Context, st MeshStorage, prefix []byte) ([]Attachment, error) {
+	var attachments []Attachment
+	err := st.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		var a Attachment
+		if err := json.Unmarshal(value, &a); err != nil {
+			return fmt.Errorf("unmarshal attachment %s: %w", key, err)
+		}
+		attachments = append(attachments, a)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(attachments, func(i, j int) bool {
+		if attachments[i].NodeID != attachments[j].NodeID {
+			return attachments[i].NodeID < attachments[j].NodeID
+		}
+		return attachments[i].ID < attachments[j].ID
+	})
+	return attachments, nil
+}
+
+// SyncAttachmentRoutes updates the routes advertising the attachments of the
+// given node. Untagged IPv4 addresses are placed in a single route so that peers
+// add them to the allowed IPs of the node, IPv6 addresses are already covered by
+// the prefix of the node. Tagged addresses are placed in a route per tag so that
+// network ACLs can reference them with a tag: prefix.
+func SyncAttachmentRoutes(ctx context.Context, st MeshStorage, nw Networking, nodeID types.NodeID) error {
+	attachments, err := ListNodeAttachments(ctx, st, nodeID)
+	if err != nil {
+		return fmt.Errorf("list attachments: %w", err)
+	}
+	want := make(map[string][]string)
+	for _, a := range attachments {
+		if len(a.Tags) == 0 {
+			if a.AddressV4.IsValid() {
+				name := nodeID.String() + AttachmentRouteSuffix
+				want[name] = append(want[name], a.AddressV4.String())
+			}
+			continue
+		}
+		for _, tag := range a.Tags {
+			name := nodeID.String() + AttachmentTagRouteInfix + tag
+			for _, addr := range []netip.Prefix{a.AddressV4, a.AddressV6} {
+				if addr.IsValid() && !slices.Contains(want[name], addr.String()) {
+					want[name] = append(want[name], addr.String())
+				}
+			}
+		}
+	}
+	routes, err := nw.GetRoutesByNode(ctx, nodeID)
+	if err != nil {
+		return fmt.Errorf("get routes by node: %w", err)
+	}
+	for _, route := range routes {
+		if _, ok := want[route.GetName()]; ok || !isAttachmentRoute(nodeID, route.GetName()) {
+			continue
+		}
+		if err := nw.DeleteRoute(ctx, route.GetName()); err != nil && !errors.IsRouteNotFound(err) {
+			return fmt.Errorf("delete route %s: %w", route.GetName(), err)
+		}
+	}
+	for name, cidrs := range want {
+		route := types.Route{Route: &v1.Route{
+			Name:             name,
+			Node:             nodeID.String(),
+			DestinationCIDRs: cidrs,
+		}}
+		if err := nw.PutRoute(ctx, route); err != nil {
+			return fmt.Errorf("put route %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func isAttachmentRoute(nodeID types.NodeID, name string) bool {
+	return name == nodeID.String()+AttachmentRouteSuffix ||
+		strings.HasPrefix(name, nodeID.String()+AttachmentTagRouteInfix)
+}
+
+// ExpandACLTags expands tag references in the CIDRs of the given ACLs to the
+// addresses of the attachments carrying the tag. References are kept in place
+// so that an ACL referencing a tag without attachments matches nothing.
+func ExpandACLTags(ctx context.Context, nw Networking, acls types.NetworkACLs) error {
+	var tagged map[string][]string
+	expand := func(cidrs []string) ([]string, error) {
+		var out []string
+		for _, cidr := range cidrs {
+			out = append(out, cidr)
+			if !strings.HasPrefix(cidr, types.TagReference) {
+				continue
+			}
+			if tagged == nil {
+				var err error
+				tagged, err = attachmentTags(ctx, nw)
+				if err != nil {
+					return nil, err
+				}
+			}
+			out = append(out, tagged[strings.TrimPrefix(cidr, types.TagReference)]...)
+		}
+		return out, nil
+	}
+	for _, acl := range acls {
+		src, err := expand(acl.GetSourceCIDRs())
+		if err != nil {
+			return err
+		}
+		dst, err := expand(acl.GetDestinationCIDRs())
+		if err != nil {
+			return err
+		}
+		acl.SourceCIDRs, acl.DestinationCIDRs = src, dst
+	}
+	return nil
+}
+
+func attachmentTags(ctx context.Context, nw Networking) (map[string][]string, error) {
+	routes, err := nw.ListRoutes(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list routes: %w", err)
+	}
+	tagged := make(map[string][]string)
+	for _, route := range routes {
+		prefix := route.GetNode() + AttachmentTagRouteInfix
+		if !strings.HasPrefix(route.GetName(), prefix) {
+			continue
+		}
+		tag := strings.TrimPrefix(route.GetName(), prefix)
+		tagged[tag] = append(tagged[tag], route.GetDestinationCIDRs()...)
+	}
+	return tagged, nil
+}
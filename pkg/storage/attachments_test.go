@@ -0,0 +1,117 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestAttachments(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+
+	for _, a := range []storage.Attachment{
+		{ID: "c1", NodeID: "node-a", AddressV4: netip.MustParsePrefix("172.16.0.10/32")},
+		{ID: "c2", NodeID: "node-a", AddressV4: netip.MustParsePrefix("172.16.0.11/32"), AddressV6: netip.MustParsePrefix("fd00::1:2/128"), Tags: []string{"web"}},
+		{ID: "c3", NodeID: "node-b", AddressV4: netip.MustParsePrefix("172.16.0.12/32"), Tags: []string{"web"}},
+	} {
+		if err := storage.PutAttachment(ctx, st, a); err != nil {
+			t.Fatalf("put attachment: %v", err)
+		}
+	}
+	if err := storage.PutAttachment(ctx, st, storage.Attachment{ID: "c4", NodeID: "node-a"}); err == nil {
+		t.Fatal("expected error putting an attachment without addresses")
+	}
+	all, err := storage.ListAttachments(ctx, st)
+	if err != nil {
+		t.Fatalf("list attachments: %v", err)
+	}
+	if len(all) != 3 {
+		t.Fatalf("expected 3 attachments, got %d", len(all))
+	}
+	onA, err := storage.ListNodeAttachments(ctx, st, "node-a")
+	if err != nil {
+		t.Fatalf("list node attachments: %v", err)
+	}
+	if len(onA) != 2 || onA[0].ID != "c1" || onA[1].ID != "c2" {
+		t.Fatalf("unexpected attachments for node-a: %+v", onA)
+	}
+
+	for _, node := range []types.NodeID{"node-a", "node-b"} {
+		if err := storage.SyncAttachmentRoutes(ctx, st, db.Networking(), node); err != nil {
+			t.Fatalf("sync attachment routes: %v", err)
+		}
+	}
+	route, err := db.Networking().GetRoute(ctx, "node-a"+storage.AttachmentRouteSuffix)
+	if err != nil {
+		t.Fatalf("get untagged route: %v", err)
+	}
+	if !slices.Equal(route.GetDestinationCIDRs(), []string{"172.16.0.10/32"}) {
+		t.Fatalf("unexpected untagged route cidrs: %v", route.GetDestinationCIDRs())
+	}
+	route, err = db.Networking().GetRoute(ctx, "node-a"+storage.AttachmentTagRouteInfix+"web")
+	if err != nil {
+		t.Fatalf("get tagged route: %v", err)
+	}
+	if !slices.Equal(route.GetDestinationCIDRs(), []string{"172.16.0.11/32", "fd00::1:2/128"}) {
+		t.Fatalf("unexpected tagged route cidrs: %v", route.GetDestinationCIDRs())
+	}
+
+	acls := types.NetworkACLs{
+		{NetworkACL: &v1.NetworkACL{Name: "web", DestinationCIDRs: []string{types.TagReference + "web"}}},
+		{NetworkACL: &v1.NetworkACL{Name: "db", DestinationCIDRs: []string{types.TagReference + "db"}}},
+	}
+	if err := storage.ExpandACLTags(ctx, db.Networking(), acls); err != nil {
+		t.Fatalf("expand acl tags: %v", err)
+	}
+	web := acls[0].DestinationPrefixes()
+	if len(web) != 3 {
+		t.Fatalf("expected tag to expand to 3 prefixes, got %v", web)
+	}
+	// A tag without attachments must not widen the ACL to any destination.
+	if len(acls[1].GetDestinationCIDRs()) != 1 || len(acls[1].DestinationPrefixes()) != 0 {
+		t.Fatalf("unexpected expansion of unknown tag: %v", acls[1].GetDestinationCIDRs())
+	}
+
+	// Releasing the last tagged attachment on a node removes its route.
+	if err := storage.DeleteAttachment(ctx, st, "node-a", "c2"); err != nil {
+		t.Fatalf("delete attachment: %v", err)
+	}
+	if err := storage.SyncAttachmentRoutes(ctx, st, db.Networking(), "node-a"); err != nil {
+		t.Fatalf("sync attachment routes: %v", err)
+	}
+	routes, err := db.Networking().GetRoutesByNode(ctx, "node-a")
+	if err != nil {
+		t.Fatalf("get routes by node: %v", err)
+	}
+	if len(routes) != 1 || routes[0].GetName() != "node-a"+storage.AttachmentRouteSuffix {
+		t.Fatalf("unexpected routes after release: %v", routes)
+	}
+}
@@ -35,7 +35,7 @@ const (
 	// DefaultIPv4Network is the default IPv4 network for the mesh.
 	DefaultIPv4Network = "172.16.0.0/12"
 	// DefaultNetworkPolicy is the default network policy for the mesh.
-	DefaultNetworkPolicy = "accept"
+	DefaultNetworkPolicy = NetworkPolicyAccept
 	// DefaultBootstrapListenAddress is the default listen address for the bootstrap transport.
 	DefaultBootstrapListenAddress = "[::]:9001"
 	// DefaultBootstrapAdvertiseAddress is the default advertise address for the bootstrap transport.
@@ -65,6 +65,8 @@ type BootstrapOptions struct {
 	Voters []string
 	// DisableRBAC disables RBAC.
 	DisableRBAC bool
+	// ClusterID is the ID of the new cluster. One is generated if left unset.
+	ClusterID string
 }
 
 func (b *BootstrapOptions) Default() {
@@ -90,6 +92,9 @@ type BootstrapResults struct {
 	NetworkV6 netip.Prefix
 	// MeshDomain is the mesh domain.
 	MeshDomain string
+	// ClusterID is the ID of the cluster. It is empty if the database does not
+	// expose its MeshStorage.
+	ClusterID string
 }
 
 // Bootstrap attempts to bootstrap the given database. If data already exists,
@@ -107,9 +112,16 @@ func Bootstrap(ctx context.Context, db MeshDB, opts *BootstrapOptions) (results
 		results.NetworkV4 = state.NetworkV4()
 		results.NetworkV6 = state.NetworkV6()
 		results.MeshDomain = state.Domain()
+		if st := MeshStorageOf(db); st != nil {
+			results.ClusterID, _ = GetClusterID(ctx, st)
+		}
 		return results, errors.ErrAlreadyBootstrapped
 	}
 
+	err = ValidateNetworkPolicy(opts.DefaultNetworkPolicy)
+	if err != nil {
+		return
+	}
 	results.NetworkV4, err = netip.ParsePrefix(opts.IPv4Network)
 	if err != nil {
 		err = fmt.Errorf("parse IPv4 network: %w", err)
@@ -263,19 +275,22 @@ func Bootstrap(ctx context.Context, db MeshDB, opts *BootstrapOptions) (results
 		err = fmt.Errorf("create bootstrap nodes network acl: %w", err)
 		return
 	}
-	// Apply a default accept policy if configured
-	if opts.DefaultNetworkPolicy == "accept" {
-		err = nw.PutNetworkACL(ctx, meshtypes.NetworkACL{NetworkACL: &v1.NetworkACL{
-			Name:             "default-accept",
-			Priority:         math.MinInt32,
-			SourceNodes:      []string{"*"},
-			DestinationNodes: []string{"*"},
-			SourceCIDRs:      []string{"*"},
-			DestinationCIDRs: []string{"*"},
-			Action:           v1.ACLAction_ACTION_ACCEPT,
-		}})
+	// Apply the default network policy with an explicit catch-all ACL and
+	// record it so later migrations know where they start from.
+	err = putDefaultNetworkPolicyACL(ctx, nw, opts.DefaultNetworkPolicy)
+	if err != nil {
+		err = fmt.Errorf("create default network policy ACL: %w", err)
+		return
+	}
+	if st := MeshStorageOf(db); st != nil {
+		err = st.PutValue(ctx, DefaultNetworkPolicyKey, []byte(opts.DefaultNetworkPolicy), 0)
+		if err != nil {
+			err = fmt.Errorf("put default network policy: %w", err)
+			return
+		}
+		results.ClusterID, err = putClusterID(ctx, st, opts.ClusterID)
 		if err != nil {
-			err = fmt.Errorf("create default accept network ACL: %w", err)
+			err = fmt.Errorf("put cluster id: %w", err)
 			return
 		}
 	}
@@ -0,0 +1,176 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+const (
+	// NetworkPolicyAccept accepts traffic that no network ACL matches.
+	NetworkPolicyAccept = "accept"
+	// NetworkPolicyDrop drops traffic that no network ACL matches.
+	NetworkPolicyDrop = "drop"
+	// DefaultAcceptNetworkACLName is the name of the catch-all network ACL
+	// enforcing the accept policy.
+	DefaultAcceptNetworkACLName = "default-accept"
+	// DefaultDenyNetworkACLName is the name of the catch-all network ACL
+	// enforcing the drop policy.
+	DefaultDenyNetworkACLName = "default-deny"
+)
+
+// DefaultNetworkPolicyKey is where the default network policy of the mesh is
+// recorded in the database.
+var DefaultNetworkPolicyKey = types.RegistryPrefix.ForString("default-network-policy")
+
+// ValidateNetworkPolicy returns an error if the given default network policy
+// is not one of NetworkPolicyAccept or NetworkPolicyDrop.
+func ValidateNetworkPolicy(policy string) error {
+	switch policy {
+	case NetworkPolicyAccept, NetworkPolicyDrop:
+		return nil
+	default:
+		return fmt.Errorf("invalid default network policy %q, must be %q or %q", policy, NetworkPolicyAccept, NetworkPolicyDrop)
+	}
+}
+
+// GetDefaultNetworkPolicy returns the default network policy of the mesh. Meshes
+// bootstrapped before the policy was recorded report the policy implied by the
+// presence of the default accept network ACL.
+func GetDefaultNetworkPolicy(ctx context.Context, db MeshDB, st MeshStorage) (string, error) {
+	data, err := st.GetValue(ctx, DefaultNetworkPolicyKey)
+	if err == nil {
+		return string(data), nil
+	}
+	if !errors.IsKeyNotFound(err) {
+		return "", fmt.Errorf("get default network policy: %w", err)
+	}
+	_, err = db.Networking().GetNetworkACL(ctx, DefaultAcceptNetworkACLName)
+	if err == nil {
+		return NetworkPolicyAccept, nil
+	}
+	if !errors.IsACLNotFound(err) {
+		return "", fmt.Errorf("get network acl %s: %w", DefaultAcceptNetworkACLName, err)
+	}
+	return NetworkPolicyDrop, nil
+}
+
+// SetDefaultNetworkPolicy migrates the mesh to the given default network policy.
+// The policy is enforced by an explicit catch-all network ACL at the lowest
+// priority, so that it is visible alongside the rest of the network ACLs. The
+// catch-all of the previous policy is removed before the new one is created, so
+// traffic is never accepted by both while the migration is in progress.
+func SetDefaultNetworkPolicy(ctx context.Context, db MeshDB, st MeshStorage, policy string) error {
+	if err := ValidateNetworkPolicy(policy); err != nil {
+		return err
+	}
+	if err := putDefaultNetworkPolicyACL(ctx, db.Networking(), policy); err != nil {
+		return err
+	}
+	if err := st.PutValue(ctx, DefaultNetworkPolicyKey, []byte(policy), 0); err != nil {
+		return fmt.Errorf("put default network policy: %w", err)
+	}
+	return nil
+}
+
+// DefaultNetworkPolicyFor returns the default network policy recorded for the
+// given database. An empty policy is returned if none was recorded or the
+// database does not expose its underlying storage, in which case the policy
+// is whatever the existing catch-all ACLs implement.
+func DefaultNetworkPolicyFor(ctx context.Context, db MeshDB) (string, error) {
+	st := MeshStorageOf(db)
+	if st == nil {
+		return "", nil
+	}
+	data, err := st.GetValue(ctx, DefaultNetworkPolicyKey)
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("get default network policy: %w", err)
+	}
+	return string(data), nil
+}
+
+// ApplyDefaultNetworkPolicy returns the given network ACLs with the default
+// network policy of the mesh enforced. The catch-all ACL of the policy is
+// added if it was removed or is inactive, and a catch-all left over from the
+// other policy is dropped. This ensures that ACL evaluation follows the
+// policy recorded in the mesh state rather than whichever catch-all ACLs
+// happen to exist. The ACLs are returned unchanged if policy is empty.
+func ApplyDefaultNetworkPolicy(acls types.NetworkACLs, policy string) types.NetworkACLs {
+	if policy == "" {
+		return acls
+	}
+	catchAll, stale := defaultNetworkPolicyACL(policy)
+	out := make(types.NetworkACLs, 0, len(acls)+1)
+	var found bool
+	for _, acl := range acls {
+		switch acl.GetName() {
+		case stale:
+			continue
+		case catchAll.GetName():
+			found = true
+		}
+		out = append(out, acl)
+	}
+	if !found {
+		out = append(out, types.NetworkACL{NetworkACL: catchAll})
+	}
+	return out
+}
+
+// defaultNetworkPolicyACL returns the catch-all network ACL enforcing the given
+// policy and the name of the catch-all of the other policy.
+func defaultNetworkPolicyACL(policy string) (acl *v1.NetworkACL, stale string) {
+	acl = &v1.NetworkACL{
+		Name:             DefaultAcceptNetworkACLName,
+		Priority:         math.MinInt32,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+		SourceCIDRs:      []string{"*"},
+		DestinationCIDRs: []string{"*"},
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+	}
+	stale = DefaultDenyNetworkACLName
+	if policy == NetworkPolicyDrop {
+		acl.Name = DefaultDenyNetworkACLName
+		acl.Action = v1.ACLAction_ACTION_DENY
+		stale = DefaultAcceptNetworkACLName
+	}
+	return acl, stale
+}
+
+// putDefaultNetworkPolicyACL replaces the catch-all network ACL with the one
+// enforcing the given policy.
+func putDefaultNetworkPolicyACL(ctx context.Context, nw Networking, policy string) error {
+	acl, stale := defaultNetworkPolicyACL(policy)
+	if err := nw.DeleteNetworkACL(ctx, stale); err != nil {
+		return fmt.Errorf("delete network acl %s: %w", stale, err)
+	}
+	if err := nw.PutNetworkACL(ctx, types.NetworkACL{NetworkACL: acl}); err != nil {
+		return fmt.Errorf("put network acl %s: %w", acl.Name, err)
+	}
+	return nil
+}
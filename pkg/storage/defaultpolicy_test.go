@@ -0,0 +1,163 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestDefaultNetworkPolicy(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+
+	_, err := storage.Bootstrap(ctx, db, &storage.BootstrapOptions{DefaultNetworkPolicy: storage.NetworkPolicyDrop})
+	if err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	expectPolicy := func(want string) {
+		t.Helper()
+		policy, err := storage.GetDefaultNetworkPolicy(ctx, db, st)
+		if err != nil {
+			t.Fatalf("get default network policy: %v", err)
+		}
+		if policy != want {
+			t.Fatalf("expected default network policy %q, got %q", want, policy)
+		}
+		present, absent := storage.DefaultDenyNetworkACLName, storage.DefaultAcceptNetworkACLName
+		action := v1.ACLAction_ACTION_DENY
+		if want == storage.NetworkPolicyAccept {
+			present, absent = absent, present
+			action = v1.ACLAction_ACTION_ACCEPT
+		}
+		acl, err := db.Networking().GetNetworkACL(ctx, present)
+		if err != nil {
+			t.Fatalf("expected network acl %s: %v", present, err)
+		}
+		if acl.GetAction() != action {
+			t.Fatalf("expected %s to %s, got %s", present, action, acl.GetAction())
+		}
+		if _, err := db.Networking().GetNetworkACL(ctx, absent); !errors.IsACLNotFound(err) {
+			t.Fatalf("expected network acl %s to be removed, got %v", absent, err)
+		}
+	}
+	expectPolicy(storage.NetworkPolicyDrop)
+
+	// Only the system ACLs exist in a default-deny mesh.
+	acls, err := db.Networking().ListNetworkACLs(ctx)
+	if err != nil {
+		t.Fatalf("list network acls: %v", err)
+	}
+	for _, acl := range acls {
+		if !storage.IsSystemNetworkACL(acl.GetName()) {
+			t.Fatalf("expected only system network acls after bootstrap, got %s", acl.GetName())
+		}
+	}
+
+	// Migrate in both directions.
+	if err := storage.SetDefaultNetworkPolicy(ctx, db, st, storage.NetworkPolicyAccept); err != nil {
+		t.Fatalf("set default network policy: %v", err)
+	}
+	expectPolicy(storage.NetworkPolicyAccept)
+	if err := storage.SetDefaultNetworkPolicy(ctx, db, st, storage.NetworkPolicyDrop); err != nil {
+		t.Fatalf("set default network policy: %v", err)
+	}
+	expectPolicy(storage.NetworkPolicyDrop)
+
+	if err := storage.SetDefaultNetworkPolicy(ctx, db, st, "allow"); err == nil {
+		t.Fatal("expected error for invalid policy")
+	}
+
+	// Meshes that never recorded a policy infer it from the catch-all ACL.
+	if err := storage.SetDefaultNetworkPolicy(ctx, db, st, storage.NetworkPolicyAccept); err != nil {
+		t.Fatalf("set default network policy: %v", err)
+	}
+	if err := st.Delete(ctx, storage.DefaultNetworkPolicyKey); err != nil {
+		t.Fatalf("delete default network policy: %v", err)
+	}
+	expectPolicy(storage.NetworkPolicyAccept)
+}
+
+func TestApplyDefaultNetworkPolicy(t *testing.T) {
+	t.Parallel()
+	acl := func(name string, action v1.ACLAction) types.NetworkACL {
+		return types.NetworkACL{NetworkACL: &v1.NetworkACL{Name: name, Action: action}}
+	}
+	allowWeb := acl("allow-web", v1.ACLAction_ACTION_ACCEPT)
+	defaultAccept := acl(storage.DefaultAcceptNetworkACLName, v1.ACLAction_ACTION_ACCEPT)
+	defaultDeny := acl(storage.DefaultDenyNetworkACLName, v1.ACLAction_ACTION_DENY)
+
+	tc := []struct {
+		name   string
+		acls   types.NetworkACLs
+		policy string
+		want   []string
+	}{
+		{
+			name:   "no recorded policy",
+			acls:   types.NetworkACLs{allowWeb},
+			policy: "",
+			want:   []string{"allow-web"},
+		},
+		{
+			name:   "catch-all present",
+			acls:   types.NetworkACLs{allowWeb, defaultDeny},
+			policy: storage.NetworkPolicyDrop,
+			want:   []string{"allow-web", storage.DefaultDenyNetworkACLName},
+		},
+		{
+			name:   "catch-all removed",
+			acls:   types.NetworkACLs{allowWeb},
+			policy: storage.NetworkPolicyAccept,
+			want:   []string{"allow-web", storage.DefaultAcceptNetworkACLName},
+		},
+		{
+			name:   "stale catch-all",
+			acls:   types.NetworkACLs{allowWeb, defaultAccept},
+			policy: storage.NetworkPolicyDrop,
+			want:   []string{"allow-web", storage.DefaultDenyNetworkACLName},
+		},
+	}
+	for _, tt := range tc {
+		got := storage.ApplyDefaultNetworkPolicy(tt.acls, tt.policy)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected %d network acls, got %d", tt.name, len(tt.want), len(got))
+			continue
+		}
+		for i, name := range tt.want {
+			if got[i].GetName() != name {
+				t.Errorf("%s: expected network acl %d to be %s, got %s", tt.name, i, name, got[i].GetName())
+			}
+		}
+	}
+	// The enforced catch-all denies in default-deny mode.
+	got := storage.ApplyDefaultNetworkPolicy(types.NetworkACLs{defaultAccept}, storage.NetworkPolicyDrop)
+	if got[0].GetAction() != v1.ACLAction_ACTION_DENY {
+		t.Errorf("expected the enforced catch-all to deny, got %s", got[0].GetAction())
+	}
+}
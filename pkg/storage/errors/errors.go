@@ -27,6 +27,9 @@ import (
 // Is is a shortcut for errors.Is.
 var Is = errors.Is
 
+// As is a shortcut for errors.As.
+var As = errors.As
+
 // Common errors for storage providers to use.
 var (
 	// ErrNodeNotFound is returned when a node is not found.
@@ -37,6 +40,9 @@ var (
 	ErrStarted = fmt.Errorf("storage provider already started")
 	// ErrClosed is returned when the storage provider is closed.
 	ErrClosed = fmt.Errorf("storage provider is closed")
+	// ErrCorrupted is returned when reads are refused because local data
+	// failed verification.
+	ErrCorrupted = errors.New("local storage data is corrupt")
 	// ErrNotImplemented is returned when a method is not implemented.
 	ErrNotImplemented = fmt.Errorf("not implemented")
 	// ErrNoLeader is returned when there is no leader.
@@ -45,6 +51,12 @@ var (
 	ErrNotLeader = fmt.Errorf("not leader")
 	// ErrNotVoter is returned when the node is not a voter.
 	ErrNotVoter = fmt.Errorf("not voter")
+	// ErrIsLearner is returned when a learner is promoted to a voter.
+	ErrIsLearner = fmt.Errorf("node is a learner and cannot be promoted to voter")
+	// ErrNoHealthyVoter is returned when there is no healthy voter to transfer leadership to.
+	ErrNoHealthyVoter = errors.New("no healthy voter to transfer leadership to")
+	// ErrLeadershipNotTransferred is returned when a leadership transfer could not be confirmed.
+	ErrLeadershipNotTransferred = errors.New("leadership transfer was not confirmed")
 	// ErrAlreadyBootstrapped is returned when the storage provider is already bootstrapped.
 	ErrAlreadyBootstrapped = fmt.Errorf("already bootstrapped")
 	// ErrKeyNotFound is the error returned when a key is not found.
@@ -83,6 +95,9 @@ var (
 	ErrInvalidNodeID = errors.New("node ID is invalid")
 	// ErrInvalidQuery is returned when a query is invalid.
 	ErrInvalidQuery = errors.New("invalid query")
+	// ErrVersionConflict is returned when a compare-and-swap finds a different
+	// version of a key than the one expected.
+	ErrVersionConflict = errors.New("version conflict")
 )
 
 // NewKeyNotFoundError returns a new ErrKeyNotFound error.
@@ -161,3 +176,8 @@ func IsGroupNotFound(err error) bool {
 func IsNoLeader(err error) bool {
 	return Is(err, ErrNoLeader)
 }
+
+// IsVersionConflict returns true if the given error is a ErrVersionConflict error.
+func IsVersionConflict(err error) bool {
+	return Is(err, ErrVersionConflict)
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// EscrowPrefix is where escrowed node keys are stored in the database.
+// Wrapped keys are indexed by node ID in the format /registry/escrow/<id>.
+var EscrowPrefix = types.RegistryPrefix.ForString("escrow")
+
+// RevokedKeysPrefix is where revoked node keys are stored in the database.
+// Revocations are indexed by key ID in the format /registry/revoked-keys/<key-id>.
+// The value is the RFC3339 timestamp of the revocation.
+var RevokedKeysPrefix = types.RegistryPrefix.ForString("revoked-keys")
+
+// IsKeyRevoked returns true if the given public key has been revoked.
+func IsKeyRevoked(ctx context.Context, st MeshStorage, key crypto.PublicKey) (bool, error) {
+	_, err := st.GetValue(ctx, RevokedKeysPrefix.ForString(key.ID()))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// RevokeKey marks the given public key as revoked.
+func RevokeKey(ctx context.Context, st MeshStorage, key crypto.PublicKey) error {
+	return st.PutValue(ctx, RevokedKeysPrefix.ForString(key.ID()), []byte(time.Now().UTC().Format(time.RFC3339)), 0)
+}
+
+// KeyEscrow stores node identity keys wrapped to the organizational recovery
+// key. The default implementation keeps them in the mesh database under
+// EscrowPrefix. Applications embedding the node can provide an implementation
+// backed by an external secret store instead.
+type KeyEscrow interface {
+	// PutEscrowedKey stores the wrapped key for the given node, replacing
+	// any existing one.
+	PutEscrowedKey(ctx context.Context, nodeID types.NodeID, wrapped []byte) error
+	// GetEscrowedKey returns the wrapped key for the given node. It returns
+	// errors.ErrKeyNotFound if no key is escrowed for the node.
+	GetEscrowedKey(ctx context.Context, nodeID types.NodeID) ([]byte, error)
+}
+
+// NewKeyEscrow returns a KeyEscrow that stores wrapped keys in the mesh
+// database.
+func NewKeyEscrow(st MeshStorage) KeyEscrow {
+	return &meshKeyEscrow{st}
+}
+
+type meshKeyEscrow struct {
+	st MeshStorage
+}
+
+func (e *meshKeyEscrow) PutEscrowedKey(ctx context.Context, nodeID types.NodeID, wrapped []byte) error {
+	return e.st.PutValue(ctx, EscrowPrefix.ForString(nodeID.String()), wrapped, 0)
+}
+
+func (e *meshKeyEscrow) GetEscrowedKey(ctx context.Context, nodeID types.NodeID) ([]byte, error) {
+	return e.st.GetValue(ctx, EscrowPrefix.ForString(nodeID.String()))
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// FuzzExpandACL checks that group references in ACLs received from other
+// members are expanded to the group's subjects and nothing else.
+func FuzzExpandACL(f *testing.F) {
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	f.Cleanup(func() { db.Close() })
+	err := db.RBAC().PutGroup(ctx, types.Group{Group: &v1.Group{
+		Name: "admins",
+		Subjects: []*v1.Subject{
+			{Name: "node-a", Type: v1.SubjectType_SUBJECT_NODE},
+			{Name: "node-b", Type: v1.SubjectType_SUBJECT_NODE},
+		},
+	}})
+	if err != nil {
+		f.Fatalf("put group: %v", err)
+	}
+	f.Add("group:admins,node-c", "*")
+	f.Add("group:missing", "group:admins,group:admins")
+	f.Add("", "group:,group:group:admins")
+	f.Fuzz(func(t *testing.T, src, dst string) {
+		acl := types.NetworkACL{NetworkACL: &v1.NetworkACL{
+			Name:             "fuzz",
+			SourceNodes:      strings.Split(src, ","),
+			DestinationNodes: strings.Split(dst, ","),
+		}}
+		if err := storage.ExpandACL(ctx, db.RBAC(), acl); err != nil {
+			return
+		}
+		for input, expanded := range map[string][]string{src: acl.GetSourceNodes(), dst: acl.GetDestinationNodes()} {
+			for _, node := range strings.Split(input, ",") {
+				if node == "group:admins" {
+					if !slices.Contains(expanded, "node-a") || !slices.Contains(expanded, "node-b") {
+						t.Fatalf("expected %q to expand to the group subjects, got %v", input, expanded)
+					}
+					continue
+				}
+				if !strings.HasPrefix(node, types.GroupReference) && !slices.Contains(expanded, node) {
+					t.Fatalf("expected %q to be kept when expanding %q, got %v", node, input, expanded)
+				}
+			}
+			for _, node := range expanded {
+				if strings.HasPrefix(node, types.GroupReference) {
+					t.Fatalf("expected no group references after expanding %q, got %v", input, expanded)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// GatewaysPrefix is where nodes acting as gateways for their routes are
+// recorded in the database. Records are indexed by node ID in the format
+// /registry/gateways/<id>.
+var GatewaysPrefix = types.RegistryPrefix.ForString("gateways")
+
+// GatewayHeader is the gRPC metadata header a node sets on join and update
+// requests to report whether it acts as a gateway for its routes.
+const GatewayHeader = "x-webmesh-gateway"
+
+// GatewayEdgeWeight is the weight of edges to and from gateways. Edges between
+// public peers are otherwise weighted heavily to discourage routing through
+// them.
+const GatewayEdgeWeight = 1
+
+// WithGateway appends the gateway header to the outgoing context of a join or
+// update request.
+func WithGateway(ctx context.Context, gateway bool) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, GatewayHeader, strconv.FormatBool(gateway))
+}
+
+// GatewayFromRequest returns whether the caller reported itself as a gateway
+// in the incoming context of a join or update request, and whether it reported
+// anything at all.
+func GatewayFromRequest(ctx context.Context) (gateway bool, reported bool, err error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false, false, nil
+	}
+	values := md.Get(GatewayHeader)
+	if len(values) == 0 {
+		return false, false, nil
+	}
+	gateway, err = strconv.ParseBool(values[0])
+	if err != nil {
+		return false, false, fmt.Errorf("invalid %s header: %w", GatewayHeader, err)
+	}
+	return gateway, true, nil
+}
+
+// IsGateway returns true if the given node acts as a gateway.
+func IsGateway(ctx context.Context, st MeshStorage, nodeID types.NodeID) (bool, error) {
+	_, err := st.GetValue(ctx, GatewaysPrefix.ForString(nodeID.String()))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// SetGateway records whether the given node acts as a gateway.
+func SetGateway(ctx context.Context, st MeshStorage, nodeID types.NodeID, gateway bool) error {
+	if !gateway {
+		return st.Delete(ctx, GatewaysPrefix.ForString(nodeID.String()))
+	}
+	return st.PutValue(ctx, GatewaysPrefix.ForString(nodeID.String()), []byte("true"), 0)
+}
+
+// PreferGatewayEdges lowers every edge to and from the given gateway to the
+// GatewayEdgeWeight so that peers prefer reaching it directly.
+func PreferGatewayEdges(ctx context.Context, peers Peers, nodeID types.NodeID) error {
+	edges, err := peers.Graph().Edges()
+	if err != nil {
+		return fmt.Errorf("list edges: %w", err)
+	}
+	for _, edge := range edges {
+		if edge.Source != nodeID && edge.Target != nodeID {
+			continue
+		}
+		if edge.Properties.Weight <= GatewayEdgeWeight {
+			continue
+		}
+		meshEdge := types.Edge(edge).ToMeshEdge(edge.Source, edge.Target)
+		meshEdge.Weight = GatewayEdgeWeight
+		if err := peers.PutEdge(ctx, meshEdge); err != nil {
+			return fmt.Errorf("put edge %s -> %s: %w", edge.Source, edge.Target, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestGateways(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+
+	for _, id := range []string{"gw", "a", "b"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:        id,
+			PublicKey: generateEncodedKey(t),
+		}})
+		if err != nil {
+			t.Fatalf("put node: %v", err)
+		}
+	}
+	for _, edge := range []*v1.MeshEdge{
+		{Source: "a", Target: "gw", Weight: 99},
+		{Source: "gw", Target: "b", Weight: 99},
+		{Source: "a", Target: "b", Weight: 99},
+	} {
+		if err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: edge}); err != nil {
+			t.Fatalf("put edge: %v", err)
+		}
+	}
+
+	if err := storage.SetGateway(ctx, st, "gw", true); err != nil {
+		t.Fatalf("set gateway: %v", err)
+	}
+	for id, want := range map[types.NodeID]bool{"gw": true, "a": false} {
+		got, err := storage.IsGateway(ctx, st, id)
+		if err != nil {
+			t.Fatalf("check gateway: %v", err)
+		}
+		if got != want {
+			t.Errorf("expected gateway status of %s to be %v, got %v", id, want, got)
+		}
+	}
+	if err := storage.PreferGatewayEdges(ctx, db.Peers(), "gw"); err != nil {
+		t.Fatalf("prefer gateway edges: %v", err)
+	}
+	for _, edge := range []struct {
+		from, to types.NodeID
+		want     int32
+	}{
+		{"a", "gw", storage.GatewayEdgeWeight},
+		{"gw", "b", storage.GatewayEdgeWeight},
+		{"a", "b", 99},
+	} {
+		got, err := db.Peers().GetEdge(ctx, edge.from, edge.to)
+		if err != nil {
+			t.Fatalf("get edge: %v", err)
+		}
+		if got.GetWeight() != edge.want {
+			t.Errorf("expected edge %s -> %s to have weight %d, got %d", edge.from, edge.to, edge.want, got.GetWeight())
+		}
+	}
+
+	if err := storage.SetGateway(ctx, st, "gw", false); err != nil {
+		t.Fatalf("clear gateway: %v", err)
+	}
+	if ok, err := storage.IsGateway(ctx, st, "gw"); err != nil || ok {
+		t.Fatalf("expected gw to no longer be a gateway, got %v %v", ok, err)
+	}
+
+	t.Run("Header", func(t *testing.T) {
+		md, _ := metadata.FromOutgoingContext(storage.WithGateway(context.Background(), true))
+		gateway, reported, err := storage.GatewayFromRequest(metadata.NewIncomingContext(context.Background(), md))
+		if err != nil || !reported || !gateway {
+			t.Fatalf("expected reported gateway, got %v %v %v", gateway, reported, err)
+		}
+		_, reported, err = storage.GatewayFromRequest(context.Background())
+		if err != nil || reported {
+			t.Fatalf("expected nothing reported, got %v %v", reported, err)
+		}
+		bad := metadata.NewIncomingContext(context.Background(), metadata.Pairs(storage.GatewayHeader, "maybe"))
+		if _, _, err := storage.GatewayFromRequest(bad); err == nil {
+			t.Fatal("expected error for invalid header")
+		}
+	})
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// MembershipHistoryPrefix is where membership churn events are recorded in the database.
+// Events are indexed by time and node ID in the format
+// /registry/membership-history/<unix-nanos>-<id>, so that they sort chronologically.
+var MembershipHistoryPrefix = types.RegistryPrefix.ForString("membership-history")
+
+// MembershipEventType is the type of a membership event.
+type MembershipEventType string
+
+const (
+	// MembershipEventJoin is recorded when a new node joins the mesh.
+	MembershipEventJoin MembershipEventType = "join"
+	// MembershipEventLeave is recorded when a node leaves the mesh.
+	MembershipEventLeave MembershipEventType = "leave"
+	// MembershipEventEvict is recorded when a node is removed from the mesh
+	// without asking to leave.
+	MembershipEventEvict MembershipEventType = "evict"
+	// MembershipEventRename is recorded when a node is renamed. The event is
+	// recorded under the old ID of the node.
+	MembershipEventRename MembershipEventType = "rename"
+	// MembershipEventCollision is recorded when a node attempts to join with
+	// an ID registered to a different key. The reason holds the outcome of
+	// the configured collision policy.
+	MembershipEventCollision MembershipEventType = "collision"
+)
+
+// IsValid returns true if the event type is valid.
+func (t MembershipEventType) IsValid() bool {
+	switch t {
+	case MembershipEventJoin, MembershipEventLeave, MembershipEventEvict, MembershipEventRename, MembershipEventCollision:
+		return true
+	}
+	return false
+}
+
+// MembershipEvent is a single entry in the membership history.
+type MembershipEvent struct {
+	// Type is the type of the event.
+	Type MembershipEventType `json:"type"`
+	// NodeID is the ID of the node that joined or left.
+	NodeID types.NodeID `json:"nodeID"`
+	// Time is when the event occurred.
+	Time time.Time `json:"time"`
+	// Reason is a human readable reason for the event.
+	Reason string `json:"reason,omitempty"`
+	// Actor is the node that processed the event, usually the leader.
+	Actor types.NodeID `json:"actor,omitempty"`
+}
+
+// MembershipEventFilter filters the events returned from ListMembershipEvents.
+// Zero values match all events.
+type MembershipEventFilter struct {
+	// NodeID only matches events for the given node.
+	NodeID types.NodeID
+	// Type only matches events of the given type.
+	Type MembershipEventType
+	// Since only matches events at or after the given time.
+	Since time.Time
+	// Until only matches events before the given time.
+	Until time.Time
+}
+
+// Matches returns true if the event matches the filter.
+func (f MembershipEventFilter) Matches(ev MembershipEvent) bool {
+	if f.NodeID != "" && ev.NodeID != f.NodeID {
+		return false
+	}
+	if f.Type != "" && ev.Type != f.Type {
+		return false
+	}
+	if !f.Since.IsZero() && ev.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && !ev.Time.Before(f.Until) {
+		return false
+	}
+	return true
+}
+
+// MembershipHistoryRetention are the limits applied when compacting the membership history.
+// Zero values disable the respective limit.
+type MembershipHistoryRetention struct {
+	// MaxAge is the maximum age of an event before it is removed.
+	MaxAge time.Duration
+	// MaxEvents is the maximum number of events to retain. The oldest
+	// events are removed first.
+	MaxEvents int
+}
+
+// IsZero returns true if no retention limits are set.
+func (r MembershipHistoryRetention) IsZero() bool {
+	return r.MaxAge <= 0 && r.MaxEvents <= 0
+}
+
+// RecordMembershipEvent records the given event in the membership history. If the
+// event time is unset it is set to the current time.
+func RecordMembershipEvent(ctx context.Context, st MeshStorage, ev MembershipEvent) error {
+	if !ev.Type.IsValid() {
+		return fmt.Errorf("invalid membership event type %q", ev.Type)
+	}
+	if ev.NodeID == "" {
+		return fmt.Errorf("membership event node id is required")
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+	ev.Time = ev.Time.UTC()
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return fmt.Errorf("marshal membership event: %w", err)
+	}
+	return st.PutValue(ctx, membershipEventKey(ev), data, 0)
+}
+
+// ListMembershipEvents returns the recorded membership events matching the given
+// filter in chronological order.
+func ListMembershipEvents(ctx context.Context, st MeshStorage, filter MembershipEventFilter) ([]MembershipEvent, error) {
+	events, err := listMembershipEvents(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]MembershipEvent, 0, len(events))
+	for _, ev := range events {
+		if filter.Matches(ev) {
+			out = append(out, ev)
+		}
+	}
+	return out, nil
+}
+
+// CompactMembershipHistory removes events from the membership history that fall
+// outside of the given retention limits. It returns the number of events removed.
+func CompactMembershipHistory(ctx context.Context, st MeshStorage, retention MembershipHistoryRetention) (int, error) {
+	if retention.IsZero() {
+		return 0, nil
+	}
+	events, err := listMembershipEvents(ctx, st)
+	if err != nil {
+		return 0, err
+	}
+	var expired int
+	if retention.MaxAge > 0 {
+		cutoff := time.Now().Add(-retention.MaxAge)
+		for expired < len(events) && events[expired].Time.Before(cutoff) {
+			expired++
+		}
+	}
+	if retention.MaxEvents > 0 && len(events)-expired > retention.MaxEvents {
+		expired = len(events) - retention.MaxEvents
+	}
+	for i, ev := range events[:expired] {
+		if err := st.Delete(ctx, membershipEventKey(ev)); err != nil {
+			return i, fmt.Errorf("delete membership event: %w", err)
+		}
+	}
+	return expired, nil
+}
+
+func listMembershipEvents(ctx context.Context, st MeshStorage) ([]MembershipEvent, error) {
+	var events []MembershipEvent
+	err := st.IterPrefix(ctx, append(MembershipHistoryPrefix, '/'), func(key, value []byte) error {
+		var ev MembershipEvent
+		if err := json.Unmarshal(value, &ev); err != nil {
+			return fmt.Errorf("unmarshal membership event %s: %w", key, err)
+		}
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.Before(events[j].Time) })
+	return events, nil
+}
+
+func membershipEventKey(ev MembershipEvent) []byte {
+	return MembershipHistoryPrefix.ForString(fmt.Sprintf("%020d-%s", ev.Time.UnixNano(), ev.NodeID))
+}
@@ -0,0 +1,98 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+func TestMembershipHistory(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+
+	now := time.Now()
+	events := []storage.MembershipEvent{
+		{Type: storage.MembershipEventJoin, NodeID: "node-a", Time: now.Add(-3 * time.Hour)},
+		{Type: storage.MembershipEventJoin, NodeID: "node-b", Time: now.Add(-2 * time.Hour)},
+		{Type: storage.MembershipEventEvict, NodeID: "node-a", Time: now.Add(-time.Hour), Reason: "failed heartbeats"},
+		{Type: storage.MembershipEventLeave, NodeID: "node-b", Time: now},
+	}
+	for _, ev := range events {
+		if err := storage.RecordMembershipEvent(ctx, st, ev); err != nil {
+			t.Fatalf("record event: %v", err)
+		}
+	}
+	if err := storage.RecordMembershipEvent(ctx, st, storage.MembershipEvent{Type: "bogus", NodeID: "node-a"}); err == nil {
+		t.Fatal("expected error recording invalid event type")
+	}
+
+	all, err := storage.ListMembershipEvents(ctx, st, storage.MembershipEventFilter{})
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if len(all) != len(events) {
+		t.Fatalf("expected %d events, got %d", len(events), len(all))
+	}
+	for i, ev := range all {
+		if ev.NodeID != events[i].NodeID || ev.Type != events[i].Type {
+			t.Fatalf("event %d: expected %s %s, got %s %s", i, events[i].Type, events[i].NodeID, ev.Type, ev.NodeID)
+		}
+	}
+	nodeA, err := storage.ListMembershipEvents(ctx, st, storage.MembershipEventFilter{NodeID: "node-a"})
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if len(nodeA) != 2 || nodeA[1].Reason != "failed heartbeats" {
+		t.Fatalf("expected 2 events for node-a ending in eviction, got %+v", nodeA)
+	}
+	recent, err := storage.ListMembershipEvents(ctx, st, storage.MembershipEventFilter{Since: now.Add(-90 * time.Minute)})
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if len(recent) != 2 {
+		t.Fatalf("expected 2 recent events, got %d", len(recent))
+	}
+
+	removed, err := storage.CompactMembershipHistory(ctx, st, storage.MembershipHistoryRetention{MaxAge: 150 * time.Minute})
+	if err != nil {
+		t.Fatalf("compact history: %v", err)
+	}
+	if removed != 1 {
+		t.Fatalf("expected 1 event removed by age, got %d", removed)
+	}
+	removed, err = storage.CompactMembershipHistory(ctx, st, storage.MembershipHistoryRetention{MaxEvents: 1})
+	if err != nil {
+		t.Fatalf("compact history: %v", err)
+	}
+	if removed != 2 {
+		t.Fatalf("expected 2 events removed by count, got %d", removed)
+	}
+	all, err = storage.ListMembershipEvents(ctx, st, storage.MembershipEventFilter{})
+	if err != nil {
+		t.Fatalf("list events: %v", err)
+	}
+	if len(all) != 1 || all[0].Type != storage.MembershipEventLeave {
+		t.Fatalf("expected only the leave event to remain, got %+v", all)
+	}
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/netip"
+	"sort"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// InvariantViolation describes data in the mesh database that breaks one of
+// its invariants.
+type InvariantViolation struct {
+	// Invariant is the name of the invariant that does not hold.
+	Invariant string
+	// Detail describes the offending data.
+	Detail string
+}
+
+// Error implements the error interface.
+func (v InvariantViolation) Error() string {
+	return fmt.Sprintf("invariant %q violated: %s", v.Invariant, v.Detail)
+}
+
+// Names of the invariants checked by CheckInvariants.
+const (
+	// InvariantEdgeNodes requires edges to reference existing nodes.
+	InvariantEdgeNodes = "edge-nodes"
+	// InvariantUniqueLeases requires no two nodes to hold the same address.
+	InvariantUniqueLeases = "unique-leases"
+	// InvariantRouteNodes requires routes to reference existing nodes.
+	InvariantRouteNodes = "route-nodes"
+	// InvariantDecodable requires stored nodes and routes to be decodable.
+	InvariantDecodable = "decodable"
+)
+
+// CheckInvariants checks the referential integrity of the mesh database in the
+// given storage and returns every violation found. It reads the raw keys so
+// that it does not depend on any caches in front of them. An error is only
+// returned if the storage could not be read.
+func CheckInvariants(ctx context.Context, st MeshStorage) ([]InvariantViolation, error) {
+	var violations []InvariantViolation
+	nodes := make(map[string]struct{})
+	leases := make(map[netip.Addr]string)
+	err := st.IterPrefix(ctx, NodesPrefix.For(nil), func(key, value []byte) error {
+		var node types.MeshNode
+		if err := node.UnmarshalProtoJSON(value); err != nil {
+			violations = append(violations, InvariantViolation{
+				Invariant: InvariantDecodable,
+				Detail:    fmt.Sprintf("node %q cannot be decoded: %v", key, err),
+			})
+			return nil
+		}
+		nodes[node.GetId()] = struct{}{}
+		for _, addr := range []string{node.GetPrivateIPv4(), node.GetPrivateIPv6()} {
+			prefix, err := netip.ParsePrefix(addr)
+			if err != nil {
+				continue
+			}
+			if owner, ok := leases[prefix.Addr()]; ok {
+				violations = append(violations, InvariantViolation{
+					Invariant: InvariantUniqueLeases,
+					Detail:    fmt.Sprintf("nodes %q and %q both hold %s", owner, node.GetId(), prefix.Addr()),
+				})
+				continue
+			}
+			leases[prefix.Addr()] = node.GetId()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("iterate nodes: %w", err)
+	}
+	edges, err := st.ListKeys(ctx, EdgesPrefix.For(nil))
+	if err != nil {
+		return nil, fmt.Errorf("list edges: %w", err)
+	}
+	for _, key := range edges {
+		parts := bytes.Split(EdgesPrefix.TrimFrom(key), []byte("/"))
+		if len(parts) != 2 {
+			violations = append(violations, InvariantViolation{
+				Invariant: InvariantEdgeNodes,
+				Detail:    fmt.Sprintf("malformed edge key %q", key),
+			})
+			continue
+		}
+		for _, id := range parts {
+			if _, ok := nodes[string(id)]; !ok {
+				violations = append(violations, InvariantViolation{
+					Invariant: InvariantEdgeNodes,
+					Detail:    fmt.Sprintf("edge %s -> %s references missing node %q", parts[0], parts[1], id),
+				})
+			}
+		}
+	}
+	err = st.IterPrefix(ctx, RoutesPrefix.For(nil), func(key, value []byte) error {
+		var route types.Route
+		if err := route.UnmarshalProtoJSON(value); err != nil {
+			violations = append(violations, InvariantViolation{
+				Invariant: InvariantDecodable,
+				Detail:    fmt.Sprintf("route %q cannot be decoded: %v", key, err),
+			})
+			return nil
+		}
+		if _, ok := nodes[route.GetNode()]; !ok {
+			violations = append(violations, InvariantViolation{
+				Invariant: InvariantRouteNodes,
+				Detail:    fmt.Sprintf("route %q references missing node %q", route.GetName(), route.GetNode()),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("iterate routes: %w", err)
+	}
+	sort.SliceStable(violations, func(i, j int) bool {
+		return violations[i].Invariant < violations[j].Invariant
+	})
+	return violations, nil
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestCheckInvariants(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := meshdb.NewTestDB()
+	defer db.Close()
+	for id, addr := range map[string]string{"node-a": "10.0.0.1/32", "node-b": "10.0.0.2/32"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id, PrivateIPv4: addr}})
+		if err != nil {
+			t.Fatalf("put node: %v", err)
+		}
+	}
+	err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: "node-a", Target: "node-b", Weight: 1}})
+	if err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+	err = db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name:             "route",
+		Node:             "node-a",
+		DestinationCIDRs: []string{"192.168.0.0/24"},
+	}})
+	if err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	violations, err := storage.CheckInvariants(ctx, db.MeshStorage())
+	if err != nil {
+		t.Fatalf("check invariants: %v", err)
+	}
+	if len(violations) != 0 {
+		t.Fatalf("expected no violations, got %v", violations)
+	}
+
+	// Break each invariant by writing raw keys behind the database's back.
+	st := db.MeshStorage()
+	writes := []storage.WriteOp{
+		{Key: storage.EdgesPrefix.ForString("node-a/node-c"), Value: []byte(`{}`)},
+		{Key: storage.NodesPrefix.ForString("node-d"), Value: []byte(`{"id":"node-d","privateIPv4":"10.0.0.1/32"}`)},
+		{Key: storage.RoutesPrefix.ForString("orphan"), Value: []byte(`{"name":"orphan","node":"node-e"}`)},
+		{Key: storage.RoutesPrefix.ForString("garbage"), Value: []byte(`not json`)},
+	}
+	if err := st.WriteBatch(ctx, writes); err != nil {
+		t.Fatalf("write batch: %v", err)
+	}
+	violations, err = storage.CheckInvariants(ctx, st)
+	if err != nil {
+		t.Fatalf("check invariants: %v", err)
+	}
+	got := make(map[string]int)
+	for _, v := range violations {
+		got[v.Invariant]++
+	}
+	want := map[string]int{
+		storage.InvariantEdgeNodes:    1,
+		storage.InvariantUniqueLeases: 1,
+		storage.InvariantRouteNodes:   1,
+		storage.InvariantDecodable:    1,
+	}
+	for invariant, n := range want {
+		if got[invariant] != n {
+			t.Errorf("expected %d %q violations, got %d: %v", n, invariant, got[invariant], violations)
+		}
+	}
+}
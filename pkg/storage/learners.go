@@ -0,0 +1,76 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// LearnersPrefix is where storage learners are recorded in the database.
+// Learners are indexed by node ID in the format /registry/learners/<id>.
+// The value is the RFC3339 timestamp of when the node joined as a learner.
+var LearnersPrefix = types.RegistryPrefix.ForString("learners")
+
+// LearnerHeader is the gRPC metadata header a joining node sets to "true" to
+// join the storage group as a learner.
+const LearnerHeader = "x-webmesh-learner"
+
+// WithLearnerRequest appends the learner header to the outgoing context of a
+// join request.
+func WithLearnerRequest(ctx context.Context) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, LearnerHeader, "true")
+}
+
+// IsLearnerRequest returns true if the incoming context of a join request
+// asks for the learner role.
+func IsLearnerRequest(ctx context.Context) bool {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+	values := md.Get(LearnerHeader)
+	return len(values) > 0 && values[0] == "true"
+}
+
+// IsLearner returns true if the given node joined the storage group as a learner.
+// Learners are permanent non-voters that are never promoted.
+func IsLearner(ctx context.Context, st MeshStorage, nodeID types.NodeID) (bool, error) {
+	_, err := st.GetValue(ctx, LearnersPrefix.ForString(nodeID.String()))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// MarkLearner records the given node as a learner.
+func MarkLearner(ctx context.Context, st MeshStorage, nodeID types.NodeID) error {
+	return st.PutValue(ctx, LearnersPrefix.ForString(nodeID.String()), []byte(time.Now().UTC().Format(time.RFC3339)), 0)
+}
+
+// UnmarkLearner removes the learner record for the given node.
+func UnmarkLearner(ctx context.Context, st MeshStorage, nodeID types.NodeID) error {
+	return st.Delete(ctx, LearnersPrefix.ForString(nodeID.String()))
+}
@@ -0,0 +1,170 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// MaintenancePrefix is where maintenance windows are stored in the database.
+// Windows are indexed by name in the format /registry/maintenance/<name>.
+var MaintenancePrefix = types.RegistryPrefix.ForString("maintenance")
+
+// MaintenanceWindow is a period of time during which automatic actions against
+// nodes, such as reaping nodes that fail heartbeats, promoting nodes to voters,
+// and emitting membership events, are suppressed.
+type MaintenanceWindow struct {
+	// Name is the unique name of the window.
+	Name string `json:"name"`
+	// Nodes are the nodes covered by the window. An empty list covers
+	// the entire mesh.
+	Nodes []types.NodeID `json:"nodes,omitempty"`
+	// Start is when the window begins.
+	Start time.Time `json:"start"`
+	// End is when the window ends.
+	End time.Time `json:"end"`
+	// Reason is a human readable reason for the window.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Validate validates the maintenance window.
+func (w MaintenanceWindow) Validate() error {
+	if w.Name == "" {
+		return fmt.Errorf("maintenance window name is required")
+	}
+	if !types.IsValidID(w.Name) {
+		return fmt.Errorf("maintenance window name %q is invalid", w.Name)
+	}
+	if w.End.IsZero() {
+		return fmt.Errorf("maintenance window end is required")
+	}
+	if !w.Start.IsZero() && !w.End.After(w.Start) {
+		return fmt.Errorf("maintenance window must end after it starts")
+	}
+	for _, node := range w.Nodes {
+		if !node.IsValid() {
+			return fmt.Errorf("invalid node id %q in maintenance window", node)
+		}
+	}
+	return nil
+}
+
+// IsMeshWide returns true if the window covers the entire mesh.
+func (w MaintenanceWindow) IsMeshWide() bool {
+	return len(w.Nodes) == 0
+}
+
+// Covers returns true if the window covers the given node.
+func (w MaintenanceWindow) Covers(nodeID types.NodeID) bool {
+	if w.IsMeshWide() {
+		return true
+	}
+	for _, node := range w.Nodes {
+		if node == nodeID {
+			return true
+		}
+	}
+	return false
+}
+
+// ActiveAt returns true if the window is in effect at the given time.
+func (w MaintenanceWindow) ActiveAt(t time.Time) bool {
+	return !t.Before(w.Start) && t.Before(w.End)
+}
+
+// PutMaintenanceWindow creates or updates a maintenance window. The window is
+// removed from storage once it ends.
+func PutMaintenanceWindow(ctx context.Context, st MeshStorage, w MaintenanceWindow) error {
+	if err := w.Validate(); err != nil {
+		return err
+	}
+	ttl := time.Until(w.End)
+	if ttl <= 0 {
+		return fmt.Errorf("maintenance window %s has already ended", w.Name)
+	}
+	data, err := json.Marshal(w)
+	if err != nil {
+		return fmt.Errorf("marshal maintenance window: %w", err)
+	}
+	return st.PutValue(ctx, MaintenancePrefix.ForString(w.Name), data, ttl)
+}
+
+// GetMaintenanceWindow returns the maintenance window with the given name.
+func GetMaintenanceWindow(ctx context.Context, st MeshStorage, name string) (MaintenanceWindow, error) {
+	var w MaintenanceWindow
+	data, err := st.GetValue(ctx, MaintenancePrefix.ForString(name))
+	if err != nil {
+		return w, err
+	}
+	if err := json.Unmarshal(data, &w); err != nil {
+		return w, fmt.Errorf("unmarshal maintenance window: %w", err)
+	}
+	return w, nil
+}
+
+// DeleteMaintenanceWindow deletes the maintenance window with the given name.
+func DeleteMaintenanceWindow(ctx context.Context, st MeshStorage, name string) error {
+	return st.Delete(ctx, MaintenancePrefix.ForString(name))
+}
+
+// ListMaintenanceWindows returns all maintenance windows that have not ended,
+// ordered by start time.
+func ListMaintenanceWindows(ctx context.Context, st MeshStorage) ([]MaintenanceWindow, error) {
+	now := time.Now()
+	var windows []MaintenanceWindow
+	err := st.IterPrefix(ctx, append(MaintenancePrefix, '/'), func(key, value []byte) error {
+		var w MaintenanceWindow
+		if err := json.Unmarshal(value, &w); err != nil {
+			return fmt.Errorf("unmarshal maintenance window %s: %w", key, err)
+		}
+		// Storage without TTL support may still hold ended windows.
+		if now.Before(w.End) {
+			windows = append(windows, w)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	return windows, nil
+}
+
+// InMaintenance returns true if a maintenance window covering the given node is
+// currently in effect. An empty node ID only matches mesh-wide windows.
+func InMaintenance(ctx context.Context, st MeshStorage, nodeID types.NodeID) (bool, error) {
+	windows, err := ListMaintenanceWindows(ctx, st)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	for _, w := range windows {
+		if !w.ActiveAt(now) {
+			continue
+		}
+		if w.IsMeshWide() || (nodeID != "" && w.Covers(nodeID)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestMaintenanceWindows(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+
+	now := time.Now()
+	inMaintenance := func(id types.NodeID) bool {
+		t.Helper()
+		ok, err := storage.InMaintenance(ctx, st, id)
+		if err != nil {
+			t.Fatalf("check maintenance: %v", err)
+		}
+		return ok
+	}
+	if inMaintenance("node-a") {
+		t.Fatal("expected no maintenance without windows")
+	}
+
+	for _, w := range []storage.MaintenanceWindow{
+		{Name: "site-a", Nodes: []types.NodeID{"node-a"}, Start: now.Add(-time.Minute), End: now.Add(time.Hour)},
+		{Name: "upgrade", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)},
+	} {
+		if err := storage.PutMaintenanceWindow(ctx, st, w); err != nil {
+			t.Fatalf("put maintenance window: %v", err)
+		}
+	}
+	if err := storage.PutMaintenanceWindow(ctx, st, storage.MaintenanceWindow{Name: "past", Start: now.Add(-time.Hour), End: now.Add(-time.Minute)}); err == nil {
+		t.Fatal("expected error putting a window that has ended")
+	}
+	if err := storage.PutMaintenanceWindow(ctx, st, storage.MaintenanceWindow{Name: "backwards", Start: now.Add(time.Hour), End: now.Add(time.Minute)}); err == nil {
+		t.Fatal("expected error putting a window that ends before it starts")
+	}
+
+	windows, err := storage.ListMaintenanceWindows(ctx, st)
+	if err != nil {
+		t.Fatalf("list maintenance windows: %v", err)
+	}
+	if len(windows) != 2 || windows[0].Name != "site-a" {
+		t.Fatalf("expected 2 windows starting with site-a, got %+v", windows)
+	}
+	if !inMaintenance("node-a") {
+		t.Fatal("expected node-a to be in maintenance")
+	}
+	if inMaintenance("node-b") {
+		t.Fatal("expected node-b not to be in maintenance before the mesh-wide window starts")
+	}
+	if inMaintenance("") {
+		t.Fatal("expected no mesh-wide maintenance")
+	}
+
+	if err := storage.PutMaintenanceWindow(ctx, st, storage.MaintenanceWindow{Name: "upgrade", Start: now.Add(-time.Minute), End: now.Add(time.Hour)}); err != nil {
+		t.Fatalf("put maintenance window: %v", err)
+	}
+	if !inMaintenance("node-b") || !inMaintenance("") {
+		t.Fatal("expected mesh-wide maintenance once the window starts")
+	}
+	if err := storage.DeleteMaintenanceWindow(ctx, st, "upgrade"); err != nil {
+		t.Fatalf("delete maintenance window: %v", err)
+	}
+	if inMaintenance("node-b") {
+		t.Fatal("expected node-b not to be in maintenance after the window is deleted")
+	}
+}
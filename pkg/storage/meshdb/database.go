@@ -59,12 +59,14 @@ func New(db storage.MeshDataStore) storage.MeshDB {
 // NewFromStorage creates a new MeshDB instance from the given MeshStorage. The same
 // information applies as for New.
 func NewFromStorage(st storage.MeshStorage) storage.MeshDB {
-	return New(&MeshDataStore{
+	db := New(&MeshDataStore{
 		graph:   graphstore.NewStore(st),
 		rbac:    rbac.New(st),
 		mesh:    state.New(st),
 		network: networking.New(st),
-	})
+	}).(*Database)
+	db.storage = st
+	return db
 }
 
 // MeshDataStore is a data store using an underlying MeshStorage instance.
@@ -100,6 +102,7 @@ func (m *MeshDataStore) Networking() storage.Networking {
 // read methods to perform validation. So any locks used internally must be reentrant.
 type Database struct {
 	db         storage.MeshDataStore
+	storage    storage.MeshStorage
 	graphStore storage.GraphStore
 	peers      storage.Peers
 	rbac       storage.RBAC
@@ -113,6 +116,26 @@ func (d *Database) Peers() storage.Peers {
 	return d.peers
 }
 
+// Txn runs fn against a view of the database whose writes are committed together
+// once fn returns without error. Transactions are only available when the database
+// was created from a MeshStorage.
+func (d *Database) Txn(ctx context.Context, fn func(tx storage.MeshDB) error) error {
+	if d.storage == nil {
+		return fmt.Errorf("%w: transactions require direct storage access", errors.ErrNotImplemented)
+	}
+	txn := storage.NewTxnStorage(d.storage)
+	if err := fn(NewFromStorage(txn)); err != nil {
+		return err
+	}
+	return txn.Commit(ctx)
+}
+
+// MeshStorage returns the MeshStorage the database was created from, or nil if
+// it was created from a MeshDataStore.
+func (d *Database) MeshStorage() storage.MeshStorage {
+	return d.storage
+}
+
 // GraphStore returns the underlying storage.MeshDB's GraphStore instance with
 // validators run before operations.
 func (d *Database) GraphStore() storage.GraphStore {
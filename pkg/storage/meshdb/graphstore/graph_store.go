@@ -20,6 +20,7 @@ package graphstore
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/dominikbraun/graph"
@@ -31,10 +32,14 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
-// GraphStore implements the Store.
+// GraphStore implements the Store. Writes bump the graph version, which lets
+// reads that list the whole graph be served from an in-memory copy for as long
+// as the version does not change.
 type GraphStore struct {
 	storage.MeshStorage
-	mu sync.RWMutex
+	idx   *graphIndex
+	idxmu sync.Mutex
+	mu    sync.RWMutex
 }
 
 // NewStore creates a new Graph storage instance.
@@ -67,7 +72,7 @@ func (g *GraphStore) AddVertex(nodeID types.NodeID, node types.MeshNode, props g
 		return fmt.Errorf("marshal node: %w", err)
 	}
 	key := storage.NodesPrefix.For(nodeID.Bytes())
-	if err := g.PutValue(ctx, key, data, 0); err != nil {
+	if err := g.write(ctx, storage.WriteOp{Key: key, Value: data}); err != nil {
 		return fmt.Errorf("put node: %w", err)
 	}
 	return nil
@@ -87,6 +92,15 @@ func (g *GraphStore) Vertex(nodeID types.NodeID) (node types.MeshNode, props gra
 		err = fmt.Errorf("%w: %s", errors.ErrInvalidNodeID, nodeID)
 		return
 	}
+	if idx := g.currentIndex(ctx); idx != nil {
+		cached, ok := idx.nodes[nodeID]
+		if !ok {
+			err = graph.ErrVertexNotFound
+			return
+		}
+		node = cached.DeepCopy()
+		return
+	}
 	key := storage.NodesPrefix.For(nodeID.Bytes())
 	data, err := g.GetValue(ctx, key)
 	if err != nil {
@@ -139,9 +153,13 @@ func (g *GraphStore) RemoveVertex(nodeID types.NodeID) error {
 			return graph.ErrVertexHasEdges
 		}
 	}
-	if err := g.Delete(ctx, key); err != nil {
+	if err := g.write(ctx, storage.WriteOp{Key: key, Delete: true}); err != nil {
 		return fmt.Errorf("delete node: %w", err)
 	}
+	// Service advertisements belong to the node record.
+	if err := storage.WithdrawNodeServices(ctx, g.MeshStorage, nodeID); err != nil {
+		return fmt.Errorf("withdraw node services: %w", err)
+	}
 	return nil
 }
 
@@ -149,18 +167,15 @@ func (g *GraphStore) RemoveVertex(nodeID types.NodeID) error {
 func (g *GraphStore) ListVertices() ([]types.NodeID, error) {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	ctx := context.Background()
-	keys, err := g.ListKeys(ctx, storage.NodesPrefix)
+	idx, err := g.loadIndex(context.Background())
 	if err != nil {
-		return nil, fmt.Errorf("list nodes: %w", err)
+		return nil, err
 	}
-	out := make([]types.NodeID, 0)
-	for _, key := range keys {
-		if bytes.Equal(key, storage.NodesPrefix) {
-			continue
-		}
-		out = append(out, types.NodeID(bytes.TrimPrefix(key, append(storage.NodesPrefix, '/'))))
+	out := make([]types.NodeID, 0, len(idx.nodes))
+	for id := range idx.nodes {
+		out = append(out, id)
 	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
 	return out, nil
 }
 
@@ -230,7 +245,7 @@ func (g *GraphStore) AddEdge(sourceNode, targetNode types.NodeID, edge graph.Edg
 	if err != nil {
 		return fmt.Errorf("marshal edge: %w", err)
 	}
-	err = g.PutValue(ctx, key, edgeData, 0)
+	err = g.write(ctx, storage.WriteOp{Key: key, Value: edgeData})
 	if err != nil {
 		return fmt.Errorf("put node edge: %w", err)
 	}
@@ -258,7 +273,7 @@ func (g *GraphStore) UpdateEdge(sourceNode, targetNode types.NodeID, edge graph.
 	if err != nil {
 		return fmt.Errorf("marshal edge: %w", err)
 	}
-	err = g.PutValue(ctx, key, edgeData, 0)
+	err = g.write(ctx, storage.WriteOp{Key: key, Value: edgeData})
 	if err != nil {
 		return fmt.Errorf("put node edge: %w", err)
 	}
@@ -279,7 +294,7 @@ func (g *GraphStore) RemoveEdge(sourceNode, targetNode types.NodeID) error {
 		return fmt.Errorf("node ID must not be empty")
 	}
 	key := newEdgeKey(sourceNode, targetNode)
-	err := g.Delete(ctx, key)
+	err := g.write(ctx, storage.WriteOp{Key: key, Delete: true})
 	if err != nil {
 		// Don't return an error if the edge doesn't exist.
 		if errors.IsKeyNotFound(err) {
@@ -305,6 +320,13 @@ func (g *GraphStore) Edge(sourceNode, targetNode types.NodeID) (graph.Edge[types
 	if sourceNode.IsEmpty() || targetNode.IsEmpty() {
 		return graph.Edge[types.NodeID]{}, fmt.Errorf("node ID must not be empty")
 	}
+	if idx := g.currentIndex(ctx); idx != nil {
+		cached, ok := idx.edges[[2]types.NodeID{sourceNode, targetNode}]
+		if !ok {
+			return graph.Edge[types.NodeID]{}, graph.ErrEdgeNotFound
+		}
+		return cached.DeepCopy().AsGraphEdge(), nil
+	}
 	key := newEdgeKey(sourceNode, targetNode)
 	data, err := g.GetValue(ctx, key)
 	if err != nil {
@@ -325,21 +347,25 @@ func (g *GraphStore) Edge(sourceNode, targetNode types.NodeID) (graph.Edge[types
 func (g *GraphStore) ListEdges() ([]graph.Edge[types.NodeID], error) {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
-	ctx := context.Background()
-	edges := make([]graph.Edge[types.NodeID], 0)
-	err := g.IterPrefix(ctx, storage.EdgesPrefix, func(key, value []byte) error {
-		if bytes.Equal(key, storage.EdgesPrefix) {
-			return nil
-		}
-		var edge types.MeshEdge
-		err := edge.UnmarshalProtoJSON(value)
-		if err != nil {
-			return fmt.Errorf("unmarshal edge: %w", err)
+	idx, err := g.loadIndex(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][2]types.NodeID, 0, len(idx.edges))
+	for key := range idx.edges {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
 		}
-		edges = append(edges, edge.AsGraphEdge())
-		return nil
+		return keys[i][1] < keys[j][1]
 	})
-	return edges, err
+	edges := make([]graph.Edge[types.NodeID], 0, len(keys))
+	for _, key := range keys {
+		edges = append(edges, idx.edges[key].DeepCopy().AsGraphEdge())
+	}
+	return edges, nil
 }
 
 // Subscribe subscribes to changes in the graph.
@@ -392,6 +418,53 @@ func (g *GraphStore) Subscribe(ctx context.Context, fn storage.PeerSubscribeFunc
 	})
 }
 
+// write applies the given writes along with a new graph version.
+func (g *GraphStore) write(ctx context.Context, ops ...storage.WriteOp) error {
+	ops = append(ops, storage.WriteOp{Key: storage.GraphVersionKey, Value: []byte(newGraphVersion())})
+	return g.MeshStorage.WriteBatch(ctx, ops)
+}
+
+// currentIndex returns the in-memory copy of the graph if it is still current.
+func (g *GraphStore) currentIndex(ctx context.Context) *graphIndex {
+	g.idxmu.Lock()
+	idx := g.idx
+	g.idxmu.Unlock()
+	if idx == nil {
+		return nil
+	}
+	version, err := GraphVersion(ctx, g.MeshStorage)
+	if err != nil || version != idx.version {
+		return nil
+	}
+	return idx
+}
+
+// loadIndex returns an in-memory copy of the current graph. It prefers, in order,
+// the copy already held, the snapshot stored alongside the graph, and finally
+// reading every node and edge.
+func (g *GraphStore) loadIndex(ctx context.Context) (*graphIndex, error) {
+	if idx := g.currentIndex(ctx); idx != nil {
+		return idx, nil
+	}
+	snap, ok, err := LoadSnapshot(ctx, g.MeshStorage)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		snap, err = TakeSnapshot(ctx, g.MeshStorage)
+		if err != nil {
+			return nil, err
+		}
+	}
+	idx := newGraphIndex(snap)
+	if snap.Version != "" {
+		g.idxmu.Lock()
+		g.idx = idx
+		g.idxmu.Unlock()
+	}
+	return idx, nil
+}
+
 func newEdgeKey(source, target types.NodeID) []byte {
 	return storage.EdgesPrefix.For(source.Bytes()).For(target.Bytes())
 }
@@ -0,0 +1,253 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graphstore
+
+import (
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/google/uuid"
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Snapshot is a point in time copy of all the nodes and edges in the graph.
+// Its binary form is independent of how the graph is laid out in storage and
+// can be loaded with a single read instead of one per node and edge.
+type Snapshot struct {
+	// Version is the graph version the snapshot was taken at. It is empty
+	// if the graph changed while the snapshot was being taken.
+	Version string
+	// Nodes are all the nodes in the graph.
+	Nodes []types.MeshNode
+	// Edges are all the edges in the graph.
+	Edges []types.MeshEdge
+}
+
+// GraphVersion returns the current version of the graph in the given storage.
+// An empty version is returned if the graph has not been written to since the
+// version key was introduced.
+func GraphVersion(ctx context.Context, st storage.MeshStorage) (string, error) {
+	version, err := st.GetValue(ctx, storage.GraphVersionKey)
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("get graph version: %w", err)
+	}
+	return string(version), nil
+}
+
+// TakeSnapshot reads all nodes and edges from the given storage.
+func TakeSnapshot(ctx context.Context, st storage.MeshStorage) (Snapshot, error) {
+	before, err := GraphVersion(ctx, st)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	var snap Snapshot
+	err = st.IterPrefix(ctx, storage.NodesPrefix, func(key, value []byte) error {
+		var node types.MeshNode
+		if err := node.UnmarshalProtoJSON(value); err != nil {
+			return fmt.Errorf("unmarshal node: %w", err)
+		}
+		snap.Nodes = append(snap.Nodes, node)
+		return nil
+	})
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("list nodes: %w", err)
+	}
+	err = st.IterPrefix(ctx, storage.EdgesPrefix, func(key, value []byte) error {
+		var edge types.MeshEdge
+		if err := edge.UnmarshalProtoJSON(value); err != nil {
+			return fmt.Errorf("unmarshal edge: %w", err)
+		}
+		snap.Edges = append(snap.Edges, edge)
+		return nil
+	})
+	if err != nil {
+		return Snapshot{}, fmt.Errorf("list edges: %w", err)
+	}
+	after, err := GraphVersion(ctx, st)
+	if err != nil {
+		return Snapshot{}, err
+	}
+	if before == after {
+		snap.Version = after
+	}
+	return snap, nil
+}
+
+// LoadSnapshot returns the snapshot stored alongside the graph if it is
+// current. False is returned if there is no snapshot or the graph has
+// changed since it was taken.
+func LoadSnapshot(ctx context.Context, st storage.MeshStorage) (Snapshot, bool, error) {
+	version, err := GraphVersion(ctx, st)
+	if err != nil || version == "" {
+		return Snapshot{}, false, err
+	}
+	data, err := st.GetValue(ctx, storage.GraphSnapshotKey)
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return Snapshot{}, false, nil
+		}
+		return Snapshot{}, false, fmt.Errorf("get graph snapshot: %w", err)
+	}
+	var snap Snapshot
+	if err := snap.UnmarshalBinary(data); err != nil {
+		return Snapshot{}, false, err
+	}
+	if snap.Version != version {
+		return Snapshot{}, false, nil
+	}
+	return snap, true, nil
+}
+
+// WriteSnapshot stores a snapshot of the current graph alongside it, unless
+// the stored snapshot is already current. It returns true if a new snapshot
+// was written.
+func WriteSnapshot(ctx context.Context, st storage.MeshStorage) (bool, error) {
+	version, err := GraphVersion(ctx, st)
+	if err != nil {
+		return false, err
+	}
+	if version == "" {
+		// The graph predates versioning, tag it so the snapshot can be verified.
+		err = st.PutValue(ctx, storage.GraphVersionKey, []byte(newGraphVersion()), 0)
+		if err != nil {
+			return false, fmt.Errorf("put graph version: %w", err)
+		}
+	} else if _, ok, err := LoadSnapshot(ctx, st); err != nil {
+		return false, err
+	} else if ok {
+		return false, nil
+	}
+	snap, err := TakeSnapshot(ctx, st)
+	if err != nil {
+		return false, err
+	}
+	if snap.Version == "" {
+		// The graph changed underneath us, try again next time.
+		return false, nil
+	}
+	data, err := snap.MarshalBinary()
+	if err != nil {
+		return false, err
+	}
+	if err := st.PutValue(ctx, storage.GraphSnapshotKey, data, 0); err != nil {
+		return false, fmt.Errorf("put graph snapshot: %w", err)
+	}
+	return true, nil
+}
+
+// MarshalBinary encodes the snapshot as snappy compressed protobuf. The layout
+// is that of a message with the version in field 1, repeated MeshNodes in field
+// 2 and repeated MeshEdges in field 3.
+func (s Snapshot) MarshalBinary() ([]byte, error) {
+	var data []byte
+	data = protowire.AppendTag(data, 1, protowire.BytesType)
+	data = protowire.AppendString(data, s.Version)
+	for _, node := range s.Nodes {
+		b, err := proto.Marshal(node.MeshNode)
+		if err != nil {
+			return nil, fmt.Errorf("marshal node: %w", err)
+		}
+		data = protowire.AppendTag(data, 2, protowire.BytesType)
+		data = protowire.AppendBytes(data, b)
+	}
+	for _, edge := range s.Edges {
+		b, err := proto.Marshal(edge.MeshEdge)
+		if err != nil {
+			return nil, fmt.Errorf("marshal edge: %w", err)
+		}
+		data = protowire.AppendTag(data, 3, protowire.BytesType)
+		data = protowire.AppendBytes(data, b)
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// UnmarshalBinary decodes a snapshot encoded with MarshalBinary.
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	data, err := snappy.Decode(nil, data)
+	if err != nil {
+		return fmt.Errorf("decode graph snapshot: %w", err)
+	}
+	*s = Snapshot{}
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("decode graph snapshot: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		if typ != protowire.BytesType {
+			return fmt.Errorf("decode graph snapshot: unexpected wire type for field %d", num)
+		}
+		b, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return fmt.Errorf("decode graph snapshot: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		switch num {
+		case 1:
+			s.Version = string(b)
+		case 2:
+			node := &v1.MeshNode{}
+			if err := proto.Unmarshal(b, node); err != nil {
+				return fmt.Errorf("unmarshal node: %w", err)
+			}
+			s.Nodes = append(s.Nodes, types.MeshNode{MeshNode: node})
+		case 3:
+			edge := &v1.MeshEdge{}
+			if err := proto.Unmarshal(b, edge); err != nil {
+				return fmt.Errorf("unmarshal edge: %w", err)
+			}
+			s.Edges = append(s.Edges, types.MeshEdge{MeshEdge: edge})
+		}
+	}
+	return nil
+}
+
+func newGraphVersion() string {
+	return uuid.NewString()
+}
+
+// graphIndex is an in-memory copy of the graph at a given version.
+type graphIndex struct {
+	version string
+	nodes   map[types.NodeID]types.MeshNode
+	edges   map[[2]types.NodeID]types.MeshEdge
+}
+
+func newGraphIndex(snap Snapshot) *graphIndex {
+	idx := &graphIndex{
+		version: snap.Version,
+		nodes:   make(map[types.NodeID]types.MeshNode, len(snap.Nodes)),
+		edges:   make(map[[2]types.NodeID]types.MeshEdge, len(snap.Edges)),
+	}
+	for _, node := range snap.Nodes {
+		idx.nodes[node.NodeID()] = node
+	}
+	for _, edge := range snap.Edges {
+		idx.edges[[2]types.NodeID{edge.SourceID(), edge.TargetID()}] = edge
+	}
+	return idx
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package graphstore
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestGraphSnapshot(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	g := storage.NewGraphWithStore(NewStore(st))
+	for _, id := range []string{"node-a", "node-b", "node-c"} {
+		if err := g.AddVertex(types.MeshNode{MeshNode: &v1.MeshNode{Id: id}}); err != nil {
+			t.Fatalf("add vertex: %v", err)
+		}
+	}
+	if err := g.AddEdge("node-a", "node-b"); err != nil {
+		t.Fatalf("add edge: %v", err)
+	}
+
+	// No snapshot has been written yet.
+	if _, ok, err := LoadSnapshot(ctx, st); err != nil || ok {
+		t.Fatalf("expected no snapshot, got ok=%v err=%v", ok, err)
+	}
+	wrote, err := WriteSnapshot(ctx, st)
+	if err != nil || !wrote {
+		t.Fatalf("expected snapshot to be written, got wrote=%v err=%v", wrote, err)
+	}
+	// Writing again without changes is a no-op.
+	wrote, err = WriteSnapshot(ctx, st)
+	if err != nil || wrote {
+		t.Fatalf("expected snapshot to be current, got wrote=%v err=%v", wrote, err)
+	}
+	snap, ok, err := LoadSnapshot(ctx, st)
+	if err != nil || !ok {
+		t.Fatalf("expected current snapshot, got ok=%v err=%v", ok, err)
+	}
+	// Undirected edges are stored in both directions.
+	if len(snap.Nodes) != 3 || len(snap.Edges) != 2 {
+		t.Fatalf("expected 3 nodes and 2 edges, got %d and %d", len(snap.Nodes), len(snap.Edges))
+	}
+
+	// Any graph write makes the stored snapshot stale.
+	if err := g.AddEdge("node-b", "node-c"); err != nil {
+		t.Fatalf("add edge: %v", err)
+	}
+	if _, ok, err := LoadSnapshot(ctx, st); err != nil || ok {
+		t.Fatalf("expected stale snapshot, got ok=%v err=%v", ok, err)
+	}
+	edges, err := g.Edges()
+	if err != nil {
+		t.Fatalf("list edges: %v", err)
+	}
+	if len(edges) != 2 {
+		t.Fatalf("expected 2 edges, got %d", len(edges))
+	}
+}
+
+func TestGraphSnapshotEncoding(t *testing.T) {
+	t.Parallel()
+	snap := Snapshot{
+		Version: "version",
+		Nodes: []types.MeshNode{
+			{MeshNode: &v1.MeshNode{Id: "node-a", PrivateIPv6: "fd00::1/128"}},
+			{MeshNode: &v1.MeshNode{Id: "node-b"}},
+		},
+		Edges: []types.MeshEdge{
+			{MeshEdge: &v1.MeshEdge{Source: "node-a", Target: "node-b", Weight: 1}},
+		},
+	}
+	data, err := snap.MarshalBinary()
+	if err != nil {
+		t.Fatalf("marshal snapshot: %v", err)
+	}
+	var got Snapshot
+	if err := got.UnmarshalBinary(data); err != nil {
+		t.Fatalf("unmarshal snapshot: %v", err)
+	}
+	if got.Version != snap.Version || len(got.Nodes) != 2 || len(got.Edges) != 1 {
+		t.Fatalf("unexpected snapshot: %+v", got)
+	}
+	if got.Nodes[0].GetPrivateIPv6() != "fd00::1/128" || got.Edges[0].GetTarget() != "node-b" {
+		t.Fatalf("unexpected snapshot contents: %+v", got)
+	}
+}
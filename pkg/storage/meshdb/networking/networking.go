@@ -50,7 +50,7 @@ func (n *networking) PutNetworkACL(ctx context.Context, acl types.NetworkACL) er
 	if err != nil {
 		return fmt.Errorf("marshal network acl: %w", err)
 	}
-	err = n.PutValue(ctx, key, data, 0)
+	err = storage.PutValue(ctx, n, key, data, 0)
 	if err != nil {
 		return fmt.Errorf("put network acl: %w", err)
 	}
@@ -114,7 +114,7 @@ func (n *networking) PutRoute(ctx context.Context, route types.Route) error {
 	if err != nil {
 		return fmt.Errorf("marshal route: %w", err)
 	}
-	err = n.PutValue(ctx, key, data, 0)
+	err = storage.PutValue(ctx, n, key, data, 0)
 	if err != nil {
 		return fmt.Errorf("put network route: %w", err)
 	}
@@ -184,7 +184,8 @@ func (n *networking) DeleteRoute(ctx context.Context, name string) error {
 	if err != nil && !errors.IsKeyNotFound(err) {
 		return fmt.Errorf("delete network route: %w", err)
 	}
-	return nil
+	// The metric of the route goes with it.
+	return storage.DeleteRouteMetric(ctx, n, name)
 }
 
 // ListRoutes returns a list of Routes.
@@ -63,7 +63,7 @@ func (r *rbac) GetEnabled(ctx context.Context) (bool, error) {
 
 // SetEnabled sets the RBAC enabled state.
 func (r *rbac) SetEnabled(ctx context.Context, enabled bool) error {
-	err := r.PutValue(ctx, rbacDisabledKey, []byte(fmt.Sprintf("%v", enabled)), 0)
+	err := storage.PutValue(ctx, r, rbacDisabledKey, []byte(fmt.Sprintf("%v", enabled)), 0)
 	if err != nil {
 		return fmt.Errorf("put rbac disabled: %w", err)
 	}
@@ -91,7 +91,7 @@ func (r *rbac) PutRole(ctx context.Context, role types.Role) error {
 		return fmt.Errorf("marshal role: %w", err)
 	}
 	key := rolesPrefix.ForString(role.GetName())
-	err = r.PutValue(ctx, key, data, 0)
+	err = storage.PutValue(ctx, r, key, data, 0)
 	if err != nil {
 		return fmt.Errorf("put role: %w", err)
 	}
@@ -167,7 +167,7 @@ func (r *rbac) PutRoleBinding(ctx context.Context, rolebinding types.RoleBinding
 	if err != nil {
 		return fmt.Errorf("marshal rolebinding: %w", err)
 	}
-	err = r.PutValue(ctx, key, data, 0)
+	err = storage.PutValue(ctx, r, key, data, 0)
 	if err != nil {
 		return fmt.Errorf("put rolebinding: %w", err)
 	}
@@ -235,7 +235,7 @@ func (r *rbac) PutGroup(ctx context.Context, group types.Group) error {
 	if err != nil {
 		return fmt.Errorf("marshal group: %w", err)
 	}
-	err = r.PutValue(ctx, key, data, 0)
+	err = storage.PutValue(ctx, r, key, data, 0)
 	if err != nil {
 		return fmt.Errorf("put group: %w", err)
 	}
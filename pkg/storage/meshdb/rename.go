@@ -0,0 +1,254 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// RenameNode changes the ID of a node and every reference to it in a single
+// transaction. Edges, routes, network ACLs, role bindings, groups, attachments,
+// escrowed keys, labels and namespace membership are moved to the new ID while the node keeps its address
+// leases. An alias from the old ID to the new one is left behind for the given
+// grace period so peers that have not yet observed the rename can still resolve
+// the node.
+func RenameNode(ctx context.Context, st storage.MeshStorage, from, to types.NodeID, grace time.Duration, actor types.NodeID) error {
+	if !from.IsValid() {
+		return fmt.Errorf("%w: %s", errors.ErrInvalidNodeID, from)
+	}
+	if !to.IsValid() {
+		return fmt.Errorf("%w: %s", errors.ErrInvalidNodeID, to)
+	}
+	if from == to {
+		return fmt.Errorf("node %s already has that ID", from)
+	}
+	if grace <= 0 {
+		grace = storage.DefaultRenameGracePeriod
+	}
+	txn := storage.NewTxnStorage(st)
+	db := NewFromStorage(txn)
+	node, err := db.Peers().Get(ctx, from)
+	if err != nil {
+		return fmt.Errorf("get node: %w", err)
+	}
+	if _, err := db.Peers().Get(ctx, to); err == nil {
+		return fmt.Errorf("node %s already exists", to)
+	} else if !errors.IsNodeNotFound(err) {
+		return fmt.Errorf("get node: %w", err)
+	}
+	if alias, err := storage.GetNodeAlias(ctx, txn, to); err == nil {
+		return fmt.Errorf("%s is still an alias for node %s", to, alias.NodeID)
+	} else if !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("get node alias: %w", err)
+	}
+	quarantined, err := storage.IsQuarantined(ctx, txn, from)
+	if err != nil {
+		return fmt.Errorf("check quarantine: %w", err)
+	}
+	if quarantined {
+		return fmt.Errorf("node %s is quarantined", from)
+	}
+	if err := renameNodeGraph(ctx, db, node, from, to); err != nil {
+		return err
+	}
+	if err := renameNodeNetworking(ctx, db.Networking(), from, to); err != nil {
+		return err
+	}
+	if err := renameNodeRBAC(ctx, db.RBAC(), from, to); err != nil {
+		return err
+	}
+	if err := renameNodeRegistry(ctx, txn, from, to); err != nil {
+		return err
+	}
+	if err := storage.PutNodeAlias(ctx, txn, from, to, grace); err != nil {
+		return fmt.Errorf("put node alias: %w", err)
+	}
+	err = storage.RecordMembershipEvent(ctx, txn, storage.MembershipEvent{
+		Type:   storage.MembershipEventRename,
+		NodeID: from,
+		Reason: fmt.Sprintf("renamed to %s", to),
+		Actor:  actor,
+	})
+	if err != nil {
+		return fmt.Errorf("record membership event: %w", err)
+	}
+	return txn.Commit(ctx)
+}
+
+func renameNodeGraph(ctx context.Context, db storage.MeshDB, node types.MeshNode, from, to types.NodeID) error {
+	edges, err := db.Peers().Graph().Edges()
+	if err != nil {
+		return fmt.Errorf("get edges: %w", err)
+	}
+	if err := db.Peers().Delete(ctx, from); err != nil {
+		return fmt.Errorf("delete node: %w", err)
+	}
+	renamed := node.DeepCopy()
+	renamed.Id = to.String()
+	if err := db.Peers().Put(ctx, renamed); err != nil {
+		return fmt.Errorf("put node: %w", err)
+	}
+	for _, edge := range edges {
+		source, target := edge.Source, edge.Target
+		if source != from && target != from {
+			continue
+		}
+		if source == from {
+			source = to
+		}
+		if target == from {
+			target = to
+		}
+		if err := db.Peers().PutEdge(ctx, types.Edge(edge).ToMeshEdge(source, target)); err != nil {
+			return fmt.Errorf("put edge %s -> %s: %w", source, target, err)
+		}
+	}
+	return nil
+}
+
+func renameNodeNetworking(ctx context.Context, nw storage.Networking, from, to types.NodeID) error {
+	routes, err := nw.GetRoutesByNode(ctx, from)
+	if err != nil {
+		return fmt.Errorf("get routes: %w", err)
+	}
+	for _, route := range routes {
+		renamed := route.DeepCopy()
+		renamed.Node = to.String()
+		// Routes named after the node, such as the ones holding its
+		// attachments, follow the new ID.
+		if suffix, ok := strings.CutPrefix(route.GetName(), from.String()+"-"); ok {
+			renamed.Name = to.String() + "-" + suffix
+			if err := nw.DeleteRoute(ctx, route.GetName()); err != nil {
+				return fmt.Errorf("delete route %s: %w", route.GetName(), err)
+			}
+		}
+		if err := nw.PutRoute(ctx, renamed); err != nil {
+			return fmt.Errorf("put route %s: %w", renamed.GetName(), err)
+		}
+	}
+	acls, err := nw.ListNetworkACLs(ctx)
+	if err != nil {
+		return fmt.Errorf("list network acls: %w", err)
+	}
+	for _, acl := range acls {
+		src, srcChanged := renameInList(acl.GetSourceNodes(), from, to)
+		dst, dstChanged := renameInList(acl.GetDestinationNodes(), from, to)
+		if !srcChanged && !dstChanged {
+			continue
+		}
+		renamed := acl.DeepCopy()
+		renamed.SourceNodes = src
+		renamed.DestinationNodes = dst
+		if err := nw.PutNetworkACL(ctx, renamed); err != nil {
+			return fmt.Errorf("put network acl %s: %w", acl.GetName(), err)
+		}
+	}
+	return nil
+}
+
+func renameNodeRBAC(ctx context.Context, rbac storage.RBAC, from, to types.NodeID) error {
+	rbs, err := rbac.ListRoleBindings(ctx)
+	if err != nil {
+		return fmt.Errorf("list role bindings: %w", err)
+	}
+	for _, rb := range rbs {
+		if !renameSubjects(rb.GetSubjects(), from, to) {
+			continue
+		}
+		if err := rbac.PutRoleBinding(ctx, rb); err != nil {
+			return fmt.Errorf("put role binding %s: %w", rb.GetName(), err)
+		}
+	}
+	groups, err := rbac.ListGroups(ctx)
+	if err != nil {
+		return fmt.Errorf("list groups: %w", err)
+	}
+	for _, group := range groups {
+		if !renameSubjects(group.GetSubjects(), from, to) {
+			continue
+		}
+		if err := rbac.PutGroup(ctx, group); err != nil {
+			return fmt.Errorf("put group %s: %w", group.GetName(), err)
+		}
+	}
+	return nil
+}
+
+func renameNodeRegistry(ctx context.Context, st storage.MeshStorage, from, to types.NodeID) error {
+	attachments, err := storage.ListNodeAttachments(ctx, st, from)
+	if err != nil {
+		return fmt.Errorf("list attachments: %w", err)
+	}
+	for _, a := range attachments {
+		if err := storage.DeleteAttachment(ctx, st, from, a.ID); err != nil {
+			return fmt.Errorf("delete attachment %s: %w", a.ID, err)
+		}
+		a.NodeID = to
+		if err := storage.PutAttachment(ctx, st, a); err != nil {
+			return fmt.Errorf("put attachment %s: %w", a.ID, err)
+		}
+	}
+	for _, prefix := range []types.StoragePrefix{storage.EscrowPrefix, storage.NamespaceMembersPrefix, storage.NodeLabelsPrefix} {
+		value, err := st.GetValue(ctx, prefix.ForString(from.String()))
+		if err != nil {
+			if errors.IsKeyNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("get %s: %w", prefix, err)
+		}
+		if err := st.Delete(ctx, prefix.ForString(from.String())); err != nil {
+			return fmt.Errorf("delete %s: %w", prefix, err)
+		}
+		if err := st.PutValue(ctx, prefix.ForString(to.String()), value, 0); err != nil {
+			return fmt.Errorf("put %s: %w", prefix, err)
+		}
+	}
+	return nil
+}
+
+func renameInList(ids []string, from, to types.NodeID) ([]string, bool) {
+	out := make([]string, len(ids))
+	var changed bool
+	for i, id := range ids {
+		if id == from.String() {
+			id = to.String()
+			changed = true
+		}
+		out[i] = id
+	}
+	return out, changed
+}
+
+func renameSubjects(subjects []*v1.Subject, from, to types.NodeID) bool {
+	var changed bool
+	for _, subject := range subjects {
+		if subject.GetType() == v1.SubjectType_SUBJECT_NODE && subject.GetName() == from.String() {
+			subject.Name = to.String()
+			changed = true
+		}
+	}
+	return changed
+}
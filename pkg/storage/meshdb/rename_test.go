@@ -0,0 +1,141 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package meshdb_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestRenameNode(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+
+	for _, node := range []*v1.MeshNode{
+		{Id: "node-a", PrivateIPv4: "172.16.0.1/32", PrivateIPv6: "fd00::1/128"},
+		{Id: "node-b", PrivateIPv4: "172.16.0.2/32", PrivateIPv6: "fd00::2/128"},
+	} {
+		if err := db.Peers().Put(ctx, types.MeshNode{MeshNode: node}); err != nil {
+			t.Fatalf("put node: %v", err)
+		}
+	}
+	if err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: "node-a", Target: "node-b", Weight: 1}}); err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+	if err := db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name:             "node-a-lan",
+		Node:             "node-a",
+		DestinationCIDRs: []string{"10.0.0.0/24"},
+	}}); err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	if err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "a-to-b",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"node-a"},
+		DestinationNodes: []string{"node-b"},
+	}}); err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+	if err := db.RBAC().PutRole(ctx, types.Role{Role: &v1.Role{
+		Name:  "readers",
+		Rules: []*v1.Rule{{Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_ALL}, Verbs: []v1.RuleVerb{v1.RuleVerb_VERB_GET}}},
+	}}); err != nil {
+		t.Fatalf("put role: %v", err)
+	}
+	if err := db.RBAC().PutRoleBinding(ctx, types.RoleBinding{RoleBinding: &v1.RoleBinding{
+		Name:     "readers",
+		Role:     "readers",
+		Subjects: []*v1.Subject{{Type: v1.SubjectType_SUBJECT_NODE, Name: "node-a"}},
+	}}); err != nil {
+		t.Fatalf("put role binding: %v", err)
+	}
+
+	if err := meshdb.RenameNode(ctx, st, "node-a", "node-c", time.Minute, "admin"); err != nil {
+		t.Fatalf("rename node: %v", err)
+	}
+
+	if _, err := db.Peers().Get(ctx, "node-a"); !errors.IsNodeNotFound(err) {
+		t.Fatalf("expected old node to be gone, got %v", err)
+	}
+	node, err := db.Peers().Get(ctx, "node-c")
+	if err != nil {
+		t.Fatalf("get renamed node: %v", err)
+	}
+	if node.GetPrivateIPv4() != "172.16.0.1/32" || node.GetPrivateIPv6() != "fd00::1/128" {
+		t.Errorf("expected leases to be preserved, got %s and %s", node.GetPrivateIPv4(), node.GetPrivateIPv6())
+	}
+	if _, err := db.Peers().GetEdge(ctx, "node-c", "node-b"); err != nil {
+		t.Errorf("get renamed edge: %v", err)
+	}
+	route, err := db.Networking().GetRoute(ctx, "node-c-lan")
+	if err != nil {
+		t.Fatalf("get renamed route: %v", err)
+	}
+	if route.GetNode() != "node-c" {
+		t.Errorf("expected route node to be node-c, got %s", route.GetNode())
+	}
+	if _, err := db.Networking().GetRoute(ctx, "node-a-lan"); err == nil {
+		t.Error("expected old route to be removed")
+	}
+	acl, err := db.Networking().GetNetworkACL(ctx, "a-to-b")
+	if err != nil {
+		t.Fatalf("get network acl: %v", err)
+	}
+	if acl.GetSourceNodes()[0] != "node-c" {
+		t.Errorf("expected acl source to be node-c, got %v", acl.GetSourceNodes())
+	}
+	rb, err := db.RBAC().GetRoleBinding(ctx, "readers")
+	if err != nil {
+		t.Fatalf("get role binding: %v", err)
+	}
+	if rb.GetSubjects()[0].GetName() != "node-c" {
+		t.Errorf("expected role binding subject to be node-c, got %s", rb.GetSubjects()[0].GetName())
+	}
+
+	id, err := storage.ResolveNodeAlias(ctx, st, "node-a")
+	if err != nil {
+		t.Fatalf("resolve alias: %v", err)
+	}
+	if id != "node-c" {
+		t.Errorf("expected node-a to resolve to node-c, got %s", id)
+	}
+	events, err := storage.ListMembershipEvents(ctx, st, storage.MembershipEventFilter{Type: storage.MembershipEventRename})
+	if err != nil {
+		t.Fatalf("list membership events: %v", err)
+	}
+	if len(events) != 1 || events[0].NodeID != "node-a" {
+		t.Errorf("expected one rename event for node-a, got %+v", events)
+	}
+
+	// The old ID can not be taken while the alias is active.
+	if err := meshdb.RenameNode(ctx, st, "node-b", "node-a", time.Minute, "admin"); err == nil {
+		t.Error("expected renaming onto an active alias to fail")
+	}
+}
@@ -58,7 +58,7 @@ func (s *state) GetIPv6Prefix(ctx context.Context) (netip.Prefix, error) {
 }
 
 func (s *state) SetIPv6Prefix(ctx context.Context, prefix netip.Prefix) error {
-	err := s.PutValue(ctx, IPv6PrefixKey, []byte(prefix.String()), 0)
+	err := storage.PutValue(ctx, s, IPv6PrefixKey, []byte(prefix.String()), 0)
 	if err != nil {
 		return err
 	}
@@ -74,7 +74,7 @@ func (s *state) GetIPv4Prefix(ctx context.Context) (netip.Prefix, error) {
 }
 
 func (s *state) SetIPv4Prefix(ctx context.Context, prefix netip.Prefix) error {
-	err := s.PutValue(ctx, IPv4PrefixKey, []byte(prefix.String()), 0)
+	err := storage.PutValue(ctx, s, IPv4PrefixKey, []byte(prefix.String()), 0)
 	if err != nil {
 		return err
 	}
@@ -90,7 +90,7 @@ func (s *state) GetMeshDomain(ctx context.Context) (string, error) {
 }
 
 func (s *state) SetMeshDomain(ctx context.Context, domain string) error {
-	err := s.PutValue(ctx, MeshDomainKey, []byte(domain), 0)
+	err := storage.PutValue(ctx, s, MeshDomainKey, []byte(domain), 0)
 	if err != nil {
 		return err
 	}
@@ -42,3 +42,8 @@ type TestDB struct {
 	storage.MeshDB
 	io.Closer
 }
+
+// MeshStorage returns the in-memory storage backing the database.
+func (t *TestDB) MeshStorage() storage.MeshStorage {
+	return storage.MeshStorageOf(t.MeshDB)
+}
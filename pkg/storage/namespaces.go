@@ -0,0 +1,325 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// NamespacesPrefix is where namespaces are stored in the database.
+// Namespaces are indexed by name in the format /registry/namespaces/<name>.
+var NamespacesPrefix = types.RegistryPrefix.ForString("namespaces")
+
+// NamespaceMembersPrefix is where the namespace of each node is stored in the
+// database. Assignments are indexed by node ID in the format
+// /registry/namespace-members/<id>.
+var NamespaceMembersPrefix = types.RegistryPrefix.ForString("namespace-members")
+
+// DefaultNamespace is the namespace of nodes that have not been assigned one.
+const DefaultNamespace = "default"
+
+// Namespace is an isolated partition of the mesh. Nodes in different namespaces
+// can not communicate regardless of network ACLs unless both namespaces list
+// each other as peers. Routes belong to the namespace of the node advertising
+// them.
+type Namespace struct {
+	// Name is the unique name of the namespace.
+	Name string `json:"name"`
+	// Peers are the namespaces whose nodes may communicate with nodes in
+	// this namespace. Peering only takes effect when it is mutual.
+	Peers []string `json:"peers,omitempty"`
+	// RoleBindings are the role bindings whose grants are confined to this
+	// namespace. See InNamespace for the resources a namespace contains. They
+	// can not be set on the default namespace.
+	RoleBindings []string `json:"roleBindings,omitempty"`
+	// Quota limits the resources the namespace may use.
+	Quota NamespaceQuota `json:"quota,omitempty"`
+	// Created is when the namespace was created.
+	Created time.Time `json:"created"`
+}
+
+// Validate validates the namespace.
+func (n Namespace) Validate() error {
+	if n.Name == "" {
+		return fmt.Errorf("namespace name is required")
+	}
+	if !types.IsValidID(n.Name) {
+		return fmt.Errorf("namespace name %q is invalid", n.Name)
+	}
+	for _, peer := range n.Peers {
+		if !types.IsValidID(peer) {
+			return fmt.Errorf("invalid peer namespace %q", peer)
+		}
+		if peer == n.Name {
+			return fmt.Errorf("namespace %s can not peer with itself", n.Name)
+		}
+	}
+	if n.Name == DefaultNamespace && len(n.RoleBindings) > 0 {
+		return fmt.Errorf("role bindings can not be scoped to the default namespace")
+	}
+	for _, rb := range n.RoleBindings {
+		if !types.IsValidID(rb) {
+			return fmt.Errorf("invalid role binding %q", rb)
+		}
+		if IsSystemRoleBinding(rb) {
+			return fmt.Errorf("system role binding %s can not be scoped to a namespace", rb)
+		}
+	}
+	return n.Quota.Validate()
+}
+
+// PeersWith returns true if the namespace lists the given namespace as a peer.
+func (n Namespace) PeersWith(name string) bool {
+	for _, peer := range n.Peers {
+		if peer == name {
+			return true
+		}
+	}
+	return false
+}
+
+// PutNamespace creates or updates a namespace.
+func PutNamespace(ctx context.Context, st MeshStorage, ns Namespace) error {
+	if err := ns.Validate(); err != nil {
+		return err
+	}
+	if existing, err := GetNamespace(ctx, st, ns.Name); err == nil {
+		ns.Created = existing.Created
+	} else if !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("get namespace: %w", err)
+	}
+	if ns.Created.IsZero() {
+		ns.Created = time.Now().UTC()
+	}
+	data, err := json.Marshal(ns)
+	if err != nil {
+		return fmt.Errorf("marshal namespace: %w", err)
+	}
+	return st.PutValue(ctx, NamespacesPrefix.ForString(ns.Name), data, 0)
+}
+
+// GetNamespace returns the namespace with the given name.
+func GetNamespace(ctx context.Context, st MeshStorage, name string) (Namespace, error) {
+	var ns Namespace
+	data, err := st.GetValue(ctx, NamespacesPrefix.ForString(name))
+	if err != nil {
+		return ns, err
+	}
+	if err := json.Unmarshal(data, &ns); err != nil {
+		return ns, fmt.Errorf("unmarshal namespace: %w", err)
+	}
+	return ns, nil
+}
+
+// DeleteNamespace deletes the namespace with the given name. Namespaces that
+// still have nodes assigned to them can not be deleted.
+func DeleteNamespace(ctx context.Context, st MeshStorage, name string) error {
+	members, err := ListNamespaceMembers(ctx, st)
+	if err != nil {
+		return err
+	}
+	for node, ns := range members {
+		if ns == name {
+			return fmt.Errorf("namespace %s still contains node %s", name, node)
+		}
+	}
+	return st.Delete(ctx, NamespacesPrefix.ForString(name))
+}
+
+// ListNamespaces returns all namespaces ordered by name.
+func ListNamespaces(ctx context.Context, st MeshStorage) ([]Namespace, error) {
+	var namespaces []Namespace
+	err := st.IterPrefix(ctx, append(NamespacesPrefix, '/'), func(key, value []byte) error {
+		var ns Namespace
+		if err := json.Unmarshal(value, &ns); err != nil {
+			return fmt.Errorf("unmarshal namespace %s: %w", key, err)
+		}
+		namespaces = append(namespaces, ns)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(namespaces, func(i, j int) bool { return namespaces[i].Name < namespaces[j].Name })
+	return namespaces, nil
+}
+
+// SetNodeNamespace assigns a node to a namespace. The namespace must exist
+// unless it is the default namespace, which returns the node to the default.
+func SetNodeNamespace(ctx context.Context, st MeshStorage, nodeID types.NodeID, name string) error {
+	if !nodeID.IsValid() {
+		return fmt.Errorf("%w: %s", errors.ErrInvalidNodeID, nodeID)
+	}
+	if name == "" || name == DefaultNamespace {
+		return st.Delete(ctx, NamespaceMembersPrefix.ForString(nodeID.String()))
+	}
+	if _, err := GetNamespace(ctx, st, name); err != nil {
+		if errors.IsKeyNotFound(err) {
+			return fmt.Errorf("namespace %s does not exist", name)
+		}
+		return fmt.Errorf("get namespace: %w", err)
+	}
+	return st.PutValue(ctx, NamespaceMembersPrefix.ForString(nodeID.String()), []byte(name), 0)
+}
+
+// GetNodeNamespace returns the namespace of the given node.
+func GetNodeNamespace(ctx context.Context, st MeshStorage, nodeID types.NodeID) (string, error) {
+	data, err := st.GetValue(ctx, NamespaceMembersPrefix.ForString(nodeID.String()))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return DefaultNamespace, nil
+		}
+		return "", err
+	}
+	return string(data), nil
+}
+
+// ListNamespaceMembers returns the namespace of every node assigned to one.
+// Nodes missing from the map are in the default namespace.
+func ListNamespaceMembers(ctx context.Context, st MeshStorage) (map[types.NodeID]string, error) {
+	prefix := append(NamespaceMembersPrefix, '/')
+	members := make(map[types.NodeID]string)
+	err := st.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		members[types.NodeID(strings.TrimPrefix(string(key), string(prefix)))] = string(value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// NamespacePolicy decides whether nodes may communicate based on the namespaces
+// they are in. A nil policy allows all communication.
+type NamespacePolicy struct {
+	members    map[types.NodeID]string
+	namespaces map[string]Namespace
+}
+
+// LoadNamespacePolicy builds the current NamespacePolicy from storage. A nil
+// policy is returned when no nodes have been assigned to namespaces.
+func LoadNamespacePolicy(ctx context.Context, st MeshStorage) (*NamespacePolicy, error) {
+	members, err := ListNamespaceMembers(ctx, st)
+	if err != nil {
+		return nil, fmt.Errorf("list namespace members: %w", err)
+	}
+	if len(members) == 0 {
+		return nil, nil
+	}
+	namespaces, err := ListNamespaces(ctx, st)
+	if err != nil {
+		return nil, fmt.Errorf("list namespaces: %w", err)
+	}
+	p := &NamespacePolicy{
+		members:    members,
+		namespaces: make(map[string]Namespace, len(namespaces)),
+	}
+	for _, ns := range namespaces {
+		p.namespaces[ns.Name] = ns
+	}
+	return p, nil
+}
+
+// NamespacePolicyFor loads the NamespacePolicy for the given database. A nil
+// policy is returned if the database does not expose its underlying storage,
+// such as when it is accessed remotely.
+func NamespacePolicyFor(ctx context.Context, db MeshDB) (*NamespacePolicy, error) {
+	st := MeshStorageOf(db)
+	if st == nil {
+		return nil, nil
+	}
+	return LoadNamespacePolicy(ctx, st)
+}
+
+// MeshStorageOf returns the MeshStorage the given database is built on, or nil
+// if the database does not expose one.
+func MeshStorageOf(db MeshDB) MeshStorage {
+	backed, ok := db.(interface{ MeshStorage() MeshStorage })
+	if !ok {
+		return nil
+	}
+	return backed.MeshStorage()
+}
+
+// ListNamespaceScopes returns the namespace each scoped role binding is confined
+// to, indexed by role binding name.
+func ListNamespaceScopes(ctx context.Context, st MeshStorage) (map[string]string, error) {
+	namespaces, err := ListNamespaces(ctx, st)
+	if err != nil {
+		return nil, err
+	}
+	scopes := make(map[string]string)
+	for _, ns := range namespaces {
+		for _, rb := range ns.RoleBindings {
+			scopes[rb] = ns.Name
+		}
+	}
+	return scopes, nil
+}
+
+// NamespaceOf returns the namespace of the given node.
+func (p *NamespacePolicy) NamespaceOf(nodeID types.NodeID) string {
+	if p == nil {
+		return DefaultNamespace
+	}
+	if ns, ok := p.members[nodeID]; ok {
+		return ns
+	}
+	return DefaultNamespace
+}
+
+// Allow returns true if the given nodes are in the same namespace or in
+// namespaces that peer with each other.
+func (p *NamespacePolicy) Allow(a, b types.NodeID) bool {
+	if p == nil {
+		return true
+	}
+	nsA, nsB := p.NamespaceOf(a), p.NamespaceOf(b)
+	if nsA == nsB {
+		return true
+	}
+	return p.namespaces[nsA].PeersWith(nsB) && p.namespaces[nsB].PeersWith(nsA)
+}
+
+// InNamespace returns true if the given resource name belongs to the namespace.
+// Nodes belong to the namespace they are assigned to. Other resources, such as
+// network ACLs and routes, are placed in a namespace by prefixing their names
+// with the name of the namespace and a dash.
+func InNamespace(ctx context.Context, st MeshStorage, name, resourceName string) (bool, error) {
+	if resourceName == "" {
+		return false, nil
+	}
+	if strings.HasPrefix(resourceName, name+"-") {
+		return true, nil
+	}
+	data, err := st.GetValue(ctx, NamespaceMembersPrefix.ForString(resourceName))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return string(data) == name, nil
+}
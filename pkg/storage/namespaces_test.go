@@ -0,0 +1,131 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestNamespaces(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+
+	policy, err := storage.LoadNamespacePolicy(ctx, st)
+	if err != nil {
+		t.Fatalf("load namespace policy: %v", err)
+	}
+	if !policy.Allow("node-a", "node-b") {
+		t.Fatal("expected nodes to communicate without namespaces")
+	}
+
+	if err := storage.SetNodeNamespace(ctx, st, "node-a", "team-a"); err == nil {
+		t.Fatal("expected assigning a missing namespace to fail")
+	}
+	for _, ns := range []storage.Namespace{
+		{Name: "team-a", Peers: []string{"shared"}, RoleBindings: []string{"team-a-admins"}},
+		{Name: "team-b"},
+		{Name: "shared"},
+	} {
+		if err := storage.PutNamespace(ctx, st, ns); err != nil {
+			t.Fatalf("put namespace: %v", err)
+		}
+	}
+	if err := storage.PutNamespace(ctx, st, storage.Namespace{Name: storage.DefaultNamespace, RoleBindings: []string{"admins"}}); err == nil {
+		t.Fatal("expected scoping role bindings to the default namespace to fail")
+	}
+	for node, ns := range map[string]string{"node-a": "team-a", "node-b": "team-b", "node-c": "team-a", "node-d": "shared"} {
+		if err := storage.SetNodeNamespace(ctx, st, types.NodeID(node), ns); err != nil {
+			t.Fatalf("set node namespace: %v", err)
+		}
+	}
+
+	policy, err = storage.LoadNamespacePolicy(ctx, st)
+	if err != nil {
+		t.Fatalf("load namespace policy: %v", err)
+	}
+	tc := []struct {
+		a, b  types.NodeID
+		allow bool
+	}{
+		{"node-a", "node-c", true},
+		{"node-a", "node-b", false},
+		{"node-a", "node-e", false},
+		// Peering is not mutual yet.
+		{"node-a", "node-d", false},
+	}
+	for _, c := range tc {
+		if got := policy.Allow(c.a, c.b); got != c.allow {
+			t.Errorf("expected allow %s <-> %s to be %v, got %v", c.a, c.b, c.allow, got)
+		}
+	}
+	if err := storage.PutNamespace(ctx, st, storage.Namespace{Name: "shared", Peers: []string{"team-a"}}); err != nil {
+		t.Fatalf("put namespace: %v", err)
+	}
+	policy, err = storage.LoadNamespacePolicy(ctx, st)
+	if err != nil {
+		t.Fatalf("load namespace policy: %v", err)
+	}
+	if !policy.Allow("node-a", "node-d") {
+		t.Error("expected mutually peered namespaces to communicate")
+	}
+	if policy.Allow("node-b", "node-d") {
+		t.Error("expected unpeered namespaces to be isolated")
+	}
+
+	for _, c := range []struct {
+		name string
+		in   bool
+	}{
+		{"node-a", true},
+		{"node-b", false},
+		{"team-a-routes", true},
+		{"team-b-routes", false},
+		{"", false},
+	} {
+		in, err := storage.InNamespace(ctx, st, "team-a", c.name)
+		if err != nil {
+			t.Fatalf("check namespace: %v", err)
+		}
+		if in != c.in {
+			t.Errorf("expected %q in team-a to be %v, got %v", c.name, c.in, in)
+		}
+	}
+	scopes, err := storage.ListNamespaceScopes(ctx, st)
+	if err != nil {
+		t.Fatalf("list namespace scopes: %v", err)
+	}
+	if scopes["team-a-admins"] != "team-a" {
+		t.Errorf("expected team-a-admins to be scoped to team-a, got %q", scopes["team-a-admins"])
+	}
+
+	if err := storage.DeleteNamespace(ctx, st, "team-b"); err == nil {
+		t.Error("expected deleting a namespace with nodes to fail")
+	}
+	if err := storage.SetNodeNamespace(ctx, st, "node-b", storage.DefaultNamespace); err != nil {
+		t.Fatalf("set node namespace: %v", err)
+	}
+	if err := storage.DeleteNamespace(ctx, st, "team-b"); err != nil {
+		t.Errorf("delete namespace: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// NATPrefix is where the NAT types detected by nodes are recorded in the database.
+// Records are indexed by node ID in the format /registry/nat/<id>.
+var NATPrefix = types.RegistryPrefix.ForString("nat")
+
+// NATTypeHeader is the gRPC metadata header a node sets on join and update
+// requests to report the type of NAT it detected it is behind.
+const NATTypeHeader = "x-webmesh-nat-type"
+
+// NATRecord is the NAT type last reported by a node.
+type NATRecord struct {
+	// Type is the NAT type reported by the node.
+	Type string `json:"type"`
+	// Time is when the NAT type was reported.
+	Time time.Time `json:"time"`
+}
+
+// WithNATType appends the NAT type header to the outgoing context of a join
+// or update request.
+func WithNATType(ctx context.Context, natType string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, NATTypeHeader, natType)
+}
+
+// NATTypeFromRequest returns the NAT type reported in the incoming context of
+// a join or update request, and whether one was reported.
+func NATTypeFromRequest(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get(NATTypeHeader)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[0], true
+}
+
+// GetNATType returns the NAT type last reported by the given node. An empty
+// record is returned if the node never reported one.
+func GetNATType(ctx context.Context, st MeshStorage, nodeID types.NodeID) (NATRecord, error) {
+	var rec NATRecord
+	data, err := st.GetValue(ctx, NATPrefix.ForString(nodeID.String()))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return rec, nil
+		}
+		return rec, err
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("unmarshal nat record: %w", err)
+	}
+	return rec, nil
+}
+
+// SetNATType records the NAT type reported by the given node.
+func SetNATType(ctx context.Context, st MeshStorage, nodeID types.NodeID, natType string) error {
+	data, err := json.Marshal(NATRecord{Type: natType, Time: time.Now().UTC()})
+	if err != nil {
+		return fmt.Errorf("marshal nat record: %w", err)
+	}
+	return st.PutValue(ctx, NATPrefix.ForString(nodeID.String()), data, 0)
+}
+
+// DeleteNATType removes the NAT record for the given node.
+func DeleteNATType(ctx context.Context, st MeshStorage, nodeID types.NodeID) error {
+	return st.Delete(ctx, NATPrefix.ForString(nodeID.String()))
+}
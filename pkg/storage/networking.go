@@ -17,6 +17,7 @@ limitations under the License.
 package storage
 
 import (
+	"fmt"
 	"net/netip"
 	"slices"
 	"strings"
@@ -59,6 +60,81 @@ type Networking interface {
 	ListRoutes(ctx context.Context) (types.Routes, error)
 }
 
+// IsSystemNetworkACL returns true if the named NetworkACL is managed by the
+// mesh itself, such as the bootstrap nodes ACL and the catch-all ACL enforcing
+// the default network policy.
+func IsSystemNetworkACL(name string) bool {
+	switch name {
+	case string(BootstrapNodesNetworkACLName), DefaultAcceptNetworkACLName, DefaultDenyNetworkACLName:
+		return true
+	default:
+		return false
+	}
+}
+
+// ReplaceNetworkACLs atomically replaces the full set of NetworkACLs with the
+// given ones. System ACLs are left in place unless they are included in the
+// given set.
+func ReplaceNetworkACLs(ctx context.Context, db MeshDB, acls types.NetworkACLs) error {
+	return db.Txn(ctx, func(tx MeshDB) error {
+		existing, err := tx.Networking().ListNetworkACLs(ctx)
+		if err != nil {
+			return fmt.Errorf("list network acls: %w", err)
+		}
+		keep := make(map[string]struct{}, len(acls))
+		for _, acl := range acls {
+			keep[acl.GetName()] = struct{}{}
+		}
+		for _, acl := range existing {
+			if _, ok := keep[acl.GetName()]; ok || IsSystemNetworkACL(acl.GetName()) {
+				continue
+			}
+			if err := tx.Networking().DeleteNetworkACL(ctx, acl.GetName()); err != nil {
+				return fmt.Errorf("delete network acl %q: %w", acl.GetName(), err)
+			}
+		}
+		for _, acl := range acls {
+			if err := tx.Networking().PutNetworkACL(ctx, acl); err != nil {
+				return fmt.Errorf("put network acl %q: %w", acl.GetName(), err)
+			}
+		}
+		return nil
+	})
+}
+
+// NetworkACLConflicts returns the stored NetworkACLs that conflict with the
+// given one, meaning they share its priority but not its action and may match
+// the same traffic. Group, tag, and label references are expanded on copies of the
+// ACLs before they are compared, and the originals are returned.
+func NetworkACLConflicts(ctx context.Context, db MeshDB, acl types.NetworkACL) (types.NetworkACLs, error) {
+	existing, err := db.Networking().ListNetworkACLs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("list network acls: %w", err)
+	}
+	expanded := make(types.NetworkACLs, 0, len(existing)+1)
+	byName := make(map[string]types.NetworkACL, len(existing))
+	for _, other := range existing {
+		expanded = append(expanded, other.DeepCopy())
+		byName[other.GetName()] = other
+	}
+	candidate := acl.DeepCopy()
+	expanded = append(expanded, candidate)
+	if err := ExpandACLs(ctx, db.RBAC(), expanded); err != nil {
+		return nil, fmt.Errorf("expand network acls: %w", err)
+	}
+	if err := ExpandACLTags(ctx, db.Networking(), expanded); err != nil {
+		return nil, fmt.Errorf("expand network acl tags: %w", err)
+	}
+	if err := ExpandACLLabels(ctx, db, expanded); err != nil {
+		return nil, fmt.Errorf("expand network acl labels: %w", err)
+	}
+	var out types.NetworkACLs
+	for _, conflict := range expanded[:len(expanded)-1].Conflicts(candidate) {
+		out = append(out, byName[conflict.GetName()])
+	}
+	return out, nil
+}
+
 // ExpandACLs will use the given RBAC interface to expand any group references
 // in the ACLs.
 func ExpandACLs(ctx context.Context, rbac RBAC, acls types.NetworkACLs) error {
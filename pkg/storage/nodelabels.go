@@ -0,0 +1,159 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// NodeLabelsPrefix is where the labels of nodes are stored in the database.
+// Labels are indexed by node ID in the format /registry/node-labels/<node>.
+// They are assigned by operators and outlive the node leaving the mesh, so a
+// node rejoining with the same ID keeps its labels.
+var NodeLabelsPrefix = types.RegistryPrefix.ForString("node-labels")
+
+// GetNodeLabels returns the labels of the given node. Nodes without labels
+// return empty labels.
+func GetNodeLabels(ctx context.Context, st MeshStorage, nodeID types.NodeID) (types.NodeLabels, error) {
+	labels := make(types.NodeLabels)
+	data, err := st.GetValue(ctx, NodeLabelsPrefix.ForString(nodeID.String()))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return labels, nil
+		}
+		return nil, fmt.Errorf("get node labels: %w", err)
+	}
+	if err := json.Unmarshal(data, &labels); err != nil {
+		return nil, fmt.Errorf("unmarshal node labels: %w", err)
+	}
+	return labels, nil
+}
+
+// PutNodeLabels replaces the labels of the given node. Putting empty labels
+// removes them.
+func PutNodeLabels(ctx context.Context, st MeshStorage, nodeID types.NodeID, labels types.NodeLabels) error {
+	if !nodeID.IsValid() {
+		return fmt.Errorf("%w: %s", errors.ErrInvalidNodeID, nodeID)
+	}
+	if len(labels) == 0 {
+		return DeleteNodeLabels(ctx, st, nodeID)
+	}
+	if err := labels.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return fmt.Errorf("marshal node labels: %w", err)
+	}
+	if err := st.PutValue(ctx, NodeLabelsPrefix.ForString(nodeID.String()), data, 0); err != nil {
+		return fmt.Errorf("put node labels: %w", err)
+	}
+	return nil
+}
+
+// DeleteNodeLabels removes all labels from the given node.
+func DeleteNodeLabels(ctx context.Context, st MeshStorage, nodeID types.NodeID) error {
+	if err := st.Delete(ctx, NodeLabelsPrefix.ForString(nodeID.String())); err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete node labels: %w", err)
+	}
+	return nil
+}
+
+// ListNodeLabels returns the labels of every labeled node.
+func ListNodeLabels(ctx context.Context, st MeshStorage) (map[types.NodeID]types.NodeLabels, error) {
+	out := make(map[types.NodeID]types.NodeLabels)
+	prefix := append(NodeLabelsPrefix, '/')
+	err := st.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		var labels types.NodeLabels
+		if err := json.Unmarshal(value, &labels); err != nil {
+			return fmt.Errorf("unmarshal node labels %s: %w", key, err)
+		}
+		out[types.NodeID(bytes.TrimPrefix(key, prefix))] = labels
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list node labels: %w", err)
+	}
+	return out, nil
+}
+
+// ExpandACLLabels expands label selector references in the nodes of the given
+// ACLs to the IDs of the nodes in the mesh they select. References are kept in place so
+// that an ACL whose selector matches no nodes matches nothing. Nothing is
+// expanded if the database does not expose its underlying storage.
+func ExpandACLLabels(ctx context.Context, db MeshDB, acls types.NetworkACLs) error {
+	st := MeshStorageOf(db)
+	if st == nil {
+		return nil
+	}
+	var labeled map[types.NodeID]types.NodeLabels
+	var nodeIDs []types.NodeID
+	expand := func(nodes []string) ([]string, error) {
+		var out []string
+		for _, node := range nodes {
+			out = append(out, node)
+			ref, ok := strings.CutPrefix(node, types.LabelReference)
+			if !ok {
+				continue
+			}
+			selector, err := types.ParseLabelSelector(ref)
+			if err != nil {
+				// Invalid selectors are rejected when ACLs are put and
+				// otherwise select nothing.
+				continue
+			}
+			if labeled == nil {
+				labeled, err = ListNodeLabels(ctx, st)
+				if err != nil {
+					return nil, err
+				}
+				nodeIDs, err = db.Peers().ListIDs(ctx)
+				if err != nil {
+					return nil, fmt.Errorf("list node ids: %w", err)
+				}
+				sort.Slice(nodeIDs, func(i, j int) bool { return nodeIDs[i] < nodeIDs[j] })
+			}
+			for _, id := range nodeIDs {
+				if selector.Matches(labeled[id]) && !slices.Contains(out, id.String()) {
+					out = append(out, id.String())
+				}
+			}
+		}
+		return out, nil
+	}
+	for _, acl := range acls {
+		src, err := expand(acl.GetSourceNodes())
+		if err != nil {
+			return err
+		}
+		dst, err := expand(acl.GetDestinationNodes())
+		if err != nil {
+			return err
+		}
+		acl.SourceNodes, acl.DestinationNodes = src, dst
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestExpandACLLabels(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+
+	for i, id := range []string{"db-1", "db-2", "web-1", "bastion"} {
+		err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{
+			Id:          id,
+			PublicKey:   generateEncodedKey(t),
+			PrivateIPv4: fmt.Sprintf("172.16.0.%d/32", i+1),
+		}})
+		if err != nil {
+			t.Fatalf("put node: %v", err)
+		}
+	}
+	for id, labels := range map[types.NodeID]types.NodeLabels{
+		"db-1":  {"role": "db", "env": "prod"},
+		"db-2":  {"role": "db", "env": "dev"},
+		"web-1": {"role": "web", "env": "prod"},
+	} {
+		if err := storage.PutNodeLabels(ctx, st, id, labels); err != nil {
+			t.Fatalf("put node labels: %v", err)
+		}
+	}
+	labels, err := storage.GetNodeLabels(ctx, st, "db-1")
+	if err != nil {
+		t.Fatalf("get node labels: %v", err)
+	}
+	if labels["role"] != "db" {
+		t.Fatalf("expected db-1 to have role=db, got %v", labels)
+	}
+
+	acls := types.NetworkACLs{{NetworkACL: &v1.NetworkACL{
+		Name:             "prod-to-db",
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+		SourceNodes:      []string{"label:env=prod,role!=db", "label:!env"},
+		DestinationNodes: []string{"label:role=db"},
+	}}}
+	if err := storage.ExpandACLLabels(ctx, db, acls); err != nil {
+		t.Fatalf("expand acl labels: %v", err)
+	}
+	for _, want := range []string{"web-1", "bastion"} {
+		if !slices.Contains(acls[0].GetSourceNodes(), want) {
+			t.Errorf("expected source nodes to contain %s, got %v", want, acls[0].GetSourceNodes())
+		}
+	}
+	if slices.Contains(acls[0].GetSourceNodes(), "db-1") {
+		t.Errorf("expected source nodes not to contain db-1, got %v", acls[0].GetSourceNodes())
+	}
+	for _, want := range []string{"db-1", "db-2"} {
+		if !slices.Contains(acls[0].GetDestinationNodes(), want) {
+			t.Errorf("expected destination nodes to contain %s, got %v", want, acls[0].GetDestinationNodes())
+		}
+	}
+	action := types.NetworkAction{NetworkAction: &v1.NetworkAction{SrcNode: "web-1", DstNode: "db-2"}}
+	if !acls.Accept(ctx, action) {
+		t.Error("expected web-1 to reach db-2 through the label selectors")
+	}
+
+	// Removing the labels deselects the node.
+	if err := storage.PutNodeLabels(ctx, st, "db-2", nil); err != nil {
+		t.Fatalf("put node labels: %v", err)
+	}
+	all, err := storage.ListNodeLabels(ctx, st)
+	if err != nil {
+		t.Fatalf("list node labels: %v", err)
+	}
+	if _, ok := all["db-2"]; ok || len(all) != 2 {
+		t.Errorf("expected the labels of db-2 to be removed, got %v", all)
+	}
+}
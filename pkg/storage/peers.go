@@ -35,6 +35,14 @@ var NodesPrefix = types.RegistryPrefix.ForString("nodes")
 // in the format /registry/edges/<source>/<target>.
 var EdgesPrefix = types.RegistryPrefix.ForString("edges")
 
+// GraphVersionKey holds an opaque token that changes with every write to
+// the nodes or edges of the graph.
+var GraphVersionKey = types.RegistryPrefix.ForString("graph-version")
+
+// GraphSnapshotKey holds a compact binary snapshot of all nodes and edges
+// in the graph, tagged with the graph version it was taken at.
+var GraphSnapshotKey = types.RegistryPrefix.ForString("graph-snapshot")
+
 // PeerSubscribeFunc is a function that can be used to subscribe to peer changes.
 // The function is called with multiple peers when the change reflects a new edge
 // being added or removed. The function is called with a single peer when the
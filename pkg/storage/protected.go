@@ -0,0 +1,59 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ProtectedPrefixes are the prefixes whose keys are only written by the
+// leader on behalf of an authorized API. Raw writes to them through the
+// storage query API are rejected, since they would bypass authorization
+// and validation.
+var ProtectedPrefixes = []types.StoragePrefix{
+	MembershipHistoryPrefix,
+	QuarantinePrefix,
+	MaintenancePrefix,
+	EscrowPrefix,
+	RevokedKeysPrefix,
+	ClusterIDKey,
+	SplitBrainAlarmPrefix,
+	NamespacesPrefix,
+	NamespaceMembersPrefix,
+	GatewaysPrefix,
+	NodeAliasPrefix,
+	DefaultNetworkPolicyKey,
+	ACLExemptionsKey,
+	ACLSchedulesPrefix,
+	NodeLabelsPrefix,
+	TrafficPoliciesPrefix,
+	RouteMetricsPrefix,
+	ShardedBatchesPrefix,
+}
+
+// IsProtectedKey returns true if the given key is under one of the
+// ProtectedPrefixes.
+func IsProtectedKey(key []byte) bool {
+	for _, prefix := range ProtectedPrefixes {
+		if bytes.Equal(key, prefix) || bytes.HasPrefix(key, append(prefix[:len(prefix):len(prefix)], '/')) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"testing"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+func TestIsProtectedKey(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		key  string
+		want bool
+	}{
+		{key: storage.MembershipHistoryPrefix.String(), want: true},
+		{key: storage.MembershipHistoryPrefix.ForString("00001-node-a").String(), want: true},
+		{key: storage.MembershipHistoryPrefix.String() + "-other", want: false},
+		{key: storage.EscrowPrefix.ForString("node-a").String(), want: true},
+		{key: storage.ClusterIDKey.String(), want: true},
+		{key: storage.NamespaceMembersPrefix.ForString("node-a").String(), want: true},
+		{key: storage.NamespacesPrefix.ForString("team-a").String(), want: true},
+		{key: storage.ACLExemptionsKey.String(), want: true},
+		{key: storage.ACLSchedulesPrefix.ForString("maintenance").String(), want: true},
+		{key: storage.NodeLabelsPrefix.ForString("node-a").String(), want: true},
+		{key: storage.TrafficPoliciesPrefix.ForString("tenant-a").String(), want: true},
+		{key: storage.RouteMetricsPrefix.ForString("route-a").String(), want: true},
+		{key: storage.ShardedBatchesPrefix.ForString("commits/batch-a").String(), want: true},
+		{key: storage.NodesPrefix.ForString("node-a").String(), want: false},
+		{key: "/services/acme.v1.Widgets/node-a", want: false},
+	}
+	for _, tt := range tc {
+		if got := storage.IsProtectedKey([]byte(tt.key)); got != tt.want {
+			t.Errorf("IsProtectedKey(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+}
@@ -64,6 +64,11 @@ type MeshDB interface {
 	// Peers returns a simplified interface for managing nodes in the mesh
 	// via the underlying MeshDataStore.
 	Peers() Peers
+	// Txn runs fn against a view of the database whose writes are buffered
+	// and committed together once fn returns without error. Reads inside fn
+	// observe the buffered writes. Storage that supports it commits all writes
+	// atomically.
+	Txn(ctx context.Context, fn func(tx MeshDB) error) error
 }
 
 // MeshDataStore is an interface for storing and retrieving data about the state of the mesh.
@@ -99,6 +104,9 @@ type Consensus interface {
 	AddVoter(context.Context, types.StoragePeer) error
 	// AddObserver adds an observer to the consensus group.
 	AddObserver(context.Context, types.StoragePeer) error
+	// AddLearner adds a learner to the consensus group. Learners are permanent
+	// observers that serve reads and are never promoted to voters.
+	AddLearner(context.Context, types.StoragePeer) error
 	// DemoteVoter demotes a voter to an observer.
 	DemoteVoter(context.Context, types.StoragePeer) error
 	// RemovePeer removes a peer from the consensus group. If wait
@@ -106,6 +114,23 @@ type Consensus interface {
 	RemovePeer(ctx context.Context, peer types.StoragePeer, wait bool) error
 }
 
+// LeadershipTransferer is implemented by consensus implementations that can
+// hand leadership to a specific member.
+type LeadershipTransferer interface {
+	// TransferLeadership transfers leadership to the voter with the given ID
+	// and waits until the voter is confirmed as the new leader.
+	TransferLeadership(ctx context.Context, id string) error
+}
+
+// StatsReporter is implemented by storage providers and backends that can
+// report statistics about their internal state, such as consensus indexes
+// and on-disk sizes.
+type StatsReporter interface {
+	// Stats returns the statistics as a document of strings, numbers,
+	// booleans, and nested documents.
+	Stats() map[string]any
+}
+
 // KVSubscribeFunc is the function signature for subscribing to changes to a key.
 type KVSubscribeFunc func(key, value []byte)
 
@@ -124,8 +149,15 @@ type MeshStorage interface {
 
 	// GetValue returns the value of a key.
 	GetValue(ctx context.Context, key []byte) ([]byte, error)
+	// GetValueVersion returns the value of a key along with its version. If the
+	// key does not exist, NoVersion is returned along with the not found error.
+	GetValueVersion(ctx context.Context, key []byte) ([]byte, Version, error)
 	// PutValue sets the value of a key. TTL is optional and can be set to 0.
 	PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error
+	// CompareAndSwap sets the value of a key only if its current version matches
+	// the expected one. NoVersion expects the key to not exist. ErrVersionConflict
+	// is returned if the versions do not match. TTL is optional and can be set to 0.
+	CompareAndSwap(ctx context.Context, key, value []byte, expected Version, ttl time.Duration) error
 	// Delete removes a key.
 	Delete(ctx context.Context, key []byte) error
 	// ListKeys returns all keys with a given prefix.
@@ -137,6 +169,14 @@ type MeshStorage interface {
 	// Subscribe will call the given function whenever a key with the given prefix is changed.
 	// The returned function can be called to unsubscribe.
 	Subscribe(ctx context.Context, prefix []byte, fn KVSubscribeFunc) (context.CancelFunc, error)
+	// Watch streams the keys under a prefix. The current keys are sent first
+	// as creates, followed by every create, update, and delete. The channel
+	// is closed when the context is canceled.
+	Watch(ctx context.Context, prefix []byte) (<-chan WatchEvent, error)
+	// WriteBatch applies either all of the given writes or none of them.
+	// Implementations backed by consensus must apply the writes as a single
+	// log entry.
+	WriteBatch(ctx context.Context, ops []WriteOp) error
 }
 
 // ConsensusStorage is the interface for storing and retrieving data about the state of consensus.
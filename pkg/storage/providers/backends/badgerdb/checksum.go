@@ -0,0 +1,84 @@
+//go:build !wasm
+
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package badgerdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/hashicorp/raft"
+)
+
+// ErrChecksumMismatch is returned when a stored raft log entry fails checksum verification.
+var ErrChecksumMismatch = errors.New("log entry checksum mismatch")
+
+// logChecksumMagic marks a log entry encoded with a trailing checksum.
+// Entries written before checksums were introduced do not carry it.
+var logChecksumMagic = []byte("wmc1")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// logTrailerSize is the size of the checksum and magic appended to encoded log entries.
+const logTrailerSize = 8
+
+// Verifier is implemented by storage that can verify the checksums of
+// everything it has persisted without modifying it.
+type Verifier interface {
+	// VerifyChecksum verifies the checksums of the underlying storage.
+	VerifyChecksum() error
+}
+
+// VerifyChecksum verifies the checksums of all tables and value logs.
+func (db *badgerDB) VerifyChecksum() error {
+	if db.opts.InMemory {
+		return nil
+	}
+	return db.db.VerifyChecksum()
+}
+
+// encodeLog encodes a raft log entry followed by a CRC-32C of the encoding.
+func encodeLog(log *raft.Log) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(log); err != nil {
+		return nil, err
+	}
+	buf.Write(binary.BigEndian.AppendUint32(nil, crc32.Checksum(buf.Bytes(), crcTable)))
+	buf.Write(logChecksumMagic)
+	return buf.Bytes(), nil
+}
+
+// decodeLog decodes a raft log entry, verifying its checksum if present.
+func decodeLog(val []byte, log *raft.Log) error {
+	if len(val) >= logTrailerSize && bytes.Equal(val[len(val)-len(logChecksumMagic):], logChecksumMagic) {
+		data := val[:len(val)-logTrailerSize]
+		sum := binary.BigEndian.Uint32(val[len(data) : len(data)+4])
+		if crc32.Checksum(data, crcTable) != sum {
+			return ErrChecksumMismatch
+		}
+		val = data
+	}
+	if err := gob.NewDecoder(bytes.NewReader(val)).Decode(log); err != nil {
+		return fmt.Errorf("decode log: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+//go:build !wasm
+
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package badgerdb
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// Recoverer is implemented by storage that can verify and repair its on-disk
+// state after an unclean shutdown.
+type Recoverer interface {
+	// Recover verifies the integrity of the storage and repairs what it can.
+	// An error is returned if the storage is damaged beyond repair.
+	Recover(ctx context.Context) error
+}
+
+// Recover verifies the checksums of all tables and value logs and rebuilds the
+// raft log indexes. Log entries that fail their checksum or cannot be decoded, and any entries following
+// them or a gap in the log, are removed so that they are replicated again from
+// the leader.
+func (db *badgerDB) Recover(ctx context.Context) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.opts.InMemory {
+		return nil
+	}
+	log := context.LoggerFrom(ctx)
+	log.Debug("Verifying storage checksums")
+	if err := db.db.VerifyChecksum(); err != nil {
+		return fmt.Errorf("verify checksums: %w", err)
+	}
+	var indexes []uint64
+	var corrupt uint64
+	err := db.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = []byte(RaftLogPrefix)
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			index, err := strconv.ParseUint(string(bytes.TrimPrefix(item.Key(), []byte(RaftLogPrefix))), 10, 64)
+			if err != nil {
+				return fmt.Errorf("parse log index %q: %w", item.Key(), err)
+			}
+			indexes = append(indexes, index)
+			err = item.Value(func(val []byte) error {
+				var entry raft.Log
+				return decodeLog(val, &entry)
+			})
+			if err != nil && (corrupt == 0 || index < corrupt) {
+				corrupt = index
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scan raft log: %w", err)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	truncateFrom := corrupt
+	for i := 1; i < len(indexes); i++ {
+		if indexes[i] != indexes[i-1]+1 {
+			if truncateFrom == 0 || indexes[i] < truncateFrom {
+				truncateFrom = indexes[i]
+			}
+			break
+		}
+	}
+	if truncateFrom != 0 {
+		log.Warn("Truncating damaged raft log", slog.Uint64("from-index", truncateFrom), slog.Uint64("last-index", indexes[len(indexes)-1]))
+		err = db.db.Update(func(txn *badger.Txn) error {
+			for _, index := range indexes {
+				if index < truncateFrom {
+					continue
+				}
+				if err := txn.Delete(append([]byte(RaftLogPrefix), []byte(strconv.FormatUint(index, 10))...)); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("truncate raft log: %w", err)
+		}
+	}
+	first, last, err := getFirstAndLastIndex(db.db)
+	if err != nil {
+		return fmt.Errorf("rebuild raft log index: %w", err)
+	}
+	db.firstIdx.Store(first)
+	db.lastIdx.Store(last)
+	log.Debug("Rebuilt raft log index", slog.Uint64("first-index", first), slog.Uint64("last-index", last))
+	return nil
+}
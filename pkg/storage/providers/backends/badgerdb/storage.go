@@ -21,9 +21,9 @@ package badgerdb
 
 import (
 	"bytes"
-	"encoding/gob"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"strconv"
 	"sync"
@@ -71,10 +71,24 @@ type Options struct {
 type badgerDB struct {
 	opts              Options
 	db                *badger.DB
+	ttls              *storage.TTLReaper
 	firstIdx, lastIdx atomic.Uint64
 	mu                sync.Mutex
 }
 
+func newBadgerDB(opts Options, db *badger.DB) (*badgerDB, error) {
+	bdb := &badgerDB{
+		opts: opts,
+		db:   db,
+	}
+	bdb.ttls = storage.NewTTLReaper(bdb.expire)
+	if err := bdb.trackExisting(); err != nil {
+		bdb.ttls.Close()
+		return nil, err
+	}
+	return bdb, nil
+}
+
 // New creates a new BadgerDB storage.
 func New(opts Options) (storage.DualStorage, error) {
 	if opts.InMemory {
@@ -98,9 +112,9 @@ func New(opts Options) (storage.DualStorage, error) {
 	if err != nil {
 		return nil, err
 	}
-	bdb := &badgerDB{
-		opts: opts,
-		db:   db,
+	bdb, err := newBadgerDB(opts, db)
+	if err != nil {
+		return nil, err
 	}
 	bdb.firstIdx.Store(first)
 	bdb.lastIdx.Store(last)
@@ -120,10 +134,7 @@ func NewInMemory(opts Options) (storage.DualStorage, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &badgerDB{
-		opts: opts,
-		db:   db,
-	}, nil
+	return newBadgerDB(opts, db)
 }
 
 // NewTestStorage is a helper method for returning a new in-memory storage
@@ -174,6 +185,7 @@ func (t *TempDiskStorage) Close() error {
 func (db *badgerDB) DropAll(ctx context.Context) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
+	db.ttls.Reset()
 	return db.db.DropAll()
 }
 
@@ -199,17 +211,122 @@ func (db *badgerDB) GetValue(ctx context.Context, key []byte) ([]byte, error) {
 	return value, nil
 }
 
+// GetValueVersion returns the value of a key along with its version.
+func (db *badgerDB) GetValueVersion(ctx context.Context, key []byte) ([]byte, storage.Version, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var value []byte
+	var version storage.Version
+	err := db.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		value, err = item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		version, err = currentVersion(txn, key)
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, storage.NoVersion, errors.ErrKeyNotFound
+		}
+		return nil, storage.NoVersion, err
+	}
+	return value, version, nil
+}
+
 // PutValue sets the value of a key. TTL is optional and can be set to 0.
 func (db *badgerDB) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-	return db.db.Update(func(txn *badger.Txn) error {
-		entry := badger.NewEntry(key, value)
-		if ttl > 0 {
-			entry = entry.WithTTL(ttl)
+	entry := newEntry(key, value, ttl)
+	err := db.db.Update(func(txn *badger.Txn) error {
+		revision, err := nextRevision(ctx, txn)
+		if err != nil {
+			return err
+		}
+		if err := txn.SetEntry(entry); err != nil {
+			return err
+		}
+		return setRevision(txn, key, revision, ttl)
+	})
+	if err != nil {
+		return err
+	}
+	db.track(key, entry)
+	return nil
+}
+
+// CompareAndSwap sets the value of a key only if its current version matches the expected one.
+func (db *badgerDB) CompareAndSwap(ctx context.Context, key, value []byte, expected storage.Version, ttl time.Duration) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	entry := newEntry(key, value, ttl)
+	err := db.db.Update(func(txn *badger.Txn) error {
+		current, err := currentVersion(txn, key)
+		if err != nil {
+			return err
+		}
+		if err := storage.CheckVersion(current, expected); err != nil {
+			return err
+		}
+		revision, err := nextRevision(ctx, txn)
+		if err != nil {
+			return err
+		}
+		if err := txn.SetEntry(entry); err != nil {
+			return err
+		}
+		return setRevision(txn, key, revision, ttl)
+	})
+	if err != nil {
+		return err
+	}
+	db.track(key, entry)
+	return nil
+}
+
+// WriteBatch applies all of the given writes in a single transaction.
+func (db *badgerDB) WriteBatch(ctx context.Context, ops []storage.WriteOp) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	entries := make([]*badger.Entry, len(ops))
+	err := db.db.Update(func(txn *badger.Txn) error {
+		revision, err := nextRevision(ctx, txn)
+		if err != nil {
+			return err
+		}
+		for i, op := range ops {
+			if op.Delete {
+				if err := deleteKey(txn, op.Key); err != nil {
+					return err
+				}
+				continue
+			}
+			entries[i] = newEntry(op.Key, op.Value, op.TTL)
+			if err := txn.SetEntry(entries[i]); err != nil {
+				return err
+			}
+			if err := setRevision(txn, op.Key, revision, op.TTL); err != nil {
+				return err
+			}
 		}
-		return txn.SetEntry(entry)
+		return nil
 	})
+	if err != nil {
+		return err
+	}
+	for i, op := range ops {
+		if op.Delete {
+			db.ttls.Forget(op.Key)
+			continue
+		}
+		db.track(op.Key, entries[i])
+	}
+	return nil
 }
 
 // Delete removes a key.
@@ -217,7 +334,7 @@ func (db *badgerDB) Delete(ctx context.Context, key []byte) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	err := db.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(key)
+		return deleteKey(txn, key)
 	})
 	if err != nil {
 		if errors.Is(err, badger.ErrKeyNotFound) {
@@ -225,6 +342,7 @@ func (db *badgerDB) Delete(ctx context.Context, key []byte) error {
 		}
 		return err
 	}
+	db.ttls.Forget(key)
 	return nil
 }
 
@@ -303,6 +421,10 @@ func (db *badgerDB) Subscribe(ctx context.Context, prefix []byte, fn storage.KVS
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	ctx, cancel := context.WithCancel(ctx)
+	// Badger registers the subscriber as soon as Subscribe is called but
+	// offers no way to wait for it. Waiting for the goroutine to be about
+	// to call it narrows the window in which writes would be missed.
+	ready := make(chan struct{})
 	go func() {
 		match := []pb.Match{}
 		if len(prefix) > 0 {
@@ -315,6 +437,7 @@ func (db *badgerDB) Subscribe(ctx context.Context, prefix []byte, fn storage.KVS
 			})
 		}
 		var mu sync.Mutex
+		close(ready)
 		_ = db.db.Subscribe(ctx, func(kv *pb.KVList) error {
 			mu.Lock()
 			defer mu.Unlock()
@@ -327,39 +450,23 @@ func (db *badgerDB) Subscribe(ctx context.Context, prefix []byte, fn storage.KVS
 			return nil
 		}, match)
 	}()
+	<-ready
 	return cancel, nil
 }
 
+// Watch streams changes to keys with the given prefix.
+func (db *badgerDB) Watch(ctx context.Context, prefix []byte) (<-chan storage.WatchEvent, error) {
+	return storage.WatchPrefix(ctx, db, prefix)
+}
+
 // Snapshot returns a snapshot of the storage.
 func (db *badgerDB) Snapshot(ctx context.Context) (io.Reader, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	snapshot := &v1.RaftSnapshot{}
 	err := db.db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.Prefix = types.RegistryPrefix
-		it := txn.NewIterator(opts)
-		defer it.Close()
-		for it.Rewind(); it.Valid(); it.Next() {
-			item := it.Item()
-			if item.IsDeletedOrExpired() {
-				return nil
-			}
-			var ttl time.Duration
-			if item.ExpiresAt() > 0 {
-				ttl = time.Until(time.Unix(int64(item.ExpiresAt()), 0))
-			}
-			k := item.KeyCopy(nil)
-			value, err := item.ValueCopy(nil)
-			if err != nil {
-				return err
-			}
-			snapshot.Kv = append(snapshot.Kv, &v1.RaftDataItem{
-				Key:   k,
-				Value: value,
-				Ttl:   durationpb.New(ttl),
-			})
-			if err != nil {
+		for _, prefix := range []types.StoragePrefix{types.RegistryPrefix, types.RevisionsPrefix} {
+			if err := snapshotPrefix(txn, prefix, snapshot); err != nil {
 				return err
 			}
 		}
@@ -375,6 +482,39 @@ func (db *badgerDB) Snapshot(ctx context.Context) (io.Reader, error) {
 	return bytes.NewReader(data), nil
 }
 
+// snapshotPrefix appends the live keys under the given prefix to the snapshot.
+func snapshotPrefix(txn *badger.Txn, prefix []byte, snapshot *v1.RaftSnapshot) error {
+	opts := badger.DefaultIteratorOptions
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		item := it.Item()
+		if item.IsDeletedOrExpired() {
+			continue
+		}
+		var ttl time.Duration
+		if item.ExpiresAt() > 0 {
+			ttl = time.Until(time.Unix(int64(item.ExpiresAt()), 0))
+			if ttl <= 0 {
+				// Restoring it without a TTL would keep it forever.
+				continue
+			}
+		}
+		k := item.KeyCopy(nil)
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		snapshot.Kv = append(snapshot.Kv, &v1.RaftDataItem{
+			Key:   k,
+			Value: value,
+			Ttl:   durationpb.New(ttl),
+		})
+	}
+	return nil
+}
+
 // Restore restores a snapshot of the storage.
 func (db *badgerDB) Restore(ctx context.Context, r io.Reader) error {
 	db.mu.Lock()
@@ -395,17 +535,16 @@ func (db *badgerDB) Restore(ctx context.Context, r io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("badger restore: %w", err)
 	}
+	db.ttls.Reset()
+	entries := make([]*badger.Entry, len(snapshot.Kv))
 	err = db.db.Update(func(txn *badger.Txn) error {
-		for _, kv := range snapshot.Kv {
+		for i, kv := range snapshot.Kv {
 			var ttl time.Duration
 			if kv.Ttl != nil {
 				ttl = kv.Ttl.AsDuration()
 			}
-			entry := badger.NewEntry([]byte(kv.Key), []byte(kv.Value))
-			if ttl > 0 {
-				entry = entry.WithTTL(ttl)
-			}
-			err := txn.SetEntry(entry)
+			entries[i] = newEntry([]byte(kv.Key), []byte(kv.Value), ttl)
+			err := txn.SetEntry(entries[i])
 			if err != nil {
 				return err
 			}
@@ -415,16 +554,173 @@ func (db *badgerDB) Restore(ctx context.Context, r io.Reader) error {
 	if err != nil {
 		return fmt.Errorf("badger restore: %w", err)
 	}
+	for i, kv := range snapshot.Kv {
+		if types.RevisionsPrefix.Contains(kv.Key) {
+			// Revisions expire along with their keys.
+			continue
+		}
+		db.track(kv.Key, entries[i])
+	}
 	return nil
 }
 
+// Stats returns the on-disk sizes of the database and the tracked raft log
+// indexes.
+func (db *badgerDB) Stats() map[string]any {
+	lsm, vlog := db.db.Size()
+	return map[string]any{
+		"backend":    "badger",
+		"inMemory":   db.opts.InMemory,
+		"lsmSize":    lsm,
+		"vlogSize":   vlog,
+		"firstIndex": db.firstIdx.Load(),
+		"lastIndex":  db.lastIdx.Load(),
+	}
+}
+
 // Close closes the storage.
 func (db *badgerDB) Close() error {
+	// The reaper takes the lock to expire keys, so stop it first.
+	db.ttls.Close()
 	db.mu.Lock()
 	defer db.mu.Unlock()
 	return db.db.Close()
 }
 
+// revisionCounterKey holds the last revision recorded by the storage.
+var revisionCounterKey = []byte(types.RevisionsPrefix)
+
+// nextRevision returns the revision to record for a write. It is the one set on
+// the context, if any, or the one after the last revision recorded.
+func nextRevision(ctx context.Context, txn *badger.Txn) (uint64, error) {
+	var last uint64
+	item, err := txn.Get(revisionCounterKey)
+	if err == nil {
+		err = item.Value(func(v []byte) error {
+			last = storage.DecodeRevision(v)
+			return nil
+		})
+	}
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return 0, err
+	}
+	revision, ok := storage.RevisionFrom(ctx)
+	if !ok {
+		revision = last + 1
+	}
+	if revision > last {
+		if err := txn.Set(revisionCounterKey, storage.EncodeRevision(revision)); err != nil {
+			return 0, err
+		}
+	}
+	return revision, nil
+}
+
+// setRevision records the revision of a key written with the given TTL.
+func setRevision(txn *badger.Txn, key []byte, revision uint64, ttl time.Duration) error {
+	return txn.SetEntry(newEntry(storage.RevisionKey(key), storage.EncodeRevision(revision), ttl))
+}
+
+// currentVersion returns the version of a key, or NoVersion if it is not set.
+func currentVersion(txn *badger.Txn, key []byte) (storage.Version, error) {
+	if _, err := txn.Get(key); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return storage.NoVersion, nil
+		}
+		return storage.NoVersion, err
+	}
+	var revision uint64
+	item, err := txn.Get(storage.RevisionKey(key))
+	if err == nil {
+		err = item.Value(func(v []byte) error {
+			revision = storage.DecodeRevision(v)
+			return nil
+		})
+	}
+	if err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+		return storage.NoVersion, err
+	}
+	return storage.VersionOf(revision), nil
+}
+
+// deleteKey removes a key along with its revision.
+func deleteKey(txn *badger.Txn, key []byte) error {
+	for _, k := range [][]byte{key, storage.RevisionKey(key)} {
+		if err := txn.Delete(k); err != nil && !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// newEntry returns a new entry for the given key and value expiring after
+// the given TTL, if any.
+func newEntry(key, value []byte, ttl time.Duration) *badger.Entry {
+	entry := badger.NewEntry(key, value)
+	if ttl > 0 {
+		entry = entry.WithTTL(ttl)
+	}
+	return entry
+}
+
+// track updates the reaper with the expiry of the entry written for key.
+// Badger rewrites the key of an entry on commit, so it is passed separately.
+// It must be called with the lock held.
+func (db *badgerDB) track(key []byte, entry *badger.Entry) {
+	if entry.ExpiresAt == 0 {
+		db.ttls.Forget(key)
+		return
+	}
+	db.ttls.Track(bytes.Clone(key), time.Unix(int64(entry.ExpiresAt), 0))
+}
+
+// trackExisting tracks the keys in the registry that already have a TTL.
+func (db *badgerDB) trackExisting() error {
+	return db.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Prefix = types.RegistryPrefix
+		opts.PrefetchValues = false
+		it := txn.NewIterator(opts)
+		defer it.Close()
+		for it.Rewind(); it.Valid(); it.Next() {
+			item := it.Item()
+			if item.ExpiresAt() > 0 {
+				db.ttls.Track(item.KeyCopy(nil), time.Unix(int64(item.ExpiresAt()), 0))
+			}
+		}
+		return nil
+	})
+}
+
+// expire is called by the reaper once a key's TTL has passed. Badger already
+// hides the key, writing a tombstone delivers the removal to subscribers.
+func (db *badgerDB) expire(key []byte, _ time.Time) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.ttls.Expiry(key); ok {
+		// The key was written again with a new TTL.
+		return
+	}
+	err := db.db.Update(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err == nil {
+			// The key was written again without a TTL, or our clock is
+			// ahead of badger's idea of when it expires.
+			if item.ExpiresAt() > 0 {
+				db.ttls.Track(key, time.Unix(int64(item.ExpiresAt()), 0))
+			}
+			return nil
+		}
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+		return deleteKey(txn, key)
+	})
+	if err != nil && !errors.Is(err, badger.ErrDBClosed) {
+		slog.Default().Error("Failed to remove expired key", "key", string(key), "error", err.Error())
+	}
+}
+
 // Raft Log Storage Operations
 
 var RaftLogPrefix = types.ConsensusPrefix.For([]byte("/log/"))
@@ -453,7 +749,7 @@ func (db *badgerDB) GetLog(index uint64, log *raft.Log) error {
 		if err != nil {
 			return err
 		}
-		return gob.NewDecoder(bytes.NewReader(val)).Decode(log)
+		return decodeLog(val, log)
 	})
 	if err != nil {
 		if errors.Is(err, badger.ErrKeyNotFound) {
@@ -497,13 +793,12 @@ func (db *badgerDB) StoreLogs(logs []*raft.Log) error {
 }
 
 func (db *badgerDB) storeLog(txn *badger.Txn, log *raft.Log) error {
-	var buf bytes.Buffer
-	err := gob.NewEncoder(&buf).Encode(log)
+	data, err := encodeLog(log)
 	if err != nil {
 		return fmt.Errorf("encode log: %w", err)
 	}
 	idx := strconv.Itoa(int(log.Index))
-	err = txn.Set(append([]byte(RaftLogPrefix), []byte(idx)...), buf.Bytes())
+	err = txn.Set(append([]byte(RaftLogPrefix), []byte(idx)...), data)
 	if err != nil {
 		return fmt.Errorf("set log: %w", err)
 	}
@@ -527,7 +822,7 @@ func (db *badgerDB) DeleteRange(min, max uint64) error {
 		defer it.Close()
 		for it.Rewind(); it.Valid(); it.Next() {
 			item := it.Item()
-			k := item.Key()
+			k := item.KeyCopy(nil)
 			index := bytes.TrimPrefix(k, []byte(RaftLogPrefix))
 			idx, err := strconv.ParseUint(string(index), 10, 64)
 			if err != nil {
@@ -635,7 +930,7 @@ func getFirstAndLastIndex(db *badger.DB) (first, last uint64, err error) {
 			if err != nil {
 				return err
 			}
-			if index < first {
+			if first == 0 || index < first {
 				first = index
 			}
 			if index > last {
@@ -0,0 +1,509 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package postgres implements mesh storage using PostgreSQL.
+package postgres
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Ensure we satisfy the storage interfaces.
+var _ storage.MeshStorage = &Storage{}
+
+const (
+	// DefaultTable is the default name of the table keys are stored in.
+	DefaultTable = "webmesh_kv"
+	// DefaultPruneInterval is the default interval at which expired keys are removed.
+	DefaultPruneInterval = 5 * time.Second
+)
+
+var tableNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]{0,54}$`)
+
+// Options are the options for creating a new PostgreSQL storage.
+type Options struct {
+	// ConnString is the PostgreSQL connection string.
+	ConnString string
+	// Table is the name of the table to store keys in. It is created if it
+	// does not exist. Defaults to DefaultTable.
+	Table string
+	// PruneInterval is the interval at which expired keys are removed from
+	// the table. Expired keys are never returned, regardless of this interval,
+	// but subscribers are only told about them once they are removed.
+	// Defaults to DefaultPruneInterval.
+	PruneInterval time.Duration
+	// Logger is the logger to use. Defaults to the default logger.
+	Logger *slog.Logger
+}
+
+// Storage is a MeshStorage backed by a PostgreSQL table. Changes are
+// published with NOTIFY so that subscribers on every node sharing the
+// table observe them.
+type Storage struct {
+	opts    Options
+	pool    *pgxpool.Pool
+	table   string
+	channel string
+	log     *slog.Logger
+	subs    map[uint64]subscription
+	nextSub uint64
+	listen  context.CancelFunc
+	stop    context.CancelFunc
+	submu   sync.Mutex
+}
+
+type subscription struct {
+	prefix []byte
+	fn     storage.KVSubscribeFunc
+}
+
+// New connects to the database and returns a new PostgreSQL storage.
+// The table is created if it does not exist.
+func New(ctx context.Context, opts Options) (*Storage, error) {
+	if opts.Table == "" {
+		opts.Table = DefaultTable
+	}
+	if !tableNameRegex.MatchString(opts.Table) {
+		return nil, fmt.Errorf("invalid table name %q", opts.Table)
+	}
+	if opts.PruneInterval <= 0 {
+		opts.PruneInterval = DefaultPruneInterval
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	pool, err := pgxpool.New(ctx, opts.ConnString)
+	if err != nil {
+		return nil, fmt.Errorf("connect to postgres: %w", err)
+	}
+	st := &Storage{
+		opts:    opts,
+		pool:    pool,
+		table:   pgx.Identifier{opts.Table}.Sanitize(),
+		channel: opts.Table + "_changes",
+		log:     opts.Logger.With("component", "postgres-storage"),
+		subs:    make(map[uint64]subscription),
+	}
+	_, err = pool.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+		key BYTEA PRIMARY KEY,
+		value BYTEA NOT NULL,
+		expires_at TIMESTAMPTZ,
+		revision BIGSERIAL
+	)`, st.table))
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("create table: %w", err)
+	}
+	// Tables created before revisions were recorded lack the column.
+	var hasRevision bool
+	err = pool.QueryRow(ctx, `SELECT EXISTS (
+		SELECT 1 FROM information_schema.columns WHERE table_name = $1 AND column_name = 'revision'
+	)`, opts.Table).Scan(&hasRevision)
+	if err == nil && !hasRevision {
+		_, err = pool.Exec(ctx, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN revision BIGSERIAL`, st.table))
+	}
+	if err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("add revision column: %w", err)
+	}
+	pruneCtx, stop := context.WithCancel(context.Background())
+	st.stop = stop
+	go st.pruneExpired(pruneCtx)
+	return st, nil
+}
+
+// Table returns the name of the table keys are stored in.
+func (st *Storage) Table() string {
+	return st.opts.Table
+}
+
+// GetValue returns the value of a key.
+func (st *Storage) GetValue(ctx context.Context, key []byte) ([]byte, error) {
+	var value []byte
+	err := st.pool.QueryRow(ctx, fmt.Sprintf(
+		`SELECT value FROM %s WHERE key = $1 AND %s`, st.table, liveCondition,
+	), key).Scan(&value)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, errors.ErrKeyNotFound
+		}
+		return nil, fmt.Errorf("get value: %w", err)
+	}
+	return value, nil
+}
+
+// GetValueVersion returns the value of a key along with its version.
+func (st *Storage) GetValueVersion(ctx context.Context, key []byte) ([]byte, storage.Version, error) {
+	var value []byte
+	var revision int64
+	err := st.pool.QueryRow(ctx, fmt.Sprintf(
+		`SELECT value, revision FROM %s WHERE key = $1 AND %s`, st.table, liveCondition,
+	), key).Scan(&value, &revision)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, storage.NoVersion, errors.ErrKeyNotFound
+		}
+		return nil, storage.NoVersion, fmt.Errorf("get value: %w", err)
+	}
+	return value, storage.VersionOf(uint64(revision)), nil
+}
+
+// PutValue sets the value of a key. TTL is optional and can be set to 0.
+func (st *Storage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
+	return st.inTx(ctx, func(tx pgx.Tx) error {
+		return st.put(ctx, tx, key, value, ttl)
+	})
+}
+
+// CompareAndSwap sets the value of a key only if its current version matches the expected one.
+func (st *Storage) CompareAndSwap(ctx context.Context, key, value []byte, expected storage.Version, ttl time.Duration) error {
+	return st.inTx(ctx, func(tx pgx.Tx) error {
+		// Serialize writers of the key, including ones creating it.
+		_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended(encode($1, 'hex'), 0))`, key)
+		if err != nil {
+			return fmt.Errorf("lock key: %w", err)
+		}
+		current := storage.NoVersion
+		var revision int64
+		err = tx.QueryRow(ctx, fmt.Sprintf(
+			`SELECT revision FROM %s WHERE key = $1 AND %s`, st.table, liveCondition,
+		), key).Scan(&revision)
+		switch {
+		case err == nil:
+			current = storage.VersionOf(uint64(revision))
+		case !errors.Is(err, pgx.ErrNoRows):
+			return fmt.Errorf("get revision: %w", err)
+		}
+		if err := storage.CheckVersion(current, expected); err != nil {
+			return err
+		}
+		return st.put(ctx, tx, key, value, ttl)
+	})
+}
+
+// WriteBatch applies all of the given writes in a single transaction.
+func (st *Storage) WriteBatch(ctx context.Context, ops []storage.WriteOp) error {
+	return st.inTx(ctx, func(tx pgx.Tx) error {
+		for _, op := range ops {
+			var err error
+			if op.Delete {
+				err = st.delete(ctx, tx, op.Key)
+			} else {
+				err = st.put(ctx, tx, op.Key, op.Value, op.TTL)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes a key.
+func (st *Storage) Delete(ctx context.Context, key []byte) error {
+	return st.inTx(ctx, func(tx pgx.Tx) error {
+		return st.delete(ctx, tx, key)
+	})
+}
+
+// DropAll deletes all keys.
+func (st *Storage) DropAll(ctx context.Context) error {
+	_, err := st.pool.Exec(ctx, fmt.Sprintf(`DELETE FROM %s`, st.table))
+	return err
+}
+
+// ListKeys returns all keys with a given prefix.
+func (st *Storage) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	var out [][]byte
+	err := st.iter(ctx, "key", prefix, func(rows pgx.Rows) error {
+		var key []byte
+		if err := rows.Scan(&key); err != nil {
+			return err
+		}
+		out = append(out, key)
+		return nil
+	})
+	return out, err
+}
+
+// IterPrefix iterates over all keys with a given prefix. It is important
+// that the iterator not attempt any write operations as this will cause
+// a deadlock. The iteration will stop if the iterator returns an error.
+func (st *Storage) IterPrefix(ctx context.Context, prefix []byte, fn storage.PrefixIterator) error {
+	err := st.iter(ctx, "key, value", prefix, func(rows pgx.Rows) error {
+		var key, value []byte
+		if err := rows.Scan(&key, &value); err != nil {
+			return err
+		}
+		return fn(key, value)
+	})
+	if errors.Is(err, storage.ErrStopIteration) {
+		return nil
+	}
+	return err
+}
+
+// Subscribe will call the given function whenever a key with the given prefix is changed.
+// The returned function can be called to unsubscribe.
+func (st *Storage) Subscribe(ctx context.Context, prefix []byte, fn storage.KVSubscribeFunc) (context.CancelFunc, error) {
+	if len(prefix) == 0 {
+		prefix = types.RegistryPrefix
+	}
+	st.submu.Lock()
+	defer st.submu.Unlock()
+	if st.listen == nil {
+		listenCtx, cancel := context.WithCancel(context.Background())
+		st.listen = cancel
+		ready := make(chan error, 1)
+		go st.listenChanges(listenCtx, ready)
+		if err := <-ready; err != nil {
+			cancel()
+			st.listen = nil
+			return nil, err
+		}
+	}
+	id := st.nextSub
+	st.nextSub++
+	st.subs[id] = subscription{prefix: prefix, fn: fn}
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		<-ctx.Done()
+		st.submu.Lock()
+		defer st.submu.Unlock()
+		delete(st.subs, id)
+	}()
+	return cancel, nil
+}
+
+// Watch streams changes to keys with the given prefix.
+func (st *Storage) Watch(ctx context.Context, prefix []byte) (<-chan storage.WatchEvent, error) {
+	return storage.WatchPrefix(ctx, st, prefix)
+}
+
+// Close closes the connections to the database.
+func (st *Storage) Close() error {
+	st.submu.Lock()
+	if st.listen != nil {
+		st.listen()
+		st.listen = nil
+	}
+	st.submu.Unlock()
+	st.stop()
+	st.pool.Close()
+	return nil
+}
+
+// liveCondition matches keys that have not expired.
+const liveCondition = `(expires_at IS NULL OR expires_at > now())`
+
+func (st *Storage) put(ctx context.Context, tx pgx.Tx, key, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return errors.ErrInvalidKey
+	}
+	_, err := tx.Exec(ctx, fmt.Sprintf(`INSERT INTO %s (key, value, expires_at)
+		VALUES ($1, $2, CASE WHEN $3::bigint > 0 THEN now() + $3::bigint * interval '1 microsecond' END)
+		ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, expires_at = EXCLUDED.expires_at, revision = DEFAULT`, st.table),
+		key, value, ttl.Microseconds())
+	if err != nil {
+		return fmt.Errorf("put value: %w", err)
+	}
+	return st.notify(ctx, tx, key)
+}
+
+func (st *Storage) delete(ctx context.Context, tx pgx.Tx, key []byte) error {
+	tag, err := tx.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE key = $1`, st.table), key)
+	if err != nil {
+		return fmt.Errorf("delete key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return nil
+	}
+	return st.notify(ctx, tx, key)
+}
+
+// notify publishes a change to the given key. Notifications are only
+// delivered once the transaction commits.
+func (st *Storage) notify(ctx context.Context, tx pgx.Tx, key []byte) error {
+	_, err := tx.Exec(ctx, `SELECT pg_notify($1, $2)`, st.channel, hex.EncodeToString(key))
+	if err != nil {
+		return fmt.Errorf("notify change: %w", err)
+	}
+	return nil
+}
+
+func (st *Storage) inTx(ctx context.Context, fn func(tx pgx.Tx) error) error {
+	tx, err := st.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (st *Storage) iter(ctx context.Context, columns string, prefix []byte, fn func(pgx.Rows) error) error {
+	query := fmt.Sprintf(`SELECT %s FROM %s WHERE key >= $1 AND %s`, columns, st.table, liveCondition)
+	args := []any{prefix}
+	if end := prefixEnd(prefix); end != nil {
+		query += ` AND key < $2`
+		args = append(args, end)
+	}
+	rows, err := st.pool.Query(ctx, query+` ORDER BY key`, args...)
+	if err != nil {
+		return fmt.Errorf("query keys: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// prefixEnd returns the smallest key greater than every key with the given
+// prefix, or nil if there is none.
+func prefixEnd(prefix []byte) []byte {
+	end := bytes.Clone(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// listenChanges listens for change notifications and dispatches them to
+// subscribers until the context is canceled. The result of the first LISTEN
+// is sent on ready. The listener reconnects if the connection is lost.
+func (st *Storage) listenChanges(ctx context.Context, ready chan<- error) {
+	for {
+		err := st.listenOnce(ctx, ready)
+		ready = nil
+		if ctx.Err() != nil {
+			return
+		}
+		st.log.Error("Change listener failed, reconnecting", "error", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+func (st *Storage) listenOnce(ctx context.Context, ready chan<- error) error {
+	conn, err := st.pool.Acquire(ctx)
+	if err == nil {
+		_, err = conn.Exec(ctx, "LISTEN "+pgx.Identifier{st.channel}.Sanitize())
+		if err != nil {
+			conn.Release()
+		}
+	}
+	if ready != nil {
+		ready <- err
+	}
+	if err != nil {
+		return err
+	}
+	// The connection is closed rather than returned to the pool so
+	// that it does not keep listening.
+	defer conn.Hijack().Close(context.Background())
+	for {
+		n, err := conn.Conn().WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		key, err := hex.DecodeString(n.Payload)
+		if err != nil {
+			st.log.Warn("Ignoring malformed change notification", "payload", n.Payload)
+			continue
+		}
+		st.dispatch(ctx, key)
+	}
+}
+
+func (st *Storage) dispatch(ctx context.Context, key []byte) {
+	st.submu.Lock()
+	var subs []subscription
+	for _, sub := range st.subs {
+		if bytes.HasPrefix(key, sub.prefix) {
+			subs = append(subs, sub)
+		}
+	}
+	st.submu.Unlock()
+	if len(subs) == 0 {
+		return
+	}
+	// Notifications only carry the key, subscribers receive the current value
+	// and an empty value for deleted keys.
+	value, err := st.GetValue(ctx, key)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		st.log.Error("Failed to get changed value", "key", string(key), "error", err)
+		return
+	}
+	for _, sub := range subs {
+		sub.fn(key, value)
+	}
+}
+
+func (st *Storage) pruneExpired(ctx context.Context) {
+	t := time.NewTicker(st.opts.PruneInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			err := st.inTx(ctx, func(tx pgx.Tx) error {
+				rows, err := tx.Query(ctx, fmt.Sprintf(`DELETE FROM %s WHERE expires_at <= now() RETURNING key`, st.table))
+				if err != nil {
+					return err
+				}
+				keys, err := pgx.CollectRows(rows, pgx.RowTo[[]byte])
+				if err != nil {
+					return err
+				}
+				for _, key := range keys {
+					if err := st.notify(ctx, tx, key); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil && ctx.Err() == nil {
+				st.log.Error("Failed to prune expired keys", "error", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/testutil"
+)
+
+// testDSNEnv is the environment variable holding the connection string of
+// a database to run the conformance tests against.
+const testDSNEnv = "WEBMESH_TEST_POSTGRES_DSN"
+
+func TestPostgresStorageConformance(t *testing.T) {
+	dsn, ok := os.LookupEnv(testDSNEnv)
+	if !ok {
+		t.Skipf("%s is not set", testDSNEnv)
+	}
+	ctx := context.Background()
+	st, err := New(ctx, Options{ConnString: dsn, Table: "webmesh_conformance", PruneInterval: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+	testutil.TestMeshStorageConformance(ctx, t, st)
+}
+
+func TestPrefixEnd(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		prefix, want []byte
+	}{
+		{[]byte("/registry/"), []byte("/registry0")},
+		{[]byte("a\xff"), []byte("b")},
+		{[]byte("\xff\xff"), nil},
+		{nil, nil},
+	}
+	for _, tt := range tc {
+		if got := prefixEnd(tt.prefix); !bytes.Equal(got, tt.want) {
+			t.Errorf("prefixEnd(%q) = %q, want %q", tt.prefix, got, tt.want)
+		}
+	}
+}
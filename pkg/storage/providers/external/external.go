@@ -321,6 +321,12 @@ func (ext *Consensus) AddObserver(ctx context.Context, peer types.StoragePeer) e
 	return nil
 }
 
+// AddLearner adds a learner to the consensus group. The external storage API
+// has no notion of learners, so they are added as observers.
+func (ext *Consensus) AddLearner(ctx context.Context, peer types.StoragePeer) error {
+	return ext.AddObserver(ctx, peer)
+}
+
 // DemoteVoter demotes a voter to an observer.
 func (ext *Consensus) DemoteVoter(ctx context.Context, peer types.StoragePeer) error {
 	ext.mu.Lock()
@@ -381,6 +387,11 @@ func (ext *ExternalStorage) GetValue(ctx context.Context, key []byte) ([]byte, e
 	return resp.GetValue().GetValue(), nil
 }
 
+// GetValueVersion is not supported by external storage plugins.
+func (ext *ExternalStorage) GetValueVersion(ctx context.Context, key []byte) ([]byte, storage.Version, error) {
+	return nil, storage.NoVersion, fmt.Errorf("%w: versions on external storage", errors.ErrNotImplemented)
+}
+
 // PutValue sets the value of a key. TTL is optional and can be set to 0.
 func (ext *ExternalStorage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
 	ext.mu.Lock()
@@ -407,6 +418,11 @@ func (ext *ExternalStorage) PutValue(ctx context.Context, key, value []byte, ttl
 	return nil
 }
 
+// CompareAndSwap is not supported by external storage plugins.
+func (ext *ExternalStorage) CompareAndSwap(ctx context.Context, key, value []byte, expected storage.Version, ttl time.Duration) error {
+	return fmt.Errorf("%w: compare-and-swap on external storage", errors.ErrNotImplemented)
+}
+
 // Delete removes a key.
 func (ext *ExternalStorage) Delete(ctx context.Context, key []byte) error {
 	ext.mu.Lock()
@@ -427,6 +443,12 @@ func (ext *ExternalStorage) Delete(ctx context.Context, key []byte) error {
 	return nil
 }
 
+// WriteBatch applies the given writes one at a time. The external storage API
+// has no batch operation, so the writes are not applied atomically.
+func (ext *ExternalStorage) WriteBatch(ctx context.Context, ops []storage.WriteOp) error {
+	return storage.ApplyWriteOps(ctx, ext, ops)
+}
+
 // ListKeys returns all keys with a given prefix.
 func (ext *ExternalStorage) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error) {
 	ext.mu.RLock()
@@ -511,3 +533,8 @@ func (ext *ExternalStorage) Subscribe(ctx context.Context, prefix []byte, fn sto
 	}()
 	return cancel, nil
 }
+
+// Watch streams changes to keys with the given prefix.
+func (ext *ExternalStorage) Watch(ctx context.Context, prefix []byte) (<-chan storage.WatchEvent, error) {
+	return storage.WatchPrefix(ctx, ext, prefix)
+}
@@ -21,12 +21,14 @@ package passthrough
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
 	"sync"
 	"time"
 
 	v1 "github.com/webmeshproj/api/go/v1"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/durationpb"
 
@@ -233,6 +235,11 @@ func (p *Consensus) AddObserver(context.Context, types.StoragePeer) error {
 	return errors.ErrNotStorageNode
 }
 
+// AddLearner adds a learner to the consensus group.
+func (p *Consensus) AddLearner(context.Context, types.StoragePeer) error {
+	return errors.ErrNotStorageNode
+}
+
 // DemoteVoter demotes a voter to an observer.
 func (p *Consensus) DemoteVoter(context.Context, types.StoragePeer) error {
 	return errors.ErrNotStorageNode
@@ -272,6 +279,12 @@ func (p *Storage) GetValue(ctx context.Context, key []byte) ([]byte, error) {
 	return resp.GetItems()[0], nil
 }
 
+// GetValueVersion is not supported on passthrough storage. The query API does
+// not return versions.
+func (p *Storage) GetValueVersion(ctx context.Context, key []byte) ([]byte, storage.Version, error) {
+	return nil, storage.NoVersion, fmt.Errorf("%w: versions over the query API", errors.ErrNotImplemented)
+}
+
 // PutValue sets the value of a key. TTL is optional and can be set to 0.
 func (p *Storage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
 	if !types.IsValidPathID(string(key)) {
@@ -292,11 +305,40 @@ func (p *Storage) PutValue(ctx context.Context, key, value []byte, ttl time.Dura
 	return err
 }
 
+// CompareAndSwap sets the value of a key only if its current version matches
+// the expected version. The expected version is forwarded to the publish API.
+func (p *Storage) CompareAndSwap(ctx context.Context, key, value []byte, expected storage.Version, ttl time.Duration) error {
+	if !types.IsValidPathID(string(key)) {
+		return errors.ErrInvalidKey
+	}
+	cli, close, err := p.newStorageClient(ctx)
+	if err != nil {
+		return err
+	}
+	defer close()
+	ctx = metadata.AppendToOutgoingContext(ctx, storage.ExpectedVersionHeader, expected.String())
+	_, err = cli.Publish(ctx, &v1.PublishRequest{
+		Key:   key,
+		Value: value,
+		Ttl:   durationpb.New(ttl),
+	})
+	if status.Code(err) == codes.Aborted {
+		return fmt.Errorf("%w: %s", errors.ErrVersionConflict, status.Convert(err).Message())
+	}
+	return err
+}
+
 // Delete removes a key.
 func (p *Storage) Delete(ctx context.Context, key []byte) error {
 	return errors.ErrNotStorageNode
 }
 
+// WriteBatch is not supported on passthrough storage. Writes should be made
+// against a storage node.
+func (p *Storage) WriteBatch(ctx context.Context, ops []storage.WriteOp) error {
+	return errors.ErrNotStorageNode
+}
+
 // ListKeys returns all keys with a given prefix.
 func (p *Storage) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error) {
 	cli, close, err := p.newStorageClient(ctx)
@@ -400,6 +442,11 @@ func (p *Storage) Subscribe(ctx context.Context, prefix []byte, fn storage.KVSub
 	return cancel, nil
 }
 
+// Watch streams changes to keys with the given prefix.
+func (p *Storage) Watch(ctx context.Context, prefix []byte) (<-chan storage.WatchEvent, error) {
+	return storage.WatchPrefix(ctx, p, prefix)
+}
+
 func (p *Storage) doSubscribe(ctx context.Context, prefix []byte, fn storage.KVSubscribeFunc) error {
 	cli, close, err := p.newStorageClient(ctx)
 	if err != nil {
@@ -0,0 +1,429 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package postgresstorage provides a storage provider that keeps mesh state in
+// a PostgreSQL database shared by all storage members. Leadership is held by the
+// member owning a session-level advisory lock on the database.
+package postgresstorage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/logging"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/postgres"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Ensure we satisfy the provider interface.
+var _ storage.Provider = &Provider{}
+var _ storage.Consensus = &Consensus{}
+
+// DefaultHeartbeatInterval is the default interval at which members refresh
+// their membership and the leader verifies its lock.
+const DefaultHeartbeatInterval = 2 * time.Second
+
+var (
+	// MembersPrefix is the prefix where storage members are registered.
+	MembersPrefix = types.ConsensusPrefix.For([]byte("members"))
+	// BootstrappedKey marks the database as bootstrapped.
+	BootstrappedKey = types.ConsensusPrefix.For([]byte("bootstrapped"))
+)
+
+// Options are the options for the PostgreSQL storage provider.
+type Options struct {
+	// NodeID is the ID of the node.
+	NodeID types.NodeID
+	// ConnString is the PostgreSQL connection string.
+	ConnString string
+	// Table is the name of the table to store keys in. Members sharing a
+	// table form one storage group.
+	Table string
+	// HeartbeatInterval is the interval at which members refresh their
+	// membership. Members are considered gone after three missed heartbeats.
+	HeartbeatInterval time.Duration
+	// LogLevel is the log level for the storage provider.
+	LogLevel string
+	// LogFormat is the log format for the storage provider.
+	LogFormat string
+}
+
+// member is the record of a storage member.
+type member struct {
+	ID     string `json:"id"`
+	Leader bool   `json:"leader"`
+}
+
+// Provider is a storage provider that uses a PostgreSQL database.
+type Provider struct {
+	Options
+	storage   *postgres.Storage
+	meshdb    storage.MeshDB
+	consensus *Consensus
+	lockConn  *pgx.Conn
+	leader    bool
+	pauseTill time.Time
+	stop      context.CancelFunc
+	done      chan struct{}
+	log       *slog.Logger
+	mu        sync.RWMutex
+}
+
+// NewProvider returns a new PostgreSQL storage provider.
+func NewProvider(opts Options) *Provider {
+	if opts.Table == "" {
+		opts.Table = postgres.DefaultTable
+	}
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	p := &Provider{
+		Options: opts,
+		log:     logging.NewLogger(opts.LogLevel, opts.LogFormat).With("component", "storage-provider", "provider", "postgres"),
+	}
+	p.consensus = &Consensus{p}
+	return p
+}
+
+// MeshStorage returns the underlying MeshStorage instance.
+func (p *Provider) MeshStorage() storage.MeshStorage {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.storage == nil {
+		return nil
+	}
+	return p.storage
+}
+
+// MeshDB returns the underlying MeshDB instance.
+func (p *Provider) MeshDB() storage.MeshDB {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.meshdb
+}
+
+// Consensus returns the underlying Consensus instance.
+func (p *Provider) Consensus() storage.Consensus {
+	return p.consensus
+}
+
+// Start connects to the database and starts campaigning for leadership.
+func (p *Provider) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.storage != nil {
+		return errors.ErrStarted
+	}
+	st, err := postgres.New(ctx, postgres.Options{
+		ConnString: p.ConnString,
+		Table:      p.Table,
+		Logger:     p.log,
+	})
+	if err != nil {
+		return err
+	}
+	p.storage = st
+	p.meshdb = meshdb.NewFromStorage(st)
+	runCtx, cancel := context.WithCancel(context.Background())
+	p.stop = cancel
+	p.done = make(chan struct{})
+	p.heartbeat(ctx)
+	go p.run(runCtx)
+	return nil
+}
+
+// Bootstrap marks the database as bootstrapped. ErrAlreadyBootstrapped is
+// returned if another member already did.
+func (p *Provider) Bootstrap(ctx context.Context) error {
+	st := p.MeshStorage()
+	if st == nil {
+		return errors.ErrClosed
+	}
+	err := st.CompareAndSwap(ctx, BootstrappedKey, []byte(p.NodeID.String()), storage.NoVersion, 0)
+	if err != nil {
+		if errors.IsVersionConflict(err) {
+			return errors.ErrAlreadyBootstrapped
+		}
+		return fmt.Errorf("bootstrap: %w", err)
+	}
+	return nil
+}
+
+// ListenPort returns zero as members do not listen for storage traffic.
+func (p *Provider) ListenPort() uint16 {
+	return 0
+}
+
+// Status returns the status of the storage provider.
+func (p *Provider) Status() *v1.StorageStatus {
+	p.mu.RLock()
+	started, leader := p.storage != nil, p.leader
+	p.mu.RUnlock()
+	if !started {
+		return &v1.StorageStatus{
+			Message: errors.ErrClosed.Error(),
+		}
+	}
+	status := &v1.StorageStatus{
+		IsWritable:    true,
+		ClusterStatus: v1.ClusterStatus_CLUSTER_VOTER,
+	}
+	if leader {
+		status.ClusterStatus = v1.ClusterStatus_CLUSTER_LEADER
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+	peers, err := p.consensus.GetPeers(ctx)
+	if err != nil {
+		status.Message = err.Error()
+		return status
+	}
+	for _, peer := range peers {
+		status.Peers = append(status.Peers, peer.StoragePeer)
+	}
+	return status
+}
+
+// Close stops campaigning, withdraws the membership of the node, and closes
+// the connections to the database.
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	if p.storage == nil {
+		p.mu.Unlock()
+		return errors.ErrClosed
+	}
+	p.stop()
+	p.mu.Unlock()
+	<-p.done
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := p.storage.Delete(ctx, MembersPrefix.ForString(p.NodeID.String())); err != nil {
+		p.log.Warn("Failed to withdraw membership", "error", err.Error())
+	}
+	p.releaseLock(ctx)
+	err := p.storage.Close()
+	p.storage = nil
+	return err
+}
+
+func (p *Provider) run(ctx context.Context) {
+	defer close(p.done)
+	t := time.NewTicker(p.HeartbeatInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			p.mu.Lock()
+			p.heartbeat(ctx)
+			p.mu.Unlock()
+		}
+	}
+}
+
+// lockID returns the advisory lock key for the storage group.
+func (p *Provider) lockID() string {
+	return "webmesh-leader/" + p.Table
+}
+
+// heartbeat verifies or campaigns for leadership and refreshes the
+// membership of the node. It must be called with the lock held.
+func (p *Provider) heartbeat(ctx context.Context) {
+	switch {
+	case p.lockConn != nil:
+		if err := p.lockConn.Ping(ctx); err != nil {
+			p.log.Warn("Lost connection holding leadership", "error", err.Error())
+			p.releaseLock(ctx)
+		}
+	case time.Now().After(p.pauseTill):
+		p.campaign(ctx)
+	}
+	data, err := json.Marshal(member{ID: p.NodeID.String(), Leader: p.leader})
+	if err != nil {
+		p.log.Error("Failed to encode membership", "error", err.Error())
+		return
+	}
+	err = p.storage.PutValue(ctx, MembersPrefix.ForString(p.NodeID.String()), data, 3*p.HeartbeatInterval)
+	if err != nil && ctx.Err() == nil {
+		p.log.Error("Failed to refresh membership", "error", err.Error())
+	}
+}
+
+func (p *Provider) campaign(ctx context.Context) {
+	conn, err := pgx.Connect(ctx, p.ConnString)
+	if err != nil {
+		p.log.Error("Failed to connect for leader election", "error", err.Error())
+		return
+	}
+	var acquired bool
+	err = conn.QueryRow(ctx, `SELECT pg_try_advisory_lock(hashtextextended($1, 0))`, p.lockID()).Scan(&acquired)
+	if err != nil || !acquired {
+		if err != nil {
+			p.log.Error("Failed to campaign for leadership", "error", err.Error())
+		}
+		_ = conn.Close(ctx)
+		return
+	}
+	p.log.Info("Acquired storage leadership")
+	p.lockConn = conn
+	p.leader = true
+}
+
+// releaseLock gives up leadership. It must be called with the lock held.
+func (p *Provider) releaseLock(ctx context.Context) {
+	if p.lockConn == nil {
+		return
+	}
+	// Closing the session releases the advisory lock.
+	_ = p.lockConn.Close(ctx)
+	p.lockConn = nil
+	p.leader = false
+}
+
+// Consensus is a consensus implementation backed by the membership records
+// and the leadership lock in the database.
+type Consensus struct {
+	*Provider
+}
+
+// IsLeader returns true if the node holds the leadership lock.
+func (c *Consensus) IsLeader() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.leader
+}
+
+// IsMember returns true if the node is a member of the storage group.
+// Every node with access to the database is a member.
+func (c *Consensus) IsMember() bool {
+	return true
+}
+
+// StepDown releases the leadership lock and refrains from campaigning for
+// a few heartbeats so that another member can take over.
+func (c *Consensus) StepDown(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.leader {
+		return errors.ErrNotLeader
+	}
+	c.releaseLock(ctx)
+	c.pauseTill = time.Now().Add(3 * c.HeartbeatInterval)
+	return nil
+}
+
+// GetPeers returns the members with a current membership record.
+func (c *Consensus) GetPeers(ctx context.Context) ([]types.StoragePeer, error) {
+	st := c.MeshStorage()
+	if st == nil {
+		return nil, errors.ErrClosed
+	}
+	var out []types.StoragePeer
+	err := st.IterPrefix(ctx, append(MembersPrefix, '/'), func(_, value []byte) error {
+		var m member
+		if err := json.Unmarshal(value, &m); err != nil {
+			return fmt.Errorf("decode member: %w", err)
+		}
+		status := v1.ClusterStatus_CLUSTER_VOTER
+		if m.Leader {
+			status = v1.ClusterStatus_CLUSTER_LEADER
+		}
+		out = append(out, types.StoragePeer{StoragePeer: &v1.StoragePeer{
+			Id:            m.ID,
+			ClusterStatus: status,
+		}})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].GetId() < out[j].GetId()
+	})
+	return out, nil
+}
+
+// GetPeer returns the peer with the given ID.
+func (c *Consensus) GetPeer(ctx context.Context, id string) (types.StoragePeer, error) {
+	peers, err := c.GetPeers(ctx)
+	if err != nil {
+		return types.StoragePeer{}, err
+	}
+	for _, peer := range peers {
+		if peer.GetId() == id {
+			return peer, nil
+		}
+	}
+	return types.StoragePeer{}, errors.ErrNodeNotFound
+}
+
+// GetLeader returns the member holding the leadership lock.
+func (c *Consensus) GetLeader(ctx context.Context) (types.StoragePeer, error) {
+	peers, err := c.GetPeers(ctx)
+	if err != nil {
+		return types.StoragePeer{}, err
+	}
+	for _, peer := range peers {
+		if peer.GetClusterStatus() == v1.ClusterStatus_CLUSTER_LEADER {
+			return peer, nil
+		}
+	}
+	return types.StoragePeer{}, errors.ErrNoLeader
+}
+
+// AddVoter is a no-op. Membership is granted by access to the database.
+func (c *Consensus) AddVoter(ctx context.Context, peer types.StoragePeer) error {
+	return nil
+}
+
+// AddObserver is a no-op. Membership is granted by access to the database.
+func (c *Consensus) AddObserver(ctx context.Context, peer types.StoragePeer) error {
+	return nil
+}
+
+// AddLearner is a no-op. Membership is granted by access to the database.
+func (c *Consensus) AddLearner(ctx context.Context, peer types.StoragePeer) error {
+	return nil
+}
+
+// DemoteVoter is a no-op. Membership is granted by access to the database.
+func (c *Consensus) DemoteVoter(ctx context.Context, peer types.StoragePeer) error {
+	return nil
+}
+
+// RemovePeer removes the membership record of the given peer. A member that
+// is still running registers itself again on its next heartbeat.
+func (c *Consensus) RemovePeer(ctx context.Context, peer types.StoragePeer, wait bool) error {
+	st := c.MeshStorage()
+	if st == nil {
+		return errors.ErrClosed
+	}
+	return st.Delete(ctx, MembersPrefix.ForString(peer.GetId()))
+}
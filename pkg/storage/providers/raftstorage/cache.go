@@ -0,0 +1,256 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// Read cache metrics
+var (
+	// ReadCacheHitsTotal tracks the number of reads served from the read cache.
+	ReadCacheHitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "storage_read_cache_hits_total",
+		Help:      "Total number of storage reads served from the read cache.",
+	}, []string{"node_id", "kind"})
+
+	// ReadCacheMissesTotal tracks the number of reads that missed the read cache.
+	ReadCacheMissesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "storage_read_cache_misses_total",
+		Help:      "Total number of storage reads that missed the read cache.",
+	}, []string{"node_id", "kind"})
+)
+
+// Ensure the read cache can be used in place of the local storage.
+var _ storage.DualStorage = &readCache{}
+
+type cachedValue struct {
+	value   []byte
+	expires time.Time
+}
+
+type cachedPrefix struct {
+	items   []cachedItem
+	expires time.Time
+}
+
+type cachedItem struct {
+	key, value []byte
+}
+
+// readCache is an LRU read-through cache in front of the local storage. All writes
+// applied by the FSM pass through it and invalidate the keys and prefixes they touch.
+// Entries also expire after the configured TTL, which bounds how long a key written
+// with a TTL can outlive its expiry in the cache.
+type readCache struct {
+	storage.DualStorage
+	nodeID   string
+	ttl      time.Duration
+	keys     *lru.Cache[string, cachedValue]
+	prefixes *lru.Cache[string, cachedPrefix]
+	// gen is bumped on every invalidation so that reads that raced a write
+	// do not fill the cache with a stale value.
+	gen uint64
+	mu  sync.Mutex
+}
+
+func newReadCache(st storage.DualStorage, nodeID string, size int, ttl time.Duration) (*readCache, error) {
+	keys, err := lru.New[string, cachedValue](size)
+	if err != nil {
+		return nil, err
+	}
+	prefixes, err := lru.New[string, cachedPrefix](size)
+	if err != nil {
+		return nil, err
+	}
+	return &readCache{
+		DualStorage: st,
+		nodeID:      nodeID,
+		ttl:         ttl,
+		keys:        keys,
+		prefixes:    prefixes,
+	}, nil
+}
+
+// GetValue returns the value of a key, serving it from the cache if possible.
+func (c *readCache) GetValue(ctx context.Context, key []byte) ([]byte, error) {
+	if cached, ok := c.keys.Get(string(key)); ok && c.fresh(cached.expires) {
+		ReadCacheHitsTotal.WithLabelValues(c.nodeID, "key").Inc()
+		return bytes.Clone(cached.value), nil
+	}
+	ReadCacheMissesTotal.WithLabelValues(c.nodeID, "key").Inc()
+	gen := c.generation()
+	value, err := c.DualStorage.GetValue(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	c.fill(gen, func() {
+		c.keys.Add(string(key), cachedValue{value: bytes.Clone(value), expires: c.expiry()})
+	})
+	return value, nil
+}
+
+// ListKeys returns all keys with a given prefix.
+func (c *readCache) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	items, err := c.listPrefix(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, len(items))
+	for i, item := range items {
+		keys[i] = bytes.Clone(item.key)
+	}
+	return keys, nil
+}
+
+// IterPrefix iterates over all keys with a given prefix. The iterator is
+// called without any storage locks held.
+func (c *readCache) IterPrefix(ctx context.Context, prefix []byte, fn storage.PrefixIterator) error {
+	items, err := c.listPrefix(ctx, prefix)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if err := fn(bytes.Clone(item.key), bytes.Clone(item.value)); err != nil {
+			if errors.Is(err, storage.ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// PutValue sets the value of a key and invalidates it in the cache.
+func (c *readCache) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
+	defer c.invalidate(key)
+	return c.DualStorage.PutValue(ctx, key, value, ttl)
+}
+
+// CompareAndSwap sets the value of a key if it matches the expected version and
+// invalidates it in the cache.
+func (c *readCache) CompareAndSwap(ctx context.Context, key, value []byte, expected storage.Version, ttl time.Duration) error {
+	defer c.invalidate(key)
+	return c.DualStorage.CompareAndSwap(ctx, key, value, expected, ttl)
+}
+
+// Delete removes a key and invalidates it in the cache.
+func (c *readCache) Delete(ctx context.Context, key []byte) error {
+	defer c.invalidate(key)
+	return c.DualStorage.Delete(ctx, key)
+}
+
+// WriteBatch applies the writes to the underlying storage and invalidates
+// every key they touch.
+func (c *readCache) WriteBatch(ctx context.Context, ops []storage.WriteOp) error {
+	keys := make([][]byte, len(ops))
+	for i, op := range ops {
+		keys[i] = op.Key
+	}
+	defer c.invalidate(keys...)
+	return c.DualStorage.WriteBatch(ctx, ops)
+}
+
+// Restore restores a snapshot of the storage and purges the cache.
+func (c *readCache) Restore(ctx context.Context, r io.Reader) error {
+	defer c.purge()
+	return c.DualStorage.Restore(ctx, r)
+}
+
+func (c *readCache) listPrefix(ctx context.Context, prefix []byte) ([]cachedItem, error) {
+	if cached, ok := c.prefixes.Get(string(prefix)); ok && c.fresh(cached.expires) {
+		ReadCacheHitsTotal.WithLabelValues(c.nodeID, "prefix").Inc()
+		return cached.items, nil
+	}
+	ReadCacheMissesTotal.WithLabelValues(c.nodeID, "prefix").Inc()
+	gen := c.generation()
+	var items []cachedItem
+	err := c.DualStorage.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		items = append(items, cachedItem{key: bytes.Clone(key), value: bytes.Clone(value)})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.fill(gen, func() {
+		c.prefixes.Add(string(prefix), cachedPrefix{items: items, expires: c.expiry()})
+	})
+	return items, nil
+}
+
+func (c *readCache) generation() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.gen
+}
+
+func (c *readCache) fill(gen uint64, fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.gen == gen {
+		fn()
+	}
+}
+
+func (c *readCache) invalidate(keys ...[]byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	for _, key := range keys {
+		c.keys.Remove(string(key))
+	}
+	for _, prefix := range c.prefixes.Keys() {
+		for _, key := range keys {
+			if bytes.HasPrefix(key, []byte(prefix)) {
+				c.prefixes.Remove(prefix)
+				break
+			}
+		}
+	}
+}
+
+func (c *readCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gen++
+	c.keys.Purge()
+	c.prefixes.Purge()
+}
+
+func (c *readCache) expiry() time.Time {
+	if c.ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(c.ttl)
+}
+
+func (c *readCache) fresh(expires time.Time) bool {
+	return expires.IsZero() || time.Now().Before(expires)
+}
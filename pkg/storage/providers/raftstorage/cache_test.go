@@ -0,0 +1,93 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+func TestReadCache(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	cache, err := newReadCache(st, "test-read-cache", 16, time.Minute)
+	if err != nil {
+		t.Fatalf("new read cache: %v", err)
+	}
+	hits := ReadCacheHitsTotal.WithLabelValues("test-read-cache", "key")
+
+	if err := cache.PutValue(ctx, []byte("/prefix/a"), []byte("a"), 0); err != nil {
+		t.Fatalf("put value: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		got, err := cache.GetValue(ctx, []byte("/prefix/a"))
+		if err != nil {
+			t.Fatalf("get value: %v", err)
+		}
+		if string(got) != "a" {
+			t.Fatalf("expected %q, got %q", "a", string(got))
+		}
+	}
+	if got := testutil.ToFloat64(hits); got != 1 {
+		t.Fatalf("expected 1 cache hit, got %v", got)
+	}
+	keys, err := cache.ListKeys(ctx, []byte("/prefix/"))
+	if err != nil {
+		t.Fatalf("list keys: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("expected 1 key, got %d", len(keys))
+	}
+
+	// Writes must invalidate both the key and any prefix containing it.
+	err = cache.WriteBatch(ctx, []storage.WriteOp{
+		{Key: []byte("/prefix/a"), Value: []byte("a2")},
+		{Key: []byte("/prefix/b"), Value: []byte("b")},
+	})
+	if err != nil {
+		t.Fatalf("write batch: %v", err)
+	}
+	got, err := cache.GetValue(ctx, []byte("/prefix/a"))
+	if err != nil {
+		t.Fatalf("get value: %v", err)
+	}
+	if string(got) != "a2" {
+		t.Fatalf("expected %q, got %q", "a2", string(got))
+	}
+	keys, err = cache.ListKeys(ctx, []byte("/prefix/"))
+	if err != nil {
+		t.Fatalf("list keys: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(keys))
+	}
+	if err := cache.Delete(ctx, []byte("/prefix/a")); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, err := cache.GetValue(ctx, []byte("/prefix/a")); !errors.IsKeyNotFound(err) {
+		t.Fatalf("expected key not found, got %v", err)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package raftstorage
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/hashicorp/raft"
@@ -28,8 +29,9 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
-// Ensure we satisfy the Consensus interface.
+// Ensure we satisfy the Consensus interfaces.
 var _ storage.Consensus = &Consensus{}
+var _ storage.LeadershipTransferer = &Consensus{}
 
 // RaftConsensus is the Raft consensus implementation.
 type Consensus struct {
@@ -47,7 +49,8 @@ func (r *Consensus) IsMember() bool {
 	return true
 }
 
-// StepDown steps down from leadership.
+// StepDown steps down from leadership by transferring it to a healthy voter.
+// It returns once the new leader is confirmed.
 func (r *Consensus) StepDown(ctx context.Context) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -55,7 +58,27 @@ func (r *Consensus) StepDown(ctx context.Context) error {
 		return errors.ErrNotLeader
 	}
 	r.log.Debug("Raft node is current leader, stepping down")
-	return r.raft.LeadershipTransfer().Error()
+	return r.transferToHealthyVoter(ctx)
+}
+
+// TransferLeadership transfers leadership to the voter with the given ID and
+// waits until it is confirmed as the new leader.
+func (r *Consensus) TransferLeadership(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.raft.State() != raft.Leader {
+		return errors.ErrNotLeader
+	}
+	for _, srv := range r.GetRaftConfiguration().Servers {
+		if string(srv.ID) != id {
+			continue
+		}
+		if srv.Suffrage != raft.Voter {
+			return errors.ErrNotVoter
+		}
+		return r.transferLeadership(ctx, srv)
+	}
+	return errors.ErrNodeNotFound
 }
 
 // GetPeers returns the peers of the cluster.
@@ -134,8 +157,15 @@ func (r *Consensus) GetLeader(ctx context.Context) (types.StoragePeer, error) {
 	}}, nil
 }
 
-// AddVoter adds a voter to the consensus group.
+// AddVoter adds a voter to the consensus group. Learners are never promoted.
 func (r *Consensus) AddVoter(ctx context.Context, peer types.StoragePeer) error {
+	learner, err := storage.IsLearner(ctx, r.raftStorage, types.NodeID(peer.GetId()))
+	if err != nil {
+		return fmt.Errorf("check learner: %w", err)
+	}
+	if learner {
+		return errors.ErrIsLearner
+	}
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	if !r.started.Load() {
@@ -155,7 +185,7 @@ func (r *Consensus) AddVoter(ctx context.Context, peer types.StoragePeer) error
 		timeout = time.Until(deadline)
 	}
 	f := r.raft.AddVoter(raft.ServerID(peer.GetId()), raft.ServerAddress(peer.GetAddress()), 0, timeout)
-	err := f.Error()
+	err = f.Error()
 	if err != nil && errors.Is(err, raft.ErrNotLeader) {
 		return errors.ErrNotLeader
 	}
@@ -190,6 +220,21 @@ func (r *Consensus) AddObserver(ctx context.Context, peer types.StoragePeer) err
 	return err
 }
 
+// AddLearner adds a learner to the consensus group. The peer is recorded as a
+// learner before it is added as a non-voter so that it can never be promoted.
+func (r *Consensus) AddLearner(ctx context.Context, peer types.StoragePeer) error {
+	if !r.started.Load() {
+		return errors.ErrClosed
+	}
+	if !r.IsLeader() {
+		return errors.ErrNotLeader
+	}
+	if err := storage.MarkLearner(ctx, r.raftStorage, types.NodeID(peer.GetId())); err != nil {
+		return fmt.Errorf("mark learner: %w", err)
+	}
+	return r.AddObserver(ctx, peer)
+}
+
 // DemoteVoter demotes a voter to an observer.
 func (r *Consensus) DemoteVoter(ctx context.Context, peer types.StoragePeer) error {
 	r.mu.Lock()
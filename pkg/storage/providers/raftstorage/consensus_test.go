@@ -0,0 +1,142 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/testutil"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestAddLearner(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	b := &builder{}
+	providers := b.newProviders(t, 2)
+	leader, learner := providers[0], providers[1]
+	for _, p := range providers {
+		testutil.MustStartProvider(ctx, t, p)
+		defer p.Close()
+	}
+	testutil.MustBootstrapProvider(ctx, t, leader)
+	ok := testutil.Eventually[bool](func() bool {
+		return leader.Consensus().IsLeader()
+	}).ShouldEqual(time.Second*10, time.Millisecond*100, true)
+	if !ok {
+		t.Fatal("Bootstrapped provider did not become leader")
+	}
+
+	peer := types.StoragePeer{StoragePeer: learner.Status().GetPeers()[0]}
+	if err := leader.Consensus().AddLearner(ctx, peer); err != nil {
+		t.Fatalf("add learner: %v", err)
+	}
+	isLearner, err := storage.IsLearner(ctx, leader.MeshStorage(), types.NodeID(peer.GetId()))
+	if err != nil {
+		t.Fatalf("check learner: %v", err)
+	}
+	if !isLearner {
+		t.Fatal("Expected peer to be recorded as a learner")
+	}
+	got, err := leader.Consensus().GetPeer(ctx, peer.GetId())
+	if err != nil {
+		t.Fatalf("get peer: %v", err)
+	}
+	if got.GetClusterStatus() != v1.ClusterStatus_CLUSTER_OBSERVER {
+		t.Fatalf("Expected learner to be an observer, got %s", got.GetClusterStatus())
+	}
+
+	// Learners are never promoted.
+	err = leader.Consensus().AddVoter(ctx, peer)
+	if !errors.Is(err, errors.ErrIsLearner) {
+		t.Fatalf("Expected error %v, got %v", errors.ErrIsLearner, err)
+	}
+
+	// Learners serve reads of replicated data.
+	if err := leader.MeshStorage().PutValue(ctx, []byte("/registry/learner-test"), []byte("value"), 0); err != nil {
+		t.Fatalf("put value: %v", err)
+	}
+	ok = testutil.Eventually[bool](func() bool {
+		val, err := learner.MeshStorage().GetValue(ctx, []byte("/registry/learner-test"))
+		return err == nil && bytes.Equal(val, []byte("value"))
+	}).ShouldEqual(time.Second*10, time.Millisecond*100, true)
+	if !ok {
+		t.Fatal("Learner did not replicate value")
+	}
+}
+
+func TestTransferLeadership(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	b := &builder{}
+	providers := b.newProviders(t, 3)
+	for _, p := range providers {
+		testutil.MustStartProvider(ctx, t, p)
+		defer p.Close()
+	}
+	testutil.MustBootstrapProvider(ctx, t, providers[0])
+	ok := testutil.Eventually[bool](func() bool {
+		return providers[0].Consensus().IsLeader()
+	}).ShouldEqual(time.Second*10, time.Millisecond*100, true)
+	if !ok {
+		t.Fatal("Bootstrapped provider did not become leader")
+	}
+	for _, p := range providers[1:] {
+		peer := types.StoragePeer{StoragePeer: p.Status().GetPeers()[0]}
+		if err := providers[0].Consensus().AddVoter(ctx, peer); err != nil {
+			t.Fatalf("add voter: %v", err)
+		}
+	}
+
+	consensus := providers[0].Consensus().(storage.LeadershipTransferer)
+	if err := consensus.TransferLeadership(ctx, "unknown"); !errors.Is(err, errors.ErrNodeNotFound) {
+		t.Fatalf("Expected error %v, got %v", errors.ErrNodeNotFound, err)
+	}
+	target := providers[1].(*Provider).Options.NodeID.String()
+	if err := consensus.TransferLeadership(ctx, target); err != nil {
+		t.Fatalf("transfer leadership: %v", err)
+	}
+	leader, err := providers[0].Consensus().GetLeader(ctx)
+	if err != nil {
+		t.Fatalf("get leader: %v", err)
+	}
+	if leader.GetId() != target {
+		t.Fatalf("Expected %s to be confirmed as leader, got %s", target, leader.GetId())
+	}
+
+	// Stepping down hands leadership to one of the other voters.
+	if err := providers[1].Consensus().StepDown(ctx); err != nil {
+		t.Fatalf("step down: %v", err)
+	}
+	leader, err = providers[1].Consensus().GetLeader(ctx)
+	if err != nil {
+		t.Fatalf("get leader: %v", err)
+	}
+	if leader.GetId() == target {
+		t.Fatal("Expected leadership to move away after stepping down")
+	}
+	if err := providers[1].Consensus().StepDown(ctx); !errors.Is(err, errors.ErrNotLeader) {
+		t.Fatalf("Expected error %v, got %v", errors.ErrNotLeader, err)
+	}
+}
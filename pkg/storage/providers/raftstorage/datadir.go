@@ -0,0 +1,307 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/logging"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/snapshots"
+)
+
+const (
+	// DataDirLockFile is the name of the lock file held in the data directory
+	// while a node is using it.
+	DataDirLockFile = "webmesh.lock"
+	// FencingTokenFile is the name of the file in the data directory holding
+	// the last issued fencing token.
+	FencingTokenFile = "fencing-token"
+	// CorruptSnapshotsDir is the directory in the data directory that snapshots
+	// failing verification are moved to.
+	CorruptSnapshotsDir = "corrupt-snapshots"
+)
+
+// ErrDataDirLocked is returned when the data directory is in use by another process.
+var ErrDataDirLocked = fmt.Errorf("data directory is locked by another process")
+
+// ErrDataDirFenced is returned when another process was issued a newer fencing
+// token for the data directory while it was held, for example because the file
+// system does not honor the lock.
+var ErrDataDirFenced = fmt.Errorf("data directory was taken over by another process")
+
+// dataDirHolder is the content of the lock file while the data directory is held.
+type dataDirHolder struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname,omitempty"`
+	NodeID   string    `json:"nodeID"`
+	Token    uint64    `json:"token"`
+	Started  time.Time `json:"started"`
+}
+
+// dataDirLock is an exclusive lock on a data directory.
+type dataDirLock struct {
+	f     *os.File
+	dir   string
+	token uint64
+	// unclean is true if the previous holder did not release the lock.
+	unclean bool
+}
+
+// lockDataDir acquires an exclusive lock on the given data directory and issues
+// a new fencing token. The lock is held until release is called or the process
+// exits. Writes to the data directory must be preceded by a call to check,
+// which fails once another process was issued a newer token. A lock file left with contents from a previous holder indicates the
+// previous holder did not shut down cleanly.
+func lockDataDir(dir string, nodeID string) (*dataDirLock, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("ensure data directory: %w", err)
+	}
+	f, err := os.OpenFile(filepath.Join(dir, DataDirLockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("open lock file: %w", err)
+	}
+	if err := lockFile(f); err != nil {
+		defer f.Close()
+		var holder dataDirHolder
+		if data, readErr := io.ReadAll(f); readErr == nil && json.Unmarshal(data, &holder) == nil {
+			return nil, fmt.Errorf("%w: %s (pid %d, node %s)", ErrDataDirLocked, dir, holder.PID, holder.NodeID)
+		}
+		return nil, fmt.Errorf("%w: %s: %v", ErrDataDirLocked, dir, err)
+	}
+	lock := &dataDirLock{f: f, dir: dir}
+	handleErr := func(cause error) (*dataDirLock, error) {
+		_ = unlockFile(f)
+		f.Close()
+		return nil, cause
+	}
+	previous, err := io.ReadAll(f)
+	if err != nil {
+		return handleErr(fmt.Errorf("read lock file: %w", err))
+	}
+	lock.unclean = len(strings.TrimSpace(string(previous))) > 0
+	lock.token, err = nextFencingToken(dir)
+	if err != nil {
+		return handleErr(err)
+	}
+	hostname, _ := os.Hostname()
+	data, err := json.Marshal(dataDirHolder{
+		PID:      os.Getpid(),
+		Hostname: hostname,
+		NodeID:   nodeID,
+		Token:    lock.token,
+		Started:  time.Now().UTC(),
+	})
+	if err != nil {
+		return handleErr(fmt.Errorf("marshal lock holder: %w", err))
+	}
+	if err := lock.write(data); err != nil {
+		return handleErr(err)
+	}
+	return lock, nil
+}
+
+// release marks a clean shutdown and releases the lock.
+func (l *dataDirLock) release() error {
+	defer l.f.Close()
+	if err := l.write(nil); err != nil {
+		_ = unlockFile(l.f)
+		return err
+	}
+	return unlockFile(l.f)
+}
+
+// check returns ErrDataDirFenced if the fencing token persisted in the data
+// directory is no longer the one issued to this lock.
+func (l *dataDirLock) check() error {
+	token, err := readFencingToken(l.dir)
+	if err != nil {
+		return err
+	}
+	if token != l.token {
+		return fmt.Errorf("%w: %s (fencing token %d, ours is %d)", ErrDataDirFenced, l.dir, token, l.token)
+	}
+	return nil
+}
+
+func (l *dataDirLock) write(data []byte) error {
+	if err := l.f.Truncate(0); err != nil {
+		return fmt.Errorf("truncate lock file: %w", err)
+	}
+	if _, err := l.f.WriteAt(data, 0); err != nil {
+		return fmt.Errorf("write lock file: %w", err)
+	}
+	if err := l.f.Sync(); err != nil {
+		return fmt.Errorf("sync lock file: %w", err)
+	}
+	return nil
+}
+
+// nextFencingToken increments and returns the fencing token persisted in the
+// data directory. It must only be called while holding the data directory lock.
+func nextFencingToken(dir string) (uint64, error) {
+	token, err := readFencingToken(dir)
+	if err != nil {
+		return 0, err
+	}
+	token++
+	path := filepath.Join(dir, FencingTokenFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strconv.FormatUint(token, 10)+"\n"), 0644); err != nil {
+		return 0, fmt.Errorf("write fencing token: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return 0, fmt.Errorf("write fencing token: %w", err)
+	}
+	return token, nil
+}
+
+// readFencingToken returns the fencing token persisted in the data directory,
+// or zero if none was issued yet.
+func readFencingToken(dir string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, FencingTokenFile))
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("read fencing token: %w", err)
+	}
+	if len(data) == 0 {
+		return 0, nil
+	}
+	token, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse fencing token: %w", err)
+	}
+	return token, nil
+}
+
+// fencedLogStore is a raft log and stable store that checks the data
+// directory lock before every write.
+type fencedLogStore struct {
+	raftLogStore
+	lock *dataDirLock
+}
+
+// StoreLog stores a log entry.
+func (f *fencedLogStore) StoreLog(log *raft.Log) error {
+	if err := f.lock.check(); err != nil {
+		return err
+	}
+	return f.raftLogStore.StoreLog(log)
+}
+
+// StoreLogs stores multiple log entries.
+func (f *fencedLogStore) StoreLogs(logs []*raft.Log) error {
+	if err := f.lock.check(); err != nil {
+		return err
+	}
+	return f.raftLogStore.StoreLogs(logs)
+}
+
+// DeleteRange deletes a range of log entries. The range is inclusive.
+func (f *fencedLogStore) DeleteRange(min, max uint64) error {
+	if err := f.lock.check(); err != nil {
+		return err
+	}
+	return f.raftLogStore.DeleteRange(min, max)
+}
+
+// Set sets a key in the stable store.
+func (f *fencedLogStore) Set(key []byte, val []byte) error {
+	if err := f.lock.check(); err != nil {
+		return err
+	}
+	return f.raftLogStore.Set(key, val)
+}
+
+// SetUint64 sets a uint64 key in the stable store.
+func (f *fencedLogStore) SetUint64(key []byte, val uint64) error {
+	if err := f.lock.check(); err != nil {
+		return err
+	}
+	return f.raftLogStore.SetUint64(key, val)
+}
+
+// verifySnapshots checks the integrity of the snapshots in the data directory.
+// Snapshots that fail verification are moved out of the way so that raft
+// falls back to an older snapshot or to the leader. It returns the number of
+// snapshots moved aside.
+func verifySnapshots(dir string, sealer *snapshots.Sealer, logLevel string, log *slog.Logger) (int, error) {
+	store, err := raft.NewFileSnapshotStoreWithLogger(dir, 1, logging.NewHCLogAdapter("", logLevel, log.With("component", "snapshotstore")))
+	if err != nil {
+		return 0, fmt.Errorf("open snapshot store: %w", err)
+	}
+	corrupt, err := checkSnapshots(store, sealer)
+	if err != nil {
+		return 0, err
+	}
+	for id, cause := range corrupt {
+		log.Warn("Snapshot failed verification, moving it aside",
+			slog.String("snapshot", id),
+			slog.String("error", cause.Error()),
+		)
+		if err := moveSnapshotAside(dir, id); err != nil {
+			return 0, err
+		}
+	}
+	return len(corrupt), nil
+}
+
+// checkSnapshots verifies every snapshot in the store and returns the
+// verification error for each one that failed. Sealed snapshots are opened
+// with the given sealer.
+func checkSnapshots(store raft.SnapshotStore, sealer *snapshots.Sealer) (map[string]error, error) {
+	list, err := store.List()
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	corrupt := make(map[string]error)
+	for _, meta := range list {
+		// Opening a file snapshot verifies its CRC.
+		_, rc, err := store.Open(meta.ID)
+		if err != nil {
+			corrupt[meta.ID] = err
+			continue
+		}
+		err = snapshots.Verify(rc, sealer)
+		rc.Close()
+		if err != nil {
+			corrupt[meta.ID] = err
+		}
+	}
+	return corrupt, nil
+}
+
+// moveSnapshotAside moves a snapshot out of the snapshot store so it is
+// no longer listed, keeping it around for inspection.
+func moveSnapshotAside(dir string, id string) error {
+	asideDir := filepath.Join(dir, CorruptSnapshotsDir)
+	if err := os.MkdirAll(asideDir, 0755); err != nil {
+		return fmt.Errorf("create %s: %w", asideDir, err)
+	}
+	if err := os.Rename(filepath.Join(dir, "snapshots", id), filepath.Join(asideDir, id)); err != nil {
+		return fmt.Errorf("move aside snapshot %s: %w", id, err)
+	}
+	return nil
+}
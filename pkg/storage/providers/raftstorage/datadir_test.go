@@ -0,0 +1,132 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+func TestDataDirLock(t *testing.T) {
+	t.Parallel()
+
+	t.Run("ExclusiveAccess", func(t *testing.T) {
+		dir := t.TempDir()
+		lock, err := lockDataDir(dir, "node-a")
+		if err != nil {
+			t.Fatalf("lock data dir: %v", err)
+		}
+		_, err = lockDataDir(dir, "node-b")
+		if !errors.Is(err, ErrDataDirLocked) {
+			t.Fatalf("expected ErrDataDirLocked, got %v", err)
+		}
+		if err := lock.release(); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		lock, err = lockDataDir(dir, "node-b")
+		if err != nil {
+			t.Fatalf("lock data dir after release: %v", err)
+		}
+		defer lock.release()
+	})
+
+	t.Run("FencingTokenIncreases", func(t *testing.T) {
+		dir := t.TempDir()
+		var last uint64
+		for i := 0; i < 3; i++ {
+			lock, err := lockDataDir(dir, "node")
+			if err != nil {
+				t.Fatalf("lock data dir: %v", err)
+			}
+			if lock.token <= last {
+				t.Fatalf("expected token greater than %d, got %d", last, lock.token)
+			}
+			last = lock.token
+			if err := lock.release(); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+		}
+	})
+
+	t.Run("RefusesWritesOnceFenced", func(t *testing.T) {
+		dir := t.TempDir()
+		lock, err := lockDataDir(dir, "node")
+		if err != nil {
+			t.Fatalf("lock data dir: %v", err)
+		}
+		defer lock.release()
+		if err := lock.check(); err != nil {
+			t.Fatalf("check: %v", err)
+		}
+		db, err := badgerdb.NewInMemory(badgerdb.Options{})
+		if err != nil {
+			t.Fatalf("create log store: %v", err)
+		}
+		defer db.Close()
+		logs := &fencedLogStore{raftLogStore: db, lock: lock}
+		if err := logs.StoreLog(&raft.Log{Index: 1, Term: 1}); err != nil {
+			t.Fatalf("store log: %v", err)
+		}
+		// Simulate another process taking over the data directory on a
+		// file system that does not honor the lock.
+		if _, err := nextFencingToken(dir); err != nil {
+			t.Fatalf("issue fencing token: %v", err)
+		}
+		if err := lock.check(); !errors.Is(err, ErrDataDirFenced) {
+			t.Fatalf("expected ErrDataDirFenced, got %v", err)
+		}
+		if err := logs.StoreLog(&raft.Log{Index: 2, Term: 1}); !errors.Is(err, ErrDataDirFenced) {
+			t.Fatalf("expected ErrDataDirFenced storing a log, got %v", err)
+		}
+		if err := logs.SetUint64([]byte("CurrentTerm"), 2); !errors.Is(err, ErrDataDirFenced) {
+			t.Fatalf("expected ErrDataDirFenced setting stable state, got %v", err)
+		}
+	})
+
+	t.Run("DetectsUncleanShutdown", func(t *testing.T) {
+		dir := t.TempDir()
+		lock, err := lockDataDir(dir, "node")
+		if err != nil {
+			t.Fatalf("lock data dir: %v", err)
+		}
+		if lock.unclean {
+			t.Fatal("expected fresh data dir to be clean")
+		}
+		if err := lock.release(); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		// Simulate a crash by leaving holder information behind.
+		err = os.WriteFile(filepath.Join(dir, DataDirLockFile), []byte(`{"pid":1}`), 0644)
+		if err != nil {
+			t.Fatalf("write lock file: %v", err)
+		}
+		lock, err = lockDataDir(dir, "node")
+		if err != nil {
+			t.Fatalf("lock data dir: %v", err)
+		}
+		defer lock.release()
+		if !lock.unclean {
+			t.Fatal("expected unclean shutdown to be detected")
+		}
+	})
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/logging"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/s3snapshots"
+)
+
+// DefaultSnapshotExportRetain is the default number of exported snapshots to keep.
+const DefaultSnapshotExportRetain = 7
+
+// errNothingToExport is returned when no state has been applied yet.
+var errNothingToExport = errors.New("nothing to export")
+
+// SnapshotExportOptions are options for periodically exporting full snapshots
+// for backups, independent of the snapshots raft takes for log compaction.
+// Exported snapshots use the layout of the snapshot store they are written to,
+// so they can be restored by copying them into a data directory.
+type SnapshotExportOptions struct {
+	// Interval is how often a snapshot is exported.
+	Interval time.Duration
+	// Dir is the directory to export snapshots to.
+	Dir string
+	// S3, if set, exports snapshots to an S3 compatible object store
+	// instead of Dir.
+	S3 *s3snapshots.Options
+	// Retain is the number of exported snapshots to keep. Older snapshots
+	// are removed after each export. Defaults to DefaultSnapshotExportRetain.
+	Retain int
+}
+
+// newSnapshotExportStore creates the snapshot store exported snapshots are
+// written to.
+func (r *Provider) newSnapshotExportStore() (raft.SnapshotStore, error) {
+	opts := r.Options.SnapshotExport
+	retain := opts.Retain
+	if retain <= 0 {
+		retain = DefaultSnapshotExportRetain
+	}
+	if opts.S3 != nil {
+		s3opts := *opts.S3
+		s3opts.Retain = retain
+		s3opts.Logger = r.log.With("component", "snapshot-export")
+		store, err := s3snapshots.New(s3opts)
+		if err != nil {
+			return nil, fmt.Errorf("new s3 snapshot export store: %w", err)
+		}
+		return store, nil
+	}
+	store, err := raft.NewFileSnapshotStoreWithLogger(
+		opts.Dir,
+		retain,
+		logging.NewHCLogAdapter("", r.Options.LogLevel, r.log.With("component", "snapshot-export")),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("new file snapshot export store: %w", err)
+	}
+	return store, nil
+}
+
+// runSnapshotExporter periodically exports a full snapshot of the local state
+// while this node is the leader, so the sink holds a single timeline of backups.
+func (r *Provider) runSnapshotExporter(store raft.SnapshotStore) (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(r.Options.SnapshotExport.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closeCh:
+				return
+			case <-ticker.C:
+				if !r.Consensus().IsLeader() {
+					continue
+				}
+				ctx := context.WithLogger(context.Background(), r.log)
+				start := time.Now()
+				meta, err := r.exportSnapshot(ctx, store)
+				if errors.Is(err, errNothingToExport) {
+					continue
+				}
+				if err != nil {
+					r.log.Error("Failed to export snapshot", slog.String("error", err.Error()))
+					continue
+				}
+				r.log.Info("Exported snapshot",
+					slog.String("id", meta.ID),
+					slog.Uint64("index", meta.Index),
+					slog.String("duration", time.Since(start).String()),
+				)
+			}
+		}
+	}()
+	return
+}
+
+// exportSnapshot writes a full snapshot of the local state to the given store.
+func (r *Provider) exportSnapshot(ctx context.Context, store raft.SnapshotStore) (*raft.SnapshotMeta, error) {
+	snap, index, term, err := r.fsm.Export(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("take snapshot: %w", err)
+	}
+	defer snap.Release()
+	if index == 0 {
+		// Nothing was applied since startup, the state is that of the
+		// latest snapshot raft restored.
+		list, err := r.snapshots.List()
+		if err != nil {
+			return nil, fmt.Errorf("list snapshots: %w", err)
+		}
+		if len(list) == 0 {
+			return nil, errNothingToExport
+		}
+		index, term = list[0].Index, list[0].Term
+	}
+	future := r.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, fmt.Errorf("get configuration: %w", err)
+	}
+	sink, err := store.Create(raft.SnapshotVersionMax, index, term, future.Configuration(), future.Index(), r.Options.Transport)
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot sink: %w", err)
+	}
+	if err := snap.Persist(sink); err != nil {
+		return nil, fmt.Errorf("persist snapshot: %w", err)
+	}
+	return &raft.SnapshotMeta{ID: sink.ID(), Index: index, Term: term}, nil
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/snapshots"
+)
+
+func TestSnapshotExport(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	transport, err := tcp.NewRaftTransport(nil, tcp.RaftTransportOptions{
+		Addr:    "[::]:0",
+		MaxPool: 10,
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create raft transport: %v", err)
+	}
+	opts := newTestOptions(transport)
+	opts.SnapshotExport = &SnapshotExportOptions{
+		Interval: time.Hour,
+		Dir:      t.TempDir(),
+		Retain:   2,
+	}
+	p := NewProvider(opts)
+	if err := p.Start(ctx); err != nil {
+		t.Fatalf("start provider: %v", err)
+	}
+	defer p.Close()
+	if err := p.Bootstrap(ctx); err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	store, err := p.newSnapshotExportStore()
+	if err != nil {
+		t.Fatalf("create export store: %v", err)
+	}
+
+	var lastIndex uint64
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("/registry/key-%d", i)
+		if err := p.MeshStorage().PutValue(ctx, []byte(key), []byte("value"), 0); err != nil {
+			t.Fatalf("put value: %v", err)
+		}
+		meta, err := p.exportSnapshot(ctx, store)
+		if err != nil {
+			t.Fatalf("export snapshot: %v", err)
+		}
+		if meta.Index <= lastIndex {
+			t.Fatalf("expected export index to increase, got %d after %d", meta.Index, lastIndex)
+		}
+		lastIndex = meta.Index
+	}
+
+	// Only the latest exports are retained.
+	list, err := store.List()
+	if err != nil {
+		t.Fatalf("list exports: %v", err)
+	}
+	if len(list) != 2 || list[0].Index != lastIndex {
+		t.Fatalf("expected the 2 latest exports to be retained, got %+v", list)
+	}
+
+	// The latest export restores the full state.
+	_, rc, err := store.Open(list[0].ID)
+	if err != nil {
+		t.Fatalf("open export: %v", err)
+	}
+	db, err := badgerdb.NewInMemory(badgerdb.Options{})
+	if err != nil {
+		t.Fatalf("create test db: %v", err)
+	}
+	defer db.Close()
+	if _, err := snapshots.New(ctx, db, snapshots.Options{}).Restore(ctx, rc); err != nil {
+		t.Fatalf("restore export: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		key := fmt.Sprintf("/registry/key-%d", i)
+		if _, err := db.GetValue(ctx, []byte(key)); err != nil {
+			t.Fatalf("expected %s to be restored: %v", key, err)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// LogFormat is the encoding of commands in the raft log. Every storage member
+// of a cluster must use the same format.
+type LogFormat string
+
+const (
+	// LogFormatProtobufSnappy encodes commands as snappy compressed protobuf.
+	LogFormatProtobufSnappy LogFormat = storage.DefaultRaftLogFormat
+	// LogFormatProtobufZstd encodes commands as zstd compressed protobuf. It
+	// compresses large ACL and route payloads better at a higher CPU cost.
+	LogFormatProtobufZstd LogFormat = "protobuf+zstd"
+)
+
+// DefaultLogCompressionLevel is the default zstd compression level.
+const DefaultLogCompressionLevel = 3
+
+// ErrLogFormatMismatch is returned when a log entry was written in a different
+// format than the one configured.
+var ErrLogFormatMismatch = errors.New("log entry was written in a different log format")
+
+// zstdMagic starts every zstd frame.
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Validate returns an error if the format is unknown. The empty format is the
+// default format.
+func (f LogFormat) Validate() error {
+	switch f {
+	case "", LogFormatProtobufSnappy, LogFormatProtobufZstd:
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q", f)
+	}
+}
+
+// OrDefault returns the format, or the default format if it is empty.
+func (f LogFormat) OrDefault() LogFormat {
+	if f == "" {
+		return LogFormatProtobufSnappy
+	}
+	return f
+}
+
+// ValidateLogCompressionLevel returns an error if the level is not a valid
+// zstd compression level.
+func ValidateLogCompressionLevel(level int) error {
+	if level < 1 || level > 22 {
+		return fmt.Errorf("compression level must be between 1 and 22")
+	}
+	return nil
+}
+
+// LogCodec encodes and decodes raft log entries in a log format. It is safe
+// for concurrent use.
+type LogCodec struct {
+	format LogFormat
+	enc    *zstd.Encoder
+	dec    *zstd.Decoder
+}
+
+// defaultLogCodec encodes log entries in the default format.
+var defaultLogCodec = &LogCodec{format: LogFormatProtobufSnappy}
+
+// NewLogCodec returns a codec for the given format. The compression level is
+// only used by zstd formats and defaults to DefaultLogCompressionLevel.
+func NewLogCodec(format LogFormat, level int) (*LogCodec, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+	format = format.OrDefault()
+	if format != LogFormatProtobufZstd {
+		return &LogCodec{format: format}, nil
+	}
+	if level == 0 {
+		level = DefaultLogCompressionLevel
+	}
+	if err := ValidateLogCompressionLevel(level); err != nil {
+		return nil, err
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return nil, fmt.Errorf("create zstd encoder: %w", err)
+	}
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		return nil, fmt.Errorf("create zstd decoder: %w", err)
+	}
+	return &LogCodec{format: format, enc: enc, dec: dec}, nil
+}
+
+// Format returns the log format of the codec.
+func (c *LogCodec) Format() LogFormat {
+	return c.format
+}
+
+// Marshal marshals a RaftLogEntry.
+func (c *LogCodec) Marshal(logEntry *v1.RaftLogEntry) ([]byte, error) {
+	data, err := proto.Marshal(logEntry)
+	if err != nil {
+		return nil, fmt.Errorf("encode log entry: %w", err)
+	}
+	if c.format == LogFormatProtobufZstd {
+		return c.enc.EncodeAll(data, nil), nil
+	}
+	return snappy.Encode(nil, data), nil
+}
+
+// Unmarshal unmarshals a RaftLogEntry.
+func (c *LogCodec) Unmarshal(data []byte) (*v1.RaftLogEntry, error) {
+	var err error
+	isZstd := bytes.HasPrefix(data, zstdMagic)
+	if c.format == LogFormatProtobufZstd {
+		if !isZstd {
+			return nil, fmt.Errorf("%w: expected %s", ErrLogFormatMismatch, c.format)
+		}
+		data, err = c.dec.DecodeAll(data, nil)
+	} else {
+		data, err = snappy.Decode(nil, data)
+		if err != nil && isZstd {
+			return nil, fmt.Errorf("%w: expected %s", ErrLogFormatMismatch, c.format)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decode log entry: %w", err)
+	}
+	logEntry := &v1.RaftLogEntry{}
+	if err := proto.Unmarshal(data, logEntry); err != nil {
+		return nil, fmt.Errorf("unmarshal log entry: %w", err)
+	}
+	return logEntry, nil
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsm
+
+import (
+	"errors"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+)
+
+func TestLogCodec(t *testing.T) {
+	t.Parallel()
+	entry := &v1.RaftLogEntry{
+		Type:  v1.RaftCommandType_PUT,
+		Key:   []byte("/registry/key"),
+		Value: []byte("value"),
+	}
+	formats := []LogFormat{LogFormatProtobufSnappy, LogFormatProtobufZstd}
+	for _, format := range formats {
+		format := format
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+			codec, err := NewLogCodec(format, 0)
+			if err != nil {
+				t.Fatalf("new codec: %v", err)
+			}
+			data, err := codec.Marshal(entry)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			out, err := codec.Unmarshal(data)
+			if err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if string(out.GetKey()) != string(entry.GetKey()) || string(out.GetValue()) != string(entry.GetValue()) {
+				t.Fatalf("expected %v, got %v", entry, out)
+			}
+			for _, other := range formats {
+				if other == format {
+					continue
+				}
+				otherCodec, err := NewLogCodec(other, 0)
+				if err != nil {
+					t.Fatalf("new codec: %v", err)
+				}
+				_, err = otherCodec.Unmarshal(data)
+				if !errors.Is(err, ErrLogFormatMismatch) {
+					t.Fatalf("expected format mismatch decoding %s with %s, got %v", format, other, err)
+				}
+			}
+		})
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		t.Parallel()
+		if _, err := NewLogCodec("protobuf+lz4", 0); err == nil {
+			t.Fatal("expected unknown format to be rejected")
+		}
+		if _, err := NewLogCodec(LogFormatProtobufZstd, 23); err == nil {
+			t.Fatal("expected invalid compression level to be rejected")
+		}
+	})
+}
@@ -21,14 +21,13 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/golang/snappy"
 	"github.com/hashicorp/raft"
 	v1 "github.com/webmeshproj/api/go/v1"
-	"google.golang.org/protobuf/proto"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/storage"
@@ -46,24 +45,57 @@ type RaftFSM struct {
 	opts             Options
 	store            storage.MeshStorage
 	snapshotter      snapshots.Snapshotter
+	exporter         snapshots.Snapshotter
+	violations       map[string]struct{}
+	hooks            []ApplyHook
+	hookmu           sync.RWMutex
 	log              *slog.Logger
 	mu               sync.Mutex
 }
 
 // Options are options for the FSM.
 type Options struct {
+	// NodeID is the ID of the local node. It labels the FSM metrics.
+	NodeID string
 	// ApplyTimeout is the timeout for applying a log entry.
 	ApplyTimeout time.Duration
+	// CheckInvariants checks the referential integrity of the mesh database
+	// after every applied command. This is expensive and meant for debugging.
+	CheckInvariants InvariantMode
+	// IncrementalSnapshots is the number of incremental snapshots taken
+	// between full snapshots. Zero disables incremental snapshots.
+	IncrementalSnapshots int
+	// SnapshotSealer, if set, encrypts and signs snapshots and verifies them
+	// on restore.
+	SnapshotSealer *snapshots.Sealer
+	// ApplyHooks are called after every applied log entry and snapshot
+	// restore, starting with the first log replayed on startup.
+	ApplyHooks []ApplyHook
+	// LogCodec decodes applied log entries. Defaults to the default log format.
+	LogCodec *LogCodec
+	// Fence, if set, is called before every write to storage. Log entries
+	// and snapshots are not applied while it returns an error.
+	Fence func() error
 }
 
 // New returns a new RaftFSM. The storage interface must be a direct
 // connection to the underlying database.
 func New(ctx context.Context, st storage.DualStorage, opts Options) *RaftFSM {
+	if opts.LogCodec == nil {
+		opts.LogCodec = defaultLogCodec
+	}
 	return &RaftFSM{
-		store:       st,
-		opts:        opts,
-		log:         context.LoggerFrom(ctx).With("component", "raft-fsm"),
-		snapshotter: snapshots.New(ctx, st),
+		store: st,
+		opts:  opts,
+		hooks: slices.Clone(opts.ApplyHooks),
+		log:   context.LoggerFrom(ctx).With("component", "raft-fsm"),
+		snapshotter: snapshots.New(ctx, st, snapshots.Options{
+			MaxIncrementals: opts.IncrementalSnapshots,
+			Sealer:          opts.SnapshotSealer,
+		}),
+		exporter: snapshots.New(ctx, st, snapshots.Options{
+			Sealer: opts.SnapshotSealer,
+		}),
 	}
 }
 
@@ -82,7 +114,26 @@ func (r *RaftFSM) Snapshot() (raft.FSMSnapshot, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	// TODO: Set a timeout on this.
-	return r.snapshotter.Snapshot(context.Background())
+	start := time.Now()
+	snap, err := r.snapshotter.Snapshot(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &timedSnapshot{FSMSnapshot: snap, nodeID: r.opts.NodeID, start: start}, nil
+}
+
+// Export returns a full snapshot of the local state along with the index and
+// term of the last log applied to it. It is meant for backups and does not
+// affect the snapshots taken by raft. The index is zero if no log was applied
+// since the FSM was created.
+func (r *RaftFSM) Export(ctx context.Context) (snap raft.FSMSnapshot, index, term uint64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snap, err = r.exporter.Snapshot(ctx)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return snap, r.lastAppliedIndex.Load(), r.currentTerm.Load(), nil
 }
 
 // Restore restores a Raft snapshot.
@@ -90,10 +141,18 @@ func (r *RaftFSM) Restore(rdr io.ReadCloser) error {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	// TODO: Set a timeout on this.
-	err := r.snapshotter.Restore(context.Background(), rdr)
+	ctx := context.Background()
+	if err := r.fence(); err != nil {
+		rdr.Close()
+		return fmt.Errorf("restore snapshot: %w", err)
+	}
+	start := time.Now()
+	restored, err := r.snapshotter.Restore(ctx, rdr)
 	if err != nil {
 		return fmt.Errorf("restore snapshot: %w", err)
 	}
+	SnapshotDuration.WithLabelValues(r.opts.NodeID, "restore").Observe(time.Since(start).Seconds())
+	r.runHooks(context.WithLogger(ctx, r.log), ApplyEvent{Restored: true, Snapshot: restored})
 	return nil
 }
 
@@ -142,6 +201,14 @@ func (r *RaftFSM) applyLog(l *raft.Log) (cmd *v1.RaftLogEntry, res *v1.RaftApply
 		}
 	}
 
+	if err := r.fence(); err != nil {
+		log.Error("Refusing to apply log entry", slog.String("error", err.Error()))
+		return nil, &v1.RaftApplyResponse{
+			Time:  time.Since(start).String(),
+			Error: err.Error(),
+		}
+	}
+
 	defer r.lastAppliedIndex.Store(l.Index)
 	defer r.currentTerm.Store(l.Term)
 
@@ -152,8 +219,15 @@ func (r *RaftFSM) applyLog(l *raft.Log) (cmd *v1.RaftLogEntry, res *v1.RaftApply
 		}
 	}
 
+	defer func() {
+		ApplyDuration.WithLabelValues(r.opts.NodeID).Observe(time.Since(start).Seconds())
+		if res.GetError() != "" {
+			ApplyErrorsTotal.WithLabelValues(r.opts.NodeID).Inc()
+		}
+	}()
+
 	// Decode the log entry
-	cmd, err := UnmarshalLogEntry(l.Data)
+	cmd, err := r.opts.LogCodec.Unmarshal(l.Data)
 	if err != nil {
 		// This is a fatal error. We can't apply the log entry if we can't
 		// decode it. This should never happen.
@@ -174,32 +248,53 @@ func (r *RaftFSM) applyLog(l *raft.Log) (cmd *v1.RaftLogEntry, res *v1.RaftApply
 	}
 	defer cancel()
 	ctx = context.WithLogger(ctx, log)
+	// Version writes by the index of the log entry, which is the same on
+	// every storage node.
+	ctx = storage.WithRevision(ctx, l.Index)
+
+	// Count TTLs from when the leader appended the log, not from when it is
+	// applied, so that replaying old logs does not revive expired keys.
+	apply := cmd
+	if !l.AppendedAt.IsZero() {
+		apply, err = raftlogs.AgeTTLs(cmd, time.Since(l.AppendedAt))
+		if err != nil {
+			log.Error("Error aging raft log entry", slog.String("error", err.Error()))
+			return cmd, &v1.RaftApplyResponse{
+				Time:  time.Since(start).String(),
+				Error: fmt.Sprintf("age log entry: %s", err.Error()),
+			}
+		}
+	}
 
 	// Apply the log entry to the database.
-	return cmd, raftlogs.Apply(ctx, r.store, cmd)
+	res = raftlogs.Apply(ctx, r.store, apply)
+	if r.opts.CheckInvariants != InvariantsOff {
+		r.checkInvariants(ctx, l.Index)
+	}
+	r.runHooks(ctx, ApplyEvent{
+		Index:      l.Index,
+		Term:       l.Term,
+		AppendedAt: l.AppendedAt,
+		Entry:      apply,
+		Result:     res,
+	})
+	return cmd, res
 }
 
-// MarshalLogEntry marshals a RaftLogEntry.
+// MarshalLogEntry marshals a RaftLogEntry in the default log format.
 func MarshalLogEntry(logEntry *v1.RaftLogEntry) ([]byte, error) {
-	data, err := proto.Marshal(logEntry)
-	if err == nil {
-		data = snappy.Encode(nil, data)
-	}
-	if err != nil {
-		return nil, fmt.Errorf("encode log entry: %w", err)
-	}
-	return data, nil
+	return defaultLogCodec.Marshal(logEntry)
 }
 
-// UnmarshalLogEntry unmarshals a RaftLogEntry.
+// UnmarshalLogEntry unmarshals a RaftLogEntry in the default log format.
 func UnmarshalLogEntry(data []byte) (*v1.RaftLogEntry, error) {
-	data, err := snappy.Decode(nil, data)
-	if err != nil {
-		return nil, fmt.Errorf("decode log entry: %w", err)
-	}
-	logEntry := &v1.RaftLogEntry{}
-	if err := proto.Unmarshal(data, logEntry); err != nil {
-		return nil, fmt.Errorf("unmarshal log entry: %w", err)
+	return defaultLogCodec.Unmarshal(data)
+}
+
+// fence calls the configured fence, if any.
+func (r *RaftFSM) fence() error {
+	if r.opts.Fence == nil {
+		return nil
 	}
-	return logEntry, nil
+	return r.opts.Fence()
 }
@@ -0,0 +1,70 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+func TestFence(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	db, err := badgerdb.NewInMemory(badgerdb.Options{})
+	if err != nil {
+		t.Fatalf("create test db: %v", err)
+	}
+	defer db.Close()
+
+	var fenced error
+	f := New(ctx, db, Options{
+		Fence: func() error { return fenced },
+	})
+	apply := func(index uint64, key string) *v1.RaftApplyResponse {
+		t.Helper()
+		data, err := MarshalLogEntry(&v1.RaftLogEntry{
+			Type:  v1.RaftCommandType_PUT,
+			Key:   []byte(key),
+			Value: []byte("value"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f.Apply(&raft.Log{Index: index, Term: 1, Type: raft.LogCommand, Data: data}).(*v1.RaftApplyResponse)
+	}
+
+	if res := apply(1, "/registry/foo"); res.GetError() != "" {
+		t.Fatalf("apply log: %s", res.GetError())
+	}
+	fenced = errors.New("fenced")
+	if res := apply(2, "/registry/bar"); res.GetError() == "" {
+		t.Fatal("expected a fenced apply to fail")
+	}
+	if _, err := db.GetValue(ctx, []byte("/registry/bar")); err == nil {
+		t.Fatal("expected a fenced apply to leave storage untouched")
+	}
+	if f.LastAppliedIndex() != 1 {
+		t.Fatalf("expected the fenced log to not be marked applied, got index %d", f.LastAppliedIndex())
+	}
+}
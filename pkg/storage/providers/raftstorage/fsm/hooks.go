@@ -0,0 +1,91 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsm
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/snapshots"
+)
+
+// ApplyEvent describes a change to the local state made by the FSM.
+type ApplyEvent struct {
+	// Index is the raft index of the applied log. It is zero for restores.
+	Index uint64
+	// Term is the raft term of the applied log. It is zero for restores.
+	Term uint64
+	// AppendedAt is when the leader appended the log, if known.
+	AppendedAt time.Time
+	// Entry is the applied log entry with its TTLs counted from AppendedAt.
+	// It is nil for restores.
+	Entry *v1.RaftLogEntry
+	// Result is the result of applying the entry. It is nil for restores.
+	Result *v1.RaftApplyResponse
+	// Restored is true when the local state was replaced by a snapshot.
+	// Hooks that maintain derived state must rebuild it from Storage or
+	// from the keys in Snapshot.
+	Restored bool
+	// Snapshot describes the restored snapshot. It is nil for applied logs.
+	Snapshot *snapshots.Restored
+	// Storage is the local storage the change was made to. Reads reflect
+	// the state right after the change and before any later log is applied.
+	Storage storage.MeshStorage
+}
+
+// ApplyHook is called synchronously for every log entry applied by the FSM
+// and after every snapshot restore, in raft order. It runs while the FSM is
+// locked, so it must return quickly and must not apply raft logs itself or
+// register other hooks.
+type ApplyHook func(ctx context.Context, ev ApplyEvent)
+
+// OnApply registers a hook that is called after every applied log entry and
+// snapshot restore. Hooks registered after the FSM has started only see
+// changes made from then on.
+func (r *RaftFSM) OnApply(hook ApplyHook) {
+	r.hookmu.Lock()
+	defer r.hookmu.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+// runHooks calls the registered hooks with the given event. A panicking
+// hook is logged and does not stop the FSM.
+func (r *RaftFSM) runHooks(ctx context.Context, ev ApplyEvent) {
+	r.hookmu.RLock()
+	hooks := r.hooks
+	r.hookmu.RUnlock()
+	ev.Storage = r.store
+	for _, hook := range hooks {
+		func() {
+			defer func() {
+				if err := recover(); err != nil {
+					context.LoggerFrom(ctx).Error("Apply hook panicked",
+						slog.String("error", fmt.Sprint(err)),
+						slog.String("stack", string(debug.Stack())),
+					)
+				}
+			}()
+			hook(ctx, ev)
+		}()
+	}
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsm
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+func TestApplyHooks(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	db, err := badgerdb.NewInMemory(badgerdb.Options{})
+	if err != nil {
+		t.Fatalf("create test db: %v", err)
+	}
+	defer db.Close()
+
+	var events []ApplyEvent
+	f := New(ctx, db, Options{
+		ApplyHooks: []ApplyHook{
+			func(ctx context.Context, ev ApplyEvent) {
+				panic("hooks must not stop the fsm")
+			},
+			func(ctx context.Context, ev ApplyEvent) {
+				if !ev.Restored {
+					// The change must be visible to the hook.
+					value, err := ev.Storage.GetValue(ctx, ev.Entry.Key)
+					if err != nil {
+						t.Errorf("get applied key: %v", err)
+					} else if !bytes.Equal(value, ev.Entry.Value) {
+						t.Errorf("expected %q, got %q", ev.Entry.Value, value)
+					}
+				}
+				events = append(events, ev)
+			},
+		},
+	})
+	var registered int
+	f.OnApply(func(ctx context.Context, ev ApplyEvent) {
+		registered++
+	})
+
+	apply := func(index uint64, key, value string) {
+		t.Helper()
+		data, err := MarshalLogEntry(&v1.RaftLogEntry{
+			Type:  v1.RaftCommandType_PUT,
+			Key:   []byte(key),
+			Value: []byte(value),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := f.Apply(&raft.Log{Index: index, Term: 1, Type: raft.LogCommand, Data: data})
+		if res.(*v1.RaftApplyResponse).GetError() != "" {
+			t.Fatalf("apply log: %s", res.(*v1.RaftApplyResponse).GetError())
+		}
+	}
+	apply(1, "/registry/foo", "bar")
+	apply(2, "/registry/baz", "qux")
+	// Logs that were already applied are not passed to hooks.
+	apply(2, "/registry/baz", "qux")
+
+	if len(events) != 2 || registered != 2 {
+		t.Fatalf("expected 2 events, got %d and %d", len(events), registered)
+	}
+	for i, ev := range events {
+		if ev.Index != uint64(i+1) || ev.Term != 1 || ev.Result == nil {
+			t.Fatalf("unexpected event %d: %+v", i, ev)
+		}
+	}
+
+	snap, err := f.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Release()
+	var buf bytes.Buffer
+	if err := snap.Persist(&testSink{Writer: &buf}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Restore(io.NopCloser(&buf)); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || !events[2].Restored || events[2].Entry != nil {
+		t.Fatalf("expected a restore event, got %+v", events)
+	}
+	var restored int
+	err = events[2].Snapshot.Iter(func(key, value []byte, ttl time.Duration) error {
+		restored++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != 2 {
+		t.Fatalf("expected 2 restored keys, got %d", restored)
+	}
+}
+
+type testSink struct {
+	io.Writer
+}
+
+func (s *testSink) ID() string    { return "test" }
+func (s *testSink) Cancel() error { return nil }
+func (s *testSink) Close() error  { return nil }
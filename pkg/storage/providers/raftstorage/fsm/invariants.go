@@ -0,0 +1,82 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsm
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// InvariantMode is how the FSM reacts to mesh database invariants that stop
+// holding after a log is applied.
+type InvariantMode string
+
+const (
+	// InvariantsOff disables invariant checks.
+	InvariantsOff InvariantMode = ""
+	// InvariantsLog logs violations introduced by a log.
+	InvariantsLog InvariantMode = "log"
+	// InvariantsPanic panics on violations introduced by a log.
+	InvariantsPanic InvariantMode = "panic"
+)
+
+// Validate returns an error if the mode is unknown.
+func (m InvariantMode) Validate() error {
+	switch m {
+	case InvariantsOff, InvariantsLog, InvariantsPanic:
+		return nil
+	default:
+		return fmt.Errorf("unknown invariant mode %q", m)
+	}
+}
+
+// checkInvariants checks the invariants of the database after the log with
+// the given index was applied. Only violations that were not present before
+// are reported, so data that was already broken is logged once. It must be
+// called with the lock held.
+func (r *RaftFSM) checkInvariants(ctx context.Context, index uint64) {
+	violations, err := storage.CheckInvariants(ctx, r.store)
+	if err != nil {
+		r.log.Warn("Failed to check invariants", slog.Int("index", int(index)), slog.String("error", err.Error()))
+		return
+	}
+	current := make(map[string]struct{}, len(violations))
+	var introduced []storage.InvariantViolation
+	for _, v := range violations {
+		current[v.Error()] = struct{}{}
+		if _, ok := r.violations[v.Error()]; !ok {
+			introduced = append(introduced, v)
+		}
+	}
+	r.violations = current
+	if len(introduced) == 0 {
+		return
+	}
+	for _, v := range introduced {
+		r.log.Error("Invariant violated after applying log",
+			slog.Int("index", int(index)),
+			slog.String("invariant", v.Invariant),
+			slog.String("detail", v.Detail),
+		)
+	}
+	if r.opts.CheckInvariants == InvariantsPanic {
+		panic(fmt.Sprintf("log %d violated %d invariant(s), first: %s", index, len(introduced), introduced[0].Error()))
+	}
+}
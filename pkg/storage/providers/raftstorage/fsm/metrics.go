@@ -0,0 +1,66 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fsm
+
+import (
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// FSM metrics
+var (
+	// ApplyDuration tracks how long command logs take to apply to the local storage.
+	ApplyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "webmesh",
+		Name:      "raft_fsm_apply_duration_seconds",
+		Help:      "Time taken to apply raft log entries to the local storage.",
+		Buckets:   prometheus.ExponentialBuckets(0.0001, 2, 16),
+	}, []string{"node_id"})
+
+	// ApplyErrorsTotal tracks the number of command logs that failed to apply.
+	ApplyErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "raft_fsm_apply_errors_total",
+		Help:      "Total number of raft log entries that failed to apply to the local storage.",
+	}, []string{"node_id"})
+
+	// SnapshotDuration tracks how long snapshots take to persist and restore.
+	SnapshotDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "webmesh",
+		Name:      "raft_snapshot_duration_seconds",
+		Help:      "Time taken to persist or restore raft snapshots.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+	}, []string{"node_id", "op"})
+)
+
+// timedSnapshot records the time from taking a snapshot until it is persisted.
+type timedSnapshot struct {
+	raft.FSMSnapshot
+	nodeID string
+	start  time.Time
+}
+
+// Persist persists the snapshot and records how long it took.
+func (s *timedSnapshot) Persist(sink raft.SnapshotSink) error {
+	defer func() {
+		SnapshotDuration.WithLabelValues(s.nodeID, "persist").Observe(time.Since(s.start).Seconds())
+	}()
+	return s.FSMSnapshot.Persist(sink)
+}
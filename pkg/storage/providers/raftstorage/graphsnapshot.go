@@ -0,0 +1,59 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb/graphstore"
+)
+
+// runGraphSnapshotter periodically refreshes the graph snapshot stored alongside
+// the registry while this node is the leader. Nodes load the snapshot wholesale
+// instead of reading every node and edge key.
+func (r *Provider) runGraphSnapshotter() (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(r.Options.GraphSnapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closeCh:
+				return
+			case <-ticker.C:
+				if !r.Consensus().IsLeader() {
+					continue
+				}
+				ctx, cancel := context.WithTimeout(context.WithLogger(context.Background(), r.log), r.Options.ApplyTimeout)
+				wrote, err := graphstore.WriteSnapshot(ctx, r.raftStorage)
+				cancel()
+				if err != nil {
+					r.log.Warn("Failed to write graph snapshot", slog.String("error", err.Error()))
+					continue
+				}
+				if wrote {
+					r.log.Debug("Wrote graph snapshot")
+				}
+			}
+		}
+	}()
+	return
+}
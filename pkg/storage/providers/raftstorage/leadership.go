@@ -0,0 +1,144 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// leadershipPollInterval is how often the leader is checked while confirming
+// a leadership transfer.
+const leadershipPollInterval = 50 * time.Millisecond
+
+// trackHeartbeat records which voters the leader is failing to heartbeat.
+func (r *Provider) trackHeartbeat(ev raft.Observation) {
+	r.heartbeatMu.Lock()
+	defer r.heartbeatMu.Unlock()
+	if r.failedHeartbeats == nil {
+		r.failedHeartbeats = make(map[raft.ServerID]time.Time)
+	}
+	switch data := ev.Data.(type) {
+	case raft.FailedHeartbeatObservation:
+		if _, ok := r.failedHeartbeats[data.PeerID]; !ok {
+			r.failedHeartbeats[data.PeerID] = data.LastContact
+		}
+	case raft.ResumedHeartbeatObservation:
+		delete(r.failedHeartbeats, data.PeerID)
+	case raft.LeaderObservation:
+		// Heartbeats are only sent by the leader, what we knew is stale.
+		clear(r.failedHeartbeats)
+	}
+}
+
+// failingSince returns when the leader last heard from the given server if
+// it is failing heartbeats.
+func (r *Provider) failingSince(id raft.ServerID) (time.Time, bool) {
+	r.heartbeatMu.Lock()
+	defer r.heartbeatMu.Unlock()
+	since, ok := r.failedHeartbeats[id]
+	return since, ok
+}
+
+// healthyVoters returns the voters other than ourselves that the leader is
+// not failing to heartbeat, sorted by ID.
+func (r *Provider) healthyVoters() []raft.Server {
+	r.heartbeatMu.Lock()
+	defer r.heartbeatMu.Unlock()
+	var voters []raft.Server
+	for _, srv := range r.GetRaftConfiguration().Servers {
+		if srv.ID == r.nodeID || srv.Suffrage != raft.Voter {
+			continue
+		}
+		if _, failed := r.failedHeartbeats[srv.ID]; failed {
+			continue
+		}
+		voters = append(voters, srv)
+	}
+	sort.Slice(voters, func(i, j int) bool {
+		return voters[i].ID < voters[j].ID
+	})
+	return voters
+}
+
+// transferLeadership transfers leadership to the given voter and waits until
+// it is confirmed as the new leader. The caller must hold the lock.
+func (r *Provider) transferLeadership(ctx context.Context, srv raft.Server) error {
+	if r.raft.State() != raft.Leader {
+		return errors.ErrNotLeader
+	}
+	r.log.Debug("Transferring leadership", slog.String("to", string(srv.ID)))
+	err := r.raft.LeadershipTransferToServer(srv.ID, srv.Address).Error()
+	if err != nil {
+		if errors.Is(err, raft.ErrNotLeader) {
+			return errors.ErrNotLeader
+		}
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Options.ApplyTimeout)
+		defer cancel()
+	}
+	ticker := time.NewTicker(leadershipPollInterval)
+	defer ticker.Stop()
+	for {
+		_, id := r.raft.LeaderWithID()
+		if id == srv.ID {
+			return nil
+		}
+		if id != "" && id != r.nodeID {
+			return fmt.Errorf("%w: %s became leader instead of %s", errors.ErrLeadershipNotTransferred, id, srv.ID)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s did not become leader: %w", errors.ErrLeadershipNotTransferred, srv.ID, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// transferToHealthyVoter transfers leadership to the first healthy voter that
+// confirms it. The caller must hold the lock.
+func (r *Provider) transferToHealthyVoter(ctx context.Context) error {
+	voters := r.healthyVoters()
+	if len(voters) == 0 {
+		return errors.ErrNoHealthyVoter
+	}
+	var lastErr error
+	for _, srv := range voters {
+		err := r.transferLeadership(ctx, srv)
+		if err == nil {
+			r.log.Info("Transferred leadership", slog.String("to", string(srv.ID)))
+			return nil
+		}
+		if errors.Is(err, errors.ErrNotLeader) || errors.Is(err, errors.ErrLeadershipNotTransferred) {
+			// Leadership has left us, trying other voters would not help.
+			return err
+		}
+		r.log.Warn("Failed to transfer leadership", slog.String("to", string(srv.ID)), slog.String("error", err.Error()))
+		lastErr = fmt.Errorf("transfer to %s: %w", srv.ID, err)
+	}
+	return lastErr
+}
@@ -0,0 +1,64 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
+)
+
+// logFormatCheckDepth is how many entries from the end of the log are searched
+// for a command to check the log format against.
+const logFormatCheckDepth = 64
+
+// checkLogFormat returns an error if the latest command in the log store was
+// written in a different format than the codec's. This catches a node that was
+// restarted with a different log format before it replays entries it cannot
+// decode.
+func checkLogFormat(logs raft.LogStore, codec *fsm.LogCodec) error {
+	first, err := logs.FirstIndex()
+	if err != nil {
+		return fmt.Errorf("get first index: %w", err)
+	}
+	last, err := logs.LastIndex()
+	if err != nil {
+		return fmt.Errorf("get last index: %w", err)
+	}
+	for index := last; index >= first && index > 0 && last-index < logFormatCheckDepth; index-- {
+		var entry raft.Log
+		if err := logs.GetLog(index, &entry); err != nil {
+			if errors.Is(err, raft.ErrLogNotFound) {
+				continue
+			}
+			return fmt.Errorf("get log %d: %w", index, err)
+		}
+		if entry.Type != raft.LogCommand {
+			continue
+		}
+		_, err := codec.Unmarshal(entry.Data)
+		if errors.Is(err, fsm.ErrLogFormatMismatch) {
+			return fmt.Errorf("raft log was not written in the configured %s format: %w", codec.Format(), err)
+		}
+		// Other decoding errors are left to the scrubber.
+		return nil
+	}
+	return nil
+}
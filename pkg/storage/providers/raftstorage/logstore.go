@@ -0,0 +1,286 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/wal"
+)
+
+// LogStore is where raft logs and stable state are stored.
+type LogStore string
+
+const (
+	// LogStoreShared stores raft logs in the same database as the mesh state.
+	LogStoreShared LogStore = "shared"
+	// LogStoreBadger stores raft logs in a dedicated badger database, so that
+	// log writes and compactions do not contend with the mesh state.
+	LogStoreBadger LogStore = "badger"
+	// LogStoreWAL stores raft logs in a dedicated segmented write-ahead log,
+	// which keeps append latency low on slow disks.
+	LogStoreWAL LogStore = "wal"
+)
+
+// logStoreMigrateBatch is how many log entries are copied at a time when
+// migrating between log stores.
+const logStoreMigrateBatch = 1024
+
+// stableStoreKeys are the keys raft writes to the stable store.
+var stableStoreKeys = []string{"CurrentTerm", "LastVoteTerm", "LastVoteCand"}
+
+// dedicatedLogStores are the log stores kept apart from the mesh state.
+var dedicatedLogStores = []LogStore{LogStoreBadger, LogStoreWAL}
+
+// raftLogStore is a store for raft logs and stable state.
+type raftLogStore interface {
+	io.Closer
+	raft.LogStore
+	raft.StableStore
+}
+
+// Validate returns an error if the log store is unknown.
+func (l LogStore) Validate() error {
+	switch l {
+	case "", LogStoreShared, LogStoreBadger, LogStoreWAL:
+		return nil
+	default:
+		return fmt.Errorf("unknown log store %q", l)
+	}
+}
+
+// OrDefault returns the log store, or the shared log store if it is empty.
+func (l LogStore) OrDefault() LogStore {
+	if l == "" {
+		return LogStoreShared
+	}
+	return l
+}
+
+// logStoreDir returns the directory of the given dedicated log store.
+func (r *Provider) logStoreDir(kind LogStore) string {
+	name := "raft-logs"
+	if kind == LogStoreWAL {
+		name = "raft-wal"
+	}
+	return filepath.Join(r.Options.DataDir, r.Options.NodeID.String(), name)
+}
+
+// openLogStore opens the given dedicated log store in dir.
+func (r *Provider) openLogStore(kind LogStore, dir string) (raftLogStore, error) {
+	if kind == LogStoreWAL {
+		w, err := wal.Open(dir, r.Options.WAL)
+		if err != nil {
+			return nil, fmt.Errorf("open log store: %w", err)
+		}
+		return w, nil
+	}
+	db, err := badgerdb.New(badgerdb.Options{
+		DiskPath:   dir,
+		SyncWrites: true,
+		Debug:      strings.ToLower(r.Options.LogLevel) == "debug",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("open log store: %w", err)
+	}
+	return db, nil
+}
+
+// createLogStore returns the store raft logs and stable state are kept in.
+// Logs are migrated from any other kind of store when the configured one
+// changes, so switching in any direction keeps the local log.
+func (r *Provider) createLogStore(shared storage.DualStorage) (raftLogStore, error) {
+	kind := r.Options.LogStore.OrDefault()
+	if r.Options.InMemory {
+		if kind == LogStoreShared {
+			return shared, nil
+		}
+		// Nothing is written to disk, so every dedicated store is an
+		// in-memory badger database.
+		db, err := badgerdb.NewInMemory(badgerdb.Options{Debug: strings.ToLower(r.Options.LogLevel) == "debug"})
+		if err != nil {
+			return nil, fmt.Errorf("create in-memory log store: %w", err)
+		}
+		return db, nil
+	}
+	if r.Options.ClearDataDir {
+		for _, other := range dedicatedLogStores {
+			if err := os.RemoveAll(r.logStoreDir(other)); err != nil {
+				return nil, fmt.Errorf("remove log store directory: %w", err)
+			}
+		}
+	}
+	// Move the logs of dedicated stores that are no longer configured back
+	// to the shared store. Their directory is only removed once everything
+	// was copied, so an interrupted migration is repeated on the next start.
+	for _, other := range dedicatedLogStores {
+		if other == kind {
+			continue
+		}
+		dir := r.logStoreDir(other)
+		exists, err := dirExists(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			continue
+		}
+		st, err := r.openLogStore(other, dir)
+		if err != nil {
+			return nil, err
+		}
+		n, err := migrateLogStore(st, shared)
+		if cerr := st.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("close log store: %w", cerr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, fmt.Errorf("remove log store directory: %w", err)
+		}
+		r.log.Info("Migrated raft logs to the shared store", slog.String("from", string(other)), slog.Int("entries", n))
+	}
+	if kind == LogStoreShared {
+		return shared, nil
+	}
+	dir := r.logStoreDir(kind)
+	exists, err := dirExists(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		// Copy the logs into a temporary directory and move it into place
+		// once complete, so an interrupted migration starts over.
+		tmp := dir + ".tmp"
+		if err := os.RemoveAll(tmp); err != nil {
+			return nil, fmt.Errorf("remove temporary log store directory: %w", err)
+		}
+		st, err := r.openLogStore(kind, tmp)
+		if err != nil {
+			return nil, err
+		}
+		n, err := migrateLogStore(shared, st)
+		if cerr := st.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("close log store: %w", cerr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Rename(tmp, dir); err != nil {
+			return nil, fmt.Errorf("move log store into place: %w", err)
+		}
+		if n > 0 {
+			r.log.Info("Migrated raft logs to a dedicated store", slog.String("to", string(kind)), slog.Int("entries", n))
+		}
+	}
+	st, err := r.openLogStore(kind, dir)
+	if err != nil {
+		return nil, err
+	}
+	// Remove logs left in the shared store by a migration that was
+	// interrupted after the dedicated store was moved into place.
+	first, err := shared.FirstIndex()
+	if err == nil && first > 0 {
+		var last uint64
+		last, err = shared.LastIndex()
+		if err == nil {
+			err = shared.DeleteRange(first, last)
+		}
+	}
+	if err != nil {
+		st.Close()
+		return nil, fmt.Errorf("remove migrated logs from shared store: %w", err)
+	}
+	return st, nil
+}
+
+// dirExists returns true if the given directory exists.
+func dirExists(dir string) (bool, error) {
+	_, err := os.Stat(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("stat log store directory: %w", err)
+	}
+	return err == nil, nil
+}
+
+// migrateLogStore copies the raft logs and stable state from one store to
+// another and returns the number of log entries copied.
+func migrateLogStore(from, to raftLogStore) (int, error) {
+	for _, key := range stableStoreKeys {
+		val, err := from.Get([]byte(key))
+		if err != nil {
+			return 0, fmt.Errorf("get stable store key %s: %w", key, err)
+		}
+		if val == nil {
+			continue
+		}
+		if err := to.Set([]byte(key), val); err != nil {
+			return 0, fmt.Errorf("set stable store key %s: %w", key, err)
+		}
+	}
+	first, err := from.FirstIndex()
+	if err != nil {
+		return 0, fmt.Errorf("get first index: %w", err)
+	}
+	last, err := from.LastIndex()
+	if err != nil {
+		return 0, fmt.Errorf("get last index: %w", err)
+	}
+	if first == 0 {
+		return 0, nil
+	}
+	var copied int
+	batch := make([]*raft.Log, 0, logStoreMigrateBatch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := to.StoreLogs(batch); err != nil {
+			return fmt.Errorf("store logs: %w", err)
+		}
+		copied += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	for index := first; index <= last; index++ {
+		var entry raft.Log
+		if err := from.GetLog(index, &entry); err != nil {
+			if errors.Is(err, raft.ErrLogNotFound) {
+				continue
+			}
+			return copied, fmt.Errorf("get log %d: %w", index, err)
+		}
+		batch = append(batch, &entry)
+		if len(batch) == logStoreMigrateBatch {
+			if err := flush(); err != nil {
+				return copied, err
+			}
+		}
+	}
+	return copied, flush()
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/logging"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestLogStoreMigration(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	p := &Provider{
+		Options: Options{DataDir: dir, NodeID: types.NodeID("node")},
+		log:     logging.NewLogger("", ""),
+	}
+	openShared := func() storage.DualStorage {
+		t.Helper()
+		db, err := badgerdb.New(badgerdb.Options{DiskPath: filepath.Join(dir, "shared")})
+		if err != nil {
+			t.Fatalf("open shared store: %v", err)
+		}
+		return db
+	}
+	expectLogs := func(st raftLogStore, first, last uint64) {
+		t.Helper()
+		gotFirst, _ := st.FirstIndex()
+		gotLast, _ := st.LastIndex()
+		if gotFirst != first || gotLast != last {
+			t.Fatalf("expected logs %d-%d, got %d-%d", first, last, gotFirst, gotLast)
+		}
+		if last == 0 {
+			return
+		}
+		var entry raft.Log
+		if err := st.GetLog(last, &entry); err != nil {
+			t.Fatalf("get log %d: %v", last, err)
+		}
+		if string(entry.Data) != "entry" {
+			t.Fatalf("expected log data to be preserved, got %q", entry.Data)
+		}
+		term, err := st.GetUint64([]byte("CurrentTerm"))
+		if err != nil || term != 3 {
+			t.Fatalf("expected current term 3, got %d (%v)", term, err)
+		}
+	}
+
+	shared := openShared()
+	logs := make([]*raft.Log, 0, 2500)
+	for i := uint64(1); i <= 2500; i++ {
+		logs = append(logs, &raft.Log{Index: i, Term: 3, Type: raft.LogCommand, Data: []byte("entry")})
+	}
+	if err := shared.StoreLogs(logs); err != nil {
+		t.Fatalf("store logs: %v", err)
+	}
+	if err := shared.SetUint64([]byte("CurrentTerm"), 3); err != nil {
+		t.Fatalf("set current term: %v", err)
+	}
+
+	// Migrate to a dedicated store.
+	p.Options.LogStore = LogStoreBadger
+	dedicated, err := p.createLogStore(shared)
+	if err != nil {
+		t.Fatalf("create dedicated log store: %v", err)
+	}
+	expectLogs(dedicated, 1, 2500)
+	if err := dedicated.Close(); err != nil {
+		t.Fatalf("close dedicated log store: %v", err)
+	}
+	if err := shared.Close(); err != nil {
+		t.Fatalf("close shared store: %v", err)
+	}
+	shared = openShared()
+	expectLogs(shared, 0, 0)
+
+	// Switch to the write-ahead log, moving the logs out of the badger store.
+	p.Options.LogStore = LogStoreWAL
+	dedicated, err = p.createLogStore(shared)
+	if err != nil {
+		t.Fatalf("create wal log store: %v", err)
+	}
+	expectLogs(dedicated, 1, 2500)
+	if _, err := os.Stat(p.logStoreDir(LogStoreBadger)); !os.IsNotExist(err) {
+		t.Fatalf("expected badger log store to be removed, got %v", err)
+	}
+	if err := dedicated.Close(); err != nil {
+		t.Fatalf("close wal log store: %v", err)
+	}
+
+	// Migrate back to the shared store.
+	p.Options.LogStore = LogStoreShared
+	st, err := p.createLogStore(shared)
+	if err != nil {
+		t.Fatalf("create shared log store: %v", err)
+	}
+	if st != raftLogStore(shared) {
+		t.Fatal("expected the shared store to be used")
+	}
+	expectLogs(shared, 1, 2500)
+	if _, err := os.Stat(p.logStoreDir(LogStoreWAL)); !os.IsNotExist(err) {
+		t.Fatalf("expected dedicated log store to be removed, got %v", err)
+	}
+	if err := shared.Close(); err != nil {
+		t.Fatalf("close shared store: %v", err)
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/context"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/raftlogs"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -55,17 +56,39 @@ func (rs *RaftStorage) GetValue(ctx context.Context, key []byte) ([]byte, error)
 	if !rs.raft.started.Load() {
 		return nil, errors.ErrClosed
 	}
+	if rs.raft.corrupted.Load() {
+		return nil, errors.ErrCorrupted
+	}
 	if !types.IsValidPathID(string(key)) {
 		return nil, errors.ErrInvalidKey
 	}
 	return rs.storage.GetValue(ctx, key)
 }
 
+// GetValueVersion gets the value of a key along with its version. Versions are
+// the index of the log entry that last wrote the key, so a local read returns
+// the same version the leader would.
+func (rs *RaftStorage) GetValueVersion(ctx context.Context, key []byte) ([]byte, storage.Version, error) {
+	if !rs.raft.started.Load() {
+		return nil, storage.NoVersion, errors.ErrClosed
+	}
+	if rs.raft.corrupted.Load() {
+		return nil, storage.NoVersion, errors.ErrCorrupted
+	}
+	if !types.IsValidPathID(string(key)) {
+		return nil, storage.NoVersion, errors.ErrInvalidKey
+	}
+	return rs.storage.GetValueVersion(ctx, key)
+}
+
 // ListKeys returns a list of keys.
 func (rs *RaftStorage) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error) {
 	if !rs.raft.started.Load() {
 		return nil, errors.ErrClosed
 	}
+	if rs.raft.corrupted.Load() {
+		return nil, errors.ErrCorrupted
+	}
 	return rs.storage.ListKeys(ctx, prefix)
 }
 
@@ -74,6 +97,9 @@ func (rs *RaftStorage) IterPrefix(ctx context.Context, prefix []byte, fn storage
 	if !rs.raft.started.Load() {
 		return errors.ErrClosed
 	}
+	if rs.raft.corrupted.Load() {
+		return errors.ErrCorrupted
+	}
 	return rs.storage.IterPrefix(ctx, prefix, fn)
 }
 
@@ -82,9 +108,23 @@ func (rs *RaftStorage) Subscribe(ctx context.Context, prefix []byte, fn storage.
 	if !rs.raft.started.Load() {
 		return func() {}, errors.ErrClosed
 	}
+	if rs.raft.corrupted.Load() {
+		return func() {}, errors.ErrCorrupted
+	}
 	return rs.storage.Subscribe(ctx, prefix, fn)
 }
 
+// Watch streams changes to a prefix.
+func (rs *RaftStorage) Watch(ctx context.Context, prefix []byte) (<-chan storage.WatchEvent, error) {
+	if !rs.raft.started.Load() {
+		return nil, errors.ErrClosed
+	}
+	if rs.raft.corrupted.Load() {
+		return nil, errors.ErrCorrupted
+	}
+	return rs.storage.Watch(ctx, prefix)
+}
+
 // Put sets the value of a key.
 func (rs *RaftStorage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
 	if !rs.raft.started.Load() {
@@ -110,6 +150,25 @@ func (rs *RaftStorage) PutValue(ctx context.Context, key, value []byte, ttl time
 	return rs.sendLogToLeader(ctx, &logEntry)
 }
 
+// CompareAndSwap sets the value of a key only if its current version matches the expected one.
+func (rs *RaftStorage) CompareAndSwap(ctx context.Context, key, value []byte, expected storage.Version, ttl time.Duration) error {
+	if !rs.raft.started.Load() {
+		return errors.ErrClosed
+	}
+	if !types.IsValidPathID(string(key)) {
+		return errors.ErrInvalidKey
+	}
+	if !rs.raft.isVoter() {
+		return errors.ErrNotVoter
+	}
+	// The version is checked by the FSM when the entry is applied.
+	logEntry := raftlogs.NewCompareAndSwapEntry(key, value, expected, ttl)
+	if rs.raft.Consensus().IsLeader() {
+		return rs.applyLog(ctx, logEntry)
+	}
+	return rs.sendLogToLeader(ctx, logEntry)
+}
+
 // Delete removes a key.
 func (rs *RaftStorage) Delete(ctx context.Context, key []byte) error {
 	if !rs.raft.started.Load() {
@@ -133,6 +192,34 @@ func (rs *RaftStorage) Delete(ctx context.Context, key []byte) error {
 	return rs.sendLogToLeader(ctx, &logEntry)
 }
 
+// WriteBatch applies all of the given writes in a single raft log entry.
+func (rs *RaftStorage) WriteBatch(ctx context.Context, ops []storage.WriteOp) error {
+	if !rs.raft.started.Load() {
+		return errors.ErrClosed
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+	for _, op := range ops {
+		if !types.IsValidPathID(string(op.Key)) {
+			return errors.ErrInvalidKey
+		}
+	}
+	if !rs.raft.isVoter() {
+		return errors.ErrNotVoter
+	}
+	logEntry, err := raftlogs.NewBatchEntry(ops)
+	if err != nil {
+		return err
+	}
+	if rs.raft.Consensus().IsLeader() {
+		// lock is taken in the FSM
+		return rs.applyLog(ctx, logEntry)
+	}
+	// We need to forward the request to the leader.
+	return rs.sendLogToLeader(ctx, logEntry)
+}
+
 func (rs *RaftStorage) sendLogToLeader(ctx context.Context, logEntry *v1.RaftLogEntry) error {
 	log := context.LoggerFrom(ctx)
 	log.Debug("sending log to leader")
@@ -147,8 +234,8 @@ func (rs *RaftStorage) sendLogToLeader(ctx context.Context, logEntry *v1.RaftLog
 		return fmt.Errorf("apply log entry: %w", err)
 	}
 	log.Debug("applied log entry", slog.String("time", resp.GetTime()))
-	if resp.GetError() != "" {
-		return fmt.Errorf("apply log entry: %s", resp.GetError())
+	if err := raftlogs.ResponseError(resp); err != nil {
+		return fmt.Errorf("apply log entry: %w", err)
 	}
 	return nil
 }
@@ -170,8 +257,8 @@ func (rs *RaftStorage) applyLog(ctx context.Context, logEntry *v1.RaftLogEntry)
 		}
 		return fmt.Errorf("apply log entry: %w", err)
 	}
-	if res.GetError() != "" {
-		return fmt.Errorf("apply log entry data: %s", res.GetError())
+	if err := raftlogs.ResponseError(res); err != nil {
+		return fmt.Errorf("apply log entry data: %w", err)
 	}
 	return nil
 }
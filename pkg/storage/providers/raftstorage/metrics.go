@@ -0,0 +1,120 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Raft metrics. Metrics of the FSM are defined in the fsm package.
+var (
+	// CommitDuration tracks how long log entries submitted by the leader take
+	// to be committed and applied.
+	CommitDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "webmesh",
+		Name:      "raft_commit_duration_seconds",
+		Help:      "Time taken for raft log entries submitted by the leader to be committed and applied.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	}, []string{"node_id"})
+
+	// LeaderChangesTotal tracks the number of leader changes observed.
+	LeaderChangesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "webmesh",
+		Name:      "raft_leader_changes_total",
+		Help:      "Total number of raft leader changes observed.",
+	}, []string{"node_id"})
+
+	// LocalDataCorrupt is set to 1 when a scrub finds corrupt local data and
+	// the node stops serving reads.
+	LocalDataCorrupt = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "webmesh",
+		Name:      "raft_local_data_corrupt",
+		Help:      "Set to 1 when a scrub found corrupt local raft data and reads are refused.",
+	}, []string{"node_id"})
+)
+
+// raftStats exposes the indexes and term of every started provider. Gauges are
+// labeled with the raft listen address as well, since a node runs a provider for
+// every shard when storage is sharded.
+var raftStats = newStatsCollector()
+
+func init() {
+	prometheus.MustRegister(raftStats)
+}
+
+// statsCollector reads gauges from the raft stats of started providers when
+// scraped.
+type statsCollector struct {
+	descs     map[string]*prometheus.Desc
+	providers map[*Provider]struct{}
+	mu        sync.Mutex
+}
+
+func newStatsCollector() *statsCollector {
+	gauge := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("webmesh", "raft", name), help, []string{"node_id", "listen_addr"}, nil)
+	}
+	return &statsCollector{
+		descs: map[string]*prometheus.Desc{
+			"last_log_index":      gauge("last_log_index", "Index of the last entry in the raft log."),
+			"commit_index":        gauge("commit_index", "Index of the last committed raft log entry."),
+			"applied_index":       gauge("applied_index", "Index of the last raft log entry applied to the FSM."),
+			"last_snapshot_index": gauge("last_snapshot_index", "Index of the last raft snapshot."),
+			"term":                gauge("term", "Current raft term."),
+		},
+		providers: make(map[*Provider]struct{}),
+	}
+}
+
+func (c *statsCollector) add(p *Provider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.providers[p] = struct{}{}
+}
+
+func (c *statsCollector) remove(p *Provider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.providers, p)
+}
+
+// Describe implements prometheus.Collector.
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range c.descs {
+		ch <- desc
+	}
+}
+
+// Collect implements prometheus.Collector.
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p := range c.providers {
+		stats := p.raft.Stats()
+		for stat, desc := range c.descs {
+			value, err := strconv.ParseUint(stats[stat], 10, 64)
+			if err != nil {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value), p.Options.NodeID.String(), string(p.Options.Transport.LocalAddr()))
+		}
+	}
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	transport, err := tcp.NewRaftTransport(nil, tcp.RaftTransportOptions{
+		Addr:    "[::]:0",
+		MaxPool: 10,
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create raft transport: %v", err)
+	}
+	p := NewProvider(newTestOptions(transport))
+	if err := p.Start(ctx); err != nil {
+		t.Fatalf("start provider: %v", err)
+	}
+	defer p.Close()
+	if err := p.Bootstrap(ctx); err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	if err := p.MeshStorage().PutValue(ctx, []byte("/registry/key"), []byte("value"), 0); err != nil {
+		t.Fatalf("put value: %v", err)
+	}
+	nodeID := p.Options.NodeID.String()
+
+	if n := testutil.ToFloat64(LeaderChangesTotal.WithLabelValues(nodeID)); n < 1 {
+		t.Fatalf("expected a leader change to be counted, got %v", n)
+	}
+	for name, h := range map[string]prometheus.Collector{
+		"commit": CommitDuration.WithLabelValues(nodeID).(prometheus.Histogram),
+		"apply":  fsm.ApplyDuration.WithLabelValues(nodeID).(prometheus.Histogram),
+	} {
+		if n := testutil.CollectAndCount(h); n != 1 {
+			t.Fatalf("expected %s duration to be recorded, got %d series", name, n)
+		}
+	}
+
+	// The stats collector only reports started providers.
+	reported := func() bool {
+		metrics := make(chan prometheus.Metric, 100)
+		raftStats.Collect(metrics)
+		close(metrics)
+		for m := range metrics {
+			if m.Desc() != raftStats.descs["applied_index"] {
+				continue
+			}
+			var out dto.Metric
+			if err := m.Write(&out); err != nil {
+				t.Fatalf("write metric: %v", err)
+			}
+			for _, label := range out.GetLabel() {
+				if label.GetName() == "node_id" && label.GetValue() == nodeID && out.GetGauge().GetValue() > 0 {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	if !reported() {
+		t.Fatal("expected the applied index of the provider to be reported")
+	}
+
+	// The stats of the provider can be encoded in a status document.
+	stats := p.Stats()
+	if stats["role"] != "Leader" {
+		t.Fatalf("expected provider to report the leader role, got %v", stats["role"])
+	}
+	if n, _ := stats["appliedIndex"].(uint64); n == 0 {
+		t.Fatalf("expected provider to report its applied index, got %v", stats["appliedIndex"])
+	}
+	if _, ok := stats["backend"]; !ok {
+		t.Fatal("expected provider to report backend stats")
+	}
+	if _, err := structpb.NewStruct(stats); err != nil {
+		t.Fatalf("encode stats: %v", err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("close provider: %v", err)
+	}
+	if reported() {
+		t.Fatal("expected closed provider to no longer be reported")
+	}
+}
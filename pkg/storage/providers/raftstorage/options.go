@@ -24,8 +24,13 @@ import (
 	"github.com/hashicorp/raft"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/logging"
 	"github.com/webmeshproj/webmesh/pkg/meshnet/transport"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/s3snapshots"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/snapshots"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/wal"
 	"github.com/webmeshproj/webmesh/pkg/storage/types"
 )
 
@@ -45,6 +50,15 @@ const (
 	// DefaultBarrierThreshold is the threshold for sending a barrier after
 	// a write operation.
 	DefaultBarrierThreshold = 10
+	// DefaultScrubInterval is the default interval for verifying the integrity
+	// of the local log store and snapshots. Scrubbing is disabled by default.
+	DefaultScrubInterval = time.Duration(0)
+	// DefaultReadCacheTTL is the default maximum time an entry is served from
+	// the read cache.
+	DefaultReadCacheTTL = 30 * time.Second
+	// DefaultGraphSnapshotInterval is the default interval at which the leader
+	// refreshes the graph snapshot stored alongside the registry.
+	DefaultGraphSnapshotInterval = time.Minute
 )
 
 // Options are the raft options.
@@ -76,6 +90,16 @@ type Options struct {
 	MaxAppendEntries int
 	// LeaderLeaseTimeout is the timeout for leader leases.
 	LeaderLeaseTimeout time.Duration
+	// ElectionMultiplier scales the heartbeat, election, and leader lease
+	// timeouts. Raising it reduces spurious elections on high-latency networks
+	// at the cost of slower failover. Values below 1 are treated as 1.
+	ElectionMultiplier int
+	// StableLeader, if set, raises the heartbeat and election timeouts of this
+	// node at runtime when contact with the leader is observed to be slow.
+	StableLeader *StableLeaderOptions
+	// VoterPolicy, if set, automatically promotes observers to voters and
+	// demotes flaky voters while this node is the leader.
+	VoterPolicy *VoterPolicyOptions
 	// SnapshotInterval is the interval to take snapshots.
 	SnapshotInterval time.Duration
 	// SnapshotThreshold is the threshold to take snapshots.
@@ -86,31 +110,93 @@ type Options struct {
 	ObserverChanBuffer int
 	// BarrierThreshold is the threshold for sending a barrier after a write operation.
 	BarrierThreshold int32
+	// ScrubInterval is the interval for verifying the integrity of the local log
+	// store and snapshots. If zero, scrubbing is disabled.
+	ScrubInterval time.Duration
+	// ReadCacheSize is the number of keys and prefixes to keep in an LRU read cache
+	// in front of the local storage. If zero, the read cache is disabled.
+	ReadCacheSize int
+	// ReadCacheTTL is the maximum time an entry is served from the read cache.
+	// Writes always invalidate the entries they touch, this only bounds how long
+	// a key written with a TTL can outlive its expiry.
+	ReadCacheTTL time.Duration
+	// GraphSnapshotInterval is the interval at which the leader refreshes the
+	// compact graph snapshot stored alongside the registry, if the graph changed.
+	// If zero, no snapshots are written and the graph is read key by key.
+	GraphSnapshotInterval time.Duration
+	// CheckInvariants checks the referential integrity of the mesh database
+	// after every applied log and logs or panics on violations. It is off by
+	// default and meant for debugging.
+	CheckInvariants fsm.InvariantMode
+	// RaftLogFormat is the format commands are encoded in in the raft log.
+	// Every storage member must use the same format, and it cannot be changed
+	// once the cluster is bootstrapped. Defaults to protobuf+snappy.
+	RaftLogFormat fsm.LogFormat
+	// RaftLogCompressionLevel is the zstd compression level used by zstd log
+	// formats. Defaults to fsm.DefaultLogCompressionLevel.
+	RaftLogCompressionLevel int
+	// LogStore is where raft logs and stable state are stored. Logs are
+	// migrated to the configured store on startup. Defaults to LogStoreShared.
+	LogStore LogStore
+	// WAL are options for the write-ahead log when LogStore is LogStoreWAL.
+	WAL wal.Options
+	// IncrementalSnapshots is the number of incremental snapshots taken between
+	// full snapshots. Incremental snapshots only record the keys changed since
+	// the previous snapshot. It must be less than SnapshotRetention so every
+	// chain can be resolved, and it is ignored for in-memory storage.
+	IncrementalSnapshots int
+	// S3Snapshots, if set, stores snapshots in an S3 compatible object store
+	// instead of the data directory. This lets nodes without persistent disks
+	// recover their state. The retention is taken from SnapshotRetention.
+	S3Snapshots *s3snapshots.Options
+	// SnapshotEncryptionKey, if set, encrypts snapshots with a key derived from
+	// it and signs them with NodeKey. Every node in the cluster must use the
+	// same key, and snapshots that are not sealed with it are rejected.
+	SnapshotEncryptionKey []byte
+	// NodeKey is the key of the local node. It is required when
+	// SnapshotEncryptionKey is set.
+	NodeKey crypto.PrivateKey
+	// SnapshotExport, if set, periodically exports full snapshots to a
+	// directory or object store for backups.
+	SnapshotExport *SnapshotExportOptions
+	// OnSnapshotRestore, if set, is called after every snapshot restored to
+	// the local storage, including the one restored on startup. It runs before
+	// any later log is applied, so applications can deterministically rebuild
+	// caches derived from the mesh database.
+	OnSnapshotRestore SnapshotRestoreHook
 	// LogLevel is the log level for the raft backend.
 	LogLevel string
 	// LogFormat is the log format for the raft backend.
 	LogFormat string
 }
 
+// SnapshotRestoreHook is called with every snapshot restored to the local
+// storage. The snapshot can be iterated to rebuild state from the restored keys.
+// It runs while the FSM is locked and must not apply raft logs.
+type SnapshotRestoreHook func(ctx context.Context, snapshot *snapshots.Restored)
+
 // NewOptions returns new raft options with sensible defaults.
 func NewOptions(nodeID types.NodeID, transport transport.RaftTransport) Options {
 	return Options{
-		NodeID:             nodeID,
-		Transport:          transport,
-		DataDir:            DefaultDataDir,
-		ConnectionTimeout:  time.Second * 3,
-		HeartbeatTimeout:   time.Second * 3,
-		ElectionTimeout:    time.Second * 3,
-		ApplyTimeout:       time.Second * 15,
-		CommitTimeout:      time.Second * 15,
-		LeaderLeaseTimeout: time.Second * 3,
-		SnapshotInterval:   time.Minute * 3,
-		SnapshotThreshold:  5,
-		MaxAppendEntries:   15,
-		SnapshotRetention:  3,
-		ObserverChanBuffer: 100,
-		BarrierThreshold:   DefaultBarrierThreshold,
-		LogLevel:           "info",
+		NodeID:                nodeID,
+		Transport:             transport,
+		DataDir:               DefaultDataDir,
+		ConnectionTimeout:     time.Second * 3,
+		HeartbeatTimeout:      time.Second * 3,
+		ElectionTimeout:       time.Second * 3,
+		ApplyTimeout:          time.Second * 15,
+		CommitTimeout:         time.Second * 15,
+		LeaderLeaseTimeout:    time.Second * 3,
+		SnapshotInterval:      time.Minute * 3,
+		SnapshotThreshold:     5,
+		MaxAppendEntries:      15,
+		SnapshotRetention:     3,
+		ObserverChanBuffer:    100,
+		BarrierThreshold:      DefaultBarrierThreshold,
+		ScrubInterval:         DefaultScrubInterval,
+		ReadCacheTTL:          DefaultReadCacheTTL,
+		GraphSnapshotInterval: DefaultGraphSnapshotInterval,
+		LogLevel:              "info",
 	}
 }
 
@@ -134,6 +220,12 @@ func (o *Options) RaftConfig(ctx context.Context, nodeID string) *raft.Config {
 	if o.LeaderLeaseTimeout != 0 {
 		config.LeaderLeaseTimeout = o.LeaderLeaseTimeout
 	}
+	if o.ElectionMultiplier > 1 {
+		multiplier := time.Duration(o.ElectionMultiplier)
+		config.HeartbeatTimeout *= multiplier
+		config.ElectionTimeout *= multiplier
+		config.LeaderLeaseTimeout *= multiplier
+	}
 	if o.SnapshotInterval != 0 {
 		config.SnapshotInterval = o.SnapshotInterval
 	}
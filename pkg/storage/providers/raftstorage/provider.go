@@ -24,6 +24,8 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -38,11 +40,22 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/s3snapshots"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/snapshots"
 )
 
 // Ensure we satisfy the provider interface.
 var _ storage.Provider = &Provider{}
 
+// Ensure we satisfy the term provider interface.
+var _ storage.TermProvider = &Provider{}
+
+// Ensure we satisfy the raft log format provider interface.
+var _ storage.RaftLogFormatProvider = &Provider{}
+
+// Ensure we satisfy the stats reporter interface.
+var _ storage.StatsReporter = &Provider{}
+
 // Ensure that RaftStorage implements a MonothonicLogStore.
 var _ = raft.MonotonicLogStore(&MonotonicLogStore{})
 
@@ -91,6 +104,7 @@ type Provider struct {
 	Options
 	nodeID                      raft.ServerID
 	started                     atomic.Bool
+	corrupted                   atomic.Bool
 	raft                        *raft.Raft
 	raftStorage                 *RaftStorage
 	meshDB                      storage.MeshDB
@@ -99,10 +113,33 @@ type Provider struct {
 	observerChan                chan raft.Observation
 	observerClose, observerDone chan struct{}
 	observerCbs                 []ObservationCallback
+	applyHooks                  []fsm.ApplyHook
+	localStorage                storage.DualStorage
+	logStore                    raftLogStore
+	fsm                         *fsm.RaftFSM
+	snapshots                   raft.SnapshotStore
+	sealer                      *snapshots.Sealer
+	codec                       *fsm.LogCodec
+	scrubClose, scrubDone       chan struct{}
+	graphClose, graphDone       chan struct{}
+	exportClose, exportDone     chan struct{}
+	stableClose, stableDone     chan struct{}
+	voterClose, voterDone       chan struct{}
+	stableMultiplier            atomic.Int32
+	failedHeartbeats            map[raft.ServerID]time.Time
+	heartbeatMu                 sync.Mutex
+	corruptionCbs               []CorruptionCallback
+	cbmu                        sync.Mutex
+	dataDirLock                 *dataDirLock
 	log                         *slog.Logger
 	mu                          sync.RWMutex
 }
 
+// RaftLogFormat returns the format log entries are encoded in.
+func (r *Provider) RaftLogFormat() string {
+	return string(r.Options.RaftLogFormat.OrDefault())
+}
+
 // NewProvider returns a new RaftStorageProvider.
 func NewProvider(opts Options) *Provider {
 	p := &Provider{
@@ -123,6 +160,20 @@ func (r *Provider) OnObservation(cb ObservationCallback) {
 	r.observerCbs = append(r.observerCbs, cb)
 }
 
+// OnApply registers a hook that is called synchronously with every log entry
+// applied to the local storage and after every snapshot restore. Hooks
+// registered before Start also see the logs replayed on startup, so they can
+// maintain state derived from the mesh database without polling it.
+func (r *Provider) OnApply(hook fsm.ApplyHook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.fsm != nil {
+		r.fsm.OnApply(hook)
+		return
+	}
+	r.applyHooks = append(r.applyHooks, hook)
+}
+
 // MeshStorage returns the underlying MeshStorage instance.
 func (r *Provider) MeshStorage() storage.MeshStorage {
 	return r.raftStorage
@@ -151,29 +202,123 @@ func (r *Provider) Start(ctx context.Context) error {
 		return errors.ErrStarted
 	}
 	r.log.Debug("Starting raft storage provider")
+	var unclean bool
+	if !r.Options.InMemory {
+		// Fence the data directory before touching anything inside it.
+		lock, err := lockDataDir(r.Options.DataDir, r.Options.NodeID.String())
+		if err != nil {
+			return err
+		}
+		r.dataDirLock = lock
+		unclean = lock.unclean
+		r.log.Debug("Acquired data directory lock", slog.Uint64("fencing-token", lock.token))
+	}
+	handleErr := func(cause error) error {
+		r.releaseDataDir()
+		return cause
+	}
+	if len(r.Options.SnapshotEncryptionKey) > 0 {
+		sealer, err := snapshots.NewSealer(r.Options.SnapshotEncryptionKey, r.Options.NodeKey)
+		if err != nil {
+			return handleErr(fmt.Errorf("create snapshot sealer: %w", err))
+		}
+		r.sealer = sealer
+	}
+	codec, err := fsm.NewLogCodec(r.Options.RaftLogFormat, r.Options.RaftLogCompressionLevel)
+	if err != nil {
+		return handleErr(fmt.Errorf("create log codec: %w", err))
+	}
+	r.codec = codec
 	storage, err := r.createStorage()
 	if err != nil {
-		return fmt.Errorf("create storage: %w", err)
+		return handleErr(fmt.Errorf("create storage: %w", err))
+	}
+	logStore, err := r.createLogStore(storage)
+	if err != nil {
+		return handleErr(fmt.Errorf("create log store: %w", err))
+	}
+	if err := checkLogFormat(logStore, codec); err != nil {
+		return handleErr(err)
+	}
+	r.logStore = logStore
+	// Set the raft storage instance. Writes applied by the FSM go through the
+	// read cache when enabled so they invalidate it.
+	r.localStorage = storage
+	meshStorage := storage
+	if r.Options.ReadCacheSize > 0 {
+		cache, err := newReadCache(storage, r.Options.NodeID.String(), r.Options.ReadCacheSize, r.Options.ReadCacheTTL)
+		if err != nil {
+			return handleErr(fmt.Errorf("create read cache: %w", err))
+		}
+		meshStorage = cache
+	}
+	r.raftStorage.storage = meshStorage
+	if unclean && !r.Options.ClearDataDir {
+		r.log.Warn("Data directory was not shut down cleanly, running recovery")
+		if rec, ok := storage.(badgerdb.Recoverer); ok {
+			if err := rec.Recover(ctx); err != nil {
+				return handleErr(fmt.Errorf("recover storage: %w", err))
+			}
+		}
+		bad, err := verifySnapshots(r.Options.DataDir, r.sealer, r.Options.LogLevel, r.log)
+		if err != nil {
+			return handleErr(fmt.Errorf("verify snapshots: %w", err))
+		}
+		r.log.Info("Recovery complete", slog.Int("corrupt-snapshots", bad))
 	}
-	// Set the raft storage instance.
-	r.raftStorage.storage = storage
 	snapshots, err := r.createSnapshotStorage()
 	if err != nil {
-		return fmt.Errorf("create snapshot storage: %w", err)
+		return handleErr(fmt.Errorf("create snapshot storage: %w", err))
+	}
+	var exportStore raft.SnapshotStore
+	if r.Options.SnapshotExport != nil {
+		exportStore, err = r.newSnapshotExportStore()
+		if err != nil {
+			return handleErr(fmt.Errorf("create snapshot export store: %w", err))
+		}
+	}
+	var logs raftLogStore = logStore
+	var fence func() error
+	if r.dataDirLock != nil {
+		// Recovery can take a while, make sure the data directory is still
+		// ours before raft starts writing to it.
+		if err := r.dataDirLock.check(); err != nil {
+			return handleErr(err)
+		}
+		logs = &fencedLogStore{raftLogStore: logStore, lock: r.dataDirLock}
+		fence = r.dataDirLock.check
 	}
 	r.log.Debug("Starting raft instance", slog.String("listen-addr", string(r.Options.Transport.LocalAddr())))
+	r.snapshots = snapshots
+	hooks := r.applyHooks
+	if r.Options.OnSnapshotRestore != nil {
+		hooks = append(slices.Clone(hooks), func(ctx context.Context, ev fsm.ApplyEvent) {
+			if ev.Restored {
+				r.Options.OnSnapshotRestore(ctx, ev.Snapshot)
+			}
+		})
+	}
+	r.fsm = fsm.New(ctx, meshStorage, fsm.Options{
+		NodeID:               r.Options.NodeID.String(),
+		ApplyTimeout:         r.Options.ApplyTimeout,
+		CheckInvariants:      r.Options.CheckInvariants,
+		IncrementalSnapshots: r.incrementalSnapshots(),
+		SnapshotSealer:       r.sealer,
+		ApplyHooks:           hooks,
+		LogCodec:             r.codec,
+		Fence:                fence,
+	})
+	raftConfig := r.Options.RaftConfig(ctx, string(r.nodeID))
 	r.raft, err = raft.NewRaft(
-		r.Options.RaftConfig(ctx, string(r.nodeID)),
-		fsm.New(ctx, storage, fsm.Options{
-			ApplyTimeout: r.Options.ApplyTimeout,
-		}),
-		&MonotonicLogStore{storage},
-		storage,
+		raftConfig,
+		r.fsm,
+		&MonotonicLogStore{logs},
+		logs,
 		snapshots,
 		r.Options.Transport,
 	)
 	if err != nil {
-		return fmt.Errorf("new raft: %w", err)
+		return handleErr(fmt.Errorf("new raft: %w", err))
 	}
 	// Register observers.
 	r.observerChan = make(chan raft.Observation, r.Options.ObserverChanBuffer)
@@ -182,11 +327,50 @@ func (r *Provider) Start(ctx context.Context) error {
 	})
 	r.raft.RegisterObserver(r.observer)
 	r.observerClose, r.observerDone = r.observe()
+	if r.Options.ScrubInterval > 0 {
+		r.scrubClose, r.scrubDone = r.runScrubber()
+	}
+	if r.Options.GraphSnapshotInterval > 0 {
+		r.graphClose, r.graphDone = r.runGraphSnapshotter()
+	}
+	if exportStore != nil {
+		r.exportClose, r.exportDone = r.runSnapshotExporter(exportStore)
+	}
+	if r.Options.StableLeader != nil {
+		r.stableClose, r.stableDone = r.runStableLeader(raftConfig.HeartbeatTimeout, raftConfig.ElectionTimeout)
+	}
+	if r.Options.VoterPolicy != nil {
+		r.voterClose, r.voterDone = r.runVoterPolicy()
+	}
+	raftStats.add(r)
 	// We're done here.
 	r.started.Store(true)
 	return nil
 }
 
+// FencingToken returns the fencing token issued when the data directory was
+// locked. It increases monotonically across restarts on the same data directory
+// and is zero for in-memory storage or before the provider is started.
+func (r *Provider) FencingToken() uint64 {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.dataDirLock == nil {
+		return 0
+	}
+	return r.dataDirLock.token
+}
+
+// releaseDataDir releases the data directory lock if held.
+func (r *Provider) releaseDataDir() {
+	if r.dataDirLock == nil {
+		return
+	}
+	if err := r.dataDirLock.release(); err != nil {
+		r.log.Error("Failed to release data directory lock", slog.String("error", err.Error()))
+	}
+	r.dataDirLock = nil
+}
+
 // Status returns the status of the storage provider.
 func (r *Provider) Status() *v1.StorageStatus {
 	r.mu.RLock()
@@ -318,8 +502,51 @@ func (r *Provider) Close() error {
 	r.log.Debug("Stopping raft storage provider")
 	defer r.log.Debug("Raft storage provider stopped")
 	defer r.started.Store(false)
+	// Released last so the directory is only marked clean once storage is closed.
+	defer r.releaseDataDir()
 	defer r.raftStorage.Close()
+	defer func() {
+		if r.logStore != r.localStorage {
+			r.logStore.Close()
+		}
+	}()
 	defer r.Options.Transport.Close()
+	raftStats.remove(r)
+	if r.scrubClose != nil {
+		close(r.scrubClose)
+		<-r.scrubDone
+		r.scrubClose, r.scrubDone = nil, nil
+	}
+	if r.graphClose != nil {
+		close(r.graphClose)
+		<-r.graphDone
+		r.graphClose, r.graphDone = nil, nil
+	}
+	if r.exportClose != nil {
+		close(r.exportClose)
+		<-r.exportDone
+		r.exportClose, r.exportDone = nil, nil
+	}
+	if r.stableClose != nil {
+		close(r.stableClose)
+		<-r.stableDone
+		r.stableClose, r.stableDone = nil, nil
+	}
+	if r.voterClose != nil {
+		close(r.voterClose)
+		<-r.voterDone
+		r.voterClose, r.voterDone = nil, nil
+	}
+	if r.raft.State() == raft.Leader {
+		// Hand leadership to a healthy voter so the cluster does not have
+		// to wait out an election timeout after we are gone.
+		ctx, cancel := context.WithTimeout(context.Background(), r.Options.ApplyTimeout)
+		err := r.transferToHealthyVoter(ctx)
+		cancel()
+		if err != nil && !errors.Is(err, errors.ErrNoHealthyVoter) {
+			r.log.Warn("Failed to transfer leadership before shutdown", slog.String("error", err.Error()))
+		}
+	}
 	// If we were not running in memory, force a snapshot.
 	if !r.Options.InMemory {
 		r.log.Debug("Taking raft storage snapshot")
@@ -342,6 +569,62 @@ func (r *Provider) GetRaftConfiguration() raft.Configuration {
 	return r.raft.GetConfiguration().Configuration()
 }
 
+// CurrentTerm returns the current raft term.
+func (r *Provider) CurrentTerm() uint64 {
+	if !r.started.Load() || r.raft == nil {
+		return 0
+	}
+	term, _ := strconv.ParseUint(r.raft.Stats()["term"], 10, 64)
+	return term
+}
+
+// Stats returns the raft role, term, and indexes along with the statistics of
+// the underlying storage.
+func (r *Provider) Stats() map[string]any {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if !r.started.Load() || r.raft == nil {
+		return map[string]any{"role": "Shutdown"}
+	}
+	stats := r.raft.Stats()
+	out := map[string]any{
+		"role":        stats["state"],
+		"lastContact": stats["last_contact"],
+		"logStore":    string(r.Options.LogStore.OrDefault()),
+	}
+	for key, stat := range map[string]string{
+		"term":              "term",
+		"commitIndex":       "commit_index",
+		"appliedIndex":      "applied_index",
+		"lastLogIndex":      "last_log_index",
+		"lastLogTerm":       "last_log_term",
+		"lastSnapshotIndex": "last_snapshot_index",
+		"lastSnapshotTerm":  "last_snapshot_term",
+	} {
+		if value, err := strconv.ParseUint(stats[stat], 10, 64); err == nil {
+			out[key] = value
+		}
+	}
+	if st, ok := r.localStorage.(storage.StatsReporter); ok {
+		out["backend"] = st.Stats()
+	}
+	if r.logStore != r.localStorage {
+		if st, ok := r.logStore.(storage.StatsReporter); ok {
+			out["logStoreBackend"] = st.Stats()
+		}
+	}
+	return out
+}
+
+// LastLogTerm returns the term of the last entry in the local raft log.
+func (r *Provider) LastLogTerm() uint64 {
+	if !r.started.Load() || r.raft == nil {
+		return 0
+	}
+	term, _ := strconv.ParseUint(r.raft.Stats()["last_log_term"], 10, 64)
+	return term
+}
+
 // ApplyRaftLog applies a raft log entry.
 func (r *Provider) ApplyRaftLog(ctx context.Context, log *v1.RaftLogEntry) (*v1.RaftApplyResponse, error) {
 	r.mu.Lock()
@@ -361,15 +644,17 @@ func (r *Provider) ApplyRaftLog(ctx context.Context, log *v1.RaftLogEntry) (*v1.
 		slog.String("key", string(log.Key)),
 		slog.Duration("timeout", timeout),
 	)
-	data, err := fsm.MarshalLogEntry(log)
+	data, err := r.codec.Marshal(log)
 	if err != nil {
 		return nil, fmt.Errorf("marshal log entry: %w", err)
 	}
+	start := time.Now()
 	f := r.raft.Apply(data, timeout)
 	err = f.Error()
 	if err != nil {
 		return nil, fmt.Errorf("apply: %w", err)
 	}
+	CommitDuration.WithLabelValues(r.Options.NodeID.String()).Observe(time.Since(start).Seconds())
 	resp, ok := f.Response().(*v1.RaftApplyResponse)
 	if !ok {
 		return nil, fmt.Errorf("apply: invalid response type")
@@ -436,8 +721,38 @@ func (r *Provider) createStorage() (storage.DualStorage, error) {
 	return db, nil
 }
 
-// createSnapshotStorage creates the snapshot storage.
+// incrementalSnapshots returns the number of incremental snapshots to take
+// between full snapshots. The in-memory snapshot store only keeps the latest
+// snapshot, so incremental snapshots are disabled for it.
+func (r *Provider) incrementalSnapshots() int {
+	if r.Options.InMemory && r.Options.S3Snapshots == nil {
+		return 0
+	}
+	return r.Options.IncrementalSnapshots
+}
+
+// createSnapshotStorage creates the snapshot storage. Stores are wrapped in a
+// chain store so incremental snapshots are always opened as full snapshots,
+// resealed when snapshot encryption is enabled.
 func (r *Provider) createSnapshotStorage() (raft.SnapshotStore, error) {
+	store, err := r.newSnapshotStore()
+	if err != nil {
+		return nil, err
+	}
+	return snapshots.NewChainStore(store, r.sealer), nil
+}
+
+func (r *Provider) newSnapshotStore() (raft.SnapshotStore, error) {
+	if r.Options.S3Snapshots != nil {
+		opts := *r.Options.S3Snapshots
+		opts.Retain = int(r.Options.SnapshotRetention)
+		opts.Logger = r.log
+		snapshotStore, err := s3snapshots.New(opts)
+		if err != nil {
+			return nil, fmt.Errorf("new s3 snapshot store: %w", err)
+		}
+		return snapshotStore, nil
+	}
 	if r.Options.InMemory {
 		return raft.NewInmemSnapshotStore(), nil
 	}
@@ -472,11 +787,13 @@ func (r *Provider) observe() (closeCh, doneCh chan struct{}) {
 					r.log.Debug("PeerObservation", slog.Any("data", data))
 				case raft.LeaderObservation:
 					r.log.Debug("LeaderObservation", slog.Any("data", data))
+					LeaderChangesTotal.WithLabelValues(r.Options.NodeID.String()).Inc()
 				case raft.ResumedHeartbeatObservation:
 					r.log.Debug("ResumedHeartbeatObservation", slog.Any("data", data))
 				case raft.FailedHeartbeatObservation:
 					r.log.Debug("FailedHeartbeatObservation", slog.Any("data", data))
 				}
+				r.trackHeartbeat(ev)
 				for _, obs := range r.observerCbs {
 					obs(context.Background(), ev)
 				}
@@ -35,7 +35,14 @@ func TestInMemoryProviderConformance(t *testing.T) {
 	testutil.TestStorageProviderConformance(context.Background(), t, builder.newProviders)
 }
 
-type builder struct{}
+func TestInMemoryProviderConformanceWithReadCache(t *testing.T) {
+	builder := &builder{readCacheSize: 128}
+	testutil.TestStorageProviderConformance(context.Background(), t, builder.newProviders)
+}
+
+type builder struct {
+	readCacheSize int
+}
 
 func (b *builder) newProviders(t *testing.T, count int) []storage.Provider {
 	var out []storage.Provider
@@ -48,7 +55,9 @@ func (b *builder) newProviders(t *testing.T, count int) []storage.Provider {
 		if err != nil {
 			t.Fatalf("failed to create raft transport: %v", err)
 		}
-		out = append(out, NewProvider(newTestOptions(transport)))
+		opts := newTestOptions(transport)
+		opts.ReadCacheSize = b.readCacheSize
+		out = append(out, NewProvider(opts))
 	}
 
 	return out
@@ -58,6 +58,39 @@ func Apply(ctx context.Context, db storage.MeshStorage, logEntry *v1.RaftLogEntr
 		}
 		res.Time = time.Since(start).String()
 		return res
+	case CommandCompareAndSwap:
+		value, expected, err := DecodeCompareAndSwap(logEntry)
+		if err != nil {
+			return &v1.RaftApplyResponse{
+				Error: err.Error(),
+			}
+		}
+		log.Debug("Applying compare-and-swap",
+			slog.String("key", string(logEntry.GetKey())),
+			slog.String("expected", expected.String()),
+		)
+		err = db.CompareAndSwap(ctx, logEntry.GetKey(), value, expected, logEntry.Ttl.AsDuration())
+		res := &v1.RaftApplyResponse{}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		res.Time = time.Since(start).String()
+		return res
+	case CommandBatch:
+		ops, err := DecodeBatch(logEntry)
+		if err != nil {
+			return &v1.RaftApplyResponse{
+				Error: err.Error(),
+			}
+		}
+		log.Debug("Applying batch", slog.Int("writes", len(ops)))
+		err = db.WriteBatch(ctx, ops)
+		res := &v1.RaftApplyResponse{}
+		if err != nil {
+			res.Error = err.Error()
+		}
+		res.Time = time.Since(start).String()
+		return res
 	default:
 		return &v1.RaftApplyResponse{
 			Error: fmt.Sprintf("unknown command type: %v", logEntry.GetType()),
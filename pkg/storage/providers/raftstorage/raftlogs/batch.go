@@ -0,0 +1,50 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftlogs
+
+import (
+	"fmt"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// CommandBatch is the command type of a log entry carrying a batch of writes
+// that are applied atomically. The batch is encoded in the value of the entry
+// with storage.MarshalWriteOps.
+const CommandBatch v1.RaftCommandType = 3
+
+// NewBatchEntry returns a log entry applying all of the given writes at once.
+func NewBatchEntry(ops []storage.WriteOp) (*v1.RaftLogEntry, error) {
+	data, err := storage.MarshalWriteOps(ops)
+	if err != nil {
+		return nil, fmt.Errorf("marshal batch: %w", err)
+	}
+	return &v1.RaftLogEntry{
+		Type:  CommandBatch,
+		Value: data,
+	}, nil
+}
+
+// DecodeBatch decodes the writes carried by a batch log entry.
+func DecodeBatch(logEntry *v1.RaftLogEntry) ([]storage.WriteOp, error) {
+	if logEntry.GetType() != CommandBatch {
+		return nil, fmt.Errorf("not a batch entry: %v", logEntry.GetType())
+	}
+	return storage.UnmarshalWriteOps(logEntry.GetValue())
+}
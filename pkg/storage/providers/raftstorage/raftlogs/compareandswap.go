@@ -0,0 +1,93 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftlogs
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// CommandCompareAndSwap is the command type of a log entry that sets the value
+// of its key only if the key is at the expected version when the entry is
+// applied. The check runs in the FSM, so every storage node reaches the same
+// outcome. The expected version and the value are encoded in the value of the
+// entry.
+const CommandCompareAndSwap v1.RaftCommandType = 4
+
+// NewCompareAndSwapEntry returns a log entry setting the value of the given key
+// if it is at the expected version.
+func NewCompareAndSwapEntry(key, value []byte, expected storage.Version, ttl time.Duration) *v1.RaftLogEntry {
+	data := protowire.AppendTag(nil, 1, protowire.BytesType)
+	data = protowire.AppendString(data, expected.String())
+	data = protowire.AppendTag(data, 2, protowire.BytesType)
+	data = protowire.AppendBytes(data, value)
+	return &v1.RaftLogEntry{
+		Type:  CommandCompareAndSwap,
+		Key:   key,
+		Value: data,
+		Ttl:   durationpb.New(ttl),
+	}
+}
+
+// DecodeCompareAndSwap decodes the value and expected version carried by a
+// compare-and-swap log entry.
+func DecodeCompareAndSwap(logEntry *v1.RaftLogEntry) (value []byte, expected storage.Version, err error) {
+	if logEntry.GetType() != CommandCompareAndSwap {
+		return nil, storage.NoVersion, fmt.Errorf("not a compare-and-swap entry: %v", logEntry.GetType())
+	}
+	data := logEntry.GetValue()
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 || typ != protowire.BytesType {
+			return nil, storage.NoVersion, fmt.Errorf("invalid compare-and-swap entry")
+		}
+		data = data[n:]
+		field, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, storage.NoVersion, fmt.Errorf("invalid compare-and-swap entry")
+		}
+		data = data[n:]
+		switch num {
+		case 1:
+			expected = storage.Version(field)
+		case 2:
+			value = field
+		}
+	}
+	return value, expected, nil
+}
+
+// ResponseError returns the error carried by an apply response, if any. A
+// failed version check is returned as ErrVersionConflict.
+func ResponseError(res *v1.RaftApplyResponse) error {
+	msg := res.GetError()
+	if msg == "" {
+		return nil
+	}
+	if rest, ok := strings.CutPrefix(msg, errors.ErrVersionConflict.Error()); ok {
+		return fmt.Errorf("%w%s", errors.ErrVersionConflict, rest)
+	}
+	return fmt.Errorf("%s", msg)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftlogs
+
+import (
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// AgeTTLs returns the log entry with the TTLs of its writes reduced by the
+// given elapsed time. Writes whose TTL has already passed become deletes.
+// This keeps replayed logs from extending the life of ephemeral keys. The
+// given entry is not modified.
+func AgeTTLs(logEntry *v1.RaftLogEntry, elapsed time.Duration) (*v1.RaftLogEntry, error) {
+	if elapsed <= 0 {
+		return logEntry, nil
+	}
+	switch logEntry.GetType() {
+	case v1.RaftCommandType_PUT:
+		ttl := logEntry.GetTtl().AsDuration()
+		if ttl <= 0 {
+			return logEntry, nil
+		}
+		if ttl <= elapsed {
+			return &v1.RaftLogEntry{
+				Type: v1.RaftCommandType_DELETE,
+				Key:  logEntry.GetKey(),
+			}, nil
+		}
+		return &v1.RaftLogEntry{
+			Type:  v1.RaftCommandType_PUT,
+			Key:   logEntry.GetKey(),
+			Value: logEntry.GetValue(),
+			Ttl:   durationpb.New(ttl - elapsed),
+		}, nil
+	case CommandCompareAndSwap:
+		ttl := logEntry.GetTtl().AsDuration()
+		if ttl <= 0 {
+			return logEntry, nil
+		}
+		// The version check must still run, so an expired value is written
+		// with the shortest TTL instead of being dropped.
+		ttl = max(ttl-elapsed, time.Nanosecond)
+		return &v1.RaftLogEntry{
+			Type:  CommandCompareAndSwap,
+			Key:   logEntry.GetKey(),
+			Value: logEntry.GetValue(),
+			Ttl:   durationpb.New(ttl),
+		}, nil
+	case CommandBatch:
+		ops, err := DecodeBatch(logEntry)
+		if err != nil {
+			return nil, err
+		}
+		aged := false
+		for i, op := range ops {
+			if op.Delete || op.TTL <= 0 {
+				continue
+			}
+			aged = true
+			if op.TTL <= elapsed {
+				ops[i] = storage.WriteOp{Key: op.Key, Delete: true}
+				continue
+			}
+			ops[i].TTL -= elapsed
+		}
+		if !aged {
+			return logEntry, nil
+		}
+		return NewBatchEntry(ops)
+	default:
+		return logEntry, nil
+	}
+}
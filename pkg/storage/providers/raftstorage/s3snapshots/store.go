@@ -0,0 +1,403 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package s3snapshots implements a raft snapshot store that keeps snapshots in
+// an S3 compatible object store, such as AWS S3, GCS, or MinIO.
+package s3snapshots
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// Ensure we satisfy the raft snapshot store interface.
+var _ raft.SnapshotStore = &Store{}
+
+const (
+	// DefaultRetain is the default number of snapshots to keep.
+	DefaultRetain = 3
+	// DefaultTimeout is the default timeout for requests to the object store.
+	DefaultTimeout = time.Minute
+
+	stateObject = "state.bin"
+	metaObject  = "meta.json"
+)
+
+// Options are the options for an S3 snapshot store.
+type Options struct {
+	// Endpoint is the host and optional port of the object store,
+	// e.g. s3.amazonaws.com, storage.googleapis.com or minio:9000.
+	Endpoint string
+	// Bucket is the bucket to store snapshots in. It must already exist.
+	Bucket string
+	// Prefix is the prefix of the objects holding snapshots. Each snapshot
+	// is stored under <prefix>/<snapshot-id>/.
+	Prefix string
+	// Region is the region of the bucket. It is discovered if empty.
+	Region string
+	// AccessKeyID and SecretAccessKey are the credentials to use. If empty,
+	// they are read from the AWS_* or MINIO_* environment variables or
+	// the instance metadata service.
+	AccessKeyID     string
+	SecretAccessKey string
+	// Insecure disables TLS when talking to the object store.
+	Insecure bool
+	// Retain is the number of snapshots to keep. Defaults to DefaultRetain.
+	Retain int
+	// Timeout is the timeout for requests to the object store.
+	// Defaults to DefaultTimeout.
+	Timeout time.Duration
+	// Logger is the logger to use. Defaults to the default logger.
+	Logger *slog.Logger
+}
+
+// Validate validates the options.
+func (o Options) Validate() error {
+	if o.Endpoint == "" {
+		return errors.New("endpoint is required")
+	}
+	if o.Bucket == "" {
+		return errors.New("bucket is required")
+	}
+	if o.Retain < 0 {
+		return errors.New("retain must not be negative")
+	}
+	if o.Timeout < 0 {
+		return errors.New("timeout must not be negative")
+	}
+	if (o.AccessKeyID == "") != (o.SecretAccessKey == "") {
+		return errors.New("access key ID and secret access key must be set together")
+	}
+	return nil
+}
+
+// objectStore is the subset of an object store used by the snapshot store.
+type objectStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, int64, error)
+	List(ctx context.Context, prefix string) ([]string, error)
+	Remove(ctx context.Context, key string) error
+}
+
+// Store is a raft snapshot store backed by an S3 compatible object store.
+type Store struct {
+	objects objectStore
+	opts    Options
+	log     *slog.Logger
+	mu      sync.Mutex
+}
+
+// New returns a new snapshot store for the given options.
+func New(opts Options) (*Store, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid s3 snapshot options: %w", err)
+	}
+	creds := credentials.NewChainCredentials([]credentials.Provider{
+		&credentials.EnvAWS{},
+		&credentials.EnvMinio{},
+		&credentials.IAM{},
+	})
+	if opts.AccessKeyID != "" {
+		creds = credentials.NewStaticV4(opts.AccessKeyID, opts.SecretAccessKey, "")
+	}
+	cli, err := minio.New(opts.Endpoint, &minio.Options{
+		Creds:  creds,
+		Secure: !opts.Insecure,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("create s3 client: %w", err)
+	}
+	return newStore(&minioStore{cli: cli, bucket: opts.Bucket}, opts), nil
+}
+
+func newStore(objects objectStore, opts Options) *Store {
+	if opts.Retain == 0 {
+		opts.Retain = DefaultRetain
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	opts.Prefix = strings.Trim(opts.Prefix, "/")
+	return &Store{
+		objects: objects,
+		opts:    opts,
+		log:     opts.Logger.With("component", "s3-snapshots"),
+	}
+}
+
+// Create starts a new snapshot. The snapshot is buffered in a temporary file
+// and uploaded when the sink is closed.
+func (s *Store) Create(version raft.SnapshotVersion, index, term uint64, configuration raft.Configuration, configurationIndex uint64, trans raft.Transport) (raft.SnapshotSink, error) {
+	if version != 1 {
+		return nil, fmt.Errorf("unsupported snapshot version %d", version)
+	}
+	tmp, err := os.CreateTemp("", "webmesh-snapshot-*")
+	if err != nil {
+		return nil, fmt.Errorf("create snapshot buffer: %w", err)
+	}
+	now := time.Now()
+	meta := &raft.SnapshotMeta{
+		Version:            version,
+		ID:                 fmt.Sprintf("%d-%d-%d", term, index, now.UnixMilli()),
+		Index:              index,
+		Term:               term,
+		Configuration:      configuration,
+		ConfigurationIndex: configurationIndex,
+	}
+	s.log.Debug("Creating snapshot", slog.String("id", meta.ID))
+	return &sink{store: s, meta: meta, buf: tmp}, nil
+}
+
+// List returns the available snapshots, newest first.
+func (s *Store) List() ([]*raft.SnapshotMeta, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+	metas, err := s.list(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(metas) > s.opts.Retain {
+		metas = metas[:s.opts.Retain]
+	}
+	return metas, nil
+}
+
+// Open opens the snapshot with the given ID.
+func (s *Store) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+	meta, err := s.readMeta(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	// The state may be larger than can be read within the timeout, so it
+	// is read without one.
+	state, size, err := s.objects.Get(context.Background(), s.key(id, stateObject))
+	if err != nil {
+		return nil, nil, fmt.Errorf("open snapshot %s: %w", id, err)
+	}
+	if size != meta.Size {
+		state.Close()
+		return nil, nil, fmt.Errorf("snapshot %s is %d bytes, expected %d", id, size, meta.Size)
+	}
+	return meta, state, nil
+}
+
+func (s *Store) list(ctx context.Context) ([]*raft.SnapshotMeta, error) {
+	keys, err := s.objects.List(ctx, s.key())
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	var metas []*raft.SnapshotMeta
+	for _, key := range keys {
+		if path.Base(key) != metaObject {
+			continue
+		}
+		id := path.Base(path.Dir(key))
+		meta, err := s.readMeta(ctx, id)
+		if err != nil {
+			s.log.Warn("Skipping unreadable snapshot", slog.String("id", id), slog.String("error", err.Error()))
+			continue
+		}
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		if metas[i].Term != metas[j].Term {
+			return metas[i].Term > metas[j].Term
+		}
+		if metas[i].Index != metas[j].Index {
+			return metas[i].Index > metas[j].Index
+		}
+		return metas[i].ID > metas[j].ID
+	})
+	return metas, nil
+}
+
+func (s *Store) readMeta(ctx context.Context, id string) (*raft.SnapshotMeta, error) {
+	rc, _, err := s.objects.Get(ctx, s.key(id, metaObject))
+	if err != nil {
+		return nil, fmt.Errorf("get snapshot %s metadata: %w", id, err)
+	}
+	defer rc.Close()
+	var meta raft.SnapshotMeta
+	if err := json.NewDecoder(rc).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("decode snapshot %s metadata: %w", id, err)
+	}
+	return &meta, nil
+}
+
+// upload stores a completed snapshot and removes the ones past retention.
+// The metadata is written last, so a partially uploaded snapshot is never
+// listed.
+func (s *Store) upload(meta *raft.SnapshotMeta, state *os.File) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := state.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("rewind snapshot buffer: %w", err)
+	}
+	if err := s.objects.Put(context.Background(), s.key(meta.ID, stateObject), state, meta.Size); err != nil {
+		return fmt.Errorf("upload snapshot state: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.opts.Timeout)
+	defer cancel()
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("marshal snapshot metadata: %w", err)
+	}
+	if err := s.objects.Put(ctx, s.key(meta.ID, metaObject), bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("upload snapshot metadata: %w", err)
+	}
+	s.log.Info("Uploaded snapshot", slog.String("id", meta.ID), slog.Int64("size", meta.Size))
+	s.reap(ctx)
+	return nil
+}
+
+// reap removes the snapshots past retention. Failures are only logged, they
+// are retried after the next snapshot.
+func (s *Store) reap(ctx context.Context) {
+	metas, err := s.list(ctx)
+	if err != nil {
+		s.log.Warn("Failed to list snapshots for removal", slog.String("error", err.Error()))
+		return
+	}
+	if len(metas) <= s.opts.Retain {
+		return
+	}
+	for _, meta := range metas[s.opts.Retain:] {
+		s.log.Debug("Removing old snapshot", slog.String("id", meta.ID))
+		// Remove the metadata first so the snapshot is no longer listed.
+		for _, name := range []string{metaObject, stateObject} {
+			if err := s.objects.Remove(ctx, s.key(meta.ID, name)); err != nil {
+				s.log.Warn("Failed to remove old snapshot", slog.String("id", meta.ID), slog.String("error", err.Error()))
+				break
+			}
+		}
+	}
+}
+
+func (s *Store) key(parts ...string) string {
+	key := path.Join(append([]string{s.opts.Prefix}, parts...)...)
+	if len(parts) == 0 && key != "" {
+		key += "/"
+	}
+	return strings.TrimPrefix(key, "/")
+}
+
+// sink buffers a snapshot until it is closed.
+type sink struct {
+	store  *Store
+	meta   *raft.SnapshotMeta
+	buf    *os.File
+	closed bool
+}
+
+// ID returns the ID of the snapshot.
+func (s *sink) ID() string {
+	return s.meta.ID
+}
+
+// Write writes snapshot data to the buffer.
+func (s *sink) Write(p []byte) (int, error) {
+	n, err := s.buf.Write(p)
+	s.meta.Size += int64(n)
+	return n, err
+}
+
+// Close uploads the snapshot.
+func (s *sink) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	defer s.discard()
+	return s.store.upload(s.meta, s.buf)
+}
+
+// Cancel discards the snapshot.
+func (s *sink) Cancel() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	s.discard()
+	return nil
+}
+
+func (s *sink) discard() {
+	s.buf.Close()
+	os.Remove(s.buf.Name())
+}
+
+// minioStore is an objectStore backed by a minio client.
+type minioStore struct {
+	cli    *minio.Client
+	bucket string
+}
+
+func (m *minioStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	_, err := m.cli.PutObject(ctx, m.bucket, key, r, size, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+	})
+	return err
+}
+
+func (m *minioStore) Get(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	obj, err := m.cli.GetObject(ctx, m.bucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := obj.Stat()
+	if err != nil {
+		obj.Close()
+		return nil, 0, err
+	}
+	return obj, info.Size, nil
+}
+
+func (m *minioStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for obj := range m.cli.ListObjects(ctx, m.bucket, minio.ListObjectsOptions{Prefix: prefix, Recursive: true}) {
+		if obj.Err != nil {
+			return nil, obj.Err
+		}
+		keys = append(keys, obj.Key)
+	}
+	return keys, nil
+}
+
+func (m *minioStore) Remove(ctx context.Context, key string) error {
+	return m.cli.RemoveObject(ctx, m.bucket, key, minio.RemoveObjectOptions{})
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package s3snapshots
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	objects := newMemStore()
+	store := newStore(objects, Options{Prefix: "/cluster/", Retain: 2})
+
+	if metas, err := store.List(); err != nil || len(metas) != 0 {
+		t.Fatalf("expected no snapshots, got %v, %v", metas, err)
+	}
+
+	var ids []string
+	for i := 1; i <= 3; i++ {
+		sink, err := store.Create(1, uint64(i*10), 1, raft.Configuration{}, 1, nil)
+		if err != nil {
+			t.Fatalf("create snapshot: %v", err)
+		}
+		if _, err := fmt.Fprintf(sink, "snapshot-%d", i); err != nil {
+			t.Fatalf("write snapshot: %v", err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatalf("close snapshot: %v", err)
+		}
+		ids = append(ids, sink.ID())
+	}
+
+	// A canceled snapshot is never uploaded.
+	sink, err := store.Create(1, 40, 1, raft.Configuration{}, 1, nil)
+	if err != nil {
+		t.Fatalf("create snapshot: %v", err)
+	}
+	if _, err := sink.Write([]byte("canceled")); err != nil {
+		t.Fatalf("write snapshot: %v", err)
+	}
+	if err := sink.Cancel(); err != nil {
+		t.Fatalf("cancel snapshot: %v", err)
+	}
+
+	metas, err := store.List()
+	if err != nil {
+		t.Fatalf("list snapshots: %v", err)
+	}
+	if len(metas) != 2 || metas[0].ID != ids[2] || metas[1].ID != ids[1] {
+		t.Fatalf("expected the two newest snapshots %v, got %v", ids[1:], metas)
+	}
+	for _, key := range objects.keys() {
+		if !strings.HasPrefix(key, "cluster/") || strings.Contains(key, ids[0]) {
+			t.Errorf("unexpected object %q", key)
+		}
+	}
+
+	meta, rc, err := store.Open(ids[2])
+	if err != nil {
+		t.Fatalf("open snapshot: %v", err)
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+	if string(data) != "snapshot-3" || meta.Index != 30 || meta.Size != int64(len(data)) {
+		t.Fatalf("unexpected snapshot %+v with data %q", meta, data)
+	}
+
+	if _, _, err := store.Open(ids[0]); err == nil {
+		t.Fatal("expected removed snapshot to fail to open")
+	}
+}
+
+func TestStoreSkipsPartialUploads(t *testing.T) {
+	t.Parallel()
+	objects := newMemStore()
+	store := newStore(objects, Options{})
+	// A snapshot whose metadata was never written is not listed.
+	_ = objects.Put(context.Background(), "1-1-1/state.bin", strings.NewReader("partial"), 7)
+	metas, err := store.List()
+	if err != nil {
+		t.Fatalf("list snapshots: %v", err)
+	}
+	if len(metas) != 0 {
+		t.Fatalf("expected no snapshots, got %v", metas)
+	}
+}
+
+// memStore is an in-memory objectStore.
+type memStore struct {
+	objects map[string][]byte
+	mu      sync.Mutex
+}
+
+func newMemStore() *memStore {
+	return &memStore{objects: make(map[string][]byte)}
+}
+
+func (m *memStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return fmt.Errorf("read %d bytes, expected %d", len(data), size)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.objects[key] = data
+	return nil
+}
+
+func (m *memStore) Get(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.objects[key]
+	if !ok {
+		return nil, 0, fmt.Errorf("object %q not found", key)
+	}
+	return io.NopCloser(bytes.NewReader(data)), int64(len(data)), nil
+}
+
+func (m *memStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var out []string
+	for _, key := range m.keys() {
+		if strings.HasPrefix(key, prefix) {
+			out = append(out, key)
+		}
+	}
+	return out, nil
+}
+
+func (m *memStore) Remove(ctx context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.objects, key)
+	return nil
+}
+
+func (m *memStore) keys() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.objects))
+	for key := range m.objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
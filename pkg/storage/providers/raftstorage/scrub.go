@@ -0,0 +1,226 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	storageerrors "github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+// ScrubReport is the result of verifying the local log store and snapshots.
+type ScrubReport struct {
+	// Time is when the scrub completed.
+	Time time.Time
+	// CheckedLogs is the number of log entries verified.
+	CheckedLogs uint64
+	// CheckedSnapshots is the number of snapshots verified.
+	CheckedSnapshots int
+	// CorruptLogs are the indexes of log entries that failed verification.
+	CorruptLogs []uint64
+	// CorruptSnapshots are the IDs of snapshots that failed verification.
+	CorruptSnapshots []string
+	// StorageError is set if the underlying storage or stable store failed verification.
+	StorageError error
+}
+
+// Healthy returns true if no corruption was found.
+func (s *ScrubReport) Healthy() bool {
+	return len(s.CorruptLogs) == 0 && len(s.CorruptSnapshots) == 0 && s.StorageError == nil
+}
+
+// CorruptionCallback is a callback invoked when a scrub finds corrupt local data.
+type CorruptionCallback func(ctx context.Context, report ScrubReport)
+
+// OnCorruption registers a callback for when a scrub finds corrupt local data.
+func (r *Provider) OnCorruption(cb CorruptionCallback) {
+	r.cbmu.Lock()
+	defer r.cbmu.Unlock()
+	r.corruptionCbs = append(r.corruptionCbs, cb)
+}
+
+// Scrub verifies the integrity of the local log store, stable store, and
+// snapshots. Corrupt snapshots are moved aside. If any corruption is found,
+// the node stops serving reads from its local storage and raises an alarm
+// through the corruption callbacks and the raft_local_data_corrupt metric.
+// A leader with corrupt data also steps down. The local data is not repaired
+// in place, since that would diverge from the applied index of the log. The
+// node is repaired by removing its data directory and restarting it, so the
+// leader replicates its state through raft.
+func (r *Provider) Scrub(ctx context.Context) (ScrubReport, error) {
+	r.mu.RLock()
+	if !r.started.Load() {
+		r.mu.RUnlock()
+		return ScrubReport{}, storageerrors.ErrClosed
+	}
+	r.mu.RUnlock()
+	return r.scrub(ctx)
+}
+
+func (r *Provider) scrub(ctx context.Context) (report ScrubReport, err error) {
+	defer func() { report.Time = time.Now().UTC() }()
+	stores := []any{r.localStorage}
+	if r.logStore != r.localStorage {
+		stores = append(stores, r.logStore)
+	}
+	for _, st := range stores {
+		if v, ok := st.(badgerdb.Verifier); ok && report.StorageError == nil {
+			if err := v.VerifyChecksum(); err != nil {
+				report.StorageError = err
+			}
+		}
+	}
+	for _, key := range []string{"CurrentTerm", "LastVoteTerm"} {
+		if _, err := r.logStore.GetUint64([]byte(key)); err != nil && report.StorageError == nil {
+			report.StorageError = fmt.Errorf("read stable store key %s: %w", key, err)
+		}
+	}
+	if err := r.scrubLogs(ctx, &report); err != nil {
+		return report, err
+	}
+	corrupt, err := checkSnapshots(r.snapshots, r.sealer)
+	if err != nil {
+		return report, err
+	}
+	list, _ := r.snapshots.List()
+	report.CheckedSnapshots = len(list)
+	for id := range corrupt {
+		report.CorruptSnapshots = append(report.CorruptSnapshots, id)
+		if !r.Options.InMemory {
+			if err := moveSnapshotAside(r.Options.DataDir, id); err != nil {
+				return report, err
+			}
+		}
+	}
+	if report.Healthy() {
+		r.log.Debug("Scrub found no corruption",
+			slog.Uint64("checked-logs", report.CheckedLogs),
+			slog.Int("checked-snapshots", report.CheckedSnapshots),
+		)
+		return report, nil
+	}
+	attrs := []any{
+		slog.Any("corrupt-logs", report.CorruptLogs),
+		slog.Any("corrupt-snapshots", report.CorruptSnapshots),
+	}
+	if report.StorageError != nil {
+		attrs = append(attrs, slog.String("storage-error", report.StorageError.Error()))
+	}
+	r.log.Error("Scrub found corrupt local data", attrs...)
+	if r.raft.State() == raft.Leader {
+		r.log.Warn("Stepping down from leadership with corrupt local data")
+		if err := r.transferToHealthyVoter(ctx); err != nil {
+			r.log.Error("Failed to transfer leadership", slog.String("error", err.Error()))
+		}
+	}
+	if !r.corrupted.Swap(true) {
+		LocalDataCorrupt.WithLabelValues(r.Options.NodeID.String()).Set(1)
+		r.log.Error("Refusing reads from corrupt local data, remove the data directory and restart the node to repair it from the leader")
+	}
+	r.cbmu.Lock()
+	cbs := r.corruptionCbs
+	r.cbmu.Unlock()
+	for _, cb := range cbs {
+		cb(ctx, report)
+	}
+	return report, nil
+}
+
+// scrubLogs verifies every entry in the log store.
+func (r *Provider) scrubLogs(ctx context.Context, report *ScrubReport) error {
+	st := r.logStore
+	first, err := st.FirstIndex()
+	if err != nil {
+		report.StorageError = fmt.Errorf("read first index: %w", err)
+		return nil
+	}
+	last, err := st.LastIndex()
+	if err != nil {
+		report.StorageError = fmt.Errorf("read last index: %w", err)
+		return nil
+	}
+	if first == 0 {
+		return nil
+	}
+	for index := first; index <= last; index++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var entry raft.Log
+		err := st.GetLog(index, &entry)
+		if errors.Is(err, raft.ErrLogNotFound) {
+			// The log may have been compacted since we started.
+			current, ferr := st.FirstIndex()
+			if ferr == nil && current > index {
+				index = current - 1
+				continue
+			}
+		}
+		if err == nil && entry.Type == raft.LogCommand {
+			_, err = r.codec.Unmarshal(entry.Data)
+		}
+		report.CheckedLogs++
+		if err != nil {
+			r.log.Warn("Log entry failed verification", slog.Uint64("index", index), slog.String("error", err.Error()))
+			report.CorruptLogs = append(report.CorruptLogs, index)
+		}
+	}
+	return nil
+}
+
+// Corrupted returns true if a scrub found corrupt local data. Reads from the
+// local storage are refused until the node is repaired.
+func (r *Provider) Corrupted() bool {
+	return r.corrupted.Load()
+}
+
+func (r *Provider) runScrubber() (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(r.Options.ScrubInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closeCh:
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithCancel(context.WithLogger(context.Background(), r.log))
+				go func() {
+					select {
+					case <-closeCh:
+						cancel()
+					case <-ctx.Done():
+					}
+				}()
+				if _, err := r.scrub(ctx); err != nil && ctx.Err() == nil {
+					r.log.Error("Failed to scrub local data", slog.String("error", err.Error()))
+				}
+				cancel()
+			}
+		}
+	}()
+	return
+}
@@ -0,0 +1,119 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+func TestScrub(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	transport, err := tcp.NewRaftTransport(nil, tcp.RaftTransportOptions{
+		Addr:    "[::]:0",
+		MaxPool: 10,
+		Timeout: time.Second,
+	})
+	if err != nil {
+		t.Fatalf("failed to create raft transport: %v", err)
+	}
+	opts := newTestOptions(transport)
+	opts.InMemory = false
+	opts.DataDir = t.TempDir()
+	p := NewProvider(opts)
+	if err := p.Start(ctx); err != nil {
+		t.Fatalf("start provider: %v", err)
+	}
+	defer p.Close()
+	if err := p.Bootstrap(ctx); err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	for _, key := range []string{"/registry/a", "/registry/b", "/registry/c"} {
+		if err := p.MeshStorage().PutValue(ctx, []byte(key), []byte("value"), 0); err != nil {
+			t.Fatalf("put value: %v", err)
+		}
+	}
+	if err := p.raft.Snapshot().Error(); err != nil {
+		t.Fatalf("snapshot: %v", err)
+	}
+	var alerts int
+	p.OnCorruption(func(ctx context.Context, report ScrubReport) {
+		alerts++
+	})
+
+	report, err := p.Scrub(ctx)
+	if err != nil {
+		t.Fatalf("scrub: %v", err)
+	}
+	if p.Corrupted() {
+		t.Fatal("expected provider to not report corrupt data")
+	}
+	if !report.Healthy() {
+		t.Fatalf("expected healthy report, got %+v", report)
+	}
+	if report.CheckedLogs == 0 || report.CheckedSnapshots != 1 {
+		t.Fatalf("expected logs and one snapshot to be checked, got %+v", report)
+	}
+
+	// Corrupt the snapshot on disk.
+	list, err := p.snapshots.List()
+	if err != nil || len(list) != 1 {
+		t.Fatalf("list snapshots: %v", err)
+	}
+	state := filepath.Join(opts.DataDir, "snapshots", list[0].ID, "state.bin")
+	data, err := os.ReadFile(state)
+	if err != nil {
+		t.Fatalf("read snapshot: %v", err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(state, data, 0644); err != nil {
+		t.Fatalf("write snapshot: %v", err)
+	}
+
+	report, err = p.Scrub(ctx)
+	if err != nil {
+		t.Fatalf("scrub: %v", err)
+	}
+	if report.Healthy() || len(report.CorruptSnapshots) != 1 || report.CorruptSnapshots[0] != list[0].ID {
+		t.Fatalf("expected corrupt snapshot %s to be reported, got %+v", list[0].ID, report)
+	}
+	if alerts != 1 {
+		t.Fatalf("expected one corruption alert, got %d", alerts)
+	}
+	// Reads are refused until the node is repaired.
+	if !p.Corrupted() {
+		t.Fatal("expected provider to report corrupt data")
+	}
+	if _, err := p.MeshStorage().GetValue(ctx, []byte("/registry/a")); !errors.Is(err, errors.ErrCorrupted) {
+		t.Fatalf("expected reads to be refused, got: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(opts.DataDir, CorruptSnapshotsDir, list[0].ID)); err != nil {
+		t.Fatalf("expected corrupt snapshot to be moved aside: %v", err)
+	}
+	list, err = p.snapshots.List()
+	if err != nil || len(list) != 0 {
+		t.Fatalf("expected no snapshots to remain, got %d (%v)", len(list), err)
+	}
+}
@@ -0,0 +1,286 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshots
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/hashicorp/raft"
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+// ErrMissingBase is returned when an incremental snapshot references a base
+// snapshot that is no longer in the snapshot store.
+var ErrMissingBase = errors.New("incremental snapshot base not found")
+
+// ErrIncrementalSnapshot is returned when an incremental snapshot is restored
+// without first being resolved against its base by a ChainStore.
+var ErrIncrementalSnapshot = errors.New("incremental snapshot must be resolved by a chain store")
+
+// deltaMagic prefixes incremental snapshots. It is followed by the uvarint
+// length of the base snapshot ID, the base snapshot ID, and the encoded
+// write ops that turn the base into this snapshot.
+var deltaMagic = []byte("WMDELTA\x01")
+
+// maxChainDepth bounds how many incremental snapshots are followed when
+// resolving a chain, guarding against cycles in a corrupt store.
+const maxChainDepth = 1024
+
+// IsIncremental returns true if the persisted snapshot data is an
+// incremental snapshot.
+func IsIncremental(data []byte) bool {
+	return bytes.HasPrefix(data, deltaMagic)
+}
+
+// encodeDelta encodes an incremental snapshot on top of the given base.
+func encodeDelta(base string, ops []storage.WriteOp) (*bytes.Buffer, error) {
+	data, err := storage.MarshalWriteOps(ops)
+	if err != nil {
+		return nil, fmt.Errorf("encode snapshot delta: %w", err)
+	}
+	payload, err := Encode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(len(deltaMagic) + binary.MaxVarintLen64 + len(base) + payload.Len())
+	buf.Write(deltaMagic)
+	buf.Write(binary.AppendUvarint(nil, uint64(len(base))))
+	buf.WriteString(base)
+	buf.Write(payload.Bytes())
+	return &buf, nil
+}
+
+// splitDelta returns the base snapshot ID and the encoded payload of an
+// incremental snapshot.
+func splitDelta(data []byte) (string, []byte, error) {
+	data = data[len(deltaMagic):]
+	n, sz := binary.Uvarint(data)
+	if sz <= 0 || n > uint64(len(data)-sz) {
+		return "", nil, fmt.Errorf("%w: truncated incremental snapshot header", ErrChecksumMismatch)
+	}
+	data = data[sz:]
+	return string(data[:n]), data[n:], nil
+}
+
+// decodeDelta decodes the base snapshot ID and write ops of an incremental snapshot.
+func decodeDelta(data []byte) (string, []storage.WriteOp, error) {
+	base, payload, err := splitDelta(data)
+	if err != nil {
+		return "", nil, err
+	}
+	raw, err := decode(payload)
+	if err != nil {
+		return "", nil, err
+	}
+	ops, err := storage.UnmarshalWriteOps(raw)
+	if err != nil {
+		return "", nil, err
+	}
+	return base, ops, nil
+}
+
+// itemDigest identifies the contents of a snapshot item. The expiry is
+// rounded to the second so the same key produces the same digest across
+// snapshots even though its remaining TTL shrinks.
+type itemDigest [sha256.Size]byte
+
+func digestItem(now time.Time, item *v1.RaftDataItem) itemDigest {
+	var expires int64
+	if ttl := item.GetTtl().AsDuration(); ttl > 0 {
+		expires = now.Add(ttl).Round(time.Second).Unix()
+	}
+	h := sha256.New()
+	h.Write(item.GetValue())
+	h.Write(binary.BigEndian.AppendUint64(nil, uint64(expires)))
+	var d itemDigest
+	h.Sum(d[:0])
+	return d
+}
+
+// digestSnapshot returns the digests of every item in a storage snapshot.
+func digestSnapshot(snap *v1.RaftSnapshot) map[string]itemDigest {
+	now := time.Now()
+	digests := make(map[string]itemDigest, len(snap.GetKv()))
+	for _, item := range snap.GetKv() {
+		digests[string(item.GetKey())] = digestItem(now, item)
+	}
+	return digests
+}
+
+// diffSnapshot returns the write ops that turn a snapshot with the base
+// digests into the given snapshot.
+func diffSnapshot(base map[string]itemDigest, snap *v1.RaftSnapshot, digests map[string]itemDigest) []storage.WriteOp {
+	var ops []storage.WriteOp
+	for _, item := range snap.GetKv() {
+		key := string(item.GetKey())
+		if d, ok := base[key]; ok && d == digests[key] {
+			continue
+		}
+		ops = append(ops, storage.WriteOp{
+			Key:   item.GetKey(),
+			Value: item.GetValue(),
+			TTL:   item.GetTtl().AsDuration(),
+		})
+	}
+	var deleted []string
+	for key := range base {
+		if _, ok := digests[key]; !ok {
+			deleted = append(deleted, key)
+		}
+	}
+	slices.Sort(deleted)
+	for _, key := range deleted {
+		ops = append(ops, storage.WriteOp{Key: []byte(key), Delete: true})
+	}
+	return ops
+}
+
+// ChainStore wraps a raft.SnapshotStore that may hold incremental snapshots.
+// Open resolves the chain of an incremental snapshot back to its full base and
+// returns the flattened result, so raft restores and snapshots sent to
+// followers always carry the complete state. The wrapped store must retain
+// enough snapshots to cover the longest chain. When the snapshots are sealed,
+// the store must be given the same sealer and reseals flattened snapshots.
+type ChainStore struct {
+	raft.SnapshotStore
+	sealer *Sealer
+}
+
+// NewChainStore returns a ChainStore wrapping the given store. The sealer
+// may be nil if snapshots are not sealed.
+func NewChainStore(store raft.SnapshotStore, sealer *Sealer) *ChainStore {
+	return &ChainStore{SnapshotStore: store, sealer: sealer}
+}
+
+// Open opens the snapshot with the given ID, flattening it if it is incremental.
+func (c *ChainStore) Open(id string) (*raft.SnapshotMeta, io.ReadCloser, error) {
+	meta, sealed, err := c.read(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := unseal(c.sealer, sealed)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open snapshot %s: %w", id, err)
+	}
+	if !IsIncremental(data) {
+		return meta, io.NopCloser(bytes.NewReader(sealed)), nil
+	}
+	data, err = c.flatten(id, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.sealer != nil {
+		data, err = c.sealer.Seal(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("seal flattened snapshot %s: %w", id, err)
+		}
+	}
+	flat := *meta
+	flat.Size = int64(len(data))
+	return &flat, io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *ChainStore) read(id string) (*raft.SnapshotMeta, []byte, error) {
+	meta, rc, err := c.SnapshotStore.Open(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(rc)
+	if err != nil {
+		return nil, nil, fmt.Errorf("read snapshot %s: %w", id, err)
+	}
+	return meta, data, nil
+}
+
+// flatten walks an incremental snapshot back to its full base and applies
+// each delta in order, returning an encoded full snapshot.
+func (c *ChainStore) flatten(id string, data []byte) ([]byte, error) {
+	var deltas [][]storage.WriteOp
+	for IsIncremental(data) {
+		if len(deltas) >= maxChainDepth {
+			return nil, fmt.Errorf("snapshot %s: incremental chain longer than %d", id, maxChainDepth)
+		}
+		base, ops, err := decodeDelta(data)
+		if err != nil {
+			return nil, fmt.Errorf("decode incremental snapshot %s: %w", id, err)
+		}
+		deltas = append(deltas, ops)
+		_, data, err = c.read(base)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrMissingBase, base, err)
+		}
+		data, err = unseal(c.sealer, data)
+		if err != nil {
+			return nil, fmt.Errorf("open snapshot %s: %w", base, err)
+		}
+		id = base
+	}
+	raw, err := decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("decode snapshot %s: %w", id, err)
+	}
+	var snap v1.RaftSnapshot
+	if err := proto.Unmarshal(raw, &snap); err != nil {
+		return nil, fmt.Errorf("unmarshal snapshot %s: %w", id, err)
+	}
+	items := make(map[string]*v1.RaftDataItem, len(snap.Kv))
+	for _, item := range snap.Kv {
+		items[string(item.Key)] = item
+	}
+	for i := len(deltas) - 1; i >= 0; i-- {
+		for _, op := range deltas[i] {
+			if op.Delete {
+				delete(items, string(op.Key))
+				continue
+			}
+			items[string(op.Key)] = &v1.RaftDataItem{
+				Key:   op.Key,
+				Value: op.Value,
+				Ttl:   durationpb.New(op.TTL),
+			}
+		}
+	}
+	snap.Kv = make([]*v1.RaftDataItem, 0, len(items))
+	for _, item := range items {
+		snap.Kv = append(snap.Kv, item)
+	}
+	slices.SortFunc(snap.Kv, func(a, b *v1.RaftDataItem) int {
+		return bytes.Compare(a.Key, b.Key)
+	})
+	raw, err = proto.Marshal(&snap)
+	if err != nil {
+		return nil, fmt.Errorf("marshal flattened snapshot: %w", err)
+	}
+	buf, err := Encode(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,156 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snapshots
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+)
+
+// ErrNotSealed is returned when a snapshot is not sealed but a sealer is configured.
+var ErrNotSealed = errors.New("snapshot is not sealed")
+
+// ErrSealed is returned when a snapshot is sealed but no sealer is configured.
+var ErrSealed = errors.New("snapshot is sealed and no encryption key is configured")
+
+// ErrBadSeal is returned when a sealed snapshot fails decryption or its
+// signature does not match.
+var ErrBadSeal = errors.New("snapshot seal is invalid")
+
+// sealMagic prefixes sealed snapshots. It is followed by the raw public key
+// of the signing node, the signature, the nonce, and the encrypted snapshot.
+// The signature covers everything but itself.
+var sealMagic = []byte("WMSEAL\x00\x01")
+
+// sealInfo is the HKDF info string used when deriving the encryption key.
+const sealInfo = "webmesh-snapshot-seal-v1"
+
+// Sealer encrypts snapshots with a key shared by the cluster and signs them
+// with the key of the local node. Any encoded snapshot, full or incremental,
+// can be sealed.
+type Sealer struct {
+	aead   cipher.AEAD
+	signer crypto.PrivateKey
+}
+
+// NewSealer returns a sealer that encrypts with a key derived from the given
+// operator-provided key and signs with the given node key.
+func NewSealer(key []byte, signer crypto.PrivateKey) (*Sealer, error) {
+	if len(key) == 0 {
+		return nil, fmt.Errorf("snapshot encryption key must not be empty")
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("snapshot signing key must not be nil")
+	}
+	derived := make([]byte, 32)
+	_, err := io.ReadFull(hkdf.New(sha256.New, key, nil, []byte(sealInfo)), derived)
+	if err != nil {
+		return nil, fmt.Errorf("derive snapshot encryption key: %w", err)
+	}
+	block, err := aes.NewCipher(derived)
+	if err != nil {
+		return nil, fmt.Errorf("create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("create gcm: %w", err)
+	}
+	return &Sealer{aead: aead, signer: signer}, nil
+}
+
+// IsSealed returns true if the persisted snapshot data is sealed.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealMagic)
+}
+
+// Seal encrypts and signs the given snapshot data.
+func (s *Sealer) Seal(data []byte) ([]byte, error) {
+	pub := s.signer.PublicKey().Bytes()
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	headerSize := len(sealMagic) + len(pub) + ed25519.SignatureSize
+	out := make([]byte, 0, headerSize+len(nonce)+len(data)+s.aead.Overhead())
+	out = append(out, sealMagic...)
+	out = append(out, pub...)
+	out = append(out, make([]byte, ed25519.SignatureSize)...)
+	out = append(out, nonce...)
+	out = s.aead.Seal(out, nonce, data, pub)
+	sig := ed25519.Sign(s.signer.AsNative(), signedData(out))
+	copy(out[len(sealMagic)+len(pub):], sig)
+	return out, nil
+}
+
+// Open verifies the signature of sealed snapshot data and decrypts it. It
+// returns the decrypted data and the public key of the node that sealed it.
+func (s *Sealer) Open(data []byte) ([]byte, crypto.PublicKey, error) {
+	if !IsSealed(data) {
+		return nil, nil, ErrNotSealed
+	}
+	headerSize := len(sealMagic) + ed25519.PublicKeySize + ed25519.SignatureSize
+	if len(data) < headerSize+s.aead.NonceSize() {
+		return nil, nil, fmt.Errorf("%w: truncated header", ErrBadSeal)
+	}
+	pub := data[len(sealMagic) : len(sealMagic)+ed25519.PublicKeySize]
+	sig := data[len(sealMagic)+ed25519.PublicKeySize : headerSize]
+	if !ed25519.Verify(ed25519.PublicKey(pub), signedData(data), sig) {
+		return nil, nil, fmt.Errorf("%w: signature mismatch", ErrBadSeal)
+	}
+	signer, err := crypto.ParsePublicKey(pub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrBadSeal, err)
+	}
+	nonce := data[headerSize : headerSize+s.aead.NonceSize()]
+	out, err := s.aead.Open(nil, nonce, data[headerSize+len(nonce):], pub)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrBadSeal, err)
+	}
+	return out, signer, nil
+}
+
+// signedData returns the parts of sealed data covered by the signature.
+func signedData(data []byte) []byte {
+	sigStart := len(sealMagic) + ed25519.PublicKeySize
+	signed := make([]byte, 0, len(data)-ed25519.SignatureSize)
+	signed = append(signed, data[:sigStart]...)
+	return append(signed, data[sigStart+ed25519.SignatureSize:]...)
+}
+
+// unseal opens the data with the given sealer. Sealed data is rejected
+// without a sealer and unsealed data is rejected with one.
+func unseal(s *Sealer, data []byte) ([]byte, error) {
+	if s == nil {
+		if IsSealed(data) {
+			return nil, ErrSealed
+		}
+		return data, nil
+	}
+	out, _, err := s.Open(data)
+	return out, err
+}
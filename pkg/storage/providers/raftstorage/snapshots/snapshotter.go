@@ -20,35 +20,194 @@ package snapshots
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/hashicorp/raft"
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
 
 	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/storage"
 )
 
+// ErrChecksumMismatch is returned when snapshot data does not match its checksum.
+var ErrChecksumMismatch = errors.New("snapshot checksum mismatch")
+
+// checksumMagic prefixes snapshots that carry a SHA-256 checksum of their
+// compressed payload. Snapshots taken before checksums were introduced are
+// plain gzip streams and are verified with the gzip CRC instead.
+var checksumMagic = []byte("WMSNAP\x00\x01")
+
+// checksumHeaderSize is the size of the magic and checksum preceding the payload.
+const checksumHeaderSize = 8 + sha256.Size
+
+// Encode compresses raw storage snapshot data and prefixes it with a checksum
+// in the format expected by Restore.
+func Encode(data io.Reader) (*bytes.Buffer, error) {
+	var payload bytes.Buffer
+	gzw := gzip.NewWriter(&payload)
+	if _, err := io.Copy(gzw, data); err != nil {
+		return nil, fmt.Errorf("compress snapshot data: %w", err)
+	}
+	if err := gzw.Close(); err != nil {
+		return nil, fmt.Errorf("close gzip writer: %w", err)
+	}
+	sum := sha256.Sum256(payload.Bytes())
+	var buf bytes.Buffer
+	buf.Grow(checksumHeaderSize + payload.Len())
+	buf.Write(checksumMagic)
+	buf.Write(sum[:])
+	buf.Write(payload.Bytes())
+	return &buf, nil
+}
+
+// Verify reads a persisted snapshot and verifies its integrity. Incremental
+// snapshots are verified on their own, without resolving their base. Sealed
+// snapshots can only be verified with a sealer.
+func Verify(r io.Reader, sealer *Sealer) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+	data, err = unseal(sealer, data)
+	if err != nil {
+		return err
+	}
+	if IsIncremental(data) {
+		_, data, err = splitDelta(data)
+		if err != nil {
+			return err
+		}
+	}
+	// Reading to EOF verifies the gzip CRC.
+	_, err = decode(data)
+	return err
+}
+
+// decode verifies and decompresses encoded snapshot data.
+func decode(data []byte) ([]byte, error) {
+	payload, err := verifyChecksum(data)
+	if err != nil {
+		return nil, err
+	}
+	gzr, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("gzip reader: %w", err)
+	}
+	defer gzr.Close()
+	raw, err := io.ReadAll(gzr)
+	if err != nil {
+		return nil, fmt.Errorf("decompress snapshot: %w", err)
+	}
+	return raw, nil
+}
+
+// verifyChecksum verifies the checksum header if present and returns the payload.
+func verifyChecksum(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, checksumMagic) {
+		return data, nil
+	}
+	if len(data) < checksumHeaderSize {
+		return nil, fmt.Errorf("%w: truncated header", ErrChecksumMismatch)
+	}
+	payload := data[checksumHeaderSize:]
+	sum := sha256.Sum256(payload)
+	if !bytes.Equal(sum[:], data[len(checksumMagic):checksumHeaderSize]) {
+		return nil, ErrChecksumMismatch
+	}
+	return payload, nil
+}
+
 // Snapshotter is an interface for taking and restoring snapshots.
 type Snapshotter interface {
 	// Snapshot returns a new snapshot.
 	Snapshot(ctx context.Context) (raft.FSMSnapshot, error)
-	// Restore restores a snapshot.
-	Restore(ctx context.Context, r io.ReadCloser) error
+	// Restore restores a snapshot and returns a description of what was restored.
+	Restore(ctx context.Context, r io.ReadCloser) (*Restored, error)
+}
+
+// Restored describes a snapshot that was restored to the local storage.
+type Restored struct {
+	// Size is the size of the persisted snapshot in bytes.
+	Size int64
+	// Signer is the public key of the node that sealed the snapshot. It is
+	// nil if snapshots are not sealed.
+	Signer crypto.PublicKey
+	// Duration is how long the restore took.
+	Duration time.Duration
+
+	data []byte
+}
+
+// Iter calls fn for every key in the restored snapshot in key order, along
+// with its value and the TTL it had when the snapshot was taken. A TTL of
+// zero means the key does not expire. The revisions recorded alongside each
+// key are not reported.
+func (r *Restored) Iter(fn func(key, value []byte, ttl time.Duration) error) error {
+	var snap v1.RaftSnapshot
+	if err := proto.Unmarshal(r.data, &snap); err != nil {
+		return fmt.Errorf("unmarshal snapshot: %w", err)
+	}
+	slices.SortFunc(snap.Kv, func(a, b *v1.RaftDataItem) int {
+		return bytes.Compare(a.Key, b.Key)
+	})
+	for _, item := range snap.Kv {
+		if storage.RevisionsPrefix.Contains(item.GetKey()) {
+			continue
+		}
+		if err := fn(item.GetKey(), item.GetValue(), item.GetTtl().AsDuration()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Options are options for the snapshotter.
+type Options struct {
+	// MaxIncrementals is the number of incremental snapshots taken between
+	// full snapshots. Incremental snapshots only record the keys changed since
+	// the previous snapshot and must be read through a ChainStore. The
+	// snapshot store must retain more snapshots than this. Zero disables
+	// incremental snapshots.
+	MaxIncrementals int
+	// Sealer, if set, encrypts and signs every snapshot taken. Restore then
+	// only accepts snapshots sealed with the same encryption key.
+	Sealer *Sealer
 }
 
 type snapshotter struct {
-	st  storage.ConsensusStorage
-	log *slog.Logger
+	st   storage.ConsensusStorage
+	opts Options
+	log  *slog.Logger
+
+	// base is the last persisted snapshot that the next incremental
+	// snapshot is taken against, and chain is the number of incremental
+	// snapshots persisted since the last full one.
+	base  *snapshotBase
+	chain int
+	mu    sync.Mutex
+}
+
+// snapshotBase is a persisted snapshot and the digests of its contents.
+type snapshotBase struct {
+	id      string
+	digests map[string]itemDigest
 }
 
 // New returns a new Snapshotter.
-func New(ctx context.Context, st storage.ConsensusStorage) Snapshotter {
+func New(ctx context.Context, st storage.ConsensusStorage, opts Options) Snapshotter {
 	return &snapshotter{
-		st:  st,
-		log: context.LoggerFrom(ctx).With("component", "snapshots"),
+		st:   st,
+		opts: opts,
+		log:  context.LoggerFrom(ctx).With("component", "snapshots"),
 	}
 }
 
@@ -59,58 +218,140 @@ func (s *snapshotter) Snapshot(ctx context.Context) (raft.FSMSnapshot, error) {
 	if err != nil {
 		return nil, fmt.Errorf("get snapshot: %w", err)
 	}
-	var buf bytes.Buffer
-	gzw := gzip.NewWriter(&buf)
-	if _, err := io.Copy(gzw, data); err != nil {
-		return nil, fmt.Errorf("compress snapshot data: %w", err)
+	var snapshot *snapshot
+	if s.opts.MaxIncrementals > 0 {
+		snapshot, err = s.incremental(data)
+	} else {
+		var buf *bytes.Buffer
+		buf, err = Encode(data)
+		snapshot = newSnapshot(buf, nil)
 	}
-	if err := gzw.Close(); err != nil {
-		return nil, fmt.Errorf("close gzip writer: %w", err)
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.Sealer != nil {
+		sealed, err := s.opts.Sealer.Seal(snapshot.data.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("seal snapshot: %w", err)
+		}
+		snapshot.data = bytes.NewBuffer(sealed)
 	}
-	snapshot := &snapshot{&buf}
 	s.log.Info("db snapshot complete",
 		slog.String("duration", time.Since(start).String()),
 		slog.String("size", snapshot.size()),
+		slog.Bool("incremental", snapshot.incremental),
 	)
 	return snapshot, nil
 }
 
-func (s *snapshotter) Restore(ctx context.Context, r io.ReadCloser) error {
+// incremental returns an incremental snapshot against the last persisted
+// snapshot, or a full snapshot if there is none or the chain is at its limit.
+func (s *snapshotter) incremental(data io.Reader) (*snapshot, error) {
+	raw, err := io.ReadAll(data)
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	var snap v1.RaftSnapshot
+	if err := proto.Unmarshal(raw, &snap); err != nil {
+		return nil, fmt.Errorf("unmarshal snapshot: %w", err)
+	}
+	digests := digestSnapshot(&snap)
+	s.mu.Lock()
+	base, chain := s.base, s.chain
+	s.mu.Unlock()
+	// The snapshot becomes the base for the next one once it is persisted.
+	persisted := func(chain int) func(string) {
+		return func(id string) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.base = &snapshotBase{id: id, digests: digests}
+			s.chain = chain
+		}
+	}
+	if base == nil || chain >= s.opts.MaxIncrementals {
+		buf, err := Encode(bytes.NewReader(raw))
+		if err != nil {
+			return nil, err
+		}
+		return newSnapshot(buf, persisted(0)), nil
+	}
+	buf, err := encodeDelta(base.id, diffSnapshot(base.digests, &snap, digests))
+	if err != nil {
+		return nil, err
+	}
+	snapshot := newSnapshot(buf, persisted(chain+1))
+	snapshot.incremental = true
+	return snapshot, nil
+}
+
+func (s *snapshotter) Restore(ctx context.Context, r io.ReadCloser) (*Restored, error) {
 	defer r.Close()
 	s.log.Info("restoring db snapshot")
 	start := time.Now()
-	gzr, err := gzip.NewReader(r)
+	raw, err := io.ReadAll(r)
 	if err != nil {
-		return fmt.Errorf("gzip reader: %w", err)
+		return nil, fmt.Errorf("read snapshot: %w", err)
 	}
-	defer gzr.Close()
-	data, err := io.ReadAll(gzr)
+	restored := &Restored{Size: int64(len(raw))}
+	if s.opts.Sealer != nil {
+		raw, restored.Signer, err = s.opts.Sealer.Open(raw)
+		if err != nil {
+			return nil, fmt.Errorf("open sealed snapshot: %w", err)
+		}
+		s.log.Info("verified snapshot signature", slog.String("signer", restored.Signer.ID()))
+	} else if IsSealed(raw) {
+		return nil, ErrSealed
+	}
+	if IsIncremental(raw) {
+		return nil, ErrIncrementalSnapshot
+	}
+	restored.data, err = decode(raw)
 	if err != nil {
-		return fmt.Errorf("read snapshot: %w", err)
+		return nil, fmt.Errorf("verify snapshot: %w", err)
 	}
-	if err := s.st.Restore(ctx, bytes.NewReader(data)); err != nil {
-		return fmt.Errorf("restore snapshot: %w", err)
+	// The restored state no longer matches the last persisted snapshot,
+	// so the next snapshot must be a full one.
+	s.mu.Lock()
+	s.base, s.chain = nil, 0
+	s.mu.Unlock()
+	if err := s.st.Restore(ctx, bytes.NewReader(restored.data)); err != nil {
+		return nil, fmt.Errorf("restore snapshot: %w", err)
 	}
-	s.log.Info("db snapshot restore complete", slog.String("duration", time.Since(start).String()))
-	return nil
+	restored.Duration = time.Since(start)
+	s.log.Info("db snapshot restore complete", slog.String("duration", restored.Duration.String()))
+	return restored, nil
 }
 
 // snapshot is a Raft snapshot.
 type snapshot struct {
-	data *bytes.Buffer
+	data        *bytes.Buffer
+	incremental bool
+	// persisted is called with the sink ID once the snapshot is persisted.
+	persisted func(id string)
+}
+
+func newSnapshot(data *bytes.Buffer, persisted func(id string)) *snapshot {
+	return &snapshot{data: data, persisted: persisted}
 }
 
 // Persist persists the snapshot to a sink.
 func (s *snapshot) Persist(sink raft.SnapshotSink) error {
-	defer sink.Close()
 	if s.data == nil {
+		sink.Close()
 		return fmt.Errorf("snapshot data is nil")
 	}
 	var buf bytes.Buffer
 	if _, err := io.Copy(sink, io.TeeReader(s.data, &buf)); err != nil {
+		sink.Close()
 		return fmt.Errorf("write snapshot data to sink: %w", err)
 	}
 	s.data = &buf
+	if err := sink.Close(); err != nil {
+		return fmt.Errorf("close snapshot sink: %w", err)
+	}
+	if s.persisted != nil {
+		s.persisted(sink.ID())
+	}
 	return nil
 }
 
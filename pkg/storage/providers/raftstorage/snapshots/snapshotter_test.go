@@ -18,10 +18,20 @@ package snapshots
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"maps"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
 	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
 )
 
@@ -45,7 +55,7 @@ func TestSnapshotter(t *testing.T) {
 			t.Fatal(err)
 		}
 	}
-	snaps := New(context.Background(), db)
+	snaps := New(context.Background(), db, Options{})
 
 	// Take a snapshot.
 	snap, err := snaps.Snapshot(context.Background())
@@ -68,12 +78,32 @@ func TestSnapshotter(t *testing.T) {
 	}
 
 	// Restore the snapshot.
-	if err := snaps.Restore(context.Background(), sink); err != nil {
+	restored, err := snaps.Restore(context.Background(), sink)
+	if err != nil {
 		t.Fatal(err)
 	}
 
 	snap.Release()
 
+	// Ensure the restored keys are reported in order.
+	var keys []string
+	err = restored.Iter(func(key, value []byte, ttl time.Duration) error {
+		if !bytes.Equal(value, testValues[string(key)]) {
+			t.Errorf("got %q for %s, want %q", value, key, testValues[string(key)])
+		}
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(keys, []string{"/registry/abc", "/registry/baz", "/registry/foo"}) {
+		t.Errorf("unexpected restored keys: %v", keys)
+	}
+	if restored.Size == 0 || restored.Signer != nil {
+		t.Errorf("unexpected restore metadata: %+v", restored)
+	}
+
 	// Ensure the keys were restored.
 	for key, val := range testValues {
 		got, err := db.GetValue(context.Background(), []byte(key))
@@ -86,6 +116,353 @@ func TestSnapshotter(t *testing.T) {
 	}
 }
 
+func TestSnapshotChecksum(t *testing.T) {
+	t.Parallel()
+
+	db, err := badgerdb.NewInMemory(badgerdb.Options{})
+	if err != nil {
+		t.Fatalf("create test db: %v", err)
+	}
+	defer db.Close()
+	if err := db.PutValue(context.Background(), []byte("/registry/foo"), []byte("bar"), 0); err != nil {
+		t.Fatal(err)
+	}
+	snaps := New(context.Background(), db, Options{})
+	snap, err := snaps.Snapshot(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer snap.Release()
+	buf := new(bytes.Buffer)
+	if err := snap.Persist(&testSnapshotSink{buf}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	t.Run("Valid", func(t *testing.T) {
+		if err := Verify(bytes.NewReader(data), nil); err != nil {
+			t.Fatalf("expected valid snapshot, got %v", err)
+		}
+	})
+
+	t.Run("Corrupt", func(t *testing.T) {
+		corrupt := bytes.Clone(data)
+		corrupt[len(corrupt)-1] ^= 0xff
+		if err := Verify(bytes.NewReader(corrupt), nil); !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+		}
+		_, err := snaps.Restore(context.Background(), io.NopCloser(bytes.NewReader(corrupt)))
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("expected restore to fail with ErrChecksumMismatch, got %v", err)
+		}
+	})
+
+	t.Run("Legacy", func(t *testing.T) {
+		var legacy bytes.Buffer
+		gzw := gzip.NewWriter(&legacy)
+		if _, err := gzw.Write([]byte("legacy snapshot")); err != nil {
+			t.Fatal(err)
+		}
+		if err := gzw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(bytes.NewReader(legacy.Bytes()), nil); err != nil {
+			t.Fatalf("expected legacy snapshot to verify, got %v", err)
+		}
+	})
+}
+
+func TestIncrementalSnapshots(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	db, err := badgerdb.NewInMemory(badgerdb.Options{})
+	if err != nil {
+		t.Fatalf("create test db: %v", err)
+	}
+	defer db.Close()
+	fileStore, err := raft.NewFileSnapshotStore(t.TempDir(), 3, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewChainStore(fileStore, nil)
+	snaps := New(ctx, db, Options{MaxIncrementals: 2})
+
+	var index uint64
+	// take persists a new snapshot and returns its ID and raw persisted data.
+	take := func(t *testing.T) (string, []byte) {
+		t.Helper()
+		snap, err := snaps.Snapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snap.Release()
+		index++
+		sink, err := store.Create(raft.SnapshotVersionMax, index, 1, raft.Configuration{}, 1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := snap.Persist(sink); err != nil {
+			t.Fatal(err)
+		}
+		_, rc, err := fileStore.Open(sink.ID())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rc.Close()
+		data, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := Verify(bytes.NewReader(data), nil); err != nil {
+			t.Fatalf("verify snapshot: %v", err)
+		}
+		return sink.ID(), data
+	}
+	put := func(t *testing.T, key, value string) {
+		t.Helper()
+		if err := db.PutValue(ctx, []byte(key), []byte(value), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]string{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("/registry/key-%03d", i)
+		want[key] = strings.Repeat("x", 64)
+		put(t, key, want[key])
+	}
+
+	_, full := take(t)
+	if IsIncremental(full) {
+		t.Fatal("expected the first snapshot to be full")
+	}
+
+	put(t, "/registry/key-000", "changed")
+	want["/registry/key-000"] = "changed"
+	if err := db.Delete(ctx, []byte("/registry/key-001")); err != nil {
+		t.Fatal(err)
+	}
+	delete(want, "/registry/key-001")
+	_, delta := take(t)
+	if !IsIncremental(delta) {
+		t.Fatal("expected the second snapshot to be incremental")
+	}
+	if len(delta) >= len(full) {
+		t.Fatalf("expected incremental snapshot to be smaller than full, got %d >= %d", len(delta), len(full))
+	}
+
+	put(t, "/registry/new", "value")
+	want["/registry/new"] = "value"
+	latest, delta := take(t)
+	if !IsIncremental(delta) {
+		t.Fatal("expected the third snapshot to be incremental")
+	}
+
+	t.Run("ChainedRestore", func(t *testing.T) {
+		meta, rc, err := store.Open(latest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		flat, err := io.ReadAll(rc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta.Size != int64(len(flat)) {
+			t.Fatalf("expected size %d, got %d", len(flat), meta.Size)
+		}
+		restoreDB, err := badgerdb.NewInMemory(badgerdb.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer restoreDB.Close()
+		_, err = New(ctx, restoreDB, Options{}).Restore(ctx, io.NopCloser(bytes.NewReader(flat)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := map[string]string{}
+		err = restoreDB.IterPrefix(ctx, []byte("/registry/"), func(key, value []byte) error {
+			got[string(key)] = string(value)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !maps.Equal(got, want) {
+			t.Fatalf("restored state does not match, got %d keys, want %d", len(got), len(want))
+		}
+	})
+
+	t.Run("FullAfterMaxIncrementals", func(t *testing.T) {
+		_, data := take(t)
+		if IsIncremental(data) {
+			t.Fatal("expected a full snapshot after the maximum number of incrementals")
+		}
+	})
+
+	t.Run("RestoreUnresolved", func(t *testing.T) {
+		_, err := snaps.Restore(ctx, io.NopCloser(bytes.NewReader(delta)))
+		if !errors.Is(err, ErrIncrementalSnapshot) {
+			t.Fatalf("expected ErrIncrementalSnapshot, got %v", err)
+		}
+	})
+
+	t.Run("MissingBase", func(t *testing.T) {
+		orphan, err := encodeDelta("missing", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sink, err := store.Create(raft.SnapshotVersionMax, 100, 1, raft.Configuration{}, 1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sink.Write(orphan.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if err := sink.Close(); err != nil {
+			t.Fatal(err)
+		}
+		_, _, err = store.Open(sink.ID())
+		if !errors.Is(err, ErrMissingBase) {
+			t.Fatalf("expected ErrMissingBase, got %v", err)
+		}
+	})
+}
+
+func TestSealedSnapshots(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	newSealer := func(t *testing.T, key string) *Sealer {
+		t.Helper()
+		sealer, err := NewSealer([]byte(key), crypto.MustGenerateKey())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sealer
+	}
+	leader, follower := newSealer(t, "cluster-key"), newSealer(t, "cluster-key")
+
+	db, err := badgerdb.NewInMemory(badgerdb.Options{})
+	if err != nil {
+		t.Fatalf("create test db: %v", err)
+	}
+	defer db.Close()
+	if err := db.PutValue(ctx, []byte("/registry/foo"), []byte("secret-endpoint"), 0); err != nil {
+		t.Fatal(err)
+	}
+	fileStore, err := raft.NewFileSnapshotStore(t.TempDir(), 3, io.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewChainStore(fileStore, leader)
+	snaps := New(ctx, db, Options{MaxIncrementals: 2, Sealer: leader})
+
+	var index uint64
+	take := func(t *testing.T) (string, []byte) {
+		t.Helper()
+		snap, err := snaps.Snapshot(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snap.Release()
+		index++
+		sink, err := store.Create(raft.SnapshotVersionMax, index, 1, raft.Configuration{}, 1, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := snap.Persist(&teeSink{SnapshotSink: sink, w: &buf}); err != nil {
+			t.Fatal(err)
+		}
+		if !IsSealed(buf.Bytes()) {
+			t.Fatal("expected a sealed snapshot")
+		}
+		if err := Verify(bytes.NewReader(buf.Bytes()), follower); err != nil {
+			t.Fatalf("verify snapshot: %v", err)
+		}
+		return sink.ID(), buf.Bytes()
+	}
+	_, full := take(t)
+	if err := db.PutValue(ctx, []byte("/registry/bar"), []byte("baz"), 0); err != nil {
+		t.Fatal(err)
+	}
+	latest, _ := take(t)
+
+	// Open flattens the incremental snapshot and reseals it.
+	_, rc, err := store.Open(latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	flat, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(flat, []byte("secret-endpoint")) {
+		t.Fatal("sealed snapshot contains cleartext data")
+	}
+
+	t.Run("RestoreWithClusterKey", func(t *testing.T) {
+		restoreDB, err := badgerdb.NewInMemory(badgerdb.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer restoreDB.Close()
+		_, err = New(ctx, restoreDB, Options{Sealer: follower}).Restore(ctx, io.NopCloser(bytes.NewReader(flat)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := restoreDB.GetValue(ctx, []byte("/registry/bar"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(value) != "baz" {
+			t.Fatalf("expected baz, got %q", value)
+		}
+	})
+
+	tc := []struct {
+		name   string
+		sealer *Sealer
+		data   []byte
+		err    error
+	}{
+		{"WrongKey", newSealer(t, "other-key"), full, ErrBadSeal},
+		{"NoKey", nil, full, ErrSealed},
+		{"Tampered", follower, func() []byte {
+			tampered := bytes.Clone(full)
+			tampered[len(tampered)-1] ^= 0xff
+			return tampered
+		}(), ErrBadSeal},
+		{"Unsealed", follower, func() []byte {
+			encoded, err := Encode(strings.NewReader("data"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return encoded.Bytes()
+		}(), ErrNotSealed},
+	}
+	for _, tt := range tc {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(ctx, db, Options{Sealer: tt.sealer}).Restore(ctx, io.NopCloser(bytes.NewReader(tt.data)))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+// teeSink copies everything written to the sink to w.
+type teeSink struct {
+	raft.SnapshotSink
+	w io.Writer
+}
+
+func (t *teeSink) Write(p []byte) (int, error) {
+	t.w.Write(p)
+	return t.SnapshotSink.Write(p)
+}
+
 type testSnapshotSink struct {
 	io.ReadWriter
 }
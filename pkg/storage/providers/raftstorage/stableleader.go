@@ -0,0 +1,127 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// DefaultStableLeaderMaxMultiplier is the default largest multiplier stable
+// leader mode raises the heartbeat and election timeouts to.
+const DefaultStableLeaderMaxMultiplier = 8
+
+// StableLeaderOptions are options for raising the heartbeat and election
+// timeouts at runtime on high-latency networks to reduce spurious elections.
+type StableLeaderOptions struct {
+	// MaxMultiplier is the largest multiplier the configured heartbeat and
+	// election timeouts are raised to. Defaults to DefaultStableLeaderMaxMultiplier.
+	MaxMultiplier int
+}
+
+// ElectionMultiplier returns the multiplier stable leader mode applied to the
+// configured heartbeat and election timeouts. It is 1 if they were not raised.
+func (r *Provider) ElectionMultiplier() int {
+	if m := r.stableMultiplier.Load(); m > 1 {
+		return int(m)
+	}
+	return 1
+}
+
+// runStableLeader watches the contact with the leader while this node is a
+// follower. When the leader was not heard from for more than half of the
+// heartbeat timeout before contact resumed, the heartbeat and election
+// timeouts are doubled, up to the maximum multiplier. Gaps are only measured
+// once contact resumes, so a failed leader does not slow down its replacement.
+// Timeouts are never lowered again, favoring a stable leader over fast failover.
+func (r *Provider) runStableLeader(heartbeat, election time.Duration) (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	maxMultiplier := r.Options.StableLeader.MaxMultiplier
+	if maxMultiplier <= 0 {
+		maxMultiplier = DefaultStableLeaderMaxMultiplier
+	}
+	r.stableMultiplier.Store(1)
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(max(heartbeat/10, 10*time.Millisecond))
+		defer ticker.Stop()
+		var watcher contactWatcher
+		for {
+			select {
+			case <-closeCh:
+				return
+			case <-ticker.C:
+				multiplier := r.ElectionMultiplier()
+				if multiplier >= maxMultiplier {
+					return
+				}
+				if r.raft.State() != raft.Follower {
+					watcher = contactWatcher{}
+					continue
+				}
+				_, leader := r.raft.LeaderWithID()
+				gap := watcher.observe(string(leader), r.raft.LastContact())
+				if gap <= heartbeat*time.Duration(multiplier)/2 {
+					continue
+				}
+				multiplier = min(multiplier*2, maxMultiplier)
+				rc := r.raft.ReloadableConfig()
+				rc.HeartbeatTimeout = heartbeat * time.Duration(multiplier)
+				rc.ElectionTimeout = election * time.Duration(multiplier)
+				if err := r.raft.ReloadConfig(rc); err != nil {
+					r.log.Error("Failed to raise election timeouts", slog.String("error", err.Error()))
+					continue
+				}
+				r.stableMultiplier.Store(int32(multiplier))
+				r.log.Info("Raised election timeouts after slow contact with the leader",
+					slog.String("leader", string(leader)),
+					slog.String("gap", gap.String()),
+					slog.Int("multiplier", multiplier),
+					slog.String("heartbeat-timeout", rc.HeartbeatTimeout.String()),
+					slog.String("election-timeout", rc.ElectionTimeout.String()),
+				)
+			}
+		}
+	}()
+	return
+}
+
+// contactWatcher tracks the gaps between contacts with the same leader.
+type contactWatcher struct {
+	leader  string
+	contact time.Time
+}
+
+// observe records the last contact with the given leader and returns the gap
+// since the previously observed contact. It returns zero until contact with the
+// same leader has been observed twice.
+func (w *contactWatcher) observe(leader string, contact time.Time) time.Duration {
+	if leader == "" || contact.IsZero() {
+		*w = contactWatcher{}
+		return 0
+	}
+	if leader != w.leader || w.contact.IsZero() {
+		*w = contactWatcher{leader: leader, contact: contact}
+		return 0
+	}
+	gap := contact.Sub(w.contact)
+	w.contact = contact
+	return gap
+}
@@ -0,0 +1,69 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestElectionMultiplier(t *testing.T) {
+	t.Parallel()
+	opts := Options{
+		HeartbeatTimeout:   time.Second,
+		ElectionTimeout:    time.Second,
+		LeaderLeaseTimeout: 500 * time.Millisecond,
+		ElectionMultiplier: 3,
+	}
+	config := opts.RaftConfig(context.Background(), "node")
+	if config.HeartbeatTimeout != 3*time.Second || config.ElectionTimeout != 3*time.Second {
+		t.Fatalf("expected heartbeat and election timeouts to be tripled, got %s and %s", config.HeartbeatTimeout, config.ElectionTimeout)
+	}
+	if config.LeaderLeaseTimeout != 1500*time.Millisecond {
+		t.Fatalf("expected leader lease timeout to be tripled, got %s", config.LeaderLeaseTimeout)
+	}
+	if err := raft.ValidateConfig(config); err != nil {
+		t.Fatalf("expected scaled config to be valid: %v", err)
+	}
+}
+
+func TestContactWatcher(t *testing.T) {
+	t.Parallel()
+	var w contactWatcher
+	start := time.Now()
+	if gap := w.observe("leader-a", start); gap != 0 {
+		t.Fatalf("expected no gap on first contact, got %s", gap)
+	}
+	// No new contact yet.
+	if gap := w.observe("leader-a", start); gap != 0 {
+		t.Fatalf("expected no gap without new contact, got %s", gap)
+	}
+	if gap := w.observe("leader-a", start.Add(2*time.Second)); gap != 2*time.Second {
+		t.Fatalf("expected gap of 2s once contact resumed, got %s", gap)
+	}
+	// Gaps are not measured across leader changes.
+	if gap := w.observe("leader-b", start.Add(10*time.Second)); gap != 0 {
+		t.Fatalf("expected no gap after leader change, got %s", gap)
+	}
+	if gap := w.observe("", time.Time{}); gap != 0 {
+		t.Fatalf("expected no gap without a leader, got %s", gap)
+	}
+}
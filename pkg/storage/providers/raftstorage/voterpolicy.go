@@ -0,0 +1,216 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/raft"
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+const (
+	// DefaultVoterPolicyInterval is the default interval voters are reconciled at.
+	DefaultVoterPolicyInterval = 30 * time.Second
+	// DefaultFlakyVoterTimeout is the default time the leader must fail to
+	// heartbeat a voter before it is demoted.
+	DefaultFlakyVoterTimeout = time.Minute
+)
+
+// VoterPolicyOptions are options for automatically promoting observers to
+// voters and demoting flaky voters to maintain a target number of voters.
+type VoterPolicyOptions struct {
+	// TargetVoters is the number of voters to maintain, including the leader.
+	TargetVoters int
+	// Interval is how often the voters are reconciled. Defaults to
+	// DefaultVoterPolicyInterval.
+	Interval time.Duration
+	// FlakyTimeout is how long the leader must fail to heartbeat a voter
+	// before it is demoted. Defaults to DefaultFlakyVoterTimeout.
+	FlakyTimeout time.Duration
+}
+
+// canVoteAction is the action a node must be allowed to be promoted to voter.
+var canVoteAction = &v1.RBACAction{
+	Verb:     v1.RuleVerb_VERB_PUT,
+	Resource: v1.RuleResource_RESOURCE_VOTES,
+}
+
+// runVoterPolicy periodically reconciles the voters while this node is the leader.
+func (r *Provider) runVoterPolicy() (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	interval := r.Options.VoterPolicy.Interval
+	if interval <= 0 {
+		interval = DefaultVoterPolicyInterval
+	}
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closeCh:
+				return
+			case <-ticker.C:
+				if r.raft.State() != raft.Leader {
+					continue
+				}
+				ctx := context.WithLogger(context.Background(), r.log)
+				if err := r.reconcileVoters(ctx); err != nil {
+					r.log.Error("Failed to reconcile voters", slog.String("error", err.Error()))
+				}
+			}
+		}
+	}()
+	return
+}
+
+// reconcileVoters demotes voters the leader has failed to heartbeat for longer
+// than the flaky timeout when they can be replaced, promotes eligible observers
+// until the target is reached, and demotes the voters above the target. The
+// leader is never demoted and nodes in a maintenance window are left alone.
+func (r *Provider) reconcileVoters(ctx context.Context) error {
+	opts := r.Options.VoterPolicy
+	flakyTimeout := opts.FlakyTimeout
+	if flakyTimeout <= 0 {
+		flakyTimeout = DefaultFlakyVoterTimeout
+	}
+	if inMaintenance, err := storage.InMaintenance(ctx, r.raftStorage, ""); err != nil {
+		return fmt.Errorf("check maintenance: %w", err)
+	} else if inMaintenance {
+		return nil
+	}
+	var healthy, flaky, candidates []raft.Server
+	for _, srv := range r.GetRaftConfiguration().Servers {
+		if srv.ID == r.nodeID {
+			continue
+		}
+		inMaintenance, err := storage.InMaintenance(ctx, r.raftStorage, types.NodeID(srv.ID))
+		if err != nil {
+			return fmt.Errorf("check maintenance: %w", err)
+		}
+		if inMaintenance {
+			continue
+		}
+		since, failing := r.failingSince(srv.ID)
+		switch srv.Suffrage {
+		case raft.Voter:
+			if failing && time.Since(since) > flakyTimeout {
+				flaky = append(flaky, srv)
+			} else {
+				healthy = append(healthy, srv)
+			}
+		case raft.Nonvoter:
+			if failing {
+				continue
+			}
+			eligible, err := r.canPromote(ctx, types.NodeID(srv.ID))
+			if err != nil {
+				return err
+			}
+			if eligible {
+				candidates = append(candidates, srv)
+			}
+		}
+	}
+	byID := func(servers []raft.Server) {
+		sort.Slice(servers, func(i, j int) bool { return servers[i].ID < servers[j].ID })
+	}
+	byID(healthy)
+	byID(flaky)
+	byID(candidates)
+	// Count the leader itself.
+	voters := len(healthy) + len(flaky) + 1
+	for _, srv := range flaky {
+		if len(candidates) == 0 && voters <= opts.TargetVoters {
+			break
+		}
+		r.log.Info("Demoting flaky voter", slog.String("id", string(srv.ID)))
+		if err := r.setVoter(srv, false); err != nil {
+			return fmt.Errorf("demote voter %s: %w", srv.ID, err)
+		}
+		voters--
+	}
+	for _, srv := range candidates {
+		if voters >= opts.TargetVoters {
+			break
+		}
+		r.log.Info("Promoting observer to voter", slog.String("id", string(srv.ID)))
+		if err := r.setVoter(srv, true); err != nil {
+			return fmt.Errorf("promote observer %s: %w", srv.ID, err)
+		}
+		voters++
+	}
+	for i := len(healthy) - 1; i >= 0 && voters > opts.TargetVoters; i-- {
+		srv := healthy[i]
+		r.log.Info("Demoting voter above the target", slog.String("id", string(srv.ID)))
+		if err := r.setVoter(srv, false); err != nil {
+			return fmt.Errorf("demote voter %s: %w", srv.ID, err)
+		}
+		voters--
+	}
+	return nil
+}
+
+// canPromote returns true if the given observer may be promoted to voter.
+// Learners, quarantined nodes, and nodes without permission to vote are not.
+func (r *Provider) canPromote(ctx context.Context, id types.NodeID) (bool, error) {
+	learner, err := storage.IsLearner(ctx, r.raftStorage, id)
+	if err != nil {
+		return false, fmt.Errorf("check learner: %w", err)
+	}
+	if learner {
+		return false, nil
+	}
+	quarantined, err := storage.IsQuarantined(ctx, r.raftStorage, id)
+	if err != nil {
+		return false, fmt.Errorf("check quarantine: %w", err)
+	}
+	if quarantined {
+		return false, nil
+	}
+	// We treat nodes and users as the same entity for the purpose of authorization.
+	nodeRoles, err := r.meshDB.RBAC().ListNodeRoles(ctx, id)
+	if err != nil {
+		return false, fmt.Errorf("list node roles: %w", err)
+	}
+	userRoles, err := r.meshDB.RBAC().ListUserRoles(ctx, id)
+	if err != nil {
+		return false, fmt.Errorf("list user roles: %w", err)
+	}
+	return nodeRoles.Eval(canVoteAction) || userRoles.Eval(canVoteAction), nil
+}
+
+// setVoter promotes the given server to voter or demotes it to observer. It
+// does not take the lock, so that it does not block closing the provider.
+func (r *Provider) setVoter(srv raft.Server, voter bool) error {
+	var f raft.IndexFuture
+	if voter {
+		f = r.raft.AddVoter(srv.ID, srv.Address, 0, r.Options.ApplyTimeout)
+	} else {
+		f = r.raft.DemoteVoter(srv.ID, 0, r.Options.ApplyTimeout)
+	}
+	return f.Error()
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package raftstorage
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/testutil"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestReconcileVoters(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	b := &builder{}
+	providers := b.newProviders(t, 4)
+	for _, p := range providers {
+		testutil.MustStartProvider(ctx, t, p)
+		defer p.Close()
+	}
+	testutil.MustBootstrapProvider(ctx, t, providers[0])
+	ok := testutil.Eventually[bool](func() bool {
+		return providers[0].Consensus().IsLeader()
+	}).ShouldEqual(time.Second*10, time.Millisecond*100, true)
+	if !ok {
+		t.Fatal("Bootstrapped provider did not become leader")
+	}
+	leader := providers[0].(*Provider)
+	var ids []string
+	for _, p := range providers[1:] {
+		peer := types.StoragePeer{StoragePeer: p.Status().GetPeers()[0]}
+		ids = append(ids, peer.GetId())
+		if err := leader.Consensus().AddObserver(ctx, peer); err != nil {
+			t.Fatalf("add observer: %v", err)
+		}
+	}
+	slices.Sort(ids)
+	// The last observer is a learner and the first may not vote.
+	learner := ids[2]
+	if err := storage.MarkLearner(ctx, leader.MeshStorage(), types.NodeID(learner)); err != nil {
+		t.Fatalf("mark learner: %v", err)
+	}
+	err := leader.MeshDB().RBAC().PutRole(ctx, types.Role{Role: &v1.Role{
+		Name: "voters",
+		Rules: []*v1.Rule{{
+			Resources: []v1.RuleResource{v1.RuleResource_RESOURCE_VOTES},
+			Verbs:     []v1.RuleVerb{v1.RuleVerb_VERB_PUT},
+		}},
+	}})
+	if err != nil {
+		t.Fatalf("put role: %v", err)
+	}
+	err = leader.MeshDB().RBAC().PutRoleBinding(ctx, types.RoleBinding{RoleBinding: &v1.RoleBinding{
+		Name:     "voters",
+		Role:     "voters",
+		Subjects: []*v1.Subject{{Type: v1.SubjectType_SUBJECT_NODE, Name: ids[1]}, {Type: v1.SubjectType_SUBJECT_NODE, Name: learner}},
+	}})
+	if err != nil {
+		t.Fatalf("put role binding: %v", err)
+	}
+	voters := func() []string {
+		var out []string
+		for _, srv := range leader.GetRaftConfiguration().Servers {
+			if srv.Suffrage == raft.Voter && srv.ID != leader.nodeID {
+				out = append(out, string(srv.ID))
+			}
+		}
+		slices.Sort(out)
+		return out
+	}
+
+	// Only the observer allowed to vote is promoted.
+	leader.Options.VoterPolicy = &VoterPolicyOptions{TargetVoters: 5}
+	if err := leader.reconcileVoters(ctx); err != nil {
+		t.Fatalf("reconcile voters: %v", err)
+	}
+	if got := voters(); !slices.Equal(got, []string{ids[1]}) {
+		t.Fatalf("expected %s to be promoted, got voters %v", ids[1], got)
+	}
+
+	// A flaky voter is kept when it cannot be replaced.
+	leader.trackHeartbeat(raft.Observation{Data: raft.FailedHeartbeatObservation{
+		PeerID:      raft.ServerID(ids[1]),
+		LastContact: time.Now().Add(-time.Hour),
+	}})
+	if err := leader.reconcileVoters(ctx); err != nil {
+		t.Fatalf("reconcile voters: %v", err)
+	}
+	if got := voters(); !slices.Equal(got, []string{ids[1]}) {
+		t.Fatalf("expected flaky voter %s to be kept, got voters %v", ids[1], got)
+	}
+
+	// Voters above the target are demoted.
+	leader.trackHeartbeat(raft.Observation{Data: raft.ResumedHeartbeatObservation{PeerID: raft.ServerID(ids[1])}})
+	leader.Options.VoterPolicy = &VoterPolicyOptions{TargetVoters: 1}
+	if err := leader.reconcileVoters(ctx); err != nil {
+		t.Fatalf("reconcile voters: %v", err)
+	}
+	if got := voters(); len(got) != 0 {
+		t.Fatalf("expected voters above the target to be demoted, got %v", got)
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// segmentExt is the file extension of segment files.
+const segmentExt = ".wal"
+
+// recordHeaderSize is the size of the header preceding every record. It holds
+// the length of the payload followed by its CRC32-C checksum.
+const recordHeaderSize = 8
+
+// entryHeaderSize is the size of the fixed fields of an encoded log entry.
+const entryHeaderSize = 8 + 8 + 1 + 8 + 4
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// errTorn is returned when a segment ends in a partially written record.
+var errTorn = errors.New("torn record")
+
+// segment is a file holding a contiguous run of log entries starting at base.
+type segment struct {
+	base    uint64
+	path    string
+	file    *os.File
+	offsets []int64
+	size    int64
+}
+
+// segmentPath returns the path of the segment starting at the given index.
+func segmentPath(dir string, base uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", base, segmentExt))
+}
+
+// parseSegmentName returns the base index of a segment file name.
+func parseSegmentName(name string) (uint64, bool) {
+	if !strings.HasSuffix(name, segmentExt) {
+		return 0, false
+	}
+	base, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+	return base, err == nil
+}
+
+// createSegment creates an empty segment starting at the given index.
+func createSegment(dir string, base uint64) (*segment, error) {
+	path := segmentPath(dir, base)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("create segment: %w", err)
+	}
+	return &segment{base: base, path: path, file: f}, nil
+}
+
+// openSegment opens an existing segment and indexes its records. A torn record
+// at the end of the file is reported with errTorn, with the segment holding
+// every record before it.
+func openSegment(path string, base uint64) (*segment, error) {
+	f, err := os.OpenFile(path, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("open segment: %w", err)
+	}
+	seg := &segment{base: base, path: path, file: f}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("stat segment: %w", err)
+	}
+	header := make([]byte, recordHeaderSize)
+	var entry raft.Log
+	for seg.size < info.Size() {
+		payload, err := seg.readRecord(seg.size, header)
+		if err == nil {
+			err = decodeEntry(payload, &entry)
+			if err == nil && entry.Index != seg.base+uint64(len(seg.offsets)) {
+				err = fmt.Errorf("expected index %d, found %d", seg.base+uint64(len(seg.offsets)), entry.Index)
+			}
+		}
+		if err != nil {
+			return seg, fmt.Errorf("%w at offset %d of %s: %v", errTorn, seg.size, filepath.Base(path), err)
+		}
+		seg.offsets = append(seg.offsets, seg.size)
+		seg.size += recordHeaderSize + int64(len(payload))
+	}
+	return seg, nil
+}
+
+// last returns the index of the last entry in the segment, or base-1 if it is
+// empty.
+func (s *segment) last() uint64 {
+	return s.base + uint64(len(s.offsets)) - 1
+}
+
+// readRecord reads and verifies the record at the given offset.
+func (s *segment) readRecord(offset int64, header []byte) ([]byte, error) {
+	if _, err := s.file.ReadAt(header, offset); err != nil {
+		return nil, err
+	}
+	length := binary.BigEndian.Uint32(header[0:4])
+	payload := make([]byte, length)
+	if _, err := s.file.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if crc32.Checksum(payload, crcTable) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	return payload, nil
+}
+
+// get reads the entry with the given index.
+func (s *segment) get(index uint64, entry *raft.Log) error {
+	payload, err := s.readRecord(s.offsets[index-s.base], make([]byte, recordHeaderSize))
+	if err != nil {
+		return fmt.Errorf("read log %d: %w", index, err)
+	}
+	return decodeEntry(payload, entry)
+}
+
+// append writes the given entries to the end of the segment.
+func (s *segment) append(entries []*raft.Log) error {
+	var buf []byte
+	offsets := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		offsets = append(offsets, s.size+int64(len(buf)))
+		buf = appendRecord(buf, entry)
+	}
+	if _, err := s.file.WriteAt(buf, s.size); err != nil {
+		return fmt.Errorf("write segment: %w", err)
+	}
+	s.offsets = append(s.offsets, offsets...)
+	s.size += int64(len(buf))
+	return nil
+}
+
+// truncate removes the entry with the given index and every entry after it.
+func (s *segment) truncate(index uint64) error {
+	offset := s.offsets[index-s.base]
+	if err := s.file.Truncate(offset); err != nil {
+		return fmt.Errorf("truncate segment: %w", err)
+	}
+	s.offsets = s.offsets[:index-s.base]
+	s.size = offset
+	return nil
+}
+
+// remove closes and deletes the segment file.
+func (s *segment) remove() error {
+	s.file.Close()
+	if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove segment: %w", err)
+	}
+	return nil
+}
+
+// appendRecord appends the framed encoding of the entry to buf.
+func appendRecord(buf []byte, entry *raft.Log) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, recordHeaderSize)...)
+	buf = binary.BigEndian.AppendUint64(buf, entry.Index)
+	buf = binary.BigEndian.AppendUint64(buf, entry.Term)
+	buf = append(buf, byte(entry.Type))
+	var appendedAt int64
+	if !entry.AppendedAt.IsZero() {
+		appendedAt = entry.AppendedAt.UnixNano()
+	}
+	buf = binary.BigEndian.AppendUint64(buf, uint64(appendedAt))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(entry.Data)))
+	buf = append(buf, entry.Data...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(entry.Extensions)))
+	buf = append(buf, entry.Extensions...)
+	payload := buf[start+recordHeaderSize:]
+	binary.BigEndian.PutUint32(buf[start:], uint32(len(payload)))
+	binary.BigEndian.PutUint32(buf[start+4:], crc32.Checksum(payload, crcTable))
+	return buf
+}
+
+// decodeEntry decodes an entry from a record payload.
+func decodeEntry(payload []byte, entry *raft.Log) error {
+	if len(payload) < entryHeaderSize+4 {
+		return fmt.Errorf("entry too short")
+	}
+	entry.Index = binary.BigEndian.Uint64(payload[0:8])
+	entry.Term = binary.BigEndian.Uint64(payload[8:16])
+	entry.Type = raft.LogType(payload[16])
+	entry.AppendedAt = time.Time{}
+	if appendedAt := int64(binary.BigEndian.Uint64(payload[17:25])); appendedAt != 0 {
+		entry.AppendedAt = time.Unix(0, appendedAt)
+	}
+	rest := payload[25:]
+	dataLen := binary.BigEndian.Uint32(rest[0:4])
+	rest = rest[4:]
+	if uint64(len(rest)) < uint64(dataLen)+4 {
+		return fmt.Errorf("entry data truncated")
+	}
+	entry.Data = rest[:dataLen:dataLen]
+	rest = rest[dataLen:]
+	extLen := binary.BigEndian.Uint32(rest[0:4])
+	rest = rest[4:]
+	if uint64(len(rest)) != uint64(extLen) {
+		return fmt.Errorf("entry extensions truncated")
+	}
+	entry.Extensions = nil
+	if extLen > 0 {
+		entry.Extensions = rest
+	}
+	return nil
+}
@@ -0,0 +1,568 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package wal implements a segmented write-ahead log for raft logs.
+//
+// Entries are appended to segment files that are rotated once they reach a
+// configured size. Compacting the head of the log removes whole segments and
+// truncating the tail truncates the segment holding the first removed entry,
+// so neither rewrites entries that are kept. Stable state and the logical
+// first index are kept in a small meta file that is replaced atomically.
+package wal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// SyncPolicy is when appended entries are flushed to disk.
+type SyncPolicy string
+
+const (
+	// SyncAlways flushes every append before it returns.
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval flushes appends in the background at a fixed interval.
+	// Entries appended since the last flush can be lost on a crash.
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leaves flushing to the operating system.
+	SyncNever SyncPolicy = "never"
+)
+
+const (
+	// DefaultSegmentSize is the default size at which segments are rotated.
+	DefaultSegmentSize = 64 * 1024 * 1024
+	// DefaultSyncInterval is the default interval for SyncInterval.
+	DefaultSyncInterval = 100 * time.Millisecond
+)
+
+// metaFile is the name of the file holding stable state.
+const metaFile = "meta.json"
+
+// ErrClosed is returned when the log is used after it was closed.
+var ErrClosed = errors.New("wal is closed")
+
+// Options are options for the write-ahead log.
+type Options struct {
+	// SegmentSize is the size in bytes at which segments are rotated.
+	// Defaults to DefaultSegmentSize.
+	SegmentSize int64
+	// Sync is when appended entries are flushed to disk. Defaults to
+	// SyncAlways.
+	Sync SyncPolicy
+	// SyncInterval is how often appends are flushed with SyncInterval.
+	// Defaults to DefaultSyncInterval.
+	SyncInterval time.Duration
+}
+
+// Validate returns an error if the options are invalid.
+func (o Options) Validate() error {
+	if o.SegmentSize < 0 {
+		return fmt.Errorf("segment size must not be negative")
+	}
+	if o.SyncInterval < 0 {
+		return fmt.Errorf("sync interval must not be negative")
+	}
+	switch o.Sync {
+	case "", SyncAlways, SyncInterval, SyncNever:
+		return nil
+	default:
+		return fmt.Errorf("unknown sync policy %q", o.Sync)
+	}
+}
+
+// meta is the content of the meta file.
+type meta struct {
+	// FirstIndex is the first index of the log after its head was compacted
+	// in the middle of a segment. Entries before it are ignored.
+	FirstIndex uint64 `json:"firstIndex,omitempty"`
+	// Stable is the stable store.
+	Stable map[string][]byte `json:"stable,omitempty"`
+}
+
+// WAL is a segmented write-ahead log. It implements raft.LogStore and
+// raft.StableStore.
+type WAL struct {
+	dir      string
+	opts     Options
+	mu       sync.RWMutex
+	segments []*segment
+	first    uint64
+	meta     meta
+	dirty    bool
+	closed   bool
+	closeCh  chan struct{}
+	doneCh   chan struct{}
+}
+
+// Open opens or creates the write-ahead log in the given directory. A torn
+// record at the end of the last segment, left by a crash in the middle of an
+// append, is truncated away.
+func Open(dir string, opts Options) (*WAL, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if opts.SegmentSize == 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.Sync == "" {
+		opts.Sync = SyncAlways
+	}
+	if opts.SyncInterval == 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("create wal directory: %w", err)
+	}
+	w := &WAL{dir: dir, opts: opts}
+	if err := w.load(); err != nil {
+		for _, seg := range w.segments {
+			seg.file.Close()
+		}
+		return nil, err
+	}
+	if opts.Sync == SyncInterval {
+		w.closeCh, w.doneCh = w.runSyncer()
+	}
+	return w, nil
+}
+
+// load reads the meta file and indexes the segments in the directory.
+func (w *WAL) load() error {
+	data, err := os.ReadFile(filepath.Join(w.dir, metaFile))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read wal meta: %w", err)
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &w.meta); err != nil {
+			return fmt.Errorf("decode wal meta: %w", err)
+		}
+	}
+	if w.meta.Stable == nil {
+		w.meta.Stable = make(map[string][]byte)
+	}
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return fmt.Errorf("read wal directory: %w", err)
+	}
+	var bases []uint64
+	for _, entry := range entries {
+		if base, ok := parseSegmentName(entry.Name()); ok && !entry.IsDir() {
+			bases = append(bases, base)
+		}
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	for i, base := range bases {
+		seg, err := openSegment(segmentPath(w.dir, base), base)
+		if err != nil {
+			if !errors.Is(err, errTorn) || i != len(bases)-1 {
+				if seg != nil {
+					seg.file.Close()
+				}
+				return err
+			}
+			if err := seg.file.Truncate(seg.size); err != nil {
+				seg.file.Close()
+				return fmt.Errorf("truncate torn record: %w", err)
+			}
+		}
+		if len(seg.offsets) == 0 {
+			// A segment created right before a crash or emptied by a
+			// torn record.
+			if err := seg.remove(); err != nil {
+				return err
+			}
+			continue
+		}
+		if len(w.segments) > 0 && seg.base != w.lastIndex()+1 {
+			seg.file.Close()
+			return fmt.Errorf("segment %s does not follow index %d", filepath.Base(seg.path), w.lastIndex())
+		}
+		w.segments = append(w.segments, seg)
+	}
+	if len(w.segments) == 0 {
+		return nil
+	}
+	w.first = max(w.meta.FirstIndex, w.segments[0].base)
+	if w.first > w.lastIndex() {
+		return w.reset()
+	}
+	// Remove segments left behind by a compaction interrupted after the
+	// meta file was written.
+	return w.removeBefore(w.first)
+}
+
+// FirstIndex returns the first index written. 0 for no entries.
+func (w *WAL) FirstIndex() (uint64, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return 0, ErrClosed
+	}
+	return w.first, nil
+}
+
+// LastIndex returns the last index written. 0 for no entries.
+func (w *WAL) LastIndex() (uint64, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return 0, ErrClosed
+	}
+	return w.lastIndex(), nil
+}
+
+// GetLog gets a log entry at a given index.
+func (w *WAL) GetLog(index uint64, log *raft.Log) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrClosed
+	}
+	if w.first == 0 || index < w.first || index > w.lastIndex() {
+		return raft.ErrLogNotFound
+	}
+	i := sort.Search(len(w.segments), func(i int) bool { return w.segments[i].base > index }) - 1
+	return w.segments[i].get(index, log)
+}
+
+// StoreLog stores a log entry.
+func (w *WAL) StoreLog(log *raft.Log) error {
+	return w.StoreLogs([]*raft.Log{log})
+}
+
+// StoreLogs stores multiple log entries. Entries must directly follow the
+// last entry in the log.
+func (w *WAL) StoreLogs(logs []*raft.Log) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	next := logs[0].Index
+	if w.first != 0 && next != w.lastIndex()+1 {
+		return fmt.Errorf("log index %d does not follow last index %d", next, w.lastIndex())
+	}
+	for i, log := range logs {
+		if log.Index != next+uint64(i) {
+			return fmt.Errorf("log index %d is out of order", log.Index)
+		}
+	}
+	var active *segment
+	if len(w.segments) > 0 {
+		active = w.segments[len(w.segments)-1]
+	}
+	if active == nil || active.size >= w.opts.SegmentSize {
+		seg, err := w.rotate(active, next)
+		if err != nil {
+			return err
+		}
+		active = seg
+	}
+	if err := active.append(logs); err != nil {
+		return err
+	}
+	if w.first == 0 {
+		w.first = next
+	}
+	switch w.opts.Sync {
+	case SyncAlways:
+		if err := active.file.Sync(); err != nil {
+			return fmt.Errorf("sync segment: %w", err)
+		}
+	case SyncInterval:
+		w.dirty = true
+	}
+	return nil
+}
+
+// DeleteRange deletes a range of log entries. The range is inclusive and
+// must include either the first or the last entry in the log.
+func (w *WAL) DeleteRange(min, max uint64) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	last := w.lastIndex()
+	if w.first == 0 || max < w.first || min > last {
+		return nil
+	}
+	switch {
+	case min <= w.first && max >= last:
+		return w.reset()
+	case min <= w.first:
+		// Record the new first index before removing any segments, so
+		// a crash in between leaves segments that are ignored.
+		w.meta.FirstIndex = max + 1
+		if err := w.writeMeta(); err != nil {
+			return err
+		}
+		w.first = max + 1
+		return w.removeBefore(w.first)
+	case max >= last:
+		return w.truncate(min)
+	default:
+		return fmt.Errorf("cannot delete logs %d to %d from the middle of the log", min, max)
+	}
+}
+
+// Set sets a key in the stable store.
+func (w *WAL) Set(key []byte, val []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return ErrClosed
+	}
+	w.meta.Stable[string(key)] = append([]byte(nil), val...)
+	return w.writeMeta()
+}
+
+// Get returns the value for key, or a nil byte slice if key was not found.
+func (w *WAL) Get(key []byte) ([]byte, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return nil, ErrClosed
+	}
+	val, ok := w.meta.Stable[string(key)]
+	if !ok {
+		return nil, nil
+	}
+	return append([]byte(nil), val...), nil
+}
+
+// SetUint64 sets a key in the stable store to a uint64 value. Values are
+// stored in decimal like the badger stable store, so they can be migrated
+// between the two as is.
+func (w *WAL) SetUint64(key []byte, val uint64) error {
+	return w.Set(key, []byte(strconv.FormatUint(val, 10)))
+}
+
+// GetUint64 returns the uint64 value for key, or 0 if key was not found.
+func (w *WAL) GetUint64(key []byte) (uint64, error) {
+	val, err := w.Get(key)
+	if err != nil || val == nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(val), 10, 64)
+}
+
+// Stats returns the number and total size of the segments along with the
+// range of the log.
+func (w *WAL) Stats() map[string]any {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	var size int64
+	for _, seg := range w.segments {
+		size += seg.size
+	}
+	return map[string]any{
+		"backend":    "wal",
+		"segments":   len(w.segments),
+		"totalSize":  size,
+		"sync":       string(w.opts.Sync),
+		"firstIndex": w.first,
+		"lastIndex":  w.lastIndex(),
+	}
+}
+
+// Close flushes and closes every segment.
+func (w *WAL) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+	if w.closeCh != nil {
+		close(w.closeCh)
+		<-w.doneCh
+	}
+	var err error
+	for _, seg := range w.segments {
+		if serr := seg.file.Sync(); serr != nil && err == nil {
+			err = fmt.Errorf("sync segment: %w", serr)
+		}
+		if cerr := seg.file.Close(); cerr != nil && err == nil {
+			err = fmt.Errorf("close segment: %w", cerr)
+		}
+	}
+	return err
+}
+
+// lastIndex returns the last index in the log. The lock must be held.
+func (w *WAL) lastIndex() uint64 {
+	if len(w.segments) == 0 {
+		return 0
+	}
+	return w.segments[len(w.segments)-1].last()
+}
+
+// rotate flushes the active segment and creates a new one starting at the
+// given index.
+func (w *WAL) rotate(active *segment, base uint64) (*segment, error) {
+	if active != nil && w.opts.Sync != SyncNever {
+		if err := active.file.Sync(); err != nil {
+			return nil, fmt.Errorf("sync segment: %w", err)
+		}
+	}
+	seg, err := createSegment(w.dir, base)
+	if err != nil {
+		return nil, err
+	}
+	if w.opts.Sync == SyncAlways {
+		if err := syncDir(w.dir); err != nil {
+			seg.remove()
+			return nil, err
+		}
+	}
+	w.segments = append(w.segments, seg)
+	return seg, nil
+}
+
+// removeBefore removes the segments holding only entries before the given
+// index.
+func (w *WAL) removeBefore(index uint64) error {
+	for len(w.segments) > 0 && w.segments[0].last() < index {
+		if err := w.segments[0].remove(); err != nil {
+			return err
+		}
+		w.segments = w.segments[1:]
+	}
+	return nil
+}
+
+// truncate removes the entry with the given index and every entry after it.
+func (w *WAL) truncate(index uint64) error {
+	for len(w.segments) > 0 {
+		seg := w.segments[len(w.segments)-1]
+		if seg.last() < index {
+			return nil
+		}
+		if seg.base < index {
+			if err := seg.truncate(index); err != nil {
+				return err
+			}
+			if err := seg.file.Sync(); err != nil {
+				return fmt.Errorf("sync segment: %w", err)
+			}
+			return nil
+		}
+		if err := seg.remove(); err != nil {
+			return err
+		}
+		w.segments = w.segments[:len(w.segments)-1]
+	}
+	return nil
+}
+
+// reset removes every entry in the log.
+func (w *WAL) reset() error {
+	for _, seg := range w.segments {
+		if err := seg.remove(); err != nil {
+			return err
+		}
+	}
+	w.segments = nil
+	w.first = 0
+	if w.meta.FirstIndex == 0 {
+		return nil
+	}
+	w.meta.FirstIndex = 0
+	return w.writeMeta()
+}
+
+// writeMeta atomically replaces the meta file.
+func (w *WAL) writeMeta() error {
+	data, err := json.Marshal(w.meta)
+	if err != nil {
+		return fmt.Errorf("encode wal meta: %w", err)
+	}
+	path := filepath.Join(w.dir, metaFile)
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("create wal meta: %w", err)
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write wal meta: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("replace wal meta: %w", err)
+	}
+	return syncDir(w.dir)
+}
+
+// runSyncer periodically flushes the active segment when it has unflushed
+// appends.
+func (w *WAL) runSyncer() (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(w.opts.SyncInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closeCh:
+				return
+			case <-ticker.C:
+				w.mu.Lock()
+				if w.dirty && !w.closed && len(w.segments) > 0 {
+					// Errors surface on the next flush or on close.
+					_ = w.segments[len(w.segments)-1].file.Sync()
+					w.dirty = false
+				}
+				w.mu.Unlock()
+			}
+		}
+	}()
+	return
+}
+
+// syncDir flushes directory entries so created, renamed, and removed files
+// survive a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open wal directory: %w", err)
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("sync wal directory: %w", err)
+	}
+	return nil
+}
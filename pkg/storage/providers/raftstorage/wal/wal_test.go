@@ -0,0 +1,171 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/raft"
+)
+
+func TestWAL(t *testing.T) {
+	t.Parallel()
+	dir := t.TempDir()
+	// Small segments so the test spans several of them.
+	opts := Options{SegmentSize: 256}
+	w, err := Open(dir, opts)
+	if err != nil {
+		t.Fatalf("open wal: %v", err)
+	}
+	defer func() { w.Close() }()
+
+	logs := make([]*raft.Log, 0, 100)
+	for i := uint64(1); i <= 100; i++ {
+		logs = append(logs, &raft.Log{Index: i, Term: 1, Type: raft.LogCommand, Data: []byte(fmt.Sprintf("entry-%d", i))})
+	}
+	for i := 0; i < len(logs); i += 10 {
+		if err := w.StoreLogs(logs[i : i+10]); err != nil {
+			t.Fatalf("store logs: %v", err)
+		}
+	}
+	if len(w.segments) < 2 {
+		t.Fatalf("expected segments to be rotated, got %d", len(w.segments))
+	}
+	if err := w.StoreLog(&raft.Log{Index: 200, Term: 1}); err == nil {
+		t.Fatal("expected an error storing a log that does not follow the last index")
+	}
+	if err := w.SetUint64([]byte("CurrentTerm"), 5); err != nil {
+		t.Fatalf("set stable key: %v", err)
+	}
+
+	// Compact the head in the middle of a segment and truncate the tail.
+	if err := w.DeleteRange(1, 25); err != nil {
+		t.Fatalf("delete head: %v", err)
+	}
+	if err := w.DeleteRange(91, 100); err != nil {
+		t.Fatalf("delete tail: %v", err)
+	}
+	if err := w.DeleteRange(40, 50); err == nil {
+		t.Fatal("expected an error deleting from the middle of the log")
+	}
+	checkRange := func(w *WAL, first, last uint64) {
+		t.Helper()
+		if got, _ := w.FirstIndex(); got != first {
+			t.Fatalf("expected first index %d, got %d", first, got)
+		}
+		if got, _ := w.LastIndex(); got != last {
+			t.Fatalf("expected last index %d, got %d", last, got)
+		}
+		for _, index := range []uint64{first - 1, last + 1} {
+			var entry raft.Log
+			if err := w.GetLog(index, &entry); !errors.Is(err, raft.ErrLogNotFound) {
+				t.Fatalf("expected log %d to be missing, got %v", index, err)
+			}
+		}
+		for index := first; index <= last; index++ {
+			var entry raft.Log
+			if err := w.GetLog(index, &entry); err != nil {
+				t.Fatalf("get log %d: %v", index, err)
+			}
+			if entry.Index != index || !bytes.Equal(entry.Data, logs[index-1].Data) {
+				t.Fatalf("unexpected log at index %d: %+v", index, entry)
+			}
+		}
+	}
+	checkRange(w, 26, 90)
+
+	// Appends continue after the truncated tail and survive a reopen.
+	if err := w.StoreLogs([]*raft.Log{{Index: 91, Term: 2, Data: logs[90].Data}}); err != nil {
+		t.Fatalf("store log after truncation: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("close wal: %v", err)
+	}
+	if err := w.GetLog(30, &raft.Log{}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected closed error, got %v", err)
+	}
+	w, err = Open(dir, opts)
+	if err != nil {
+		t.Fatalf("reopen wal: %v", err)
+	}
+	checkRange(w, 26, 91)
+	if term, err := w.GetUint64([]byte("CurrentTerm")); err != nil || term != 5 {
+		t.Fatalf("expected stable key to be persisted, got %d: %v", term, err)
+	}
+
+	// A torn append at the end of the log is truncated away on open.
+	last := w.segments[len(w.segments)-1]
+	if err := w.Close(); err != nil {
+		t.Fatalf("close wal: %v", err)
+	}
+	f, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatalf("open segment: %v", err)
+	}
+	torn := appendRecord(nil, &raft.Log{Index: 92, Term: 2, Data: []byte("torn")})
+	if _, err := f.Write(torn[:len(torn)-2]); err != nil {
+		t.Fatalf("write torn record: %v", err)
+	}
+	f.Close()
+	w, err = Open(dir, opts)
+	if err != nil {
+		t.Fatalf("reopen wal with torn record: %v", err)
+	}
+	checkRange(w, 26, 91)
+	if err := w.StoreLog(&raft.Log{Index: 92, Term: 2, Data: []byte("whole")}); err != nil {
+		t.Fatalf("store log after torn record: %v", err)
+	}
+
+	// Deleting everything empties the log, and appends may then start at
+	// any index, as they do after installing a snapshot.
+	if err := w.DeleteRange(26, 92); err != nil {
+		t.Fatalf("delete all: %v", err)
+	}
+	if first, _ := w.FirstIndex(); first != 0 {
+		t.Fatalf("expected an empty log, got first index %d", first)
+	}
+	if err := w.StoreLog(&raft.Log{Index: 500, Term: 3}); err != nil {
+		t.Fatalf("store log after reset: %v", err)
+	}
+	if first, _ := w.FirstIndex(); first != 500 {
+		t.Fatalf("expected first index 500, got %d", first)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name    string
+		opts    Options
+		wantErr bool
+	}{
+		{name: "defaults", opts: Options{}},
+		{name: "interval", opts: Options{Sync: SyncInterval, SyncInterval: DefaultSyncInterval}},
+		{name: "unknown sync policy", opts: Options{Sync: "sometimes"}, wantErr: true},
+		{name: "negative segment size", opts: Options{SegmentSize: -1}, wantErr: true},
+	}
+	for _, tt := range tc {
+		err := tt.opts.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tt.name, tt.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shardedstorage
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	storageerrors "github.com/webmeshproj/webmesh/pkg/storage/errors"
+)
+
+// A batch spanning shards is committed in two phases. Each shard first stages
+// its part of the batch under a batch intent key, which leaves its data
+// untouched. Once every shard has staged its part, a commit record naming the
+// shards is written to the meta shard. That write is the commit point: before
+// it the batch is discarded, after it the batch is rolled forward. Each shard
+// then applies its staged writes and drops its intent in one batch, and the
+// commit record is removed last.
+
+// abandonedBatchAge is how old a batch intent without a commit record must be
+// before it is treated as left behind by a failed writer and removed.
+const abandonedBatchAge = time.Minute
+
+// batchIntentsPrefix holds the writes each shard stages for a batch.
+var batchIntentsPrefix = storage.ShardedBatchesPrefix.ForString("intents")
+
+// batchCommitsPrefix holds the commit records on the meta shard.
+var batchCommitsPrefix = storage.ShardedBatchesPrefix.ForString("commits")
+
+// newBatchID returns a new batch ID. It starts with the time it was created
+// so abandoned intents can be recognized.
+func newBatchID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 16) + "-" + strconv.FormatUint(uint64(rand.Uint32()), 16)
+}
+
+// batchCreated returns the time the given batch was created.
+func batchCreated(id string) (time.Time, bool) {
+	created, _, _ := strings.Cut(id, "-")
+	nanos, err := strconv.ParseInt(created, 16, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// writeShardedBatch commits writes spanning more than one shard, so that
+// either all of them are applied or none of them are. The caller must hold
+// the batch lock.
+func (r *Router) writeShardedBatch(ctx context.Context, byShard map[int][]storage.WriteOp) error {
+	if err := r.recoverBatches(ctx); err != nil {
+		return fmt.Errorf("recover earlier batches: %w", err)
+	}
+	shards := make([]int, 0, len(byShard))
+	for i := range byShard {
+		shards = append(shards, i)
+	}
+	sort.Ints(shards)
+	id := newBatchID()
+	intent := batchIntentsPrefix.ForString(id)
+	for n, i := range shards {
+		data, err := storage.MarshalWriteOps(byShard[i])
+		if err == nil {
+			err = r.shards[i].PutValue(ctx, intent, data, 0)
+		}
+		if err != nil {
+			r.abortBatch(ctx, id, shards[:n+1])
+			return fmt.Errorf("stage batch on shard %d: %w", i, err)
+		}
+	}
+	record := make([]string, len(shards))
+	for n, i := range shards {
+		record[n] = strconv.Itoa(i)
+	}
+	err := r.shards[MetaShard].PutValue(ctx, batchCommitsPrefix.ForString(id), []byte(strings.Join(record, ",")), 0)
+	if err != nil {
+		if delErr := r.shards[MetaShard].Delete(ctx, batchCommitsPrefix.ForString(id)); delErr == nil {
+			r.abortBatch(ctx, id, shards)
+		}
+		return fmt.Errorf("commit batch: %w", err)
+	}
+	if err := r.finishBatch(ctx, id, shards); err != nil {
+		return fmt.Errorf("batch is committed but not yet applied on every shard, the next batch spanning shards finishes it: %w", err)
+	}
+	return nil
+}
+
+// abortBatch removes the intents of a batch that was never committed. Any
+// intent left behind is removed once it is abandoned.
+func (r *Router) abortBatch(ctx context.Context, id string, shards []int) {
+	for _, i := range shards {
+		_ = r.shards[i].Delete(ctx, batchIntentsPrefix.ForString(id))
+	}
+}
+
+// finishBatch applies the staged writes of a committed batch on the given
+// shards and removes its commit record. Shards that no longer hold an intent
+// for the batch have already applied it.
+func (r *Router) finishBatch(ctx context.Context, id string, shards []int) error {
+	intent := batchIntentsPrefix.ForString(id)
+	for _, i := range shards {
+		if i < 0 || i >= len(r.shards) {
+			return fmt.Errorf("batch %s names unknown shard %d", id, i)
+		}
+		data, err := r.shards[i].GetValue(ctx, intent)
+		if err != nil {
+			if storageerrors.IsKeyNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("read batch intent on shard %d: %w", i, err)
+		}
+		ops, err := storage.UnmarshalWriteOps(data)
+		if err != nil {
+			return fmt.Errorf("decode batch intent on shard %d: %w", i, err)
+		}
+		ops = append(ops, storage.WriteOp{Key: intent, Delete: true})
+		if err := r.shards[i].WriteBatch(ctx, ops); err != nil {
+			return fmt.Errorf("apply batch on shard %d: %w", i, err)
+		}
+	}
+	return r.shards[MetaShard].Delete(ctx, batchCommitsPrefix.ForString(id))
+}
+
+// recoverBatches finishes every committed batch that was not applied on all
+// of its shards, and removes abandoned intents of batches never committed.
+func (r *Router) recoverBatches(ctx context.Context) error {
+	commits, err := r.shards[MetaShard].ListKeys(ctx, batchCommitsPrefix)
+	if err != nil {
+		return fmt.Errorf("list batch commits: %w", err)
+	}
+	for _, key := range commits {
+		id := string(batchCommitsPrefix.TrimFrom(key))
+		data, err := r.shards[MetaShard].GetValue(ctx, key)
+		if err != nil {
+			return fmt.Errorf("read batch commit %s: %w", id, err)
+		}
+		var shards []int
+		for _, field := range strings.Split(string(data), ",") {
+			i, err := strconv.Atoi(field)
+			if err != nil {
+				return fmt.Errorf("decode batch commit %s: %w", id, err)
+			}
+			shards = append(shards, i)
+		}
+		if err := r.finishBatch(ctx, id, shards); err != nil {
+			return err
+		}
+	}
+	for i, shard := range r.shards {
+		intents, err := shard.ListKeys(ctx, batchIntentsPrefix)
+		if err != nil {
+			return fmt.Errorf("list batch intents on shard %d: %w", i, err)
+		}
+		for _, key := range intents {
+			created, ok := batchCreated(string(batchIntentsPrefix.TrimFrom(key)))
+			if ok && time.Since(created) < abandonedBatchAge {
+				// The batch may still be committed by its writer.
+				continue
+			}
+			if err := shard.Delete(ctx, key); err != nil {
+				return fmt.Errorf("remove abandoned batch intent on shard %d: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,389 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package shardedstorage provides a storage provider that partitions the mesh
+// registry across several raft groups for meshes too large for a single group.
+// Every storage member runs every shard, and the leader of the meta shard is
+// made the leader of all shards so writes can be forwarded to a single node.
+package shardedstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/logging"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	storerrors "github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/fsm"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage/raftlogs"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Ensure we satisfy the provider interface.
+var _ storage.Provider = &Provider{}
+var _ storage.Consensus = &Consensus{}
+var _ storage.LeadershipTransferer = &Consensus{}
+var _ storage.RaftLogFormatProvider = &Provider{}
+
+// DefaultColocateInterval is the default interval at which shard leaders hand
+// leadership to the leader of the meta shard.
+const DefaultColocateInterval = time.Second
+
+// Options are the options for the sharded storage provider.
+type Options struct {
+	// Shards are the options for the raft group of each shard. The first is
+	// the meta shard. Every storage member must run the same number of shards,
+	// and shard i of a member must listen on the raft port of its meta shard
+	// plus i. The number of shards cannot be changed once bootstrapped.
+	Shards []raftstorage.Options
+	// ColocateInterval is how often shard leaders hand leadership to the
+	// leader of the meta shard. Defaults to DefaultColocateInterval.
+	ColocateInterval time.Duration
+}
+
+// Provider is a storage provider that routes keys across raft groups.
+type Provider struct {
+	Options
+	shards     []*raftstorage.Provider
+	router     *Router
+	meshDB     storage.MeshDB
+	consensus  *Consensus
+	colocClose chan struct{}
+	colocDone  chan struct{}
+	log        *slog.Logger
+	mu         sync.Mutex
+}
+
+// NewProvider returns a new sharded storage provider. Graph snapshots and
+// invariant checks only see the keys of a single shard, so they are disabled
+// on every shard.
+func NewProvider(opts Options) *Provider {
+	if opts.ColocateInterval <= 0 {
+		opts.ColocateInterval = DefaultColocateInterval
+	}
+	p := &Provider{Options: opts}
+	shardStorage := make([]storage.MeshStorage, len(opts.Shards))
+	for i, o := range opts.Shards {
+		o.GraphSnapshotInterval = 0
+		o.CheckInvariants = fsm.InvariantsOff
+		shard := raftstorage.NewProvider(o)
+		p.shards = append(p.shards, shard)
+		shardStorage[i] = shard.MeshStorage()
+	}
+	p.router = NewRouter(shardStorage...)
+	p.meshDB = meshdb.NewFromStorage(p.router)
+	p.consensus = &Consensus{Provider: p}
+	var level, format string
+	if len(opts.Shards) > 0 {
+		level, format = opts.Shards[MetaShard].LogLevel, opts.Shards[MetaShard].LogFormat
+	}
+	p.log = logging.NewLogger(level, format).With("component", "shardedstorage")
+	return p
+}
+
+// Shards returns the number of shards.
+func (p *Provider) Shards() int {
+	return len(p.shards)
+}
+
+// Shard returns the raft storage of the given shard.
+func (p *Provider) Shard(i int) *raftstorage.Provider {
+	return p.shards[i]
+}
+
+// ShardForLog returns the shard a raft log entry forwarded by another member
+// must be applied to. Batches are always split by shard before they are sent.
+func (p *Provider) ShardForLog(entry *v1.RaftLogEntry) (*raftstorage.Provider, error) {
+	key := entry.GetKey()
+	if entry.GetType() == raftlogs.CommandBatch {
+		ops, err := raftlogs.DecodeBatch(entry)
+		if err != nil {
+			return nil, err
+		}
+		if len(ops) > 0 {
+			key = ops[0].Key
+		}
+	}
+	return p.shards[KeyShard(key, len(p.shards))], nil
+}
+
+// OnObservation registers a callback for observations of the meta shard.
+func (p *Provider) OnObservation(cb raftstorage.ObservationCallback) {
+	p.shards[MetaShard].OnObservation(cb)
+}
+
+// MeshStorage returns the MeshStorage routing keys across shards.
+func (p *Provider) MeshStorage() storage.MeshStorage {
+	return p.router
+}
+
+// MeshDB returns the MeshDB over all shards.
+func (p *Provider) MeshDB() storage.MeshDB {
+	return p.meshDB
+}
+
+// Consensus returns the Consensus managing membership of all shards.
+func (p *Provider) Consensus() storage.Consensus {
+	return p.consensus
+}
+
+// ListenPort returns the raft port of the meta shard.
+func (p *Provider) ListenPort() uint16 {
+	return p.shards[MetaShard].ListenPort()
+}
+
+// RaftLogFormat returns the log format of the meta shard. Every shard is
+// configured with the same format.
+func (p *Provider) RaftLogFormat() string {
+	return p.shards[MetaShard].RaftLogFormat()
+}
+
+// Status returns the status of the meta shard.
+func (p *Provider) Status() *v1.StorageStatus {
+	return p.shards[MetaShard].Status()
+}
+
+// Start starts every shard.
+func (p *Provider) Start(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.shards) == 0 {
+		return fmt.Errorf("no shards configured")
+	}
+	for i, shard := range p.shards {
+		if err := shard.Start(ctx); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				_ = p.shards[j].Close()
+			}
+			return fmt.Errorf("start shard %d: %w", i, err)
+		}
+	}
+	p.colocClose, p.colocDone = p.runColocation()
+	return nil
+}
+
+// Bootstrap bootstraps every shard. ErrAlreadyBootstrapped is returned if the
+// meta shard was already bootstrapped.
+func (p *Provider) Bootstrap(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var bootstrapErr error
+	for i, shard := range p.shards {
+		err := shard.Bootstrap(ctx)
+		if storerrors.IsAlreadyBootstrapped(err) {
+			if i == MetaShard {
+				bootstrapErr = err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("bootstrap shard %d: %w", i, err)
+		}
+	}
+	return bootstrapErr
+}
+
+// Close closes every shard.
+func (p *Provider) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.colocClose != nil {
+		close(p.colocClose)
+		<-p.colocDone
+		p.colocClose, p.colocDone = nil, nil
+	}
+	var errs []error
+	for i := len(p.shards) - 1; i >= 0; i-- {
+		if err := p.shards[i].Close(); err != nil {
+			errs = append(errs, fmt.Errorf("close shard %d: %w", i, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// runColocation periodically hands leadership of every shard led by this node
+// to the leader of the meta shard.
+func (p *Provider) runColocation() (closeCh, doneCh chan struct{}) {
+	closeCh = make(chan struct{})
+	doneCh = make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		ticker := time.NewTicker(p.ColocateInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-closeCh:
+				return
+			case <-ticker.C:
+				p.colocate(context.Background())
+			}
+		}
+	}()
+	return
+}
+
+func (p *Provider) colocate(ctx context.Context) {
+	leader, err := p.shards[MetaShard].Consensus().GetLeader(ctx)
+	if err != nil {
+		return
+	}
+	for i, shard := range p.shards {
+		if i == MetaShard || leader.GetId() == shard.Options.NodeID.String() {
+			continue
+		}
+		consensus := shard.Consensus().(*raftstorage.Consensus)
+		if !consensus.IsLeader() {
+			continue
+		}
+		if err := consensus.TransferLeadership(ctx, leader.GetId()); err != nil {
+			p.log.Debug("Failed to hand shard leadership to the meta leader",
+				slog.Int("shard", i),
+				slog.String("leader", leader.GetId()),
+				slog.String("error", err.Error()),
+			)
+		}
+	}
+}
+
+// ShardAddress returns the raft address of the given shard of the member whose
+// meta shard listens on addr.
+func ShardAddress(addr string, shard int) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", fmt.Errorf("invalid port %q: %w", port, err)
+	}
+	if p+shard > 65535 {
+		return "", fmt.Errorf("shard %d port out of range for %s", shard, addr)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(p+shard)), nil
+}
+
+// Consensus manages the membership of every shard. Leadership is that of the
+// meta shard.
+type Consensus struct {
+	*Provider
+}
+
+// IsLeader returns true if the node is the leader of the meta shard.
+func (c *Consensus) IsLeader() bool {
+	return c.shards[MetaShard].Consensus().IsLeader()
+}
+
+// IsMember returns true if the node is a member of the storage group.
+func (c *Consensus) IsMember() bool {
+	return true
+}
+
+// StepDown steps down from leadership of the meta shard. The other shards
+// follow the new leader.
+func (c *Consensus) StepDown(ctx context.Context) error {
+	return c.shards[MetaShard].Consensus().StepDown(ctx)
+}
+
+// TransferLeadership transfers leadership of the meta shard to the voter with
+// the given ID. The other shards follow the new leader.
+func (c *Consensus) TransferLeadership(ctx context.Context, id string) error {
+	return c.shards[MetaShard].Consensus().(*raftstorage.Consensus).TransferLeadership(ctx, id)
+}
+
+// GetPeer returns the peer with the given ID in the meta shard.
+func (c *Consensus) GetPeer(ctx context.Context, id string) (types.StoragePeer, error) {
+	return c.shards[MetaShard].Consensus().GetPeer(ctx, id)
+}
+
+// GetPeers returns the peers of the meta shard.
+func (c *Consensus) GetPeers(ctx context.Context) ([]types.StoragePeer, error) {
+	return c.shards[MetaShard].Consensus().GetPeers(ctx)
+}
+
+// GetLeader returns the leader of the meta shard.
+func (c *Consensus) GetLeader(ctx context.Context) (types.StoragePeer, error) {
+	return c.shards[MetaShard].Consensus().GetLeader(ctx)
+}
+
+// AddVoter adds a voter to every shard.
+func (c *Consensus) AddVoter(ctx context.Context, peer types.StoragePeer) error {
+	return c.forEachShard(peer, func(i int, consensus storage.Consensus, peer types.StoragePeer) error {
+		return consensus.AddVoter(ctx, peer)
+	})
+}
+
+// AddObserver adds an observer to every shard.
+func (c *Consensus) AddObserver(ctx context.Context, peer types.StoragePeer) error {
+	return c.forEachShard(peer, func(i int, consensus storage.Consensus, peer types.StoragePeer) error {
+		return consensus.AddObserver(ctx, peer)
+	})
+}
+
+// AddLearner adds a learner to every shard. The learner is recorded in the
+// meta shard and observes the others.
+func (c *Consensus) AddLearner(ctx context.Context, peer types.StoragePeer) error {
+	return c.forEachShard(peer, func(i int, consensus storage.Consensus, peer types.StoragePeer) error {
+		if i == MetaShard {
+			return consensus.AddLearner(ctx, peer)
+		}
+		return consensus.AddObserver(ctx, peer)
+	})
+}
+
+// DemoteVoter demotes a voter to an observer in every shard.
+func (c *Consensus) DemoteVoter(ctx context.Context, peer types.StoragePeer) error {
+	return c.forEachShard(peer, func(i int, consensus storage.Consensus, peer types.StoragePeer) error {
+		return consensus.DemoteVoter(ctx, peer)
+	})
+}
+
+// RemovePeer removes a peer from every shard.
+func (c *Consensus) RemovePeer(ctx context.Context, peer types.StoragePeer, wait bool) error {
+	return c.forEachShard(peer, func(i int, consensus storage.Consensus, peer types.StoragePeer) error {
+		return consensus.RemovePeer(ctx, peer, wait)
+	})
+}
+
+// forEachShard calls fn with the consensus of every shard and the peer with
+// its address rewritten to that of the shard, starting with the meta shard.
+func (c *Consensus) forEachShard(peer types.StoragePeer, fn func(int, storage.Consensus, types.StoragePeer) error) error {
+	for i, shard := range c.shards {
+		shardPeer := peer
+		if peer.StoragePeer != nil && peer.GetAddress() != "" {
+			addr, err := ShardAddress(peer.GetAddress(), i)
+			if err != nil {
+				return fmt.Errorf("shard %d address: %w", i, err)
+			}
+			shardPeer = types.StoragePeer{StoragePeer: proto.Clone(peer.StoragePeer).(*v1.StoragePeer)}
+			shardPeer.Address = addr
+		}
+		if err := fn(i, shard.Consensus(), shardPeer); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shardedstorage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/webmeshproj/webmesh/pkg/meshnet/transport/tcp"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/raftstorage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	nodeID := types.NodeID(uuid.NewString())
+	var shards []raftstorage.Options
+	for i := 0; i < 3; i++ {
+		transport, err := tcp.NewRaftTransport(nil, tcp.RaftTransportOptions{
+			Addr:    "127.0.0.1:0",
+			MaxPool: 10,
+			Timeout: time.Second,
+		})
+		if err != nil {
+			t.Fatalf("failed to create raft transport: %v", err)
+		}
+		opts := raftstorage.NewOptions(nodeID, transport)
+		opts.InMemory = true
+		opts.HeartbeatTimeout = 500 * time.Millisecond
+		opts.ElectionTimeout = 500 * time.Millisecond
+		opts.LeaderLeaseTimeout = 500 * time.Millisecond
+		shards = append(shards, opts)
+	}
+	p := NewProvider(Options{Shards: shards})
+	if err := p.Start(ctx); err != nil {
+		t.Fatalf("start provider: %v", err)
+	}
+	defer p.Close()
+	if err := p.Bootstrap(ctx); err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	if !p.Consensus().IsLeader() {
+		t.Fatal("expected to lead after bootstrap")
+	}
+
+	for i := 0; i < 8; i++ {
+		key := storage.NodesPrefix.ForString(fmt.Sprintf("node-%d", i))
+		if err := p.MeshStorage().PutValue(ctx, key, []byte("value"), 0); err != nil {
+			t.Fatalf("put value: %v", err)
+		}
+		owner := p.Shard(KeyShard(key, p.Shards()))
+		if _, err := owner.MeshStorage().GetValue(ctx, key); err != nil {
+			t.Fatalf("expected %s on its shard: %v", key, err)
+		}
+	}
+	keys, err := p.MeshStorage().ListKeys(ctx, storage.NodesPrefix)
+	if err != nil {
+		t.Fatalf("list keys: %v", err)
+	}
+	if len(keys) != 8 {
+		t.Fatalf("expected 8 keys across shards, got %d", len(keys))
+	}
+}
+
+func TestShardAddress(t *testing.T) {
+	t.Parallel()
+	addr, err := ShardAddress("10.0.0.1:9000", 2)
+	if err != nil {
+		t.Fatalf("shard address: %v", err)
+	}
+	if addr != "10.0.0.1:9002" {
+		t.Fatalf("expected 10.0.0.1:9002, got %s", addr)
+	}
+	if _, err := ShardAddress("10.0.0.1:65535", 1); err == nil {
+		t.Fatal("expected out of range port to fail")
+	}
+}
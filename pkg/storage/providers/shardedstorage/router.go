@@ -0,0 +1,277 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shardedstorage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Ensure we satisfy the MeshStorage interface.
+var _ storage.MeshStorage = &Router{}
+
+// ShardedPrefixes are the key spaces partitioned across shards. Keys under them
+// are routed by the node ID that follows the prefix, so a node and its outgoing
+// edges always live on the same shard. All other keys live on the meta shard.
+var ShardedPrefixes = []types.StoragePrefix{
+	storage.NodesPrefix,
+	storage.EdgesPrefix,
+}
+
+// MetaShard is the shard holding every key outside of ShardedPrefixes. Its
+// raft group also decides the leader of the sharded storage.
+const MetaShard = 0
+
+// KeyShard returns the shard the given key belongs to out of n shards.
+func KeyShard(key []byte, n int) int {
+	if n <= 1 {
+		return MetaShard
+	}
+	for _, prefix := range ShardedPrefixes {
+		rest, ok := bytes.CutPrefix(key, append(prefix[:len(prefix):len(prefix)], '/'))
+		if !ok {
+			continue
+		}
+		id, _, _ := bytes.Cut(rest, []byte("/"))
+		h := fnv.New32a()
+		h.Write(id)
+		return int(h.Sum32() % uint32(n))
+	}
+	return MetaShard
+}
+
+// prefixShard returns the single shard holding every key under the given prefix,
+// or false if the keys may be spread across shards.
+func prefixShard(prefix []byte, n int) (int, bool) {
+	for _, p := range ShardedPrefixes {
+		keyspace := append(p[:len(p):len(p)], '/')
+		if bytes.HasPrefix(keyspace, prefix) {
+			// The prefix covers the whole key space.
+			return 0, false
+		}
+		if rest, ok := bytes.CutPrefix(prefix, keyspace); ok {
+			if !bytes.Contains(rest, []byte("/")) {
+				// The node ID is not complete, it may match many.
+				return 0, false
+			}
+			return KeyShard(prefix, n), true
+		}
+	}
+	return MetaShard, true
+}
+
+// Router is a MeshStorage that routes keys to the shard that owns them.
+// Operations on prefixes spanning shards are fanned out to every shard.
+// Batches touching several shards are committed in two phases, so they are
+// atomic like batches on a single shard.
+type Router struct {
+	shards []storage.MeshStorage
+	// batchMu serializes batches spanning shards.
+	batchMu sync.Mutex
+}
+
+// NewRouter returns a router over the given shards. The first shard is the
+// meta shard.
+func NewRouter(shards ...storage.MeshStorage) *Router {
+	return &Router{shards: shards}
+}
+
+// Shards returns the number of shards.
+func (r *Router) Shards() int {
+	return len(r.shards)
+}
+
+// ShardFor returns the storage of the shard owning the given key.
+func (r *Router) ShardFor(key []byte) storage.MeshStorage {
+	return r.shards[KeyShard(key, len(r.shards))]
+}
+
+// shardsFor returns the shards that may hold keys under the given prefix.
+func (r *Router) shardsFor(prefix []byte) []storage.MeshStorage {
+	if i, ok := prefixShard(prefix, len(r.shards)); ok {
+		return r.shards[i : i+1]
+	}
+	return r.shards
+}
+
+// GetValue returns the value of a key.
+func (r *Router) GetValue(ctx context.Context, key []byte) ([]byte, error) {
+	return r.ShardFor(key).GetValue(ctx, key)
+}
+
+// GetValueVersion returns the value of a key along with its version.
+func (r *Router) GetValueVersion(ctx context.Context, key []byte) ([]byte, storage.Version, error) {
+	return r.ShardFor(key).GetValueVersion(ctx, key)
+}
+
+// PutValue sets the value of a key.
+func (r *Router) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
+	return r.ShardFor(key).PutValue(ctx, key, value, ttl)
+}
+
+// CompareAndSwap sets the value of a key only if its current version matches the expected one.
+func (r *Router) CompareAndSwap(ctx context.Context, key, value []byte, expected storage.Version, ttl time.Duration) error {
+	return r.ShardFor(key).CompareAndSwap(ctx, key, value, expected, ttl)
+}
+
+// Delete removes a key.
+func (r *Router) Delete(ctx context.Context, key []byte) error {
+	return r.ShardFor(key).Delete(ctx, key)
+}
+
+// ListKeys returns all keys with a given prefix, sorted across shards.
+func (r *Router) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	shards := r.shardsFor(prefix)
+	if len(shards) == 1 {
+		return shards[0].ListKeys(ctx, prefix)
+	}
+	var keys [][]byte
+	for _, shard := range shards {
+		shardKeys, err := shard.ListKeys(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, shardKeys...)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	return keys, nil
+}
+
+// IterPrefix iterates over all keys with a given prefix. Keys are ordered
+// within each shard, but not across shards.
+func (r *Router) IterPrefix(ctx context.Context, prefix []byte, fn storage.PrefixIterator) error {
+	var stopped bool
+	iter := func(key, value []byte) error {
+		if stopped {
+			// Not every backend stops on the first ErrStopIteration.
+			return storage.ErrStopIteration
+		}
+		err := fn(key, value)
+		if err == storage.ErrStopIteration {
+			stopped = true
+		}
+		return err
+	}
+	for _, shard := range r.shardsFor(prefix) {
+		if err := shard.IterPrefix(ctx, prefix, iter); err != nil {
+			return err
+		}
+		if stopped {
+			return nil
+		}
+	}
+	return nil
+}
+
+// Subscribe calls fn whenever a key with the given prefix changes on any shard.
+func (r *Router) Subscribe(ctx context.Context, prefix []byte, fn storage.KVSubscribeFunc) (context.CancelFunc, error) {
+	shards := r.shardsFor(prefix)
+	cancels := make([]context.CancelFunc, 0, len(shards))
+	cancelAll := func() {
+		for _, cancel := range cancels {
+			cancel()
+		}
+	}
+	for _, shard := range shards {
+		cancel, err := shard.Subscribe(ctx, prefix, fn)
+		if err != nil {
+			cancelAll()
+			return func() {}, err
+		}
+		cancels = append(cancels, cancel)
+	}
+	return cancelAll, nil
+}
+
+// Watch streams the keys under a prefix from every shard holding them.
+func (r *Router) Watch(ctx context.Context, prefix []byte) (<-chan storage.WatchEvent, error) {
+	shards := r.shardsFor(prefix)
+	if len(shards) == 1 {
+		return shards[0].Watch(ctx, prefix)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	chans := make([]<-chan storage.WatchEvent, 0, len(shards))
+	for _, shard := range shards {
+		ch, err := shard.Watch(ctx, prefix)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+		chans = append(chans, ch)
+	}
+	out := make(chan storage.WatchEvent)
+	var wg sync.WaitGroup
+	for _, ch := range chans {
+		wg.Add(1)
+		go func(ch <-chan storage.WatchEvent) {
+			defer wg.Done()
+			for ev := range ch {
+				select {
+				case out <- ev:
+				case <-ctx.Done():
+				}
+			}
+		}(ch)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		close(out)
+	}()
+	return out, nil
+}
+
+// WriteBatch applies either all of the given writes or none of them. A batch
+// within one shard is written to it directly, a batch spanning shards is
+// staged on each of them and committed on the meta shard.
+func (r *Router) WriteBatch(ctx context.Context, ops []storage.WriteOp) error {
+	byShard := make(map[int][]storage.WriteOp)
+	for _, op := range ops {
+		i := KeyShard(op.Key, len(r.shards))
+		byShard[i] = append(byShard[i], op)
+	}
+	if len(byShard) > 1 {
+		r.batchMu.Lock()
+		defer r.batchMu.Unlock()
+		return r.writeShardedBatch(ctx, byShard)
+	}
+	for i, shardOps := range byShard {
+		return r.shards[i].WriteBatch(ctx, shardOps)
+	}
+	return nil
+}
+
+// Close closes every shard.
+func (r *Router) Close() error {
+	var errs []error
+	for _, shard := range r.shards {
+		if err := shard.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,273 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shardedstorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+func newTestRouter(t *testing.T, n int) (*Router, []storage.MeshStorage) {
+	t.Helper()
+	shards := make([]storage.MeshStorage, n)
+	for i := range shards {
+		db, err := badgerdb.NewInMemory(badgerdb.Options{})
+		if err != nil {
+			t.Fatalf("create test db: %v", err)
+		}
+		shards[i] = db
+	}
+	r := NewRouter(shards...)
+	t.Cleanup(func() { _ = r.Close() })
+	return r, shards
+}
+
+func TestKeyShard(t *testing.T) {
+	t.Parallel()
+	node := storage.NodesPrefix.ForString("node-a")
+	edge := storage.EdgesPrefix.ForString("node-a/node-b")
+	if KeyShard(node, 8) != KeyShard(edge, 8) {
+		t.Fatal("expected a node and its outgoing edges on the same shard")
+	}
+	if got := KeyShard(storage.RoutesPrefix.ForString("route"), 8); got != MetaShard {
+		t.Fatalf("expected unsharded keys on the meta shard, got %d", got)
+	}
+	if got := KeyShard(node, 1); got != MetaShard {
+		t.Fatalf("expected a single shard to hold every key, got %d", got)
+	}
+	seen := make(map[int]bool)
+	for i := 0; i < 64; i++ {
+		seen[KeyShard(storage.NodesPrefix.ForString(fmt.Sprintf("node-%d", i)), 4)] = true
+	}
+	if len(seen) != 4 {
+		t.Fatalf("expected nodes spread across all shards, got %v", seen)
+	}
+
+	for _, tc := range []struct {
+		prefix []byte
+		single bool
+	}{
+		{storage.NodesPrefix, false},
+		{storage.NodesPrefix.ForString("node-"), false},
+		{storage.EdgesPrefix.ForString("node-a/"), true},
+		{storage.RoutesPrefix, true},
+		{[]byte("/registry"), false},
+	} {
+		if _, ok := prefixShard(tc.prefix, 4); ok != tc.single {
+			t.Errorf("prefix %q: expected single shard %v, got %v", tc.prefix, tc.single, ok)
+		}
+	}
+}
+
+func TestRouter(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	r, shards := newTestRouter(t, 3)
+
+	var ops []storage.WriteOp
+	for i := 0; i < 16; i++ {
+		id := fmt.Sprintf("node-%d", i)
+		ops = append(ops,
+			storage.WriteOp{Key: storage.NodesPrefix.ForString(id), Value: []byte(id)},
+			storage.WriteOp{Key: storage.EdgesPrefix.ForString(id + "/node-0"), Value: []byte(id)},
+		)
+	}
+	ops = append(ops, storage.WriteOp{Key: storage.GraphVersionKey, Value: []byte("1")})
+	if err := r.WriteBatch(ctx, ops); err != nil {
+		t.Fatalf("write batch: %v", err)
+	}
+
+	// Every key is stored only on the shard that owns it.
+	for _, op := range ops {
+		owner := KeyShard(op.Key, 3)
+		for i, shard := range shards {
+			_, err := shard.GetValue(ctx, op.Key)
+			if (err == nil) != (i == owner) {
+				t.Fatalf("key %s: expected only shard %d to hold it, shard %d returned %v", op.Key, owner, i, err)
+			}
+		}
+		if _, err := r.GetValue(ctx, op.Key); err != nil {
+			t.Fatalf("get %s: %v", op.Key, err)
+		}
+	}
+
+	keys, err := r.ListKeys(ctx, storage.NodesPrefix)
+	if err != nil {
+		t.Fatalf("list keys: %v", err)
+	}
+	if len(keys) != 16 {
+		t.Fatalf("expected 16 nodes across shards, got %d", len(keys))
+	}
+	for i := 1; i < len(keys); i++ {
+		if string(keys[i-1]) > string(keys[i]) {
+			t.Fatalf("expected sorted keys, got %q before %q", keys[i-1], keys[i])
+		}
+	}
+
+	var count int
+	err = r.IterPrefix(ctx, storage.EdgesPrefix, func(key, value []byte) error {
+		count++
+		if count == 5 {
+			return storage.ErrStopIteration
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("iterate: %v", err)
+	}
+	if count != 5 {
+		t.Fatalf("expected iteration to stop after 5 keys, got %d", count)
+	}
+
+	// Subscriptions see changes on every shard.
+	changes := make(chan string, 16)
+	cancel, err := r.Subscribe(ctx, storage.NodesPrefix, func(key, value []byte) {
+		changes <- string(key)
+	})
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	defer cancel()
+	want := map[string]bool{}
+	for i := 16; i < 20; i++ {
+		key := storage.NodesPrefix.ForString(fmt.Sprintf("node-%d", i))
+		want[string(key)] = true
+		if err := r.PutValue(ctx, key, []byte("x"), 0); err != nil {
+			t.Fatalf("put value: %v", err)
+		}
+	}
+	timeout := time.After(5 * time.Second)
+	for len(want) > 0 {
+		select {
+		case key := <-changes:
+			delete(want, key)
+		case <-timeout:
+			t.Fatalf("did not receive changes for %v", want)
+		}
+	}
+}
+
+var errShardDown = errors.New("shard down")
+
+// failingShard is a shard whose writes can be made to fail.
+type failingShard struct {
+	storage.MeshStorage
+	failPuts    bool
+	failBatches bool
+}
+
+func (f *failingShard) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
+	if f.failPuts {
+		return errShardDown
+	}
+	return f.MeshStorage.PutValue(ctx, key, value, ttl)
+}
+
+func (f *failingShard) WriteBatch(ctx context.Context, ops []storage.WriteOp) error {
+	if f.failBatches {
+		return errShardDown
+	}
+	return f.MeshStorage.WriteBatch(ctx, ops)
+}
+
+func TestRouterBatchAcrossShards(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	_, shards := newTestRouter(t, 2)
+	second := &failingShard{MeshStorage: shards[1]}
+	r := NewRouter(shards[0], second)
+
+	// nodeOnSecond returns a node key routed to the second shard.
+	var n int
+	nodeOnSecond := func() []byte {
+		for ; ; n++ {
+			key := storage.NodesPrefix.ForString(fmt.Sprintf("node-%d", n))
+			if KeyShard(key, 2) == 1 {
+				n++
+				return key
+			}
+		}
+	}
+	expectKey := func(t *testing.T, shard storage.MeshStorage, key []byte, want bool) {
+		t.Helper()
+		_, err := shard.GetValue(ctx, key)
+		if (err == nil) != want {
+			t.Fatalf("key %s: expected present %v, got %v", key, want, err)
+		}
+	}
+	expectNoStaging := func(t *testing.T) {
+		t.Helper()
+		for i, shard := range shards {
+			keys, err := shard.ListKeys(ctx, storage.ShardedBatchesPrefix)
+			if err != nil {
+				t.Fatalf("list keys: %v", err)
+			}
+			if len(keys) != 0 {
+				t.Fatalf("expected no staged batches on shard %d, got %q", i, keys)
+			}
+		}
+	}
+
+	t.Run("SecondShardFails", func(t *testing.T) {
+		node := nodeOnSecond()
+		second.failPuts = true
+		defer func() { second.failPuts = false }()
+		err := r.WriteBatch(ctx, []storage.WriteOp{
+			{Key: storage.GraphVersionKey, Value: []byte("1")},
+			{Key: node, Value: []byte("node")},
+		})
+		if !errors.Is(err, errShardDown) {
+			t.Fatalf("expected the shard error, got %v", err)
+		}
+		expectKey(t, shards[0], storage.GraphVersionKey, false)
+		expectKey(t, shards[1], node, false)
+		expectNoStaging(t)
+	})
+
+	t.Run("CommittedBatchRollsForward", func(t *testing.T) {
+		node := nodeOnSecond()
+		second.failBatches = true
+		err := r.WriteBatch(ctx, []storage.WriteOp{
+			{Key: storage.GraphVersionKey, Value: []byte("2")},
+			{Key: node, Value: []byte("node")},
+		})
+		second.failBatches = false
+		if !errors.Is(err, errShardDown) {
+			t.Fatalf("expected the shard error, got %v", err)
+		}
+		expectKey(t, shards[1], node, false)
+
+		// The next batch spanning shards finishes the committed one.
+		other := nodeOnSecond()
+		err = r.WriteBatch(ctx, []storage.WriteOp{
+			{Key: storage.RoutesPrefix.ForString("route"), Value: []byte("route")},
+			{Key: other, Value: []byte("other")},
+		})
+		if err != nil {
+			t.Fatalf("write batch: %v", err)
+		}
+		expectKey(t, shards[1], node, true)
+		expectKey(t, shards[1], other, true)
+		expectNoStaging(t)
+	})
+}
@@ -0,0 +1,250 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// QuarantinePrefix is where quarantined nodes are recorded in the database.
+// Records are indexed by node ID in the format /registry/quarantine/<id>.
+var QuarantinePrefix = types.RegistryPrefix.ForString("quarantine")
+
+// QuarantineRecord describes a quarantined node and the state that was withdrawn
+// from it. The node's peer registration and edges are left untouched until it
+// is purged so they can be inspected.
+type QuarantineRecord struct {
+	// NodeID is the ID of the quarantined node.
+	NodeID types.NodeID `json:"nodeID"`
+	// Time is when the node was quarantined.
+	Time time.Time `json:"time"`
+	// Reason is a human readable reason for the quarantine.
+	Reason string `json:"reason,omitempty"`
+	// PublicKey is the public key registered to the node when it was quarantined.
+	// It is revoked for the duration of the quarantine.
+	PublicKey string `json:"publicKey,omitempty"`
+	// Routes are the protojson encoded routes withdrawn from the node.
+	Routes []json.RawMessage `json:"routes,omitempty"`
+}
+
+// QuarantineACLNames returns the names of the network ACLs that isolate the given node.
+func QuarantineACLNames(nodeID types.NodeID) []string {
+	return []string{
+		fmt.Sprintf("quarantine-%s-egress", nodeID),
+		fmt.Sprintf("quarantine-%s-ingress", nodeID),
+	}
+}
+
+// IsQuarantined returns true if the given node is quarantined.
+func IsQuarantined(ctx context.Context, st MeshStorage, nodeID types.NodeID) (bool, error) {
+	_, err := st.GetValue(ctx, QuarantinePrefix.ForString(nodeID.String()))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GetQuarantine returns the quarantine record for the given node.
+func GetQuarantine(ctx context.Context, st MeshStorage, nodeID types.NodeID) (QuarantineRecord, error) {
+	var rec QuarantineRecord
+	data, err := st.GetValue(ctx, QuarantinePrefix.ForString(nodeID.String()))
+	if err != nil {
+		return rec, err
+	}
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return rec, fmt.Errorf("unmarshal quarantine record: %w", err)
+	}
+	return rec, nil
+}
+
+// ListQuarantines returns the quarantine records for all quarantined nodes.
+func ListQuarantines(ctx context.Context, st MeshStorage) ([]QuarantineRecord, error) {
+	var recs []QuarantineRecord
+	err := st.IterPrefix(ctx, append(QuarantinePrefix, '/'), func(key, value []byte) error {
+		var rec QuarantineRecord
+		if err := json.Unmarshal(value, &rec); err != nil {
+			return fmt.Errorf("unmarshal quarantine record %s: %w", key, err)
+		}
+		recs = append(recs, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(recs, func(i, j int) bool { return recs[i].NodeID < recs[j].NodeID })
+	return recs, nil
+}
+
+// QuarantineNode isolates the given node from the rest of the mesh. Network ACLs
+// denying all traffic to and from the node are created at the highest priority,
+// any routes the node advertises are withdrawn, and its public key is revoked so
+// that it can no longer join. The node's registration is preserved until it is
+// purged with PurgeQuarantinedNode or restored with ReleaseQuarantine.
+func QuarantineNode(ctx context.Context, db MeshDB, st MeshStorage, nodeID types.NodeID, reason string) (QuarantineRecord, error) {
+	rec := QuarantineRecord{NodeID: nodeID, Time: time.Now().UTC(), Reason: reason}
+	quarantined, err := IsQuarantined(ctx, st, nodeID)
+	if err != nil {
+		return rec, fmt.Errorf("check quarantine: %w", err)
+	}
+	if quarantined {
+		return rec, fmt.Errorf("node %s is already quarantined", nodeID)
+	}
+	node, err := db.Peers().Get(ctx, nodeID)
+	if err != nil {
+		return rec, fmt.Errorf("get node: %w", err)
+	}
+	rec.PublicKey = node.GetPublicKey()
+	routes, err := db.Networking().GetRoutesByNode(ctx, nodeID)
+	if err != nil {
+		return rec, fmt.Errorf("get routes: %w", err)
+	}
+	for _, route := range routes {
+		data, err := protojson.Marshal(route.Route)
+		if err != nil {
+			return rec, fmt.Errorf("marshal route %s: %w", route.GetName(), err)
+		}
+		rec.Routes = append(rec.Routes, data)
+	}
+	// Write the record first so the withdrawn state is never lost.
+	if err := putQuarantine(ctx, st, rec); err != nil {
+		return rec, err
+	}
+	names := QuarantineACLNames(nodeID)
+	for i, acl := range []*v1.NetworkACL{
+		{SourceNodes: []string{nodeID.String()}, DestinationNodes: []string{"*"}},
+		{SourceNodes: []string{"*"}, DestinationNodes: []string{nodeID.String()}},
+	} {
+		acl.Name = names[i]
+		acl.Priority = math.MaxInt32
+		acl.Action = v1.ACLAction_ACTION_DENY
+		if err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: acl}); err != nil {
+			return rec, fmt.Errorf("put network acl %s: %w", acl.Name, err)
+		}
+	}
+	for _, route := range routes {
+		if err := db.Networking().DeleteRoute(ctx, route.GetName()); err != nil {
+			return rec, fmt.Errorf("withdraw route %s: %w", route.GetName(), err)
+		}
+	}
+	if rec.PublicKey != "" {
+		key, err := crypto.DecodePublicKey(rec.PublicKey)
+		if err != nil {
+			return rec, fmt.Errorf("decode public key: %w", err)
+		}
+		if err := RevokeKey(ctx, st, key); err != nil {
+			return rec, fmt.Errorf("revoke key: %w", err)
+		}
+	}
+	return rec, nil
+}
+
+// ReleaseQuarantine lifts the quarantine on the given node. The isolating network
+// ACLs are removed, withdrawn routes are restored, and the node's key revocation
+// is lifted.
+func ReleaseQuarantine(ctx context.Context, db MeshDB, st MeshStorage, nodeID types.NodeID) error {
+	rec, err := GetQuarantine(ctx, st, nodeID)
+	if err != nil {
+		return fmt.Errorf("get quarantine: %w", err)
+	}
+	for _, data := range rec.Routes {
+		var route v1.Route
+		if err := protojson.Unmarshal(data, &route); err != nil {
+			return fmt.Errorf("unmarshal route: %w", err)
+		}
+		if err := db.Networking().PutRoute(ctx, types.Route{Route: &route}); err != nil {
+			return fmt.Errorf("restore route %s: %w", route.GetName(), err)
+		}
+	}
+	if rec.PublicKey != "" {
+		key, err := crypto.DecodePublicKey(rec.PublicKey)
+		if err != nil {
+			return fmt.Errorf("decode public key: %w", err)
+		}
+		if err := st.Delete(ctx, RevokedKeysPrefix.ForString(key.ID())); err != nil {
+			return fmt.Errorf("lift key revocation: %w", err)
+		}
+	}
+	if err := deleteQuarantineACLs(ctx, db, nodeID); err != nil {
+		return err
+	}
+	return st.Delete(ctx, QuarantinePrefix.ForString(nodeID.String()))
+}
+
+// PurgeQuarantinedNode completes the eviction of a quarantined node by removing
+// its registration and edges from the mesh along with the quarantine itself.
+// The node's key remains revoked and the eviction is recorded in the membership
+// history.
+func PurgeQuarantinedNode(ctx context.Context, db MeshDB, st MeshStorage, nodeID types.NodeID, actor types.NodeID) error {
+	rec, err := GetQuarantine(ctx, st, nodeID)
+	if err != nil {
+		return fmt.Errorf("get quarantine: %w", err)
+	}
+	if err := db.Peers().Delete(ctx, nodeID); err != nil {
+		return fmt.Errorf("delete node: %w", err)
+	}
+	if err := deleteQuarantineACLs(ctx, db, nodeID); err != nil {
+		return err
+	}
+	if err := st.Delete(ctx, QuarantinePrefix.ForString(nodeID.String())); err != nil {
+		return fmt.Errorf("delete quarantine: %w", err)
+	}
+	reason := "quarantine purged"
+	if rec.Reason != "" {
+		reason += ": " + rec.Reason
+	}
+	return RecordMembershipEvent(ctx, st, MembershipEvent{
+		Type:   MembershipEventEvict,
+		NodeID: nodeID,
+		Reason: reason,
+		Actor:  actor,
+	})
+}
+
+func putQuarantine(ctx context.Context, st MeshStorage, rec QuarantineRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("marshal quarantine record: %w", err)
+	}
+	if err := st.PutValue(ctx, QuarantinePrefix.ForString(rec.NodeID.String()), data, 0); err != nil {
+		return fmt.Errorf("put quarantine record: %w", err)
+	}
+	return nil
+}
+
+func deleteQuarantineACLs(ctx context.Context, db MeshDB, nodeID types.NodeID) error {
+	for _, name := range QuarantineACLNames(nodeID) {
+		if err := db.Networking().DeleteNetworkACL(ctx, name); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("delete network acl %s: %w", name, err)
+		}
+	}
+	return nil
+}
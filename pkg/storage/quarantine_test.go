@@ -0,0 +1,160 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/crypto"
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestQuarantine(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+
+	key := crypto.MustGenerateKey().PublicKey()
+	encoded, err := key.Encode()
+	if err != nil {
+		t.Fatalf("encode key: %v", err)
+	}
+	for _, node := range []*v1.MeshNode{
+		{Id: "node-a", PublicKey: encoded, PrivateIPv4: "172.16.0.1/32", PrivateIPv6: "fd00::1/128"},
+		{Id: "node-b", PublicKey: generateEncodedKey(t), PrivateIPv4: "172.16.0.2/32", PrivateIPv6: "fd00::2/128"},
+	} {
+		if err := db.Peers().Put(ctx, types.MeshNode{MeshNode: node}); err != nil {
+			t.Fatalf("put node: %v", err)
+		}
+	}
+	if err := db.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: "node-a", Target: "node-b", Weight: 1}}); err != nil {
+		t.Fatalf("put edge: %v", err)
+	}
+	if err := db.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+		Name:             "node-a-auto",
+		Node:             "node-a",
+		DestinationCIDRs: []string{"10.0.0.0/24"},
+	}}); err != nil {
+		t.Fatalf("put route: %v", err)
+	}
+	if err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+		Name:             "allow-all",
+		Priority:         math.MaxInt32,
+		SourceNodes:      []string{"*"},
+		DestinationNodes: []string{"*"},
+		Action:           v1.ACLAction_ACTION_ACCEPT,
+	}}); err != nil {
+		t.Fatalf("put network acl: %v", err)
+	}
+
+	allowed := func() bool {
+		t.Helper()
+		acls, err := db.Networking().ListNetworkACLs(ctx)
+		if err != nil {
+			t.Fatalf("list network acls: %v", err)
+		}
+		acls.Sort(types.SortDescending)
+		a, _ := db.Peers().Get(ctx, "node-a")
+		b, _ := db.Peers().Get(ctx, "node-b")
+		return acls.AllowNodesToCommunicate(ctx, a, b) || acls.AllowNodesToCommunicate(ctx, b, a)
+	}
+	if !allowed() {
+		t.Fatal("expected nodes to communicate before quarantine")
+	}
+
+	rec, err := storage.QuarantineNode(ctx, db, st, "node-a", "suspected compromise")
+	if err != nil {
+		t.Fatalf("quarantine node: %v", err)
+	}
+	if len(rec.Routes) != 1 {
+		t.Fatalf("expected 1 withdrawn route, got %d", len(rec.Routes))
+	}
+	if _, err := storage.QuarantineNode(ctx, db, st, "node-a", ""); err == nil {
+		t.Fatal("expected error quarantining node twice")
+	}
+	if allowed() {
+		t.Fatal("expected quarantined node to be isolated")
+	}
+	routes, err := db.Networking().GetRoutesByNode(ctx, "node-a")
+	if err != nil {
+		t.Fatalf("get routes: %v", err)
+	}
+	if len(routes) != 0 {
+		t.Fatalf("expected routes to be withdrawn, got %d", len(routes))
+	}
+	if revoked, err := storage.IsKeyRevoked(ctx, st, key); err != nil || !revoked {
+		t.Fatalf("expected key to be revoked, got %v, %v", revoked, err)
+	}
+	if _, err := db.Peers().Get(ctx, "node-a"); err != nil {
+		t.Fatalf("expected node registration to be preserved: %v", err)
+	}
+
+	if err := storage.ReleaseQuarantine(ctx, db, st, "node-a"); err != nil {
+		t.Fatalf("release quarantine: %v", err)
+	}
+	if !allowed() {
+		t.Fatal("expected nodes to communicate after release")
+	}
+	if _, err := db.Networking().GetRoute(ctx, "node-a-auto"); err != nil {
+		t.Fatalf("expected route to be restored: %v", err)
+	}
+	if revoked, err := storage.IsKeyRevoked(ctx, st, key); err != nil || revoked {
+		t.Fatalf("expected key revocation to be lifted, got %v, %v", revoked, err)
+	}
+
+	if _, err := storage.QuarantineNode(ctx, db, st, "node-a", "confirmed compromise"); err != nil {
+		t.Fatalf("quarantine node: %v", err)
+	}
+	if err := storage.PurgeQuarantinedNode(ctx, db, st, "node-a", "admin"); err != nil {
+		t.Fatalf("purge node: %v", err)
+	}
+	if _, err := db.Peers().Get(ctx, "node-a"); !errors.IsNodeNotFound(err) {
+		t.Fatalf("expected node to be removed, got %v", err)
+	}
+	if quarantined, err := storage.IsQuarantined(ctx, st, "node-a"); err != nil || quarantined {
+		t.Fatalf("expected quarantine to be removed, got %v, %v", quarantined, err)
+	}
+	if revoked, err := storage.IsKeyRevoked(ctx, st, key); err != nil || !revoked {
+		t.Fatalf("expected key to remain revoked, got %v, %v", revoked, err)
+	}
+	events, err := storage.ListMembershipEvents(ctx, st, storage.MembershipEventFilter{NodeID: "node-a"})
+	if err != nil {
+		t.Fatalf("list membership events: %v", err)
+	}
+	if len(events) != 1 || events[0].Type != storage.MembershipEventEvict || events[0].Actor != "admin" {
+		t.Fatalf("expected eviction to be recorded, got %+v", events)
+	}
+}
+
+func generateEncodedKey(t *testing.T) string {
+	t.Helper()
+	encoded, err := crypto.MustGenerateKey().PublicKey().Encode()
+	if err != nil {
+		t.Fatalf("encode key: %v", err)
+	}
+	return encoded
+}
@@ -0,0 +1,262 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// QuotaResource is a resource limited by a namespace quota.
+type QuotaResource string
+
+const (
+	// QuotaNodes limits the number of nodes in a namespace.
+	QuotaNodes QuotaResource = "nodes"
+	// QuotaRoutes limits the number of routes advertised by nodes in a namespace.
+	QuotaRoutes QuotaResource = "routes"
+	// QuotaNetworkACLs limits the number of network ACLs in a namespace.
+	QuotaNetworkACLs QuotaResource = "network-acls"
+	// QuotaStorageBytes limits the size of the records of a namespace.
+	QuotaStorageBytes QuotaResource = "storage-bytes"
+)
+
+// NamespaceQuota limits the resources a namespace may use. Zero values are
+// unlimited.
+type NamespaceQuota struct {
+	// MaxNodes is the maximum number of nodes in the namespace.
+	MaxNodes int64 `json:"maxNodes,omitempty"`
+	// MaxRoutes is the maximum number of routes advertised by nodes in
+	// the namespace.
+	MaxRoutes int64 `json:"maxRoutes,omitempty"`
+	// MaxNetworkACLs is the maximum number of network ACLs in the namespace.
+	MaxNetworkACLs int64 `json:"maxNetworkACLs,omitempty"`
+	// MaxStorageBytes is the maximum size of the node, route and network ACL
+	// records of the namespace.
+	MaxStorageBytes int64 `json:"maxStorageBytes,omitempty"`
+}
+
+// Validate validates the quota.
+func (q NamespaceQuota) Validate() error {
+	if q.MaxNodes < 0 || q.MaxRoutes < 0 || q.MaxNetworkACLs < 0 || q.MaxStorageBytes < 0 {
+		return fmt.Errorf("quota limits can not be negative")
+	}
+	return nil
+}
+
+// Limit returns the limit for the given resource, zero if it is unlimited.
+func (q NamespaceQuota) Limit(resource QuotaResource) int64 {
+	switch resource {
+	case QuotaNodes:
+		return q.MaxNodes
+	case QuotaRoutes:
+		return q.MaxRoutes
+	case QuotaNetworkACLs:
+		return q.MaxNetworkACLs
+	case QuotaStorageBytes:
+		return q.MaxStorageBytes
+	}
+	return 0
+}
+
+// NamespaceUsage is the amount of each quota resource used by a namespace.
+type NamespaceUsage struct {
+	// Nodes is the number of nodes in the namespace.
+	Nodes int64 `json:"nodes"`
+	// Routes is the number of routes advertised by nodes in the namespace.
+	Routes int64 `json:"routes"`
+	// NetworkACLs is the number of network ACLs in the namespace.
+	NetworkACLs int64 `json:"networkACLs"`
+	// StorageBytes is the approximate size of the node, route and network
+	// ACL records of the namespace.
+	StorageBytes int64 `json:"storageBytes"`
+}
+
+// Get returns the usage of the given resource.
+func (u NamespaceUsage) Get(resource QuotaResource) int64 {
+	switch resource {
+	case QuotaNodes:
+		return u.Nodes
+	case QuotaRoutes:
+		return u.Routes
+	case QuotaNetworkACLs:
+		return u.NetworkACLs
+	case QuotaStorageBytes:
+		return u.StorageBytes
+	}
+	return 0
+}
+
+// QuotaExceededError is returned when a change would take a namespace over one
+// of its quotas.
+type QuotaExceededError struct {
+	// Namespace is the namespace whose quota was exceeded.
+	Namespace string
+	// Resource is the resource whose quota was exceeded.
+	Resource QuotaResource
+	// Used is the usage the change would have resulted in.
+	Used int64
+	// Limit is the quota for the resource.
+	Limit int64
+}
+
+// Error implements the error interface.
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("namespace %s would exceed its %s quota (%d/%d)", e.Namespace, e.Resource, e.Used, e.Limit)
+}
+
+// IsQuotaExceeded returns true if the given error is a QuotaExceededError.
+func IsQuotaExceeded(err error) bool {
+	var qe *QuotaExceededError
+	return errors.As(err, &qe)
+}
+
+// NamespaceOfName returns the namespace a resource named with a namespace
+// prefix belongs to, or the default namespace if none of the given namespaces
+// prefix the name. The longest matching namespace wins.
+func NamespaceOfName(namespaces []Namespace, name string) string {
+	match := DefaultNamespace
+	for _, ns := range namespaces {
+		if strings.HasPrefix(name, ns.Name+"-") && (match == DefaultNamespace || len(ns.Name) > len(match)) {
+			match = ns.Name
+		}
+	}
+	return match
+}
+
+// GetNamespaceUsage returns the resources currently used by the given namespace.
+// Network ACLs are counted towards the namespace prefixing their names.
+func GetNamespaceUsage(ctx context.Context, db MeshDB, st MeshStorage, name string) (NamespaceUsage, error) {
+	var usage NamespaceUsage
+	members, err := ListNamespaceMembers(ctx, st)
+	if err != nil {
+		return usage, fmt.Errorf("list namespace members: %w", err)
+	}
+	namespaces, err := ListNamespaces(ctx, st)
+	if err != nil {
+		return usage, fmt.Errorf("list namespaces: %w", err)
+	}
+	inNamespace := func(id types.NodeID) bool {
+		ns, ok := members[id]
+		if !ok {
+			ns = DefaultNamespace
+		}
+		return ns == name
+	}
+	nodes, err := db.Peers().List(ctx)
+	if err != nil {
+		return usage, fmt.Errorf("list nodes: %w", err)
+	}
+	for _, node := range nodes {
+		if inNamespace(node.NodeID()) {
+			usage.Nodes++
+			usage.StorageBytes += int64(proto.Size(node.MeshNode))
+		}
+	}
+	routes, err := db.Networking().ListRoutes(ctx)
+	if err != nil {
+		return usage, fmt.Errorf("list routes: %w", err)
+	}
+	for _, route := range routes {
+		if inNamespace(types.NodeID(route.GetNode())) {
+			usage.Routes++
+			usage.StorageBytes += int64(proto.Size(route.Route))
+		}
+	}
+	acls, err := db.Networking().ListNetworkACLs(ctx)
+	if err != nil {
+		return usage, fmt.Errorf("list network acls: %w", err)
+	}
+	for _, acl := range acls {
+		if NamespaceOfName(namespaces, acl.GetName()) == name {
+			usage.NetworkACLs++
+			usage.StorageBytes += int64(proto.Size(acl.NetworkACL))
+		}
+	}
+	return usage, nil
+}
+
+// CheckNamespaceQuota returns a QuotaExceededError if adding the given delta to
+// the usage of the namespace would exceed its quota. Only resources the delta
+// increases are checked, so a namespace over its quota can still shrink. No
+// error is returned if the namespace has no record.
+func CheckNamespaceQuota(ctx context.Context, db MeshDB, st MeshStorage, name string, delta NamespaceUsage) error {
+	ns, err := GetNamespace(ctx, st, name)
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("get namespace: %w", err)
+	}
+	if ns.Quota == (NamespaceQuota{}) {
+		return nil
+	}
+	usage, err := GetNamespaceUsage(ctx, db, st, name)
+	if err != nil {
+		return err
+	}
+	for _, resource := range []QuotaResource{QuotaNodes, QuotaRoutes, QuotaNetworkACLs, QuotaStorageBytes} {
+		limit, add := ns.Quota.Limit(resource), delta.Get(resource)
+		if limit == 0 || add <= 0 {
+			continue
+		}
+		if used := usage.Get(resource) + add; used > limit {
+			return &QuotaExceededError{Namespace: name, Resource: resource, Used: used, Limit: limit}
+		}
+	}
+	return nil
+}
+
+// CheckRouteQuota checks the quota of the namespace of the node advertising the
+// given route before it is created or replaced.
+func CheckRouteQuota(ctx context.Context, db MeshDB, st MeshStorage, route types.Route) error {
+	ns, err := GetNodeNamespace(ctx, st, types.NodeID(route.GetNode()))
+	if err != nil {
+		return fmt.Errorf("get node namespace: %w", err)
+	}
+	delta := NamespaceUsage{Routes: 1, StorageBytes: int64(proto.Size(route.Route))}
+	if existing, err := db.Networking().GetRoute(ctx, route.GetName()); err == nil {
+		delta.Routes = 0
+		delta.StorageBytes -= int64(proto.Size(existing.Route))
+	} else if !errors.IsRouteNotFound(err) {
+		return fmt.Errorf("get route: %w", err)
+	}
+	return CheckNamespaceQuota(ctx, db, st, ns, delta)
+}
+
+// CheckNetworkACLQuota checks the quota of the namespace prefixing the name of
+// the given network ACL before it is created or replaced.
+func CheckNetworkACLQuota(ctx context.Context, db MeshDB, st MeshStorage, acl types.NetworkACL) error {
+	namespaces, err := ListNamespaces(ctx, st)
+	if err != nil {
+		return fmt.Errorf("list namespaces: %w", err)
+	}
+	delta := NamespaceUsage{NetworkACLs: 1, StorageBytes: int64(proto.Size(acl.NetworkACL))}
+	if existing, err := db.Networking().GetNetworkACL(ctx, acl.GetName()); err == nil {
+		delta.NetworkACLs = 0
+		delta.StorageBytes -= int64(proto.Size(existing.NetworkACL))
+	} else if !errors.IsACLNotFound(err) {
+		return fmt.Errorf("get network acl: %w", err)
+	}
+	return CheckNamespaceQuota(ctx, db, st, NamespaceOfName(namespaces, acl.GetName()), delta)
+}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestNamespaceQuotas(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+
+	for _, ns := range []storage.Namespace{
+		{Name: "team", Quota: storage.NamespaceQuota{MaxNodes: 1, MaxNetworkACLs: 1}},
+		{Name: "team-ops"},
+	} {
+		if err := storage.PutNamespace(ctx, st, ns); err != nil {
+			t.Fatalf("put namespace: %v", err)
+		}
+	}
+	if err := storage.PutNamespace(ctx, st, storage.Namespace{Name: "bad", Quota: storage.NamespaceQuota{MaxNodes: -1}}); err == nil {
+		t.Fatal("expected negative quota to fail validation")
+	}
+	if err := storage.SetNodeNamespace(ctx, st, "node-a", "team"); err != nil {
+		t.Fatalf("set node namespace: %v", err)
+	}
+	if err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: "node-a"}}); err != nil {
+		t.Fatalf("put node: %v", err)
+	}
+	for _, acl := range []*v1.NetworkACL{
+		{Name: "team-allow", Action: v1.ACLAction_ACTION_ACCEPT, SourceNodes: []string{"node-a"}},
+		// Counts towards the longer team-ops namespace.
+		{Name: "team-ops-allow", Action: v1.ACLAction_ACTION_ACCEPT, SourceNodes: []string{"node-a"}},
+	} {
+		if err := db.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: acl}); err != nil {
+			t.Fatalf("put network acl: %v", err)
+		}
+	}
+
+	usage, err := storage.GetNamespaceUsage(ctx, db, st, "team")
+	if err != nil {
+		t.Fatalf("get namespace usage: %v", err)
+	}
+	if usage.Nodes != 1 || usage.NetworkACLs != 1 || usage.StorageBytes == 0 {
+		t.Fatalf("unexpected usage: %+v", usage)
+	}
+
+	err = storage.CheckNamespaceQuota(ctx, db, st, "team", storage.NamespaceUsage{Nodes: 1})
+	if !storage.IsQuotaExceeded(err) {
+		t.Fatalf("expected node quota to be exceeded, got %v", err)
+	}
+	err = storage.CheckNetworkACLQuota(ctx, db, st, types.NetworkACL{NetworkACL: &v1.NetworkACL{Name: "team-deny", SourceNodes: []string{"node-a"}}})
+	if !storage.IsQuotaExceeded(err) {
+		t.Fatalf("expected network acl quota to be exceeded, got %v", err)
+	}
+	// Replacing an existing ACL does not add to the count.
+	err = storage.CheckNetworkACLQuota(ctx, db, st, types.NetworkACL{NetworkACL: &v1.NetworkACL{Name: "team-allow", SourceNodes: []string{"*"}}})
+	if err != nil {
+		t.Fatalf("expected replacing a network acl to be allowed, got %v", err)
+	}
+	// Namespaces without quotas and without records are unlimited.
+	for _, ns := range []string{"team-ops", storage.DefaultNamespace} {
+		if err := storage.CheckNamespaceQuota(ctx, db, st, ns, storage.NamespaceUsage{Nodes: 100}); err != nil {
+			t.Errorf("expected %s to be unlimited, got %v", ns, err)
+		}
+	}
+}
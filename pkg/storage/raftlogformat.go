@@ -0,0 +1,57 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+
+	"google.golang.org/grpc/metadata"
+)
+
+// DefaultRaftLogFormat is the raft log format of nodes that do not report one.
+const DefaultRaftLogFormat = "protobuf+snappy"
+
+// RaftLogFormatHeader is the gRPC metadata header a node joining the storage
+// group uses to report the format it encodes raft log entries in.
+const RaftLogFormatHeader = "x-webmesh-raft-log-format"
+
+// RaftLogFormatProvider is implemented by storage providers that encode their
+// log entries in a configurable format.
+type RaftLogFormatProvider interface {
+	// RaftLogFormat returns the format log entries are encoded in.
+	RaftLogFormat() string
+}
+
+// WithRaftLogFormat appends the raft log format header to the outgoing context
+// of a join request.
+func WithRaftLogFormat(ctx context.Context, format string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, RaftLogFormatHeader, format)
+}
+
+// RaftLogFormatFrom returns the raft log format reported in the incoming context
+// of a join request. Nodes that do not report one use DefaultRaftLogFormat.
+func RaftLogFormatFrom(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return DefaultRaftLogFormat
+	}
+	values := md.Get(RaftLogFormatHeader)
+	if len(values) == 0 || values[0] == "" {
+		return DefaultRaftLogFormat
+	}
+	return values[0]
+}
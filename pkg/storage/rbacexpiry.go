@@ -0,0 +1,186 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// RoleBindingExpiryPrefix is where rolebinding expiry times are recorded in the
+// database. Expiries are indexed by rolebinding name in the format
+// /registry/rolebinding-expiry/<name>. The value is the RFC3339 timestamp at
+// which the rolebinding is removed.
+var RoleBindingExpiryPrefix = types.RegistryPrefix.ForString("rolebinding-expiry")
+
+// RoleBindingExpiresHeader is the gRPC metadata header set on PutRoleBinding
+// requests to the RFC3339 time at which the rolebinding should be removed.
+const RoleBindingExpiresHeader = "x-webmesh-rolebinding-expires"
+
+// RoleBindingExpiry is when a rolebinding is removed.
+type RoleBindingExpiry struct {
+	// Name is the name of the rolebinding.
+	Name string `json:"name"`
+	// Expires is when the rolebinding is removed.
+	Expires time.Time `json:"expires"`
+}
+
+// ExpiringRoleBinding is a rolebinding along with when it expires.
+type ExpiringRoleBinding struct {
+	types.RoleBinding
+	// Expires is when the rolebinding is removed.
+	Expires time.Time
+}
+
+// WithRoleBindingExpiry appends the expiry header to the outgoing context of
+// a PutRoleBinding request.
+func WithRoleBindingExpiry(ctx context.Context, expires time.Time) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, RoleBindingExpiresHeader, expires.UTC().Format(time.RFC3339))
+}
+
+// RoleBindingExpiryFromRequest returns the expiry set in the incoming context
+// of a PutRoleBinding request. A zero time is returned if none was set.
+func RoleBindingExpiryFromRequest(ctx context.Context) (time.Time, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return time.Time{}, nil
+	}
+	values := md.Get(RoleBindingExpiresHeader)
+	if len(values) == 0 || values[0] == "" {
+		return time.Time{}, nil
+	}
+	expires, err := time.Parse(time.RFC3339, values[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s header: %w", RoleBindingExpiresHeader, err)
+	}
+	return expires, nil
+}
+
+// GetRoleBindingExpiry returns when the given rolebinding expires. A zero time
+// is returned if it does not expire.
+func GetRoleBindingExpiry(ctx context.Context, st MeshStorage, name string) (time.Time, error) {
+	data, err := st.GetValue(ctx, RoleBindingExpiryPrefix.ForString(name))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, err
+	}
+	expires, err := time.Parse(time.RFC3339, string(data))
+	if err != nil {
+		return time.Time{}, fmt.Errorf("parse rolebinding expiry: %w", err)
+	}
+	return expires, nil
+}
+
+// SetRoleBindingExpiry records when the given rolebinding expires. A zero time
+// removes the expiry. System rolebindings cannot expire.
+func SetRoleBindingExpiry(ctx context.Context, st MeshStorage, name string, expires time.Time) error {
+	key := RoleBindingExpiryPrefix.ForString(name)
+	if expires.IsZero() {
+		return st.Delete(ctx, key)
+	}
+	if IsSystemRoleBinding(name) {
+		return fmt.Errorf("%w %q", errors.ErrIsSystemRoleBinding, name)
+	}
+	return st.PutValue(ctx, key, []byte(expires.UTC().Format(time.RFC3339)), 0)
+}
+
+// ListRoleBindingExpiries returns the expiry of every expiring rolebinding,
+// soonest first.
+func ListRoleBindingExpiries(ctx context.Context, st MeshStorage) ([]RoleBindingExpiry, error) {
+	var out []RoleBindingExpiry
+	prefix := append(RoleBindingExpiryPrefix, '/')
+	err := st.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		expires, err := time.Parse(time.RFC3339, string(value))
+		if err != nil {
+			return fmt.Errorf("parse rolebinding expiry %s: %w", key, err)
+		}
+		out = append(out, RoleBindingExpiry{
+			Name:    string(bytes.TrimPrefix(key, prefix)),
+			Expires: expires,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Expires.Equal(out[j].Expires) {
+			return out[i].Name < out[j].Name
+		}
+		return out[i].Expires.Before(out[j].Expires)
+	})
+	return out, nil
+}
+
+// ListExpiringRoleBindings returns the rolebindings that expire before the
+// given time, soonest first. Expiries of rolebindings that no longer exist
+// are skipped.
+func ListExpiringRoleBindings(ctx context.Context, db MeshDB, st MeshStorage, before time.Time) ([]ExpiringRoleBinding, error) {
+	expiries, err := ListRoleBindingExpiries(ctx, st)
+	if err != nil {
+		return nil, fmt.Errorf("list rolebinding expiries: %w", err)
+	}
+	var out []ExpiringRoleBinding
+	for _, expiry := range expiries {
+		if !expiry.Expires.Before(before) {
+			break
+		}
+		rb, err := db.RBAC().GetRoleBinding(ctx, expiry.Name)
+		if err != nil {
+			if errors.IsRoleBindingNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("get rolebinding %s: %w", expiry.Name, err)
+		}
+		out = append(out, ExpiringRoleBinding{RoleBinding: rb, Expires: expiry.Expires})
+	}
+	return out, nil
+}
+
+// RemoveExpiredRoleBindings removes the rolebindings that expired by now along
+// with their expiries, and returns the names of the removed rolebindings.
+func RemoveExpiredRoleBindings(ctx context.Context, db MeshDB, st MeshStorage, now time.Time) ([]string, error) {
+	expiries, err := ListRoleBindingExpiries(ctx, st)
+	if err != nil {
+		return nil, fmt.Errorf("list rolebinding expiries: %w", err)
+	}
+	var removed []string
+	for _, expiry := range expiries {
+		if expiry.Expires.After(now) {
+			break
+		}
+		err := db.RBAC().DeleteRoleBinding(ctx, expiry.Name)
+		if err != nil && !errors.IsRoleBindingNotFound(err) {
+			return removed, fmt.Errorf("delete rolebinding %s: %w", expiry.Name, err)
+		}
+		if err := st.Delete(ctx, RoleBindingExpiryPrefix.ForString(expiry.Name)); err != nil {
+			return removed, fmt.Errorf("delete rolebinding expiry %s: %w", expiry.Name, err)
+		}
+		removed = append(removed, expiry.Name)
+	}
+	return removed, nil
+}
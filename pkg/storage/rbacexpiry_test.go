@@ -0,0 +1,104 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestRoleBindingExpiry(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for name, expires := range map[string]time.Time{
+		"contractor": now.Add(time.Hour),
+		"on-call":    now.Add(48 * time.Hour),
+		"expired":    now.Add(-time.Minute),
+		"permanent":  {},
+	} {
+		err := db.RBAC().PutRoleBinding(ctx, types.RoleBinding{RoleBinding: &v1.RoleBinding{
+			Name:     name,
+			Role:     "some-role",
+			Subjects: []*v1.Subject{{Type: v1.SubjectType_SUBJECT_USER, Name: name}},
+		}})
+		if err != nil {
+			t.Fatalf("put rolebinding %s: %v", name, err)
+		}
+		if err := storage.SetRoleBindingExpiry(ctx, st, name, expires); err != nil {
+			t.Fatalf("set expiry of %s: %v", name, err)
+		}
+	}
+	// An expiry left behind by a rolebinding deleted without it.
+	if err := storage.SetRoleBindingExpiry(ctx, st, "deleted", now.Add(time.Minute)); err != nil {
+		t.Fatalf("set expiry: %v", err)
+	}
+	if err := storage.SetRoleBindingExpiry(ctx, st, string(storage.MeshAdminRoleBinding), now.Add(time.Hour)); !errors.Is(err, errors.ErrIsSystemRoleBinding) {
+		t.Fatalf("expected system rolebindings to be rejected, got %v", err)
+	}
+
+	expiring, err := storage.ListExpiringRoleBindings(ctx, db, st, now.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("list expiring rolebindings: %v", err)
+	}
+	var names []string
+	for _, rb := range expiring {
+		names = append(names, rb.GetName())
+	}
+	if len(names) != 2 || names[0] != "expired" || names[1] != "contractor" {
+		t.Fatalf("expected expired and contractor to be expiring, got %v", names)
+	}
+	if !expiring[1].Expires.Equal(now.Add(time.Hour)) {
+		t.Fatalf("unexpected expiry for contractor: %v", expiring[1].Expires)
+	}
+
+	removed, err := storage.RemoveExpiredRoleBindings(ctx, db, st, now.Add(2*time.Minute))
+	if err != nil {
+		t.Fatalf("remove expired rolebindings: %v", err)
+	}
+	if len(removed) != 2 || removed[0] != "expired" || removed[1] != "deleted" {
+		t.Fatalf("expected expired and deleted to be removed, got %v", removed)
+	}
+	if _, err := db.RBAC().GetRoleBinding(ctx, "expired"); !errors.IsRoleBindingNotFound(err) {
+		t.Fatalf("expected expired rolebinding to be removed, got %v", err)
+	}
+	for _, name := range []string{"contractor", "on-call", "permanent"} {
+		if _, err := db.RBAC().GetRoleBinding(ctx, name); err != nil {
+			t.Fatalf("expected %s to be kept: %v", name, err)
+		}
+	}
+	expiries, err := storage.ListRoleBindingExpiries(ctx, st)
+	if err != nil {
+		t.Fatalf("list expiries: %v", err)
+	}
+	if len(expiries) != 2 || expiries[0].Name != "contractor" || expiries[1].Name != "on-call" {
+		t.Fatalf("expected the remaining expiries to be listed soonest first, got %+v", expiries)
+	}
+}
@@ -0,0 +1,99 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// RouteMetricsPrefix is where route metrics are stored in the database.
+// Metrics are indexed by route name in the format /registry/route-metrics/<name>.
+var RouteMetricsPrefix = types.RegistryPrefix.ForString("route-metrics")
+
+// GetRouteMetric returns the metric of the given route. False is returned if
+// the route has no metric.
+func GetRouteMetric(ctx context.Context, st MeshStorage, name string) (uint32, bool, error) {
+	data, err := st.GetValue(ctx, RouteMetricsPrefix.ForString(name))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("get route metric: %w", err)
+	}
+	var metric uint32
+	if err := json.Unmarshal(data, &metric); err != nil {
+		return 0, false, fmt.Errorf("unmarshal route metric: %w", err)
+	}
+	return metric, true, nil
+}
+
+// PutRouteMetric sets the metric of the given route.
+func PutRouteMetric(ctx context.Context, st MeshStorage, name string, metric uint32) error {
+	if !types.IsValidID(name) {
+		return fmt.Errorf("invalid route name %q", name)
+	}
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return fmt.Errorf("marshal route metric: %w", err)
+	}
+	if err := st.PutValue(ctx, RouteMetricsPrefix.ForString(name), data, 0); err != nil {
+		return fmt.Errorf("put route metric: %w", err)
+	}
+	return nil
+}
+
+// DeleteRouteMetric removes the metric of the given route, resetting it to zero.
+func DeleteRouteMetric(ctx context.Context, st MeshStorage, name string) error {
+	if err := st.Delete(ctx, RouteMetricsPrefix.ForString(name)); err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete route metric: %w", err)
+	}
+	return nil
+}
+
+// ListRouteMetrics returns the metrics of all routes that have one.
+func ListRouteMetrics(ctx context.Context, st MeshStorage) (types.RouteMetrics, error) {
+	out := make(types.RouteMetrics)
+	prefix := append(RouteMetricsPrefix, '/')
+	err := st.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		var metric uint32
+		if err := json.Unmarshal(value, &metric); err != nil {
+			return fmt.Errorf("unmarshal route metric %s: %w", key, err)
+		}
+		out[string(bytes.TrimPrefix(key, prefix))] = metric
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list route metrics: %w", err)
+	}
+	return out, nil
+}
+
+// RouteMetricsFor loads the route metrics for the given database. Every route
+// has a metric of zero if the database does not expose its underlying storage.
+func RouteMetricsFor(ctx context.Context, db MeshDB) (types.RouteMetrics, error) {
+	st := MeshStorageOf(db)
+	if st == nil {
+		return types.RouteMetrics{}, nil
+	}
+	return ListRouteMetrics(ctx, st)
+}
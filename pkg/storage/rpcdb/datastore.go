@@ -77,7 +77,7 @@ func (p *KVStorage) GetValue(ctx context.Context, key []byte) ([]byte, error) {
 		return nil, err
 	}
 	if resp.GetError() != "" {
-		if strings.Contains(err.Error(), "not found") {
+		if strings.Contains(resp.GetError(), "not found") {
 			return nil, errors.ErrKeyNotFound
 		}
 		return nil, fmt.Errorf(resp.GetError())
@@ -88,6 +88,10 @@ func (p *KVStorage) GetValue(ctx context.Context, key []byte) ([]byte, error) {
 	return resp.GetItems()[0], nil
 }
 
+func (p *KVStorage) GetValueVersion(ctx context.Context, key []byte) ([]byte, storage.Version, error) {
+	return nil, storage.NoVersion, fmt.Errorf("%w: versions over a query stream", errors.ErrNotImplemented)
+}
+
 func (p *KVStorage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
 	resp, err := p.Query(ctx, &v1.QueryRequest{
 		Command: v1.QueryRequest_PUT,
@@ -104,6 +108,30 @@ func (p *KVStorage) PutValue(ctx context.Context, key, value []byte, ttl time.Du
 	return nil
 }
 
+func (p *KVStorage) CompareAndSwap(ctx context.Context, key, value []byte, expected storage.Version, ttl time.Duration) error {
+	return fmt.Errorf("%w: compare-and-swap over a query stream", errors.ErrNotImplemented)
+}
+
+// WriteBatch applies all of the given writes atomically on the remote storage.
+func (p *KVStorage) WriteBatch(ctx context.Context, ops []storage.WriteOp) error {
+	data, err := storage.MarshalWriteOps(ops)
+	if err != nil {
+		return err
+	}
+	resp, err := p.Query(ctx, &v1.QueryRequest{
+		Command: types.QueryCommandBatch,
+		Type:    v1.QueryRequest_VALUE,
+		Item:    data,
+	})
+	if err != nil {
+		return err
+	}
+	if resp.GetError() != "" {
+		return fmt.Errorf(resp.GetError())
+	}
+	return nil
+}
+
 func (p *KVStorage) Delete(ctx context.Context, key []byte) error {
 	resp, err := p.Query(ctx, &v1.QueryRequest{
 		Command: v1.QueryRequest_DELETE,
@@ -152,6 +180,10 @@ func (p *KVStorage) Subscribe(ctx context.Context, prefix []byte, fn storage.KVS
 	return func() {}, errors.ErrNotStorageNode
 }
 
+func (p *KVStorage) Watch(ctx context.Context, prefix []byte) (<-chan storage.WatchEvent, error) {
+	return nil, errors.ErrNotStorageNode
+}
+
 func (p *KVStorage) Close() error {
 	return nil
 }
@@ -191,7 +223,7 @@ func (g *GraphStore) Vertex(nodeID types.NodeID) (node types.MeshNode, props gra
 		return node, props, err
 	}
 	if resp.GetError() != "" {
-		if strings.Contains(err.Error(), "not found") {
+		if strings.Contains(resp.GetError(), "not found") {
 			return node, props, graph.ErrVertexNotFound
 		}
 		return node, props, fmt.Errorf(resp.GetError())
@@ -290,7 +322,7 @@ func (g *GraphStore) Edge(sourceNode, targetNode types.NodeID) (edge graph.Edge[
 	}
 	resp, err := g.Query(context.Background(), req)
 	if resp.GetError() != "" {
-		if strings.Contains(err.Error(), "not found") {
+		if strings.Contains(resp.GetError(), "not found") {
 			return edge, graph.ErrEdgeNotFound
 		}
 		return edge, fmt.Errorf(resp.GetError())
@@ -471,7 +503,7 @@ func (r *RBACStore) GetRole(ctx context.Context, name string) (types.Role, error
 		return meshrole, err
 	}
 	if resp.GetError() != "" {
-		if strings.Contains(err.Error(), "not found") {
+		if strings.Contains(resp.GetError(), "not found") {
 			return meshrole, errors.ErrRoleNotFound
 		}
 		return meshrole, fmt.Errorf(resp.GetError())
@@ -516,7 +548,7 @@ func (r *RBACStore) GetRoleBinding(ctx context.Context, name string) (types.Role
 		return rb, err
 	}
 	if resp.GetError() != "" {
-		if strings.Contains(err.Error(), "not found") {
+		if strings.Contains(resp.GetError(), "not found") {
 			return rb, errors.ErrRoleBindingNotFound
 		}
 		return rb, fmt.Errorf(resp.GetError())
@@ -561,7 +593,7 @@ func (r *RBACStore) GetGroup(ctx context.Context, name string) (types.Group, err
 		return group, err
 	}
 	if resp.GetError() != "" {
-		if strings.Contains(err.Error(), "not found") {
+		if strings.Contains(resp.GetError(), "not found") {
 			return group, errors.ErrGroupNotFound
 		}
 		return group, fmt.Errorf(resp.GetError())
@@ -684,7 +716,7 @@ func (nw *NetworkingStore) GetNetworkACL(ctx context.Context, name string) (type
 		return acl, err
 	}
 	if resp.GetError() != "" {
-		if strings.Contains(err.Error(), "not found") {
+		if strings.Contains(resp.GetError(), "not found") {
 			return acl, errors.ErrACLNotFound
 		}
 		return acl, fmt.Errorf(resp.GetError())
@@ -763,7 +795,7 @@ func (nw *NetworkingStore) GetRoute(ctx context.Context, name string) (types.Rou
 		return route, err
 	}
 	if resp.GetError() != "" {
-		if strings.Contains(err.Error(), "not found") {
+		if strings.Contains(resp.GetError(), "not found") {
 			return route, errors.ErrRouteNotFound
 		}
 		return route, fmt.Errorf(resp.GetError())
@@ -53,6 +53,8 @@ func ServeQuery(ctx context.Context, db storage.Provider, req *v1.QueryRequest)
 		return doPutQuery(ctx, db, query)
 	case v1.QueryRequest_DELETE:
 		return doDeleteQuery(ctx, db, query)
+	case types.QueryCommandBatch:
+		return doBatchQuery(ctx, db, query)
 	default:
 		return &v1.QueryResponse{
 			Error: fmt.Errorf("%w: unknown query command %s", ErrInvalidQuery, req.GetCommand().String()).Error(),
@@ -514,6 +516,20 @@ func doListQuery(ctx context.Context, db storage.Provider, req types.StorageQuer
 	return
 }
 
+func doBatchQuery(ctx context.Context, db storage.Provider, req types.StorageQuery) (res *v1.QueryResponse) {
+	res = &v1.QueryResponse{}
+	ops, err := storage.UnmarshalWriteOps(req.GetItem())
+	if err != nil {
+		res.Error = fmt.Errorf("%w: %w", ErrInvalidArgument, err).Error()
+		return
+	}
+	err = db.MeshStorage().WriteBatch(ctx, ops)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return
+}
+
 func doPutQuery(ctx context.Context, db storage.Provider, req types.StorageQuery) (res *v1.QueryResponse) {
 	res = &v1.QueryResponse{}
 	switch req.GetType() {
@@ -0,0 +1,112 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ServicesPrefix is where nodes advertise user-defined gRPC services they serve.
+// Advertisements are indexed by service name and node ID in the format
+// /services/<service>/<node-id>. The node record cannot carry them, since its
+// features are a closed enum of the mesh APIs. They are kept outside of the
+// registry so that nodes that are not storage members can publish their own
+// advertisements, and are tied to the node record instead: providers without
+// a registered node are not listed, and removing a node withdraws its
+// advertisements.
+var ServicesPrefix = types.StoragePrefix("/services")
+
+// AdvertiseService advertises that the given node serves the given fully-qualified
+// gRPC service. The advertisement expires after ttl unless it is refreshed. A ttl
+// of zero never expires.
+func AdvertiseService(ctx context.Context, st MeshStorage, service string, nodeID types.NodeID, ttl time.Duration) error {
+	return st.PutValue(ctx, ServicesPrefix.ForString(service).ForString(nodeID.String()), []byte(nodeID), ttl)
+}
+
+// WithdrawService removes the advertisement of the given service by the given node.
+func WithdrawService(ctx context.Context, st MeshStorage, service string, nodeID types.NodeID) error {
+	return st.Delete(ctx, ServicesPrefix.ForString(service).ForString(nodeID.String()))
+}
+
+// ListServiceProviders returns the IDs of the registered nodes advertising the
+// given service. The nodes can be dialed at their gRPC address to reach the
+// service.
+func ListServiceProviders(ctx context.Context, st MeshStorage, service string) ([]types.NodeID, error) {
+	prefix := ServicesPrefix.ForString(service)
+	var ids []types.NodeID
+	err := st.IterPrefix(ctx, append(prefix, '/'), func(key, _ []byte) error {
+		id := string(prefix.TrimFrom(key))
+		if id != "" && !strings.Contains(id, "/") {
+			ids = append(ids, types.NodeID(id))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	registered := ids[:0]
+	for _, id := range ids {
+		_, err := st.GetValue(ctx, NodesPrefix.ForString(id.String()))
+		if err != nil {
+			if errors.IsKeyNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		registered = append(registered, id)
+	}
+	ids = registered
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+// ListNodeServices returns the services advertised by the given node.
+func ListNodeServices(ctx context.Context, st MeshStorage, nodeID types.NodeID) ([]string, error) {
+	var services []string
+	err := st.IterPrefix(ctx, append(ServicesPrefix, '/'), func(key, _ []byte) error {
+		service, id, ok := strings.Cut(string(ServicesPrefix.TrimFrom(key)), "/")
+		if ok && id == nodeID.String() {
+			services = append(services, service)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(services)
+	return services, nil
+}
+
+// WithdrawNodeServices removes all service advertisements by the given node.
+func WithdrawNodeServices(ctx context.Context, st MeshStorage, nodeID types.NodeID) error {
+	services, err := ListNodeServices(ctx, st, nodeID)
+	if err != nil {
+		return err
+	}
+	for _, service := range services {
+		if err := WithdrawService(ctx, st, service, nodeID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
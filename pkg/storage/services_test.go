@@ -0,0 +1,98 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestServiceAdvertisements(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+	for _, id := range []string{"node-a", "node-b", "node-c"} {
+		if err := db.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: id, PublicKey: generateEncodedKey(t)}}); err != nil {
+			t.Fatalf("put node: %v", err)
+		}
+	}
+
+	// node-d is not registered and should not be listed.
+	for _, id := range []types.NodeID{"node-b", "node-a", "node-d"} {
+		if err := storage.AdvertiseService(ctx, st, "acme.v1.Widgets", id, 0); err != nil {
+			t.Fatalf("advertise service: %v", err)
+		}
+	}
+	if err := storage.AdvertiseService(ctx, st, "acme.v1.WidgetsAdmin", "node-c", 0); err != nil {
+		t.Fatalf("advertise service: %v", err)
+	}
+	ids, err := storage.ListServiceProviders(ctx, st, "acme.v1.Widgets")
+	if err != nil {
+		t.Fatalf("list providers: %v", err)
+	}
+	if len(ids) != 2 || ids[0] != "node-a" || ids[1] != "node-b" {
+		t.Fatalf("expected [node-a node-b], got %v", ids)
+	}
+	if err := storage.WithdrawService(ctx, st, "acme.v1.Widgets", "node-a"); err != nil {
+		t.Fatalf("withdraw service: %v", err)
+	}
+	ids, err = storage.ListServiceProviders(ctx, st, "acme.v1.Widgets")
+	if err != nil {
+		t.Fatalf("list providers: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != "node-b" {
+		t.Fatalf("expected [node-b], got %v", ids)
+	}
+
+	// Removing a node should withdraw its advertisements.
+	if err := storage.AdvertiseService(ctx, st, "acme.v1.WidgetsAdmin", "node-b", 0); err != nil {
+		t.Fatalf("advertise service: %v", err)
+	}
+	services, err := storage.ListNodeServices(ctx, st, "node-b")
+	if err != nil {
+		t.Fatalf("list node services: %v", err)
+	}
+	if len(services) != 2 || services[0] != "acme.v1.Widgets" || services[1] != "acme.v1.WidgetsAdmin" {
+		t.Fatalf("expected [acme.v1.Widgets acme.v1.WidgetsAdmin], got %v", services)
+	}
+	if err := db.Peers().Delete(ctx, "node-b"); err != nil {
+		t.Fatalf("delete node: %v", err)
+	}
+	services, err = storage.ListNodeServices(ctx, st, "node-b")
+	if err != nil {
+		t.Fatalf("list node services: %v", err)
+	}
+	if len(services) != 0 {
+		t.Fatalf("expected removed node to have no services, got %v", services)
+	}
+	ids, err = storage.ListServiceProviders(ctx, st, "acme.v1.WidgetsAdmin")
+	if err != nil {
+		t.Fatalf("list providers: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != "node-c" {
+		t.Fatalf("expected [node-c], got %v", ids)
+	}
+}
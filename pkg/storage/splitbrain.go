@@ -0,0 +1,236 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ClusterIDKey is where the ID of the mesh cluster is stored. It is generated
+// once when the mesh is bootstrapped and replicated to every storage node, so
+// two partitions that bootstrapped independently will carry different IDs.
+// Nothing but Bootstrap writes it.
+var ClusterIDKey = types.RegistryPrefix.ForString("cluster-id")
+
+// SplitBrainAlarmPrefix is where split-brain alarms are recorded in the database.
+// Alarms are indexed by node ID in the format /registry/alarms/split-brain/<id>.
+var SplitBrainAlarmPrefix = types.RegistryPrefix.ForString("alarms").ForString("split-brain")
+
+const (
+	// ClusterIDHeader is the gRPC metadata header a joining node uses to report
+	// the cluster ID found in its local storage.
+	ClusterIDHeader = "x-webmesh-cluster-id"
+	// ClusterTermHeader is the gRPC metadata header a joining node uses to report
+	// the consensus term of the last entry in its local log.
+	ClusterTermHeader = "x-webmesh-cluster-term"
+)
+
+// TermProvider is implemented by storage providers that track consensus terms.
+type TermProvider interface {
+	// CurrentTerm returns the current consensus term.
+	CurrentTerm() uint64
+	// LastLogTerm returns the term of the last entry in the local log.
+	LastLogTerm() uint64
+}
+
+// Split-brain resolutions.
+const (
+	// SplitBrainRejoin admits the diverged node on its next join. Any local
+	// state it accumulated while partitioned is discarded in favor of this mesh.
+	SplitBrainRejoin = "rejoin"
+	// SplitBrainDismiss clears the alarm without admitting the node. It will be
+	// raised again if the node attempts to join with the same diverged state.
+	SplitBrainDismiss = "dismiss"
+)
+
+// SplitBrainAlarm records a node that attempted to join with state that
+// diverged from the mesh. Automatic merges are refused until an administrator
+// resolves the alarm.
+type SplitBrainAlarm struct {
+	// NodeID is the ID of the diverged node.
+	NodeID types.NodeID `json:"nodeID"`
+	// Time is when the alarm was raised.
+	Time time.Time `json:"time"`
+	// Reason is a human readable reason for the alarm.
+	Reason string `json:"reason"`
+	// LocalClusterID is the cluster ID of this mesh.
+	LocalClusterID string `json:"localClusterID"`
+	// RemoteClusterID is the cluster ID reported by the node.
+	RemoteClusterID string `json:"remoteClusterID"`
+	// LocalTerm is the current consensus term of this mesh.
+	LocalTerm uint64 `json:"localTerm,omitempty"`
+	// RemoteTerm is the last log term reported by the node.
+	RemoteTerm uint64 `json:"remoteTerm,omitempty"`
+	// Attempts is the number of refused join attempts since the alarm was raised.
+	Attempts int `json:"attempts"`
+	// AllowRejoin is set when an administrator chose to admit the node.
+	AllowRejoin bool `json:"allowRejoin,omitempty"`
+	// Recovery are the steps to take to resolve the alarm.
+	Recovery []string `json:"recovery"`
+}
+
+// GetClusterID returns the ID of the mesh cluster.
+func GetClusterID(ctx context.Context, st MeshStorage) (string, error) {
+	id, err := st.GetValue(ctx, ClusterIDKey)
+	if err != nil {
+		return "", err
+	}
+	return string(id), nil
+}
+
+// putClusterID stores the ID of a newly bootstrapped cluster. A random one is
+// generated if the given ID is empty. The ID is never changed once stored, so
+// this is only called by Bootstrap.
+func putClusterID(ctx context.Context, st MeshStorage, id string) (string, error) {
+	if id == "" {
+		id = uuid.NewString()
+	}
+	err := st.CompareAndSwap(ctx, ClusterIDKey, []byte(id), NoVersion, 0)
+	if errors.IsVersionConflict(err) {
+		current, err := GetClusterID(ctx, st)
+		if err != nil {
+			return "", err
+		}
+		return "", fmt.Errorf("mesh already belongs to cluster %s", current)
+	}
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// WithClusterLineage appends the given cluster ID and last log term to the
+// outgoing context of a join request.
+func WithClusterLineage(ctx context.Context, clusterID string, term uint64) context.Context {
+	return metadata.AppendToOutgoingContext(ctx,
+		ClusterIDHeader, clusterID,
+		ClusterTermHeader, strconv.FormatUint(term, 10),
+	)
+}
+
+// ClusterLineageFrom returns the cluster ID and last log term reported in the
+// incoming context of a join request. An empty ID is returned if none was sent.
+func ClusterLineageFrom(ctx context.Context) (clusterID string, term uint64) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", 0
+	}
+	if vals := md.Get(ClusterIDHeader); len(vals) > 0 {
+		clusterID = vals[0]
+	}
+	if vals := md.Get(ClusterTermHeader); len(vals) > 0 {
+		term, _ = strconv.ParseUint(vals[0], 10, 64)
+	}
+	return clusterID, term
+}
+
+// SplitBrainRecovery returns the guided recovery steps for an alarm on the given node.
+func SplitBrainRecovery(nodeID types.NodeID) []string {
+	return []string{
+		fmt.Sprintf("Inspect the state of %s and decide which partition holds the authoritative data.", nodeID),
+		fmt.Sprintf("To discard the node's diverged state and merge it into this mesh, run: wmctl resolve-split-brain %s --action %s", nodeID, SplitBrainRejoin),
+		fmt.Sprintf("To keep the node out of this mesh, stop it and run: wmctl resolve-split-brain %s --action %s", nodeID, SplitBrainDismiss),
+		"If the other partition is authoritative, restore this mesh from a backup of it instead of merging.",
+	}
+}
+
+// GetSplitBrainAlarm returns the split-brain alarm for the given node.
+func GetSplitBrainAlarm(ctx context.Context, st MeshStorage, nodeID types.NodeID) (SplitBrainAlarm, error) {
+	var alarm SplitBrainAlarm
+	data, err := st.GetValue(ctx, SplitBrainAlarmPrefix.ForString(nodeID.String()))
+	if err != nil {
+		return alarm, err
+	}
+	if err := json.Unmarshal(data, &alarm); err != nil {
+		return alarm, fmt.Errorf("unmarshal split-brain alarm: %w", err)
+	}
+	return alarm, nil
+}
+
+// ListSplitBrainAlarms returns all raised split-brain alarms.
+func ListSplitBrainAlarms(ctx context.Context, st MeshStorage) ([]SplitBrainAlarm, error) {
+	var alarms []SplitBrainAlarm
+	err := st.IterPrefix(ctx, append(SplitBrainAlarmPrefix, '/'), func(key, value []byte) error {
+		var alarm SplitBrainAlarm
+		if err := json.Unmarshal(value, &alarm); err != nil {
+			return fmt.Errorf("unmarshal split-brain alarm %s: %w", key, err)
+		}
+		alarms = append(alarms, alarm)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(alarms, func(i, j int) bool { return alarms[i].NodeID < alarms[j].NodeID })
+	return alarms, nil
+}
+
+// RaiseSplitBrainAlarm records the given alarm. If one is already raised for the
+// node, its attempt count is incremented and the original time is kept.
+func RaiseSplitBrainAlarm(ctx context.Context, st MeshStorage, alarm SplitBrainAlarm) (SplitBrainAlarm, error) {
+	existing, err := GetSplitBrainAlarm(ctx, st, alarm.NodeID)
+	switch {
+	case err == nil:
+		alarm.Time = existing.Time
+		alarm.Attempts = existing.Attempts
+	case errors.IsKeyNotFound(err):
+		alarm.Time = time.Now().UTC()
+	default:
+		return alarm, fmt.Errorf("get split-brain alarm: %w", err)
+	}
+	alarm.Attempts++
+	alarm.AllowRejoin = false
+	alarm.Recovery = SplitBrainRecovery(alarm.NodeID)
+	return alarm, putSplitBrainAlarm(ctx, st, alarm)
+}
+
+// ResolveSplitBrainAlarm resolves the alarm on the given node with the given
+// action, either SplitBrainRejoin or SplitBrainDismiss.
+func ResolveSplitBrainAlarm(ctx context.Context, st MeshStorage, nodeID types.NodeID, action string) error {
+	alarm, err := GetSplitBrainAlarm(ctx, st, nodeID)
+	if err != nil {
+		return fmt.Errorf("get split-brain alarm: %w", err)
+	}
+	switch action {
+	case SplitBrainRejoin:
+		alarm.AllowRejoin = true
+		return putSplitBrainAlarm(ctx, st, alarm)
+	case SplitBrainDismiss:
+		return st.Delete(ctx, SplitBrainAlarmPrefix.ForString(nodeID.String()))
+	default:
+		return fmt.Errorf("unknown split-brain resolution %q", action)
+	}
+}
+
+func putSplitBrainAlarm(ctx context.Context, st MeshStorage, alarm SplitBrainAlarm) error {
+	data, err := json.Marshal(alarm)
+	if err != nil {
+		return fmt.Errorf("marshal split-brain alarm: %w", err)
+	}
+	return st.PutValue(ctx, SplitBrainAlarmPrefix.ForString(alarm.NodeID.String()), data, 0)
+}
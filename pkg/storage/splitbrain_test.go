@@ -0,0 +1,138 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+)
+
+func TestClusterID(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+
+	if _, err := storage.GetClusterID(ctx, st); !errors.IsKeyNotFound(err) {
+		t.Fatalf("expected key not found before the cluster id is created, got %v", err)
+	}
+	results, err := storage.Bootstrap(ctx, meshdb.NewFromStorage(st), &storage.BootstrapOptions{})
+	if err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	id, err := storage.GetClusterID(ctx, st)
+	if err != nil {
+		t.Fatalf("get cluster id: %v", err)
+	}
+	if id == "" || results.ClusterID != id {
+		t.Fatalf("expected bootstrap to store cluster id %q, got %q", results.ClusterID, id)
+	}
+	results, err = storage.Bootstrap(ctx, meshdb.NewFromStorage(st), &storage.BootstrapOptions{ClusterID: "00000000-0000-0000-0000-000000000000"})
+	if !errors.IsAlreadyBootstrapped(err) {
+		t.Fatalf("expected already bootstrapped, got %v", err)
+	}
+	if results.ClusterID != id {
+		t.Fatalf("expected the existing cluster id %q, got %q", id, results.ClusterID)
+	}
+	pinned := badgerdb.NewTestStorage(false)
+	defer pinned.Close()
+	want := "11111111-1111-1111-1111-111111111111"
+	results, err = storage.Bootstrap(ctx, meshdb.NewFromStorage(pinned), &storage.BootstrapOptions{ClusterID: want})
+	if err != nil {
+		t.Fatalf("bootstrap: %v", err)
+	}
+	if got, err := storage.GetClusterID(ctx, pinned); err != nil || got != want || results.ClusterID != want {
+		t.Fatalf("expected pinned cluster id %q, got %q: %v", want, got, err)
+	}
+
+	out := storage.WithClusterLineage(ctx, id, 7)
+	md, _ := metadata.FromOutgoingContext(out)
+	gotID, gotTerm := storage.ClusterLineageFrom(metadata.NewIncomingContext(ctx, md))
+	if gotID != id || gotTerm != 7 {
+		t.Fatalf("expected lineage %q/7, got %q/%d", id, gotID, gotTerm)
+	}
+	if gotID, _ := storage.ClusterLineageFrom(ctx); gotID != "" {
+		t.Fatalf("expected no lineage without metadata, got %q", gotID)
+	}
+}
+
+func TestSplitBrainAlarms(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+
+	raise := func() storage.SplitBrainAlarm {
+		t.Helper()
+		alarm, err := storage.RaiseSplitBrainAlarm(ctx, st, storage.SplitBrainAlarm{
+			NodeID:          "node-a",
+			Reason:          "diverged",
+			LocalClusterID:  "local",
+			RemoteClusterID: "remote",
+		})
+		if err != nil {
+			t.Fatalf("raise alarm: %v", err)
+		}
+		return alarm
+	}
+	first := raise()
+	second := raise()
+	if second.Attempts != 2 || !second.Time.Equal(first.Time) {
+		t.Fatalf("expected the alarm to be updated in place, got %+v", second)
+	}
+	if len(second.Recovery) == 0 {
+		t.Fatal("expected recovery steps on the alarm")
+	}
+	alarms, err := storage.ListSplitBrainAlarms(ctx, st)
+	if err != nil {
+		t.Fatalf("list alarms: %v", err)
+	}
+	if len(alarms) != 1 || alarms[0].NodeID != "node-a" {
+		t.Fatalf("expected one alarm for node-a, got %+v", alarms)
+	}
+
+	if err := storage.ResolveSplitBrainAlarm(ctx, st, "node-a", "merge"); err == nil {
+		t.Fatal("expected an error for an unknown resolution")
+	}
+	if err := storage.ResolveSplitBrainAlarm(ctx, st, "node-a", storage.SplitBrainRejoin); err != nil {
+		t.Fatalf("resolve alarm: %v", err)
+	}
+	alarm, err := storage.GetSplitBrainAlarm(ctx, st, "node-a")
+	if err != nil {
+		t.Fatalf("get alarm: %v", err)
+	}
+	if !alarm.AllowRejoin {
+		t.Fatal("expected the node to be allowed to rejoin")
+	}
+	// A new divergence revokes the pending resolution.
+	if raise().AllowRejoin {
+		t.Fatal("expected raising the alarm again to revoke the rejoin")
+	}
+	if err := storage.ResolveSplitBrainAlarm(ctx, st, "node-a", storage.SplitBrainDismiss); err != nil {
+		t.Fatalf("dismiss alarm: %v", err)
+	}
+	if _, err := storage.GetSplitBrainAlarm(ctx, st, "node-a"); !errors.IsKeyNotFound(err) {
+		t.Fatalf("expected the alarm to be cleared, got %v", err)
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -397,6 +398,10 @@ func TestConsensusStorageConformance(ctx context.Context, t *testing.T, raftStor
 			if err := proto.Unmarshal(data, &snap); err != nil {
 				t.Fatalf("failed to unmarshal snapshot: %v", err)
 			}
+			// Revisions are snapshotted alongside the keys they belong to.
+			snap.Kv = slices.DeleteFunc(snap.Kv, func(item *v1.RaftDataItem) bool {
+				return storage.RevisionsPrefix.Contains(item.Key)
+			})
 			// Make sure the snapshot has the correct keys
 			if len(snap.Kv) != len(snapshotKV) {
 				t.Errorf("expected %d keys, got %d", len(snapshotKV), len(snap.Kv))
@@ -456,6 +461,14 @@ func TestConsensusStorageConformance(ctx context.Context, t *testing.T, raftStor
 				if !bytes.Equal(got, value) {
 					t.Errorf("expected %q, got %q", string(value), string(got))
 				}
+				// The revisions must be restored with the keys.
+				_, version, err := meshStorage.GetValueVersion(ctx, []byte(key))
+				if err != nil {
+					t.Fatalf("failed to get key version: %v", err)
+				}
+				if version == storage.VersionOf(0) {
+					t.Errorf("expected the revision of %q to be restored", key)
+				}
 			}
 			// Make sure the keys we don't want to see are still gone
 			for key := range restoreKV {
@@ -519,6 +532,94 @@ func TestMeshStorageConformance(ctx context.Context, t *testing.T, meshStorage s
 		}
 	})
 
+	t.Run("CompareAndSwap", func(t *testing.T) {
+		key, value := []byte("cas-key"), []byte("value")
+		// Creating a key only succeeds if it does not exist yet.
+		if err := meshStorage.CompareAndSwap(ctx, key, value, storage.NoVersion, 0); err != nil {
+			t.Fatalf("failed to create key: %v", err)
+		}
+		err := meshStorage.CompareAndSwap(ctx, key, value, storage.NoVersion, 0)
+		if !errors.IsVersionConflict(err) {
+			t.Errorf("expected ErrVersionConflict, got %v", err)
+		}
+		// Swapping against a stale version must fail and leave the value alone.
+		err = meshStorage.CompareAndSwap(ctx, key, []byte("stale"), storage.VersionOf(0), 0)
+		if !errors.IsVersionConflict(err) {
+			t.Errorf("expected ErrVersionConflict, got %v", err)
+		}
+		got, version, err := meshStorage.GetValueVersion(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get key: %v", err)
+		}
+		if !bytes.Equal(got, value) {
+			t.Errorf("expected %q, got %q", string(value), string(got))
+		}
+		// Writing the key back to the same value still changes its version.
+		if err := meshStorage.PutValue(ctx, key, []byte("other"), 0); err != nil {
+			t.Fatalf("failed to put key: %v", err)
+		}
+		if err := meshStorage.PutValue(ctx, key, value, 0); err != nil {
+			t.Fatalf("failed to put key: %v", err)
+		}
+		err = meshStorage.CompareAndSwap(ctx, key, []byte("stale"), version, 0)
+		if !errors.IsVersionConflict(err) {
+			t.Errorf("expected ErrVersionConflict after the value was written back, got %v", err)
+		}
+		_, version, err = meshStorage.GetValueVersion(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get key: %v", err)
+		}
+		// Swapping against the current version succeeds.
+		newValue := []byte("new-value")
+		if err := meshStorage.CompareAndSwap(ctx, key, newValue, version, 0); err != nil {
+			t.Fatalf("failed to swap key: %v", err)
+		}
+		got, err = meshStorage.GetValue(ctx, key)
+		if err != nil {
+			t.Fatalf("failed to get key: %v", err)
+		}
+		if !bytes.Equal(got, newValue) {
+			t.Errorf("expected %q, got %q", string(newValue), string(got))
+		}
+		// Clean up
+		if err := meshStorage.Delete(ctx, key); err != nil {
+			t.Fatalf("failed to delete key: %v", err)
+		}
+	})
+
+	t.Run("WriteBatch", func(t *testing.T) {
+		if err := meshStorage.PutValue(ctx, []byte("batch-b"), []byte("value"), 0); err != nil {
+			t.Fatalf("failed to put key: %v", err)
+		}
+		err := meshStorage.WriteBatch(ctx, []storage.WriteOp{
+			{Key: []byte("batch-a"), Value: []byte("value-a")},
+			{Key: []byte("batch-b"), Delete: true},
+			{Key: []byte("batch-c"), Value: []byte("value-c")},
+		})
+		if err != nil {
+			t.Fatalf("failed to write batch: %v", err)
+		}
+		for key, value := range map[string]string{"batch-a": "value-a", "batch-c": "value-c"} {
+			got, err := meshStorage.GetValue(ctx, []byte(key))
+			if err != nil {
+				t.Fatalf("failed to get key: %v", err)
+			}
+			if string(got) != value {
+				t.Errorf("expected %q, got %q", value, string(got))
+			}
+		}
+		_, err = meshStorage.GetValue(ctx, []byte("batch-b"))
+		if !errors.IsKeyNotFound(err) {
+			t.Errorf("expected ErrKeyNotFound, got %v", err)
+		}
+		// Clean up
+		for _, key := range []string{"batch-a", "batch-c"} {
+			if err := meshStorage.Delete(ctx, []byte(key)); err != nil {
+				t.Fatalf("failed to delete key: %v", err)
+			}
+		}
+	})
+
 	t.Run("Delete", func(t *testing.T) {
 		// Delete should never error, but it should also work
 		// if the key does in fact exist.
@@ -696,4 +797,97 @@ func TestMeshStorageConformance(ctx context.Context, t *testing.T, meshStorage s
 			}
 		}
 	})
+
+	t.Run("Watch", func(t *testing.T) {
+		SkipOnCI(t, "Skipping on CI due to flakiness")
+		var watchTimeout = 15 * time.Second
+		if err := meshStorage.PutValue(ctx, []byte("Watch/existing"), []byte("value"), 0); err != nil {
+			t.Fatalf("failed to put key: %v", err)
+		}
+		defer func() {
+			_ = meshStorage.Delete(ctx, []byte("Watch/existing"))
+		}()
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		events, err := meshStorage.Watch(watchCtx, []byte("Watch/"))
+		if err != nil {
+			t.Fatalf("failed to watch: %v", err)
+		}
+		expect := func(typ storage.WatchEventType, key, value string) {
+			t.Helper()
+			select {
+			case ev, ok := <-events:
+				if !ok {
+					t.Fatal("watch closed unexpectedly")
+				}
+				if ev.Type != typ || string(ev.Key) != key || string(ev.Value) != value {
+					t.Fatalf("expected %s %q=%q, got %s %q=%q", typ, key, value, ev.Type, ev.Key, ev.Value)
+				}
+			case <-time.After(watchTimeout):
+				t.Fatalf("timed out waiting for %s of %q", typ, key)
+			}
+		}
+		// The existing key is sent first.
+		expect(storage.WatchCreate, "Watch/existing", "value")
+		if err := meshStorage.PutValue(ctx, []byte("Watch/key"), []byte("value1"), 0); err != nil {
+			t.Fatalf("failed to put key: %v", err)
+		}
+		expect(storage.WatchCreate, "Watch/key", "value1")
+		if err := meshStorage.PutValue(ctx, []byte("Watch/key"), []byte("value2"), 0); err != nil {
+			t.Fatalf("failed to put key: %v", err)
+		}
+		expect(storage.WatchUpdate, "Watch/key", "value2")
+		if err := meshStorage.Delete(ctx, []byte("Watch/key")); err != nil {
+			t.Fatalf("failed to delete key: %v", err)
+		}
+		expect(storage.WatchDelete, "Watch/key", "")
+		// The channel is closed once the context is canceled.
+		cancel()
+		closed := make(chan struct{})
+		go func() {
+			defer close(closed)
+			for range events {
+			}
+		}()
+		select {
+		case <-closed:
+		case <-time.After(watchTimeout):
+			t.Fatal("watch was not closed after cancel")
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		SkipOnCI(t, "Skipping on CI due to flakiness")
+		var expiryTimeout = 15 * time.Second
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		events, err := meshStorage.Watch(watchCtx, []byte("TTL/"))
+		if err != nil {
+			t.Fatalf("failed to watch: %v", err)
+		}
+		if err := meshStorage.PutValue(ctx, []byte("TTL/key"), []byte("value"), time.Second); err != nil {
+			t.Fatalf("failed to put key: %v", err)
+		}
+		for _, typ := range []storage.WatchEventType{storage.WatchCreate, storage.WatchDelete} {
+			select {
+			case ev := <-events:
+				if ev.Type != typ || string(ev.Key) != "TTL/key" {
+					t.Fatalf("expected %s of %q, got %s of %q", typ, "TTL/key", ev.Type, ev.Key)
+				}
+			case <-time.After(expiryTimeout):
+				t.Fatalf("timed out waiting for %s of %q", typ, "TTL/key")
+			}
+		}
+		_, err = meshStorage.GetValue(ctx, []byte("TTL/key"))
+		if !errors.IsKeyNotFound(err) {
+			t.Errorf("expected ErrKeyNotFound for expired key, got %v", err)
+		}
+		keys, err := meshStorage.ListKeys(ctx, []byte("TTL/"))
+		if err != nil {
+			t.Fatalf("failed to list keys: %v", err)
+		}
+		if len(keys) != 0 {
+			t.Errorf("expected no keys after expiry, got %q", keys)
+		}
+	})
 }
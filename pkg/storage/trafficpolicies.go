@@ -0,0 +1,102 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// TrafficPoliciesPrefix is where traffic policies are stored in the database.
+// Policies are indexed by name in the format /registry/traffic-policies/<name>.
+var TrafficPoliciesPrefix = types.RegistryPrefix.ForString("traffic-policies")
+
+// GetTrafficPolicy returns the traffic policy with the given name. False is
+// returned if it does not exist.
+func GetTrafficPolicy(ctx context.Context, st MeshStorage, name string) (types.TrafficPolicy, bool, error) {
+	var policy types.TrafficPolicy
+	data, err := st.GetValue(ctx, TrafficPoliciesPrefix.ForString(name))
+	if err != nil {
+		if errors.IsKeyNotFound(err) {
+			return policy, false, nil
+		}
+		return policy, false, fmt.Errorf("get traffic policy: %w", err)
+	}
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return policy, false, fmt.Errorf("unmarshal traffic policy: %w", err)
+	}
+	return policy, true, nil
+}
+
+// PutTrafficPolicy creates or replaces the traffic policy with the given name.
+func PutTrafficPolicy(ctx context.Context, st MeshStorage, name string, policy types.TrafficPolicy) error {
+	if !types.IsValidID(name) {
+		return fmt.Errorf("invalid traffic policy name %q", name)
+	}
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(policy)
+	if err != nil {
+		return fmt.Errorf("marshal traffic policy: %w", err)
+	}
+	if err := st.PutValue(ctx, TrafficPoliciesPrefix.ForString(name), data, 0); err != nil {
+		return fmt.Errorf("put traffic policy: %w", err)
+	}
+	return nil
+}
+
+// DeleteTrafficPolicy removes the traffic policy with the given name.
+func DeleteTrafficPolicy(ctx context.Context, st MeshStorage, name string) error {
+	if err := st.Delete(ctx, TrafficPoliciesPrefix.ForString(name)); err != nil && !errors.IsKeyNotFound(err) {
+		return fmt.Errorf("delete traffic policy: %w", err)
+	}
+	return nil
+}
+
+// ListTrafficPolicies returns all traffic policies.
+func ListTrafficPolicies(ctx context.Context, st MeshStorage) (types.TrafficPolicies, error) {
+	out := make(types.TrafficPolicies)
+	prefix := append(TrafficPoliciesPrefix, '/')
+	err := st.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		var policy types.TrafficPolicy
+		if err := json.Unmarshal(value, &policy); err != nil {
+			return fmt.Errorf("unmarshal traffic policy %s: %w", key, err)
+		}
+		out[string(bytes.TrimPrefix(key, prefix))] = policy
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list traffic policies: %w", err)
+	}
+	return out, nil
+}
+
+// TrafficPoliciesFor loads the traffic policies for the given database. There
+// are none if the database does not expose its underlying storage.
+func TrafficPoliciesFor(ctx context.Context, db MeshDB) (types.TrafficPolicies, error) {
+	st := MeshStorageOf(db)
+	if st == nil {
+		return types.TrafficPolicies{}, nil
+	}
+	return ListTrafficPolicies(ctx, st)
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"container/heap"
+	"context"
+	"sync"
+	"time"
+)
+
+// ExpireFunc is called by a TTLReaper with a key whose TTL has passed and the
+// time it was due to expire.
+type ExpireFunc func(key []byte, at time.Time)
+
+// TTLReaper tracks when keys written with a TTL expire and calls an ExpireFunc
+// once each of them has. Storage implementations use it to remove expired keys
+// in the background and to deliver the removal to subscribers. Expiry times are
+// kept in memory, so implementations should track any keys that already carry
+// a TTL when they are opened.
+type TTLReaper struct {
+	expire ExpireFunc
+	keys   map[string]time.Time
+	queue  expiryQueue
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+	mu     sync.Mutex
+}
+
+// NewTTLReaper starts a new TTLReaper calling fn for expired keys. The reaper
+// runs until it is closed.
+func NewTTLReaper(fn ExpireFunc) *TTLReaper {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &TTLReaper{
+		expire: fn,
+		keys:   make(map[string]time.Time),
+		wake:   make(chan struct{}, 1),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	go r.run(ctx)
+	return r
+}
+
+// Track sets the time at which the given key expires, replacing any previous
+// expiry. A zero time stops tracking the key.
+func (r *TTLReaper) Track(key []byte, at time.Time) {
+	if at.IsZero() {
+		r.Forget(key)
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys[string(key)] = at
+	heap.Push(&r.queue, expiry{key: string(key), at: at})
+	if len(r.queue) > 2*len(r.keys)+1024 {
+		r.compact()
+	}
+	if r.queue[0].at.Equal(at) {
+		r.notify()
+	}
+}
+
+// Forget stops tracking the given key.
+func (r *TTLReaper) Forget(key []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.keys, string(key))
+}
+
+// Reset stops tracking all keys.
+func (r *TTLReaper) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.keys = make(map[string]time.Time)
+	r.queue = nil
+}
+
+// Expiry returns the time at which the given key expires, if it is tracked.
+func (r *TTLReaper) Expiry(key []byte) (time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	at, ok := r.keys[string(key)]
+	return at, ok
+}
+
+// Close stops the reaper and waits for any ExpireFunc in progress to return.
+func (r *TTLReaper) Close() {
+	r.cancel()
+	<-r.done
+}
+
+func (r *TTLReaper) notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *TTLReaper) run(ctx context.Context) {
+	defer close(r.done)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		for _, exp := range r.due(time.Now()) {
+			if ctx.Err() != nil {
+				return
+			}
+			r.expire([]byte(exp.key), exp.at)
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(r.next())
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.wake:
+		case <-timer.C:
+		}
+	}
+}
+
+// due removes and returns the keys that have expired by now.
+func (r *TTLReaper) due(now time.Time) []expiry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var out []expiry
+	for len(r.queue) > 0 && !r.queue[0].at.After(now) {
+		exp := heap.Pop(&r.queue).(expiry)
+		// Entries are left in the queue when a key is retracked or forgotten,
+		// only the one matching the current expiry counts.
+		if at, ok := r.keys[exp.key]; ok && at.Equal(exp.at) {
+			delete(r.keys, exp.key)
+			out = append(out, exp)
+		}
+	}
+	return out
+}
+
+// compact drops the queue entries left behind by retracked and forgotten keys.
+func (r *TTLReaper) compact() {
+	r.queue = r.queue[:0:0]
+	for key, at := range r.keys {
+		r.queue = append(r.queue, expiry{key: key, at: at})
+	}
+	heap.Init(&r.queue)
+}
+
+// next returns how long to wait until the next key expires.
+func (r *TTLReaper) next() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.queue) == 0 {
+		return time.Hour
+	}
+	return max(time.Until(r.queue[0].at), 0)
+}
+
+type expiry struct {
+	key string
+	at  time.Time
+}
+
+// expiryQueue is a min-heap of expiries ordered by time.
+type expiryQueue []expiry
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].at.Before(q[j].at) }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x any)        { *q = append(*q, x.(expiry)) }
+
+func (q *expiryQueue) Pop() any {
+	old := *q
+	n := len(old)
+	exp := old[n-1]
+	*q = old[:n-1]
+	return exp
+}
@@ -0,0 +1,78 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+)
+
+func TestTTLReaper(t *testing.T) {
+	t.Parallel()
+	var mu sync.Mutex
+	var expired []string
+	done := make(chan struct{}, 8)
+	reaper := storage.NewTTLReaper(func(key []byte, _ time.Time) {
+		mu.Lock()
+		expired = append(expired, string(key))
+		mu.Unlock()
+		done <- struct{}{}
+	})
+	defer reaper.Close()
+
+	now := time.Now()
+	reaper.Track([]byte("late"), now.Add(200*time.Millisecond))
+	reaper.Track([]byte("early"), now.Add(50*time.Millisecond))
+	reaper.Track([]byte("forgotten"), now.Add(10*time.Millisecond))
+	reaper.Forget([]byte("forgotten"))
+	// Tracking a key again replaces its expiry.
+	reaper.Track([]byte("moved"), now.Add(20*time.Millisecond))
+	reaper.Track([]byte("moved"), now.Add(100*time.Millisecond))
+	if at, ok := reaper.Expiry([]byte("moved")); !ok || !at.Equal(now.Add(100*time.Millisecond)) {
+		t.Fatalf("expected moved to expire at %v, got %v", now.Add(100*time.Millisecond), at)
+	}
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for expiry %d", i)
+		}
+	}
+	select {
+	case <-done:
+		t.Fatal("expected only three keys to expire")
+	case <-time.After(100 * time.Millisecond):
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{"early", "moved", "late"}
+	if len(expired) != len(want) {
+		t.Fatalf("expected %v to expire, got %v", want, expired)
+	}
+	for i, key := range want {
+		if expired[i] != key {
+			t.Fatalf("expected %v to expire in order, got %v", want, expired)
+		}
+	}
+	if _, ok := reaper.Expiry([]byte("late")); ok {
+		t.Error("expected expired keys to no longer be tracked")
+	}
+}
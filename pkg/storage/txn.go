@@ -0,0 +1,329 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// ShardedBatchesPrefix is where sharded storage stages batches spanning more
+// than one shard until every shard has applied them.
+var ShardedBatchesPrefix = types.RegistryPrefix.ForString("sharded-batches")
+
+// WriteOp is a single write in a batch applied to a MeshStorage.
+type WriteOp struct {
+	// Key is the key being written.
+	Key []byte
+	// Value is the value to set. It is ignored for deletes.
+	Value []byte
+	// TTL is the optional time to live of the value.
+	TTL time.Duration
+	// Delete is true if the key should be removed.
+	Delete bool
+}
+
+// ApplyWriteOps applies the given writes to the storage one at a time in order.
+// It is meant for MeshStorage implementations that have no way to apply writes
+// atomically. A failed write leaves the ones before it in place.
+func ApplyWriteOps(ctx context.Context, st MeshStorage, ops []WriteOp) error {
+	for _, op := range ops {
+		var err error
+		if op.Delete {
+			err = st.Delete(ctx, op.Key)
+		} else {
+			err = st.PutValue(ctx, op.Key, op.Value, op.TTL)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MarshalWriteOps encodes the given writes for transmission. They are encoded as
+// a repeated field of RaftLogEntry messages, the same as a message with a single
+// "repeated RaftLogEntry entries = 1" field would be.
+func MarshalWriteOps(ops []WriteOp) ([]byte, error) {
+	var data []byte
+	for _, op := range ops {
+		entry := &v1.RaftLogEntry{
+			Type:  v1.RaftCommandType_PUT,
+			Key:   op.Key,
+			Value: op.Value,
+			Ttl:   durationpb.New(op.TTL),
+		}
+		if op.Delete {
+			entry = &v1.RaftLogEntry{
+				Type: v1.RaftCommandType_DELETE,
+				Key:  op.Key,
+			}
+		}
+		b, err := proto.Marshal(entry)
+		if err != nil {
+			return nil, fmt.Errorf("marshal write op: %w", err)
+		}
+		data = protowire.AppendTag(data, 1, protowire.BytesType)
+		data = protowire.AppendBytes(data, b)
+	}
+	return data, nil
+}
+
+// UnmarshalWriteOps decodes writes encoded with MarshalWriteOps.
+func UnmarshalWriteOps(data []byte) ([]WriteOp, error) {
+	var ops []WriteOp
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, fmt.Errorf("decode write ops: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		if num != 1 || typ != protowire.BytesType {
+			return nil, fmt.Errorf("decode write ops: unexpected field %d", num)
+		}
+		b, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, fmt.Errorf("decode write ops: %w", protowire.ParseError(n))
+		}
+		data = data[n:]
+		var entry v1.RaftLogEntry
+		if err := proto.Unmarshal(b, &entry); err != nil {
+			return nil, fmt.Errorf("unmarshal write op: %w", err)
+		}
+		switch entry.GetType() {
+		case v1.RaftCommandType_PUT:
+			ops = append(ops, WriteOp{
+				Key:   entry.GetKey(),
+				Value: entry.GetValue(),
+				TTL:   entry.GetTtl().AsDuration(),
+			})
+		case v1.RaftCommandType_DELETE:
+			ops = append(ops, WriteOp{
+				Key:    entry.GetKey(),
+				Delete: true,
+			})
+		default:
+			return nil, fmt.Errorf("unsupported write op type: %v", entry.GetType())
+		}
+	}
+	return ops, nil
+}
+
+// Ensure TxnStorage satisfies the MeshStorage interface.
+var _ MeshStorage = &TxnStorage{}
+
+// TxnStorage is a MeshStorage that buffers writes on top of another MeshStorage
+// until they are committed. Reads through the TxnStorage observe the buffered
+// writes. It is used to build transactions out of the regular meshdb modules.
+type TxnStorage struct {
+	base  MeshStorage
+	ops   []WriteOp
+	index map[string]int
+	mu    sync.Mutex
+}
+
+// NewTxnStorage returns a new TxnStorage buffering writes to the given storage.
+func NewTxnStorage(base MeshStorage) *TxnStorage {
+	return &TxnStorage{
+		base:  base,
+		index: make(map[string]int),
+	}
+}
+
+// Ops returns the writes buffered in the transaction.
+func (t *TxnStorage) Ops() []WriteOp {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ops := make([]WriteOp, len(t.ops))
+	copy(ops, t.ops)
+	return ops
+}
+
+// Commit applies all buffered writes to the underlying storage.
+func (t *TxnStorage) Commit(ctx context.Context) error {
+	ops := t.Ops()
+	if len(ops) == 0 {
+		return nil
+	}
+	return t.base.WriteBatch(ctx, ops)
+}
+
+// Close is a no-op. The underlying storage is not closed.
+func (t *TxnStorage) Close() error {
+	return nil
+}
+
+// GetValue returns the value of a key.
+func (t *TxnStorage) GetValue(ctx context.Context, key []byte) ([]byte, error) {
+	t.mu.Lock()
+	op, ok := t.lookup(key)
+	t.mu.Unlock()
+	if ok {
+		if op.Delete {
+			return nil, errors.NewKeyNotFoundError(key)
+		}
+		return op.Value, nil
+	}
+	return t.base.GetValue(ctx, key)
+}
+
+// PutValue buffers setting the value of a key.
+func (t *TxnStorage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buffer(WriteOp{Key: key, Value: value, TTL: ttl})
+	return nil
+}
+
+// WriteBatch buffers the given writes. They are committed along with the
+// rest of the transaction.
+func (t *TxnStorage) WriteBatch(ctx context.Context, ops []WriteOp) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, op := range ops {
+		t.buffer(op)
+	}
+	return nil
+}
+
+// GetValueVersion returns the value of a key along with its version. Keys
+// written in the transaction have no version until it is committed, so
+// ErrVersionConflict is returned for them.
+func (t *TxnStorage) GetValueVersion(ctx context.Context, key []byte) ([]byte, Version, error) {
+	t.mu.Lock()
+	_, ok := t.lookup(key)
+	t.mu.Unlock()
+	if ok {
+		return nil, NoVersion, fmt.Errorf("%w: %s is written by the transaction", errors.ErrVersionConflict, key)
+	}
+	return t.base.GetValueVersion(ctx, key)
+}
+
+// CompareAndSwap buffers setting the value of a key if its current version, as
+// seen by the transaction, matches the expected one. The version is checked at
+// the time of the call and not again on commit.
+func (t *TxnStorage) CompareAndSwap(ctx context.Context, key, value []byte, expected Version, ttl time.Duration) error {
+	_, current, err := t.GetValueVersion(ctx, key)
+	if err != nil && !errors.IsKeyNotFound(err) {
+		return err
+	}
+	if err := CheckVersion(current, expected); err != nil {
+		return err
+	}
+	return t.PutValue(ctx, key, value, ttl)
+}
+
+// Delete buffers removing a key.
+func (t *TxnStorage) Delete(ctx context.Context, key []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.buffer(WriteOp{Key: key, Delete: true})
+	return nil
+}
+
+// ListKeys returns all keys with a given prefix.
+func (t *TxnStorage) ListKeys(ctx context.Context, prefix []byte) ([][]byte, error) {
+	var keys [][]byte
+	err := t.IterPrefix(ctx, prefix, func(key, _ []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// IterPrefix iterates over all keys with a given prefix. Unlike most storage
+// implementations, the iterator may safely call back into the TxnStorage.
+func (t *TxnStorage) IterPrefix(ctx context.Context, prefix []byte, fn PrefixIterator) error {
+	values := make(map[string][]byte)
+	err := t.base.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		values[string(key)] = value
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	for _, op := range t.ops {
+		if !bytes.HasPrefix(op.Key, prefix) {
+			continue
+		}
+		if op.Delete {
+			delete(values, string(op.Key))
+			continue
+		}
+		values[string(op.Key)] = op.Value
+	}
+	t.mu.Unlock()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn([]byte(key), values[key]); err != nil {
+			if errors.Is(err, ErrStopIteration) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe subscribes to changes in the underlying storage. Buffered writes
+// are only seen by subscribers once they are committed.
+func (t *TxnStorage) Subscribe(ctx context.Context, prefix []byte, fn KVSubscribeFunc) (context.CancelFunc, error) {
+	return t.base.Subscribe(ctx, prefix, fn)
+}
+
+// Watch watches the underlying storage. Like Subscribe, buffered writes are
+// only seen once they are committed.
+func (t *TxnStorage) Watch(ctx context.Context, prefix []byte) (<-chan WatchEvent, error) {
+	return t.base.Watch(ctx, prefix)
+}
+
+func (t *TxnStorage) lookup(key []byte) (WriteOp, bool) {
+	i, ok := t.index[string(key)]
+	if !ok {
+		return WriteOp{}, false
+	}
+	return t.ops[i], true
+}
+
+func (t *TxnStorage) buffer(op WriteOp) {
+	if i, ok := t.index[string(op.Key)]; ok {
+		t.ops[i] = op
+		return
+	}
+	t.index[string(op.Key)] = len(t.ops)
+	t.ops = append(t.ops, op)
+}
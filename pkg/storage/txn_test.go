@@ -0,0 +1,178 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/storage"
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/meshdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/providers/backends/badgerdb"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+func TestTxn(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("Commit", func(t *testing.T) {
+		st := badgerdb.NewTestStorage(false)
+		defer st.Close()
+		db := meshdb.NewFromStorage(st)
+		err := db.Txn(ctx, func(tx storage.MeshDB) error {
+			for _, node := range []string{"node-a", "node-b"} {
+				if err := tx.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: node}}); err != nil {
+					return err
+				}
+			}
+			// The edge validation has to see the nodes buffered above.
+			return tx.Peers().PutEdge(ctx, types.MeshEdge{MeshEdge: &v1.MeshEdge{Source: "node-a", Target: "node-b", Weight: 1}})
+		})
+		if err != nil {
+			t.Fatalf("txn: %v", err)
+		}
+		if _, err := db.Peers().GetEdge(ctx, "node-a", "node-b"); err != nil {
+			t.Fatalf("get edge: %v", err)
+		}
+	})
+
+	t.Run("Abort", func(t *testing.T) {
+		st := badgerdb.NewTestStorage(false)
+		defer st.Close()
+		db := meshdb.NewFromStorage(st)
+		cause := fmt.Errorf("abort")
+		err := db.Txn(ctx, func(tx storage.MeshDB) error {
+			if err := tx.Peers().Put(ctx, types.MeshNode{MeshNode: &v1.MeshNode{Id: "node-a"}}); err != nil {
+				return err
+			}
+			if _, err := tx.Peers().Get(ctx, "node-a"); err != nil {
+				return err
+			}
+			return cause
+		})
+		if !errors.Is(err, cause) {
+			t.Fatalf("expected abort error, got %v", err)
+		}
+		if _, err := db.Peers().Get(ctx, "node-a"); !errors.IsNodeNotFound(err) {
+			t.Fatalf("expected node not found, got %v", err)
+		}
+	})
+
+	t.Run("SingleBatch", func(t *testing.T) {
+		st := &countingStorage{MeshStorage: badgerdb.NewTestStorage(false)}
+		defer st.Close()
+		db := meshdb.NewFromStorage(st)
+		err := db.Txn(ctx, func(tx storage.MeshDB) error {
+			err := tx.Networking().PutNetworkACL(ctx, types.NetworkACL{NetworkACL: &v1.NetworkACL{
+				Name:             "acl",
+				Action:           v1.ACLAction_ACTION_ACCEPT,
+				SourceNodes:      []string{"*"},
+				DestinationNodes: []string{"*"},
+			}})
+			if err != nil {
+				return err
+			}
+			return tx.Networking().PutRoute(ctx, types.Route{Route: &v1.Route{
+				Name:             "route",
+				Node:             "node-a",
+				DestinationCIDRs: []string{"10.0.0.0/24"},
+			}})
+		})
+		if err != nil {
+			t.Fatalf("txn: %v", err)
+		}
+		if st.batches != 1 || st.writes != 0 {
+			t.Fatalf("expected a single batch and no other writes, got %d batches and %d writes", st.batches, st.writes)
+		}
+		if _, err := db.Networking().GetNetworkACL(ctx, "acl"); err != nil {
+			t.Fatalf("get acl: %v", err)
+		}
+		if _, err := db.Networking().GetRoute(ctx, "route"); err != nil {
+			t.Fatalf("get route: %v", err)
+		}
+	})
+}
+
+// countingStorage counts the writes made against the wrapped storage.
+type countingStorage struct {
+	storage.MeshStorage
+	batches, writes int
+}
+
+func (c *countingStorage) PutValue(ctx context.Context, key, value []byte, ttl time.Duration) error {
+	c.writes++
+	return c.MeshStorage.PutValue(ctx, key, value, ttl)
+}
+
+func (c *countingStorage) Delete(ctx context.Context, key []byte) error {
+	c.writes++
+	return c.MeshStorage.Delete(ctx, key)
+}
+
+func (c *countingStorage) WriteBatch(ctx context.Context, ops []storage.WriteOp) error {
+	c.batches++
+	return c.MeshStorage.WriteBatch(ctx, ops)
+}
+
+func TestReplaceNetworkACLs(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	st := badgerdb.NewTestStorage(false)
+	defer st.Close()
+	db := meshdb.NewFromStorage(st)
+
+	newACL := func(name string) types.NetworkACL {
+		return types.NetworkACL{NetworkACL: &v1.NetworkACL{
+			Name:             name,
+			Action:           v1.ACLAction_ACTION_ACCEPT,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: []string{"*"},
+		}}
+	}
+	for _, name := range []string{string(storage.BootstrapNodesNetworkACLName), "old-a", "old-b"} {
+		if err := db.Networking().PutNetworkACL(ctx, newACL(name)); err != nil {
+			t.Fatalf("put acl: %v", err)
+		}
+	}
+	err := storage.ReplaceNetworkACLs(ctx, db, types.NetworkACLs{newACL("old-b"), newACL("new-c")})
+	if err != nil {
+		t.Fatalf("replace acls: %v", err)
+	}
+	acls, err := db.Networking().ListNetworkACLs(ctx)
+	if err != nil {
+		t.Fatalf("list acls: %v", err)
+	}
+	got := make(map[string]bool)
+	for _, acl := range acls {
+		got[acl.GetName()] = true
+	}
+	want := []string{string(storage.BootstrapNodesNetworkACLName), "old-b", "new-c"}
+	if len(got) != len(want) {
+		t.Fatalf("expected acls %v, got %v", want, got)
+	}
+	for _, name := range want {
+		if !got[name] {
+			t.Errorf("expected acl %q to exist", name)
+		}
+	}
+}
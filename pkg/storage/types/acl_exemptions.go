@@ -0,0 +1,126 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"context"
+	"fmt"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+)
+
+// Exemption is a kind of traffic that can be allowed between mesh members
+// regardless of the network ACLs.
+type Exemption string
+
+const (
+	// ExemptICMPEcho exempts ICMP echo requests and replies.
+	ExemptICMPEcho Exemption = "icmp-echo"
+	// ExemptMeshDNS exempts queries to nodes serving meshdns.
+	ExemptMeshDNS Exemption = "mesh-dns"
+)
+
+// ACLExemptions are the mesh-wide toggles for traffic that is allowed between
+// members regardless of the network ACLs, with per-ACL overrides.
+type ACLExemptions struct {
+	// ICMPEcho allows ICMP echo between members.
+	ICMPEcho bool `json:"icmpEcho,omitempty"`
+	// MeshDNS allows members to query nodes serving meshdns.
+	MeshDNS bool `json:"meshDNS,omitempty"`
+	// Overrides change whether exempt traffic passes the deny ACL of the
+	// same name, indexed by ACL name. They allow a single ACL to block
+	// exempt traffic while the toggle is on, or to let it through while
+	// the toggle is off.
+	Overrides map[string]ACLExemptionOverride `json:"overrides,omitempty"`
+}
+
+// ACLExemptionOverride overrides the mesh-wide exemptions for a single ACL.
+// Unset fields inherit the mesh-wide toggle.
+type ACLExemptionOverride struct {
+	// ICMPEcho overrides whether ICMP echo passes the ACL.
+	ICMPEcho *bool `json:"icmpEcho,omitempty"`
+	// MeshDNS overrides whether meshdns queries pass the ACL.
+	MeshDNS *bool `json:"meshDNS,omitempty"`
+}
+
+// Validate validates the exemptions.
+func (e ACLExemptions) Validate() error {
+	for name := range e.Overrides {
+		if !IsValidID(name) {
+			return fmt.Errorf("invalid network acl name %q in exemption overrides", name)
+		}
+	}
+	return nil
+}
+
+// Enabled returns true if the given exemption can apply to any traffic, either
+// through the mesh-wide toggle or an override.
+func (e ACLExemptions) Enabled(kind Exemption) bool {
+	if e.meshWide(kind) {
+		return true
+	}
+	for name := range e.Overrides {
+		if e.Exempts(name, kind) {
+			return true
+		}
+	}
+	return false
+}
+
+// Exempts returns true if the given kind of traffic passes the named ACL.
+func (e ACLExemptions) Exempts(aclName string, kind Exemption) bool {
+	override, ok := e.Overrides[aclName]
+	if ok {
+		var value *bool
+		switch kind {
+		case ExemptICMPEcho:
+			value = override.ICMPEcho
+		case ExemptMeshDNS:
+			value = override.MeshDNS
+		}
+		if value != nil {
+			return *value
+		}
+	}
+	return e.meshWide(kind)
+}
+
+func (e ACLExemptions) meshWide(kind Exemption) bool {
+	switch kind {
+	case ExemptICMPEcho:
+		return e.ICMPEcho
+	case ExemptMeshDNS:
+		return e.MeshDNS
+	default:
+		return false
+	}
+}
+
+// AcceptExempt evaluates an action carrying the given kind of exempt traffic
+// against the ACLs in the list. Like Accept, the first matching ACL decides,
+// but a matching deny ACL the traffic is exempt from accepts it, as does the
+// default deny when the mesh-wide toggle is on.
+func (a NetworkACLs) AcceptExempt(ctx context.Context, action NetworkAction, exemptions ACLExemptions, kind Exemption) bool {
+	acl, ok := a.Match(ctx, action)
+	if !ok {
+		return exemptions.meshWide(kind)
+	}
+	if acl.Action == v1.ACLAction_ACTION_ACCEPT {
+		return true
+	}
+	return exemptions.Exempts(acl.GetName(), kind)
+}
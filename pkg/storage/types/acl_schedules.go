@@ -0,0 +1,297 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ACLSchedule restricts when a network ACL is in effect. An ACL with a schedule
+// is only evaluated between its start and end, and during one of its windows
+// if any are given. Outside of its schedule the ACL is skipped as if it did
+// not exist.
+type ACLSchedule struct {
+	// Start is when the ACL comes into effect. The ACL is in effect
+	// immediately when unset.
+	Start *time.Time `json:"start,omitempty"`
+	// End is when the ACL stops being in effect. The ACL never expires
+	// when unset.
+	End *time.Time `json:"end,omitempty"`
+	// Windows are the recurring windows the ACL is in effect during.
+	// The ACL is in effect at all times between Start and End when empty.
+	Windows []ACLWindow `json:"windows,omitempty"`
+}
+
+// ACLWindow is a recurring window of time on certain days of the week.
+type ACLWindow struct {
+	// Days are the days of the week the window opens on, as three letter
+	// abbreviations. The window opens every day when empty.
+	Days []string `json:"days,omitempty"`
+	// From is the time of day the window opens, formatted as HH:MM.
+	From string `json:"from"`
+	// To is the time of day the window closes, formatted as HH:MM. A window
+	// closing at or before the time it opens closes on the following day.
+	To string `json:"to"`
+	// Location is the IANA time zone the window is in. Defaults to UTC.
+	Location string `json:"location,omitempty"`
+}
+
+// ParseACLWindow parses a window in the format "[DAYS ]HH:MM-HH:MM[ LOCATION]",
+// where DAYS is a comma separated list of days or day ranges, e.g.
+// "mon-fri 02:00-04:00 Europe/Berlin" or "sat,sun 22:00-06:00".
+func ParseACLWindow(spec string) (ACLWindow, error) {
+	var window ACLWindow
+	fields := strings.Fields(spec)
+	if len(fields) == 0 || len(fields) > 3 {
+		return window, fmt.Errorf("invalid window %q, expected [DAYS ]HH:MM-HH:MM[ LOCATION]", spec)
+	}
+	if !strings.Contains(fields[0], ":") {
+		for _, part := range strings.Split(fields[0], ",") {
+			first, last, isRange := strings.Cut(part, "-")
+			if !isRange {
+				window.Days = append(window.Days, strings.ToLower(first))
+				continue
+			}
+			start, ok := parseWeekday(first)
+			if !ok {
+				return window, fmt.Errorf("invalid day %q in window %q", first, spec)
+			}
+			end, ok := parseWeekday(last)
+			if !ok {
+				return window, fmt.Errorf("invalid day %q in window %q", last, spec)
+			}
+			for day := start; ; day = (day + 1) % 7 {
+				window.Days = append(window.Days, weekdayNames[day])
+				if day == end {
+					break
+				}
+			}
+		}
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return window, fmt.Errorf("invalid window %q, missing time of day", spec)
+	}
+	var ok bool
+	window.From, window.To, ok = strings.Cut(fields[0], "-")
+	if !ok {
+		return window, fmt.Errorf("invalid window %q, expected HH:MM-HH:MM", spec)
+	}
+	if len(fields) > 1 {
+		window.Location = fields[1]
+	}
+	return window, window.Validate()
+}
+
+// Validate validates the schedule.
+func (s ACLSchedule) Validate() error {
+	if s.Start != nil && s.End != nil && !s.End.After(*s.Start) {
+		return fmt.Errorf("schedule end must be after its start")
+	}
+	for _, window := range s.Windows {
+		if err := window.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate validates the window.
+func (w ACLWindow) Validate() error {
+	for _, day := range w.Days {
+		if _, ok := parseWeekday(day); !ok {
+			return fmt.Errorf("invalid day %q in window", day)
+		}
+	}
+	if _, err := parseTimeOfDay(w.From); err != nil {
+		return err
+	}
+	if _, err := parseTimeOfDay(w.To); err != nil {
+		return err
+	}
+	if _, err := w.location(); err != nil {
+		return fmt.Errorf("invalid location %q in window: %w", w.Location, err)
+	}
+	return nil
+}
+
+// Active returns true if the ACL is in effect at the given time.
+func (s ACLSchedule) Active(t time.Time) bool {
+	if s.Start != nil && t.Before(*s.Start) {
+		return false
+	}
+	if s.End != nil && !t.Before(*s.End) {
+		return false
+	}
+	if len(s.Windows) == 0 {
+		return true
+	}
+	for _, window := range s.Windows {
+		for _, span := range window.spans(t, 1) {
+			if !t.Before(span[0]) && t.Before(span[1]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NextTransition returns the first time after the given time the ACL comes
+// into or goes out of effect. It returns the zero time if the schedule never
+// changes again.
+func (s ACLSchedule) NextTransition(after time.Time) time.Time {
+	var next time.Time
+	consider := func(t time.Time) {
+		if t.After(after) && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	if s.Start != nil {
+		consider(*s.Start)
+	}
+	if s.End != nil {
+		consider(*s.End)
+	}
+	for _, window := range s.Windows {
+		// A week from now covers every window.
+		for _, span := range window.spans(after, 8) {
+			consider(span[0])
+			consider(span[1])
+		}
+	}
+	if !next.IsZero() && s.End != nil && next.After(*s.End) {
+		return time.Time{}
+	}
+	return next
+}
+
+// spans returns the occurrences of the window starting on the day before the
+// given time and the given number of days after it.
+func (w ACLWindow) spans(t time.Time, days int) [][2]time.Time {
+	loc, err := w.location()
+	if err != nil {
+		return nil
+	}
+	from, err := parseTimeOfDay(w.From)
+	if err != nil {
+		return nil
+	}
+	to, err := parseTimeOfDay(w.To)
+	if err != nil {
+		return nil
+	}
+	t = t.In(loc)
+	var out [][2]time.Time
+	for offset := -1; offset <= days; offset++ {
+		start := time.Date(t.Year(), t.Month(), t.Day()+offset, 0, from, 0, 0, loc)
+		if !w.opensOn(start.Weekday()) {
+			continue
+		}
+		end := time.Date(t.Year(), t.Month(), t.Day()+offset, 0, to, 0, 0, loc)
+		if to <= from {
+			end = time.Date(t.Year(), t.Month(), t.Day()+offset+1, 0, to, 0, 0, loc)
+		}
+		out = append(out, [2]time.Time{start, end})
+	}
+	return out
+}
+
+func (w ACLWindow) opensOn(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, name := range w.Days {
+		if d, ok := parseWeekday(name); ok && d == day {
+			return true
+		}
+	}
+	return false
+}
+
+func (w ACLWindow) location() (*time.Location, error) {
+	if w.Location == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(w.Location)
+}
+
+// ACLSchedules are the schedules of network ACLs, indexed by ACL name.
+type ACLSchedules map[string]ACLSchedule
+
+// Active returns true if the named ACL is in effect at the given time. ACLs
+// without a schedule are always in effect.
+func (s ACLSchedules) Active(name string, t time.Time) bool {
+	schedule, ok := s[name]
+	if !ok {
+		return true
+	}
+	return schedule.Active(t)
+}
+
+// NextTransition returns the first time after the given time any ACL comes
+// into or goes out of effect, or the zero time if none ever do again.
+func (s ACLSchedules) NextTransition(after time.Time) time.Time {
+	var next time.Time
+	for _, schedule := range s {
+		t := schedule.NextTransition(after)
+		if !t.IsZero() && (next.IsZero() || t.Before(next)) {
+			next = t
+		}
+	}
+	return next
+}
+
+// ActiveAt returns the ACLs in the list that are in effect at the given time
+// according to the schedules.
+func (a NetworkACLs) ActiveAt(schedules ACLSchedules, t time.Time) NetworkACLs {
+	if len(schedules) == 0 {
+		return a
+	}
+	out := make(NetworkACLs, 0, len(a))
+	for _, acl := range a {
+		if schedules.Active(acl.GetName(), t) {
+			out = append(out, acl)
+		}
+	}
+	return out
+}
+
+var weekdayNames = [...]string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(name)
+	if len(name) > 3 {
+		name = name[:3]
+	}
+	for i, day := range weekdayNames {
+		if day == name {
+			return time.Weekday(i), true
+		}
+	}
+	return 0, false
+}
+
+// parseTimeOfDay parses a HH:MM time of day into the minutes since midnight.
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, expected HH:MM", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
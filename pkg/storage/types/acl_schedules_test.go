@@ -0,0 +1,153 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"testing"
+	"time"
+)
+
+func TestACLSchedule(t *testing.T) {
+	t.Parallel()
+	at := func(s string) time.Time {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			panic(err)
+		}
+		return t
+	}
+	ptr := func(s string) *time.Time {
+		t := at(s)
+		return &t
+	}
+	weekdays, err := ParseACLWindow("mon-fri 02:00-04:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	overnight, err := ParseACLWindow("sat 22:00-06:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tc := []struct {
+		name     string
+		schedule ACLSchedule
+		// 2024-01-01 is a Monday.
+		at     string
+		active bool
+		next   string
+	}{
+		{
+			name:     "BeforeStart",
+			schedule: ACLSchedule{Start: ptr("2024-01-01T00:00:00Z")},
+			at:       "2023-12-31T23:00:00Z",
+			active:   false,
+			next:     "2024-01-01T00:00:00Z",
+		},
+		{
+			name:     "AfterEnd",
+			schedule: ACLSchedule{End: ptr("2024-01-01T00:00:00Z")},
+			at:       "2024-01-01T00:00:00Z",
+			active:   false,
+		},
+		{
+			name:     "InWeekdayWindow",
+			schedule: ACLSchedule{Windows: []ACLWindow{weekdays}},
+			at:       "2024-01-01T03:00:00Z",
+			active:   true,
+			next:     "2024-01-01T04:00:00Z",
+		},
+		{
+			name:     "OutsideWeekdayWindowOnFriday",
+			schedule: ACLSchedule{Windows: []ACLWindow{weekdays}},
+			at:       "2024-01-05T05:00:00Z",
+			active:   false,
+			next:     "2024-01-08T02:00:00Z",
+		},
+		{
+			name:     "OvernightWindowAfterMidnight",
+			schedule: ACLSchedule{Windows: []ACLWindow{overnight}},
+			at:       "2024-01-07T01:00:00Z",
+			active:   true,
+			next:     "2024-01-07T06:00:00Z",
+		},
+		{
+			name:     "WindowAfterEnd",
+			schedule: ACLSchedule{End: ptr("2024-01-01T01:00:00Z"), Windows: []ACLWindow{weekdays}},
+			at:       "2024-01-01T00:00:00Z",
+			active:   false,
+			next:     "2024-01-01T01:00:00Z",
+		},
+		{
+			name:     "WindowInTimeZone",
+			schedule: ACLSchedule{Windows: []ACLWindow{{From: "09:00", To: "17:00", Location: "America/New_York"}}},
+			at:       "2024-01-01T15:00:00Z",
+			active:   true,
+			next:     "2024-01-01T22:00:00Z",
+		},
+	}
+	for _, tt := range tc {
+		if err := tt.schedule.Validate(); err != nil {
+			t.Errorf("%s: unexpected validation error: %v", tt.name, err)
+			continue
+		}
+		if got := tt.schedule.Active(at(tt.at)); got != tt.active {
+			t.Errorf("%s: expected active %v, got %v", tt.name, tt.active, got)
+		}
+		var want time.Time
+		if tt.next != "" {
+			want = at(tt.next)
+		}
+		if got := tt.schedule.NextTransition(at(tt.at)); !got.Equal(want) {
+			t.Errorf("%s: expected next transition %v, got %v", tt.name, want, got)
+		}
+	}
+}
+
+func TestParseACLWindow(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name    string
+		spec    string
+		days    int
+		wantErr bool
+	}{
+		{name: "EveryDay", spec: "02:00-04:00", days: 0},
+		{name: "DayRange", spec: "mon-fri 02:00-04:00", days: 5},
+		{name: "WrappingDayRange", spec: "fri-mon 02:00-04:00 UTC", days: 4},
+		{name: "DayList", spec: "sat,sun 22:00-06:00", days: 2},
+		{name: "InvalidDay", spec: "funday 02:00-04:00", wantErr: true},
+		{name: "InvalidTime", spec: "mon 25:00-04:00", wantErr: true},
+		{name: "MissingTime", spec: "mon", wantErr: true},
+		{name: "InvalidLocation", spec: "02:00-04:00 Nowhere/Special", wantErr: true},
+	}
+	for _, tt := range tc {
+		window, err := ParseACLWindow(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		if len(window.Days) != tt.days {
+			t.Errorf("%s: expected %d days, got %v", tt.name, tt.days, window.Days)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// The fuzz targets in this file cover values that are read from storage or
+// received from other mesh members. Failing inputs are written to
+// testdata/fuzz/<target> by the go tool and are replayed by go test.
+
+// FuzzNetworkACLAccept checks that evaluating arbitrary ACLs against arbitrary
+// actions never panics and agrees with the first matching ACL.
+func FuzzNetworkACLAccept(f *testing.F) {
+	f.Add([]byte(`{"name":"allow","action":"ACTION_ACCEPT","sourceNodes":["*"],"destinationNodes":["*"]}`), "node-a", "10.0.0.1/32", "node-b", "10.0.0.2/32")
+	f.Add([]byte(`{"name":"deny","priority":10,"action":"ACTION_DENY","sourceCidrs":["10.0.0.0/8"],"destinationCidrs":["*"]}`), "", "10.1.2.3/32", "", "fd00::1/128")
+	f.Add([]byte(`{"name":"group","sourceNodes":["group:admins"],"destinationCidrs":["tag:web"],"protocol":"tcp","ports":[443]}`), "admin", "", "web", "")
+	f.Add([]byte(`{}`), "", "", "", "")
+	f.Fuzz(func(t *testing.T, data []byte, srcNode, srcCIDR, dstNode, dstCIDR string) {
+		var acl NetworkACL
+		if err := acl.UnmarshalProtoJSON(data); err != nil {
+			return
+		}
+		acls := NetworkACLs{acl}
+		acls.Sort(SortDescending)
+		action := NetworkAction{NetworkAction: &v1.NetworkAction{
+			SrcNode: srcNode,
+			SrcCIDR: srcCIDR,
+			DstNode: dstNode,
+			DstCIDR: dstCIDR,
+		}}
+		ctx := context.Background()
+		want := acl.Matches(ctx, action) && acl.GetAction() == v1.ACLAction_ACTION_ACCEPT
+		if got := acls.Accept(ctx, action); got != want {
+			t.Fatalf("expected accept to be %v for acl %s and action %s", want, data, action)
+		}
+		if (NetworkACLs{}).Accept(ctx, action) {
+			t.Fatal("expected an empty ACL list to deny the action")
+		}
+		_ = ValidateACL(acl)
+	})
+}
+
+// FuzzUnmarshalProtoJSON checks that the storage codecs never panic on
+// arbitrary input and that anything they accept survives a round trip.
+func FuzzUnmarshalProtoJSON(f *testing.F) {
+	f.Add([]byte(`{"id":"node-a","primaryEndpoint":"1.2.3.4","privateIPv4":"10.0.0.1/32","features":[{"feature":"NODES","port":8443}]}`))
+	f.Add([]byte(`{"name":"acl","action":"ACTION_DENY","sourceCidrs":["*"]}`))
+	f.Add([]byte(`{"name":"route","node":"node-a","destinationCidrs":["0.0.0.0/0"]}`))
+	f.Add([]byte(`{"source":"node-a","target":"node-b","weight":"1","attributes":{"a":"b"}}`))
+	f.Add([]byte(`null`))
+	decoders := map[string]func([]byte) (proto.Message, error){
+		"MeshNode": func(b []byte) (proto.Message, error) {
+			var v MeshNode
+			err := v.UnmarshalProtoJSON(b)
+			return v.MeshNode, err
+		},
+		"NetworkACL": func(b []byte) (proto.Message, error) {
+			var v NetworkACL
+			err := v.UnmarshalProtoJSON(b)
+			return v.NetworkACL, err
+		},
+		"Route": func(b []byte) (proto.Message, error) {
+			var v Route
+			err := v.UnmarshalProtoJSON(b)
+			return v.Route, err
+		},
+		"MeshEdge": func(b []byte) (proto.Message, error) {
+			var v MeshEdge
+			err := v.UnmarshalProtoJSON(b)
+			return v.MeshEdge, err
+		},
+		"Role": func(b []byte) (proto.Message, error) {
+			var v Role
+			err := v.UnmarshalProtoJSON(b)
+			return v.Role, err
+		},
+		"Group": func(b []byte) (proto.Message, error) {
+			var v Group
+			err := v.UnmarshalProtoJSON(b)
+			return v.Group, err
+		},
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for name, decode := range decoders {
+			msg, err := decode(data)
+			if err != nil {
+				continue
+			}
+			out, err := protojson.Marshal(msg)
+			if err != nil {
+				t.Fatalf("marshal %s decoded from %q: %v", name, data, err)
+			}
+			again, err := decode(out)
+			if err != nil {
+				t.Fatalf("unmarshal %s from its own encoding %q: %v", name, out, err)
+			}
+			if !proto.Equal(msg, again) {
+				t.Fatalf("%s changed across a round trip: %q != %q", name, data, out)
+			}
+		}
+	})
+}
+
+// FuzzStoragePrefix checks the helpers used to build and strip storage keys.
+func FuzzStoragePrefix(f *testing.F) {
+	f.Add([]byte("/registry"), []byte("peers/node-a"))
+	f.Add([]byte("/registry/network-acls"), []byte("/bootstrap-nodes"))
+	f.Add([]byte("/raft"), []byte(""))
+	f.Add([]byte(""), []byte("//"))
+	f.Fuzz(func(t *testing.T, prefix, key []byte) {
+		p := StoragePrefix(prefix)
+		full := p.For(key)
+		if !p.Contains(full) {
+			t.Fatalf("expected %q to contain %q", p, full)
+		}
+		trimmed := p.TrimFrom(full)
+		if want := bytes.TrimPrefix(key, []byte("/")); !bytes.Equal(trimmed, want) {
+			t.Fatalf("expected %q trimmed from %q to be %q, got %q", p, full, want, trimmed)
+		}
+		for _, reserved := range ReservedPrefixes {
+			if !IsReservedPrefix(reserved.For(key)) {
+				t.Fatalf("expected %q to be reserved", reserved.For(key))
+			}
+		}
+	})
+}
+
+// FuzzPrefixJSON checks that a Prefix survives a round trip through JSON and
+// that decoding arbitrary JSON never panics.
+func FuzzPrefixJSON(f *testing.F) {
+	f.Add("10.0.0.0/8")
+	f.Add("fd00::/64")
+	f.Add("")
+	f.Add("null")
+	f.Add(`"not-a-prefix"`)
+	f.Fuzz(func(t *testing.T, data string) {
+		var p Prefix
+		_ = json.Unmarshal([]byte(data), &p)
+		p, err := ParsePrefix(data)
+		if err != nil {
+			return
+		}
+		out, err := json.Marshal(p)
+		if err != nil {
+			t.Fatalf("marshal %v: %v", p, err)
+		}
+		var again Prefix
+		if err := json.Unmarshal(out, &again); err != nil {
+			t.Fatalf("unmarshal %q: %v", out, err)
+		}
+		if again != p {
+			t.Fatalf("prefix changed across a round trip: %v != %v", p, again)
+		}
+	})
+}
@@ -33,6 +33,12 @@ import (
 const (
 	// GroupReference is the prefix of a node name that indicates it is a group reference.
 	GroupReference = "group:"
+	// TagReference is the prefix of a CIDR that indicates it is a reference to the
+	// addresses of attachments carrying the tag.
+	TagReference = "tag:"
+	// LabelReference is the prefix of a node name that selects the nodes
+	// matching a label selector, for example "label:role=db".
+	LabelReference = "label:"
 )
 
 // ValidateACL validates a NetworkACL.
@@ -50,6 +56,12 @@ func ValidateACL(acl NetworkACL) error {
 		if node == "*" {
 			continue
 		}
+		if selector, ok := strings.CutPrefix(node, LabelReference); ok {
+			if _, err := ParseLabelSelector(selector); err != nil {
+				return err
+			}
+			continue
+		}
 		node = strings.TrimPrefix(node, GroupReference)
 		if !IsValidID(node) {
 			return fmt.Errorf("invalid source node: %s", node)
@@ -59,6 +71,12 @@ func ValidateACL(acl NetworkACL) error {
 		if cidr == "*" {
 			continue
 		}
+		if tag, ok := strings.CutPrefix(cidr, TagReference); ok {
+			if !IsValidID(tag) {
+				return fmt.Errorf("invalid tag reference: %s", cidr)
+			}
+			continue
+		}
 		_, err := netip.ParsePrefix(cidr)
 		if err != nil {
 			return fmt.Errorf("invalid source cidr: %s", cidr)
@@ -88,7 +106,16 @@ func (a NetworkACLs) Len() int { return len(a) }
 func (a NetworkACLs) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 
 // Less returns whether the ACL at index i should be sorted before the ACL at index j.
+// Deny ACLs are considered higher priority than accept ACLs of the same priority.
+// ACLs of the same priority and action are ordered by name, so that the evaluation
+// order is always deterministic.
 func (a NetworkACLs) Less(i, j int) bool {
+	if a[i].Priority == a[j].Priority {
+		if a[i].Action == a[j].Action {
+			return a[i].GetName() > a[j].GetName()
+		}
+		return a[i].Action != v1.ACLAction_ACTION_DENY && a[j].Action == v1.ACLAction_ACTION_DENY
+	}
 	return a[i].Priority < a[j].Priority
 }
 
@@ -104,6 +131,39 @@ func (a NetworkACLs) Sort(direction SortDirection) {
 	}
 }
 
+// ordered returns the ACLs in evaluation order, highest priority first. The
+// list itself is returned if it is already sorted, otherwise a sorted copy.
+func (a NetworkACLs) ordered() NetworkACLs {
+	if sort.IsSorted(sort.Reverse(a)) {
+		return a
+	}
+	out := make(NetworkACLs, len(a))
+	copy(out, a)
+	out.Sort(SortDescending)
+	return out
+}
+
+// Conflicts returns the ACLs in the list that conflict with the given one. Two
+// ACLs conflict if they have the same priority, different actions, and may
+// match the same traffic, in which case only the implicit rule that deny wins
+// decides between them. The ACL itself, identified by name, is never reported.
+// Group and tag references should be expanded before calling Conflicts.
+func (a NetworkACLs) Conflicts(acl NetworkACL) NetworkACLs {
+	var out NetworkACLs
+	for _, other := range a {
+		if other.GetName() == acl.GetName() {
+			continue
+		}
+		if other.GetPriority() != acl.GetPriority() || other.GetAction() == acl.GetAction() {
+			continue
+		}
+		if acl.Overlaps(other) {
+			out = append(out, other)
+		}
+	}
+	return out
+}
+
 // Proto returns the protobuf representation of the ACLs.
 func (a NetworkACLs) Proto() []*v1.NetworkACL {
 	if a == nil {
@@ -137,18 +197,29 @@ func (a NetworkACLs) AllowNodesToCommunicate(ctx context.Context, nodeA, nodeB M
 	return a.Accept(ctx, v4action) || a.Accept(ctx, v6action)
 }
 
-// Accept evaluates an action against the ACLs in the list. It assumes the ACLs
-// are sorted by priority. The first ACL that matches the action will be used.
-// If no ACL matches, the action is denied.
+// Accept evaluates an action against the ACLs in the list in priority order.
+// The highest priority ACL that matches the action decides it, with deny ACLs
+// taking precedence over accept ACLs of the same priority. If no ACL matches,
+// the action is denied.
 func (a NetworkACLs) Accept(ctx context.Context, action NetworkAction) bool {
-	for _, acl := range a {
+	acl, ok := a.Match(ctx, action)
+	if !ok {
+		context.LoggerFrom(ctx).Debug("No network ACL matches action, denying", "action", action)
+		return false
+	}
+	context.LoggerFrom(ctx).Debug("Network ACL matches action", "action", action, "acl", acl)
+	return acl.Action == v1.ACLAction_ACTION_ACCEPT
+}
+
+// Match returns the highest priority ACL in the list that matches the action.
+// False is returned if no ACL matches.
+func (a NetworkACLs) Match(ctx context.Context, action NetworkAction) (NetworkACL, bool) {
+	for _, acl := range a.ordered() {
 		if acl.Matches(ctx, action) {
-			context.LoggerFrom(ctx).Debug("Network ACL matches action", "action", action, "acl", acl)
-			return acl.Action == v1.ACLAction_ACTION_ACCEPT
+			return acl, true
 		}
 	}
-	context.LoggerFrom(ctx).Debug("No network ACL matches action, denying", "action", action)
-	return false
+	return NetworkACL{}, false
 }
 
 // NetworkACL is a Network ACL.
@@ -231,29 +302,137 @@ func (a NetworkACL) DestinationPrefixes() []netip.Prefix {
 	return ToPrefixes(a.GetDestinationCIDRs())
 }
 
+// Overlaps returns true if there may be traffic matched by both ACLs. Node
+// lists overlap if they share a node or either is a wildcard, and CIDR lists
+// overlap if any of their prefixes do. Empty lists overlap with everything,
+// since they match actions that do not carry the field.
+func (acl NetworkACL) Overlaps(other NetworkACL) bool {
+	return nodesOverlap(acl.GetSourceNodes(), other.GetSourceNodes()) &&
+		nodesOverlap(acl.GetDestinationNodes(), other.GetDestinationNodes()) &&
+		cidrsOverlap(acl.GetSourceCIDRs(), other.GetSourceCIDRs()) &&
+		cidrsOverlap(acl.GetDestinationCIDRs(), other.GetDestinationCIDRs())
+}
+
+func nodesOverlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 || slices.Contains(a, "*") || slices.Contains(b, "*") {
+		return true
+	}
+	for _, node := range a {
+		if slices.Contains(b, node) {
+			return true
+		}
+	}
+	return false
+}
+
+func cidrsOverlap(a, b []string) bool {
+	if len(a) == 0 || len(b) == 0 || slices.Contains(a, "*") || slices.Contains(b, "*") {
+		return true
+	}
+	for _, pa := range ToPrefixes(a) {
+		for _, pb := range ToPrefixes(b) {
+			if pa.Overlaps(pb) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // Matches checks if an action matches this ACL.
 func (acl NetworkACL) Matches(ctx context.Context, action NetworkAction) bool {
+	return acl.mismatch(action) == aclMatched
+}
+
+// aclField is the field of an ACL that did not match an action.
+type aclField int
+
+const (
+	aclMatched aclField = iota
+	aclSourceNodes
+	aclDestinationNodes
+	aclSourceCIDRs
+	aclDestinationCIDRs
+)
+
+// mismatch returns the first field of the ACL that does not match the action.
+func (acl NetworkACL) mismatch(action NetworkAction) aclField {
 	if action.GetSrcNode() != "" && len(acl.GetSourceNodes()) >= 0 {
 		if !containsOrWildcardMatch(acl.GetSourceNodes(), action.GetSrcNode()) {
-			return false
+			return aclSourceNodes
 		}
 	}
 	if action.GetDstNode() != "" && len(acl.GetDestinationNodes()) >= 0 {
 		if !containsOrWildcardMatch(acl.GetDestinationNodes(), action.GetDstNode()) {
-			return false
+			return aclDestinationNodes
 		}
 	}
 	if action.SourcePrefix().IsValid() && len(acl.GetSourceCIDRs()) > 0 {
 		if !containsAddress(acl.SourcePrefixes(), action.SourcePrefix().Addr()) {
-			return false
+			return aclSourceCIDRs
 		}
 	}
 	if action.DestinationPrefix().IsValid() && len(acl.GetDestinationCIDRs()) > 0 {
 		if !containsAddress(acl.DestinationPrefixes(), action.DestinationPrefix().Addr()) {
-			return false
+			return aclDestinationCIDRs
 		}
 	}
-	return true
+	return aclMatched
+}
+
+// ACLEvaluation is an ACL considered while evaluating an action.
+type ACLEvaluation struct {
+	// ACL is the ACL that was considered.
+	ACL NetworkACL
+	// Matched is true if the ACL matched the action and decided it.
+	Matched bool
+	// Reason explains why the ACL did or did not match.
+	Reason string
+}
+
+// ACLExplanation explains how a list of ACLs decided an action.
+type ACLExplanation struct {
+	// Evaluated are the ACLs considered in order, up to and including
+	// the one that matched.
+	Evaluated []ACLEvaluation
+	// Accepted is true if the action was accepted.
+	Accepted bool
+	// Reason explains the decision, naming the ACL that produced it or
+	// the default deny.
+	Reason string
+}
+
+// Explain evaluates an action like Accept and explains the decision.
+func (a NetworkACLs) Explain(ctx context.Context, action NetworkAction) ACLExplanation {
+	var out ACLExplanation
+	for _, acl := range a.ordered() {
+		field := acl.mismatch(action)
+		eval := ACLEvaluation{ACL: acl, Matched: field == aclMatched}
+		switch field {
+		case aclMatched:
+			eval.Reason = "matched"
+		case aclSourceNodes:
+			eval.Reason = fmt.Sprintf("source node %s is not in %v", action.GetSrcNode(), acl.GetSourceNodes())
+		case aclDestinationNodes:
+			eval.Reason = fmt.Sprintf("destination node %s is not in %v", action.GetDstNode(), acl.GetDestinationNodes())
+		case aclSourceCIDRs:
+			eval.Reason = fmt.Sprintf("source %s is not in %v", action.SourcePrefix(), acl.GetSourceCIDRs())
+		case aclDestinationCIDRs:
+			eval.Reason = fmt.Sprintf("destination %s is not in %v", action.DestinationPrefix(), acl.GetDestinationCIDRs())
+		}
+		out.Evaluated = append(out.Evaluated, eval)
+		if eval.Matched {
+			out.Accepted = acl.GetAction() == v1.ACLAction_ACTION_ACCEPT
+			verb := "denied"
+			if out.Accepted {
+				verb = "accepted"
+			}
+			out.Reason = fmt.Sprintf("%s by network ACL %q", verb, acl.GetName())
+			return out
+		}
+	}
+	out.Reason = "denied by default, no network ACL matched"
+	return out
 }
 
 func containsOrWildcardMatch(ss []string, s string) bool {
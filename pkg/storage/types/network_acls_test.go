@@ -0,0 +1,119 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+func TestNetworkACLPriority(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	acl := func(name string, priority int32, action v1.ACLAction, dstNodes ...string) NetworkACL {
+		return NetworkACL{NetworkACL: &v1.NetworkACL{
+			Name:             name,
+			Priority:         priority,
+			Action:           action,
+			SourceNodes:      []string{"*"},
+			DestinationNodes: dstNodes,
+		}}
+	}
+	denyAll := acl("deny-all", 0, v1.ACLAction_ACTION_DENY, "*")
+	allowWeb := acl("allow-web", 100, v1.ACLAction_ACTION_ACCEPT, "web")
+	allowDB := acl("allow-db", 0, v1.ACLAction_ACTION_ACCEPT, "db")
+	toWeb := NetworkAction{NetworkAction: &v1.NetworkAction{SrcNode: "client", DstNode: "web"}}
+	toDB := NetworkAction{NetworkAction: &v1.NetworkAction{SrcNode: "client", DstNode: "db"}}
+
+	// The evaluation order does not depend on the order of the list.
+	for _, acls := range []NetworkACLs{{denyAll, allowWeb, allowDB}, {allowDB, allowWeb, denyAll}} {
+		if !acls.Accept(ctx, toWeb) {
+			t.Errorf("expected the higher priority allow to accept traffic to web")
+		}
+		if acls.Accept(ctx, toDB) {
+			t.Errorf("expected deny to win over an allow of the same priority")
+		}
+		if got := acls.Explain(ctx, toDB); got.Accepted || got.Evaluated[len(got.Evaluated)-1].ACL.GetName() != "deny-all" {
+			t.Errorf("expected deny-all to decide traffic to db, got %s", got.Reason)
+		}
+	}
+
+	// Equal priorities with the same action are ordered by name.
+	a := acl("a", 0, v1.ACLAction_ACTION_ACCEPT, "*")
+	b := acl("b", 0, v1.ACLAction_ACTION_ACCEPT, "*")
+	for _, acls := range []NetworkACLs{{a, b}, {b, a}} {
+		acls.Sort(SortDescending)
+		if acls[0].GetName() != "a" {
+			t.Errorf("expected a to sort first, got %s", acls[0].GetName())
+		}
+	}
+}
+
+func TestNetworkACLConflicts(t *testing.T) {
+	t.Parallel()
+	existing := NetworkACLs{
+		{NetworkACL: &v1.NetworkACL{Name: "deny-all", Action: v1.ACLAction_ACTION_DENY, SourceNodes: []string{"*"}, DestinationNodes: []string{"*"}}},
+		{NetworkACL: &v1.NetworkACL{Name: "allow-lan", Priority: 10, Action: v1.ACLAction_ACTION_ACCEPT, DestinationCIDRs: []string{"10.0.0.0/16"}}},
+	}
+	tc := []struct {
+		name string
+		acl  *v1.NetworkACL
+		want []string
+	}{
+		{
+			name: "same action",
+			acl:  &v1.NetworkACL{Name: "deny-web", Action: v1.ACLAction_ACTION_DENY, DestinationNodes: []string{"web"}},
+		},
+		{
+			name: "different priority",
+			acl:  &v1.NetworkACL{Name: "allow-web", Priority: 100, Action: v1.ACLAction_ACTION_ACCEPT, DestinationNodes: []string{"web"}},
+		},
+		{
+			name: "overlapping wildcard",
+			acl:  &v1.NetworkACL{Name: "allow-web", Action: v1.ACLAction_ACTION_ACCEPT, DestinationNodes: []string{"web"}},
+			want: []string{"deny-all"},
+		},
+		{
+			name: "overlapping cidrs",
+			acl:  &v1.NetworkACL{Name: "deny-subnet", Priority: 10, Action: v1.ACLAction_ACTION_DENY, DestinationCIDRs: []string{"10.0.1.0/24"}},
+			want: []string{"allow-lan"},
+		},
+		{
+			name: "disjoint cidrs",
+			acl:  &v1.NetworkACL{Name: "deny-subnet", Priority: 10, Action: v1.ACLAction_ACTION_DENY, DestinationCIDRs: []string{"192.168.0.0/24"}},
+		},
+		{
+			name: "updating itself",
+			acl:  &v1.NetworkACL{Name: "allow-lan", Priority: 10, Action: v1.ACLAction_ACTION_DENY, DestinationCIDRs: []string{"10.0.0.0/16"}},
+		},
+	}
+	for _, tt := range tc {
+		got := existing.Conflicts(NetworkACL{NetworkACL: tt.acl})
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: expected conflicts %v, got %d", tt.name, tt.want, len(got))
+			continue
+		}
+		for i, name := range tt.want {
+			if got[i].GetName() != name {
+				t.Errorf("%s: expected conflict %s, got %s", tt.name, name, got[i].GetName())
+			}
+		}
+	}
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// NodeLabels are key-value pairs attached to a node that network ACLs can
+// select nodes by.
+type NodeLabels map[string]string
+
+// ParseNodeLabels parses labels given in the format key=value.
+func ParseNodeLabels(ss []string) (NodeLabels, error) {
+	out := make(NodeLabels, len(ss))
+	for _, s := range ss {
+		key, value, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label %q, must be in the format key=value", s)
+		}
+		out[key] = value
+	}
+	return out, out.Validate()
+}
+
+// Validate returns an error if any of the labels has an invalid key or value.
+func (l NodeLabels) Validate() error {
+	for key, value := range l {
+		if !isValidLabel(key) {
+			return fmt.Errorf("invalid label key %q", key)
+		}
+		if value != "" && !isValidLabel(value) {
+			return fmt.Errorf("invalid value %q for label %q", value, key)
+		}
+	}
+	return nil
+}
+
+// String returns the labels in the format key=value, sorted by key and
+// separated by commas.
+func (l NodeLabels) String() string {
+	pairs := make([]string, 0, len(l))
+	for key, value := range l {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// LabelOperator is the operator of a label selector requirement.
+type LabelOperator string
+
+const (
+	// LabelEquals requires the label to be set to the value.
+	LabelEquals LabelOperator = "="
+	// LabelNotEquals requires the label to not be set to the value,
+	// including when it is not set at all.
+	LabelNotEquals LabelOperator = "!="
+	// LabelExists requires the label to be set.
+	LabelExists LabelOperator = "exists"
+	// LabelNotExists requires the label to not be set.
+	LabelNotExists LabelOperator = "!exists"
+)
+
+// LabelRequirement is a single requirement of a label selector.
+type LabelRequirement struct {
+	// Key is the label key.
+	Key string
+	// Operator is how the label is compared.
+	Operator LabelOperator
+	// Value is the value compared against for the equality operators.
+	Value string
+}
+
+// LabelSelector selects nodes by their labels. A node is selected if it
+// satisfies every requirement.
+type LabelSelector []LabelRequirement
+
+// ParseLabelSelector parses a comma separated list of requirements. Each
+// requirement is one of key=value, key!=value, key, or !key.
+func ParseLabelSelector(s string) (LabelSelector, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("label selector must not be empty")
+	}
+	var out LabelSelector
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		var req LabelRequirement
+		switch {
+		case strings.Contains(part, "!="):
+			req.Key, req.Value, _ = strings.Cut(part, "!=")
+			req.Operator = LabelNotEquals
+		case strings.Contains(part, "="):
+			req.Key, req.Value, _ = strings.Cut(part, "=")
+			req.Operator = LabelEquals
+		case strings.HasPrefix(part, "!"):
+			req.Key = strings.TrimPrefix(part, "!")
+			req.Operator = LabelNotExists
+		default:
+			req.Key = part
+			req.Operator = LabelExists
+		}
+		if !isValidLabel(req.Key) {
+			return nil, fmt.Errorf("invalid label key %q in selector %q", req.Key, s)
+		}
+		if req.Value != "" && !isValidLabel(req.Value) {
+			return nil, fmt.Errorf("invalid label value %q in selector %q", req.Value, s)
+		}
+		out = append(out, req)
+	}
+	return out, nil
+}
+
+// Matches returns true if the given labels satisfy every requirement.
+func (s LabelSelector) Matches(labels NodeLabels) bool {
+	for _, req := range s {
+		value, ok := labels[req.Key]
+		switch req.Operator {
+		case LabelEquals:
+			if !ok || value != req.Value {
+				return false
+			}
+		case LabelNotEquals:
+			if ok && value == req.Value {
+				return false
+			}
+		case LabelExists:
+			if !ok {
+				return false
+			}
+		case LabelNotExists:
+			if ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// String returns the selector in the format accepted by ParseLabelSelector.
+func (s LabelSelector) String() string {
+	parts := make([]string, len(s))
+	for i, req := range s {
+		switch req.Operator {
+		case LabelExists:
+			parts[i] = req.Key
+		case LabelNotExists:
+			parts[i] = "!" + req.Key
+		default:
+			parts[i] = req.Key + string(req.Operator) + req.Value
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// isValidLabel returns true if the given label key or value is made up of
+// alphanumerics, dashes, underscores, dots, and slashes.
+func isValidLabel(s string) bool {
+	if s == "" || len(s) > MaxIDLength {
+		return false
+	}
+	for _, c := range s {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == '/':
+		default:
+			return false
+		}
+	}
+	return true
+}
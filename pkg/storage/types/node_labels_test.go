@@ -0,0 +1,96 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"testing"
+
+	v1 "github.com/webmeshproj/api/go/v1"
+)
+
+func TestLabelSelector(t *testing.T) {
+	t.Parallel()
+	db := NodeLabels{"role": "db", "env": "prod"}
+	web := NodeLabels{"role": "web", "env": "staging"}
+	tc := []struct {
+		name     string
+		selector string
+		invalid  bool
+		matches  []NodeLabels
+		excludes []NodeLabels
+	}{
+		{name: "equals", selector: "role=db", matches: []NodeLabels{db}, excludes: []NodeLabels{web, {}}},
+		{name: "not equals", selector: "role!=db", matches: []NodeLabels{web, {}}, excludes: []NodeLabels{db}},
+		{name: "exists", selector: "env", matches: []NodeLabels{db, web}, excludes: []NodeLabels{{}}},
+		{name: "not exists", selector: "!env", matches: []NodeLabels{{}}, excludes: []NodeLabels{db, web}},
+		{name: "all requirements", selector: "role=db, env=prod", matches: []NodeLabels{db}, excludes: []NodeLabels{web, {"role": "db"}}},
+		{name: "empty", selector: "", invalid: true},
+		{name: "empty key", selector: "=db", invalid: true},
+		{name: "invalid value", selector: "role=d b", invalid: true},
+	}
+	for _, tt := range tc {
+		selector, err := ParseLabelSelector(tt.selector)
+		if tt.invalid {
+			if err == nil {
+				t.Errorf("%s: expected error parsing %q", tt.name, tt.selector)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.name, err)
+			continue
+		}
+		for _, labels := range tt.matches {
+			if !selector.Matches(labels) {
+				t.Errorf("%s: expected %q to match %v", tt.name, selector, labels)
+			}
+		}
+		for _, labels := range tt.excludes {
+			if selector.Matches(labels) {
+				t.Errorf("%s: expected %q not to match %v", tt.name, selector, labels)
+			}
+		}
+	}
+}
+
+func TestValidateACLLabelReference(t *testing.T) {
+	t.Parallel()
+	valid := NetworkACL{NetworkACL: &v1.NetworkACL{Name: "db", SourceNodes: []string{"label:role=web"}, DestinationNodes: []string{"label:role=db,env!=dev"}}}
+	if err := ValidateACL(valid); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	invalid := NetworkACL{NetworkACL: &v1.NetworkACL{Name: "db", SourceNodes: []string{"label:"}}}
+	if err := ValidateACL(invalid); err == nil {
+		t.Error("expected error for an empty label selector")
+	}
+}
+
+func TestParseNodeLabels(t *testing.T) {
+	t.Parallel()
+	labels, err := ParseNodeLabels([]string{"role=db", "zone=eu-west-1"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if labels.String() != "role=db,zone=eu-west-1" {
+		t.Errorf("expected role=db,zone=eu-west-1, got %s", labels)
+	}
+	for _, invalid := range []string{"role", "=db", "role=a,b"} {
+		if _, err := ParseNodeLabels([]string{invalid}); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
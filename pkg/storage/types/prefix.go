@@ -16,7 +16,10 @@ limitations under the License.
 
 package types
 
-import "net/netip"
+import (
+	"encoding/json"
+	"net/netip"
+)
 
 // Prefix is wraps a netip.Prefix with a custom JSON marshaller.
 type Prefix struct{ netip.Prefix }
@@ -46,11 +49,15 @@ func (p Prefix) MarshalJSON() ([]byte, error) {
 
 // UnmarshalJSON unmarshals a Prefix from a string.
 func (p *Prefix) UnmarshalJSON(b []byte) error {
-	if string(b) == "null" || string(b) == `""` {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	}
+	if str == "" {
 		return nil
 	}
 	var err error
-	p.Prefix, err = netip.ParsePrefix(string(b))
+	p.Prefix, err = netip.ParsePrefix(str)
 	if err != nil {
 		return err
 	}
@@ -29,6 +29,9 @@ var (
 
 	// ConsensusPrefix is the prefix for all data stored related to consensus.
 	ConsensusPrefix StoragePrefix = []byte("/raft")
+
+	// RevisionsPrefix is the prefix for the revisions of stored keys.
+	RevisionsPrefix StoragePrefix = []byte("/revisions")
 )
 
 // String returns the string representation of the prefix.
@@ -53,13 +56,16 @@ func (p StoragePrefix) ForString(key string) StoragePrefix {
 
 // TrimFrom returns the key without the prefix.
 func (p StoragePrefix) TrimFrom(key []byte) []byte {
-	return bytes.TrimPrefix(key, append(p, '/'))
+	// Limit the capacity so the separator is never written into memory
+	// shared with the caller.
+	return bytes.TrimPrefix(key, append(p[:len(p):len(p)], '/'))
 }
 
 // ReservedPrefixes is a list of all reserved prefixes.
 var ReservedPrefixes = []StoragePrefix{
 	RegistryPrefix,
 	ConsensusPrefix,
+	RevisionsPrefix,
 }
 
 // IsReservedPrefix returns true if the given key is reserved.
@@ -77,6 +77,16 @@ func ValidateRoute(route Route) error {
 	return nil
 }
 
+// RouteMetrics are the administrative metrics of routes indexed by route name.
+// When multiple nodes advertise routes to the same prefix, peers prefer the
+// route with the lowest metric. Routes without a metric have a metric of zero.
+type RouteMetrics map[string]uint32
+
+// MetricOf returns the metric of the given route.
+func (m RouteMetrics) MetricOf(route Route) uint32 {
+	return m[route.GetName()]
+}
+
 // Routes is a list of routes.
 type Routes []Route
 
@@ -26,6 +26,11 @@ import (
 	"github.com/webmeshproj/webmesh/pkg/storage/errors"
 )
 
+// QueryCommandBatch is a query command that applies a batch of writes
+// atomically. The writes are carried in the item of the request, encoded
+// with storage.MarshalWriteOps.
+const QueryCommandBatch v1.QueryRequest_QueryCommand = 4
+
 // StorageQuery represents a parsed storage query.
 type StorageQuery struct {
 	*v1.QueryRequest
@@ -50,6 +55,9 @@ func ParseStorageQuery(query *v1.QueryRequest) (StorageQuery, error) {
 	case v1.QueryRequest_LIST:
 		// List queries don't require any filters.
 		return StorageQuery{QueryRequest: query, filters: filters}, nil
+	case QueryCommandBatch:
+		// Batches carry their keys in the item.
+		return StorageQuery{QueryRequest: query, filters: filters}, nil
 	default:
 		return StorageQuery{}, errors.ErrInvalidQuery
 	}
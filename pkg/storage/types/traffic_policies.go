@@ -0,0 +1,129 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// TrafficPolicy limits the bandwidth of traffic between the nodes enforcing it
+// and the peers and prefixes it selects. Every selected peer and prefix is
+// limited on its own, so the policy caps each of them rather than all of them
+// together.
+type TrafficPolicy struct {
+	// NetworkACL attaches the policy to the network ACL of the given name.
+	// The source nodes and prefixes of the ACL are selected while it is in
+	// effect.
+	NetworkACL string `json:"networkACL,omitempty"`
+	// Nodes are the IDs of the peers selected by the policy. A wildcard
+	// selects every peer.
+	Nodes []string `json:"nodes,omitempty"`
+	// CIDRs are the prefixes selected by the policy.
+	CIDRs []string `json:"cidrs,omitempty"`
+	// Rate is the guaranteed rate in bits per second.
+	Rate uint64 `json:"rate"`
+	// Ceil is the rate in bits per second traffic can borrow up to when the
+	// interface is not saturated. It defaults to Rate.
+	Ceil uint64 `json:"ceil,omitempty"`
+	// Enforcers are the IDs of the nodes enforcing the policy. Every node
+	// enforces it when empty.
+	Enforcers []string `json:"enforcers,omitempty"`
+}
+
+// Validate validates the policy.
+func (p TrafficPolicy) Validate() error {
+	if p.NetworkACL == "" && len(p.Nodes) == 0 && len(p.CIDRs) == 0 {
+		return fmt.Errorf("traffic policy must select a network acl, nodes, or cidrs")
+	}
+	if p.NetworkACL != "" && !IsValidID(p.NetworkACL) {
+		return fmt.Errorf("invalid network acl name %q", p.NetworkACL)
+	}
+	for _, node := range p.Nodes {
+		if !IsValidIDOrWildcard(node) {
+			return fmt.Errorf("invalid node ID %q", node)
+		}
+	}
+	for _, cidr := range p.CIDRs {
+		if _, err := netip.ParsePrefix(cidr); err != nil {
+			return fmt.Errorf("invalid cidr %q: %w", cidr, err)
+		}
+	}
+	for _, node := range p.Enforcers {
+		if !IsValidNodeID(node) {
+			return fmt.Errorf("invalid enforcer node ID %q", node)
+		}
+	}
+	if p.Rate == 0 {
+		return fmt.Errorf("traffic policy rate must be greater than zero")
+	}
+	if p.Ceil != 0 && p.Ceil < p.Rate {
+		return fmt.Errorf("traffic policy ceil must not be less than its rate")
+	}
+	return nil
+}
+
+// EnforcedBy returns true if the given node enforces the policy.
+func (p TrafficPolicy) EnforcedBy(nodeID NodeID) bool {
+	if len(p.Enforcers) == 0 {
+		return true
+	}
+	for _, node := range p.Enforcers {
+		if node == nodeID.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// TrafficPolicies are traffic policies indexed by name.
+type TrafficPolicies map[string]TrafficPolicy
+
+// rateUnits are the suffixes accepted by ParseRate and their multipliers.
+var rateUnits = []struct {
+	suffix string
+	mult   uint64
+}{
+	{"gbit", 1_000_000_000},
+	{"mbit", 1_000_000},
+	{"kbit", 1_000},
+	{"bit", 1},
+}
+
+// ParseRate parses a rate in bits per second with an optional kbit, mbit, or
+// gbit suffix, e.g. "500kbit" or "10mbit".
+func ParseRate(s string) (uint64, error) {
+	value, mult := strings.ToLower(strings.TrimSpace(s)), uint64(1)
+	for _, unit := range rateUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value, mult = strings.TrimSuffix(value, unit.suffix), unit.mult
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	rate := n * float64(mult)
+	if rate >= math.MaxUint64 {
+		return 0, fmt.Errorf("rate %q is too large", s)
+	}
+	return uint64(rate), nil
+}
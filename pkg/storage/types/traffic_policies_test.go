@@ -0,0 +1,92 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package types
+
+import (
+	"testing"
+)
+
+func TestParseRate(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		rate    string
+		want    uint64
+		wantErr bool
+	}{
+		{rate: "1000", want: 1000},
+		{rate: "500kbit", want: 500_000},
+		{rate: "10Mbit", want: 10_000_000},
+		{rate: "1.5gbit", want: 1_500_000_000},
+		{rate: "0", wantErr: true},
+		{rate: "-1mbit", wantErr: true},
+		{rate: "fast", wantErr: true},
+	}
+	for _, tt := range tc {
+		got, err := ParseRate(tt.rate)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.rate, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.rate, got, tt.want)
+		}
+	}
+}
+
+func TestTrafficPolicyValidate(t *testing.T) {
+	t.Parallel()
+	tc := []struct {
+		name    string
+		policy  TrafficPolicy
+		wantErr bool
+	}{
+		{
+			name:   "NetworkACL",
+			policy: TrafficPolicy{NetworkACL: "tenants", Rate: 1000},
+		},
+		{
+			name:   "NodesAndCIDRs",
+			policy: TrafficPolicy{Nodes: []string{"*"}, CIDRs: []string{"10.0.0.0/8"}, Rate: 1000, Ceil: 2000, Enforcers: []string{"gateway"}},
+		},
+		{
+			name:    "NoSelector",
+			policy:  TrafficPolicy{Rate: 1000},
+			wantErr: true,
+		},
+		{
+			name:    "InvalidCIDR",
+			policy:  TrafficPolicy{CIDRs: []string{"10.0.0.0"}, Rate: 1000},
+			wantErr: true,
+		},
+		{
+			name:    "NoRate",
+			policy:  TrafficPolicy{Nodes: []string{"node"}},
+			wantErr: true,
+		},
+		{
+			name:    "CeilBelowRate",
+			policy:  TrafficPolicy{Nodes: []string{"node"}, Rate: 2000, Ceil: 1000},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tc {
+		err := tt.policy.Validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/webmeshproj/webmesh/pkg/storage/errors"
+	"github.com/webmeshproj/webmesh/pkg/storage/types"
+)
+
+// Version is the version of a value in the mesh storage. It is the revision of
+// the last write to the key. Revisions only ever grow, so a key written back to
+// an earlier value still gets a new version. Storage replicated by consensus
+// uses the index of the log entry that wrote the key, so that the version is
+// the same on every storage node.
+type Version string
+
+// NoVersion is the version of a key that does not exist. Passing it as the
+// expected version to CompareAndSwap only succeeds if the key is not set.
+const NoVersion Version = ""
+
+// RevisionsPrefix is where storage backends record the revision of every key
+// they hold, in the format /revisions/<key>.
+var RevisionsPrefix = types.RevisionsPrefix
+
+// RevisionKey returns the key holding the revision of the given key.
+func RevisionKey(key []byte) []byte {
+	return RevisionsPrefix.For(key)
+}
+
+// VersionOf returns the version of the given revision.
+func VersionOf(revision uint64) Version {
+	return Version(strconv.FormatUint(revision, 10))
+}
+
+// EncodeRevision encodes a revision for storage.
+func EncodeRevision(revision uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, revision)
+}
+
+// DecodeRevision decodes a stored revision. Keys written before revisions
+// were recorded have none and are at revision zero.
+func DecodeRevision(data []byte) uint64 {
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// String returns the string representation of the version.
+func (v Version) String() string {
+	return string(v)
+}
+
+// CheckVersion returns ErrVersionConflict if the current version of a key does
+// not match the expected one. It is a helper for MeshStorage implementations of
+// CompareAndSwap, which must call it while holding whatever lock serializes
+// their writes.
+func CheckVersion(current, expected Version) error {
+	if current != expected {
+		return fmt.Errorf("%w: expected %q, have %q", errors.ErrVersionConflict, expected, current)
+	}
+	return nil
+}
+
+type revisionKey struct{}
+
+// WithRevision returns a context that makes storage backends record the given
+// revision for the writes made with it, instead of one of their own. Storage
+// replicated by consensus uses it to version writes by the index of the log
+// entry applying them.
+func WithRevision(ctx context.Context, revision uint64) context.Context {
+	return context.WithValue(ctx, revisionKey{}, revision)
+}
+
+// RevisionFrom returns the revision set on the context, if any.
+func RevisionFrom(ctx context.Context) (uint64, bool) {
+	revision, ok := ctx.Value(revisionKey{}).(uint64)
+	return revision, ok
+}
+
+// ExpectedVersionHeader is the gRPC metadata header used to carry the expected
+// version of a write forwarded to another storage node.
+const ExpectedVersionHeader = "x-webmesh-expected-version"
+
+type expectedVersionKey struct{}
+
+// WithExpectedVersion returns a context that makes writes through the mesh
+// database only succeed if the key being written is currently at the given
+// version. Use NoVersion to only allow creating new keys.
+func WithExpectedVersion(ctx context.Context, version Version) context.Context {
+	return context.WithValue(ctx, expectedVersionKey{}, version)
+}
+
+// ExpectedVersionFrom returns the expected version set on the context, if any.
+func ExpectedVersionFrom(ctx context.Context) (Version, bool) {
+	version, ok := ctx.Value(expectedVersionKey{}).(Version)
+	return version, ok
+}
+
+// PutValue sets the value of a key in the given storage. If an expected version
+// is set on the context, the write is a compare-and-swap against it.
+func PutValue(ctx context.Context, st MeshStorage, key, value []byte, ttl time.Duration) error {
+	if expected, ok := ExpectedVersionFrom(ctx); ok {
+		return st.CompareAndSwap(ctx, key, value, expected, ttl)
+	}
+	return st.PutValue(ctx, key, value, ttl)
+}
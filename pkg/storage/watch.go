@@ -0,0 +1,154 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"bytes"
+	"sync"
+
+	"github.com/webmeshproj/webmesh/pkg/context"
+)
+
+// WatchEventType is the type of change reported by a watch.
+type WatchEventType int
+
+const (
+	// WatchCreate is sent when a key is created.
+	WatchCreate WatchEventType = iota
+	// WatchUpdate is sent when the value of an existing key changes.
+	WatchUpdate
+	// WatchDelete is sent when a key is deleted.
+	WatchDelete
+)
+
+// String returns the string representation of the event type.
+func (t WatchEventType) String() string {
+	switch t {
+	case WatchCreate:
+		return "create"
+	case WatchUpdate:
+		return "update"
+	case WatchDelete:
+		return "delete"
+	default:
+		return "unknown"
+	}
+}
+
+// WatchEvent is a change to a key under a watched prefix.
+type WatchEvent struct {
+	// Type is the type of change.
+	Type WatchEventType
+	// Key is the key that changed.
+	Key []byte
+	// Value is the new value of the key. It is nil for deletes.
+	Value []byte
+}
+
+// WatchPrefix implements Watch for storage that only supports Subscribe.
+// Subscriptions report deletes as changes to an empty value, so the current
+// keys are tracked to tell creates, updates, and deletes apart. Changes that
+// leave the value as it was are not reported.
+func WatchPrefix(ctx context.Context, st MeshStorage, prefix []byte) (<-chan WatchEvent, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	var mu sync.Mutex
+	var pending []WatchEvent
+	notify := make(chan struct{}, 1)
+	unsubscribe, err := st.Subscribe(ctx, prefix, func(key, value []byte) {
+		mu.Lock()
+		pending = append(pending, WatchEvent{
+			Key:   bytes.Clone(key),
+			Value: bytes.Clone(value),
+		})
+		mu.Unlock()
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	})
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// Subscribe before listing so that nothing written in between is missed.
+	known := make(map[string][]byte)
+	var snapshot []WatchEvent
+	err = st.IterPrefix(ctx, prefix, func(key, value []byte) error {
+		key, value = bytes.Clone(key), bytes.Clone(value)
+		known[string(key)] = value
+		snapshot = append(snapshot, WatchEvent{Type: WatchCreate, Key: key, Value: value})
+		return nil
+	})
+	if err != nil {
+		unsubscribe()
+		cancel()
+		return nil, err
+	}
+	out := make(chan WatchEvent)
+	send := func(ev WatchEvent) bool {
+		select {
+		case out <- ev:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	go func() {
+		defer close(out)
+		defer cancel()
+		defer unsubscribe()
+		for _, ev := range snapshot {
+			if !send(ev) {
+				return
+			}
+		}
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-notify:
+			}
+			mu.Lock()
+			changes := pending
+			pending = nil
+			mu.Unlock()
+			for _, ev := range changes {
+				old, exists := known[string(ev.Key)]
+				switch {
+				case len(ev.Value) == 0:
+					if !exists {
+						continue
+					}
+					delete(known, string(ev.Key))
+					ev.Type, ev.Value = WatchDelete, nil
+				case !exists:
+					known[string(ev.Key)] = ev.Value
+					ev.Type = WatchCreate
+				case bytes.Equal(old, ev.Value):
+					continue
+				default:
+					known[string(ev.Key)] = ev.Value
+					ev.Type = WatchUpdate
+				}
+				if !send(ev) {
+					return
+				}
+			}
+		}
+	}()
+	return out, nil
+}
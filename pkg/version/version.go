@@ -17,7 +17,13 @@ limitations under the License.
 // Package version contains compile-time version information.
 package version
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
 
 var (
 	// Version is the version of the binary.
@@ -33,33 +39,87 @@ type BuildInfo struct {
 	Version   string `json:"version"`
 	GitCommit string `json:"gitCommit"`
 	BuildDate string `json:"buildDate"`
+	// GoVersion is the version of Go the binary was built with.
+	GoVersion string `json:"goVersion"`
+	// Platform is the operating system and architecture of the binary.
+	Platform string `json:"platform"`
+	// BuildTags are the build tags the binary was built with, sorted.
+	BuildTags []string `json:"buildTags"`
+	// Modified is true if the binary was built from a source tree
+	// with uncommitted changes.
+	Modified bool `json:"modified"`
 }
 
-// GetBuildInfo returns the current build information.
+// GetBuildInfo returns the current build information. The commit and build
+// date fall back to the version control information embedded by the Go
+// toolchain when they were not set at link time.
 func GetBuildInfo() BuildInfo {
-	return BuildInfo{
+	info := BuildInfo{
 		Version:   Version,
 		GitCommit: GitCommit,
 		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+		BuildTags: []string{},
+	}
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
 	}
+	for _, setting := range bi.Settings {
+		switch setting.Key {
+		case "-tags":
+			for _, tag := range strings.Split(setting.Value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					info.BuildTags = append(info.BuildTags, tag)
+				}
+			}
+		case "vcs.revision":
+			if info.GitCommit == "unknown" {
+				info.GitCommit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildDate == "unknown" {
+				info.BuildDate = setting.Value
+			}
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	sort.Strings(info.BuildTags)
+	return info
 }
 
 // MarshalJSON implements json.Marshaler.
 func (b BuildInfo) MarshalJSON() ([]byte, error) {
-	return json.Marshal(map[string]string{
-		"version":   b.Version,
-		"gitCommit": b.GitCommit,
-		"buildDate": b.BuildDate,
-	})
+	return json.Marshal(b.fields())
 }
 
 // PrettyJSON returns the current build information as a pretty-printed JSON string.
 func (b BuildInfo) PrettyJSON(component string) string {
-	out, _ := json.MarshalIndent(map[string]string{
-		"component": component,
+	fields := b.fields()
+	fields["component"] = component
+	out, _ := json.MarshalIndent(fields, "", "    ")
+	return string(out)
+}
+
+// Tags returns the build tags as a comma separated list.
+func (b BuildInfo) Tags() string {
+	return strings.Join(b.BuildTags, ",")
+}
+
+func (b BuildInfo) fields() map[string]any {
+	tags := b.BuildTags
+	if tags == nil {
+		tags = []string{}
+	}
+	return map[string]any{
 		"version":   b.Version,
 		"gitCommit": b.GitCommit,
 		"buildDate": b.BuildDate,
-	}, "", "    ")
-	return string(out)
+		"goVersion": b.GoVersion,
+		"platform":  b.Platform,
+		"buildTags": tags,
+		"modified":  b.Modified,
+	}
 }
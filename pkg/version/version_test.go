@@ -0,0 +1,50 @@
+/*
+Copyright 2023 Avi Zimmerman <avi.zimmerman@gmail.com>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package version
+
+import (
+	"encoding/json"
+	"runtime"
+	"testing"
+)
+
+func TestGetBuildInfo(t *testing.T) {
+	t.Parallel()
+	info := GetBuildInfo()
+	if info.GoVersion != runtime.Version() {
+		t.Errorf("expected go version %q, got %q", runtime.Version(), info.GoVersion)
+	}
+	if info.Platform != runtime.GOOS+"/"+runtime.GOARCH {
+		t.Errorf("expected platform %q, got %q", runtime.GOOS+"/"+runtime.GOARCH, info.Platform)
+	}
+	if info.BuildTags == nil {
+		t.Error("expected build tags to be non-nil")
+	}
+	out, err := json.Marshal(info)
+	if err != nil {
+		t.Fatalf("marshal build info: %v", err)
+	}
+	var fields map[string]any
+	if err := json.Unmarshal(out, &fields); err != nil {
+		t.Fatalf("unmarshal build info: %v", err)
+	}
+	for _, key := range []string{"version", "gitCommit", "buildDate", "goVersion", "platform", "buildTags", "modified"} {
+		if _, ok := fields[key]; !ok {
+			t.Errorf("expected %q in JSON output", key)
+		}
+	}
+}